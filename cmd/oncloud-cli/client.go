@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"oncloud/models"
+	"oncloud/utils"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sessions are refreshed when their access token expires within this long
+const tokenRefreshMargin = 5 * time.Minute
+
+// listPageSize is the page size used when listing whole folders
+const listPageSize = 100
+
+var errNotLoggedIn = errors.New("not logged in, run oncloud-cli login first")
+
+// apiError is a failed API request
+type apiError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *apiError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("request failed with status %d", e.Status)
+	}
+	return e.Message
+}
+
+// envelope is the API's response envelope, with the data left undecoded
+type envelope struct {
+	Success bool             `json:"success"`
+	Message string           `json:"message"`
+	Data    json.RawMessage  `json:"data"`
+	Error   *models.APIError `json:"error"`
+	Meta    *models.Meta     `json:"meta"`
+}
+
+type client struct {
+	creds *credentials
+	http  *http.Client
+}
+
+func newClient(creds *credentials) *client {
+	return &client{creds: creds, http: &http.Client{}}
+}
+
+// authorizedClient returns a client for the saved session, refreshing its
+// tokens when they are about to expire
+func authorizedClient() (*client, error) {
+	creds, err := loadCredentials()
+	if err != nil {
+		return nil, err
+	}
+	if creds.AccessToken == "" {
+		return nil, errNotLoggedIn
+	}
+
+	c := newClient(creds)
+	if creds.RefreshToken != "" && tokenExpiresWithin(creds.AccessToken, tokenRefreshMargin) {
+		// Refresh tokens are rotated, so the new one must be saved
+		var tokens utils.TokenPair
+		if err := c.postJSON("/auth/refresh", map[string]string{"refresh_token": creds.RefreshToken}, &tokens); err == nil {
+			creds.AccessToken, creds.RefreshToken = tokens.AccessToken, tokens.RefreshToken
+			if err := saveCredentials(creds); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return c, nil
+}
+
+// tokenExpiresWithin reads the expiry of a JWT without verifying it
+func tokenExpiresWithin(token string, d time.Duration) bool {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+	var claims struct {
+		ExpiresAt int64 `json:"exp"`
+	}
+	if json.Unmarshal(payload, &claims) != nil || claims.ExpiresAt == 0 {
+		return false
+	}
+	return time.Until(time.Unix(claims.ExpiresAt, 0)) < d
+}
+
+func (c *client) url(path string, query url.Values) string {
+	u := c.creds.Server + "/api/v1" + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u
+}
+
+// do sends a request and decodes the data of the response into out
+func (c *client) do(req *http.Request, out interface{}) (*models.Meta, error) {
+	if c.creds.AccessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.creds.AccessToken)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var body envelope
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, &apiError{Status: resp.StatusCode, Message: fmt.Sprintf("unexpected response from server (status %d)", resp.StatusCode)}
+	}
+	if resp.StatusCode >= 300 || !body.Success {
+		err := &apiError{Status: resp.StatusCode, Message: body.Message}
+		if body.Error != nil {
+			err.Code, err.Message = body.Error.Code, body.Error.Message
+		}
+		if resp.StatusCode == http.StatusUnauthorized && c.creds.AccessToken != "" {
+			err.Message += " (run oncloud-cli login to sign in again)"
+		}
+		return nil, err
+	}
+
+	if out != nil && len(body.Data) > 0 {
+		if err := json.Unmarshal(body.Data, out); err != nil {
+			return nil, fmt.Errorf("failed to decode response: %v", err)
+		}
+	}
+	return body.Meta, nil
+}
+
+func (c *client) get(path string, query url.Values, out interface{}) (*models.Meta, error) {
+	req, err := http.NewRequest(http.MethodGet, c.url(path, query), nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.do(req, out)
+}
+
+func (c *client) postJSON(path string, body, out interface{}) error {
+	return c.sendJSON(http.MethodPost, path, body, out)
+}
+
+func (c *client) sendJSON(method, path string, body, out interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(method, c.url(path, nil), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	_, err = c.do(req, out)
+	return err
+}
+
+// upload streams content as the file part of a multipart form
+func (c *client) upload(path string, fields map[string]string, name string, content io.Reader, out interface{}) error {
+	pr, pw := io.Pipe()
+	form := multipart.NewWriter(pw)
+	go func() {
+		for key, value := range fields {
+			if err := form.WriteField(key, value); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		part, err := form.CreateFormFile("file", name)
+		if err == nil {
+			_, err = io.Copy(part, content)
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	req, err := http.NewRequest(http.MethodPost, c.url(path, nil), pr)
+	if err != nil {
+		pr.Close()
+		return err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	_, err = c.do(req, out)
+	pr.Close()
+	return err
+}
+
+// download writes a file's content to w. The API redirects to the storage
+// provider, which the HTTP client follows.
+func (c *client) download(fileID string, w io.Writer) error {
+	req, err := http.NewRequest(http.MethodGet, c.url("/files/"+fileID+"/download", nil), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.creds.AccessToken)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var body envelope
+		if json.NewDecoder(resp.Body).Decode(&body) == nil && body.Message != "" {
+			return &apiError{Status: resp.StatusCode, Message: body.Message}
+		}
+		return &apiError{Status: resp.StatusCode}
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// listFolders returns every subfolder of parentID ("root" for the root)
+func (c *client) listFolders(parentID string) ([]models.Folder, error) {
+	var all []models.Folder
+	for page := 1; ; page++ {
+		var folders []models.Folder
+		meta, err := c.get("/folders/", url.Values{
+			"parent_id": {parentID},
+			"page":      {strconv.Itoa(page)},
+			"limit":     {strconv.Itoa(listPageSize)},
+		}, &folders)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, folders...)
+		if meta == nil || !meta.HasNext {
+			return all, nil
+		}
+	}
+}
+
+// listFiles returns every file directly in folderID ("root" for the root)
+func (c *client) listFiles(folderID string) ([]models.File, error) {
+	var all []models.File
+	for page := 1; ; page++ {
+		var files []models.File
+		meta, err := c.get("/files/", url.Values{
+			"folder_id": {folderID},
+			"sort":      {"name"},
+			"order":     {"asc"},
+			"page":      {strconv.Itoa(page)},
+			"limit":     {strconv.Itoa(listPageSize)},
+		}, &files)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, files...)
+		if meta == nil || !meta.HasNext {
+			return all, nil
+		}
+	}
+}
+
+// resolveFolder returns the ID of the folder at a remote path, "root" for
+// the root
+func (c *client) resolveFolder(path string) (string, error) {
+	folderID := "root"
+	for _, name := range splitRemotePath(path) {
+		folders, err := c.listFolders(folderID)
+		if err != nil {
+			return "", err
+		}
+		found := false
+		for _, folder := range folders {
+			if folder.Name == name {
+				folderID, found = folder.ID.Hex(), true
+				break
+			}
+		}
+		if !found {
+			return "", fmt.Errorf("folder %s not found", path)
+		}
+	}
+	return folderID, nil
+}
+
+// resolveFile returns the file at a remote path
+func (c *client) resolveFile(path string) (*models.File, error) {
+	parts := splitRemotePath(path)
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("%s is not a file", path)
+	}
+	folderID, err := c.resolveFolder(strings.Join(parts[:len(parts)-1], "/"))
+	if err != nil {
+		return nil, err
+	}
+	files, err := c.listFiles(folderID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range files {
+		if fileName(&files[i]) == parts[len(parts)-1] {
+			return &files[i], nil
+		}
+	}
+	return nil, fmt.Errorf("file %s not found", path)
+}
+
+func splitRemotePath(path string) []string {
+	var parts []string
+	for _, part := range strings.Split(path, "/") {
+		if part != "" && part != "." {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+// fileName is the name a file is shown with
+func fileName(f *models.File) string {
+	if f.DisplayName != "" {
+		return f.DisplayName
+	}
+	if f.OriginalName != "" {
+		return f.OriginalName
+	}
+	return f.Name
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"oncloud/models"
+	"oncloud/utils"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+func runLogin(args []string) error {
+	fs := newFlagSet("login")
+	server := fs.String("server", "", "server URL, such as https://cloud.example.com")
+	email := fs.String("email", "", "account email")
+	passwordStdin := fs.Bool("password-stdin", false, "read the password from standard input")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errUsage
+	}
+
+	creds, err := loadCredentials()
+	if err != nil {
+		return err
+	}
+	if *server != "" {
+		creds.Server = strings.TrimRight(*server, "/")
+	}
+
+	stdin := bufio.NewReader(os.Stdin)
+	if *email == "" {
+		if *email, err = prompt(stdin, "Email: "); err != nil {
+			return err
+		}
+	}
+	var password string
+	if *passwordStdin {
+		if password, err = readLine(stdin); err != nil {
+			return err
+		}
+	} else {
+		fmt.Fprint(os.Stderr, "Password: ")
+		password, err = readPassword(stdin)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return err
+		}
+	}
+
+	c := newClient(&credentials{Server: creds.Server})
+	var result struct {
+		Tokens               *utils.TokenPair `json:"tokens"`
+		VerificationRequired bool             `json:"verification_required"`
+		Challenge            struct {
+			ChallengeID string `json:"challenge_id"`
+			Method      string `json:"method"`
+		} `json:"challenge"`
+	}
+	if err := c.postJSON("/auth/login", &models.LoginRequest{Email: *email, Password: password}, &result); err != nil {
+		return err
+	}
+
+	// Logins from a new device are held until confirmed with a code
+	if result.VerificationRequired {
+		message := "Enter the code sent to your email: "
+		if result.Challenge.Method == "totp" {
+			message = "Enter the code from your authenticator app: "
+		}
+		code, err := prompt(stdin, message)
+		if err != nil {
+			return err
+		}
+		err = c.postJSON("/auth/login/verify", &models.LoginVerificationRequest{
+			ChallengeID: result.Challenge.ChallengeID,
+			Code:        code,
+		}, &result)
+		if err != nil {
+			return err
+		}
+	}
+	if result.Tokens == nil {
+		return fmt.Errorf("server did not return a session")
+	}
+
+	creds.Email = *email
+	creds.AccessToken = result.Tokens.AccessToken
+	creds.RefreshToken = result.Tokens.RefreshToken
+	if err := saveCredentials(creds); err != nil {
+		return fmt.Errorf("failed to save session: %v", err)
+	}
+	fmt.Printf("Logged in to %s as %s\n", creds.Server, creds.Email)
+	return nil
+}
+
+func runLogout(args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	c, err := authorizedClient()
+	if errors.Is(err, errNotLoggedIn) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// Forget the session even when the server has already ended it
+	if err := c.postJSON("/auth/logout", nil, nil); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+	}
+	return removeCredentials()
+}
+
+func runList(args []string) error {
+	fs := newFlagSet("ls")
+	long := fs.Bool("long", false, "show sizes and modification times")
+	fs.BoolVar(long, "l", false, "shorthand for --long")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		return errUsage
+	}
+
+	c, err := authorizedClient()
+	if err != nil {
+		return err
+	}
+	folderID, err := c.resolveFolder(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	folders, err := c.listFolders(folderID)
+	if err != nil {
+		return err
+	}
+	files, err := c.listFiles(folderID)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, folder := range folders {
+		if *long {
+			fmt.Fprintf(w, "%s\t%s\t%s/\n", "-", folder.UpdatedAt.Local().Format("2006-01-02 15:04"), folder.Name)
+		} else {
+			fmt.Fprintf(w, "%s/\n", folder.Name)
+		}
+	}
+	for i := range files {
+		if *long {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", utils.FormatFileSize(files[i].Size), files[i].UpdatedAt.Local().Format("2006-01-02 15:04"), fileName(&files[i]))
+		} else {
+			fmt.Fprintln(w, fileName(&files[i]))
+		}
+	}
+	return w.Flush()
+}
+
+func runUpload(args []string) error {
+	fs := newFlagSet("upload")
+	to := fs.String("to", "/", "remote folder to upload into")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errUsage
+	}
+
+	c, err := authorizedClient()
+	if err != nil {
+		return err
+	}
+	folderID, err := c.resolveFolder(*to)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range fs.Args() {
+		file, err := uploadFile(c, path, folderID)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		fmt.Printf("Uploaded %s (%s)\n", fileName(file), utils.FormatFileSize(file.Size))
+	}
+	return nil
+}
+
+// uploadFile uploads a local file into a folder as a new file
+func uploadFile(c *client, path, folderID string) (*models.File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fields := map[string]string{}
+	if folderID != "root" {
+		fields["folder_id"] = folderID
+	}
+	var result models.UploadResponse
+	if err := c.upload("/files/upload", fields, filepath.Base(path), f, &result); err != nil {
+		return nil, err
+	}
+	if result.File == nil {
+		return nil, fmt.Errorf("server did not return the uploaded file")
+	}
+	return result.File, nil
+}
+
+func runDownload(args []string) error {
+	fs := newFlagSet("download")
+	output := fs.String("o", "", "local file or directory to save to (default: the current directory)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+
+	c, err := authorizedClient()
+	if err != nil {
+		return err
+	}
+	file, err := c.resolveFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	target := fileName(file)
+	if *output != "" {
+		target = *output
+		if info, err := os.Stat(target); err == nil && info.IsDir() {
+			target = filepath.Join(target, fileName(file))
+		}
+	}
+	if err := downloadFile(c, file, target); err != nil {
+		return err
+	}
+	fmt.Printf("Downloaded %s (%s)\n", target, utils.FormatFileSize(file.Size))
+	return nil
+}
+
+// downloadFile saves a file to target through a temporary file, so an
+// interrupted download never leaves a partial file in its place
+func downloadFile(c *client, file *models.File, target string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".*.part")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := c.download(file.ID.Hex(), tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return err
+	}
+	return os.Chtimes(target, file.UpdatedAt, file.UpdatedAt)
+}
+
+func runShare(args []string) error {
+	fs := newFlagSet("share")
+	password := fs.String("password", "", "password required to open the link")
+	expires := fs.Duration("expires", 0, "how long the link works, such as 72h (default: no expiry)")
+	maxDownloads := fs.Int("max-downloads", 0, "number of downloads after which the link stops working")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+
+	c, err := authorizedClient()
+	if err != nil {
+		return err
+	}
+
+	req := &models.ShareRequest{Password: *password, MaxDownloads: *maxDownloads}
+	if *expires > 0 {
+		expiresAt := time.Now().Add(*expires)
+		req.ExpiresAt = &expiresAt
+	}
+
+	// Paths naming a folder share the folder, others a file
+	path := fs.Arg(0)
+	resource := "/folders/"
+	id, err := c.resolveFolder(path)
+	if err != nil || id == "root" {
+		file, fileErr := c.resolveFile(path)
+		if fileErr != nil {
+			return fileErr
+		}
+		resource, id = "/files/", file.ID.Hex()
+	}
+
+	// An active share is reused, updated with any settings given, so its
+	// link keeps working
+	_, err = c.get(resource+id+"/share", nil, nil)
+	var apiErr *apiError
+	switch {
+	case errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound:
+		err = c.postJSON(resource+id+"/share", req, nil)
+	case err == nil && (*password != "" || *expires > 0 || *maxDownloads > 0):
+		err = c.sendJSON(http.MethodPut, resource+id+"/share", req, nil)
+	}
+	if err != nil {
+		return err
+	}
+	var result struct {
+		ShareURL string `json:"share_url"`
+	}
+	if _, err := c.get(resource+id+"/share/url", nil, &result); err != nil {
+		return err
+	}
+	fmt.Println(result.ShareURL)
+	return nil
+}
+
+func prompt(r *bufio.Reader, message string) (string, error) {
+	fmt.Fprint(os.Stderr, message)
+	line, err := readLine(r)
+	return strings.TrimSpace(line), err
+}
+
+// readLine reads a line without its line ending, accepting a last line
+// with none
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
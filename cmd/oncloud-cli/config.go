@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const defaultServer = "http://localhost:8080"
+
+// credentials are saved by login, readable only by the user
+type credentials struct {
+	Server       string `json:"server"`
+	Email        string `json:"email,omitempty"`
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+func credentialsPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "oncloud", "credentials.json"), nil
+}
+
+// loadCredentials reads the saved session. ONCLOUD_SERVER and
+// ONCLOUD_TOKEN override it, for scripts and CI.
+func loadCredentials() (*credentials, error) {
+	creds := &credentials{}
+	if path, err := credentialsPath(); err == nil {
+		data, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, creds); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if server := os.Getenv("ONCLOUD_SERVER"); server != "" {
+		creds.Server = server
+	}
+	if token := os.Getenv("ONCLOUD_TOKEN"); token != "" {
+		creds.AccessToken = token
+		creds.RefreshToken = ""
+	}
+	if creds.Server == "" {
+		creds.Server = defaultServer
+	}
+	creds.Server = strings.TrimRight(creds.Server, "/")
+	return creds, nil
+}
+
+func saveCredentials(creds *credentials) error {
+	path, err := credentialsPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(creds, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+func removeCredentials() error {
+	path, err := credentialsPath()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
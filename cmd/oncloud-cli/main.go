@@ -0,0 +1,86 @@
+// Command oncloud-cli works with an OnCloud account from the terminal:
+// signing in, listing, uploading, downloading and sharing files, and
+// keeping a local directory in sync with a folder. It only uses the public
+// REST API, and is built from the server's module so the two stay in step.
+//
+// Usage:
+//
+//	oncloud-cli <command> [flags] [arguments]
+//
+// Remote folders and files are given as slash separated paths from the
+// root of the account, such as /Photos/2024.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+)
+
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(args []string) error
+}
+
+var commands = []*command{
+	{"login", "login [--server URL] [--email EMAIL] [--password-stdin]", "Sign in and save the session", runLogin},
+	{"logout", "logout", "End the session and forget it", runLogout},
+	{"ls", "ls [--long] [REMOTE_FOLDER]", "List a folder", runList},
+	{"upload", "upload [--to REMOTE_FOLDER] LOCAL_FILE...", "Upload files", runUpload},
+	{"download", "download [-o LOCAL_PATH] REMOTE_FILE", "Download a file", runDownload},
+	{"share", "share [--password P] [--expires DURATION] [--max-downloads N] REMOTE_PATH", "Create a share link for a file or folder", runShare},
+	{"sync", "sync [--direction both|up|down] [--dry-run] LOCAL_DIR REMOTE_FOLDER", "Sync a local directory with a folder", runSync},
+}
+
+// errUsage makes main print the command's usage
+var errUsage = errors.New("usage")
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] == "help" || os.Args[1] == "-h" || os.Args[1] == "--help" {
+		printUsage()
+		return
+	}
+
+	for _, cmd := range commands {
+		if cmd.name != os.Args[1] {
+			continue
+		}
+		err := cmd.run(os.Args[2:])
+		if errors.Is(err, errUsage) || errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintf(os.Stderr, "usage: oncloud-cli %s\n", cmd.usage)
+			os.Exit(2)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "oncloud-cli %s: %v\n", cmd.name, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "oncloud-cli: unknown command %q\n\n", os.Args[1])
+	printUsage()
+	os.Exit(2)
+}
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "usage: oncloud-cli <command> [flags] [arguments]")
+	fmt.Fprintln(os.Stderr, "\nCommands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(os.Stderr, "\nRun oncloud-cli <command> -h for a command's flags.")
+	fmt.Fprintln(os.Stderr, "The server and token can also be set with ONCLOUD_SERVER and ONCLOUD_TOKEN.")
+}
+
+// newFlagSet returns a flag set which reports errors instead of exiting
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Flags of oncloud-cli %s:\n", name)
+		fs.PrintDefaults()
+	}
+	return fs
+}
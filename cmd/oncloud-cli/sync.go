@@ -0,0 +1,263 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"maps"
+	"oncloud/models"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// syncer compares a local directory tree with a remote folder tree and
+// copies what is missing or changed. Deletions are never propagated.
+type syncer struct {
+	client *client
+	up     bool // copy local changes to the server
+	down   bool // copy remote changes to the local directory
+	dryRun bool
+
+	copied, failed int
+}
+
+func runSync(args []string) error {
+	fs := newFlagSet("sync")
+	direction := fs.String("direction", "both", "both, up (local to remote only) or down (remote to local only)")
+	dryRun := fs.Bool("dry-run", false, "only print what would be copied")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return errUsage
+	}
+	if *direction != "both" && *direction != "up" && *direction != "down" {
+		return fmt.Errorf("--direction must be both, up or down")
+	}
+
+	c, err := authorizedClient()
+	if err != nil {
+		return err
+	}
+	localDir := fs.Arg(0)
+	if info, err := os.Stat(localDir); err != nil || !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", localDir)
+	}
+	folderID, err := c.resolveFolder(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	s := &syncer{
+		client: c,
+		up:     *direction != "down",
+		down:   *direction != "up",
+		dryRun: *dryRun,
+	}
+	if err := s.syncFolder(localDir, folderID); err != nil {
+		return err
+	}
+
+	fmt.Printf("%d copied, %d failed\n", s.copied, s.failed)
+	if s.failed > 0 {
+		return fmt.Errorf("some files could not be copied")
+	}
+	return nil
+}
+
+// syncFolder syncs one level and recurses into the subfolders on either
+// side. Files present on both sides with different content are copied
+// from the side changed most recently.
+func (s *syncer) syncFolder(localDir, folderID string) error {
+	entries, err := os.ReadDir(localDir)
+	if err != nil {
+		return err
+	}
+	localFiles := map[string]os.FileInfo{}
+	localDirs := map[string]bool{}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		if entry.IsDir() {
+			localDirs[entry.Name()] = true
+		} else if entry.Type().IsRegular() {
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			localFiles[entry.Name()] = info
+		}
+	}
+
+	remoteFiles := map[string]*models.File{}
+	remoteDirs := map[string]string{}
+	if folderID != "" {
+		files, err := s.client.listFiles(folderID)
+		if err != nil {
+			return err
+		}
+		for i := range files {
+			remoteFiles[fileName(&files[i])] = &files[i]
+		}
+		folders, err := s.client.listFolders(folderID)
+		if err != nil {
+			return err
+		}
+		for _, folder := range folders {
+			remoteDirs[folder.Name] = folder.ID.Hex()
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(localFiles)) {
+		info := localFiles[name]
+		path := filepath.Join(localDir, name)
+		remote, exists := remoteFiles[name]
+		switch {
+		case !exists:
+			if s.up {
+				s.upload(path, folderID)
+			}
+		case remote.Size == info.Size() && localHash(path) == remote.Hash:
+			// Unchanged
+		case info.ModTime().After(remote.UpdatedAt):
+			if s.up {
+				s.uploadVersion(path, remote)
+			}
+		default:
+			if s.down {
+				s.download(remote, path)
+			}
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(remoteFiles)) {
+		remote := remoteFiles[name]
+		if _, exists := localFiles[name]; !exists && !localDirs[name] && s.down {
+			s.download(remote, filepath.Join(localDir, name))
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(localDirs)) {
+		childID, exists := remoteDirs[name]
+		if !exists {
+			if !s.up {
+				continue
+			}
+			if childID, err = s.createFolder(name, folderID); err != nil {
+				s.fail(filepath.Join(localDir, name), err)
+				continue
+			}
+		}
+		if err := s.syncFolder(filepath.Join(localDir, name), childID); err != nil {
+			s.fail(filepath.Join(localDir, name), err)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(remoteDirs)) {
+		childID := remoteDirs[name]
+		if localDirs[name] || !s.down {
+			continue
+		}
+		if _, isFile := localFiles[name]; isFile {
+			continue
+		}
+		path := filepath.Join(localDir, name)
+		fmt.Printf("mkdir %s\n", path)
+		if s.dryRun {
+			continue
+		}
+		if err := os.Mkdir(path, 0o755); err != nil {
+			s.fail(path, err)
+			continue
+		}
+		if err := s.syncFolder(path, childID); err != nil {
+			s.fail(path, err)
+		}
+	}
+	return nil
+}
+
+func (s *syncer) upload(path, folderID string) {
+	fmt.Printf("upload %s\n", path)
+	if s.dryRun {
+		return
+	}
+	if _, err := uploadFile(s.client, path, folderID); err != nil {
+		s.fail(path, err)
+		return
+	}
+	s.copied++
+}
+
+// uploadVersion replaces a remote file's content, keeping the previous
+// content as a version
+func (s *syncer) uploadVersion(path string, remote *models.File) {
+	fmt.Printf("update %s\n", path)
+	if s.dryRun {
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		s.fail(path, err)
+		return
+	}
+	defer f.Close()
+
+	if err := s.client.upload("/files/"+remote.ID.Hex()+"/versions", nil, filepath.Base(path), f, nil); err != nil {
+		s.fail(path, err)
+		return
+	}
+	s.copied++
+}
+
+func (s *syncer) download(remote *models.File, path string) {
+	fmt.Printf("download %s\n", path)
+	if s.dryRun {
+		return
+	}
+	if err := downloadFile(s.client, remote, path); err != nil {
+		s.fail(path, err)
+		return
+	}
+	s.copied++
+}
+
+// createFolder creates a remote folder. In a dry run it is not created and
+// its contents are compared with an empty folder.
+func (s *syncer) createFolder(name, parentID string) (string, error) {
+	fmt.Printf("mkdir remote %s\n", name)
+	if s.dryRun || parentID == "" {
+		return "", nil
+	}
+	req := &models.FolderCreateRequest{Name: name}
+	if parentID != "root" {
+		req.ParentID = parentID
+	}
+	var folder models.Folder
+	if err := s.client.postJSON("/folders/", req, &folder); err != nil {
+		return "", err
+	}
+	return folder.ID.Hex(), nil
+}
+
+func (s *syncer) fail(path string, err error) {
+	fmt.Fprintf(os.Stderr, "error: %s: %v\n", path, err)
+	s.failed++
+}
+
+// localHash is the MD5 of a local file, in the form the server stores
+func localHash(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
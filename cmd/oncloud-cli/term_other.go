@@ -0,0 +1,11 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package main
+
+import "bufio"
+
+// readPassword reads a line. Echo cannot be turned off on this platform;
+// use login --password-stdin to keep the password off the screen.
+func readPassword(r *bufio.Reader) (string, error) {
+	return readLine(r)
+}
@@ -1,9 +1,16 @@
 package config
 
 import (
+	"fmt"
 	"log"
+	"net"
+	"oncloud/secrets"
+	"oncloud/utils"
+	"oncloud/warehouse"
 	"os"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -13,9 +20,13 @@ type Config struct {
 	Environment string
 	Debug       bool
 
-	// Database Configuration
-	MongoURI string
-	DBName   string
+	// Database Configuration. Indexes are created and pending migrations
+	// applied at startup unless turned off, such as when deploys run
+	// "oncloud migrate up" as a separate step.
+	MongoURI        string
+	DBName          string
+	DBCreateIndexes bool
+	DBAutoMigrate   bool
 
 	// JWT Configuration
 	JWTSecret        string
@@ -23,11 +34,230 @@ type Config struct {
 	AccessTokenTTL   time.Duration
 	RefreshTokenTTL  time.Duration
 
+	// Session Lifetime Configuration. Refreshing slides a session's expiry
+	// forward by RefreshTokenTTL, up to SessionMaxLifetime after sign-in.
+	SessionMaxLifetime   time.Duration
+	SessionDeviceBinding bool
+
+	// Password Reset Configuration. Reset links work once, for
+	// PasswordResetTokenTTL, and can be asked for PasswordResetMaxPerEmail
+	// times per email and PasswordResetMaxPerIP times per IP address every
+	// PasswordResetWindow.
+	PasswordResetTokenTTL    time.Duration
+	PasswordResetMaxPerEmail int
+	PasswordResetMaxPerIP    int
+	PasswordResetWindow      time.Duration
+	PasswordResetURL         string
+
 	// Storage Configuration
 	DefaultStorageProvider string
 	UploadPath             string
 	MaxUploadSize          int64
 	AllowedFileTypes       []string
+	RejectTypeMismatch     bool // reject, not just flag, uploads whose content does not match their extension
+
+	// Upload Concurrency Configuration
+	MaxConcurrentUploads        int
+	MaxConcurrentUploadsPerUser int
+	UploadQueueTimeout          time.Duration
+
+	// Upload Session Configuration
+	ChunkUploadPath       string
+	UploadSessionTTL      time.Duration
+	UploadCleanupInterval time.Duration
+
+	// Orphaned Object Collection Configuration
+	OrphanGCInterval     time.Duration
+	OrphanGCSafetyWindow time.Duration
+	OrphanGCDelete       bool
+
+	// File Expiry Configuration
+	FileExpiryInterval time.Duration
+	FileExpiryWarning  time.Duration
+
+	// Trash Configuration
+	TrashEmptyConfirmWindow time.Duration
+	TrashEmptyDelay         time.Duration
+
+	// Instant Upload Configuration
+	InstantUploadShared bool
+
+	// Hot File Cache Configuration
+	HotFileThreshold  int
+	HotFileWindow     time.Duration
+	CacheWarmInterval time.Duration
+	EdgeCacheBudget   int64
+	LocalCacheDir     string
+	LocalCacheBudget  int64
+
+	// Object Cache Configuration
+	ObjectCacheDir           string
+	ObjectCacheSize          int64
+	ObjectCacheMaxObjectSize int64
+
+	// Transfer Acceleration Configuration
+	TransferConcurrency int
+	TransferPartSize    int64
+
+	// Compression At Rest Configuration
+	CompressionAtRest string
+
+	// Provider Resilience Configuration
+	ProviderMaxRetries       int
+	ProviderRetryBackoff     time.Duration
+	ProviderTimeout          time.Duration
+	ProviderBreakerThreshold int
+	ProviderBreakerCooldown  time.Duration
+
+	// Analytics Configuration
+	RollupInterval          time.Duration
+	AnalyticsBufferSize     int
+	AnalyticsBatchSize      int
+	AnalyticsFlushInterval  time.Duration
+	AnalyticsEnqueueTimeout time.Duration
+
+	// Analytics events, API request logs and webhook logs expire this long
+	// after they are written, kept forever when 0. AnalyticsRetentionByType
+	// overrides AnalyticsRetention per event type, as type=duration pairs.
+	AnalyticsRetention       time.Duration
+	AnalyticsRetentionByType []string
+	APIMetricsRetention      time.Duration
+	WebhookLogRetention      time.Duration
+
+	// Admin report aggregations are stopped after ReportMaxTime and return
+	// at most ReportMaxResults documents
+	ReportMaxTime    time.Duration
+	ReportMaxResults int
+
+	// Activity Archive Configuration. Activities older than
+	// ActivityRetentionDays are moved to the default storage provider.
+	ActivityArchiveEnabled  bool
+	ActivityRetentionDays   int
+	ActivityArchiveInterval time.Duration
+
+	// Anomaly Detection Configuration
+	AnomalyDetectionEnabled bool
+	AnomalyCheckInterval    time.Duration
+	AnomalySigma            float64
+
+	// Status Page Configuration. Components are checked every
+	// StatusCheckInterval; payments are checked by requesting
+	// StatusPaymentsURL, and left out when it is empty.
+	StatusCheckInterval time.Duration
+	StatusCheckTimeout  time.Duration
+	StatusPaymentsURL   string
+
+	// Warehouse Export Configuration
+	WarehouseExportEnabled  bool
+	WarehouseSink           string
+	WarehouseExportInterval time.Duration
+	WarehouseBatchSize      int
+	ClickHouseURL           string
+	ClickHouseDatabase      string
+	ClickHouseTable         string
+	ClickHouseUsername      string
+	ClickHousePassword      string
+	BigQueryProjectID       string
+	BigQueryDataset         string
+	BigQueryTable           string
+	BigQueryCredentialsFile string
+
+	// Secrets Backend Configuration. JWT secrets, the SMTP password and
+	// storage provider keys can be "secret://<path>#<key>" references to
+	// secrets kept in it.
+	SecretsBackend         string
+	SecretsRefreshInterval time.Duration
+	SecretsTimeout         time.Duration
+	VaultAddr              string
+	VaultToken             string
+	VaultNamespace         string
+	SecretsAWSRegion       string
+
+	// GeoIP Configuration
+	GeoIPDatabasePath string
+	IPAnonymization   string
+
+	// Office Editor (WOPI) Configuration
+	OfficeEditorEnabled bool
+	OfficeEditorURL     string
+	WOPIBaseURL         string
+	WOPITokenTTL        time.Duration
+
+	// Virus Scan Configuration
+	ClamAVAddress    string
+	VirusScanTimeout time.Duration
+	VirusScanMaxSize int64
+
+	// Document Preview Configuration
+	PreviewRenderer string
+	PreviewDPI      int
+	PreviewTimeout  time.Duration
+	PreviewMaxPages int
+
+	// Text Preview Configuration
+	TextPreviewMaxFileSize int64
+
+	// Storage Report Configuration. Monthly storage reports are emailed on
+	// StorageReportDay of the next month, with a link to StorageReportUnsubscribeURL.
+	StorageReportsEnabled       bool
+	StorageReportDay            int
+	StorageReportUnsubscribeURL string
+
+	// Media Streaming Configuration
+	HLSTranscoder       string
+	HLSSegmentDuration  time.Duration
+	HLSTranscodeTimeout time.Duration
+	HLSMaxFileSize      int64
+	HLSSegmentURLTTL    time.Duration
+	HLSRetention        time.Duration
+
+	// Payment Gateway Configuration. Refunds are issued through
+	// PaymentGateway. Users with a chargeback are flagged, and their service
+	// paused when the dispute is opened or lost, as ChargebackPause says, or
+	// never.
+	PaymentGateway      string
+	StripeSecretKey     string
+	StripeWebhookSecret string
+	ChargebackPause     string
+
+	// Download Receipt Configuration
+	DownloadReceiptSigningKey string
+
+	// Cloud Import Configuration
+	CloudImportRedirectURL  string
+	DropboxClientID         string
+	DropboxClientSecret     string
+	GoogleDriveClientID     string
+	GoogleDriveClientSecret string
+	OneDriveClientID        string
+	OneDriveClientSecret    string
+
+	// Inbound Email Configuration
+	InboundEmailDomain            string
+	InboundEmailMailgunSigningKey string
+	InboundEmailWebhookSecret     string
+
+	// Chat Bot Configuration
+	TelegramBotToken      string
+	TelegramWebhookSecret string
+	SlackBotToken         string
+	SlackSigningSecret    string
+
+	// Helpdesk Integration Configuration
+	HelpdeskProvider      string // zendesk, freshdesk, webhook; disabled when empty
+	HelpdeskURL           string
+	HelpdeskEmail         string
+	HelpdeskAPIKey        string
+	HelpdeskWebhookSecret string
+	HelpdeskAutoExport    bool
+
+	// Sync gRPC API Configuration
+	GRPCPort        string
+	GRPCTLSCertFile string
+	GRPCTLSKeyFile  string
+
+	// GraphQL API Configuration
+	GraphQLEnabled bool
 
 	// Security Configuration
 	CORSAllowedOrigins []string
@@ -35,6 +265,13 @@ type Config struct {
 	RateLimitRequests  int
 	RateLimitWindow    time.Duration
 
+	// Load Test Configuration
+	LoadTestMode bool // skips rate limiting so benchmarks measure the handlers
+
+	// Proxy Configuration
+	TrustedProxies  []string
+	ClientIPHeaders []string
+
 	// Email Configuration
 	SMTPHost     string
 	SMTPPort     int
@@ -60,10 +297,20 @@ type Config struct {
 	AdminPanelEnabled bool
 	AdminDefaultEmail string
 	AdminDefaultPass  string
+
+	// Maintenance mode, which admins can also switch at runtime
+	MaintenanceMode    bool
+	MaintenanceMessage string
+
+	// Confirmed abuse reports after which a share link is disabled, which
+	// admins can also change at runtime
+	AbuseDisableThreshold int
 }
 
 var AppConfig *Config
 
+var smtpPasswordMu sync.RWMutex
+
 // LoadConfig loads configuration from environment variables
 func LoadConfig() *Config {
 	config := &Config{
@@ -73,20 +320,223 @@ func LoadConfig() *Config {
 		Debug:       getEnvAsBool("DEBUG", true),
 
 		// Database Configuration
-		MongoURI: getEnv("MONGO_URI", "mongodb://localhost:27017"),
-		DBName:   getEnv("DB_NAME", "cloudstorage"),
+		MongoURI:        getEnv("MONGO_URI", "mongodb://localhost:27017"),
+		DBName:          getEnv("DB_NAME", "cloudstorage"),
+		DBCreateIndexes: getEnvAsBool("DB_CREATE_INDEXES", true),
+		DBAutoMigrate:   getEnvAsBool("DB_AUTO_MIGRATE", true),
 
 		// JWT Configuration
 		JWTSecret:        getEnv("JWT_SECRET", "your-super-secret-jwt-key-change-in-production"),
 		JWTRefreshSecret: getEnv("JWT_REFRESH_SECRET", "your-super-secret-refresh-key-change-in-production"),
-		AccessTokenTTL:   getEnvAsDuration("ACCESS_TOKEN_TTL", "24h"),
+		AccessTokenTTL:   getEnvAsDuration("ACCESS_TOKEN_TTL", "15m"),
 		RefreshTokenTTL:  getEnvAsDuration("REFRESH_TOKEN_TTL", "168h"), // 7 days
 
+		// Session Lifetime Configuration
+		SessionMaxLifetime:   getEnvAsDuration("SESSION_MAX_LIFETIME", "720h"), // 30 days
+		SessionDeviceBinding: getEnvAsBool("SESSION_DEVICE_BINDING", true),
+
+		// Password Reset Configuration
+		PasswordResetTokenTTL:    getEnvAsDuration("PASSWORD_RESET_TOKEN_TTL", "1h"),
+		PasswordResetMaxPerEmail: getEnvAsInt("PASSWORD_RESET_MAX_PER_EMAIL", 3),
+		PasswordResetMaxPerIP:    getEnvAsInt("PASSWORD_RESET_MAX_PER_IP", 10),
+		PasswordResetWindow:      getEnvAsDuration("PASSWORD_RESET_WINDOW", "1h"),
+		PasswordResetURL:         getEnv("PASSWORD_RESET_URL", strings.TrimRight(getEnv("APP_URL", "http://localhost:8080"), "/")+"/reset-password"),
+
 		// Storage Configuration
 		DefaultStorageProvider: getEnv("DEFAULT_STORAGE_PROVIDER", "local"),
 		UploadPath:             getEnv("UPLOAD_PATH", "./uploads"),
 		MaxUploadSize:          getEnvAsInt64("MAX_UPLOAD_SIZE", 104857600), // 100MB
 		AllowedFileTypes:       getEnvAsSlice("ALLOWED_FILE_TYPES", []string{}),
+		RejectTypeMismatch:     getEnvAsBool("REJECT_TYPE_MISMATCH", false),
+
+		// Upload Concurrency Configuration
+		MaxConcurrentUploads:        getEnvAsInt("MAX_CONCURRENT_UPLOADS", 50),
+		MaxConcurrentUploadsPerUser: getEnvAsInt("MAX_CONCURRENT_UPLOADS_PER_USER", 3),
+		UploadQueueTimeout:          getEnvAsDuration("UPLOAD_QUEUE_TIMEOUT", "5s"),
+
+		// Upload Session Configuration
+		ChunkUploadPath:       getEnv("CHUNK_UPLOAD_PATH", "./tmp/chunks"), // outside UPLOAD_PATH, which is served publicly
+		UploadSessionTTL:      getEnvAsDuration("UPLOAD_SESSION_TTL", "24h"),
+		UploadCleanupInterval: getEnvAsDuration("UPLOAD_CLEANUP_INTERVAL", "1h"),
+
+		// Orphaned Object Collection Configuration
+		OrphanGCInterval:     getEnvAsDuration("ORPHAN_GC_INTERVAL", "24h"),
+		OrphanGCSafetyWindow: getEnvAsDuration("ORPHAN_GC_SAFETY_WINDOW", "168h"), // 7 days
+		OrphanGCDelete:       getEnvAsBool("ORPHAN_GC_DELETE", false),             // report only by default
+
+		// File Expiry Configuration
+		FileExpiryInterval: getEnvAsDuration("FILE_EXPIRY_INTERVAL", "15m"),
+		FileExpiryWarning:  getEnvAsDuration("FILE_EXPIRY_WARNING", "24h"), // owners are warned this long before
+
+		// Trash Configuration
+		TrashEmptyConfirmWindow: getEnvAsDuration("TRASH_EMPTY_CONFIRM_WINDOW", "15m"),
+		TrashEmptyDelay:         getEnvAsDuration("TRASH_EMPTY_DELAY", "10m"), // left to cancel, or download the trash
+
+		// Instant Upload Configuration
+		InstantUploadShared: getEnvAsBool("INSTANT_UPLOAD_SHARED", false), // reuse only the user's own content by default
+
+		// Hot File Cache Configuration
+		HotFileThreshold:  getEnvAsInt("HOT_FILE_THRESHOLD", 0), // downloads within HOT_FILE_WINDOW, disabled when 0
+		HotFileWindow:     getEnvAsDuration("HOT_FILE_WINDOW", "24h"),
+		CacheWarmInterval: getEnvAsDuration("CACHE_WARM_INTERVAL", "15m"),
+		EdgeCacheBudget:   getEnvAsInt64("EDGE_CACHE_BUDGET", 10*1024*1024*1024), // 10GB
+		LocalCacheDir:     getEnv("LOCAL_CACHE_DIR", ""),                         // disabled when empty
+		LocalCacheBudget:  getEnvAsInt64("LOCAL_CACHE_BUDGET", 5*1024*1024*1024), // 5GB
+
+		// Object Cache Configuration
+		ObjectCacheDir:           getEnv("OBJECT_CACHE_DIR", ""),                               // disabled when empty
+		ObjectCacheSize:          getEnvAsInt64("OBJECT_CACHE_SIZE", 2*1024*1024*1024),         // 2GB
+		ObjectCacheMaxObjectSize: getEnvAsInt64("OBJECT_CACHE_MAX_OBJECT_SIZE", 100*1024*1024), // 100MB
+
+		// Transfer Acceleration Configuration
+		TransferConcurrency: getEnvAsInt("TRANSFER_CONCURRENCY", 4), // 1 moves every object in a single stream
+		TransferPartSize:    getEnvAsInt64("TRANSFER_PART_SIZE", 16*1024*1024),
+
+		// Compression At Rest Configuration
+		CompressionAtRest: getEnv("COMPRESSION_AT_REST", ""), // gzip or zstd, disabled when empty
+
+		// Provider Resilience Configuration, overridable per provider
+		ProviderMaxRetries:       getEnvAsInt("PROVIDER_MAX_RETRIES", 2),
+		ProviderRetryBackoff:     getEnvAsDuration("PROVIDER_RETRY_BACKOFF", "200ms"), // doubled on every retry
+		ProviderTimeout:          getEnvAsDuration("PROVIDER_TIMEOUT", "2m"),          // of a single attempt
+		ProviderBreakerThreshold: getEnvAsInt("PROVIDER_BREAKER_THRESHOLD", 5),        // consecutive failures
+		ProviderBreakerCooldown:  getEnvAsDuration("PROVIDER_BREAKER_COOLDOWN", "30s"),
+
+		// Analytics Configuration
+		RollupInterval:          getEnvAsDuration("ROLLUP_INTERVAL", "15m"),
+		AnalyticsBufferSize:     getEnvAsInt("ANALYTICS_BUFFER_SIZE", 10000),
+		AnalyticsBatchSize:      getEnvAsInt("ANALYTICS_BATCH_SIZE", 500),
+		AnalyticsFlushInterval:  getEnvAsDuration("ANALYTICS_FLUSH_INTERVAL", "5s"),
+		AnalyticsEnqueueTimeout: getEnvAsDuration("ANALYTICS_ENQUEUE_TIMEOUT", "50ms"),
+		ReportMaxTime:           getEnvAsDuration("REPORT_MAX_TIME", "30s"),
+		ReportMaxResults:        getEnvAsInt("REPORT_MAX_RESULTS", 10000),
+
+		// Analytics and Log Retention Configuration
+		AnalyticsRetention:       getEnvAsDuration("ANALYTICS_RETENTION", "8760h"), // 1 year
+		AnalyticsRetentionByType: getEnvAsSlice("ANALYTICS_RETENTION_BY_TYPE", []string{}),
+		APIMetricsRetention:      getEnvAsDuration("API_METRICS_RETENTION", "720h"),  // 30 days
+		WebhookLogRetention:      getEnvAsDuration("WEBHOOK_LOG_RETENTION", "2160h"), // 90 days
+
+		// Activity Archive Configuration
+		ActivityArchiveEnabled:  getEnvAsBool("ACTIVITY_ARCHIVE_ENABLED", false),
+		ActivityRetentionDays:   getEnvAsInt("ACTIVITY_RETENTION_DAYS", 90),
+		ActivityArchiveInterval: getEnvAsDuration("ACTIVITY_ARCHIVE_INTERVAL", "24h"),
+
+		// Anomaly Detection Configuration
+		AnomalyDetectionEnabled: getEnvAsBool("ANOMALY_DETECTION_ENABLED", true),
+		AnomalyCheckInterval:    getEnvAsDuration("ANOMALY_CHECK_INTERVAL", "10m"),
+		AnomalySigma:            getEnvAsFloat("ANOMALY_SIGMA", 3),
+
+		// Status Page Configuration
+		StatusCheckInterval: getEnvAsDuration("STATUS_CHECK_INTERVAL", "1m"),
+		StatusCheckTimeout:  getEnvAsDuration("STATUS_CHECK_TIMEOUT", "10s"),
+		StatusPaymentsURL:   getEnv("STATUS_PAYMENTS_URL", "https://api.stripe.com/v1"),
+
+		// Warehouse Export Configuration
+		WarehouseExportEnabled:  getEnvAsBool("WAREHOUSE_EXPORT_ENABLED", false),
+		WarehouseSink:           getEnv("WAREHOUSE_SINK", "clickhouse"),
+		WarehouseExportInterval: getEnvAsDuration("WAREHOUSE_EXPORT_INTERVAL", "5m"),
+		WarehouseBatchSize:      getEnvAsInt("WAREHOUSE_BATCH_SIZE", 1000),
+		ClickHouseURL:           getEnv("CLICKHOUSE_URL", "http://localhost:8123"),
+		ClickHouseDatabase:      getEnv("CLICKHOUSE_DATABASE", "default"),
+		ClickHouseTable:         getEnv("CLICKHOUSE_TABLE", "oncloud_events"),
+		ClickHouseUsername:      getEnv("CLICKHOUSE_USERNAME", ""),
+		ClickHousePassword:      getEnv("CLICKHOUSE_PASSWORD", ""),
+		BigQueryProjectID:       getEnv("BIGQUERY_PROJECT_ID", ""),
+		BigQueryDataset:         getEnv("BIGQUERY_DATASET", ""),
+		BigQueryTable:           getEnv("BIGQUERY_TABLE", "oncloud_events"),
+		BigQueryCredentialsFile: getEnv("BIGQUERY_CREDENTIALS_FILE", ""),
+
+		// Secrets Backend Configuration
+		SecretsBackend:         getEnv("SECRETS_BACKEND", ""),
+		SecretsRefreshInterval: getEnvAsDuration("SECRETS_REFRESH_INTERVAL", "5m"),
+		SecretsTimeout:         getEnvAsDuration("SECRETS_TIMEOUT", "10s"),
+		VaultAddr:              getEnv("VAULT_ADDR", ""),
+		VaultToken:             getEnv("VAULT_TOKEN", ""),
+		VaultNamespace:         getEnv("VAULT_NAMESPACE", ""),
+		SecretsAWSRegion:       getEnv("SECRETS_AWS_REGION", getEnv("AWS_REGION", "")),
+
+		// GeoIP Configuration
+		GeoIPDatabasePath: getEnv("GEOIP_DATABASE_PATH", ""),
+		IPAnonymization:   getEnv("IP_ANONYMIZATION", "truncate"),
+
+		// Office Editor (WOPI) Configuration
+		OfficeEditorEnabled: getEnvAsBool("OFFICE_EDITOR_ENABLED", false),
+		OfficeEditorURL:     getEnv("OFFICE_EDITOR_URL", ""),
+		WOPIBaseURL:         getEnv("WOPI_BASE_URL", ""), // defaults to APP_URL
+		WOPITokenTTL:        getEnvAsDuration("WOPI_TOKEN_TTL", "10h"),
+
+		// Virus Scan Configuration
+		ClamAVAddress:    getEnv("CLAMAV_ADDRESS", ""), // host:port of clamd, disabled when empty
+		VirusScanTimeout: getEnvAsDuration("VIRUS_SCAN_TIMEOUT", "2m"),
+		VirusScanMaxSize: getEnvAsInt64("VIRUS_SCAN_MAX_SIZE", 25*1024*1024), // match clamd's StreamMaxLength
+
+		// Document Preview Configuration
+		PreviewRenderer: getEnv("PREVIEW_RENDERER", ""), // path to poppler's pdftoppm, PDF pages are not rendered when empty
+		PreviewDPI:      getEnvAsInt("PREVIEW_DPI", 110),
+		PreviewTimeout:  getEnvAsDuration("PREVIEW_TIMEOUT", "30s"),
+		PreviewMaxPages: getEnvAsInt("PREVIEW_MAX_PAGES", 300),
+
+		// Text Preview Configuration
+		TextPreviewMaxFileSize: getEnvAsInt64("TEXT_PREVIEW_MAX_FILE_SIZE", 20*1024*1024), // read whole to count lines
+
+		// Storage Report Configuration
+		StorageReportsEnabled:       getEnvAsBool("STORAGE_REPORTS_ENABLED", true),
+		StorageReportDay:            getEnvAsInt("STORAGE_REPORT_DAY", 1),
+		StorageReportUnsubscribeURL: getEnv("STORAGE_REPORT_UNSUBSCRIBE_URL", strings.TrimRight(getEnv("APP_URL", "http://localhost:8080"), "/")+"/unsubscribe"),
+
+		// Media Streaming Configuration
+		HLSTranscoder:       getEnv("HLS_TRANSCODER", ""), // path to ffmpeg, shared media is not streamed when empty
+		HLSSegmentDuration:  getEnvAsDuration("HLS_SEGMENT_DURATION", "6s"),
+		HLSTranscodeTimeout: getEnvAsDuration("HLS_TRANSCODE_TIMEOUT", "30m"),
+		HLSMaxFileSize:      getEnvAsInt64("HLS_MAX_FILE_SIZE", 1024*1024*1024),
+		HLSSegmentURLTTL:    getEnvAsDuration("HLS_SEGMENT_URL_TTL", "1h"),
+		HLSRetention:        getEnvAsDuration("HLS_RETENTION", "720h"), // since a stream was last played
+
+		// Payment Gateway Configuration
+		PaymentGateway:      getEnv("PAYMENT_GATEWAY", "stripe"),
+		StripeSecretKey:     getEnv("STRIPE_SECRET_KEY", ""), // refunds are unavailable when empty
+		StripeWebhookSecret: getEnv("STRIPE_WEBHOOK_SECRET", ""),
+		ChargebackPause:     getEnv("CHARGEBACK_PAUSE", "opened"), // opened, lost or never
+
+		// Download Receipt Configuration
+		DownloadReceiptSigningKey: getEnv("DOWNLOAD_RECEIPT_SIGNING_KEY", ""), // base64 Ed25519 key, disabled when empty
+
+		// Cloud Import Configuration
+		CloudImportRedirectURL:  getEnv("CLOUD_IMPORT_REDIRECT_URL", ""), // defaults to APP_URL/imports/callback
+		DropboxClientID:         getEnv("DROPBOX_CLIENT_ID", ""),
+		DropboxClientSecret:     getEnv("DROPBOX_CLIENT_SECRET", ""),
+		GoogleDriveClientID:     getEnv("GOOGLE_DRIVE_CLIENT_ID", ""),
+		GoogleDriveClientSecret: getEnv("GOOGLE_DRIVE_CLIENT_SECRET", ""),
+		OneDriveClientID:        getEnv("ONEDRIVE_CLIENT_ID", ""),
+		OneDriveClientSecret:    getEnv("ONEDRIVE_CLIENT_SECRET", ""),
+
+		// Inbound Email Configuration
+		InboundEmailDomain:            getEnv("INBOUND_EMAIL_DOMAIN", ""),
+		InboundEmailMailgunSigningKey: getEnv("INBOUND_EMAIL_MAILGUN_SIGNING_KEY", ""),
+		InboundEmailWebhookSecret:     getEnv("INBOUND_EMAIL_WEBHOOK_SECRET", ""),
+
+		// Chat Bot Configuration
+		TelegramBotToken:      getEnv("TELEGRAM_BOT_TOKEN", ""),
+		TelegramWebhookSecret: getEnv("TELEGRAM_WEBHOOK_SECRET", ""),
+		SlackBotToken:         getEnv("SLACK_BOT_TOKEN", ""),
+		SlackSigningSecret:    getEnv("SLACK_SIGNING_SECRET", ""),
+
+		// Helpdesk Integration Configuration
+		HelpdeskProvider:      getEnv("HELPDESK_PROVIDER", ""),
+		HelpdeskURL:           getEnv("HELPDESK_URL", ""),
+		HelpdeskEmail:         getEnv("HELPDESK_EMAIL", ""),
+		HelpdeskAPIKey:        getEnv("HELPDESK_API_KEY", ""),
+		HelpdeskWebhookSecret: getEnv("HELPDESK_WEBHOOK_SECRET", ""),
+		HelpdeskAutoExport:    getEnvAsBool("HELPDESK_AUTO_EXPORT", false),
+
+		// Sync gRPC API Configuration
+		GRPCPort:        getEnv("GRPC_PORT", ""), // disabled when empty
+		GRPCTLSCertFile: getEnv("GRPC_TLS_CERT_FILE", ""),
+		GRPCTLSKeyFile:  getEnv("GRPC_TLS_KEY_FILE", ""),
+
+		// GraphQL API Configuration
+		GraphQLEnabled: getEnvAsBool("GRAPHQL_ENABLED", false),
 
 		// Security Configuration
 		CORSAllowedOrigins: getEnvAsSlice("CORS_ALLOWED_ORIGINS", []string{
@@ -98,6 +548,17 @@ func LoadConfig() *Config {
 		RateLimitRequests: getEnvAsInt("RATE_LIMIT_REQUESTS", 100),
 		RateLimitWindow:   getEnvAsDuration("RATE_LIMIT_WINDOW", "1h"),
 
+		// Load Test Configuration
+		LoadTestMode: getEnvAsBool("LOAD_TEST_MODE", false),
+
+		// Proxy Configuration
+		TrustedProxies: getEnvAsSlice("TRUSTED_PROXIES", []string{"127.0.0.1", "::1"}),
+		ClientIPHeaders: getEnvAsSlice("CLIENT_IP_HEADERS", []string{
+			"CF-Connecting-IP",
+			"X-Forwarded-For",
+			"X-Real-IP",
+		}),
+
 		// Email Configuration
 		SMTPHost:     getEnv("SMTP_HOST", ""),
 		SMTPPort:     getEnvAsInt("SMTP_PORT", 587),
@@ -123,6 +584,13 @@ func LoadConfig() *Config {
 		AdminPanelEnabled: getEnvAsBool("ADMIN_PANEL_ENABLED", true),
 		AdminDefaultEmail: getEnv("ADMIN_DEFAULT_EMAIL", "admin@example.com"),
 		AdminDefaultPass:  getEnv("ADMIN_DEFAULT_PASS", "admin123"),
+
+		// Maintenance
+		MaintenanceMode:    getEnvAsBool("MAINTENANCE_MODE", false),
+		MaintenanceMessage: getEnv("MAINTENANCE_MESSAGE", ""),
+
+		// Abuse reports
+		AbuseDisableThreshold: getEnvAsInt("ABUSE_REPORT_DISABLE_THRESHOLD", 3),
 	}
 
 	// Set global config
@@ -172,6 +640,15 @@ func getEnvAsInt64(key string, defaultValue int64) int64 {
 	return defaultValue
 }
 
+func getEnvAsFloat(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+			return parsed
+		}
+	}
+	return defaultValue
+}
+
 func getEnvAsDuration(key string, defaultValue string) time.Duration {
 	if value := os.Getenv(key); value != "" {
 		if parsed, err := time.ParseDuration(value); err == nil {
@@ -187,10 +664,9 @@ func getEnvAsDuration(key string, defaultValue string) time.Duration {
 func getEnvAsSlice(key string, defaultValue []string) []string {
 	if value := os.Getenv(key); value != "" {
 		// Simple comma-separated parsing
-		// You might want to use a more sophisticated parser
 		var result []string
-		for _, item := range []string{value} {
-			if item != "" {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
 				result = append(result, item)
 			}
 		}
@@ -230,10 +706,198 @@ func (c *Config) ValidateConfig() error {
 		log.Fatal("JWT_REFRESH_SECRET must be changed in production")
 	}
 
+	if c.AccessTokenTTL <= 0 || c.RefreshTokenTTL <= c.AccessTokenTTL {
+		return fmt.Errorf("ACCESS_TOKEN_TTL must be positive and shorter than REFRESH_TOKEN_TTL")
+	}
+
+	if c.SessionMaxLifetime < 0 {
+		return fmt.Errorf("SESSION_MAX_LIFETIME must not be negative")
+	}
+
+	// Reset requests are only kept for a day, see the password_resets indexes
+	if c.PasswordResetTokenTTL <= 0 || c.PasswordResetTokenTTL > 24*time.Hour {
+		return fmt.Errorf("PASSWORD_RESET_TOKEN_TTL must be positive and at most 24h")
+	}
+	if c.PasswordResetWindow <= 0 || c.PasswordResetWindow > 24*time.Hour {
+		return fmt.Errorf("PASSWORD_RESET_WINDOW must be positive and at most 24h")
+	}
+	if c.PasswordResetMaxPerEmail < 0 || c.PasswordResetMaxPerIP < 0 {
+		return fmt.Errorf("PASSWORD_RESET_MAX_PER_EMAIL and PASSWORD_RESET_MAX_PER_IP must not be negative")
+	}
+
 	if c.SessionSecret == "your-session-secret-change-in-production" && c.IsProduction() {
 		log.Fatal("SESSION_SECRET must be changed in production")
 	}
 
+	if c.LoadTestMode && c.IsProduction() {
+		return fmt.Errorf("LOAD_TEST_MODE must not be enabled in production")
+	}
+
+	for _, proxy := range c.TrustedProxies {
+		if !isValidProxyAddress(proxy) {
+			return fmt.Errorf("invalid trusted proxy address or CIDR: %s", proxy)
+		}
+	}
+
+	if !utils.IsValidIPAnonymization(c.IPAnonymization) {
+		return fmt.Errorf("IP_ANONYMIZATION must be one of none, truncate or drop")
+	}
+
+	if c.WarehouseExportEnabled {
+		if err := warehouse.ValidateConfig(c.WarehouseConfig()); err != nil {
+			return fmt.Errorf("invalid warehouse export configuration: %v", err)
+		}
+	}
+
+	if c.SecretsBackend != "" {
+		if err := secrets.ValidateConfig(c.SecretsConfig()); err != nil {
+			return fmt.Errorf("invalid secrets backend configuration: %v", err)
+		}
+		if c.SecretsRefreshInterval < time.Minute {
+			return fmt.Errorf("SECRETS_REFRESH_INTERVAL must be at least 1m")
+		}
+	} else {
+		for name, value := range map[string]string{
+			"JWT_SECRET":         c.JWTSecret,
+			"JWT_REFRESH_SECRET": c.JWTRefreshSecret,
+			"SMTP_PASSWORD":      c.SMTPPassword,
+		} {
+			if secrets.IsReference(value) {
+				return fmt.Errorf("%s refers to a secret but SECRETS_BACKEND is not set", name)
+			}
+		}
+	}
+
+	if c.OfficeEditorEnabled && c.OfficeEditorURL == "" {
+		return fmt.Errorf("OFFICE_EDITOR_URL is required when office editing is enabled")
+	}
+
+	if c.InboundEmailDomain != "" && c.InboundEmailMailgunSigningKey == "" && c.InboundEmailWebhookSecret == "" {
+		return fmt.Errorf("INBOUND_EMAIL_MAILGUN_SIGNING_KEY or INBOUND_EMAIL_WEBHOOK_SECRET is required when inbound email is enabled")
+	}
+
+	if c.DownloadReceiptSigningKey != "" {
+		if _, err := utils.ParseEd25519PrivateKey(c.DownloadReceiptSigningKey); err != nil {
+			return fmt.Errorf("invalid DOWNLOAD_RECEIPT_SIGNING_KEY: %v", err)
+		}
+	}
+
+	if c.PaymentGateway != "stripe" {
+		return fmt.Errorf("PAYMENT_GATEWAY must be stripe")
+	}
+
+	if c.StripeSecretKey != "" && c.StripeWebhookSecret == "" {
+		return fmt.Errorf("STRIPE_WEBHOOK_SECRET is required when STRIPE_SECRET_KEY is set")
+	}
+
+	switch c.ChargebackPause {
+	case "opened", "lost", "never":
+	default:
+		return fmt.Errorf("CHARGEBACK_PAUSE must be one of opened, lost or never")
+	}
+
+	if c.TelegramBotToken != "" && c.TelegramWebhookSecret == "" {
+		return fmt.Errorf("TELEGRAM_WEBHOOK_SECRET is required when the Telegram bot is enabled")
+	}
+
+	if c.SlackBotToken != "" && c.SlackSigningSecret == "" {
+		return fmt.Errorf("SLACK_SIGNING_SECRET is required when the Slack bot is enabled")
+	}
+
+	switch c.HelpdeskProvider {
+	case "":
+	case "zendesk", "freshdesk", "webhook":
+		if c.HelpdeskURL == "" {
+			return fmt.Errorf("HELPDESK_URL is required when HELPDESK_PROVIDER is set")
+		}
+		if c.HelpdeskProvider == "zendesk" && (c.HelpdeskEmail == "" || c.HelpdeskAPIKey == "") {
+			return fmt.Errorf("HELPDESK_EMAIL and HELPDESK_API_KEY are required for Zendesk")
+		}
+		if c.HelpdeskProvider == "freshdesk" && c.HelpdeskAPIKey == "" {
+			return fmt.Errorf("HELPDESK_API_KEY is required for Freshdesk")
+		}
+	default:
+		return fmt.Errorf("HELPDESK_PROVIDER must be zendesk, freshdesk or webhook")
+	}
+
+	if (c.GRPCTLSCertFile == "") != (c.GRPCTLSKeyFile == "") {
+		return fmt.Errorf("GRPC_TLS_CERT_FILE and GRPC_TLS_KEY_FILE must be set together")
+	}
+
+	if c.UploadSessionTTL <= 0 || c.UploadCleanupInterval <= 0 {
+		return fmt.Errorf("UPLOAD_SESSION_TTL and UPLOAD_CLEANUP_INTERVAL must be positive")
+	}
+
+	if c.OrphanGCInterval <= 0 {
+		return fmt.Errorf("ORPHAN_GC_INTERVAL must be positive")
+	}
+
+	if c.OrphanGCSafetyWindow < time.Hour {
+		return fmt.Errorf("ORPHAN_GC_SAFETY_WINDOW must be at least 1h")
+	}
+
+	if c.FileExpiryInterval <= 0 || c.FileExpiryWarning < 0 {
+		return fmt.Errorf("FILE_EXPIRY_INTERVAL must be positive and FILE_EXPIRY_WARNING not negative")
+	}
+
+	if c.TrashEmptyConfirmWindow <= 0 || c.TrashEmptyDelay < 0 {
+		return fmt.Errorf("TRASH_EMPTY_CONFIRM_WINDOW must be positive and TRASH_EMPTY_DELAY not negative")
+	}
+
+	if c.CacheWarmInterval <= 0 {
+		return fmt.Errorf("CACHE_WARM_INTERVAL must be positive")
+	}
+
+	if c.AnalyticsRetention < 0 || c.APIMetricsRetention < 0 || c.WebhookLogRetention < 0 {
+		return fmt.Errorf("ANALYTICS_RETENTION, API_METRICS_RETENTION and WEBHOOK_LOG_RETENTION must not be negative")
+	}
+
+	if _, err := c.AnalyticsRetentionPolicies(); err != nil {
+		return err
+	}
+
+	if c.ReportMaxTime <= 0 || c.ReportMaxResults <= 0 {
+		return fmt.Errorf("REPORT_MAX_TIME and REPORT_MAX_RESULTS must be positive")
+	}
+
+	if c.ActivityRetentionDays < 1 || c.ActivityArchiveInterval <= 0 {
+		return fmt.Errorf("ACTIVITY_RETENTION_DAYS must be at least 1 and ACTIVITY_ARCHIVE_INTERVAL positive")
+	}
+
+	if c.StatusCheckInterval < 10*time.Second || c.StatusCheckTimeout <= 0 || c.StatusCheckTimeout >= c.StatusCheckInterval {
+		return fmt.Errorf("STATUS_CHECK_INTERVAL must be at least 10s and STATUS_CHECK_TIMEOUT positive and shorter")
+	}
+
+	// Download counts are kept for a week
+	if c.HotFileWindow < time.Hour || c.HotFileWindow > 7*24*time.Hour {
+		return fmt.Errorf("HOT_FILE_WINDOW must be between 1h and 168h")
+	}
+
+	if c.ObjectCacheDir != "" && (c.ObjectCacheSize <= 0 || c.ObjectCacheMaxObjectSize <= 0) {
+		return fmt.Errorf("OBJECT_CACHE_SIZE and OBJECT_CACHE_MAX_OBJECT_SIZE must be positive when the object cache is enabled")
+	}
+
+	if c.TransferConcurrency < 1 {
+		return fmt.Errorf("TRANSFER_CONCURRENCY must be at least 1")
+	}
+
+	// The smallest part S3 multipart uploads accept
+	if c.TransferPartSize < 5*1024*1024 {
+		return fmt.Errorf("TRANSFER_PART_SIZE must be at least 5MB")
+	}
+
+	if c.CompressionAtRest != "" && c.CompressionAtRest != "gzip" && c.CompressionAtRest != "zstd" {
+		return fmt.Errorf("COMPRESSION_AT_REST must be gzip, zstd or empty")
+	}
+
+	if c.ProviderMaxRetries < 0 || c.ProviderRetryBackoff <= 0 || c.ProviderTimeout <= 0 {
+		return fmt.Errorf("PROVIDER_MAX_RETRIES must not be negative, PROVIDER_RETRY_BACKOFF and PROVIDER_TIMEOUT must be positive")
+	}
+
+	if c.ProviderBreakerThreshold < 1 || c.ProviderBreakerCooldown <= 0 {
+		return fmt.Errorf("PROVIDER_BREAKER_THRESHOLD must be at least 1 and PROVIDER_BREAKER_COOLDOWN positive")
+	}
+
 	// Create upload directory if it doesn't exist
 	if err := os.MkdirAll(c.UploadPath, 0755); err != nil {
 		log.Printf("Warning: Could not create upload directory %s: %v", c.UploadPath, err)
@@ -241,3 +905,71 @@ func (c *Config) ValidateConfig() error {
 
 	return nil
 }
+
+// WarehouseConfig returns the settings for the analytics warehouse sink
+func (c *Config) WarehouseConfig() *warehouse.Config {
+	return &warehouse.Config{
+		Type:                    c.WarehouseSink,
+		ClickHouseURL:           c.ClickHouseURL,
+		ClickHouseDatabase:      c.ClickHouseDatabase,
+		ClickHouseTable:         c.ClickHouseTable,
+		ClickHouseUsername:      c.ClickHouseUsername,
+		ClickHousePassword:      c.ClickHousePassword,
+		BigQueryProjectID:       c.BigQueryProjectID,
+		BigQueryDataset:         c.BigQueryDataset,
+		BigQueryTable:           c.BigQueryTable,
+		BigQueryCredentialsFile: c.BigQueryCredentialsFile,
+	}
+}
+
+// AnalyticsRetentionPolicies returns the retention of the analytics event
+// types listed in ANALYTICS_RETENTION_BY_TYPE
+func (c *Config) AnalyticsRetentionPolicies() (map[string]time.Duration, error) {
+	policies := make(map[string]time.Duration, len(c.AnalyticsRetentionByType))
+	for _, pair := range c.AnalyticsRetentionByType {
+		eventType, value, ok := strings.Cut(pair, "=")
+		eventType = strings.TrimSpace(eventType)
+		retention, err := time.ParseDuration(strings.TrimSpace(value))
+		if !ok || eventType == "" || err != nil || retention < 0 {
+			return nil, fmt.Errorf("ANALYTICS_RETENTION_BY_TYPE entries must be type=duration, got %q", pair)
+		}
+		policies[eventType] = retention
+	}
+	return policies, nil
+}
+
+// SecretsConfig returns the settings for the secrets backend
+func (c *Config) SecretsConfig() *secrets.Config {
+	return &secrets.Config{
+		Type:           c.SecretsBackend,
+		Timeout:        c.SecretsTimeout,
+		VaultAddr:      c.VaultAddr,
+		VaultToken:     c.VaultToken,
+		VaultNamespace: c.VaultNamespace,
+		AWSRegion:      c.SecretsAWSRegion,
+	}
+}
+
+// GetSMTPPassword returns the SMTP password, which changes when it is read
+// from a secrets backend and rotated there
+func (c *Config) GetSMTPPassword() string {
+	smtpPasswordMu.RLock()
+	defer smtpPasswordMu.RUnlock()
+	return c.SMTPPassword
+}
+
+// SetSMTPPassword replaces the SMTP password
+func (c *Config) SetSMTPPassword(password string) {
+	smtpPasswordMu.Lock()
+	defer smtpPasswordMu.Unlock()
+	c.SMTPPassword = password
+}
+
+// isValidProxyAddress reports whether value is a plain IP or a CIDR range
+func isValidProxyAddress(value string) bool {
+	if strings.Contains(value, "/") {
+		_, _, err := net.ParseCIDR(value)
+		return err == nil
+	}
+	return net.ParseIP(value) != nil
+}
@@ -87,13 +87,28 @@ func (dm *DatabaseManager) SetupDatabase() error {
 	log.Println("Setting up database...")
 
 	// Create indexes
-	if err := dm.CreateIndexes(); err != nil {
-		return fmt.Errorf("failed to create indexes: %v", err)
+	if dm.config.DBCreateIndexes {
+		if err := dm.CreateIndexes(); err != nil {
+			return fmt.Errorf("failed to create indexes: %v", err)
+		}
+	} else {
+		log.Println("Index creation disabled, skipping")
 	}
 
-	// Run migrations
-	if err := dm.RunMigrations(); err != nil {
-		return fmt.Errorf("failed to run migrations: %v", err)
+	// Run migrations, or only warn about pending ones when they are applied
+	// separately
+	if dm.config.DBAutoMigrate {
+		if err := dm.RunMigrations(); err != nil {
+			return fmt.Errorf("failed to run migrations: %v", err)
+		}
+	} else {
+		pending, err := database.PendingMigrations()
+		if err != nil {
+			return fmt.Errorf("failed to check migrations: %v", err)
+		}
+		if len(pending) > 0 {
+			log.Printf("Warning: %d database migrations are pending, apply them with \"oncloud migrate up\"", len(pending))
+		}
 	}
 
 	log.Println("Database setup completed successfully")
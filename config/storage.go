@@ -1,6 +1,7 @@
 package config
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log"
@@ -89,7 +90,7 @@ func (sm *StorageManager) initializeLocalStorage() error {
 // initializeProvidersFromDB loads and initializes storage providers from database
 func (sm *StorageManager) initializeProvidersFromDB() error {
 	// FIXED: Use lazy initialization
-	providers, err := sm.getStorageService().GetProviders()
+	providers, err := sm.getStorageService().GetProviders(context.Background())
 	if err != nil {
 		return fmt.Errorf("failed to get active providers: %v", err)
 	}
@@ -320,7 +321,8 @@ func (sm *StorageManager) ValidateFileType(filename string) bool {
 
 // ValidateFileSize checks if a file size is within limits
 func (sm *StorageManager) ValidateFileSize(size int64) bool {
-	return size <= sm.config.MaxUploadSize
+	maxUploadSize := services.RuntimeSettingInt64(services.RuntimeSettingMaxUploadSize)
+	return maxUploadSize <= 0 || size <= maxUploadSize
 }
 
 // GenerateStorageKey generates a unique storage key for a file
@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"oncloud/models"
+	"oncloud/services"
+	"oncloud/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AbuseReportController struct {
+	abuseReportService *services.AbuseReportService
+	auditService       *services.AuditService
+}
+
+func NewAbuseReportController() *AbuseReportController {
+	return &AbuseReportController{
+		abuseReportService: services.NewAbuseReportService(),
+		auditService:       services.NewAuditService(),
+	}
+}
+
+// ReportLink lets anyone who opened a public or shared link report it
+func (arc *AbuseReportController) ReportLink(c *gin.Context) {
+	req, ok := utils.BoundRequest[models.AbuseReportRequest](c)
+	if !ok {
+		return
+	}
+
+	report, err := arc.abuseReportService.CreateReport(c.Request.Context(), c.Param("token"), req, c.ClientIP())
+	if err != nil {
+		respondAbuseReportError(c, err, "Failed to report link")
+		return
+	}
+
+	// Reporters only learn that the report was received
+	utils.CreatedResponse(c, "Thank you, your report was received", gin.H{
+		"id":     report.ID,
+		"status": report.Status,
+	})
+}
+
+// GetReports lists abuse reports, filtered by status, link token or file
+func (arc *AbuseReportController) GetReports(c *gin.Context) {
+	page, limit := utils.GetPagination(c, 20, 0)
+
+	filter := services.AbuseReportFilter{
+		Status: c.Query("status"),
+		Token:  c.Query("token"),
+	}
+	if fileID := c.Query("file_id"); fileID != "" {
+		if !utils.IsValidObjectID(fileID) {
+			utils.BadRequestResponse(c, "Invalid file ID")
+			return
+		}
+		objID, _ := utils.StringToObjectID(fileID)
+		filter.FileID = &objID
+	}
+
+	reports, total, err := arc.abuseReportService.ListReports(c.Request.Context(), filter, page, limit)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get abuse reports")
+		return
+	}
+
+	utils.PaginatedResponse(c, "Abuse reports retrieved successfully", reports, page, limit, total)
+}
+
+// GetReport returns one abuse report
+func (arc *AbuseReportController) GetReport(c *gin.Context) {
+	reportID := c.Param("id")
+	if !utils.IsValidObjectID(reportID) {
+		utils.BadRequestResponse(c, "Invalid report ID")
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(reportID)
+	report, err := arc.abuseReportService.GetReport(c.Request.Context(), objID)
+	if err != nil {
+		respondAbuseReportError(c, err, "Failed to get abuse report")
+		return
+	}
+
+	utils.SuccessResponse(c, "Abuse report retrieved successfully", report)
+}
+
+// TriageReport moves a report through review, disabling the reported link
+// when asked to or when enough reports about it are confirmed
+func (arc *AbuseReportController) TriageReport(c *gin.Context) {
+	admin, exists := utils.GetAdminFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "Admin not found")
+		return
+	}
+
+	reportID := c.Param("id")
+	if !utils.IsValidObjectID(reportID) {
+		utils.BadRequestResponse(c, "Invalid report ID")
+		return
+	}
+
+	req, ok := utils.BoundRequest[models.AbuseReportTriageRequest](c)
+	if !ok {
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(reportID)
+	report, err := arc.abuseReportService.TriageReport(c.Request.Context(), objID, req, admin.ID)
+	if err != nil {
+		respondAbuseReportError(c, err, "Failed to update abuse report")
+		return
+	}
+
+	arc.auditService.Record(c.Request.Context(), &models.AuditLog{
+		ActorType:    "admin",
+		ActorID:      &admin.ID,
+		Action:       "abuse_report.triaged",
+		ResourceType: "abuse_report",
+		ResourceID:   objID.Hex(),
+		Outcome:      "success",
+		IPAddress:    c.ClientIP(),
+		UserAgent:    c.Request.UserAgent(),
+		Details:      map[string]interface{}{"status": report.Status, "link_disabled": report.LinkDisabled},
+	})
+
+	utils.SuccessResponse(c, "Abuse report updated successfully", report)
+}
+
+func respondAbuseReportError(c *gin.Context, err error, message string) {
+	utils.ServiceErrorResponse(c, err, message)
+}
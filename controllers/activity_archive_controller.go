@@ -0,0 +1,142 @@
+package controllers
+
+import (
+	"errors"
+	"oncloud/models"
+	"oncloud/services"
+	"oncloud/utils"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ActivityArchiveController struct {
+	archiveService *services.ActivityArchiveService
+	auditService   *services.AuditService
+}
+
+func NewActivityArchiveController(deps services.Dependencies) *ActivityArchiveController {
+	return &ActivityArchiveController{
+		archiveService: services.NewActivityArchiveServiceWith(deps),
+		auditService:   services.NewAuditServiceWith(deps),
+	}
+}
+
+// GetArchives lists the activity archives, optionally of the days between
+// from and to
+func (ac *ActivityArchiveController) GetArchives(c *gin.Context) {
+	var from, to time.Time
+	if value := c.Query("from"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			utils.BadRequestResponse(c, "Invalid from time, expected RFC 3339")
+			return
+		}
+		from = parsed
+	}
+	if value := c.Query("to"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			utils.BadRequestResponse(c, "Invalid to time, expected RFC 3339")
+			return
+		}
+		to = parsed
+	}
+
+	archives, err := ac.archiveService.ListArchives(c.Request.Context(), from, to)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get activity archives")
+		return
+	}
+
+	utils.SuccessResponse(c, "Activity archives retrieved successfully", archives)
+}
+
+// GetArchivedActivities reads the archived activities between from and to
+// back from storage, optionally only those of a user or with an action
+func (ac *ActivityArchiveController) GetArchivedActivities(c *gin.Context) {
+	from, err := time.Parse(time.RFC3339, c.Query("from"))
+	if err != nil {
+		utils.BadRequestResponse(c, "from is required, as an RFC 3339 time")
+		return
+	}
+	to, err := time.Parse(time.RFC3339, c.Query("to"))
+	if err != nil {
+		utils.BadRequestResponse(c, "to is required, as an RFC 3339 time")
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "1000"))
+	if limit < 1 || limit > 10000 {
+		limit = 1000
+	}
+
+	query := &models.ArchivedActivityQuery{
+		From:   from,
+		To:     to,
+		Action: c.Query("action"),
+		Limit:  limit,
+	}
+	if userID := c.Query("user_id"); userID != "" {
+		if !utils.IsValidObjectID(userID) {
+			utils.BadRequestResponse(c, "Invalid user ID")
+			return
+		}
+		objID, _ := utils.StringToObjectID(userID)
+		query.UserID = &objID
+	}
+
+	activities, err := ac.archiveService.QueryArchived(c.Request.Context(), query)
+	if err != nil {
+		if errors.Is(err, services.ErrArchivedRangeInvalid) {
+			utils.BadRequestResponse(c, err.Error())
+			return
+		}
+		utils.InternalServerErrorResponse(c, "Failed to read archived activities")
+		return
+	}
+
+	utils.SuccessResponse(c, "Archived activities retrieved successfully", activities)
+}
+
+// RunArchive archives the activities past the retention period now instead
+// of waiting for the next scheduled run
+func (ac *ActivityArchiveController) RunArchive(c *gin.Context) {
+	admin, exists := utils.GetAdminFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "Admin not found")
+		return
+	}
+
+	archives, err := ac.archiveService.ArchiveOldActivities(c.Request.Context())
+	if err != nil {
+		if errors.Is(err, services.ErrActivityArchiveRunning) {
+			utils.ConflictResponse(c, "Activity archival is already running")
+			return
+		}
+		utils.InternalServerErrorResponse(c, "Failed to archive activities")
+		return
+	}
+
+	var archived int64
+	for _, archive := range archives {
+		archived += archive.Count
+	}
+
+	ac.auditService.Record(c.Request.Context(), &models.AuditLog{
+		ActorType:    "admin",
+		ActorID:      &admin.ID,
+		Action:       "activities.archived",
+		ResourceType: "activity_archive",
+		Outcome:      "success",
+		IPAddress:    c.ClientIP(),
+		UserAgent:    c.Request.UserAgent(),
+		Details: map[string]interface{}{
+			"archives":   len(archives),
+			"activities": archived,
+		},
+	})
+
+	utils.SuccessResponse(c, "Activities archived successfully", archives)
+}
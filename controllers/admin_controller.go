@@ -1,11 +1,15 @@
 package controllers
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
 	"oncloud/models"
 	"oncloud/services"
 	"oncloud/utils"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -17,32 +21,34 @@ type AdminController struct {
 	fileService    *services.FileService
 	planService    *services.PlanService
 	storageService *services.StorageService
+	pricingService *services.PricingService
+	accessService  *services.AccessPolicyService
+	auditService   *services.AuditService
+	policyService  *services.SecurityPolicyService
 }
 
-func NewAdminController() *AdminController {
+func NewAdminController(deps services.Dependencies) *AdminController {
 	return &AdminController{
-		adminService:   services.NewAdminService(),
-		userService:    services.NewUserService(),
-		fileService:    services.NewFileService(),
-		planService:    services.NewPlanService(),
-		storageService: services.NewStorageService(),
+		adminService:   services.NewAdminServiceWith(deps),
+		userService:    services.NewUserServiceWith(deps),
+		fileService:    services.NewFileServiceWith(deps),
+		planService:    services.NewPlanServiceWith(deps),
+		storageService: services.NewStorageServiceWith(deps),
+		pricingService: services.NewPricingServiceWith(deps),
+		accessService:  services.NewAccessPolicyServiceWith(deps),
+		auditService:   services.NewAuditServiceWith(deps),
+		policyService:  services.NewSecurityPolicyServiceWith(deps),
 	}
 }
 
 // Admin authentication
 func (ac *AdminController) Login(c *gin.Context) {
-	var req models.LoginRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request data")
+	req, ok := utils.BoundRequest[models.LoginRequest](c)
+	if !ok {
 		return
 	}
 
-	if err := utils.ValidateStruct(&req); err != nil {
-		utils.ValidationErrorResponse(c, err)
-		return
-	}
-
-	admin, _, err := ac.adminService.Login(req.Email, req.Password)
+	admin, _, err := ac.adminService.Login(c.Request.Context(), req.Email, req.Password)
 	if err != nil {
 		utils.UnauthorizedResponse(c, "Invalid credentials")
 		return
@@ -81,7 +87,7 @@ func (ac *AdminController) GetPlans(c *gin.Context) {
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
 	includeInactive := c.Query("include_inactive") == "true"
 
-	plans, total, err := ac.planService.GetPlansForAdmin(page, limit, includeInactive)
+	plans, total, err := ac.planService.GetPlansForAdmin(c.Request.Context(), page, limit, includeInactive)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get plans")
 		return
@@ -98,7 +104,7 @@ func (ac *AdminController) GetPlan(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(planID)
-	plan, err := ac.planService.GetPlanForAdmin(objID)
+	plan, err := ac.planService.GetPlanForAdmin(c.Request.Context(), objID)
 	if err != nil {
 		utils.NotFoundResponse(c, "Plan not found")
 		return
@@ -108,18 +114,12 @@ func (ac *AdminController) GetPlan(c *gin.Context) {
 }
 
 func (ac *AdminController) CreatePlan(c *gin.Context) {
-	var plan models.Plan
-	if err := c.ShouldBindJSON(&plan); err != nil {
-		utils.BadRequestResponse(c, "Invalid request data")
+	plan, ok := utils.BoundRequest[models.Plan](c)
+	if !ok {
 		return
 	}
 
-	if err := utils.ValidateStruct(&plan); err != nil {
-		utils.ValidationErrorResponse(c, err)
-		return
-	}
-
-	createdPlan, err := ac.planService.CreatePlan(&plan)
+	createdPlan, err := ac.planService.CreatePlan(c.Request.Context(), plan)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to create plan")
 		return
@@ -135,14 +135,13 @@ func (ac *AdminController) UpdatePlan(c *gin.Context) {
 		return
 	}
 
-	var updates map[string]interface{}
-	if err := c.ShouldBindJSON(&updates); err != nil {
-		utils.BadRequestResponse(c, "Invalid request data")
+	req, ok := utils.BoundRequest[models.PlanUpdateRequest](c)
+	if !ok {
 		return
 	}
 
 	objID, _ := utils.StringToObjectID(planID)
-	updatedPlan, err := ac.planService.UpdatePlan(objID, updates)
+	updatedPlan, err := ac.planService.UpdatePlan(c.Request.Context(), objID, utils.UpdateFields(req))
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to update plan")
 		return
@@ -159,7 +158,7 @@ func (ac *AdminController) DeletePlan(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(planID)
-	err := ac.planService.DeletePlan(objID)
+	err := ac.planService.DeletePlan(c.Request.Context(), objID)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to delete plan")
 		return
@@ -176,7 +175,7 @@ func (ac *AdminController) ActivatePlan(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(planID)
-	err := ac.planService.SetPlanStatus(objID, true)
+	err := ac.planService.SetPlanStatus(c.Request.Context(), objID, true)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to activate plan")
 		return
@@ -193,7 +192,7 @@ func (ac *AdminController) DeactivatePlan(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(planID)
-	err := ac.planService.SetPlanStatus(objID, false)
+	err := ac.planService.SetPlanStatus(c.Request.Context(), objID, false)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to deactivate plan")
 		return
@@ -207,7 +206,7 @@ func (ac *AdminController) GetStorageProviders(c *gin.Context) {
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
 
-	providers, total, err := ac.storageService.GetProvidersForAdmin(page, limit)
+	providers, total, err := ac.storageService.GetProvidersForAdmin(c.Request.Context(), page, limit)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get storage providers")
 		return
@@ -224,7 +223,7 @@ func (ac *AdminController) GetStorageProvider(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(providerID)
-	provider, err := ac.storageService.GetProviderForAdmin(objID)
+	provider, err := ac.storageService.GetProviderForAdmin(c.Request.Context(), objID)
 	if err != nil {
 		utils.NotFoundResponse(c, "Storage provider not found")
 		return
@@ -234,18 +233,12 @@ func (ac *AdminController) GetStorageProvider(c *gin.Context) {
 }
 
 func (ac *AdminController) CreateStorageProvider(c *gin.Context) {
-	var provider models.StorageProvider
-	if err := c.ShouldBindJSON(&provider); err != nil {
-		utils.BadRequestResponse(c, "Invalid request data")
+	provider, ok := utils.BoundRequest[models.StorageProvider](c)
+	if !ok {
 		return
 	}
 
-	if err := utils.ValidateStruct(&provider); err != nil {
-		utils.ValidationErrorResponse(c, err)
-		return
-	}
-
-	createdProvider, err := ac.storageService.CreateProvider(&provider)
+	createdProvider, err := ac.storageService.CreateProvider(c.Request.Context(), provider)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to create storage provider")
 		return
@@ -261,14 +254,13 @@ func (ac *AdminController) UpdateStorageProvider(c *gin.Context) {
 		return
 	}
 
-	var updates map[string]interface{}
-	if err := c.ShouldBindJSON(&updates); err != nil {
-		utils.BadRequestResponse(c, "Invalid request data")
+	req, ok := utils.BoundRequest[models.StorageProviderUpdateRequest](c)
+	if !ok {
 		return
 	}
 
 	objID, _ := utils.StringToObjectID(providerID)
-	updatedProvider, err := ac.storageService.UpdateProvider(objID, updates)
+	updatedProvider, err := ac.storageService.UpdateProvider(c.Request.Context(), objID, utils.UpdateFields(req))
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to update storage provider")
 		return
@@ -285,7 +277,7 @@ func (ac *AdminController) DeleteStorageProvider(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(providerID)
-	err := ac.storageService.DeleteProvider(objID)
+	err := ac.storageService.DeleteProvider(c.Request.Context(), objID)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to delete storage provider")
 		return
@@ -302,7 +294,7 @@ func (ac *AdminController) TestStorageProvider(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(providerID)
-	testResult, err := ac.storageService.TestProvider(objID)
+	testResult, err := ac.storageService.TestProvider(c.Request.Context(), objID)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to test storage provider")
 		return
@@ -319,7 +311,7 @@ func (ac *AdminController) SyncStorageProvider(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(providerID)
-	err := ac.storageService.SyncProvider(objID)
+	err := ac.storageService.SyncProvider(c.Request.Context(), objID)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to sync storage provider")
 		return
@@ -328,9 +320,90 @@ func (ac *AdminController) SyncStorageProvider(c *gin.Context) {
 	utils.SuccessResponse(c, "Storage provider sync initiated", nil)
 }
 
+// Storage pricing management
+func (ac *AdminController) GetStoragePricing(c *gin.Context) {
+	pricing, err := ac.pricingService.GetStoragePricing(c.Request.Context(), c.Query("provider_type"))
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get storage pricing")
+		return
+	}
+
+	utils.SuccessResponse(c, "Storage pricing retrieved successfully", pricing)
+}
+
+func (ac *AdminController) GetStoragePricingByID(c *gin.Context) {
+	pricingID := c.Param("id")
+	if !utils.IsValidObjectID(pricingID) {
+		utils.BadRequestResponse(c, "Invalid pricing ID")
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(pricingID)
+	pricing, err := ac.pricingService.GetStoragePricingByID(c.Request.Context(), objID)
+	if err != nil {
+		utils.NotFoundResponse(c, "Storage pricing not found")
+		return
+	}
+
+	utils.SuccessResponse(c, "Storage pricing retrieved successfully", pricing)
+}
+
+func (ac *AdminController) CreateStoragePricing(c *gin.Context) {
+	pricing, ok := utils.BoundRequest[models.StoragePricing](c)
+	if !ok {
+		return
+	}
+
+	createdPricing, err := ac.pricingService.CreateStoragePricing(c.Request.Context(), pricing)
+	if err != nil {
+		utils.BadRequestResponse(c, err.Error())
+		return
+	}
+
+	utils.CreatedResponse(c, "Storage pricing created successfully", createdPricing)
+}
+
+func (ac *AdminController) UpdateStoragePricing(c *gin.Context) {
+	pricingID := c.Param("id")
+	if !utils.IsValidObjectID(pricingID) {
+		utils.BadRequestResponse(c, "Invalid pricing ID")
+		return
+	}
+
+	req, ok := utils.BoundRequest[models.StoragePricingUpdateRequest](c)
+	if !ok {
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(pricingID)
+	updatedPricing, err := ac.pricingService.UpdateStoragePricing(c.Request.Context(), objID, utils.UpdateFields(req))
+	if err != nil {
+		utils.BadRequestResponse(c, err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, "Storage pricing updated successfully", updatedPricing)
+}
+
+func (ac *AdminController) DeleteStoragePricing(c *gin.Context) {
+	pricingID := c.Param("id")
+	if !utils.IsValidObjectID(pricingID) {
+		utils.BadRequestResponse(c, "Invalid pricing ID")
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(pricingID)
+	if err := ac.pricingService.DeleteStoragePricing(c.Request.Context(), objID); err != nil {
+		utils.NotFoundResponse(c, "Storage pricing not found")
+		return
+	}
+
+	utils.SuccessResponse(c, "Storage pricing deleted successfully", nil)
+}
+
 // System maintenance
 func (ac *AdminController) GetSystemInfo(c *gin.Context) {
-	systemInfo, err := ac.adminService.GetSystemInfo()
+	systemInfo, err := ac.adminService.GetSystemInfo(c.Request.Context())
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get system info")
 		return
@@ -340,7 +413,7 @@ func (ac *AdminController) GetSystemInfo(c *gin.Context) {
 }
 
 func (ac *AdminController) GetSystemHealth(c *gin.Context) {
-	healthStatus, err := ac.adminService.GetSystemHealth()
+	healthStatus, err := ac.adminService.GetSystemHealth(c.Request.Context())
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get system health")
 		return
@@ -359,13 +432,22 @@ func (ac *AdminController) GetSystemHealth(c *gin.Context) {
 	})
 }
 
-func (ac *AdminController) ClearCache(c *gin.Context) {
-	var req struct {
-		CacheType string `json:"cache_type"` // redis, memory, all
+// GetStorageFailovers lists reads storage providers failed that were sent to
+// a replica, newest first, optionally of one provider type
+func (ac *AdminController) GetStorageFailovers(c *gin.Context) {
+	page, limit := utils.GetPagination(c, 20, 0)
+
+	failovers, total, err := ac.storageService.GetStorageFailovers(c.Request.Context(), c.Query("provider"), page, limit)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get storage failovers")
+		return
 	}
 
-	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request data")
+	utils.PaginatedResponse(c, "Storage failovers retrieved successfully", failovers, page, limit, total)
+}
+
+func (ac *AdminController) ClearCache(c *gin.Context) {
+	if _, ok := utils.BoundRequest[models.ClearCacheRequest](c); !ok {
 		return
 	}
 
@@ -379,17 +461,11 @@ func (ac *AdminController) ClearCache(c *gin.Context) {
 }
 
 func (ac *AdminController) ClearLogs(c *gin.Context) {
-	var req struct {
-		LogType   string `json:"log_type"`   // access, error, all
-		OlderThan int    `json:"older_than"` // days
-	}
-
-	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request data")
+	if _, ok := utils.BoundRequest[models.ClearLogsRequest](c); !ok {
 		return
 	}
 
-	err := ac.adminService.ClearLogs()
+	err := ac.adminService.ClearLogs(c.Request.Context())
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to clear logs")
 		return
@@ -403,7 +479,7 @@ func (ac *AdminController) GetLogs(c *gin.Context) {
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
 
-	logs, total, err := ac.adminService.GetLogs(page, limit, logType)
+	logs, total, err := ac.adminService.GetLogs(c.Request.Context(), page, limit, logType)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get logs")
 		return
@@ -413,17 +489,11 @@ func (ac *AdminController) GetLogs(c *gin.Context) {
 }
 
 func (ac *AdminController) CreateSystemBackup(c *gin.Context) {
-	var req struct {
-		BackupType string `json:"backup_type" validate:"required"` // database, files, full
-		Name       string `json:"name"`
-	}
-
-	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request data")
+	if _, ok := utils.BoundRequest[models.SystemBackupRequest](c); !ok {
 		return
 	}
 
-	backup, err := ac.adminService.CreateSystemBackup()
+	backup, err := ac.adminService.CreateSystemBackup(c.Request.Context())
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to create system backup")
 		return
@@ -449,12 +519,18 @@ func (ac *AdminController) GetSystemBackups(c *gin.Context) {
 type DashboardController struct {
 	adminService     *services.AdminService
 	analyticsService *services.AnalyticsService
+	rollupService    *services.RollupService
+	settingsService  *services.SettingsService
+	brandingService  *services.BrandingService
 }
 
-func NewDashboardController() *DashboardController {
+func NewDashboardController(deps services.Dependencies) *DashboardController {
 	return &DashboardController{
-		adminService:     services.NewAdminService(),
-		analyticsService: services.NewAnalyticsService(),
+		adminService:     services.NewAdminServiceWith(deps),
+		analyticsService: services.NewAnalyticsServiceWith(deps),
+		rollupService:    services.NewRollupServiceWith(deps),
+		settingsService:  services.NewSettingsServiceWith(deps),
+		brandingService:  services.NewBrandingServiceWith(deps),
 	}
 }
 
@@ -472,8 +548,14 @@ func (dc *DashboardController) Dashboard(c *gin.Context) {
 		return
 	}
 
-	// Get dashboard data
-	stats, err := dc.adminService.GetDashboardStats()
+	// Serve the rolled up dashboard, counting live only until rollups exist
+	stats, err := dc.rollupService.GetRollupDashboard(c.Request.Context())
+	if errors.Is(err, services.ErrNoDashboardRollup) {
+		stats, err = dc.adminService.GetDashboardStats(c.Request.Context())
+		if err == nil {
+			stats["freshness"] = models.RollupFreshness{Source: "live"}
+		}
+	}
 	if err != nil {
 		c.HTML(http.StatusInternalServerError, "error.html", gin.H{
 			"error": "Failed to load dashboard data",
@@ -482,9 +564,10 @@ func (dc *DashboardController) Dashboard(c *gin.Context) {
 	}
 
 	c.HTML(http.StatusOK, "dashboard/index.html", gin.H{
-		"title": "Dashboard",
-		"admin": admin,
-		"stats": stats,
+		"title":     "Dashboard",
+		"admin":     admin,
+		"stats":     stats,
+		"freshness": stats["freshness"],
 	})
 }
 
@@ -652,6 +735,143 @@ func (dc *DashboardController) PricingSettingsPage(c *gin.Context) {
 	})
 }
 
+func (dc *DashboardController) RuntimeSettingsPage(c *gin.Context) {
+	admin, exists := utils.GetAdminFromContext(c)
+	if !exists {
+		c.Redirect(http.StatusFound, "/admin/login")
+		return
+	}
+
+	dc.renderRuntimeSettings(c, http.StatusOK, admin, c.Query("updated"), "")
+}
+
+// UpdateRuntimeSettingPage handles the runtime settings form, which either
+// saves a value or resets a setting to its environment value
+func (dc *DashboardController) UpdateRuntimeSettingPage(c *gin.Context) {
+	admin, exists := utils.GetAdminFromContext(c)
+	if !exists {
+		c.Redirect(http.StatusFound, "/admin/login")
+		return
+	}
+
+	key := c.PostForm("key")
+	var err error
+	if c.PostForm("action") == "reset" {
+		err = dc.settingsService.ResetRuntimeSetting(c.Request.Context(), key)
+	} else {
+		var value interface{}
+		value, err = parseRuntimeSettingForm(c.PostForm("type"), c.PostForm("value"))
+		if err == nil {
+			err = dc.settingsService.UpdateRuntimeSetting(c.Request.Context(), key, value, admin.ID)
+		}
+	}
+	if err != nil {
+		dc.renderRuntimeSettings(c, http.StatusBadRequest, admin, "", err.Error())
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/admin/settings/runtime?updated="+url.QueryEscape(key))
+}
+
+func (dc *DashboardController) renderRuntimeSettings(c *gin.Context, status int, admin *models.Admin, updated, formError string) {
+	settings, err := dc.settingsService.GetRuntimeSettings(c.Request.Context())
+	if err != nil && formError == "" {
+		formError = "Failed to load runtime settings"
+	}
+
+	c.HTML(status, "settings/runtime.html", gin.H{
+		"title":    "Runtime Settings",
+		"admin":    admin,
+		"settings": settings,
+		"updated":  updated,
+		"error":    formError,
+	})
+}
+
+// parseRuntimeSettingForm converts a submitted form value to the setting's
+// type. Unchecked checkboxes are not submitted, so an empty bool is false.
+func parseRuntimeSettingForm(settingType, value string) (interface{}, error) {
+	value = strings.TrimSpace(value)
+	switch settingType {
+	case "int":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q is not a whole number", services.ErrInvalidRuntimeSetting, value)
+		}
+		return n, nil
+	case "bool":
+		return value == "true" || value == "on", nil
+	}
+	return value, nil
+}
+
+func (dc *DashboardController) BrandingSettingsPage(c *gin.Context) {
+	admin, exists := utils.GetAdminFromContext(c)
+	if !exists {
+		c.Redirect(http.StatusFound, "/admin/login")
+		return
+	}
+
+	branding, err := dc.brandingService.GetBranding(c.Request.Context())
+	formError := ""
+	if err != nil {
+		branding, formError = &models.Branding{}, "Failed to load branding"
+	}
+	dc.renderBranding(c, http.StatusOK, admin, branding, c.Query("updated") != "", formError)
+}
+
+// UpdateBrandingPage handles the branding form, which either saves the
+// branding or resets it to the defaults
+func (dc *DashboardController) UpdateBrandingPage(c *gin.Context) {
+	admin, exists := utils.GetAdminFromContext(c)
+	if !exists {
+		c.Redirect(http.StatusFound, "/admin/login")
+		return
+	}
+
+	if c.PostForm("action") == "reset" {
+		if err := dc.brandingService.ResetBranding(c.Request.Context()); err != nil {
+			dc.renderBranding(c, http.StatusInternalServerError, admin, &models.Branding{}, false, err.Error())
+			return
+		}
+		c.Redirect(http.StatusFound, "/admin/settings/branding?updated=1")
+		return
+	}
+
+	req := &models.BrandingRequest{
+		ProductName:   c.PostForm("product_name"),
+		LogoURL:       c.PostForm("logo_url"),
+		PrimaryColor:  c.PostForm("primary_color"),
+		AccentColor:   c.PostForm("accent_color"),
+		EmailFooter:   c.PostForm("email_footer"),
+		SharePageHTML: c.PostForm("share_page_html"),
+	}
+	if _, err := dc.brandingService.UpdateBranding(c.Request.Context(), req, admin.ID); err != nil {
+		// Keep what was entered so it can be corrected
+		dc.renderBranding(c, http.StatusBadRequest, admin, &models.Branding{
+			ProductName:   req.ProductName,
+			LogoURL:       req.LogoURL,
+			PrimaryColor:  req.PrimaryColor,
+			AccentColor:   req.AccentColor,
+			EmailFooter:   req.EmailFooter,
+			SharePageHTML: req.SharePageHTML,
+		}, false, err.Error())
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/admin/settings/branding?updated=1")
+}
+
+func (dc *DashboardController) renderBranding(c *gin.Context, status int, admin *models.Admin, branding *models.Branding, updated bool, formError string) {
+	c.HTML(status, "settings/branding.html", gin.H{
+		"title":   "Branding",
+		"admin":   admin,
+		"form":    branding,
+		"updated": updated,
+		"error":   formError,
+	})
+}
+
 func (dc *DashboardController) AnalyticsPage(c *gin.Context) {
 	admin, exists := utils.GetAdminFromContext(c)
 	if !exists {
@@ -677,3 +897,127 @@ func (dc *DashboardController) ReportsPage(c *gin.Context) {
 		"admin": admin,
 	})
 }
+
+// Security
+
+// GetGlobalAccessPolicy returns the deployment-wide IP access policy
+func (ac *AdminController) GetGlobalAccessPolicy(c *gin.Context) {
+	policy, err := ac.accessService.GetGlobalPolicy(c.Request.Context())
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get access policy")
+		return
+	}
+
+	utils.SuccessResponse(c, "Access policy retrieved successfully", policy)
+}
+
+// UpdateGlobalAccessPolicy replaces the deployment-wide IP access policy
+func (ac *AdminController) UpdateGlobalAccessPolicy(c *gin.Context) {
+	admin, exists := utils.GetAdminFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "Admin not found in context")
+		return
+	}
+
+	var req models.IPAccessPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data")
+		return
+	}
+
+	policy, err := ac.accessService.SaveGlobalPolicy(c.Request.Context(), &req, admin.ID)
+	if err != nil {
+		utils.BadRequestResponse(c, err.Error())
+		return
+	}
+
+	ac.auditService.Record(c.Request.Context(), &models.AuditLog{
+		ActorType:    "admin",
+		ActorID:      &admin.ID,
+		Action:       "access_policy.updated",
+		ResourceType: "ip_access_policy",
+		ResourceID:   policy.ID.Hex(),
+		IPAddress:    c.ClientIP(),
+		UserAgent:    c.Request.UserAgent(),
+		Details:      map[string]interface{}{"scope": policy.Scope, "is_enabled": policy.IsEnabled},
+	})
+
+	utils.SuccessResponse(c, "Access policy updated successfully", policy)
+}
+
+// GetSecurityPolicy returns the password and account lockout policy
+func (ac *AdminController) GetSecurityPolicy(c *gin.Context) {
+	utils.SuccessResponse(c, "Security policy retrieved successfully", ac.policyService.GetPolicy(c.Request.Context()))
+}
+
+// UpdateSecurityPolicy replaces the password and account lockout policy
+func (ac *AdminController) UpdateSecurityPolicy(c *gin.Context) {
+	admin, exists := utils.GetAdminFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "Admin not found in context")
+		return
+	}
+
+	var req models.SecurityPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data")
+		return
+	}
+
+	policy, err := ac.policyService.SavePolicy(c.Request.Context(), &req, admin.ID)
+	if err != nil {
+		utils.BadRequestResponse(c, err.Error())
+		return
+	}
+
+	ac.auditService.Record(c.Request.Context(), &models.AuditLog{
+		ActorType:    "admin",
+		ActorID:      &admin.ID,
+		Action:       "security_policy.updated",
+		ResourceType: "security_policy",
+		IPAddress:    c.ClientIP(),
+		UserAgent:    c.Request.UserAgent(),
+		Details:      map[string]interface{}{"password": policy.Password, "lockout": policy.Lockout},
+	})
+
+	utils.SuccessResponse(c, "Security policy updated successfully", policy)
+}
+
+// GetAuditLogs returns the security audit log
+func (ac *AdminController) GetAuditLogs(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 200 {
+		limit = 50
+	}
+
+	filter := services.AuditLogFilter{
+		Action:  c.Query("action"),
+		Outcome: c.Query("outcome"),
+	}
+	if actorID := c.Query("actor_id"); actorID != "" {
+		if !utils.IsValidObjectID(actorID) {
+			utils.BadRequestResponse(c, "Invalid actor ID")
+			return
+		}
+		objID, _ := utils.StringToObjectID(actorID)
+		filter.ActorID = &objID
+	}
+	if from, err := time.Parse(time.RFC3339, c.Query("from")); err == nil {
+		filter.From = &from
+	}
+	if to, err := time.Parse(time.RFC3339, c.Query("to")); err == nil {
+		filter.To = &to
+	}
+
+	logs, total, err := ac.auditService.GetAuditLogs(c.Request.Context(), filter, page, limit)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get audit logs")
+		return
+	}
+
+	utils.PaginatedResponse(c, "Audit logs retrieved successfully", logs, page, limit, total)
+}
@@ -10,20 +10,21 @@ import (
 
 type AnalyticsController struct {
 	analyticsService *services.AnalyticsService
-	adminService     *services.AdminService
+	rollupService    *services.RollupService
+	anomalyService   *services.AnomalyService
 }
 
 func NewAnalyticsController() *AnalyticsController {
 	return &AnalyticsController{
 		analyticsService: services.NewAnalyticsService(),
+		rollupService:    services.NewRollupService(),
+		anomalyService:   services.NewAnomalyService(),
 	}
 }
 
-// GetDashboard returns dashboard analytics data
+// GetDashboard returns dashboard analytics data served from precomputed rollups
 func (ac *AnalyticsController) GetDashboard(c *gin.Context) {
-	c.DefaultQuery("period", "30") // days
-
-	dashboard, err := ac.adminService.GetDashboardStats()
+	dashboard, err := ac.rollupService.GetDashboard(c.Request.Context())
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get dashboard analytics")
 		return
@@ -32,12 +33,45 @@ func (ac *AnalyticsController) GetDashboard(c *gin.Context) {
 	utils.SuccessResponse(c, "Dashboard analytics retrieved successfully", dashboard)
 }
 
+// RefreshDashboard recomputes the dashboard rollups on demand
+func (ac *AnalyticsController) RefreshDashboard(c *gin.Context) {
+	if err := ac.rollupService.RunScheduledRollups(c.Request.Context()); err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to refresh dashboard analytics")
+		return
+	}
+
+	dashboard, err := ac.rollupService.GetDashboard(c.Request.Context())
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get dashboard analytics")
+		return
+	}
+
+	utils.SuccessResponse(c, "Dashboard analytics refreshed successfully", dashboard)
+}
+
+// BackfillRollups computes daily rollups for past days
+func (ac *AnalyticsController) BackfillRollups(c *gin.Context) {
+	days, _ := strconv.Atoi(c.DefaultQuery("days", "60"))
+	if days < 1 || days > 366 {
+		utils.BadRequestResponse(c, "days must be between 1 and 366")
+		return
+	}
+
+	computed, err := ac.rollupService.BackfillDailyRollups(c.Request.Context(), days)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to backfill rollups")
+		return
+	}
+
+	utils.SuccessResponse(c, "Rollups backfilled successfully", gin.H{"computed": computed})
+}
+
 // GetUserAnalytics returns user-related analytics
 func (ac *AnalyticsController) GetUserAnalytics(c *gin.Context) {
 	period := c.DefaultQuery("period", "30")     // days
 	groupBy := c.DefaultQuery("group_by", "day") // day, week, month
 
-	analytics, err := ac.analyticsService.GetUserAnalytics(period, groupBy)
+	analytics, err := ac.analyticsService.GetUserAnalytics(c.Request.Context(), period, groupBy)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get user analytics")
 		return
@@ -46,12 +80,27 @@ func (ac *AnalyticsController) GetUserAnalytics(c *gin.Context) {
 	utils.SuccessResponse(c, "User analytics retrieved successfully", analytics)
 }
 
+// GetCohortRetention returns the signup cohort retention matrix
+func (ac *AnalyticsController) GetCohortRetention(c *gin.Context) {
+	granularity := c.DefaultQuery("granularity", "week") // week, month
+	periods, _ := strconv.Atoi(c.DefaultQuery("periods", "12"))
+	refresh := c.Query("refresh") == "true"
+
+	cohorts, err := ac.analyticsService.GetCohortRetention(c.Request.Context(), granularity, periods, refresh)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get cohort retention")
+		return
+	}
+
+	utils.SuccessResponse(c, "Cohort retention retrieved successfully", cohorts)
+}
+
 // GetFileAnalytics returns file-related analytics
 func (ac *AnalyticsController) GetFileAnalytics(c *gin.Context) {
 	period := c.DefaultQuery("period", "30") // days
 	groupBy := c.DefaultQuery("group_by", "day")
 
-	analytics, err := ac.analyticsService.GetFileAnalytics(period, groupBy)
+	analytics, err := ac.analyticsService.GetFileAnalytics(c.Request.Context(), period, groupBy)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get file analytics")
 		return
@@ -66,7 +115,7 @@ func (ac *AnalyticsController) GetStorageAnalytics(c *gin.Context) {
 	groupBy := c.DefaultQuery("group_by", "day") // day, week, month
 	providerID := c.Query("provider_id")
 
-	analytics, err := ac.analyticsService.GetStorageAnalytics(period, groupBy, providerID)
+	analytics, err := ac.analyticsService.GetStorageAnalytics(c.Request.Context(), period, groupBy, providerID)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get storage analytics")
 		return
@@ -81,7 +130,7 @@ func (ac *AnalyticsController) GetRevenueAnalytics(c *gin.Context) {
 	groupBy := c.DefaultQuery("group_by", "day") // day, week, month
 	currency := c.DefaultQuery("currency", "USD")
 
-	analytics, err := ac.analyticsService.GetRevenueAnalytics(period, groupBy, currency)
+	analytics, err := ac.analyticsService.GetRevenueAnalytics(c.Request.Context(), period, groupBy, currency)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get revenue analytics")
 		return
@@ -92,7 +141,7 @@ func (ac *AnalyticsController) GetRevenueAnalytics(c *gin.Context) {
 
 // GetRealTimeStats returns real-time statistics
 func (ac *AnalyticsController) GetRealTimeStats(c *gin.Context) {
-	stats, err := ac.analyticsService.GetRealTimeStats()
+	stats, err := ac.analyticsService.GetRealTimeStats(c.Request.Context())
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get real-time stats")
 		return
@@ -105,8 +154,11 @@ func (ac *AnalyticsController) GetRealTimeStats(c *gin.Context) {
 func (ac *AnalyticsController) GetTopFiles(c *gin.Context) {
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
 	sortBy := c.DefaultQuery("sort_by", "downloads") // days
+	if limit < 1 || limit > 100 {
+		limit = 10
+	}
 
-	topFiles, err := ac.analyticsService.GetTopFiles(sortBy, limit)
+	topFiles, err := ac.analyticsService.GetTopFiles(c.Request.Context(), sortBy, limit)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get top files")
 		return
@@ -120,8 +172,11 @@ func (ac *AnalyticsController) GetTopUsers(c *gin.Context) {
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
 	sortBy := c.DefaultQuery("sort_by", "storage_used") // storage_used, files_count, downloads
 	period := c.DefaultQuery("period", "30")            // days
+	if limit < 1 || limit > 100 {
+		limit = 10
+	}
 
-	topUsers, err := ac.analyticsService.GetTopUsers(limit, sortBy, period)
+	topUsers, err := ac.analyticsService.GetTopUsers(c.Request.Context(), limit, sortBy, period)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get top users")
 		return
@@ -134,7 +189,7 @@ func (ac *AnalyticsController) GetTopUsers(c *gin.Context) {
 func (ac *AnalyticsController) GetSystemMetrics(c *gin.Context) {
 	period := c.DefaultQuery("period", "24") // hours
 
-	metrics, err := ac.analyticsService.GetSystemMetrics(period)
+	metrics, err := ac.analyticsService.GetSystemMetrics(c.Request.Context(), period)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get system metrics")
 		return
@@ -163,7 +218,7 @@ func (ac *AnalyticsController) ExportAnalytics(c *gin.Context) {
 		return
 	}
 
-	exportResult, err := ac.analyticsService.ExportAnalytics(req.Type, req.Period, req.Format, req.Email, req.GroupBy)
+	exportResult, err := ac.analyticsService.ExportAnalytics(c.Request.Context(), req.Type, req.Period, req.Format, req.Email, req.GroupBy)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to export analytics")
 		return
@@ -210,3 +265,100 @@ func (ac *AnalyticsController) ExportAnalytics(c *gin.Context) {
 
 // 	c.Status(200)
 // }
+
+// GetWarehouseExportStatus returns the analytics warehouse export checkpoints
+func (ac *AnalyticsController) GetWarehouseExportStatus(c *gin.Context) {
+	exporter := services.GetWarehouseExportService()
+	if exporter == nil {
+		utils.BadRequestResponse(c, "Warehouse export is not enabled")
+		return
+	}
+
+	status, err := exporter.GetExportStatus(c.Request.Context())
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get warehouse export status")
+		return
+	}
+
+	utils.SuccessResponse(c, "Warehouse export status retrieved successfully", status)
+}
+
+// RunWarehouseExport triggers an immediate warehouse export
+func (ac *AnalyticsController) RunWarehouseExport(c *gin.Context) {
+	exporter := services.GetWarehouseExportService()
+	if exporter == nil {
+		utils.BadRequestResponse(c, "Warehouse export is not enabled")
+		return
+	}
+
+	exported, err := exporter.RunExport(c.Request.Context())
+	if err != nil {
+		utils.InternalServerErrorResponse(c, err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, "Warehouse export completed successfully", gin.H{"exported": exported})
+}
+
+// GetAnomalies returns detected usage and error anomalies
+func (ac *AnalyticsController) GetAnomalies(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	status := c.Query("status") // active, acknowledged, resolved
+	anomalyType := c.Query("type")
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	anomalies, total, err := ac.anomalyService.GetAnomalies(c.Request.Context(), status, anomalyType, page, limit)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get anomalies")
+		return
+	}
+
+	utils.PaginatedResponse(c, "Anomalies retrieved successfully", anomalies, page, limit, total)
+}
+
+// AcknowledgeAnomaly marks an anomaly as acknowledged by the current admin
+func (ac *AnalyticsController) AcknowledgeAnomaly(c *gin.Context) {
+	admin, exists := utils.GetAdminFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "Admin not found in context")
+		return
+	}
+
+	anomalyID := c.Param("id")
+	if !utils.IsValidObjectID(anomalyID) {
+		utils.BadRequestResponse(c, "Invalid anomaly ID")
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(anomalyID)
+	if err := ac.anomalyService.AcknowledgeAnomaly(c.Request.Context(), objID, admin.ID); err != nil {
+		utils.NotFoundResponse(c, err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, "Anomaly acknowledged successfully", nil)
+}
+
+// ResolveAnomaly closes an anomaly
+func (ac *AnalyticsController) ResolveAnomaly(c *gin.Context) {
+	anomalyID := c.Param("id")
+	if !utils.IsValidObjectID(anomalyID) {
+		utils.BadRequestResponse(c, "Invalid anomaly ID")
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(anomalyID)
+	if err := ac.anomalyService.ResolveAnomaly(c.Request.Context(), objID); err != nil {
+		utils.NotFoundResponse(c, err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, "Anomaly resolved successfully", nil)
+}
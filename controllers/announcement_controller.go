@@ -0,0 +1,184 @@
+package controllers
+
+import (
+	"errors"
+	"oncloud/models"
+	"oncloud/services"
+	"oncloud/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AnnouncementController struct {
+	announcementService *services.AnnouncementService
+	auditService        *services.AuditService
+}
+
+func NewAnnouncementController() *AnnouncementController {
+	return &AnnouncementController{
+		announcementService: services.NewAnnouncementService(),
+		auditService:        services.NewAuditService(),
+	}
+}
+
+// GetActiveAnnouncements returns the banners to show, polled by clients.
+// Signed-out visitors get the announcements for everyone.
+func (ac *AnnouncementController) GetActiveAnnouncements(c *gin.Context) {
+	user, _ := utils.GetUserFromContext(c)
+
+	announcements, err := ac.announcementService.ActiveAnnouncements(c.Request.Context(), user)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get announcements")
+		return
+	}
+
+	utils.SuccessResponse(c, "Announcements retrieved successfully", announcements)
+}
+
+// DismissAnnouncement stops showing an announcement to the user
+func (ac *AnnouncementController) DismissAnnouncement(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found")
+		return
+	}
+
+	announcementID := c.Param("id")
+	if !utils.IsValidObjectID(announcementID) {
+		utils.BadRequestResponse(c, "Invalid announcement ID")
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(announcementID)
+	if err := ac.announcementService.DismissAnnouncement(c.Request.Context(), objID, user.ID); err != nil {
+		respondAnnouncementError(c, err, "Failed to dismiss announcement")
+		return
+	}
+
+	utils.SuccessResponse(c, "Announcement dismissed successfully", nil)
+}
+
+// GetAnnouncements lists announcements for admins
+func (ac *AnnouncementController) GetAnnouncements(c *gin.Context) {
+	announcements, err := ac.announcementService.ListAnnouncements(c.Request.Context(), c.Query("include_past") == "true")
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get announcements")
+		return
+	}
+
+	utils.SuccessResponse(c, "Announcements retrieved successfully", announcements)
+}
+
+// CreateAnnouncement schedules an announcement
+func (ac *AnnouncementController) CreateAnnouncement(c *gin.Context) {
+	admin, exists := utils.GetAdminFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "Admin not found")
+		return
+	}
+
+	req, ok := utils.BoundRequest[models.AnnouncementRequest](c)
+	if !ok {
+		return
+	}
+
+	announcement, err := ac.announcementService.CreateAnnouncement(c.Request.Context(), req, admin.ID)
+	if err != nil {
+		respondAnnouncementError(c, err, "Failed to create announcement")
+		return
+	}
+
+	ac.audit(c, admin, "announcement.created", announcement)
+
+	utils.CreatedResponse(c, "Announcement created successfully", announcement)
+}
+
+// UpdateAnnouncement replaces an announcement
+func (ac *AnnouncementController) UpdateAnnouncement(c *gin.Context) {
+	admin, exists := utils.GetAdminFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "Admin not found")
+		return
+	}
+
+	announcementID := c.Param("id")
+	if !utils.IsValidObjectID(announcementID) {
+		utils.BadRequestResponse(c, "Invalid announcement ID")
+		return
+	}
+
+	req, ok := utils.BoundRequest[models.AnnouncementRequest](c)
+	if !ok {
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(announcementID)
+	announcement, err := ac.announcementService.UpdateAnnouncement(c.Request.Context(), objID, req, admin.ID)
+	if err != nil {
+		respondAnnouncementError(c, err, "Failed to update announcement")
+		return
+	}
+
+	ac.audit(c, admin, "announcement.updated", announcement)
+
+	utils.SuccessResponse(c, "Announcement updated successfully", announcement)
+}
+
+// DeleteAnnouncement takes an announcement down
+func (ac *AnnouncementController) DeleteAnnouncement(c *gin.Context) {
+	admin, exists := utils.GetAdminFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "Admin not found")
+		return
+	}
+
+	announcementID := c.Param("id")
+	if !utils.IsValidObjectID(announcementID) {
+		utils.BadRequestResponse(c, "Invalid announcement ID")
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(announcementID)
+	if err := ac.announcementService.DeleteAnnouncement(c.Request.Context(), objID); err != nil {
+		respondAnnouncementError(c, err, "Failed to delete announcement")
+		return
+	}
+
+	ac.audit(c, admin, "announcement.deleted", &models.Announcement{ID: objID})
+
+	utils.SuccessResponse(c, "Announcement deleted successfully", nil)
+}
+
+func (ac *AnnouncementController) audit(c *gin.Context, admin *models.Admin, action string, announcement *models.Announcement) {
+	var details map[string]interface{}
+	if announcement.Title != "" {
+		details = map[string]interface{}{
+			"title":      announcement.Title,
+			"audience":   announcement.Audience,
+			"starts_at":  announcement.StartsAt,
+			"ends_at":    announcement.EndsAt,
+			"send_email": announcement.SendEmail,
+		}
+	}
+
+	ac.auditService.Record(c.Request.Context(), &models.AuditLog{
+		ActorType:    "admin",
+		ActorID:      &admin.ID,
+		Action:       action,
+		ResourceType: "announcement",
+		ResourceID:   announcement.ID.Hex(),
+		Outcome:      "success",
+		IPAddress:    c.ClientIP(),
+		UserAgent:    c.Request.UserAgent(),
+		Details:      details,
+	})
+}
+
+func respondAnnouncementError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrInvalidAnnouncement):
+		utils.BadRequestResponse(c, err.Error())
+	default:
+		utils.ServiceErrorResponse(c, err, message)
+	}
+}
@@ -1,10 +1,12 @@
 package controllers
 
 import (
+	"errors"
+	"net/http"
 	"oncloud/models"
 	"oncloud/services"
 	"oncloud/utils"
-	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -12,15 +14,58 @@ import (
 )
 
 type AuthController struct {
-	authService *services.AuthService
-	userService *services.UserService
+	authService    *services.AuthService
+	userService    *services.UserService
+	accessService  *services.AccessPolicyService
+	auditService   *services.AuditService
+	sessionService *services.SessionService
+	loginSecurity  *services.LoginSecurityService
+	policyService  *services.SecurityPolicyService
+	resetService   *services.PasswordResetService
 }
 
 func NewAuthController() *AuthController {
 	return &AuthController{
-		authService: services.NewAuthService(),
-		userService: services.NewUserService(),
+		authService:    services.NewAuthService(),
+		userService:    services.NewUserService(),
+		accessService:  services.NewAccessPolicyService(),
+		auditService:   services.NewAuditService(),
+		sessionService: services.NewSessionService(),
+		loginSecurity:  services.NewLoginSecurityService(),
+		policyService:  services.NewSecurityPolicyService(),
+		resetService:   services.NewPasswordResetService(),
+	}
+}
+
+// GetPasswordPolicy returns the rules new passwords must meet so clients can
+// show them up front
+func (ac *AuthController) GetPasswordPolicy(c *gin.Context) {
+	policy := ac.policyService.GetPolicy(c.Request.Context())
+	utils.SuccessResponse(c, "Password policy retrieved successfully", policy.Password)
+}
+
+// respondPasswordPolicyError writes a 422 listing the broken password rules and
+// reports whether err was a password policy violation
+func respondPasswordPolicyError(c *gin.Context, err error) bool {
+	var policyErr *services.PasswordPolicyError
+	if !errors.As(err, &policyErr) {
+		return false
 	}
+
+	utils.ErrorResponse(c, http.StatusUnprocessableEntity, "Password does not meet the password policy", map[string]interface{}{
+		"violations": policyErr.Violations,
+	})
+	return true
+}
+
+// deviceIDHeader carries an ID clients keep for the device they run on, which
+// sessions are bound to rather than to the device's user agent
+const deviceIDHeader = "X-Device-ID"
+
+// startSession records the signed-in device and issues tokens bound to it
+func (ac *AuthController) startSession(c *gin.Context, user *models.User) (*utils.TokenPair, error) {
+	_, tokens, err := ac.sessionService.StartSession(c.Request.Context(), user, c.ClientIP(), c.Request.UserAgent(), c.GetHeader(deviceIDHeader))
+	return tokens, err
 }
 
 // Register handles user registration
@@ -38,20 +83,23 @@ func (ac *AuthController) Register(c *gin.Context) {
 	}
 
 	// Check if registration is allowed
-	if !ac.authService.IsRegistrationAllowed() {
+	if !ac.authService.IsRegistrationAllowed(c.Request.Context()) {
 		utils.ForbiddenResponse(c, "Registration is currently disabled")
 		return
 	}
 
 	// Create user
-	user, err := ac.authService.Register(&req)
+	user, err := ac.authService.Register(c.Request.Context(), &req)
 	if err != nil {
+		if respondPasswordPolicyError(c, err) {
+			return
+		}
 		utils.ErrorResponse(c, http.StatusConflict, err.Error(), nil)
 		return
 	}
 
 	// Generate tokens
-	tokens, err := utils.GenerateTokenPair(user.ID, user.Email, user.Username, "user", user.PlanID)
+	tokens, err := ac.startSession(c, user)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to generate tokens")
 		return
@@ -78,8 +126,17 @@ func (ac *AuthController) Login(c *gin.Context) {
 	}
 
 	// Authenticate user
-	user, err := ac.authService.Login(req.Email, req.Password)
+	user, err := ac.authService.Login(c.Request.Context(), req.Email, req.Password, c.ClientIP())
 	if err != nil {
+		var locked *services.AccountLockedError
+		if errors.As(err, &locked) {
+			retryAfter := int(time.Until(locked.Until).Seconds()) + 1
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			utils.ErrorResponse(c, http.StatusTooManyRequests, "Too many failed login attempts, account is temporarily locked", map[string]interface{}{
+				"locked_until": locked.Until,
+			})
+			return
+		}
 		utils.UnauthorizedResponse(c, "Invalid credentials")
 		return
 	}
@@ -90,11 +147,117 @@ func (ac *AuthController) Login(c *gin.Context) {
 		return
 	}
 
+	// Refuse logins from networks the user has blocked
+	if decision := ac.accessService.CheckUserAccess(c.Request.Context(), user.ID, c.ClientIP(), false); !decision.Allowed {
+		ac.auditService.Record(c.Request.Context(), &models.AuditLog{
+			ActorType:    "user",
+			ActorID:      &user.ID,
+			Action:       "auth.login",
+			ResourceType: "user",
+			ResourceID:   user.ID.Hex(),
+			Outcome:      "denied",
+			IPAddress:    c.ClientIP(),
+			UserAgent:    c.Request.UserAgent(),
+			Details:      map[string]interface{}{"reason": decision.Reason},
+		})
+		utils.ErrorResponse(c, http.StatusForbidden, decision.Message, map[string]interface{}{
+			"scope":  decision.Scope,
+			"reason": decision.Reason,
+		})
+		return
+	}
+
+	// Hold logins from an unfamiliar device or location until the user
+	// confirms them with a second factor
+	assessment := ac.loginSecurity.AssessLogin(c.Request.Context(), user, c.ClientIP(), c.Request.UserAgent())
+	if assessment.Suspicious {
+		if ac.loginSecurity.VerificationRequired(c.Request.Context(), user.ID) {
+			challenge, err := ac.loginSecurity.StartChallenge(c.Request.Context(), user, assessment, c.ClientIP())
+			if err != nil {
+				utils.InternalServerErrorResponse(c, "Failed to start login verification")
+				return
+			}
+
+			ac.auditService.Record(c.Request.Context(), &models.AuditLog{
+				ActorType:    "user",
+				ActorID:      &user.ID,
+				Action:       "auth.login_challenged",
+				ResourceType: "user",
+				ResourceID:   user.ID.Hex(),
+				Outcome:      "pending",
+				IPAddress:    c.ClientIP(),
+				UserAgent:    c.Request.UserAgent(),
+				Details:      map[string]interface{}{"reasons": assessment.Reasons, "method": challenge.Method},
+			})
+
+			utils.SuccessResponse(c, "Verification required to complete login", gin.H{
+				"verification_required": true,
+				"challenge":             challenge,
+			})
+			return
+		}
+
+		ac.loginSecurity.NotifyLogin(c.Request.Context(), user, assessment)
+	}
+
+	ac.completeLogin(c, user)
+}
+
+// VerifyLogin completes a login that was held for step-up verification
+func (ac *AuthController) VerifyLogin(c *gin.Context) {
+	var req models.LoginVerificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data")
+		return
+	}
+
+	if err := utils.ValidateStruct(&req); err != nil {
+		utils.ValidationErrorResponse(c, err)
+		return
+	}
+
+	challenge, err := ac.loginSecurity.VerifyChallenge(c.Request.Context(), req.ChallengeID, req.Code)
+	if err != nil {
+		utils.UnauthorizedResponse(c, err.Error())
+		return
+	}
+
+	user, err := ac.userService.GetByID(c.Request.Context(), challenge.UserID)
+	if err != nil || !user.IsActive {
+		utils.UnauthorizedResponse(c, "User not found or inactive")
+		return
+	}
+
+	if decision := ac.accessService.CheckUserAccess(c.Request.Context(), user.ID, c.ClientIP(), false); !decision.Allowed {
+		utils.ErrorResponse(c, http.StatusForbidden, decision.Message, map[string]interface{}{
+			"scope":  decision.Scope,
+			"reason": decision.Reason,
+		})
+		return
+	}
+
+	ac.auditService.Record(c.Request.Context(), &models.AuditLog{
+		ActorType:    "user",
+		ActorID:      &user.ID,
+		Action:       "auth.login_verified",
+		ResourceType: "user",
+		ResourceID:   user.ID.Hex(),
+		IPAddress:    c.ClientIP(),
+		UserAgent:    c.Request.UserAgent(),
+		Details:      map[string]interface{}{"method": challenge.Method},
+	})
+	ac.loginSecurity.NotifyLogin(c.Request.Context(), user, services.AssessmentFromChallenge(challenge))
+
+	ac.completeLogin(c, user)
+}
+
+// completeLogin starts a session for an authenticated user and returns its tokens
+func (ac *AuthController) completeLogin(c *gin.Context, user *models.User) {
 	// Update last login
-	ac.userService.UpdateLastLogin(user.ID)
+	ac.userService.UpdateLastLogin(c.Request.Context(), user.ID)
 
 	// Generate tokens
-	tokens, err := utils.GenerateTokenPair(user.ID, user.Email, user.Username, "user", user.PlanID)
+	tokens, err := ac.startSession(c, user)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to generate tokens")
 		return
@@ -106,14 +269,25 @@ func (ac *AuthController) Login(c *gin.Context) {
 	})
 }
 
-// Logout handles user logout
+// Logout ends the current session so its tokens stop working
 func (ac *AuthController) Logout(c *gin.Context) {
-	// In a stateless JWT system, logout is handled client-side
-	// Here we could implement token blacklisting if needed
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	if err := ac.sessionService.EndSession(c.Request.Context(), c.GetString("session_id"), user.ID, "logout"); err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to end session")
+		return
+	}
+
 	utils.SuccessResponse(c, "Logout successful", nil)
 }
 
-// RefreshToken handles token refresh
+// RefreshToken exchanges a refresh token for new tokens. It doesn't need a
+// valid access token, as access tokens are short-lived. Each refresh token
+// can only be used once: using one again ends its session.
 func (ac *AuthController) RefreshToken(c *gin.Context) {
 	var req struct {
 		RefreshToken string `json:"refresh_token" validate:"required"`
@@ -132,23 +306,87 @@ func (ac *AuthController) RefreshToken(c *gin.Context) {
 	}
 
 	// Get user
-	user, err := ac.userService.GetByID(claims.UserID)
+	user, err := ac.userService.GetByID(c.Request.Context(), claims.UserID)
 	if err != nil || !user.IsActive {
 		utils.UnauthorizedResponse(c, "User not found or inactive")
 		return
 	}
 
-	// Generate new tokens
-	tokens, err := utils.GenerateTokenPair(user.ID, user.Email, user.Username, "user", user.PlanID)
+	// The refresh token must be the latest of a session that is still active
+	fingerprint := services.DeviceFingerprint(c.GetHeader(deviceIDHeader), c.Request.UserAgent())
+	tokens, err := ac.sessionService.RefreshSession(c.Request.Context(), claims, user, fingerprint)
 	if err != nil {
-		utils.InternalServerErrorResponse(c, "Failed to generate tokens")
+		switch {
+		case errors.Is(err, services.ErrRefreshTokenReused), errors.Is(err, services.ErrSessionDeviceMismatch):
+			ac.auditService.Record(c.Request.Context(), &models.AuditLog{
+				ActorType:    "user",
+				ActorID:      &user.ID,
+				Action:       "auth.refresh_rejected",
+				ResourceType: "user",
+				ResourceID:   user.ID.Hex(),
+				Outcome:      "denied",
+				IPAddress:    c.ClientIP(),
+				UserAgent:    c.Request.UserAgent(),
+				Details:      map[string]interface{}{"reason": err.Error()},
+			})
+			utils.UnauthorizedResponse(c, "Refresh token rejected, the session has been ended, please sign in again")
+		case errors.Is(err, services.ErrSessionEnded):
+			utils.UnauthorizedResponse(c, "Session has ended, please sign in again")
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to generate tokens")
+		}
 		return
 	}
 
 	utils.SuccessResponse(c, "Token refreshed successfully", tokens)
 }
 
-// ForgotPassword handles password reset request
+// RevokeToken revokes one of the user's tokens before it expires. Access
+// tokens are added to the revocation list; refresh tokens end their session,
+// as its access tokens were all issued with them.
+func (ac *AuthController) RevokeToken(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	var req struct {
+		Token string `json:"token" validate:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Token == "" {
+		utils.BadRequestResponse(c, "Invalid request data")
+		return
+	}
+
+	if claims, err := utils.ValidateToken(req.Token); err == nil {
+		if claims.UserID != user.ID {
+			utils.ForbiddenResponse(c, "Token belongs to another user")
+			return
+		}
+		if err := ac.sessionService.RevokeToken(c.Request.Context(), claims, "revoked"); err != nil {
+			utils.InternalServerErrorResponse(c, "Failed to revoke token")
+			return
+		}
+	} else if claims, err := utils.ValidateRefreshToken(req.Token); err == nil {
+		if claims.UserID != user.ID {
+			utils.ForbiddenResponse(c, "Token belongs to another user")
+			return
+		}
+		if err := ac.sessionService.EndSession(c.Request.Context(), claims.SessionID, user.ID, "revoked"); err != nil {
+			utils.InternalServerErrorResponse(c, "Failed to revoke token")
+			return
+		}
+	} else {
+		utils.BadRequestResponse(c, "Invalid or expired token")
+		return
+	}
+
+	utils.SuccessResponse(c, "Token revoked successfully", nil)
+}
+
+// ForgotPassword emails a password reset link. The answer is the same
+// whether or not the email has an account.
 func (ac *AuthController) ForgotPassword(c *gin.Context) {
 	var req struct {
 		Email string `json:"email" validate:"required,email"`
@@ -159,17 +397,35 @@ func (ac *AuthController) ForgotPassword(c *gin.Context) {
 		return
 	}
 
-	err := ac.authService.SendPasswordResetEmail(req.Email)
+	err := ac.resetService.RequestReset(c.Request.Context(), req.Email, c.ClientIP(), c.Request.UserAgent())
+	if errors.Is(err, services.ErrPasswordResetThrottled) {
+		utils.TooManyRequestsResponse(c, "Too many password reset requests, try again later")
+		return
+	}
 	if err != nil {
-		// Don't reveal if email exists for security
-		utils.SuccessResponse(c, "If the email exists, a reset link has been sent", nil)
+		utils.InternalServerErrorResponse(c, "Failed to request password reset")
+		return
+	}
+
+	utils.SuccessResponse(c, "If the email exists, a reset link has been sent", nil)
+}
+
+// CheckResetToken reports whether a password reset link still works
+func (ac *AuthController) CheckResetToken(c *gin.Context) {
+	if err := ac.resetService.CheckToken(c.Request.Context(), c.Param("token")); err != nil {
+		if errors.Is(err, services.ErrPasswordResetTokenInvalid) {
+			utils.BadRequestResponse(c, err.Error())
+			return
+		}
+		utils.InternalServerErrorResponse(c, "Failed to check reset token")
 		return
 	}
 
-	utils.SuccessResponse(c, "Password reset email sent", nil)
+	utils.SuccessResponse(c, "Reset token is valid", nil)
 }
 
-// ResetPassword handles password reset
+// ResetPassword sets a new password with a token from a reset email, and
+// signs the account out everywhere
 func (ac *AuthController) ResetPassword(c *gin.Context) {
 	var req struct {
 		Token       string `json:"token" validate:"required"`
@@ -181,11 +437,29 @@ func (ac *AuthController) ResetPassword(c *gin.Context) {
 		return
 	}
 
-	err := ac.authService.ResetPassword(req.Token, req.NewPassword)
+	user, err := ac.resetService.ResetPassword(c.Request.Context(), req.Token, req.NewPassword)
 	if err != nil {
-		utils.BadRequestResponse(c, err.Error())
-		return
-	}
+		if respondPasswordPolicyError(c, err) {
+			return
+		}
+		if errors.Is(err, services.ErrPasswordResetTokenInvalid) {
+			utils.BadRequestResponse(c, err.Error())
+			return
+		}
+		utils.InternalServerErrorResponse(c, "Failed to reset password")
+		return
+	}
+
+	ac.auditService.Record(c.Request.Context(), &models.AuditLog{
+		ActorType:    "user",
+		ActorID:      &user.ID,
+		Action:       "auth.password_reset",
+		ResourceType: "user",
+		ResourceID:   user.ID.Hex(),
+		Outcome:      "success",
+		IPAddress:    c.ClientIP(),
+		UserAgent:    c.Request.UserAgent(),
+	})
 
 	utils.SuccessResponse(c, "Password reset successful", nil)
 }
@@ -198,7 +472,7 @@ func (ac *AuthController) VerifyEmail(c *gin.Context) {
 		return
 	}
 
-	err := ac.authService.VerifyEmail(token)
+	err := ac.authService.VerifyEmail(c.Request.Context(), token)
 	if err != nil {
 		utils.BadRequestResponse(c, err.Error())
 		return
@@ -218,7 +492,7 @@ func (ac *AuthController) ResendVerification(c *gin.Context) {
 		return
 	}
 
-	err := ac.authService.ResendVerificationEmail(req.Email)
+	err := ac.authService.ResendVerificationEmail(c.Request.Context(), req.Email)
 	if err != nil {
 		utils.ErrorResponse(c, http.StatusBadRequest, err.Error(), nil)
 		return
@@ -253,12 +527,28 @@ func (ac *AuthController) ChangePassword(c *gin.Context) {
 		return
 	}
 
-	err := ac.authService.ChangePassword(user.ID, req.CurrentPassword, req.NewPassword)
+	err := ac.authService.ChangePassword(c.Request.Context(), user.ID, req.CurrentPassword, req.NewPassword)
 	if err != nil {
+		if respondPasswordPolicyError(c, err) {
+			return
+		}
 		utils.BadRequestResponse(c, err.Error())
 		return
 	}
 
+	// Sign out every other device that knew the old password
+	revoked, _ := ac.sessionService.RevokeOtherSessions(c.Request.Context(), user.ID, c.GetString("session_id"), "password_changed")
+	ac.auditService.Record(c.Request.Context(), &models.AuditLog{
+		ActorType:    "user",
+		ActorID:      &user.ID,
+		Action:       "auth.password_changed",
+		ResourceType: "user",
+		ResourceID:   user.ID.Hex(),
+		IPAddress:    c.ClientIP(),
+		UserAgent:    c.Request.UserAgent(),
+		Details:      map[string]interface{}{"sessions_revoked": revoked},
+	})
+
 	utils.SuccessResponse(c, "Password changed successfully", nil)
 }
 
@@ -317,7 +607,7 @@ func (ac *AuthController) UpdateProfile(c *gin.Context) {
 		"updated_at": time.Now(),
 	}
 
-	err := ac.userService.UpdateUser(user.ID, updates)
+	err := ac.userService.UpdateUser(c.Request.Context(), user.ID, updates)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to update profile")
 		return
@@ -343,7 +633,7 @@ func (ac *AuthController) DeleteAccount(c *gin.Context) {
 		return
 	}
 
-	err := ac.authService.DeleteAccount(user.ID, req.Password)
+	err := ac.authService.DeleteAccount(c.Request.Context(), user.ID, req.Password)
 	if err != nil {
 		utils.BadRequestResponse(c, err.Error())
 		return
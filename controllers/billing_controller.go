@@ -0,0 +1,177 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"oncloud/models"
+	"oncloud/services"
+	"oncloud/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type BillingController struct {
+	billingService *services.BillingService
+	auditService   *services.AuditService
+}
+
+func NewBillingController() *BillingController {
+	return &BillingController{
+		billingService: services.NewBillingService(),
+		auditService:   services.NewAuditService(),
+	}
+}
+
+// RefundPayment refunds a payment, fully or in part, through the payment
+// gateway
+func (bc *BillingController) RefundPayment(c *gin.Context) {
+	admin, exists := utils.GetAdminFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "Admin not found")
+		return
+	}
+
+	paymentID := c.Param("id")
+	if !utils.IsValidObjectID(paymentID) {
+		utils.BadRequestResponse(c, "Invalid payment ID")
+		return
+	}
+
+	req, ok := utils.BoundRequest[models.RefundRequest](c)
+	if !ok {
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(paymentID)
+	refund, err := bc.billingService.IssueRefund(c.Request.Context(), objID, req, admin.ID)
+	if err != nil {
+		respondBillingError(c, err, "Failed to refund payment")
+		return
+	}
+
+	bc.auditService.Record(c.Request.Context(), &models.AuditLog{
+		ActorType:    "admin",
+		ActorID:      &admin.ID,
+		Action:       "payment.refunded",
+		ResourceType: "payment",
+		ResourceID:   objID.Hex(),
+		Outcome:      "success",
+		IPAddress:    c.ClientIP(),
+		UserAgent:    c.Request.UserAgent(),
+		Details:      map[string]interface{}{"refund_id": refund.ID, "amount": refund.Amount, "currency": refund.Currency, "reason": refund.Reason},
+	})
+
+	utils.CreatedResponse(c, "Refund issued successfully", refund)
+}
+
+// GetRefunds lists refunds, filtered by status, payment or user
+func (bc *BillingController) GetRefunds(c *gin.Context) {
+	page, limit := utils.GetPagination(c, 20, 0)
+
+	filter := services.RefundFilter{Status: c.Query("status")}
+	if paymentID := c.Query("payment_id"); paymentID != "" {
+		if !utils.IsValidObjectID(paymentID) {
+			utils.BadRequestResponse(c, "Invalid payment ID")
+			return
+		}
+		objID, _ := utils.StringToObjectID(paymentID)
+		filter.PaymentID = &objID
+	}
+	if userID := c.Query("user_id"); userID != "" {
+		if !utils.IsValidObjectID(userID) {
+			utils.BadRequestResponse(c, "Invalid user ID")
+			return
+		}
+		objID, _ := utils.StringToObjectID(userID)
+		filter.UserID = &objID
+	}
+
+	refunds, total, err := bc.billingService.ListRefunds(c.Request.Context(), filter, page, limit)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get refunds")
+		return
+	}
+
+	utils.PaginatedResponse(c, "Refunds retrieved successfully", refunds, page, limit, total)
+}
+
+// GetDisputes lists chargebacks, filtered by status or user
+func (bc *BillingController) GetDisputes(c *gin.Context) {
+	page, limit := utils.GetPagination(c, 20, 0)
+
+	filter := services.DisputeFilter{Status: c.Query("status")}
+	if userID := c.Query("user_id"); userID != "" {
+		if !utils.IsValidObjectID(userID) {
+			utils.BadRequestResponse(c, "Invalid user ID")
+			return
+		}
+		objID, _ := utils.StringToObjectID(userID)
+		filter.UserID = &objID
+	}
+
+	disputes, total, err := bc.billingService.ListDisputes(c.Request.Context(), filter, page, limit)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get disputes")
+		return
+	}
+
+	utils.PaginatedResponse(c, "Disputes retrieved successfully", disputes, page, limit, total)
+}
+
+// ReleaseDispute resumes the service a chargeback paused, before the
+// dispute is decided
+func (bc *BillingController) ReleaseDispute(c *gin.Context) {
+	admin, exists := utils.GetAdminFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "Admin not found")
+		return
+	}
+
+	disputeID := c.Param("id")
+	if !utils.IsValidObjectID(disputeID) {
+		utils.BadRequestResponse(c, "Invalid dispute ID")
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(disputeID)
+	dispute, err := bc.billingService.ReleaseDispute(c.Request.Context(), objID, admin.ID)
+	if err != nil {
+		respondBillingError(c, err, "Failed to release dispute")
+		return
+	}
+
+	bc.auditService.Record(c.Request.Context(), &models.AuditLog{
+		ActorType:    "admin",
+		ActorID:      &admin.ID,
+		Action:       "dispute.released",
+		ResourceType: "dispute",
+		ResourceID:   objID.Hex(),
+		Outcome:      "success",
+		IPAddress:    c.ClientIP(),
+		UserAgent:    c.Request.UserAgent(),
+		Details:      map[string]interface{}{"user_id": dispute.UserID, "status": dispute.Status},
+	})
+
+	utils.SuccessResponse(c, "Dispute released successfully", dispute)
+}
+
+func respondBillingError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrPaymentGatewayNotConfigured):
+		utils.ServiceUnavailableResponse(c, "No payment gateway is configured for refunds")
+	case errors.Is(err, services.ErrPaymentNotRefundable):
+		utils.BadRequestResponse(c, "The payment was not completed through the payment gateway")
+	case errors.Is(err, services.ErrRefundExceedsPayment):
+		utils.BadRequestResponse(c, "The refund is more than is left of the payment")
+	case errors.Is(err, services.ErrPaymentDisputed):
+		utils.ConflictResponse(c, "The payment is disputed and cannot be refunded until the dispute is decided")
+	case errors.Is(err, services.ErrPaymentFullyRefunded):
+		utils.ConflictResponse(c, "The payment is already fully refunded")
+	case errors.Is(err, services.ErrDisputeNotPaused):
+		utils.ConflictResponse(c, "The dispute does not pause the user's service")
+	case errors.Is(err, services.ErrRefundFailed):
+		utils.ErrorResponse(c, http.StatusBadGateway, "The payment gateway did not make the refund", map[string]interface{}{"reason": err.Error()})
+	default:
+		utils.ServiceErrorResponse(c, err, message)
+	}
+}
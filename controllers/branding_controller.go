@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"errors"
+	"oncloud/models"
+	"oncloud/services"
+	"oncloud/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type BrandingController struct {
+	brandingService *services.BrandingService
+}
+
+func NewBrandingController() *BrandingController {
+	return &BrandingController{
+		brandingService: services.NewBrandingService(),
+	}
+}
+
+// GetPublicBranding returns the branding in effect, for clients to theme
+// themselves with
+func (bc *BrandingController) GetPublicBranding(c *gin.Context) {
+	branding := services.CurrentBranding()
+
+	utils.SuccessResponse(c, "Branding retrieved successfully", gin.H{
+		"product_name":    branding.ProductName,
+		"logo_url":        branding.LogoURL,
+		"primary_color":   branding.PrimaryColor,
+		"accent_color":    branding.AccentColor,
+		"share_page_html": branding.SharePageHTML,
+	})
+}
+
+// GetBranding returns the stored branding, without defaults
+func (bc *BrandingController) GetBranding(c *gin.Context) {
+	branding, err := bc.brandingService.GetBranding(c.Request.Context())
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get branding")
+		return
+	}
+
+	utils.SuccessResponse(c, "Branding retrieved successfully", branding)
+}
+
+// UpdateBranding replaces the branding
+func (bc *BrandingController) UpdateBranding(c *gin.Context) {
+	admin, exists := utils.GetAdminFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "Admin not found")
+		return
+	}
+
+	var req models.BrandingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data")
+		return
+	}
+
+	branding, err := bc.brandingService.UpdateBranding(c.Request.Context(), &req, admin.ID)
+	if err != nil {
+		respondBrandingError(c, err, "Failed to update branding")
+		return
+	}
+
+	utils.SuccessResponse(c, "Branding updated successfully", branding)
+}
+
+// ResetBranding removes all customization
+func (bc *BrandingController) ResetBranding(c *gin.Context) {
+	if err := bc.brandingService.ResetBranding(c.Request.Context()); err != nil {
+		respondBrandingError(c, err, "Failed to reset branding")
+		return
+	}
+
+	utils.SuccessResponse(c, "Branding reset successfully", nil)
+}
+
+func respondBrandingError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrInvalidBranding):
+		utils.BadRequestResponse(c, err.Error())
+	default:
+		utils.ServiceErrorResponse(c, err, message)
+	}
+}
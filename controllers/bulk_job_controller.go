@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"errors"
+	"oncloud/models"
+	"oncloud/services"
+	"oncloud/utils"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type BulkJobController struct {
+	bulkJobService *services.BulkJobService
+}
+
+func NewBulkJobController() *BulkJobController {
+	return &BulkJobController{
+		bulkJobService: services.NewBulkJobService(),
+	}
+}
+
+// GetJobs lists the user's bulk jobs
+func (bc *BulkJobController) GetJobs(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	page, limit := utils.GetPagination(c, 20, 0)
+
+	jobs, total, err := bc.bulkJobService.GetJobs(c.Request.Context(), user.ID, page, limit)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get bulk jobs")
+		return
+	}
+
+	utils.PaginatedResponse(c, "Bulk jobs retrieved successfully", jobs, page, limit, total)
+}
+
+// GetJob returns a bulk job with its progress. With after, only the items
+// finished after the first after are returned, so clients polling for
+// progress receive each item once.
+func (bc *BulkJobController) GetJob(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	jobID := c.Param("id")
+	if !utils.IsValidObjectID(jobID) {
+		utils.BadRequestResponse(c, "Invalid bulk job ID")
+		return
+	}
+
+	after, err := strconv.Atoi(c.DefaultQuery("after", "0"))
+	if err != nil || after < 0 {
+		utils.BadRequestResponse(c, "Invalid after")
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(jobID)
+	job, err := bc.bulkJobService.GetJob(c.Request.Context(), user.ID, objID, after)
+	if errors.Is(err, services.ErrBulkJobNotFound) {
+		utils.NotFoundResponse(c, "Bulk job not found")
+		return
+	}
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get bulk job")
+		return
+	}
+
+	utils.SuccessResponse(c, "Bulk job retrieved successfully", job)
+}
+
+// respondBulkResult responds with the results of a bulk operation run within
+// the request, or with the job running it in the background
+func respondBulkResult(c *gin.Context, results map[string]interface{}, job *models.BulkJob, err error, failure, success string) {
+	if err != nil {
+		utils.InternalServerErrorResponse(c, failure)
+		return
+	}
+	if job != nil {
+		utils.CreatedResponse(c, "Bulk job started", job)
+		return
+	}
+	utils.SuccessResponse(c, success, results)
+}
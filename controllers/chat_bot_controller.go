@@ -0,0 +1,150 @@
+package controllers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"oncloud/models"
+	"oncloud/services"
+	"oncloud/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Chat webhook bodies are small JSON documents; files are fetched
+// separately
+const maxChatWebhookSize = 1 << 20
+
+type ChatBotController struct {
+	chatBotService *services.ChatBotService
+}
+
+func NewChatBotController() *ChatBotController {
+	return &ChatBotController{
+		chatBotService: services.NewChatBotService(),
+	}
+}
+
+// GetPlatforms lists the chat platforms the bot is reachable on
+func (cc *ChatBotController) GetPlatforms(c *gin.Context) {
+	utils.SuccessResponse(c, "Chat platforms retrieved successfully", cc.chatBotService.GetPlatforms())
+}
+
+// CreateLinkCode returns a code to send to the bot to link a chat account
+func (cc *ChatBotController) CreateLinkCode(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	linkCode, err := cc.chatBotService.CreateLinkCode(c.Request.Context(), user.ID)
+	if err != nil {
+		respondChatBotError(c, err, "Failed to create link code")
+		return
+	}
+
+	utils.CreatedResponse(c, "Link code created successfully", linkCode)
+}
+
+// GetAccounts lists the user's linked chat accounts
+func (cc *ChatBotController) GetAccounts(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	accounts, err := cc.chatBotService.GetAccounts(c.Request.Context(), user.ID)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get chat accounts")
+		return
+	}
+
+	utils.SuccessResponse(c, "Chat accounts retrieved successfully", accounts)
+}
+
+// UpdateAccount changes the folder files sent from a chat account go to
+func (cc *ChatBotController) UpdateAccount(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	accountID := c.Param("id")
+	if !utils.IsValidObjectID(accountID) {
+		utils.BadRequestResponse(c, "Invalid account ID")
+		return
+	}
+
+	var req models.ChatAccountUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data")
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(accountID)
+	account, err := cc.chatBotService.UpdateAccount(c.Request.Context(), user.ID, objID, &req)
+	if err != nil {
+		respondChatBotError(c, err, "Failed to update chat account")
+		return
+	}
+
+	utils.SuccessResponse(c, "Chat account updated successfully", account)
+}
+
+// DeleteAccount unlinks a chat account
+func (cc *ChatBotController) DeleteAccount(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	accountID := c.Param("id")
+	if !utils.IsValidObjectID(accountID) {
+		utils.BadRequestResponse(c, "Invalid account ID")
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(accountID)
+	if err := cc.chatBotService.DeleteAccount(c.Request.Context(), user.ID, objID); err != nil {
+		respondChatBotError(c, err, "Failed to unlink chat account")
+		return
+	}
+
+	utils.SuccessResponse(c, "Chat account unlinked successfully", nil)
+}
+
+// Webhook receives messages sent to the bot on a chat platform
+func (cc *ChatBotController) Webhook(c *gin.Context) {
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxChatWebhookSize))
+	if err != nil {
+		utils.BadRequestResponse(c, "Failed to read request body")
+		return
+	}
+
+	response, err := cc.chatBotService.HandleWebhook(c.Param("platform"), c.Request.Header, body)
+	if err != nil {
+		respondChatBotError(c, err, "Failed to process webhook")
+		return
+	}
+
+	if response != nil {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func respondChatBotError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrChatPlatformNotConfigured):
+		utils.NotFoundResponse(c, "The chat bot is not available")
+	case errors.Is(err, services.ErrChatWebhookUnverified):
+		utils.UnauthorizedResponse(c, "Webhook could not be verified")
+	default:
+		utils.ServiceErrorResponse(c, err, message)
+	}
+}
@@ -0,0 +1,35 @@
+package controllers
+
+import (
+	"errors"
+	"oncloud/services"
+	"oncloud/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CompressionController struct {
+	compressionService *services.CompressionService
+}
+
+func NewCompressionController() *CompressionController {
+	return &CompressionController{
+		compressionService: services.NewCompressionService(),
+	}
+}
+
+// GetCompressionSavings reports the space compression at rest saves per
+// user, or per storage provider with ?group_by=provider
+func (cc *CompressionController) GetCompressionSavings(c *gin.Context) {
+	report, err := cc.compressionService.GetSavings(c.Request.Context(), c.DefaultQuery("group_by", "user"))
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidSavingsGrouping) {
+			utils.BadRequestResponse(c, err.Error())
+			return
+		}
+		utils.InternalServerErrorResponse(c, "Failed to get compression savings")
+		return
+	}
+
+	utils.SuccessResponse(c, "Compression savings retrieved successfully", report)
+}
@@ -0,0 +1,43 @@
+package controllers
+
+import (
+	"net/http"
+	"oncloud/docs"
+
+	"github.com/gin-gonic/gin"
+)
+
+// docsPage renders the OpenAPI document with Swagger UI
+const docsPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>OnCloud API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js" crossorigin></script>
+  <script>
+    window.onload = function () {
+      window.ui = SwaggerUIBundle({ url: "/api/docs/openapi.yaml", dom_id: "#swagger-ui" });
+    };
+  </script>
+</body>
+</html>`
+
+type DocsController struct{}
+
+func NewDocsController() *DocsController {
+	return &DocsController{}
+}
+
+// GetDocs serves the interactive API reference
+func (dc *DocsController) GetDocs(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(docsPage))
+}
+
+// GetOpenAPISpec serves the OpenAPI document
+func (dc *DocsController) GetOpenAPISpec(c *gin.Context) {
+	c.Data(http.StatusOK, "application/yaml", docs.OpenAPISpec)
+}
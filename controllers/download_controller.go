@@ -0,0 +1,124 @@
+package controllers
+
+import (
+	"oncloud/services"
+	"oncloud/utils"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type DownloadController struct {
+	downloadService *services.DownloadService
+}
+
+func NewDownloadController(deps services.Dependencies) *DownloadController {
+	return &DownloadController{
+		downloadService: services.NewDownloadServiceWith(deps),
+	}
+}
+
+// CreateDownloadToken issues a resumable download token for a file
+func (dc *DownloadController) CreateDownloadToken(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	var req struct {
+		FileID     string `json:"file_id" validate:"required"`
+		TTLSeconds int    `json:"ttl_seconds"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data")
+		return
+	}
+
+	if !utils.IsValidObjectID(req.FileID) {
+		utils.BadRequestResponse(c, "Invalid file ID")
+		return
+	}
+
+	fileID, _ := utils.StringToObjectID(req.FileID)
+	token, err := dc.downloadService.CreateDownloadToken(c.Request.Context(), user.ID, fileID, time.Duration(req.TTLSeconds)*time.Second)
+	if err != nil {
+		utils.NotFoundResponse(c, "File not found")
+		return
+	}
+
+	utils.CreatedResponse(c, "Download token created successfully", token)
+}
+
+// GetDownloadTokens lists the user's download tokens
+func (dc *DownloadController) GetDownloadTokens(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+
+	var fileID *primitive.ObjectID
+	if id := c.Query("file_id"); id != "" {
+		if !utils.IsValidObjectID(id) {
+			utils.BadRequestResponse(c, "Invalid file ID")
+			return
+		}
+		objID, _ := utils.StringToObjectID(id)
+		fileID = &objID
+	}
+
+	tokens, total, err := dc.downloadService.GetUserDownloadTokens(c.Request.Context(), user.ID, fileID, page, limit)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get download tokens")
+		return
+	}
+
+	utils.PaginatedResponse(c, "Download tokens retrieved successfully", tokens, page, limit, total)
+}
+
+// RevokeDownloadToken revokes a download token
+func (dc *DownloadController) RevokeDownloadToken(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	tokenID := c.Param("id")
+	if !utils.IsValidObjectID(tokenID) {
+		utils.BadRequestResponse(c, "Invalid token ID")
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(tokenID)
+	if err := dc.downloadService.RevokeDownloadToken(c.Request.Context(), user.ID, objID); err != nil {
+		utils.NotFoundResponse(c, "Download token not found")
+		return
+	}
+
+	utils.SuccessResponse(c, "Download token revoked successfully", nil)
+}
+
+// ServeDownload streams the file behind a download token (no authentication required)
+func (dc *DownloadController) ServeDownload(c *gin.Context) {
+	token := c.Param("token")
+	if token == "" {
+		utils.BadRequestResponse(c, "Download token is required")
+		return
+	}
+
+	if err := dc.downloadService.ServeDownload(c.Request.Context(), token, c.ClientIP(), c.Writer, c.Request); err != nil {
+		if respondFileExpired(c, err) || respondFileArchived(c, err) || respondReceiptUnavailable(c, err) {
+			return
+		}
+		utils.ServiceErrorResponse(c, err, "Failed to serve download")
+		return
+	}
+}
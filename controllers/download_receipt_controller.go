@@ -0,0 +1,139 @@
+package controllers
+
+import (
+	"errors"
+	"oncloud/models"
+	"oncloud/services"
+	"oncloud/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type DownloadReceiptController struct {
+	receiptService *services.DownloadReceiptService
+}
+
+func NewDownloadReceiptController(deps services.Dependencies) *DownloadReceiptController {
+	return &DownloadReceiptController{
+		receiptService: services.NewDownloadReceiptServiceWith(deps),
+	}
+}
+
+// GetPublicKey returns the key receipts are verified with, so that they can
+// be checked offline (no authentication required)
+func (rc *DownloadReceiptController) GetPublicKey(c *gin.Context) {
+	key, err := services.DownloadReceiptPublicKey()
+	if errors.Is(err, services.ErrDownloadReceiptsDisabled) {
+		utils.ServiceUnavailableResponse(c, "Download receipts are not enabled")
+		return
+	}
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get receipt signing key")
+		return
+	}
+
+	utils.SuccessResponse(c, "Receipt signing key retrieved successfully", key)
+}
+
+// SetFileReceipts turns signed receipts for every download of a file on or off
+func (rc *DownloadReceiptController) SetFileReceipts(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	fileID := c.Param("id")
+	if !utils.IsValidObjectID(fileID) {
+		utils.BadRequestResponse(c, "Invalid file ID")
+		return
+	}
+
+	req, ok := utils.BoundRequest[models.DownloadReceiptsRequest](c)
+	if !ok {
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(fileID)
+	file, err := rc.receiptService.SetFileReceipts(c.Request.Context(), user.ID, objID, req.Enabled)
+	if errors.Is(err, services.ErrDownloadReceiptsDisabled) {
+		utils.ServiceUnavailableResponse(c, "Download receipts are not enabled")
+		return
+	}
+	if err != nil {
+		utils.NotFoundResponse(c, "File not found")
+		return
+	}
+
+	utils.SuccessResponse(c, "Download receipts updated successfully", file)
+}
+
+// GetFileReceipts lists the receipts for downloads of a file
+func (rc *DownloadReceiptController) GetFileReceipts(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	fileID := c.Param("id")
+	if !utils.IsValidObjectID(fileID) {
+		utils.BadRequestResponse(c, "Invalid file ID")
+		return
+	}
+
+	page, limit := utils.GetPagination(c, 20, 0)
+	objID, _ := utils.StringToObjectID(fileID)
+	receipts, total, err := rc.receiptService.ListFileReceipts(c.Request.Context(), user.ID, objID, page, limit)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get download receipts")
+		return
+	}
+
+	utils.PaginatedResponse(c, "Download receipts retrieved successfully", receipts, page, limit, total)
+}
+
+// GetReceipt returns one download receipt
+func (rc *DownloadReceiptController) GetReceipt(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	receiptID := c.Param("id")
+	if !utils.IsValidObjectID(receiptID) {
+		utils.BadRequestResponse(c, "Invalid receipt ID")
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(receiptID)
+	receipt, err := rc.receiptService.GetReceipt(c.Request.Context(), user.ID, objID)
+	if err != nil {
+		utils.NotFoundResponse(c, "Download receipt not found")
+		return
+	}
+
+	utils.SuccessResponse(c, "Download receipt retrieved successfully", receipt)
+}
+
+// respondReceiptUnavailable writes the response to a download refused because
+// its receipt could not be issued, when err says so, and reports whether it did
+func respondReceiptUnavailable(c *gin.Context, err error) bool {
+	if !errors.Is(err, services.ErrReceiptUnavailable) {
+		return false
+	}
+	c.Header("Retry-After", "60")
+	utils.ServiceUnavailableResponse(c, "A download receipt could not be issued for this file. Please try again later.")
+	return true
+}
+
+// anonymousDownloader identifies someone downloading through a public or
+// share link
+func anonymousDownloader(c *gin.Context, linkType string) models.ReceiptDownloader {
+	return models.ReceiptDownloader{
+		Type:      linkType,
+		IPAddress: c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	}
+}
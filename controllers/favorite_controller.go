@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"oncloud/models"
+	"oncloud/services"
+	"oncloud/utils"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type FavoriteController struct {
+	favoriteService *services.FavoriteService
+	fileService     *services.FileService
+	folderService   *services.FolderService
+}
+
+func NewFavoriteController(deps services.Dependencies) *FavoriteController {
+	return &FavoriteController{
+		favoriteService: services.NewFavoriteServiceWith(deps),
+		fileService:     services.NewFileServiceWith(deps),
+		folderService:   services.NewFolderServiceWith(deps),
+	}
+}
+
+// GetFavorites returns the user's favorite files and folders in one list
+func (fc *FavoriteController) GetFavorites(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	filters := &services.FavoriteFilters{
+		Type:      c.Query("type"),
+		SortBy:    c.DefaultQuery("sort", "favorited_at"),
+		SortOrder: c.DefaultQuery("order", "desc"),
+	}
+
+	items, total, err := fc.favoriteService.GetFavorites(c.Request.Context(), user.ID, page, limit, filters)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get favorites")
+		return
+	}
+
+	utils.PaginatedResponse(c, "Favorites retrieved successfully", items, page, limit, total)
+}
+
+// AddFavorite stars a file or folder
+func (fc *FavoriteController) AddFavorite(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	var req models.FavoriteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data")
+		return
+	}
+	if err := utils.ValidateStruct(&req); err != nil {
+		utils.ValidationErrorResponse(c, err)
+		return
+	}
+
+	fc.setFavorite(c, user.ID, req.Type, req.ID, true)
+}
+
+// RemoveFavorite unstars a file or folder
+func (fc *FavoriteController) RemoveFavorite(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	fc.setFavorite(c, user.ID, c.Param("type"), c.Param("id"), false)
+}
+
+func (fc *FavoriteController) setFavorite(c *gin.Context, userID primitive.ObjectID, itemType, itemID string, isFavorite bool) {
+	if !utils.IsValidObjectID(itemID) {
+		utils.BadRequestResponse(c, "Invalid ID")
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(itemID)
+
+	var err error
+	switch itemType {
+	case "file":
+		err = fc.fileService.ToggleFavorite(c.Request.Context(), userID, objID, isFavorite)
+	case "folder":
+		err = fc.folderService.ToggleFavorite(c.Request.Context(), userID, objID, isFavorite)
+	default:
+		utils.BadRequestResponse(c, "Type must be file or folder")
+		return
+	}
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to update favorites")
+		return
+	}
+
+	if isFavorite {
+		utils.SuccessResponse(c, "Added to favorites", nil)
+	} else {
+		utils.SuccessResponse(c, "Removed from favorites", nil)
+	}
+}
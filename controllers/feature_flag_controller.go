@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"errors"
+	"oncloud/models"
+	"oncloud/services"
+	"oncloud/utils"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type FeatureFlagController struct {
+	flagService  *services.FeatureFlagService
+	auditService *services.AuditService
+}
+
+func NewFeatureFlagController() *FeatureFlagController {
+	return &FeatureFlagController{
+		flagService:  services.NewFeatureFlagService(),
+		auditService: services.NewAuditService(),
+	}
+}
+
+// GetUserFlags returns whether each feature flag is on for the user, so the
+// frontend can show the features being rolled out to them. Signed-out
+// visitors get the flags that are on for everyone.
+func (ffc *FeatureFlagController) GetUserFlags(c *gin.Context) {
+	var userID *primitive.ObjectID
+	if user, exists := utils.GetUserFromContext(c); exists {
+		userID = &user.ID
+	}
+
+	flags := ffc.flagService.UserFlags(c.Request.Context(), userID)
+
+	utils.SuccessResponse(c, "Feature flags retrieved successfully", flags)
+}
+
+// GetFlags lists the feature flags
+func (ffc *FeatureFlagController) GetFlags(c *gin.Context) {
+	flags, err := ffc.flagService.ListFlags(c.Request.Context())
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get feature flags")
+		return
+	}
+
+	utils.SuccessResponse(c, "Feature flags retrieved successfully", flags)
+}
+
+// SetFlag sets who a feature flag is on for, creating it if needed
+func (ffc *FeatureFlagController) SetFlag(c *gin.Context) {
+	admin, exists := utils.GetAdminFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "Admin not found")
+		return
+	}
+
+	req, ok := utils.BoundRequest[models.FeatureFlagRequest](c)
+	if !ok {
+		return
+	}
+
+	flag, err := ffc.flagService.SetFlag(c.Request.Context(), c.Param("key"), req, admin.ID)
+	if err != nil {
+		respondFeatureFlagError(c, err, "Failed to save feature flag")
+		return
+	}
+
+	ffc.audit(c, admin, "feature_flag.updated", flag.Key, map[string]interface{}{
+		"enabled":    flag.Enabled,
+		"percentage": flag.Percentage,
+		"users":      len(flag.UserIDs),
+		"plans":      flag.PlanIDs,
+		"groups":     flag.GroupIDs,
+	})
+
+	utils.SuccessResponse(c, "Feature flag saved successfully", flag)
+}
+
+// DeleteFlag removes a feature flag, returning a built-in one to its default
+func (ffc *FeatureFlagController) DeleteFlag(c *gin.Context) {
+	admin, exists := utils.GetAdminFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "Admin not found")
+		return
+	}
+
+	key := c.Param("key")
+	if err := ffc.flagService.DeleteFlag(c.Request.Context(), key); err != nil {
+		respondFeatureFlagError(c, err, "Failed to delete feature flag")
+		return
+	}
+
+	ffc.audit(c, admin, "feature_flag.deleted", key, nil)
+
+	utils.SuccessResponse(c, "Feature flag deleted successfully", nil)
+}
+
+func (ffc *FeatureFlagController) audit(c *gin.Context, admin *models.Admin, action, key string, details map[string]interface{}) {
+	ffc.auditService.Record(c.Request.Context(), &models.AuditLog{
+		ActorType:    "admin",
+		ActorID:      &admin.ID,
+		Action:       action,
+		ResourceType: "feature_flag",
+		ResourceID:   key,
+		Outcome:      "success",
+		IPAddress:    c.ClientIP(),
+		UserAgent:    c.Request.UserAgent(),
+		Details:      details,
+	})
+}
+
+func respondFeatureFlagError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrInvalidFeatureFlag):
+		utils.BadRequestResponse(c, err.Error())
+	default:
+		utils.ServiceErrorResponse(c, err, message)
+	}
+}
@@ -1,6 +1,8 @@
 package controllers
 
 import (
+	"errors"
+	"oncloud/models"
 	"oncloud/services"
 	"oncloud/utils"
 	"strconv"
@@ -9,14 +11,18 @@ import (
 )
 
 type FileAdminController struct {
-	fileService  *services.FileService
-	adminService *services.AdminService
+	fileService     *services.FileService
+	adminService    *services.AdminService
+	fileLockService *services.FileLockService
+	auditService    *services.AuditService
 }
 
-func NewFileAdminController() *FileAdminController {
+func NewFileAdminController(deps services.Dependencies) *FileAdminController {
 	return &FileAdminController{
-		fileService:  services.NewFileService(),
-		adminService: services.NewAdminService(),
+		fileService:     services.NewFileServiceWith(deps),
+		adminService:    services.NewAdminServiceWith(deps),
+		fileLockService: services.NewFileLockServiceWith(deps),
+		auditService:    services.NewAuditServiceWith(deps),
 	}
 }
 
@@ -27,7 +33,7 @@ func (fac *FileAdminController) GetFiles(c *gin.Context) {
 	search := c.Query("search")
 	fileType := c.Query("type")
 	userID := c.Query("user_id")
-	status := c.Query("status") // active, deleted, reported
+	status := c.Query("status") // active, deleted, reported, mismatched
 	sortBy := c.DefaultQuery("sort", "created_at")
 	sortOrder := c.DefaultQuery("order", "desc")
 
@@ -40,7 +46,7 @@ func (fac *FileAdminController) GetFiles(c *gin.Context) {
 		SortOrder: sortOrder,
 	}
 
-	files, total, err := fac.fileService.GetFilesForAdmin(page, limit, filters)
+	files, total, err := fac.fileService.GetFilesForAdmin(c.Request.Context(), page, limit, filters)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get files")
 		return
@@ -58,7 +64,7 @@ func (fac *FileAdminController) GetFile(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(fileID)
-	file, err := fac.fileService.GetFileForAdmin(objID)
+	file, err := fac.fileService.GetFileForAdmin(c.Request.Context(), objID)
 	if err != nil {
 		utils.NotFoundResponse(c, "File not found")
 		return
@@ -75,18 +81,13 @@ func (fac *FileAdminController) DeleteFile(c *gin.Context) {
 		return
 	}
 
-	var req struct {
-		Reason    string `json:"reason"`
-		Permanent bool   `json:"permanent"`
-	}
-
-	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request data")
+	req, ok := utils.BoundRequest[models.AdminFileDeleteRequest](c)
+	if !ok {
 		return
 	}
 
 	objID, _ := utils.StringToObjectID(fileID)
-	err := fac.fileService.DeleteFileByAdmin(objID, req.Reason, req.Permanent)
+	err := fac.fileService.DeleteFileByAdmin(c.Request.Context(), objID, req.Reason, req.Permanent)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to delete file")
 		return
@@ -104,7 +105,7 @@ func (fac *FileAdminController) RestoreFile(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(fileID)
-	err := fac.fileService.RestoreFileByAdmin(objID)
+	err := fac.fileService.RestoreFileByAdmin(c.Request.Context(), objID)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to restore file")
 		return
@@ -121,24 +122,13 @@ func (fac *FileAdminController) ModerateFile(c *gin.Context) {
 		return
 	}
 
-	var req struct {
-		Action string `json:"action" validate:"required"` // approve, reject, flag, quarantine
-		Reason string `json:"reason"`
-		Notes  string `json:"notes"`
-	}
-
-	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request data")
-		return
-	}
-
-	if err := utils.ValidateStruct(&req); err != nil {
-		utils.ValidationErrorResponse(c, err)
+	req, ok := utils.BoundRequest[models.FileModerationRequest](c)
+	if !ok {
 		return
 	}
 
 	objID, _ := utils.StringToObjectID(fileID)
-	err := fac.fileService.ModerateFile(objID, req.Action, req.Reason, req.Notes)
+	err := fac.fileService.ModerateFile(c.Request.Context(), objID, req.Action, req.Reason, req.Notes)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to moderate file")
 		return
@@ -149,11 +139,13 @@ func (fac *FileAdminController) ModerateFile(c *gin.Context) {
 
 // GetReportedFiles returns list of reported files
 func (fac *FileAdminController) GetReportedFiles(c *gin.Context) {
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
-	status := c.DefaultQuery("status", "pending") // pending, reviewed, resolved
+	page, limit := utils.GetPagination(c, 20, 0)
+	status := c.DefaultQuery("status", models.AbuseReportPending) // pending, reviewing, confirmed, dismissed or all
+	if status == "all" {
+		status = ""
+	}
 
-	reportedFiles, total, err := fac.fileService.GetReportedFiles(status, page, limit)
+	reportedFiles, total, err := fac.fileService.GetReportedFiles(c.Request.Context(), status, page, limit)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get reported files")
 		return
@@ -170,18 +162,17 @@ func (fac *FileAdminController) ScanFile(c *gin.Context) {
 		return
 	}
 
-	var req struct {
-		ScanType string `json:"scan_type"` // virus, malware, content
-		Force    bool   `json:"force"`     // force rescan even if already scanned
-	}
-
-	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request data")
+	req, ok := utils.BoundRequest[models.FileScanRequest](c)
+	if !ok {
 		return
 	}
 
 	objID, _ := utils.StringToObjectID(fileID)
-	scanResult, err := fac.fileService.ScanFile(objID, req.ScanType, req.Force)
+	scanResult, err := fac.fileService.ScanFile(c.Request.Context(), objID, req.ScanType, req.Force)
+	if errors.Is(err, services.ErrVirusScanDisabled) {
+		utils.ServiceUnavailableResponse(c, "Virus scanning is not enabled")
+		return
+	}
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to scan file")
 		return
@@ -189,3 +180,41 @@ func (fac *FileAdminController) ScanFile(c *gin.Context) {
 
 	utils.SuccessResponse(c, "File scan initiated successfully", scanResult)
 }
+
+// UnlockFile force-releases the edit lock on a file
+func (fac *FileAdminController) UnlockFile(c *gin.Context) {
+	admin, exists := utils.GetAdminFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "Admin not found in context")
+		return
+	}
+
+	fileID := c.Param("id")
+	if !utils.IsValidObjectID(fileID) {
+		utils.BadRequestResponse(c, "Invalid file ID")
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(fileID)
+	lock, err := fac.fileLockService.ForceUnlock(c.Request.Context(), objID)
+	if err != nil {
+		utils.NotFoundResponse(c, "File not found")
+		return
+	}
+
+	if lock != nil {
+		fac.auditService.Record(c.Request.Context(), &models.AuditLog{
+			ActorType:    "admin",
+			ActorID:      &admin.ID,
+			Action:       "file.unlocked",
+			ResourceType: "file",
+			ResourceID:   objID.Hex(),
+			Outcome:      "success",
+			IPAddress:    c.ClientIP(),
+			UserAgent:    c.Request.UserAgent(),
+			Details:      map[string]interface{}{"locked_by": lock.LockedBy, "forced": true},
+		})
+	}
+
+	utils.SuccessResponse(c, "File unlocked successfully", nil)
+}
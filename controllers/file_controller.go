@@ -1,28 +1,165 @@
 package controllers
 
 import (
+	"errors"
+	"html/template"
 	"net/http"
 	"oncloud/models"
 	"oncloud/services"
 	"oncloud/utils"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type FileController struct {
-	fileService    *services.FileService
-	storageService *services.StorageService
+	fileService      *services.FileService
+	storageService   *services.StorageService
+	analyticsService *services.AnalyticsService
+	fileLockService  *services.FileLockService
+	auditService     *services.AuditService
+	bulkJobService   *services.BulkJobService
+	watermarkService *services.ShareWatermarkService
+	previewService   *services.DocumentPreviewService
+	textService      *services.TextPreviewService
+	markdownService  *services.MarkdownPreviewService
+	expiryService    *services.FileExpiryService
+	classService     *services.StorageClassService
 }
 
-func NewFileController() *FileController {
+func NewFileController(deps services.Dependencies) *FileController {
 	return &FileController{
-		fileService:    services.NewFileService(),
-		storageService: services.NewStorageService(),
+		fileService:      services.NewFileServiceWith(deps),
+		storageService:   services.NewStorageServiceWith(deps),
+		analyticsService: services.NewAnalyticsServiceWith(deps),
+		fileLockService:  services.NewFileLockServiceWith(deps),
+		auditService:     services.NewAuditServiceWith(deps),
+		bulkJobService:   services.NewBulkJobServiceWith(deps),
+		watermarkService: services.NewShareWatermarkServiceWith(deps),
+		previewService:   services.NewDocumentPreviewServiceWith(deps),
+		textService:      services.NewTextPreviewServiceWith(deps),
+		markdownService:  services.NewMarkdownPreviewServiceWith(deps),
+		expiryService:    services.NewFileExpiryServiceWith(deps),
+		classService:     services.NewStorageClassServiceWith(deps),
 	}
 }
 
+// respondFileLocked writes a 423 response when err is a FileLockedError and
+// reports whether it did
+func respondFileLocked(c *gin.Context, err error) bool {
+	var lockedErr *services.FileLockedError
+	if !errors.As(err, &lockedErr) {
+		return false
+	}
+
+	utils.ErrorResponse(c, http.StatusLocked, "File is locked for editing by another user", map[string]interface{}{
+		"locked_by":  lockedErr.Lock.LockedBy,
+		"expires_at": lockedErr.Lock.ExpiresAt,
+	})
+	return true
+}
+
+// respondAccountState answers writes to accounts which are suspended,
+// read-only or pending deletion
+func respondAccountState(c *gin.Context, err error) bool {
+	var stateErr *services.AccountStateError
+	if !errors.As(err, &stateErr) {
+		return false
+	}
+
+	code := utils.ErrorCodeAccountReadOnly
+	if stateErr.State == models.AccountStateSuspended {
+		code = utils.ErrorCodeAccountSuspended
+	}
+	utils.ErrorResponseWithCode(c, http.StatusForbidden, code, "This account cannot be changed right now", map[string]interface{}{
+		"state": stateErr.State,
+	})
+	return true
+}
+
+// respondFileScanBlocked writes the response to a share link whose file has
+// not passed its virus scan, when err says so, and reports whether it did
+func respondFileScanBlocked(c *gin.Context, err error) bool {
+	var blockedErr *services.FileScanBlockedError
+	if !errors.As(err, &blockedErr) {
+		return false
+	}
+
+	details := map[string]interface{}{"scan_status": blockedErr.Scan.Status}
+	if blockedErr.Scan.Status == models.ScanStatusInfected {
+		utils.ErrorResponseWithCode(c, http.StatusForbidden, utils.ErrorCodeFileInfected,
+			"This file was found to contain a virus and cannot be downloaded", details)
+		return true
+	}
+
+	c.Header("Retry-After", "60")
+	utils.ErrorResponseWithCode(c, http.StatusConflict, utils.ErrorCodeScanPending,
+		"This file is being scanned for viruses. Please try again shortly.", details)
+	return true
+}
+
+// respondFileExpired answers requests for files past their expiry
+func respondFileExpired(c *gin.Context, err error) bool {
+	if !errors.Is(err, services.ErrFileExpired) {
+		return false
+	}
+	utils.ErrorResponseWithCode(c, http.StatusGone, utils.ErrorCodeFileExpired, "This file has expired", nil)
+	return true
+}
+
+// respondFileArchived answers downloads of archived files which have not
+// been restored
+func respondFileArchived(c *gin.Context, err error) bool {
+	if !errors.Is(err, services.ErrFileArchived) {
+		return false
+	}
+	utils.ErrorResponseWithCode(c, http.StatusConflict, utils.ErrorCodeFileArchived, "This file is archived; restore it before downloading", nil)
+	return true
+}
+
+// respondInvalidStorageClass answers requests for storage classes the
+// provider does not offer
+func respondInvalidStorageClass(c *gin.Context, err error) bool {
+	if !errors.Is(err, services.ErrInvalidStorageClass) && !errors.Is(err, services.ErrStorageClassUnsupported) {
+		return false
+	}
+	utils.BadRequestResponse(c, err.Error())
+	return true
+}
+
+// respondFileTypeMismatch answers uploads refused because their content is
+// not what their extension says
+func respondFileTypeMismatch(c *gin.Context, err error) bool {
+	var mismatchErr *services.FileTypeMismatchError
+	if !errors.As(err, &mismatchErr) {
+		return false
+	}
+
+	utils.ErrorResponseWithCode(c, http.StatusUnprocessableEntity, utils.ErrorCodeFileTypeMismatch, mismatchErr.Error(), map[string]interface{}{
+		"extension":     mismatchErr.Extension,
+		"detected_type": mismatchErr.Check.DetectedType,
+		"executable":    mismatchErr.Check.Executable,
+	})
+	return true
+}
+
+// respondFileTypeBlocked answers uploads refused by a file type policy
+func respondFileTypeBlocked(c *gin.Context, err error) bool {
+	var blockedErr *services.FileTypeBlockedError
+	if !errors.As(err, &blockedErr) {
+		return false
+	}
+
+	utils.ErrorResponseWithCode(c, http.StatusUnprocessableEntity, utils.ErrorCodeFileTypeBlocked, blockedErr.Error(), map[string]interface{}{
+		"policy": blockedErr.Policy.Name,
+		"scope":  blockedErr.Policy.Scope,
+	})
+	return true
+}
+
 // GetFiles returns list of user files
 func (fc *FileController) GetFiles(c *gin.Context) {
 	user, exists := utils.GetUserFromContext(c)
@@ -31,8 +168,7 @@ func (fc *FileController) GetFiles(c *gin.Context) {
 		return
 	}
 
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	page, limit := utils.GetPagination(c, 20, 0)
 	folderID := c.Query("folder_id")
 	search := c.Query("search")
 	fileType := c.Query("type")
@@ -45,10 +181,54 @@ func (fc *FileController) GetFiles(c *gin.Context) {
 		FileType:  fileType,
 		SortBy:    sortBy,
 		SortOrder: sortOrder,
+		Metadata:  make(map[string]string),
+	}
+
+	// Media filters: taken_year, or a taken_from/taken_to date range
+	if year := c.Query("taken_year"); year != "" {
+		y, err := strconv.Atoi(year)
+		if err != nil || y < 1800 || y > 9999 {
+			utils.BadRequestResponse(c, "Invalid taken_year")
+			return
+		}
+		from := time.Date(y, 1, 1, 0, 0, 0, 0, time.UTC)
+		to := from.AddDate(1, 0, 0)
+		filters.TakenFrom, filters.TakenTo = &from, &to
+	}
+	if from := c.Query("taken_from"); from != "" {
+		t, err := time.Parse("2006-01-02", from)
+		if err != nil {
+			utils.BadRequestResponse(c, "Invalid taken_from, expected YYYY-MM-DD")
+			return
+		}
+		filters.TakenFrom = &t
+	}
+	if to := c.Query("taken_to"); to != "" {
+		t, err := time.Parse("2006-01-02", to)
+		if err != nil {
+			utils.BadRequestResponse(c, "Invalid taken_to, expected YYYY-MM-DD")
+			return
+		}
+		// The range includes the whole of the last day
+		t = t.AddDate(0, 0, 1)
+		filters.TakenTo = &t
 	}
+	filters.Camera = c.Query("camera")
+	filters.HasLocation = c.Query("has_location") == "true"
 
-	files, total, err := fc.fileService.GetUserFiles(user.ID, page, limit, filters)
+	// Custom metadata filters: meta.<key>=value or meta.<key>.<op>=value
+	for name, values := range c.Request.URL.Query() {
+		if strings.HasPrefix(name, "meta.") && len(values) > 0 {
+			filters.Metadata[strings.TrimPrefix(name, "meta.")] = values[0]
+		}
+	}
+
+	files, total, err := fc.fileService.GetUserFiles(c.Request.Context(), user.ID, page, limit, filters)
 	if err != nil {
+		if errors.Is(err, services.ErrInvalidMetadata) {
+			utils.BadRequestResponse(c, err.Error())
+			return
+		}
 		utils.InternalServerErrorResponse(c, "Failed to get files")
 		return
 	}
@@ -71,9 +251,9 @@ func (fc *FileController) GetFile(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(fileID)
-	file, err := fc.fileService.GetUserFile(user.ID, objID)
+	file, err := fc.fileService.GetUserFile(c.Request.Context(), user.ID, objID)
 	if err != nil {
-		utils.NotFoundResponse(c, "File not found")
+		utils.ServiceErrorResponse(c, err, "Failed to get file")
 		return
 	}
 
@@ -103,27 +283,87 @@ func (fc *FileController) Upload(c *gin.Context) {
 	}
 
 	// Check user limits
-	plan, err := fc.fileService.GetUserPlan(user.ID)
+	plan, err := fc.fileService.GetUserPlan(c.Request.Context(), user.ID)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get user plan")
 		return
 	}
 
 	if err := fc.fileService.CheckUploadLimits(user, plan, fileHeader.Size); err != nil {
-		utils.ForbiddenResponse(c, err.Error())
+		utils.ServiceErrorResponse(c, err, "Failed to check upload limits")
 		return
 	}
 
 	// Upload file
-	file, err := fc.fileService.UploadFile(user.ID, fileHeader, &req)
+	file, err := fc.fileService.UploadFile(c.Request.Context(), user.ID, fileHeader, &req)
+	if respondAccountState(c, err) || respondFileTypeMismatch(c, err) || respondFileTypeBlocked(c, err) || respondInvalidStorageClass(c, err) {
+		return
+	}
 	if err != nil {
-		utils.InternalServerErrorResponse(c, "Failed to upload file")
+		utils.ServiceErrorResponse(c, err, "Failed to upload file")
 		return
 	}
 
 	utils.FileUploadResponse(c, "File uploaded successfully", file, "")
 }
 
+// UploadPreflight reports whether an upload would be accepted before its
+// content is sent
+func (fc *FileController) UploadPreflight(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	req, ok := utils.BoundRequest[models.UploadPreflightRequest](c)
+	if !ok {
+		return
+	}
+
+	result, err := fc.fileService.PreflightUpload(c.Request.Context(), user, req)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to check upload")
+		return
+	}
+
+	utils.SuccessResponse(c, "Upload checked successfully", result)
+}
+
+// InstantUpload creates a file from content the server already stores,
+// without the client sending it
+func (fc *FileController) InstantUpload(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	req, ok := utils.BoundRequest[models.InstantUploadRequest](c)
+	if !ok {
+		return
+	}
+
+	result, err := fc.fileService.InstantUpload(c.Request.Context(), user, req)
+	switch {
+	case errors.Is(err, services.ErrUploadChallengeInvalid):
+		utils.BadRequestResponse(c, "Upload challenge not found or expired")
+		return
+	case errors.Is(err, services.ErrUploadProofMismatch):
+		utils.ForbiddenResponse(c, "Upload proof does not match the content")
+		return
+	case err != nil:
+		utils.InternalServerErrorResponse(c, "Failed to upload file")
+		return
+	}
+
+	if result.Status == models.InstantUploadCreated {
+		utils.CreatedResponse(c, "File uploaded successfully", result)
+		return
+	}
+	utils.SuccessResponse(c, "Upload checked successfully", result)
+}
+
 // ChunkUpload handles chunked file upload
 func (fc *FileController) ChunkUpload(c *gin.Context) {
 	user, exists := utils.GetUserFromContext(c)
@@ -149,7 +389,11 @@ func (fc *FileController) ChunkUpload(c *gin.Context) {
 		return
 	}
 
-	result, err := fc.fileService.UploadChunk(user.ID, req.UploadID, req.ChunkNumber, req.TotalChunks, chunk)
+	result, err := fc.fileService.UploadChunk(c.Request.Context(), user.ID, req.UploadID, req.ChunkNumber, req.TotalChunks, chunk)
+	if errors.Is(err, services.ErrInvalidUploadID) {
+		utils.BadRequestResponse(c, "Invalid upload ID")
+		return
+	}
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to upload chunk")
 		return
@@ -166,14 +410,8 @@ func (fc *FileController) CompleteChunkUpload(c *gin.Context) {
 		return
 	}
 
-	var req struct {
-		UploadID string `json:"upload_id" validate:"required"`
-		FileName string `json:"file_name" validate:"required"`
-		FolderID string `json:"folder_id"`
-	}
-
-	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request data")
+	req, ok := utils.BoundRequest[models.CompleteUploadRequest](c)
+	if !ok {
 		return
 	}
 
@@ -200,21 +438,17 @@ func (fc *FileController) UpdateFile(c *gin.Context) {
 		return
 	}
 
-	var req struct {
-		Name        string            `json:"name"`
-		Description string            `json:"description"`
-		Tags        []string          `json:"tags"`
-		Metadata    map[string]string `json:"metadata"`
-	}
-
-	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request data")
+	req, ok := utils.BoundRequest[models.FileUpdateRequest](c)
+	if !ok {
 		return
 	}
 
 	objID, _ := utils.StringToObjectID(fileID)
-	file, err := fc.fileService.UpdateFile(user.ID, objID, &req)
+	file, err := fc.fileService.UpdateFile(c.Request.Context(), user.ID, objID, req)
 	if err != nil {
+		if respondFileLocked(c, err) {
+			return
+		}
 		utils.InternalServerErrorResponse(c, "Failed to update file")
 		return
 	}
@@ -237,8 +471,11 @@ func (fc *FileController) DeleteFile(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(fileID)
-	err := fc.fileService.DeleteFile(user.ID, objID, false) // Soft delete
+	err := fc.fileService.DeleteFile(c.Request.Context(), user.ID, objID, false) // Soft delete
 	if err != nil {
+		if respondFileLocked(c, err) {
+			return
+		}
 		utils.InternalServerErrorResponse(c, "Failed to delete file")
 		return
 	}
@@ -260,14 +497,45 @@ func (fc *FileController) RestoreFile(c *gin.Context) {
 		return
 	}
 
+	req, ok := utils.BoundRequest[models.RestoreRequest](c)
+	if !ok {
+		return
+	}
+
 	objID, _ := utils.StringToObjectID(fileID)
-	err := fc.fileService.RestoreFile(user.ID, objID)
+	file, err := fc.fileService.RestoreFile(c.Request.Context(), user.ID, objID, req)
 	if err != nil {
-		utils.InternalServerErrorResponse(c, "Failed to restore file")
+		switch {
+		case errors.Is(err, services.ErrNotInTrash):
+			utils.NotFoundResponse(c, "File not found in trash")
+		case errors.Is(err, services.ErrRestoreTarget):
+			utils.BadRequestResponse(c, err.Error())
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to restore file")
+		}
 		return
 	}
 
-	utils.SuccessResponse(c, "File restored successfully", nil)
+	utils.SuccessResponse(c, "File restored successfully", file)
+}
+
+// GetDeletedFiles returns the files in the trash
+func (fc *FileController) GetDeletedFiles(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	page, limit := utils.GetPagination(c, 20, 0)
+
+	files, total, err := fc.fileService.GetDeletedFiles(c.Request.Context(), user.ID, page, limit)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get deleted files")
+		return
+	}
+
+	utils.PaginatedResponse(c, "Deleted files retrieved successfully", files, page, limit, total)
 }
 
 // PermanentDelete permanently deletes a file
@@ -285,7 +553,7 @@ func (fc *FileController) PermanentDelete(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(fileID)
-	err := fc.fileService.DeleteFile(user.ID, objID, true) // Permanent delete
+	err := fc.fileService.DeleteFile(c.Request.Context(), user.ID, objID, true) // Permanent delete
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to permanently delete file")
 		return
@@ -309,15 +577,32 @@ func (fc *FileController) Download(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(fileID)
-	downloadURL, err := fc.fileService.GetDownloadURL(user.ID, objID)
+	file, err := fc.fileService.GetUserFile(c.Request.Context(), user.ID, objID)
+	if err != nil {
+		utils.ServiceErrorResponse(c, err, "Failed to get file")
+		return
+	}
+
+	downloadURL, err := fc.fileService.GetDownloadURL(c.Request.Context(), user.ID, objID, models.ReceiptDownloader{
+		Type:      "user",
+		UserID:    user.ID.Hex(),
+		Email:     user.Email,
+		IPAddress: c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	})
+	if respondFileExpired(c, err) || respondFileArchived(c, err) || respondReceiptUnavailable(c, err) {
+		return
+	}
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to generate download URL")
 		return
 	}
 
 	// Increment download counter
-	fc.fileService.IncrementDownloadCount(objID)
+	fc.fileService.IncrementDownloadCount(c.Request.Context(), objID)
+	fc.analyticsService.TrackFileActivity(user.ID, objID, "download", file.Size, utils.LocateClient(c.ClientIP()))
 
+	utils.NoStore(c.Writer)
 	c.Redirect(http.StatusFound, downloadURL)
 }
 
@@ -336,7 +621,10 @@ func (fc *FileController) Stream(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(fileID)
-	err := fc.fileService.StreamFile(user.ID, objID, c.Writer, c.Request)
+	err := fc.fileService.StreamFile(c.Request.Context(), user.ID, objID, c.Writer, c.Request)
+	if respondFileExpired(c, err) || respondFileArchived(c, err) {
+		return
+	}
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to stream file")
 		return
@@ -358,7 +646,18 @@ func (fc *FileController) Preview(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(fileID)
-	previewURL, err := fc.fileService.GeneratePreview(user.ID, objID)
+	file, err := fc.fileService.GetUserFile(c.Request.Context(), user.ID, objID)
+	if err != nil {
+		utils.ServiceErrorResponse(c, err, "Failed to get file")
+		return
+	}
+
+	// The preview is served through this server, so it only changes with the file
+	if utils.CheckNotModified(c.Writer, c.Request, utils.FileETag(file, "preview"), file.UpdatedAt) {
+		return
+	}
+
+	previewURL, err := fc.fileService.GeneratePreview(c.Request.Context(), user.ID, objID)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to generate preview")
 		return
@@ -369,6 +668,47 @@ func (fc *FileController) Preview(c *gin.Context) {
 	})
 }
 
+// TextPreview returns the start of a text or code file, decoded, with its
+// language for highlighting, so it can be shown without downloading it
+func (fc *FileController) TextPreview(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	fileID := c.Param("id")
+	if !utils.IsValidObjectID(fileID) {
+		utils.BadRequestResponse(c, "Invalid file ID")
+		return
+	}
+
+	kb, _ := strconv.Atoi(c.DefaultQuery("kb", strconv.Itoa(services.DefaultTextPreviewKB)))
+	if kb < 1 || kb > services.MaxTextPreviewKB {
+		utils.BadRequestResponse(c, "kb must be between 1 and "+strconv.Itoa(services.MaxTextPreviewKB))
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(fileID)
+	preview, err := fc.textService.GetTextPreview(c.Request.Context(), user.ID, objID, kb)
+	if respondFileExpired(c, err) || respondFileArchived(c, err) {
+		return
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrTextPreviewTooLarge):
+			utils.ErrorResponseWithCode(c, http.StatusRequestEntityTooLarge, utils.ErrorCodePayloadTooLarge, err.Error(), nil)
+		case errors.Is(err, services.ErrPreviewUnavailable):
+			utils.ErrorResponse(c, http.StatusUnprocessableEntity, err.Error(), nil)
+		default:
+			utils.ServiceErrorResponse(c, err, "Failed to preview file")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Preview generated successfully", preview)
+}
+
 // GetThumbnail returns file thumbnail
 func (fc *FileController) GetThumbnail(c *gin.Context) {
 	user, exists := utils.GetUserFromContext(c)
@@ -384,12 +724,26 @@ func (fc *FileController) GetThumbnail(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(fileID)
-	thumbnailURL, err := fc.fileService.GetThumbnail(user.ID, objID)
+	file, err := fc.fileService.GetUserFile(c.Request.Context(), user.ID, objID)
+	if err != nil {
+		utils.ServiceErrorResponse(c, err, "Failed to get file")
+		return
+	}
+
+	// Thumbnails this server serves can be revalidated; presigned ones expire
+	if utils.IsLocalURL(file.ThumbnailURL) && utils.CheckNotModified(c.Writer, c.Request, utils.FileETag(file, "thumb"), file.UpdatedAt) {
+		return
+	}
+
+	thumbnailURL, err := fc.fileService.GetThumbnail(c.Request.Context(), user.ID, objID)
 	if err != nil {
 		utils.NotFoundResponse(c, "Thumbnail not found")
 		return
 	}
 
+	if !utils.IsLocalURL(thumbnailURL) {
+		utils.NoStore(c.Writer)
+	}
 	c.Redirect(http.StatusFound, thumbnailURL)
 }
 
@@ -408,7 +762,7 @@ func (fc *FileController) GenerateThumbnail(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(fileID)
-	thumbnailURL, err := fc.fileService.GenerateThumbnail(user.ID, objID)
+	thumbnailURL, err := fc.fileService.GenerateThumbnail(c.Request.Context(), user.ID, objID)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to generate thumbnail")
 		return
@@ -433,14 +787,16 @@ func (fc *FileController) CreateShare(c *gin.Context) {
 		return
 	}
 
-	var req models.ShareRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request data")
+	req, ok := utils.BoundRequest[models.ShareRequest](c)
+	if !ok {
 		return
 	}
 
 	objID, _ := utils.StringToObjectID(fileID)
-	share, err := fc.fileService.CreateShare(user.ID, objID, &req)
+	share, err := fc.fileService.CreateShare(c.Request.Context(), user.ID, objID, req)
+	if respondContentTakenDown(c, err) || respondFileExpired(c, err) {
+		return
+	}
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to create share")
 		return
@@ -463,7 +819,7 @@ func (fc *FileController) GetShare(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(fileID)
-	share, err := fc.fileService.GetShare(user.ID, objID)
+	share, err := fc.fileService.GetShare(c.Request.Context(), user.ID, objID)
 	if err != nil {
 		utils.NotFoundResponse(c, "Share not found")
 		return
@@ -485,14 +841,13 @@ func (fc *FileController) UpdateShare(c *gin.Context) {
 		return
 	}
 
-	var req models.ShareRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request data")
+	req, ok := utils.BoundRequest[models.ShareRequest](c)
+	if !ok {
 		return
 	}
 
 	objID, _ := utils.StringToObjectID(fileID)
-	share, err := fc.fileService.UpdateShare(user.ID, objID, &req)
+	share, err := fc.fileService.UpdateShare(c.Request.Context(), user.ID, objID, req)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to update share")
 		return
@@ -515,7 +870,7 @@ func (fc *FileController) DeleteShare(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(fileID)
-	err := fc.fileService.DeleteShare(user.ID, objID)
+	err := fc.fileService.DeleteShare(c.Request.Context(), user.ID, objID)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to delete share")
 		return
@@ -538,7 +893,7 @@ func (fc *FileController) GetShareURL(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(fileID)
-	shareURL, err := fc.fileService.GetShareURL(user.ID, objID)
+	shareURL, err := fc.fileService.GetShareURL(c.Request.Context(), user.ID, objID)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get share URL")
 		return
@@ -549,8 +904,9 @@ func (fc *FileController) GetShareURL(c *gin.Context) {
 	})
 }
 
-// File operations
-func (fc *FileController) CopyFile(c *gin.Context) {
+// RescanFile scans a shared file for viruses again, for owners whose share
+// link is blocked by a failed scan or a false positive
+func (fc *FileController) RescanFile(c *gin.Context) {
 	user, exists := utils.GetUserFromContext(c)
 	if !exists {
 		utils.UnauthorizedResponse(c, "User not found in context")
@@ -563,18 +919,43 @@ func (fc *FileController) CopyFile(c *gin.Context) {
 		return
 	}
 
-	var req struct {
-		DestFolderID string `json:"dest_folder_id"`
-		NewName      string `json:"new_name"`
+	objID, _ := utils.StringToObjectID(fileID)
+	scan, err := fc.fileService.RescanFile(c.Request.Context(), user.ID, objID)
+	switch {
+	case errors.Is(err, services.ErrVirusScanDisabled):
+		utils.ServiceUnavailableResponse(c, "Virus scanning is not enabled")
+	case errors.Is(err, services.ErrVirusScanRunning):
+		utils.ErrorResponse(c, http.StatusConflict, "The file is already being scanned", map[string]interface{}{
+			"scan": scan,
+		})
+	case err != nil:
+		utils.NotFoundResponse(c, "File not found")
+	default:
+		utils.SuccessResponse(c, "File scan started", scan)
 	}
+}
 
-	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request data")
+// File operations
+func (fc *FileController) CopyFile(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	fileID := c.Param("id")
+	if !utils.IsValidObjectID(fileID) {
+		utils.BadRequestResponse(c, "Invalid file ID")
+		return
+	}
+
+	req, ok := utils.BoundRequest[models.FileCopyRequest](c)
+	if !ok {
 		return
 	}
 
 	objID, _ := utils.StringToObjectID(fileID)
-	newFile, err := fc.fileService.CopyFile(user.ID, objID, req.DestFolderID, req.NewName)
+	newFile, err := fc.fileService.CopyFile(c.Request.Context(), user.ID, objID, req.DestFolderID, req.NewName)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to copy file")
 		return
@@ -596,18 +977,17 @@ func (fc *FileController) MoveFile(c *gin.Context) {
 		return
 	}
 
-	var req struct {
-		DestFolderID string `json:"dest_folder_id"`
-	}
-
-	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request data")
+	req, ok := utils.BoundRequest[models.FileMoveRequest](c)
+	if !ok {
 		return
 	}
 
 	objID, _ := utils.StringToObjectID(fileID)
-	err := fc.fileService.MoveFile(user.ID, objID, req.DestFolderID)
+	err := fc.fileService.MoveFile(c.Request.Context(), user.ID, objID, req.DestFolderID)
 	if err != nil {
+		if respondFileLocked(c, err) {
+			return
+		}
 		utils.InternalServerErrorResponse(c, "Failed to move file")
 		return
 	}
@@ -629,7 +1009,7 @@ func (fc *FileController) AddToFavorites(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(fileID)
-	err := fc.fileService.ToggleFavorite(user.ID, objID, true)
+	err := fc.fileService.ToggleFavorite(c.Request.Context(), user.ID, objID, true)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to add to favorites")
 		return
@@ -652,7 +1032,7 @@ func (fc *FileController) RemoveFromFavorites(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(fileID)
-	err := fc.fileService.ToggleFavorite(user.ID, objID, false)
+	err := fc.fileService.ToggleFavorite(c.Request.Context(), user.ID, objID, false)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to remove from favorites")
 		return
@@ -674,17 +1054,13 @@ func (fc *FileController) UpdateTags(c *gin.Context) {
 		return
 	}
 
-	var req struct {
-		Tags []string `json:"tags"`
-	}
-
-	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request data")
+	req, ok := utils.BoundRequest[models.TagsRequest](c)
+	if !ok {
 		return
 	}
 
 	objID, _ := utils.StringToObjectID(fileID)
-	err := fc.fileService.UpdateTags(user.ID, objID, req.Tags)
+	err := fc.fileService.UpdateTags(c.Request.Context(), user.ID, objID, req.Tags)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to update tags")
 		return
@@ -708,7 +1084,7 @@ func (fc *FileController) GetVersions(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(fileID)
-	versions, err := fc.fileService.GetFileVersions(user.ID, objID)
+	versions, err := fc.fileService.GetFileVersions(c.Request.Context(), user.ID, objID)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get file versions")
 		return
@@ -737,8 +1113,11 @@ func (fc *FileController) CreateVersion(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(fileID)
-	version, err := fc.fileService.CreateFileVersion(user.ID, objID, fileHeader)
+	version, err := fc.fileService.CreateFileVersion(c.Request.Context(), user.ID, objID, fileHeader)
 	if err != nil {
+		if respondFileLocked(c, err) || respondFileTypeMismatch(c, err) || respondFileTypeBlocked(c, err) {
+			return
+		}
 		utils.InternalServerErrorResponse(c, "Failed to create file version")
 		return
 	}
@@ -768,7 +1147,7 @@ func (fc *FileController) GetVersion(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(fileID)
-	fileVersion, err := fc.fileService.GetFileVersion(user.ID, objID, version)
+	fileVersion, err := fc.fileService.GetFileVersion(c.Request.Context(), user.ID, objID, version)
 	if err != nil {
 		utils.NotFoundResponse(c, "File version not found")
 		return
@@ -799,8 +1178,11 @@ func (fc *FileController) RestoreVersion(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(fileID)
-	err = fc.fileService.RestoreFileVersion(user.ID, objID, version)
+	err = fc.fileService.RestoreFileVersion(c.Request.Context(), user.ID, objID, version)
 	if err != nil {
+		if respondFileLocked(c, err) {
+			return
+		}
 		utils.InternalServerErrorResponse(c, "Failed to restore file version")
 		return
 	}
@@ -830,8 +1212,11 @@ func (fc *FileController) DeleteVersion(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(fileID)
-	err = fc.fileService.DeleteFileVersion(user.ID, objID, version)
+	err = fc.fileService.DeleteFileVersion(c.Request.Context(), user.ID, objID, version)
 	if err != nil {
+		if respondFileLocked(c, err) {
+			return
+		}
 		utils.InternalServerErrorResponse(c, "Failed to delete file version")
 		return
 	}
@@ -839,57 +1224,343 @@ func (fc *FileController) DeleteVersion(c *gin.Context) {
 	utils.SuccessResponse(c, "File version deleted successfully", nil)
 }
 
-// Bulk operations
-func (fc *FileController) BulkDelete(c *gin.Context) {
+// GetLock returns the current edit lock on a file
+func (fc *FileController) GetLock(c *gin.Context) {
 	user, exists := utils.GetUserFromContext(c)
 	if !exists {
 		utils.UnauthorizedResponse(c, "User not found in context")
 		return
 	}
 
-	var req struct {
-		FileIDs []string `json:"file_ids" validate:"required"`
+	fileID := c.Param("id")
+	if !utils.IsValidObjectID(fileID) {
+		utils.BadRequestResponse(c, "Invalid file ID")
+		return
 	}
 
-	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request data")
+	objID, _ := utils.StringToObjectID(fileID)
+	lock, err := fc.fileLockService.GetLock(c.Request.Context(), user.ID, objID)
+	if err != nil {
+		utils.NotFoundResponse(c, "File not found")
 		return
 	}
 
-	// Validate all file IDs
-	var objIDs []primitive.ObjectID
-	for _, id := range req.FileIDs {
-		if !utils.IsValidObjectID(id) {
-			utils.BadRequestResponse(c, "Invalid file ID: "+id)
+	utils.SuccessResponse(c, "File lock retrieved successfully", gin.H{
+		"locked": lock != nil,
+		"lock":   lock,
+	})
+}
+
+// LockFile takes or refreshes an exclusive edit lock on a file
+func (fc *FileController) LockFile(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	fileID := c.Param("id")
+	if !utils.IsValidObjectID(fileID) {
+		utils.BadRequestResponse(c, "Invalid file ID")
+		return
+	}
+
+	var req models.FileLockRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.BadRequestResponse(c, "Invalid request data")
 			return
 		}
-		objID, _ := utils.StringToObjectID(id)
-		objIDs = append(objIDs, objID)
 	}
 
-	results, err := fc.fileService.BulkDeleteFiles(user.ID, objIDs)
+	objID, _ := utils.StringToObjectID(fileID)
+	lock, err := fc.fileLockService.LockFile(c.Request.Context(), user.ID, objID, &req)
 	if err != nil {
-		utils.InternalServerErrorResponse(c, "Failed to delete files")
+		if respondFileLocked(c, err) {
+			return
+		}
+		utils.BadRequestResponse(c, err.Error())
 		return
 	}
 
-	utils.SuccessResponse(c, "Bulk delete completed", results)
+	fc.auditService.Record(c.Request.Context(), &models.AuditLog{
+		ActorType:    "user",
+		ActorID:      &user.ID,
+		Action:       "file.locked",
+		ResourceType: "file",
+		ResourceID:   objID.Hex(),
+		Outcome:      "success",
+		IPAddress:    c.ClientIP(),
+		UserAgent:    c.Request.UserAgent(),
+		Details:      map[string]interface{}{"expires_at": lock.ExpiresAt},
+	})
+
+	utils.SuccessResponse(c, "File locked successfully", gin.H{
+		"lock":  lock,
+		"token": lock.Token,
+	})
 }
 
-func (fc *FileController) BulkMove(c *gin.Context) {
+// UnlockFile releases the caller's edit lock; ?force=true lets the file's
+// owner release a lock held by someone else
+func (fc *FileController) UnlockFile(c *gin.Context) {
 	user, exists := utils.GetUserFromContext(c)
 	if !exists {
 		utils.UnauthorizedResponse(c, "User not found in context")
 		return
 	}
 
-	var req struct {
-		FileIDs      []string `json:"file_ids" validate:"required"`
-		DestFolderID string   `json:"dest_folder_id"`
+	fileID := c.Param("id")
+	if !utils.IsValidObjectID(fileID) {
+		utils.BadRequestResponse(c, "Invalid file ID")
+		return
+	}
+
+	force := c.Query("force") == "true"
+
+	objID, _ := utils.StringToObjectID(fileID)
+	lock, err := fc.fileLockService.UnlockFile(c.Request.Context(), user.ID, objID, force)
+	if err != nil {
+		if respondFileLocked(c, err) {
+			return
+		}
+		utils.NotFoundResponse(c, "File not found")
+		return
+	}
+
+	if lock != nil {
+		fc.auditService.Record(c.Request.Context(), &models.AuditLog{
+			ActorType:    "user",
+			ActorID:      &user.ID,
+			Action:       "file.unlocked",
+			ResourceType: "file",
+			ResourceID:   objID.Hex(),
+			Outcome:      "success",
+			IPAddress:    c.ClientIP(),
+			UserAgent:    c.Request.UserAgent(),
+			Details:      map[string]interface{}{"locked_by": lock.LockedBy, "forced": lock.LockedBy != user.ID},
+		})
+	}
+
+	utils.SuccessResponse(c, "File unlocked successfully", nil)
+}
+
+// SetExpiry sets when a file expires, or extends its expiry
+func (fc *FileController) SetExpiry(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	fileID := c.Param("id")
+	if !utils.IsValidObjectID(fileID) {
+		utils.BadRequestResponse(c, "Invalid file ID")
+		return
+	}
+
+	req, ok := utils.BoundRequest[models.FileExpiryRequest](c)
+	if !ok {
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(fileID)
+	file, err := fc.expiryService.SetExpiry(c.Request.Context(), user.ID, objID, req)
+	if err != nil {
+		respondFileExpiryError(c, err, "Failed to set file expiry")
+		return
+	}
+
+	utils.SuccessResponse(c, "File expiry set successfully", file)
+}
+
+// ClearExpiry keeps a file until it is deleted
+func (fc *FileController) ClearExpiry(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	fileID := c.Param("id")
+	if !utils.IsValidObjectID(fileID) {
+		utils.BadRequestResponse(c, "Invalid file ID")
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(fileID)
+	file, err := fc.expiryService.ClearExpiry(c.Request.Context(), user.ID, objID)
+	if err != nil {
+		respondFileExpiryError(c, err, "Failed to clear file expiry")
+		return
+	}
+
+	utils.SuccessResponse(c, "File expiry cleared successfully", file)
+}
+
+func respondFileExpiryError(c *gin.Context, err error, message string) {
+	if respondFileLocked(c, err) {
+		return
+	}
+
+	switch {
+	case errors.Is(err, services.ErrInvalidFileExpiry):
+		utils.BadRequestResponse(c, err.Error())
+	default:
+		utils.ServiceErrorResponse(c, err, message)
+	}
+}
+
+// GetStorageClasses lists the storage classes uploads can choose
+func (fc *FileController) GetStorageClasses(c *gin.Context) {
+	classes, err := fc.classService.GetStorageClasses(c.Request.Context())
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get storage classes")
+		return
+	}
+
+	utils.SuccessResponse(c, "Storage classes retrieved successfully", classes)
+}
+
+// SetStorageClass moves a file to another storage class
+func (fc *FileController) SetStorageClass(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	fileID := c.Param("id")
+	if !utils.IsValidObjectID(fileID) {
+		utils.BadRequestResponse(c, "Invalid file ID")
+		return
+	}
+
+	req, ok := utils.BoundRequest[models.FileStorageClassRequest](c)
+	if !ok {
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(fileID)
+	file, err := fc.classService.ChangeStorageClass(c.Request.Context(), user.ID, objID, req.StorageClass)
+	if err != nil {
+		respondStorageClassError(c, err, "Failed to change storage class")
+		return
+	}
+
+	utils.SuccessResponse(c, "Storage class changed successfully", file)
+}
+
+// RestoreArchived starts restoring a readable copy of an archived file
+func (fc *FileController) RestoreArchived(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
 	}
 
-	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request data")
+	fileID := c.Param("id")
+	if !utils.IsValidObjectID(fileID) {
+		utils.BadRequestResponse(c, "Invalid file ID")
+		return
+	}
+
+	req, ok := utils.BoundRequest[models.FileRestoreRequest](c)
+	if !ok {
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(fileID)
+	file, err := fc.classService.RestoreFile(c.Request.Context(), user.ID, objID, req)
+	if err != nil {
+		respondStorageClassError(c, err, "Failed to restore file")
+		return
+	}
+
+	utils.SuccessResponse(c, "File restore started successfully", file)
+}
+
+// GetArchiveRestore reports whether an archived file's restored copy is
+// ready to download
+func (fc *FileController) GetArchiveRestore(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	fileID := c.Param("id")
+	if !utils.IsValidObjectID(fileID) {
+		utils.BadRequestResponse(c, "Invalid file ID")
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(fileID)
+	file, err := fc.classService.GetRestore(c.Request.Context(), user.ID, objID)
+	if err != nil {
+		respondStorageClassError(c, err, "Failed to get file restore")
+		return
+	}
+
+	utils.SuccessResponse(c, "File restore retrieved successfully", gin.H{
+		"file_id":       file.ID,
+		"storage_class": file.StorageClass,
+		"restore":       file.Restore,
+		"downloadable":  services.FileDownloadable(file),
+	})
+}
+
+func respondStorageClassError(c *gin.Context, err error, message string) {
+	if respondFileLocked(c, err) || respondFileArchived(c, err) || respondInvalidStorageClass(c, err) {
+		return
+	}
+
+	switch {
+	case errors.Is(err, services.ErrFileNotArchived):
+		utils.BadRequestResponse(c, err.Error())
+	default:
+		utils.ServiceErrorResponse(c, err, message)
+	}
+}
+
+// BatchGet returns many files in one call, partitioned into found and missing
+func (fc *FileController) BatchGet(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	req, ok := utils.BoundRequest[models.BatchGetRequest](c)
+	if !ok {
+		return
+	}
+
+	objIDs := make([]primitive.ObjectID, 0, len(req.IDs))
+	for _, id := range req.IDs {
+		objID, _ := utils.StringToObjectID(id)
+		objIDs = append(objIDs, objID)
+	}
+
+	batch, err := fc.fileService.BatchGetFiles(c.Request.Context(), user.ID, objIDs)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get files")
+		return
+	}
+
+	utils.SuccessResponse(c, "Files retrieved successfully", batch)
+}
+
+// Bulk operations
+func (fc *FileController) BulkDelete(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	req, ok := utils.BoundRequest[models.BulkFilesRequest](c)
+	if !ok {
 		return
 	}
 
@@ -904,13 +1575,44 @@ func (fc *FileController) BulkMove(c *gin.Context) {
 		objIDs = append(objIDs, objID)
 	}
 
-	results, err := fc.fileService.BulkMoveFiles(user.ID, objIDs, req.DestFolderID)
-	if err != nil {
-		utils.InternalServerErrorResponse(c, "Failed to move files")
+	results, job, err := fc.bulkJobService.Run(c.Request.Context(), &models.BulkJob{
+		UserID:    user.ID,
+		Operation: models.BulkDeleteFiles,
+		ItemIDs:   objIDs,
+	})
+	respondBulkResult(c, results, job, err, "Failed to delete files", "Bulk delete completed")
+}
+
+func (fc *FileController) BulkMove(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
 		return
 	}
 
-	utils.SuccessResponse(c, "Bulk move completed", results)
+	req, ok := utils.BoundRequest[models.BulkFilesMoveRequest](c)
+	if !ok {
+		return
+	}
+
+	// Validate all file IDs
+	var objIDs []primitive.ObjectID
+	for _, id := range req.FileIDs {
+		if !utils.IsValidObjectID(id) {
+			utils.BadRequestResponse(c, "Invalid file ID: "+id)
+			return
+		}
+		objID, _ := utils.StringToObjectID(id)
+		objIDs = append(objIDs, objID)
+	}
+
+	results, job, err := fc.bulkJobService.Run(c.Request.Context(), &models.BulkJob{
+		UserID:    user.ID,
+		Operation: models.BulkMoveFiles,
+		ItemIDs:   objIDs,
+		DestID:    req.DestFolderID,
+	})
+	respondBulkResult(c, results, job, err, "Failed to move files", "Bulk move completed")
 }
 
 func (fc *FileController) BulkCopy(c *gin.Context) {
@@ -920,13 +1622,8 @@ func (fc *FileController) BulkCopy(c *gin.Context) {
 		return
 	}
 
-	var req struct {
-		FileIDs      []string `json:"file_ids" validate:"required"`
-		DestFolderID string   `json:"dest_folder_id"`
-	}
-
-	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request data")
+	req, ok := utils.BoundRequest[models.BulkFilesMoveRequest](c)
+	if !ok {
 		return
 	}
 
@@ -941,13 +1638,13 @@ func (fc *FileController) BulkCopy(c *gin.Context) {
 		objIDs = append(objIDs, objID)
 	}
 
-	results, err := fc.fileService.BulkCopyFiles(user.ID, objIDs, req.DestFolderID)
-	if err != nil {
-		utils.InternalServerErrorResponse(c, "Failed to copy files")
-		return
-	}
-
-	utils.SuccessResponse(c, "Bulk copy completed", results)
+	results, job, err := fc.bulkJobService.Run(c.Request.Context(), &models.BulkJob{
+		UserID:    user.ID,
+		Operation: models.BulkCopyFiles,
+		ItemIDs:   objIDs,
+		DestID:    req.DestFolderID,
+	})
+	respondBulkResult(c, results, job, err, "Failed to copy files", "Bulk copy completed")
 }
 
 func (fc *FileController) BulkDownload(c *gin.Context) {
@@ -957,12 +1654,8 @@ func (fc *FileController) BulkDownload(c *gin.Context) {
 		return
 	}
 
-	var req struct {
-		FileIDs []string `json:"file_ids" validate:"required"`
-	}
-
-	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request data")
+	req, ok := utils.BoundRequest[models.BulkFilesRequest](c)
+	if !ok {
 		return
 	}
 
@@ -995,13 +1688,8 @@ func (fc *FileController) BulkShare(c *gin.Context) {
 		return
 	}
 
-	var req struct {
-		FileIDs   []string            `json:"file_ids" validate:"required"`
-		ShareData models.ShareRequest `json:"share_data"`
-	}
-
-	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request data")
+	req, ok := utils.BoundRequest[models.BulkFilesShareRequest](c)
+	if !ok {
 		return
 	}
 
@@ -1016,13 +1704,13 @@ func (fc *FileController) BulkShare(c *gin.Context) {
 		objIDs = append(objIDs, objID)
 	}
 
-	results, err := fc.fileService.BulkShareFiles(user.ID, objIDs, &req.ShareData)
-	if err != nil {
-		utils.InternalServerErrorResponse(c, "Failed to share files")
-		return
-	}
-
-	utils.SuccessResponse(c, "Bulk share completed", results)
+	results, job, err := fc.bulkJobService.Run(c.Request.Context(), &models.BulkJob{
+		UserID:    user.ID,
+		Operation: models.BulkShareFiles,
+		ItemIDs:   objIDs,
+		ShareData: &req.ShareData,
+	})
+	respondBulkResult(c, results, job, err, "Failed to share files", "Bulk share completed")
 }
 
 // Public file access (no authentication required)
@@ -1033,7 +1721,10 @@ func (fc *FileController) PublicDownload(c *gin.Context) {
 		return
 	}
 
-	downloadURL, err := fc.fileService.GetPublicDownloadURL(token)
+	downloadURL, err := fc.fileService.GetPublicDownloadURL(c.Request.Context(), token, anonymousDownloader(c, "public"))
+	if respondFileExpired(c, err) || respondFileArchived(c, err) || respondFileScanBlocked(c, err) || respondReceiptUnavailable(c, err) {
+		return
+	}
 	if err != nil {
 		utils.NotFoundResponse(c, "File not found or access denied")
 		return
@@ -1049,7 +1740,70 @@ func (fc *FileController) SharedDownload(c *gin.Context) {
 		return
 	}
 
-	downloadURL, err := fc.fileService.GetSharedDownloadURL(token)
+	// Browsers are shown a branded page whose button downloads the file
+	if wantsSharePage(c) {
+		share, file, err := fc.fileService.GetSharedFile(c.Request.Context(), token)
+		if respondFileExpired(c, err) {
+			return
+		}
+		if err != nil {
+			utils.NotFoundResponse(c, "File not found or access denied")
+			return
+		}
+
+		name := file.DisplayName
+		if name == "" {
+			name = file.OriginalName
+		}
+		page := gin.H{
+			"title":        name,
+			"name":         name,
+			"size":         utils.FormatFileSize(file.Size),
+			"share":        share,
+			"blocked":      file.Scan.Blocked(),
+			"infected":     file.Scan.Blocked() && file.Scan.Status == models.ScanStatusInfected,
+			"download_url": c.Request.URL.Path + "?download=1",
+			"watermark":    share.Watermark && services.Watermarkable(file),
+			"custom_html":  template.HTML(services.CurrentBranding().SharePageHTML),
+		}
+
+		// Markdown documents, such as READMEs, are shown rendered
+		if services.IsMarkdown(file) && !file.Scan.Blocked() {
+			if document, err := fc.markdownService.RenderShared(c.Request.Context(), token); err == nil {
+				page["document"] = template.HTML(document.HTML)
+			}
+		}
+
+		// View-only shares show page images in place of the download button
+		if share.ViewOnly && !file.Scan.Blocked() {
+			pages, err := fc.previewService.GetSharedPages(c.Request.Context(), token, shareRecipient(c))
+			if err == nil {
+				page["pages"] = pages.Pages
+			}
+			page["watermark"] = errors.Is(err, services.ErrShareRecipientRequired)
+		}
+
+		c.HTML(http.StatusOK, "shares/file.html", page)
+		return
+	}
+
+	if share, file, err := fc.fileService.GetSharedFile(c.Request.Context(), token); err == nil {
+		// View-only shares never serve the file itself
+		if share.ViewOnly {
+			utils.ForbiddenResponse(c, services.ErrShareViewOnly.Error())
+			return
+		}
+		// Watermarked shares serve a copy made for whoever downloads it
+		if share.Watermark && services.Watermarkable(file) {
+			fc.sharedWatermarkedDownload(c, share, file)
+			return
+		}
+	}
+
+	downloadURL, err := fc.fileService.GetSharedDownloadURL(c.Request.Context(), token, anonymousDownloader(c, "share"))
+	if respondFileExpired(c, err) || respondFileArchived(c, err) || respondFileScanBlocked(c, err) || respondReceiptUnavailable(c, err) {
+		return
+	}
 	if err != nil {
 		utils.NotFoundResponse(c, "File not found or access denied")
 		return
@@ -1058,6 +1812,45 @@ func (fc *FileController) SharedDownload(c *gin.Context) {
 	c.Redirect(http.StatusFound, downloadURL)
 }
 
+func (fc *FileController) sharedWatermarkedDownload(c *gin.Context, share *models.FileShare, file *models.File) {
+	downloader := anonymousDownloader(c, "share")
+	if user, exists := utils.GetUserFromContext(c); exists {
+		downloader.UserID = user.ID.Hex()
+	}
+
+	err := fc.watermarkService.ServeShared(c.Request.Context(), share, file, shareRecipient(c), downloader, c.Writer, c.Request)
+	if err == nil || respondFileScanBlocked(c, err) || respondFileArchived(c, err) || respondReceiptUnavailable(c, err) {
+		return
+	}
+
+	switch {
+	case errors.Is(err, services.ErrShareRecipientRequired):
+		utils.BadRequestResponse(c, err.Error())
+	case errors.Is(err, services.ErrShareWatermarkFailed):
+		utils.ErrorResponse(c, http.StatusUnprocessableEntity, err.Error(), nil)
+	default:
+		utils.InternalServerErrorResponse(c, "Failed to download file")
+	}
+}
+
+// shareRecipient returns the email of whoever opens a share link: the
+// signed-in user's, else the valid one they gave, else ""
+func shareRecipient(c *gin.Context) string {
+	if user, exists := utils.GetUserFromContext(c); exists {
+		return user.Email
+	}
+	if email := strings.ToLower(strings.TrimSpace(c.Query("email"))); utils.IsValidEmail(email) {
+		return email
+	}
+	return ""
+}
+
+// wantsSharePage reports whether a share link was opened in a browser rather
+// than by an API client or the share page's download button
+func wantsSharePage(c *gin.Context) bool {
+	return c.Query("download") == "" && strings.Contains(c.GetHeader("Accept"), "text/html")
+}
+
 func (fc *FileController) VerifySharePassword(c *gin.Context) {
 	token := c.Param("token")
 	if token == "" {
@@ -1065,16 +1858,12 @@ func (fc *FileController) VerifySharePassword(c *gin.Context) {
 		return
 	}
 
-	var req struct {
-		Password string `json:"password" validate:"required"`
-	}
-
-	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request data")
+	req, ok := utils.BoundRequest[models.SharePasswordRequest](c)
+	if !ok {
 		return
 	}
 
-	access, err := fc.fileService.VerifySharePassword(token, req.Password)
+	access, err := fc.fileService.VerifySharePassword(c.Request.Context(), token, req.Password)
 	if err != nil {
 		utils.UnauthorizedResponse(c, "Invalid password")
 		return
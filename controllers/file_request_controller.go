@@ -0,0 +1,146 @@
+package controllers
+
+import (
+	"errors"
+	"oncloud/models"
+	"oncloud/services"
+	"oncloud/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type FileRequestController struct {
+	fileRequestService *services.FileRequestService
+}
+
+func NewFileRequestController() *FileRequestController {
+	return &FileRequestController{
+		fileRequestService: services.NewFileRequestService(),
+	}
+}
+
+// GetRequest returns the folder's file request
+func (frc *FileRequestController) GetRequest(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	folderID := c.Param("id")
+	if !utils.IsValidObjectID(folderID) {
+		utils.BadRequestResponse(c, "Invalid folder ID")
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(folderID)
+	request, err := frc.fileRequestService.GetRequest(c.Request.Context(), user.ID, objID)
+	if err != nil {
+		respondFileRequestError(c, err, "Failed to get file request")
+		return
+	}
+
+	utils.SuccessResponse(c, "File request retrieved successfully", request)
+}
+
+// SaveRequest opens a file request on the folder, or changes its settings
+func (frc *FileRequestController) SaveRequest(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	folderID := c.Param("id")
+	if !utils.IsValidObjectID(folderID) {
+		utils.BadRequestResponse(c, "Invalid folder ID")
+		return
+	}
+
+	req, ok := utils.BoundRequest[models.FileRequestRequest](c)
+	if !ok {
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(folderID)
+	request, err := frc.fileRequestService.SaveRequest(c.Request.Context(), user.ID, objID, req)
+	if err != nil {
+		respondFileRequestError(c, err, "Failed to save file request")
+		return
+	}
+
+	utils.SuccessResponse(c, "File request saved successfully", request)
+}
+
+// DeleteRequest closes the folder's file request
+func (frc *FileRequestController) DeleteRequest(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	folderID := c.Param("id")
+	if !utils.IsValidObjectID(folderID) {
+		utils.BadRequestResponse(c, "Invalid folder ID")
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(folderID)
+	if err := frc.fileRequestService.DeleteRequest(c.Request.Context(), user.ID, objID); err != nil {
+		respondFileRequestError(c, err, "Failed to delete file request")
+		return
+	}
+
+	utils.SuccessResponse(c, "File request deleted successfully", nil)
+}
+
+// GetPage returns what a guest is shown before uploading
+func (frc *FileRequestController) GetPage(c *gin.Context) {
+	page, err := frc.fileRequestService.GetPage(c.Request.Context(), c.Param("token"))
+	if err != nil {
+		respondFileRequestError(c, err, "Failed to get file request")
+		return
+	}
+
+	utils.SuccessResponse(c, "File request retrieved successfully", page)
+}
+
+// Upload saves a guest's file into the file request's folder
+func (frc *FileRequestController) Upload(c *gin.Context) {
+	var form models.FileRequestUploadForm
+	if err := c.ShouldBind(&form); err != nil {
+		utils.BadRequestResponse(c, "Invalid form data")
+		return
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		utils.BadRequestResponse(c, "No file provided")
+		return
+	}
+
+	file, err := frc.fileRequestService.Upload(c.Request.Context(), c.Param("token"), fileHeader, &form, c.ClientIP())
+	if err != nil {
+		respondFileRequestError(c, err, "Failed to upload file")
+		return
+	}
+
+	utils.CreatedResponse(c, "File uploaded successfully", gin.H{
+		"name": file.Name,
+		"size": file.Size,
+	})
+}
+
+func respondFileRequestError(c *gin.Context, err error, message string) {
+	if respondAccountState(c, err) || respondFileTypeMismatch(c, err) || respondFileTypeBlocked(c, err) {
+		return
+	}
+
+	switch {
+	case errors.Is(err, services.ErrFileRequestGuest):
+		utils.BadRequestResponse(c, err.Error())
+	default:
+		utils.ServiceErrorResponse(c, err, message)
+	}
+}
@@ -0,0 +1,213 @@
+package controllers
+
+import (
+	"errors"
+	"oncloud/models"
+	"oncloud/services"
+	"oncloud/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type FileTypePolicyController struct {
+	policyService *services.FileTypePolicyService
+	auditService  *services.AuditService
+}
+
+func NewFileTypePolicyController() *FileTypePolicyController {
+	return &FileTypePolicyController{
+		policyService: services.NewFileTypePolicyService(),
+		auditService:  services.NewAuditService(),
+	}
+}
+
+// GetPolicies lists the file type policies
+func (ftpc *FileTypePolicyController) GetPolicies(c *gin.Context) {
+	policies, err := ftpc.policyService.ListPolicies(c.Request.Context())
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get file type policies")
+		return
+	}
+
+	utils.SuccessResponse(c, "File type policies retrieved successfully", policies)
+}
+
+// CreatePolicy adds a file type policy
+func (ftpc *FileTypePolicyController) CreatePolicy(c *gin.Context) {
+	admin, exists := utils.GetAdminFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "Admin not found")
+		return
+	}
+
+	req, ok := utils.BoundRequest[models.FileTypePolicyRequest](c)
+	if !ok {
+		return
+	}
+
+	policy, err := ftpc.policyService.CreatePolicy(c.Request.Context(), req, admin.ID)
+	if err != nil {
+		respondFileTypePolicyError(c, err, "Failed to create file type policy")
+		return
+	}
+
+	ftpc.audit(c, admin, "file_type_policy.created", "file_type_policy", policy.ID.Hex(), map[string]interface{}{
+		"name":  policy.Name,
+		"scope": policy.Scope,
+	})
+
+	utils.CreatedResponse(c, "File type policy created successfully", policy)
+}
+
+// UpdatePolicy replaces a file type policy
+func (ftpc *FileTypePolicyController) UpdatePolicy(c *gin.Context) {
+	admin, exists := utils.GetAdminFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "Admin not found")
+		return
+	}
+
+	policyID := c.Param("id")
+	if !utils.IsValidObjectID(policyID) {
+		utils.BadRequestResponse(c, "Invalid policy ID")
+		return
+	}
+
+	req, ok := utils.BoundRequest[models.FileTypePolicyRequest](c)
+	if !ok {
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(policyID)
+	policy, err := ftpc.policyService.UpdatePolicy(c.Request.Context(), objID, req, admin.ID)
+	if err != nil {
+		respondFileTypePolicyError(c, err, "Failed to update file type policy")
+		return
+	}
+
+	ftpc.audit(c, admin, "file_type_policy.updated", "file_type_policy", policyID, map[string]interface{}{
+		"name":       policy.Name,
+		"scope":      policy.Scope,
+		"is_enabled": policy.IsEnabled,
+	})
+
+	utils.SuccessResponse(c, "File type policy updated successfully", policy)
+}
+
+// DeletePolicy removes a file type policy
+func (ftpc *FileTypePolicyController) DeletePolicy(c *gin.Context) {
+	admin, exists := utils.GetAdminFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "Admin not found")
+		return
+	}
+
+	policyID := c.Param("id")
+	if !utils.IsValidObjectID(policyID) {
+		utils.BadRequestResponse(c, "Invalid policy ID")
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(policyID)
+	if err := ftpc.policyService.DeletePolicy(c.Request.Context(), objID); err != nil {
+		respondFileTypePolicyError(c, err, "Failed to delete file type policy")
+		return
+	}
+
+	ftpc.audit(c, admin, "file_type_policy.deleted", "file_type_policy", policyID, nil)
+
+	utils.SuccessResponse(c, "File type policy deleted successfully", nil)
+}
+
+// GetOverrides lists the users allowed past file type policies
+func (ftpc *FileTypePolicyController) GetOverrides(c *gin.Context) {
+	overrides, err := ftpc.policyService.ListOverrides(c.Request.Context())
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get file type overrides")
+		return
+	}
+
+	utils.SuccessResponse(c, "File type overrides retrieved successfully", overrides)
+}
+
+// SetOverride lets a trusted user upload file types the policies block
+func (ftpc *FileTypePolicyController) SetOverride(c *gin.Context) {
+	admin, exists := utils.GetAdminFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "Admin not found")
+		return
+	}
+
+	userID := c.Param("user_id")
+	if !utils.IsValidObjectID(userID) {
+		utils.BadRequestResponse(c, "Invalid user ID")
+		return
+	}
+
+	req, ok := utils.BoundRequest[models.FileTypeOverrideRequest](c)
+	if !ok {
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(userID)
+	override, err := ftpc.policyService.SetOverride(c.Request.Context(), objID, req, admin.ID)
+	if err != nil {
+		respondFileTypePolicyError(c, err, "Failed to save file type override")
+		return
+	}
+
+	ftpc.audit(c, admin, "file_type_override.granted", "user", userID, map[string]interface{}{
+		"reason":     override.Reason,
+		"expires_at": override.ExpiresAt,
+	})
+
+	utils.SuccessResponse(c, "File type override saved successfully", override)
+}
+
+// RemoveOverride subjects a user to the file type policies again
+func (ftpc *FileTypePolicyController) RemoveOverride(c *gin.Context) {
+	admin, exists := utils.GetAdminFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "Admin not found")
+		return
+	}
+
+	userID := c.Param("user_id")
+	if !utils.IsValidObjectID(userID) {
+		utils.BadRequestResponse(c, "Invalid user ID")
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(userID)
+	if err := ftpc.policyService.RemoveOverride(c.Request.Context(), objID); err != nil {
+		respondFileTypePolicyError(c, err, "Failed to delete file type override")
+		return
+	}
+
+	ftpc.audit(c, admin, "file_type_override.revoked", "user", userID, nil)
+
+	utils.SuccessResponse(c, "File type override deleted successfully", nil)
+}
+
+func (ftpc *FileTypePolicyController) audit(c *gin.Context, admin *models.Admin, action, resourceType, resourceID string, details map[string]interface{}) {
+	ftpc.auditService.Record(c.Request.Context(), &models.AuditLog{
+		ActorType:    "admin",
+		ActorID:      &admin.ID,
+		Action:       action,
+		ResourceType: resourceType,
+		ResourceID:   resourceID,
+		Outcome:      "success",
+		IPAddress:    c.ClientIP(),
+		UserAgent:    c.Request.UserAgent(),
+		Details:      details,
+	})
+}
+
+func respondFileTypePolicyError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrInvalidFileTypePolicy):
+		utils.BadRequestResponse(c, err.Error())
+	default:
+		utils.ServiceErrorResponse(c, err, message)
+	}
+}
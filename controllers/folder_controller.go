@@ -1,24 +1,34 @@
 package controllers
 
 import (
+	"errors"
+	"html/template"
+	"net/http"
 	"oncloud/models"
 	"oncloud/services"
 	"oncloud/utils"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type FolderController struct {
-	folderService *services.FolderService
-	fileService   *services.FileService
+	folderService      *services.FolderService
+	fileService        *services.FileService
+	folderUsageService *services.FolderUsageService
+	bulkJobService     *services.BulkJobService
+	manifestService    *services.FolderManifestService
 }
 
-func NewFolderController() *FolderController {
+func NewFolderController(deps services.Dependencies) *FolderController {
 	return &FolderController{
-		folderService: services.NewFolderService(),
-		fileService:   services.NewFileService(),
+		folderService:      services.NewFolderServiceWith(deps),
+		fileService:        services.NewFileServiceWith(deps),
+		folderUsageService: services.NewFolderUsageServiceWith(deps),
+		bulkJobService:     services.NewBulkJobServiceWith(deps),
+		manifestService:    services.NewFolderManifestServiceWith(deps),
 	}
 }
 
@@ -30,12 +40,11 @@ func (fc *FolderController) GetFolders(c *gin.Context) {
 		return
 	}
 
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	page, limit := utils.GetPagination(c, 50, 0)
 	parentID := c.Query("parent_id")
 	search := c.Query("search")
 
-	folders, total, err := fc.folderService.GetUserFolders(user.ID, parentID, search, page, limit)
+	folders, total, err := fc.folderService.GetUserFolders(c.Request.Context(), user.ID, parentID, search, page, limit)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get folders")
 		return
@@ -59,9 +68,9 @@ func (fc *FolderController) GetFolder(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(folderID)
-	folder, err := fc.folderService.GetUserFolder(user.ID, objID)
+	folder, err := fc.folderService.GetUserFolder(c.Request.Context(), user.ID, objID)
 	if err != nil {
-		utils.NotFoundResponse(c, "Folder not found")
+		utils.ServiceErrorResponse(c, err, "Failed to get folder")
 		return
 	}
 
@@ -76,18 +85,12 @@ func (fc *FolderController) CreateFolder(c *gin.Context) {
 		return
 	}
 
-	var req models.FolderCreateRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request data")
-		return
-	}
-
-	if err := utils.ValidateStruct(&req); err != nil {
-		utils.ValidationErrorResponse(c, err)
+	req, ok := utils.BoundRequest[models.FolderCreateRequest](c)
+	if !ok {
 		return
 	}
 
-	folder, err := fc.folderService.CreateFolder(user.ID, &req)
+	folder, err := fc.folderService.CreateFolder(c.Request.Context(), user.ID, req)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to create folder")
 		return
@@ -110,21 +113,13 @@ func (fc *FolderController) UpdateFolder(c *gin.Context) {
 		return
 	}
 
-	var req struct {
-		Name        string   `json:"name"`
-		Description string   `json:"description"`
-		Color       string   `json:"color"`
-		Icon        string   `json:"icon"`
-		Tags        []string `json:"tags"`
-	}
-
-	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request data")
+	req, ok := utils.BoundRequest[models.FolderUpdateRequest](c)
+	if !ok {
 		return
 	}
 
 	objID, _ := utils.StringToObjectID(folderID)
-	folder, err := fc.folderService.UpdateFolder(user.ID, objID, &req)
+	folder, err := fc.folderService.UpdateFolder(c.Request.Context(), user.ID, objID, req)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to update folder")
 		return
@@ -148,7 +143,7 @@ func (fc *FolderController) DeleteFolder(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(folderID)
-	err := fc.folderService.DeleteFolder(user.ID, objID, false) // Soft delete
+	err := fc.folderService.DeleteFolder(c.Request.Context(), user.ID, objID, false) // Soft delete
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to delete folder")
 		return
@@ -171,14 +166,26 @@ func (fc *FolderController) RestoreFolder(c *gin.Context) {
 		return
 	}
 
+	req, ok := utils.BoundRequest[models.RestoreRequest](c)
+	if !ok {
+		return
+	}
+
 	objID, _ := utils.StringToObjectID(folderID)
-	err := fc.folderService.RestoreFolder(user.ID, objID)
+	folder, err := fc.folderService.RestoreFolder(c.Request.Context(), user.ID, objID, req)
 	if err != nil {
-		utils.InternalServerErrorResponse(c, "Failed to restore folder")
+		switch {
+		case errors.Is(err, services.ErrNotInTrash):
+			utils.NotFoundResponse(c, "Folder not found in trash")
+		case errors.Is(err, services.ErrRestoreTarget):
+			utils.BadRequestResponse(c, err.Error())
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to restore folder")
+		}
 		return
 	}
 
-	utils.SuccessResponse(c, "Folder restored successfully", nil)
+	utils.SuccessResponse(c, "Folder restored successfully", folder)
 }
 
 // PermanentDelete permanently deletes a folder
@@ -196,7 +203,7 @@ func (fc *FolderController) PermanentDelete(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(folderID)
-	err := fc.folderService.DeleteFolder(user.ID, objID, true) // Permanent delete
+	err := fc.folderService.DeleteFolder(c.Request.Context(), user.ID, objID, true) // Permanent delete
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to permanently delete folder")
 		return
@@ -219,22 +226,25 @@ func (fc *FolderController) GetFolderContents(c *gin.Context) {
 		return
 	}
 
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	page, limit := utils.GetPagination(c, 50, 0)
 	sortBy := c.DefaultQuery("sort", "name")
 	sortOrder := c.DefaultQuery("order", "asc")
 
 	objID, _ := utils.StringToObjectID(folderID)
-	contents, err := fc.folderService.GetFolderContents(user.ID, objID, page, limit, sortBy, sortOrder)
+	contents, err := fc.folderService.GetFolderContents(c.Request.Context(), user.ID, objID, page, limit, sortBy, sortOrder)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get folder contents")
 		return
 	}
 
-	utils.SuccessResponse(c, "Folder contents retrieved successfully", contents)
+	// Subfolders are listed in full; the pagination is of the files
+	filesTotal, _ := contents["files_total"].(int)
+	utils.PaginatedResponse(c, "Folder contents retrieved successfully", contents, page, limit, filesTotal)
 }
 
-// GetFolderTree returns hierarchical folder tree
+// GetFolderTree returns hierarchical folder tree. depth limits how many
+// levels are returned (0 for all); page and limit page through the first
+// level and cap the subfolders listed at deeper levels.
 func (fc *FolderController) GetFolderTree(c *gin.Context) {
 	user, exists := utils.GetUserFromContext(c)
 	if !exists {
@@ -254,7 +264,14 @@ func (fc *FolderController) GetFolderTree(c *gin.Context) {
 		objID, _ = utils.StringToObjectID(folderID)
 	}
 
-	tree, err := fc.folderService.GetFolderTree(user.ID, objID)
+	depth, _ := strconv.Atoi(c.DefaultQuery("depth", "0"))
+	page, limit := utils.GetPagination(c, 50, 200)
+
+	if depth < 0 {
+		depth = 0
+	}
+
+	tree, err := fc.folderService.GetFolderTree(c.Request.Context(), user.ID, objID, depth, page, limit)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get folder tree")
 		return
@@ -278,7 +295,7 @@ func (fc *FolderController) GetBreadcrumb(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(folderID)
-	breadcrumb, err := fc.folderService.GetBreadcrumb(user.ID, objID)
+	breadcrumb, err := fc.folderService.GetBreadcrumb(c.Request.Context(), user.ID, objID)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get breadcrumb")
 		return
@@ -295,16 +312,16 @@ func (fc *FolderController) GetRootFolder(c *gin.Context) {
 		return
 	}
 
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	page, limit := utils.GetPagination(c, 50, 0)
 
-	contents, err := fc.folderService.GetRootFolderContents(user.ID, page, limit)
+	contents, err := fc.folderService.GetRootFolderContents(c.Request.Context(), user.ID, page, limit)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get root folder contents")
 		return
 	}
 
-	utils.SuccessResponse(c, "Root folder contents retrieved successfully", contents)
+	filesTotal, _ := contents["files_total"].(int)
+	utils.PaginatedResponse(c, "Root folder contents retrieved successfully", contents, page, limit, filesTotal)
 }
 
 // GetRecentFolders returns recently accessed folders
@@ -317,7 +334,7 @@ func (fc *FolderController) GetRecentFolders(c *gin.Context) {
 
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
 
-	folders, err := fc.folderService.GetRecentFolders(user.ID, limit)
+	folders, err := fc.folderService.GetRecentFolders(c.Request.Context(), user.ID, limit)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get recent folders")
 		return
@@ -334,10 +351,9 @@ func (fc *FolderController) GetFavoriteFolders(c *gin.Context) {
 		return
 	}
 
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	page, limit := utils.GetPagination(c, 20, 0)
 
-	folders, total, err := fc.folderService.GetFavoriteFolders(user.ID, page, limit)
+	folders, total, err := fc.folderService.GetFavoriteFolders(c.Request.Context(), user.ID, page, limit)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get favorite folders")
 		return
@@ -354,10 +370,9 @@ func (fc *FolderController) GetDeletedFolders(c *gin.Context) {
 		return
 	}
 
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	page, limit := utils.GetPagination(c, 20, 0)
 
-	folders, total, err := fc.folderService.GetDeletedFolders(user.ID, page, limit)
+	folders, total, err := fc.folderService.GetDeletedFolders(c.Request.Context(), user.ID, page, limit)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get deleted folders")
 		return
@@ -380,27 +395,49 @@ func (fc *FolderController) CopyFolder(c *gin.Context) {
 		return
 	}
 
-	var req struct {
-		DestParentID string `json:"dest_parent_id"`
-		NewName      string `json:"new_name"`
+	req, ok := utils.BoundRequest[models.FolderCopyRequest](c)
+	if !ok {
+		return
 	}
 
-	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request data")
+	objID, _ := utils.StringToObjectID(folderID)
+	newFolder, err := fc.folderService.CopyFolder(c.Request.Context(), user.ID, objID, req.DestParentID, req.NewName)
+	if err != nil {
+		utils.ServiceErrorResponse(c, err, "Failed to copy folder")
+		return
+	}
+
+	utils.CreatedResponse(c, "Folder copied successfully", newFolder)
+}
+
+// GetCopyJob reports the progress of filling a copied folder
+func (fc *FolderController) GetCopyJob(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	folderID := c.Param("id")
+	if !utils.IsValidObjectID(folderID) {
+		utils.BadRequestResponse(c, "Invalid folder ID")
 		return
 	}
 
 	objID, _ := utils.StringToObjectID(folderID)
-	newFolder, err := fc.folderService.CopyFolder(user.ID, objID, req.DestParentID, req.NewName)
+	job, err := fc.folderService.GetCopyJob(c.Request.Context(), user.ID, objID)
 	if err != nil {
-		utils.InternalServerErrorResponse(c, "Failed to copy folder")
+		utils.NotFoundResponse(c, "Folder copy not found")
 		return
 	}
 
-	utils.CreatedResponse(c, "Folder copied successfully", newFolder)
+	utils.SuccessResponse(c, "Folder copy retrieved successfully", job)
 }
 
-func (fc *FolderController) MoveFolder(c *gin.Context) {
+// ExportManifest lists everything in a folder tree with its size, hash and
+// modification date as CSV or JSON. The manifest of a big tree is exported
+// in the background, and the export is returned to be polled instead.
+func (fc *FolderController) ExportManifest(c *gin.Context) {
 	user, exists := utils.GetUserFromContext(c)
 	if !exists {
 		utils.UnauthorizedResponse(c, "User not found in context")
@@ -413,17 +450,137 @@ func (fc *FolderController) MoveFolder(c *gin.Context) {
 		return
 	}
 
-	var req struct {
-		DestParentID string `json:"dest_parent_id"`
+	format := c.DefaultQuery("format", models.FolderManifestCSV)
+	if format != models.FolderManifestCSV && format != models.FolderManifestJSON {
+		utils.BadRequestResponse(c, "Format must be csv or json")
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(folderID)
+	manifest, export, err := fc.manifestService.ExportManifest(c.Request.Context(), user.ID, objID, format)
+	if err != nil {
+		if errors.Is(err, services.ErrManifestFolderNotFound) {
+			utils.NotFoundResponse(c, "Folder not found")
+			return
+		}
+		utils.InternalServerErrorResponse(c, "Failed to export folder manifest")
+		return
+	}
+
+	if export != nil {
+		utils.CreatedResponse(c, "Manifest export started", export)
+		return
+	}
+
+	c.Header("Content-Disposition", utils.ContentDisposition("attachment", manifest.Name))
+	c.Data(http.StatusOK, manifest.ContentType, manifest.Content)
+}
+
+// GetManifestExports lists the recent manifest exports of a folder
+func (fc *FolderController) GetManifestExports(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	folderID := c.Param("id")
+	if !utils.IsValidObjectID(folderID) {
+		utils.BadRequestResponse(c, "Invalid folder ID")
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(folderID)
+	exports, err := fc.manifestService.ListExports(c.Request.Context(), user.ID, objID)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get manifest exports")
+		return
+	}
+
+	utils.SuccessResponse(c, "Manifest exports retrieved successfully", exports)
+}
+
+// GetManifestExport reports whether a manifest export is ready
+func (fc *FolderController) GetManifestExport(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	folderID, exportID, ok := manifestExportParams(c)
+	if !ok {
+		return
+	}
+
+	export, err := fc.manifestService.GetExport(c.Request.Context(), user.ID, folderID, exportID)
+	if err != nil {
+		utils.NotFoundResponse(c, "Manifest export not found")
+		return
+	}
+
+	utils.SuccessResponse(c, "Manifest export retrieved successfully", export)
+}
+
+// DownloadManifestExport sends the manifest of a completed export
+func (fc *FolderController) DownloadManifestExport(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	folderID, exportID, ok := manifestExportParams(c)
+	if !ok {
+		return
+	}
+
+	if err := fc.manifestService.ServeExport(c.Request.Context(), user.ID, folderID, exportID, c.Writer, c.Request); err != nil {
+		switch {
+		case errors.Is(err, services.ErrManifestExportNotFound):
+			utils.NotFoundResponse(c, "Manifest export not found")
+		case errors.Is(err, services.ErrManifestNotReady):
+			utils.ConflictResponse(c, "Manifest export has not completed")
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to download manifest")
+		}
+	}
+}
+
+func manifestExportParams(c *gin.Context) (primitive.ObjectID, primitive.ObjectID, bool) {
+	folderID, err := utils.StringToObjectID(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid folder ID")
+		return primitive.NilObjectID, primitive.NilObjectID, false
+	}
+	exportID, err := utils.StringToObjectID(c.Param("export_id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid export ID")
+		return primitive.NilObjectID, primitive.NilObjectID, false
+	}
+	return folderID, exportID, true
+}
+
+func (fc *FolderController) MoveFolder(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	folderID := c.Param("id")
+	if !utils.IsValidObjectID(folderID) {
+		utils.BadRequestResponse(c, "Invalid folder ID")
+		return
 	}
 
-	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request data")
+	req, ok := utils.BoundRequest[models.FolderMoveRequest](c)
+	if !ok {
 		return
 	}
 
 	objID, _ := utils.StringToObjectID(folderID)
-	err := fc.folderService.MoveFolder(user.ID, objID, req.DestParentID)
+	err := fc.folderService.MoveFolder(c.Request.Context(), user.ID, objID, req.DestParentID)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to move folder")
 		return
@@ -446,7 +603,7 @@ func (fc *FolderController) AddToFavorites(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(folderID)
-	err := fc.folderService.ToggleFavorite(user.ID, objID, true)
+	err := fc.folderService.ToggleFavorite(c.Request.Context(), user.ID, objID, true)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to add to favorites")
 		return
@@ -469,7 +626,7 @@ func (fc *FolderController) RemoveFromFavorites(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(folderID)
-	err := fc.folderService.ToggleFavorite(user.ID, objID, false)
+	err := fc.folderService.ToggleFavorite(c.Request.Context(), user.ID, objID, false)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to remove from favorites")
 		return
@@ -491,17 +648,13 @@ func (fc *FolderController) UpdateTags(c *gin.Context) {
 		return
 	}
 
-	var req struct {
-		Tags []string `json:"tags"`
-	}
-
-	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request data")
+	req, ok := utils.BoundRequest[models.TagsRequest](c)
+	if !ok {
 		return
 	}
 
 	objID, _ := utils.StringToObjectID(folderID)
-	err := fc.folderService.UpdateTags(user.ID, objID, req.Tags)
+	err := fc.folderService.UpdateTags(c.Request.Context(), user.ID, objID, req.Tags)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to update tags")
 		return
@@ -524,14 +677,16 @@ func (fc *FolderController) CreateShare(c *gin.Context) {
 		return
 	}
 
-	var req models.ShareRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request data")
+	req, ok := utils.BoundRequest[models.ShareRequest](c)
+	if !ok {
 		return
 	}
 
 	objID, _ := utils.StringToObjectID(folderID)
-	share, err := fc.folderService.CreateShare(user.ID, objID, &req)
+	share, err := fc.folderService.CreateShare(c.Request.Context(), user.ID, objID, req)
+	if respondContentTakenDown(c, err) {
+		return
+	}
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to create share")
 		return
@@ -554,7 +709,7 @@ func (fc *FolderController) GetShare(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(folderID)
-	share, err := fc.folderService.GetShare(user.ID, objID)
+	share, err := fc.folderService.GetShare(c.Request.Context(), user.ID, objID)
 	if err != nil {
 		utils.NotFoundResponse(c, "Share not found")
 		return
@@ -576,14 +731,13 @@ func (fc *FolderController) UpdateShare(c *gin.Context) {
 		return
 	}
 
-	var req models.ShareRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request data")
+	req, ok := utils.BoundRequest[models.ShareRequest](c)
+	if !ok {
 		return
 	}
 
 	objID, _ := utils.StringToObjectID(folderID)
-	share, err := fc.folderService.UpdateShare(user.ID, objID, &req)
+	share, err := fc.folderService.UpdateShare(c.Request.Context(), user.ID, objID, req)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to update share")
 		return
@@ -606,7 +760,7 @@ func (fc *FolderController) DeleteShare(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(folderID)
-	err := fc.folderService.DeleteShare(user.ID, objID)
+	err := fc.folderService.DeleteShare(c.Request.Context(), user.ID, objID)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to delete share")
 		return
@@ -629,7 +783,7 @@ func (fc *FolderController) GetShareURL(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(folderID)
-	shareURL, err := fc.folderService.GetShareURL(user.ID, objID)
+	shareURL, err := fc.folderService.GetShareURL(c.Request.Context(), user.ID, objID)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get share URL")
 		return
@@ -655,7 +809,7 @@ func (fc *FolderController) GetFolderStats(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(folderID)
-	stats, err := fc.folderService.GetFolderStats(user.ID, objID)
+	stats, err := fc.folderService.GetFolderStats(c.Request.Context(), user.ID, objID)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get folder stats")
 		return
@@ -678,7 +832,7 @@ func (fc *FolderController) GetFolderSize(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(folderID)
-	size, err := fc.folderService.GetFolderSize(user.ID, objID)
+	size, err := fc.folderService.GetFolderSize(c.Request.Context(), user.ID, objID)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get folder size")
 		return
@@ -690,20 +844,74 @@ func (fc *FolderController) GetFolderSize(c *gin.Context) {
 	})
 }
 
-// Bulk operations
-func (fc *FolderController) BulkDelete(c *gin.Context) {
+// GetUsage returns the storage usage tree below a folder, or below the root
+// without an ID, for disk usage views such as a treemap
+func (fc *FolderController) GetUsage(c *gin.Context) {
 	user, exists := utils.GetUserFromContext(c)
 	if !exists {
 		utils.UnauthorizedResponse(c, "User not found in context")
 		return
 	}
 
-	var req struct {
-		FolderIDs []string `json:"folder_ids" validate:"required"`
+	var folderObjID *primitive.ObjectID
+	if folderID := c.Param("id"); folderID != "" {
+		if !utils.IsValidObjectID(folderID) {
+			utils.BadRequestResponse(c, "Invalid folder ID")
+			return
+		}
+		objID, _ := utils.StringToObjectID(folderID)
+		folderObjID = &objID
 	}
 
-	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request data")
+	depth, _ := strconv.Atoi(c.DefaultQuery("depth", "3"))
+
+	usage, err := fc.folderUsageService.GetUsageTree(c.Request.Context(), user.ID, folderObjID, depth)
+	if err != nil {
+		utils.NotFoundResponse(c, "Folder not found")
+		return
+	}
+
+	utils.SuccessResponse(c, "Folder usage retrieved successfully", usage)
+}
+
+// BatchGet returns many folders in one call, partitioned into found and missing
+func (fc *FolderController) BatchGet(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	req, ok := utils.BoundRequest[models.BatchGetRequest](c)
+	if !ok {
+		return
+	}
+
+	objIDs := make([]primitive.ObjectID, 0, len(req.IDs))
+	for _, id := range req.IDs {
+		objID, _ := utils.StringToObjectID(id)
+		objIDs = append(objIDs, objID)
+	}
+
+	batch, err := fc.folderService.BatchGetFolders(c.Request.Context(), user.ID, objIDs)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get folders")
+		return
+	}
+
+	utils.SuccessResponse(c, "Folders retrieved successfully", batch)
+}
+
+// Bulk operations
+func (fc *FolderController) BulkDelete(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	req, ok := utils.BoundRequest[models.BulkFoldersRequest](c)
+	if !ok {
 		return
 	}
 
@@ -718,13 +926,12 @@ func (fc *FolderController) BulkDelete(c *gin.Context) {
 		objIDs = append(objIDs, objID)
 	}
 
-	results, err := fc.folderService.BulkDeleteFolders(user.ID, objIDs)
-	if err != nil {
-		utils.InternalServerErrorResponse(c, "Failed to delete folders")
-		return
-	}
-
-	utils.SuccessResponse(c, "Bulk delete completed", results)
+	results, job, err := fc.bulkJobService.Run(c.Request.Context(), &models.BulkJob{
+		UserID:    user.ID,
+		Operation: models.BulkDeleteFolders,
+		ItemIDs:   objIDs,
+	})
+	respondBulkResult(c, results, job, err, "Failed to delete folders", "Bulk delete completed")
 }
 
 func (fc *FolderController) BulkMove(c *gin.Context) {
@@ -734,13 +941,8 @@ func (fc *FolderController) BulkMove(c *gin.Context) {
 		return
 	}
 
-	var req struct {
-		FolderIDs    []string `json:"folder_ids" validate:"required"`
-		DestParentID string   `json:"dest_parent_id"`
-	}
-
-	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request data")
+	req, ok := utils.BoundRequest[models.BulkFoldersMoveRequest](c)
+	if !ok {
 		return
 	}
 
@@ -755,13 +957,13 @@ func (fc *FolderController) BulkMove(c *gin.Context) {
 		objIDs = append(objIDs, objID)
 	}
 
-	results, err := fc.folderService.BulkMoveFolders(user.ID, objIDs, req.DestParentID)
-	if err != nil {
-		utils.InternalServerErrorResponse(c, "Failed to move folders")
-		return
-	}
-
-	utils.SuccessResponse(c, "Bulk move completed", results)
+	results, job, err := fc.bulkJobService.Run(c.Request.Context(), &models.BulkJob{
+		UserID:    user.ID,
+		Operation: models.BulkMoveFolders,
+		ItemIDs:   objIDs,
+		DestID:    req.DestParentID,
+	})
+	respondBulkResult(c, results, job, err, "Failed to move folders", "Bulk move completed")
 }
 
 func (fc *FolderController) BulkCopy(c *gin.Context) {
@@ -771,13 +973,8 @@ func (fc *FolderController) BulkCopy(c *gin.Context) {
 		return
 	}
 
-	var req struct {
-		FolderIDs    []string `json:"folder_ids" validate:"required"`
-		DestParentID string   `json:"dest_parent_id"`
-	}
-
-	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request data")
+	req, ok := utils.BoundRequest[models.BulkFoldersMoveRequest](c)
+	if !ok {
 		return
 	}
 
@@ -792,13 +989,13 @@ func (fc *FolderController) BulkCopy(c *gin.Context) {
 		objIDs = append(objIDs, objID)
 	}
 
-	results, err := fc.folderService.BulkCopyFolders(user.ID, objIDs, req.DestParentID)
-	if err != nil {
-		utils.InternalServerErrorResponse(c, "Failed to copy folders")
-		return
-	}
-
-	utils.SuccessResponse(c, "Bulk copy completed", results)
+	results, job, err := fc.bulkJobService.Run(c.Request.Context(), &models.BulkJob{
+		UserID:    user.ID,
+		Operation: models.BulkCopyFolders,
+		ItemIDs:   objIDs,
+		DestID:    req.DestParentID,
+	})
+	respondBulkResult(c, results, job, err, "Failed to copy folders", "Bulk copy completed")
 }
 
 func (fc *FolderController) BulkShare(c *gin.Context) {
@@ -808,13 +1005,8 @@ func (fc *FolderController) BulkShare(c *gin.Context) {
 		return
 	}
 
-	var req struct {
-		FolderIDs []string            `json:"folder_ids" validate:"required"`
-		ShareData models.ShareRequest `json:"share_data"`
-	}
-
-	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request data")
+	req, ok := utils.BoundRequest[models.BulkFoldersShareRequest](c)
+	if !ok {
 		return
 	}
 
@@ -829,13 +1021,13 @@ func (fc *FolderController) BulkShare(c *gin.Context) {
 		objIDs = append(objIDs, objID)
 	}
 
-	results, err := fc.folderService.BulkShareFolders(user.ID, objIDs, &req.ShareData)
-	if err != nil {
-		utils.InternalServerErrorResponse(c, "Failed to share folders")
-		return
-	}
-
-	utils.SuccessResponse(c, "Bulk share completed", results)
+	results, job, err := fc.bulkJobService.Run(c.Request.Context(), &models.BulkJob{
+		UserID:    user.ID,
+		Operation: models.BulkShareFolders,
+		ItemIDs:   objIDs,
+		ShareData: &req.ShareData,
+	})
+	respondBulkResult(c, results, job, err, "Failed to share folders", "Bulk share completed")
 }
 
 // Public folder access
@@ -846,7 +1038,7 @@ func (fc *FolderController) PublicFolderAccess(c *gin.Context) {
 		return
 	}
 
-	folder, err := fc.folderService.GetPublicFolderContents(token)
+	folder, err := fc.folderService.GetPublicFolderContents(c.Request.Context(), token)
 	if err != nil {
 		utils.NotFoundResponse(c, "Folder not found or access denied")
 		return
@@ -862,11 +1054,70 @@ func (fc *FolderController) SharedFolderAccess(c *gin.Context) {
 		return
 	}
 
-	folder, err := fc.folderService.GetSharedFolderContents(token)
+	folder, err := fc.folderService.GetSharedFolderContents(c.Request.Context(), token)
 	if err != nil {
 		utils.NotFoundResponse(c, "Folder not found or access denied")
 		return
 	}
 
+	if wantsSharePage(c) {
+		c.HTML(http.StatusOK, "shares/folder.html", gin.H{
+			"title":       folder["folder"].(models.Folder).Name,
+			"subfolders":  folder["subfolders"],
+			"files":       folder["files"],
+			"custom_html": template.HTML(services.CurrentBranding().SharePageHTML),
+		})
+		return
+	}
+
 	utils.SuccessResponse(c, "Shared folder accessed successfully", folder)
 }
+
+// SearchPublicFolder searches a public folder and its subfolders by name and
+// tags
+func (fc *FolderController) SearchPublicFolder(c *gin.Context) {
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		utils.BadRequestResponse(c, "Search query is required")
+		return
+	}
+	_, limit := utils.GetPagination(c, 50, 200)
+
+	results, err := fc.folderService.SearchPublicFolder(c.Request.Context(), c.Param("token"), query, limit)
+	if err != nil {
+		respondSharedSearchError(c, err)
+		return
+	}
+
+	utils.SuccessResponse(c, "Search completed successfully", results)
+}
+
+// SearchSharedFolder searches a shared folder and its subfolders by name and
+// tags. Password protected shares take the password in X-Share-Password.
+func (fc *FolderController) SearchSharedFolder(c *gin.Context) {
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		utils.BadRequestResponse(c, "Search query is required")
+		return
+	}
+	_, limit := utils.GetPagination(c, 50, 200)
+
+	results, err := fc.folderService.SearchSharedFolder(c.Request.Context(), c.Param("token"), c.GetHeader("X-Share-Password"), query, limit)
+	if err != nil {
+		respondSharedSearchError(c, err)
+		return
+	}
+
+	utils.SuccessResponse(c, "Search completed successfully", results)
+}
+
+func respondSharedSearchError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrSharePasswordRequired):
+		utils.UnauthorizedResponse(c, "This share is password protected")
+	case errors.Is(err, services.ErrSharedFolderNotFound):
+		utils.NotFoundResponse(c, "Folder not found or access denied")
+	default:
+		utils.InternalServerErrorResponse(c, "Failed to search folder")
+	}
+}
@@ -0,0 +1,381 @@
+package controllers
+
+import (
+	"errors"
+	"oncloud/models"
+	"oncloud/services"
+	"oncloud/utils"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type FolderMemberController struct {
+	folderMemberService *services.FolderMemberService
+}
+
+func NewFolderMemberController() *FolderMemberController {
+	return &FolderMemberController{
+		folderMemberService: services.NewFolderMemberService(),
+	}
+}
+
+// GetMembers lists the users a folder is shared with
+func (fmc *FolderMemberController) GetMembers(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	folderID := c.Param("id")
+	if !utils.IsValidObjectID(folderID) {
+		utils.BadRequestResponse(c, "Invalid folder ID")
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(folderID)
+	members, err := fmc.folderMemberService.ListMembers(c.Request.Context(), user.ID, objID)
+	if err != nil {
+		respondFolderMemberError(c, err, "Failed to get folder members")
+		return
+	}
+
+	utils.SuccessResponse(c, "Folder members retrieved successfully", members)
+}
+
+// AddMember shares a folder with another user
+func (fmc *FolderMemberController) AddMember(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	folderID := c.Param("id")
+	if !utils.IsValidObjectID(folderID) {
+		utils.BadRequestResponse(c, "Invalid folder ID")
+		return
+	}
+
+	req, ok := utils.BoundRequest[models.FolderMemberRequest](c)
+	if !ok {
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(folderID)
+	member, err := fmc.folderMemberService.AddMember(c.Request.Context(), user.ID, objID, req)
+	if err != nil {
+		respondFolderMemberError(c, err, "Failed to add folder member")
+		return
+	}
+
+	utils.CreatedResponse(c, "Folder member added successfully", member)
+}
+
+// UpdateMember changes a member's role
+func (fmc *FolderMemberController) UpdateMember(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	folderID, memberID, ok := folderMemberParams(c)
+	if !ok {
+		return
+	}
+
+	req, ok := utils.BoundRequest[models.FolderMemberUpdateRequest](c)
+	if !ok {
+		return
+	}
+
+	member, err := fmc.folderMemberService.UpdateMember(c.Request.Context(), user.ID, folderID, memberID, req)
+	if err != nil {
+		respondFolderMemberError(c, err, "Failed to update folder member")
+		return
+	}
+
+	utils.SuccessResponse(c, "Folder member updated successfully", member)
+}
+
+// RemoveMember stops sharing a folder with a user
+func (fmc *FolderMemberController) RemoveMember(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	folderID, memberID, ok := folderMemberParams(c)
+	if !ok {
+		return
+	}
+
+	if err := fmc.folderMemberService.RemoveMember(c.Request.Context(), user.ID, folderID, memberID); err != nil {
+		respondFolderMemberError(c, err, "Failed to remove folder member")
+		return
+	}
+
+	utils.SuccessResponse(c, "Folder member removed successfully", nil)
+}
+
+// GetMemberActivity lists what a member did in a folder, newest first
+func (fmc *FolderMemberController) GetMemberActivity(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	folderID, memberID, ok := folderMemberParams(c)
+	if !ok {
+		return
+	}
+	page, limit := utils.GetPagination(c, 20, 0)
+
+	activity, total, err := fmc.folderMemberService.GetMemberActivity(c.Request.Context(), user.ID, folderID, memberID, page, limit)
+	if err != nil {
+		respondFolderMemberError(c, err, "Failed to get folder activity")
+		return
+	}
+
+	utils.PaginatedResponse(c, "Folder activity retrieved successfully", activity, page, limit, total)
+}
+
+// GetSharedWithMe lists the folders other users shared with the user
+func (fmc *FolderMemberController) GetSharedWithMe(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	folders, err := fmc.folderMemberService.SharedWithUser(c.Request.Context(), user.ID)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get shared folders")
+		return
+	}
+
+	utils.SuccessResponse(c, "Shared folders retrieved successfully", folders)
+}
+
+// GetSharedContents lists a folder shared with the user, or the subfolder
+// of it given by folder_id
+func (fmc *FolderMemberController) GetSharedContents(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	sharedID, folderID, ok := sharedFolderParams(c, c.Query("folder_id"))
+	if !ok {
+		return
+	}
+	page, limit := utils.GetPagination(c, 20, 0)
+
+	contents, err := fmc.folderMemberService.GetSharedContents(c.Request.Context(), user.ID, sharedID, folderID, page, limit)
+	if err != nil {
+		respondFolderMemberError(c, err, "Failed to get folder contents")
+		return
+	}
+
+	utils.SuccessResponse(c, "Folder contents retrieved successfully", contents)
+}
+
+// UploadShared uploads a file into a folder shared with the user, or the
+// subfolder of it given by folder_id
+func (fmc *FolderMemberController) UploadShared(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	sharedID, folderID, ok := sharedFolderParams(c, c.PostForm("folder_id"))
+	if !ok {
+		return
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		utils.BadRequestResponse(c, "No file provided")
+		return
+	}
+
+	file, err := fmc.folderMemberService.Upload(c.Request.Context(), user.ID, sharedID, folderID, fileHeader)
+	if err != nil {
+		respondFolderMemberError(c, err, "Failed to upload file")
+		return
+	}
+
+	utils.FileUploadResponse(c, "File uploaded successfully", file, "")
+}
+
+// RenameSharedFile renames a file inside a folder shared with the user
+func (fmc *FolderMemberController) RenameSharedFile(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	sharedID, fileID, ok := sharedItemParams(c, "fileId")
+	if !ok {
+		return
+	}
+
+	req, ok := utils.BoundRequest[models.SharedItemRenameRequest](c)
+	if !ok {
+		return
+	}
+
+	file, err := fmc.folderMemberService.RenameFile(c.Request.Context(), user.ID, sharedID, fileID, req.Name)
+	if err != nil {
+		respondFolderMemberError(c, err, "Failed to rename file")
+		return
+	}
+
+	utils.SuccessResponse(c, "File renamed successfully", file)
+}
+
+// DeleteSharedFile moves a file inside a folder shared with the user to its
+// owner's trash
+func (fmc *FolderMemberController) DeleteSharedFile(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	sharedID, fileID, ok := sharedItemParams(c, "fileId")
+	if !ok {
+		return
+	}
+
+	if err := fmc.folderMemberService.DeleteFile(c.Request.Context(), user.ID, sharedID, fileID); err != nil {
+		respondFolderMemberError(c, err, "Failed to delete file")
+		return
+	}
+
+	utils.SuccessResponse(c, "File deleted successfully", nil)
+}
+
+// RenameSharedFolder renames a subfolder of a folder shared with the user
+func (fmc *FolderMemberController) RenameSharedFolder(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	sharedID, folderID, ok := sharedItemParams(c, "folderId")
+	if !ok {
+		return
+	}
+
+	req, ok := utils.BoundRequest[models.SharedItemRenameRequest](c)
+	if !ok {
+		return
+	}
+
+	folder, err := fmc.folderMemberService.RenameFolder(c.Request.Context(), user.ID, sharedID, folderID, req.Name)
+	if err != nil {
+		respondFolderMemberError(c, err, "Failed to rename folder")
+		return
+	}
+
+	utils.SuccessResponse(c, "Folder renamed successfully", folder)
+}
+
+// DeleteSharedFolder moves a subfolder of a folder shared with the user to
+// its owner's trash
+func (fmc *FolderMemberController) DeleteSharedFolder(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	sharedID, folderID, ok := sharedItemParams(c, "folderId")
+	if !ok {
+		return
+	}
+
+	if err := fmc.folderMemberService.DeleteFolder(c.Request.Context(), user.ID, sharedID, folderID); err != nil {
+		respondFolderMemberError(c, err, "Failed to delete folder")
+		return
+	}
+
+	utils.SuccessResponse(c, "Folder deleted successfully", nil)
+}
+
+// folderMemberParams reads the folder and member user IDs from the path
+func folderMemberParams(c *gin.Context) (primitive.ObjectID, primitive.ObjectID, bool) {
+	folderID, err := utils.StringToObjectID(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid folder ID")
+		return primitive.NilObjectID, primitive.NilObjectID, false
+	}
+	memberID, err := utils.StringToObjectID(c.Param("userId"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid user ID")
+		return primitive.NilObjectID, primitive.NilObjectID, false
+	}
+	return folderID, memberID, true
+}
+
+// sharedFolderParams reads the shared folder ID from the path and the
+// optional subfolder ID given
+func sharedFolderParams(c *gin.Context, subfolder string) (primitive.ObjectID, *primitive.ObjectID, bool) {
+	sharedID, err := utils.StringToObjectID(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid folder ID")
+		return primitive.NilObjectID, nil, false
+	}
+	if subfolder == "" {
+		return sharedID, nil, true
+	}
+	folderID, err := utils.StringToObjectID(subfolder)
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid folder ID")
+		return primitive.NilObjectID, nil, false
+	}
+	return sharedID, &folderID, true
+}
+
+// sharedItemParams reads the shared folder ID and the ID of the item in it
+// named by param from the path
+func sharedItemParams(c *gin.Context, param string) (primitive.ObjectID, primitive.ObjectID, bool) {
+	sharedID, err := utils.StringToObjectID(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid folder ID")
+		return primitive.NilObjectID, primitive.NilObjectID, false
+	}
+	itemID, err := utils.StringToObjectID(c.Param(param))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid item ID")
+		return primitive.NilObjectID, primitive.NilObjectID, false
+	}
+	return sharedID, itemID, true
+}
+
+func respondFolderMemberError(c *gin.Context, err error, message string) {
+	if respondAccountState(c, err) || respondFileLocked(c, err) || respondFileTypeMismatch(c, err) || respondFileTypeBlocked(c, err) {
+		return
+	}
+	switch {
+	case errors.Is(err, services.ErrSharedFolderNotMember):
+		utils.NotFoundResponse(c, "Folder not found")
+	case errors.Is(err, services.ErrFolderMemberUser):
+		utils.BadRequestResponse(c, err.Error())
+	case errors.Is(err, services.ErrFolderMemberExists):
+		utils.ConflictResponse(c, err.Error())
+	case errors.Is(err, services.ErrSharedFolderRoot):
+		utils.BadRequestResponse(c, err.Error())
+	default:
+		utils.ServiceErrorResponse(c, err, message)
+	}
+}
@@ -0,0 +1,70 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"oncloud/graphql"
+	"oncloud/services"
+	"oncloud/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GraphQL request bodies larger than this are refused
+const maxGraphQLRequestSize = 1 << 20
+
+type GraphQLController struct {
+	graphqlService *services.GraphQLService
+}
+
+func NewGraphQLController() *GraphQLController {
+	return &GraphQLController{
+		graphqlService: services.NewGraphQLService(),
+	}
+}
+
+// Query runs a GraphQL query. The result is sent as a standard GraphQL
+// response ({"data": ..., "errors": [...]}) rather than in the REST
+// envelope, so GraphQL clients can read it.
+func (gc *GraphQLController) Query(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	var req graphql.Request
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxGraphQLRequestSize)
+	if err := c.ShouldBindJSON(&req); err != nil || req.Query == "" {
+		utils.BadRequestResponse(c, "A JSON body with a query is required")
+		return
+	}
+
+	response, err := gc.graphqlService.Execute(c.Request.Context(), user, &req)
+	if err != nil {
+		respondGraphQLError(c, err, "Failed to run query")
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// GetSchema returns the schema in the GraphQL schema definition language
+func (gc *GraphQLController) GetSchema(c *gin.Context) {
+	sdl, err := gc.graphqlService.SDL()
+	if err != nil {
+		respondGraphQLError(c, err, "Failed to get schema")
+		return
+	}
+
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(sdl))
+}
+
+func respondGraphQLError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrGraphQLNotEnabled):
+		utils.NotFoundResponse(c, "The GraphQL API is not available")
+	default:
+		utils.ServiceErrorResponse(c, err, message)
+	}
+}
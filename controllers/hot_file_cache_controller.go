@@ -0,0 +1,48 @@
+package controllers
+
+import (
+	"errors"
+	"oncloud/services"
+	"oncloud/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type HotFileCacheController struct {
+	hotFileService *services.HotFileCacheService
+}
+
+func NewHotFileCacheController(deps services.Dependencies) *HotFileCacheController {
+	return &HotFileCacheController{
+		hotFileService: services.NewHotFileCacheServiceWith(deps),
+	}
+}
+
+// GetHotFiles lists the popular files kept warm in the CDN and the local
+// cache, most downloaded first
+func (hc *HotFileCacheController) GetHotFiles(c *gin.Context) {
+	page, limit := utils.GetPagination(c, 20, 0)
+	files, total, err := hc.hotFileService.ListHotFiles(c.Request.Context(), page, limit)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get hot files")
+		return
+	}
+
+	utils.PaginatedResponse(c, "Hot files retrieved successfully", files, page, limit, total)
+}
+
+// WarmCache pre-warms the caches with the current hot files now instead of
+// waiting for the next scheduled run
+func (hc *HotFileCacheController) WarmCache(c *gin.Context) {
+	run, err := hc.hotFileService.WarmCache(c.Request.Context())
+	if err != nil {
+		if errors.Is(err, services.ErrCacheWarmRunning) {
+			utils.ConflictResponse(c, "Cache warming is already running")
+			return
+		}
+		utils.InternalServerErrorResponse(c, "Failed to warm caches")
+		return
+	}
+
+	utils.SuccessResponse(c, "Caches warmed successfully", run)
+}
@@ -0,0 +1,289 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"oncloud/models"
+	"oncloud/services"
+	"oncloud/utils"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ImportController struct {
+	cloudImportService *services.CloudImportService
+}
+
+func NewImportController() *ImportController {
+	return &ImportController{
+		cloudImportService: services.NewCloudImportService(),
+	}
+}
+
+// GetProviders lists the cloud providers files can be imported from
+func (ic *ImportController) GetProviders(c *gin.Context) {
+	utils.SuccessResponse(c, "Import providers retrieved successfully", ic.cloudImportService.GetProviders())
+}
+
+// GetConnections lists the user's linked cloud accounts
+func (ic *ImportController) GetConnections(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	connections, err := ic.cloudImportService.GetConnections(c.Request.Context(), user.ID)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get connections")
+		return
+	}
+
+	utils.SuccessResponse(c, "Connections retrieved successfully", connections)
+}
+
+// AuthorizeConnection returns the provider URL to send the user to for
+// linking a cloud account
+func (ic *ImportController) AuthorizeConnection(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	authorization, err := ic.cloudImportService.Authorize(c.Request.Context(), user.ID, c.Param("provider"))
+	if err != nil {
+		respondCloudImportError(c, err, "Failed to start linking account")
+		return
+	}
+
+	utils.SuccessResponse(c, "Authorization URL created successfully", authorization)
+}
+
+// CreateConnection completes linking a cloud account with the code and
+// state the provider redirected back with
+func (ic *ImportController) CreateConnection(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	var req models.CloudConnectRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data")
+		return
+	}
+	if err := utils.ValidateStruct(&req); err != nil {
+		utils.ValidationErrorResponse(c, err)
+		return
+	}
+
+	connection, err := ic.cloudImportService.Connect(c.Request.Context(), user.ID, c.Param("provider"), &req)
+	if err != nil {
+		respondCloudImportError(c, err, "Failed to link account")
+		return
+	}
+
+	utils.CreatedResponse(c, "Account linked successfully", connection)
+}
+
+// DeleteConnection unlinks a cloud account
+func (ic *ImportController) DeleteConnection(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	connectionID := c.Param("id")
+	if !utils.IsValidObjectID(connectionID) {
+		utils.BadRequestResponse(c, "Invalid connection ID")
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(connectionID)
+	if err := ic.cloudImportService.DeleteConnection(c.Request.Context(), user.ID, objID); err != nil {
+		respondCloudImportError(c, err, "Failed to unlink account")
+		return
+	}
+
+	utils.SuccessResponse(c, "Account unlinked successfully", nil)
+}
+
+// BrowseConnection lists a folder of a linked cloud account so the user
+// can pick what to import
+func (ic *ImportController) BrowseConnection(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	connectionID := c.Param("id")
+	if !utils.IsValidObjectID(connectionID) {
+		utils.BadRequestResponse(c, "Invalid connection ID")
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(connectionID)
+	items, err := ic.cloudImportService.Browse(c.Request.Context(), user.ID, objID, c.Query("folder_id"))
+	if err != nil {
+		respondCloudImportError(c, err, "Failed to list folder")
+		return
+	}
+
+	utils.SuccessResponse(c, "Folder listed successfully", items)
+}
+
+// StartImport starts copying the picked files and folders into the user's
+// storage in the background
+func (ic *ImportController) StartImport(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	var req models.CloudImportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data")
+		return
+	}
+	if err := utils.ValidateStruct(&req); err != nil {
+		utils.ValidationErrorResponse(c, err)
+		return
+	}
+
+	job, err := ic.cloudImportService.StartImport(c.Request.Context(), user.ID, &req)
+	if err != nil {
+		respondCloudImportError(c, err, "Failed to start import")
+		return
+	}
+
+	utils.CreatedResponse(c, "Import started successfully", job)
+}
+
+// StartURLImport starts fetching a file from a URL into the user's storage
+// in the background
+func (ic *ImportController) StartURLImport(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	var req models.URLImportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data")
+		return
+	}
+	if err := utils.ValidateStruct(&req); err != nil {
+		utils.ValidationErrorResponse(c, err)
+		return
+	}
+
+	job, err := ic.cloudImportService.StartURLImport(c.Request.Context(), user.ID, &req)
+	if err != nil {
+		respondCloudImportError(c, err, "Failed to start import")
+		return
+	}
+
+	utils.CreatedResponse(c, "Import started successfully", job)
+}
+
+// GetImports lists the user's import jobs
+func (ic *ImportController) GetImports(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	jobs, total, err := ic.cloudImportService.GetJobs(c.Request.Context(), user.ID, page, limit)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get imports")
+		return
+	}
+
+	utils.PaginatedResponse(c, "Imports retrieved successfully", jobs, page, limit, total)
+}
+
+// GetImport returns an import job with its progress
+func (ic *ImportController) GetImport(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	jobID := c.Param("id")
+	if !utils.IsValidObjectID(jobID) {
+		utils.BadRequestResponse(c, "Invalid import ID")
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(jobID)
+	job, err := ic.cloudImportService.GetJob(c.Request.Context(), user.ID, objID)
+	if err != nil {
+		respondCloudImportError(c, err, "Failed to get import")
+		return
+	}
+
+	utils.SuccessResponse(c, "Import retrieved successfully", job)
+}
+
+// CancelImport stops an unfinished import job
+func (ic *ImportController) CancelImport(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	jobID := c.Param("id")
+	if !utils.IsValidObjectID(jobID) {
+		utils.BadRequestResponse(c, "Invalid import ID")
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(jobID)
+	job, err := ic.cloudImportService.CancelJob(c.Request.Context(), user.ID, objID)
+	if err != nil {
+		respondCloudImportError(c, err, "Failed to cancel import")
+		return
+	}
+
+	utils.SuccessResponse(c, "Import cancelled successfully", job)
+}
+
+func respondCloudImportError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrCloudProviderNotConfigured):
+		utils.BadRequestResponse(c, "Importing from this provider is not available")
+	case errors.Is(err, services.ErrInvalidCloudAuthState):
+		utils.BadRequestResponse(c, "Authorization expired or is invalid, please try again")
+	case errors.Is(err, services.ErrInvalidImportURL):
+		utils.BadRequestResponse(c, err.Error())
+	case errors.Is(err, services.ErrCloudConnectionNotFound):
+		utils.NotFoundResponse(c, "Connection not found")
+	case errors.Is(err, services.ErrCloudImportNotFound):
+		utils.NotFoundResponse(c, "Import not found")
+	case errors.Is(err, services.ErrCloudImportFolderNotFound):
+		utils.NotFoundResponse(c, "Folder not found")
+	case errors.Is(err, services.ErrCloudImportFinished):
+		utils.ErrorResponse(c, http.StatusConflict, "Import has already finished", nil)
+	default:
+		utils.ServiceErrorResponse(c, err, message)
+	}
+}
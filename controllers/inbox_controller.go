@@ -0,0 +1,174 @@
+package controllers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"oncloud/models"
+	"oncloud/services"
+	"oncloud/utils"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Inbound emails larger than this are refused before parsing
+const maxInboundEmailSize = 100 << 20
+
+type InboxController struct {
+	emailInboxService *services.EmailInboxService
+}
+
+func NewInboxController() *InboxController {
+	return &InboxController{
+		emailInboxService: services.NewEmailInboxService(),
+	}
+}
+
+// GetInbox returns the user's inbound email address and its settings
+func (ic *InboxController) GetInbox(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	inbox, err := ic.emailInboxService.GetInbox(c.Request.Context(), user.ID)
+	if err != nil {
+		respondEmailInboxError(c, err, "Failed to get inbox")
+		return
+	}
+
+	utils.SuccessResponse(c, "Inbox retrieved successfully", inbox)
+}
+
+// UpdateInbox changes the inbox folder, allowed senders or whether it
+// accepts mail
+func (ic *InboxController) UpdateInbox(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	var req models.EmailInboxUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data")
+		return
+	}
+	if err := utils.ValidateStruct(&req); err != nil {
+		utils.ValidationErrorResponse(c, err)
+		return
+	}
+
+	inbox, err := ic.emailInboxService.UpdateInbox(c.Request.Context(), user.ID, &req)
+	if err != nil {
+		respondEmailInboxError(c, err, "Failed to update inbox")
+		return
+	}
+
+	utils.SuccessResponse(c, "Inbox updated successfully", inbox)
+}
+
+// RegenerateAddress gives the user a new inbound address
+func (ic *InboxController) RegenerateAddress(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	inbox, err := ic.emailInboxService.RegenerateAddress(c.Request.Context(), user.ID)
+	if err != nil {
+		respondEmailInboxError(c, err, "Failed to regenerate address")
+		return
+	}
+
+	utils.SuccessResponse(c, "Inbox address regenerated successfully", inbox)
+}
+
+// MailgunWebhook receives an email forwarded by a Mailgun inbound route
+func (ic *InboxController) MailgunWebhook(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxInboundEmailSize)
+	if err := c.Request.ParseMultipartForm(32 << 20); err != nil {
+		utils.BadRequestResponse(c, "Invalid email data")
+		return
+	}
+
+	err := ic.emailInboxService.VerifyMailgunSignature(c.PostForm("timestamp"), c.PostForm("token"), c.PostForm("signature"))
+	if err != nil {
+		respondEmailInboxError(c, err, "Failed to verify email")
+		return
+	}
+
+	email := &utils.InboundEmail{
+		Sender:     strings.ToLower(c.PostForm("sender")),
+		Recipients: strings.Split(c.PostForm("recipient"), ","),
+		Subject:    c.PostForm("subject"),
+	}
+	for field, headers := range c.Request.MultipartForm.File {
+		if !strings.HasPrefix(field, "attachment-") {
+			continue
+		}
+		for _, header := range headers {
+			file, err := header.Open()
+			if err != nil {
+				utils.BadRequestResponse(c, "Invalid attachment")
+				return
+			}
+			content, err := io.ReadAll(file)
+			file.Close()
+			if err != nil {
+				utils.BadRequestResponse(c, "Invalid attachment")
+				return
+			}
+			email.Attachments = append(email.Attachments, utils.EmailAttachment{
+				FileName:    header.Filename,
+				ContentType: header.Header.Get("Content-Type"),
+				Content:     content,
+			})
+		}
+	}
+
+	utils.SuccessResponse(c, "Email received", ic.emailInboxService.Deliver(c.Request.Context(), email))
+}
+
+// RawEmailWebhook receives a raw email message, e.g. from an SES receipt
+// rule or an MTA pipe. The envelope recipient may be passed in
+// X-Envelope-To, otherwise the To and Cc headers are used.
+func (ic *InboxController) RawEmailWebhook(c *gin.Context) {
+	if err := ic.emailInboxService.VerifyWebhookSecret(c.GetHeader("X-Inbound-Secret")); err != nil {
+		respondEmailInboxError(c, err, "Failed to verify email")
+		return
+	}
+
+	raw, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxInboundEmailSize))
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusRequestEntityTooLarge, "Email is too large", nil)
+		return
+	}
+
+	email, err := utils.ParseInboundEmail(raw)
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid email data")
+		return
+	}
+	if envelopeTo := c.GetHeader("X-Envelope-To"); envelopeTo != "" {
+		email.Recipients = strings.Split(envelopeTo, ",")
+	}
+
+	utils.SuccessResponse(c, "Email received", ic.emailInboxService.Deliver(c.Request.Context(), email))
+}
+
+func respondEmailInboxError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrEmailInboxNotConfigured):
+		utils.NotFoundResponse(c, "Receiving files by email is not available")
+	case errors.Is(err, services.ErrInboundEmailUnverified):
+		utils.UnauthorizedResponse(c, "Email could not be verified")
+	case errors.Is(err, services.ErrEmailInboxFolderNotFound):
+		utils.NotFoundResponse(c, "Folder not found")
+	default:
+		utils.ServiceErrorResponse(c, err, message)
+	}
+}
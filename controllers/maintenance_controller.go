@@ -0,0 +1,108 @@
+package controllers
+
+import (
+	"errors"
+	"oncloud/models"
+	"oncloud/services"
+	"oncloud/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type MaintenanceController struct {
+	maintenanceService *services.MaintenanceService
+}
+
+func NewMaintenanceController() *MaintenanceController {
+	return &MaintenanceController{
+		maintenanceService: services.NewMaintenanceService(),
+	}
+}
+
+// GetStatus tells clients whether the service is under maintenance and what
+// is planned. It stays available during maintenance.
+func (mc *MaintenanceController) GetStatus(c *gin.Context) {
+	utils.SuccessResponse(c, "Maintenance status retrieved successfully", services.CurrentMaintenance())
+}
+
+// SetMode switches maintenance mode on or off
+func (mc *MaintenanceController) SetMode(c *gin.Context) {
+	admin, exists := utils.GetAdminFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "Admin not found")
+		return
+	}
+
+	var req models.MaintenanceModeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data")
+		return
+	}
+
+	if err := mc.maintenanceService.SetMaintenanceMode(c.Request.Context(), &req, admin.ID); err != nil {
+		respondMaintenanceError(c, err, "Failed to update maintenance mode")
+		return
+	}
+
+	utils.SuccessResponse(c, "Maintenance mode updated successfully", services.CurrentMaintenance())
+}
+
+// GetWindows lists scheduled maintenance
+func (mc *MaintenanceController) GetWindows(c *gin.Context) {
+	windows, err := mc.maintenanceService.ListWindows(c.Request.Context(), c.Query("include_past") == "true")
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get maintenance windows")
+		return
+	}
+
+	utils.SuccessResponse(c, "Maintenance windows retrieved successfully", windows)
+}
+
+// ScheduleWindow schedules maintenance
+func (mc *MaintenanceController) ScheduleWindow(c *gin.Context) {
+	admin, exists := utils.GetAdminFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "Admin not found")
+		return
+	}
+
+	var req models.MaintenanceWindowRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data")
+		return
+	}
+
+	window, err := mc.maintenanceService.ScheduleWindow(c.Request.Context(), &req, admin.ID)
+	if err != nil {
+		respondMaintenanceError(c, err, "Failed to schedule maintenance")
+		return
+	}
+
+	utils.CreatedResponse(c, "Maintenance scheduled successfully", window)
+}
+
+// CancelWindow cancels scheduled maintenance
+func (mc *MaintenanceController) CancelWindow(c *gin.Context) {
+	windowID := c.Param("id")
+	if !utils.IsValidObjectID(windowID) {
+		utils.BadRequestResponse(c, "Invalid maintenance window ID")
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(windowID)
+	if err := mc.maintenanceService.CancelWindow(c.Request.Context(), objID, c.Query("notify") != "false"); err != nil {
+		respondMaintenanceError(c, err, "Failed to cancel maintenance")
+		return
+	}
+
+	utils.SuccessResponse(c, "Maintenance cancelled successfully", nil)
+}
+
+func respondMaintenanceError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrInvalidMaintenanceWindow), errors.Is(err, services.ErrInvalidRuntimeSetting):
+		utils.BadRequestResponse(c, err.Error())
+	default:
+		utils.ServiceErrorResponse(c, err, message)
+	}
+}
@@ -0,0 +1,174 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"oncloud/models"
+	"oncloud/services"
+	"oncloud/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type MetadataController struct {
+	metadataService *services.MetadataService
+}
+
+func NewMetadataController() *MetadataController {
+	return &MetadataController{
+		metadataService: services.NewMetadataService(),
+	}
+}
+
+// GetFields lists the user's custom metadata fields
+func (mc *MetadataController) GetFields(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	fields, err := mc.metadataService.ListFields(c.Request.Context(), user.ID)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get metadata fields")
+		return
+	}
+
+	utils.SuccessResponse(c, "Metadata fields retrieved successfully", fields)
+}
+
+// CreateField defines a new custom metadata field
+func (mc *MetadataController) CreateField(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	var req models.MetadataFieldRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data")
+		return
+	}
+	if err := utils.ValidateStruct(&req); err != nil {
+		utils.ValidationErrorResponse(c, err)
+		return
+	}
+
+	field, err := mc.metadataService.CreateField(c.Request.Context(), user.ID, &req)
+	if err != nil {
+		respondMetadataError(c, err, "Failed to create metadata field")
+		return
+	}
+
+	utils.CreatedResponse(c, "Metadata field created successfully", field)
+}
+
+// UpdateField changes a custom metadata field
+func (mc *MetadataController) UpdateField(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	fieldID := c.Param("id")
+	if !utils.IsValidObjectID(fieldID) {
+		utils.BadRequestResponse(c, "Invalid field ID")
+		return
+	}
+
+	var req models.MetadataFieldUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data")
+		return
+	}
+	if err := utils.ValidateStruct(&req); err != nil {
+		utils.ValidationErrorResponse(c, err)
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(fieldID)
+	field, err := mc.metadataService.UpdateField(c.Request.Context(), user.ID, objID, &req)
+	if err != nil {
+		respondMetadataError(c, err, "Failed to update metadata field")
+		return
+	}
+
+	utils.SuccessResponse(c, "Metadata field updated successfully", field)
+}
+
+// DeleteField removes a custom metadata field and its values
+func (mc *MetadataController) DeleteField(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	fieldID := c.Param("id")
+	if !utils.IsValidObjectID(fieldID) {
+		utils.BadRequestResponse(c, "Invalid field ID")
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(fieldID)
+	if err := mc.metadataService.DeleteField(c.Request.Context(), user.ID, objID); err != nil {
+		respondMetadataError(c, err, "Failed to delete metadata field")
+		return
+	}
+
+	utils.SuccessResponse(c, "Metadata field deleted successfully", nil)
+}
+
+// SetFileMetadata sets custom metadata values on a file
+func (mc *MetadataController) SetFileMetadata(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	fileID := c.Param("id")
+	if !utils.IsValidObjectID(fileID) {
+		utils.BadRequestResponse(c, "Invalid file ID")
+		return
+	}
+
+	var req models.FileMetadataRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data")
+		return
+	}
+	if err := utils.ValidateStruct(&req); err != nil {
+		utils.ValidationErrorResponse(c, err)
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(fileID)
+	file, err := mc.metadataService.SetFileMetadata(c.Request.Context(), user.ID, objID, req.Values)
+	if err != nil {
+		if respondFileLocked(c, err) {
+			return
+		}
+		if errors.Is(err, services.ErrInvalidMetadata) {
+			utils.ValidationErrorResponse(c, err)
+			return
+		}
+		utils.NotFoundResponse(c, "File not found")
+		return
+	}
+
+	utils.SuccessResponse(c, "File metadata updated successfully", file)
+}
+
+func respondMetadataError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrMetadataFieldExists):
+		utils.ErrorResponse(c, http.StatusConflict, "A metadata field with this key already exists", nil)
+	case errors.Is(err, services.ErrInvalidMetadata):
+		utils.ValidationErrorResponse(c, err)
+	default:
+		utils.ServiceErrorResponse(c, err, message)
+	}
+}
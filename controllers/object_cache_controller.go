@@ -0,0 +1,52 @@
+package controllers
+
+import (
+	"oncloud/models"
+	"oncloud/services"
+	"oncloud/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ObjectCacheController struct {
+	auditService *services.AuditService
+}
+
+func NewObjectCacheController() *ObjectCacheController {
+	return &ObjectCacheController{
+		auditService: services.NewAuditService(),
+	}
+}
+
+// GetObjectCacheStats returns the hit rate, size and egress saved by the
+// disk cache of objects read from remote providers, for this instance
+func (oc *ObjectCacheController) GetObjectCacheStats(c *gin.Context) {
+	stats := services.ObjectCacheStats()
+	utils.SuccessResponse(c, "Object cache stats retrieved successfully", gin.H{
+		"enabled": stats != nil,
+		"stats":   stats,
+	})
+}
+
+// PurgeObjectCache drops every object cached by this instance
+func (oc *ObjectCacheController) PurgeObjectCache(c *gin.Context) {
+	admin, exists := utils.GetAdminFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "Admin not found")
+		return
+	}
+
+	purged := services.PurgeObjectCache()
+	oc.auditService.Record(c.Request.Context(), &models.AuditLog{
+		ActorType:    "admin",
+		ActorID:      &admin.ID,
+		Action:       "object_cache.purged",
+		ResourceType: "object_cache",
+		Outcome:      "success",
+		IPAddress:    c.ClientIP(),
+		UserAgent:    c.Request.UserAgent(),
+		Details:      map[string]interface{}{"purged": purged},
+	})
+
+	utils.SuccessResponse(c, "Object cache purged successfully", gin.H{"purged": purged})
+}
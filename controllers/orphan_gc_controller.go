@@ -0,0 +1,95 @@
+package controllers
+
+import (
+	"errors"
+	"oncloud/services"
+	"oncloud/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type OrphanGCController struct {
+	orphanGCService *services.OrphanGCService
+}
+
+func NewOrphanGCController() *OrphanGCController {
+	return &OrphanGCController{
+		orphanGCService: services.NewOrphanGCService(),
+	}
+}
+
+// StartOrphanScan reconciles a provider's objects with the records referring
+// to them. With delete=true, orphans older than the safety window are deleted.
+func (oc *OrphanGCController) StartOrphanScan(c *gin.Context) {
+	admin, exists := utils.GetAdminFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "Admin not found")
+		return
+	}
+
+	providerID := c.Param("id")
+	if !utils.IsValidObjectID(providerID) {
+		utils.BadRequestResponse(c, "Invalid provider ID")
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(providerID)
+	scan, err := oc.orphanGCService.StartScan(c.Request.Context(), objID, c.Query("delete") == "true", &admin.ID)
+	if err != nil {
+		respondOrphanGCError(c, err, "Failed to start orphan scan")
+		return
+	}
+
+	utils.CreatedResponse(c, "Orphan scan started successfully", scan)
+}
+
+// GetOrphanScans lists a provider's orphan scans
+func (oc *OrphanGCController) GetOrphanScans(c *gin.Context) {
+	providerID := c.Param("id")
+	if !utils.IsValidObjectID(providerID) {
+		utils.BadRequestResponse(c, "Invalid provider ID")
+		return
+	}
+
+	page, limit := utils.GetPagination(c, 20, 0)
+	objID, _ := utils.StringToObjectID(providerID)
+	scans, total, err := oc.orphanGCService.ListScans(c.Request.Context(), objID, page, limit)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get orphan scans")
+		return
+	}
+
+	utils.PaginatedResponse(c, "Orphan scans retrieved successfully", scans, page, limit, total)
+}
+
+// GetOrphanScan returns one orphan scan with the orphaned and missing objects
+// it found
+func (oc *OrphanGCController) GetOrphanScan(c *gin.Context) {
+	providerID := c.Param("id")
+	scanID := c.Param("scan_id")
+	if !utils.IsValidObjectID(providerID) || !utils.IsValidObjectID(scanID) {
+		utils.BadRequestResponse(c, "Invalid provider or scan ID")
+		return
+	}
+
+	providerObjID, _ := utils.StringToObjectID(providerID)
+	scanObjID, _ := utils.StringToObjectID(scanID)
+	scan, err := oc.orphanGCService.GetScan(c.Request.Context(), providerObjID, scanObjID)
+	if err != nil {
+		respondOrphanGCError(c, err, "Failed to get orphan scan")
+		return
+	}
+
+	utils.SuccessResponse(c, "Orphan scan retrieved successfully", scan)
+}
+
+func respondOrphanGCError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrOrphanScanRunning):
+		utils.ConflictResponse(c, "An orphan scan is already running for this provider")
+	case errors.Is(err, services.ErrOrphanScanUnsupported):
+		utils.BadRequestResponse(c, "This storage provider cannot list its objects")
+	default:
+		utils.ServiceErrorResponse(c, err, message)
+	}
+}
@@ -0,0 +1,173 @@
+package controllers
+
+import (
+	"oncloud/services"
+	"oncloud/utils"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PhotoController struct {
+	photoService *services.PhotoService
+}
+
+func NewPhotoController() *PhotoController {
+	return &PhotoController{
+		photoService: services.NewPhotoService(),
+	}
+}
+
+// GetTimeline returns the user's photos grouped by day, month or year
+func (pc *PhotoController) GetTimeline(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	granularity := c.DefaultQuery("granularity", "month")
+	if granularity != "day" && granularity != "month" && granularity != "year" {
+		utils.BadRequestResponse(c, "Invalid granularity, expected day, month or year")
+		return
+	}
+
+	filters, ok := photoFilters(c)
+	if !ok {
+		return
+	}
+
+	page, limit := photoPagination(c, 50)
+	buckets, total, err := pc.photoService.GetTimeline(c.Request.Context(), user.ID, granularity, filters, page, limit)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get timeline")
+		return
+	}
+
+	utils.PaginatedResponse(c, "Timeline retrieved successfully", buckets, page, limit, total)
+}
+
+// GetPhotos lists the user's photos newest first, optionally within a date
+// range or map area
+func (pc *PhotoController) GetPhotos(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	filters, ok := photoFilters(c)
+	if !ok {
+		return
+	}
+
+	page, limit := photoPagination(c, 50)
+	photos, total, err := pc.photoService.GetPhotos(c.Request.Context(), user.ID, filters, page, limit)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get photos")
+		return
+	}
+
+	utils.PaginatedResponse(c, "Photos retrieved successfully", photos, page, limit, total)
+}
+
+// GetMap returns clusters of geotagged photos for a map viewport
+func (pc *PhotoController) GetMap(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	zoom, err := strconv.Atoi(c.DefaultQuery("zoom", "3"))
+	if err != nil || zoom < 0 || zoom > 20 {
+		utils.BadRequestResponse(c, "Invalid zoom, expected 0-20")
+		return
+	}
+
+	filters, ok := photoFilters(c)
+	if !ok {
+		return
+	}
+
+	clusters, err := pc.photoService.GetMapClusters(c.Request.Context(), user.ID, zoom, filters)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get photo map")
+		return
+	}
+
+	utils.SuccessResponse(c, "Photo map retrieved successfully", clusters)
+}
+
+// photoFilters reads the from, to, bbox and include_videos query parameters,
+// writing a 400 response and returning false if any is invalid. bbox is
+// "minLon,minLat,maxLon,maxLat".
+func photoFilters(c *gin.Context) (*services.PhotoFilters, bool) {
+	filters := &services.PhotoFilters{
+		IncludeVideos: c.Query("include_videos") == "true",
+	}
+
+	if from := c.Query("from"); from != "" {
+		t, err := time.Parse("2006-01-02", from)
+		if err != nil {
+			utils.BadRequestResponse(c, "Invalid from, expected YYYY-MM-DD")
+			return nil, false
+		}
+		filters.From = &t
+	}
+	if to := c.Query("to"); to != "" {
+		t, err := time.Parse("2006-01-02", to)
+		if err != nil {
+			utils.BadRequestResponse(c, "Invalid to, expected YYYY-MM-DD")
+			return nil, false
+		}
+		// The range includes the whole of the last day
+		t = t.AddDate(0, 0, 1)
+		filters.To = &t
+	}
+
+	if bbox := c.Query("bbox"); bbox != "" {
+		parts := strings.Split(bbox, ",")
+		if len(parts) != 4 {
+			utils.BadRequestResponse(c, "Invalid bbox, expected minLon,minLat,maxLon,maxLat")
+			return nil, false
+		}
+		var coords [4]float64
+		for i, part := range parts {
+			value, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+			if err != nil {
+				utils.BadRequestResponse(c, "Invalid bbox, expected minLon,minLat,maxLon,maxLat")
+				return nil, false
+			}
+			coords[i] = value
+		}
+		bounds := &services.GeoBounds{
+			MinLongitude: coords[0],
+			MinLatitude:  coords[1],
+			MaxLongitude: coords[2],
+			MaxLatitude:  coords[3],
+		}
+		if bounds.MinLatitude > bounds.MaxLatitude || bounds.MinLatitude < -90 || bounds.MaxLatitude > 90 ||
+			bounds.MinLongitude < -180 || bounds.MaxLongitude > 180 {
+			utils.BadRequestResponse(c, "Invalid bbox, coordinates out of range")
+			return nil, false
+		}
+		filters.Bounds = bounds
+	}
+
+	return filters, true
+}
+
+func photoPagination(c *gin.Context, defaultLimit int) (int, int) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultLimit)))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 200 {
+		limit = defaultLimit
+	}
+	return page, limit
+}
@@ -2,9 +2,9 @@ package controllers
 
 import (
 	"net/http"
+	"oncloud/models"
 	"oncloud/services"
 	"oncloud/utils"
-	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -24,7 +24,7 @@ func NewPlanController() *PlanController {
 func (pc *PlanController) GetPlans(c *gin.Context) {
 	includeInactive := c.Query("include_inactive") == "true"
 
-	plans, err := pc.planService.GetAvailablePlans(includeInactive)
+	plans, err := pc.planService.GetAvailablePlans(c.Request.Context(), includeInactive)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get plans")
 		return
@@ -42,7 +42,7 @@ func (pc *PlanController) GetPlan(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(planID)
-	plan, err := pc.planService.GetPlan(objID)
+	plan, err := pc.planService.GetPlan(c.Request.Context(), objID)
 	if err != nil {
 		utils.NotFoundResponse(c, "Plan not found")
 		return
@@ -56,7 +56,7 @@ func (pc *PlanController) ComparePlans(c *gin.Context) {
 	planIDs := c.QueryArray("plan_ids")
 	if len(planIDs) == 0 {
 		// Return all active plans for comparison
-		comparison, err := pc.planService.GetPlanComparison(nil)
+		comparison, err := pc.planService.GetPlanComparison(c.Request.Context(), nil)
 		if err != nil {
 			utils.InternalServerErrorResponse(c, "Failed to get plan comparison")
 			return
@@ -76,7 +76,7 @@ func (pc *PlanController) ComparePlans(c *gin.Context) {
 		objIDs = append(objIDs, objID)
 	}
 
-	comparison, err := pc.planService.GetPlanComparison(objIDs)
+	comparison, err := pc.planService.GetPlanComparison(c.Request.Context(), objIDs)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get plan comparison")
 		return
@@ -90,7 +90,7 @@ func (pc *PlanController) GetPricing(c *gin.Context) {
 	// currency := c.DefaultQuery("currency", "USD")
 	// billingCycle := c.DefaultQuery("billing_cycle", "monthly")
 
-	pricing, err := pc.planService.GetPricing()
+	pricing, err := pc.planService.GetPricing(c.Request.Context())
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get pricing")
 		return
@@ -107,7 +107,7 @@ func (pc *PlanController) GetUserPlan(c *gin.Context) {
 		return
 	}
 
-	userPlan, err := pc.planService.GetUserPlan(user.ID)
+	userPlan, err := pc.planService.GetUserPlan(c.Request.Context(), user.ID)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get user plan")
 		return
@@ -124,30 +124,13 @@ func (pc *PlanController) Subscribe(c *gin.Context) {
 		return
 	}
 
-	var req struct {
-		PlanID        string `json:"plan_id" validate:"required"`
-		PaymentMethod string `json:"payment_method" validate:"required"`
-		BillingCycle  string `json:"billing_cycle"`
-		CouponCode    string `json:"coupon_code"`
-	}
-
-	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request data")
-		return
-	}
-
-	if err := utils.ValidateStruct(&req); err != nil {
-		utils.ValidationErrorResponse(c, err)
-		return
-	}
-
-	if !utils.IsValidObjectID(req.PlanID) {
-		utils.BadRequestResponse(c, "Invalid plan ID")
+	req, ok := utils.BoundRequest[models.SubscribeRequest](c)
+	if !ok {
 		return
 	}
 
 	planObjID, _ := utils.StringToObjectID(req.PlanID)
-	subscription, err := pc.planService.Subscribe(user.ID, planObjID, req.PaymentMethod)
+	subscription, err := pc.planService.Subscribe(c.Request.Context(), user.ID, planObjID, req.PaymentMethod)
 	if err != nil {
 		utils.ErrorResponse(c, http.StatusPaymentRequired, err.Error(), nil)
 		return
@@ -164,24 +147,13 @@ func (pc *PlanController) UpgradePlan(c *gin.Context) {
 		return
 	}
 
-	var req struct {
-		NewPlanID     string `json:"new_plan_id" validate:"required"`
-		PaymentMethod string `json:"payment_method"`
-		BillingCycle  string `json:"billing_cycle"`
-	}
-
-	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request data")
-		return
-	}
-
-	if !utils.IsValidObjectID(req.NewPlanID) {
-		utils.BadRequestResponse(c, "Invalid plan ID")
+	req, ok := utils.BoundRequest[models.PlanUpgradeRequest](c)
+	if !ok {
 		return
 	}
 
 	newPlanObjID, _ := utils.StringToObjectID(req.NewPlanID)
-	upgrade, err := pc.planService.UpgradePlan(user.ID, newPlanObjID, req.PaymentMethod)
+	upgrade, err := pc.planService.UpgradePlan(c.Request.Context(), user.ID, newPlanObjID, req.PaymentMethod)
 	if err != nil {
 		utils.ErrorResponse(c, http.StatusPaymentRequired, err.Error(), nil)
 		return
@@ -198,23 +170,13 @@ func (pc *PlanController) DowngradePlan(c *gin.Context) {
 		return
 	}
 
-	var req struct {
-		NewPlanID string `json:"new_plan_id" validate:"required"`
-		Immediate bool   `json:"immediate"`
-	}
-
-	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request data")
-		return
-	}
-
-	if !utils.IsValidObjectID(req.NewPlanID) {
-		utils.BadRequestResponse(c, "Invalid plan ID")
+	req, ok := utils.BoundRequest[models.PlanDowngradeRequest](c)
+	if !ok {
 		return
 	}
 
 	newPlanObjID, _ := utils.StringToObjectID(req.NewPlanID)
-	downgrade, err := pc.planService.DowngradePlan(user.ID, newPlanObjID)
+	downgrade, err := pc.planService.DowngradePlan(c.Request.Context(), user.ID, newPlanObjID)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, err.Error())
 		return
@@ -231,17 +193,11 @@ func (pc *PlanController) CancelSubscription(c *gin.Context) {
 		return
 	}
 
-	var req struct {
-		Reason    string `json:"reason"`
-		Immediate bool   `json:"immediate"`
-	}
-
-	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request data")
+	if _, ok := utils.BoundRequest[models.CancelSubscriptionRequest](c); !ok {
 		return
 	}
 
-	cancellation, err := pc.planService.CancelSubscription(user.ID)
+	cancellation, err := pc.planService.CancelSubscription(c.Request.Context(), user.ID)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, err.Error())
 		return
@@ -258,17 +214,12 @@ func (pc *PlanController) RenewSubscription(c *gin.Context) {
 		return
 	}
 
-	var req struct {
-		PaymentMethod string `json:"payment_method"`
-		BillingCycle  string `json:"billing_cycle"`
-	}
-
-	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request data")
+	req, ok := utils.BoundRequest[models.RenewSubscriptionRequest](c)
+	if !ok {
 		return
 	}
 
-	renewal, err := pc.planService.RenewSubscription(user.ID, req.PaymentMethod, req.BillingCycle)
+	renewal, err := pc.planService.RenewSubscription(c.Request.Context(), user.ID, req.PaymentMethod, req.BillingCycle)
 	if err != nil {
 		utils.ErrorResponse(c, http.StatusPaymentRequired, err.Error(), nil)
 		return
@@ -285,10 +236,9 @@ func (pc *PlanController) GetBillingHistory(c *gin.Context) {
 		return
 	}
 
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	page, limit := utils.GetPagination(c, 20, 0)
 
-	history, total, err := pc.planService.GetBillingHistory(user.ID, page, limit)
+	history, total, err := pc.planService.GetBillingHistory(c.Request.Context(), user.ID, page, limit)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get billing history")
 		return
@@ -305,10 +255,9 @@ func (pc *PlanController) GetInvoices(c *gin.Context) {
 		return
 	}
 
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	page, limit := utils.GetPagination(c, 20, 0)
 
-	invoices, total, err := pc.planService.GetInvoices(user.ID, page, limit)
+	invoices, total, err := pc.planService.GetInvoices(c.Request.Context(), user.ID, page, limit)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get invoices")
 		return
@@ -332,7 +281,7 @@ func (pc *PlanController) DownloadInvoice(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(invoiceID)
-	downloadURL, err := pc.planService.GetInvoiceDownloadURL(user.ID, objID)
+	downloadURL, err := pc.planService.GetInvoiceDownloadURL(c.Request.Context(), user.ID, objID)
 	if err != nil {
 		utils.NotFoundResponse(c, "Invoice not found")
 		return
@@ -349,19 +298,12 @@ func (pc *PlanController) AddPaymentMethod(c *gin.Context) {
 		return
 	}
 
-	var req struct {
-		Type      string            `json:"type" validate:"required"`  // card, paypal, bank
-		Token     string            `json:"token" validate:"required"` // Payment gateway token
-		IsDefault bool              `json:"is_default"`
-		Metadata  map[string]string `json:"metadata"`
-	}
-
-	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request data")
+	req, ok := utils.BoundRequest[models.PaymentMethodRequest](c)
+	if !ok {
 		return
 	}
 
-	paymentMethod, err := pc.planService.AddPaymentMethod(user.ID, req.Type, req.Token, req.IsDefault, req.Metadata)
+	paymentMethod, err := pc.planService.AddPaymentMethod(c.Request.Context(), user.ID, req.Type, req.Token, req.IsDefault, req.Metadata)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to add payment method")
 		return
@@ -377,7 +319,7 @@ func (pc *PlanController) GetPaymentMethods(c *gin.Context) {
 		return
 	}
 
-	methods, err := pc.planService.GetPaymentMethods(user.ID)
+	methods, err := pc.planService.GetPaymentMethods(c.Request.Context(), user.ID)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get payment methods")
 		return
@@ -399,18 +341,13 @@ func (pc *PlanController) UpdatePaymentMethod(c *gin.Context) {
 		return
 	}
 
-	var req struct {
-		IsDefault bool              `json:"is_default"`
-		Metadata  map[string]string `json:"metadata"`
-	}
-
-	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request data")
+	req, ok := utils.BoundRequest[models.PaymentMethodUpdateRequest](c)
+	if !ok {
 		return
 	}
 
 	objID, _ := utils.StringToObjectID(methodID)
-	err := pc.planService.UpdatePaymentMethod(user.ID, objID, req.IsDefault, req.Metadata)
+	err := pc.planService.UpdatePaymentMethod(c.Request.Context(), user.ID, objID, req.IsDefault, req.Metadata)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to update payment method")
 		return
@@ -433,7 +370,7 @@ func (pc *PlanController) DeletePaymentMethod(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(methodID)
-	err := pc.planService.DeletePaymentMethod(user.ID, objID)
+	err := pc.planService.DeletePaymentMethod(c.Request.Context(), user.ID, objID)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to delete payment method")
 		return
@@ -450,7 +387,7 @@ func (pc *PlanController) GetUsage(c *gin.Context) {
 		return
 	}
 
-	usage, err := pc.planService.GetUsage(user.ID)
+	usage, err := pc.planService.GetUsage(c.Request.Context(), user.ID)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get usage")
 		return
@@ -469,7 +406,7 @@ func (pc *PlanController) GetUsageHistory(c *gin.Context) {
 	period := c.DefaultQuery("period", "30") // days
 	usageType := c.Query("type")             // storage, bandwidth, files
 
-	history, err := pc.planService.GetUsageHistory(user.ID, period, usageType)
+	history, err := pc.planService.GetUsageHistory(c.Request.Context(), user.ID, period, usageType)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get usage history")
 		return
@@ -485,7 +422,7 @@ func (pc *PlanController) GetLimits(c *gin.Context) {
 		return
 	}
 
-	limits, err := pc.planService.GetUserLimits(user.ID)
+	limits, err := pc.planService.GetUserLimits(c.Request.Context(), user.ID)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get limits")
 		return
@@ -508,7 +445,7 @@ func (pc *PlanController) StripeWebhook(c *gin.Context) {
 		return
 	}
 
-	err = pc.planService.HandleStripeWebhook(payload, signature)
+	err = pc.planService.HandleStripeWebhook(c.Request.Context(), payload, signature)
 	if err != nil {
 		utils.BadRequestResponse(c, "Failed to process webhook")
 		return
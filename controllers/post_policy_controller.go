@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"oncloud/models"
+	"oncloud/services"
+	"oncloud/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PostPolicyController struct {
+	postPolicyService *services.PostPolicyService
+}
+
+func NewPostPolicyController(deps services.Dependencies) *PostPolicyController {
+	return &PostPolicyController{
+		postPolicyService: services.NewPostPolicyServiceWith(deps),
+	}
+}
+
+// CreatePolicy returns a form a browser can upload a file straight to the
+// storage bucket with
+func (pc *PostPolicyController) CreatePolicy(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	req, ok := utils.BoundRequest[models.PostPolicyUploadRequest](c)
+	if !ok {
+		return
+	}
+
+	form, err := pc.postPolicyService.CreatePolicy(c.Request.Context(), user, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrPostPolicyUnsupported):
+			utils.ErrorResponse(c, http.StatusNotImplemented, "The storage provider does not support form uploads", nil)
+		case errors.Is(err, services.ErrPostPolicyLimitReached):
+			utils.ForbiddenResponse(c, err.Error())
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to create upload form")
+		}
+		return
+	}
+
+	utils.CreatedResponse(c, "Upload form created successfully", form)
+}
+
+// CompleteUpload creates the file a form uploaded to the bucket
+func (pc *PostPolicyController) CompleteUpload(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	uploadID, err := utils.StringToObjectID(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid upload ID")
+		return
+	}
+	req, ok := utils.BoundRequest[models.PostPolicyCompleteRequest](c)
+	if !ok {
+		return
+	}
+
+	file, err := pc.postPolicyService.CompleteUpload(c.Request.Context(), user.ID, uploadID, req)
+	if respondFileTypeMismatch(c, err) || respondFileTypeBlocked(c, err) {
+		return
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrPostPolicyUploadNotFound):
+			utils.NotFoundResponse(c, "Upload not found")
+		case errors.Is(err, services.ErrPostPolicyKeyMismatch), errors.Is(err, services.ErrPostPolicyObjectMissing):
+			utils.BadRequestResponse(c, err.Error())
+		case errors.Is(err, services.ErrPostPolicyTooLarge):
+			utils.PayloadTooLargeResponse(c, err.Error())
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to complete upload")
+		}
+		return
+	}
+
+	utils.FileUploadResponse(c, "File uploaded successfully", file, "")
+}
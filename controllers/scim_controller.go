@@ -0,0 +1,506 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"oncloud/models"
+	"oncloud/services"
+	"oncloud/utils"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type ScimController struct {
+	scimService  *services.ScimService
+	auditService *services.AuditService
+}
+
+func NewScimController() *ScimController {
+	return &ScimController{
+		scimService:  services.NewScimService(),
+		auditService: services.NewAuditService(),
+	}
+}
+
+// ServiceProviderConfig describes the SCIM features this server supports
+func (sc *ScimController) ServiceProviderConfig(c *gin.Context) {
+	utils.ScimResponse(c, http.StatusOK, gin.H{
+		"schemas":        []string{models.ScimServiceConfig},
+		"patch":          gin.H{"supported": true},
+		"bulk":           gin.H{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         gin.H{"supported": true, "maxResults": 200},
+		"changePassword": gin.H{"supported": false},
+		"sort":           gin.H{"supported": false},
+		"etag":           gin.H{"supported": false},
+		"authenticationSchemes": []gin.H{{
+			"type":        "oauthbearertoken",
+			"name":        "OAuth Bearer Token",
+			"description": "Authentication with an admin-issued SCIM token",
+			"primary":     true,
+		}},
+	})
+}
+
+// ResourceTypes lists the SCIM resource types exposed
+func (sc *ScimController) ResourceTypes(c *gin.Context) {
+	resourceTypes := []interface{}{
+		gin.H{
+			"schemas":  []string{models.ScimResourceTypeSchema},
+			"id":       "User",
+			"name":     "User",
+			"endpoint": "/Users",
+			"schema":   models.ScimUserSchema,
+		},
+		gin.H{
+			"schemas":  []string{models.ScimResourceTypeSchema},
+			"id":       "Group",
+			"name":     "Group",
+			"endpoint": "/Groups",
+			"schema":   models.ScimGroupSchema,
+		},
+	}
+
+	utils.ScimResponse(c, http.StatusOK, scimList(resourceTypes, len(resourceTypes), 1))
+}
+
+// ListUsers lists users, optionally filtered by userName, externalId or email
+func (sc *ScimController) ListUsers(c *gin.Context) {
+	startIndex, count := scimPaging(c)
+
+	users, total, err := sc.scimService.ListUsers(c.Request.Context(), c.Query("filter"), startIndex, count)
+	if err != nil {
+		scimServiceError(c, err)
+		return
+	}
+
+	resources := make([]interface{}, 0, len(users))
+	for i := range users {
+		groups, _ := sc.scimService.GetUserGroups(c.Request.Context(), users[i].ID)
+		resources = append(resources, services.ToScimUser(&users[i], groups, scimBaseURL(c)))
+	}
+
+	utils.ScimResponse(c, http.StatusOK, scimList(resources, total, startIndex))
+}
+
+// GetUser returns one user
+func (sc *ScimController) GetUser(c *gin.Context) {
+	userID, ok := scimResourceID(c)
+	if !ok {
+		return
+	}
+
+	user, err := sc.scimService.GetUser(c.Request.Context(), userID)
+	if err != nil {
+		scimServiceError(c, err)
+		return
+	}
+
+	sc.respondUser(c, http.StatusOK, user)
+}
+
+// CreateUser provisions a user
+func (sc *ScimController) CreateUser(c *gin.Context) {
+	var req models.ScimUser
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ScimErrorResponse(c, http.StatusBadRequest, "invalidSyntax", "Invalid request body")
+		return
+	}
+
+	user, err := sc.scimService.CreateUser(c.Request.Context(), &req)
+	if err != nil {
+		scimServiceError(c, err)
+		return
+	}
+
+	sc.audit(c, "scim.user_provisioned", "user", user.ID.Hex())
+	sc.respondUser(c, http.StatusCreated, user)
+}
+
+// ReplaceUser overwrites a user
+func (sc *ScimController) ReplaceUser(c *gin.Context) {
+	userID, ok := scimResourceID(c)
+	if !ok {
+		return
+	}
+
+	var req models.ScimUser
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ScimErrorResponse(c, http.StatusBadRequest, "invalidSyntax", "Invalid request body")
+		return
+	}
+
+	user, err := sc.scimService.ReplaceUser(c.Request.Context(), userID, &req)
+	if err != nil {
+		scimServiceError(c, err)
+		return
+	}
+
+	sc.audit(c, "scim.user_updated", "user", user.ID.Hex())
+	sc.respondUser(c, http.StatusOK, user)
+}
+
+// PatchUser partially updates a user, e.g. setting active to false
+func (sc *ScimController) PatchUser(c *gin.Context) {
+	userID, ok := scimResourceID(c)
+	if !ok {
+		return
+	}
+
+	var req models.ScimPatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ScimErrorResponse(c, http.StatusBadRequest, "invalidSyntax", "Invalid request body")
+		return
+	}
+
+	user, err := sc.scimService.PatchUser(c.Request.Context(), userID, &req)
+	if err != nil {
+		scimServiceError(c, err)
+		return
+	}
+
+	sc.audit(c, "scim.user_updated", "user", user.ID.Hex())
+	sc.respondUser(c, http.StatusOK, user)
+}
+
+// DeleteUser deprovisions a user
+func (sc *ScimController) DeleteUser(c *gin.Context) {
+	userID, ok := scimResourceID(c)
+	if !ok {
+		return
+	}
+
+	if err := sc.scimService.DeprovisionUser(c.Request.Context(), userID); err != nil {
+		scimServiceError(c, err)
+		return
+	}
+
+	sc.audit(c, "scim.user_deprovisioned", "user", userID.Hex())
+	c.Status(http.StatusNoContent)
+}
+
+// ListGroups lists groups, optionally filtered by displayName or externalId
+func (sc *ScimController) ListGroups(c *gin.Context) {
+	startIndex, count := scimPaging(c)
+
+	groups, total, err := sc.scimService.ListGroups(c.Request.Context(), c.Query("filter"), startIndex, count)
+	if err != nil {
+		scimServiceError(c, err)
+		return
+	}
+
+	resources := make([]interface{}, 0, len(groups))
+	for i := range groups {
+		resources = append(resources, services.ToScimGroup(&groups[i], scimBaseURL(c)))
+	}
+
+	utils.ScimResponse(c, http.StatusOK, scimList(resources, total, startIndex))
+}
+
+// GetGroup returns one group
+func (sc *ScimController) GetGroup(c *gin.Context) {
+	groupID, ok := scimResourceID(c)
+	if !ok {
+		return
+	}
+
+	group, err := sc.scimService.GetGroup(c.Request.Context(), groupID)
+	if err != nil {
+		scimServiceError(c, err)
+		return
+	}
+
+	utils.ScimResponse(c, http.StatusOK, services.ToScimGroup(group, scimBaseURL(c)))
+}
+
+// CreateGroup stores a directory group
+func (sc *ScimController) CreateGroup(c *gin.Context) {
+	var req models.ScimGroupResource
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ScimErrorResponse(c, http.StatusBadRequest, "invalidSyntax", "Invalid request body")
+		return
+	}
+
+	group, err := sc.scimService.CreateGroup(c.Request.Context(), &req)
+	if err != nil {
+		scimServiceError(c, err)
+		return
+	}
+
+	sc.audit(c, "scim.group_created", "scim_group", group.ID.Hex())
+	utils.ScimResponse(c, http.StatusCreated, services.ToScimGroup(group, scimBaseURL(c)))
+}
+
+// ReplaceGroup overwrites a group
+func (sc *ScimController) ReplaceGroup(c *gin.Context) {
+	groupID, ok := scimResourceID(c)
+	if !ok {
+		return
+	}
+
+	var req models.ScimGroupResource
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ScimErrorResponse(c, http.StatusBadRequest, "invalidSyntax", "Invalid request body")
+		return
+	}
+
+	group, err := sc.scimService.ReplaceGroup(c.Request.Context(), groupID, &req)
+	if err != nil {
+		scimServiceError(c, err)
+		return
+	}
+
+	sc.audit(c, "scim.group_updated", "scim_group", group.ID.Hex())
+	utils.ScimResponse(c, http.StatusOK, services.ToScimGroup(group, scimBaseURL(c)))
+}
+
+// PatchGroup renames a group or changes its members
+func (sc *ScimController) PatchGroup(c *gin.Context) {
+	groupID, ok := scimResourceID(c)
+	if !ok {
+		return
+	}
+
+	var req models.ScimPatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ScimErrorResponse(c, http.StatusBadRequest, "invalidSyntax", "Invalid request body")
+		return
+	}
+
+	group, err := sc.scimService.PatchGroup(c.Request.Context(), groupID, &req)
+	if err != nil {
+		scimServiceError(c, err)
+		return
+	}
+
+	sc.audit(c, "scim.group_updated", "scim_group", group.ID.Hex())
+	utils.ScimResponse(c, http.StatusOK, services.ToScimGroup(group, scimBaseURL(c)))
+}
+
+// DeleteGroup removes a group
+func (sc *ScimController) DeleteGroup(c *gin.Context) {
+	groupID, ok := scimResourceID(c)
+	if !ok {
+		return
+	}
+
+	if err := sc.scimService.DeleteGroup(c.Request.Context(), groupID); err != nil {
+		scimServiceError(c, err)
+		return
+	}
+
+	sc.audit(c, "scim.group_deleted", "scim_group", groupID.Hex())
+	c.Status(http.StatusNoContent)
+}
+
+// GetTokens lists SCIM tokens for admins
+func (sc *ScimController) GetTokens(c *gin.Context) {
+	tokens, err := sc.scimService.GetTokens(c.Request.Context())
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get SCIM tokens")
+		return
+	}
+
+	utils.SuccessResponse(c, "SCIM tokens retrieved successfully", tokens)
+}
+
+// CreateToken issues a SCIM token. The token is shown once.
+func (sc *ScimController) CreateToken(c *gin.Context) {
+	admin, exists := utils.GetAdminFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "Admin not found in context")
+		return
+	}
+
+	var req models.ScimTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data")
+		return
+	}
+
+	if err := utils.ValidateStruct(&req); err != nil {
+		utils.ValidationErrorResponse(c, err)
+		return
+	}
+
+	token, record, err := sc.scimService.CreateToken(c.Request.Context(), req.Name, admin.ID)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to create SCIM token")
+		return
+	}
+
+	sc.auditService.Record(c.Request.Context(), &models.AuditLog{
+		ActorType:    "admin",
+		ActorID:      &admin.ID,
+		Action:       "scim.token_created",
+		ResourceType: "scim_token",
+		ResourceID:   record.ID.Hex(),
+		IPAddress:    c.ClientIP(),
+		UserAgent:    c.Request.UserAgent(),
+	})
+
+	utils.CreatedResponse(c, "SCIM token created successfully", gin.H{
+		"token":  token,
+		"record": record,
+	})
+}
+
+// RevokeToken disables a SCIM token
+func (sc *ScimController) RevokeToken(c *gin.Context) {
+	admin, exists := utils.GetAdminFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "Admin not found in context")
+		return
+	}
+
+	tokenID := c.Param("id")
+	if !utils.IsValidObjectID(tokenID) {
+		utils.BadRequestResponse(c, "Invalid token ID")
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(tokenID)
+	if err := sc.scimService.RevokeToken(c.Request.Context(), objID); err != nil {
+		utils.NotFoundResponse(c, "SCIM token not found")
+		return
+	}
+
+	sc.auditService.Record(c.Request.Context(), &models.AuditLog{
+		ActorType:    "admin",
+		ActorID:      &admin.ID,
+		Action:       "scim.token_revoked",
+		ResourceType: "scim_token",
+		ResourceID:   tokenID,
+		IPAddress:    c.ClientIP(),
+		UserAgent:    c.Request.UserAgent(),
+	})
+
+	utils.SuccessResponse(c, "SCIM token revoked successfully", nil)
+}
+
+// GetGroupMappings lists directory groups with the plans they map to
+func (sc *ScimController) GetGroupMappings(c *gin.Context) {
+	groups, err := sc.scimService.GetGroupsForAdmin(c.Request.Context())
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get SCIM groups")
+		return
+	}
+
+	utils.SuccessResponse(c, "SCIM groups retrieved successfully", groups)
+}
+
+// UpdateGroupMapping maps a directory group to a plan
+func (sc *ScimController) UpdateGroupMapping(c *gin.Context) {
+	groupID := c.Param("id")
+	if !utils.IsValidObjectID(groupID) {
+		utils.BadRequestResponse(c, "Invalid group ID")
+		return
+	}
+
+	var req models.ScimGroupMappingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data")
+		return
+	}
+
+	var planID *primitive.ObjectID
+	if req.PlanID != "" {
+		if !utils.IsValidObjectID(req.PlanID) {
+			utils.BadRequestResponse(c, "Invalid plan ID")
+			return
+		}
+		id, _ := utils.StringToObjectID(req.PlanID)
+		planID = &id
+	}
+
+	objID, _ := utils.StringToObjectID(groupID)
+	group, err := sc.scimService.SetGroupPlan(c.Request.Context(), objID, planID)
+	if err != nil {
+		utils.BadRequestResponse(c, err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, "SCIM group mapping updated successfully", group)
+}
+
+func (sc *ScimController) respondUser(c *gin.Context, status int, user *models.User) {
+	groups, _ := sc.scimService.GetUserGroups(c.Request.Context(), user.ID)
+	utils.ScimResponse(c, status, services.ToScimUser(user, groups, scimBaseURL(c)))
+}
+
+// audit records a provisioning change made by the directory behind the token
+func (sc *ScimController) audit(c *gin.Context, action, resourceType, resourceID string) {
+	entry := &models.AuditLog{
+		ActorType:    "scim",
+		Action:       action,
+		ResourceType: resourceType,
+		ResourceID:   resourceID,
+		IPAddress:    c.ClientIP(),
+		UserAgent:    c.Request.UserAgent(),
+	}
+	if token, ok := c.Get("scim_token"); ok {
+		if record, ok := token.(*models.ScimToken); ok {
+			entry.ActorID = &record.ID
+			entry.Details = map[string]interface{}{"token": record.Name}
+		}
+	}
+
+	sc.auditService.Record(c.Request.Context(), entry)
+}
+
+// scimServiceError maps service errors onto SCIM error responses
+func scimServiceError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrScimNotFound):
+		utils.ScimErrorResponse(c, http.StatusNotFound, "", "Resource not found")
+	case errors.Is(err, services.ErrScimUniqueness):
+		utils.ScimErrorResponse(c, http.StatusConflict, "uniqueness", err.Error())
+	case errors.Is(err, services.ErrScimInvalidFilter):
+		utils.ScimErrorResponse(c, http.StatusBadRequest, "invalidFilter", "Only `attribute eq \"value\"` filters are supported")
+	case errors.Is(err, services.ErrScimInvalidValue):
+		utils.ScimErrorResponse(c, http.StatusBadRequest, "invalidValue", err.Error())
+	default:
+		utils.ScimErrorResponse(c, http.StatusInternalServerError, "", "Internal server error")
+	}
+}
+
+func scimResourceID(c *gin.Context) (primitive.ObjectID, bool) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		utils.ScimErrorResponse(c, http.StatusNotFound, "", "Resource not found")
+		return primitive.NilObjectID, false
+	}
+	return id, true
+}
+
+func scimPaging(c *gin.Context) (int, int) {
+	startIndex, err := strconv.Atoi(c.DefaultQuery("startIndex", "1"))
+	if err != nil || startIndex < 1 {
+		startIndex = 1
+	}
+	count, err := strconv.Atoi(c.DefaultQuery("count", "100"))
+	if err != nil || count < 0 {
+		count = 100
+	}
+	return startIndex, count
+}
+
+func scimList(resources []interface{}, total, startIndex int) *models.ScimListResponse {
+	return &models.ScimListResponse{
+		Schemas:      []string{models.ScimListResponseSchema},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	}
+}
+
+// scimBaseURL is the absolute SCIM root used in resource locations
+func scimBaseURL(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host + "/scim/v2"
+}
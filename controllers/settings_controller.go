@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"errors"
 	"oncloud/services"
 	"oncloud/utils"
 
@@ -22,7 +23,7 @@ func (sc *SettingsController) GetSettings(c *gin.Context) {
 	group := c.Query("group")
 	includePrivate := c.Query("include_private") == "true"
 
-	settings, err := sc.settingsService.GetSettings(group, includePrivate)
+	settings, err := sc.settingsService.GetSettings(c.Request.Context(), group, includePrivate)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get settings")
 		return
@@ -39,7 +40,7 @@ func (sc *SettingsController) UpdateSettings(c *gin.Context) {
 		return
 	}
 
-	err := sc.settingsService.UpdateSettings(req)
+	err := sc.settingsService.UpdateSettings(c.Request.Context(), req)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to update settings")
 		return
@@ -50,7 +51,7 @@ func (sc *SettingsController) UpdateSettings(c *gin.Context) {
 
 // GetSettingGroups returns available setting groups
 func (sc *SettingsController) GetSettingGroups(c *gin.Context) {
-	groups, err := sc.settingsService.GetSettingGroups()
+	groups, err := sc.settingsService.GetSettingGroups(c.Request.Context())
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get setting groups")
 		return
@@ -67,7 +68,7 @@ func (sc *SettingsController) GetSettingsByGroup(c *gin.Context) {
 		return
 	}
 
-	settings, err := sc.settingsService.GetSettingsByGroup(group)
+	settings, err := sc.settingsService.GetSettingsByGroup(c.Request.Context(), group)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get settings by group")
 		return
@@ -98,7 +99,7 @@ func (sc *SettingsController) UpdateSetting(c *gin.Context) {
 		return
 	}
 
-	err := sc.settingsService.UpdateSetting(key, req.Value)
+	err := sc.settingsService.UpdateSetting(c.Request.Context(), key, req.Value)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to update setting")
 		return
@@ -119,7 +120,7 @@ func (sc *SettingsController) BackupSettings(c *gin.Context) {
 		return
 	}
 
-	backup, err := sc.settingsService.BackupSettings(req.Name, req.Description)
+	backup, err := sc.settingsService.BackupSettings(c.Request.Context(), req.Name, req.Description)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to backup settings")
 		return
@@ -150,7 +151,7 @@ func (sc *SettingsController) RestoreSettings(c *gin.Context) {
 	}
 
 	backupObjID, _ := utils.StringToObjectID(req.BackupID)
-	err := sc.settingsService.RestoreSettings(backupObjID)
+	err := sc.settingsService.RestoreSettings(c.Request.Context(), backupObjID)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to restore settings")
 		return
@@ -158,3 +159,58 @@ func (sc *SettingsController) RestoreSettings(c *gin.Context) {
 
 	utils.SuccessResponse(c, "Settings restored successfully", nil)
 }
+
+// GetRuntimeSettings returns the settings which take effect without a
+// restart, with their environment values
+func (sc *SettingsController) GetRuntimeSettings(c *gin.Context) {
+	settings, err := sc.settingsService.GetRuntimeSettings(c.Request.Context())
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get runtime settings")
+		return
+	}
+
+	utils.SuccessResponse(c, "Runtime settings retrieved successfully", settings)
+}
+
+// UpdateRuntimeSetting overrides a runtime setting
+func (sc *SettingsController) UpdateRuntimeSetting(c *gin.Context) {
+	admin, exists := utils.GetAdminFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "Admin not found")
+		return
+	}
+
+	var req struct {
+		Value interface{} `json:"value"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Value == nil {
+		utils.BadRequestResponse(c, "A value is required")
+		return
+	}
+
+	if err := sc.settingsService.UpdateRuntimeSetting(c.Request.Context(), c.Param("key"), req.Value, admin.ID); err != nil {
+		respondRuntimeSettingError(c, err, "Failed to update runtime setting")
+		return
+	}
+
+	utils.SuccessResponse(c, "Runtime setting updated successfully", nil)
+}
+
+// ResetRuntimeSetting returns a runtime setting to its environment value
+func (sc *SettingsController) ResetRuntimeSetting(c *gin.Context) {
+	if err := sc.settingsService.ResetRuntimeSetting(c.Request.Context(), c.Param("key")); err != nil {
+		respondRuntimeSettingError(c, err, "Failed to reset runtime setting")
+		return
+	}
+
+	utils.SuccessResponse(c, "Runtime setting reset successfully", nil)
+}
+
+func respondRuntimeSettingError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrInvalidRuntimeSetting):
+		utils.BadRequestResponse(c, err.Error())
+	default:
+		utils.ServiceErrorResponse(c, err, message)
+	}
+}
@@ -0,0 +1,145 @@
+package controllers
+
+import (
+	"errors"
+	"oncloud/models"
+	"oncloud/services"
+	"oncloud/utils"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ShareEmbedController struct {
+	shareEmbedService *services.ShareEmbedService
+}
+
+func NewShareEmbedController(deps services.Dependencies) *ShareEmbedController {
+	return &ShareEmbedController{
+		shareEmbedService: services.NewShareEmbedServiceWith(deps),
+	}
+}
+
+// SetEmbed sets which sites may embed a file's share
+func (sec *ShareEmbedController) SetEmbed(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	fileID := c.Param("id")
+	if !utils.IsValidObjectID(fileID) {
+		utils.BadRequestResponse(c, "Invalid file ID")
+		return
+	}
+
+	req, ok := utils.BoundRequest[models.ShareEmbedRequest](c)
+	if !ok {
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(fileID)
+	share, err := sec.shareEmbedService.SetEmbed(c.Request.Context(), user.ID, objID, req)
+	if err != nil {
+		respondShareEmbedError(c, err, "Failed to update embed settings")
+		return
+	}
+
+	utils.SuccessResponse(c, "Embed settings updated successfully", share)
+}
+
+// CreateEmbedURL signs an expiring URL embedding a shared image or video
+func (sec *ShareEmbedController) CreateEmbedURL(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	fileID := c.Param("id")
+	if !utils.IsValidObjectID(fileID) {
+		utils.BadRequestResponse(c, "Invalid file ID")
+		return
+	}
+
+	req, ok := utils.BoundRequest[models.ShareEmbedURLRequest](c)
+	if !ok {
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(fileID)
+	embed, err := sec.shareEmbedService.CreateEmbedURL(c.Request.Context(), user.ID, objID, time.Duration(req.ExpiresIn)*time.Second)
+	if err != nil {
+		respondShareEmbedError(c, err, "Failed to create embed URL")
+		return
+	}
+
+	utils.CreatedResponse(c, "Embed URL created successfully", embed)
+}
+
+// GetShareAnalytics returns a file share's downloads and embed views
+func (sec *ShareEmbedController) GetShareAnalytics(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	fileID := c.Param("id")
+	if !utils.IsValidObjectID(fileID) {
+		utils.BadRequestResponse(c, "Invalid file ID")
+		return
+	}
+
+	days, err := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if err != nil || days < 1 || days > 365 {
+		utils.BadRequestResponse(c, "days must be between 1 and 365")
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(fileID)
+	analytics, err := sec.shareEmbedService.GetAnalytics(c.Request.Context(), user.ID, objID, days)
+	if err != nil {
+		respondShareEmbedError(c, err, "Failed to get share analytics")
+		return
+	}
+
+	utils.SuccessResponse(c, "Share analytics retrieved successfully", analytics)
+}
+
+// ServeEmbed streams the image or video behind a signed embed URL (no
+// authentication required)
+func (sec *ShareEmbedController) ServeEmbed(c *gin.Context) {
+	err := sec.shareEmbedService.ServeEmbed(c.Request.Context(), c.Param("token"), c.Writer, c.Request)
+	if err == nil || respondFileScanBlocked(c, err) || respondContentTakenDown(c, err) || respondFileExpired(c, err) || respondFileArchived(c, err) {
+		return
+	}
+
+	switch {
+	case errors.Is(err, services.ErrShareEmbedNotFound), errors.Is(err, services.ErrShareEmbedMedia),
+		errors.Is(err, services.ErrShareEmbedPassword), errors.Is(err, services.ErrShareEmbedReceipts),
+		errors.Is(err, services.ErrShareEmbedWatermarked), errors.Is(err, services.ErrShareEmbedViewOnly):
+		utils.NotFoundResponse(c, "Embed not found or expired")
+	default:
+		utils.ServiceErrorResponse(c, err, "Failed to serve embed")
+	}
+}
+
+func respondShareEmbedError(c *gin.Context, err error, message string) {
+	if respondFileScanBlocked(c, err) || respondContentTakenDown(c, err) || respondFileExpired(c, err) || respondFileArchived(c, err) {
+		return
+	}
+
+	switch {
+	case errors.Is(err, services.ErrShareLinkNotFound), errors.Is(err, services.ErrShareEmbedNotFound):
+		utils.NotFoundResponse(c, "Share not found")
+	case errors.Is(err, services.ErrShareEmbedMedia), errors.Is(err, services.ErrShareEmbedPassword),
+		errors.Is(err, services.ErrShareEmbedReceipts), errors.Is(err, services.ErrShareEmbedReferrer),
+		errors.Is(err, services.ErrShareEmbedWatermarked), errors.Is(err, services.ErrShareEmbedViewOnly):
+		utils.BadRequestResponse(c, err.Error())
+	default:
+		utils.ServiceErrorResponse(c, err, message)
+	}
+}
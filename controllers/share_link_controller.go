@@ -0,0 +1,205 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"oncloud/models"
+	"oncloud/services"
+	"oncloud/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ShareLinkController struct {
+	shareLinkService *services.ShareLinkService
+	fileController   *FileController
+	folderController *FolderController
+}
+
+func NewShareLinkController(deps services.Dependencies) *ShareLinkController {
+	return &ShareLinkController{
+		shareLinkService: services.NewShareLinkServiceWith(deps),
+		fileController:   NewFileController(deps),
+		folderController: NewFolderController(deps),
+	}
+}
+
+// Open serves the share a short link names, as its token link would
+func (slc *ShareLinkController) Open(c *gin.Context) {
+	if c.GetString("share_kind") == services.ShareKindFolder {
+		slc.folderController.SharedFolderAccess(c)
+		return
+	}
+	slc.fileController.SharedDownload(c)
+}
+
+// VerifyPassword unlocks the password protected file share a short link
+// names
+func (slc *ShareLinkController) VerifyPassword(c *gin.Context) {
+	if c.GetString("share_kind") != services.ShareKindFile {
+		utils.NotFoundResponse(c, "Share not found")
+		return
+	}
+	slc.fileController.VerifySharePassword(c)
+}
+
+// SetFileSlug gives a file's share link a custom name
+func (slc *ShareLinkController) SetFileSlug(c *gin.Context) {
+	slc.setSlug(c, services.ShareKindFile)
+}
+
+// ClearFileSlug removes the custom name of a file's share link
+func (slc *ShareLinkController) ClearFileSlug(c *gin.Context) {
+	slc.clearSlug(c, services.ShareKindFile)
+}
+
+// SetFolderSlug gives a folder's share link a custom name
+func (slc *ShareLinkController) SetFolderSlug(c *gin.Context) {
+	slc.setSlug(c, services.ShareKindFolder)
+}
+
+// ClearFolderSlug removes the custom name of a folder's share link
+func (slc *ShareLinkController) ClearFolderSlug(c *gin.Context) {
+	slc.clearSlug(c, services.ShareKindFolder)
+}
+
+func (slc *ShareLinkController) setSlug(c *gin.Context, kind string) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	itemID := c.Param("id")
+	if !utils.IsValidObjectID(itemID) {
+		utils.BadRequestResponse(c, "Invalid ID")
+		return
+	}
+
+	req, ok := utils.BoundRequest[models.ShareSlugRequest](c)
+	if !ok {
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(itemID)
+	share, err := slc.shareLinkService.SetSlug(c.Request.Context(), user.ID, objID, kind, req.Slug)
+	if err != nil {
+		respondShareLinkError(c, err, "Failed to set share slug")
+		return
+	}
+
+	utils.SuccessResponse(c, "Share slug set successfully", gin.H{
+		"share":     share,
+		"share_url": services.ShareLinkURL(c.Request.Context(), share, kind),
+	})
+}
+
+func (slc *ShareLinkController) clearSlug(c *gin.Context, kind string) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	itemID := c.Param("id")
+	if !utils.IsValidObjectID(itemID) {
+		utils.BadRequestResponse(c, "Invalid ID")
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(itemID)
+	if err := slc.shareLinkService.ClearSlug(c.Request.Context(), user.ID, objID, kind); err != nil {
+		respondShareLinkError(c, err, "Failed to remove share slug")
+		return
+	}
+
+	utils.SuccessResponse(c, "Share slug removed successfully", nil)
+}
+
+// GetDomain returns the domain the user's share links are served on
+func (slc *ShareLinkController) GetDomain(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	domain, err := slc.shareLinkService.GetDomain(c.Request.Context(), user.ID)
+	if err != nil {
+		respondShareLinkError(c, err, "Failed to get share domain")
+		return
+	}
+
+	utils.SuccessResponse(c, "Share domain retrieved successfully", domain)
+}
+
+// SetDomain sets the domain the user's share links are served on, to be
+// verified before links use it
+func (slc *ShareLinkController) SetDomain(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	req, ok := utils.BoundRequest[models.ShareDomainRequest](c)
+	if !ok {
+		return
+	}
+
+	domain, err := slc.shareLinkService.SetDomain(c.Request.Context(), user.ID, req)
+	if err != nil {
+		respondShareLinkError(c, err, "Failed to set share domain")
+		return
+	}
+
+	utils.SuccessResponse(c, "Share domain set successfully", domain)
+}
+
+// VerifyDomain checks the verification record of the user's share domain
+func (slc *ShareLinkController) VerifyDomain(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	domain, err := slc.shareLinkService.VerifyDomain(c.Request.Context(), user.ID)
+	if err != nil {
+		respondShareLinkError(c, err, "Failed to verify share domain")
+		return
+	}
+
+	utils.SuccessResponse(c, "Share domain verified successfully", domain)
+}
+
+// DeleteDomain stops serving the user's share links on their domain
+func (slc *ShareLinkController) DeleteDomain(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	if err := slc.shareLinkService.DeleteDomain(c.Request.Context(), user.ID); err != nil {
+		respondShareLinkError(c, err, "Failed to delete share domain")
+		return
+	}
+
+	utils.SuccessResponse(c, "Share domain deleted successfully", nil)
+}
+
+func respondShareLinkError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrShareLinkPlan):
+		utils.ErrorResponse(c, http.StatusPaymentRequired, err.Error(), nil)
+	case errors.Is(err, services.ErrShareSlugInvalid), errors.Is(err, services.ErrShareSlugReserved):
+		utils.BadRequestResponse(c, err.Error())
+	case errors.Is(err, services.ErrShareSlugTaken), errors.Is(err, services.ErrShareDomainTaken):
+		utils.ConflictResponse(c, err.Error())
+	case errors.Is(err, services.ErrShareDomainUnverified):
+		utils.BadRequestResponse(c, services.ErrShareDomainUnverified.Error())
+	default:
+		utils.ServiceErrorResponse(c, err, message)
+	}
+}
@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"oncloud/services"
+	"oncloud/utils"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SharePreviewController struct {
+	previewService     *services.DocumentPreviewService
+	markdownService    *services.MarkdownPreviewService
+	mediaStreamService *services.MediaStreamService
+}
+
+func NewSharePreviewController(deps services.Dependencies) *SharePreviewController {
+	return &SharePreviewController{
+		previewService:     services.NewDocumentPreviewServiceWith(deps),
+		markdownService:    services.NewMarkdownPreviewServiceWith(deps),
+		mediaStreamService: services.NewMediaStreamServiceWith(deps),
+	}
+}
+
+// GetSharedPages lists the page images a view-only share shows (no
+// authentication required)
+func (spc *SharePreviewController) GetSharedPages(c *gin.Context) {
+	pages, err := spc.previewService.GetSharedPages(c.Request.Context(), c.Param("token"), shareRecipient(c))
+	if err != nil {
+		respondSharePreviewError(c, err, "Failed to get pages")
+		return
+	}
+
+	utils.SuccessResponse(c, "Pages retrieved successfully", pages)
+}
+
+// GetSharedPage serves a watermarked page image of a view-only share (no
+// authentication required)
+func (spc *SharePreviewController) GetSharedPage(c *gin.Context) {
+	number, err := strconv.Atoi(c.Param("page"))
+	if err != nil || number < 1 {
+		utils.BadRequestResponse(c, "Invalid page number")
+		return
+	}
+
+	err = spc.previewService.ServeSharedPage(c.Request.Context(), c.Param("token"), number, shareRecipient(c), c.ClientIP(), c.Writer, c.Request)
+	if err != nil {
+		respondSharePreviewError(c, err, "Failed to get page")
+	}
+}
+
+// GetSharedDocument renders a shared Markdown document to HTML (no
+// authentication required)
+func (spc *SharePreviewController) GetSharedDocument(c *gin.Context) {
+	document, err := spc.markdownService.RenderShared(c.Request.Context(), c.Param("token"))
+	if err != nil {
+		respondSharePreviewError(c, err, "Failed to render document")
+		return
+	}
+
+	utils.SuccessResponse(c, "Document rendered successfully", document)
+}
+
+// GetSharedAsset serves an image a shared Markdown document shows from its
+// folder (no authentication required)
+func (spc *SharePreviewController) GetSharedAsset(c *gin.Context) {
+	err := spc.markdownService.ServeSharedAsset(c.Request.Context(), c.Param("token"), c.Param("path"), c.Writer, c.Request)
+	if err != nil {
+		respondSharePreviewError(c, err, "Failed to get image")
+	}
+}
+
+// GetSharedPlaylist returns the HLS playlist of a shared video or audio
+// file, once it has been transcoded (no authentication required)
+func (spc *SharePreviewController) GetSharedPlaylist(c *gin.Context) {
+	playlist, err := spc.mediaStreamService.GetSharedPlaylist(c.Request.Context(), c.Param("token"))
+	if errors.Is(err, services.ErrStreamPreparing) {
+		c.Header("Retry-After", "10")
+		utils.ErrorResponseWithCode(c, http.StatusConflict, utils.ErrorCodeStreamPreparing, err.Error(), nil)
+		return
+	}
+	if err != nil {
+		respondSharePreviewError(c, err, "Failed to get playlist")
+		return
+	}
+
+	// The playlist carries signed segment URLs, so it is not kept
+	c.Header("Cache-Control", "private, no-store")
+	c.Data(http.StatusOK, "application/vnd.apple.mpegurl", []byte(playlist))
+}
+
+// GetStreamSegment serves a segment of a shared media stream by its signed
+// URL (no authentication required)
+func (spc *SharePreviewController) GetStreamSegment(c *gin.Context) {
+	err := spc.mediaStreamService.ServeSegment(c.Request.Context(), c.Param("token"), c.Param("segment"), c.Writer, c.Request)
+	if err != nil {
+		respondSharePreviewError(c, err, "Failed to get segment")
+	}
+}
+
+func respondSharePreviewError(c *gin.Context, err error, message string) {
+	if respondFileScanBlocked(c, err) || respondContentTakenDown(c, err) || respondFileExpired(c, err) || respondFileArchived(c, err) {
+		return
+	}
+
+	switch {
+	case errors.Is(err, services.ErrShareLinkNotFound):
+		utils.NotFoundResponse(c, "File not found or access denied")
+	case errors.Is(err, services.ErrPreviewPageNotFound):
+		utils.NotFoundResponse(c, err.Error())
+	case errors.Is(err, services.ErrShareRecipientRequired):
+		utils.BadRequestResponse(c, err.Error())
+	case errors.Is(err, services.ErrPreviewUnavailable):
+		utils.ErrorResponse(c, http.StatusUnprocessableEntity, err.Error(), nil)
+	default:
+		utils.ServiceErrorResponse(c, err, message)
+	}
+}
@@ -0,0 +1,31 @@
+package controllers
+
+import (
+	"oncloud/services"
+	"oncloud/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type StatusController struct {
+	statusService *services.StatusService
+}
+
+func NewStatusController() *StatusController {
+	return &StatusController{
+		statusService: services.NewStatusService(),
+	}
+}
+
+// GetStatus returns the data of the public status page: the health of each
+// component and its uptime over the last day, week and month
+func (sc *StatusController) GetStatus(c *gin.Context) {
+	page, err := sc.statusService.StatusPage(c.Request.Context())
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get status")
+		return
+	}
+
+	c.Header("Cache-Control", "public, max-age=30")
+	utils.SuccessResponse(c, "Status retrieved successfully", page)
+}
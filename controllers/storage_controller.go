@@ -14,9 +14,9 @@ type StorageController struct {
 	storageService *services.StorageService
 }
 
-func NewStorageController() *StorageController {
+func NewStorageController(deps services.Dependencies) *StorageController {
 	return &StorageController{
-		storageService: services.NewStorageService(),
+		storageService: services.NewStorageServiceWith(deps),
 	}
 }
 
@@ -28,7 +28,7 @@ func (sc *StorageController) GetProviders(c *gin.Context) {
 		return
 	}
 
-	providers, err := sc.storageService.GetProviders()
+	providers, err := sc.storageService.GetProviders(c.Request.Context())
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get storage providers")
 		return
@@ -52,7 +52,7 @@ func (sc *StorageController) GetProvider(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(providerID)
-	provider, err := sc.storageService.GetProvider(objID)
+	provider, err := sc.storageService.GetProvider(c.Request.Context(), objID)
 	if err != nil {
 		utils.NotFoundResponse(c, "Storage provider not found")
 		return
@@ -69,7 +69,7 @@ func (sc *StorageController) GetStorageStats(c *gin.Context) {
 		return
 	}
 
-	stats, err := sc.storageService.GetStorageStats()
+	stats, err := sc.storageService.GetStorageStats(c.Request.Context())
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get storage stats")
 		return
@@ -86,7 +86,7 @@ func (sc *StorageController) GetStorageUsage(c *gin.Context) {
 		return
 	}
 
-	usage, err := sc.storageService.GetStorageUsage()
+	usage, err := sc.storageService.GetStorageUsage(c.Request.Context())
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get storage usage")
 		return
@@ -213,7 +213,7 @@ func (sc *StorageController) CheckProvidersHealth(c *gin.Context) {
 		return
 	}
 
-	healthStatus, err := sc.storageService.CheckProvidersHealth()
+	healthStatus, err := sc.storageService.CheckProvidersHealth(c.Request.Context())
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to check providers health")
 		return
@@ -249,7 +249,7 @@ func (sc *StorageController) GetUploadURL(c *gin.Context) {
 	}
 
 	// Use the actual GetUploadURL method from storage service
-	uploadURL, err := sc.storageService.GetUploadURL(user.ID, req.FileName, req.FileSize)
+	uploadURL, err := sc.storageService.GetUploadURL(c.Request.Context(), user.ID, req.FileName, req.FileSize)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to generate upload URL")
 		return
@@ -279,7 +279,7 @@ func (sc *StorageController) InitiateMultipartUpload(c *gin.Context) {
 		return
 	}
 
-	upload, err := sc.storageService.InitiateMultipartUpload(user.ID, req.FileName, req.FileSize)
+	upload, err := sc.storageService.InitiateMultipartUpload(c.Request.Context(), user.ID, req.FileName, req.FileSize)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to initiate multipart upload")
 		return
@@ -542,7 +542,7 @@ func (sc *StorageController) CreateBackup(c *gin.Context) {
 	}
 
 	// Use the actual CreateBackup method from storage service
-	backup, err := sc.storageService.CreateBackup()
+	backup, err := sc.storageService.CreateBackup(c.Request.Context())
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to create backup")
 		return
@@ -558,7 +558,7 @@ func (sc *StorageController) GetBackups(c *gin.Context) {
 		return
 	}
 
-	backups, err := sc.storageService.GetBackups()
+	backups, err := sc.storageService.GetBackups(c.Request.Context())
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get backups")
 		return
@@ -602,7 +602,7 @@ func (sc *StorageController) RestoreBackup(c *gin.Context) {
 		return
 	}
 
-	restoreResult, err := sc.storageService.RestoreBackup(objID)
+	restoreResult, err := sc.storageService.RestoreBackup(c.Request.Context(), objID)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to restore backup")
 		return
@@ -625,7 +625,7 @@ func (sc *StorageController) DeleteBackup(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(backupID)
-	err := sc.storageService.DeleteBackup(objID)
+	err := sc.storageService.DeleteBackup(c.Request.Context(), objID)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to delete backup")
 		return
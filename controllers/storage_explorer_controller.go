@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"errors"
+	"oncloud/services"
+	"oncloud/utils"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// storageExplorerMaxLimit caps the objects listed on a page
+const storageExplorerMaxLimit = 1000
+
+type StorageExplorerController struct {
+	storageExplorerService *services.StorageExplorerService
+}
+
+func NewStorageExplorerController() *StorageExplorerController {
+	return &StorageExplorerController{
+		storageExplorerService: services.NewStorageExplorerService(),
+	}
+}
+
+// ListObjects lists the objects and folders under ?prefix= in a provider's
+// bucket, a page of ?limit= at a time from ?cursor=
+func (ec *StorageExplorerController) ListObjects(c *gin.Context) {
+	providerID, ok := explorerProviderID(c)
+	if !ok {
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit < 1 || limit > storageExplorerMaxLimit {
+		utils.BadRequestResponse(c, "limit must be between 1 and 1000")
+		return
+	}
+
+	listing, err := ec.storageExplorerService.ListObjects(c.Request.Context(), providerID, c.Query("prefix"), c.Query("cursor"), limit)
+	if err != nil {
+		respondStorageExplorerError(c, err, "Failed to list objects")
+		return
+	}
+
+	utils.SuccessResponse(c, "Objects retrieved successfully", listing)
+}
+
+// HeadObject describes the object at ?key= and the records referring to it
+func (ec *StorageExplorerController) HeadObject(c *gin.Context) {
+	providerID, ok := explorerProviderID(c)
+	if !ok {
+		return
+	}
+	key := c.Query("key")
+	if key == "" {
+		utils.BadRequestResponse(c, "key is required")
+		return
+	}
+
+	details, err := ec.storageExplorerService.HeadObject(c.Request.Context(), providerID, key)
+	if err != nil {
+		respondStorageExplorerError(c, err, "Failed to inspect object")
+		return
+	}
+
+	utils.SuccessResponse(c, "Object retrieved successfully", details)
+}
+
+// DeleteObject deletes the object at ?key=. Objects files refer to are only
+// deleted with force=true.
+func (ec *StorageExplorerController) DeleteObject(c *gin.Context) {
+	admin, exists := utils.GetAdminFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "Admin not found")
+		return
+	}
+
+	providerID, ok := explorerProviderID(c)
+	if !ok {
+		return
+	}
+	key := c.Query("key")
+	if key == "" {
+		utils.BadRequestResponse(c, "key is required")
+		return
+	}
+
+	if err := ec.storageExplorerService.DeleteObject(c.Request.Context(), providerID, key, c.Query("force") == "true", admin.ID); err != nil {
+		respondStorageExplorerError(c, err, "Failed to delete object")
+		return
+	}
+
+	utils.SuccessResponse(c, "Object deleted successfully", nil)
+}
+
+func explorerProviderID(c *gin.Context) (primitive.ObjectID, bool) {
+	providerID, err := utils.StringToObjectID(c.Param("id"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid provider ID")
+		return primitive.NilObjectID, false
+	}
+	return providerID, true
+}
+
+func respondStorageExplorerError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrStorageExplorerUnsupported):
+		utils.BadRequestResponse(c, "This storage provider cannot be browsed")
+	case errors.Is(err, services.ErrStorageObjectReferenced):
+		utils.ConflictResponse(c, "Files refer to this object; delete it with force=true to delete it anyway")
+	default:
+		utils.ServiceErrorResponse(c, err, message)
+	}
+}
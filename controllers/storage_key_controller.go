@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"errors"
+	"oncloud/services"
+	"oncloud/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type StorageKeyController struct {
+	storageKeyService *services.StorageKeyService
+}
+
+func NewStorageKeyController(deps services.Dependencies) *StorageKeyController {
+	return &StorageKeyController{
+		storageKeyService: services.NewStorageKeyServiceWith(deps),
+	}
+}
+
+// StartRekey moves a provider's files to the keys its key template gives
+// them. With dry_run=true it only reports which keys would change.
+func (kc *StorageKeyController) StartRekey(c *gin.Context) {
+	admin, exists := utils.GetAdminFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "Admin not found")
+		return
+	}
+
+	providerID := c.Param("id")
+	if !utils.IsValidObjectID(providerID) {
+		utils.BadRequestResponse(c, "Invalid provider ID")
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(providerID)
+	job, err := kc.storageKeyService.StartRekey(c.Request.Context(), objID, admin.ID, c.Query("dry_run") == "true")
+	if err != nil {
+		respondStorageKeyError(c, err, "Failed to start re-key job")
+		return
+	}
+
+	utils.CreatedResponse(c, "Re-key job started successfully", job)
+}
+
+// GetRekeyJobs lists a provider's re-key jobs
+func (kc *StorageKeyController) GetRekeyJobs(c *gin.Context) {
+	providerID := c.Param("id")
+	if !utils.IsValidObjectID(providerID) {
+		utils.BadRequestResponse(c, "Invalid provider ID")
+		return
+	}
+
+	page, limit := utils.GetPagination(c, 20, 0)
+	objID, _ := utils.StringToObjectID(providerID)
+	jobs, total, err := kc.storageKeyService.ListRekeyJobs(c.Request.Context(), objID, page, limit)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get re-key jobs")
+		return
+	}
+
+	utils.PaginatedResponse(c, "Re-key jobs retrieved successfully", jobs, page, limit, total)
+}
+
+// GetRekeyJob returns the progress of one re-key job
+func (kc *StorageKeyController) GetRekeyJob(c *gin.Context) {
+	providerID := c.Param("id")
+	jobID := c.Param("job_id")
+	if !utils.IsValidObjectID(providerID) || !utils.IsValidObjectID(jobID) {
+		utils.BadRequestResponse(c, "Invalid provider or job ID")
+		return
+	}
+
+	providerObjID, _ := utils.StringToObjectID(providerID)
+	jobObjID, _ := utils.StringToObjectID(jobID)
+	job, err := kc.storageKeyService.GetRekeyJob(c.Request.Context(), providerObjID, jobObjID)
+	if err != nil {
+		respondStorageKeyError(c, err, "Failed to get re-key job")
+		return
+	}
+
+	utils.SuccessResponse(c, "Re-key job retrieved successfully", job)
+}
+
+func respondStorageKeyError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrRekeyJobRunning):
+		utils.ConflictResponse(c, "A re-key job is already running for this provider")
+	default:
+		utils.ServiceErrorResponse(c, err, message)
+	}
+}
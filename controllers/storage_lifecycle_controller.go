@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"oncloud/models"
+	"oncloud/services"
+	"oncloud/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type StorageLifecycleController struct {
+	lifecycleService *services.StorageLifecycleService
+}
+
+func NewStorageLifecycleController() *StorageLifecycleController {
+	return &StorageLifecycleController{
+		lifecycleService: services.NewStorageLifecycleService(),
+	}
+}
+
+// GetLifecycle returns a provider's managed and live bucket lifecycle rules,
+// with any drift between them
+func (lc *StorageLifecycleController) GetLifecycle(c *gin.Context) {
+	providerID := c.Param("id")
+	if !utils.IsValidObjectID(providerID) {
+		utils.BadRequestResponse(c, "Invalid provider ID")
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(providerID)
+	lifecycle, err := lc.lifecycleService.GetLifecycle(c.Request.Context(), objID)
+	if err != nil {
+		respondLifecycleError(c, err, "Failed to get lifecycle rules")
+		return
+	}
+
+	utils.SuccessResponse(c, "Lifecycle rules retrieved successfully", lifecycle)
+}
+
+// SetLifecycle replaces a provider's bucket lifecycle rules
+func (lc *StorageLifecycleController) SetLifecycle(c *gin.Context) {
+	admin, exists := utils.GetAdminFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "Admin not found")
+		return
+	}
+
+	providerID := c.Param("id")
+	if !utils.IsValidObjectID(providerID) {
+		utils.BadRequestResponse(c, "Invalid provider ID")
+		return
+	}
+
+	req, ok := utils.BoundRequest[models.LifecycleRulesRequest](c)
+	if !ok {
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(providerID)
+	lifecycle, err := lc.lifecycleService.SetLifecycleRules(c.Request.Context(), objID, req.Rules, admin.ID)
+	if err != nil {
+		respondLifecycleError(c, err, "Failed to update lifecycle rules")
+		return
+	}
+
+	utils.SuccessResponse(c, "Lifecycle rules updated successfully", lifecycle)
+}
+
+// ReconcileLifecycle applies a provider's managed lifecycle rules to its
+// bucket again, or with adopt=true makes the bucket's rules the managed ones
+func (lc *StorageLifecycleController) ReconcileLifecycle(c *gin.Context) {
+	admin, exists := utils.GetAdminFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "Admin not found")
+		return
+	}
+
+	providerID := c.Param("id")
+	if !utils.IsValidObjectID(providerID) {
+		utils.BadRequestResponse(c, "Invalid provider ID")
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(providerID)
+	lifecycle, err := lc.lifecycleService.ReconcileLifecycle(c.Request.Context(), objID, c.Query("adopt") == "true", admin.ID)
+	if err != nil {
+		respondLifecycleError(c, err, "Failed to reconcile lifecycle rules")
+		return
+	}
+
+	utils.SuccessResponse(c, "Lifecycle rules reconciled successfully", lifecycle)
+}
+
+func respondLifecycleError(c *gin.Context, err error, message string) {
+	var conflictErr *services.LifecycleConflictError
+	switch {
+	case errors.As(err, &conflictErr):
+		utils.ErrorResponse(c, http.StatusConflict,
+			"Lifecycle rules would expire or archive the content of stored files",
+			map[string]interface{}{"conflicts": conflictErr.Conflicts})
+	case errors.Is(err, services.ErrLifecycleUnsupported):
+		utils.BadRequestResponse(c, "This storage provider does not support lifecycle rules")
+	case errors.Is(err, services.ErrLifecycleRuleInvalid):
+		utils.BadRequestResponse(c, err.Error())
+	default:
+		utils.ServiceErrorResponse(c, err, message)
+	}
+}
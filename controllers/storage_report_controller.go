@@ -0,0 +1,58 @@
+package controllers
+
+import (
+	"errors"
+	"oncloud/models"
+	"oncloud/services"
+	"oncloud/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type StorageReportController struct {
+	storageReportService *services.StorageReportService
+}
+
+func NewStorageReportController(deps services.Dependencies) *StorageReportController {
+	return &StorageReportController{
+		storageReportService: services.NewStorageReportServiceWith(deps),
+	}
+}
+
+// GetReports lists the user's monthly storage reports, newest first
+func (src *StorageReportController) GetReports(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	page, limit := utils.GetPagination(c, 12, 0)
+	reports, total, err := src.storageReportService.ListReports(c.Request.Context(), user.ID, page, limit)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get storage reports")
+		return
+	}
+
+	utils.PaginatedResponse(c, "Storage reports retrieved successfully", reports, page, limit, total)
+}
+
+// Unsubscribe turns off the storage report emails of the user an
+// unsubscribe link was sent to (no authentication required)
+func (src *StorageReportController) Unsubscribe(c *gin.Context) {
+	req, ok := utils.BoundRequest[models.UnsubscribeRequest](c)
+	if !ok {
+		return
+	}
+
+	if err := src.storageReportService.Unsubscribe(c.Request.Context(), req.Token); err != nil {
+		if errors.Is(err, services.ErrUnsubscribeToken) {
+			utils.BadRequestResponse(c, err.Error())
+			return
+		}
+		utils.ServiceErrorResponse(c, err, "Failed to unsubscribe")
+		return
+	}
+
+	utils.SuccessResponse(c, "You will no longer receive storage reports", nil)
+}
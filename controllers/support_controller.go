@@ -0,0 +1,302 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"oncloud/models"
+	"oncloud/services"
+	"oncloud/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SupportController struct {
+	supportService *services.SupportService
+}
+
+func NewSupportController() *SupportController {
+	return &SupportController{
+		supportService: services.NewSupportService(),
+	}
+}
+
+// GetUserNotes lists the internal notes on a user's account
+func (sc *SupportController) GetUserNotes(c *gin.Context) {
+	userID := c.Param("id")
+	if !utils.IsValidObjectID(userID) {
+		utils.BadRequestResponse(c, "Invalid user ID")
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(userID)
+	notes, err := sc.supportService.ListNotes(c.Request.Context(), objID)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get notes")
+		return
+	}
+
+	utils.SuccessResponse(c, "Notes retrieved successfully", notes)
+}
+
+// AddUserNote adds an internal note to a user's account
+func (sc *SupportController) AddUserNote(c *gin.Context) {
+	admin, exists := utils.GetAdminFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "Admin not found")
+		return
+	}
+
+	userID := c.Param("id")
+	if !utils.IsValidObjectID(userID) {
+		utils.BadRequestResponse(c, "Invalid user ID")
+		return
+	}
+
+	req, ok := utils.BoundRequest[models.SupportNoteRequest](c)
+	if !ok {
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(userID)
+	note, err := sc.supportService.AddNote(c.Request.Context(), objID, req, admin.ID)
+	if err != nil {
+		respondSupportError(c, err, "Failed to add note")
+		return
+	}
+
+	utils.CreatedResponse(c, "Note added successfully", note)
+}
+
+// UpdateUserNote edits an internal note on a user's account
+func (sc *SupportController) UpdateUserNote(c *gin.Context) {
+	admin, exists := utils.GetAdminFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "Admin not found")
+		return
+	}
+
+	userID, noteID := c.Param("id"), c.Param("note_id")
+	if !utils.IsValidObjectID(userID) || !utils.IsValidObjectID(noteID) {
+		utils.BadRequestResponse(c, "Invalid user or note ID")
+		return
+	}
+
+	req, ok := utils.BoundRequest[models.SupportNoteRequest](c)
+	if !ok {
+		return
+	}
+
+	userObjID, _ := utils.StringToObjectID(userID)
+	noteObjID, _ := utils.StringToObjectID(noteID)
+	note, err := sc.supportService.UpdateNote(c.Request.Context(), userObjID, noteObjID, req, admin.ID)
+	if err != nil {
+		respondSupportError(c, err, "Failed to update note")
+		return
+	}
+
+	utils.SuccessResponse(c, "Note updated successfully", note)
+}
+
+// DeleteUserNote removes an internal note from a user's account
+func (sc *SupportController) DeleteUserNote(c *gin.Context) {
+	admin, exists := utils.GetAdminFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "Admin not found")
+		return
+	}
+
+	userID, noteID := c.Param("id"), c.Param("note_id")
+	if !utils.IsValidObjectID(userID) || !utils.IsValidObjectID(noteID) {
+		utils.BadRequestResponse(c, "Invalid user or note ID")
+		return
+	}
+
+	userObjID, _ := utils.StringToObjectID(userID)
+	noteObjID, _ := utils.StringToObjectID(noteID)
+	if err := sc.supportService.DeleteNote(c.Request.Context(), userObjID, noteObjID, admin.ID); err != nil {
+		respondSupportError(c, err, "Failed to delete note")
+		return
+	}
+
+	utils.SuccessResponse(c, "Note deleted successfully", nil)
+}
+
+// GetTickets lists support tickets, filtered by status, priority, user,
+// file or assignee. status=active lists open and pending tickets.
+func (sc *SupportController) GetTickets(c *gin.Context) {
+	page, limit := utils.GetPagination(c, 20, 0)
+
+	filter := services.SupportTicketFilter{
+		Status:   c.Query("status"),
+		Priority: c.Query("priority"),
+	}
+	if userID := c.Query("user_id"); userID != "" {
+		if !utils.IsValidObjectID(userID) {
+			utils.BadRequestResponse(c, "Invalid user ID")
+			return
+		}
+		objID, _ := utils.StringToObjectID(userID)
+		filter.UserID = &objID
+	}
+	if fileID := c.Query("file_id"); fileID != "" {
+		if !utils.IsValidObjectID(fileID) {
+			utils.BadRequestResponse(c, "Invalid file ID")
+			return
+		}
+		objID, _ := utils.StringToObjectID(fileID)
+		filter.FileID = &objID
+	}
+	if assignedTo := c.Query("assigned_to"); assignedTo != "" {
+		if !utils.IsValidObjectID(assignedTo) {
+			utils.BadRequestResponse(c, "Invalid assignee ID")
+			return
+		}
+		objID, _ := utils.StringToObjectID(assignedTo)
+		filter.AssignedTo = &objID
+	}
+
+	tickets, total, err := sc.supportService.ListTickets(c.Request.Context(), filter, page, limit)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get support tickets")
+		return
+	}
+
+	utils.PaginatedResponse(c, "Support tickets retrieved successfully", tickets, page, limit, total)
+}
+
+// CreateTicket opens a support ticket for a user
+func (sc *SupportController) CreateTicket(c *gin.Context) {
+	admin, exists := utils.GetAdminFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "Admin not found")
+		return
+	}
+
+	req, ok := utils.BoundRequest[models.SupportTicketRequest](c)
+	if !ok {
+		return
+	}
+
+	ticket, err := sc.supportService.CreateTicket(c.Request.Context(), req, admin.ID)
+	if err != nil {
+		respondSupportError(c, err, "Failed to create support ticket")
+		return
+	}
+
+	utils.CreatedResponse(c, "Support ticket created successfully", ticket)
+}
+
+// GetTicket returns one support ticket
+func (sc *SupportController) GetTicket(c *gin.Context) {
+	ticketID := c.Param("id")
+	if !utils.IsValidObjectID(ticketID) {
+		utils.BadRequestResponse(c, "Invalid ticket ID")
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(ticketID)
+	ticket, err := sc.supportService.GetTicket(c.Request.Context(), objID)
+	if err != nil {
+		respondSupportError(c, err, "Failed to get support ticket")
+		return
+	}
+
+	utils.SuccessResponse(c, "Support ticket retrieved successfully", ticket)
+}
+
+// UpdateTicket changes a ticket's status, priority, category or assignee
+func (sc *SupportController) UpdateTicket(c *gin.Context) {
+	admin, exists := utils.GetAdminFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "Admin not found")
+		return
+	}
+
+	ticketID := c.Param("id")
+	if !utils.IsValidObjectID(ticketID) {
+		utils.BadRequestResponse(c, "Invalid ticket ID")
+		return
+	}
+
+	req, ok := utils.BoundRequest[models.SupportTicketUpdateRequest](c)
+	if !ok {
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(ticketID)
+	ticket, err := sc.supportService.UpdateTicket(c.Request.Context(), objID, req, admin.ID)
+	if err != nil {
+		respondSupportError(c, err, "Failed to update support ticket")
+		return
+	}
+
+	utils.SuccessResponse(c, "Support ticket updated successfully", ticket)
+}
+
+// AddTicketComment adds a comment to a ticket's history
+func (sc *SupportController) AddTicketComment(c *gin.Context) {
+	admin, exists := utils.GetAdminFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "Admin not found")
+		return
+	}
+
+	ticketID := c.Param("id")
+	if !utils.IsValidObjectID(ticketID) {
+		utils.BadRequestResponse(c, "Invalid ticket ID")
+		return
+	}
+
+	req, ok := utils.BoundRequest[models.SupportTicketCommentRequest](c)
+	if !ok {
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(ticketID)
+	ticket, err := sc.supportService.AddComment(c.Request.Context(), objID, req.Notes, admin.ID)
+	if err != nil {
+		respondSupportError(c, err, "Failed to add comment")
+		return
+	}
+
+	utils.SuccessResponse(c, "Comment added successfully", ticket)
+}
+
+// ExportTicket exports a ticket to the configured helpdesk
+func (sc *SupportController) ExportTicket(c *gin.Context) {
+	admin, exists := utils.GetAdminFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "Admin not found")
+		return
+	}
+
+	ticketID := c.Param("id")
+	if !utils.IsValidObjectID(ticketID) {
+		utils.BadRequestResponse(c, "Invalid ticket ID")
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(ticketID)
+	ticket, err := sc.supportService.ExportTicket(c.Request.Context(), objID, admin.ID)
+	if err != nil {
+		respondSupportError(c, err, "Failed to export support ticket")
+		return
+	}
+
+	utils.SuccessResponse(c, "Support ticket exported successfully", ticket)
+}
+
+func respondSupportError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrSupportFileNotFound), errors.Is(err, services.ErrSupportAssigneeNotFound):
+		utils.BadRequestResponse(c, err.Error())
+	case errors.Is(err, services.ErrTicketAlreadyExported):
+		utils.ConflictResponse(c, err.Error())
+	case errors.Is(err, services.ErrHelpdeskNotConfigured):
+		utils.ErrorResponse(c, http.StatusServiceUnavailable, err.Error(), nil)
+	case errors.Is(err, services.ErrHelpdeskExportFailed):
+		utils.ErrorResponse(c, http.StatusBadGateway, err.Error(), nil)
+	default:
+		utils.ServiceErrorResponse(c, err, message)
+	}
+}
@@ -0,0 +1,209 @@
+package controllers
+
+import (
+	"errors"
+	"oncloud/models"
+	"oncloud/services"
+	"oncloud/utils"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type TagController struct {
+	tagService *services.TagService
+}
+
+func NewTagController() *TagController {
+	return &TagController{
+		tagService: services.NewTagService(),
+	}
+}
+
+// GetTags lists the user's tags with usage counts
+func (tc *TagController) GetTags(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	page, limit := tagPagination(c, 50)
+	sortBy := c.DefaultQuery("sort", "name") // name, count
+
+	tags, total, err := tc.tagService.ListTags(c.Request.Context(), user.ID, sortBy, page, limit)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get tags")
+		return
+	}
+
+	utils.PaginatedResponse(c, "Tags retrieved successfully", tags, page, limit, total)
+}
+
+// AutocompleteTags suggests the user's tags matching a prefix
+func (tc *TagController) AutocompleteTags(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if limit < 1 || limit > 50 {
+		limit = 10
+	}
+
+	tags, err := tc.tagService.Autocomplete(c.Request.Context(), user.ID, c.Query("q"), limit)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get tag suggestions")
+		return
+	}
+
+	utils.SuccessResponse(c, "Tag suggestions retrieved successfully", tags)
+}
+
+// GetTagFiles lists the user's files carrying a tag
+func (tc *TagController) GetTagFiles(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	tagID := c.Param("id")
+	if !utils.IsValidObjectID(tagID) {
+		utils.BadRequestResponse(c, "Invalid tag ID")
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(tagID)
+	tag, err := tc.tagService.GetTag(c.Request.Context(), user.ID, objID)
+	if err != nil {
+		utils.NotFoundResponse(c, "Tag not found")
+		return
+	}
+
+	page, limit := tagPagination(c, 20)
+	files, total, err := tc.tagService.GetFilesByTag(c.Request.Context(), user.ID, tag, page, limit)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get tagged files")
+		return
+	}
+
+	utils.PaginatedResponse(c, "Tagged files retrieved successfully", files, page, limit, total)
+}
+
+// RenameTag renames a tag on all of the user's files
+func (tc *TagController) RenameTag(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	tagID := c.Param("id")
+	if !utils.IsValidObjectID(tagID) {
+		utils.BadRequestResponse(c, "Invalid tag ID")
+		return
+	}
+
+	var req models.TagRenameRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data")
+		return
+	}
+	if err := utils.ValidateStruct(&req); err != nil {
+		utils.ValidationErrorResponse(c, err)
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(tagID)
+	tag, err := tc.tagService.RenameTag(c.Request.Context(), user.ID, objID, req.Name)
+	if err != nil {
+		respondTagError(c, err, "Failed to rename tag")
+		return
+	}
+
+	utils.SuccessResponse(c, "Tag renamed successfully", tag)
+}
+
+// MergeTags folds several tags into one
+func (tc *TagController) MergeTags(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	var req models.TagMergeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data")
+		return
+	}
+	if err := utils.ValidateStruct(&req); err != nil {
+		utils.ValidationErrorResponse(c, err)
+		return
+	}
+
+	sourceIDs := make([]primitive.ObjectID, 0, len(req.SourceIDs))
+	for _, id := range req.SourceIDs {
+		objID, err := utils.StringToObjectID(id)
+		if err != nil {
+			utils.BadRequestResponse(c, "Invalid tag ID: "+id)
+			return
+		}
+		sourceIDs = append(sourceIDs, objID)
+	}
+
+	tag, err := tc.tagService.MergeTags(c.Request.Context(), user.ID, sourceIDs, req.Target)
+	if err != nil {
+		respondTagError(c, err, "Failed to merge tags")
+		return
+	}
+
+	utils.SuccessResponse(c, "Tags merged successfully", tag)
+}
+
+// DeleteTag removes a tag from all of the user's files
+func (tc *TagController) DeleteTag(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	tagID := c.Param("id")
+	if !utils.IsValidObjectID(tagID) {
+		utils.BadRequestResponse(c, "Invalid tag ID")
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(tagID)
+	if err := tc.tagService.DeleteTag(c.Request.Context(), user.ID, objID); err != nil {
+		respondTagError(c, err, "Failed to delete tag")
+		return
+	}
+
+	utils.SuccessResponse(c, "Tag deleted successfully", nil)
+}
+
+func respondTagError(c *gin.Context, err error, message string) {
+	if errors.Is(err, services.ErrTagNotFound) {
+		utils.NotFoundResponse(c, "Tag not found")
+		return
+	}
+	utils.ServiceErrorResponse(c, err, message)
+}
+
+func tagPagination(c *gin.Context, defaultLimit int) (int, int) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultLimit)))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = defaultLimit
+	}
+	return page, limit
+}
@@ -0,0 +1,251 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"oncloud/models"
+	"oncloud/services"
+	"oncloud/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TakedownController struct {
+	takedownService *services.TakedownService
+}
+
+func NewTakedownController() *TakedownController {
+	return &TakedownController{
+		takedownService: services.NewTakedownService(),
+	}
+}
+
+// GetMyTakedowns lists the takedown cases about the user's content
+func (tc *TakedownController) GetMyTakedowns(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	page, limit := utils.GetPagination(c, 20, 0)
+	cases, total, err := tc.takedownService.ListCases(c.Request.Context(), services.TakedownFilter{
+		Status:  c.Query("status"),
+		OwnerID: &user.ID,
+	}, page, limit)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get takedown cases")
+		return
+	}
+
+	utils.PaginatedResponse(c, "Takedown cases retrieved successfully", cases, page, limit, total)
+}
+
+// GetMyTakedown returns one takedown case about the user's content
+func (tc *TakedownController) GetMyTakedown(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	caseID := c.Param("id")
+	if !utils.IsValidObjectID(caseID) {
+		utils.BadRequestResponse(c, "Invalid takedown case ID")
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(caseID)
+	takedown, err := tc.takedownService.GetOwnerCase(c.Request.Context(), user.ID, objID)
+	if err != nil {
+		respondTakedownError(c, err, "Failed to get takedown case")
+		return
+	}
+
+	utils.SuccessResponse(c, "Takedown case retrieved successfully", takedown)
+}
+
+// SubmitCounterNotice disputes a takedown of the user's content
+func (tc *TakedownController) SubmitCounterNotice(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	caseID := c.Param("id")
+	if !utils.IsValidObjectID(caseID) {
+		utils.BadRequestResponse(c, "Invalid takedown case ID")
+		return
+	}
+
+	req, ok := utils.BoundRequest[models.TakedownCounterNoticeRequest](c)
+	if !ok {
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(caseID)
+	takedown, err := tc.takedownService.SubmitCounterNotice(c.Request.Context(), user.ID, objID, req)
+	if err != nil {
+		respondTakedownError(c, err, "Failed to submit counter notice")
+		return
+	}
+
+	utils.SuccessResponse(c, "Counter notice submitted successfully", takedown)
+}
+
+// GetTakedowns lists takedown cases, filtered by status, owner or file
+func (tc *TakedownController) GetTakedowns(c *gin.Context) {
+	page, limit := utils.GetPagination(c, 20, 0)
+
+	filter := services.TakedownFilter{Status: c.Query("status")}
+	if ownerID := c.Query("owner_id"); ownerID != "" {
+		if !utils.IsValidObjectID(ownerID) {
+			utils.BadRequestResponse(c, "Invalid owner ID")
+			return
+		}
+		objID, _ := utils.StringToObjectID(ownerID)
+		filter.OwnerID = &objID
+	}
+	if fileID := c.Query("file_id"); fileID != "" {
+		if !utils.IsValidObjectID(fileID) {
+			utils.BadRequestResponse(c, "Invalid file ID")
+			return
+		}
+		objID, _ := utils.StringToObjectID(fileID)
+		filter.FileID = &objID
+	}
+
+	cases, total, err := tc.takedownService.ListCases(c.Request.Context(), filter, page, limit)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get takedown cases")
+		return
+	}
+
+	utils.PaginatedResponse(c, "Takedown cases retrieved successfully", cases, page, limit, total)
+}
+
+// CreateTakedown registers a DMCA notice and takes the content down
+func (tc *TakedownController) CreateTakedown(c *gin.Context) {
+	admin, exists := utils.GetAdminFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "Admin not found")
+		return
+	}
+
+	req, ok := utils.BoundRequest[models.TakedownNoticeRequest](c)
+	if !ok {
+		return
+	}
+
+	takedown, err := tc.takedownService.CreateCase(c.Request.Context(), req, admin.ID)
+	if err != nil {
+		respondTakedownError(c, err, "Failed to register takedown notice")
+		return
+	}
+
+	utils.CreatedResponse(c, "Takedown notice registered successfully", takedown)
+}
+
+// GetTakedown returns one takedown case with its history
+func (tc *TakedownController) GetTakedown(c *gin.Context) {
+	caseID := c.Param("id")
+	if !utils.IsValidObjectID(caseID) {
+		utils.BadRequestResponse(c, "Invalid takedown case ID")
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(caseID)
+	takedown, err := tc.takedownService.GetCase(c.Request.Context(), objID)
+	if err != nil {
+		respondTakedownError(c, err, "Failed to get takedown case")
+		return
+	}
+
+	utils.SuccessResponse(c, "Takedown case retrieved successfully", takedown)
+}
+
+// AddTakedownNote adds a note to a takedown case's history
+func (tc *TakedownController) AddTakedownNote(c *gin.Context) {
+	admin, exists := utils.GetAdminFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "Admin not found")
+		return
+	}
+
+	caseID := c.Param("id")
+	if !utils.IsValidObjectID(caseID) {
+		utils.BadRequestResponse(c, "Invalid takedown case ID")
+		return
+	}
+
+	req, ok := utils.BoundRequest[models.TakedownNoteRequest](c)
+	if !ok {
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(caseID)
+	takedown, err := tc.takedownService.AddNote(c.Request.Context(), objID, req.Notes, admin.ID)
+	if err != nil {
+		respondTakedownError(c, err, "Failed to add note")
+		return
+	}
+
+	utils.SuccessResponse(c, "Note added successfully", takedown)
+}
+
+// ResolveTakedown closes a takedown case, restoring the content or keeping it
+// down
+func (tc *TakedownController) ResolveTakedown(c *gin.Context) {
+	admin, exists := utils.GetAdminFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "Admin not found")
+		return
+	}
+
+	caseID := c.Param("id")
+	if !utils.IsValidObjectID(caseID) {
+		utils.BadRequestResponse(c, "Invalid takedown case ID")
+		return
+	}
+
+	req, ok := utils.BoundRequest[models.TakedownResolveRequest](c)
+	if !ok {
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(caseID)
+	takedown, err := tc.takedownService.ResolveCase(c.Request.Context(), objID, req, admin.ID)
+	if err != nil {
+		respondTakedownError(c, err, "Failed to resolve takedown case")
+		return
+	}
+
+	utils.SuccessResponse(c, "Takedown case resolved successfully", takedown)
+}
+
+// respondContentTakenDown writes the response to sharing content disabled by
+// a takedown, when err says so, and reports whether it did
+func respondContentTakenDown(c *gin.Context, err error) bool {
+	if !errors.Is(err, services.ErrContentTakenDown) {
+		return false
+	}
+	utils.ErrorResponse(c, http.StatusUnavailableForLegalReasons,
+		"This content was taken down after a copyright notice and cannot be shared", nil)
+	return true
+}
+
+func respondTakedownError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrTakedownTargetRequired):
+		utils.BadRequestResponse(c, "A file ID, folder ID or link token is required")
+	case errors.Is(err, services.ErrTakedownExists):
+		utils.ConflictResponse(c, "The content already has an open takedown case")
+	case errors.Is(err, services.ErrTakedownClosed):
+		utils.ConflictResponse(c, "The takedown case is already closed")
+	case errors.Is(err, services.ErrCounterNoticeNotOpen):
+		utils.ConflictResponse(c, "Counter notices are no longer accepted for this case")
+	default:
+		utils.ServiceErrorResponse(c, err, message)
+	}
+}
@@ -0,0 +1,132 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"oncloud/models"
+	"oncloud/services"
+	"oncloud/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TrashController struct {
+	trashService *services.TrashService
+}
+
+func NewTrashController(deps services.Dependencies) *TrashController {
+	return &TrashController{
+		trashService: services.NewTrashServiceWith(deps),
+	}
+}
+
+// DownloadTrash streams the files in the user's trash as a zip archive, so
+// they can keep a copy before emptying it
+func (tc *TrashController) DownloadTrash(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", "attachment; filename=\"trash.zip\"")
+	c.Status(http.StatusOK)
+
+	// The archive is streamed, so once it has started an error can only cut
+	// it short
+	if err := tc.trashService.WriteTrashArchive(c.Request.Context(), user.ID, c.Writer); err != nil {
+		log.Printf("Failed to write the trash archive of user %s: %v", user.ID.Hex(), err)
+		c.Abort()
+	}
+}
+
+// RequestEmptyTrash asks for the user's trash to be emptied, answering with
+// what would be deleted and the token that confirms it
+func (tc *TrashController) RequestEmptyTrash(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	job, token, err := tc.trashService.RequestEmpty(c.Request.Context(), user.ID)
+	if err != nil {
+		respondTrashError(c, err, "Failed to request emptying the trash")
+		return
+	}
+
+	utils.CreatedResponse(c, "Confirm with the token to empty the trash", gin.H{
+		"job":   job,
+		"token": token,
+	})
+}
+
+// ConfirmEmptyTrash schedules emptying the trash once the grace period is
+// over
+func (tc *TrashController) ConfirmEmptyTrash(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	req, ok := utils.BoundRequest[models.TrashEmptyConfirmRequest](c)
+	if !ok {
+		return
+	}
+
+	job, err := tc.trashService.ConfirmEmpty(c.Request.Context(), user.ID, req.Token)
+	if err != nil {
+		respondTrashError(c, err, "Failed to confirm emptying the trash")
+		return
+	}
+
+	utils.SuccessResponse(c, "Emptying the trash scheduled", job)
+}
+
+// GetEmptyTrash returns the user's latest request to empty the trash
+func (tc *TrashController) GetEmptyTrash(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	job, err := tc.trashService.GetEmptyJob(c.Request.Context(), user.ID)
+	if err != nil {
+		respondTrashError(c, err, "Failed to get the request to empty the trash")
+		return
+	}
+
+	utils.SuccessResponse(c, "Request to empty the trash retrieved successfully", job)
+}
+
+// CancelEmptyTrash cancels emptying the trash before it runs
+func (tc *TrashController) CancelEmptyTrash(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	job, err := tc.trashService.CancelEmpty(c.Request.Context(), user.ID)
+	if err != nil {
+		respondTrashError(c, err, "Failed to cancel emptying the trash")
+		return
+	}
+
+	utils.SuccessResponse(c, "Emptying the trash canceled", job)
+}
+
+func respondTrashError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrTrashEmpty), errors.Is(err, services.ErrTrashEmptyToken):
+		utils.BadRequestResponse(c, err.Error())
+	case errors.Is(err, services.ErrTrashEmptyScheduled):
+		utils.ConflictResponse(c, err.Error())
+	default:
+		utils.ServiceErrorResponse(c, err, message)
+	}
+}
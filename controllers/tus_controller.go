@@ -0,0 +1,282 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"oncloud/models"
+	"oncloud/services"
+	"oncloud/utils"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// tusContentType is the content type of requests carrying upload data
+const tusContentType = "application/offset+octet-stream"
+
+// StatusChecksumMismatch is the status the tus checksum extension answers a
+// body not matching its Upload-Checksum with
+const StatusChecksumMismatch = 460
+
+// TusController serves the tus resumable upload protocol, so standard tus
+// clients such as tus-js-client and Uppy can upload to oncloud
+type TusController struct {
+	tusService  *services.TusUploadService
+	fileService *services.FileService
+}
+
+func NewTusController(deps services.Dependencies) *TusController {
+	return &TusController{
+		tusService:  services.NewTusUploadServiceWith(deps),
+		fileService: services.NewFileServiceWith(deps),
+	}
+}
+
+// TusHeadersMiddleware answers every tus request with the protocol version
+// and refuses requests made with a version other than the one served. Clients
+// which can only send GET and POST select the method with
+// X-HTTP-Method-Override.
+func TusHeadersMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Tus-Resumable", services.TusVersion)
+		c.Header("Cache-Control", "no-store")
+
+		// OPTIONS is how clients discover the version, so it needs none
+		if c.Request.Method == http.MethodOptions {
+			c.Next()
+			return
+		}
+		if c.GetHeader("Tus-Resumable") != services.TusVersion {
+			c.Header("Tus-Version", services.TusVersion)
+			c.AbortWithStatus(http.StatusPreconditionFailed)
+			return
+		}
+		c.Next()
+	}
+}
+
+// Options describes what the server supports
+func (tc *TusController) Options(c *gin.Context) {
+	c.Header("Tus-Version", services.TusVersion)
+	c.Header("Tus-Extension", services.TusExtensions)
+	c.Header("Tus-Checksum-Algorithm", services.TusChecksumAlgorithms)
+	if maxSize := services.TusMaxSize(); maxSize > 0 {
+		c.Header("Tus-Max-Size", strconv.FormatInt(maxSize, 10))
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Override dispatches a POST carrying X-HTTP-Method-Override to the method
+// it names
+func (tc *TusController) Override(c *gin.Context) {
+	switch c.GetHeader("X-HTTP-Method-Override") {
+	case http.MethodPatch:
+		tc.Patch(c)
+	case http.MethodDelete:
+		tc.Terminate(c)
+	case http.MethodHead:
+		tc.Head(c)
+	default:
+		utils.ErrorResponse(c, http.StatusMethodNotAllowed, "Unsupported method", nil)
+	}
+}
+
+// Create starts an upload of the length in Upload-Length. A body sent along
+// is appended to it right away.
+func (tc *TusController) Create(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	if c.GetHeader("Upload-Defer-Length") != "" {
+		utils.BadRequestResponse(c, "Uploads of deferred length are not supported")
+		return
+	}
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		utils.BadRequestResponse(c, "Invalid Upload-Length")
+		return
+	}
+	metadata, err := services.ParseTusMetadata(c.GetHeader("Upload-Metadata"))
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid Upload-Metadata")
+		return
+	}
+
+	plan, err := tc.fileService.GetUserPlan(c.Request.Context(), user.ID)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get user plan")
+		return
+	}
+	if err := tc.fileService.CheckUploadLimits(user, plan, length); err != nil {
+		utils.ServiceErrorResponse(c, err, "Failed to check upload limits")
+		return
+	}
+
+	upload, err := tc.tusService.CreateUpload(c.Request.Context(), user.ID, length, metadata)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrTusUploadTooLarge):
+			utils.PayloadTooLargeResponse(c, "Upload exceeds the maximum upload size")
+		case errors.Is(err, services.ErrTusInvalidMetadata):
+			utils.BadRequestResponse(c, err.Error())
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to create upload")
+		}
+		return
+	}
+
+	c.Header("Location", "/api/tus/"+upload.ID.Hex())
+	c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+
+	// Empty files are complete as soon as they are created
+	if c.ContentType() == tusContentType || length == 0 {
+		appended, ok := tc.appendChunk(c, user.ID, upload.ID, 0)
+		if !ok {
+			return
+		}
+		tusUploadHeaders(c, appended)
+	}
+	c.Status(http.StatusCreated)
+}
+
+// Head returns how much of an upload has been received
+func (tc *TusController) Head(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	uploadID, err := utils.StringToObjectID(c.Param("id"))
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	upload, err := tc.tusService.GetUpload(c.Request.Context(), user.ID, uploadID)
+	if err != nil {
+		if errors.Is(err, services.ErrTusUploadNotFound) {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	tusUploadHeaders(c, upload)
+	c.Header("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	if len(upload.Metadata) > 0 {
+		c.Header("Upload-Metadata", services.EncodeTusMetadata(upload.Metadata))
+	}
+	c.Status(http.StatusOK)
+}
+
+// Patch appends the body to an upload at Upload-Offset
+func (tc *TusController) Patch(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	if c.ContentType() != tusContentType {
+		utils.ErrorResponse(c, http.StatusUnsupportedMediaType, "Content-Type must be "+tusContentType, nil)
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		utils.BadRequestResponse(c, "Invalid Upload-Offset")
+		return
+	}
+
+	uploadID, err := utils.StringToObjectID(c.Param("id"))
+	if err != nil {
+		utils.NotFoundResponse(c, "Upload not found")
+		return
+	}
+
+	upload, ok := tc.appendChunk(c, user.ID, uploadID, offset)
+	if !ok {
+		return
+	}
+	tusUploadHeaders(c, upload)
+	c.Status(http.StatusNoContent)
+}
+
+// appendChunk appends the request's body to an upload, answering the
+// request when it fails
+func (tc *TusController) appendChunk(c *gin.Context, userID, uploadID primitive.ObjectID, offset int64) (*models.TusUpload, bool) {
+	var checksum *services.TusChecksum
+	if header := c.GetHeader("Upload-Checksum"); header != "" {
+		var err error
+		if checksum, err = services.ParseTusChecksum(header); err != nil {
+			utils.BadRequestResponse(c, err.Error())
+			return nil, false
+		}
+	}
+
+	updated, err := tc.tusService.AppendChunk(c.Request.Context(), userID, uploadID, offset, c.Request.Body, checksum)
+	if err == nil {
+		return updated, true
+	}
+	if respondFileTypeMismatch(c, err) || respondFileTypeBlocked(c, err) {
+		return nil, false
+	}
+	switch {
+	case errors.Is(err, services.ErrTusUploadNotFound):
+		utils.NotFoundResponse(c, "Upload not found")
+	case errors.Is(err, services.ErrTusOffsetMismatch):
+		c.Header("Upload-Offset", strconv.FormatInt(updated.Offset, 10))
+		utils.ConflictResponse(c, "Upload-Offset does not match the upload's offset")
+	case errors.Is(err, services.ErrTusUploadLocked):
+		utils.ErrorResponse(c, http.StatusLocked, err.Error(), nil)
+	case errors.Is(err, services.ErrTusUploadTooLarge):
+		utils.PayloadTooLargeResponse(c, "Upload data exceeds Upload-Length")
+	case errors.Is(err, services.ErrTusChecksumMismatch):
+		utils.ErrorResponse(c, StatusChecksumMismatch, "Checksum mismatch", nil)
+	default:
+		utils.InternalServerErrorResponse(c, "Failed to write upload")
+	}
+	return nil, false
+}
+
+// Terminate discards an upload
+func (tc *TusController) Terminate(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	uploadID, err := utils.StringToObjectID(c.Param("id"))
+	if err != nil {
+		utils.NotFoundResponse(c, "Upload not found")
+		return
+	}
+	if err := tc.tusService.TerminateUpload(c.Request.Context(), user.ID, uploadID); err != nil {
+		switch {
+		case errors.Is(err, services.ErrTusUploadNotFound):
+			utils.NotFoundResponse(c, "Upload not found")
+		case errors.Is(err, services.ErrTusUploadLocked):
+			utils.ErrorResponse(c, http.StatusLocked, err.Error(), nil)
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to terminate upload")
+		}
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// tusUploadHeaders describes where an upload is at. Once it is complete,
+// the file created from it is named in Upload-File-Id.
+func tusUploadHeaders(c *gin.Context, upload *models.TusUpload) {
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	if upload.FileID != nil {
+		c.Header("Upload-File-Id", upload.FileID.Hex())
+		return
+	}
+	c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+}
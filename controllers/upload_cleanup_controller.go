@@ -0,0 +1,43 @@
+package controllers
+
+import (
+	"oncloud/services"
+	"oncloud/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type UploadCleanupController struct {
+	cleanupService *services.UploadCleanupService
+}
+
+func NewUploadCleanupController(deps services.Dependencies) *UploadCleanupController {
+	return &UploadCleanupController{
+		cleanupService: services.NewUploadCleanupServiceWith(deps),
+	}
+}
+
+// GetCleanupRuns lists past runs of the abandoned upload cleanup with the
+// space each reclaimed
+func (uc *UploadCleanupController) GetCleanupRuns(c *gin.Context) {
+	page, limit := utils.GetPagination(c, 20, 0)
+	runs, total, err := uc.cleanupService.ListRuns(c.Request.Context(), page, limit)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get cleanup runs")
+		return
+	}
+
+	utils.PaginatedResponse(c, "Cleanup runs retrieved successfully", runs, page, limit, total)
+}
+
+// RunCleanup removes abandoned uploads now instead of waiting for the next
+// scheduled run
+func (uc *UploadCleanupController) RunCleanup(c *gin.Context) {
+	run, err := uc.cleanupService.RunCleanup(c.Request.Context(), "admin")
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to clean up abandoned uploads")
+		return
+	}
+
+	utils.SuccessResponse(c, "Abandoned uploads cleaned up successfully", run)
+}
@@ -0,0 +1,181 @@
+package controllers
+
+import (
+	"errors"
+	"oncloud/models"
+	"oncloud/services"
+	"oncloud/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type UploadRuleController struct {
+	uploadRuleService *services.UploadRuleService
+}
+
+func NewUploadRuleController() *UploadRuleController {
+	return &UploadRuleController{
+		uploadRuleService: services.NewUploadRuleService(),
+	}
+}
+
+// GetRules lists the user's upload rules in the order they are tried
+func (urc *UploadRuleController) GetRules(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	rules, err := urc.uploadRuleService.ListRules(c.Request.Context(), user.ID)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get upload rules")
+		return
+	}
+
+	utils.SuccessResponse(c, "Upload rules retrieved successfully", rules)
+}
+
+// CreateRule adds an upload rule
+func (urc *UploadRuleController) CreateRule(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	req, ok := utils.BoundRequest[models.UploadRuleRequest](c)
+	if !ok {
+		return
+	}
+
+	rule, err := urc.uploadRuleService.CreateRule(c.Request.Context(), user.ID, req)
+	if err != nil {
+		respondUploadRuleError(c, err, "Failed to create upload rule")
+		return
+	}
+
+	utils.CreatedResponse(c, "Upload rule created successfully", rule)
+}
+
+// UpdateRule replaces an upload rule
+func (urc *UploadRuleController) UpdateRule(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	ruleID := c.Param("id")
+	if !utils.IsValidObjectID(ruleID) {
+		utils.BadRequestResponse(c, "Invalid rule ID")
+		return
+	}
+
+	req, ok := utils.BoundRequest[models.UploadRuleRequest](c)
+	if !ok {
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(ruleID)
+	rule, err := urc.uploadRuleService.UpdateRule(c.Request.Context(), user.ID, objID, req)
+	if err != nil {
+		respondUploadRuleError(c, err, "Failed to update upload rule")
+		return
+	}
+
+	utils.SuccessResponse(c, "Upload rule updated successfully", rule)
+}
+
+// DeleteRule removes an upload rule
+func (urc *UploadRuleController) DeleteRule(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	ruleID := c.Param("id")
+	if !utils.IsValidObjectID(ruleID) {
+		utils.BadRequestResponse(c, "Invalid rule ID")
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(ruleID)
+	if err := urc.uploadRuleService.DeleteRule(c.Request.Context(), user.ID, objID); err != nil {
+		respondUploadRuleError(c, err, "Failed to delete upload rule")
+		return
+	}
+
+	utils.SuccessResponse(c, "Upload rule deleted successfully", nil)
+}
+
+// PreviewRules shows where an upload would be filed, without uploading
+func (urc *UploadRuleController) PreviewRules(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	req, ok := utils.BoundRequest[models.UploadRulePreviewRequest](c)
+	if !ok {
+		return
+	}
+
+	placement, err := urc.uploadRuleService.Preview(c.Request.Context(), user.ID, req)
+	if err != nil {
+		respondUploadRuleError(c, err, "Failed to preview upload rules")
+		return
+	}
+
+	utils.SuccessResponse(c, "Upload rules evaluated successfully", placement)
+}
+
+// GetSettings returns the user's upload settings
+func (urc *UploadRuleController) GetSettings(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	settings, err := urc.uploadRuleService.GetSettings(c.Request.Context(), user.ID)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get upload settings")
+		return
+	}
+
+	utils.SuccessResponse(c, "Upload settings retrieved successfully", settings)
+}
+
+// UpdateSettings replaces the user's upload settings
+func (urc *UploadRuleController) UpdateSettings(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	req, ok := utils.BoundRequest[models.UploadSettingsRequest](c)
+	if !ok {
+		return
+	}
+
+	settings, err := urc.uploadRuleService.UpdateSettings(c.Request.Context(), user.ID, req)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to update upload settings")
+		return
+	}
+
+	utils.SuccessResponse(c, "Upload settings updated successfully", settings)
+}
+
+func respondUploadRuleError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrInvalidUploadRule):
+		utils.BadRequestResponse(c, err.Error())
+	default:
+		utils.ServiceErrorResponse(c, err, message)
+	}
+}
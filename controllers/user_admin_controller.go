@@ -1,6 +1,8 @@
 package controllers
 
 import (
+	"errors"
+	"oncloud/models"
 	"oncloud/services"
 	"oncloud/utils"
 	"strconv"
@@ -9,14 +11,20 @@ import (
 )
 
 type UserAdminController struct {
-	userService  *services.UserService
-	adminService *services.AdminService
+	userService   *services.UserService
+	adminService  *services.AdminService
+	policyService *services.SecurityPolicyService
+	usageService  *services.UserUsageService
+	auditService  *services.AuditService
 }
 
-func NewUserAdminController() *UserAdminController {
+func NewUserAdminController(deps services.Dependencies) *UserAdminController {
 	return &UserAdminController{
-		userService:  services.NewUserService(),
-		adminService: services.NewAdminService(),
+		userService:   services.NewUserServiceWith(deps),
+		adminService:  services.NewAdminServiceWith(deps),
+		policyService: services.NewSecurityPolicyServiceWith(deps),
+		usageService:  services.NewUserUsageServiceWith(deps),
+		auditService:  services.NewAuditServiceWith(deps),
 	}
 }
 
@@ -25,7 +33,7 @@ func (uac *UserAdminController) GetUsers(c *gin.Context) {
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
 	search := c.Query("search")
-	status := c.Query("status") // active, inactive, all
+	status := c.Query("status") // active, inactive, suspended, read_only, pending_deletion, all
 	planID := c.Query("plan_id")
 	sortBy := c.DefaultQuery("sort", "created_at")
 	sortOrder := c.DefaultQuery("order", "desc")
@@ -38,7 +46,7 @@ func (uac *UserAdminController) GetUsers(c *gin.Context) {
 		SortOrder: sortOrder,
 	}
 
-	users, total, err := uac.userService.GetUsersForAdmin(page, limit, filters)
+	users, total, err := uac.userService.GetUsersForAdmin(c.Request.Context(), page, limit, filters)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get users")
 		return
@@ -56,7 +64,7 @@ func (uac *UserAdminController) GetUser(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(userID)
-	user, err := uac.userService.GetUserForAdmin(objID)
+	user, err := uac.userService.GetUserForAdmin(c.Request.Context(), objID)
 	if err != nil {
 		utils.NotFoundResponse(c, "User not found")
 		return
@@ -65,30 +73,92 @@ func (uac *UserAdminController) GetUser(c *gin.Context) {
 	utils.SuccessResponse(c, "User retrieved successfully", user)
 }
 
-// CreateUser creates a new user (admin only)
-func (uac *UserAdminController) CreateUser(c *gin.Context) {
-	var req struct {
-		Username   string `json:"username" validate:"required,min=3,max=50"`
-		Email      string `json:"email" validate:"required,email"`
-		Password   string `json:"password" validate:"required,min=6"`
-		FirstName  string `json:"first_name" validate:"required"`
-		LastName   string `json:"last_name" validate:"required"`
-		PlanID     string `json:"plan_id"`
-		IsActive   bool   `json:"is_active"`
-		IsVerified bool   `json:"is_verified"`
+// GetUserSnapshot returns everything support staff need about a user in one
+// response: profile, plan, usage, recent activity, open shares, storage
+// providers, billing status and flags
+func (uac *UserAdminController) GetUserSnapshot(c *gin.Context) {
+	userID := c.Param("id")
+	if !utils.IsValidObjectID(userID) {
+		utils.BadRequestResponse(c, "Invalid user ID")
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(userID)
+	snapshot, err := uac.userService.GetUserSnapshotForAdmin(c.Request.Context(), objID)
+	if err != nil {
+		if errors.Is(err, services.ErrUserNotFound) {
+			utils.NotFoundResponse(c, "User not found")
+			return
+		}
+		utils.InternalServerErrorResponse(c, "Failed to get user snapshot")
+		return
 	}
 
-	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request data")
+	utils.SuccessResponse(c, "User snapshot retrieved successfully", snapshot)
+}
+
+// CheckUsage compares users' storage and file counters with their files
+// and lists the users whose counters drifted, largest drift first
+func (uac *UserAdminController) CheckUsage(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if limit < 1 || limit > 1000 {
+		limit = 100
+	}
+
+	report, err := uac.usageService.CheckUsage(c.Request.Context(), limit, false)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to check usage")
 		return
 	}
 
-	if err := utils.ValidateStruct(&req); err != nil {
-		utils.ValidationErrorResponse(c, err)
+	utils.SuccessResponse(c, "Usage checked successfully", report)
+}
+
+// RepairUsage corrects the counters of every user whose counters drifted
+func (uac *UserAdminController) RepairUsage(c *gin.Context) {
+	admin, exists := utils.GetAdminFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "Admin not found")
 		return
 	}
 
-	user, err := uac.userService.CreateUserByAdmin(&req)
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if limit < 1 || limit > 1000 {
+		limit = 100
+	}
+
+	report, err := uac.usageService.CheckUsage(c.Request.Context(), limit, true)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to repair usage")
+		return
+	}
+
+	uac.auditService.Record(c.Request.Context(), &models.AuditLog{
+		ActorType:    "admin",
+		ActorID:      &admin.ID,
+		Action:       "usage.repaired",
+		ResourceType: "user",
+		Outcome:      "success",
+		IPAddress:    c.ClientIP(),
+		UserAgent:    c.Request.UserAgent(),
+		Details: map[string]interface{}{
+			"checked_users":  report.CheckedUsers,
+			"drifted_users":  report.DriftedUsers,
+			"repaired_users": report.RepairedUsers,
+		},
+	})
+
+	utils.SuccessResponse(c, "Usage repaired successfully", report)
+}
+
+// CreateUser creates a new user (admin only)
+func (uac *UserAdminController) CreateUser(c *gin.Context) {
+	req, ok := utils.BoundRequest[models.AdminUserCreateRequest](c)
+	if !ok {
+		return
+	}
+
+	user, err := uac.userService.CreateUserByAdmin(req)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to create user")
 		return
@@ -105,14 +175,18 @@ func (uac *UserAdminController) UpdateUser(c *gin.Context) {
 		return
 	}
 
-	var updates map[string]interface{}
-	if err := c.ShouldBindJSON(&updates); err != nil {
-		utils.BadRequestResponse(c, "Invalid request data")
+	req, ok := utils.BoundRequest[models.AdminUserUpdateRequest](c)
+	if !ok {
 		return
 	}
 
+	updates := utils.UpdateFields(req)
+	if req.PlanID != nil {
+		updates["plan_id"], _ = utils.StringToObjectID(*req.PlanID)
+	}
+
 	objID, _ := utils.StringToObjectID(userID)
-	updatedUser, err := uac.userService.UpdateUserByAdmin(objID, updates)
+	updatedUser, err := uac.userService.UpdateUserByAdmin(c.Request.Context(), objID, updates)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to update user")
 		return
@@ -130,7 +204,7 @@ func (uac *UserAdminController) DeleteUser(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(userID)
-	err := uac.userService.DeleteUserByAdmin(objID)
+	err := uac.userService.DeleteUserByAdmin(c.Request.Context(), objID)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to delete user")
 		return
@@ -141,33 +215,39 @@ func (uac *UserAdminController) DeleteUser(c *gin.Context) {
 
 // SuspendUser suspends a user account
 func (uac *UserAdminController) SuspendUser(c *gin.Context) {
-	userID := c.Param("id")
-	if !utils.IsValidObjectID(userID) {
-		utils.BadRequestResponse(c, "Invalid user ID")
+	req, ok := utils.BoundRequest[models.SuspendUserRequest](c)
+	if !ok {
 		return
 	}
 
-	var req struct {
-		Reason string `json:"reason"`
-	}
+	uac.changeAccountState(c, models.AccountStateSuspended, req.Reason, "User suspended successfully")
+}
 
-	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request data")
-		return
-	}
+// UnsuspendUser reactivates a suspended user account
+func (uac *UserAdminController) UnsuspendUser(c *gin.Context) {
+	uac.changeAccountState(c, models.AccountStateActive, "", "User unsuspended successfully")
+}
 
-	objID, _ := utils.StringToObjectID(userID)
-	err := uac.userService.SuspendUser(objID, req.Reason)
-	if err != nil {
-		utils.InternalServerErrorResponse(c, "Failed to suspend user")
+// SetUserState moves a user account to active, suspended, read_only or
+// pending_deletion
+func (uac *UserAdminController) SetUserState(c *gin.Context) {
+	req, ok := utils.BoundRequest[models.AccountStateRequest](c)
+	if !ok {
 		return
 	}
 
-	utils.SuccessResponse(c, "User suspended successfully", nil)
+	uac.changeAccountState(c, req.State, req.Reason, "User state updated successfully")
 }
 
-// UnsuspendUser reactivates a suspended user account
-func (uac *UserAdminController) UnsuspendUser(c *gin.Context) {
+// changeAccountState moves the user in the :id parameter to state and
+// records the change and its reason in the audit log
+func (uac *UserAdminController) changeAccountState(c *gin.Context, state, reason, message string) {
+	admin, exists := utils.GetAdminFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "Admin not found in context")
+		return
+	}
+
 	userID := c.Param("id")
 	if !utils.IsValidObjectID(userID) {
 		utils.BadRequestResponse(c, "Invalid user ID")
@@ -175,13 +255,39 @@ func (uac *UserAdminController) UnsuspendUser(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(userID)
-	err := uac.userService.UnsuspendUser(objID)
+	previous, err := uac.userService.GetByID(c.Request.Context(), objID)
+	if err != nil {
+		utils.NotFoundResponse(c, "User not found")
+		return
+	}
+
+	user, err := uac.userService.SetAccountState(c.Request.Context(), objID, state, reason)
 	if err != nil {
-		utils.InternalServerErrorResponse(c, "Failed to unsuspend user")
+		if errors.Is(err, services.ErrInvalidAccountState) {
+			utils.BadRequestResponse(c, err.Error())
+			return
+		}
+		utils.InternalServerErrorResponse(c, "Failed to change user state")
 		return
 	}
 
-	utils.SuccessResponse(c, "User unsuspended successfully", nil)
+	uac.auditService.Record(c.Request.Context(), &models.AuditLog{
+		ActorType:    "admin",
+		ActorID:      &admin.ID,
+		Action:       "user.state_changed",
+		ResourceType: "user",
+		ResourceID:   objID.Hex(),
+		Outcome:      "success",
+		IPAddress:    c.ClientIP(),
+		UserAgent:    c.Request.UserAgent(),
+		Details: map[string]interface{}{
+			"from":   previous.AccountState(),
+			"to":     user.AccountState(),
+			"reason": reason,
+		},
+	})
+
+	utils.SuccessResponse(c, message, user)
 }
 
 // VerifyUser manually verifies a user account
@@ -193,7 +299,7 @@ func (uac *UserAdminController) VerifyUser(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(userID)
-	err := uac.userService.VerifyUserByAdmin(objID)
+	err := uac.userService.VerifyUserByAdmin(c.Request.Context(), objID)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to verify user")
 		return
@@ -210,29 +316,39 @@ func (uac *UserAdminController) ResetUserPassword(c *gin.Context) {
 		return
 	}
 
-	var req struct {
-		NewPassword string `json:"new_password" validate:"required,min=6"`
-		SendEmail   bool   `json:"send_email"`
+	req, ok := utils.BoundRequest[models.AdminPasswordResetRequest](c)
+	if !ok {
+		return
 	}
 
-	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request data")
+	objID, _ := utils.StringToObjectID(userID)
+	err := uac.userService.ResetUserPasswordByAdmin(c.Request.Context(), objID, req.NewPassword, req.SendEmail)
+	if err != nil {
+		if respondPasswordPolicyError(c, err) {
+			return
+		}
+		utils.InternalServerErrorResponse(c, "Failed to reset user password")
 		return
 	}
 
-	if err := utils.ValidateStruct(&req); err != nil {
-		utils.ValidationErrorResponse(c, err)
+	utils.SuccessResponse(c, "User password reset successfully", nil)
+}
+
+// UnlockUser lifts a failed-login lockout and resets the user's failure count
+func (uac *UserAdminController) UnlockUser(c *gin.Context) {
+	userID := c.Param("id")
+	if !utils.IsValidObjectID(userID) {
+		utils.BadRequestResponse(c, "Invalid user ID")
 		return
 	}
 
 	objID, _ := utils.StringToObjectID(userID)
-	err := uac.userService.ResetUserPasswordByAdmin(objID, req.NewPassword, req.SendEmail)
-	if err != nil {
-		utils.InternalServerErrorResponse(c, "Failed to reset user password")
+	if err := uac.policyService.ClearFailedLogins(c.Request.Context(), objID); err != nil {
+		utils.NotFoundResponse(c, "User not found")
 		return
 	}
 
-	utils.SuccessResponse(c, "User password reset successfully", nil)
+	utils.SuccessResponse(c, "User unlocked successfully", nil)
 }
 
 // GetUserFiles returns files for a specific user
@@ -247,7 +363,7 @@ func (uac *UserAdminController) GetUserFiles(c *gin.Context) {
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
 
 	objID, _ := utils.StringToObjectID(userID)
-	files, total, err := uac.userService.GetUserFilesForAdmin(objID, page, limit)
+	files, total, err := uac.userService.GetUserFilesForAdmin(c.Request.Context(), objID, page, limit)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get user files")
 		return
@@ -269,7 +385,7 @@ func (uac *UserAdminController) GetUserActivity(c *gin.Context) {
 	days, _ := strconv.Atoi(c.DefaultQuery("days", "30"))
 
 	objID, _ := utils.StringToObjectID(userID)
-	activities, total, err := uac.userService.GetUserActivityForAdmin(objID, days, page, limit)
+	activities, total, err := uac.userService.GetUserActivityForAdmin(c.Request.Context(), objID, days, page, limit)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get user activity")
 		return
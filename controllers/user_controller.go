@@ -10,14 +10,20 @@ import (
 )
 
 type UserController struct {
-	userService *services.UserService
-	fileService *services.FileService
+	userService    *services.UserService
+	fileService    *services.FileService
+	accessService  *services.AccessPolicyService
+	auditService   *services.AuditService
+	sessionService *services.SessionService
 }
 
-func NewUserController() *UserController {
+func NewUserController(deps services.Dependencies) *UserController {
 	return &UserController{
-		userService: services.NewUserService(),
-		fileService: services.NewFileService(),
+		userService:    services.NewUserServiceWith(deps),
+		fileService:    services.NewFileServiceWith(deps),
+		accessService:  services.NewAccessPolicyServiceWith(deps),
+		auditService:   services.NewAuditServiceWith(deps),
+		sessionService: services.NewSessionServiceWith(deps),
 	}
 }
 
@@ -30,7 +36,7 @@ func (uc *UserController) GetProfile(c *gin.Context) {
 	}
 
 	// Get user plan
-	plan, err := uc.userService.GetUserPlan(user.ID)
+	plan, err := uc.userService.GetUserPlan(c.Request.Context(), user.ID)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get user plan")
 		return
@@ -74,7 +80,7 @@ func (uc *UserController) UpdateProfile(c *gin.Context) {
 		return
 	}
 
-	updatedUser, err := uc.userService.UpdateProfile(user.ID, req.FirstName, req.LastName, req.Phone, req.Country)
+	updatedUser, err := uc.userService.UpdateProfile(c.Request.Context(), user.ID, req.FirstName, req.LastName, req.Phone, req.Country)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to update profile")
 		return
@@ -109,7 +115,7 @@ func (uc *UserController) UploadAvatar(c *gin.Context) {
 		return
 	}
 
-	avatarURL, err := uc.userService.UploadAvatar(user.ID, file, header)
+	avatarURL, err := uc.userService.UploadAvatar(c.Request.Context(), user.ID, file, header)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to upload avatar")
 		return
@@ -128,7 +134,7 @@ func (uc *UserController) DeleteAvatar(c *gin.Context) {
 		return
 	}
 
-	err := uc.userService.DeleteAvatar(user.ID)
+	err := uc.userService.DeleteAvatar(c.Request.Context(), user.ID)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to delete avatar")
 		return
@@ -145,7 +151,7 @@ func (uc *UserController) GetUserStats(c *gin.Context) {
 		return
 	}
 
-	stats, err := uc.userService.GetUserStats(user.ID)
+	stats, err := uc.userService.GetUserStats(c.Request.Context(), user.ID)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get user stats")
 		return
@@ -162,7 +168,7 @@ func (uc *UserController) GetDashboard(c *gin.Context) {
 		return
 	}
 
-	dashboard, err := uc.userService.GetDashboardData(user.ID)
+	dashboard, err := uc.userService.GetDashboardData(c.Request.Context(), user.ID)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get dashboard data")
 		return
@@ -182,7 +188,7 @@ func (uc *UserController) GetActivity(c *gin.Context) {
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
 
-	activities, total, err := uc.userService.GetUserActivity(user.ID, page, limit)
+	activities, total, err := uc.userService.GetUserActivity(c.Request.Context(), user.ID, page, limit)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get user activity")
 		return
@@ -202,7 +208,7 @@ func (uc *UserController) GetNotifications(c *gin.Context) {
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
 
-	notifications, total, err := uc.userService.GetNotifications(user.ID, page, limit)
+	notifications, total, err := uc.userService.GetNotifications(c.Request.Context(), user.ID, page, limit)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get notifications")
 		return
@@ -226,7 +232,7 @@ func (uc *UserController) MarkNotificationRead(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(notificationID)
-	err := uc.userService.MarkNotificationRead(user.ID, objID)
+	err := uc.userService.MarkNotificationRead(c.Request.Context(), user.ID, objID)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to mark notification as read")
 		return
@@ -243,7 +249,7 @@ func (uc *UserController) GetSettings(c *gin.Context) {
 		return
 	}
 
-	settings, err := uc.userService.GetUserSettings(user.ID)
+	settings, err := uc.userService.GetUserSettings(c.Request.Context(), user.ID)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get user settings")
 		return
@@ -266,7 +272,7 @@ func (uc *UserController) UpdateSettings(c *gin.Context) {
 		return
 	}
 
-	err := uc.userService.UpdateUserSettings(user.ID, settings)
+	err := uc.userService.UpdateUserSettings(c.Request.Context(), user.ID, settings)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to update user settings")
 		return
@@ -275,7 +281,7 @@ func (uc *UserController) UpdateSettings(c *gin.Context) {
 	utils.SuccessResponse(c, "User settings updated successfully", nil)
 }
 
-// GetActiveSessions returns user's active sessions
+// GetActiveSessions returns the devices the user is signed in on
 func (uc *UserController) GetActiveSessions(c *gin.Context) {
 	user, exists := utils.GetUserFromContext(c)
 	if !exists {
@@ -283,7 +289,7 @@ func (uc *UserController) GetActiveSessions(c *gin.Context) {
 		return
 	}
 
-	sessions, err := uc.userService.GetActiveSessions(user.ID)
+	sessions, err := uc.sessionService.GetActiveSessions(c.Request.Context(), user.ID, c.GetString("session_id"))
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get active sessions")
 		return
@@ -292,7 +298,7 @@ func (uc *UserController) GetActiveSessions(c *gin.Context) {
 	utils.SuccessResponse(c, "Active sessions retrieved successfully", sessions)
 }
 
-// RevokeSession revokes a user session
+// RevokeSession signs the user out of one device
 func (uc *UserController) RevokeSession(c *gin.Context) {
 	user, exists := utils.GetUserFromContext(c)
 	if !exists {
@@ -301,15 +307,133 @@ func (uc *UserController) RevokeSession(c *gin.Context) {
 	}
 
 	sessionID := c.Param("id")
-	err := uc.userService.RevokeSession(user.ID, sessionID)
-	if err != nil {
-		utils.InternalServerErrorResponse(c, "Failed to revoke session")
+	if !utils.IsValidObjectID(sessionID) {
+		utils.BadRequestResponse(c, "Invalid session ID")
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(sessionID)
+	if err := uc.sessionService.RevokeSession(c.Request.Context(), user.ID, objID); err != nil {
+		utils.NotFoundResponse(c, "Session not found")
 		return
 	}
 
+	uc.auditService.Record(c.Request.Context(), &models.AuditLog{
+		ActorType:    "user",
+		ActorID:      &user.ID,
+		Action:       "session.revoked",
+		ResourceType: "session",
+		ResourceID:   sessionID,
+		IPAddress:    c.ClientIP(),
+		UserAgent:    c.Request.UserAgent(),
+	})
+
 	utils.SuccessResponse(c, "Session revoked successfully", nil)
 }
 
+// RevokeOtherSessions signs the user out everywhere except the current device
+func (uc *UserController) RevokeOtherSessions(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	revoked, err := uc.sessionService.RevokeOtherSessions(c.Request.Context(), user.ID, c.GetString("session_id"), "revoked")
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to revoke sessions")
+		return
+	}
+
+	uc.auditService.Record(c.Request.Context(), &models.AuditLog{
+		ActorType:    "user",
+		ActorID:      &user.ID,
+		Action:       "session.revoked_others",
+		ResourceType: "session",
+		IPAddress:    c.ClientIP(),
+		UserAgent:    c.Request.UserAgent(),
+		Details:      map[string]interface{}{"sessions_revoked": revoked},
+	})
+
+	utils.SuccessResponse(c, "Other sessions revoked successfully", gin.H{"revoked": revoked})
+}
+
+// GetAccessPolicy returns the user's IP access policy
+func (uc *UserController) GetAccessPolicy(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	policy, err := uc.accessService.GetUserPolicy(c.Request.Context(), user.ID)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to get access policy")
+		return
+	}
+
+	utils.SuccessResponse(c, "Access policy retrieved successfully", policy)
+}
+
+// UpdateAccessPolicy replaces the user's IP access policy
+func (uc *UserController) UpdateAccessPolicy(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	var req models.IPAccessPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data")
+		return
+	}
+
+	policy, err := uc.accessService.SaveUserPolicy(c.Request.Context(), user.ID, &req, c.ClientIP())
+	if err != nil {
+		utils.BadRequestResponse(c, err.Error())
+		return
+	}
+
+	uc.auditService.Record(c.Request.Context(), &models.AuditLog{
+		ActorType:    "user",
+		ActorID:      &user.ID,
+		Action:       "access_policy.updated",
+		ResourceType: "ip_access_policy",
+		ResourceID:   policy.ID.Hex(),
+		IPAddress:    c.ClientIP(),
+		UserAgent:    c.Request.UserAgent(),
+		Details:      map[string]interface{}{"scope": policy.Scope, "is_enabled": policy.IsEnabled},
+	})
+
+	utils.SuccessResponse(c, "Access policy updated successfully", policy)
+}
+
+// DeleteAccessPolicy removes the user's IP access policy
+func (uc *UserController) DeleteAccessPolicy(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	if err := uc.accessService.DeleteUserPolicy(c.Request.Context(), user.ID); err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to delete access policy")
+		return
+	}
+
+	uc.auditService.Record(c.Request.Context(), &models.AuditLog{
+		ActorType:    "user",
+		ActorID:      &user.ID,
+		Action:       "access_policy.deleted",
+		ResourceType: "ip_access_policy",
+		IPAddress:    c.ClientIP(),
+		UserAgent:    c.Request.UserAgent(),
+	})
+
+	utils.SuccessResponse(c, "Access policy deleted successfully", nil)
+}
+
 // API Keys management
 func (uc *UserController) GetAPIKeys(c *gin.Context) {
 	user, exists := utils.GetUserFromContext(c)
@@ -318,7 +442,7 @@ func (uc *UserController) GetAPIKeys(c *gin.Context) {
 		return
 	}
 
-	apiKeys, err := uc.userService.GetAPIKeys(user.ID)
+	apiKeys, err := uc.userService.GetAPIKeys(c.Request.Context(), user.ID)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get API keys")
 		return
@@ -345,7 +469,7 @@ func (uc *UserController) CreateAPIKey(c *gin.Context) {
 		return
 	}
 
-	apiKey, err := uc.userService.CreateAPIKey(user.ID, req.Name, req.Permissions, req.ExpiresAt)
+	apiKey, err := uc.userService.CreateAPIKey(c.Request.Context(), user.ID, req.Name, req.Permissions, req.ExpiresAt)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to create API key")
 		return
@@ -379,7 +503,7 @@ func (uc *UserController) UpdateAPIKey(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(keyID)
-	err := uc.userService.UpdateAPIKey(user.ID, objID, req.Name, req.Permissions, req.IsActive)
+	err := uc.userService.UpdateAPIKey(c.Request.Context(), user.ID, objID, req.Name, req.Permissions, req.IsActive)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to update API key")
 		return
@@ -402,7 +526,7 @@ func (uc *UserController) DeleteAPIKey(c *gin.Context) {
 	}
 
 	objID, _ := utils.StringToObjectID(keyID)
-	err := uc.userService.DeleteAPIKey(user.ID, objID)
+	err := uc.userService.DeleteAPIKey(c.Request.Context(), user.ID, objID)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to delete API key")
 		return
@@ -419,7 +543,7 @@ func (uc *UserController) Get2FAStatus(c *gin.Context) {
 		return
 	}
 
-	status, err := uc.userService.Get2FAStatus(user.ID)
+	status, err := uc.userService.Get2FAStatus(c.Request.Context(), user.ID)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to get 2FA status")
 		return
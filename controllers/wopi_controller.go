@@ -0,0 +1,245 @@
+package controllers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"oncloud/models"
+	"oncloud/services"
+	"oncloud/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type WopiController struct {
+	wopiService  *services.WopiService
+	auditService *services.AuditService
+}
+
+func NewWopiController(deps services.Dependencies) *WopiController {
+	return &WopiController{
+		wopiService:  services.NewWopiServiceWith(deps),
+		auditService: services.NewAuditServiceWith(deps),
+	}
+}
+
+// OpenEditor starts an office editor session on a file and returns the
+// editor URL with its access token
+func (wc *WopiController) OpenEditor(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	fileID := c.Param("id")
+	if !utils.IsValidObjectID(fileID) {
+		utils.BadRequestResponse(c, "Invalid file ID")
+		return
+	}
+
+	var req models.WopiSessionRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.BadRequestResponse(c, "Invalid request data")
+			return
+		}
+	}
+	if err := utils.ValidateStruct(&req); err != nil {
+		utils.ValidationErrorResponse(c, err)
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(fileID)
+	launch, err := wc.wopiService.OpenSession(c.Request.Context(), user.ID, objID, req.Mode, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrOfficeEditorDisabled):
+			utils.ErrorResponse(c, http.StatusServiceUnavailable, "Office editing is not available", nil)
+		case errors.Is(err, services.ErrWopiUnsupportedType):
+			utils.BadRequestResponse(c, "This file type cannot be opened in the office editor")
+		default:
+			utils.NotFoundResponse(c, "File not found")
+		}
+		return
+	}
+
+	utils.CreatedResponse(c, "Editor session created successfully", launch)
+}
+
+// GetEditorSessions lists the editor sessions currently open on a file
+func (wc *WopiController) GetEditorSessions(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	fileID := c.Param("id")
+	if !utils.IsValidObjectID(fileID) {
+		utils.BadRequestResponse(c, "Invalid file ID")
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(fileID)
+	sessions, err := wc.wopiService.GetActiveSessions(c.Request.Context(), user.ID, objID)
+	if err != nil {
+		utils.NotFoundResponse(c, "File not found")
+		return
+	}
+
+	utils.SuccessResponse(c, "Editor sessions retrieved successfully", sessions)
+}
+
+// CloseEditorSession ends an editor session, e.g. when the editor is closed
+func (wc *WopiController) CloseEditorSession(c *gin.Context) {
+	user, exists := utils.GetUserFromContext(c)
+	if !exists {
+		utils.UnauthorizedResponse(c, "User not found in context")
+		return
+	}
+
+	sessionID := c.Param("session_id")
+	if !utils.IsValidObjectID(sessionID) {
+		utils.BadRequestResponse(c, "Invalid session ID")
+		return
+	}
+
+	objID, _ := utils.StringToObjectID(sessionID)
+	if err := wc.wopiService.EndSession(c.Request.Context(), user.ID, objID); err != nil {
+		utils.NotFoundResponse(c, "Editor session not found")
+		return
+	}
+
+	utils.SuccessResponse(c, "Editor session closed successfully", nil)
+}
+
+// CheckFileInfo implements the WOPI CheckFileInfo operation
+func (wc *WopiController) CheckFileInfo(c *gin.Context) {
+	session, file := wopiContext(c)
+
+	info, err := wc.wopiService.CheckFileInfo(c.Request.Context(), session, file)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, info)
+}
+
+// GetFile implements the WOPI GetFile operation
+func (wc *WopiController) GetFile(c *gin.Context) {
+	_, file := wopiContext(c)
+
+	content, err := wc.wopiService.GetFileContent(c.Request.Context(), file)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.Header("X-WOPI-ItemVersion", services.WopiFileVersion(file))
+	c.Data(http.StatusOK, "application/octet-stream", content)
+}
+
+// PutFile implements the WOPI PutFile operation. Each save becomes a new
+// version of the file.
+func (wc *WopiController) PutFile(c *gin.Context) {
+	session, file := wopiContext(c)
+
+	if c.GetHeader("X-WOPI-Override") != "PUT" {
+		c.Status(http.StatusNotImplemented)
+		return
+	}
+
+	content, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.Status(http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	updated, err := wc.wopiService.PutFileContent(c.Request.Context(), session, file, c.GetHeader("X-WOPI-Lock"), content)
+	if err != nil {
+		if respondWopiLockError(c, err) {
+			return
+		}
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	wc.auditService.Record(c.Request.Context(), &models.AuditLog{
+		ActorType:    "user",
+		ActorID:      &session.UserID,
+		Action:       "file.edited",
+		ResourceType: "file",
+		ResourceID:   file.ID.Hex(),
+		Outcome:      "success",
+		IPAddress:    c.ClientIP(),
+		UserAgent:    c.Request.UserAgent(),
+		Details:      map[string]interface{}{"editor_session_id": session.ID.Hex(), "size": updated.Size},
+	})
+
+	c.Header("X-WOPI-ItemVersion", services.WopiFileVersion(updated))
+	c.Status(http.StatusOK)
+}
+
+// FileOperation dispatches the WOPI lock operations selected by the
+// X-WOPI-Override header
+func (wc *WopiController) FileOperation(c *gin.Context) {
+	session, file := wopiContext(c)
+	lockToken := c.GetHeader("X-WOPI-Lock")
+
+	var err error
+	switch c.GetHeader("X-WOPI-Override") {
+	case "LOCK":
+		err = wc.wopiService.Lock(c.Request.Context(), session, file, lockToken, c.GetHeader("X-WOPI-OldLock"))
+	case "REFRESH_LOCK":
+		err = wc.wopiService.RefreshLock(c.Request.Context(), session, file, lockToken)
+	case "UNLOCK":
+		err = wc.wopiService.Unlock(c.Request.Context(), session, file, lockToken)
+	case "GET_LOCK":
+		// Set directly: gin drops headers with empty values, but WOPI expects
+		// an empty X-WOPI-Lock for an unlocked file
+		c.Writer.Header().Set("X-WOPI-Lock", wc.wopiService.GetLock(file))
+		c.Status(http.StatusOK)
+		return
+	default:
+		c.Status(http.StatusNotImplemented)
+		return
+	}
+
+	if err != nil {
+		if respondWopiLockError(c, err) {
+			return
+		}
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.Header("X-WOPI-ItemVersion", services.WopiFileVersion(file))
+	c.Status(http.StatusOK)
+}
+
+// respondWopiLockError maps lock conflicts and read-only sessions to the
+// statuses the WOPI protocol expects and reports whether it did
+func respondWopiLockError(c *gin.Context, err error) bool {
+	var lockedErr *services.FileLockedError
+	switch {
+	case errors.As(err, &lockedErr):
+		c.Header("X-WOPI-Lock", lockedErr.Lock.Token)
+		c.Header("X-WOPI-LockFailureReason", "File is locked by another editor")
+		c.Status(http.StatusConflict)
+	case errors.Is(err, services.ErrFileNotLocked):
+		c.Writer.Header().Set("X-WOPI-Lock", "")
+		c.Header("X-WOPI-LockFailureReason", "File is not locked")
+		c.Status(http.StatusConflict)
+	case errors.Is(err, services.ErrWopiReadOnly):
+		c.Status(http.StatusUnauthorized)
+	default:
+		return false
+	}
+	return true
+}
+
+func wopiContext(c *gin.Context) (*models.WopiSession, *models.File) {
+	return c.MustGet("wopi_session").(*models.WopiSession), c.MustGet("wopi_file").(*models.File)
+}
@@ -4,47 +4,130 @@ import "go.mongodb.org/mongo-driver/mongo"
 
 // Collection names as constants to prevent typos
 const (
-	UsersCollection             = "users"
-	FilesCollection             = "files"
-	FoldersCollection           = "folders"
-	PlansCollection             = "plans"
-	AdminsCollection            = "admins"
-	SettingsCollection          = "settings"
-	SubscriptionsCollection     = "subscriptions"
-	PaymentsCollection          = "payments"
-	SessionsCollection          = "sessions"
-	APIKeysCollection           = "api_keys"
-	ActivitiesCollection        = "activities"
-	NotificationsCollection     = "notifications"
-	AnalyticsCollection         = "analytics"
-	ExportsCollection           = "exports"
-	LogsCollection              = "logs"
-	StorageProvidersCollection  = "storage_providers"
-	StorageSyncCollection       = "storage_sync"
-	BackupsCollection           = "backups"
-	StorageActivitiesCollection = "storage_activities"
-	FileSharesCollection        = "file_shares"
-	FileVersionsCollection      = "file_versions"
-	UsageTrackingCollection     = "usage_tracking"
-	BillingHistoryCollection    = "billing_history"
-	InvoicesCollection          = "invoices"
-	CDNInvalidationsCollection  = "cdn_invalidations"
-	OptimizationJobsCollection  = "optimization_jobs"
-	RestoreJobsCollection       = "restore_jobs"
+	UsersCollection              = "users"
+	FilesCollection              = "files"
+	FoldersCollection            = "folders"
+	PlansCollection              = "plans"
+	AdminsCollection             = "admins"
+	SettingsCollection           = "settings"
+	SubscriptionsCollection      = "subscriptions"
+	PaymentsCollection           = "payments"
+	SessionsCollection           = "sessions"
+	APIKeysCollection            = "api_keys"
+	ActivitiesCollection         = "activities"
+	NotificationsCollection      = "notifications"
+	AnalyticsCollection          = "analytics"
+	ExportsCollection            = "exports"
+	LogsCollection               = "logs"
+	StorageProvidersCollection   = "storage_providers"
+	StorageSyncCollection        = "storage_sync"
+	BackupsCollection            = "backups"
+	StorageActivitiesCollection  = "storage_activities"
+	FileSharesCollection         = "file_shares"
+	FileVersionsCollection       = "file_versions"
+	UsageTrackingCollection      = "usage_tracking"
+	BillingHistoryCollection     = "billing_history"
+	InvoicesCollection           = "invoices"
+	CDNInvalidationsCollection   = "cdn_invalidations"
+	OptimizationJobsCollection   = "optimization_jobs"
+	RestoreJobsCollection        = "restore_jobs"
+	DownloadTokensCollection     = "download_tokens"
+	StatsRollupsCollection       = "stats_rollups"
+	DashboardSnapshotsCollection = "dashboard_snapshots"
+	WarehouseExportsCollection   = "warehouse_exports"
+	CohortRetentionCollection    = "cohort_retention"
+	StoragePricingCollection     = "storage_pricing"
+	AnomaliesCollection          = "anomalies"
+	IPAccessPoliciesCollection   = "ip_access_policies"
+	AuditLogsCollection          = "audit_logs"
+	LoginChallengesCollection    = "login_challenges"
+	SecurityPoliciesCollection   = "security_policies"
+	ScimTokensCollection         = "scim_tokens"
+	ScimGroupsCollection         = "scim_groups"
+	WopiSessionsCollection       = "wopi_sessions"
+	TagsCollection               = "tags"
+	MetadataFieldsCollection     = "metadata_fields"
+	CloudConnectionsCollection   = "cloud_connections"
+	CloudImportJobsCollection    = "cloud_import_jobs"
+	EmailInboxesCollection       = "email_inboxes"
+	ChatAccountsCollection       = "chat_accounts"
+	ChatLinkCodesCollection      = "chat_link_codes"
+	RuntimeSettingsCollection    = "runtime_settings"
+	BrandingCollection           = "branding"
+	MaintenanceWindowsCollection = "maintenance_windows"
+	AbuseReportsCollection       = "abuse_reports"
+	TakedownCasesCollection      = "takedown_cases"
+	DownloadReceiptsCollection   = "download_receipts"
+	StorageRekeyJobsCollection   = "storage_rekey_jobs"
+	MultipartUploadsCollection   = "multipart_uploads"
+	UploadCleanupRunsCollection  = "upload_cleanup_runs"
+	OrphanScansCollection        = "orphan_scans"
+	UploadChallengesCollection   = "upload_challenges"
+	BulkJobsCollection           = "bulk_jobs"
+	FolderCopyJobsCollection     = "folder_copy_jobs"
+	UploadRulesCollection        = "upload_rules"
+	UploadSettingsCollection     = "upload_settings"
+	FileRequestsCollection       = "file_requests"
+	FolderMembersCollection      = "folder_members"
+	FolderActivityCollection     = "folder_activity"
+	ShareDomainsCollection       = "share_domains"
+	ShareEmbedViewsCollection    = "share_embed_views"
+	ShareWatermarksCollection    = "share_watermarks"
+	FileTypePoliciesCollection   = "file_type_policies"
+	FileTypeOverridesCollection  = "file_type_overrides"
+	FolderManifestsCollection    = "folder_manifest_exports"
+	FileHitsCollection           = "file_hits"
+	HotFilesCollection           = "hot_files"
+	StorageFailoversCollection   = "storage_failovers"
+	TusUploadsCollection         = "tus_uploads"
+	PostPolicyUploadsCollection  = "post_policy_uploads"
+	RevokedTokensCollection      = "revoked_tokens"
+	PasswordResetsCollection     = "password_resets"
+	SupportNotesCollection       = "support_notes"
+	SupportTicketsCollection     = "support_tickets"
+	AnnouncementsCollection      = "announcements"
+	DismissalsCollection         = "announcement_dismissals"
+	HealthChecksCollection       = "health_checks"
+	ActivityArchivesCollection   = "activity_archives"
+	WebhookLogsCollection        = "webhook_logs"
+	RetentionPoliciesCollection  = "retention_policies"
+	FeatureFlagsCollection       = "feature_flags"
+	TrashEmptyJobsCollection     = "trash_empty_jobs"
+	MediaStreamsCollection       = "media_streams"
+	StorageReportsCollection     = "storage_reports"
+	RefundsCollection            = "refunds"
+	DisputesCollection           = "disputes"
 )
 
+// CollectionSource looks collections up by name. Manager is the source of
+// the application's database; tests can use one of a database of their own.
+type CollectionSource interface {
+	GetCollection(name string) *mongo.Collection
+}
+
 // Collections provides typed access to all collections
 type Collections struct {
-	manager *Manager
+	manager CollectionSource
 }
 
 // NewCollections creates a new collections instance
 func NewCollections() *Collections {
+	return NewCollectionsFrom(GetManager())
+}
+
+// NewCollectionsFrom creates a collections instance on a source
+func NewCollectionsFrom(source CollectionSource) *Collections {
 	return &Collections{
-		manager: GetManager(),
+		manager: source,
 	}
 }
 
+// Collection returns the collection named name, for those without an
+// accessor of their own
+func (c *Collections) Collection(name string) *mongo.Collection {
+	return c.manager.GetCollection(name)
+}
+
 // Core collections
 func (c *Collections) Users() *mongo.Collection {
 	return c.manager.GetCollection(UsersCollection)
@@ -113,6 +196,246 @@ func (c *Collections) Analytics() *mongo.Collection {
 	return c.manager.GetCollection(AnalyticsCollection)
 }
 
+func (c *Collections) Anomalies() *mongo.Collection {
+	return c.manager.GetCollection(AnomaliesCollection)
+}
+
+func (c *Collections) IPAccessPolicies() *mongo.Collection {
+	return c.manager.GetCollection(IPAccessPoliciesCollection)
+}
+
+func (c *Collections) AuditLogs() *mongo.Collection {
+	return c.manager.GetCollection(AuditLogsCollection)
+}
+
+func (c *Collections) LoginChallenges() *mongo.Collection {
+	return c.manager.GetCollection(LoginChallengesCollection)
+}
+
+func (c *Collections) SecurityPolicies() *mongo.Collection {
+	return c.manager.GetCollection(SecurityPoliciesCollection)
+}
+
+func (c *Collections) ScimTokens() *mongo.Collection {
+	return c.manager.GetCollection(ScimTokensCollection)
+}
+
+func (c *Collections) ScimGroups() *mongo.Collection {
+	return c.manager.GetCollection(ScimGroupsCollection)
+}
+
+func (c *Collections) WopiSessions() *mongo.Collection {
+	return c.manager.GetCollection(WopiSessionsCollection)
+}
+
+func (c *Collections) Tags() *mongo.Collection {
+	return c.manager.GetCollection(TagsCollection)
+}
+
+func (c *Collections) MetadataFields() *mongo.Collection {
+	return c.manager.GetCollection(MetadataFieldsCollection)
+}
+
+func (c *Collections) CloudConnections() *mongo.Collection {
+	return c.manager.GetCollection(CloudConnectionsCollection)
+}
+
+func (c *Collections) CloudImportJobs() *mongo.Collection {
+	return c.manager.GetCollection(CloudImportJobsCollection)
+}
+
+func (c *Collections) EmailInboxes() *mongo.Collection {
+	return c.manager.GetCollection(EmailInboxesCollection)
+}
+
+func (c *Collections) ChatAccounts() *mongo.Collection {
+	return c.manager.GetCollection(ChatAccountsCollection)
+}
+
+func (c *Collections) ChatLinkCodes() *mongo.Collection {
+	return c.manager.GetCollection(ChatLinkCodesCollection)
+}
+
+func (c *Collections) RuntimeSettings() *mongo.Collection {
+	return c.manager.GetCollection(RuntimeSettingsCollection)
+}
+
+func (c *Collections) Branding() *mongo.Collection {
+	return c.manager.GetCollection(BrandingCollection)
+}
+
+func (c *Collections) MaintenanceWindows() *mongo.Collection {
+	return c.manager.GetCollection(MaintenanceWindowsCollection)
+}
+
+func (c *Collections) AbuseReports() *mongo.Collection {
+	return c.manager.GetCollection(AbuseReportsCollection)
+}
+
+func (c *Collections) TakedownCases() *mongo.Collection {
+	return c.manager.GetCollection(TakedownCasesCollection)
+}
+
+func (c *Collections) DownloadReceipts() *mongo.Collection {
+	return c.manager.GetCollection(DownloadReceiptsCollection)
+}
+
+func (c *Collections) StorageRekeyJobs() *mongo.Collection {
+	return c.manager.GetCollection(StorageRekeyJobsCollection)
+}
+
+func (c *Collections) MultipartUploads() *mongo.Collection {
+	return c.manager.GetCollection(MultipartUploadsCollection)
+}
+
+func (c *Collections) UploadCleanupRuns() *mongo.Collection {
+	return c.manager.GetCollection(UploadCleanupRunsCollection)
+}
+
+func (c *Collections) OrphanScans() *mongo.Collection {
+	return c.manager.GetCollection(OrphanScansCollection)
+}
+
+func (c *Collections) UploadChallenges() *mongo.Collection {
+	return c.manager.GetCollection(UploadChallengesCollection)
+}
+
+func (c *Collections) BulkJobs() *mongo.Collection {
+	return c.manager.GetCollection(BulkJobsCollection)
+}
+
+func (c *Collections) FolderCopyJobs() *mongo.Collection {
+	return c.manager.GetCollection(FolderCopyJobsCollection)
+}
+
+func (c *Collections) UploadRules() *mongo.Collection {
+	return c.manager.GetCollection(UploadRulesCollection)
+}
+
+func (c *Collections) UploadSettings() *mongo.Collection {
+	return c.manager.GetCollection(UploadSettingsCollection)
+}
+
+func (c *Collections) FileRequests() *mongo.Collection {
+	return c.manager.GetCollection(FileRequestsCollection)
+}
+
+func (c *Collections) FolderMembers() *mongo.Collection {
+	return c.manager.GetCollection(FolderMembersCollection)
+}
+
+func (c *Collections) FolderActivity() *mongo.Collection {
+	return c.manager.GetCollection(FolderActivityCollection)
+}
+
+func (c *Collections) ShareDomains() *mongo.Collection {
+	return c.manager.GetCollection(ShareDomainsCollection)
+}
+
+func (c *Collections) ShareEmbedViews() *mongo.Collection {
+	return c.manager.GetCollection(ShareEmbedViewsCollection)
+}
+
+func (c *Collections) ShareWatermarks() *mongo.Collection {
+	return c.manager.GetCollection(ShareWatermarksCollection)
+}
+
+func (c *Collections) FileTypePolicies() *mongo.Collection {
+	return c.manager.GetCollection(FileTypePoliciesCollection)
+}
+
+func (c *Collections) FileTypeOverrides() *mongo.Collection {
+	return c.manager.GetCollection(FileTypeOverridesCollection)
+}
+
+func (c *Collections) FolderManifests() *mongo.Collection {
+	return c.manager.GetCollection(FolderManifestsCollection)
+}
+
+func (c *Collections) FileHits() *mongo.Collection {
+	return c.manager.GetCollection(FileHitsCollection)
+}
+
+func (c *Collections) HotFiles() *mongo.Collection {
+	return c.manager.GetCollection(HotFilesCollection)
+}
+
+func (c *Collections) StorageFailovers() *mongo.Collection {
+	return c.manager.GetCollection(StorageFailoversCollection)
+}
+
+func (c *Collections) TusUploads() *mongo.Collection {
+	return c.manager.GetCollection(TusUploadsCollection)
+}
+
+func (c *Collections) PostPolicyUploads() *mongo.Collection {
+	return c.manager.GetCollection(PostPolicyUploadsCollection)
+}
+
+func (c *Collections) RevokedTokens() *mongo.Collection {
+	return c.manager.GetCollection(RevokedTokensCollection)
+}
+
+func (c *Collections) PasswordResets() *mongo.Collection {
+	return c.manager.GetCollection(PasswordResetsCollection)
+}
+
+func (c *Collections) SupportNotes() *mongo.Collection {
+	return c.manager.GetCollection(SupportNotesCollection)
+}
+
+func (c *Collections) SupportTickets() *mongo.Collection {
+	return c.manager.GetCollection(SupportTicketsCollection)
+}
+
+func (c *Collections) Announcements() *mongo.Collection {
+	return c.manager.GetCollection(AnnouncementsCollection)
+}
+
+func (c *Collections) AnnouncementDismissals() *mongo.Collection {
+	return c.manager.GetCollection(DismissalsCollection)
+}
+
+func (c *Collections) HealthChecks() *mongo.Collection {
+	return c.manager.GetCollection(HealthChecksCollection)
+}
+
+func (c *Collections) ActivityArchives() *mongo.Collection {
+	return c.manager.GetCollection(ActivityArchivesCollection)
+}
+
+func (c *Collections) WebhookLogs() *mongo.Collection {
+	return c.manager.GetCollection(WebhookLogsCollection)
+}
+
+func (c *Collections) RetentionPolicies() *mongo.Collection {
+	return c.manager.GetCollection(RetentionPoliciesCollection)
+}
+
+func (c *Collections) FeatureFlags() *mongo.Collection {
+	return c.manager.GetCollection(FeatureFlagsCollection)
+}
+
+func (c *Collections) TrashEmptyJobs() *mongo.Collection {
+	return c.manager.GetCollection(TrashEmptyJobsCollection)
+}
+
+func (c *Collections) MediaStreams() *mongo.Collection {
+	return c.manager.GetCollection(MediaStreamsCollection)
+}
+
+func (c *Collections) StorageReports() *mongo.Collection {
+	return c.manager.GetCollection(StorageReportsCollection)
+}
+
+func (c *Collections) Refunds() *mongo.Collection {
+	return c.manager.GetCollection(RefundsCollection)
+}
+
+func (c *Collections) Disputes() *mongo.Collection {
+	return c.manager.GetCollection(DisputesCollection)
+}
+
 func (c *Collections) Exports() *mongo.Collection {
 	return c.manager.GetCollection(ExportsCollection)
 }
@@ -121,6 +444,23 @@ func (c *Collections) Logs() *mongo.Collection {
 	return c.manager.GetCollection(LogsCollection)
 }
 
+// Rollup collections
+func (c *Collections) StatsRollups() *mongo.Collection {
+	return c.manager.GetCollection(StatsRollupsCollection)
+}
+
+func (c *Collections) DashboardSnapshots() *mongo.Collection {
+	return c.manager.GetCollection(DashboardSnapshotsCollection)
+}
+
+func (c *Collections) CohortRetention() *mongo.Collection {
+	return c.manager.GetCollection(CohortRetentionCollection)
+}
+
+func (c *Collections) WarehouseExports() *mongo.Collection {
+	return c.manager.GetCollection(WarehouseExportsCollection)
+}
+
 // Storage collections
 func (c *Collections) StorageProviders() *mongo.Collection {
 	return c.manager.GetCollection(StorageProvidersCollection)
@@ -134,6 +474,10 @@ func (c *Collections) Backups() *mongo.Collection {
 	return c.manager.GetCollection(BackupsCollection)
 }
 
+func (c *Collections) StoragePricing() *mongo.Collection {
+	return c.manager.GetCollection(StoragePricingCollection)
+}
+
 func (c *Collections) StorageActivities() *mongo.Collection {
 	return c.manager.GetCollection(StorageActivitiesCollection)
 }
@@ -147,6 +491,10 @@ func (c *Collections) FileVersions() *mongo.Collection {
 	return c.manager.GetCollection(FileVersionsCollection)
 }
 
+func (c *Collections) DownloadTokens() *mongo.Collection {
+	return c.manager.GetCollection(DownloadTokensCollection)
+}
+
 // Job and task collections
 func (c *Collections) CDNInvalidations() *mongo.Collection {
 	return c.manager.GetCollection(CDNInvalidationsCollection)
@@ -194,6 +194,27 @@ func CreateIndexes() error {
 		{
 			Keys: bson.D{{"mime_type", 1}},
 		},
+		{
+			Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "is_favorite", Value: 1}, {Key: "favorited_at", Value: -1}},
+		},
+		// Sync change feed
+		{
+			Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "updated_at", Value: 1}, {Key: "_id", Value: 1}},
+		},
+		// Re-keying checks new keys are free
+		{
+			Keys: bson.D{{Key: "storage_provider", Value: 1}, {Key: "storage_key", Value: 1}},
+		},
+		// Instant uploads look content up by hash
+		{
+			Keys:    bson.D{{Key: "sha256", Value: 1}, {Key: "size", Value: 1}},
+			Options: options.Index().SetSparse(true),
+		},
+		// Restores of archived files are polled until they complete
+		{
+			Keys:    bson.D{{Key: "restore.status", Value: 1}},
+			Options: options.Index().SetSparse(true),
+		},
 	}
 
 	if _, err := filesCollection.Indexes().CreateMany(ctx, fileIndexes); err != nil {
@@ -214,6 +235,13 @@ func CreateIndexes() error {
 			Keys:    bson.D{{"share_token", 1}},
 			Options: options.Index().SetSparse(true),
 		},
+		{
+			Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "is_favorite", Value: 1}, {Key: "favorited_at", Value: -1}},
+		},
+		// Sync change feed
+		{
+			Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "updated_at", Value: 1}, {Key: "_id", Value: 1}},
+		},
 	}
 
 	if _, err := foldersCollection.Indexes().CreateMany(ctx, folderIndexes); err != nil {
@@ -384,6 +412,737 @@ func CreateIndexes() error {
 		return fmt.Errorf("failed to create activity indexes: %v", err)
 	}
 
+	// Download tokens collection indexes
+	downloadTokensCollection := GetCollection("download_tokens")
+	downloadTokenIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "file_id", Value: 1}},
+		},
+		{
+			// Expired tokens are purged a day after expiry
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(86400),
+		},
+	}
+
+	if _, err := downloadTokensCollection.Indexes().CreateMany(ctx, downloadTokenIndexes); err != nil {
+		return fmt.Errorf("failed to create download token indexes: %v", err)
+	}
+
+	// Stats rollups collection indexes
+	statsRollupsCollection := GetCollection("stats_rollups")
+	statsRollupIndexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "granularity", Value: 1}, {Key: "period_start", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	}
+
+	if _, err := statsRollupsCollection.Indexes().CreateMany(ctx, statsRollupIndexes); err != nil {
+		return fmt.Errorf("failed to create stats rollup indexes: %v", err)
+	}
+
+	// Dashboard snapshots collection indexes
+	dashboardSnapshotsCollection := GetCollection("dashboard_snapshots")
+	if _, err := dashboardSnapshotsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "key", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		return fmt.Errorf("failed to create dashboard snapshot indexes: %v", err)
+	}
+
+	// Cohort retention cache
+	cohortRetentionCollection := GetCollection("cohort_retention")
+	if _, err := cohortRetentionCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "key", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		return fmt.Errorf("failed to create cohort retention indexes: %v", err)
+	}
+
+	// Analytics events, used for per-user activity lookups in cohort analysis
+	analyticsCollection := GetCollection("analytics")
+	if _, err := analyticsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "timestamp", Value: 1}},
+	}); err != nil {
+		return fmt.Errorf("failed to create analytics indexes: %v", err)
+	}
+
+	// Storage pricing, one price list per provider type and region
+	storagePricingCollection := GetCollection("storage_pricing")
+	if _, err := storagePricingCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "provider_type", Value: 1}, {Key: "region", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		return fmt.Errorf("failed to create storage pricing indexes: %v", err)
+	}
+
+	// IP access policies, one global and at most one per user
+	policiesCollection := GetCollection("ip_access_policies")
+	policyIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "scope", Value: 1}, {Key: "user_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+
+	if _, err := policiesCollection.Indexes().CreateOne(ctx, policyIndex); err != nil {
+		return fmt.Errorf("failed to create IP access policy index: %v", err)
+	}
+
+	// Audit log, browsed by time, actor and action
+	auditCollection := GetCollection("audit_logs")
+	auditIndexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "actor_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "action", Value: 1}, {Key: "created_at", Value: -1}}},
+	}
+
+	if _, err := auditCollection.Indexes().CreateMany(ctx, auditIndexes); err != nil {
+		return fmt.Errorf("failed to create audit log indexes: %v", err)
+	}
+
+	// Login challenges, looked up by their public ID and purged once expired
+	challengesCollection := GetCollection("login_challenges")
+	challengeIndexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "challenge_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	}
+
+	if _, err := challengesCollection.Indexes().CreateMany(ctx, challengeIndexes); err != nil {
+		return fmt.Errorf("failed to create login challenge indexes: %v", err)
+	}
+
+	// Security policy, a single document keyed "global"
+	if _, err := GetCollection("security_policies").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "key", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		return fmt.Errorf("failed to create security policy indexes: %v", err)
+	}
+
+	// SCIM bearer tokens are looked up by hash; groups by name, external ID and member
+	if _, err := GetCollection("scim_tokens").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "token_hash", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		return fmt.Errorf("failed to create SCIM token indexes: %v", err)
+	}
+
+	scimGroupIndexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "display_name", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "external_id", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "members", Value: 1}},
+		},
+	}
+
+	if _, err := GetCollection("scim_groups").Indexes().CreateMany(ctx, scimGroupIndexes); err != nil {
+		return fmt.Errorf("failed to create SCIM group indexes: %v", err)
+	}
+
+	if _, err := usersCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "external_id", Value: 1}},
+		Options: options.Index().SetSparse(true),
+	}); err != nil {
+		return fmt.Errorf("failed to create user external ID index: %v", err)
+	}
+
+	// File versions, numbered per file
+	if _, err := GetCollection("file_versions").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "file_id", Value: 1}, {Key: "version_number", Value: -1}},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		return fmt.Errorf("failed to create file version indexes: %v", err)
+	}
+
+	// Office editor sessions, looked up by token and listed per file while open
+	wopiCollection := GetCollection("wopi_sessions")
+	wopiIndexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "token_hash", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "file_id", Value: 1}, {Key: "last_active_at", Value: -1}},
+		},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	}
+
+	if _, err := wopiCollection.Indexes().CreateMany(ctx, wopiIndexes); err != nil {
+		return fmt.Errorf("failed to create WOPI session indexes: %v", err)
+	}
+
+	// Tags, unique per user and listed by name or usage
+	tagsCollection := GetCollection("tags")
+	tagIndexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "name", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "count", Value: -1}},
+		},
+	}
+
+	if _, err := tagsCollection.Indexes().CreateMany(ctx, tagIndexes); err != nil {
+		return fmt.Errorf("failed to create tag indexes: %v", err)
+	}
+
+	// Files are browsed by tag
+	if _, err := filesCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "tags", Value: 1}},
+	}); err != nil {
+		return fmt.Errorf("failed to create file tag indexes: %v", err)
+	}
+
+	// Custom metadata fields, unique per user, and files filtered by the
+	// values of indexed fields
+	metadataFieldsCollection := GetCollection("metadata_fields")
+	if _, err := metadataFieldsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "key", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		return fmt.Errorf("failed to create metadata field indexes: %v", err)
+	}
+
+	if _, err := filesCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "user_id", Value: 1},
+			{Key: "metadata_index.k", Value: 1},
+			{Key: "metadata_index.v", Value: 1},
+		},
+	}); err != nil {
+		return fmt.Errorf("failed to create file metadata indexes: %v", err)
+	}
+
+	// Photos and videos are filtered by when they were taken and shown on a
+	// map by where
+	mediaIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "media.captured_at", Value: -1}},
+		},
+		{
+			Keys: bson.D{
+				{Key: "user_id", Value: 1},
+				{Key: "media.location.latitude", Value: 1},
+				{Key: "media.location.longitude", Value: 1},
+			},
+		},
+	}
+
+	if _, err := filesCollection.Indexes().CreateMany(ctx, mediaIndexes); err != nil {
+		return fmt.Errorf("failed to create file media indexes: %v", err)
+	}
+
+	// Linked cloud accounts, one per provider account, and the import jobs
+	// copying from them
+	if _, err := GetCollection("cloud_connections").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "provider", Value: 1}, {Key: "account_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		return fmt.Errorf("failed to create cloud connection indexes: %v", err)
+	}
+
+	cloudImportIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "status", Value: 1}, {Key: "updated_at", Value: 1}},
+		},
+	}
+
+	if _, err := GetCollection("cloud_import_jobs").Indexes().CreateMany(ctx, cloudImportIndexes); err != nil {
+		return fmt.Errorf("failed to create cloud import job indexes: %v", err)
+	}
+
+	// Inbound email addresses, one per user, looked up by address token
+	emailInboxIndexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "token", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	}
+
+	if _, err := GetCollection("email_inboxes").Indexes().CreateMany(ctx, emailInboxIndexes); err != nil {
+		return fmt.Errorf("failed to create email inbox indexes: %v", err)
+	}
+
+	// Chat accounts linked to the bot, looked up by the sender of each
+	// message, and the short-lived codes that link them
+	chatAccountIndexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "platform", Value: 1}, {Key: "external_user_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "user_id", Value: 1}},
+		},
+	}
+
+	if _, err := GetCollection("chat_accounts").Indexes().CreateMany(ctx, chatAccountIndexes); err != nil {
+		return fmt.Errorf("failed to create chat account indexes: %v", err)
+	}
+
+	chatLinkCodeIndexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "code", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	}
+
+	if _, err := GetCollection("chat_link_codes").Indexes().CreateMany(ctx, chatLinkCodeIndexes); err != nil {
+		return fmt.Errorf("failed to create chat link code indexes: %v", err)
+	}
+
+	runtimeSettingIndexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "key", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	}
+
+	if _, err := GetCollection("runtime_settings").Indexes().CreateMany(ctx, runtimeSettingIndexes); err != nil {
+		return fmt.Errorf("failed to create runtime setting indexes: %v", err)
+	}
+
+	maintenanceWindowIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "ends_at", Value: 1}, {Key: "starts_at", Value: 1}},
+		},
+	}
+
+	if _, err := GetCollection("maintenance_windows").Indexes().CreateMany(ctx, maintenanceWindowIndexes); err != nil {
+		return fmt.Errorf("failed to create maintenance window indexes: %v", err)
+	}
+
+	// Abuse reports, triaged by status and counted per reported link
+	abuseReportIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "token", Value: 1}, {Key: "status", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "file_id", Value: 1}, {Key: "status", Value: 1}},
+		},
+	}
+
+	if _, err := GetCollection("abuse_reports").Indexes().CreateMany(ctx, abuseReportIndexes); err != nil {
+		return fmt.Errorf("failed to create abuse report indexes: %v", err)
+	}
+
+	// Takedown cases, listed by status, per owner, and per file or folder
+	// while open; deadlines are swept by the background job
+	takedownIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "owner_id", Value: 1}, {Key: "created_at", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "file_id", Value: 1}, {Key: "status", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "folder_id", Value: 1}, {Key: "status", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "status", Value: 1}, {Key: "restore_at", Value: 1}},
+		},
+	}
+
+	if _, err := GetCollection("takedown_cases").Indexes().CreateMany(ctx, takedownIndexes); err != nil {
+		return fmt.Errorf("failed to create takedown case indexes: %v", err)
+	}
+
+	// Download receipts, listed per file and per owner by time of download
+	downloadReceiptIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "file_id", Value: 1}, {Key: "downloaded_at", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "owner_id", Value: 1}, {Key: "downloaded_at", Value: -1}},
+		},
+	}
+
+	if _, err := GetCollection("download_receipts").Indexes().CreateMany(ctx, downloadReceiptIndexes); err != nil {
+		return fmt.Errorf("failed to create download receipt indexes: %v", err)
+	}
+
+	// Storage re-key jobs, listed per provider by recency
+	if _, err := GetCollection("storage_rekey_jobs").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "provider_id", Value: 1}, {Key: "started_at", Value: -1}},
+	}); err != nil {
+		return fmt.Errorf("failed to create storage rekey job indexes: %v", err)
+	}
+
+	// Multipart upload sessions, swept once they expire
+	if _, err := GetCollection("multipart_uploads").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "status", Value: 1}, {Key: "expires_at", Value: 1}},
+	}); err != nil {
+		return fmt.Errorf("failed to create multipart upload indexes: %v", err)
+	}
+
+	// Upload cleanup runs, listed by recency
+	if _, err := GetCollection("upload_cleanup_runs").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "started_at", Value: -1}},
+	}); err != nil {
+		return fmt.Errorf("failed to create upload cleanup run indexes: %v", err)
+	}
+
+	// Orphaned object scans, listed per provider by recency
+	if _, err := GetCollection("orphan_scans").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "provider_id", Value: 1}, {Key: "started_at", Value: -1}},
+	}); err != nil {
+		return fmt.Errorf("failed to create orphan scan indexes: %v", err)
+	}
+
+	// Instant upload challenges are single use and expire quickly
+	if _, err := GetCollection("upload_challenges").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}); err != nil {
+		return fmt.Errorf("failed to create upload challenge indexes: %v", err)
+	}
+
+	// Bulk jobs, listed per user and resumed by status after a restart
+	bulkJobIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "status", Value: 1}, {Key: "updated_at", Value: 1}},
+		},
+	}
+
+	if _, err := GetCollection("bulk_jobs").Indexes().CreateMany(ctx, bulkJobIndexes); err != nil {
+		return fmt.Errorf("failed to create bulk job indexes: %v", err)
+	}
+
+	// Folder copy jobs, failed by status after a restart
+	if _, err := GetCollection("folder_copy_jobs").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "status", Value: 1}, {Key: "updated_at", Value: 1}},
+	}); err != nil {
+		return fmt.Errorf("failed to create folder copy job indexes: %v", err)
+	}
+
+	// Upload rules, tried in position order at every upload, and one
+	// settings document per user
+	if _, err := GetCollection("upload_rules").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "position", Value: 1}},
+	}); err != nil {
+		return fmt.Errorf("failed to create upload rule indexes: %v", err)
+	}
+	if _, err := GetCollection("upload_settings").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "user_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		return fmt.Errorf("failed to create upload settings indexes: %v", err)
+	}
+
+	// File requests, one per folder and opened by token, and the files
+	// guests sent through them, totalled per guest
+	fileRequestIndexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "token", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "folder_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	}
+	if _, err := GetCollection("file_requests").Indexes().CreateMany(ctx, fileRequestIndexes); err != nil {
+		return fmt.Errorf("failed to create file request indexes: %v", err)
+	}
+	if _, err := GetCollection("files").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "uploader.file_request_id", Value: 1}, {Key: "uploader.guest_key", Value: 1}},
+		Options: options.Index().SetSparse(true),
+	}); err != nil {
+		return fmt.Errorf("failed to create file uploader indexes: %v", err)
+	}
+
+	// Folder members, one per user and folder, listed per folder and per
+	// member, and their activity listed newest first
+	folderMemberIndexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "folder_id", Value: 1}, {Key: "user_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}},
+		},
+	}
+	if _, err := GetCollection("folder_members").Indexes().CreateMany(ctx, folderMemberIndexes); err != nil {
+		return fmt.Errorf("failed to create folder member indexes: %v", err)
+	}
+	if _, err := GetCollection("folder_activity").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "folder_id", Value: 1}, {Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}},
+	}); err != nil {
+		return fmt.Errorf("failed to create folder activity indexes: %v", err)
+	}
+
+	// Share slugs, unique within each kind of share, and share domains, one
+	// per user and claimed by whoever verifies them first
+	slugIndex := mongo.IndexModel{
+		Keys: bson.D{{Key: "slug", Value: 1}},
+		Options: options.Index().
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"slug": bson.M{"$exists": true}}),
+	}
+	for _, name := range []string{"file_shares", "folder_shares"} {
+		if _, err := GetCollection(name).Indexes().CreateOne(ctx, slugIndex); err != nil {
+			return fmt.Errorf("failed to create share slug indexes: %v", err)
+		}
+	}
+	shareDomainIndexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "domain", Value: 1}},
+			Options: options.Index().
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"verified_at": bson.M{"$exists": true}}),
+		},
+	}
+	if _, err := GetCollection("share_domains").Indexes().CreateMany(ctx, shareDomainIndexes); err != nil {
+		return fmt.Errorf("failed to create share domain indexes: %v", err)
+	}
+
+	// Share embed views, one counter per share, day and referrer
+	shareEmbedViewIndexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "share_id", Value: 1}, {Key: "day", Value: 1}, {Key: "referrer", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	}
+	if _, err := GetCollection("share_embed_views").Indexes().CreateMany(ctx, shareEmbedViewIndexes); err != nil {
+		return fmt.Errorf("failed to create share embed view indexes: %v", err)
+	}
+
+	// Watermarked share copies, one per share and recipient
+	shareWatermarkIndexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "share_id", Value: 1}, {Key: "recipient", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	}
+	if _, err := GetCollection("share_watermarks").Indexes().CreateMany(ctx, shareWatermarkIndexes); err != nil {
+		return fmt.Errorf("failed to create share watermark indexes: %v", err)
+	}
+
+	// Files with an expiry, found by the scheduler that warns owners of
+	// expiries and trashes expired files
+	if _, err := GetCollection("files").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetSparse(true),
+	}); err != nil {
+		return fmt.Errorf("failed to create file expiry indexes: %v", err)
+	}
+
+	// File type policies, looked up by scope at every upload, and one
+	// override per trusted user
+	if _, err := GetCollection("file_type_policies").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "scope", Value: 1}, {Key: "is_enabled", Value: 1}},
+	}); err != nil {
+		return fmt.Errorf("failed to create file type policy indexes: %v", err)
+	}
+	if _, err := GetCollection("file_type_overrides").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "user_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		return fmt.Errorf("failed to create file type override indexes: %v", err)
+	}
+
+	// Folder manifest exports, listed per folder, failed by status after a
+	// restart and purged once expired
+	if _, err := GetCollection("folder_manifest_exports").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "folder_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "updated_at", Value: 1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}},
+	}); err != nil {
+		return fmt.Errorf("failed to create folder manifest export indexes: %v", err)
+	}
+
+	// Hourly download counts of public and shared files, summed by hour when
+	// looking for hot files and dropped once too old to matter
+	if _, err := GetCollection("file_hits").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "file_id", Value: 1}, {Key: "hour", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "hour", Value: 1}}},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	}); err != nil {
+		return fmt.Errorf("failed to create file hit indexes: %v", err)
+	}
+
+	// Reads served by a replica, listed by recency for the health dashboard
+	// and dropped after 30 days
+	if _, err := GetCollection("storage_failovers").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "provider_type", Value: 1}, {Key: "created_at", Value: -1}}},
+		{
+			Keys:    bson.D{{Key: "created_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(30 * 24 * 60 * 60),
+		},
+	}); err != nil {
+		return fmt.Errorf("failed to create storage failover indexes: %v", err)
+	}
+
+	// Tus uploads, looked up by owner and removed once they expire
+	if _, err := GetCollection("tus_uploads").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	}); err != nil {
+		return fmt.Errorf("failed to create tus upload indexes: %v", err)
+	}
+
+	// POST policy uploads, removed once they can no longer be completed
+	if _, err := GetCollection("post_policy_uploads").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	}); err != nil {
+		return fmt.Errorf("failed to create post policy upload indexes: %v", err)
+	}
+
+	// Revoked access tokens, checked on every request until they expire
+	if _, err := GetCollection("revoked_tokens").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "token_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	}); err != nil {
+		return fmt.Errorf("failed to create revoked token indexes: %v", err)
+	}
+
+	// Password reset requests, looked up by token and user and counted per
+	// email and IP for throttling, kept for a day
+	if _, err := GetCollection("password_resets").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "token_hash", Value: 1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+		{Keys: bson.D{{Key: "email", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "ip_address", Value: 1}, {Key: "created_at", Value: -1}}},
+		{
+			Keys:    bson.D{{Key: "created_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(24 * 60 * 60),
+		},
+	}); err != nil {
+		return fmt.Errorf("failed to create password reset indexes: %v", err)
+	}
+
+	// Support notes, listed per user; tickets listed by status, per user,
+	// per file and per assignee
+	if _, err := GetCollection("support_notes").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "pinned", Value: -1}, {Key: "created_at", Value: -1}},
+	}); err != nil {
+		return fmt.Errorf("failed to create support note indexes: %v", err)
+	}
+	if _, err := GetCollection("support_tickets").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "status", Value: 1}}},
+		{Keys: bson.D{{Key: "file_ids", Value: 1}}},
+		{Keys: bson.D{{Key: "assigned_to", Value: 1}, {Key: "status", Value: 1}}},
+	}); err != nil {
+		return fmt.Errorf("failed to create support ticket indexes: %v", err)
+	}
+
+	// Announcements, looked up by schedule when users poll and when they are
+	// due to be emailed
+	if _, err := GetCollection("announcements").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "starts_at", Value: 1}, {Key: "ends_at", Value: 1}}},
+		{Keys: bson.D{{Key: "send_email", Value: 1}, {Key: "emailed_at", Value: 1}}},
+	}); err != nil {
+		return fmt.Errorf("failed to create announcement indexes: %v", err)
+	}
+
+	// Announcements users closed, once per user
+	if _, err := GetCollection("announcement_dismissals").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "announcement_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "announcement_id", Value: 1}}},
+	}); err != nil {
+		return fmt.Errorf("failed to create announcement dismissal indexes: %v", err)
+	}
+
+	// Health checks behind the status page, kept for the month uptime is
+	// reported over
+	if _, err := GetCollection("health_checks").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "component", Value: 1}, {Key: "checked_at", Value: -1}}},
+		{
+			Keys:    bson.D{{Key: "checked_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(31 * 24 * 60 * 60),
+		},
+	}); err != nil {
+		return fmt.Errorf("failed to create health check indexes: %v", err)
+	}
+
+	// Anomalies, looked up by type/subject while open and listed by recency
+	anomaliesCollection := GetCollection("anomalies")
+	anomalyIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "type", Value: 1}, {Key: "subject", Value: 1}, {Key: "status", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "status", Value: 1}, {Key: "last_detected_at", Value: -1}},
+		},
+	}
+
+	if _, err := anomaliesCollection.Indexes().CreateMany(ctx, anomalyIndexes); err != nil {
+		return fmt.Errorf("failed to create anomaly indexes: %v", err)
+	}
+
+	// Warehouse export checkpoints
+	warehouseExportsCollection := GetCollection("warehouse_exports")
+	if _, err := warehouseExportsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "source", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		return fmt.Errorf("failed to create warehouse export indexes: %v", err)
+	}
+
 	log.Println("Database indexes created successfully")
 	return nil
 }
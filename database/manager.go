@@ -107,15 +107,25 @@ func (m *Manager) GetCollection(name string) *mongo.Collection {
 		return collection
 	}
 
-	collection := m.database.Collection(name)
+	db := m.database
+	if db == nil {
+		// Not initialized itself, the manager serves the database the
+		// application's DatabaseManager connected
+		return GetCollection(name)
+	}
+	collection := db.Collection(name)
 	m.collections[name] = collection
 	return collection
 }
 
-// GetDatabase returns the database instance
+// GetDatabase returns the database instance, the one the application's
+// DatabaseManager connected when the manager was not initialized itself
 func (m *Manager) GetDatabase() *mongo.Database {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
+	if m.database == nil {
+		return database
+	}
 	return m.database
 }
 
@@ -5,33 +5,221 @@ import (
 	"log"
 	"oncloud/models"
 	"oncloud/utils"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// RunMigrations executes all database migrations
+// migrations are applied in order of version. A released migration's
+// version and name never change; changes to indexes and backfills go in a
+// new migration at the end.
+var migrations = []Migration{
+	{Version: 1, Name: "create_default_admin", Up: createDefaultAdmin},
+	{Version: 2, Name: "create_default_plans", Up: createDefaultPlans},
+	{Version: 3, Name: "create_default_settings", Up: createDefaultSettings},
+	{Version: 4, Name: "create_default_storage_provider", Up: createDefaultStorageProvider},
+	{Version: 5, Name: "create_default_storage_pricing", Up: createDefaultStoragePricing},
+	{Version: 6, Name: "backfill_tags", Up: backfillTags},
+	{Version: 7, Name: "backfill_folder_usage", Up: backfillFolderUsage},
+
+	// Materialized paths: folders keep their ancestors, looked up per user,
+	// and paths left stale by earlier moves are rebuilt
+	{Version: 8, Name: "backfill_folder_ancestors", Up: backfillFolderAncestors},
+	{
+		Version: 9,
+		Name:    "index_folder_ancestors",
+		Up: createIndexes("folders", mongo.IndexModel{
+			Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "ancestors", Value: 1}},
+		}),
+		Down: dropIndexes("folders", "user_id_1_ancestors_1"),
+	},
+	{Version: 10, Name: "repair_folder_paths", Up: repairFolderPaths},
+
+	// Top users are ranked on the storage and file counters kept on users,
+	// and by downloads in a period
+	{
+		Version: 11,
+		Name:    "index_top_users",
+		Up: func() error {
+			if err := createIndexes("users",
+				mongo.IndexModel{Keys: bson.D{{Key: "storage_used", Value: -1}}},
+				mongo.IndexModel{Keys: bson.D{{Key: "files_count", Value: -1}}},
+			)(); err != nil {
+				return err
+			}
+			return createIndexes("activities", mongo.IndexModel{
+				Keys: bson.D{{Key: "action", Value: 1}, {Key: "created_at", Value: -1}},
+			})()
+		},
+		Down: func() error {
+			if err := dropIndexes("users", "storage_used_-1", "files_count_-1")(); err != nil {
+				return err
+			}
+			return dropIndexes("activities", "action_1_created_at_-1")()
+		},
+	},
+
+	// Usage counters are kept up to date as files change; recount them once
+	// as files_count stopped covering the trash and permanent deletes used to
+	// add to storage_used
+	{Version: 12, Name: "recount_user_usage", Up: recountUserUsage},
+
+	// Activity archives are listed by day
+	{
+		Version: 13,
+		Name:    "index_activity_archives",
+		Up: createIndexes("activity_archives", mongo.IndexModel{
+			Keys: bson.D{{Key: "day", Value: 1}, {Key: "from", Value: 1}},
+		}),
+		Down: dropIndexes("activity_archives", "day_1_from_1"),
+	},
+
+	// Analytics events, API request logs and webhook logs expire at their
+	// expires_at, set from the retention configured for them
+	{
+		Version: 14,
+		Name:    "index_retention_expiry",
+		Up: func() error {
+			for _, coll := range []string{"analytics", "logs", "webhook_logs"} {
+				if err := createIndexes(coll, mongo.IndexModel{
+					Keys:    bson.D{{Key: "expires_at", Value: 1}},
+					Options: options.Index().SetExpireAfterSeconds(0),
+				})(); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func() error {
+			for _, coll := range []string{"analytics", "logs", "webhook_logs"} {
+				if err := dropIndexes(coll, "expires_at_1")(); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+
+	// Feature flags are looked up by key
+	{
+		Version: 15,
+		Name:    "index_feature_flags",
+		Up: createIndexes("feature_flags", mongo.IndexModel{
+			Keys:    bson.D{{Key: "key", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		}),
+		Down: dropIndexes("feature_flags", "key_1"),
+	},
+
+	// Requests to empty the trash are looked up by user, and picked up once
+	// their grace period is over
+	{
+		Version: 16,
+		Name:    "index_trash_empty_jobs",
+		Up: createIndexes("trash_empty_jobs",
+			mongo.IndexModel{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+			mongo.IndexModel{Keys: bson.D{{Key: "status", Value: 1}, {Key: "run_at", Value: 1}}},
+		),
+		Down: dropIndexes("trash_empty_jobs", "user_id_1_created_at_-1", "status_1_run_at_1"),
+	},
+
+	// HLS streams are made once per content of a file, picked up while
+	// pending, pruned once unplayed, and their segments kept from orphan
+	// collection
+	{
+		Version: 17,
+		Name:    "index_media_streams",
+		Up: createIndexes("media_streams",
+			mongo.IndexModel{
+				Keys:    bson.D{{Key: "file_id", Value: 1}, {Key: "source", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+			mongo.IndexModel{Keys: bson.D{{Key: "status", Value: 1}, {Key: "updated_at", Value: 1}}},
+			mongo.IndexModel{Keys: bson.D{{Key: "last_served_at", Value: 1}}},
+			mongo.IndexModel{Keys: bson.D{{Key: "storage_provider", Value: 1}, {Key: "segments.key", Value: 1}}},
+		),
+		Down: dropIndexes("media_streams", "file_id_1_source_1", "status_1_updated_at_1", "last_served_at_1", "storage_provider_1_segments.key_1"),
+	},
+
+	// A user has one storage report a month, made by whichever instance
+	// gets to them first, and lists theirs newest first. Duplicates are
+	// found by content among a user's files.
+	{
+		Version: 18,
+		Name:    "index_storage_reports",
+		Up: func() error {
+			if err := createIndexes("storage_reports", mongo.IndexModel{
+				Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "period", Value: -1}},
+				Options: options.Index().SetUnique(true),
+			})(); err != nil {
+				return err
+			}
+			return createIndexes("files", mongo.IndexModel{
+				Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "hash", Value: 1}},
+			})()
+		},
+		Down: func() error {
+			if err := dropIndexes("storage_reports", "user_id_1_period_-1")(); err != nil {
+				return err
+			}
+			return dropIndexes("files", "user_id_1_hash_1")()
+		},
+	},
+
+	// Refunds are listed per payment and user, and a gateway's dispute is
+	// recorded once however often its webhooks are delivered. Payments are
+	// found by the gateway's charge when a dispute comes in.
+	{
+		Version: 19,
+		Name:    "index_refunds_and_disputes",
+		Up: func() error {
+			if err := createIndexes("refunds",
+				mongo.IndexModel{Keys: bson.D{{Key: "payment_id", Value: 1}, {Key: "created_at", Value: -1}}},
+				mongo.IndexModel{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+				mongo.IndexModel{Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: -1}}},
+			)(); err != nil {
+				return err
+			}
+			if err := createIndexes("disputes",
+				mongo.IndexModel{
+					Keys:    bson.D{{Key: "gateway", Value: 1}, {Key: "gateway_dispute_id", Value: 1}},
+					Options: options.Index().SetUnique(true),
+				},
+				mongo.IndexModel{Keys: bson.D{{Key: "status", Value: 1}, {Key: "opened_at", Value: -1}}},
+				mongo.IndexModel{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "status", Value: 1}}},
+			)(); err != nil {
+				return err
+			}
+			return createIndexes("payments", mongo.IndexModel{
+				Keys: bson.D{{Key: "transaction_id", Value: 1}},
+			})()
+		},
+		Down: func() error {
+			if err := dropIndexes("refunds", "payment_id_1_created_at_-1", "user_id_1_created_at_-1", "status_1_created_at_-1")(); err != nil {
+				return err
+			}
+			if err := dropIndexes("disputes", "gateway_1_gateway_dispute_id_1", "status_1_opened_at_-1", "user_id_1_status_1")(); err != nil {
+				return err
+			}
+			return dropIndexes("payments", "transaction_id_1")()
+		},
+	},
+}
+
+// RunMigrations applies the pending database migrations
 func RunMigrations() error {
 	log.Println("Running database migrations...")
 
-	if err := createDefaultAdmin(); err != nil {
-		return err
-	}
-
-	if err := createDefaultPlans(); err != nil {
-		return err
-	}
-
-	if err := createDefaultSettings(); err != nil {
-		return err
-	}
-
-	if err := createDefaultStorageProvider(); err != nil {
+	applied, err := MigrateUp(0, false)
+	if err != nil {
 		return err
 	}
 
-	log.Println("Database migrations completed successfully")
+	log.Printf("Database migrations completed successfully (%d applied)", len(applied))
 	return nil
 }
 
@@ -344,3 +532,440 @@ func createDefaultStorageProvider() error {
 	log.Printf("Created default storage provider: %s", provider.Name)
 	return nil
 }
+
+// createDefaultStoragePricing creates list prices for the supported providers
+func createDefaultStoragePricing() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	collection := GetCollection("storage_pricing")
+
+	count, err := collection.CountDocuments(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+
+	if count > 0 {
+		log.Println("Storage pricing already exists, skipping default pricing creation")
+		return nil
+	}
+
+	now := time.Now()
+	pricing := []interface{}{
+		models.StoragePricing{
+			ID:                primitive.NewObjectID(),
+			ProviderType:      "s3",
+			StoragePerGBMonth: 0.023,
+			EgressPerGB:       0.09,
+			FreeEgressGB:      100,
+			ClassAPer1000:     0.005,
+			ClassBPer1000:     0.0004,
+			Notes:             "S3 Standard list price",
+			CreatedAt:         now,
+			UpdatedAt:         now,
+		},
+		models.StoragePricing{
+			ID:                primitive.NewObjectID(),
+			ProviderType:      "r2",
+			StoragePerGBMonth: 0.015,
+			EgressPerGB:       0,
+			ClassAPer1000:     0.0045,
+			ClassBPer1000:     0.00036,
+			Notes:             "Cloudflare R2 standard, no egress fees",
+			CreatedAt:         now,
+			UpdatedAt:         now,
+		},
+		models.StoragePricing{
+			ID:                primitive.NewObjectID(),
+			ProviderType:      "wasabi",
+			StoragePerGBMonth: 0.0059,
+			Notes:             "Wasabi pay-as-you-go, no egress or API fees",
+			CreatedAt:         now,
+			UpdatedAt:         now,
+		},
+		models.StoragePricing{
+			ID:           primitive.NewObjectID(),
+			ProviderType: "local",
+			Notes:        "Local disk, priced at zero by default",
+			CreatedAt:    now,
+			UpdatedAt:    now,
+		},
+	}
+
+	if _, err := collection.InsertMany(ctx, pricing); err != nil {
+		return err
+	}
+
+	log.Printf("Created default storage pricing for %d providers", len(pricing))
+	return nil
+}
+
+// backfillTags builds the tags collection from tags already on files
+func backfillTags() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	collection := GetCollection("tags")
+
+	count, err := collection.CountDocuments(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+
+	if count > 0 {
+		return nil
+	}
+
+	cursor, err := GetCollection("files").Aggregate(ctx, []bson.M{
+		{"$match": bson.M{"is_deleted": false, "tags.0": bson.M{"$exists": true}}},
+		{"$unwind": "$tags"},
+		{"$group": bson.M{
+			"_id":   bson.M{"user_id": "$user_id", "name": "$tags"},
+			"count": bson.M{"$sum": 1},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var groups []struct {
+		ID struct {
+			UserID primitive.ObjectID `bson:"user_id"`
+			Name   string             `bson:"name"`
+		} `bson:"_id"`
+		Count int `bson:"count"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return err
+	}
+
+	if len(groups) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	tags := make([]interface{}, 0, len(groups))
+	for _, group := range groups {
+		if group.ID.Name == "" {
+			continue
+		}
+		tags = append(tags, models.Tag{
+			ID:        primitive.NewObjectID(),
+			UserID:    group.ID.UserID,
+			Name:      group.ID.Name,
+			Count:     group.Count,
+			CreatedAt: now,
+			UpdatedAt: now,
+		})
+	}
+
+	if len(tags) == 0 {
+		return nil
+	}
+
+	if _, err := collection.InsertMany(ctx, tags); err != nil {
+		return err
+	}
+
+	log.Printf("Backfilled %d file tags", len(tags))
+	return nil
+}
+
+// backfillFolderUsage computes the recursive size of folders created before
+// folder sizes were kept up to date
+func backfillFolderUsage() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	collection := GetCollection("folders")
+
+	count, err := collection.CountDocuments(ctx, bson.M{"total_size": bson.M{"$exists": false}})
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return nil
+	}
+
+	cursor, err := collection.Find(ctx,
+		bson.M{"is_deleted": false},
+		options.Find().SetProjection(bson.M{"_id": 1, "parent_id": 1}),
+	)
+	if err != nil {
+		return err
+	}
+	var folders []struct {
+		ID       primitive.ObjectID  `bson:"_id"`
+		ParentID *primitive.ObjectID `bson:"parent_id"`
+	}
+	if err := cursor.All(ctx, &folders); err != nil {
+		return err
+	}
+
+	cursor, err = GetCollection("files").Aggregate(ctx, []bson.M{
+		{"$match": bson.M{"is_deleted": false, "folder_id": bson.M{"$ne": nil}}},
+		{"$group": bson.M{
+			"_id":   "$folder_id",
+			"size":  bson.M{"$sum": "$size"},
+			"count": bson.M{"$sum": 1},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	var groups []struct {
+		FolderID primitive.ObjectID `bson:"_id"`
+		Size     int64              `bson:"size"`
+		Count    int                `bson:"count"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return err
+	}
+
+	type usage struct {
+		size, totalSize   int64
+		files, totalFiles int
+	}
+	usages := make(map[primitive.ObjectID]*usage, len(folders))
+	parents := make(map[primitive.ObjectID]*primitive.ObjectID, len(folders))
+	for _, folder := range folders {
+		usages[folder.ID] = &usage{}
+		parents[folder.ID] = folder.ParentID
+	}
+	for _, group := range groups {
+		if u, ok := usages[group.FolderID]; ok {
+			u.size, u.files = group.Size, group.Count
+		}
+	}
+
+	// Add each folder's direct files to itself and every live ancestor
+	for id, u := range usages {
+		seen := make(map[primitive.ObjectID]bool)
+		for current := &id; current != nil && !seen[*current]; current = parents[*current] {
+			ancestor, ok := usages[*current]
+			if !ok {
+				break
+			}
+			seen[*current] = true
+			ancestor.totalSize += u.size
+			ancestor.totalFiles += u.files
+		}
+	}
+
+	writes := make([]mongo.WriteModel, 0, len(usages))
+	for id, u := range usages {
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": id}).
+			SetUpdate(bson.M{"$set": bson.M{
+				"size":        u.size,
+				"files_count": u.files,
+				"total_size":  u.totalSize,
+				"total_files": u.totalFiles,
+			}}))
+	}
+
+	// Folders in the trash are no longer counted by their ancestors
+	writes = append(writes, mongo.NewUpdateManyModel().
+		SetFilter(bson.M{"total_size": bson.M{"$exists": false}}).
+		SetUpdate(bson.M{"$set": bson.M{"total_size": 0, "total_files": 0}}))
+
+	if _, err := collection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false)); err != nil {
+		return err
+	}
+
+	log.Printf("Backfilled usage of %d folders", len(usages))
+	return nil
+}
+
+// backfillFolderAncestors stores the ancestors of folders created before
+// folders kept them
+func backfillFolderAncestors() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	collection := GetCollection("folders")
+
+	count, err := collection.CountDocuments(ctx, bson.M{"ancestors": bson.M{"$exists": false}})
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return nil
+	}
+
+	cursor, err := collection.Find(ctx, bson.M{},
+		options.Find().SetProjection(bson.M{"_id": 1, "parent_id": 1}),
+	)
+	if err != nil {
+		return err
+	}
+	var folders []struct {
+		ID       primitive.ObjectID  `bson:"_id"`
+		ParentID *primitive.ObjectID `bson:"parent_id"`
+	}
+	if err := cursor.All(ctx, &folders); err != nil {
+		return err
+	}
+
+	parents := make(map[primitive.ObjectID]*primitive.ObjectID, len(folders))
+	for _, folder := range folders {
+		parents[folder.ID] = folder.ParentID
+	}
+
+	writes := make([]mongo.WriteModel, 0, len(folders))
+	for _, folder := range folders {
+		// Walk up the parents, guarding against cycles, then reverse so the
+		// root comes first
+		ancestors := []primitive.ObjectID{}
+		seen := map[primitive.ObjectID]bool{folder.ID: true}
+		for current := folder.ParentID; current != nil && !seen[*current]; current = parents[*current] {
+			seen[*current] = true
+			ancestors = append(ancestors, *current)
+		}
+		for i, j := 0, len(ancestors)-1; i < j; i, j = i+1, j-1 {
+			ancestors[i], ancestors[j] = ancestors[j], ancestors[i]
+		}
+
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": folder.ID}).
+			SetUpdate(bson.M{"$set": bson.M{"ancestors": ancestors}}))
+	}
+
+	if _, err := collection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false)); err != nil {
+		return err
+	}
+
+	log.Printf("Backfilled ancestors of %d folders", len(folders))
+	return nil
+}
+
+// repairFolderPaths rebuilds the paths of folders left stale by moves made
+// before moving a folder updated the folders below it. Moves and renames now
+// rewrite descendant paths by replacing the moved folder's path prefix, which
+// relies on the paths below it being right.
+func repairFolderPaths() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	repaired, err := repairFolderPathsIn(ctx, GetCollection("folders"))
+	if err != nil {
+		return err
+	}
+	if repaired > 0 {
+		log.Printf("Repaired paths of %d folders", repaired)
+	}
+	return nil
+}
+
+// repairFolderPathsIn rebuilds the stale folder paths in collection and
+// returns how many it rewrote
+func repairFolderPathsIn(ctx context.Context, collection *mongo.Collection) (int, error) {
+	// Look for a folder whose path is not its parent's path and its name
+	cursor, err := collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$lookup", Value: bson.M{
+			"from":         collection.Name(),
+			"localField":   "parent_id",
+			"foreignField": "_id",
+			"as":           "parent",
+		}}},
+		{{Key: "$match", Value: bson.M{"$expr": bson.M{"$ne": bson.A{
+			"$path",
+			bson.M{"$concat": bson.A{
+				bson.M{"$ifNull": bson.A{bson.M{"$arrayElemAt": bson.A{"$parent.path", 0}}, ""}},
+				"/",
+				"$name",
+			}},
+		}}}}},
+		{{Key: "$limit", Value: 1}},
+		{{Key: "$project", Value: bson.M{"_id": 1}}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	var stale []bson.M
+	if err := cursor.All(ctx, &stale); err != nil {
+		return 0, err
+	}
+
+	if len(stale) == 0 {
+		return 0, nil
+	}
+
+	cursor, err = collection.Find(ctx, bson.M{},
+		options.Find().SetProjection(bson.M{"_id": 1, "parent_id": 1, "name": 1, "path": 1}),
+	)
+	if err != nil {
+		return 0, err
+	}
+	var folders []folderLineage
+	if err := cursor.All(ctx, &folders); err != nil {
+		return 0, err
+	}
+
+	paths := rebuiltFolderPaths(folders)
+	if len(paths) == 0 {
+		return 0, nil
+	}
+
+	writes := make([]mongo.WriteModel, 0, len(paths))
+	for id, path := range paths {
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": id}).
+			SetUpdate(bson.M{"$set": bson.M{"path": path}}))
+	}
+	if _, err := collection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false)); err != nil {
+		return 0, err
+	}
+	return len(writes), nil
+}
+
+// folderLineage is what the path repair reads of a folder
+type folderLineage struct {
+	ID       primitive.ObjectID  `bson:"_id"`
+	ParentID *primitive.ObjectID `bson:"parent_id"`
+	Name     string              `bson:"name"`
+	Path     string              `bson:"path"`
+}
+
+// rebuiltFolderPaths joins each folder's name to those of its parents, from
+// the root down, and returns the paths that differ from the stored ones.
+// Walks stop at a missing parent or at a folder seen already, so orphans
+// and cycles get a path of what could be followed.
+func rebuiltFolderPaths(folders []folderLineage) map[primitive.ObjectID]string {
+	type node struct {
+		parentID *primitive.ObjectID
+		name     string
+	}
+	nodes := make(map[primitive.ObjectID]node, len(folders))
+	for _, folder := range folders {
+		nodes[folder.ID] = node{parentID: folder.ParentID, name: folder.Name}
+	}
+
+	paths := make(map[primitive.ObjectID]string)
+	for _, folder := range folders {
+		names := []string{folder.Name}
+		seen := map[primitive.ObjectID]bool{folder.ID: true}
+		for current := folder.ParentID; current != nil && !seen[*current]; {
+			parent, ok := nodes[*current]
+			if !ok {
+				break
+			}
+			seen[*current] = true
+			names = append(names, parent.name)
+			current = parent.parentID
+		}
+
+		var path strings.Builder
+		for i := len(names) - 1; i >= 0; i-- {
+			path.WriteString("/")
+			path.WriteString(names[i])
+		}
+		if path.String() != folder.Path {
+			paths[folder.ID] = path.String()
+		}
+	}
+	return paths
+}
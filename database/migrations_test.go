@@ -0,0 +1,148 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// folderChain returns a chain of depth folders, each the parent of the
+// next, with correct paths
+func folderChain(depth int) []folderLineage {
+	folders := make([]folderLineage, depth)
+	path := ""
+	for i := range folders {
+		name := fmt.Sprintf("f%d", i)
+		path += "/" + name
+		folders[i] = folderLineage{ID: primitive.NewObjectID(), Name: name, Path: path}
+		if i > 0 {
+			parentID := folders[i-1].ID
+			folders[i].ParentID = &parentID
+		}
+	}
+	return folders
+}
+
+func TestRebuiltFolderPathsLeavesCorrectPaths(t *testing.T) {
+	if paths := rebuiltFolderPaths(folderChain(300)); len(paths) != 0 {
+		t.Fatalf("rebuilt %d correct paths", len(paths))
+	}
+}
+
+// A folder moved before moves rewrote the folders below it has its own
+// path updated and every descendant still under the old one
+func TestRebuiltFolderPathsRepairsDeepChainAfterMove(t *testing.T) {
+	const depth, moved = 300, 100
+	folders := folderChain(depth)
+
+	// Move folder moved to the root, as the old move did
+	folders[moved].ParentID = nil
+	folders[moved].Path = "/" + folders[moved].Name
+
+	paths := rebuiltFolderPaths(folders)
+	if len(paths) != depth-moved-1 {
+		t.Fatalf("rebuilt %d paths, want %d", len(paths), depth-moved-1)
+	}
+	for i := moved + 1; i < depth; i++ {
+		var want strings.Builder
+		for j := moved; j <= i; j++ {
+			want.WriteString("/" + folders[j].Name)
+		}
+		if got := paths[folders[i].ID]; got != want.String() {
+			t.Fatalf("folder %d: path %q, want %q", i, got, want.String())
+		}
+	}
+}
+
+func TestRebuiltFolderPathsStopsAtCyclesAndOrphans(t *testing.T) {
+	folders := folderChain(3)
+
+	// f0 under f2 makes a cycle
+	cycleParent := folders[2].ID
+	folders[0].ParentID = &cycleParent
+	// f3 under a folder that no longer exists
+	missing := primitive.NewObjectID()
+	orphan := folderLineage{ID: primitive.NewObjectID(), ParentID: &missing, Name: "orphan", Path: "/gone/orphan"}
+	folders = append(folders, orphan)
+
+	paths := rebuiltFolderPaths(folders)
+	if got := paths[folders[0].ID]; got != "/f1/f2/f0" {
+		t.Errorf("cycle path %q, want /f1/f2/f0", got)
+	}
+	if got := paths[orphan.ID]; got != "/orphan" {
+		t.Errorf("orphan path %q, want /orphan", got)
+	}
+}
+
+// testCollection returns a collection in a database of its own on the
+// MongoDB at TEST_MONGO_URI, dropped when the test ends, and skips the test
+// when no test database is configured
+func testCollection(t *testing.T, name string) *mongo.Collection {
+	t.Helper()
+	uri := os.Getenv("TEST_MONGO_URI")
+	if uri == "" {
+		t.Skip("TEST_MONGO_URI is not set")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("failed to connect to test database: %v", err)
+	}
+	db := client.Database("oncloud_test_" + primitive.NewObjectID().Hex())
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		db.Drop(ctx)
+		client.Disconnect(ctx)
+	})
+	return db.Collection(name)
+}
+
+func TestRepairFolderPathsInDeepChain(t *testing.T) {
+	collection := testCollection(t, "folders")
+	ctx := context.Background()
+
+	const depth, moved = 200, 50
+	folders := folderChain(depth)
+	folders[moved].ParentID = nil
+	folders[moved].Path = "/" + folders[moved].Name
+
+	docs := make([]interface{}, len(folders))
+	for i, folder := range folders {
+		docs[i] = folder
+	}
+	if _, err := collection.InsertMany(ctx, docs); err != nil {
+		t.Fatal(err)
+	}
+
+	repaired, err := repairFolderPathsIn(ctx, collection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repaired != depth-moved-1 {
+		t.Fatalf("repaired %d folders, want %d", repaired, depth-moved-1)
+	}
+
+	var deepest folderLineage
+	if err := collection.FindOne(ctx, bson.M{"_id": folders[depth-1].ID}).Decode(&deepest); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(deepest.Path, "/"+folders[moved].Name+"/") || strings.Count(deepest.Path, "/") != depth-moved {
+		t.Fatalf("deepest folder path %q", deepest.Path)
+	}
+
+	// A second run finds nothing to repair
+	if repaired, err := repairFolderPathsIn(ctx, collection); err != nil || repaired != 0 {
+		t.Fatalf("second run repaired %d, err %v", repaired, err)
+	}
+}
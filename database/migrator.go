@@ -0,0 +1,345 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	schemaMigrationsCollection = "schema_migrations"
+	migrationLocksCollection   = "migration_locks"
+
+	// A lock left behind by a process that died mid-migration is taken over
+	// after this long
+	migrationLockLease = 30 * time.Minute
+)
+
+var (
+	ErrIrreversibleMigration = errors.New("migration cannot be reverted")
+	ErrMigrationLocked       = errors.New("migrations are being applied by another process")
+	ErrUnknownMigration      = errors.New("migration is not known to this build")
+)
+
+// Migration is a versioned change to the schema or data, such as an index
+// change or a backfill. Migrations are applied in order of version and each
+// is applied once; Down reverts Up, and is nil when it can't be reverted.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func() error
+	Down    func() error
+}
+
+// MigrationStatus is whether a migration has been applied
+type MigrationStatus struct {
+	Version    int        `json:"version"`
+	Name       string     `json:"name"`
+	Applied    bool       `json:"applied"`
+	AppliedAt  *time.Time `json:"applied_at,omitempty"`
+	Reversible bool       `json:"reversible"`
+}
+
+// migrationRecord marks a migration as applied
+type migrationRecord struct {
+	Version    int       `bson:"_id"`
+	Name       string    `bson:"name"`
+	AppliedAt  time.Time `bson:"applied_at"`
+	DurationMs int64     `bson:"duration_ms"`
+}
+
+// MigrationStatuses lists every migration, known to this build or applied,
+// in order of version
+func MigrationStatuses() ([]MigrationStatus, error) {
+	applied, err := appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	known := make(map[int]bool, len(migrations))
+	for _, migration := range migrations {
+		known[migration.Version] = true
+		status := MigrationStatus{
+			Version:    migration.Version,
+			Name:       migration.Name,
+			Reversible: migration.Down != nil,
+		}
+		if record, ok := applied[migration.Version]; ok {
+			status.Applied = true
+			status.AppliedAt = &record.AppliedAt
+		}
+		statuses = append(statuses, status)
+	}
+
+	// Migrations applied by a newer build are listed too, so rolling back to
+	// an older build doesn't hide them
+	for version, record := range applied {
+		if known[version] {
+			continue
+		}
+		appliedAt := record.AppliedAt
+		statuses = append(statuses, MigrationStatus{
+			Version:   version,
+			Name:      record.Name,
+			Applied:   true,
+			AppliedAt: &appliedAt,
+		})
+	}
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// PendingMigrations lists the migrations not applied yet, in the order they
+// would be applied
+func PendingMigrations() ([]MigrationStatus, error) {
+	statuses, err := MigrationStatuses()
+	if err != nil {
+		return nil, err
+	}
+
+	pending := []MigrationStatus{}
+	for _, status := range statuses {
+		if !status.Applied {
+			pending = append(pending, status)
+		}
+	}
+	return pending, nil
+}
+
+// MigrateUp applies the pending migrations up to and including version to,
+// or all of them when to is 0, and returns those applied. A dry run returns
+// the migrations that would be applied without applying them.
+func MigrateUp(to int, dryRun bool) ([]MigrationStatus, error) {
+	if !dryRun {
+		release, err := lockMigrations()
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+	}
+
+	pending, err := PendingMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	toApply := []MigrationStatus{}
+	for _, status := range pending {
+		if to == 0 || status.Version <= to {
+			toApply = append(toApply, status)
+		}
+	}
+	if dryRun {
+		return toApply, nil
+	}
+
+	applied := []MigrationStatus{}
+	for _, status := range toApply {
+		migration := findMigration(status.Version)
+
+		log.Printf("Applying migration %d %s...", migration.Version, migration.Name)
+		started := time.Now()
+		if err := migration.Up(); err != nil {
+			return applied, fmt.Errorf("migration %d %s failed: %v", migration.Version, migration.Name, err)
+		}
+
+		now := time.Now()
+		if err := recordMigration(migration, now, now.Sub(started)); err != nil {
+			return applied, err
+		}
+		status.Applied = true
+		status.AppliedAt = &now
+		applied = append(applied, status)
+	}
+	return applied, nil
+}
+
+// MigrateDown reverts the applied migrations above version to, newest first,
+// and returns those reverted. Nothing is reverted when any of them can't be.
+// A dry run returns the migrations that would be reverted without reverting
+// them.
+func MigrateDown(to int, dryRun bool) ([]MigrationStatus, error) {
+	if !dryRun {
+		release, err := lockMigrations()
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+	}
+
+	statuses, err := MigrationStatuses()
+	if err != nil {
+		return nil, err
+	}
+
+	toRevert := []MigrationStatus{}
+	for i := len(statuses) - 1; i >= 0; i-- {
+		status := statuses[i]
+		if !status.Applied || status.Version <= to {
+			continue
+		}
+		migration := findMigration(status.Version)
+		if migration == nil {
+			return nil, fmt.Errorf("%w: %d %s", ErrUnknownMigration, status.Version, status.Name)
+		}
+		if migration.Down == nil {
+			return nil, fmt.Errorf("%w: %d %s", ErrIrreversibleMigration, status.Version, status.Name)
+		}
+		toRevert = append(toRevert, status)
+	}
+	if dryRun {
+		return toRevert, nil
+	}
+
+	reverted := []MigrationStatus{}
+	for _, status := range toRevert {
+		migration := findMigration(status.Version)
+
+		log.Printf("Reverting migration %d %s...", migration.Version, migration.Name)
+		if err := migration.Down(); err != nil {
+			return reverted, fmt.Errorf("reverting migration %d %s failed: %v", migration.Version, migration.Name, err)
+		}
+		if err := forgetMigration(migration); err != nil {
+			return reverted, err
+		}
+		status.Applied = false
+		status.AppliedAt = nil
+		reverted = append(reverted, status)
+	}
+	return reverted, nil
+}
+
+func findMigration(version int) *Migration {
+	for i := range migrations {
+		if migrations[i].Version == version {
+			return &migrations[i]
+		}
+	}
+	return nil
+}
+
+func appliedMigrations() (map[int]migrationRecord, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := GetCollection(schemaMigrationsCollection).Find(ctx, bson.M{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list applied migrations: %v", err)
+	}
+	var records []migrationRecord
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, fmt.Errorf("failed to list applied migrations: %v", err)
+	}
+
+	applied := make(map[int]migrationRecord, len(records))
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
+
+func recordMigration(migration *Migration, appliedAt time.Time, took time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := GetCollection(schemaMigrationsCollection).ReplaceOne(ctx,
+		bson.M{"_id": migration.Version},
+		migrationRecord{
+			Version:    migration.Version,
+			Name:       migration.Name,
+			AppliedAt:  appliedAt,
+			DurationMs: took.Milliseconds(),
+		},
+		options.Replace().SetUpsert(true),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record migration %d %s: %v", migration.Version, migration.Name, err)
+	}
+	return nil
+}
+
+func forgetMigration(migration *Migration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := GetCollection(schemaMigrationsCollection).DeleteOne(ctx, bson.M{"_id": migration.Version}); err != nil {
+		return fmt.Errorf("failed to forget migration %d %s: %v", migration.Version, migration.Name, err)
+	}
+	return nil
+}
+
+// lockMigrations keeps instances starting together from applying the same
+// migrations at once. The returned function releases the lock.
+func lockMigrations() (func(), error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	hostname, _ := os.Hostname()
+	holder := fmt.Sprintf("%s:%d:%d", hostname, os.Getpid(), time.Now().UnixNano())
+	now := time.Now()
+
+	_, err := GetCollection(migrationLocksCollection).UpdateOne(ctx,
+		bson.M{"_id": "schema", "locked_until": bson.M{"$lt": now}},
+		bson.M{"$set": bson.M{"holder": holder, "locked_at": now, "locked_until": now.Add(migrationLockLease)}},
+		options.Update().SetUpsert(true),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrMigrationLocked
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock migrations: %v", err)
+	}
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if _, err := GetCollection(migrationLocksCollection).DeleteOne(ctx, bson.M{"_id": "schema", "holder": holder}); err != nil {
+			log.Printf("Failed to release migration lock: %v", err)
+		}
+	}, nil
+}
+
+// createIndexes returns a migration step creating indexes on a collection
+func createIndexes(collection string, indexes ...mongo.IndexModel) func() error {
+	return func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+
+		if _, err := GetCollection(collection).Indexes().CreateMany(ctx, indexes); err != nil {
+			return fmt.Errorf("failed to create %s indexes: %v", collection, err)
+		}
+		return nil
+	}
+}
+
+// dropIndexes returns a migration step dropping indexes from a collection by
+// name. Indexes already gone are skipped.
+func dropIndexes(collection string, names ...string) func() error {
+	return func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+
+		for _, name := range names {
+			_, err := GetCollection(collection).Indexes().DropOne(ctx, name)
+			var cmdErr mongo.CommandError
+			if errors.As(err, &cmdErr) && cmdErr.Name == "IndexNotFound" {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("failed to drop %s index %s: %v", collection, name, err)
+			}
+		}
+		return nil
+	}
+}
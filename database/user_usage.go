@@ -0,0 +1,117 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// UserUsage is what a user's usage counters should be according to their
+// files. Storage covers all of the user's files, in the trash or not, and
+// the older versions kept of them; files only the files not in the trash.
+type UserUsage struct {
+	UserID  primitive.ObjectID `bson:"_id"`
+	Storage int64              `bson:"storage"`
+	Files   int                `bson:"files"`
+}
+
+// CountUserUsage computes the usage of the given users from their files.
+// Users without files are left out.
+func CountUserUsage(ctx context.Context, userIDs []primitive.ObjectID) (map[primitive.ObjectID]UserUsage, error) {
+	cursor, err := GetCollection("files").Aggregate(ctx, []bson.M{
+		{"$match": bson.M{"user_id": bson.M{"$in": userIDs}}},
+		{"$lookup": bson.M{
+			"from":         "file_versions",
+			"localField":   "_id",
+			"foreignField": "file_id",
+			"as":           "versions",
+		}},
+		{"$group": bson.M{
+			"_id":     "$user_id",
+			"storage": bson.M{"$sum": bson.M{"$add": bson.A{"$size", bson.M{"$sum": "$versions.size"}}}},
+			"files":   bson.M{"$sum": bson.M{"$cond": bson.A{"$is_deleted", 0, 1}}},
+		}},
+	}, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, err
+	}
+
+	var usages []UserUsage
+	if err := cursor.All(ctx, &usages); err != nil {
+		return nil, err
+	}
+
+	byUser := make(map[primitive.ObjectID]UserUsage, len(usages))
+	for _, usage := range usages {
+		byUser[usage.UserID] = usage
+	}
+	return byUser, nil
+}
+
+// recountUserUsage sets every user's usage counters from their files, in
+// batches of users
+func recountUserUsage() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	users := GetCollection("users")
+	cursor, err := users.Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"_id": 1}).SetBatchSize(500))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	recounted := 0
+	flush := func(userIDs []primitive.ObjectID) error {
+		usages, err := CountUserUsage(ctx, userIDs)
+		if err != nil {
+			return err
+		}
+		writes := make([]mongo.WriteModel, 0, len(userIDs))
+		for _, userID := range userIDs {
+			usage := usages[userID]
+			writes = append(writes, mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"_id": userID}).
+				SetUpdate(bson.M{"$set": bson.M{"storage_used": usage.Storage, "files_count": usage.Files}}))
+		}
+		if _, err := users.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false)); err != nil {
+			return fmt.Errorf("failed to update usage counters: %v", err)
+		}
+		recounted += len(userIDs)
+		return nil
+	}
+
+	batch := make([]primitive.ObjectID, 0, 500)
+	for cursor.Next(ctx) {
+		var user struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err := cursor.Decode(&user); err != nil {
+			return err
+		}
+		batch = append(batch, user.ID)
+		if len(batch) == cap(batch) {
+			if err := flush(batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	if len(batch) > 0 {
+		if err := flush(batch); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Recounted usage of %d users", recounted)
+	return nil
+}
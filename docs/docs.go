@@ -0,0 +1,11 @@
+// Package docs holds the API's OpenAPI document, embedded into the binary
+// so the running server always serves the spec it was built with.
+package docs
+
+import _ "embed"
+
+// OpenAPISpec is the OpenAPI 3 document for /api/v1. It is maintained by
+// hand; update it together with the routes and request models.
+//
+//go:embed openapi.yaml
+var OpenAPISpec []byte
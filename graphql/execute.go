@@ -0,0 +1,505 @@
+// Package graphql is a small GraphQL query engine for the web frontend's
+// API. It supports queries with variables, fragments and the @skip and
+// @include directives against a schema of object and scalar types, and
+// batch resolvers so that related records are loaded a level at a time.
+// Mutations, subscriptions and introspection are not supported; the
+// schema is published as SDL instead.
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// Queries nested deeper than this are refused, so a client cannot make
+// the server walk e.g. folder { parent { parent { ... } } } indefinitely
+const maxQueryDepth = 12
+
+// Request is a GraphQL request as sent by clients
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// Response is the result of a request. Data is left out when the request
+// failed before execution.
+type Response struct {
+	Data   interface{} `json:"data,omitempty"`
+	Errors []*Error    `json:"errors,omitempty"`
+}
+
+type Error struct {
+	Message   string        `json:"message"`
+	Locations []Location    `json:"locations,omitempty"`
+	Path      []interface{} `json:"path,omitempty"`
+}
+
+type Location struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// Execute runs a query against the schema. Fields are resolved breadth
+// first: each field is resolved for every object at its level before the
+// level below, so batch resolvers see all their parents at once.
+func (s *Schema) Execute(ctx context.Context, req *Request) *Response {
+	doc, err := Parse(req.Query)
+	if err != nil {
+		return &Response{Errors: []*Error{{Message: err.Error()}}}
+	}
+
+	operation, err := selectOperation(doc, req.OperationName)
+	if err != nil {
+		return &Response{Errors: []*Error{{Message: err.Error()}}}
+	}
+	if operation.Type != "query" {
+		return &Response{Errors: []*Error{{Message: "only queries are supported"}}}
+	}
+
+	e := &executor{schema: s, doc: doc}
+	if e.variables, err = s.coerceVariables(operation, req.Variables); err != nil {
+		return &Response{Errors: []*Error{{Message: err.Error()}}}
+	}
+
+	v := &validator{executor: e, operation: operation, fragmentsInUse: map[string]bool{}}
+	v.validateSelections(s.Query, operation.SelectionSet, 1)
+	if len(v.errors) > 0 {
+		return &Response{Errors: v.errors}
+	}
+
+	results := e.executeObjects(ctx, s.Query, []interface{}{nil}, [][]interface{}{nil}, operation.SelectionSet)
+	return &Response{Data: results[0], Errors: e.errors}
+}
+
+func selectOperation(doc *Document, name string) (*Operation, error) {
+	if name == "" {
+		if len(doc.Operations) > 1 {
+			return nil, fmt.Errorf("operationName is required when the document has several operations")
+		}
+		return doc.Operations[0], nil
+	}
+	for _, operation := range doc.Operations {
+		if operation.Name == name {
+			return operation, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown operation %q", name)
+}
+
+func (s *Schema) coerceVariables(operation *Operation, values map[string]interface{}) (map[string]interface{}, error) {
+	variables := map[string]interface{}{}
+	for _, definition := range operation.Variables {
+		t, err := s.typeFromRef(&definition.Type)
+		if err != nil {
+			return nil, fmt.Errorf("variable $%s: %v", definition.Name, err)
+		}
+
+		value, provided := values[definition.Name]
+		if !provided {
+			if definition.Default == nil {
+				if _, required := t.(*NonNull); required {
+					return nil, fmt.Errorf("variable $%s is required", definition.Name)
+				}
+				continue
+			}
+			value = valueFromAST(definition.Default, nil)
+		}
+
+		coerced, err := coerceInput(t, value)
+		if err != nil {
+			return nil, fmt.Errorf("variable $%s: %v", definition.Name, err)
+		}
+		variables[definition.Name] = coerced
+	}
+	return variables, nil
+}
+
+func (s *Schema) typeFromRef(ref *TypeRef) (Type, error) {
+	var t Type
+	if ref.Elem != nil {
+		elem, err := s.typeFromRef(ref.Elem)
+		if err != nil {
+			return nil, err
+		}
+		t = &List{OfType: elem}
+	} else {
+		named, ok := s.types[ref.Name].(*Scalar)
+		if !ok {
+			return nil, fmt.Errorf("unknown input type %s", ref.Name)
+		}
+		t = named
+	}
+	if ref.NonNull {
+		t = &NonNull{OfType: t}
+	}
+	return t, nil
+}
+
+type executor struct {
+	schema    *Schema
+	doc       *Document
+	variables map[string]interface{}
+	errors    []*Error
+}
+
+// collectedField is the fields of a selection set sharing a response key
+type collectedField struct {
+	key   string
+	nodes []*Field
+}
+
+// executeObjects resolves a selection set on each of sources, objects of
+// type t found at paths
+func (e *executor) executeObjects(ctx context.Context, t *Object, sources []interface{}, paths [][]interface{}, selections []Selection) []*orderedMap {
+	results := make([]*orderedMap, len(sources))
+	for i := range results {
+		results[i] = &orderedMap{values: map[string]interface{}{}}
+	}
+
+	for _, field := range e.collectFields(t, selections, nil, map[string]bool{}) {
+		node := field.nodes[0]
+		if node.Name == "__typename" {
+			for _, result := range results {
+				result.set(field.key, t.Name)
+			}
+			continue
+		}
+
+		definition := t.field(node.Name)
+		fieldPaths := make([][]interface{}, len(paths))
+		for i, path := range paths {
+			fieldPaths[i] = appendPath(path, field.key)
+		}
+
+		values := make([]interface{}, len(sources))
+		args, err := e.coerceArguments(definition, node.Arguments)
+		if err != nil {
+			e.addError(err, node, fieldPaths[0])
+		} else {
+			values = e.resolve(ctx, definition, node, sources, args, fieldPaths)
+		}
+
+		var subSelections []Selection
+		for _, n := range field.nodes {
+			subSelections = append(subSelections, n.SelectionSet...)
+		}
+		completed := e.complete(ctx, definition.Type, values, fieldPaths, subSelections)
+		for i, result := range results {
+			result.set(field.key, completed[i])
+		}
+	}
+	return results
+}
+
+func (e *executor) resolve(ctx context.Context, definition *FieldDefinition, node *Field, sources []interface{}, args map[string]interface{}, paths [][]interface{}) []interface{} {
+	if definition.BatchResolve != nil {
+		values, err := definition.BatchResolve(ctx, sources, args)
+		if err == nil && len(values) != len(sources) {
+			err = fmt.Errorf("internal error: resolved %d values for %d objects", len(values), len(sources))
+		}
+		if err != nil {
+			e.addError(err, node, paths[0])
+			return make([]interface{}, len(sources))
+		}
+		return values
+	}
+
+	values := make([]interface{}, len(sources))
+	for i, source := range sources {
+		value, err := definition.Resolve(ctx, source, args)
+		if err != nil {
+			e.addError(err, node, paths[i])
+			continue
+		}
+		values[i] = value
+	}
+	return values
+}
+
+// complete turns resolved values into their result form: scalars are
+// serialized and objects and lists of objects executed, a level at a time
+func (e *executor) complete(ctx context.Context, t Type, values []interface{}, paths [][]interface{}, selections []Selection) []interface{} {
+	completed := make([]interface{}, len(values))
+	switch t := t.(type) {
+	case *NonNull:
+		return e.complete(ctx, t.OfType, values, paths, selections)
+
+	case *Scalar:
+		for i, value := range values {
+			if !isNil(value) {
+				completed[i] = t.Serialize(value)
+			}
+		}
+
+	case *List:
+		var items []interface{}
+		var itemPaths [][]interface{}
+		counts := make([]int, len(values))
+		for i, value := range values {
+			if isNil(value) {
+				counts[i] = -1
+				continue
+			}
+			list := reflect.ValueOf(value)
+			if list.Kind() != reflect.Slice && list.Kind() != reflect.Array {
+				e.errors = append(e.errors, &Error{Message: "internal error: expected a list", Path: paths[i]})
+				counts[i] = -1
+				continue
+			}
+			counts[i] = list.Len()
+			for j := 0; j < list.Len(); j++ {
+				items = append(items, list.Index(j).Interface())
+				itemPaths = append(itemPaths, appendPath(paths[i], j))
+			}
+		}
+
+		completedItems := e.complete(ctx, t.OfType, items, itemPaths, selections)
+		offset := 0
+		for i, count := range counts {
+			if count < 0 {
+				continue
+			}
+			completed[i] = completedItems[offset : offset+count : offset+count]
+			offset += count
+		}
+
+	case *Object:
+		var present []int
+		var sources []interface{}
+		var sourcePaths [][]interface{}
+		for i, value := range values {
+			if !isNil(value) {
+				present = append(present, i)
+				sources = append(sources, value)
+				sourcePaths = append(sourcePaths, paths[i])
+			}
+		}
+		if len(sources) > 0 {
+			for k, result := range e.executeObjects(ctx, t, sources, sourcePaths, selections) {
+				completed[present[k]] = result
+			}
+		}
+	}
+	return completed
+}
+
+// collectFields flattens fragments and groups fields by response key, in
+// the order they first appear
+func (e *executor) collectFields(t *Object, selections []Selection, fields []*collectedField, visited map[string]bool) []*collectedField {
+	for _, selection := range selections {
+		switch selection := selection.(type) {
+		case *Field:
+			if !e.included(selection.Directives) {
+				continue
+			}
+			key := selection.ResponseKey()
+			found := false
+			for _, field := range fields {
+				if field.key == key {
+					field.nodes = append(field.nodes, selection)
+					found = true
+					break
+				}
+			}
+			if !found {
+				fields = append(fields, &collectedField{key: key, nodes: []*Field{selection}})
+			}
+
+		case *FragmentSpread:
+			if visited[selection.Name] || !e.included(selection.Directives) {
+				continue
+			}
+			visited[selection.Name] = true
+			fragment := e.doc.Fragments[selection.Name]
+			if fragment.TypeCondition == t.Name {
+				fields = e.collectFields(t, fragment.SelectionSet, fields, visited)
+			}
+
+		case *InlineFragment:
+			if !e.included(selection.Directives) {
+				continue
+			}
+			if selection.TypeCondition == "" || selection.TypeCondition == t.Name {
+				fields = e.collectFields(t, selection.SelectionSet, fields, visited)
+			}
+		}
+	}
+	return fields
+}
+
+// included evaluates @skip and @include
+func (e *executor) included(directives []*Directive) bool {
+	for _, directive := range directives {
+		if directive.Name != "skip" && directive.Name != "include" {
+			continue
+		}
+		condition := false
+		for _, arg := range directive.Arguments {
+			if arg.Name == "if" {
+				condition, _ = valueFromAST(arg.Value, e.variables).(bool)
+			}
+		}
+		if condition == (directive.Name == "skip") {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *executor) coerceArguments(definition *FieldDefinition, nodes []*Argument) (map[string]interface{}, error) {
+	args := map[string]interface{}{}
+	for _, arg := range definition.Args {
+		var value interface{}
+		provided := false
+		for _, node := range nodes {
+			if node.Name != arg.Name {
+				continue
+			}
+			if node.Value.Kind == VariableValue {
+				value, provided = e.variables[node.Value.Raw]
+			} else {
+				value, provided = valueFromAST(node.Value, e.variables), true
+			}
+		}
+		if !provided {
+			value = arg.DefaultValue
+		}
+
+		coerced, err := coerceInput(arg.Type, value)
+		if err != nil {
+			return nil, fmt.Errorf("argument %q of field %q: %v", arg.Name, definition.Name, err)
+		}
+		if coerced != nil {
+			args[arg.Name] = coerced
+		}
+	}
+	return args, nil
+}
+
+func (e *executor) addError(err error, node *Field, path []interface{}) {
+	e.errors = append(e.errors, &Error{
+		Message:   err.Error(),
+		Locations: []Location{{Line: node.Line, Column: node.Column}},
+		Path:      path,
+	})
+}
+
+func coerceInput(t Type, value interface{}) (interface{}, error) {
+	if nonNull, ok := t.(*NonNull); ok {
+		if value == nil {
+			return nil, fmt.Errorf("a value is required")
+		}
+		return coerceInput(nonNull.OfType, value)
+	}
+	if value == nil {
+		return nil, nil
+	}
+
+	switch t := t.(type) {
+	case *List:
+		items, ok := value.([]interface{})
+		if !ok {
+			items = []interface{}{value}
+		}
+		coerced := make([]interface{}, len(items))
+		for i, item := range items {
+			var err error
+			if coerced[i], err = coerceInput(t.OfType, item); err != nil {
+				return nil, err
+			}
+		}
+		return coerced, nil
+	case *Scalar:
+		return t.ParseValue(value)
+	}
+	return nil, fmt.Errorf("%s is not an input type", t)
+}
+
+func valueFromAST(v *Value, variables map[string]interface{}) interface{} {
+	switch v.Kind {
+	case VariableValue:
+		return variables[v.Raw]
+	case IntValue:
+		if i, err := strconv.Atoi(v.Raw); err == nil {
+			return i
+		}
+		f, _ := strconv.ParseFloat(v.Raw, 64)
+		return f
+	case FloatValue:
+		f, _ := strconv.ParseFloat(v.Raw, 64)
+		return f
+	case StringValue, EnumValue:
+		return v.Raw
+	case BooleanValue:
+		return v.Raw == "true"
+	case ListValue:
+		list := make([]interface{}, len(v.List))
+		for i, item := range v.List {
+			list[i] = valueFromAST(item, variables)
+		}
+		return list
+	case ObjectValue:
+		object := map[string]interface{}{}
+		for _, field := range v.Fields {
+			object[field.Name] = valueFromAST(field.Value, variables)
+		}
+		return object
+	}
+	return nil
+}
+
+func isNil(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	switch v := reflect.ValueOf(value); v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
+
+// appendPath returns a copy of path with key added, leaving path's
+// backing array alone as it is shared by sibling fields
+func appendPath(path []interface{}, key interface{}) []interface{} {
+	return append(path[:len(path):len(path)], key)
+}
+
+// orderedMap is a result object, which keeps its fields in the order they
+// were selected
+type orderedMap struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (m *orderedMap) set(key string, value interface{}) {
+	if _, exists := m.values[key]; !exists {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+func (m *orderedMap) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, key := range m.keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		name, _ := json.Marshal(key)
+		b.Write(name)
+		b.WriteByte(':')
+		value, err := json.Marshal(m.values[key])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
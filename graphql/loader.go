@@ -0,0 +1,62 @@
+package graphql
+
+import (
+	"context"
+	"sync"
+)
+
+// FetchFunc loads the records with the given keys, returning them by key.
+// Keys with no record are left out.
+type FetchFunc func(ctx context.Context, keys []string) (map[string]interface{}, error)
+
+// Loader batches and caches record lookups for one request. Batch
+// resolvers pass it every key they need at once, and records already
+// loaded by an earlier field are not fetched again.
+type Loader struct {
+	fetch FetchFunc
+	mu    sync.Mutex
+	cache map[string]interface{}
+}
+
+func NewLoader(fetch FetchFunc) *Loader {
+	return &Loader{fetch: fetch, cache: map[string]interface{}{}}
+}
+
+// LoadMany returns the records for keys in the same order, with nil for
+// keys which have no record
+func (l *Loader) LoadMany(ctx context.Context, keys []string) ([]interface{}, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var missing []string
+	seen := map[string]bool{}
+	for _, key := range keys {
+		if _, cached := l.cache[key]; !cached && !seen[key] && key != "" {
+			missing = append(missing, key)
+			seen[key] = true
+		}
+	}
+
+	if len(missing) > 0 {
+		records, err := l.fetch(ctx, missing)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range missing {
+			l.cache[key] = records[key]
+		}
+	}
+
+	values := make([]interface{}, len(keys))
+	for i, key := range keys {
+		values[i] = l.cache[key]
+	}
+	return values, nil
+}
+
+// Prime adds a record loaded some other way to the cache
+func (l *Loader) Prime(key string, value interface{}) {
+	l.mu.Lock()
+	l.cache[key] = value
+	l.mu.Unlock()
+}
@@ -0,0 +1,762 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Document is a parsed GraphQL request document
+type Document struct {
+	Operations []*Operation
+	Fragments  map[string]*Fragment
+}
+
+type Operation struct {
+	Type         string // query, mutation or subscription
+	Name         string
+	Variables    []*VariableDefinition
+	SelectionSet []Selection
+}
+
+type VariableDefinition struct {
+	Name    string
+	Type    TypeRef
+	Default *Value
+}
+
+// TypeRef is a type as written in a variable definition
+type TypeRef struct {
+	Name    string
+	Elem    *TypeRef // set for lists
+	NonNull bool
+}
+
+type Fragment struct {
+	Name          string
+	TypeCondition string
+	SelectionSet  []Selection
+}
+
+// Selection is a *Field, *FragmentSpread or *InlineFragment
+type Selection interface {
+	selection()
+}
+
+type Field struct {
+	Alias        string
+	Name         string
+	Arguments    []*Argument
+	Directives   []*Directive
+	SelectionSet []Selection
+	Line         int
+	Column       int
+}
+
+type FragmentSpread struct {
+	Name       string
+	Directives []*Directive
+}
+
+type InlineFragment struct {
+	TypeCondition string
+	Directives    []*Directive
+	SelectionSet  []Selection
+}
+
+func (*Field) selection()          {}
+func (*FragmentSpread) selection() {}
+func (*InlineFragment) selection() {}
+
+// ResponseKey is the name the field's value is returned under
+func (f *Field) ResponseKey() string {
+	if f.Alias != "" {
+		return f.Alias
+	}
+	return f.Name
+}
+
+type Argument struct {
+	Name  string
+	Value *Value
+}
+
+type Directive struct {
+	Name      string
+	Arguments []*Argument
+}
+
+type ValueKind int
+
+const (
+	VariableValue ValueKind = iota
+	IntValue
+	FloatValue
+	StringValue
+	BooleanValue
+	NullValue
+	EnumValue
+	ListValue
+	ObjectValue
+)
+
+// Value is a literal or variable in a query
+type Value struct {
+	Kind   ValueKind
+	Raw    string // name, number, string or enum
+	List   []*Value
+	Fields []*Argument
+}
+
+// Parse parses a GraphQL request document
+func Parse(source string) (*Document, error) {
+	p := &parser{lexer: lexer{source: source, line: 1, lineStart: 0}}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	doc := &Document{Fragments: map[string]*Fragment{}}
+	for p.token.kind != tokenEOF {
+		switch {
+		case p.peekPunct("{"):
+			selections, err := p.parseSelectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.Operations = append(doc.Operations, &Operation{Type: "query", SelectionSet: selections})
+		case p.peekName("fragment"):
+			fragment, err := p.parseFragment()
+			if err != nil {
+				return nil, err
+			}
+			if _, exists := doc.Fragments[fragment.Name]; exists {
+				return nil, fmt.Errorf("fragment %q is defined more than once", fragment.Name)
+			}
+			doc.Fragments[fragment.Name] = fragment
+		case p.peekName("query"), p.peekName("mutation"), p.peekName("subscription"):
+			operation, err := p.parseOperation()
+			if err != nil {
+				return nil, err
+			}
+			doc.Operations = append(doc.Operations, operation)
+		default:
+			return nil, p.unexpected()
+		}
+	}
+
+	if len(doc.Operations) == 0 {
+		return nil, fmt.Errorf("document has no operation")
+	}
+	return doc, nil
+}
+
+type parser struct {
+	lexer lexer
+	token token
+}
+
+func (p *parser) advance() error {
+	token, err := p.lexer.next()
+	if err != nil {
+		return err
+	}
+	p.token = token
+	return nil
+}
+
+func (p *parser) peekPunct(value string) bool {
+	return p.token.kind == tokenPunct && p.token.value == value
+}
+
+func (p *parser) peekName(value string) bool {
+	return p.token.kind == tokenName && p.token.value == value
+}
+
+func (p *parser) unexpected() error {
+	if p.token.kind == tokenEOF {
+		return fmt.Errorf("syntax error: unexpected end of document")
+	}
+	return fmt.Errorf("syntax error: unexpected %q at line %d, column %d", p.token.value, p.token.line, p.token.column)
+}
+
+func (p *parser) expectPunct(value string) error {
+	if !p.peekPunct(value) {
+		return p.unexpected()
+	}
+	return p.advance()
+}
+
+func (p *parser) expectName() (string, error) {
+	if p.token.kind != tokenName {
+		return "", p.unexpected()
+	}
+	name := p.token.value
+	return name, p.advance()
+}
+
+func (p *parser) parseOperation() (*Operation, error) {
+	operation := &Operation{Type: p.token.value}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	if p.token.kind == tokenName {
+		operation.Name = p.token.value
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.peekPunct("(") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		for !p.peekPunct(")") {
+			definition, err := p.parseVariableDefinition()
+			if err != nil {
+				return nil, err
+			}
+			operation.Variables = append(operation.Variables, definition)
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := p.parseDirectives(); err != nil {
+		return nil, err
+	}
+
+	selections, err := p.parseSelectionSet()
+	if err != nil {
+		return nil, err
+	}
+	operation.SelectionSet = selections
+	return operation, nil
+}
+
+func (p *parser) parseVariableDefinition() (*VariableDefinition, error) {
+	if err := p.expectPunct("$"); err != nil {
+		return nil, err
+	}
+	name, err := p.expectName()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectPunct(":"); err != nil {
+		return nil, err
+	}
+	typeRef, err := p.parseTypeRef()
+	if err != nil {
+		return nil, err
+	}
+
+	definition := &VariableDefinition{Name: name, Type: *typeRef}
+	if p.peekPunct("=") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		if definition.Default, err = p.parseValue(true); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := p.parseDirectives(); err != nil {
+		return nil, err
+	}
+	return definition, nil
+}
+
+func (p *parser) parseTypeRef() (*TypeRef, error) {
+	var typeRef *TypeRef
+	if p.peekPunct("[") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		elem, err := p.parseTypeRef()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectPunct("]"); err != nil {
+			return nil, err
+		}
+		typeRef = &TypeRef{Elem: elem}
+	} else {
+		name, err := p.expectName()
+		if err != nil {
+			return nil, err
+		}
+		typeRef = &TypeRef{Name: name}
+	}
+
+	if p.peekPunct("!") {
+		typeRef.NonNull = true
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+	return typeRef, nil
+}
+
+func (p *parser) parseFragment() (*Fragment, error) {
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	name, err := p.expectName()
+	if err != nil {
+		return nil, err
+	}
+	if !p.peekName("on") {
+		return nil, p.unexpected()
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	typeCondition, err := p.expectName()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.parseDirectives(); err != nil {
+		return nil, err
+	}
+	selections, err := p.parseSelectionSet()
+	if err != nil {
+		return nil, err
+	}
+	return &Fragment{Name: name, TypeCondition: typeCondition, SelectionSet: selections}, nil
+}
+
+func (p *parser) parseSelectionSet() ([]Selection, error) {
+	if err := p.expectPunct("{"); err != nil {
+		return nil, err
+	}
+
+	var selections []Selection
+	for !p.peekPunct("}") {
+		selection, err := p.parseSelection()
+		if err != nil {
+			return nil, err
+		}
+		selections = append(selections, selection)
+	}
+	if len(selections) == 0 {
+		return nil, p.unexpected()
+	}
+	return selections, p.advance()
+}
+
+func (p *parser) parseSelection() (Selection, error) {
+	if p.peekPunct("...") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+
+		if p.token.kind == tokenName && p.token.value != "on" {
+			spread := &FragmentSpread{Name: p.token.value}
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			directives, err := p.parseDirectives()
+			spread.Directives = directives
+			return spread, err
+		}
+
+		fragment := &InlineFragment{}
+		if p.peekName("on") {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			typeCondition, err := p.expectName()
+			if err != nil {
+				return nil, err
+			}
+			fragment.TypeCondition = typeCondition
+		}
+		directives, err := p.parseDirectives()
+		if err != nil {
+			return nil, err
+		}
+		fragment.Directives = directives
+		fragment.SelectionSet, err = p.parseSelectionSet()
+		return fragment, err
+	}
+
+	field := &Field{Line: p.token.line, Column: p.token.column}
+	name, err := p.expectName()
+	if err != nil {
+		return nil, err
+	}
+	if p.peekPunct(":") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		field.Alias = name
+		if name, err = p.expectName(); err != nil {
+			return nil, err
+		}
+	}
+	field.Name = name
+
+	if field.Arguments, err = p.parseArguments(false); err != nil {
+		return nil, err
+	}
+	if field.Directives, err = p.parseDirectives(); err != nil {
+		return nil, err
+	}
+	if p.peekPunct("{") {
+		if field.SelectionSet, err = p.parseSelectionSet(); err != nil {
+			return nil, err
+		}
+	}
+	return field, nil
+}
+
+func (p *parser) parseArguments(constant bool) ([]*Argument, error) {
+	if !p.peekPunct("(") {
+		return nil, nil
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	var arguments []*Argument
+	for !p.peekPunct(")") {
+		name, err := p.expectName()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectPunct(":"); err != nil {
+			return nil, err
+		}
+		value, err := p.parseValue(constant)
+		if err != nil {
+			return nil, err
+		}
+		arguments = append(arguments, &Argument{Name: name, Value: value})
+	}
+	return arguments, p.advance()
+}
+
+func (p *parser) parseDirectives() ([]*Directive, error) {
+	var directives []*Directive
+	for p.peekPunct("@") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		name, err := p.expectName()
+		if err != nil {
+			return nil, err
+		}
+		arguments, err := p.parseArguments(false)
+		if err != nil {
+			return nil, err
+		}
+		directives = append(directives, &Directive{Name: name, Arguments: arguments})
+	}
+	return directives, nil
+}
+
+// parseValue parses a value; constant values, such as variable defaults,
+// may not refer to variables
+func (p *parser) parseValue(constant bool) (*Value, error) {
+	token := p.token
+	switch {
+	case token.kind == tokenPunct && token.value == "$" && !constant:
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		name, err := p.expectName()
+		return &Value{Kind: VariableValue, Raw: name}, err
+
+	case token.kind == tokenPunct && token.value == "[":
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		value := &Value{Kind: ListValue, List: []*Value{}}
+		for !p.peekPunct("]") {
+			item, err := p.parseValue(constant)
+			if err != nil {
+				return nil, err
+			}
+			value.List = append(value.List, item)
+		}
+		return value, p.advance()
+
+	case token.kind == tokenPunct && token.value == "{":
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		value := &Value{Kind: ObjectValue}
+		for !p.peekPunct("}") {
+			name, err := p.expectName()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectPunct(":"); err != nil {
+				return nil, err
+			}
+			fieldValue, err := p.parseValue(constant)
+			if err != nil {
+				return nil, err
+			}
+			value.Fields = append(value.Fields, &Argument{Name: name, Value: fieldValue})
+		}
+		return value, p.advance()
+
+	case token.kind == tokenInt:
+		return &Value{Kind: IntValue, Raw: token.value}, p.advance()
+	case token.kind == tokenFloat:
+		return &Value{Kind: FloatValue, Raw: token.value}, p.advance()
+	case token.kind == tokenString:
+		return &Value{Kind: StringValue, Raw: token.value}, p.advance()
+
+	case token.kind == tokenName:
+		value := &Value{Kind: EnumValue, Raw: token.value}
+		switch token.value {
+		case "true", "false":
+			value.Kind = BooleanValue
+		case "null":
+			value.Kind = NullValue
+		}
+		return value, p.advance()
+	}
+	return nil, p.unexpected()
+}
+
+// Lexer
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenPunct
+	tokenName
+	tokenInt
+	tokenFloat
+	tokenString
+)
+
+type token struct {
+	kind   tokenKind
+	value  string
+	line   int
+	column int
+}
+
+type lexer struct {
+	source    string
+	pos       int
+	line      int
+	lineStart int
+}
+
+func (l *lexer) next() (token, error) {
+	l.skipIgnored()
+	if l.pos >= len(l.source) {
+		return token{kind: tokenEOF}, nil
+	}
+
+	start := l.pos
+	t := token{line: l.line, column: start - l.lineStart + 1}
+	c := l.source[start]
+	switch {
+	case strings.HasPrefix(l.source[start:], "..."):
+		l.pos += 3
+		t.kind, t.value = tokenPunct, "..."
+	case strings.IndexByte("!$()[]{}:=@|&", c) >= 0:
+		l.pos++
+		t.kind, t.value = tokenPunct, string(c)
+	case c == '_' || isLetter(c):
+		for l.pos < len(l.source) && (l.source[l.pos] == '_' || isLetter(l.source[l.pos]) || isDigit(l.source[l.pos])) {
+			l.pos++
+		}
+		t.kind, t.value = tokenName, l.source[start:l.pos]
+	case c == '-' || isDigit(c):
+		return l.number(t)
+	case c == '"':
+		value, err := l.string()
+		if err != nil {
+			return t, err
+		}
+		t.kind, t.value = tokenString, value
+	default:
+		r, _ := utf8.DecodeRuneInString(l.source[start:])
+		return t, fmt.Errorf("syntax error: unexpected character %q at line %d, column %d", r, t.line, t.column)
+	}
+	return t, nil
+}
+
+// skipIgnored skips whitespace, commas and comments
+func (l *lexer) skipIgnored() {
+	for l.pos < len(l.source) {
+		switch c := l.source[l.pos]; c {
+		case ' ', '\t', ',', '\r':
+			l.pos++
+		case '\n':
+			l.pos++
+			l.line++
+			l.lineStart = l.pos
+		case '#':
+			for l.pos < len(l.source) && l.source[l.pos] != '\n' {
+				l.pos++
+			}
+		default:
+			if strings.HasPrefix(l.source[l.pos:], "\ufeff") {
+				l.pos += len("\ufeff")
+				continue
+			}
+			return
+		}
+	}
+}
+
+func (l *lexer) number(t token) (token, error) {
+	start := l.pos
+	if l.source[l.pos] == '-' {
+		l.pos++
+	}
+	l.digits()
+
+	t.kind = tokenInt
+	if l.pos < len(l.source) && l.source[l.pos] == '.' {
+		l.pos++
+		l.digits()
+		t.kind = tokenFloat
+	}
+	if l.pos < len(l.source) && (l.source[l.pos] == 'e' || l.source[l.pos] == 'E') {
+		l.pos++
+		if l.pos < len(l.source) && (l.source[l.pos] == '+' || l.source[l.pos] == '-') {
+			l.pos++
+		}
+		l.digits()
+		t.kind = tokenFloat
+	}
+
+	t.value = l.source[start:l.pos]
+	if _, err := strconv.ParseFloat(t.value, 64); err != nil {
+		return t, fmt.Errorf("syntax error: invalid number %q at line %d, column %d", t.value, t.line, t.column)
+	}
+	return t, nil
+}
+
+func (l *lexer) digits() {
+	for l.pos < len(l.source) && isDigit(l.source[l.pos]) {
+		l.pos++
+	}
+}
+
+// string reads a quoted or block string. Block strings are returned with
+// their common indentation removed.
+func (l *lexer) string() (string, error) {
+	line, column := l.line, l.pos-l.lineStart+1
+	unterminated := fmt.Errorf("syntax error: unterminated string at line %d, column %d", line, column)
+
+	if strings.HasPrefix(l.source[l.pos:], `"""`) {
+		start := l.pos + 3
+		end := -1
+		for i := start; end < 0; {
+			j := strings.Index(l.source[i:], `"""`)
+			if j < 0 {
+				return "", unterminated
+			}
+			if l.source[i+j-1] == '\\' {
+				i += j + 3
+				continue
+			}
+			end = i + j - start
+		}
+		raw := l.source[start : start+end]
+		l.pos = start + end + 3
+		l.line += strings.Count(raw, "\n")
+		if i := strings.LastIndexByte(raw, '\n'); i >= 0 {
+			l.lineStart = start + i + 1
+		}
+		return blockStringValue(raw), nil
+	}
+
+	var b strings.Builder
+	l.pos++
+	for l.pos < len(l.source) {
+		c := l.source[l.pos]
+		switch {
+		case c == '"':
+			l.pos++
+			return b.String(), nil
+		case c == '\n':
+			return "", unterminated
+		case c == '\\' && l.pos+1 < len(l.source):
+			escape := l.source[l.pos+1]
+			l.pos += 2
+			switch escape {
+			case '"', '\\', '/':
+				b.WriteByte(escape)
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'u':
+				if l.pos+4 > len(l.source) {
+					return "", unterminated
+				}
+				code, err := strconv.ParseUint(l.source[l.pos:l.pos+4], 16, 32)
+				if err != nil {
+					return "", fmt.Errorf("syntax error: invalid escape at line %d", l.line)
+				}
+				b.WriteRune(rune(code))
+				l.pos += 4
+			default:
+				return "", fmt.Errorf("syntax error: invalid escape at line %d", l.line)
+			}
+		default:
+			b.WriteByte(c)
+			l.pos++
+		}
+	}
+	return "", unterminated
+}
+
+func blockStringValue(raw string) string {
+	lines := strings.Split(strings.ReplaceAll(raw, `\"""`, `"""`), "\n")
+
+	indent := -1
+	for _, line := range lines[1:] {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed == "" {
+			continue
+		}
+		if n := len(line) - len(trimmed); indent < 0 || n < indent {
+			indent = n
+		}
+	}
+	if indent > 0 {
+		for i := 1; i < len(lines); i++ {
+			if len(lines[i]) >= indent {
+				lines[i] = lines[i][indent:]
+			} else {
+				lines[i] = ""
+			}
+		}
+	}
+
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
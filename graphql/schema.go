@@ -0,0 +1,342 @@
+package graphql
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Type is a *Scalar, *Object, *List or *NonNull
+type Type interface {
+	String() string
+}
+
+// Scalar is a leaf type. Serialize turns a resolved value into its JSON
+// form and ParseValue coerces an input value, returning an error when it
+// is not valid for the type.
+type Scalar struct {
+	Name        string
+	Description string
+	Serialize   func(value interface{}) interface{}
+	ParseValue  func(value interface{}) (interface{}, error)
+}
+
+func (s *Scalar) String() string { return s.Name }
+
+type Object struct {
+	Name        string
+	Description string
+	Fields      []*FieldDefinition
+}
+
+func (o *Object) String() string { return o.Name }
+
+func (o *Object) field(name string) *FieldDefinition {
+	for _, field := range o.Fields {
+		if field.Name == name {
+			return field
+		}
+	}
+	return nil
+}
+
+type List struct {
+	OfType Type
+}
+
+func (l *List) String() string { return "[" + l.OfType.String() + "]" }
+
+type NonNull struct {
+	OfType Type
+}
+
+func (n *NonNull) String() string { return n.OfType.String() + "!" }
+
+// ResolveFunc resolves a field for one parent value
+type ResolveFunc func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error)
+
+// BatchResolveFunc resolves a field for every parent value at the same
+// level of the result at once, returning one value per source. It is how
+// fields that load related records avoid a query per parent.
+type BatchResolveFunc func(ctx context.Context, sources []interface{}, args map[string]interface{}) ([]interface{}, error)
+
+// FieldDefinition is a field of an object type. Exactly one of Resolve
+// and BatchResolve is set.
+type FieldDefinition struct {
+	Name         string
+	Description  string
+	Type         Type
+	Args         []*ArgumentDefinition
+	Resolve      ResolveFunc
+	BatchResolve BatchResolveFunc
+}
+
+type ArgumentDefinition struct {
+	Name         string
+	Description  string
+	Type         Type
+	DefaultValue interface{}
+}
+
+// Schema is an executable schema with a query root
+type Schema struct {
+	Query *Object
+	types map[string]Type
+}
+
+// NewSchema checks a schema and collects the types reachable from query
+func NewSchema(query *Object) (*Schema, error) {
+	s := &Schema{Query: query, types: map[string]Type{}}
+	for _, scalar := range []*Scalar{String, Int, Float, Boolean, ID} {
+		s.types[scalar.Name] = scalar
+	}
+	if err := s.collect(query); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Schema) collect(t Type) error {
+	switch t := t.(type) {
+	case *NonNull:
+		return s.collect(t.OfType)
+	case *List:
+		return s.collect(t.OfType)
+	case *Scalar:
+		if existing, ok := s.types[t.Name]; ok && existing != t {
+			return fmt.Errorf("type %s is defined more than once", t.Name)
+		}
+		s.types[t.Name] = t
+	case *Object:
+		if existing, ok := s.types[t.Name]; ok {
+			if existing != t {
+				return fmt.Errorf("type %s is defined more than once", t.Name)
+			}
+			return nil
+		}
+		s.types[t.Name] = t
+		for _, field := range t.Fields {
+			if (field.Resolve == nil) == (field.BatchResolve == nil) {
+				return fmt.Errorf("field %s.%s needs exactly one resolver", t.Name, field.Name)
+			}
+			if err := s.collect(field.Type); err != nil {
+				return err
+			}
+			for _, arg := range field.Args {
+				if err := s.collect(arg.Type); err != nil {
+					return err
+				}
+				if _, ok := namedType(arg.Type).(*Scalar); !ok {
+					return fmt.Errorf("argument %s.%s(%s) must be a scalar or list of scalars", t.Name, field.Name, arg.Name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// SDL returns the schema in the GraphQL schema definition language
+func (s *Schema) SDL() string {
+	names := make([]string, 0, len(s.types))
+	for name := range s.types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString("schema {\n  query: " + s.Query.Name + "\n}\n")
+	for _, name := range names {
+		switch t := s.types[name].(type) {
+		case *Scalar:
+			if isBuiltinScalar(t) {
+				continue
+			}
+			b.WriteString("\n")
+			writeDescription(&b, "", t.Description)
+			b.WriteString("scalar " + t.Name + "\n")
+		case *Object:
+			b.WriteString("\n")
+			writeDescription(&b, "", t.Description)
+			b.WriteString("type " + t.Name + " {\n")
+			for _, field := range t.Fields {
+				writeDescription(&b, "  ", field.Description)
+				b.WriteString("  " + field.Name)
+				if len(field.Args) > 0 {
+					args := make([]string, 0, len(field.Args))
+					for _, arg := range field.Args {
+						definition := arg.Name + ": " + arg.Type.String()
+						if arg.DefaultValue != nil {
+							definition += " = " + formatDefault(arg.DefaultValue)
+						}
+						args = append(args, definition)
+					}
+					b.WriteString("(" + strings.Join(args, ", ") + ")")
+				}
+				b.WriteString(": " + field.Type.String() + "\n")
+			}
+			b.WriteString("}\n")
+		}
+	}
+	return b.String()
+}
+
+func writeDescription(b *strings.Builder, indent, description string) {
+	if description != "" {
+		b.WriteString(indent + strconv.Quote(description) + "\n")
+	}
+}
+
+func formatDefault(value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		return strconv.Quote(value)
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+func namedType(t Type) Type {
+	for {
+		switch wrapped := t.(type) {
+		case *NonNull:
+			t = wrapped.OfType
+		case *List:
+			t = wrapped.OfType
+		default:
+			return t
+		}
+	}
+}
+
+func isBuiltinScalar(s *Scalar) bool {
+	return s == String || s == Int || s == Float || s == Boolean || s == ID
+}
+
+// Built-in scalars
+
+var String = &Scalar{
+	Name: "String",
+	Serialize: func(value interface{}) interface{} {
+		if s, ok := value.(fmt.Stringer); ok {
+			return s.String()
+		}
+		return fmt.Sprint(value)
+	},
+	ParseValue: func(value interface{}) (interface{}, error) {
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+		return nil, fmt.Errorf("expected a string")
+	},
+}
+
+var ID = &Scalar{
+	Name:      "ID",
+	Serialize: String.Serialize,
+	ParseValue: func(value interface{}) (interface{}, error) {
+		switch value := value.(type) {
+		case string:
+			return value, nil
+		case int:
+			return strconv.Itoa(value), nil
+		}
+		return nil, fmt.Errorf("expected an ID")
+	},
+}
+
+var Int = &Scalar{
+	Name: "Int",
+	Serialize: func(value interface{}) interface{} {
+		return value
+	},
+	ParseValue: func(value interface{}) (interface{}, error) {
+		switch value := value.(type) {
+		case int:
+			return value, nil
+		case float64:
+			if value == float64(int32(value)) {
+				return int(value), nil
+			}
+		}
+		return nil, fmt.Errorf("expected a 32-bit integer")
+	},
+}
+
+var Float = &Scalar{
+	Name: "Float",
+	Serialize: func(value interface{}) interface{} {
+		return value
+	},
+	ParseValue: func(value interface{}) (interface{}, error) {
+		switch value := value.(type) {
+		case int:
+			return float64(value), nil
+		case float64:
+			return value, nil
+		}
+		return nil, fmt.Errorf("expected a number")
+	},
+}
+
+var Boolean = &Scalar{
+	Name: "Boolean",
+	Serialize: func(value interface{}) interface{} {
+		return value
+	},
+	ParseValue: func(value interface{}) (interface{}, error) {
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+		return nil, fmt.Errorf("expected a boolean")
+	},
+}
+
+// Time is an RFC 3339 timestamp. It serializes time.Time and *time.Time.
+var Time = &Scalar{
+	Name:        "Time",
+	Description: "An RFC 3339 timestamp",
+	Serialize: func(value interface{}) interface{} {
+		switch value := value.(type) {
+		case time.Time:
+			return value.UTC().Format(time.RFC3339)
+		case *time.Time:
+			if value == nil {
+				return nil
+			}
+			return value.UTC().Format(time.RFC3339)
+		}
+		return nil
+	},
+	ParseValue: func(value interface{}) (interface{}, error) {
+		if s, ok := value.(string); ok {
+			if t, err := time.Parse(time.RFC3339, s); err == nil {
+				return t, nil
+			}
+		}
+		return nil, fmt.Errorf("expected an RFC 3339 timestamp")
+	},
+}
+
+// Long is a 64-bit integer, used for sizes in bytes which do not fit Int.
+// It is serialized as a JSON number.
+var Long = &Scalar{
+	Name:        "Long",
+	Description: "A 64-bit integer",
+	Serialize: func(value interface{}) interface{} {
+		return value
+	},
+	ParseValue: func(value interface{}) (interface{}, error) {
+		switch value := value.(type) {
+		case int:
+			return int64(value), nil
+		case float64:
+			if value == float64(int64(value)) {
+				return int64(value), nil
+			}
+		}
+		return nil, fmt.Errorf("expected an integer")
+	},
+}
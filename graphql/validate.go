@@ -0,0 +1,168 @@
+package graphql
+
+import "fmt"
+
+// validator checks a query against the schema before it is executed, so a
+// malformed query fails as a whole instead of partway through
+type validator struct {
+	executor  *executor
+	operation *Operation
+	errors    []*Error
+
+	// fragmentsInUse holds the fragments being spread into the current
+	// selection, to catch fragments which spread themselves
+	fragmentsInUse map[string]bool
+}
+
+func (v *validator) validateSelections(t *Object, selections []Selection, depth int) {
+	if depth > maxQueryDepth {
+		v.errorf(nil, "query is nested deeper than %d levels", maxQueryDepth)
+		return
+	}
+
+	for _, selection := range selections {
+		switch selection := selection.(type) {
+		case *Field:
+			v.validateDirectives(selection, selection.Directives)
+			v.validateField(t, selection, depth)
+
+		case *FragmentSpread:
+			v.validateDirectives(nil, selection.Directives)
+			fragment, ok := v.executor.doc.Fragments[selection.Name]
+			if !ok {
+				v.errorf(nil, "unknown fragment %q", selection.Name)
+				continue
+			}
+			if v.fragmentsInUse[selection.Name] {
+				v.errorf(nil, "fragment %q spreads itself", selection.Name)
+				continue
+			}
+			if !v.validTypeCondition(t, fragment.TypeCondition) {
+				continue
+			}
+			v.fragmentsInUse[selection.Name] = true
+			v.validateSelections(t, fragment.SelectionSet, depth)
+			delete(v.fragmentsInUse, selection.Name)
+
+		case *InlineFragment:
+			v.validateDirectives(nil, selection.Directives)
+			if selection.TypeCondition != "" && !v.validTypeCondition(t, selection.TypeCondition) {
+				continue
+			}
+			v.validateSelections(t, selection.SelectionSet, depth)
+		}
+	}
+}
+
+func (v *validator) validateField(t *Object, field *Field, depth int) {
+	if field.Name == "__typename" {
+		if len(field.SelectionSet) > 0 {
+			v.errorf(field, "field %q must not have a selection", field.Name)
+		}
+		return
+	}
+
+	definition := t.field(field.Name)
+	if definition == nil {
+		v.errorf(field, "cannot query field %q on type %q", field.Name, t.Name)
+		return
+	}
+
+	for _, arg := range field.Arguments {
+		found := false
+		for _, argDefinition := range definition.Args {
+			found = found || argDefinition.Name == arg.Name
+		}
+		if !found {
+			v.errorf(field, "unknown argument %q on field %q", arg.Name, field.Name)
+		}
+		v.validateVariables(field, arg.Value)
+	}
+	for _, argDefinition := range definition.Args {
+		if _, required := argDefinition.Type.(*NonNull); !required || argDefinition.DefaultValue != nil {
+			continue
+		}
+		found := false
+		for _, arg := range field.Arguments {
+			found = found || arg.Name == argDefinition.Name
+		}
+		if !found {
+			v.errorf(field, "field %q requires argument %q", field.Name, argDefinition.Name)
+		}
+	}
+
+	switch named := namedType(definition.Type).(type) {
+	case *Scalar:
+		if len(field.SelectionSet) > 0 {
+			v.errorf(field, "field %q of type %q must not have a selection", field.Name, named.Name)
+		}
+	case *Object:
+		if len(field.SelectionSet) == 0 {
+			v.errorf(field, "field %q of type %q must have a selection of subfields", field.Name, named.Name)
+			return
+		}
+		v.validateSelections(named, field.SelectionSet, depth+1)
+	}
+}
+
+func (v *validator) validateDirectives(field *Field, directives []*Directive) {
+	for _, directive := range directives {
+		if directive.Name != "skip" && directive.Name != "include" {
+			v.errorf(field, "unknown directive @%s", directive.Name)
+			continue
+		}
+		hasCondition := false
+		for _, arg := range directive.Arguments {
+			hasCondition = hasCondition || arg.Name == "if"
+			v.validateVariables(field, arg.Value)
+		}
+		if !hasCondition {
+			v.errorf(field, "directive @%s requires argument \"if\"", directive.Name)
+		}
+	}
+}
+
+// validateVariables checks that the variables used in a value are defined
+// by the operation
+func (v *validator) validateVariables(field *Field, value *Value) {
+	switch value.Kind {
+	case VariableValue:
+		for _, definition := range v.operation.Variables {
+			if definition.Name == value.Raw {
+				return
+			}
+		}
+		v.errorf(field, "variable $%s is not defined", value.Raw)
+	case ListValue:
+		for _, item := range value.List {
+			v.validateVariables(field, item)
+		}
+	case ObjectValue:
+		for _, item := range value.Fields {
+			v.validateVariables(field, item.Value)
+		}
+	}
+}
+
+// validTypeCondition reports whether a fragment on typeCondition can be
+// spread in a selection on t. The schema has no interfaces or unions, so
+// the condition must name t itself.
+func (v *validator) validTypeCondition(t *Object, typeCondition string) bool {
+	if _, ok := v.executor.schema.types[typeCondition].(*Object); !ok {
+		v.errorf(nil, "unknown type %q", typeCondition)
+		return false
+	}
+	if typeCondition != t.Name {
+		v.errorf(nil, "fragment on %q cannot be spread within %q", typeCondition, t.Name)
+		return false
+	}
+	return true
+}
+
+func (v *validator) errorf(field *Field, format string, args ...interface{}) {
+	err := &Error{Message: fmt.Sprintf(format, args...)}
+	if field != nil {
+		err.Locations = []Location{{Line: field.Line, Column: field.Column}}
+	}
+	v.errors = append(v.errors, err)
+}
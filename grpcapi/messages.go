@@ -0,0 +1,368 @@
+package grpcapi
+
+import (
+	"oncloud/models"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Messages of sync.proto. Requests only need decoding and responses only
+// encoding, so each type implements one direction. Field numbers must
+// match sync.proto.
+
+type requestMessage interface {
+	unmarshal(b []byte) error
+}
+
+type responseMessage interface {
+	marshal() []byte
+}
+
+type FileInfo struct {
+	ID        string
+	FolderID  string
+	Name      string
+	Size      int64
+	MimeType  string
+	MD5       string
+	IsDeleted bool
+	CreatedAt int64
+	UpdatedAt int64
+}
+
+func newFileInfo(file *models.File) *FileInfo {
+	info := &FileInfo{
+		ID:        file.ID.Hex(),
+		Name:      file.OriginalName,
+		Size:      file.Size,
+		MimeType:  file.MimeType,
+		MD5:       file.Hash,
+		IsDeleted: file.IsDeleted,
+		CreatedAt: file.CreatedAt.UnixMilli(),
+		UpdatedAt: file.UpdatedAt.UnixMilli(),
+	}
+	if file.DisplayName != "" {
+		info.Name = file.DisplayName
+	}
+	if file.FolderID != nil {
+		info.FolderID = file.FolderID.Hex()
+	}
+	return info
+}
+
+func (m *FileInfo) marshal() []byte {
+	var b []byte
+	b = appendString(b, 1, m.ID)
+	b = appendString(b, 2, m.FolderID)
+	b = appendString(b, 3, m.Name)
+	b = appendVarint(b, 4, uint64(m.Size))
+	b = appendString(b, 5, m.MimeType)
+	b = appendString(b, 6, m.MD5)
+	b = appendBool(b, 7, m.IsDeleted)
+	b = appendVarint(b, 8, uint64(m.CreatedAt))
+	b = appendVarint(b, 9, uint64(m.UpdatedAt))
+	return b
+}
+
+type FolderInfo struct {
+	ID        string
+	ParentID  string
+	Name      string
+	Path      string
+	IsDeleted bool
+	CreatedAt int64
+	UpdatedAt int64
+}
+
+func newFolderInfo(folder *models.Folder) *FolderInfo {
+	info := &FolderInfo{
+		ID:        folder.ID.Hex(),
+		Name:      folder.Name,
+		Path:      folder.Path,
+		IsDeleted: folder.IsDeleted,
+		CreatedAt: folder.CreatedAt.UnixMilli(),
+		UpdatedAt: folder.UpdatedAt.UnixMilli(),
+	}
+	if folder.ParentID != nil {
+		info.ParentID = folder.ParentID.Hex()
+	}
+	return info
+}
+
+func (m *FolderInfo) marshal() []byte {
+	var b []byte
+	b = appendString(b, 1, m.ID)
+	b = appendString(b, 2, m.ParentID)
+	b = appendString(b, 3, m.Name)
+	b = appendString(b, 4, m.Path)
+	b = appendBool(b, 5, m.IsDeleted)
+	b = appendVarint(b, 6, uint64(m.CreatedAt))
+	b = appendVarint(b, 7, uint64(m.UpdatedAt))
+	return b
+}
+
+type GetFileRequest struct {
+	ID string
+}
+
+func (m *GetFileRequest) unmarshal(b []byte) error {
+	return walkFields(b, func(num protowire.Number, _ uint64, value []byte) {
+		if num == 1 {
+			m.ID = string(value)
+		}
+	})
+}
+
+type ListFolderRequest struct {
+	FolderID string
+	Page     int32
+	Limit    int32
+}
+
+func (m *ListFolderRequest) unmarshal(b []byte) error {
+	return walkFields(b, func(num protowire.Number, varint uint64, value []byte) {
+		switch num {
+		case 1:
+			m.FolderID = string(value)
+		case 2:
+			m.Page = int32(varint)
+		case 3:
+			m.Limit = int32(varint)
+		}
+	})
+}
+
+type ListFolderResponse struct {
+	Folders      []*FolderInfo
+	Files        []*FileInfo
+	TotalFolders int32
+	TotalFiles   int32
+}
+
+func (m *ListFolderResponse) marshal() []byte {
+	var b []byte
+	for _, folder := range m.Folders {
+		b = appendMessage(b, 1, folder)
+	}
+	for _, file := range m.Files {
+		b = appendMessage(b, 2, file)
+	}
+	b = appendVarint(b, 3, uint64(m.TotalFolders))
+	b = appendVarint(b, 4, uint64(m.TotalFiles))
+	return b
+}
+
+// Change holds either a File or a Folder
+type Change struct {
+	File   *FileInfo
+	Folder *FolderInfo
+}
+
+func (m *Change) marshal() []byte {
+	if m.File != nil {
+		return appendMessage(nil, 1, m.File)
+	}
+	return appendMessage(nil, 2, m.Folder)
+}
+
+type GetChangesRequest struct {
+	Cursor string
+	Limit  int32
+}
+
+func (m *GetChangesRequest) unmarshal(b []byte) error {
+	return walkFields(b, func(num protowire.Number, varint uint64, value []byte) {
+		switch num {
+		case 1:
+			m.Cursor = string(value)
+		case 2:
+			m.Limit = int32(varint)
+		}
+	})
+}
+
+type GetChangesResponse struct {
+	Changes []*Change
+	Cursor  string
+	HasMore bool
+}
+
+func newGetChangesResponse(changes *models.SyncChanges) *GetChangesResponse {
+	response := &GetChangesResponse{
+		Changes: make([]*Change, 0, len(changes.Changes)),
+		Cursor:  changes.Cursor,
+		HasMore: changes.HasMore,
+	}
+	for _, change := range changes.Changes {
+		if change.File != nil {
+			response.Changes = append(response.Changes, &Change{File: newFileInfo(change.File)})
+		} else {
+			response.Changes = append(response.Changes, &Change{Folder: newFolderInfo(change.Folder)})
+		}
+	}
+	return response
+}
+
+func (m *GetChangesResponse) marshal() []byte {
+	var b []byte
+	for _, change := range m.Changes {
+		b = appendMessage(b, 1, change)
+	}
+	b = appendString(b, 2, m.Cursor)
+	b = appendBool(b, 3, m.HasMore)
+	return b
+}
+
+type WatchChangesRequest struct {
+	Cursor string
+}
+
+func (m *WatchChangesRequest) unmarshal(b []byte) error {
+	return walkFields(b, func(num protowire.Number, _ uint64, value []byte) {
+		if num == 1 {
+			m.Cursor = string(value)
+		}
+	})
+}
+
+type UploadHeader struct {
+	Name     string
+	FolderID string
+	Size     int64
+}
+
+func (m *UploadHeader) unmarshal(b []byte) error {
+	return walkFields(b, func(num protowire.Number, varint uint64, value []byte) {
+		switch num {
+		case 1:
+			m.Name = string(value)
+		case 2:
+			m.FolderID = string(value)
+		case 3:
+			m.Size = int64(varint)
+		}
+	})
+}
+
+// UploadRequest holds either a Header or a Chunk. Chunk points into the
+// received message and is only valid until the next one.
+type UploadRequest struct {
+	Header *UploadHeader
+	Chunk  []byte
+}
+
+func (m *UploadRequest) unmarshal(b []byte) error {
+	var header []byte
+	err := walkFields(b, func(num protowire.Number, _ uint64, value []byte) {
+		switch num {
+		case 1:
+			header = value
+		case 2:
+			m.Chunk = value
+		}
+	})
+	if err != nil || header == nil {
+		return err
+	}
+	m.Header = &UploadHeader{}
+	return m.Header.unmarshal(header)
+}
+
+type DownloadRequest struct {
+	ID     string
+	Offset int64
+}
+
+func (m *DownloadRequest) unmarshal(b []byte) error {
+	return walkFields(b, func(num protowire.Number, varint uint64, value []byte) {
+		switch num {
+		case 1:
+			m.ID = string(value)
+		case 2:
+			m.Offset = int64(varint)
+		}
+	})
+}
+
+type DownloadResponse struct {
+	File  *FileInfo
+	Chunk []byte
+}
+
+func (m *DownloadResponse) marshal() []byte {
+	var b []byte
+	if m.File != nil {
+		b = appendMessage(b, 1, m.File)
+	}
+	if len(m.Chunk) > 0 {
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, m.Chunk)
+	}
+	return b
+}
+
+// Encoding. Scalar fields with their zero value are left out, as proto3
+// does; message fields are always written so an empty oneof member is
+// still set.
+
+func appendString(b []byte, num protowire.Number, value string) []byte {
+	if value == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, value)
+}
+
+func appendVarint(b []byte, num protowire.Number, value uint64) []byte {
+	if value == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, value)
+}
+
+func appendBool(b []byte, num protowire.Number, value bool) []byte {
+	if !value {
+		return b
+	}
+	return appendVarint(b, num, 1)
+}
+
+func appendMessage(b []byte, num protowire.Number, message responseMessage) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, message.marshal())
+}
+
+// walkFields calls fn with each varint and length-delimited field of an
+// encoded message. Fields of other wire types are skipped.
+func walkFields(b []byte, fn func(num protowire.Number, varint uint64, value []byte)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		switch typ {
+		case protowire.VarintType:
+			var varint uint64
+			varint, n = protowire.ConsumeVarint(b)
+			if n >= 0 {
+				fn(num, varint, nil)
+			}
+		case protowire.BytesType:
+			var value []byte
+			value, n = protowire.ConsumeBytes(b)
+			if n >= 0 {
+				fn(num, 0, value)
+			}
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return nil
+}
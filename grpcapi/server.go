@@ -0,0 +1,181 @@
+// Package grpcapi serves the gRPC API used by the desktop sync client,
+// defined in sync.proto. It runs on its own port next to the REST API and
+// calls the same services.
+//
+// The protocol is implemented directly on net/http's HTTP/2 server and
+// protowire, so the package does not pull in grpc-go and generated code;
+// any standard gRPC client can call it.
+package grpcapi
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"oncloud/models"
+	"oncloud/services"
+	"oncloud/utils"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+const serviceName = "oncloud.sync.v1.SyncService"
+
+// Options configures the gRPC server. Without a certificate it serves
+// HTTP/2 in cleartext (h2c), for running behind a TLS terminating proxy.
+type Options struct {
+	Address     string
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// Dependencies are what the sync services are built on
+	Dependencies services.Dependencies
+}
+
+type handler func(s *stream, user *models.User) error
+
+// Server is the gRPC server
+type Server struct {
+	options    Options
+	httpServer *http.Server
+	methods    map[string]handler
+
+	// cancel ends the context of every call, so open change watches stop
+	// on shutdown
+	cancel context.CancelFunc
+}
+
+func NewServer(opts Options) *Server {
+	syncServer := newSyncServer(opts.Dependencies)
+	s := &Server{
+		options: opts,
+		methods: map[string]handler{
+			"GetFile":      syncServer.GetFile,
+			"ListFolder":   syncServer.ListFolder,
+			"GetChanges":   syncServer.GetChanges,
+			"WatchChanges": syncServer.WatchChanges,
+			"Upload":       syncServer.Upload,
+			"Download":     syncServer.Download,
+		},
+	}
+
+	baseCtx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+
+	var h http.Handler = s
+	if opts.TLSCertFile == "" {
+		h = h2c.NewHandler(s, &http2.Server{})
+	}
+	// No read or write timeouts: calls stream for as long as they need,
+	// bounded by the client's deadline
+	s.httpServer = &http.Server{
+		Addr:              opts.Address,
+		Handler:           h,
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       5 * time.Minute,
+		TLSConfig:         &tls.Config{MinVersion: tls.VersionTLS12, NextProtos: []string{"h2"}},
+		BaseContext:       func(net.Listener) context.Context { return baseCtx },
+	}
+	return s
+}
+
+// ListenAndServe serves until Shutdown is called
+func (s *Server) ListenAndServe() error {
+	if s.options.TLSCertFile != "" {
+		return s.httpServer.ListenAndServeTLS(s.options.TLSCertFile, s.options.TLSKeyFile)
+	}
+	return s.httpServer.ListenAndServe()
+}
+
+// Shutdown stops accepting calls, ends open change watches and waits for
+// the other running calls until ctx is done
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.cancel()
+	return s.httpServer.Shutdown(ctx)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ProtoMajor != 2 || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "gRPC requests only", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	ctx := r.Context()
+	if timeout, ok := parseGrpcTimeout(r.Header.Get("Grpc-Timeout")); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	s.serve(&stream{ctx: ctx, w: w, body: r.Body}, r)
+}
+
+func (s *Server) serve(st *stream, r *http.Request) {
+	service, method, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	h, ok := s.methods[method]
+	if service != serviceName || !ok {
+		st.finish(statusErrorf(CodeUnimplemented, "unknown method %s", r.URL.Path))
+		return
+	}
+
+	user, err := authenticate(r)
+	if err != nil {
+		st.finish(err)
+		return
+	}
+
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			log.Printf("gRPC %s panicked: %v", method, recovered)
+			st.finish(statusErrorf(CodeInternal, "internal error"))
+		}
+	}()
+	st.finish(h(st, user))
+}
+
+// authenticate checks the bearer token in the authorization metadata the
+// way the REST API's AuthMiddleware does
+func authenticate(r *http.Request) (*models.User, error) {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found || token == "" {
+		return nil, statusErrorf(CodeUnauthenticated, "authorization metadata required")
+	}
+
+	claims, err := utils.ValidateToken(token)
+	if err != nil {
+		return nil, statusErrorf(CodeUnauthenticated, "invalid or expired token")
+	}
+	user, err := services.NewUserService().GetByID(r.Context(), claims.UserID)
+	if err != nil {
+		return nil, statusErrorf(CodeUnauthenticated, "user not found")
+	}
+	if !user.IsActive {
+		return nil, statusErrorf(CodeUnauthenticated, "account is deactivated")
+	}
+	if _, err := services.NewSessionService().ValidateToken(r.Context(), claims, user.ID); err != nil {
+		if errors.Is(err, services.ErrTokenRevoked) {
+			return nil, statusErrorf(CodeUnauthenticated, "token has been revoked")
+		}
+		return nil, statusErrorf(CodeUnauthenticated, "session has ended, please sign in again")
+	}
+
+	// Forwarding headers are not trusted on the gRPC port, so policies are
+	// checked against the peer address
+	clientIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		clientIP = r.RemoteAddr
+	}
+	policies := services.NewAccessPolicyService()
+	if decision := policies.CheckGlobalAccess(r.Context(), clientIP); !decision.Allowed {
+		return nil, statusErrorf(CodePermissionDenied, "access from this address is not allowed")
+	}
+	if decision := policies.CheckUserAccess(r.Context(), user.ID, clientIP, false); !decision.Allowed {
+		return nil, statusErrorf(CodePermissionDenied, "access from this address is not allowed")
+	}
+
+	return user, nil
+}
@@ -0,0 +1,276 @@
+package grpcapi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"oncloud/models"
+	"oncloud/services"
+	"oncloud/utils"
+	"time"
+)
+
+const (
+	// Files are downloaded in messages of this size, well under
+	// maxMessageSize
+	downloadChunkSize = 1 << 20
+
+	// How often WatchChanges looks for new changes
+	watchChangesInterval = 5 * time.Second
+)
+
+type syncServer struct {
+	fileService   *services.FileService
+	folderService *services.FolderService
+	syncService   *services.SyncService
+}
+
+func newSyncServer(deps services.Dependencies) *syncServer {
+	return &syncServer{
+		fileService:   services.NewFileServiceWith(deps),
+		folderService: services.NewFolderService(),
+		syncService:   services.NewSyncServiceWith(deps),
+	}
+}
+
+func (ss *syncServer) GetFile(s *stream, user *models.User) error {
+	var req GetFileRequest
+	if err := s.Recv(&req); err != nil {
+		return err
+	}
+
+	fileID, err := utils.StringToObjectID(req.ID)
+	if err != nil {
+		return statusErrorf(CodeInvalidArgument, "invalid file ID")
+	}
+	file, err := ss.fileService.GetUserFile(s.ctx, user.ID, fileID)
+	if err != nil {
+		return serviceStatusError(err, "failed to get file")
+	}
+
+	return s.Send(newFileInfo(file))
+}
+
+func (ss *syncServer) ListFolder(s *stream, user *models.User) error {
+	var req ListFolderRequest
+	if err := s.Recv(&req); err != nil {
+		return err
+	}
+
+	page, limit := int(req.Page), int(req.Limit)
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > services.MaxSyncChangesLimit {
+		limit = services.DefaultSyncChangesLimit
+	}
+
+	folderID := "root"
+	if req.FolderID != "" {
+		id, err := utils.StringToObjectID(req.FolderID)
+		if err != nil {
+			return statusErrorf(CodeInvalidArgument, "invalid folder ID")
+		}
+		if _, err := ss.folderService.GetUserFolder(s.ctx, user.ID, id); err != nil {
+			return serviceStatusError(err, "failed to get folder")
+		}
+		folderID = req.FolderID
+	}
+
+	folders, totalFolders, err := ss.folderService.GetUserFolders(s.ctx, user.ID, folderID, "", page, limit)
+	if err != nil {
+		return statusErrorf(CodeInternal, "failed to list folders")
+	}
+	files, totalFiles, err := ss.fileService.GetUserFiles(s.ctx, user.ID, page, limit, &services.FileFilters{
+		FolderID:  folderID,
+		SortBy:    "name",
+		SortOrder: "asc",
+	})
+	if err != nil {
+		return statusErrorf(CodeInternal, "failed to list files")
+	}
+
+	response := &ListFolderResponse{
+		TotalFolders: int32(totalFolders),
+		TotalFiles:   int32(totalFiles),
+	}
+	for i := range folders {
+		response.Folders = append(response.Folders, newFolderInfo(&folders[i]))
+	}
+	for i := range files {
+		response.Files = append(response.Files, newFileInfo(&files[i]))
+	}
+	return s.Send(response)
+}
+
+func (ss *syncServer) GetChanges(s *stream, user *models.User) error {
+	var req GetChangesRequest
+	if err := s.Recv(&req); err != nil {
+		return err
+	}
+
+	changes, err := ss.getChanges(s.ctx, user, req.Cursor, int(req.Limit))
+	if err != nil {
+		return err
+	}
+	return s.Send(newGetChangesResponse(changes))
+}
+
+// WatchChanges sends every page of changes after the cursor, then polls
+// for more until the client goes away
+func (ss *syncServer) WatchChanges(s *stream, user *models.User) error {
+	var req WatchChangesRequest
+	if err := s.Recv(&req); err != nil {
+		return err
+	}
+
+	cursor := req.Cursor
+	ticker := time.NewTicker(watchChangesInterval)
+	defer ticker.Stop()
+
+	for {
+		changes, err := ss.getChanges(s.ctx, user, cursor, 0)
+		if err != nil {
+			return err
+		}
+		if len(changes.Changes) > 0 {
+			if err := s.Send(newGetChangesResponse(changes)); err != nil {
+				return err
+			}
+			cursor = changes.Cursor
+			if changes.HasMore {
+				continue
+			}
+		}
+
+		select {
+		case <-s.ctx.Done():
+			return s.ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (ss *syncServer) getChanges(ctx context.Context, user *models.User, cursor string, limit int) (*models.SyncChanges, error) {
+	changes, err := ss.syncService.GetChanges(ctx, user.ID, cursor, limit)
+	if errors.Is(err, services.ErrInvalidSyncCursor) {
+		return nil, statusErrorf(CodeInvalidArgument, "invalid cursor")
+	}
+	if err != nil {
+		return nil, statusErrorf(CodeInternal, "failed to get changes")
+	}
+	return changes, nil
+}
+
+// Upload receives a file's header and then its content. The declared size
+// is checked against the user's plan before any content is accepted.
+func (ss *syncServer) Upload(s *stream, user *models.User) error {
+	var first UploadRequest
+	if err := s.Recv(&first); err != nil {
+		if err == io.EOF {
+			return statusErrorf(CodeInvalidArgument, "upload header required")
+		}
+		return err
+	}
+	header := first.Header
+	if header == nil || header.Name == "" || header.Size < 0 {
+		return statusErrorf(CodeInvalidArgument, "upload header with a file name required")
+	}
+	if header.FolderID != "" && !utils.IsValidObjectID(header.FolderID) {
+		return statusErrorf(CodeInvalidArgument, "invalid folder ID")
+	}
+
+	plan, err := ss.fileService.GetUserPlan(s.ctx, user.ID)
+	if err != nil {
+		return statusErrorf(CodeInternal, "failed to get plan")
+	}
+	if err := ss.fileService.CheckUploadLimits(user, plan, header.Size); err != nil {
+		return serviceStatusError(err, "failed to check upload limits")
+	}
+
+	var content bytes.Buffer
+	content.Grow(int(header.Size))
+	for {
+		var req UploadRequest
+		err := s.Recv(&req)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if int64(content.Len()+len(req.Chunk)) > header.Size {
+			return statusErrorf(CodeInvalidArgument, "content is larger than the declared size")
+		}
+		content.Write(req.Chunk)
+	}
+	if int64(content.Len()) != header.Size {
+		return statusErrorf(CodeInvalidArgument, "received %d of %d bytes", content.Len(), header.Size)
+	}
+
+	file, err := ss.fileService.UploadContent(s.ctx, user.ID, header.Name, content.Bytes(), &models.FileUploadRequest{
+		FolderID: header.FolderID,
+		Source:   models.UploadSourceSync,
+	})
+	if err != nil {
+		return serviceStatusError(err, "failed to upload file: "+err.Error())
+	}
+	return s.Send(newFileInfo(file))
+}
+
+// Download sends a file's content from the requested offset, so clients
+// can resume interrupted transfers
+func (ss *syncServer) Download(s *stream, user *models.User) error {
+	var req DownloadRequest
+	if err := s.Recv(&req); err != nil {
+		return err
+	}
+
+	fileID, err := utils.StringToObjectID(req.ID)
+	if err != nil {
+		return statusErrorf(CodeInvalidArgument, "invalid file ID")
+	}
+	file, content, err := ss.syncService.ReadFile(s.ctx, user.ID, fileID)
+	if err != nil {
+		if file == nil {
+			return statusErrorf(CodeNotFound, "file not found")
+		}
+		return statusErrorf(CodeInternal, "failed to read file")
+	}
+	if req.Offset < 0 || req.Offset > int64(len(content)) {
+		return statusErrorf(CodeInvalidArgument, "offset is outside the file")
+	}
+
+	response := &DownloadResponse{File: newFileInfo(file)}
+	content = content[req.Offset:]
+	for {
+		n := min(len(content), downloadChunkSize)
+		response.Chunk = content[:n]
+		if err := s.Send(response); err != nil {
+			return err
+		}
+		content = content[n:]
+		response.File = nil
+		if len(content) == 0 {
+			return nil
+		}
+	}
+}
+
+// serviceStatusError returns the status of a service error by its kind.
+// Errors of no known kind are internal, with message.
+func serviceStatusError(err error, message string) error {
+	switch {
+	case errors.Is(err, services.ErrNotFound):
+		return statusErrorf(CodeNotFound, "%s", err.Error())
+	case errors.Is(err, services.ErrForbidden):
+		return statusErrorf(CodePermissionDenied, "%s", err.Error())
+	case errors.Is(err, services.ErrQuotaExceeded):
+		return statusErrorf(CodeResourceExhausted, "%s", err.Error())
+	case errors.Is(err, services.ErrProviderUnavailable), errors.Is(err, services.ErrProviderTimeout):
+		return statusErrorf(CodeUnavailable, "storage is temporarily unavailable")
+	default:
+		return statusErrorf(CodeInternal, "%s", message)
+	}
+}
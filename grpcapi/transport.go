@@ -0,0 +1,192 @@
+package grpcapi
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// gRPC over HTTP/2 as described in
+// https://github.com/grpc/grpc/blob/master/doc/PROTOCOL-HTTP2.md, limited
+// to what the sync API needs: uncompressed protobuf messages and unary or
+// streaming calls.
+
+// Messages larger than this are refused, as gRPC does by default
+const maxMessageSize = 4 << 20
+
+// Code is a gRPC status code
+type Code uint32
+
+const (
+	CodeOK                Code = 0
+	CodeCanceled          Code = 1
+	CodeInvalidArgument   Code = 3
+	CodeDeadlineExceeded  Code = 4
+	CodeNotFound          Code = 5
+	CodeAlreadyExists     Code = 6
+	CodePermissionDenied  Code = 7
+	CodeResourceExhausted Code = 8
+	CodeUnimplemented     Code = 12
+	CodeInternal          Code = 13
+	CodeUnavailable       Code = 14
+	CodeUnauthenticated   Code = 16
+)
+
+// statusError ends a call with a status other than OK
+type statusError struct {
+	code    Code
+	message string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("rpc error: code = %d desc = %s", e.code, e.message)
+}
+
+func statusErrorf(code Code, format string, args ...interface{}) error {
+	return &statusError{code: code, message: fmt.Sprintf(format, args...)}
+}
+
+// stream is one call. Requests are read with Recv and responses written
+// with Send; unary calls do each once.
+type stream struct {
+	ctx        context.Context
+	w          http.ResponseWriter
+	body       io.Reader
+	headerSent bool
+}
+
+// Recv reads the next request message, returning io.EOF once the client
+// has sent them all
+func (s *stream) Recv(message requestMessage) error {
+	var prefix [5]byte
+	if _, err := io.ReadFull(s.body, prefix[:]); err != nil {
+		if err == io.EOF {
+			return io.EOF
+		}
+		return statusErrorf(CodeCanceled, "failed to read request: %v", err)
+	}
+	if prefix[0] != 0 {
+		return statusErrorf(CodeUnimplemented, "compressed messages are not supported")
+	}
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if size > maxMessageSize {
+		return statusErrorf(CodeResourceExhausted, "message of %d bytes is larger than %d", size, maxMessageSize)
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(s.body, payload); err != nil {
+		return statusErrorf(CodeCanceled, "failed to read request: %v", err)
+	}
+	if err := message.unmarshal(payload); err != nil {
+		return statusErrorf(CodeInvalidArgument, "invalid request message: %v", err)
+	}
+	return nil
+}
+
+// Send writes a response message and flushes it to the client
+func (s *stream) Send(message responseMessage) error {
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	s.sendHeader()
+
+	payload := message.marshal()
+	frame := make([]byte, 5, 5+len(payload))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(payload)))
+	if _, err := s.w.Write(append(frame, payload...)); err != nil {
+		return err
+	}
+	if flusher, ok := s.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+func (s *stream) sendHeader() {
+	if s.headerSent {
+		return
+	}
+	s.headerSent = true
+	s.w.Header().Set("Content-Type", "application/grpc")
+	s.w.WriteHeader(http.StatusOK)
+}
+
+// finish ends the call with the status for err in the trailers
+func (s *stream) finish(err error) {
+	code, message := CodeOK, ""
+	var statusErr *statusError
+	switch {
+	case err == nil:
+	case errors.As(err, &statusErr):
+		code, message = statusErr.code, statusErr.message
+	case errors.Is(err, context.DeadlineExceeded):
+		code, message = CodeDeadlineExceeded, "deadline exceeded"
+	case errors.Is(err, context.Canceled):
+		code, message = CodeCanceled, "call canceled"
+	default:
+		code, message = CodeInternal, err.Error()
+	}
+
+	// Calls that end before sending anything get a Trailers-Only response,
+	// with the status in the headers
+	prefix := http.TrailerPrefix
+	if !s.headerSent {
+		prefix = ""
+	}
+	header := s.w.Header()
+	header.Set(prefix+"Grpc-Status", strconv.FormatUint(uint64(code), 10))
+	if message != "" {
+		header.Set(prefix+"Grpc-Message", encodeGrpcMessage(message))
+	}
+	s.sendHeader()
+}
+
+// encodeGrpcMessage percent-encodes a status message for the
+// grpc-message trailer
+func encodeGrpcMessage(message string) string {
+	var b strings.Builder
+	for i := 0; i < len(message); i++ {
+		c := message[i]
+		if c >= ' ' && c <= '~' && c != '%' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// parseGrpcTimeout parses the grpc-timeout header, e.g. "30S" or "500m"
+func parseGrpcTimeout(value string) (time.Duration, bool) {
+	if len(value) < 2 || len(value) > 9 {
+		return 0, false
+	}
+	amount, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+	if err != nil || amount < 0 {
+		return 0, false
+	}
+
+	units := map[byte]time.Duration{
+		'H': time.Hour,
+		'M': time.Minute,
+		'S': time.Second,
+		'm': time.Millisecond,
+		'u': time.Microsecond,
+		'n': time.Nanosecond,
+	}
+	unit, ok := units[value[len(value)-1]]
+	if !ok {
+		return 0, false
+	}
+	if amount > int64(math.MaxInt64/unit) {
+		return math.MaxInt64, true
+	}
+	return time.Duration(amount) * unit, true
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+const loadTestUsage = `Usage: oncloud loadtest [flags]
+
+Runs a load-test profile against a running server and reports the latency
+of each endpoint. Run the server with LOAD_TEST_MODE=true so rate limits
+do not cut the benchmark short, on a database filled with oncloud seed.
+
+Profiles:
+  listing             file and folder listings of the seeded users
+  upload              file uploads of -upload-size bytes, kept in the users' files
+  analytics           the admin analytics reports, needs -admin-email and -admin-password
+  all                 all of the above (default)
+
+Flags:
+  -url URL            server to test (default http://localhost:8080)
+  -profile P          profiles to run, comma separated (default all)
+  -users N            seeded users to log in as, seed_user_00000 on (default 10)
+  -password P         password of the seeded users (default ` + seedDefaultPassword + `)
+  -admin-email E      admin to run the analytics profile as
+  -admin-password P   password of the admin
+  -concurrency N      requests in flight at once (default 10)
+  -duration D         how long to run (default 30s)
+  -upload-size N      bytes per upload (default 262144)
+  -out FILE           also write the report as JSON, to compare later runs against
+  -compare FILE       compare with a report written by an earlier run
+`
+
+// loadTestEndpoint is a request a load-test profile sends
+type loadTestEndpoint struct {
+	name    string
+	profile string
+	admin   bool
+	request func(baseURL string) (*http.Request, error)
+}
+
+var loadTestEndpoints = []loadTestEndpoint{
+	{name: "GET /api/v1/files", profile: "listing", request: getRequest("/api/v1/files/?page=1&limit=50")},
+	{name: "GET /api/v1/files?sort=size", profile: "listing", request: getRequest("/api/v1/files/?page=1&limit=50&sort=size&order=desc")},
+	{name: "GET /api/v1/folders", profile: "listing", request: getRequest("/api/v1/folders/")},
+	{name: "GET /admin/api/analytics/users", profile: "analytics", admin: true, request: getRequest("/admin/api/analytics/users?period=30")},
+	{name: "GET /admin/api/analytics/files", profile: "analytics", admin: true, request: getRequest("/admin/api/analytics/files?period=30")},
+	{name: "GET /admin/api/analytics/storage", profile: "analytics", admin: true, request: getRequest("/admin/api/analytics/storage?period=30")},
+	{name: "GET /admin/api/dashboard", profile: "analytics", admin: true, request: getRequest("/admin/api/dashboard")},
+}
+
+// LoadTestReport is the outcome of a load-test run, as written with -out
+type LoadTestReport struct {
+	Profile     string                   `json:"profile"`
+	Concurrency int                      `json:"concurrency"`
+	Duration    string                   `json:"duration"`
+	StartedAt   time.Time                `json:"started_at"`
+	Endpoints   []LoadTestEndpointReport `json:"endpoints"`
+}
+
+// LoadTestEndpointReport is the outcome of the requests to one endpoint.
+// Latencies are in milliseconds.
+type LoadTestEndpointReport struct {
+	Name              string  `json:"name"`
+	Requests          int     `json:"requests"`
+	Errors            int     `json:"errors"`
+	RequestsPerSecond float64 `json:"requests_per_second"`
+	MeanMs            float64 `json:"mean_ms"`
+	P50Ms             float64 `json:"p50_ms"`
+	P95Ms             float64 `json:"p95_ms"`
+	P99Ms             float64 `json:"p99_ms"`
+	MaxMs             float64 `json:"max_ms"`
+}
+
+// runLoadTest sends the requests of a load-test profile to a running server
+// for a while and reports their latency, for benchmarks before and after
+// performance changes
+func runLoadTest(args []string) error {
+	flags := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, loadTestUsage) }
+	baseURL := flags.String("url", "http://localhost:8080", "server to test")
+	profile := flags.String("profile", "all", "profiles to run")
+	users := flags.Int("users", 10, "seeded users to log in as")
+	password := flags.String("password", seedDefaultPassword, "password of the seeded users")
+	adminEmail := flags.String("admin-email", "", "admin to run the analytics profile as")
+	adminPassword := flags.String("admin-password", "", "password of the admin")
+	concurrency := flags.Int("concurrency", 10, "requests in flight at once")
+	duration := flags.Duration("duration", 30*time.Second, "how long to run")
+	uploadSize := flags.Int("upload-size", 256<<10, "bytes per upload")
+	out := flags.String("out", "", "file to write the report to")
+	compare := flags.String("compare", "", "report to compare with")
+	if err := flags.Parse(args); errors.Is(err, flag.ErrHelp) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if *users < 1 || *concurrency < 1 || *duration <= 0 || *uploadSize < 1 {
+		return fmt.Errorf("-users, -concurrency, -duration and -upload-size must be positive")
+	}
+	*baseURL = strings.TrimRight(*baseURL, "/")
+
+	profiles := make(map[string]bool)
+	for _, name := range strings.Split(*profile, ",") {
+		switch name = strings.TrimSpace(name); name {
+		case "all":
+			profiles["listing"], profiles["upload"], profiles["analytics"] = true, true, true
+		case "listing", "upload", "analytics":
+			profiles[name] = true
+		default:
+			return fmt.Errorf("unknown profile %q", name)
+		}
+	}
+
+	var endpoints []loadTestEndpoint
+	for _, endpoint := range loadTestEndpoints {
+		if profiles[endpoint.profile] {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	if profiles["upload"] {
+		endpoints = append(endpoints, loadTestEndpoint{
+			name:    "POST /api/v1/files/upload",
+			profile: "upload",
+			request: uploadRequest(*uploadSize),
+		})
+	}
+
+	client := &http.Client{Timeout: time.Minute}
+
+	// Users log in before the clock starts
+	var userTokens []string
+	if profiles["listing"] || profiles["upload"] {
+		for i := 0; i < *users; i++ {
+			email := fmt.Sprintf("seed_user_%05d@%s", i, seedEmailDomain)
+			token, err := loadTestLogin(client, *baseURL+"/api/v1/auth/login", email, *password, "tokens", "access_token")
+			if err != nil {
+				return fmt.Errorf("failed to log in as %s: %v", email, err)
+			}
+			userTokens = append(userTokens, token)
+		}
+	}
+	var adminToken string
+	if profiles["analytics"] {
+		if *adminEmail == "" || *adminPassword == "" {
+			return fmt.Errorf("the analytics profile needs -admin-email and -admin-password")
+		}
+		token, err := loadTestLogin(client, *baseURL+"/admin/login", *adminEmail, *adminPassword, "token")
+		if err != nil {
+			return fmt.Errorf("failed to log in as admin %s: %v", *adminEmail, err)
+		}
+		adminToken = token
+	}
+
+	fmt.Printf("Running %s for %s with %d requests in flight against %s\n", *profile, *duration, *concurrency, *baseURL)
+
+	latencies := make([][]time.Duration, len(endpoints))
+	failures := make([]int, len(endpoints))
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+
+	startedAt := time.Now()
+	deadline := startedAt.Add(*duration)
+	for worker := 0; worker < *concurrency; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+
+			// Workers start at different endpoints so each gets its share
+			for n := worker; time.Now().Before(deadline); n++ {
+				index := n % len(endpoints)
+				endpoint := endpoints[index]
+
+				token := adminToken
+				if !endpoint.admin {
+					token = userTokens[(worker+n)%len(userTokens)]
+				}
+
+				elapsed, err := loadTestRequest(client, endpoint, *baseURL, token)
+
+				mutex.Lock()
+				if err != nil {
+					failures[index]++
+				} else {
+					latencies[index] = append(latencies[index], elapsed)
+				}
+				mutex.Unlock()
+			}
+		}(worker)
+	}
+	wg.Wait()
+	elapsed := time.Since(startedAt)
+
+	report := &LoadTestReport{
+		Profile:     *profile,
+		Concurrency: *concurrency,
+		Duration:    duration.String(),
+		StartedAt:   startedAt,
+	}
+	for i, endpoint := range endpoints {
+		report.Endpoints = append(report.Endpoints, summarizeLatencies(endpoint.name, latencies[i], failures[i], elapsed))
+	}
+	printLoadTestReport(report)
+
+	if *out != "" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(*out, data, 0644); err != nil {
+			return fmt.Errorf("failed to write report: %v", err)
+		}
+		fmt.Printf("Report written to %s\n", *out)
+	}
+
+	if *compare != "" {
+		data, err := os.ReadFile(*compare)
+		if err != nil {
+			return fmt.Errorf("failed to read report to compare with: %v", err)
+		}
+		var before LoadTestReport
+		if err := json.Unmarshal(data, &before); err != nil {
+			return fmt.Errorf("invalid report to compare with: %v", err)
+		}
+		printLoadTestComparison(&before, report)
+	}
+	return nil
+}
+
+func getRequest(path string) func(baseURL string) (*http.Request, error) {
+	return func(baseURL string) (*http.Request, error) {
+		return http.NewRequest(http.MethodGet, baseURL+path, nil)
+	}
+}
+
+// uploadRequest returns requests uploading a file of random content, so
+// uploads are not deduplicated
+func uploadRequest(size int) func(baseURL string) (*http.Request, error) {
+	return func(baseURL string) (*http.Request, error) {
+		content := make([]byte, size)
+		if _, err := rand.Read(content); err != nil {
+			return nil, err
+		}
+
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		part, err := writer.CreateFormFile("file", fmt.Sprintf("loadtest-%d.bin", time.Now().UnixNano()))
+		if err != nil {
+			return nil, err
+		}
+		if _, err := part.Write(content); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+
+		req, err := http.NewRequest(http.MethodPost, baseURL+"/api/v1/files/upload", &body)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		return req, nil
+	}
+}
+
+// loadTestRequest sends a request to an endpoint, returning how long the
+// server took to answer it in full. Answers other than 2xx are errors.
+func loadTestRequest(client *http.Client, endpoint loadTestEndpoint, baseURL, token string) (time.Duration, error) {
+	req, err := endpoint.request(baseURL)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	started := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	_, err = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	elapsed := time.Since(started)
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return 0, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return elapsed, nil
+}
+
+// loadTestLogin logs in and returns the token found at path in the data of
+// the answer
+func loadTestLogin(client *http.Client, url, email, password string, path ...string) (string, error) {
+	body, err := json.Marshal(map[string]string{"email": email, "password": password})
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var answer struct {
+		Message string                 `json:"message"`
+		Data    map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+		return "", fmt.Errorf("status %d", resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("status %d: %s", resp.StatusCode, answer.Message)
+	}
+
+	value := interface{}(answer.Data)
+	for _, key := range path {
+		object, _ := value.(map[string]interface{})
+		value = object[key]
+	}
+	token, _ := value.(string)
+	if token == "" {
+		// Logins held for step-up verification have no token
+		return "", fmt.Errorf("no token in answer: %s", answer.Message)
+	}
+	return token, nil
+}
+
+// summarizeLatencies returns the report of an endpoint's requests
+func summarizeLatencies(name string, latencies []time.Duration, failures int, elapsed time.Duration) LoadTestEndpointReport {
+	report := LoadTestEndpointReport{
+		Name:              name,
+		Requests:          len(latencies) + failures,
+		Errors:            failures,
+		RequestsPerSecond: float64(len(latencies)+failures) / elapsed.Seconds(),
+	}
+	if len(latencies) == 0 {
+		return report
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	var total time.Duration
+	for _, latency := range latencies {
+		total += latency
+	}
+	percentile := func(p float64) float64 {
+		return milliseconds(latencies[int(p*float64(len(latencies)-1))])
+	}
+
+	report.MeanMs = milliseconds(total / time.Duration(len(latencies)))
+	report.P50Ms = percentile(0.50)
+	report.P95Ms = percentile(0.95)
+	report.P99Ms = percentile(0.99)
+	report.MaxMs = milliseconds(latencies[len(latencies)-1])
+	return report
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func printLoadTestReport(report *LoadTestReport) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ENDPOINT\tREQUESTS\tERRORS\tREQ/S\tMEAN\tP50\tP95\tP99\tMAX")
+	for _, endpoint := range report.Endpoints {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%.1f\t%.1fms\t%.1fms\t%.1fms\t%.1fms\t%.1fms\n",
+			endpoint.Name, endpoint.Requests, endpoint.Errors, endpoint.RequestsPerSecond,
+			endpoint.MeanMs, endpoint.P50Ms, endpoint.P95Ms, endpoint.P99Ms, endpoint.MaxMs)
+	}
+	w.Flush()
+}
+
+// printLoadTestComparison prints how the endpoints of a run did against an
+// earlier run of them. Negative latency changes are improvements.
+func printLoadTestComparison(before, after *LoadTestReport) {
+	previous := make(map[string]LoadTestEndpointReport, len(before.Endpoints))
+	for _, endpoint := range before.Endpoints {
+		previous[endpoint.Name] = endpoint
+	}
+
+	fmt.Printf("\nCompared with the run started %s:\n", before.StartedAt.Local().Format("2006-01-02 15:04:05"))
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ENDPOINT\tREQ/S\tP50\tP95\tP99")
+	for _, endpoint := range after.Endpoints {
+		earlier, ok := previous[endpoint.Name]
+		if !ok {
+			fmt.Fprintf(w, "%s\tnot in earlier run\t\t\t\n", endpoint.Name)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", endpoint.Name,
+			percentChange(earlier.RequestsPerSecond, endpoint.RequestsPerSecond),
+			percentChange(earlier.P50Ms, endpoint.P50Ms),
+			percentChange(earlier.P95Ms, endpoint.P95Ms),
+			percentChange(earlier.P99Ms, endpoint.P99Ms))
+	}
+	w.Flush()
+}
+
+func percentChange(before, after float64) string {
+	if before == 0 {
+		return "n/a"
+	}
+	return fmt.Sprintf("%+.1f%%", (after-before)/before*100)
+}
@@ -2,13 +2,24 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"html/template"
 	"log"
 	"net/http"
 	"oncloud/config"
 	"oncloud/database"
+	"oncloud/grpcapi"
+	"oncloud/middleware"
 	"oncloud/routes"
+	"oncloud/secrets"
+	"oncloud/services"
+	"oncloud/storage"
+	"oncloud/utils"
+	"oncloud/warehouse"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 
@@ -16,6 +27,29 @@ import (
 )
 
 func main() {
+	// "oncloud migrate ..." manages database migrations instead of serving
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(os.Args[2:]); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		return
+	}
+
+	// "oncloud seed" and "oncloud loadtest" fill the database with synthetic
+	// data and benchmark a running server with it
+	if len(os.Args) > 1 && os.Args[1] == "seed" {
+		if err := runSeed(os.Args[2:]); err != nil {
+			log.Fatalf("Seeding failed: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "loadtest" {
+		if err := runLoadTest(os.Args[2:]); err != nil {
+			log.Fatalf("Load test failed: %v", err)
+		}
+		return
+	}
+
 	// Initialize application
 	app, err := NewApplication()
 	if err != nil {
@@ -32,9 +66,12 @@ func main() {
 type Application struct {
 	config         *config.Config
 	server         *http.Server
+	grpcServer     *grpcapi.Server
 	dbManager      *config.DatabaseManager
 	storageManager *config.StorageManager
 	router         *gin.Engine
+	deps           services.Dependencies
+	stopJobs       context.CancelFunc
 }
 
 // NewApplication creates and initializes a new application instance
@@ -55,6 +92,14 @@ func NewApplication() (*Application, error) {
 	// Initialize database manager
 	dbManager := config.NewDatabaseManager(cfg)
 
+	// Wire what services are built on. The routes and background jobs
+	// build their services on them.
+	deps := services.Dependencies{
+		Database: database.GetManager(),
+		Mailer:   services.SMTPMailer{},
+		Clock:    services.SystemClock{},
+	}
+
 	// Initialize router
 	router := setupRouter(cfg)
 
@@ -64,6 +109,7 @@ func NewApplication() (*Application, error) {
 		dbManager:      dbManager,
 		storageManager: nil, // Will be initialized after database connection
 		router:         router,
+		deps:           deps,
 		server: &http.Server{
 			Addr:         cfg.GetServerAddress(),
 			Handler:      router,
@@ -86,6 +132,11 @@ func (app *Application) Start() error {
 	// Log startup info
 	app.logStartupInfo()
 
+	// Resolve the settings kept in the secrets backend before anything uses them
+	if err := app.initializeSecrets(); err != nil {
+		log.Fatalf("Secrets backend initialization failed: %v", err)
+	}
+
 	// Initialize database
 	if err := app.initializeDatabase(); err != nil {
 		log.Fatalf("Database initialization failed: %v", err)
@@ -96,6 +147,282 @@ func (app *Application) Start() error {
 		log.Fatalf("Storage initialization failed: %v", err)
 	}
 
+	// Configure how long tokens and the sessions they belong to last
+	utils.SetTokenTTLs(app.config.AccessTokenTTL, app.config.RefreshTokenTTL)
+	services.InitSessions(services.SessionOptions{
+		MaxLifetime: app.config.SessionMaxLifetime,
+		BindDevice:  app.config.SessionDeviceBinding,
+	})
+
+	// Configure how account emails are sent and password reset links issued
+	services.InitNotifications(services.NotificationOptions{
+		SMTPHost:     app.config.SMTPHost,
+		SMTPPort:     app.config.SMTPPort,
+		SMTPUsername: app.config.SMTPUsername,
+		SMTPPassword: app.config.GetSMTPPassword,
+		From:         app.config.SMTPFrom,
+	})
+	services.InitPasswordResets(services.PasswordResetOptions{
+		TokenTTL:    app.config.PasswordResetTokenTTL,
+		MaxPerEmail: app.config.PasswordResetMaxPerEmail,
+		MaxPerIP:    app.config.PasswordResetMaxPerIP,
+		Window:      app.config.PasswordResetWindow,
+		ResetURL:    app.config.PasswordResetURL,
+	})
+
+	// Configure the health checks behind the status page
+	services.InitStatus(services.StatusOptions{
+		PaymentsURL: app.config.StatusPaymentsURL,
+		Timeout:     app.config.StatusCheckTimeout,
+	})
+
+	// Load the GeoIP database used to locate logins and downloads
+	if err := utils.InitGeoIP(app.config.GeoIPDatabasePath, app.config.IPAnonymization); err != nil {
+		log.Printf("Warning: GeoIP lookups disabled: %v", err)
+	}
+
+	// Start the buffered analytics event writer
+	services.InitEventWriter(
+		app.config.AnalyticsBufferSize,
+		app.config.AnalyticsBatchSize,
+		app.config.AnalyticsFlushInterval,
+		app.config.AnalyticsEnqueueTimeout,
+	)
+	analyticsRetention, _ := app.config.AnalyticsRetentionPolicies() // validated with the config
+	services.InitRetention(services.RetentionOptions{
+		Analytics:       app.config.AnalyticsRetention,
+		AnalyticsByType: analyticsRetention,
+		APIMetrics:      app.config.APIMetricsRetention,
+		WebhookLogs:     app.config.WebhookLogRetention,
+	})
+	services.InitReportAggregations(services.ReportAggregationOptions{
+		MaxTime:    app.config.ReportMaxTime,
+		MaxResults: app.config.ReportMaxResults,
+	})
+
+	// Move activities past the retention period to cold storage
+	services.InitActivityArchives(services.ActivityArchiveOptions{
+		RetentionDays: app.config.ActivityRetentionDays,
+	})
+
+	// Confirm emptying the trash, and leave a grace period before it runs
+	services.InitTrash(services.TrashOptions{
+		ConfirmWindow: app.config.TrashEmptyConfirmWindow,
+		EmptyDelay:    app.config.TrashEmptyDelay,
+	})
+
+	// Configure the analytics warehouse export
+	if app.config.WarehouseExportEnabled {
+		sink, err := warehouse.NewSink(app.config.WarehouseConfig())
+		if err != nil {
+			log.Fatalf("Warehouse export initialization failed: %v", err)
+		}
+		services.InitWarehouseExport(sink, app.config.WarehouseBatchSize)
+	}
+
+	// Configure the OnlyOffice/Collabora editor integration
+	if app.config.OfficeEditorEnabled {
+		wopiBaseURL := app.config.WOPIBaseURL
+		if wopiBaseURL == "" {
+			wopiBaseURL = app.config.AppURL
+		}
+		services.InitOfficeEditor(services.OfficeEditorOptions{
+			EditorURL:   app.config.OfficeEditorURL,
+			WOPIBaseURL: wopiBaseURL,
+			TokenTTL:    app.config.WOPITokenTTL,
+		})
+	}
+
+	// Scan uploads for viruses before share links serve them
+	if app.config.ClamAVAddress != "" {
+		services.InitVirusScan(services.VirusScanOptions{
+			ClamAVAddress: app.config.ClamAVAddress,
+			Timeout:       app.config.VirusScanTimeout,
+			MaxSize:       app.config.VirusScanMaxSize,
+		})
+	}
+
+	// Render PDF pages for view-only shares
+	if app.config.PreviewRenderer != "" {
+		services.InitDocumentPreview(services.DocumentPreviewOptions{
+			Renderer: app.config.PreviewRenderer,
+			DPI:      app.config.PreviewDPI,
+			Timeout:  app.config.PreviewTimeout,
+			MaxPages: app.config.PreviewMaxPages,
+		})
+	}
+
+	// Preview the start of text and code files
+	services.InitTextPreview(services.TextPreviewOptions{
+		MaxFileSize: app.config.TextPreviewMaxFileSize,
+	})
+
+	// Email users a monthly report of their storage
+	if app.config.StorageReportsEnabled {
+		services.InitStorageReports(services.StorageReportOptions{
+			SendDay:        app.config.StorageReportDay,
+			UnsubscribeURL: app.config.StorageReportUnsubscribeURL,
+		})
+	}
+
+	// Stream shared video and audio over HLS, transcoded with ffmpeg
+	if app.config.HLSTranscoder != "" {
+		services.InitMediaStreams(services.MediaStreamOptions{
+			Transcoder:      app.config.HLSTranscoder,
+			SegmentDuration: app.config.HLSSegmentDuration,
+			Timeout:         app.config.HLSTranscodeTimeout,
+			MaxFileSize:     app.config.HLSMaxFileSize,
+			SegmentURLTTL:   app.config.HLSSegmentURLTTL,
+			Retention:       app.config.HLSRetention,
+		})
+	}
+
+	// Configure importing from Dropbox, Google Drive and OneDrive
+	cloudImportRedirectURL := app.config.CloudImportRedirectURL
+	if cloudImportRedirectURL == "" {
+		cloudImportRedirectURL = strings.TrimRight(app.config.AppURL, "/") + "/imports/callback"
+	}
+	services.InitCloudImport(services.CloudImportOptions{
+		RedirectURL: cloudImportRedirectURL,
+		Dropbox: services.CloudProviderCredentials{
+			ClientID:     app.config.DropboxClientID,
+			ClientSecret: app.config.DropboxClientSecret,
+		},
+		GoogleDrive: services.CloudProviderCredentials{
+			ClientID:     app.config.GoogleDriveClientID,
+			ClientSecret: app.config.GoogleDriveClientSecret,
+		},
+		OneDrive: services.CloudProviderCredentials{
+			ClientID:     app.config.OneDriveClientID,
+			ClientSecret: app.config.OneDriveClientSecret,
+		},
+	})
+
+	// Keep chunked uploads outside the public upload path until completed
+	services.InitChunkUploads(services.ChunkUploadOptions{
+		Dir:        app.config.ChunkUploadPath,
+		SessionTTL: app.config.UploadSessionTTL,
+	})
+
+	// Collect objects no file, version or thumbnail refers to
+	services.InitOrphanGC(services.OrphanGCOptions{
+		SafetyWindow:  app.config.OrphanGCSafetyWindow,
+		DeleteOrphans: app.config.OrphanGCDelete,
+	})
+
+	// Pre-warm the CDN and the local disk cache with popular files
+	services.InitHotFileCache(services.HotFileCacheOptions{
+		Window:   app.config.HotFileWindow,
+		LocalDir: app.config.LocalCacheDir,
+	})
+
+	// Move large objects to and from providers as parallel ranged parts
+	services.InitTransferAcceleration(storage.TransferOptions{
+		Concurrency: app.config.TransferConcurrency,
+		PartSize:    app.config.TransferPartSize,
+	})
+
+	// Retry, time out and cut off calls to failing providers
+	services.InitProviderResilience(services.ProviderResilienceOptions{
+		MaxRetries:       app.config.ProviderMaxRetries,
+		RetryBackoff:     app.config.ProviderRetryBackoff,
+		Timeout:          app.config.ProviderTimeout,
+		BreakerThreshold: app.config.ProviderBreakerThreshold,
+		BreakerCooldown:  app.config.ProviderBreakerCooldown,
+	})
+
+	// Cache objects read from remote providers on local disk
+	if app.config.ObjectCacheDir != "" {
+		err := services.InitObjectCache(services.ObjectCacheOptions{
+			Dir:           app.config.ObjectCacheDir,
+			MaxSize:       app.config.ObjectCacheSize,
+			MaxObjectSize: app.config.ObjectCacheMaxObjectSize,
+		})
+		if err != nil {
+			log.Printf("Warning: Object cache disabled: %v", err)
+		}
+	}
+
+	// Create files from stored content when clients send a known hash
+	services.InitInstantUploads(services.InstantUploadOptions{
+		Shared: app.config.InstantUploadShared,
+	})
+
+	// Sign receipts for downloads of files that ask for them
+	if app.config.DownloadReceiptSigningKey != "" {
+		signingKey, _ := utils.ParseEd25519PrivateKey(app.config.DownloadReceiptSigningKey)
+		services.InitDownloadReceipts(services.DownloadReceiptOptions{SigningKey: signingKey})
+	}
+
+	// Configure receiving files by email
+	if app.config.InboundEmailDomain != "" {
+		services.InitEmailInbox(services.EmailInboxOptions{
+			Domain:            app.config.InboundEmailDomain,
+			MailgunSigningKey: app.config.InboundEmailMailgunSigningKey,
+			WebhookSecret:     app.config.InboundEmailWebhookSecret,
+		})
+	}
+
+	// Configure the Telegram and Slack bots
+	services.InitChatBots(services.ChatBotOptions{
+		Telegram: services.TelegramBotOptions{
+			Token:         app.config.TelegramBotToken,
+			WebhookSecret: app.config.TelegramWebhookSecret,
+		},
+		Slack: services.SlackBotOptions{
+			BotToken:      app.config.SlackBotToken,
+			SigningSecret: app.config.SlackSigningSecret,
+		},
+	})
+
+	// Export support tickets to the configured helpdesk
+	services.InitHelpdesk(services.HelpdeskOptions{
+		Provider:      app.config.HelpdeskProvider,
+		URL:           app.config.HelpdeskURL,
+		Email:         app.config.HelpdeskEmail,
+		APIKey:        app.config.HelpdeskAPIKey,
+		WebhookSecret: app.config.HelpdeskWebhookSecret,
+		AutoExport:    app.config.HelpdeskAutoExport,
+	})
+
+	// Refund through the payment gateway and act on the chargebacks it reports
+	services.InitPaymentGateway(services.PaymentGatewayOptions{
+		Gateway: app.config.PaymentGateway,
+		Stripe: services.StripeGatewayOptions{
+			SecretKey:     app.config.StripeSecretKey,
+			WebhookSecret: app.config.StripeWebhookSecret,
+		},
+		ChargebackPause: app.config.ChargebackPause,
+	})
+
+	// Default branding until an admin customizes it
+	services.InitBranding(services.BrandingOptions{
+		ProductName: app.config.AppName,
+	})
+
+	// Environment values of the settings admins can change at runtime
+	services.InitRuntimeSettings(services.RuntimeSettingsOptions{
+		MaxUploadSize:          app.config.MaxUploadSize,
+		MaxUploadsPerUser:      app.config.MaxConcurrentUploadsPerUser,
+		DefaultStorageProvider: app.config.DefaultStorageProvider,
+		AdminPanelEnabled:      app.config.AdminPanelEnabled,
+		MaintenanceMode:        app.config.MaintenanceMode,
+		MaintenanceMessage:     app.config.MaintenanceMessage,
+		AbuseDisableThreshold:  app.config.AbuseDisableThreshold,
+		RejectTypeMismatch:     app.config.RejectTypeMismatch,
+		HotFileThreshold:       app.config.HotFileThreshold,
+		EdgeCacheBudget:        app.config.EdgeCacheBudget,
+		LocalCacheBudget:       app.config.LocalCacheBudget,
+		CompressionAtRest:      app.config.CompressionAtRest,
+	})
+
+	// Enable the GraphQL API
+	if app.config.GraphQLEnabled {
+		if err := services.InitGraphQL(); err != nil {
+			log.Fatalf("GraphQL initialization failed: %v", err)
+		}
+	}
+
 	// Setup routes
 	app.setupRoutes()
 
@@ -110,12 +437,84 @@ func (app *Application) Start() error {
 		}
 	}()
 
+	// Start the gRPC API for sync clients
+	if app.config.GRPCPort != "" {
+		app.grpcServer = grpcapi.NewServer(grpcapi.Options{
+			Address:     ":" + app.config.GRPCPort,
+			TLSCertFile: app.config.GRPCTLSCertFile,
+			TLSKeyFile:  app.config.GRPCTLSKeyFile,
+
+			Dependencies: app.deps,
+		})
+		go func() {
+			log.Printf("gRPC server starting on :%s", app.config.GRPCPort)
+			if err := app.grpcServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Failed to start gRPC server: %v", err)
+			}
+		}()
+	}
+
 	// Wait for shutdown signal
 	app.waitForShutdown()
 
 	return nil
 }
 
+// initializeSecrets connects to the secrets backend and resolves the settings
+// referring to secrets in it, keeping them up to date as they rotate.
+// Storage provider keys are resolved as clients are created.
+func (app *Application) initializeSecrets() error {
+	if app.config.SecretsBackend == "" {
+		return nil
+	}
+
+	backend, err := secrets.NewBackend(app.config.SecretsConfig())
+	if err != nil {
+		return err
+	}
+	resolver := secrets.NewResolver(backend, app.config.SecretsRefreshInterval)
+	secrets.Init(resolver)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var jwtSecret, jwtRefreshSecret string
+	if secrets.IsReference(app.config.JWTSecret) {
+		jwtSecret, err = resolver.Watch(ctx, app.config.JWTSecret, func(value string) {
+			log.Println("JWT secret rotated")
+			utils.SetJWTSecrets(value, "")
+		})
+		if err != nil {
+			return fmt.Errorf("failed to resolve JWT_SECRET: %v", err)
+		}
+	}
+	if secrets.IsReference(app.config.JWTRefreshSecret) {
+		jwtRefreshSecret, err = resolver.Watch(ctx, app.config.JWTRefreshSecret, func(value string) {
+			log.Println("JWT refresh secret rotated")
+			utils.SetJWTSecrets("", value)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to resolve JWT_REFRESH_SECRET: %v", err)
+		}
+	}
+	if jwtSecret != "" || jwtRefreshSecret != "" {
+		utils.SetJWTSecrets(jwtSecret, jwtRefreshSecret)
+	}
+
+	smtpPassword, err := resolver.Watch(ctx, app.config.SMTPPassword, func(value string) {
+		log.Println("SMTP password rotated")
+		app.config.SetSMTPPassword(value)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to resolve SMTP_PASSWORD: %v", err)
+	}
+	app.config.SetSMTPPassword(smtpPassword)
+
+	resolver.Start()
+	log.Printf("Secrets backend %s connected", backend.Name())
+	return nil
+}
+
 // initializeDatabase sets up database connection and runs migrations
 func (app *Application) initializeDatabase() error {
 	log.Println("Initializing database...")
@@ -151,15 +550,20 @@ func (app *Application) initializeStorage() error {
 
 // setupRoutes configures all application routes and middleware
 func (app *Application) setupRoutes() {
-	routes.SetupRoutes(app.router)
+	routes.SetupRoutes(app.router, app.deps)
 	log.Println("Routes configured successfully")
 }
 
 func setupRouter(config *config.Config) *gin.Engine {
 	router := gin.New()
 
-	// Trust proxies for proper client IP detection
-	router.SetTrustedProxies([]string{"127.0.0.1", "::1"})
+	// Trust proxies for proper client IP detection. Forwarding headers are only
+	// honoured when the direct peer is one of the configured proxies/CIDRs, and
+	// X-Forwarded-For chains are walked right-to-left skipping trusted hops.
+	if err := router.SetTrustedProxies(config.TrustedProxies); err != nil {
+		log.Fatalf("Invalid trusted proxies configuration: %v", err)
+	}
+	router.RemoteIPHeaders = config.ClientIPHeaders
 
 	// Global middleware (order matters)
 	router.Use(gin.Recovery())
@@ -168,8 +572,13 @@ func setupRouter(config *config.Config) *gin.Engine {
 	router.GET("/health", healthCheckHandler())
 	router.GET("/version", versionHandler())
 
-	// Configure template loading if admin panel is enabled
-	if config.AdminPanelEnabled {
+	// Templates are loaded even while the admin panel is disabled, as it can
+	// be enabled from the runtime settings
+	if templates, _ := filepath.Glob("admin/templates/**/*"); len(templates) > 0 {
+		router.SetFuncMap(template.FuncMap{
+			"branding":       services.CurrentBranding,
+			"formatFileSize": utils.FormatFileSize,
+		})
 		router.LoadHTMLGlob("admin/templates/**/*")
 		router.Static("/admin/static", "./admin/static")
 	}
@@ -199,6 +608,10 @@ func (app *Application) waitForShutdown() {
 func (app *Application) shutdown() {
 	log.Println("Shutting down server...")
 
+	if app.stopJobs != nil {
+		app.stopJobs()
+	}
+
 	// Create context with timeout for shutdown
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
@@ -208,6 +621,24 @@ func (app *Application) shutdown() {
 		log.Printf("Server forced to shutdown: %v", err)
 	}
 
+	// Shutdown gRPC server
+	if app.grpcServer != nil {
+		if err := app.grpcServer.Shutdown(ctx); err != nil {
+			log.Printf("gRPC server forced to shutdown: %v", err)
+		}
+	}
+
+	// Flush buffered analytics events before the database goes away
+	if err := services.StopEventWriter(ctx); err != nil {
+		log.Printf("Failed to flush analytics events: %v", err)
+	}
+
+	utils.CloseGeoIP()
+
+	if resolver := secrets.GetResolver(); resolver != nil {
+		resolver.Stop()
+	}
+
 	// Close database connection
 	if err := app.dbManager.Close(); err != nil {
 		log.Printf("Error closing database: %v", err)
@@ -241,6 +672,21 @@ func healthCheckHandler() gin.HandlerFunc {
 			}
 		}
 
+		// Add upload gate utilisation
+		health["uploads"] = middleware.GetUploadGateStats()
+
+		// Add analytics event buffer utilisation
+		health["analytics_events"] = services.GetEventWriterStats()
+
+		// Add storage provider circuit breakers
+		breakers := services.GetProviderBreakerStates()
+		for _, breaker := range breakers {
+			if breaker.State == services.BreakerOpen {
+				health["status"] = "degraded"
+			}
+		}
+		health["storage_breakers"] = breakers
+
 		c.JSON(http.StatusOK, health)
 	}
 }
@@ -258,6 +704,11 @@ func versionHandler() gin.HandlerFunc {
 }
 
 func (app *Application) startBackgroundJobs() {
+	// Jobs run under a context cancelled on shutdown, so their database and
+	// provider calls stop instead of racing the closing connection
+	ctx, cancel := context.WithCancel(context.Background())
+	app.stopJobs = cancel
+
 	// Database cleanup job
 	go func() {
 		ticker := time.NewTicker(1 * time.Hour)
@@ -294,6 +745,340 @@ func (app *Application) startBackgroundJobs() {
 		}
 	}()
 
+	// Dashboard statistics rollups
+	go func() {
+		rollupService := services.NewRollupServiceWith(app.deps)
+		if err := rollupService.RunScheduledRollups(ctx); err != nil {
+			log.Printf("Initial stats rollup failed: %v", err)
+		}
+
+		ticker := time.NewTicker(app.config.RollupInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := rollupService.RunScheduledRollups(ctx); err != nil {
+					log.Printf("Stats rollup failed: %v", err)
+				}
+			}
+		}
+	}()
+
+	// Analytics warehouse export
+	if exporter := services.GetWarehouseExportService(); exporter != nil {
+		go func() {
+			ticker := time.NewTicker(app.config.WarehouseExportInterval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ticker.C:
+					if _, err := exporter.RunExport(ctx); err != nil {
+						log.Printf("Warehouse export failed: %v", err)
+					}
+				}
+			}
+		}()
+	}
+
+	// Usage and error anomaly detection
+	if app.config.AnomalyDetectionEnabled {
+		go func() {
+			anomalyService := services.NewAnomalyServiceWith(app.deps)
+			opts := services.AnomalyDetectionOptions{
+				Sigma:    app.config.AnomalySigma,
+				Interval: app.config.AnomalyCheckInterval,
+			}
+
+			ticker := time.NewTicker(app.config.AnomalyCheckInterval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ticker.C:
+					if _, err := anomalyService.DetectAnomalies(ctx, opts); err != nil {
+						log.Printf("Anomaly detection failed: %v", err)
+					}
+				}
+			}
+		}()
+	}
+
+	// Takedown deadlines: restore counter-noticed content, uphold the rest
+	go func() {
+		takedownService := services.NewTakedownServiceWith(app.deps)
+
+		ticker := time.NewTicker(1 * time.Hour)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if closed, err := takedownService.ProcessDeadlines(ctx); err != nil {
+					log.Printf("Takedown deadline processing failed: %v", err)
+				} else if closed > 0 {
+					log.Printf("Closed %d takedown cases past their deadline", closed)
+				}
+			}
+		}
+	}()
+
+	// File expiries: warn owners ahead, trash expired files
+	go func() {
+		expiryService := services.NewFileExpiryServiceWith(app.deps)
+
+		ticker := time.NewTicker(app.config.FileExpiryInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				warned, trashed, err := expiryService.ProcessExpiries(ctx, app.config.FileExpiryWarning)
+				if err != nil {
+					log.Printf("File expiry processing failed: %v", err)
+				} else if warned > 0 || trashed > 0 {
+					log.Printf("Warned of %d expiring files, moved %d expired files to the trash", warned, trashed)
+				}
+			}
+		}
+	}()
+
+	// Trash emptying: purge the trash of confirmed requests past their grace period
+	go func() {
+		trashService := services.NewTrashServiceWith(app.deps)
+
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				ran, err := trashService.RunScheduledEmpties(ctx)
+				if err != nil {
+					log.Printf("Emptying scheduled trash failed: %v", err)
+				} else if ran > 0 {
+					log.Printf("Emptied the trash of %d users", ran)
+				}
+			}
+		}
+	}()
+
+	// Storage reports: email users the report of the month before, from the send day
+	go func() {
+		storageReportService := services.NewStorageReportServiceWith(app.deps)
+
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := storageReportService.SendDueReports(ctx); err != nil {
+					log.Printf("Storage reports failed: %v", err)
+				}
+			}
+		}
+	}()
+
+	// Media streams: transcode shared media queued for streaming, and prune unplayed streams
+	go func() {
+		mediaStreamService := services.NewMediaStreamServiceWith(app.deps)
+
+		transcodeTicker := time.NewTicker(15 * time.Second)
+		defer transcodeTicker.Stop()
+		pruneTicker := time.NewTicker(time.Hour)
+		defer pruneTicker.Stop()
+
+		for {
+			select {
+			case <-transcodeTicker.C:
+				mediaStreamService.ProcessPending(ctx)
+			case <-pruneTicker.C:
+				mediaStreamService.PruneStreams(ctx)
+			}
+		}
+	}()
+
+	// Restores of archived files: notify owners once restored copies are ready
+	go func() {
+		classService := services.NewStorageClassServiceWith(app.deps)
+
+		ticker := time.NewTicker(15 * time.Minute)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if completed, err := classService.PollRestores(ctx); err != nil {
+					log.Printf("Archive restore polling failed: %v", err)
+				} else if completed > 0 {
+					log.Printf("Restored %d archived files", completed)
+				}
+			}
+		}
+	}()
+
+	// Folder manifest exports past their retention
+	go func() {
+		manifestService := services.NewFolderManifestServiceWith(app.deps)
+
+		ticker := time.NewTicker(1 * time.Hour)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if purged, err := manifestService.PurgeExpiredExports(ctx); err != nil {
+					log.Printf("Folder manifest export purge failed: %v", err)
+				} else if purged > 0 {
+					log.Printf("Purged %d expired folder manifest exports", purged)
+				}
+			}
+		}
+	}()
+
+	// Popular public and shared files: keep them warm in the caches
+	go func() {
+		hotFileService := services.NewHotFileCacheServiceWith(app.deps)
+
+		ticker := time.NewTicker(app.config.CacheWarmInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if run, err := hotFileService.WarmCache(ctx); err != nil {
+					log.Printf("Cache warming failed: %v", err)
+				} else if run.Warmed > 0 || run.Evicted > 0 {
+					log.Printf("Warmed %d hot files in the caches, evicted %d", run.Warmed, run.Evicted)
+				}
+			}
+		}
+	}()
+
+	// Abandoned multipart sessions, provider uploads and upload chunks
+	go func() {
+		cleanupService := services.NewUploadCleanupServiceWith(app.deps)
+
+		ticker := time.NewTicker(app.config.UploadCleanupInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if run, err := cleanupService.RunCleanup(ctx, "scheduled"); err != nil {
+					log.Printf("Upload cleanup failed: %v", err)
+				} else if run.ReclaimedBytes > 0 {
+					log.Printf("Upload cleanup reclaimed %s", utils.FormatFileSize(run.ReclaimedBytes))
+				}
+			}
+		}
+	}()
+
+	// Orphaned object reconciliation
+	go func() {
+		orphanGCService := services.NewOrphanGCServiceWith(app.deps)
+
+		ticker := time.NewTicker(app.config.OrphanGCInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				orphanGCService.RunScheduledScans(ctx)
+			}
+		}
+	}()
+
+	// Component health checks behind the status page
+	go func() {
+		statusService := services.NewStatusServiceWith(app.deps)
+		if _, err := statusService.RunChecks(ctx); err != nil {
+			log.Printf("Health checks failed: %v", err)
+		}
+
+		ticker := time.NewTicker(app.config.StatusCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := statusService.RunChecks(ctx); err != nil {
+					log.Printf("Health checks failed: %v", err)
+				}
+			}
+		}
+	}()
+
+	// Expiry of analytics events and logs kept in line with their retention
+	go func() {
+		retentionService := services.NewRetentionServiceWith(app.deps)
+		if err := retentionService.ApplyRetention(ctx); err != nil {
+			log.Printf("Applying retention failed: %v", err)
+		}
+
+		ticker := time.NewTicker(24 * time.Hour)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := retentionService.ApplyRetention(ctx); err != nil {
+					log.Printf("Applying retention failed: %v", err)
+				}
+			}
+		}
+	}()
+
+	// Activities past the retention period moved to cold storage
+	if app.config.ActivityArchiveEnabled {
+		go func() {
+			archiveService := services.NewActivityArchiveServiceWith(app.deps)
+
+			ticker := time.NewTicker(app.config.ActivityArchiveInterval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ticker.C:
+					if _, err := archiveService.ArchiveOldActivities(ctx); err != nil {
+						log.Printf("Activity archival failed: %v", err)
+					}
+				}
+			}
+		}()
+	}
+
+	// Announcements to email once they start
+	go func() {
+		announcementService := services.NewAnnouncementServiceWith(app.deps)
+
+		ticker := time.NewTicker(1 * time.Minute)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := announcementService.SendDueEmails(ctx); err != nil {
+					log.Printf("Announcement emails failed: %v", err)
+				}
+			}
+		}
+	}()
+
+	// Cloud imports interrupted by a restart
+	go services.NewCloudImportServiceWith(app.deps).ResumeJobs(ctx)
+
+	// Bulk operations interrupted by a restart
+	go services.NewBulkJobServiceWith(app.deps).ResumeJobs(ctx)
+
+	// Folder copies interrupted by a restart
+	go services.NewFolderServiceWith(app.deps).FailInterruptedCopies()
+
+	// Folder manifest exports interrupted by a restart
+	go services.NewFolderManifestServiceWith(app.deps).FailInterruptedExports(ctx)
+
 	log.Println("Background jobs started successfully")
 }
 
@@ -307,6 +1092,16 @@ func (app *Application) logStartupInfo() {
 	log.Printf("Default Storage Provider: %s", app.config.DefaultStorageProvider)
 	log.Printf("Admin Panel: %t", app.config.AdminPanelEnabled)
 	log.Printf("Rate Limiting: %t", app.config.RateLimitEnabled)
+	if app.config.LoadTestMode {
+		log.Printf("Load Test Mode: rate limiting is skipped")
+	}
+	log.Printf("Trusted Proxies: %v", app.config.TrustedProxies)
+	if app.config.WarehouseExportEnabled {
+		log.Printf("Warehouse Export: %s every %s", app.config.WarehouseSink, app.config.WarehouseExportInterval)
+	}
+	if app.config.SecretsBackend != "" {
+		log.Printf("Secrets Backend: %s, refreshed every %s", app.config.SecretsBackend, app.config.SecretsRefreshInterval)
+	}
 	if app.config.Debug {
 		log.Println("Debug mode enabled")
 	}
@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"net/http"
+	"oncloud/models"
+	"oncloud/utils"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// accountStateExemptPaths keep accepting writes from read-only accounts and
+// accounts pending deletion: signing out and securing the account, fetching
+// download links, and changing the plan or payment details that may lift the
+// restriction
+var accountStateExemptPaths = []string{
+	"/api/v1/auth/",
+	"/api/v1/downloads/tokens",
+	"/api/v1/files/bulk/download",
+	"/api/v1/plans/",
+}
+
+// enforceAccountState refuses requests which would change content from
+// accounts that may not currently write, responding with 403 and the
+// account's state. Reads are always let through.
+func enforceAccountState(c *gin.Context, user *models.User) bool {
+	if user.CanWrite() {
+		return true
+	}
+
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	for _, prefix := range accountStateExemptPaths {
+		if strings.HasPrefix(c.Request.URL.Path, prefix) {
+			return true
+		}
+	}
+
+	message := "Your account is read-only"
+	if user.AccountState() == models.AccountStatePendingDeletion {
+		message = "Your account is scheduled for deletion and can no longer be changed"
+	}
+	details := map[string]interface{}{"state": user.AccountState()}
+	if user.StateReason != "" {
+		details["reason"] = user.StateReason
+	}
+	utils.ErrorResponseWithCode(c, http.StatusForbidden, utils.ErrorCodeAccountReadOnly, message, details)
+	c.Abort()
+	return false
+}
@@ -2,8 +2,10 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"oncloud/database"
 	"oncloud/models"
+	"oncloud/services"
 	"oncloud/utils"
 	"strings"
 
@@ -53,9 +55,32 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		// Tokens stop working as soon as they are revoked or their session is
+		// ended
+		if _, err := services.NewSessionService().ValidateToken(c.Request.Context(), claims, user.ID); err != nil {
+			if errors.Is(err, services.ErrTokenRevoked) {
+				utils.UnauthorizedResponse(c, "Token has been revoked")
+			} else {
+				utils.UnauthorizedResponse(c, "Session has ended, please sign in again")
+			}
+			c.Abort()
+			return
+		}
+
+		// Enforce the user's own IP allow/deny list
+		if !enforceUserAccessPolicy(c, user) {
+			return
+		}
+
+		// Read-only accounts and accounts pending deletion may only read
+		if !enforceAccountState(c, user) {
+			return
+		}
+
 		// Set user in context
 		utils.SetUserInContext(c, user)
 		c.Set("token_claims", claims)
+		c.Set("session_id", claims.SessionID)
 
 		c.Next()
 	}
@@ -89,8 +114,14 @@ func OptionalAuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		if _, err := services.NewSessionService().ValidateToken(c.Request.Context(), claims, user.ID); err != nil {
+			c.Next()
+			return
+		}
+
 		utils.SetUserInContext(c, user)
 		c.Set("token_claims", claims)
+		c.Set("session_id", claims.SessionID)
 		c.Next()
 	}
 }
@@ -173,6 +204,18 @@ func RequirePermission(permission string) gin.HandlerFunc {
 	}
 }
 
+// AdminPanelEnabledMiddleware hides the HTML admin panel while it is
+// switched off in the runtime settings
+func AdminPanelEnabledMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !services.RuntimeSettingBool(services.RuntimeSettingAdminPanelEnabled) {
+			c.AbortWithStatus(404)
+			return
+		}
+		c.Next()
+	}
+}
+
 // AdminPanelMiddleware for HTML admin panel authentication
 func AdminPanelMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -27,8 +27,16 @@ func CORSMiddleware() gin.HandlerFunc {
 			"User-Agent",
 			"X-Requested-With",
 			"X-CSRF-Token",
+			"X-Device-ID",
 			"X-Upload-Content-Type",
 			"X-Upload-Content-Length",
+			"X-HTTP-Method-Override",
+			"Tus-Resumable",
+			"Upload-Length",
+			"Upload-Metadata",
+			"Upload-Offset",
+			"Upload-Checksum",
+			"Upload-Defer-Length",
 		},
 		ExposeHeaders: []string{
 			"Content-Length",
@@ -36,6 +44,17 @@ func CORSMiddleware() gin.HandlerFunc {
 			"Content-Disposition",
 			"X-Total-Count",
 			"X-Page-Count",
+			"Location",
+			"Tus-Resumable",
+			"Tus-Version",
+			"Tus-Extension",
+			"Tus-Max-Size",
+			"Tus-Checksum-Algorithm",
+			"Upload-Offset",
+			"Upload-Length",
+			"Upload-Metadata",
+			"Upload-Expires",
+			"Upload-File-Id",
 		},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
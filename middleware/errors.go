@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"oncloud/services"
+	"oncloud/utils"
+)
+
+// errorKinds are the statuses and error codes the kinds of service errors
+// are answered with. Errors of a kind with a message are answered with it
+// instead of their own, which may carry provider details.
+var errorKinds = []struct {
+	kind    error
+	status  int
+	code    string
+	message string
+}{
+	{kind: services.ErrNotFound, status: http.StatusNotFound, code: utils.ErrorCodeNotFound},
+	{kind: services.ErrForbidden, status: http.StatusForbidden, code: utils.ErrorCodeForbidden},
+	{kind: services.ErrQuotaExceeded, status: http.StatusForbidden, code: utils.ErrorCodeQuotaExceeded},
+	{kind: services.ErrProviderUnavailable, status: http.StatusServiceUnavailable, code: utils.ErrorCodeProviderUnavailable,
+		message: "Storage is temporarily unavailable, please try again shortly"},
+	{kind: services.ErrProviderTimeout, status: http.StatusGatewayTimeout, code: utils.ErrorCodeProviderTimeout,
+		message: "Storage did not respond in time, please try again"},
+}
+
+// ErrorHandler answers requests whose handler left an error with
+// utils.ServiceErrorResponse instead of responding, with the status and
+// error code of the error's kind. Errors of no known kind are internal
+// errors, answered with the message the handler gave.
+func ErrorHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if c.Writer.Written() || len(c.Errors) == 0 {
+			return
+		}
+		last := c.Errors.Last()
+
+		for _, kind := range errorKinds {
+			if !errors.Is(last.Err, kind.kind) {
+				continue
+			}
+			message := kind.message
+			if message == "" {
+				message = capitalize(last.Err.Error())
+			}
+			if kind.status == http.StatusServiceUnavailable {
+				c.Header("Retry-After", "30")
+			}
+			utils.ErrorResponseWithCode(c, kind.status, kind.code, message, nil)
+			return
+		}
+
+		message, _ := last.Meta.(string)
+		utils.InternalServerErrorResponse(c, message)
+	}
+}
+
+func capitalize(message string) string {
+	if message == "" {
+		return message
+	}
+	return strings.ToUpper(message[:1]) + message[1:]
+}
@@ -32,9 +32,10 @@ func LoggingMiddleware() gin.HandlerFunc {
 		path := c.Request.URL.Path
 		raw := c.Request.URL.RawQuery
 
-		// Read request body
+		// Read request body (small non-upload bodies only, so uploads are never
+		// buffered in memory just for logging)
 		var requestBody []byte
-		if c.Request.Body != nil {
+		if c.Request.Body != nil && !isFileUpload(c) && c.Request.ContentLength > 0 && c.Request.ContentLength < 1024 {
 			requestBody, _ = io.ReadAll(c.Request.Body)
 			c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
 		}
@@ -59,6 +60,9 @@ func LoggingMiddleware() gin.HandlerFunc {
 			path = path + "?" + raw
 		}
 
+		// Feed the per-minute request counters used for error rate monitoring
+		utils.RecordRequestStatus(statusCode)
+
 		// Get user info if available
 		var userID string
 		var username string
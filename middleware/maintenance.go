@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"oncloud/services"
+	"oncloud/utils"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maintenanceExemptPaths keep working during maintenance: health checks, the
+// admin API and panel, and what clients need to explain the outage, such as
+// the status page
+var maintenanceExemptPaths = []string{
+	"/health",
+	"/version",
+	"/admin",
+	"/api/docs",
+	"/api/v1/maintenance",
+	"/api/v1/branding",
+	"/api/v1/status",
+}
+
+// MaintenanceMiddleware answers users with 503 while the service is under
+// maintenance. Requests carrying an admin token are let through.
+func MaintenanceMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, prefix := range maintenanceExemptPaths {
+			if strings.HasPrefix(c.Request.URL.Path, prefix) {
+				c.Next()
+				return
+			}
+		}
+
+		status := services.CurrentMaintenance()
+		if !status.Active || isAdminRequest(c) {
+			c.Next()
+			return
+		}
+
+		details := map[string]interface{}{}
+		if status.Until != nil {
+			retryAfter := int(math.Ceil(time.Until(*status.Until).Seconds()))
+			c.Header("Retry-After", strconv.Itoa(max(retryAfter, 1)))
+			details["until"] = status.Until
+		}
+
+		if strings.Contains(c.GetHeader("Accept"), "text/html") {
+			c.HTML(http.StatusServiceUnavailable, "status/maintenance.html", gin.H{
+				"title":   "Under maintenance",
+				"message": status.Message,
+				"until":   status.Until,
+			})
+		} else {
+			utils.ErrorResponseWithCode(c, http.StatusServiceUnavailable, utils.ErrorCodeMaintenance, status.Message, details)
+		}
+		c.Abort()
+	}
+}
+
+// isAdminRequest reports whether the request carries a valid admin token.
+// User tokens share the signing key, so the admin ID is checked too.
+func isAdminRequest(c *gin.Context) bool {
+	token, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !found {
+		return false
+	}
+	claims, err := utils.ValidateAdminToken(token)
+	return err == nil && !claims.AdminID.IsZero()
+}
@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"net/http"
+	"oncloud/services"
+	"oncloud/utils"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ScimAuthMiddleware authenticates identity providers calling the SCIM API
+// with an admin-issued bearer token
+func ScimAuthMiddleware() gin.HandlerFunc {
+	scimService := services.NewScimService()
+
+	return func(c *gin.Context) {
+		token, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !found || token == "" {
+			utils.ScimErrorResponse(c, http.StatusUnauthorized, "", "Bearer token required")
+			c.Abort()
+			return
+		}
+
+		record, err := scimService.AuthenticateToken(c.Request.Context(), token)
+		if err != nil {
+			utils.ScimErrorResponse(c, http.StatusUnauthorized, "", "Invalid or revoked SCIM token")
+			c.Abort()
+			return
+		}
+
+		c.Set("scim_token", record)
+		c.Next()
+	}
+}
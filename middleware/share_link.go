@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"net/http"
+	"oncloud/services"
+	"oncloud/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ShareLinkMiddleware resolves the :slug of a short link, a custom slug or
+// a share token, to its share. The share's token is set as the :token
+// parameter, and its kind as "share_kind", for the share handlers.
+func ShareLinkMiddleware() gin.HandlerFunc {
+	shareLinks := services.NewShareLinkService()
+
+	return func(c *gin.Context) {
+		share, kind, err := shareLinks.Resolve(c.Request.Context(), c.Param("slug"), c.Request.Host)
+		if err != nil {
+			utils.NotFoundResponse(c, "Share not found or access denied")
+			c.Abort()
+			return
+		}
+
+		c.Params = append(c.Params, gin.Param{Key: "token", Value: share.Token})
+		c.Set("share_kind", kind)
+		c.Next()
+	}
+}
+
+// ShareLinkRedirectMiddleware redirects token links of kind to the short
+// link of their share once it has a slug or its owner a share domain
+func ShareLinkRedirectMiddleware(kind string) gin.HandlerFunc {
+	shareLinks := services.NewShareLinkService()
+
+	return func(c *gin.Context) {
+		target := shareLinks.CanonicalURL(c.Request.Context(), kind, c.Param("token"))
+		if target == "" {
+			c.Next()
+			return
+		}
+
+		if c.Request.URL.RawQuery != "" {
+			target += "?" + c.Request.URL.RawQuery
+		}
+		c.Redirect(http.StatusFound, target)
+		c.Abort()
+	}
+}
@@ -0,0 +1,199 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"oncloud/config"
+	"oncloud/services"
+	"oncloud/utils"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// multipartOverhead is the extra room allowed on top of the file size for
+// multipart boundaries and regular form fields
+const multipartOverhead = 1 << 20 // 1MB
+
+// UploadGate bounds the number of uploads processed at the same time, both
+// globally and per user, so a burst of large uploads cannot exhaust memory
+type UploadGate struct {
+	global       chan struct{}
+	perUserLimit int
+	queueTimeout time.Duration
+	active       map[string]int
+	mutex        sync.Mutex
+}
+
+var (
+	uploadGate     *UploadGate
+	uploadGateOnce sync.Once
+)
+
+// NewUploadGate creates a new upload gate
+func NewUploadGate(globalLimit, perUserLimit int, queueTimeout time.Duration) *UploadGate {
+	return &UploadGate{
+		global:       make(chan struct{}, globalLimit),
+		perUserLimit: perUserLimit,
+		queueTimeout: queueTimeout,
+		active:       make(map[string]int),
+	}
+}
+
+// getUploadGate returns the process-wide upload gate configured from AppConfig
+func getUploadGate() *UploadGate {
+	uploadGateOnce.Do(func() {
+		globalLimit, perUserLimit, queueTimeout := 50, 3, 5*time.Second
+		if cfg := config.AppConfig; cfg != nil {
+			globalLimit = cfg.MaxConcurrentUploads
+			perUserLimit = cfg.MaxConcurrentUploadsPerUser
+			queueTimeout = cfg.UploadQueueTimeout
+		}
+		uploadGate = NewUploadGate(globalLimit, perUserLimit, queueTimeout)
+	})
+	return uploadGate
+}
+
+// SetPerUserLimit changes the number of uploads each user can run at once.
+// Uploads already running are not affected.
+func (g *UploadGate) SetPerUserLimit(limit int) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.perUserLimit = limit
+}
+
+// acquireUser reserves a per-user upload slot
+func (g *UploadGate) acquireUser(key string) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.perUserLimit > 0 && g.active[key] >= g.perUserLimit {
+		return false
+	}
+	g.active[key]++
+	return true
+}
+
+// releaseUser frees a per-user upload slot
+func (g *UploadGate) releaseUser(key string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.active[key] <= 1 {
+		delete(g.active, key)
+		return
+	}
+	g.active[key]--
+}
+
+// acquireGlobal waits up to the queue timeout for a global upload slot
+func (g *UploadGate) acquireGlobal() bool {
+	if cap(g.global) == 0 {
+		return true
+	}
+
+	timer := time.NewTimer(g.queueTimeout)
+	defer timer.Stop()
+
+	select {
+	case g.global <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// releaseGlobal frees a global upload slot
+func (g *UploadGate) releaseGlobal() {
+	if cap(g.global) == 0 {
+		return
+	}
+	<-g.global
+}
+
+// Stats returns the current gate utilisation
+func (g *UploadGate) Stats() map[string]interface{} {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	return map[string]interface{}{
+		"active_uploads":       len(g.global),
+		"max_uploads":          cap(g.global),
+		"active_uploaders":     len(g.active),
+		"max_uploads_per_user": g.perUserLimit,
+	}
+}
+
+// GetUploadGateStats returns utilisation of the process-wide upload gate
+func GetUploadGateStats() map[string]interface{} {
+	return getUploadGate().Stats()
+}
+
+// RequestSizeLimitMiddleware rejects request bodies larger than maxBytes.
+// Requests announcing a larger Content-Length are refused with 413 before any
+// of the body is read; chunked bodies are capped while being read.
+func RequestSizeLimitMiddleware(maxBytes int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if maxBytes <= 0 {
+			c.Next()
+			return
+		}
+
+		if c.Request.ContentLength > maxBytes {
+			c.Header("Connection", "close")
+			utils.PayloadTooLargeResponse(c, fmt.Sprintf("Request body exceeds limit of %s", utils.FormatFileSize(maxBytes)))
+			c.Abort()
+			return
+		}
+
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes)
+		c.Next()
+	}
+}
+
+// UploadSizeLimitMiddleware limits multipart upload requests to the maximum
+// upload size plus room for form fields. The size is read for each request,
+// so changes to the runtime setting apply without a restart.
+func UploadSizeLimitMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var maxBytes int64
+		if maxUploadSize := services.RuntimeSettingInt64(services.RuntimeSettingMaxUploadSize); maxUploadSize > 0 {
+			maxBytes = maxUploadSize + multipartOverhead
+		}
+		RequestSizeLimitMiddleware(maxBytes)(c)
+	}
+}
+
+// UploadConcurrencyMiddleware applies per-user and global upload concurrency
+// limits. Must run after authentication so the user can be identified.
+func UploadConcurrencyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		gate := getUploadGate()
+		clientID := getClientID(c)
+
+		// The per-user limit can be changed from the runtime settings
+		perUserLimit := int(services.RuntimeSettingInt64(services.RuntimeSettingMaxUploadsPerUser))
+		gate.SetPerUserLimit(perUserLimit)
+
+		if !gate.acquireUser(clientID) {
+			c.Header("Retry-After", "5")
+			utils.TooManyRequestsResponse(c, fmt.Sprintf("Too many concurrent uploads, at most %d allowed at a time", perUserLimit))
+			c.Abort()
+			return
+		}
+		defer gate.releaseUser(clientID)
+
+		if !gate.acquireGlobal() {
+			c.Header("Retry-After", strconv.Itoa(int(gate.queueTimeout.Seconds())+1))
+			utils.ServiceUnavailableResponse(c, "Server is busy processing uploads, please retry shortly")
+			c.Abort()
+			return
+		}
+		defer gate.releaseGlobal()
+
+		c.Next()
+	}
+}
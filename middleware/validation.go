@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"oncloud/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ValidateJSON binds the JSON body of a route into T and validates it before
+// the handler runs. Invalid bodies are answered with 422 listing the failing
+// fields; the handler reads the body with utils.BoundRequest.
+func ValidateJSON[T any]() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req := new(T)
+		if err := utils.BindJSON(c, req); err != nil {
+			utils.BindingErrorResponse(c, err)
+			c.Abort()
+			return
+		}
+
+		utils.SetRequestInContext(c, req)
+		c.Next()
+	}
+}
@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"net/http"
+	"oncloud/services"
+	"oncloud/utils"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// WopiAuthMiddleware authenticates office editor servers calling the WOPI
+// endpoints with an editor session's access token
+func WopiAuthMiddleware(deps services.Dependencies) gin.HandlerFunc {
+	wopiService := services.NewWopiServiceWith(deps)
+
+	return func(c *gin.Context) {
+		token := c.Query("access_token")
+		if token == "" {
+			token, _ = strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		}
+
+		fileID, err := utils.StringToObjectID(c.Param("id"))
+		if token == "" || err != nil {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		session, file, err := wopiService.Authenticate(c.Request.Context(), fileID, token)
+		if err != nil {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		c.Set("wopi_session", session)
+		c.Set("wopi_file", file)
+		c.Next()
+	}
+}
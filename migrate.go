@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"oncloud/config"
+	"oncloud/database"
+	"os"
+	"text/tabwriter"
+)
+
+const migrateUsage = `Usage: oncloud migrate <command> [flags]
+
+Commands:
+  status              list migrations and whether they are applied
+  up [-to N]          apply pending migrations, up to version N if given
+  down [-to N]        revert migrations above version N, or the latest one
+
+Flags:
+  -dry-run            list the migrations up or down would apply or revert
+`
+
+// runMigrate applies or reverts database migrations without starting the
+// server, for deploys that run them as a separate step
+func runMigrate(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, migrateUsage)
+		return fmt.Errorf("missing migrate command")
+	}
+
+	command := args[0]
+	if command != "status" && command != "up" && command != "down" {
+		fmt.Fprint(os.Stderr, migrateUsage)
+		return fmt.Errorf("unknown migrate command %q", command)
+	}
+
+	flags := flag.NewFlagSet("migrate "+command, flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, migrateUsage) }
+	to := flags.Int("to", -1, "target version")
+	dryRun := flags.Bool("dry-run", false, "list migrations without applying or reverting them")
+	if err := flags.Parse(args[1:]); errors.Is(err, flag.ErrHelp) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	cfg := config.LoadConfig()
+	dbManager := config.NewDatabaseManager(cfg)
+	if err := dbManager.Initialize(); err != nil {
+		return err
+	}
+	defer dbManager.Close()
+
+	switch command {
+	case "status":
+		statuses, err := database.MigrationStatuses()
+		if err != nil {
+			return err
+		}
+		printMigrations(statuses)
+		return nil
+
+	case "up":
+		applied, err := database.MigrateUp(max(*to, 0), *dryRun)
+		if *dryRun {
+			fmt.Printf("%d migrations would be applied\n", len(applied))
+		} else {
+			fmt.Printf("%d migrations applied\n", len(applied))
+		}
+		printMigrations(applied)
+		return err
+
+	case "down":
+		if *to < 0 {
+			// Without a target only the latest applied migration is reverted
+			statuses, err := database.MigrationStatuses()
+			if err != nil {
+				return err
+			}
+			previous, latest := 0, 0
+			for _, status := range statuses {
+				if status.Applied {
+					previous, latest = latest, status.Version
+				}
+			}
+			if latest == 0 {
+				fmt.Println("No migrations applied")
+				return nil
+			}
+			*to = previous
+		}
+		reverted, err := database.MigrateDown(*to, *dryRun)
+		if *dryRun {
+			fmt.Printf("%d migrations would be reverted\n", len(reverted))
+		} else {
+			fmt.Printf("%d migrations reverted\n", len(reverted))
+		}
+		printMigrations(reverted)
+		return err
+	}
+	return nil
+}
+
+func printMigrations(statuses []database.MigrationStatus) {
+	if len(statuses) == 0 {
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT\tREVERSIBLE")
+	for _, status := range statuses {
+		appliedAt := "pending"
+		if status.AppliedAt != nil {
+			appliedAt = status.AppliedAt.Local().Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%t\n", status.Version, status.Name, appliedAt, status.Reversible)
+	}
+	w.Flush()
+}
@@ -0,0 +1,59 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Abuse report statuses. Reports start pending and are triaged by admins;
+// confirmed reports count towards disabling the reported link.
+const (
+	AbuseReportPending   = "pending"
+	AbuseReportReviewing = "reviewing"
+	AbuseReportConfirmed = "confirmed"
+	AbuseReportDismissed = "dismissed"
+)
+
+// AbuseReport is a report about a public or shared link, made by anyone who
+// opened it
+type AbuseReport struct {
+	ID       primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	Token    string              `bson:"token" json:"token"`
+	LinkType string              `bson:"link_type" json:"link_type"` // file_share, folder_share, public_file, public_folder
+	FileID   *primitive.ObjectID `bson:"file_id,omitempty" json:"file_id,omitempty"`
+	FolderID *primitive.ObjectID `bson:"folder_id,omitempty" json:"folder_id,omitempty"`
+	OwnerID  primitive.ObjectID  `bson:"owner_id" json:"owner_id"`
+
+	Reason        string   `bson:"reason" json:"reason"`
+	Description   string   `bson:"description" json:"description"`
+	ReporterName  string   `bson:"reporter_name,omitempty" json:"reporter_name,omitempty"`
+	ReporterEmail string   `bson:"reporter_email,omitempty" json:"reporter_email,omitempty"`
+	Evidence      []string `bson:"evidence,omitempty" json:"evidence,omitempty"`
+	ReporterIP    string   `bson:"reporter_ip,omitempty" json:"reporter_ip,omitempty"` // anonymized
+
+	Status       string              `bson:"status" json:"status"`
+	ReviewedBy   *primitive.ObjectID `bson:"reviewed_by,omitempty" json:"reviewed_by,omitempty"`
+	ReviewNotes  string              `bson:"review_notes,omitempty" json:"review_notes,omitempty"`
+	ReviewedAt   *time.Time          `bson:"reviewed_at,omitempty" json:"reviewed_at,omitempty"`
+	LinkDisabled bool                `bson:"link_disabled" json:"link_disabled"`
+	CreatedAt    time.Time           `bson:"created_at" json:"created_at"`
+	UpdatedAt    time.Time           `bson:"updated_at" json:"updated_at"`
+}
+
+// AbuseReportRequest reports a link. Evidence holds URLs such as screenshots.
+type AbuseReportRequest struct {
+	Reason        string   `json:"reason" validate:"required,oneof=malware phishing copyright illegal harassment spam other"`
+	Description   string   `json:"description" validate:"required,max=2000"`
+	ReporterName  string   `json:"reporter_name" validate:"max=100"`
+	ReporterEmail string   `json:"reporter_email" validate:"omitempty,email,max=255"`
+	Evidence      []string `json:"evidence" validate:"max=10,dive,required,url,max=2000"`
+}
+
+// AbuseReportTriageRequest moves a report through triage. DisableLink takes
+// the reported link down at once, without waiting for more reports.
+type AbuseReportTriageRequest struct {
+	Status      string `json:"status" validate:"required,oneof=reviewing confirmed dismissed"`
+	Notes       string `json:"notes" validate:"max=2000"`
+	DisableLink bool   `json:"disable_link"`
+}
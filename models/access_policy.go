@@ -0,0 +1,51 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// IPAccessPolicy restricts which networks and countries may reach the API.
+// The global policy is managed by admins; each user may add their own for
+// their account and, optionally, their share links. Deny rules win over
+// allow rules, and non-empty allow lists admit only matching clients.
+type IPAccessPolicy struct {
+	ID             primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	Scope          string              `bson:"scope" json:"scope"` // global, user
+	UserID         *primitive.ObjectID `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	IsEnabled      bool                `bson:"is_enabled" json:"is_enabled"`
+	AllowCIDRs     []string            `bson:"allow_cidrs" json:"allow_cidrs"`
+	DenyCIDRs      []string            `bson:"deny_cidrs" json:"deny_cidrs"`
+	AllowCountries []string            `bson:"allow_countries" json:"allow_countries"` // ISO 3166-1 alpha-2
+	DenyCountries  []string            `bson:"deny_countries" json:"deny_countries"`
+	ApplyToShares  bool                `bson:"apply_to_shares" json:"apply_to_shares"`
+	UpdatedBy      *primitive.ObjectID `bson:"updated_by,omitempty" json:"updated_by,omitempty"`
+	CreatedAt      time.Time           `bson:"created_at" json:"created_at"`
+	UpdatedAt      time.Time           `bson:"updated_at" json:"updated_at"`
+}
+
+type IPAccessPolicyRequest struct {
+	IsEnabled      bool     `json:"is_enabled"`
+	AllowCIDRs     []string `json:"allow_cidrs"`
+	DenyCIDRs      []string `json:"deny_cidrs"`
+	AllowCountries []string `json:"allow_countries"`
+	DenyCountries  []string `json:"deny_countries"`
+	ApplyToShares  bool     `json:"apply_to_shares"`
+}
+
+// AuditLog is an append-only record of a security-relevant action
+type AuditLog struct {
+	ID           primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
+	ActorType    string                 `bson:"actor_type" json:"actor_type"` // user, admin, system, anonymous
+	ActorID      *primitive.ObjectID    `bson:"actor_id,omitempty" json:"actor_id,omitempty"`
+	Action       string                 `bson:"action" json:"action"`
+	ResourceType string                 `bson:"resource_type,omitempty" json:"resource_type,omitempty"`
+	ResourceID   string                 `bson:"resource_id,omitempty" json:"resource_id,omitempty"`
+	Outcome      string                 `bson:"outcome" json:"outcome"` // success, denied, failure
+	IPAddress    string                 `bson:"ip_address,omitempty" json:"ip_address,omitempty"`
+	Country      string                 `bson:"country,omitempty" json:"country,omitempty"`
+	UserAgent    string                 `bson:"user_agent,omitempty" json:"user_agent,omitempty"`
+	Details      map[string]interface{} `bson:"details,omitempty" json:"details,omitempty"`
+	CreatedAt    time.Time              `bson:"created_at" json:"created_at"`
+}
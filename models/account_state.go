@@ -0,0 +1,28 @@
+package models
+
+// Account states. Suspension is recorded as is_active=false, which every
+// sign-in path already refuses; the other restricted states keep the account
+// signed in but stop it from changing its files and folders.
+const (
+	AccountStateActive          = "active"
+	AccountStateSuspended       = "suspended"
+	AccountStateReadOnly        = "read_only"
+	AccountStatePendingDeletion = "pending_deletion"
+)
+
+// AccountState returns the user's current account state
+func (u *User) AccountState() string {
+	if !u.IsActive {
+		return AccountStateSuspended
+	}
+	switch u.State {
+	case AccountStateReadOnly, AccountStatePendingDeletion:
+		return u.State
+	}
+	return AccountStateActive
+}
+
+// CanWrite reports whether the account may create, change or delete content
+func (u *User) CanWrite() bool {
+	return u.AccountState() == AccountStateActive
+}
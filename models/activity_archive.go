@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ActivityArchive is a gzipped NDJSON file on a storage provider holding the
+// activities of one UTC day, moved out of the activities collection once
+// they were old enough. A day archived over several runs has several
+// archives.
+type ActivityArchive struct {
+	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Day             time.Time          `bson:"day" json:"day"` // midnight UTC
+	From            time.Time          `bson:"from" json:"from"`
+	To              time.Time          `bson:"to" json:"to"` // created_at of the first and last activity
+	Count           int64              `bson:"count" json:"count"`
+	Size            int64              `bson:"size" json:"size"` // compressed
+	StorageProvider string             `bson:"storage_provider" json:"storage_provider"`
+	StorageKey      string             `bson:"storage_key" json:"-"`
+	CreatedAt       time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// ArchivedActivityQuery selects archived activities. The range is required
+// and is limited in length.
+type ArchivedActivityQuery struct {
+	From   time.Time
+	To     time.Time
+	UserID *primitive.ObjectID
+	Action string
+	Limit  int
+}
+
+// ArchivedActivities are the archived activities matching a query, oldest
+// first. Truncated is set when more matched than the limit.
+type ArchivedActivities struct {
+	Activities []map[string]interface{} `json:"activities"`
+	Archives   int                      `json:"archives"` // read to answer the query
+	Truncated  bool                     `json:"truncated"`
+}
@@ -43,6 +43,31 @@ type SystemSettings struct {
 	AllowedFileTypes       []string `json:"allowed_file_types"`
 }
 
+// RuntimeSettingOverride replaces the environment value of a runtime setting
+// until it is reset
+type RuntimeSettingOverride struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Key       string             `bson:"key" json:"key"`
+	Value     interface{}        `bson:"value" json:"value"`
+	UpdatedBy primitive.ObjectID `bson:"updated_by" json:"updated_by"`
+	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// RuntimeSetting is a setting which takes effect without a restart, with the
+// value in use and the environment value it falls back to
+type RuntimeSetting struct {
+	Key         string      `json:"key"`
+	Type        string      `json:"type"` // string, int, bool
+	Group       string      `json:"group"`
+	Label       string      `json:"label"`
+	Description string      `json:"description"`
+	Rules       []string    `json:"rules,omitempty"`
+	Value       interface{} `json:"value"`
+	Default     interface{} `json:"default"`
+	Overridden  bool        `json:"overridden"`
+	UpdatedAt   *time.Time  `json:"updated_at,omitempty"`
+}
+
 type Admin struct {
 	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
 	Username    string             `bson:"username" json:"username" validate:"required"`
@@ -0,0 +1,67 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Who an announcement is shown to
+const (
+	AnnouncementAudienceAll  = "all"
+	AnnouncementAudiencePlan = "plan"
+	AnnouncementAudienceOrg  = "org" // the members of a SCIM directory group, an organization
+)
+
+// Announcement is a banner admins show users from StartsAt until EndsAt,
+// about maintenance, new features or anything else. It can also be emailed
+// to its audience once it starts.
+type Announcement struct {
+	ID          primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	Title       string              `bson:"title" json:"title"`
+	Message     string              `bson:"message" json:"message"`
+	Kind        string              `bson:"kind" json:"kind"` // info, feature, maintenance, warning
+	LinkURL     string              `bson:"link_url,omitempty" json:"link_url,omitempty"`
+	Audience    string              `bson:"audience" json:"audience"`
+	PlanID      *primitive.ObjectID `bson:"plan_id,omitempty" json:"plan_id,omitempty"`
+	GroupID     *primitive.ObjectID `bson:"group_id,omitempty" json:"group_id,omitempty"`
+	Dismissible bool                `bson:"dismissible" json:"dismissible"`
+	StartsAt    time.Time           `bson:"starts_at" json:"starts_at"`
+	EndsAt      *time.Time          `bson:"ends_at,omitempty" json:"ends_at,omitempty"`
+
+	// SendEmail emails the announcement to its audience once it starts
+	SendEmail    bool       `bson:"send_email" json:"send_email"`
+	EmailedAt    *time.Time `bson:"emailed_at,omitempty" json:"emailed_at,omitempty"`
+	EmailedCount int        `bson:"emailed_count" json:"emailed_count"`
+
+	CreatedBy primitive.ObjectID `bson:"created_by" json:"created_by"`
+	UpdatedBy primitive.ObjectID `bson:"updated_by" json:"updated_by"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// AnnouncementRequest creates or replaces an announcement. PlanID is
+// required for plan announcements and GroupID for org announcements. Without
+// StartsAt the announcement starts right away, and without EndsAt it runs
+// until it is deleted.
+type AnnouncementRequest struct {
+	Title       string     `json:"title" validate:"required,max=100"`
+	Message     string     `json:"message" validate:"required,max=2000"`
+	Kind        string     `json:"kind" validate:"omitempty,oneof=info feature maintenance warning"`
+	LinkURL     string     `json:"link_url" validate:"omitempty,url,max=500"`
+	Audience    string     `json:"audience" validate:"required,oneof=all plan org"`
+	PlanID      string     `json:"plan_id" validate:"omitempty,objectid"`
+	GroupID     string     `json:"group_id" validate:"omitempty,objectid"`
+	Dismissible *bool      `json:"dismissible"`
+	StartsAt    *time.Time `json:"starts_at"`
+	EndsAt      *time.Time `json:"ends_at"`
+	SendEmail   bool       `json:"send_email"`
+}
+
+// AnnouncementDismissal records that a user closed an announcement's banner
+type AnnouncementDismissal struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	AnnouncementID primitive.ObjectID `bson:"announcement_id" json:"announcement_id"`
+	UserID         primitive.ObjectID `bson:"user_id" json:"user_id"`
+	DismissedAt    time.Time          `bson:"dismissed_at" json:"dismissed_at"`
+}
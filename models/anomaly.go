@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Anomaly struct {
+	ID              primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	Type            string              `bson:"type" json:"type"`         // download_spike, failed_login_spike, error_rate_spike, storage_growth_spike
+	Severity        string              `bson:"severity" json:"severity"` // warning, critical
+	Subject         string              `bson:"subject" json:"subject"`   // "global" or a user ID
+	UserID          *primitive.ObjectID `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	Message         string              `bson:"message" json:"message"`
+	Value           float64             `bson:"value" json:"value"`
+	Baseline        float64             `bson:"baseline" json:"baseline"`
+	StdDev          float64             `bson:"std_dev" json:"std_dev"`
+	Threshold       float64             `bson:"threshold" json:"threshold"`
+	WindowStart     time.Time           `bson:"window_start" json:"window_start"`
+	WindowEnd       time.Time           `bson:"window_end" json:"window_end"`
+	Status          string              `bson:"status" json:"status"` // active, acknowledged, resolved
+	Occurrences     int                 `bson:"occurrences" json:"occurrences"`
+	FirstDetectedAt time.Time           `bson:"first_detected_at" json:"first_detected_at"`
+	LastDetectedAt  time.Time           `bson:"last_detected_at" json:"last_detected_at"`
+	AcknowledgedBy  *primitive.ObjectID `bson:"acknowledged_by,omitempty" json:"acknowledged_by,omitempty"`
+	AcknowledgedAt  *time.Time          `bson:"acknowledged_at,omitempty" json:"acknowledged_at,omitempty"`
+	ResolvedAt      *time.Time          `bson:"resolved_at,omitempty" json:"resolved_at,omitempty"`
+}
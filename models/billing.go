@@ -0,0 +1,86 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Refund statuses. A refund is pending while the payment gateway is asked
+// to make it, and failed when the gateway refused it.
+const (
+	RefundPending   = "pending"
+	RefundSucceeded = "succeeded"
+	RefundFailed    = "failed"
+)
+
+// Refund is money given back from a payment through the payment gateway
+type Refund struct {
+	ID              primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	PaymentID       primitive.ObjectID  `bson:"payment_id" json:"payment_id"`
+	UserID          primitive.ObjectID  `bson:"user_id" json:"user_id"`
+	InvoiceID       *primitive.ObjectID `bson:"invoice_id,omitempty" json:"invoice_id,omitempty"`
+	Amount          float64             `bson:"amount" json:"amount"`
+	Currency        string              `bson:"currency" json:"currency"`
+	Full            bool                `bson:"full" json:"full"` // whether it gives back all that was left of the payment
+	Reason          string              `bson:"reason" json:"reason"`
+	Note            string              `bson:"note,omitempty" json:"note,omitempty"`
+	Status          string              `bson:"status" json:"status"`
+	Gateway         string              `bson:"gateway" json:"gateway"`
+	GatewayRefundID string              `bson:"gateway_refund_id,omitempty" json:"gateway_refund_id,omitempty"`
+	FailureReason   string              `bson:"failure_reason,omitempty" json:"failure_reason,omitempty"`
+	IssuedBy        primitive.ObjectID  `bson:"issued_by" json:"issued_by"`
+	CreatedAt       time.Time           `bson:"created_at" json:"created_at"`
+	UpdatedAt       time.Time           `bson:"updated_at" json:"updated_at"`
+}
+
+// RefundRequest refunds a payment, all that is left of it unless an amount
+// is given
+type RefundRequest struct {
+	Amount float64 `json:"amount" validate:"omitempty,gt=0"`
+	Reason string  `json:"reason" validate:"omitempty,oneof=requested_by_customer duplicate fraudulent"`
+	Note   string  `json:"note" validate:"max=500"`
+}
+
+// Dispute statuses. A dispute is open until the payer's bank decides it.
+const (
+	DisputeOpen = "open"
+	DisputeWon  = "won"
+	DisputeLost = "lost"
+)
+
+// Dispute is a chargeback: the payer asked their bank to take a payment
+// back. Its user is flagged, and their service paused as the chargeback
+// policy says, until the dispute is won or an admin releases it.
+type Dispute struct {
+	ID               primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	Gateway          string              `bson:"gateway" json:"gateway"`
+	GatewayDisputeID string              `bson:"gateway_dispute_id" json:"gateway_dispute_id"`
+	TransactionID    string              `bson:"transaction_id" json:"transaction_id"` // the gateway's charge disputed
+	PaymentID        *primitive.ObjectID `bson:"payment_id,omitempty" json:"payment_id,omitempty"`
+	UserID           *primitive.ObjectID `bson:"user_id,omitempty" json:"user_id,omitempty"` // none when the payment is not known
+	Amount           float64             `bson:"amount" json:"amount"`
+	Currency         string              `bson:"currency" json:"currency"`
+	Reason           string              `bson:"reason" json:"reason"` // the bank's, such as fraudulent
+	Status           string              `bson:"status" json:"status"`
+	GatewayStatus    string              `bson:"gateway_status" json:"gateway_status"` // such as needs_response
+	AccountPaused    bool                `bson:"account_paused" json:"account_paused"`
+	OpenedAt         time.Time           `bson:"opened_at" json:"opened_at"`
+	ClosedAt         *time.Time          `bson:"closed_at,omitempty" json:"closed_at,omitempty"`
+	ReleasedBy       *primitive.ObjectID `bson:"released_by,omitempty" json:"released_by,omitempty"`
+	ReleasedAt       *time.Time          `bson:"released_at,omitempty" json:"released_at,omitempty"`
+	CreatedAt        time.Time           `bson:"created_at" json:"created_at"`
+	UpdatedAt        time.Time           `bson:"updated_at" json:"updated_at"`
+}
+
+// NetRevenue is what payments brought in over a period once refunds and
+// lost chargebacks are taken off
+type NetRevenue struct {
+	Currency    string  `json:"currency"`
+	Gross       float64 `json:"gross"`
+	Refunded    float64 `json:"refunded"`
+	Refunds     int64   `json:"refunds"`
+	ChargedBack float64 `json:"charged_back"`
+	Chargebacks int64   `json:"chargebacks"`
+	Net         float64 `json:"net"`
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Branding is how the deployment presents itself in the admin panel, on
+// share pages and in emails. Empty fields fall back to the defaults.
+type Branding struct {
+	ProductName   string             `bson:"product_name" json:"product_name"`
+	LogoURL       string             `bson:"logo_url" json:"logo_url"`
+	PrimaryColor  string             `bson:"primary_color" json:"primary_color"`
+	AccentColor   string             `bson:"accent_color" json:"accent_color"`
+	EmailFooter   string             `bson:"email_footer" json:"email_footer"`
+	SharePageHTML string             `bson:"share_page_html" json:"share_page_html"`
+	UpdatedBy     primitive.ObjectID `bson:"updated_by,omitempty" json:"updated_by,omitempty"`
+	UpdatedAt     *time.Time         `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
+}
+
+// BrandingRequest replaces the branding. SharePageHTML is shown on share
+// pages as given, so only admins can set it.
+type BrandingRequest struct {
+	ProductName   string `json:"product_name" validate:"max=100"`
+	LogoURL       string `json:"logo_url" validate:"omitempty,url,max=2048"`
+	PrimaryColor  string `json:"primary_color" validate:"omitempty,hexcolor"`
+	AccentColor   string `json:"accent_color" validate:"omitempty,hexcolor"`
+	EmailFooter   string `json:"email_footer" validate:"max=2000"`
+	SharePageHTML string `json:"share_page_html" validate:"max=65536"`
+}
+
+// EmailMessage is a rendered notification email
+type EmailMessage struct {
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+	HTML    string `json:"html"`
+}
+
+// EmailSection is a table of figures shown below the text of an email, such
+// as a storage report
+type EmailSection struct {
+	Title string     `json:"title"`
+	Rows  []EmailRow `json:"rows"`
+}
+
+type EmailRow struct {
+	Label string `json:"label"`
+	Value string `json:"value"`
+}
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Bulk operations on files and folders
+const (
+	BulkDeleteFiles   = "files.delete"
+	BulkMoveFiles     = "files.move"
+	BulkCopyFiles     = "files.copy"
+	BulkShareFiles    = "files.share"
+	BulkDeleteFolders = "folders.delete"
+	BulkMoveFolders   = "folders.move"
+	BulkCopyFolders   = "folders.copy"
+	BulkShareFolders  = "folders.share"
+)
+
+const (
+	BulkJobRunning   = "running"
+	BulkJobCompleted = "completed"
+	BulkJobFailed    = "failed"
+)
+
+// BulkJob runs a bulk operation too large to finish within a request in the
+// background. Items holds the outcome of each item in the order they
+// finished, so clients can follow progress by asking for the items after
+// the ones they have seen.
+type BulkJob struct {
+	ID          primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
+	UserID      primitive.ObjectID   `bson:"user_id" json:"user_id"`
+	Operation   string               `bson:"operation" json:"operation"`
+	Status      string               `bson:"status" json:"status"`
+	ItemIDs     []primitive.ObjectID `bson:"item_ids" json:"-"`
+	DestID      string               `bson:"dest_id,omitempty" json:"dest_id,omitempty"`
+	ShareData   *ShareRequest        `bson:"share_data,omitempty" json:"-"`
+	Total       int                  `bson:"total" json:"total"`
+	Succeeded   int                  `bson:"succeeded" json:"succeeded"`
+	Failed      int                  `bson:"failed" json:"failed"`
+	Items       []BulkItemResult     `bson:"items" json:"items"`
+	Error       string               `bson:"error,omitempty" json:"error,omitempty"`
+	CreatedAt   time.Time            `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time            `bson:"updated_at" json:"updated_at"`
+	CompletedAt *time.Time           `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+}
+
+// BulkItemResult is the outcome of a bulk operation on one item. Token is
+// the share token of a shared item.
+type BulkItemResult struct {
+	ID    primitive.ObjectID `bson:"id" json:"id"`
+	Error string             `bson:"error,omitempty" json:"error,omitempty"`
+	Token string             `bson:"token,omitempty" json:"token,omitempty"`
+}
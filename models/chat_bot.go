@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ChatAccount is a Telegram or Slack account linked to a user, which can
+// send files to the bot and ask it for share links
+type ChatAccount struct {
+	ID             primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	UserID         primitive.ObjectID  `bson:"user_id" json:"user_id"`
+	Platform       string              `bson:"platform" json:"platform"` // telegram, slack
+	ExternalUserID string              `bson:"external_user_id" json:"external_user_id"`
+	ExternalName   string              `bson:"external_name" json:"external_name"`
+	FolderID       *primitive.ObjectID `bson:"folder_id,omitempty" json:"folder_id,omitempty"`
+	LastUsedAt     *time.Time          `bson:"last_used_at,omitempty" json:"last_used_at,omitempty"`
+	CreatedAt      time.Time           `bson:"created_at" json:"created_at"`
+	UpdatedAt      time.Time           `bson:"updated_at" json:"updated_at"`
+}
+
+// ChatLinkCode is a short-lived code a user sends to the bot to link their
+// chat account
+type ChatLinkCode struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"-"`
+	Code      string             `bson:"code" json:"code"`
+	ExpiresAt time.Time          `bson:"expires_at" json:"expires_at"`
+	CreatedAt time.Time          `bson:"created_at" json:"-"`
+}
+
+// ChatAccountUpdateRequest changes where files sent to the bot are saved;
+// an empty folder_id saves into the root
+type ChatAccountUpdateRequest struct {
+	FolderID string `json:"folder_id"`
+}
@@ -0,0 +1,115 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// CloudConnection is a user's linked Dropbox, Google Drive or OneDrive
+// account. Its tokens are stored encrypted.
+type CloudConnection struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID         primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Provider       string             `bson:"provider" json:"provider"` // dropbox, google_drive, onedrive
+	AccountID      string             `bson:"account_id" json:"account_id"`
+	AccountEmail   string             `bson:"account_email" json:"account_email"`
+	AccountName    string             `bson:"account_name" json:"account_name"`
+	AccessToken    string             `bson:"access_token" json:"-"`
+	RefreshToken   string             `bson:"refresh_token" json:"-"`
+	TokenExpiresAt *time.Time         `bson:"token_expires_at,omitempty" json:"-"`
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt      time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// CloudItem is a file or folder in a connected cloud account
+type CloudItem struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Path       string     `json:"path,omitempty"`
+	IsFolder   bool       `json:"is_folder"`
+	Size       int64      `json:"size"`
+	MimeType   string     `json:"mime_type,omitempty"`
+	MD5        string     `json:"-"`
+	ModifiedAt *time.Time `json:"modified_at,omitempty"`
+}
+
+// CloudImportSource is a file or folder picked for import, as listed by
+// browsing the connection
+type CloudImportSource struct {
+	ID       string `bson:"id" json:"id" validate:"required"`
+	Name     string `bson:"name" json:"name" validate:"required"`
+	IsFolder bool   `bson:"is_folder" json:"is_folder"`
+	Size     int64  `bson:"size" json:"size"`
+	MimeType string `bson:"mime_type,omitempty" json:"mime_type,omitempty"`
+}
+
+// CloudImportProgress counts what an import job has done so far. Skipped
+// files were already in the user's storage. DownloadedBytes tracks a URL
+// import's download, whose TotalBytes is only known when the server sends
+// a length.
+type CloudImportProgress struct {
+	TotalFiles      int   `bson:"total_files" json:"total_files"`
+	TotalBytes      int64 `bson:"total_bytes" json:"total_bytes"`
+	DownloadedBytes int64 `bson:"downloaded_bytes" json:"downloaded_bytes"`
+	ImportedFiles   int   `bson:"imported_files" json:"imported_files"`
+	ImportedBytes   int64 `bson:"imported_bytes" json:"imported_bytes"`
+	SkippedFiles    int   `bson:"skipped_files" json:"skipped_files"`
+	FailedFiles     int   `bson:"failed_files" json:"failed_files"`
+}
+
+// CloudImportError records a file that could not be imported
+type CloudImportError struct {
+	Path    string `bson:"path" json:"path"`
+	Message string `bson:"message" json:"message"`
+}
+
+// CloudImportJob copies files from a cloud connection, or a single file
+// from a URL, into the user's storage in the background
+type CloudImportJob struct {
+	ID           primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	UserID       primitive.ObjectID  `bson:"user_id" json:"user_id"`
+	ConnectionID *primitive.ObjectID `bson:"connection_id,omitempty" json:"connection_id,omitempty"`
+	Provider     string              `bson:"provider" json:"provider"` // a cloud provider, or url
+	Sources      []CloudImportSource `bson:"sources" json:"sources"`
+	SourceURL    string              `bson:"source_url,omitempty" json:"source_url,omitempty"`
+	FileName     string              `bson:"file_name,omitempty" json:"file_name,omitempty"`
+	FolderID     *primitive.ObjectID `bson:"folder_id,omitempty" json:"folder_id,omitempty"`
+	Status       string              `bson:"status" json:"status"` // pending, running, completed, failed, cancelled
+	Progress     CloudImportProgress `bson:"progress" json:"progress"`
+	Errors       []CloudImportError  `bson:"errors" json:"errors"`
+	Error        string              `bson:"error,omitempty" json:"error,omitempty"`
+	CreatedAt    time.Time           `bson:"created_at" json:"created_at"`
+	UpdatedAt    time.Time           `bson:"updated_at" json:"updated_at"`
+	StartedAt    *time.Time          `bson:"started_at,omitempty" json:"started_at,omitempty"`
+	CompletedAt  *time.Time          `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+}
+
+// CloudAuthorizeResponse starts linking a cloud account: the user is sent
+// to AuthURL and the provider redirects back with a code and State
+type CloudAuthorizeResponse struct {
+	AuthURL string `json:"auth_url"`
+	State   string `json:"state"`
+}
+
+// CloudConnectRequest completes linking a cloud account with the code the
+// provider redirected back with
+type CloudConnectRequest struct {
+	Code  string `json:"code" validate:"required"`
+	State string `json:"state" validate:"required"`
+}
+
+// CloudImportRequest starts an import job
+type CloudImportRequest struct {
+	ConnectionID string              `json:"connection_id" validate:"required"`
+	Sources      []CloudImportSource `json:"sources" validate:"required,min=1,max=100,dive"`
+	FolderID     string              `json:"folder_id"`
+}
+
+// URLImportRequest starts fetching a file from a URL. Without a name the
+// file is named after the server's Content-Disposition header or the URL.
+type URLImportRequest struct {
+	URL      string `json:"url" validate:"required,url,max=2048"`
+	FolderID string `json:"folder_id"`
+	Name     string `json:"name" validate:"max=255"`
+}
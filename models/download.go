@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type DownloadToken struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	FileID         primitive.ObjectID `bson:"file_id" json:"file_id"`
+	UserID         primitive.ObjectID `bson:"user_id" json:"user_id"`
+	FileSize       int64              `bson:"file_size" json:"file_size"`
+	BytesServed    int64              `bson:"bytes_served" json:"bytes_served"`
+	HighestOffset  int64              `bson:"highest_offset" json:"highest_offset"` // furthest byte delivered, for resume hints
+	Requests       int                `bson:"requests" json:"requests"`
+	IsRevoked      bool               `bson:"is_revoked" json:"is_revoked"`
+	IsCompleted    bool               `bson:"is_completed" json:"is_completed"`
+	Proxied        bool               `bson:"proxied,omitempty" json:"-"` // issued by the server to serve a compressed file decompressed
+	ExpiresAt      time.Time          `bson:"expires_at" json:"expires_at"`
+	LastAccessedAt *time.Time         `bson:"last_accessed_at,omitempty" json:"last_accessed_at,omitempty"`
+	RevokedAt      *time.Time         `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+}
+
+type DownloadTokenResponse struct {
+	Token       string         `json:"token"`
+	DownloadURL string         `json:"download_url"`
+	Download    *DownloadToken `json:"download"`
+}
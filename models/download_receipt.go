@@ -0,0 +1,66 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// FileReceipts marks a file whose every download is receipted. SHA256 is the
+// digest of the content stored under StorageKey, kept so that receipts need
+// not read the file again.
+type FileReceipts struct {
+	SHA256     string    `bson:"sha256" json:"sha256"`
+	StorageKey string    `bson:"storage_key" json:"-"`
+	EnabledAt  time.Time `bson:"enabled_at" json:"enabled_at"`
+}
+
+// ReceiptDownloader identifies who downloaded a file
+type ReceiptDownloader struct {
+	Type      string `bson:"type" json:"type"` // user, share, public, download_token
+	UserID    string `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	Email     string `bson:"email,omitempty" json:"email,omitempty"`
+	ShareID   string `bson:"share_id,omitempty" json:"share_id,omitempty"`
+	IPAddress string `bson:"ip_address" json:"ip_address"`
+	UserAgent string `bson:"user_agent,omitempty" json:"user_agent,omitempty"`
+}
+
+// DownloadReceiptPayload is the document a receipt signs. Its JSON encoding
+// is stored as the receipt's payload and is what verifiers check.
+type DownloadReceiptPayload struct {
+	ReceiptID    string            `json:"receipt_id"`
+	FileID       string            `json:"file_id"`
+	FileName     string            `json:"file_name"`
+	FileSize     int64             `json:"file_size"`
+	SHA256       string            `json:"sha256"`
+	Downloader   ReceiptDownloader `json:"downloader"`
+	DownloadedAt time.Time         `json:"downloaded_at"`
+	KeyID        string            `json:"key_id"`
+}
+
+// DownloadReceipt is the signed record of one download of a file with
+// receipts enabled. Signature is the base64 Ed25519 signature of Payload.
+type DownloadReceipt struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	FileID       primitive.ObjectID `bson:"file_id" json:"file_id"`
+	OwnerID      primitive.ObjectID `bson:"owner_id" json:"owner_id"`
+	SHA256       string             `bson:"sha256" json:"sha256"`
+	Downloader   ReceiptDownloader  `bson:"downloader" json:"downloader"`
+	DownloadedAt time.Time          `bson:"downloaded_at" json:"downloaded_at"`
+	KeyID        string             `bson:"key_id" json:"key_id"`
+	Payload      string             `bson:"payload" json:"payload"`
+	Signature    string             `bson:"signature" json:"signature"`
+}
+
+// DownloadReceiptKey is the public key receipts are verified with
+type DownloadReceiptKey struct {
+	KeyID     string `json:"key_id"`
+	Algorithm string `json:"algorithm"`
+	PublicKey string `json:"public_key"` // base64 of the raw 32 byte key
+	PEM       string `json:"pem"`
+}
+
+// DownloadReceiptsRequest turns receipts for a file on or off
+type DownloadReceiptsRequest struct {
+	Enabled bool `json:"enabled"`
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// EmailInbox is a user's inbound email address. Attachments of emails sent
+// to it are saved into FolderID, or the root, when the sender is allowed.
+type EmailInbox struct {
+	ID             primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	UserID         primitive.ObjectID  `bson:"user_id" json:"user_id"`
+	Token          string              `bson:"token" json:"-"`
+	Address        string              `bson:"-" json:"address"`
+	FolderID       *primitive.ObjectID `bson:"folder_id,omitempty" json:"folder_id,omitempty"`
+	AllowedSenders []string            `bson:"allowed_senders" json:"allowed_senders"` // addresses or @domains; empty allows only the account's email
+	IsEnabled      bool                `bson:"is_enabled" json:"is_enabled"`
+	ReceivedEmails int                 `bson:"received_emails" json:"received_emails"`
+	ReceivedFiles  int                 `bson:"received_files" json:"received_files"`
+	LastReceivedAt *time.Time          `bson:"last_received_at,omitempty" json:"last_received_at,omitempty"`
+	LastError      string              `bson:"last_error,omitempty" json:"last_error,omitempty"`
+	CreatedAt      time.Time           `bson:"created_at" json:"created_at"`
+	UpdatedAt      time.Time           `bson:"updated_at" json:"updated_at"`
+}
+
+// EmailInboxUpdateRequest changes inbox settings. Fields left out are kept;
+// an empty folder_id saves into the root.
+type EmailInboxUpdateRequest struct {
+	FolderID       *string  `json:"folder_id"`
+	AllowedSenders []string `json:"allowed_senders" validate:"omitempty,max=50,dive,min=3,max=254"`
+	IsEnabled      *bool    `json:"is_enabled"`
+}
+
+// InboundEmailResult reports what was done with an inbound email
+type InboundEmailResult struct {
+	SavedFiles    int      `json:"saved_files"`
+	RejectedFiles int      `json:"rejected_files"`
+	Errors        []string `json:"errors,omitempty"`
+}
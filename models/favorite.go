@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// FavoriteItem is a favorite file or folder in the combined favorites list
+type FavoriteItem struct {
+	ID           primitive.ObjectID  `bson:"_id" json:"id"`
+	Type         string              `bson:"type" json:"type"` // file, folder
+	Name         string              `bson:"name" json:"name"`
+	ParentID     *primitive.ObjectID `bson:"parent_id,omitempty" json:"parent_id,omitempty"`
+	Path         string              `bson:"path" json:"path"`
+	Size         int64               `bson:"size" json:"size"`
+	MimeType     string              `bson:"mime_type,omitempty" json:"mime_type,omitempty"`
+	Extension    string              `bson:"extension,omitempty" json:"extension,omitempty"`
+	ThumbnailURL string              `bson:"thumbnail_url,omitempty" json:"thumbnail_url,omitempty"`
+	Color        string              `bson:"color,omitempty" json:"color,omitempty"`
+	FilesCount   int                 `bson:"files_count,omitempty" json:"files_count,omitempty"`
+	Tags         []string            `bson:"tags" json:"tags"`
+	FavoritedAt  *time.Time          `bson:"favorited_at,omitempty" json:"favorited_at,omitempty"`
+	CreatedAt    time.Time           `bson:"created_at" json:"created_at"`
+	UpdatedAt    time.Time           `bson:"updated_at" json:"updated_at"`
+}
+
+// FavoriteRequest stars a file or folder
+type FavoriteRequest struct {
+	Type string `json:"type" validate:"required,oneof=file folder"`
+	ID   string `json:"id" validate:"required"`
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// FeatureFlag turns a feature on for some users while it is rolled out.
+// A disabled flag is off for everyone. An enabled one is on for the users,
+// plans and directory groups (organizations) it lists, and for the
+// rollout percentage of everyone else; each user falls in the same place of
+// the rollout every time, so raising the percentage only adds users.
+type FeatureFlag struct {
+	ID          primitive.ObjectID   `bson:"_id,omitempty" json:"id,omitempty"`
+	Key         string               `bson:"key" json:"key"`
+	Description string               `bson:"description" json:"description"`
+	Enabled     bool                 `bson:"enabled" json:"enabled"`
+	Percentage  int                  `bson:"percentage" json:"percentage"` // 0 to 100
+	UserIDs     []primitive.ObjectID `bson:"user_ids" json:"user_ids"`
+	PlanIDs     []primitive.ObjectID `bson:"plan_ids" json:"plan_ids"`
+	GroupIDs    []primitive.ObjectID `bson:"group_ids" json:"group_ids"`
+	Builtin     bool                 `bson:"-" json:"builtin"`    // gates a feature of the server rather than of a frontend
+	IsDefault   bool                 `bson:"-" json:"is_default"` // a built-in flag an admin has not set
+	UpdatedBy   *primitive.ObjectID  `bson:"updated_by,omitempty" json:"updated_by,omitempty"`
+	CreatedAt   time.Time            `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time            `bson:"updated_at" json:"updated_at"`
+}
+
+// FeatureFlagRequest sets a feature flag, creating it if needed
+type FeatureFlagRequest struct {
+	Description string   `json:"description" validate:"max=500"`
+	Enabled     bool     `json:"enabled"`
+	Percentage  int      `json:"percentage" validate:"min=0,max=100"`
+	UserIDs     []string `json:"user_ids" validate:"omitempty,max=1000,dive,objectid"`
+	PlanIDs     []string `json:"plan_ids" validate:"omitempty,max=100,dive,objectid"`
+	GroupIDs    []string `json:"group_ids" validate:"omitempty,max=100,dive,objectid"`
+}
@@ -18,15 +18,20 @@ type File struct {
 	Size            int64                  `bson:"size" json:"size"`
 	MimeType        string                 `bson:"mime_type" json:"mime_type"`
 	Extension       string                 `bson:"extension" json:"extension"`
-	Hash            string                 `bson:"hash" json:"hash"` // for duplicate detection
+	Hash            string                 `bson:"hash" json:"hash"`                         // for duplicate detection
+	SHA256          string                 `bson:"sha256,omitempty" json:"sha256,omitempty"` // for instant uploads
 	StorageProvider string                 `bson:"storage_provider" json:"storage_provider"`
 	StorageKey      string                 `bson:"storage_key" json:"storage_key"`
 	StorageBucket   string                 `bson:"storage_bucket" json:"storage_bucket"`
+	StorageClass    string                 `bson:"storage_class,omitempty" json:"storage_class,omitempty"` // the provider's storage class, if one was chosen
+	Compression     *FileCompression       `bson:"compression,omitempty" json:"compression,omitempty"`     // set when the stored object is compressed
+	Restore         *FileRestore           `bson:"restore,omitempty" json:"restore,omitempty"`             // of an archived file's readable copy
 	PublicURL       string                 `bson:"public_url" json:"public_url"`
 	ThumbnailURL    string                 `bson:"thumbnail_url" json:"thumbnail_url"`
 	IsPublic        bool                   `bson:"is_public" json:"is_public"`
 	IsShared        bool                   `bson:"is_shared" json:"is_shared"`
 	IsFavorite      bool                   `bson:"is_favorite" json:"is_favorite"`
+	FavoritedAt     *time.Time             `bson:"favorited_at,omitempty" json:"favorited_at,omitempty"`
 	IsDeleted       bool                   `bson:"is_deleted" json:"is_deleted"`
 	Downloads       int                    `bson:"downloads" json:"downloads"`
 	Views           int                    `bson:"views" json:"views"`
@@ -34,9 +39,22 @@ type File struct {
 	ShareExpiresAt  *time.Time             `bson:"share_expires_at,omitempty" json:"share_expires_at,omitempty"`
 	Tags            []string               `bson:"tags" json:"tags"`
 	Metadata        map[string]interface{} `bson:"metadata" json:"metadata"`
+	CustomMetadata  map[string]interface{} `bson:"custom_metadata,omitempty" json:"custom_metadata,omitempty"`
+	MetadataIndex   []MetadataIndexEntry   `bson:"metadata_index,omitempty" json:"-"`
+	Media           *MediaMetadata         `bson:"media,omitempty" json:"media,omitempty"`
 	CreatedAt       time.Time              `bson:"created_at" json:"created_at"`
 	UpdatedAt       time.Time              `bson:"updated_at" json:"updated_at"`
 	DeletedAt       *time.Time             `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+	DeletionID      *primitive.ObjectID    `bson:"deletion_id,omitempty" json:"-"` // the folder deleted along with it
+	Lock            *FileLock              `bson:"lock,omitempty" json:"lock,omitempty"`
+	Scan            *FileScan              `bson:"scan,omitempty" json:"scan,omitempty"`
+	TypeCheck       *FileTypeCheck         `bson:"type_check,omitempty" json:"type_check,omitempty"`
+	ExpiresAt       *time.Time             `bson:"expires_at,omitempty" json:"expires_at,omitempty"`             // the file is inaccessible after, and moved to the trash
+	ExpiryWarnedAt  *time.Time             `bson:"expiry_warned_at,omitempty" json:"expiry_warned_at,omitempty"` // when the owner was warned of the expiry
+	TakedownID      *primitive.ObjectID    `bson:"takedown_id,omitempty" json:"takedown_id,omitempty"`
+	Receipts        *FileReceipts          `bson:"receipts,omitempty" json:"receipts,omitempty"`
+	Uploader        *FileUploader          `bson:"uploader,omitempty" json:"uploader,omitempty"`             // the guest who sent it through a file request
+	ContributorID   *primitive.ObjectID    `bson:"contributor_id,omitempty" json:"contributor_id,omitempty"` // the member who uploaded it into a shared folder
 }
 
 type FileShare struct {
@@ -44,12 +62,31 @@ type FileShare struct {
 	FileID       primitive.ObjectID `bson:"file_id" json:"file_id"`
 	UserID       primitive.ObjectID `bson:"user_id" json:"user_id"`
 	Token        string             `bson:"token" json:"token"`
+	Slug         string             `bson:"slug,omitempty" json:"slug,omitempty"` // the custom name of a short link, /s/{slug}
 	Password     string             `bson:"password" json:"password,omitempty"`
 	Downloads    int                `bson:"downloads" json:"downloads"`
 	MaxDownloads int                `bson:"max_downloads" json:"max_downloads"`
 	ExpiresAt    *time.Time         `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
 	IsActive     bool               `bson:"is_active" json:"is_active"`
 	CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
+
+	// Watermark burns the recipient's email and the time into PDFs and
+	// images downloaded through the share
+	Watermark bool `bson:"watermark" json:"watermark"`
+
+	// ViewOnly serves documents and images through the share as watermarked
+	// page images, never the file itself
+	ViewOnly bool `bson:"view_only" json:"view_only"`
+
+	// Embed is the hotlink protection of the share's embeds, which count
+	// their views apart from downloads
+	Embed           *ShareEmbed `bson:"embed,omitempty" json:"embed,omitempty"`
+	EmbedViews      int         `bson:"embed_views" json:"embed_views"`
+	EmbedBlocked    int         `bson:"embed_blocked" json:"embed_blocked"`
+	LastEmbedViewAt *time.Time  `bson:"last_embed_view_at,omitempty" json:"last_embed_view_at,omitempty"`
+
+	// FileScan is the scan of the shared file, shown to its owner
+	FileScan *FileScan `bson:"-" json:"file_scan,omitempty"`
 }
 
 type FileVersion struct {
@@ -59,5 +96,140 @@ type FileVersion struct {
 	Size          int64              `bson:"size" json:"size"`
 	StorageKey    string             `bson:"storage_key" json:"storage_key"`
 	Hash          string             `bson:"hash" json:"hash"`
+	Compression   *FileCompression   `bson:"compression,omitempty" json:"compression,omitempty"`
 	CreatedAt     time.Time          `bson:"created_at" json:"created_at"`
 }
+
+// FileCompression is how a file's stored object is compressed at rest. The
+// file is decompressed whenever it is read, so its size and hash are those of
+// the original content.
+type FileCompression struct {
+	Encoding     string `bson:"encoding" json:"encoding"` // gzip or zstd
+	OriginalSize int64  `bson:"original_size" json:"original_size"`
+	StoredSize   int64  `bson:"stored_size" json:"stored_size"`
+}
+
+// CompressionSavings is the space compression at rest saves for one user or
+// storage provider. Key is the user's ID or the provider's type.
+type CompressionSavings struct {
+	Key           string `json:"key"`
+	Email         string `json:"email,omitempty"`
+	Files         int64  `json:"files"`
+	OriginalBytes int64  `json:"original_bytes"`
+	StoredBytes   int64  `json:"stored_bytes"`
+	SavedBytes    int64  `json:"saved_bytes"`
+}
+
+// CompressionSavingsReport is the space compression at rest saves, grouped
+// by user or provider
+type CompressionSavingsReport struct {
+	GroupBy  string               `json:"group_by"`
+	Encoding string               `json:"encoding"` // used for new files, empty when off
+	Total    CompressionSavings   `json:"total"`
+	Groups   []CompressionSavings `json:"groups"`
+}
+
+// MediaMetadata is read from a file's embedded EXIF, ID3 or movie headers
+// when it is uploaded. Only the fields present in the file are set.
+type MediaMetadata struct {
+	Width        int            `bson:"width,omitempty" json:"width,omitempty"`
+	Height       int            `bson:"height,omitempty" json:"height,omitempty"`
+	Orientation  int            `bson:"orientation,omitempty" json:"orientation,omitempty"`
+	CapturedAt   *time.Time     `bson:"captured_at,omitempty" json:"captured_at,omitempty"`
+	CameraMake   string         `bson:"camera_make,omitempty" json:"camera_make,omitempty"`
+	CameraModel  string         `bson:"camera_model,omitempty" json:"camera_model,omitempty"`
+	LensModel    string         `bson:"lens_model,omitempty" json:"lens_model,omitempty"`
+	ExposureTime string         `bson:"exposure_time,omitempty" json:"exposure_time,omitempty"`
+	FNumber      float64        `bson:"f_number,omitempty" json:"f_number,omitempty"`
+	ISO          int            `bson:"iso,omitempty" json:"iso,omitempty"`
+	FocalLength  float64        `bson:"focal_length,omitempty" json:"focal_length,omitempty"`
+	Location     *MediaLocation `bson:"location,omitempty" json:"location,omitempty"`
+	Title        string         `bson:"title,omitempty" json:"title,omitempty"`
+	Artist       string         `bson:"artist,omitempty" json:"artist,omitempty"`
+	Album        string         `bson:"album,omitempty" json:"album,omitempty"`
+	Year         int            `bson:"year,omitempty" json:"year,omitempty"`
+	Duration     float64        `bson:"duration,omitempty" json:"duration,omitempty"` // seconds
+	VideoCodec   string         `bson:"video_codec,omitempty" json:"video_codec,omitempty"`
+	AudioCodec   string         `bson:"audio_codec,omitempty" json:"audio_codec,omitempty"`
+}
+
+// MediaLocation is where a photo or video was taken
+type MediaLocation struct {
+	Latitude  float64  `bson:"latitude" json:"latitude"`
+	Longitude float64  `bson:"longitude" json:"longitude"`
+	Altitude  *float64 `bson:"altitude,omitempty" json:"altitude,omitempty"`
+}
+
+// FileLock is an exclusive edit lock on a file. An expired lock is treated as
+// released. Token identifies the lock to clients such as WebDAV that pass it
+// back with later writes.
+type FileLock struct {
+	Token     string             `bson:"token" json:"-"`
+	LockedBy  primitive.ObjectID `bson:"locked_by" json:"locked_by"`
+	Note      string             `bson:"note,omitempty" json:"note,omitempty"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	ExpiresAt time.Time          `bson:"expires_at" json:"expires_at"`
+}
+
+// Virus scan statuses of a file. Files uploaded while scanning was disabled
+// have no scan at all.
+const (
+	ScanStatusPending  = "pending"
+	ScanStatusClean    = "clean"
+	ScanStatusInfected = "infected"
+	ScanStatusSkipped  = "skipped" // too large to scan
+)
+
+// FileScan is the virus scan of a file's current content. A scan which
+// failed stays pending with Error set until it is retried.
+type FileScan struct {
+	Status      string     `bson:"status" json:"status"`
+	Signature   string     `bson:"signature,omitempty" json:"signature,omitempty"`
+	Error       string     `bson:"error,omitempty" json:"error,omitempty"`
+	RequestedAt time.Time  `bson:"requested_at" json:"requested_at"`
+	ScannedAt   *time.Time `bson:"scanned_at,omitempty" json:"scanned_at,omitempty"`
+}
+
+// Blocked reports whether the content may not be downloaded through share
+// links
+func (s *FileScan) Blocked() bool {
+	return s != nil && (s.Status == ScanStatusPending || s.Status == ScanStatusInfected)
+}
+
+// Type check statuses of a file, comparing its content's magic bytes with
+// its extension
+const (
+	TypeCheckMatch    = "match"
+	TypeCheckMismatch = "mismatch"
+	TypeCheckUnknown  = "unknown" // the content's type or the extension's cannot be told
+)
+
+// FileTypeCheck is what a file's content was found to be when it was
+// uploaded. Mismatched files are only stored when uploads are not rejected
+// for it; disguised executables never are.
+type FileTypeCheck struct {
+	Status       string    `bson:"status" json:"status"`
+	DetectedType string    `bson:"detected_type,omitempty" json:"detected_type,omitempty"` // MIME type of the content
+	Executable   bool      `bson:"executable,omitempty" json:"executable,omitempty"`
+	CheckedAt    time.Time `bson:"checked_at" json:"checked_at"`
+}
+
+// FileExpiryRequest sets when a file expires, at ExpiresAt or ExtendDays
+// after its current expiry (or from now when it has none)
+type FileExpiryRequest struct {
+	ExpiresAt  *time.Time `json:"expires_at"`
+	ExtendDays int        `json:"extend_days" validate:"omitempty,min=1,max=3650"`
+}
+
+// FileLockRequest acquires or refreshes a lock; TTLSeconds defaults to 30 minutes
+type FileLockRequest struct {
+	TTLSeconds int    `json:"ttl_seconds" validate:"omitempty,min=60,max=86400"`
+	Note       string `json:"note" validate:"max=200"`
+}
+
+// FileBatch answers a batch get: the files found, in the order asked for, and
+// the IDs which do not exist or belong to someone else
+type FileBatch struct {
+	Found   []File   `json:"found"`
+	Missing []string `json:"missing"`
+}
@@ -0,0 +1,70 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// FileRequest is a link guests use to upload files into a folder without an
+// account. Every file uploaded through it records the guest who sent it.
+type FileRequest struct {
+	ID               primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID           primitive.ObjectID `bson:"user_id" json:"user_id"`
+	FolderID         primitive.ObjectID `bson:"folder_id" json:"folder_id"`
+	Token            string             `bson:"token" json:"token"`
+	Title            string             `bson:"title" json:"title"`
+	Message          string             `bson:"message,omitempty" json:"message,omitempty"`
+	RequireName      bool               `bson:"require_name" json:"require_name"`
+	RequireEmail     bool               `bson:"require_email" json:"require_email"`
+	MaxFilesPerGuest int                `bson:"max_files_per_guest" json:"max_files_per_guest"` // 0 for no limit
+	MaxBytesPerGuest int64              `bson:"max_bytes_per_guest" json:"max_bytes_per_guest"` // 0 for no limit
+	ExpiresAt        *time.Time         `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+	IsActive         bool               `bson:"is_active" json:"is_active"`
+	Uploads          int                `bson:"uploads" json:"uploads"`
+	BytesReceived    int64              `bson:"bytes_received" json:"bytes_received"`
+	LastUploadAt     *time.Time         `bson:"last_upload_at,omitempty" json:"last_upload_at,omitempty"`
+	CreatedAt        time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt        time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// FileRequestRequest creates a folder's file request or changes it. Fields
+// left out of a change are kept.
+type FileRequestRequest struct {
+	Title            *string    `json:"title" validate:"omitempty,max=200"`
+	Message          *string    `json:"message" validate:"omitempty,max=2000"`
+	RequireName      *bool      `json:"require_name"`
+	RequireEmail     *bool      `json:"require_email"`
+	MaxFilesPerGuest *int       `json:"max_files_per_guest" validate:"omitempty,gte=0"`
+	MaxBytesPerGuest *int64     `json:"max_bytes_per_guest" validate:"omitempty,gte=0"`
+	ExpiresAt        *time.Time `json:"expires_at"`
+	IsActive         *bool      `json:"is_active"`
+}
+
+// FileRequestPage is what guests are shown before uploading
+type FileRequestPage struct {
+	Title            string     `json:"title"`
+	Message          string     `json:"message,omitempty"`
+	FolderName       string     `json:"folder_name"`
+	RequireName      bool       `json:"require_name"`
+	RequireEmail     bool       `json:"require_email"`
+	MaxFilesPerGuest int        `json:"max_files_per_guest"`
+	MaxBytesPerGuest int64      `json:"max_bytes_per_guest"`
+	ExpiresAt        *time.Time `json:"expires_at,omitempty"`
+}
+
+// FileRequestUploadForm is the guest's identity sent along with a file
+type FileRequestUploadForm struct {
+	Name  string `form:"uploader_name"`
+	Email string `form:"uploader_email"`
+}
+
+// FileUploader is the guest who uploaded a file through a file request.
+// GuestKey identifies the guest for per-guest limits: their email, or their
+// IP address when they gave none.
+type FileUploader struct {
+	Name          string             `bson:"name,omitempty" json:"name,omitempty"`
+	Email         string             `bson:"email,omitempty" json:"email,omitempty"`
+	FileRequestID primitive.ObjectID `bson:"file_request_id" json:"file_request_id"`
+	GuestKey      string             `bson:"guest_key" json:"-"`
+}
@@ -0,0 +1,70 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// What a file type policy applies to
+const (
+	FileTypePolicyGlobal = "global"
+	FileTypePolicyPlan   = "plan"
+	FileTypePolicyGroup  = "group" // the members of a SCIM directory group, an organization
+)
+
+// FileTypePolicy blocks uploads of the types it lists for everyone, the
+// users on a plan or the members of a directory group. Extensions and MIME
+// types are those the upload is named with; detected types and executables
+// are told from its content.
+type FileTypePolicy struct {
+	ID               primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	Name             string              `bson:"name" json:"name"`
+	Scope            string              `bson:"scope" json:"scope"`
+	PlanID           *primitive.ObjectID `bson:"plan_id,omitempty" json:"plan_id,omitempty"`
+	GroupID          *primitive.ObjectID `bson:"group_id,omitempty" json:"group_id,omitempty"`
+	IsEnabled        bool                `bson:"is_enabled" json:"is_enabled"`
+	Extensions       []string            `bson:"extensions" json:"extensions"`         // such as .exe
+	MimeTypes        []string            `bson:"mime_types" json:"mime_types"`         // such as application/x-msdownload, or application/* for a family
+	DetectedTypes    []string            `bson:"detected_types" json:"detected_types"` // MIME types, matched against the content's magic bytes
+	BlockExecutables bool                `bson:"block_executables" json:"block_executables"`
+	Message          string              `bson:"message,omitempty" json:"message,omitempty"` // shown to the uploader in place of the default
+	CreatedBy        primitive.ObjectID  `bson:"created_by" json:"created_by"`
+	UpdatedBy        primitive.ObjectID  `bson:"updated_by" json:"updated_by"`
+	CreatedAt        time.Time           `bson:"created_at" json:"created_at"`
+	UpdatedAt        time.Time           `bson:"updated_at" json:"updated_at"`
+}
+
+// FileTypePolicyRequest creates or replaces a file type policy. PlanID is
+// required for plan policies and GroupID for group policies.
+type FileTypePolicyRequest struct {
+	Name             string   `json:"name" validate:"required,max=100"`
+	Scope            string   `json:"scope" validate:"required,oneof=global plan group"`
+	PlanID           string   `json:"plan_id" validate:"omitempty,objectid"`
+	GroupID          string   `json:"group_id" validate:"omitempty,objectid"`
+	IsEnabled        *bool    `json:"is_enabled"`
+	Extensions       []string `json:"extensions" validate:"omitempty,max=200,dive,min=1,max=20"`
+	MimeTypes        []string `json:"mime_types" validate:"omitempty,max=100,dive,min=3,max=100"`
+	DetectedTypes    []string `json:"detected_types" validate:"omitempty,max=100,dive,min=3,max=100"`
+	BlockExecutables bool     `json:"block_executables"`
+	Message          string   `json:"message" validate:"max=500"`
+}
+
+// FileTypeOverride lets a trusted user upload what file type policies
+// block, until it expires. Content that is not what its extension says is
+// still refused.
+type FileTypeOverride struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Reason    string             `bson:"reason" json:"reason"`
+	ExpiresAt *time.Time         `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+	CreatedBy primitive.ObjectID `bson:"created_by" json:"created_by"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// FileTypeOverrideRequest grants or replaces a user's override
+type FileTypeOverrideRequest struct {
+	Reason    string     `json:"reason" validate:"required,max=500"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
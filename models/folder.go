@@ -7,29 +7,70 @@ import (
 )
 
 type Folder struct {
-	ID          primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
-	UserID      primitive.ObjectID  `bson:"user_id" json:"user_id"`
-	ParentID    *primitive.ObjectID `bson:"parent_id,omitempty" json:"parent_id,omitempty"`
-	Name        string              `bson:"name" json:"name" validate:"required"`
-	Description string              `bson:"description" json:"description"`
-	Path        string              `bson:"path" json:"path"`
-	Color       string              `bson:"color" json:"color"`
-	Icon        string              `bson:"icon" json:"icon"`
-	IsPublic    bool                `bson:"is_public" json:"is_public"`
-	IsShared    bool                `bson:"is_shared" json:"is_shared"`
-	IsFavorite  bool                `bson:"is_favorite" json:"is_favorite"`
-	IsDeleted   bool                `bson:"is_deleted" json:"is_deleted"`
-	FilesCount  int                 `bson:"files_count" json:"files_count"`
-	Size        int64               `bson:"size" json:"size"`
-	ShareToken  string              `bson:"share_token" json:"share_token"`
-	Tags        []string            `bson:"tags" json:"tags"`
-	CreatedAt   time.Time           `bson:"created_at" json:"created_at"`
-	UpdatedAt   time.Time           `bson:"updated_at" json:"updated_at"`
-	DeletedAt   *time.Time          `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+	ID          primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
+	UserID      primitive.ObjectID   `bson:"user_id" json:"user_id"`
+	ParentID    *primitive.ObjectID  `bson:"parent_id,omitempty" json:"parent_id,omitempty"`
+	Name        string               `bson:"name" json:"name" validate:"required"`
+	Description string               `bson:"description" json:"description"`
+	Path        string               `bson:"path" json:"path"`
+	Ancestors   []primitive.ObjectID `bson:"ancestors" json:"ancestors"` // root first, ending with the parent
+	Color       string               `bson:"color" json:"color"`
+	Icon        string               `bson:"icon" json:"icon"`
+	IsPublic    bool                 `bson:"is_public" json:"is_public"`
+	IsShared    bool                 `bson:"is_shared" json:"is_shared"`
+	IsFavorite  bool                 `bson:"is_favorite" json:"is_favorite"`
+	FavoritedAt *time.Time           `bson:"favorited_at,omitempty" json:"favorited_at,omitempty"`
+	IsDeleted   bool                 `bson:"is_deleted" json:"is_deleted"`
+	FilesCount  int                  `bson:"files_count" json:"files_count"`
+	Size        int64                `bson:"size" json:"size"`
+	TotalFiles  int                  `bson:"total_files" json:"total_files"` // including subfolders
+	TotalSize   int64                `bson:"total_size" json:"total_size"`   // including subfolders
+	ShareToken  string               `bson:"share_token" json:"share_token"`
+	Tags        []string             `bson:"tags" json:"tags"`
+	CreatedAt   time.Time            `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time            `bson:"updated_at" json:"updated_at"`
+	DeletedAt   *time.Time           `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+	DeletionID  *primitive.ObjectID  `bson:"deletion_id,omitempty" json:"-"` // the folder whose deletion took it to the trash
+	TakedownID  *primitive.ObjectID  `bson:"takedown_id,omitempty" json:"takedown_id,omitempty"`
+	CopyJobID   *primitive.ObjectID  `bson:"copy_job_id,omitempty" json:"copy_job_id,omitempty"` // the job filling a copied folder
 }
 
+// FolderTree is a node of the folder tree. ChildrenCount is the number of
+// subfolders, of which Children may hold only one page.
 type FolderTree struct {
-	Folder   *Folder       `json:"folder"`
-	Children []*FolderTree `json:"children,omitempty"`
-	Files    []*File       `json:"files,omitempty"`
+	Folder        *Folder       `json:"folder"`
+	Children      []*FolderTree `json:"children,omitempty"`
+	ChildrenCount int           `json:"children_count"`
+	HasMore       bool          `json:"has_more,omitempty"`
+	Files         []*File       `json:"files,omitempty"`
+}
+
+// FolderUsage is a node of the storage usage tree. Size and FilesCount cover
+// the files directly in the folder, TotalSize and TotalFiles its whole
+// subtree. The root node has no ID.
+type FolderUsage struct {
+	ID         *primitive.ObjectID `json:"id,omitempty"`
+	Name       string              `json:"name"`
+	Path       string              `json:"path"`
+	Size       int64               `json:"size"`
+	FilesCount int                 `json:"files_count"`
+	TotalSize  int64               `json:"total_size"`
+	TotalFiles int                 `json:"total_files"`
+	Children   []*FolderUsage      `json:"children,omitempty"`
+}
+
+// FolderBatch answers a batch get: the folders found, in the order asked for,
+// and the IDs which do not exist or belong to someone else
+type FolderBatch struct {
+	Found   []Folder `json:"found"`
+	Missing []string `json:"missing"`
+}
+
+// SharedFolderSearch holds the matches of a search inside a shared folder.
+// Only items within the shared folder are ever returned.
+type SharedFolderSearch struct {
+	Folder  Folder   `json:"folder"`
+	Query   string   `json:"query"`
+	Folders []Folder `json:"folders"`
+	Files   []File   `json:"files"`
 }
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	FolderCopyRunning   = "running"
+	FolderCopyCompleted = "completed"
+	FolderCopyFailed    = "failed"
+)
+
+// FolderCopyProgress counts what a folder copy has done so far
+type FolderCopyProgress struct {
+	TotalFolders  int   `bson:"total_folders" json:"total_folders"`
+	TotalFiles    int   `bson:"total_files" json:"total_files"`
+	TotalBytes    int64 `bson:"total_bytes" json:"total_bytes"`
+	CopiedFolders int   `bson:"copied_folders" json:"copied_folders"`
+	CopiedFiles   int   `bson:"copied_files" json:"copied_files"`
+	CopiedBytes   int64 `bson:"copied_bytes" json:"copied_bytes"`
+	FailedFiles   int   `bson:"failed_files" json:"failed_files"`
+}
+
+// FolderCopyError records a file or folder that could not be copied
+type FolderCopyError struct {
+	Path    string `bson:"path" json:"path"`
+	Message string `bson:"message" json:"message"`
+}
+
+// FolderCopyJob fills a copied folder with copies of the source folder's
+// subfolders and files in the background
+type FolderCopyJob struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID         primitive.ObjectID `bson:"user_id" json:"user_id"`
+	SourceFolderID primitive.ObjectID `bson:"source_folder_id" json:"source_folder_id"`
+	FolderID       primitive.ObjectID `bson:"folder_id" json:"folder_id"`
+	Status         string             `bson:"status" json:"status"`
+	Progress       FolderCopyProgress `bson:"progress" json:"progress"`
+	Errors         []FolderCopyError  `bson:"errors" json:"errors"`
+	Error          string             `bson:"error,omitempty" json:"error,omitempty"`
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt      time.Time          `bson:"updated_at" json:"updated_at"`
+	CompletedAt    *time.Time         `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+}
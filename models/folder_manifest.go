@@ -0,0 +1,51 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	FolderManifestCSV  = "csv"
+	FolderManifestJSON = "json"
+)
+
+const (
+	FolderManifestRunning   = "running"
+	FolderManifestCompleted = "completed"
+	FolderManifestFailed    = "failed"
+)
+
+// FolderManifestEntry is a folder or file listed in a folder manifest.
+// Paths are relative to the exported folder.
+type FolderManifestEntry struct {
+	Path       string    `json:"path"`
+	Type       string    `json:"type"` // folder or file
+	Size       int64     `json:"size"`
+	MD5        string    `json:"md5,omitempty"`
+	MimeType   string    `json:"mime_type,omitempty"`
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+// FolderManifestExport builds the manifest of a folder tree too big to list
+// in one response in the background. The manifest can be downloaded until
+// ExpiresAt.
+type FolderManifestExport struct {
+	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID          primitive.ObjectID `bson:"user_id" json:"user_id"`
+	FolderID        primitive.ObjectID `bson:"folder_id" json:"folder_id"`
+	Format          string             `bson:"format" json:"format"`
+	Status          string             `bson:"status" json:"status"`
+	Folders         int                `bson:"folders" json:"folders"`
+	Files           int                `bson:"files" json:"files"`
+	TotalBytes      int64              `bson:"total_bytes" json:"total_bytes"`
+	Size            int64              `bson:"size" json:"size"` // of the manifest itself
+	StorageProvider string             `bson:"storage_provider,omitempty" json:"-"`
+	StorageKey      string             `bson:"storage_key,omitempty" json:"-"`
+	Error           string             `bson:"error,omitempty" json:"error,omitempty"`
+	CreatedAt       time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt       time.Time          `bson:"updated_at" json:"updated_at"`
+	CompletedAt     *time.Time         `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+	ExpiresAt       time.Time          `bson:"expires_at" json:"expires_at"`
+}
@@ -0,0 +1,82 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// What a folder member may do in the folder shared with them
+const (
+	FolderRoleViewer = "viewer" // browse and download
+	FolderRoleEditor = "editor" // also upload, rename and delete
+)
+
+// What a folder member did, as the folder's activity records it
+const (
+	FolderActivityUpload = "upload"
+	FolderActivityRename = "rename"
+	FolderActivityDelete = "delete"
+)
+
+// FolderMember is a user a folder is shared with, along with its
+// subfolders. What editors upload belongs to the folder's owner and counts
+// against the owner's quota. The counts sum up the member's activity.
+type FolderMember struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	FolderID      primitive.ObjectID `bson:"folder_id" json:"folder_id"`
+	OwnerID       primitive.ObjectID `bson:"owner_id" json:"owner_id"`
+	UserID        primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Email         string             `bson:"email" json:"email"`
+	Username      string             `bson:"username" json:"username"`
+	Role          string             `bson:"role" json:"role"`
+	Uploads       int                `bson:"uploads" json:"uploads"`
+	UploadedBytes int64              `bson:"uploaded_bytes" json:"uploaded_bytes"`
+	Renames       int                `bson:"renames" json:"renames"`
+	Deletes       int                `bson:"deletes" json:"deletes"`
+	LastActiveAt  *time.Time         `bson:"last_active_at,omitempty" json:"last_active_at,omitempty"`
+	CreatedAt     time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt     time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// FolderMemberRequest shares a folder with the user having Email
+type FolderMemberRequest struct {
+	Email string `json:"email" validate:"required,email"`
+	Role  string `json:"role" validate:"required,oneof=viewer editor"`
+}
+
+// FolderMemberUpdateRequest changes a member's role
+type FolderMemberUpdateRequest struct {
+	Role string `json:"role" validate:"required,oneof=viewer editor"`
+}
+
+// FolderActivity is one change a member made inside a folder shared with
+// them. FolderID is the shared folder, ItemID the file or subfolder changed.
+type FolderActivity struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	FolderID primitive.ObjectID `bson:"folder_id" json:"folder_id"`
+	OwnerID  primitive.ObjectID `bson:"owner_id" json:"-"`
+	UserID   primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Action   string             `bson:"action" json:"action"`
+	ItemType string             `bson:"item_type" json:"item_type"` // file or folder
+	ItemID   primitive.ObjectID `bson:"item_id" json:"item_id"`
+	Name     string             `bson:"name" json:"name"`
+	OldName  string             `bson:"old_name,omitempty" json:"old_name,omitempty"` // before a rename
+	Size     int64              `bson:"size,omitempty" json:"size,omitempty"`
+	// CreatedAt is when the change was made
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+}
+
+// SharedFolder is a folder another user shared with the current one
+type SharedFolder struct {
+	Folder    Folder    `json:"folder"`
+	Role      string    `json:"role"`
+	OwnerName string    `json:"owner_name"`
+	SharedAt  time.Time `json:"shared_at"`
+}
+
+// SharedItemRenameRequest renames a file or subfolder inside a folder shared
+// with the current user
+type SharedItemRenameRequest struct {
+	Name string `json:"name" validate:"required,min=1,max=255,folder_name"`
+}
@@ -0,0 +1,12 @@
+package models
+
+// GeoLocation is where a request came from, resolved from its IP address.
+// IP holds the address after the configured anonymization is applied.
+type GeoLocation struct {
+	IP          string `bson:"ip,omitempty" json:"ip,omitempty"`
+	Country     string `bson:"country,omitempty" json:"country,omitempty"` // ISO 3166-1 alpha-2
+	CountryName string `bson:"country_name,omitempty" json:"country_name,omitempty"`
+	Region      string `bson:"region,omitempty" json:"region,omitempty"` // ISO 3166-2 subdivision code
+	RegionName  string `bson:"region_name,omitempty" json:"region_name,omitempty"`
+	City        string `bson:"city,omitempty" json:"city,omitempty"`
+}
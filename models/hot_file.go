@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// HotFile is a public or shared file downloaded often enough to be kept warm
+// in the caches. Its ID is the file's. EdgeURL is set while the file is
+// served from the CDN, LocalPath while a copy is kept on local disk.
+type HotFile struct {
+	ID              primitive.ObjectID `bson:"_id" json:"id"`
+	UserID          primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Name            string             `bson:"name" json:"name"`
+	StorageProvider string             `bson:"storage_provider" json:"storage_provider"`
+	StorageKey      string             `bson:"storage_key" json:"-"`
+	Hash            string             `bson:"hash" json:"-"`
+	Size            int64              `bson:"size" json:"size"`
+	Hits            int64              `bson:"hits" json:"hits"` // downloads within the hot file window
+	EdgeURL         string             `bson:"edge_url,omitempty" json:"edge_url,omitempty"`
+	EdgeWarmedAt    *time.Time         `bson:"edge_warmed_at,omitempty" json:"edge_warmed_at,omitempty"`
+	LocalPath       string             `bson:"local_path,omitempty" json:"-"`
+	LocalCachedAt   *time.Time         `bson:"local_cached_at,omitempty" json:"local_cached_at,omitempty"`
+	CreatedAt       time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt       time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// CacheWarmRun is the outcome of a cache warming run
+type CacheWarmRun struct {
+	HotFiles    int       `json:"hot_files"`
+	EdgeFiles   int       `json:"edge_files"`
+	EdgeBytes   int64     `json:"edge_bytes"`
+	LocalFiles  int       `json:"local_files"`
+	LocalBytes  int64     `json:"local_bytes"`
+	Warmed      int       `json:"warmed"` // files fetched into a cache on this run
+	Evicted     int       `json:"evicted"`
+	Failed      int       `json:"failed"`
+	StartedAt   time.Time `json:"started_at"`
+	CompletedAt time.Time `json:"completed_at"`
+}
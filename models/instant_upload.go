@@ -0,0 +1,52 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Outcomes of an instant upload
+const (
+	InstantUploadCreated   = "created"   // the file was created from stored content
+	InstantUploadChallenge = "challenge" // prove having the content, then retry
+	InstantUploadNotFound  = "not_found" // the content is not stored, upload it
+	InstantUploadRefused   = "refused"   // the upload would not be accepted
+)
+
+// InstantUploadRequest creates a file from content the server already
+// stores, identified by its SHA-256. A retry answering a challenge carries
+// the challenge's ID and the proof computed for it.
+type InstantUploadRequest struct {
+	FileName    string `json:"file_name" validate:"required,max=255"`
+	Size        int64  `json:"size" validate:"gt=0"`
+	SHA256      string `json:"sha256" validate:"required,len=64,hexadecimal"`
+	FolderID    string `json:"folder_id" validate:"omitempty,objectid"`
+	ChallengeID string `json:"challenge_id" validate:"omitempty,objectid"`
+	Proof       string `json:"proof" validate:"omitempty,len=64,hexadecimal"`
+}
+
+// InstantUploadResult reports the outcome of an instant upload
+type InstantUploadResult struct {
+	Status        string            `json:"status"`
+	File          *File             `json:"file,omitempty"`
+	Challenge     *UploadChallenge  `json:"challenge,omitempty"`
+	Issues        []PreflightIssue  `json:"issues,omitempty"`
+	DuplicateFile *PreflightFileRef `json:"duplicate_file,omitempty"`
+}
+
+// UploadChallenge asks a client to prove it has the content it claims to
+// upload by hashing the nonce followed by Length bytes of the content from
+// Offset. Challenges are single use.
+type UploadChallenge struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID       primitive.ObjectID `bson:"user_id" json:"-"`
+	SourceFileID primitive.ObjectID `bson:"source_file_id" json:"-"`
+	SHA256       string             `bson:"sha256" json:"-"`
+	Size         int64              `bson:"size" json:"-"`
+	Nonce        string             `bson:"nonce" json:"nonce"`
+	Offset       int64              `bson:"offset" json:"offset"`
+	Length       int64              `bson:"length" json:"length"`
+	ExpiresAt    time.Time          `bson:"expires_at" json:"expires_at"`
+	CreatedAt    time.Time          `bson:"created_at" json:"-"`
+}
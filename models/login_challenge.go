@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// LoginChallenge holds a login from an unfamiliar device or location until the
+// user proves it is them with an emailed code or a 2FA code
+type LoginChallenge struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+	ChallengeID string             `bson:"challenge_id" json:"challenge_id"`
+	UserID      primitive.ObjectID `bson:"user_id" json:"-"`
+	Method      string             `bson:"method" json:"method"` // email, totp
+	CodeHash    string             `bson:"code_hash,omitempty" json:"-"`
+	Reasons     []string           `bson:"reasons" json:"reasons"` // new_device, new_location
+	IPAddress   string             `bson:"ip_address,omitempty" json:"-"`
+	Location    *GeoLocation       `bson:"location,omitempty" json:"location,omitempty"`
+	Device      string             `bson:"device" json:"device"`
+	Attempts    int                `bson:"attempts" json:"-"`
+	VerifiedAt  *time.Time         `bson:"verified_at,omitempty" json:"-"`
+	CreatedAt   time.Time          `bson:"created_at" json:"-"`
+	ExpiresAt   time.Time          `bson:"expires_at" json:"expires_at"`
+}
+
+// LoginVerificationRequest completes a challenged login
+type LoginVerificationRequest struct {
+	ChallengeID string `json:"challenge_id" validate:"required"`
+	Code        string `json:"code" validate:"required"`
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MaintenanceWindow is scheduled maintenance. Users are answered with 503
+// from StartsAt until EndsAt, and are notified when it is scheduled.
+type MaintenanceWindow struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Title       string             `bson:"title" json:"title"`
+	Message     string             `bson:"message" json:"message"`
+	StartsAt    time.Time          `bson:"starts_at" json:"starts_at"`
+	EndsAt      time.Time          `bson:"ends_at" json:"ends_at"`
+	CreatedBy   primitive.ObjectID `bson:"created_by" json:"created_by"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	CancelledAt *time.Time         `bson:"cancelled_at,omitempty" json:"cancelled_at,omitempty"`
+}
+
+// MaintenanceWindowRequest schedules maintenance
+type MaintenanceWindowRequest struct {
+	Title    string    `json:"title" validate:"required,max=100"`
+	Message  string    `json:"message" validate:"max=500"`
+	StartsAt time.Time `json:"starts_at" validate:"required"`
+	EndsAt   time.Time `json:"ends_at" validate:"required,gtfield=StartsAt"`
+	// Notify announces the window to every active user
+	Notify bool `json:"notify"`
+}
+
+// MaintenanceModeRequest switches maintenance mode on or off
+type MaintenanceModeRequest struct {
+	Enabled bool   `json:"enabled"`
+	Message string `json:"message" validate:"max=500"`
+}
+
+// MaintenanceStatus tells clients whether the service is under maintenance
+// and what is planned
+type MaintenanceStatus struct {
+	Active   bool                `json:"active"`
+	Message  string              `json:"message,omitempty"`
+	Until    *time.Time          `json:"until,omitempty"`
+	Upcoming []MaintenanceWindow `json:"upcoming"`
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Media stream statuses
+const (
+	MediaStreamPending    = "pending"
+	MediaStreamProcessing = "processing"
+	MediaStreamReady      = "ready"
+	MediaStreamFailed     = "failed"
+)
+
+// MediaStream is a shared video or audio file transcoded to HLS segments,
+// made once for each content of the file, on the file's provider
+type MediaStream struct {
+	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	FileID          primitive.ObjectID `bson:"file_id" json:"file_id"`
+	UserID          primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Source          string             `bson:"source" json:"-"` // the content of the file it was made from
+	Status          string             `bson:"status" json:"status"`
+	StorageProvider string             `bson:"storage_provider" json:"-"`
+	TargetDuration  int                `bson:"target_duration" json:"target_duration"` // seconds, the longest segment rounded up
+	Segments        []MediaSegment     `bson:"segments" json:"segments"`
+	Attempts        int                `bson:"attempts" json:"attempts"`
+	Error           string             `bson:"error,omitempty" json:"error,omitempty"`
+	SegmentsServed  int64              `bson:"segments_served" json:"segments_served"`
+	BytesServed     int64              `bson:"bytes_served" json:"bytes_served"`
+	LastServedAt    *time.Time         `bson:"last_served_at,omitempty" json:"last_served_at,omitempty"`
+	CreatedAt       time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt       time.Time          `bson:"updated_at" json:"updated_at"`
+	ReadyAt         *time.Time         `bson:"ready_at,omitempty" json:"ready_at,omitempty"`
+}
+
+type MediaSegment struct {
+	Key      string  `bson:"key" json:"-"`
+	Duration float64 `bson:"duration" json:"duration"` // seconds
+	Size     int64   `bson:"size" json:"size"`
+}
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MetadataField is a custom metadata field a user defines for their files.
+// Values are stored on the file under custom_metadata.<key>. Values of
+// indexed fields are also copied into the file's metadata index so they can
+// be filtered on efficiently.
+type MetadataField struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Key       string             `bson:"key" json:"key"`
+	Label     string             `bson:"label" json:"label"`
+	Type      string             `bson:"type" json:"type"` // string, number, date, enum
+	Options   []string           `bson:"options,omitempty" json:"options,omitempty"`
+	Required  bool               `bson:"required" json:"required"`
+	Indexed   bool               `bson:"indexed" json:"indexed"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// MetadataIndexEntry holds the value of an indexed metadata field on a file
+type MetadataIndexEntry struct {
+	Key   string      `bson:"k" json:"k"`
+	Value interface{} `bson:"v" json:"v"`
+}
+
+// MetadataFieldRequest defines a new metadata field. Options are required
+// for enum fields.
+type MetadataFieldRequest struct {
+	Key      string   `json:"key" validate:"required,max=40"`
+	Label    string   `json:"label" validate:"max=100"`
+	Type     string   `json:"type" validate:"required,oneof=string number date enum"`
+	Options  []string `json:"options" validate:"omitempty,max=100,dive,required,max=100"`
+	Required bool     `json:"required"`
+	Indexed  bool     `json:"indexed"`
+}
+
+// MetadataFieldUpdateRequest changes a metadata field. The key and type of a
+// field cannot be changed.
+type MetadataFieldUpdateRequest struct {
+	Label    *string  `json:"label" validate:"omitempty,max=100"`
+	Options  []string `json:"options" validate:"omitempty,max=100,dive,required,max=100"`
+	Required *bool    `json:"required"`
+	Indexed  *bool    `json:"indexed"`
+}
+
+// FileMetadataRequest sets custom metadata values on a file. A null value
+// clears the field.
+type FileMetadataRequest struct {
+	Values map[string]interface{} `json:"values" validate:"required"`
+}
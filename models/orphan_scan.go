@@ -0,0 +1,52 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	OrphanScanRunning   = "running"
+	OrphanScanCompleted = "completed"
+	OrphanScanFailed    = "failed"
+)
+
+// OrphanScan reconciles the objects in a provider's bucket with the files,
+// versions and thumbnails referring to them. Orphans are objects nothing
+// refers to; missing objects are referred to but not stored.
+type OrphanScan struct {
+	ID             primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	ProviderID     primitive.ObjectID  `bson:"provider_id" json:"provider_id"`
+	ProviderType   string              `bson:"provider_type" json:"provider_type"`
+	Status         string              `bson:"status" json:"status"`
+	DeleteOrphans  bool                `bson:"delete_orphans" json:"delete_orphans"`
+	SafetyWindow   string              `bson:"safety_window" json:"safety_window"`
+	ObjectsScanned int                 `bson:"objects_scanned" json:"objects_scanned"`
+	OrphanCount    int                 `bson:"orphan_count" json:"orphan_count"`
+	OrphanBytes    int64               `bson:"orphan_bytes" json:"orphan_bytes"`
+	DeletedCount   int                 `bson:"deleted_count" json:"deleted_count"`
+	DeletedBytes   int64               `bson:"deleted_bytes" json:"deleted_bytes"`
+	MissingCount   int                 `bson:"missing_count" json:"missing_count"`
+	Orphans        []OrphanObject      `bson:"orphans" json:"orphans"`
+	Missing        []MissingObject     `bson:"missing" json:"missing"`
+	Error          string              `bson:"error,omitempty" json:"error,omitempty"`
+	StartedBy      *primitive.ObjectID `bson:"started_by,omitempty" json:"started_by,omitempty"`
+	StartedAt      time.Time           `bson:"started_at" json:"started_at"`
+	CompletedAt    *time.Time          `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+}
+
+// OrphanObject is a stored object no record refers to
+type OrphanObject struct {
+	Key          string    `bson:"key" json:"key"`
+	Size         int64     `bson:"size" json:"size"`
+	LastModified time.Time `bson:"last_modified" json:"last_modified"`
+	Deleted      bool      `bson:"deleted" json:"deleted"`
+}
+
+// MissingObject is a record referring to an object which is not stored
+type MissingObject struct {
+	Kind   string             `bson:"kind" json:"kind"` // file, version, thumbnail, stream_segment
+	FileID primitive.ObjectID `bson:"file_id" json:"file_id"`
+	Key    string             `bson:"key" json:"key"`
+}
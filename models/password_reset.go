@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PasswordReset is a request to reset a password. Only the hash of its token
+// is kept, and the token works once, until ExpiresAt. Requests for emails
+// without an account are recorded too, without a token, so throttling
+// doesn't tell the two apart.
+type PasswordReset struct {
+	ID        primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	UserID    *primitive.ObjectID `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	Email     string              `bson:"email" json:"email"`
+	TokenHash string              `bson:"token_hash,omitempty" json:"-"`
+	IPAddress string              `bson:"ip_address" json:"ip_address"`
+	UserAgent string              `bson:"user_agent" json:"user_agent"`
+	ExpiresAt time.Time           `bson:"expires_at" json:"expires_at"`
+	UsedAt    *time.Time          `bson:"used_at,omitempty" json:"used_at,omitempty"`
+	CreatedAt time.Time           `bson:"created_at" json:"created_at"`
+}
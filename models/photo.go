@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PhotoItem is a photo as shown in the timeline and map views. TakenAt is
+// the capture date, or the upload date when the photo has none.
+type PhotoItem struct {
+	ID           primitive.ObjectID  `bson:"_id" json:"id"`
+	FolderID     *primitive.ObjectID `bson:"folder_id,omitempty" json:"folder_id,omitempty"`
+	Name         string              `bson:"name" json:"name"`
+	MimeType     string              `bson:"mime_type" json:"mime_type"`
+	Size         int64               `bson:"size" json:"size"`
+	ThumbnailURL string              `bson:"thumbnail_url,omitempty" json:"thumbnail_url,omitempty"`
+	Width        int                 `bson:"width,omitempty" json:"width,omitempty"`
+	Height       int                 `bson:"height,omitempty" json:"height,omitempty"`
+	TakenAt      time.Time           `bson:"taken_at" json:"taken_at"`
+	Location     *MediaLocation      `bson:"location,omitempty" json:"location,omitempty"`
+}
+
+// TimelineBucket groups the photos taken in one day, month or year
+type TimelineBucket struct {
+	Period string     `bson:"_id" json:"period"` // 2023, 2023-07 or 2023-07-04
+	Count  int        `bson:"count" json:"count"`
+	Start  time.Time  `bson:"start" json:"start"`
+	End    time.Time  `bson:"end" json:"end"`
+	Cover  *PhotoItem `bson:"cover" json:"cover"`
+}
+
+// MapCluster groups nearby photos for the map view. Latitude and Longitude
+// are the centre of the photos in the cluster.
+type MapCluster struct {
+	Latitude     float64    `bson:"latitude" json:"latitude"`
+	Longitude    float64    `bson:"longitude" json:"longitude"`
+	Count        int        `bson:"count" json:"count"`
+	MinLatitude  float64    `bson:"min_latitude" json:"min_latitude"`
+	MaxLatitude  float64    `bson:"max_latitude" json:"max_latitude"`
+	MinLongitude float64    `bson:"min_longitude" json:"min_longitude"`
+	MaxLongitude float64    `bson:"max_longitude" json:"max_longitude"`
+	Cover        *PhotoItem `bson:"cover" json:"cover"`
+}
@@ -0,0 +1,71 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// POST policy upload statuses
+const (
+	PostPolicyUploadPending   = "pending"
+	PostPolicyUploadCompleted = "completed"
+)
+
+// PostPolicyUpload is a signed POST policy letting a browser form upload a
+// file straight to a bucket. The file is created, with the upload's ID, when
+// the client reports the upload complete.
+type PostPolicyUpload struct {
+	ID           primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	UserID       primitive.ObjectID  `bson:"user_id" json:"user_id"`
+	ProviderType string              `bson:"provider_type" json:"provider_type"`
+	Bucket       string              `bson:"bucket" json:"bucket"`
+	Key          string              `bson:"key,omitempty" json:"key,omitempty"`               // when the provider's key template doesn't use {name}
+	KeyPrefix    string              `bson:"key_prefix,omitempty" json:"key_prefix,omitempty"` // of keys named after the uploaded file
+	FolderID     string              `bson:"folder_id,omitempty" json:"folder_id,omitempty"`
+	ContentType  string              `bson:"content_type,omitempty" json:"content_type,omitempty"`
+	MaxSize      int64               `bson:"max_size" json:"max_size"`
+	Status       string              `bson:"status" json:"status"`
+	FileID       *primitive.ObjectID `bson:"file_id,omitempty" json:"file_id,omitempty"`
+	ExpiresAt    time.Time           `bson:"expires_at" json:"expires_at"`
+	CreatedAt    time.Time           `bson:"created_at" json:"created_at"`
+	UpdatedAt    time.Time           `bson:"updated_at" json:"updated_at"`
+}
+
+// PostPolicyUploadRequest asks for a form to upload a file to the default
+// provider's bucket with. ContentType is an exact type, or a prefix ending
+// in "/" such as image/.
+type PostPolicyUploadRequest struct {
+	FolderID        string `json:"folder_id" validate:"omitempty,objectid"`
+	ContentType     string `json:"content_type" validate:"omitempty,max=100"`
+	MaxSize         int64  `json:"max_size" validate:"omitempty,min=1"`
+	ExpiresIn       int    `json:"expires_in" validate:"omitempty,min=60,max=86400"` // seconds, an hour by default
+	SuccessRedirect string `json:"success_redirect" validate:"omitempty,url"`
+}
+
+// PostPolicyForm is the form a browser posts the file with, to URL with
+// Fields as its hidden inputs, followed by a "file" input
+type PostPolicyForm struct {
+	UploadID  string            `json:"upload_id"`
+	URL       string            `json:"url"`
+	Fields    map[string]string `json:"fields"`
+	MaxSize   int64             `json:"max_size"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+// PostPolicyCompleteRequest reports a form upload done, with the key the
+// bucket stored the file under. Name defaults to the last part of the key.
+type PostPolicyCompleteRequest struct {
+	Key         string `json:"key" validate:"required,max=1024"`
+	Name        string `json:"name" validate:"omitempty,max=255"`
+	Description string `json:"description" validate:"max=1000"`
+}
+
+// StoredObject is content already in a provider's bucket, such as a file a
+// browser uploaded there directly. FileID is the ID its key was made for.
+type StoredObject struct {
+	FileID       primitive.ObjectID
+	ProviderType string
+	Bucket       string
+	Key          string
+}
@@ -0,0 +1,252 @@
+package models
+
+// Request bodies of the file, folder, plan and admin routes. They are bound
+// and validated by middleware.ValidateJSON before the handler runs. Partial
+// updates use pointers, so that fields left out of the body are not changed.
+
+type CompleteUploadRequest struct {
+	UploadID string `json:"upload_id" validate:"required"`
+	FileName string `json:"file_name" validate:"required,max=255"`
+	FolderID string `json:"folder_id" validate:"omitempty,objectid"`
+}
+
+type FileUpdateRequest struct {
+	Name        *string  `json:"name" validate:"omitempty,min=1,max=255"`
+	Description *string  `json:"description" validate:"omitempty,max=1000"`
+	Tags        []string `json:"tags" validate:"omitempty,max=50,dive,required,max=50"`
+}
+
+type FileCopyRequest struct {
+	DestFolderID string `json:"dest_folder_id" validate:"omitempty,objectid"`
+	NewName      string `json:"new_name" validate:"max=255"`
+}
+
+type FileMoveRequest struct {
+	DestFolderID string `json:"dest_folder_id" validate:"omitempty,objectid"`
+}
+
+// TagsRequest replaces the tags of a file or folder
+type TagsRequest struct {
+	Tags []string `json:"tags" validate:"max=50,dive,required,max=50"`
+}
+
+type BulkFilesRequest struct {
+	FileIDs []string `json:"file_ids" validate:"required,min=1,max=1000,dive,objectid"`
+}
+
+type BulkFilesMoveRequest struct {
+	FileIDs      []string `json:"file_ids" validate:"required,min=1,max=1000,dive,objectid"`
+	DestFolderID string   `json:"dest_folder_id" validate:"omitempty,objectid"`
+}
+
+type BulkFilesShareRequest struct {
+	FileIDs   []string     `json:"file_ids" validate:"required,min=1,max=1000,dive,objectid"`
+	ShareData ShareRequest `json:"share_data"`
+}
+
+// BatchGetRequest fetches many files or folders at once
+type BatchGetRequest struct {
+	IDs []string `json:"ids" validate:"required,min=1,max=500,dive,objectid"`
+}
+
+type SharePasswordRequest struct {
+	Password string `json:"password" validate:"required"`
+}
+
+type FolderUpdateRequest struct {
+	Name        *string  `json:"name" validate:"omitempty,min=1,max=255,folder_name"`
+	Description *string  `json:"description" validate:"omitempty,max=1000"`
+	Color       *string  `json:"color" validate:"omitempty,max=20"`
+	Icon        *string  `json:"icon" validate:"omitempty,max=50"`
+	Tags        []string `json:"tags" validate:"omitempty,max=50,dive,required,max=50"`
+}
+
+type FolderCopyRequest struct {
+	DestParentID string `json:"dest_parent_id" validate:"omitempty,objectid"`
+	NewName      string `json:"new_name" validate:"omitempty,max=255,folder_name"`
+}
+
+type FolderMoveRequest struct {
+	DestParentID string `json:"dest_parent_id" validate:"omitempty,objectid"`
+}
+
+type BulkFoldersRequest struct {
+	FolderIDs []string `json:"folder_ids" validate:"required,min=1,max=1000,dive,objectid"`
+}
+
+type BulkFoldersMoveRequest struct {
+	FolderIDs    []string `json:"folder_ids" validate:"required,min=1,max=1000,dive,objectid"`
+	DestParentID string   `json:"dest_parent_id" validate:"omitempty,objectid"`
+}
+
+type BulkFoldersShareRequest struct {
+	FolderIDs []string     `json:"folder_ids" validate:"required,min=1,max=1000,dive,objectid"`
+	ShareData ShareRequest `json:"share_data"`
+}
+
+type SubscribeRequest struct {
+	PlanID        string `json:"plan_id" validate:"required,objectid"`
+	PaymentMethod string `json:"payment_method" validate:"required"`
+	BillingCycle  string `json:"billing_cycle" validate:"omitempty,oneof=daily weekly monthly yearly"`
+	CouponCode    string `json:"coupon_code" validate:"max=50"`
+}
+
+type PlanUpgradeRequest struct {
+	NewPlanID     string `json:"new_plan_id" validate:"required,objectid"`
+	PaymentMethod string `json:"payment_method"`
+	BillingCycle  string `json:"billing_cycle" validate:"omitempty,oneof=daily weekly monthly yearly"`
+}
+
+type PlanDowngradeRequest struct {
+	NewPlanID string `json:"new_plan_id" validate:"required,objectid"`
+	Immediate bool   `json:"immediate"`
+}
+
+type CancelSubscriptionRequest struct {
+	Reason    string `json:"reason" validate:"max=500"`
+	Immediate bool   `json:"immediate"`
+}
+
+type RenewSubscriptionRequest struct {
+	PaymentMethod string `json:"payment_method"`
+	BillingCycle  string `json:"billing_cycle" validate:"omitempty,oneof=daily weekly monthly yearly"`
+}
+
+type PaymentMethodRequest struct {
+	Type      string            `json:"type" validate:"required,oneof=card paypal bank"`
+	Token     string            `json:"token" validate:"required"` // payment gateway token
+	IsDefault bool              `json:"is_default"`
+	Metadata  map[string]string `json:"metadata"`
+}
+
+type PaymentMethodUpdateRequest struct {
+	IsDefault bool              `json:"is_default"`
+	Metadata  map[string]string `json:"metadata"`
+}
+
+// PlanUpdateRequest changes the fields of a plan which are set
+type PlanUpdateRequest struct {
+	Name             *string  `bson:"name" json:"name" validate:"omitempty,min=1,max=100"`
+	Slug             *string  `bson:"slug" json:"slug" validate:"omitempty,max=100"`
+	Description      *string  `bson:"description" json:"description" validate:"omitempty,max=2000"`
+	ShortDescription *string  `bson:"short_description" json:"short_description" validate:"omitempty,max=255"`
+	StorageLimit     *int64   `bson:"storage_limit" json:"storage_limit" validate:"omitempty,gte=0"`
+	BandwidthLimit   *int64   `bson:"bandwidth_limit" json:"bandwidth_limit" validate:"omitempty,gte=0"`
+	FilesLimit       *int     `bson:"files_limit" json:"files_limit" validate:"omitempty,gte=0"`
+	FoldersLimit     *int     `bson:"folders_limit" json:"folders_limit" validate:"omitempty,gte=0"`
+	Price            *float64 `bson:"price" json:"price" validate:"omitempty,gte=0"`
+	OriginalPrice    *float64 `bson:"original_price" json:"original_price" validate:"omitempty,gte=0"`
+	Currency         *string  `bson:"currency" json:"currency" validate:"omitempty,len=3"`
+	BillingCycle     *string  `bson:"billing_cycle" json:"billing_cycle" validate:"omitempty,oneof=daily weekly monthly yearly"`
+	MaxFileSize      *int64   `bson:"max_file_size" json:"max_file_size" validate:"omitempty,gte=0"`
+	AllowedTypes     []string `bson:"allowed_types" json:"allowed_types"`
+	Features         []string `bson:"features" json:"features"`
+	Limitations      []string `bson:"limitations" json:"limitations"`
+	PopularBadge     *bool    `bson:"popular_badge" json:"popular_badge"`
+	IsActive         *bool    `bson:"is_active" json:"is_active"`
+	IsDefault        *bool    `bson:"is_default" json:"is_default"`
+	IsFree           *bool    `bson:"is_free" json:"is_free"`
+	SortOrder        *int     `bson:"sort_order" json:"sort_order"`
+	TrialDays        *int     `bson:"trial_days" json:"trial_days" validate:"omitempty,gte=0"`
+}
+
+// StorageProviderUpdateRequest changes the fields of a storage provider which
+// are set. The type of a provider cannot change.
+type StorageProviderUpdateRequest struct {
+	Name         *string                `bson:"name" json:"name" validate:"omitempty,min=1,max=100"`
+	Region       *string                `bson:"region" json:"region"`
+	Endpoint     *string                `bson:"endpoint" json:"endpoint" validate:"omitempty,url"`
+	Bucket       *string                `bson:"bucket" json:"bucket"`
+	AccessKey    *string                `bson:"access_key" json:"access_key"`
+	SecretKey    *string                `bson:"secret_key" json:"secret_key"`
+	CDNUrl       *string                `bson:"cdn_url" json:"cdn_url" validate:"omitempty,url"`
+	MaxFileSize  *int64                 `bson:"max_file_size" json:"max_file_size" validate:"omitempty,gte=0"`
+	AllowedTypes []string               `bson:"allowed_types" json:"allowed_types"`
+	Settings     map[string]interface{} `bson:"settings" json:"settings"`
+	IsActive     *bool                  `bson:"is_active" json:"is_active"`
+	IsDefault    *bool                  `bson:"is_default" json:"is_default"`
+	Priority     *int                   `bson:"priority" json:"priority"`
+	KeyTemplate  *string                `bson:"key_template" json:"key_template" validate:"omitempty,storage_key_template"`
+	Resilience   *ProviderResilience    `bson:"resilience" json:"resilience"`
+	ReplicaTypes []string               `bson:"replica_types" json:"replica_types" validate:"omitempty,max=3,dive,storage_provider"` // empty to stop failing over
+}
+
+// StoragePricingUpdateRequest changes the prices of a price list which are set
+type StoragePricingUpdateRequest struct {
+	Region            *string  `bson:"region" json:"region"`
+	StoragePerGBMonth *float64 `bson:"storage_per_gb_month" json:"storage_per_gb_month" validate:"omitempty,gte=0"`
+	EgressPerGB       *float64 `bson:"egress_per_gb" json:"egress_per_gb" validate:"omitempty,gte=0"`
+	FreeEgressGB      *float64 `bson:"free_egress_gb" json:"free_egress_gb" validate:"omitempty,gte=0"`
+	ClassAPer1000     *float64 `bson:"class_a_per_1000" json:"class_a_per_1000" validate:"omitempty,gte=0"`
+	ClassBPer1000     *float64 `bson:"class_b_per_1000" json:"class_b_per_1000" validate:"omitempty,gte=0"`
+	Notes             *string  `bson:"notes" json:"notes" validate:"omitempty,max=500"`
+}
+
+type AdminUserCreateRequest struct {
+	Username   string `json:"username" validate:"required,min=3,max=50"`
+	Email      string `json:"email" validate:"required,email"`
+	Password   string `json:"password" validate:"required,min=6"`
+	FirstName  string `json:"first_name" validate:"required"`
+	LastName   string `json:"last_name" validate:"required"`
+	PlanID     string `json:"plan_id" validate:"omitempty,objectid"`
+	IsActive   bool   `json:"is_active"`
+	IsVerified bool   `json:"is_verified"`
+}
+
+// AdminUserUpdateRequest changes the fields of a user which are set
+type AdminUserUpdateRequest struct {
+	Username   *string `bson:"username" json:"username" validate:"omitempty,min=3,max=50"`
+	Email      *string `bson:"email" json:"email" validate:"omitempty,email"`
+	FirstName  *string `bson:"first_name" json:"first_name" validate:"omitempty,min=1,max=100"`
+	LastName   *string `bson:"last_name" json:"last_name" validate:"omitempty,min=1,max=100"`
+	Phone      *string `bson:"phone" json:"phone" validate:"omitempty,max=30"`
+	Country    *string `bson:"country" json:"country" validate:"omitempty,max=100"`
+	PlanID     *string `bson:"-" json:"plan_id" validate:"omitempty,objectid"`
+	IsActive   *bool   `bson:"is_active" json:"is_active"`
+	IsVerified *bool   `bson:"is_verified" json:"is_verified"`
+	IsPremium  *bool   `bson:"is_premium" json:"is_premium"`
+}
+
+type SuspendUserRequest struct {
+	Reason string `json:"reason" validate:"max=500"`
+}
+
+type AccountStateRequest struct {
+	State  string `json:"state" validate:"required,oneof=active suspended read_only pending_deletion"`
+	Reason string `json:"reason" validate:"max=500"`
+}
+
+type AdminPasswordResetRequest struct {
+	NewPassword string `json:"new_password" validate:"required,min=6"`
+	SendEmail   bool   `json:"send_email"`
+}
+
+type AdminFileDeleteRequest struct {
+	Reason    string `json:"reason" validate:"max=500"`
+	Permanent bool   `json:"permanent"`
+}
+
+type FileModerationRequest struct {
+	Action string `json:"action" validate:"required,oneof=approve reject flag quarantine"`
+	Reason string `json:"reason" validate:"max=500"`
+	Notes  string `json:"notes" validate:"max=2000"`
+}
+
+type FileScanRequest struct {
+	ScanType string `json:"scan_type" validate:"omitempty,oneof=virus malware content"`
+	Force    bool   `json:"force"` // rescan files which were already scanned
+}
+
+type ClearCacheRequest struct {
+	CacheType string `json:"cache_type" validate:"omitempty,oneof=redis memory all"`
+}
+
+type ClearLogsRequest struct {
+	LogType   string `json:"log_type" validate:"omitempty,oneof=access error all"`
+	OlderThan int    `json:"older_than" validate:"gte=0"` // days
+}
+
+type SystemBackupRequest struct {
+	BackupType string `json:"backup_type" validate:"required,oneof=database files full"`
+	Name       string `json:"name" validate:"max=100"`
+}
@@ -1,7 +1,13 @@
 package models
 
-import "time"
+import (
+	"time"
 
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// APIResponse is the envelope every API response is sent in. Error is set
+// when Success is false and Meta on paginated lists.
 type APIResponse struct {
 	Success   bool        `json:"success"`
 	Message   string      `json:"message"`
@@ -11,17 +17,22 @@ type APIResponse struct {
 	Timestamp time.Time   `json:"timestamp"`
 }
 
+// APIError describes a failed request. Code is a stable, machine-readable
+// identifier such as not_found or validation_failed; Message may change.
 type APIError struct {
 	Code    string                 `json:"code"`
 	Message string                 `json:"message"`
 	Details map[string]interface{} `json:"details,omitempty"`
 }
 
+// Meta is the pagination of a list response
 type Meta struct {
-	Page       int `json:"page,omitempty"`
-	Limit      int `json:"limit,omitempty"`
-	Total      int `json:"total,omitempty"`
-	TotalPages int `json:"total_pages,omitempty"`
+	Page       int  `json:"page"`
+	Limit      int  `json:"limit"`
+	Total      int  `json:"total"`
+	TotalPages int  `json:"total_pages"`
+	HasNext    bool `json:"has_next"`
+	HasPrev    bool `json:"has_prev"`
 }
 
 type LoginRequest struct {
@@ -62,17 +73,22 @@ type DashboardStats struct {
 }
 
 type FileUploadRequest struct {
-	FolderID    string            `form:"folder_id"`
-	Name        string            `form:"name"`
-	Description string            `form:"description"`
-	IsPublic    bool              `form:"is_public"`
-	Tags        []string          `form:"tags"`
-	Metadata    map[string]string `form:"metadata"`
+	FolderID     string              `form:"folder_id"`
+	Name         string              `form:"name"`
+	Description  string              `form:"description"`
+	IsPublic     bool                `form:"is_public"`
+	Tags         []string            `form:"tags"`
+	Metadata     map[string]string   `form:"metadata"`
+	StorageClass string              `form:"storage_class"` // such as STANDARD_IA or GLACIER, on providers which offer them
+	Source       string              `form:"-"`             // where the upload came from, for upload rules
+	Uploader     *FileUploader       `form:"-"`             // the guest uploading through a file request
+	Contributor  *primitive.ObjectID `form:"-"`             // the member uploading into a folder shared with them
+	Stored       *StoredObject       `form:"-"`             // the content already uploaded to a bucket, to create the file at
 }
 
 type FolderCreateRequest struct {
-	Name        string `json:"name" validate:"required"`
-	ParentID    string `json:"parent_id,omitempty"`
+	Name        string `json:"name" validate:"required,max=255,folder_name"`
+	ParentID    string `json:"parent_id,omitempty" validate:"omitempty,objectid"`
 	Description string `json:"description"`
 	Color       string `json:"color"`
 	Icon        string `json:"icon"`
@@ -80,7 +96,9 @@ type FolderCreateRequest struct {
 }
 
 type ShareRequest struct {
-	Password     string     `json:"password,omitempty"`
+	Password     string     `json:"password,omitempty" validate:"max=128"`
 	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
-	MaxDownloads int        `json:"max_downloads,omitempty"`
+	MaxDownloads int        `json:"max_downloads,omitempty" validate:"gte=0"`
+	Watermark    *bool      `json:"watermark,omitempty"` // file shares only
+	ViewOnly     *bool      `json:"view_only,omitempty"` // file shares only
 }
\ No newline at end of file
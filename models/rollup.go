@@ -0,0 +1,70 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type StatsRollup struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Granularity   string             `bson:"granularity" json:"granularity"` // hourly, daily
+	PeriodStart   time.Time          `bson:"period_start" json:"period_start"`
+	PeriodEnd     time.Time          `bson:"period_end" json:"period_end"`
+	NewUsers      int64              `bson:"new_users" json:"new_users"`
+	NewFiles      int64              `bson:"new_files" json:"new_files"`
+	UploadedBytes int64              `bson:"uploaded_bytes" json:"uploaded_bytes"`
+	Downloads     int64              `bson:"downloads" json:"downloads"`
+	Revenue       float64            `bson:"revenue" json:"revenue"`
+	Payments      int64              `bson:"payments" json:"payments"`
+	ActiveUsers   int64              `bson:"active_users" json:"active_users"`
+	TotalUsers    int64              `bson:"total_users" json:"total_users"`
+	TotalFiles    int64              `bson:"total_files" json:"total_files"`
+	TotalStorage  int64              `bson:"total_storage" json:"total_storage"`
+	ComputedAt    time.Time          `bson:"computed_at" json:"computed_at"`
+	ComputeTimeMs int64              `bson:"compute_time_ms" json:"compute_time_ms"`
+}
+
+type DashboardSnapshot struct {
+	ID            primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
+	Key           string                 `bson:"key" json:"key"`
+	Data          map[string]interface{} `bson:"data" json:"data"`
+	ComputedAt    time.Time              `bson:"computed_at" json:"computed_at"`
+	ComputeTimeMs int64                  `bson:"compute_time_ms" json:"compute_time_ms"`
+}
+
+type RollupFreshness struct {
+	ComputedAt *time.Time `json:"computed_at,omitempty"`
+	AgeSeconds int64      `json:"age_seconds"`
+	IsStale    bool       `json:"is_stale"`
+	Source     string     `json:"source"` // rollup, live
+}
+
+// CohortAnalysis is a signup-cohort retention matrix, cached by key
+type CohortAnalysis struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+	Key           string             `bson:"key" json:"-"`
+	Granularity   string             `bson:"granularity" json:"granularity"` // week, month
+	Periods       int                `bson:"periods" json:"periods"`
+	Days          []int              `bson:"days" json:"days"`
+	Cohorts       []RetentionCohort  `bson:"cohorts" json:"cohorts"`
+	Overall       []RetentionPoint   `bson:"overall" json:"overall"`
+	ComputedAt    time.Time          `bson:"computed_at" json:"computed_at"`
+	ComputeTimeMs int64              `bson:"compute_time_ms" json:"compute_time_ms"`
+	Source        string             `bson:"-" json:"source"` // cache, live
+}
+
+type RetentionCohort struct {
+	CohortStart time.Time        `bson:"cohort_start" json:"cohort_start"`
+	Users       int64            `bson:"users" json:"users"`
+	Retention   []RetentionPoint `bson:"retention" json:"retention"`
+}
+
+// RetentionPoint is Dn retention: users active exactly n days after signup,
+// out of the users whose day n has already elapsed
+type RetentionPoint struct {
+	Day      int     `bson:"day" json:"day"`
+	Eligible int64   `bson:"eligible" json:"eligible"`
+	Retained int64   `bson:"retained" json:"retained"`
+	Rate     float64 `bson:"rate" json:"rate"`
+}
@@ -0,0 +1,129 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SCIM 2.0 schema URNs (RFC 7643/7644)
+const (
+	ScimUserSchema         = "urn:ietf:params:scim:schemas:core:2.0:User"
+	ScimGroupSchema        = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	ScimListResponseSchema = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	ScimPatchOpSchema      = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	ScimErrorSchema        = "urn:ietf:params:scim:api:messages:2.0:Error"
+	ScimServiceConfig      = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	ScimResourceTypeSchema = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+)
+
+// ScimToken is a bearer token an identity provider uses to call the SCIM API
+type ScimToken struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name        string             `bson:"name" json:"name"`
+	TokenHash   string             `bson:"token_hash" json:"-"`
+	TokenPrefix string             `bson:"token_prefix" json:"token_prefix"`
+	IsActive    bool               `bson:"is_active" json:"is_active"`
+	CreatedBy   primitive.ObjectID `bson:"created_by" json:"created_by"`
+	LastUsedAt  *time.Time         `bson:"last_used_at,omitempty" json:"last_used_at,omitempty"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// ScimGroup is a directory group pushed by an identity provider. Members of a
+// group mapped to a plan are moved onto that plan.
+type ScimGroup struct {
+	ID          primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
+	ExternalID  string               `bson:"external_id,omitempty" json:"external_id,omitempty"`
+	DisplayName string               `bson:"display_name" json:"display_name"`
+	Members     []primitive.ObjectID `bson:"members" json:"members"`
+	PlanID      *primitive.ObjectID  `bson:"plan_id,omitempty" json:"plan_id,omitempty"`
+	CreatedAt   time.Time            `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time            `bson:"updated_at" json:"updated_at"`
+}
+
+// ScimUser is the SCIM wire representation of a user
+type ScimUser struct {
+	Schemas     []string         `json:"schemas"`
+	ID          string           `json:"id,omitempty"`
+	ExternalID  string           `json:"externalId,omitempty"`
+	UserName    string           `json:"userName"`
+	Name        *ScimName        `json:"name,omitempty"`
+	DisplayName string           `json:"displayName,omitempty"`
+	Emails      []ScimMultiValue `json:"emails,omitempty"`
+	Active      *bool            `json:"active,omitempty"`
+	Groups      []ScimMember     `json:"groups,omitempty"`
+	Meta        *ScimMeta        `json:"meta,omitempty"`
+}
+
+type ScimName struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type ScimMultiValue struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// ScimGroupResource is the SCIM wire representation of a group
+type ScimGroupResource struct {
+	Schemas     []string     `json:"schemas"`
+	ID          string       `json:"id,omitempty"`
+	ExternalID  string       `json:"externalId,omitempty"`
+	DisplayName string       `json:"displayName"`
+	Members     []ScimMember `json:"members,omitempty"`
+	Meta        *ScimMeta    `json:"meta,omitempty"`
+}
+
+type ScimMember struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+type ScimMeta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+	Location     string    `json:"location,omitempty"`
+}
+
+type ScimListResponse struct {
+	Schemas      []string      `json:"schemas"`
+	TotalResults int           `json:"totalResults"`
+	StartIndex   int           `json:"startIndex"`
+	ItemsPerPage int           `json:"itemsPerPage"`
+	Resources    []interface{} `json:"Resources"`
+}
+
+type ScimPatchRequest struct {
+	Schemas    []string             `json:"schemas"`
+	Operations []ScimPatchOperation `json:"Operations"`
+}
+
+type ScimPatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+type ScimError struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail"`
+}
+
+// ScimTokenRequest creates a SCIM bearer token
+type ScimTokenRequest struct {
+	Name string `json:"name" validate:"required,max=100"`
+}
+
+// ScimGroupMappingRequest maps a directory group to a plan; an empty PlanID
+// removes the mapping
+type ScimGroupMappingRequest struct {
+	PlanID string `json:"plan_id"`
+}
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SecurityPolicy holds the admin-configurable password and lockout rules
+type SecurityPolicy struct {
+	ID        primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	Key       string              `bson:"key" json:"-"`
+	Password  PasswordPolicy      `bson:"password" json:"password"`
+	Lockout   LockoutPolicy       `bson:"lockout" json:"lockout"`
+	UpdatedBy *primitive.ObjectID `bson:"updated_by,omitempty" json:"updated_by,omitempty"`
+	UpdatedAt time.Time           `bson:"updated_at" json:"updated_at"`
+}
+
+// PasswordPolicy lists the complexity rules new passwords must meet
+type PasswordPolicy struct {
+	MinLength            int  `bson:"min_length" json:"min_length" validate:"min=6,max=128"`
+	RequireUppercase     bool `bson:"require_uppercase" json:"require_uppercase"`
+	RequireLowercase     bool `bson:"require_lowercase" json:"require_lowercase"`
+	RequireDigit         bool `bson:"require_digit" json:"require_digit"`
+	RequireSymbol        bool `bson:"require_symbol" json:"require_symbol"`
+	DisallowPersonalInfo bool `bson:"disallow_personal_info" json:"disallow_personal_info"` // no username, email or name
+	CheckBreached        bool `bson:"check_breached" json:"check_breached"`                 // reject passwords found in data breaches
+}
+
+// LockoutPolicy locks an account after repeated failed logins. Each lockout
+// in a row doubles in length up to MaxLockoutMinutes.
+type LockoutPolicy struct {
+	Enabled            bool `bson:"enabled" json:"enabled"`
+	MaxAttempts        int  `bson:"max_attempts" json:"max_attempts" validate:"min=1,max=100"`
+	WindowMinutes      int  `bson:"window_minutes" json:"window_minutes" validate:"min=1,max=1440"` // failures older than this are forgotten
+	BaseLockoutMinutes int  `bson:"base_lockout_minutes" json:"base_lockout_minutes" validate:"min=1,max=1440"`
+	MaxLockoutMinutes  int  `bson:"max_lockout_minutes" json:"max_lockout_minutes" validate:"min=1,max=43200"`
+}
+
+// SecurityPolicyRequest replaces the security policy
+type SecurityPolicyRequest struct {
+	Password PasswordPolicy `json:"password"`
+	Lockout  LockoutPolicy  `json:"lockout"`
+}
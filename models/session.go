@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Session is a signed-in device. Tokens carry its SessionID, so ending the
+// session invalidates them immediately. Each refresh replaces its refresh
+// token, so RefreshTokenID is the only one it accepts.
+type Session struct {
+	ID                primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	SessionID         string             `bson:"session_id" json:"-"`
+	RefreshTokenID    string             `bson:"refresh_token_id,omitempty" json:"-"`
+	DeviceFingerprint string             `bson:"device_fingerprint,omitempty" json:"-"`
+	UserID            primitive.ObjectID `bson:"user_id" json:"user_id"`
+	IPAddress         string             `bson:"ip_address,omitempty" json:"ip_address,omitempty"`
+	Country           string             `bson:"country,omitempty" json:"country,omitempty"`
+	Region            string             `bson:"region,omitempty" json:"region,omitempty"`
+	UserAgent         string             `bson:"user_agent" json:"user_agent"`
+	Device            string             `bson:"device" json:"device"`
+	IsActive          bool               `bson:"is_active" json:"is_active"`
+	IsCurrent         bool               `bson:"-" json:"is_current"`
+	CreatedAt         time.Time          `bson:"created_at" json:"created_at"`
+	LastActivity      time.Time          `bson:"last_activity" json:"last_activity"`
+	RefreshedAt       *time.Time         `bson:"refreshed_at,omitempty" json:"refreshed_at,omitempty"`
+	ExpiresAt         time.Time          `bson:"expires_at" json:"expires_at"`
+	EndedAt           *time.Time         `bson:"ended_at,omitempty" json:"ended_at,omitempty"`
+	EndReason         string             `bson:"end_reason,omitempty" json:"end_reason,omitempty"` // logout, revoked, password_changed, password_reset, refresh_token_reused, device_mismatch
+}
+
+// RevokedToken is an access token revoked before it expires. It is turned
+// away until then, when it is dropped.
+type RevokedToken struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	TokenID   string             `bson:"token_id" json:"token_id"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
+	SessionID string             `bson:"session_id,omitempty" json:"-"`
+	Reason    string             `bson:"reason" json:"reason"`
+	RevokedAt time.Time          `bson:"revoked_at" json:"revoked_at"`
+	ExpiresAt time.Time          `bson:"expires_at" json:"expires_at"`
+}
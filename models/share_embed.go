@@ -0,0 +1,73 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ShareEmbed is the hotlink protection of a file share's embeds: the sites
+// allowed to embed it, by the host of the Referer they send
+type ShareEmbed struct {
+	AllowedReferrers   []string `bson:"allowed_referrers,omitempty" json:"allowed_referrers,omitempty"` // hosts, or *.host for its subdomains; empty allows any site
+	BlockEmptyReferrer bool     `bson:"block_empty_referrer" json:"block_empty_referrer"`               // refuse requests sending no Referer
+}
+
+// ShareEmbedRequest sets the hotlink protection of a file share's embeds
+type ShareEmbedRequest struct {
+	AllowedReferrers   []string `json:"allowed_referrers" validate:"omitempty,max=50,dive,required,max=253"`
+	BlockEmptyReferrer bool     `json:"block_empty_referrer"`
+}
+
+// ShareEmbedURLRequest asks for a signed embed URL of a file share, valid
+// for ExpiresIn seconds, a day when unset
+type ShareEmbedURLRequest struct {
+	ExpiresIn int `json:"expires_in" validate:"omitempty,min=60,max=31536000"`
+}
+
+// ShareEmbedURL is a signed URL embedding a shared image or video, and the
+// HTML tag embedding it
+type ShareEmbedURL struct {
+	EmbedURL  string    `json:"embed_url"`
+	HTML      string    `json:"html"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ShareEmbedView counts a file share's embed views and blocked hotlinks
+// from one referrer on one day
+type ShareEmbedView struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	ShareID  primitive.ObjectID `bson:"share_id" json:"share_id"`
+	UserID   primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Day      time.Time          `bson:"day" json:"day"`
+	Referrer string             `bson:"referrer" json:"referrer"` // the Referer's host, "" for none
+	Views    int                `bson:"views" json:"views"`
+	Blocked  int                `bson:"blocked" json:"blocked"`
+}
+
+// ShareAnalytics is how a file share has been used: its downloads, and its
+// embed views by day and by referring site
+type ShareAnalytics struct {
+	ShareID         primitive.ObjectID   `json:"share_id"`
+	Downloads       int                  `json:"downloads"`
+	MaxDownloads    int                  `json:"max_downloads"`
+	EmbedViews      int                  `json:"embed_views"`
+	EmbedBlocked    int                  `json:"embed_blocked"`
+	LastEmbedViewAt *time.Time           `json:"last_embed_view_at,omitempty"`
+	Days            []ShareEmbedDay      `json:"days"`
+	Referrers       []ShareEmbedReferrer `json:"referrers"`
+}
+
+// ShareEmbedDay is a day of a share's embed views
+type ShareEmbedDay struct {
+	Date    string `json:"date" bson:"_id"`
+	Views   int    `json:"views" bson:"views"`
+	Blocked int    `json:"blocked" bson:"blocked"`
+}
+
+// ShareEmbedReferrer is a site's embed views of a share
+type ShareEmbedReferrer struct {
+	Referrer string `json:"referrer" bson:"_id"`
+	Views    int    `json:"views" bson:"views"`
+	Blocked  int    `json:"blocked" bson:"blocked"`
+}
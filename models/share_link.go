@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ShareSlugRequest gives a share link a custom name, such as press-kit for
+// /s/press-kit
+type ShareSlugRequest struct {
+	Slug string `json:"slug" validate:"required,min=3,max=64"`
+}
+
+// ShareDomain is the domain a user's share links are served on, such as
+// files.example.com. Links use it once a DNS TXT record on
+// _oncloud-verify.{domain} holding VerificationToken has been checked.
+type ShareDomain struct {
+	ID                primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID            primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Domain            string             `bson:"domain" json:"domain"`
+	VerificationToken string             `bson:"verification_token" json:"verification_token"`
+	VerificationHost  string             `bson:"-" json:"verification_host"` // where the TXT record goes
+	VerifiedAt        *time.Time         `bson:"verified_at,omitempty" json:"verified_at,omitempty"`
+	CreatedAt         time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt         time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// ShareDomainRequest sets the domain a user's share links are served on
+type ShareDomainRequest struct {
+	Domain string `json:"domain" validate:"required,fqdn,max=253"`
+}
@@ -0,0 +1,22 @@
+package models
+
+// SharePages lists the page images a view-only share shows of its file
+// in place of a download
+type SharePages struct {
+	Name      string      `json:"name"`
+	MimeType  string      `json:"mime_type"`
+	PageCount int         `json:"page_count"` // of the file, which may have more pages than are shown
+	Pages     []SharePage `json:"pages"`
+}
+
+type SharePage struct {
+	Number int    `json:"number"`
+	URL    string `json:"url"`
+}
+
+// ShareDocument is a Markdown document a share shows rendered to HTML,
+// with its images served from the share
+type ShareDocument struct {
+	Name string `json:"name"`
+	HTML string `json:"html"`
+}
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ShareWatermark is a copy of a shared file watermarked for one recipient,
+// made on their first download and served again until the file changes
+type ShareWatermark struct {
+	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	ShareID         primitive.ObjectID `bson:"share_id" json:"share_id"`
+	FileID          primitive.ObjectID `bson:"file_id" json:"file_id"`
+	Recipient       string             `bson:"recipient" json:"recipient"`
+	FileHash        string             `bson:"file_hash" json:"file_hash"` // the content the copy was made from
+	StorageProvider string             `bson:"storage_provider" json:"-"`
+	StorageKey      string             `bson:"storage_key" json:"-"`
+	ContentType     string             `bson:"content_type" json:"content_type"`
+	Size            int64              `bson:"size" json:"size"`
+	CreatedAt       time.Time          `bson:"created_at" json:"created_at"`
+}
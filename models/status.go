@@ -0,0 +1,47 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Component statuses on the status page
+const (
+	ComponentOperational = "operational"
+	ComponentDegraded    = "degraded"
+	ComponentDown        = "down"
+)
+
+// HealthCheck is the recorded result of checking a component. Uptime is
+// computed from these.
+type HealthCheck struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Component string             `bson:"component" json:"component"` // api, database, payments, or storage:<provider id>
+	Name      string             `bson:"name" json:"name"`
+	Status    string             `bson:"status" json:"status"`
+	LatencyMs int64              `bson:"latency_ms" json:"latency_ms"`
+	Error     string             `bson:"error,omitempty" json:"error,omitempty"`
+	CheckedAt time.Time          `bson:"checked_at" json:"checked_at"`
+}
+
+// StatusPage is the public summary of the health of the service. Its status
+// is that of its worst component.
+type StatusPage struct {
+	Status     string            `json:"status"`
+	Components []ComponentStatus `json:"components"`
+	UpdatedAt  time.Time         `json:"updated_at"`
+}
+
+// ComponentStatus is the current status of a component and the share of its
+// checks over the last day, week and month it was up in, as percentages.
+// Uptimes are null when there are no checks over the period.
+type ComponentStatus struct {
+	ID            string    `json:"id"`
+	Name          string    `json:"name"`
+	Status        string    `json:"status"`
+	LastCheckedAt time.Time `json:"last_checked_at"`
+	Uptime24h     *float64  `json:"uptime_24h"`
+	Uptime7d      *float64  `json:"uptime_7d"`
+	Uptime30d     *float64  `json:"uptime_30d"`
+}
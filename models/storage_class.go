@@ -0,0 +1,43 @@
+package models
+
+import "time"
+
+const (
+	FileRestoreInProgress = "in_progress"
+	FileRestoreCompleted  = "completed"
+)
+
+// FileRestore is the restore of a readable copy of an archived file, which
+// can be downloaded until RestoredUntil
+type FileRestore struct {
+	Status        string     `bson:"status" json:"status"`
+	Tier          string     `bson:"tier" json:"tier"` // Expedited, Standard or Bulk
+	Days          int        `bson:"days" json:"days"`
+	RequestedAt   time.Time  `bson:"requested_at" json:"requested_at"`
+	RestoredUntil *time.Time `bson:"restored_until,omitempty" json:"restored_until,omitempty"`
+}
+
+// StorageClassOption is a storage class files can be uploaded into or
+// moved to. Archived files must be restored before they can be downloaded.
+type StorageClassOption struct {
+	Name    string `json:"name"`
+	Archive bool   `json:"archive"`
+}
+
+// ProviderStorageClasses are the storage classes of the provider uploads
+// are stored with; the first is its standard class
+type ProviderStorageClasses struct {
+	Provider string               `json:"provider"`
+	Classes  []StorageClassOption `json:"classes"`
+}
+
+// FileStorageClassRequest moves a file to another storage class
+type FileStorageClassRequest struct {
+	StorageClass string `json:"storage_class" validate:"required,max=40"`
+}
+
+// FileRestoreRequest restores a readable copy of an archived file for Days
+type FileRestoreRequest struct {
+	Days int    `json:"days" validate:"required,min=1,max=30"`
+	Tier string `json:"tier" validate:"omitempty,oneof=Expedited Standard Bulk"`
+}
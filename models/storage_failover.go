@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// StorageFailover is a read a provider failed that was sent to one of its
+// replicas. ReplicaType is empty when no replica could serve it.
+type StorageFailover struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	ProviderType string             `bson:"provider_type" json:"provider_type"`
+	ReplicaType  string             `bson:"replica_type,omitempty" json:"replica_type,omitempty"`
+	Operation    string             `bson:"operation" json:"operation"` // download or presign
+	StorageKey   string             `bson:"storage_key" json:"storage_key"`
+	Error        string             `bson:"error" json:"error"` // of the provider
+	Succeeded    bool               `bson:"succeeded" json:"succeeded"`
+	ReplicaError string             `bson:"replica_error,omitempty" json:"replica_error,omitempty"`
+	CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// StorageFailoverSummary counts a provider's failovers over a period
+type StorageFailoverSummary struct {
+	ProviderType string     `bson:"_id" json:"provider_type"`
+	Failovers    int64      `bson:"failovers" json:"failovers"`
+	Failed       int64      `bson:"failed" json:"failed"` // no replica could serve the read
+	LastAt       *time.Time `bson:"last_at" json:"last_at"`
+}
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// LifecycleRule is a native object lifecycle rule of a provider's bucket,
+// applying to the objects whose keys start with Prefix
+type LifecycleRule struct {
+	ID                      string                `bson:"id" json:"id" validate:"required,max=255"`
+	Prefix                  string                `bson:"prefix" json:"prefix"`
+	Enabled                 bool                  `bson:"enabled" json:"enabled"`
+	AbortMultipartAfterDays int                   `bson:"abort_multipart_after_days,omitempty" json:"abort_multipart_after_days,omitempty" validate:"gte=0"`
+	ExpireAfterDays         int                   `bson:"expire_after_days,omitempty" json:"expire_after_days,omitempty" validate:"gte=0"`
+	Transitions             []LifecycleTransition `bson:"transitions,omitempty" json:"transitions,omitempty" validate:"dive"`
+}
+
+// LifecycleTransition moves objects to another storage class Days after they
+// are created
+type LifecycleTransition struct {
+	Days         int    `bson:"days" json:"days" validate:"gte=0"`
+	StorageClass string `bson:"storage_class" json:"storage_class" validate:"required"`
+}
+
+// LifecycleRulesRequest replaces the lifecycle rules oncloud manages for a
+// provider
+type LifecycleRulesRequest struct {
+	Rules []LifecycleRule `json:"rules" validate:"max=100,dive"`
+}
+
+// LifecycleConflict is a reason a lifecycle rule cannot be used alongside
+// the objects oncloud stores
+type LifecycleConflict struct {
+	RuleID string `json:"rule_id"`
+	Reason string `json:"reason"`
+}
+
+// LifecycleDrift is a difference between the rules oncloud manages and the
+// rules the bucket has
+type LifecycleDrift struct {
+	RuleID string `json:"rule_id"`
+	Issue  string `json:"issue"` // missing, changed, unmanaged
+}
+
+// ProviderLifecycle is a provider's lifecycle rules as oncloud manages them
+// and as its bucket has them
+type ProviderLifecycle struct {
+	ProviderID primitive.ObjectID  `json:"provider_id"`
+	Managed    []LifecycleRule     `json:"managed"`
+	Live       []LifecycleRule     `json:"live"`
+	InSync     bool                `json:"in_sync"`
+	Drift      []LifecycleDrift    `json:"drift"`
+	Conflicts  []LifecycleConflict `json:"conflicts"`
+	AppliedAt  *time.Time          `json:"applied_at,omitempty"`
+}
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// StorageObjectRef is a record referring to a stored object
+type StorageObjectRef struct {
+	Kind          string             `json:"kind"` // file, version, thumbnail
+	FileID        primitive.ObjectID `json:"file_id"`
+	FileName      string             `json:"file_name,omitempty"`
+	UserID        primitive.ObjectID `json:"user_id,omitempty"`
+	VersionNumber int                `json:"version_number,omitempty"`
+	IsDeleted     bool               `json:"is_deleted"` // in the trash
+}
+
+// StorageObject is an object in a provider's bucket with the records referring
+// to it. Orphaned objects have none.
+type StorageObject struct {
+	Key          string             `json:"key"`
+	Size         int64              `json:"size"`
+	LastModified time.Time          `json:"last_modified"`
+	Refs         []StorageObjectRef `json:"refs"`
+	Orphaned     bool               `json:"orphaned"`
+}
+
+// StorageObjectListing is a page of a provider's objects under a prefix, and
+// the "folders" under it
+type StorageObjectListing struct {
+	ProviderID   primitive.ObjectID `json:"provider_id"`
+	ProviderType string             `json:"provider_type"`
+	Bucket       string             `json:"bucket"`
+	Prefix       string             `json:"prefix"`
+	Prefixes     []string           `json:"prefixes"`
+	Objects      []StorageObject    `json:"objects"`
+	NextCursor   string             `json:"next_cursor,omitempty"`
+}
+
+// StorageObjectDetails describes an object as its provider stores it, with
+// the records referring to it. Missing objects are referred to but not
+// stored.
+type StorageObjectDetails struct {
+	Key          string             `json:"key"`
+	Exists       bool               `json:"exists"`
+	Size         int64              `json:"size,omitempty"`
+	LastModified *time.Time         `json:"last_modified,omitempty"`
+	ContentType  string             `json:"content_type,omitempty"`
+	ETag         string             `json:"etag,omitempty"`
+	StorageClass string             `json:"storage_class,omitempty"`
+	Metadata     map[string]string  `json:"metadata,omitempty"`
+	Refs         []StorageObjectRef `json:"refs"`
+	Orphaned     bool               `json:"orphaned"`
+	Missing      bool               `json:"missing"`
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// StoragePricing holds the USD price list used to estimate provider costs.
+// An empty Region applies to every region of the provider type.
+type StoragePricing struct {
+	ID                primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	ProviderType      string             `bson:"provider_type" json:"provider_type" validate:"required,oneof=s3 wasabi r2 local"`
+	Region            string             `bson:"region" json:"region"`
+	StoragePerGBMonth float64            `bson:"storage_per_gb_month" json:"storage_per_gb_month" validate:"gte=0"`
+	EgressPerGB       float64            `bson:"egress_per_gb" json:"egress_per_gb" validate:"gte=0"`
+	FreeEgressGB      float64            `bson:"free_egress_gb" json:"free_egress_gb" validate:"gte=0"`     // per month
+	ClassAPer1000     float64            `bson:"class_a_per_1000" json:"class_a_per_1000" validate:"gte=0"` // writes: put, copy, list, multipart
+	ClassBPer1000     float64            `bson:"class_b_per_1000" json:"class_b_per_1000" validate:"gte=0"` // reads: get
+	Notes             string             `bson:"notes" json:"notes"`
+	CreatedAt         time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt         time.Time          `bson:"updated_at" json:"updated_at"`
+}
@@ -22,11 +22,51 @@ type StorageProvider struct {
 	IsActive     bool                   `bson:"is_active" json:"is_active"`
 	IsDefault    bool                   `bson:"is_default" json:"is_default"`
 	Priority     int                    `bson:"priority" json:"priority"`
+	KeyTemplate  string                 `bson:"key_template" json:"key_template" validate:"omitempty,storage_key_template"`
 	StorageUsed  int64                  `bson:"storage_used" json:"storage_used"`
 	FilesCount   int                    `bson:"files_count" json:"files_count"`
 	LastSyncAt   *time.Time             `bson:"last_sync_at,omitempty" json:"last_sync_at,omitempty"`
 	CreatedAt    time.Time              `bson:"created_at" json:"created_at"`
 	UpdatedAt    time.Time              `bson:"updated_at" json:"updated_at"`
+
+	// LifecycleRules are the bucket lifecycle rules oncloud manages, set
+	// through the provider's lifecycle endpoints
+	LifecycleRules     []LifecycleRule `bson:"lifecycle_rules,omitempty" json:"-"`
+	LifecycleAppliedAt *time.Time      `bson:"lifecycle_applied_at,omitempty" json:"lifecycle_applied_at,omitempty"`
+
+	// Resilience overrides how calls to the provider are retried, timed out
+	// and cut off while it keeps failing
+	Resilience *ProviderResilience `bson:"resilience,omitempty" json:"resilience,omitempty"`
+	// ReplicaTypes are the types of the providers objects are replicated to
+	// under the same keys, in the order reads fail over to them when this
+	// provider can't serve them
+	ReplicaTypes []string `bson:"replica_types,omitempty" json:"replica_types,omitempty" validate:"max=3,dive,storage_provider"`
+}
+
+// ProviderResilience is a provider's retry, timeout and circuit breaker
+// policy. Unset fields use the server defaults.
+type ProviderResilience struct {
+	MaxRetries             *int `bson:"max_retries,omitempty" json:"max_retries,omitempty" validate:"omitempty,min=0,max=10"`
+	RetryBackoffMs         int  `bson:"retry_backoff_ms,omitempty" json:"retry_backoff_ms,omitempty" validate:"omitempty,min=1,max=60000"`  // doubled on every retry
+	TimeoutSeconds         int  `bson:"timeout_seconds,omitempty" json:"timeout_seconds,omitempty" validate:"omitempty,min=1,max=3600"`     // of a single attempt
+	BreakerThreshold       int  `bson:"breaker_threshold,omitempty" json:"breaker_threshold,omitempty" validate:"omitempty,min=1,max=1000"` // consecutive failures that open the breaker
+	BreakerCooldownSeconds int  `bson:"breaker_cooldown_seconds,omitempty" json:"breaker_cooldown_seconds,omitempty" validate:"omitempty,min=1,max=3600"`
+}
+
+// ProviderBreakerState is the circuit breaker of a provider on this
+// instance. While it is open calls to the provider fail fast; once the
+// cooldown passes a single call is let through to probe it.
+type ProviderBreakerState struct {
+	ProviderType        string     `json:"provider_type"`
+	State               string     `json:"state"` // closed, open, half_open
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastError           string     `json:"last_error,omitempty"`
+	LastFailureAt       *time.Time `json:"last_failure_at,omitempty"`
+	OpenedAt            *time.Time `json:"opened_at,omitempty"`
+	RetryAt             *time.Time `json:"retry_at,omitempty"` // when the next probe is let through
+	Opens               int64      `json:"opens"`              // since the process started
+	Rejected            int64      `json:"rejected"`           // calls failed fast while open
+	Failovers           int64      `json:"failovers"`          // reads sent to a replica instead
 }
 
 type StorageStats struct {
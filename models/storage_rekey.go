@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	RekeyJobRunning   = "running"
+	RekeyJobCompleted = "completed"
+	RekeyJobFailed    = "failed"
+)
+
+// StorageRekeyJob moves the objects of a provider's files to the keys its
+// current key template gives them
+type StorageRekeyJob struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	ProviderID   primitive.ObjectID `bson:"provider_id" json:"provider_id"`
+	ProviderType string             `bson:"provider_type" json:"provider_type"`
+	KeyTemplate  string             `bson:"key_template" json:"key_template"`
+	DryRun       bool               `bson:"dry_run" json:"dry_run"`
+	Status       string             `bson:"status" json:"status"`
+	Total        int                `bson:"total" json:"total"`
+	Rekeyed      int                `bson:"rekeyed" json:"rekeyed"`
+	Unchanged    int                `bson:"unchanged" json:"unchanged"`
+	Failed       int                `bson:"failed" json:"failed"`
+	// Samples shows the first keys which change, so a dry run can be checked
+	Samples     []RekeySample      `bson:"samples" json:"samples"`
+	Errors      []string           `bson:"errors" json:"errors"`
+	Error       string             `bson:"error,omitempty" json:"error,omitempty"`
+	StartedBy   primitive.ObjectID `bson:"started_by" json:"started_by"`
+	StartedAt   time.Time          `bson:"started_at" json:"started_at"`
+	CompletedAt *time.Time         `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+}
+
+type RekeySample struct {
+	FileID primitive.ObjectID `bson:"file_id" json:"file_id"`
+	From   string             `bson:"from" json:"from"`
+	To     string             `bson:"to" json:"to"`
+}
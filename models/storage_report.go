@@ -0,0 +1,80 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// StorageReport is a user's monthly summary of their storage, made at the
+// start of the next month and emailed to them unless they unsubscribed
+type StorageReport struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID      primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Period      string             `bson:"period" json:"period"` // the month, as 2006-01
+	PeriodStart time.Time          `bson:"period_start" json:"period_start"`
+	PeriodEnd   time.Time          `bson:"period_end" json:"period_end"`
+
+	StorageUsed   int64  `bson:"storage_used" json:"storage_used"`
+	StorageChange *int64 `bson:"storage_change,omitempty" json:"storage_change,omitempty"` // since the last report, none for the first
+	FilesCount    int    `bson:"files_count" json:"files_count"`
+	FilesUploaded int    `bson:"files_uploaded" json:"files_uploaded"` // during the month, still kept
+	BytesUploaded int64  `bson:"bytes_uploaded" json:"bytes_uploaded"`
+
+	BiggestFiles []ReportFile     `bson:"biggest_files" json:"biggest_files"`
+	Duplicates   ReportDuplicates `bson:"duplicates" json:"duplicates"`
+
+	// BandwidthUsed is the bandwidth used during the month, told from the
+	// running total kept on the user, BandwidthTotal, against the report of
+	// the month before; the first report has the total so far
+	BandwidthUsed  int64 `bson:"bandwidth_used" json:"bandwidth_used"`
+	BandwidthTotal int64 `bson:"bandwidth_total" json:"-"`
+
+	Plan *ReportPlan `bson:"plan,omitempty" json:"plan,omitempty"`
+
+	Emailed   bool       `bson:"emailed" json:"emailed"`
+	EmailedAt *time.Time `bson:"emailed_at,omitempty" json:"emailed_at,omitempty"`
+	CreatedAt time.Time  `bson:"created_at" json:"created_at"`
+}
+
+type ReportFile struct {
+	ID   primitive.ObjectID `bson:"id" json:"id"`
+	Name string             `bson:"name" json:"name"`
+	Size int64              `bson:"size" json:"size"`
+}
+
+// ReportDuplicates are the files kept more than once, by content. Copies
+// counts the copies beyond the first, which Reclaimable bytes are.
+type ReportDuplicates struct {
+	Groups      int   `bson:"groups" json:"groups"`
+	Copies      int   `bson:"copies" json:"copies"`
+	Reclaimable int64 `bson:"reclaimable" json:"reclaimable"`
+}
+
+// ReportPlan weighs the cost of a user's plan against their usage.
+// Suggested is a plan that fits the usage better: a cheaper one that holds
+// it, or a bigger one when the usage is close to the plan's limits.
+type ReportPlan struct {
+	Name             string      `bson:"name" json:"name"`
+	MonthlyPrice     float64     `bson:"monthly_price" json:"monthly_price"`
+	Currency         string      `bson:"currency" json:"currency"`
+	StorageLimit     int64       `bson:"storage_limit" json:"storage_limit"`
+	BandwidthLimit   int64       `bson:"bandwidth_limit" json:"bandwidth_limit"`
+	StoragePercent   float64     `bson:"storage_percent" json:"storage_percent"`
+	BandwidthPercent float64     `bson:"bandwidth_percent" json:"bandwidth_percent"`
+	CostPerGB        float64     `bson:"cost_per_gb,omitempty" json:"cost_per_gb,omitempty"` // of the storage used
+	Suggested        *PlanAdvice `bson:"suggested,omitempty" json:"suggested,omitempty"`
+}
+
+type PlanAdvice struct {
+	PlanID       primitive.ObjectID `bson:"plan_id" json:"plan_id"`
+	Name         string             `bson:"name" json:"name"`
+	MonthlyPrice float64            `bson:"monthly_price" json:"monthly_price"`
+	Reason       string             `bson:"reason" json:"reason"` // cheaper or more_room
+}
+
+// UnsubscribeRequest unsubscribes the user an email was sent to, by the
+// token of its unsubscribe link
+type UnsubscribeRequest struct {
+	Token string `json:"token" validate:"required"`
+}
@@ -0,0 +1,111 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Support ticket statuses. Open tickets await support staff, pending ones
+// await the customer, and resolved or closed tickets need nothing further.
+const (
+	TicketOpen     = "open"
+	TicketPending  = "pending"
+	TicketResolved = "resolved"
+	TicketClosed   = "closed"
+)
+
+// Support ticket priorities
+const (
+	TicketPriorityLow    = "low"
+	TicketPriorityNormal = "normal"
+	TicketPriorityHigh   = "high"
+	TicketPriorityUrgent = "urgent"
+)
+
+// SupportNote is an internal note support staff keep on a user's account.
+// Notes are never shown to the user.
+type SupportNote struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Body      string             `bson:"body" json:"body"`
+	Pinned    bool               `bson:"pinned" json:"pinned"`
+	CreatedBy primitive.ObjectID `bson:"created_by" json:"created_by"`
+	UpdatedBy primitive.ObjectID `bson:"updated_by,omitempty" json:"updated_by,omitempty"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// SupportTicket is a support request about a user's account, optionally
+// about some of their files
+type SupportTicket struct {
+	ID          primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
+	UserID      primitive.ObjectID   `bson:"user_id" json:"user_id"`
+	FileIDs     []primitive.ObjectID `bson:"file_ids,omitempty" json:"file_ids,omitempty"`
+	Subject     string               `bson:"subject" json:"subject"`
+	Description string               `bson:"description,omitempty" json:"description,omitempty"`
+	Status      string               `bson:"status" json:"status"`
+	Priority    string               `bson:"priority" json:"priority"`
+	Category    string               `bson:"category,omitempty" json:"category,omitempty"`
+	AssignedTo  *primitive.ObjectID  `bson:"assigned_to,omitempty" json:"assigned_to,omitempty"`
+
+	// The ticket's copy in the external helpdesk it was exported to
+	External *SupportTicketExternal `bson:"external,omitempty" json:"external,omitempty"`
+
+	History   []SupportTicketEvent `bson:"history" json:"history"`
+	CreatedBy primitive.ObjectID   `bson:"created_by" json:"created_by"`
+	CreatedAt time.Time            `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time            `bson:"updated_at" json:"updated_at"`
+	ClosedAt  *time.Time           `bson:"closed_at,omitempty" json:"closed_at,omitempty"`
+}
+
+// SupportTicketExternal links a ticket to its copy in an external helpdesk
+type SupportTicketExternal struct {
+	Provider   string    `bson:"provider" json:"provider"` // zendesk, freshdesk, webhook
+	ID         string    `bson:"id,omitempty" json:"id,omitempty"`
+	URL        string    `bson:"url,omitempty" json:"url,omitempty"`
+	ExportedAt time.Time `bson:"exported_at" json:"exported_at"`
+}
+
+// SupportTicketEvent is one entry of a ticket's history
+type SupportTicketEvent struct {
+	Action  string              `bson:"action" json:"action"` // created, updated, comment, exported, export_failed
+	ActorID *primitive.ObjectID `bson:"actor_id,omitempty" json:"actor_id,omitempty"`
+	Notes   string              `bson:"notes,omitempty" json:"notes,omitempty"`
+	Changes map[string]string   `bson:"changes,omitempty" json:"changes,omitempty"`
+	At      time.Time           `bson:"at" json:"at"`
+}
+
+// SupportNoteRequest adds or edits an internal note on a user's account
+type SupportNoteRequest struct {
+	Body   string `json:"body" validate:"required,max=5000"`
+	Pinned bool   `json:"pinned"`
+}
+
+// SupportTicketRequest opens a ticket for a user, optionally about some of
+// their files
+type SupportTicketRequest struct {
+	UserID      string   `json:"user_id" validate:"required,objectid"`
+	FileIDs     []string `json:"file_ids" validate:"max=50,dive,objectid"`
+	Subject     string   `json:"subject" validate:"required,max=200"`
+	Description string   `json:"description" validate:"max=10000"`
+	Priority    string   `json:"priority" validate:"omitempty,oneof=low normal high urgent"`
+	Category    string   `json:"category" validate:"max=100"`
+	AssignedTo  string   `json:"assigned_to" validate:"omitempty,objectid"`
+	Export      *bool    `json:"export"` // overrides HELPDESK_AUTO_EXPORT
+}
+
+// SupportTicketUpdateRequest changes a ticket's status, priority, category
+// or assignee, with an optional note recorded in its history
+type SupportTicketUpdateRequest struct {
+	Status     *string `json:"status" validate:"omitempty,oneof=open pending resolved closed"`
+	Priority   *string `json:"priority" validate:"omitempty,oneof=low normal high urgent"`
+	Category   *string `json:"category" validate:"omitempty,max=100"`
+	AssignedTo *string `json:"assigned_to" validate:"omitempty,objectid"`
+	Notes      string  `json:"notes" validate:"max=5000"`
+}
+
+// SupportTicketCommentRequest adds a comment to a ticket's history
+type SupportTicketCommentRequest struct {
+	Notes string `json:"notes" validate:"required,max=5000"`
+}
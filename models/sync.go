@@ -0,0 +1,16 @@
+package models
+
+// SyncChange is a file or folder that was created or changed, including
+// being moved to the trash. Exactly one of File and Folder is set.
+type SyncChange struct {
+	File   *File   `json:"file,omitempty"`
+	Folder *Folder `json:"folder,omitempty"`
+}
+
+// SyncChanges is a page of the change feed. Cursor is passed back to get
+// the changes after this page; HasMore tells whether there are more now.
+type SyncChanges struct {
+	Changes []SyncChange `json:"changes"`
+	Cursor  string       `json:"cursor"`
+	HasMore bool         `json:"has_more"`
+}
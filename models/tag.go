@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Tag is a tag in use on a user's files. Count is the number of files
+// outside the trash that carry it.
+type Tag struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Name      string             `bson:"name" json:"name"`
+	Count     int                `bson:"count" json:"count"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// TagRenameRequest renames a tag on all of the user's files
+type TagRenameRequest struct {
+	Name string `json:"name" validate:"required,max=50"`
+}
+
+// TagMergeRequest folds the source tags, given by ID, into the target tag,
+// which is created if it does not exist
+type TagMergeRequest struct {
+	SourceIDs []string `json:"source_ids" validate:"required,min=1,dive,required"`
+	Target    string   `json:"target" validate:"required,max=50"`
+}
@@ -0,0 +1,116 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Takedown case statuses. A case starts active with the content disabled.
+// The owner may dispute it with a counter notice, after which the content
+// is restored at RestoreAt unless an admin upholds the takedown first.
+const (
+	TakedownActive         = "active"
+	TakedownCounterNoticed = "counter_noticed"
+	TakedownRestored       = "restored"
+	TakedownUpheld         = "upheld"
+	TakedownWithdrawn      = "withdrawn"
+)
+
+// TakedownCase is a DMCA notice against a file or folder and everything that
+// happened to it since
+type TakedownCase struct {
+	ID       primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	FileID   *primitive.ObjectID `bson:"file_id,omitempty" json:"file_id,omitempty"`
+	FolderID *primitive.ObjectID `bson:"folder_id,omitempty" json:"folder_id,omitempty"`
+	OwnerID  primitive.ObjectID  `bson:"owner_id" json:"owner_id"`
+	Status   string              `bson:"status" json:"status"`
+
+	Claimant         TakedownParty `bson:"claimant" json:"claimant"`
+	CopyrightedWork  string        `bson:"copyrighted_work" json:"copyrighted_work"`
+	InfringingURLs   []string      `bson:"infringing_urls,omitempty" json:"infringing_urls,omitempty"`
+	NoticeReceivedAt time.Time     `bson:"notice_received_at" json:"notice_received_at"`
+
+	// What was disabled, so that restoring brings exactly that back
+	DisabledShares []primitive.ObjectID `bson:"disabled_shares,omitempty" json:"disabled_shares,omitempty"`
+	ShareToken     string               `bson:"share_token,omitempty" json:"share_token,omitempty"`
+	WasShared      bool                 `bson:"was_shared" json:"was_shared"`
+	WasPublic      bool                 `bson:"was_public" json:"was_public"`
+
+	CounterNoticeDueAt time.Time              `bson:"counter_notice_due_at" json:"counter_notice_due_at"`
+	CounterNotice      *TakedownCounterNotice `bson:"counter_notice,omitempty" json:"counter_notice,omitempty"`
+	RestoreAt          *time.Time             `bson:"restore_at,omitempty" json:"restore_at,omitempty"`
+	ClosedAt           *time.Time             `bson:"closed_at,omitempty" json:"closed_at,omitempty"`
+
+	History   []TakedownEvent    `bson:"history" json:"history"`
+	CreatedBy primitive.ObjectID `bson:"created_by" json:"created_by"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// TakedownParty identifies the claimant of a notice
+type TakedownParty struct {
+	Name         string `bson:"name" json:"name" validate:"required,max=200"`
+	Email        string `bson:"email" json:"email" validate:"required,email,max=255"`
+	Organization string `bson:"organization,omitempty" json:"organization,omitempty" validate:"max=200"`
+	Address      string `bson:"address,omitempty" json:"address,omitempty" validate:"max=500"`
+	Phone        string `bson:"phone,omitempty" json:"phone,omitempty" validate:"max=50"`
+}
+
+// TakedownCounterNotice is the owner's dispute of a takedown
+type TakedownCounterNotice struct {
+	Name        string    `bson:"name" json:"name"`
+	Email       string    `bson:"email" json:"email"`
+	Address     string    `bson:"address" json:"address"`
+	Phone       string    `bson:"phone" json:"phone"`
+	Statement   string    `bson:"statement" json:"statement"`
+	Signature   string    `bson:"signature" json:"signature"`
+	SubmittedAt time.Time `bson:"submitted_at" json:"submitted_at"`
+}
+
+// TakedownEvent is one entry of a case's history
+type TakedownEvent struct {
+	Action    string              `bson:"action" json:"action"`
+	ActorType string              `bson:"actor_type" json:"actor_type"` // admin, user, system
+	ActorID   *primitive.ObjectID `bson:"actor_id,omitempty" json:"actor_id,omitempty"`
+	Notes     string              `bson:"notes,omitempty" json:"notes,omitempty"`
+	At        time.Time           `bson:"at" json:"at"`
+}
+
+// TakedownNoticeRequest registers a DMCA notice against a file or folder,
+// given by ID or by the token of a link to it
+type TakedownNoticeRequest struct {
+	FileID           string        `json:"file_id" validate:"omitempty,objectid"`
+	FolderID         string        `json:"folder_id" validate:"omitempty,objectid"`
+	Token            string        `json:"token" validate:"max=128"`
+	Claimant         TakedownParty `json:"claimant"`
+	CopyrightedWork  string        `json:"copyrighted_work" validate:"required,max=2000"`
+	InfringingURLs   []string      `json:"infringing_urls" validate:"max=50,dive,required,url,max=2000"`
+	NoticeReceivedAt *time.Time    `json:"notice_received_at"`
+	Notes            string        `json:"notes" validate:"max=2000"`
+}
+
+// TakedownCounterNoticeRequest disputes a takedown. The owner must consent
+// to the jurisdiction of the courts and state their good faith belief that
+// the content was removed by mistake.
+type TakedownCounterNoticeRequest struct {
+	Name                  string `json:"name" validate:"required,max=200"`
+	Email                 string `json:"email" validate:"required,email,max=255"`
+	Address               string `json:"address" validate:"required,max=500"`
+	Phone                 string `json:"phone" validate:"required,max=50"`
+	Statement             string `json:"statement" validate:"required,max=5000"`
+	ConsentToJurisdiction bool   `json:"consent_to_jurisdiction" validate:"required"`
+	Signature             string `json:"signature" validate:"required,max=200"`
+}
+
+// TakedownResolveRequest closes a case: restore the content, uphold the
+// takedown, or record that the claimant withdrew the notice
+type TakedownResolveRequest struct {
+	Action string `json:"action" validate:"required,oneof=restore uphold withdraw"`
+	Notes  string `json:"notes" validate:"max=2000"`
+}
+
+// TakedownNoteRequest adds a note to a case's history
+type TakedownNoteRequest struct {
+	Notes string `json:"notes" validate:"required,max=2000"`
+}
@@ -0,0 +1,18 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// TextPreview is the start of a text or code file, decoded to UTF-8, with
+// what the frontend needs to highlight it
+type TextPreview struct {
+	FileID    primitive.ObjectID `json:"file_id"`
+	Name      string             `json:"name"`
+	MimeType  string             `json:"mime_type"`
+	Language  string             `json:"language"` // as highlight.js names it, "plaintext" when unknown
+	Encoding  string             `json:"encoding"` // of the file: utf-8, utf-16le, utf-16be or iso-8859-1
+	BOM       bool               `json:"bom"`
+	LineCount int                `json:"line_count"` // of the whole file
+	Size      int64              `json:"size"`
+	Truncated bool               `json:"truncated"` // whether Content is only the start of the file
+	Content   string             `json:"content"`
+}
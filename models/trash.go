@@ -0,0 +1,77 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TrashLocation is the folder an item in the trash was deleted from
+type TrashLocation struct {
+	FolderID *primitive.ObjectID `json:"folder_id,omitempty"` // none for the root
+	Path     string              `json:"path"`
+	// Available is false once the folder is gone or in the trash itself, in
+	// which case the item is restored to the root unless a folder is chosen
+	Available bool `json:"available"`
+}
+
+// TrashedFile is a file in the trash listing
+type TrashedFile struct {
+	File
+	OriginalLocation TrashLocation `json:"original_location"`
+}
+
+// TrashedFolder is a folder in the trash listing, with the subfolders and
+// files deleted along with it counted in its totals
+type TrashedFolder struct {
+	Folder
+	OriginalLocation TrashLocation `json:"original_location"`
+}
+
+// RestoreRequest optionally chooses the folder a file or folder is restored
+// into instead of the one it was deleted from. An empty FolderID with Root
+// set restores to the root.
+type RestoreRequest struct {
+	FolderID string `json:"folder_id" validate:"omitempty,objectid"`
+	Root     bool   `json:"root"`
+}
+
+// Trash emptying statuses. A request waits for confirmation, then for its
+// grace period, during which it can still be canceled, before it runs.
+const (
+	TrashEmptyPending   = "pending"
+	TrashEmptyScheduled = "scheduled"
+	TrashEmptyRunning   = "running"
+	TrashEmptyCompleted = "completed"
+	TrashEmptyFailed    = "failed"
+	TrashEmptyCanceled  = "canceled"
+)
+
+// TrashEmptyJob permanently deletes what was in a user's trash when they
+// asked for it to be emptied. Items trashed later are left alone. Files
+// under a takedown, or whose object could not be deleted, are kept.
+type TrashEmptyJob struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID        primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Status        string             `bson:"status" json:"status"`
+	TokenHash     string             `bson:"token_hash" json:"-"`
+	ConfirmBy     time.Time          `bson:"confirm_by" json:"confirm_by"` // when an unconfirmed request lapses
+	TrashedBefore time.Time          `bson:"trashed_before" json:"trashed_before"`
+	Files         int                `bson:"files" json:"files"`
+	Folders       int                `bson:"folders" json:"folders"`
+	Size          int64              `bson:"size" json:"size"`
+	RunAt         *time.Time         `bson:"run_at,omitempty" json:"run_at,omitempty"`
+	PurgedFiles   int                `bson:"purged_files" json:"purged_files"`
+	PurgedFolders int                `bson:"purged_folders" json:"purged_folders"`
+	KeptFiles     int                `bson:"kept_files" json:"kept_files"`
+	Error         string             `bson:"error,omitempty" json:"error,omitempty"`
+	CreatedAt     time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt     time.Time          `bson:"updated_at" json:"updated_at"`
+	CompletedAt   *time.Time         `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+}
+
+// TrashEmptyConfirmRequest confirms emptying the trash with the token the
+// request to empty it was answered with
+type TrashEmptyConfirmRequest struct {
+	Token string `json:"token" validate:"required"`
+}
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Tus upload statuses
+const (
+	TusUploadInProgress = "in_progress"
+	TusUploadCompleted  = "completed"
+)
+
+// TusUpload is a resumable upload made with the tus protocol. Its bytes are
+// appended to a file in the chunk upload directory until Offset reaches
+// Length, when the file is created from them.
+type TusUpload struct {
+	ID        primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	UserID    primitive.ObjectID  `bson:"user_id" json:"user_id"`
+	Length    int64               `bson:"length" json:"length"`
+	Offset    int64               `bson:"offset" json:"offset"`
+	Metadata  map[string]string   `bson:"metadata,omitempty" json:"metadata,omitempty"` // from the Upload-Metadata header
+	FileName  string              `bson:"file_name" json:"file_name"`
+	FolderID  string              `bson:"folder_id,omitempty" json:"folder_id,omitempty"`
+	Status    string              `bson:"status" json:"status"`
+	FileID    *primitive.ObjectID `bson:"file_id,omitempty" json:"file_id,omitempty"` // once completed
+	ExpiresAt time.Time           `bson:"expires_at" json:"expires_at"`
+	CreatedAt time.Time           `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time           `bson:"updated_at" json:"updated_at"`
+}
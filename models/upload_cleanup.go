@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// UploadCleanupRun reports what a run of the abandoned upload cleanup
+// removed and how much space it reclaimed
+type UploadCleanupRun struct {
+	ID                     primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Trigger                string             `bson:"trigger" json:"trigger"` // scheduled, admin
+	SessionsExpired        int                `bson:"sessions_expired" json:"sessions_expired"`
+	ProviderUploadsAborted int                `bson:"provider_uploads_aborted" json:"provider_uploads_aborted"`
+	ChunkUploadsRemoved    int                `bson:"chunk_uploads_removed" json:"chunk_uploads_removed"`
+	ReclaimedBytes         int64              `bson:"reclaimed_bytes" json:"reclaimed_bytes"`
+	Errors                 []string           `bson:"errors" json:"errors"`
+	StartedAt              time.Time          `bson:"started_at" json:"started_at"`
+	CompletedAt            time.Time          `bson:"completed_at" json:"completed_at"`
+}
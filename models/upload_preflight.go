@@ -0,0 +1,44 @@
+package models
+
+// Reasons an upload pre-flight check fails
+const (
+	PreflightStorageLimit = "storage_limit"
+	PreflightFilesLimit   = "files_limit"
+	PreflightMaxFileSize  = "max_file_size"
+	PreflightFileType     = "file_type"
+	PreflightDuplicate    = "duplicate"
+	PreflightFolder       = "folder"
+)
+
+// UploadPreflightRequest describes a file a client is about to upload. Hash
+// is the MD5 of the content, used to find an existing copy of the file.
+type UploadPreflightRequest struct {
+	FileName string `json:"file_name" validate:"required,max=255"`
+	Size     int64  `json:"size" validate:"gte=0"`
+	MimeType string `json:"mime_type" validate:"omitempty,max=255"`
+	Hash     string `json:"hash" validate:"omitempty,len=32,hexadecimal"`
+	FolderID string `json:"folder_id" validate:"omitempty,objectid"`
+}
+
+// UploadPreflightResult reports whether an upload would be accepted and, if
+// not, every reason it would be refused
+type UploadPreflightResult struct {
+	Allowed       bool              `json:"allowed"`
+	MimeType      string            `json:"mime_type"`
+	MaxFileSize   int64             `json:"max_file_size"`
+	StorageLeft   int64             `json:"storage_left"`
+	Issues        []PreflightIssue  `json:"issues"`
+	DuplicateFile *PreflightFileRef `json:"duplicate_file,omitempty"`
+}
+
+// PreflightIssue is a reason an upload would be refused
+type PreflightIssue struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// PreflightFileRef identifies an existing file matching an upload
+type PreflightFileRef struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
@@ -0,0 +1,91 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Where an upload came from, as upload rules match it
+const (
+	UploadSourceUpload  = "upload" // the web app or the REST API
+	UploadSourceSync    = "sync"
+	UploadSourceEmail   = "email"
+	UploadSourceChat    = "chat"
+	UploadSourceImport  = "import"
+	UploadSourceRequest = "request" // a guest, through a file request
+)
+
+// UploadRule files uploads matching it into FolderPath, a folder path
+// template such as /Photos/{year}/{month}, and tags them. A user's rules are
+// tried in position order and the first match applies.
+type UploadRule struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID     primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Name       string             `bson:"name" json:"name"`
+	Position   int                `bson:"position" json:"position"`
+	IsEnabled  bool               `bson:"is_enabled" json:"is_enabled"`
+	Match      UploadRuleMatch    `bson:"match" json:"match"`
+	FolderPath string             `bson:"folder_path" json:"folder_path"`
+	Tags       []string           `bson:"tags" json:"tags"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt  time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// UploadRuleMatch selects the uploads a rule applies to. Empty conditions
+// match every upload; a list matches any of its values.
+type UploadRuleMatch struct {
+	Sources     []string `bson:"sources,omitempty" json:"sources,omitempty" validate:"omitempty,max=6,dive,oneof=upload sync email chat import request"`
+	Categories  []string `bson:"categories,omitempty" json:"categories,omitempty" validate:"omitempty,max=7,dive,oneof=image video audio document text archive other"`
+	Extensions  []string `bson:"extensions,omitempty" json:"extensions,omitempty" validate:"omitempty,max=50,dive,min=1,max=20"`
+	NamePattern string   `bson:"name_pattern,omitempty" json:"name_pattern,omitempty" validate:"omitempty,max=255"` // a glob such as invoice*.pdf, ignoring case
+	MinSize     *int64   `bson:"min_size,omitempty" json:"min_size,omitempty" validate:"omitempty,gte=0"`
+	MaxSize     *int64   `bson:"max_size,omitempty" json:"max_size,omitempty" validate:"omitempty,gte=0"`
+}
+
+// UploadRuleRequest creates or replaces an upload rule. A new rule without
+// a position goes last.
+type UploadRuleRequest struct {
+	Name       string          `json:"name" validate:"required,max=100"`
+	Position   *int            `json:"position" validate:"omitempty,gte=0"`
+	IsEnabled  *bool           `json:"is_enabled"`
+	Match      UploadRuleMatch `json:"match"`
+	FolderPath string          `json:"folder_path" validate:"required,max=1000,folder_path_template"`
+	Tags       []string        `json:"tags" validate:"omitempty,max=20,dive,min=1,max=50"`
+}
+
+// UploadSettings are a user's upload defaults. DefaultFolderPath, a folder
+// path template, receives uploads made without a folder that no rule
+// matches. Rules only apply to uploads made without a folder unless
+// OverrideFolder is set.
+type UploadSettings struct {
+	UserID            primitive.ObjectID `bson:"user_id" json:"-"`
+	DefaultFolderPath string             `bson:"default_folder_path,omitempty" json:"default_folder_path,omitempty"`
+	OverrideFolder    bool               `bson:"override_folder" json:"override_folder"`
+	UpdatedAt         time.Time          `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
+}
+
+// UploadSettingsRequest replaces a user's upload settings
+type UploadSettingsRequest struct {
+	DefaultFolderPath string `json:"default_folder_path" validate:"omitempty,max=1000,folder_path_template"`
+	OverrideFolder    bool   `json:"override_folder"`
+}
+
+// UploadRulePreviewRequest describes an upload to show where the rules
+// would put it, without uploading anything
+type UploadRulePreviewRequest struct {
+	FileName string `json:"file_name" validate:"required,max=255"`
+	Size     int64  `json:"size" validate:"gte=0"`
+	Source   string `json:"source" validate:"omitempty,oneof=upload sync email chat import request"`
+	FolderID string `json:"folder_id" validate:"omitempty,objectid"`
+}
+
+// UploadPlacement is where the rules put an upload. Rule is nil when no
+// rule matched; FolderPath is empty when the upload stays where it was made.
+type UploadPlacement struct {
+	Rule         *UploadRule `json:"rule,omitempty"`
+	IsDefault    bool        `json:"is_default"` // placed by the default folder path
+	FolderPath   string      `json:"folder_path,omitempty"`
+	FolderExists bool        `json:"folder_exists"` // otherwise the folders are created on upload
+	Tags         []string    `json:"tags"`
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// UsageDrift is a user whose storage_used or files_count counter disagrees
+// with their files
+type UsageDrift struct {
+	UserID        primitive.ObjectID `json:"user_id"`
+	Email         string             `json:"email"`
+	StorageUsed   int64              `json:"storage_used"`
+	ActualStorage int64              `json:"actual_storage"`
+	FilesCount    int                `json:"files_count"`
+	ActualFiles   int                `json:"actual_files"`
+	Repaired      bool               `json:"repaired"`
+}
+
+// StorageDrift is how many bytes the counter is off by, positive when it
+// counts too much
+func (d *UsageDrift) StorageDrift() int64 {
+	return d.StorageUsed - d.ActualStorage
+}
+
+// UsageCheckReport is the result of checking all users' usage counters
+// against their files. Drift lists the largest drifts only.
+type UsageCheckReport struct {
+	CheckedUsers  int          `json:"checked_users"`
+	DriftedUsers  int          `json:"drifted_users"`
+	RepairedUsers int          `json:"repaired_users"`
+	Drift         []UsageDrift `json:"drift"`
+	CheckedAt     time.Time    `json:"checked_at"`
+}
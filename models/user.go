@@ -15,17 +15,29 @@ type User struct {
 	Avatar          string            `bson:"avatar" json:"avatar"`
 	Phone           string            `bson:"phone" json:"phone"`
 	Country         string            `bson:"country" json:"country"`
+	Region          string            `bson:"region,omitempty" json:"region,omitempty"`
 	PlanID          primitive.ObjectID `bson:"plan_id" json:"plan_id"`
 	StorageUsed     int64             `bson:"storage_used" json:"storage_used"` // in bytes
 	BandwidthUsed   int64             `bson:"bandwidth_used" json:"bandwidth_used"` // in bytes
 	FilesCount      int               `bson:"files_count" json:"files_count"`
 	FoldersCount    int               `bson:"folders_count" json:"folders_count"`
 	IsActive        bool              `bson:"is_active" json:"is_active"`
+	State           string            `bson:"state,omitempty" json:"state,omitempty"` // read_only, pending_deletion; suspension is is_active=false
+	StateReason     string            `bson:"state_reason,omitempty" json:"state_reason,omitempty"`
+	StateChangedAt  *time.Time        `bson:"state_changed_at,omitempty" json:"state_changed_at,omitempty"`
 	IsVerified      bool              `bson:"is_verified" json:"is_verified"`
 	IsPremium       bool              `bson:"is_premium" json:"is_premium"`
 	EmailVerifiedAt *time.Time        `bson:"email_verified_at,omitempty" json:"email_verified_at,omitempty"`
 	LastLoginAt     *time.Time        `bson:"last_login_at,omitempty" json:"last_login_at,omitempty"`
+	LastLoginFrom   *GeoLocation      `bson:"last_login_from,omitempty" json:"last_login_from,omitempty"`
+	FailedLogins    int               `bson:"failed_logins,omitempty" json:"-"`
+	LastFailedLogin *time.Time        `bson:"last_failed_login_at,omitempty" json:"-"`
+	LockoutCount    int               `bson:"lockout_count,omitempty" json:"-"`
+	LockedUntil     *time.Time        `bson:"locked_until,omitempty" json:"locked_until,omitempty"`
 	PlanExpiresAt   *time.Time        `bson:"plan_expires_at,omitempty" json:"plan_expires_at,omitempty"`
+	ExternalID      string            `bson:"external_id,omitempty" json:"external_id,omitempty"`       // identity provider ID for SCIM-managed users
+	ProvisionedBy   string            `bson:"provisioned_by,omitempty" json:"provisioned_by,omitempty"` // scim
+	DeprovisionedAt *time.Time        `bson:"deprovisioned_at,omitempty" json:"deprovisioned_at,omitempty"`
 	CreatedAt       time.Time         `bson:"created_at" json:"created_at"`
 	UpdatedAt       time.Time         `bson:"updated_at" json:"updated_at"`
 }
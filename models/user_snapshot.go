@@ -0,0 +1,79 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// UserSnapshot is everything support staff look at about an account, read
+// in one go
+type UserSnapshot struct {
+	User           *User                    `json:"user"`
+	Plan           *Plan                    `json:"plan,omitempty"`
+	Usage          *UserStats               `json:"usage"`
+	RecentActivity []map[string]interface{} `json:"recent_activity"`
+	OpenShares     []FileShare              `json:"open_shares"`
+	OpenShareCount int64                    `json:"open_share_count"`
+	Providers      []ProviderUsage          `json:"providers"`
+	Billing        UserBilling              `json:"billing"`
+	Flags          UserFlags                `json:"flags"`
+	GeneratedAt    time.Time                `json:"generated_at"`
+}
+
+// ProviderUsage is how many of a user's files, and how many bytes, are kept
+// with a storage provider
+type ProviderUsage struct {
+	Provider string `bson:"_id" json:"provider"`
+	Files    int64  `bson:"files" json:"files"`
+	Size     int64  `bson:"size" json:"size"`
+}
+
+// UserBilling is where an account stands with paying for its plan
+type UserBilling struct {
+	Status            string                 `json:"status"` // free, active, expired, cancelled
+	IsPremium         bool                   `json:"is_premium"`
+	PlanExpiresAt     *time.Time             `json:"plan_expires_at,omitempty"`
+	Subscription      map[string]interface{} `json:"subscription,omitempty"` // the latest subscription record
+	LastPayment       *Payment               `json:"last_payment,omitempty"`
+	TotalPaid         float64                `json:"total_paid"`
+	CompletedPayments int64                  `json:"completed_payments"`
+}
+
+// Payment is a payment made by a user. Refunds leave it completed, with
+// what was given back in AmountRefunded.
+type Payment struct {
+	ID             primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	UserID         primitive.ObjectID  `bson:"user_id" json:"user_id"`
+	InvoiceID      *primitive.ObjectID `bson:"invoice_id,omitempty" json:"invoice_id,omitempty"`
+	Amount         float64             `bson:"amount" json:"amount"`
+	AmountRefunded float64             `bson:"amount_refunded,omitempty" json:"amount_refunded,omitempty"`
+	Currency       string              `bson:"currency" json:"currency"`
+	Status         string              `bson:"status" json:"status"`
+	Method         string              `bson:"payment_method,omitempty" json:"payment_method,omitempty"`
+	TransactionID  string              `bson:"transaction_id,omitempty" json:"transaction_id,omitempty"` // the gateway's charge
+	Disputed       bool                `bson:"disputed,omitempty" json:"disputed,omitempty"`
+	CreatedAt      time.Time           `bson:"created_at" json:"created_at"`
+}
+
+// UserFlags are the states of an account support staff need to notice
+type UserFlags struct {
+	AccountState       string     `json:"account_state"` // active, suspended, read_only, pending_deletion
+	StateReason        string     `json:"state_reason,omitempty"`
+	Suspended          bool       `json:"suspended"`
+	SuspensionReason   string     `json:"suspension_reason,omitempty"`
+	SuspendedAt        *time.Time `json:"suspended_at,omitempty"`
+	Deleted            bool       `json:"deleted"`
+	Unverified         bool       `json:"unverified"`
+	Locked             bool       `json:"locked"`
+	ScimManaged        bool       `json:"scim_managed"`
+	Deprovisioned      bool       `json:"deprovisioned"`
+	OverStorageLimit   bool       `json:"over_storage_limit"`
+	OverBandwidthLimit bool       `json:"over_bandwidth_limit"`
+	ActiveSessions     int64      `json:"active_sessions"`
+	OpenTakedowns      int64      `json:"open_takedowns"`
+	OpenAbuseReports   int64      `json:"open_abuse_reports"`
+	OpenTickets        int64      `json:"open_tickets"`
+	Chargeback         bool       `json:"chargeback"` // a payment of theirs was disputed and the dispute not won
+	OpenDisputes       int64      `json:"open_disputes"`
+}
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// WarehouseExportState is the export checkpoint for one source collection
+type WarehouseExportState struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Source         string             `bson:"source" json:"source"` // analytics, activities
+	Sink           string             `bson:"sink" json:"sink"`
+	LastExportedID primitive.ObjectID `bson:"last_exported_id" json:"last_exported_id"`
+	LastEventAt    *time.Time         `bson:"last_event_at,omitempty" json:"last_event_at,omitempty"`
+	ExportedCount  int64              `bson:"exported_count" json:"exported_count"`
+	LastRunAt      *time.Time         `bson:"last_run_at,omitempty" json:"last_run_at,omitempty"`
+	LastSuccessAt  *time.Time         `bson:"last_success_at,omitempty" json:"last_success_at,omitempty"`
+	LastError      string             `bson:"last_error,omitempty" json:"last_error,omitempty"`
+	UpdatedAt      time.Time          `bson:"updated_at" json:"updated_at"`
+}
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// WopiSession is one user's editor session on a document. The office editor
+// server calls the WOPI endpoints with the session's access token.
+type WopiSession struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	FileID       primitive.ObjectID `bson:"file_id" json:"file_id"`
+	UserID       primitive.ObjectID `bson:"user_id" json:"user_id"`
+	TokenHash    string             `bson:"token_hash" json:"-"`
+	Mode         string             `bson:"mode" json:"mode"` // edit, view
+	IPAddress    string             `bson:"ip_address" json:"ip_address"`
+	UserAgent    string             `bson:"user_agent" json:"user_agent"`
+	LastActiveAt time.Time          `bson:"last_active_at" json:"last_active_at"`
+	EndedAt      *time.Time         `bson:"ended_at,omitempty" json:"ended_at,omitempty"`
+	CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
+	ExpiresAt    time.Time          `bson:"expires_at" json:"expires_at"`
+}
+
+// WopiEditorLaunch is what a client needs to open the editor: a form POST of
+// access_token and access_token_ttl to EditorURL
+type WopiEditorLaunch struct {
+	Session        *WopiSession `json:"session"`
+	EditorURL      string       `json:"editor_url"`
+	AccessToken    string       `json:"access_token"`
+	AccessTokenTTL int64        `json:"access_token_ttl"` // Unix milliseconds
+}
+
+// WopiSessionRequest opens an editor session
+type WopiSessionRequest struct {
+	Mode string `json:"mode" validate:"omitempty,oneof=edit view"`
+}
+
+// WopiCheckFileInfo is the CheckFileInfo response of the WOPI protocol
+type WopiCheckFileInfo struct {
+	BaseFileName               string `json:"BaseFileName"`
+	OwnerId                    string `json:"OwnerId"`
+	Size                       int64  `json:"Size"`
+	UserId                     string `json:"UserId"`
+	UserFriendlyName           string `json:"UserFriendlyName"`
+	Version                    string `json:"Version"`
+	LastModifiedTime           string `json:"LastModifiedTime"`
+	ReadOnly                   bool   `json:"ReadOnly"`
+	UserCanWrite               bool   `json:"UserCanWrite"`
+	UserCanNotWriteRelative    bool   `json:"UserCanNotWriteRelative"`
+	SupportsUpdate             bool   `json:"SupportsUpdate"`
+	SupportsLocks              bool   `json:"SupportsLocks"`
+	SupportsGetLock            bool   `json:"SupportsGetLock"`
+	SupportsExtendedLockLength bool   `json:"SupportsExtendedLockLength"`
+}
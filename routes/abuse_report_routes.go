@@ -0,0 +1,23 @@
+package routes
+
+import (
+	"oncloud/controllers"
+	"oncloud/middleware"
+	"oncloud/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func AbuseReportRoutes(r *gin.RouterGroup) {
+	abuseReportController := controllers.NewAbuseReportController()
+
+	// Public: anyone who opened a link can report it. Folder links are
+	// reported by their token like file links.
+	report := []gin.HandlerFunc{
+		middleware.RateLimitWithType("report"),
+		middleware.ValidateJSON[models.AbuseReportRequest](),
+		abuseReportController.ReportLink,
+	}
+	r.POST("/shared/:token/report", report...)
+	r.POST("/public/:token/report", report...)
+}
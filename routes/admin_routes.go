@@ -3,19 +3,40 @@ package routes
 import (
 	"oncloud/controllers"
 	"oncloud/middleware"
+	"oncloud/models"
+	"oncloud/services"
 
 	"github.com/gin-gonic/gin"
 )
 
-func AdminRoutes(r *gin.RouterGroup) {
-	adminController := controllers.NewAdminController()
-	userAdminController := controllers.NewUserAdminController()
-	fileAdminController := controllers.NewFileAdminController()
+func AdminRoutes(r *gin.RouterGroup, deps services.Dependencies) {
+	adminController := controllers.NewAdminController(deps)
+	userAdminController := controllers.NewUserAdminController(deps)
+	fileAdminController := controllers.NewFileAdminController(deps)
 	settingsController := controllers.NewSettingsController()
+	brandingController := controllers.NewBrandingController()
+	maintenanceController := controllers.NewMaintenanceController()
+	announcementController := controllers.NewAnnouncementController()
+	abuseReportController := controllers.NewAbuseReportController()
+	takedownController := controllers.NewTakedownController()
+	supportController := controllers.NewSupportController()
+	storageKeyController := controllers.NewStorageKeyController(deps)
+	storageLifecycleController := controllers.NewStorageLifecycleController()
+	uploadCleanupController := controllers.NewUploadCleanupController(deps)
+	orphanGCController := controllers.NewOrphanGCController()
+	storageExplorerController := controllers.NewStorageExplorerController()
+	hotFileCacheController := controllers.NewHotFileCacheController(deps)
+	objectCacheController := controllers.NewObjectCacheController()
+	compressionController := controllers.NewCompressionController()
 	analyticsController := controllers.NewAnalyticsController()
+	scimController := controllers.NewScimController()
+	fileTypePolicyController := controllers.NewFileTypePolicyController()
+	activityArchiveController := controllers.NewActivityArchiveController(deps)
+	featureFlagController := controllers.NewFeatureFlagController()
+	billingController := controllers.NewBillingController()
 
 	// Admin authentication
-	r.POST("/login", adminController.Login)
+	r.POST("/login", middleware.ValidateJSON[models.LoginRequest](), adminController.Login)
 	r.POST("/logout", adminController.Logout)
 
 	// Protected admin routes
@@ -24,25 +45,74 @@ func AdminRoutes(r *gin.RouterGroup) {
 	{
 		// Dashboard and analytics
 		api.GET("/dashboard", analyticsController.GetDashboard)
+		api.POST("/dashboard/refresh", analyticsController.RefreshDashboard)
+		api.POST("/analytics/rollups/backfill", analyticsController.BackfillRollups)
+		api.GET("/analytics/export", analyticsController.GetWarehouseExportStatus)
+		api.POST("/analytics/export/run", analyticsController.RunWarehouseExport)
 		api.GET("/analytics/users", analyticsController.GetUserAnalytics)
+		api.GET("/analytics/cohorts", analyticsController.GetCohortRetention)
 		api.GET("/analytics/files", analyticsController.GetFileAnalytics)
 		api.GET("/analytics/storage", analyticsController.GetStorageAnalytics)
 		api.GET("/analytics/revenue", analyticsController.GetRevenueAnalytics)
+		api.GET("/analytics/system", analyticsController.GetSystemMetrics)
+
+		// Activities past the retention period, moved to cold storage
+		activityArchives := api.Group("/activity-archives")
+		{
+			activityArchives.GET("/", activityArchiveController.GetArchives)
+			activityArchives.GET("/activities", activityArchiveController.GetArchivedActivities)
+			activityArchives.POST("/run", activityArchiveController.RunArchive)
+		}
+
+		// Usage and error anomalies
+		anomalies := api.Group("/anomalies")
+		{
+			anomalies.GET("/", analyticsController.GetAnomalies)
+			anomalies.POST("/:id/acknowledge", analyticsController.AcknowledgeAnomaly)
+			anomalies.POST("/:id/resolve", analyticsController.ResolveAnomaly)
+		}
 
 		// User management
 		users := api.Group("/users")
 		{
 			users.GET("/", userAdminController.GetUsers)
 			users.GET("/:id", userAdminController.GetUser)
-			users.POST("/", userAdminController.CreateUser)
-			users.PUT("/:id", userAdminController.UpdateUser)
+			users.GET("/:id/snapshot", userAdminController.GetUserSnapshot)
+			users.POST("/", middleware.ValidateJSON[models.AdminUserCreateRequest](), userAdminController.CreateUser)
+			users.PUT("/:id", middleware.ValidateJSON[models.AdminUserUpdateRequest](), userAdminController.UpdateUser)
 			users.DELETE("/:id", userAdminController.DeleteUser)
-			users.POST("/:id/suspend", userAdminController.SuspendUser)
+			users.POST("/:id/suspend", middleware.ValidateJSON[models.SuspendUserRequest](), userAdminController.SuspendUser)
 			users.POST("/:id/unsuspend", userAdminController.UnsuspendUser)
+			users.PUT("/:id/state", middleware.ValidateJSON[models.AccountStateRequest](), userAdminController.SetUserState)
 			users.POST("/:id/verify", userAdminController.VerifyUser)
-			users.POST("/:id/reset-password", userAdminController.ResetUserPassword)
+			users.POST("/:id/reset-password", middleware.ValidateJSON[models.AdminPasswordResetRequest](), userAdminController.ResetUserPassword)
+			users.POST("/:id/unlock", userAdminController.UnlockUser)
 			users.GET("/:id/files", userAdminController.GetUserFiles)
 			users.GET("/:id/activity", userAdminController.GetUserActivity)
+
+			// Internal notes support staff keep on the account
+			users.GET("/:id/notes", supportController.GetUserNotes)
+			users.POST("/:id/notes", middleware.ValidateJSON[models.SupportNoteRequest](), supportController.AddUserNote)
+			users.PUT("/:id/notes/:note_id", middleware.ValidateJSON[models.SupportNoteRequest](), supportController.UpdateUserNote)
+			users.DELETE("/:id/notes/:note_id", supportController.DeleteUserNote)
+		}
+
+		// Support tickets, exportable to an external helpdesk
+		tickets := api.Group("/support-tickets")
+		{
+			tickets.GET("/", supportController.GetTickets)
+			tickets.POST("/", middleware.ValidateJSON[models.SupportTicketRequest](), supportController.CreateTicket)
+			tickets.GET("/:id", supportController.GetTicket)
+			tickets.PUT("/:id", middleware.ValidateJSON[models.SupportTicketUpdateRequest](), supportController.UpdateTicket)
+			tickets.POST("/:id/comments", middleware.ValidateJSON[models.SupportTicketCommentRequest](), supportController.AddTicketComment)
+			tickets.POST("/:id/export", supportController.ExportTicket)
+		}
+
+		// Users' storage and file counters checked against their files
+		usage := api.Group("/usage")
+		{
+			usage.GET("/drift", userAdminController.CheckUsage)
+			usage.POST("/drift/repair", userAdminController.RepairUsage)
 		}
 
 		// File management
@@ -50,11 +120,53 @@ func AdminRoutes(r *gin.RouterGroup) {
 		{
 			files.GET("/", fileAdminController.GetFiles)
 			files.GET("/:id", fileAdminController.GetFile)
-			files.DELETE("/:id", fileAdminController.DeleteFile)
+			files.DELETE("/:id", middleware.ValidateJSON[models.AdminFileDeleteRequest](), fileAdminController.DeleteFile)
 			files.POST("/:id/restore", fileAdminController.RestoreFile)
-			files.PUT("/:id/moderate", fileAdminController.ModerateFile)
+			files.PUT("/:id/moderate", middleware.ValidateJSON[models.FileModerationRequest](), fileAdminController.ModerateFile)
 			files.GET("/reported", fileAdminController.GetReportedFiles)
-			files.POST("/:id/scan", fileAdminController.ScanFile)
+			files.POST("/:id/scan", middleware.ValidateJSON[models.FileScanRequest](), fileAdminController.ScanFile)
+			files.DELETE("/:id/lock", fileAdminController.UnlockFile)
+		}
+
+		// Blocked file types and the users trusted past them
+		fileTypePolicies := api.Group("/file-type-policies")
+		{
+			fileTypePolicies.GET("/", fileTypePolicyController.GetPolicies)
+			fileTypePolicies.POST("/", middleware.ValidateJSON[models.FileTypePolicyRequest](), fileTypePolicyController.CreatePolicy)
+			fileTypePolicies.PUT("/:id", middleware.ValidateJSON[models.FileTypePolicyRequest](), fileTypePolicyController.UpdatePolicy)
+			fileTypePolicies.DELETE("/:id", fileTypePolicyController.DeletePolicy)
+		}
+		fileTypeOverrides := api.Group("/file-type-overrides")
+		{
+			fileTypeOverrides.GET("/", fileTypePolicyController.GetOverrides)
+			fileTypeOverrides.PUT("/:user_id", middleware.ValidateJSON[models.FileTypeOverrideRequest](), fileTypePolicyController.SetOverride)
+			fileTypeOverrides.DELETE("/:user_id", fileTypePolicyController.RemoveOverride)
+		}
+
+		// Feature flags rolling out risky subsystems and frontend features
+		featureFlags := api.Group("/feature-flags")
+		{
+			featureFlags.GET("/", featureFlagController.GetFlags)
+			featureFlags.PUT("/:key", middleware.ValidateJSON[models.FeatureFlagRequest](), featureFlagController.SetFlag)
+			featureFlags.DELETE("/:key", featureFlagController.DeleteFlag)
+		}
+
+		// Abuse reports about share links
+		abuseReports := api.Group("/abuse-reports")
+		{
+			abuseReports.GET("/", abuseReportController.GetReports)
+			abuseReports.GET("/:id", abuseReportController.GetReport)
+			abuseReports.PUT("/:id", middleware.ValidateJSON[models.AbuseReportTriageRequest](), abuseReportController.TriageReport)
+		}
+
+		// DMCA takedown cases
+		takedowns := api.Group("/takedowns")
+		{
+			takedowns.GET("/", takedownController.GetTakedowns)
+			takedowns.POST("/", middleware.ValidateJSON[models.TakedownNoticeRequest](), takedownController.CreateTakedown)
+			takedowns.GET("/:id", takedownController.GetTakedown)
+			takedowns.POST("/:id/notes", middleware.ValidateJSON[models.TakedownNoteRequest](), takedownController.AddTakedownNote)
+			takedowns.POST("/:id/resolve", middleware.ValidateJSON[models.TakedownResolveRequest](), takedownController.ResolveTakedown)
 		}
 
 		// Plan management
@@ -62,23 +174,98 @@ func AdminRoutes(r *gin.RouterGroup) {
 		{
 			plans.GET("/", adminController.GetPlans)
 			plans.GET("/:id", adminController.GetPlan)
-			plans.POST("/", adminController.CreatePlan)
-			plans.PUT("/:id", adminController.UpdatePlan)
+			plans.POST("/", middleware.ValidateJSON[models.Plan](), adminController.CreatePlan)
+			plans.PUT("/:id", middleware.ValidateJSON[models.PlanUpdateRequest](), adminController.UpdatePlan)
 			plans.DELETE("/:id", adminController.DeletePlan)
 			plans.POST("/:id/activate", adminController.ActivatePlan)
 			plans.POST("/:id/deactivate", adminController.DeactivatePlan)
 		}
 
+		// Refunds of payments and the chargebacks the payment gateway reports
+		api.POST("/payments/:id/refunds", middleware.ValidateJSON[models.RefundRequest](), billingController.RefundPayment)
+		api.GET("/refunds", billingController.GetRefunds)
+		disputes := api.Group("/disputes")
+		{
+			disputes.GET("/", billingController.GetDisputes)
+			disputes.POST("/:id/release", billingController.ReleaseDispute)
+		}
+
 		// Storage provider management
 		providers := api.Group("/storage-providers")
 		{
 			providers.GET("/", adminController.GetStorageProviders)
 			providers.GET("/:id", adminController.GetStorageProvider)
-			providers.POST("/", adminController.CreateStorageProvider)
-			providers.PUT("/:id", adminController.UpdateStorageProvider)
+			providers.POST("/", middleware.ValidateJSON[models.StorageProvider](), adminController.CreateStorageProvider)
+			providers.PUT("/:id", middleware.ValidateJSON[models.StorageProviderUpdateRequest](), adminController.UpdateStorageProvider)
 			providers.DELETE("/:id", adminController.DeleteStorageProvider)
 			providers.POST("/:id/test", adminController.TestStorageProvider)
 			providers.POST("/:id/sync", adminController.SyncStorageProvider)
+			providers.POST("/:id/rekey", storageKeyController.StartRekey)
+			providers.GET("/:id/rekey", storageKeyController.GetRekeyJobs)
+			providers.GET("/:id/rekey/:job_id", storageKeyController.GetRekeyJob)
+			providers.GET("/:id/lifecycle", storageLifecycleController.GetLifecycle)
+			providers.PUT("/:id/lifecycle", middleware.ValidateJSON[models.LifecycleRulesRequest](), storageLifecycleController.SetLifecycle)
+			providers.POST("/:id/lifecycle/reconcile", storageLifecycleController.ReconcileLifecycle)
+			providers.POST("/:id/orphan-scans", orphanGCController.StartOrphanScan)
+			providers.GET("/:id/orphan-scans", orphanGCController.GetOrphanScans)
+			providers.GET("/:id/orphan-scans/:scan_id", orphanGCController.GetOrphanScan)
+			providers.GET("/:id/objects", storageExplorerController.ListObjects)
+			providers.GET("/:id/objects/head", storageExplorerController.HeadObject)
+			providers.DELETE("/:id/objects", storageExplorerController.DeleteObject)
+		}
+
+		// Abandoned multipart and chunked upload cleanup
+		uploads := api.Group("/uploads")
+		{
+			uploads.GET("/cleanup-runs", uploadCleanupController.GetCleanupRuns)
+			uploads.POST("/cleanup", uploadCleanupController.RunCleanup)
+		}
+
+		// Popular public and shared files pre-warmed in the CDN and local cache
+		cache := api.Group("/edge-cache")
+		{
+			cache.GET("/hot-files", hotFileCacheController.GetHotFiles)
+			cache.POST("/warm", hotFileCacheController.WarmCache)
+		}
+
+		// Disk cache of objects read from remote providers, per instance
+		objectCache := api.Group("/object-cache")
+		{
+			objectCache.GET("/", objectCacheController.GetObjectCacheStats)
+			objectCache.DELETE("/", objectCacheController.PurgeObjectCache)
+		}
+
+		// Space saved by compressing text-like files at rest
+		api.GET("/compression/savings", compressionController.GetCompressionSavings)
+
+		// Storage pricing used for cost analysis
+		pricing := api.Group("/storage-pricing")
+		{
+			pricing.GET("/", adminController.GetStoragePricing)
+			pricing.GET("/:id", adminController.GetStoragePricingByID)
+			pricing.POST("/", middleware.ValidateJSON[models.StoragePricing](), adminController.CreateStoragePricing)
+			pricing.PUT("/:id", middleware.ValidateJSON[models.StoragePricingUpdateRequest](), adminController.UpdateStoragePricing)
+			pricing.DELETE("/:id", adminController.DeleteStoragePricing)
+		}
+
+		// Security: global IP access policy, password/lockout policy and audit trail
+		security := api.Group("/security")
+		{
+			security.GET("/access-policy", adminController.GetGlobalAccessPolicy)
+			security.PUT("/access-policy", adminController.UpdateGlobalAccessPolicy)
+			security.GET("/policy", adminController.GetSecurityPolicy)
+			security.PUT("/policy", adminController.UpdateSecurityPolicy)
+			security.GET("/audit-logs", adminController.GetAuditLogs)
+		}
+
+		// SCIM provisioning tokens and group-to-plan mappings
+		scim := api.Group("/scim")
+		{
+			scim.GET("/tokens", scimController.GetTokens)
+			scim.POST("/tokens", scimController.CreateToken)
+			scim.DELETE("/tokens/:id", scimController.RevokeToken)
+			scim.GET("/groups", scimController.GetGroupMappings)
+			scim.PUT("/groups/:id/mapping", scimController.UpdateGroupMapping)
 		}
 
 		// System settings
@@ -87,30 +274,63 @@ func AdminRoutes(r *gin.RouterGroup) {
 			settings.GET("/", settingsController.GetSettings)
 			settings.PUT("/", settingsController.UpdateSettings)
 			settings.GET("/groups", settingsController.GetSettingGroups)
+			settings.GET("/runtime", settingsController.GetRuntimeSettings)
+			settings.PUT("/runtime/:key", settingsController.UpdateRuntimeSetting)
+			settings.DELETE("/runtime/:key", settingsController.ResetRuntimeSetting)
 			settings.GET("/:group", settingsController.GetSettingsByGroup)
 			settings.PUT("/:key", settingsController.UpdateSetting)
 			settings.POST("/backup", settingsController.BackupSettings)
 			settings.POST("/restore", settingsController.RestoreSettings)
 		}
 
+		// Branding of the admin panel, share pages and emails
+		branding := api.Group("/branding")
+		{
+			branding.GET("/", brandingController.GetBranding)
+			branding.PUT("/", brandingController.UpdateBranding)
+			branding.DELETE("/", brandingController.ResetBranding)
+		}
+
+		// Maintenance mode and scheduled maintenance windows
+		maintenance := api.Group("/maintenance")
+		{
+			maintenance.GET("/", maintenanceController.GetStatus)
+			maintenance.PUT("/", maintenanceController.SetMode)
+			maintenance.GET("/windows", maintenanceController.GetWindows)
+			maintenance.POST("/windows", maintenanceController.ScheduleWindow)
+			maintenance.DELETE("/windows/:id", maintenanceController.CancelWindow)
+		}
+
+		// Announcement banners, scheduled and targeted at users
+		announcements := api.Group("/announcements")
+		{
+			announcements.GET("/", announcementController.GetAnnouncements)
+			announcements.POST("/", middleware.ValidateJSON[models.AnnouncementRequest](), announcementController.CreateAnnouncement)
+			announcements.PUT("/:id", middleware.ValidateJSON[models.AnnouncementRequest](), announcementController.UpdateAnnouncement)
+			announcements.DELETE("/:id", announcementController.DeleteAnnouncement)
+		}
+
 		// System maintenance
 		system := api.Group("/system")
 		{
 			system.GET("/info", adminController.GetSystemInfo)
-			system.POST("/cache/clear", adminController.ClearCache)
-			system.POST("/logs/clear", adminController.ClearLogs)
+			system.GET("/health", adminController.GetSystemHealth)
+			system.GET("/storage-failovers", adminController.GetStorageFailovers)
+			system.POST("/cache/clear", middleware.ValidateJSON[models.ClearCacheRequest](), adminController.ClearCache)
+			system.POST("/logs/clear", middleware.ValidateJSON[models.ClearLogsRequest](), adminController.ClearLogs)
 			system.GET("/logs", adminController.GetLogs)
-			system.POST("/backup", adminController.CreateSystemBackup)
+			system.POST("/backup", middleware.ValidateJSON[models.SystemBackupRequest](), adminController.CreateSystemBackup)
 			system.GET("/backups", adminController.GetSystemBackups)
 		}
 	}
 }
 
 // Admin panel HTML routes
-func AdminPanelRoutes(r *gin.Engine) {
-	adminController := controllers.NewDashboardController()
+func AdminPanelRoutes(r *gin.Engine, deps services.Dependencies) {
+	adminController := controllers.NewDashboardController(deps)
 
 	admin := r.Group("/admin")
+	admin.Use(middleware.AdminPanelEnabledMiddleware())
 	{
 		// Login page (public)
 		admin.GET("/login", adminController.LoginPage)
@@ -141,6 +361,10 @@ func AdminPanelRoutes(r *gin.Engine) {
 			protected.GET("/settings/general", adminController.GeneralSettingsPage)
 			protected.GET("/settings/storage", adminController.StorageSettingsPage)
 			protected.GET("/settings/pricing", adminController.PricingSettingsPage)
+			protected.GET("/settings/runtime", adminController.RuntimeSettingsPage)
+			protected.POST("/settings/runtime", adminController.UpdateRuntimeSettingPage)
+			protected.GET("/settings/branding", adminController.BrandingSettingsPage)
+			protected.POST("/settings/branding", adminController.UpdateBrandingPage)
 
 			// Analytics pages
 			protected.GET("/analytics", adminController.AnalyticsPage)
@@ -0,0 +1,16 @@
+package routes
+
+import (
+	"oncloud/controllers"
+	"oncloud/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+func AnnouncementRoutes(r *gin.RouterGroup) {
+	announcementController := controllers.NewAnnouncementController()
+
+	// Polled by clients; signed-out visitors see announcements for everyone
+	r.GET("/announcements", middleware.OptionalAuthMiddleware(), announcementController.GetActiveAnnouncements)
+	r.POST("/announcements/:id/dismiss", middleware.AuthMiddleware(), announcementController.DismissAnnouncement)
+}
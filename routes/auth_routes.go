@@ -15,7 +15,11 @@ func AuthRoutes(r *gin.RouterGroup) {
 		// Public authentication routes
 		auth.POST("/register", authController.Register)
 		auth.POST("/login", authController.Login)
+		auth.POST("/login/verify", authController.VerifyLogin)
+		auth.POST("/refresh", authController.RefreshToken)
+		auth.GET("/password-policy", authController.GetPasswordPolicy)
 		auth.POST("/forgot-password", authController.ForgotPassword)
+		auth.GET("/reset-password/:token", authController.CheckResetToken)
 		auth.POST("/reset-password", authController.ResetPassword)
 		auth.GET("/verify-email/:token", authController.VerifyEmail)
 		auth.POST("/resend-verification", authController.ResendVerification)
@@ -31,7 +35,7 @@ func AuthRoutes(r *gin.RouterGroup) {
 		protected.Use(middleware.AuthMiddleware())
 		{
 			protected.POST("/logout", authController.Logout)
-			protected.POST("/refresh", authController.RefreshToken)
+			protected.POST("/revoke", authController.RevokeToken)
 			protected.POST("/change-password", authController.ChangePassword)
 			protected.GET("/me", authController.GetProfile)
 			protected.PUT("/profile", authController.UpdateProfile)
@@ -0,0 +1,14 @@
+package routes
+
+import (
+	"oncloud/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func BrandingRoutes(r *gin.RouterGroup) {
+	brandingController := controllers.NewBrandingController()
+
+	// Public, so sign-in and share pages can be themed
+	r.GET("/branding", brandingController.GetPublicBranding)
+}
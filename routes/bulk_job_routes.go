@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"oncloud/controllers"
+	"oncloud/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+func BulkJobRoutes(r *gin.RouterGroup) {
+	bulkJobController := controllers.NewBulkJobController()
+
+	jobs := r.Group("/bulk-jobs")
+	jobs.Use(middleware.AuthMiddleware())
+	{
+		jobs.GET("/", bulkJobController.GetJobs)
+		jobs.GET("/:id", bulkJobController.GetJob)
+	}
+}
@@ -0,0 +1,26 @@
+package routes
+
+import (
+	"oncloud/controllers"
+	"oncloud/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+func ChatBotRoutes(r *gin.RouterGroup) {
+	chatBotController := controllers.NewChatBotController()
+
+	chat := r.Group("/chat")
+	chat.Use(middleware.AuthMiddleware())
+	{
+		chat.GET("/platforms", chatBotController.GetPlatforms)
+		chat.POST("/link-code", chatBotController.CreateLinkCode)
+		chat.GET("/accounts", chatBotController.GetAccounts)
+		chat.PUT("/accounts/:id", chatBotController.UpdateAccount)
+		chat.DELETE("/accounts/:id", chatBotController.DeleteAccount)
+	}
+
+	// Webhook endpoints for Telegram and Slack, verified by each platform's
+	// secret
+	r.POST("/webhooks/chat/:platform", chatBotController.Webhook)
+}
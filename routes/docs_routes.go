@@ -0,0 +1,15 @@
+package routes
+
+import (
+	"oncloud/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DocsRoutes serves the public API reference
+func DocsRoutes(r *gin.Engine) {
+	docsController := controllers.NewDocsController()
+
+	r.GET("/api/docs", docsController.GetDocs)
+	r.GET("/api/docs/openapi.yaml", docsController.GetOpenAPISpec)
+}
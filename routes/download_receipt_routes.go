@@ -0,0 +1,30 @@
+package routes
+
+import (
+	"oncloud/controllers"
+	"oncloud/middleware"
+	"oncloud/models"
+	"oncloud/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func DownloadReceiptRoutes(r *gin.RouterGroup, deps services.Dependencies) {
+	receiptController := controllers.NewDownloadReceiptController(deps)
+
+	// Public: anyone holding a receipt can verify it offline with this key
+	r.GET("/receipts/public-key", receiptController.GetPublicKey)
+
+	receipts := r.Group("/receipts")
+	receipts.Use(middleware.AuthMiddleware())
+	{
+		receipts.GET("/:id", receiptController.GetReceipt)
+	}
+
+	files := r.Group("/files")
+	files.Use(middleware.AuthMiddleware())
+	{
+		files.GET("/:id/receipts", receiptController.GetFileReceipts)
+		files.PUT("/:id/receipts", middleware.ValidateJSON[models.DownloadReceiptsRequest](), receiptController.SetFileReceipts)
+	}
+}
@@ -0,0 +1,29 @@
+package routes
+
+import (
+	"oncloud/controllers"
+	"oncloud/middleware"
+	"oncloud/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func DownloadRoutes(r *gin.RouterGroup, deps services.Dependencies) {
+	downloadController := controllers.NewDownloadController(deps)
+
+	downloads := r.Group("/downloads")
+	{
+		// Download token management
+		protected := downloads.Group("/")
+		protected.Use(middleware.AuthMiddleware())
+		{
+			protected.POST("/tokens", downloadController.CreateDownloadToken)
+			protected.GET("/tokens", downloadController.GetDownloadTokens)
+			protected.DELETE("/tokens/:id", downloadController.RevokeDownloadToken)
+		}
+
+		// Token-based access (the signed token is the credential)
+		downloads.GET("/:token", downloadController.ServeDownload)
+		downloads.HEAD("/:token", downloadController.ServeDownload)
+	}
+}
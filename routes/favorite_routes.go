@@ -0,0 +1,21 @@
+package routes
+
+import (
+	"oncloud/controllers"
+	"oncloud/middleware"
+	"oncloud/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func FavoriteRoutes(r *gin.RouterGroup, deps services.Dependencies) {
+	favoriteController := controllers.NewFavoriteController(deps)
+
+	favorites := r.Group("/favorites")
+	favorites.Use(middleware.AuthMiddleware())
+	{
+		favorites.GET("/", favoriteController.GetFavorites)
+		favorites.POST("/", favoriteController.AddFavorite)
+		favorites.DELETE("/:type/:id", favoriteController.RemoveFavorite)
+	}
+}
@@ -0,0 +1,15 @@
+package routes
+
+import (
+	"oncloud/controllers"
+	"oncloud/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+func FeatureFlagRoutes(r *gin.RouterGroup) {
+	featureFlagController := controllers.NewFeatureFlagController()
+
+	// Read by frontends; signed-out visitors get the flags on for everyone
+	r.GET("/flags", middleware.OptionalAuthMiddleware(), featureFlagController.GetUserFlags)
+}
@@ -1,67 +1,115 @@
 package routes
 
 import (
+	"net/http"
 	"oncloud/controllers"
 	"oncloud/middleware"
+	"oncloud/models"
+	"oncloud/services"
 
 	"github.com/gin-gonic/gin"
 )
 
-func FileRoutes(r *gin.RouterGroup) {
-	fileController := controllers.NewFileController()
+func FileRoutes(r *gin.RouterGroup, deps services.Dependencies) {
+	fileController := controllers.NewFileController(deps)
+	wopiController := controllers.NewWopiController(deps)
+	metadataController := controllers.NewMetadataController()
+	shareLinkController := controllers.NewShareLinkController(deps)
+	shareEmbedController := controllers.NewShareEmbedController(deps)
+	sharePreviewController := controllers.NewSharePreviewController(deps)
 
 	files := r.Group("/files")
 	files.Use(middleware.AuthMiddleware())
 	{
 		// File CRUD operations
 		files.GET("/", fileController.GetFiles)
+		files.GET("/trash", fileController.GetDeletedFiles)
+		files.GET("/storage-classes", fileController.GetStorageClasses)
 		files.GET("/:id", fileController.GetFile)
-		files.POST("/upload", fileController.Upload)
-		files.POST("/upload/chunk", fileController.ChunkUpload)
-		files.POST("/upload/complete", fileController.CompleteChunkUpload)
-		files.PUT("/:id", fileController.UpdateFile)
+		files.POST("/upload", middleware.UploadSizeLimitMiddleware(), middleware.UploadConcurrencyMiddleware(), fileController.Upload)
+		files.POST("/upload/chunk", middleware.UploadSizeLimitMiddleware(), middleware.UploadConcurrencyMiddleware(), fileController.ChunkUpload)
+		files.POST("/upload/complete", middleware.ValidateJSON[models.CompleteUploadRequest](), fileController.CompleteChunkUpload)
+		files.PUT("/:id", middleware.ValidateJSON[models.FileUpdateRequest](), fileController.UpdateFile)
 		files.DELETE("/:id", fileController.DeleteFile)
-		files.POST("/:id/restore", fileController.RestoreFile)
+		files.POST("/:id/restore", middleware.ValidateJSON[models.RestoreRequest](), fileController.RestoreFile)
 		files.DELETE("/:id/permanent", fileController.PermanentDelete)
 
 		// File operations
 		files.GET("/:id/download", fileController.Download)
 		files.GET("/:id/stream", fileController.Stream)
 		files.GET("/:id/preview", fileController.Preview)
+		files.GET("/:id/preview/text", fileController.TextPreview)
 		files.GET("/:id/thumbnail", fileController.GetThumbnail)
 		files.POST("/:id/thumbnail", fileController.GenerateThumbnail)
 
 		// File sharing
-		files.POST("/:id/share", fileController.CreateShare)
+		files.POST("/:id/share", middleware.ValidateJSON[models.ShareRequest](), fileController.CreateShare)
 		files.GET("/:id/share", fileController.GetShare)
-		files.PUT("/:id/share", fileController.UpdateShare)
+		files.PUT("/:id/share", middleware.ValidateJSON[models.ShareRequest](), fileController.UpdateShare)
 		files.DELETE("/:id/share", fileController.DeleteShare)
 		files.GET("/:id/share/url", fileController.GetShareURL)
+		files.POST("/:id/share/rescan", fileController.RescanFile)
+		files.PUT("/:id/share/slug", middleware.ValidateJSON[models.ShareSlugRequest](), shareLinkController.SetFileSlug)
+		files.DELETE("/:id/share/slug", shareLinkController.ClearFileSlug)
+		files.PUT("/:id/share/embed", middleware.ValidateJSON[models.ShareEmbedRequest](), shareEmbedController.SetEmbed)
+		files.POST("/:id/share/embed/url", middleware.ValidateJSON[models.ShareEmbedURLRequest](), shareEmbedController.CreateEmbedURL)
+		files.GET("/:id/share/analytics", shareEmbedController.GetShareAnalytics)
 
 		// File organization
-		files.POST("/:id/copy", fileController.CopyFile)
-		files.POST("/:id/move", fileController.MoveFile)
+		files.POST("/:id/copy", middleware.ValidateJSON[models.FileCopyRequest](), fileController.CopyFile)
+		files.POST("/:id/move", middleware.ValidateJSON[models.FileMoveRequest](), fileController.MoveFile)
 		files.POST("/:id/favorite", fileController.AddToFavorites)
 		files.DELETE("/:id/favorite", fileController.RemoveFromFavorites)
-		files.PUT("/:id/tags", fileController.UpdateTags)
+		files.PUT("/:id/tags", middleware.ValidateJSON[models.TagsRequest](), fileController.UpdateTags)
+		files.PUT("/:id/metadata", metadataController.SetFileMetadata)
 
 		// File versions
 		files.GET("/:id/versions", fileController.GetVersions)
-		files.POST("/:id/versions", fileController.CreateVersion)
+		files.POST("/:id/versions", middleware.UploadSizeLimitMiddleware(), middleware.UploadConcurrencyMiddleware(), fileController.CreateVersion)
 		files.GET("/:id/versions/:version", fileController.GetVersion)
 		files.POST("/:id/versions/:version/restore", fileController.RestoreVersion)
 		files.DELETE("/:id/versions/:version", fileController.DeleteVersion)
 
+		// Expiry, after which the file is inaccessible and moved to the trash
+		files.PUT("/:id/expiry", middleware.ValidateJSON[models.FileExpiryRequest](), fileController.SetExpiry)
+		files.DELETE("/:id/expiry", fileController.ClearExpiry)
+
+		// Storage classes, and restores of archived files
+		files.PUT("/:id/storage-class", middleware.ValidateJSON[models.FileStorageClassRequest](), fileController.SetStorageClass)
+		files.POST("/:id/archive-restore", middleware.ValidateJSON[models.FileRestoreRequest](), fileController.RestoreArchived)
+		files.GET("/:id/archive-restore", fileController.GetArchiveRestore)
+
+		// Edit locks
+		files.GET("/:id/lock", fileController.GetLock)
+		files.POST("/:id/lock", fileController.LockFile)
+		files.DELETE("/:id/lock", fileController.UnlockFile)
+
+		// Office editor sessions
+		files.POST("/:id/editor", wopiController.OpenEditor)
+		files.GET("/:id/editor/sessions", wopiController.GetEditorSessions)
+		files.DELETE("/:id/editor/sessions/:session_id", wopiController.CloseEditorSession)
+
 		// Bulk operations
-		files.POST("/bulk/delete", fileController.BulkDelete)
-		files.POST("/bulk/move", fileController.BulkMove)
-		files.POST("/bulk/copy", fileController.BulkCopy)
-		files.POST("/bulk/download", fileController.BulkDownload)
-		files.POST("/bulk/share", fileController.BulkShare)
+		files.POST("/bulk/delete", middleware.ValidateJSON[models.BulkFilesRequest](), fileController.BulkDelete)
+		files.POST("/bulk/move", middleware.ValidateJSON[models.BulkFilesMoveRequest](), fileController.BulkMove)
+		files.POST("/bulk/copy", middleware.ValidateJSON[models.BulkFilesMoveRequest](), fileController.BulkCopy)
+		files.POST("/bulk/download", middleware.ValidateJSON[models.BulkFilesRequest](), fileController.BulkDownload)
+		files.POST("/bulk/share", middleware.ValidateJSON[models.BulkFilesShareRequest](), fileController.BulkShare)
 	}
 
+	// Fetch many at once, as a custom method on the collection
+	customMethod(r, http.MethodPost, "/files", "batchGet", middleware.AuthMiddleware(), middleware.ValidateJSON[models.BatchGetRequest](), fileController.BatchGet)
+
 	// Public file access (no auth required)
-	r.GET("/public/:token", fileController.PublicDownload)
-	r.GET("/shared/:token", fileController.SharedDownload)
-	r.POST("/shared/:token/password", fileController.VerifySharePassword)
+	r.GET("/public/:token", middleware.ShareIPAccessMiddleware(), fileController.PublicDownload)
+	r.GET("/shared/:token", middleware.ShareLinkRedirectMiddleware(services.ShareKindFile), middleware.ShareIPAccessMiddleware(), middleware.OptionalAuthMiddleware(), fileController.SharedDownload)
+	r.GET("/shared/:token/pages", middleware.ShareIPAccessMiddleware(), middleware.OptionalAuthMiddleware(), sharePreviewController.GetSharedPages)
+	r.GET("/shared/:token/pages/:page", middleware.ShareIPAccessMiddleware(), middleware.OptionalAuthMiddleware(), sharePreviewController.GetSharedPage)
+	r.GET("/shared/:token/document", middleware.ShareIPAccessMiddleware(), sharePreviewController.GetSharedDocument)
+	r.GET("/shared/:token/assets/*path", middleware.ShareIPAccessMiddleware(), sharePreviewController.GetSharedAsset)
+	r.GET("/shared/:token/stream.m3u8", middleware.ShareIPAccessMiddleware(), sharePreviewController.GetSharedPlaylist)
+	r.GET("/streams/:token/:segment", sharePreviewController.GetStreamSegment)
+	r.GET("/embed/:token", middleware.EmbedIPAccessMiddleware(), shareEmbedController.ServeEmbed)
+	r.HEAD("/embed/:token", middleware.EmbedIPAccessMiddleware(), shareEmbedController.ServeEmbed)
+	r.POST("/shared/:token/password", middleware.ShareIPAccessMiddleware(), middleware.ValidateJSON[models.SharePasswordRequest](), fileController.VerifySharePassword)
 }
@@ -1,14 +1,20 @@
 package routes
 
 import (
+	"net/http"
 	"oncloud/controllers"
 	"oncloud/middleware"
+	"oncloud/models"
+	"oncloud/services"
 
 	"github.com/gin-gonic/gin"
 )
 
-func FolderRoutes(r *gin.RouterGroup) {
-	folderController := controllers.NewFolderController()
+func FolderRoutes(r *gin.RouterGroup, deps services.Dependencies) {
+	folderController := controllers.NewFolderController(deps)
+	fileRequestController := controllers.NewFileRequestController()
+	folderMemberController := controllers.NewFolderMemberController()
+	shareLinkController := controllers.NewShareLinkController(deps)
 
 	folders := r.Group("/folders")
 	folders.Use(middleware.AuthMiddleware())
@@ -16,10 +22,10 @@ func FolderRoutes(r *gin.RouterGroup) {
 		// Folder CRUD operations
 		folders.GET("/", folderController.GetFolders)
 		folders.GET("/:id", folderController.GetFolder)
-		folders.POST("/", folderController.CreateFolder)
-		folders.PUT("/:id", folderController.UpdateFolder)
+		folders.POST("/", middleware.ValidateJSON[models.FolderCreateRequest](), folderController.CreateFolder)
+		folders.PUT("/:id", middleware.ValidateJSON[models.FolderUpdateRequest](), folderController.UpdateFolder)
 		folders.DELETE("/:id", folderController.DeleteFolder)
-		folders.POST("/:id/restore", folderController.RestoreFolder)
+		folders.POST("/:id/restore", middleware.ValidateJSON[models.RestoreRequest](), folderController.RestoreFolder)
 		folders.DELETE("/:id/permanent", folderController.PermanentDelete)
 
 		// Folder navigation
@@ -32,31 +38,77 @@ func FolderRoutes(r *gin.RouterGroup) {
 		folders.GET("/trash", folderController.GetDeletedFolders)
 
 		// Folder operations
-		folders.POST("/:id/copy", folderController.CopyFolder)
-		folders.POST("/:id/move", folderController.MoveFolder)
+		folders.POST("/:id/copy", middleware.ValidateJSON[models.FolderCopyRequest](), folderController.CopyFolder)
+		folders.GET("/:id/copy-job", folderController.GetCopyJob)
+		folders.GET("/:id/manifest", folderController.ExportManifest)
+		folders.GET("/:id/manifest/exports", folderController.GetManifestExports)
+		folders.GET("/:id/manifest/exports/:export_id", folderController.GetManifestExport)
+		folders.GET("/:id/manifest/exports/:export_id/download", folderController.DownloadManifestExport)
+		folders.POST("/:id/move", middleware.ValidateJSON[models.FolderMoveRequest](), folderController.MoveFolder)
 		folders.POST("/:id/favorite", folderController.AddToFavorites)
 		folders.DELETE("/:id/favorite", folderController.RemoveFromFavorites)
-		folders.PUT("/:id/tags", folderController.UpdateTags)
+		folders.PUT("/:id/tags", middleware.ValidateJSON[models.TagsRequest](), folderController.UpdateTags)
 
 		// Folder sharing
-		folders.POST("/:id/share", folderController.CreateShare)
+		folders.POST("/:id/share", middleware.ValidateJSON[models.ShareRequest](), folderController.CreateShare)
 		folders.GET("/:id/share", folderController.GetShare)
-		folders.PUT("/:id/share", folderController.UpdateShare)
+		folders.PUT("/:id/share", middleware.ValidateJSON[models.ShareRequest](), folderController.UpdateShare)
 		folders.DELETE("/:id/share", folderController.DeleteShare)
 		folders.GET("/:id/share/url", folderController.GetShareURL)
+		folders.PUT("/:id/share/slug", middleware.ValidateJSON[models.ShareSlugRequest](), shareLinkController.SetFolderSlug)
+		folders.DELETE("/:id/share/slug", shareLinkController.ClearFolderSlug)
+
+		// A link guests upload into the folder with
+		folders.GET("/:id/file-request", fileRequestController.GetRequest)
+		folders.PUT("/:id/file-request", middleware.ValidateJSON[models.FileRequestRequest](), fileRequestController.SaveRequest)
+		folders.DELETE("/:id/file-request", fileRequestController.DeleteRequest)
+
+		// Users the folder is shared with, and what each of them did in it
+		folders.GET("/:id/members", folderMemberController.GetMembers)
+		folders.POST("/:id/members", middleware.ValidateJSON[models.FolderMemberRequest](), folderMemberController.AddMember)
+		folders.PUT("/:id/members/:userId", middleware.ValidateJSON[models.FolderMemberUpdateRequest](), folderMemberController.UpdateMember)
+		folders.DELETE("/:id/members/:userId", folderMemberController.RemoveMember)
+		folders.GET("/:id/members/:userId/activity", folderMemberController.GetMemberActivity)
 
 		// Folder statistics
 		folders.GET("/:id/stats", folderController.GetFolderStats)
 		folders.GET("/:id/size", folderController.GetFolderSize)
+		folders.GET("/usage", folderController.GetUsage)
+		folders.GET("/:id/usage", folderController.GetUsage)
 
 		// Bulk operations
-		folders.POST("/bulk/delete", folderController.BulkDelete)
-		folders.POST("/bulk/move", folderController.BulkMove)
-		folders.POST("/bulk/copy", folderController.BulkCopy)
-		folders.POST("/bulk/share", folderController.BulkShare)
+		folders.POST("/bulk/delete", middleware.ValidateJSON[models.BulkFoldersRequest](), folderController.BulkDelete)
+		folders.POST("/bulk/move", middleware.ValidateJSON[models.BulkFoldersMoveRequest](), folderController.BulkMove)
+		folders.POST("/bulk/copy", middleware.ValidateJSON[models.BulkFoldersMoveRequest](), folderController.BulkCopy)
+		folders.POST("/bulk/share", middleware.ValidateJSON[models.BulkFoldersShareRequest](), folderController.BulkShare)
+	}
+
+	// Folders other users shared with the user, changed by editors on behalf
+	// of their owner
+	shared := r.Group("/shared-with-me")
+	shared.Use(middleware.AuthMiddleware())
+	{
+		shared.GET("/", folderMemberController.GetSharedWithMe)
+		shared.GET("/:id/contents", folderMemberController.GetSharedContents)
+		shared.POST("/:id/upload", middleware.UploadSizeLimitMiddleware(), middleware.UploadConcurrencyMiddleware(), folderMemberController.UploadShared)
+		shared.PUT("/:id/files/:fileId", middleware.ValidateJSON[models.SharedItemRenameRequest](), folderMemberController.RenameSharedFile)
+		shared.DELETE("/:id/files/:fileId", folderMemberController.DeleteSharedFile)
+		shared.PUT("/:id/folders/:folderId", middleware.ValidateJSON[models.SharedItemRenameRequest](), folderMemberController.RenameSharedFolder)
+		shared.DELETE("/:id/folders/:folderId", folderMemberController.DeleteSharedFolder)
 	}
 
+	// Fetch many at once, as a custom method on the collection
+	customMethod(r, http.MethodPost, "/folders", "batchGet", middleware.AuthMiddleware(), middleware.ValidateJSON[models.BatchGetRequest](), folderController.BatchGet)
+
 	// Public folder access
 	r.GET("/public/folder/:token", folderController.PublicFolderAccess)
-	r.GET("/shared/folder/:token", folderController.SharedFolderAccess)
+	r.GET("/shared/folder/:token", middleware.ShareLinkRedirectMiddleware(services.ShareKindFolder), middleware.ShareIPAccessMiddleware(), folderController.SharedFolderAccess)
+
+	// Search inside a public or shared folder
+	r.GET("/public/folder/:token/search", folderController.SearchPublicFolder)
+	r.GET("/shared/folder/:token/search", middleware.ShareIPAccessMiddleware(), folderController.SearchSharedFolder)
+
+	// Guest uploads through a folder's file request
+	r.GET("/file-requests/:token", fileRequestController.GetPage)
+	r.POST("/file-requests/:token/upload", middleware.UploadRateLimitMiddleware(), middleware.UploadSizeLimitMiddleware(), middleware.UploadConcurrencyMiddleware(), fileRequestController.Upload)
 }
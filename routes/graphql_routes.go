@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"oncloud/controllers"
+	"oncloud/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GraphQLRoutes(r *gin.RouterGroup) {
+	graphqlController := controllers.NewGraphQLController()
+
+	graphql := r.Group("/graphql")
+	graphql.Use(middleware.AuthMiddleware())
+	{
+		graphql.POST("", graphqlController.Query)
+		graphql.GET("/schema", graphqlController.GetSchema)
+	}
+}
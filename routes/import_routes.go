@@ -0,0 +1,32 @@
+package routes
+
+import (
+	"oncloud/controllers"
+	"oncloud/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+func ImportRoutes(r *gin.RouterGroup) {
+	importController := controllers.NewImportController()
+
+	imports := r.Group("/imports")
+	imports.Use(middleware.AuthMiddleware())
+	{
+		imports.GET("/providers", importController.GetProviders)
+
+		// Linked cloud accounts
+		imports.GET("/connections", importController.GetConnections)
+		imports.POST("/connections/:provider/authorize", importController.AuthorizeConnection)
+		imports.POST("/connections/:provider", importController.CreateConnection)
+		imports.GET("/connections/:id/browse", importController.BrowseConnection)
+		imports.DELETE("/connections/:id", importController.DeleteConnection)
+
+		// Import jobs
+		imports.GET("/", importController.GetImports)
+		imports.POST("/", importController.StartImport)
+		imports.POST("/url", importController.StartURLImport)
+		imports.GET("/:id", importController.GetImport)
+		imports.POST("/:id/cancel", importController.CancelImport)
+	}
+}
@@ -0,0 +1,24 @@
+package routes
+
+import (
+	"oncloud/controllers"
+	"oncloud/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+func InboxRoutes(r *gin.RouterGroup) {
+	inboxController := controllers.NewInboxController()
+
+	inbox := r.Group("/inbox")
+	inbox.Use(middleware.AuthMiddleware())
+	{
+		inbox.GET("/", inboxController.GetInbox)
+		inbox.PUT("/", inboxController.UpdateInbox)
+		inbox.POST("/regenerate", inboxController.RegenerateAddress)
+	}
+
+	// Webhook endpoints for inbound email, verified by signature or secret
+	r.POST("/webhooks/email/mailgun", inboxController.MailgunWebhook)
+	r.POST("/webhooks/email/raw", inboxController.RawEmailWebhook)
+}
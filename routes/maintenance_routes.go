@@ -0,0 +1,14 @@
+package routes
+
+import (
+	"oncloud/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func MaintenanceRoutes(r *gin.RouterGroup) {
+	maintenanceController := controllers.NewMaintenanceController()
+
+	// Public, and exempt from maintenance so clients can explain the outage
+	r.GET("/maintenance", maintenanceController.GetStatus)
+}
@@ -0,0 +1,21 @@
+package routes
+
+import (
+	"oncloud/controllers"
+	"oncloud/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+func MetadataRoutes(r *gin.RouterGroup) {
+	metadataController := controllers.NewMetadataController()
+
+	metadata := r.Group("/metadata")
+	metadata.Use(middleware.AuthMiddleware())
+	{
+		metadata.GET("/fields", metadataController.GetFields)
+		metadata.POST("/fields", metadataController.CreateField)
+		metadata.PUT("/fields/:id", metadataController.UpdateField)
+		metadata.DELETE("/fields/:id", metadataController.DeleteField)
+	}
+}
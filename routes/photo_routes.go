@@ -0,0 +1,20 @@
+package routes
+
+import (
+	"oncloud/controllers"
+	"oncloud/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+func PhotoRoutes(r *gin.RouterGroup) {
+	photoController := controllers.NewPhotoController()
+
+	photos := r.Group("/photos")
+	photos.Use(middleware.AuthMiddleware())
+	{
+		photos.GET("/", photoController.GetPhotos)
+		photos.GET("/timeline", photoController.GetTimeline)
+		photos.GET("/map", photoController.GetMap)
+	}
+}
@@ -3,6 +3,7 @@ package routes
 import (
 	"oncloud/controllers"
 	"oncloud/middleware"
+	"oncloud/models"
 
 	"github.com/gin-gonic/gin"
 )
@@ -24,19 +25,19 @@ func PlanRoutes(r *gin.RouterGroup) {
 		{
 			// User subscription management
 			protected.GET("/my-plan", planController.GetUserPlan)
-			protected.POST("/subscribe", planController.Subscribe)
-			protected.POST("/upgrade", planController.UpgradePlan)
-			protected.POST("/downgrade", planController.DowngradePlan)
-			protected.POST("/cancel", planController.CancelSubscription)
-			protected.POST("/renew", planController.RenewSubscription)
+			protected.POST("/subscribe", middleware.ValidateJSON[models.SubscribeRequest](), planController.Subscribe)
+			protected.POST("/upgrade", middleware.ValidateJSON[models.PlanUpgradeRequest](), planController.UpgradePlan)
+			protected.POST("/downgrade", middleware.ValidateJSON[models.PlanDowngradeRequest](), planController.DowngradePlan)
+			protected.POST("/cancel", middleware.ValidateJSON[models.CancelSubscriptionRequest](), planController.CancelSubscription)
+			protected.POST("/renew", middleware.ValidateJSON[models.RenewSubscriptionRequest](), planController.RenewSubscription)
 
 			// Payment and billing
 			protected.GET("/billing-history", planController.GetBillingHistory)
 			protected.GET("/invoices", planController.GetInvoices)
 			protected.GET("/invoices/:id/download", planController.DownloadInvoice)
-			protected.POST("/payment-methods", planController.AddPaymentMethod)
+			protected.POST("/payment-methods", middleware.ValidateJSON[models.PaymentMethodRequest](), planController.AddPaymentMethod)
 			protected.GET("/payment-methods", planController.GetPaymentMethods)
-			protected.PUT("/payment-methods/:id", planController.UpdatePaymentMethod)
+			protected.PUT("/payment-methods/:id", middleware.ValidateJSON[models.PaymentMethodUpdateRequest](), planController.UpdatePaymentMethod)
 			protected.DELETE("/payment-methods/:id", planController.DeletePaymentMethod)
 
 			// Usage tracking
@@ -2,36 +2,77 @@ package routes
 
 import (
 	"oncloud/middleware"
+	"oncloud/services"
+	"oncloud/utils"
 
 	"github.com/gin-gonic/gin"
 )
 
-func SetupRoutes(r *gin.Engine) {
+func SetupRoutes(r *gin.Engine, deps services.Dependencies) {
 	// Global middleware
 	r.Use(middleware.CORSMiddleware())
 	r.Use(middleware.LoggingMiddleware())
 	r.Use(gin.Recovery())
+	r.Use(middleware.ErrorHandler())
+	r.Use(middleware.MaintenanceMiddleware())
 
 	// API v1 routes
 	v1 := r.Group("/api/v1")
 	v1.Use(middleware.RateLimitMiddleware())
+	v1.Use(middleware.IPAccessMiddleware())
 	{
 		// Public routes
 		AuthRoutes(v1)
+		BrandingRoutes(v1)
+		MaintenanceRoutes(v1)
+		AnnouncementRoutes(v1)
+		FeatureFlagRoutes(v1)
+		StatusRoutes(v1)
+		AbuseReportRoutes(v1)
 
 		// Protected routes
-		UserRoutes(v1)
-		FileRoutes(v1)
-		FolderRoutes(v1)
+		UserRoutes(v1, deps)
+		FileRoutes(v1, deps)
+		UploadRoutes(v1, deps)
+		FolderRoutes(v1, deps)
+		BulkJobRoutes(v1)
+		FavoriteRoutes(v1, deps)
+		TrashRoutes(v1, deps)
+		TagRoutes(v1)
+		MetadataRoutes(v1)
+		PhotoRoutes(v1)
+		ImportRoutes(v1)
+		InboxRoutes(v1)
+		ChatBotRoutes(v1)
 		PlanRoutes(v1)
-		StorageRoutes(v1)
+		StorageRoutes(v1, deps)
+		DownloadRoutes(v1, deps)
+		DownloadReceiptRoutes(v1, deps)
+		GraphQLRoutes(v1)
+		TakedownRoutes(v1)
+		ShareLinkRoutes(v1, deps)
 	}
 
+	// Short share links, /s/{slug}, also served on users' share domains
+	ShortLinkRoutes(r, deps)
+
+	// API documentation
+	DocsRoutes(r)
+
+	// SCIM provisioning
+	ScimRoutes(r)
+
+	// Office editor callbacks
+	WopiRoutes(r, deps)
+
+	// Resumable uploads with the tus protocol
+	TusRoutes(r, deps)
+
 	// Admin routes
 	admin := r.Group("/admin")
 	admin.Use(middleware.AdminMiddleware())
 	{
-		AdminRoutes(admin)
+		AdminRoutes(admin, deps)
 	}
 
 	// // Static files and uploads
@@ -40,5 +81,20 @@ func SetupRoutes(r *gin.Engine) {
 
 	// // Admin panel HTML routes
 	// r.LoadHTMLGlob("admin/templates/**/*")
-	AdminPanelRoutes(r)
+	AdminPanelRoutes(r, deps)
+}
+
+// customMethod registers a custom method on a collection, such as
+// POST /files:batchGet. Gin reads ":batchGet" as a path parameter matching
+// anything after the collection, so other paths are answered with 404.
+func customMethod(r *gin.RouterGroup, httpMethod, collection, name string, handlers ...gin.HandlerFunc) {
+	matchName := func(c *gin.Context) {
+		if c.Param(name) != ":"+name {
+			utils.NotFoundResponse(c, "Not found")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+	r.Handle(httpMethod, collection+":"+name, append([]gin.HandlerFunc{matchName}, handlers...)...)
 }
@@ -0,0 +1,37 @@
+package routes
+
+import (
+	"oncloud/controllers"
+	"oncloud/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ScimRoutes exposes SCIM 2.0 provisioning for identity providers such as
+// Okta and Azure AD
+func ScimRoutes(r *gin.Engine) {
+	scimController := controllers.NewScimController()
+
+	scim := r.Group("/scim/v2")
+	scim.Use(middleware.RateLimitMiddleware())
+	scim.Use(middleware.IPAccessMiddleware())
+	scim.Use(middleware.ScimAuthMiddleware())
+	{
+		scim.GET("/ServiceProviderConfig", scimController.ServiceProviderConfig)
+		scim.GET("/ResourceTypes", scimController.ResourceTypes)
+
+		scim.GET("/Users", scimController.ListUsers)
+		scim.POST("/Users", scimController.CreateUser)
+		scim.GET("/Users/:id", scimController.GetUser)
+		scim.PUT("/Users/:id", scimController.ReplaceUser)
+		scim.PATCH("/Users/:id", scimController.PatchUser)
+		scim.DELETE("/Users/:id", scimController.DeleteUser)
+
+		scim.GET("/Groups", scimController.ListGroups)
+		scim.POST("/Groups", scimController.CreateGroup)
+		scim.GET("/Groups/:id", scimController.GetGroup)
+		scim.PUT("/Groups/:id", scimController.ReplaceGroup)
+		scim.PATCH("/Groups/:id", scimController.PatchGroup)
+		scim.DELETE("/Groups/:id", scimController.DeleteGroup)
+	}
+}
@@ -0,0 +1,45 @@
+package routes
+
+import (
+	"oncloud/controllers"
+	"oncloud/middleware"
+	"oncloud/models"
+	"oncloud/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func ShareLinkRoutes(r *gin.RouterGroup, deps services.Dependencies) {
+	shareLinkController := controllers.NewShareLinkController(deps)
+
+	// The domain the user's share links are served on
+	shares := r.Group("/shares")
+	shares.Use(middleware.AuthMiddleware())
+	{
+		shares.GET("/domain", shareLinkController.GetDomain)
+		shares.PUT("/domain", middleware.ValidateJSON[models.ShareDomainRequest](), shareLinkController.SetDomain)
+		shares.DELETE("/domain", shareLinkController.DeleteDomain)
+		shares.POST("/domain/verify", shareLinkController.VerifyDomain)
+	}
+}
+
+func ShortLinkRoutes(r *gin.Engine, deps services.Dependencies) {
+	shareLinkController := controllers.NewShareLinkController(deps)
+
+	r.GET("/s/:slug",
+		middleware.RateLimitMiddleware(),
+		middleware.IPAccessMiddleware(),
+		middleware.ShareLinkMiddleware(),
+		middleware.ShareIPAccessMiddleware(),
+		middleware.OptionalAuthMiddleware(),
+		shareLinkController.Open,
+	)
+	r.POST("/s/:slug/password",
+		middleware.AuthRateLimitMiddleware(),
+		middleware.IPAccessMiddleware(),
+		middleware.ShareLinkMiddleware(),
+		middleware.ShareIPAccessMiddleware(),
+		middleware.ValidateJSON[models.SharePasswordRequest](),
+		shareLinkController.VerifyPassword,
+	)
+}
@@ -0,0 +1,14 @@
+package routes
+
+import (
+	"oncloud/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func StatusRoutes(r *gin.RouterGroup) {
+	statusController := controllers.NewStatusController()
+
+	// Public, and exempt from maintenance so the status page stays up
+	r.GET("/status", statusController.GetStatus)
+}
@@ -3,12 +3,13 @@ package routes
 import (
 	"oncloud/controllers"
 	"oncloud/middleware"
+	"oncloud/services"
 
 	"github.com/gin-gonic/gin"
 )
 
-func StorageRoutes(r *gin.RouterGroup) {
-	storageController := controllers.NewStorageController()
+func StorageRoutes(r *gin.RouterGroup, deps services.Dependencies) {
+	storageController := controllers.NewStorageController(deps)
 
 	storage := r.Group("/storage")
 	storage.Use(middleware.AuthMiddleware())
@@ -27,7 +28,7 @@ func StorageRoutes(r *gin.RouterGroup) {
 		// Upload operations
 		storage.POST("/upload/url", storageController.GetUploadURL)
 		storage.POST("/upload/multipart", storageController.InitiateMultipartUpload)
-		storage.PUT("/upload/multipart/:upload_id/part/:part_number", storageController.UploadPart)
+		storage.PUT("/upload/multipart/:upload_id/part/:part_number", middleware.UploadSizeLimitMiddleware(), middleware.UploadConcurrencyMiddleware(), storageController.UploadPart)
 		storage.POST("/upload/multipart/:upload_id/complete", storageController.CompleteMultipartUpload)
 		storage.DELETE("/upload/multipart/:upload_id", storageController.AbortMultipartUpload)
 
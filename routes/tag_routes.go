@@ -0,0 +1,23 @@
+package routes
+
+import (
+	"oncloud/controllers"
+	"oncloud/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TagRoutes(r *gin.RouterGroup) {
+	tagController := controllers.NewTagController()
+
+	tags := r.Group("/tags")
+	tags.Use(middleware.AuthMiddleware())
+	{
+		tags.GET("/", tagController.GetTags)
+		tags.GET("/autocomplete", tagController.AutocompleteTags)
+		tags.POST("/merge", tagController.MergeTags)
+		tags.GET("/:id/files", tagController.GetTagFiles)
+		tags.PUT("/:id", tagController.RenameTag)
+		tags.DELETE("/:id", tagController.DeleteTag)
+	}
+}
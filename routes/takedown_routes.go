@@ -0,0 +1,22 @@
+package routes
+
+import (
+	"oncloud/controllers"
+	"oncloud/middleware"
+	"oncloud/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TakedownRoutes(r *gin.RouterGroup) {
+	takedownController := controllers.NewTakedownController()
+
+	// Owners follow the takedown cases about their content and may dispute them
+	takedowns := r.Group("/takedowns")
+	takedowns.Use(middleware.AuthMiddleware())
+	{
+		takedowns.GET("/", takedownController.GetMyTakedowns)
+		takedowns.GET("/:id", takedownController.GetMyTakedown)
+		takedowns.POST("/:id/counter-notice", middleware.ValidateJSON[models.TakedownCounterNoticeRequest](), takedownController.SubmitCounterNotice)
+	}
+}
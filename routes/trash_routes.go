@@ -0,0 +1,27 @@
+package routes
+
+import (
+	"oncloud/controllers"
+	"oncloud/middleware"
+	"oncloud/models"
+	"oncloud/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TrashRoutes(r *gin.RouterGroup, deps services.Dependencies) {
+	trashController := controllers.NewTrashController(deps)
+
+	trash := r.Group("/trash")
+	trash.Use(middleware.AuthMiddleware())
+	{
+		trash.GET("/download", trashController.DownloadTrash)
+
+		// Emptying the trash is confirmed with a token, then runs after a
+		// grace period during which it can be canceled
+		trash.GET("/empty", trashController.GetEmptyTrash)
+		trash.POST("/empty", trashController.RequestEmptyTrash)
+		trash.POST("/empty/confirm", middleware.ValidateJSON[models.TrashEmptyConfirmRequest](), trashController.ConfirmEmptyTrash)
+		trash.DELETE("/empty", trashController.CancelEmptyTrash)
+	}
+}
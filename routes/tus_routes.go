@@ -0,0 +1,34 @@
+package routes
+
+import (
+	"oncloud/controllers"
+	"oncloud/middleware"
+	"oncloud/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TusRoutes exposes resumable uploads with the tus protocol, so standard tus
+// clients work against oncloud without a custom upload flow
+func TusRoutes(r *gin.Engine, deps services.Dependencies) {
+	tusController := controllers.NewTusController(deps)
+
+	tus := r.Group("/api/tus")
+	tus.Use(middleware.RateLimitMiddleware())
+	tus.Use(middleware.IPAccessMiddleware())
+	tus.Use(controllers.TusHeadersMiddleware())
+	{
+		tus.OPTIONS("", tusController.Options)
+		tus.OPTIONS("/:id", tusController.Options)
+
+		uploads := tus.Group("")
+		uploads.Use(middleware.AuthMiddleware())
+		{
+			uploads.POST("", middleware.UploadSizeLimitMiddleware(), tusController.Create)
+			uploads.HEAD("/:id", tusController.Head)
+			uploads.PATCH("/:id", middleware.UploadSizeLimitMiddleware(), middleware.UploadConcurrencyMiddleware(), tusController.Patch)
+			uploads.DELETE("/:id", tusController.Terminate)
+			uploads.POST("/:id", middleware.UploadSizeLimitMiddleware(), middleware.UploadConcurrencyMiddleware(), tusController.Override)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package routes
+
+import (
+	"oncloud/controllers"
+	"oncloud/middleware"
+	"oncloud/models"
+	"oncloud/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func UploadRoutes(r *gin.RouterGroup, deps services.Dependencies) {
+	fileController := controllers.NewFileController(deps)
+	uploadRuleController := controllers.NewUploadRuleController()
+	postPolicyController := controllers.NewPostPolicyController(deps)
+
+	uploads := r.Group("/uploads")
+	uploads.Use(middleware.AuthMiddleware())
+	{
+		uploads.POST("/preflight", middleware.ValidateJSON[models.UploadPreflightRequest](), fileController.UploadPreflight)
+		uploads.POST("/instant", middleware.ValidateJSON[models.InstantUploadRequest](), fileController.InstantUpload)
+
+		// Browser form uploads straight to the bucket, with S3 POST policies
+		uploads.POST("/post-policy", middleware.ValidateJSON[models.PostPolicyUploadRequest](), postPolicyController.CreatePolicy)
+		uploads.POST("/post-policy/:id/complete", middleware.ValidateJSON[models.PostPolicyCompleteRequest](), postPolicyController.CompleteUpload)
+
+		// Rules filing uploads into folders, and the defaults when none match
+		uploads.GET("/rules", uploadRuleController.GetRules)
+		uploads.POST("/rules", middleware.ValidateJSON[models.UploadRuleRequest](), uploadRuleController.CreateRule)
+		uploads.POST("/rules/preview", middleware.ValidateJSON[models.UploadRulePreviewRequest](), uploadRuleController.PreviewRules)
+		uploads.PUT("/rules/:id", middleware.ValidateJSON[models.UploadRuleRequest](), uploadRuleController.UpdateRule)
+		uploads.DELETE("/rules/:id", uploadRuleController.DeleteRule)
+		uploads.GET("/settings", uploadRuleController.GetSettings)
+		uploads.PUT("/settings", middleware.ValidateJSON[models.UploadSettingsRequest](), uploadRuleController.UpdateSettings)
+	}
+}
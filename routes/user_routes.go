@@ -3,12 +3,15 @@ package routes
 import (
 	"oncloud/controllers"
 	"oncloud/middleware"
+	"oncloud/models"
+	"oncloud/services"
 
 	"github.com/gin-gonic/gin"
 )
 
-func UserRoutes(r *gin.RouterGroup) {
-	userController := controllers.NewUserController()
+func UserRoutes(r *gin.RouterGroup, deps services.Dependencies) {
+	userController := controllers.NewUserController(deps)
+	storageReportController := controllers.NewStorageReportController(deps)
 
 	users := r.Group("/users")
 	users.Use(middleware.AuthMiddleware())
@@ -16,7 +19,7 @@ func UserRoutes(r *gin.RouterGroup) {
 		// User profile management
 		users.GET("/profile", userController.GetProfile)
 		users.PUT("/profile", userController.UpdateProfile)
-		users.POST("/avatar", userController.UploadAvatar)
+		users.POST("/avatar", middleware.RequestSizeLimitMiddleware(6*1024*1024), userController.UploadAvatar)
 		users.DELETE("/avatar", userController.DeleteAvatar)
 
 		// User statistics and dashboard
@@ -25,12 +28,17 @@ func UserRoutes(r *gin.RouterGroup) {
 		users.GET("/activity", userController.GetActivity)
 		users.GET("/notifications", userController.GetNotifications)
 		users.PUT("/notifications/:id/read", userController.MarkNotificationRead)
+		users.GET("/storage-reports", storageReportController.GetReports)
 
 		// User settings
 		users.GET("/settings", userController.GetSettings)
 		users.PUT("/settings", userController.UpdateSettings)
 		users.GET("/sessions", userController.GetActiveSessions)
 		users.DELETE("/sessions/:id", userController.RevokeSession)
+		users.DELETE("/sessions", userController.RevokeOtherSessions)
+		users.GET("/access-policy", userController.GetAccessPolicy)
+		users.PUT("/access-policy", userController.UpdateAccessPolicy)
+		users.DELETE("/access-policy", userController.DeleteAccessPolicy)
 
 		// API keys management
 		users.GET("/api-keys", userController.GetAPIKeys)
@@ -46,4 +54,8 @@ func UserRoutes(r *gin.RouterGroup) {
 		users.GET("/2fa/backup-codes", userController.GetBackupCodes)
 		users.POST("/2fa/backup-codes/regenerate", userController.RegenerateBackupCodes)
 	}
+
+	// Unsubscribe links in storage report emails open a page that posts
+	// their token (no auth required)
+	r.POST("/email/unsubscribe", middleware.ValidateJSON[models.UnsubscribeRequest](), storageReportController.Unsubscribe)
 }
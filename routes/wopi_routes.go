@@ -0,0 +1,24 @@
+package routes
+
+import (
+	"oncloud/controllers"
+	"oncloud/middleware"
+	"oncloud/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// WopiRoutes exposes the WOPI protocol to OnlyOffice or Collabora Online.
+// Editor servers poll these endpoints, so they are not rate limited.
+func WopiRoutes(r *gin.Engine, deps services.Dependencies) {
+	wopiController := controllers.NewWopiController(deps)
+
+	wopi := r.Group("/wopi")
+	wopi.Use(middleware.WopiAuthMiddleware(deps))
+	{
+		wopi.GET("/files/:id", wopiController.CheckFileInfo)
+		wopi.POST("/files/:id", wopiController.FileOperation)
+		wopi.GET("/files/:id/contents", wopiController.GetFile)
+		wopi.POST("/files/:id/contents", middleware.UploadSizeLimitMiddleware(), wopiController.PutFile)
+	}
+}
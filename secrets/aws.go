@@ -0,0 +1,74 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+)
+
+// plainSecretKey is the key the value of a secret which isn't a JSON object
+// is read under
+const plainSecretKey = "value"
+
+// AWSBackend reads secrets from AWS Secrets Manager. Paths are secret names
+// or ARNs. Secrets stored as JSON objects have a value per key; other secrets
+// have a single "value".
+type AWSBackend struct {
+	client *secretsmanager.SecretsManager
+}
+
+// NewAWSBackend creates a new AWS Secrets Manager backend
+func NewAWSBackend(cfg *Config) (*AWSBackend, error) {
+	sess, err := session.NewSession(&aws.Config{
+		Region:     aws.String(cfg.AWSRegion),
+		HTTPClient: newHTTPClient(cfg.Timeout),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AWS session: %v", err)
+	}
+
+	return &AWSBackend{client: secretsmanager.New(sess)}, nil
+}
+
+func (a *AWSBackend) Name() string {
+	return "aws"
+}
+
+// Read reads the current version of the secret named path
+func (a *AWSBackend) Read(ctx context.Context, path string) (*Secret, error) {
+	output, err := a.client.GetSecretValueWithContext(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(path),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == secretsmanager.ErrCodeResourceNotFoundException {
+			return nil, ErrSecretNotFound
+		}
+		return nil, fmt.Errorf("failed to read secret: %v", err)
+	}
+
+	value := aws.StringValue(output.SecretString)
+	if output.SecretString == nil {
+		value = string(output.SecretBinary)
+	}
+
+	secret := &Secret{Version: aws.StringValue(output.VersionId)}
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(value), &data); err == nil && data != nil {
+		secret.Data = stringValues(data)
+	} else {
+		secret.Data = map[string]string{plainSecretKey: value}
+	}
+	return secret, nil
+}
+
+// Renew is not supported, as Secrets Manager secrets have no leases; they
+// are read again to pick up rotations
+func (a *AWSBackend) Renew(ctx context.Context, leaseID string) (time.Duration, error) {
+	return 0, ErrNotRenewable
+}
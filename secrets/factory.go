@@ -0,0 +1,51 @@
+package secrets
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// NewBackend creates a secrets backend based on the configured type
+func NewBackend(cfg *Config) (Backend, error) {
+	if err := ValidateConfig(cfg); err != nil {
+		return nil, err
+	}
+
+	switch cfg.Type {
+	case "vault":
+		return NewVaultBackend(cfg), nil
+	case "aws":
+		return NewAWSBackend(cfg)
+	default:
+		return nil, fmt.Errorf("unsupported secrets backend type: %s", cfg.Type)
+	}
+}
+
+// ValidateConfig validates secrets backend configuration
+func ValidateConfig(cfg *Config) error {
+	switch cfg.Type {
+	case "vault":
+		if cfg.VaultAddr == "" {
+			return fmt.Errorf("Vault address is required")
+		}
+		if cfg.VaultToken == "" {
+			return fmt.Errorf("Vault token is required")
+		}
+	case "aws":
+		if cfg.AWSRegion == "" {
+			return fmt.Errorf("AWS region is required")
+		}
+	default:
+		return fmt.Errorf("unsupported secrets backend type: %s", cfg.Type)
+	}
+
+	return nil
+}
+
+func newHTTPClient(timeout time.Duration) *http.Client {
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	return &http.Client{Timeout: timeout}
+}
@@ -0,0 +1,54 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	ErrSecretNotFound = errors.New("secret not found")
+	ErrNotRenewable   = errors.New("secret lease is not renewable")
+)
+
+// Backend defines the common interface for secrets backends
+type Backend interface {
+	// Read returns the values of the secret at path
+	Read(ctx context.Context, path string) (*Secret, error)
+	// Renew extends a secret's lease, returning how long it now lasts
+	Renew(ctx context.Context, leaseID string) (time.Duration, error)
+
+	// Provider info
+	Name() string
+}
+
+// TokenRenewer is implemented by backends whose own credentials expire unless
+// they are renewed, such as Vault tokens
+type TokenRenewer interface {
+	RenewToken(ctx context.Context) error
+}
+
+// Secret is the set of key/value pairs stored at a path. Secrets handed out
+// with a lease, such as Vault's dynamic credentials, must be renewed or read
+// again before LeaseDuration runs out.
+type Secret struct {
+	Data          map[string]string
+	Version       string
+	LeaseID       string
+	LeaseDuration time.Duration
+	Renewable     bool
+}
+
+// Config contains connection settings for all supported backends
+type Config struct {
+	Type    string // vault, aws
+	Timeout time.Duration
+
+	// Vault
+	VaultAddr      string
+	VaultToken     string
+	VaultNamespace string
+
+	// AWS Secrets Manager, authenticated with the default credential chain
+	AWSRegion string
+}
@@ -0,0 +1,327 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReferencePrefix starts values which refer to a secret rather than holding
+// it: "secret://<path>#<key>". The key can be left out of references to
+// secrets with a single value.
+const ReferencePrefix = "secret://"
+
+// checkInterval is how often cached secrets are checked for leases to renew
+// and values to read again
+const checkInterval = 15 * time.Second
+
+var (
+	ErrNoBackend        = errors.New("secret references need a secrets backend to be configured")
+	ErrInvalidReference = errors.New("invalid secret reference")
+)
+
+var defaultResolver *Resolver
+
+// Reference names a value of a secret
+type Reference struct {
+	Path string
+	Key  string
+}
+
+// IsReference reports whether value refers to a secret
+func IsReference(value string) bool {
+	return strings.HasPrefix(value, ReferencePrefix)
+}
+
+// ParseReference parses a "secret://<path>#<key>" reference
+func ParseReference(value string) (*Reference, error) {
+	if !IsReference(value) {
+		return nil, ErrInvalidReference
+	}
+	path, key, _ := strings.Cut(strings.TrimPrefix(value, ReferencePrefix), "#")
+	if path == "" {
+		return nil, ErrInvalidReference
+	}
+	return &Reference{Path: path, Key: key}, nil
+}
+
+// Value returns the value of key, or the only value of the secret when key is
+// empty
+func (s *Secret) Value(key string) (string, error) {
+	if key != "" {
+		value, ok := s.Data[key]
+		if !ok {
+			return "", fmt.Errorf("secret has no key %q", key)
+		}
+		return value, nil
+	}
+	if len(s.Data) != 1 {
+		keys := make([]string, 0, len(s.Data))
+		for key := range s.Data {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		return "", fmt.Errorf("secret has several keys, the reference must name one of %s", strings.Join(keys, ", "))
+	}
+	for _, value := range s.Data {
+		return value, nil
+	}
+	return "", nil
+}
+
+// Resolver resolves secret references through a backend, caching the secrets
+// it reads. Once started, it renews their leases before they run out and
+// reads them again every refresh interval, so rotated values are picked up
+// and handed to the watchers of the references to them.
+type Resolver struct {
+	backend         Backend
+	refreshInterval time.Duration
+
+	mu               sync.Mutex
+	cache            map[string]*cachedSecret
+	watchers         []*watcher
+	lastTokenRenewal time.Time
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+type cachedSecret struct {
+	secret    *Secret
+	fetchedAt time.Time
+	renewedAt time.Time
+}
+
+type watcher struct {
+	reference Reference
+	value     string
+	onChange  func(value string)
+}
+
+// NewResolver creates a resolver reading secrets from backend
+func NewResolver(backend Backend, refreshInterval time.Duration) *Resolver {
+	if refreshInterval <= 0 {
+		refreshInterval = 5 * time.Minute
+	}
+	return &Resolver{
+		backend:          backend,
+		refreshInterval:  refreshInterval,
+		cache:            make(map[string]*cachedSecret),
+		lastTokenRenewal: time.Now(),
+		stop:             make(chan struct{}),
+	}
+}
+
+// Init sets the resolver references in settings are resolved with
+func Init(resolver *Resolver) {
+	defaultResolver = resolver
+}
+
+// GetResolver returns the configured resolver, or nil when no secrets backend
+// is configured
+func GetResolver() *Resolver {
+	return defaultResolver
+}
+
+// ResolveValue returns the value value refers to when it is a secret
+// reference, and value itself otherwise
+func ResolveValue(value string) (string, error) {
+	if !IsReference(value) {
+		return value, nil
+	}
+	if defaultResolver == nil {
+		return "", ErrNoBackend
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return defaultResolver.Resolve(ctx, value)
+}
+
+// Backend returns the backend secrets are read from
+func (r *Resolver) Backend() Backend {
+	return r.backend
+}
+
+// Resolve returns the value value refers to when it is a secret reference,
+// and value itself otherwise. Secrets are read from the cache when they are
+// in it.
+func (r *Resolver) Resolve(ctx context.Context, value string) (string, error) {
+	if !IsReference(value) {
+		return value, nil
+	}
+	reference, err := ParseReference(value)
+	if err != nil {
+		return "", err
+	}
+
+	secret, err := r.secret(ctx, reference.Path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret %s: %w", reference.Path, err)
+	}
+	return secret.Value(reference.Key)
+}
+
+// Watch resolves value and calls onChange with its new value whenever the
+// secret it refers to is rotated. Values which aren't references never
+// change.
+func (r *Resolver) Watch(ctx context.Context, value string, onChange func(value string)) (string, error) {
+	resolved, err := r.Resolve(ctx, value)
+	if err != nil || !IsReference(value) {
+		return resolved, err
+	}
+	reference, _ := ParseReference(value)
+
+	r.mu.Lock()
+	r.watchers = append(r.watchers, &watcher{reference: *reference, value: resolved, onChange: onChange})
+	r.mu.Unlock()
+	return resolved, nil
+}
+
+// Start renews and refreshes the cached secrets in the background until Stop
+// is called
+func (r *Resolver) Start() {
+	go func() {
+		ticker := time.NewTicker(checkInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), checkInterval)
+				r.Refresh(ctx)
+				cancel()
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops renewing and refreshing secrets
+func (r *Resolver) Stop() {
+	r.stopOnce.Do(func() { close(r.stop) })
+}
+
+// Refresh renews the leases of cached secrets which are half way through
+// them, and reads again those whose lease can't be renewed or which were
+// read longer than the refresh interval ago. Watchers of values which
+// changed are then told of their new values.
+func (r *Resolver) Refresh(ctx context.Context) {
+	now := time.Now()
+
+	r.mu.Lock()
+	due := make(map[string]*cachedSecret)
+	for path, cached := range r.cache {
+		due[path] = cached
+	}
+	renewToken := now.Sub(r.lastTokenRenewal) >= r.refreshInterval
+	if renewToken {
+		r.lastTokenRenewal = now
+	}
+	r.mu.Unlock()
+
+	if renewer, ok := r.backend.(TokenRenewer); ok && renewToken {
+		if err := renewer.RenewToken(ctx); err != nil {
+			log.Printf("Failed to renew %s token: %v", r.backend.Name(), err)
+		}
+	}
+
+	refreshed := false
+	for path, cached := range due {
+		secret := cached.secret
+		if secret.LeaseDuration > 0 {
+			// Leased secrets don't change until they are read again
+			if now.Before(cached.renewedAt.Add(secret.LeaseDuration / 2)) {
+				continue
+			}
+			if secret.Renewable && secret.LeaseID != "" {
+				duration, err := r.backend.Renew(ctx, secret.LeaseID)
+				if err == nil && duration > 0 {
+					r.mu.Lock()
+					secret.LeaseDuration = duration
+					cached.renewedAt = now
+					r.mu.Unlock()
+					continue
+				}
+				if err != nil && !errors.Is(err, ErrNotRenewable) {
+					log.Printf("Failed to renew lease of secret %s, reading it again: %v", path, err)
+				}
+			}
+		} else if now.Sub(cached.fetchedAt) < r.refreshInterval {
+			continue
+		}
+
+		if _, err := r.read(ctx, path); err != nil {
+			// The cached values are used until the backend is back
+			log.Printf("Failed to refresh secret %s: %v", path, err)
+			continue
+		}
+		refreshed = true
+	}
+
+	if refreshed {
+		r.notifyWatchers()
+	}
+}
+
+// secret returns the secret at path from the cache, reading it into the
+// cache when it isn't there
+func (r *Resolver) secret(ctx context.Context, path string) (*Secret, error) {
+	r.mu.Lock()
+	cached, ok := r.cache[path]
+	r.mu.Unlock()
+	if ok {
+		return cached.secret, nil
+	}
+	return r.read(ctx, path)
+}
+
+func (r *Resolver) read(ctx context.Context, path string) (*Secret, error) {
+	secret, err := r.backend.Read(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	r.mu.Lock()
+	r.cache[path] = &cachedSecret{secret: secret, fetchedAt: now, renewedAt: now}
+	r.mu.Unlock()
+	return secret, nil
+}
+
+// notifyWatchers calls the watchers whose value changed with their new value
+func (r *Resolver) notifyWatchers() {
+	type change struct {
+		onChange func(string)
+		value    string
+	}
+	var changes []change
+
+	r.mu.Lock()
+	for _, w := range r.watchers {
+		cached, ok := r.cache[w.reference.Path]
+		if !ok {
+			continue
+		}
+		value, err := cached.secret.Value(w.reference.Key)
+		if err != nil {
+			log.Printf("Rotated secret %s no longer has its value: %v", w.reference.Path, err)
+			continue
+		}
+		if value != w.value {
+			w.value = value
+			changes = append(changes, change{onChange: w.onChange, value: value})
+		}
+	}
+	r.mu.Unlock()
+
+	for _, c := range changes {
+		c.onChange(c.value)
+	}
+}
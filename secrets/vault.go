@@ -0,0 +1,197 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// VaultBackend reads secrets through the Vault HTTP API. Paths are API paths
+// under /v1, so KV version 2 secrets are read as "<mount>/data/<path>".
+type VaultBackend struct {
+	addr      string
+	token     string
+	namespace string
+	client    *http.Client
+
+	// tokenNotRenewable is set once Vault refuses to renew the token, as root
+	// and periodic tokens are not renewed
+	tokenNotRenewable atomic.Bool
+}
+
+// vaultResponse is the envelope of Vault API responses
+type vaultResponse struct {
+	LeaseID       string                 `json:"lease_id"`
+	LeaseDuration int64                  `json:"lease_duration"`
+	Renewable     bool                   `json:"renewable"`
+	Data          map[string]interface{} `json:"data"`
+	Errors        []string               `json:"errors"`
+}
+
+// NewVaultBackend creates a new Vault backend
+func NewVaultBackend(cfg *Config) *VaultBackend {
+	return &VaultBackend{
+		addr:      strings.TrimRight(cfg.VaultAddr, "/"),
+		token:     cfg.VaultToken,
+		namespace: cfg.VaultNamespace,
+		client:    newHTTPClient(cfg.Timeout),
+	}
+}
+
+func (v *VaultBackend) Name() string {
+	return "vault"
+}
+
+// Read reads the secret at path. The values of KV version 2 secrets are
+// unwrapped from their metadata.
+func (v *VaultBackend) Read(ctx context.Context, path string) (*Secret, error) {
+	var response vaultResponse
+	if err := v.do(ctx, http.MethodGet, "/v1/"+strings.TrimLeft(path, "/"), nil, &response); err != nil {
+		return nil, err
+	}
+
+	secret := &Secret{
+		LeaseID:       response.LeaseID,
+		LeaseDuration: time.Duration(response.LeaseDuration) * time.Second,
+		Renewable:     response.Renewable,
+	}
+	data := response.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if metadata, ok := data["metadata"].(map[string]interface{}); ok {
+			data = nested
+			if version, ok := metadata["version"]; ok {
+				secret.Version = fmt.Sprint(version)
+			}
+		}
+	}
+	if data == nil {
+		// KV version 2 answers deleted secrets with their metadata only
+		return nil, ErrSecretNotFound
+	}
+	secret.Data = stringValues(data)
+	return secret, nil
+}
+
+// Renew extends the lease of a dynamic secret
+func (v *VaultBackend) Renew(ctx context.Context, leaseID string) (time.Duration, error) {
+	var response vaultResponse
+	if err := v.do(ctx, http.MethodPut, "/v1/sys/leases/renew", map[string]string{"lease_id": leaseID}, &response); err != nil {
+		return 0, err
+	}
+	if !response.Renewable {
+		return time.Duration(response.LeaseDuration) * time.Second, ErrNotRenewable
+	}
+	return time.Duration(response.LeaseDuration) * time.Second, nil
+}
+
+// RenewToken extends the lease of the token the backend authenticates with
+func (v *VaultBackend) RenewToken(ctx context.Context) error {
+	if v.tokenNotRenewable.Load() {
+		return nil
+	}
+
+	var response struct {
+		Auth struct {
+			Renewable bool `json:"renewable"`
+		} `json:"auth"`
+	}
+	err := v.do(ctx, http.MethodPost, "/v1/auth/token/renew-self", map[string]string{}, &response)
+	if err != nil {
+		if vaultErr, ok := err.(*VaultError); ok && vaultErr.StatusCode == http.StatusBadRequest {
+			v.tokenNotRenewable.Store(true)
+			return nil
+		}
+		return err
+	}
+	if !response.Auth.Renewable {
+		v.tokenNotRenewable.Store(true)
+	}
+	return nil
+}
+
+// VaultError is an error answered by the Vault API
+type VaultError struct {
+	StatusCode int
+	Errors     []string
+}
+
+func (e *VaultError) Error() string {
+	if len(e.Errors) == 0 {
+		return fmt.Sprintf("vault returned status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("vault returned status %d: %s", e.StatusCode, strings.Join(e.Errors, "; "))
+}
+
+func (v *VaultBackend) do(ctx context.Context, method, path string, body interface{}, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, v.addr+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	req.Header.Set("X-Vault-Request", "true")
+	if v.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("vault request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrSecretNotFound
+	}
+	if resp.StatusCode >= 300 {
+		var response vaultResponse
+		json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&response)
+		return &VaultError{StatusCode: resp.StatusCode, Errors: response.Errors}
+	}
+	if resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode vault response: %v", err)
+	}
+	return nil
+}
+
+// stringValues converts a secret's values to strings. Values which aren't
+// strings are kept as JSON.
+func stringValues(data map[string]interface{}) map[string]string {
+	values := make(map[string]string, len(data))
+	for key, value := range data {
+		switch value := value.(type) {
+		case string:
+			values[key] = value
+		case nil:
+			values[key] = ""
+		default:
+			encoded, err := json.Marshal(value)
+			if err != nil {
+				values[key] = fmt.Sprint(value)
+				continue
+			}
+			values[key] = string(encoded)
+		}
+	}
+	return values
+}
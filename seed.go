@@ -0,0 +1,589 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"oncloud/config"
+	"oncloud/database"
+	"oncloud/models"
+	"oncloud/utils"
+	"os"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const seedUsage = `Usage: oncloud seed [flags]
+
+Fills the database with synthetic users, folders, files, activities and
+analytics events for load tests. Seeded users are named seed_user_NNNNN,
+with emails at ` + seedEmailDomain + `, and all have the same password.
+Seeded files have records only, nothing is stored on the storage providers,
+so downloading them fails.
+
+Flags:
+  -users N            users to create (default 100)
+  -folders N          mean folders per user (default 10)
+  -files N            mean files per user (default 200)
+  -activities N       mean activities per user (default 500)
+  -days N             days of history to spread the data over (default 90)
+  -password P         password of the seeded users (default ` + seedDefaultPassword + `)
+  -seed N             random seed, the same seed gives the same distributions (default 1)
+  -clean              remove previously seeded data first; with -users 0 only remove it
+`
+
+const (
+	seedEmailDomain     = "seed.oncloud.test"
+	seedDefaultPassword = "seed-password"
+	seedBatchSize       = 1000
+)
+
+// seedFileKinds are the kinds of files seeded, with their share of the
+// files and median size. Sizes are log-normally distributed around the
+// median, as file sizes are in practice.
+var seedFileKinds = []struct {
+	prefix     string
+	extension  string
+	mimeType   string
+	medianSize float64
+	weight     int
+}{
+	{prefix: "IMG", extension: "jpg", mimeType: "image/jpeg", medianSize: 2560 << 10, weight: 30},
+	{prefix: "Screenshot", extension: "png", mimeType: "image/png", medianSize: 800 << 10, weight: 12},
+	{prefix: "IMG", extension: "heic", mimeType: "image/heic", medianSize: 2 << 20, weight: 3},
+	{prefix: "Document", extension: "pdf", mimeType: "application/pdf", medianSize: 400 << 10, weight: 15},
+	{prefix: "Report", extension: "docx", mimeType: "application/vnd.openxmlformats-officedocument.wordprocessingml.document", medianSize: 120 << 10, weight: 10},
+	{prefix: "Budget", extension: "xlsx", mimeType: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", medianSize: 60 << 10, weight: 5},
+	{prefix: "Notes", extension: "txt", mimeType: "text/plain", medianSize: 8 << 10, weight: 6},
+	{prefix: "Export", extension: "csv", mimeType: "text/csv", medianSize: 200 << 10, weight: 3},
+	{prefix: "VID", extension: "mp4", mimeType: "video/mp4", medianSize: 60 << 20, weight: 6},
+	{prefix: "Track", extension: "mp3", mimeType: "audio/mpeg", medianSize: 5 << 20, weight: 5},
+	{prefix: "Backup", extension: "zip", mimeType: "application/zip", medianSize: 25 << 20, weight: 5},
+}
+
+// seedActions are the actions of seeded activities and their share of them
+var seedActions = []struct {
+	action string
+	weight int
+}{
+	{action: "view", weight: 40},
+	{action: "download", weight: 25},
+	{action: "upload", weight: 15},
+	{action: "share", weight: 5},
+	{action: "delete", weight: 5},
+	{action: "login", weight: 10},
+}
+
+var seedCountries = []struct {
+	country string
+	weight  int
+}{
+	{country: "US", weight: 30},
+	{country: "GB", weight: 10},
+	{country: "DE", weight: 10},
+	{country: "IN", weight: 12},
+	{country: "BR", weight: 8},
+	{country: "FR", weight: 7},
+	{country: "ID", weight: 8},
+	{country: "TR", weight: 5},
+	{country: "JP", weight: 5},
+	{country: "AU", weight: 5},
+}
+
+var (
+	seedFolderNames = []string{"Documents", "Photos", "Projects", "Work", "Music", "Videos", "Invoices",
+		"Archive", "Shared", "Travel", "Taxes", "Backups", "Design", "Notes", "School", "Family"}
+	seedFirstNames = []string{"Alex", "Sam", "Jordan", "Taylor", "Morgan", "Casey", "Riley", "Jamie",
+		"Avery", "Quinn", "Robin", "Drew", "Kai", "Rowan", "Sage", "Emery"}
+	seedLastNames = []string{"Smith", "Garcia", "Müller", "Kumar", "Silva", "Martin", "Wijaya", "Yılmaz",
+		"Tanaka", "Brown", "Nguyen", "Rossi", "Kowalski", "Dubois", "Ahmed", "Lee"}
+)
+
+// runSeed fills the database with synthetic data for load tests, so list,
+// upload and analytics benchmarks run against realistic volumes
+func runSeed(args []string) error {
+	flags := flag.NewFlagSet("seed", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, seedUsage) }
+	users := flags.Int("users", 100, "users to create")
+	folders := flags.Int("folders", 10, "mean folders per user")
+	files := flags.Int("files", 200, "mean files per user")
+	activities := flags.Int("activities", 500, "mean activities per user")
+	days := flags.Int("days", 90, "days of history")
+	password := flags.String("password", seedDefaultPassword, "password of the seeded users")
+	seed := flags.Int64("seed", 1, "random seed")
+	clean := flags.Bool("clean", false, "remove previously seeded data first")
+	if err := flags.Parse(args); errors.Is(err, flag.ErrHelp) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if *users < 0 || *folders < 0 || *files < 0 || *activities < 0 {
+		return fmt.Errorf("counts must not be negative")
+	}
+	if *days < 1 {
+		return fmt.Errorf("-days must be at least 1")
+	}
+
+	cfg := config.LoadConfig()
+	if cfg.IsProduction() {
+		return fmt.Errorf("refusing to seed synthetic data in production")
+	}
+	dbManager := config.NewDatabaseManager(cfg)
+	if err := dbManager.Initialize(); err != nil {
+		return err
+	}
+	defer dbManager.Close()
+
+	ctx := context.Background()
+	collections := database.NewCollections()
+
+	if *clean {
+		removed, err := cleanSeedData(ctx, collections)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Removed %d seeded users and their data\n", removed)
+	}
+	if *users == 0 {
+		return nil
+	}
+
+	plan, err := seedPlan(ctx, collections)
+	if err != nil {
+		return err
+	}
+	hashedPassword, err := utils.HashPassword(*password)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %v", err)
+	}
+	// Numbering continues after users seeded before, so seeding twice adds users
+	offset, err := collections.Users().CountDocuments(ctx, seedUserFilter())
+	if err != nil {
+		return err
+	}
+
+	seeder := &dataSeeder{
+		ctx:            ctx,
+		collections:    collections,
+		rand:           rand.New(rand.NewSource(*seed)),
+		now:            time.Now(),
+		days:           *days,
+		plan:           plan,
+		password:       hashedPassword,
+		provider:       cfg.DefaultStorageProvider,
+		meanFolders:    *folders,
+		meanFiles:      *files,
+		meanActivities: *activities,
+	}
+
+	started := time.Now()
+	for i := 0; i < *users; i++ {
+		if err := seeder.seedUser(int(offset) + i); err != nil {
+			return err
+		}
+	}
+	if err := seeder.flush(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Seeded %d users, %d folders, %d files, %d activities and %d analytics events in %s\n",
+		*users, seeder.counts.folders, seeder.counts.files, seeder.counts.activities, seeder.counts.events,
+		time.Since(started).Round(time.Millisecond))
+	fmt.Printf("Users log in as seed_user_%05d@%s to seed_user_%05d@%s\n",
+		offset, seedEmailDomain, int(offset)+*users-1, seedEmailDomain)
+	return nil
+}
+
+// dataSeeder generates the data of seeded users and inserts it in batches
+type dataSeeder struct {
+	ctx         context.Context
+	collections *database.Collections
+	rand        *rand.Rand
+	now         time.Time
+	days        int
+	plan        *models.Plan
+	password    string
+	provider    string
+
+	meanFolders    int
+	meanFiles      int
+	meanActivities int
+
+	batches map[*mongo.Collection][]interface{}
+	counts  struct {
+		folders, files, activities, events int
+	}
+}
+
+// seedUser generates a user with their folders, files and activities. The
+// user's usage and the folders' totals match the files generated.
+func (s *dataSeeder) seedUser(n int) error {
+	createdAt := s.recentTime(s.now.AddDate(0, 0, -s.days))
+	firstName := seedFirstNames[s.rand.Intn(len(seedFirstNames))]
+	lastName := seedLastNames[s.rand.Intn(len(seedLastNames))]
+	lastLogin := s.recentTime(createdAt)
+
+	user := &models.User{
+		ID:              primitive.NewObjectID(),
+		Username:        fmt.Sprintf("seed_user_%05d", n),
+		Email:           fmt.Sprintf("seed_user_%05d@%s", n, seedEmailDomain),
+		Password:        s.password,
+		FirstName:       firstName,
+		LastName:        lastName,
+		Country:         s.country(),
+		PlanID:          s.plan.ID,
+		IsActive:        true,
+		IsVerified:      true,
+		IsPremium:       !s.plan.IsFree,
+		EmailVerifiedAt: &createdAt,
+		LastLoginAt:     &lastLogin,
+		CreatedAt:       createdAt,
+		UpdatedAt:       lastLogin,
+	}
+
+	folders := s.seedFolders(user)
+	files := s.seedFiles(user, folders)
+
+	foldersByID := make(map[primitive.ObjectID]*models.Folder, len(folders))
+	for _, folder := range folders {
+		foldersByID[folder.ID] = folder
+	}
+	for _, file := range files {
+		if file.IsDeleted {
+			continue
+		}
+		user.StorageUsed += file.Size
+		user.FilesCount++
+		if file.FolderID == nil {
+			continue
+		}
+
+		folder := foldersByID[*file.FolderID]
+		folder.FilesCount++
+		folder.Size += file.Size
+		folder.TotalFiles++
+		folder.TotalSize += file.Size
+		for _, ancestorID := range folder.Ancestors {
+			foldersByID[ancestorID].TotalFiles++
+			foldersByID[ancestorID].TotalSize += file.Size
+		}
+	}
+	user.FoldersCount = len(folders)
+
+	if err := s.insert(s.collections.Users(), user); err != nil {
+		return err
+	}
+	for _, folder := range folders {
+		if err := s.insert(s.collections.Folders(), folder); err != nil {
+			return err
+		}
+	}
+	s.counts.folders += len(folders)
+	for _, file := range files {
+		if err := s.insert(s.collections.Files(), file); err != nil {
+			return err
+		}
+	}
+	s.counts.files += len(files)
+
+	return s.seedActivities(user, files)
+}
+
+// seedFolders generates a user's folders, about half at the top and the
+// rest nested in earlier ones
+func (s *dataSeeder) seedFolders(user *models.User) []*models.Folder {
+	count := 0
+	if s.meanFolders > 0 {
+		count = s.rand.Intn(2*s.meanFolders + 1)
+	}
+
+	folders := make([]*models.Folder, 0, count)
+	names := make(map[string]int)
+	for i := 0; i < count; i++ {
+		createdAt := s.recentTime(user.CreatedAt)
+		folder := &models.Folder{
+			ID:        primitive.NewObjectID(),
+			UserID:    user.ID,
+			Ancestors: []primitive.ObjectID{},
+			Tags:      []string{},
+			CreatedAt: createdAt,
+			UpdatedAt: createdAt,
+		}
+
+		parentPath := ""
+		if len(folders) > 0 && s.rand.Intn(2) == 0 {
+			parent := folders[s.rand.Intn(len(folders))]
+			folder.ParentID = &parent.ID
+			folder.Ancestors = append(append([]primitive.ObjectID{}, parent.Ancestors...), parent.ID)
+			parentPath = parent.Path
+		}
+
+		// Names are unique within their parent, as the API requires
+		name := seedFolderNames[s.rand.Intn(len(seedFolderNames))]
+		names[parentPath+"/"+name]++
+		if copies := names[parentPath+"/"+name]; copies > 1 {
+			name = fmt.Sprintf("%s %d", name, copies)
+		}
+		folder.Name = name
+		folder.Path = parentPath + "/" + name
+
+		folders = append(folders, folder)
+	}
+	return folders
+}
+
+// seedFiles generates a user's files. The number of files per user follows a
+// Pareto distribution, so a few users hold most of the files.
+func (s *dataSeeder) seedFiles(user *models.User, folders []*models.Folder) []*models.File {
+	count := s.paretoCount(s.meanFiles)
+
+	files := make([]*models.File, 0, count)
+	for i := 0; i < count; i++ {
+		kind := seedFileKinds[s.weightedIndex(len(seedFileKinds), func(i int) int { return seedFileKinds[i].weight })]
+		size := int64(math.Exp(math.Log(kind.medianSize) + 1.2*s.rand.NormFloat64()))
+		size = min(max(size, 1), 5<<30)
+
+		id := primitive.NewObjectID()
+		name := fmt.Sprintf("%s_%05d.%s", kind.prefix, i, kind.extension)
+		storageKey := fmt.Sprintf("seed/%s/%s.%s", user.ID.Hex(), id.Hex(), kind.extension)
+		createdAt := s.recentTime(user.CreatedAt)
+
+		file := &models.File{
+			ID:              id,
+			UserID:          user.ID,
+			Name:            name,
+			OriginalName:    name,
+			DisplayName:     name,
+			Path:            storageKey,
+			Size:            size,
+			MimeType:        kind.mimeType,
+			Extension:       "." + kind.extension,
+			Hash:            fmt.Sprintf("%x", s.rand.Uint64()),
+			StorageProvider: s.provider,
+			StorageKey:      storageKey,
+			Downloads:       s.paretoCount(3),
+			Views:           s.paretoCount(10),
+			Tags:            []string{},
+			Metadata:        map[string]interface{}{},
+			CreatedAt:       createdAt,
+			UpdatedAt:       createdAt,
+		}
+		// A fifth of the files are at the top, the rest in folders
+		if len(folders) > 0 && s.rand.Intn(5) != 0 {
+			file.FolderID = &folders[s.rand.Intn(len(folders))].ID
+		}
+		if s.rand.Intn(100) < 3 {
+			favoritedAt := s.recentTime(createdAt)
+			file.IsFavorite = true
+			file.FavoritedAt = &favoritedAt
+		}
+		if s.rand.Intn(100) < 5 {
+			deletedAt := s.recentTime(createdAt)
+			file.IsDeleted = true
+			file.DeletedAt = &deletedAt
+		}
+
+		files = append(files, file)
+	}
+	return files
+}
+
+// seedActivities generates a user's activities, and the analytics events
+// tracking them, weighted towards recent days as activity is in practice
+func (s *dataSeeder) seedActivities(user *models.User, files []*models.File) error {
+	count := s.paretoCount(s.meanActivities)
+
+	for i := 0; i < count; i++ {
+		action := seedActions[s.weightedIndex(len(seedActions), func(i int) int { return seedActions[i].weight })].action
+		createdAt := s.recentTime(user.CreatedAt)
+		ip := fmt.Sprintf("198.51.100.%d", 1+s.rand.Intn(254))
+
+		activity := bson.M{
+			"_id":        primitive.NewObjectID(),
+			"user_id":    user.ID,
+			"action":     action,
+			"ip_address": ip,
+			"created_at": createdAt,
+		}
+		event := bson.M{
+			"_id":        primitive.NewObjectID(),
+			"action":     action,
+			"user_id":    user.ID,
+			"ip_address": ip,
+			"country":    user.Country,
+			"timestamp":  createdAt,
+		}
+
+		if action == "login" || len(files) == 0 {
+			activity["action"], event["action"] = "login", "login"
+			event["type"] = "user_activity"
+			event["metadata"] = bson.M{"resource": "auth"}
+		} else {
+			file := files[s.rand.Intn(len(files))]
+			activity["file_id"] = file.ID
+			event["type"] = "file_activity"
+			metadata := bson.M{"file_id": file.ID, "resource": "file", "bytes": int64(0)}
+			if action == "download" || action == "upload" {
+				activity["bytes"] = file.Size
+				metadata["bytes"] = file.Size
+			}
+			event["metadata"] = metadata
+		}
+
+		if err := s.insert(s.collections.Activities(), activity); err != nil {
+			return err
+		}
+		if err := s.insert(s.collections.Analytics(), event); err != nil {
+			return err
+		}
+	}
+	s.counts.activities += count
+	s.counts.events += count
+	return nil
+}
+
+// insert queues a document, inserting the collection's batch once it is full
+func (s *dataSeeder) insert(collection *mongo.Collection, document interface{}) error {
+	if s.batches == nil {
+		s.batches = make(map[*mongo.Collection][]interface{})
+	}
+	s.batches[collection] = append(s.batches[collection], document)
+	if len(s.batches[collection]) < seedBatchSize {
+		return nil
+	}
+	return s.flushCollection(collection)
+}
+
+// flush inserts every queued document
+func (s *dataSeeder) flush() error {
+	for collection := range s.batches {
+		if err := s.flushCollection(collection); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *dataSeeder) flushCollection(collection *mongo.Collection) error {
+	batch := s.batches[collection]
+	if len(batch) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, time.Minute)
+	defer cancel()
+
+	if _, err := collection.InsertMany(ctx, batch, options.InsertMany().SetOrdered(false)); err != nil {
+		return fmt.Errorf("failed to insert into %s: %v", collection.Name(), err)
+	}
+	s.batches[collection] = batch[:0]
+	return nil
+}
+
+// recentTime returns a time between since and now, more likely recent
+func (s *dataSeeder) recentTime(since time.Time) time.Time {
+	span := s.now.Sub(since)
+	if span <= 0 {
+		return s.now
+	}
+	u := s.rand.Float64()
+	return s.now.Add(-time.Duration(u * u * float64(span)))
+}
+
+// paretoCount returns a Pareto distributed count with the given mean
+func (s *dataSeeder) paretoCount(mean int) int {
+	if mean <= 0 {
+		return 0
+	}
+	const alpha = 1.5
+	scale := float64(mean) * (alpha - 1) / alpha
+	count := scale / math.Pow(1-s.rand.Float64(), 1/alpha)
+	return min(int(count), 20*mean)
+}
+
+// weightedIndex picks an index below n with the probability of its weight
+func (s *dataSeeder) weightedIndex(n int, weight func(i int) int) int {
+	total := 0
+	for i := 0; i < n; i++ {
+		total += weight(i)
+	}
+	pick := s.rand.Intn(total)
+	for i := 0; i < n; i++ {
+		if pick -= weight(i); pick < 0 {
+			return i
+		}
+	}
+	return n - 1
+}
+
+func (s *dataSeeder) country() string {
+	return seedCountries[s.weightedIndex(len(seedCountries), func(i int) int { return seedCountries[i].weight })].country
+}
+
+// seedPlan returns the plan seeded users are on, the default plan as for
+// users who register
+func seedPlan(ctx context.Context, collections *database.Collections) (*models.Plan, error) {
+	var plan models.Plan
+	err := collections.Plans().FindOne(ctx, bson.M{"is_default": true, "is_active": true}).Decode(&plan)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		err = collections.Plans().FindOne(ctx, bson.M{"is_free": true, "is_active": true}).Decode(&plan)
+	}
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("no default plan available, start the server once to create the plans")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+func seedUserFilter() bson.M {
+	return bson.M{"email": bson.M{"$regex": "@" + strings.ReplaceAll(seedEmailDomain, ".", `\.`) + "$"}}
+}
+
+// cleanSeedData removes the seeded users and everything seeded for them
+func cleanSeedData(ctx context.Context, collections *database.Collections) (int, error) {
+	cursor, err := collections.Users().Find(ctx, seedUserFilter(), options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return 0, err
+	}
+	var users []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &users); err != nil {
+		return 0, err
+	}
+
+	for start := 0; start < len(users); start += seedBatchSize {
+		end := min(start+seedBatchSize, len(users))
+		ids := make([]primitive.ObjectID, 0, end-start)
+		for _, user := range users[start:end] {
+			ids = append(ids, user.ID)
+		}
+
+		filter := bson.M{"user_id": bson.M{"$in": ids}}
+		for _, collection := range []*mongo.Collection{
+			collections.Files(),
+			collections.Folders(),
+			collections.Activities(),
+			collections.Analytics(),
+		} {
+			if _, err := collection.DeleteMany(ctx, filter); err != nil {
+				return 0, fmt.Errorf("failed to clean %s: %v", collection.Name(), err)
+			}
+		}
+		if _, err := collections.Users().DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+			return 0, fmt.Errorf("failed to clean users: %v", err)
+		}
+	}
+	return len(users), nil
+}
@@ -0,0 +1,400 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"oncloud/database"
+	"oncloud/models"
+	"oncloud/utils"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// A reporter's repeated reports of the same link within this window are
+// merged into the first one
+const abuseReportDedupeWindow = 24 * time.Hour
+
+var (
+	ErrAbuseReportNotFound  = notFoundError("abuse report not found")
+	ErrReportedLinkNotFound = notFoundError("link not found")
+)
+
+// AbuseReportFilter narrows an abuse report listing
+type AbuseReportFilter struct {
+	Status string
+	Token  string
+	FileID *primitive.ObjectID
+}
+
+type AbuseReportService struct {
+	*BaseService
+	auditService *AuditService
+}
+
+func NewAbuseReportService() *AbuseReportService {
+	return NewAbuseReportServiceWith(Dependencies{})
+}
+
+// NewAbuseReportServiceWith creates an abuse report service on the given
+// dependencies
+func NewAbuseReportServiceWith(deps Dependencies) *AbuseReportService {
+	return &AbuseReportService{
+		BaseService:  NewBaseServiceWith(deps),
+		auditService: NewAuditServiceWith(deps),
+	}
+}
+
+// reportedLink is what a share token points to
+type reportedLink struct {
+	linkType string
+	fileID   *primitive.ObjectID
+	folderID *primitive.ObjectID
+	ownerID  primitive.ObjectID
+}
+
+// CreateReport records a report about the link with token. A reporter who
+// reports the same link again within a day gets their earlier report back.
+func (as *AbuseReportService) CreateReport(ctx context.Context, token string, req *models.AbuseReportRequest, clientIP string) (*models.AbuseReport, error) {
+	link, err := as.resolveLink(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	reporterIP := utils.AnonymizeIP(clientIP)
+	var existing models.AbuseReport
+	err = as.collections.AbuseReports().FindOne(ctx, bson.M{
+		"token":       token,
+		"reporter_ip": reporterIP,
+		"created_at":  bson.M{"$gt": time.Now().Add(-abuseReportDedupeWindow)},
+	}).Decode(&existing)
+	if err == nil {
+		return &existing, nil
+	}
+
+	now := time.Now()
+	report := &models.AbuseReport{
+		ID:            primitive.NewObjectID(),
+		Token:         token,
+		LinkType:      link.linkType,
+		FileID:        link.fileID,
+		FolderID:      link.folderID,
+		OwnerID:       link.ownerID,
+		Reason:        req.Reason,
+		Description:   req.Description,
+		ReporterName:  req.ReporterName,
+		ReporterEmail: req.ReporterEmail,
+		Evidence:      req.Evidence,
+		ReporterIP:    reporterIP,
+		Status:        models.AbuseReportPending,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	if _, err := as.collections.AbuseReports().InsertOne(ctx, report); err != nil {
+		return nil, fmt.Errorf("failed to save report: %v", err)
+	}
+
+	return report, nil
+}
+
+// ListReports returns reports, newest first
+func (as *AbuseReportService) ListReports(ctx context.Context, filter AbuseReportFilter, page, limit int) ([]models.AbuseReport, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	query := bson.M{}
+	if filter.Status != "" {
+		query["status"] = filter.Status
+	}
+	if filter.Token != "" {
+		query["token"] = filter.Token
+	}
+	if filter.FileID != nil {
+		query["file_id"] = *filter.FileID
+	}
+
+	cursor, err := as.collections.AbuseReports().Find(ctx, query,
+		options.Find().
+			SetSort(bson.M{"created_at": -1}).
+			SetSkip(int64((page-1)*limit)).
+			SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	reports := []models.AbuseReport{}
+	if err := cursor.All(ctx, &reports); err != nil {
+		return nil, 0, err
+	}
+
+	total, err := as.collections.AbuseReports().CountDocuments(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return reports, int(total), nil
+}
+
+// GetReport returns one report
+func (as *AbuseReportService) GetReport(ctx context.Context, reportID primitive.ObjectID) (*models.AbuseReport, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var report models.AbuseReport
+	if err := as.collections.AbuseReports().FindOne(ctx, bson.M{"_id": reportID}).Decode(&report); err != nil {
+		return nil, ErrAbuseReportNotFound
+	}
+	return &report, nil
+}
+
+// TriageReport moves a report to a new status. Once the link has as many
+// confirmed reports as the abuse_report_disable_threshold setting asks for,
+// or when the admin asks to, the link is disabled.
+func (as *AbuseReportService) TriageReport(ctx context.Context, reportID primitive.ObjectID, req *models.AbuseReportTriageRequest, adminID primitive.ObjectID) (*models.AbuseReport, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	var report models.AbuseReport
+	err := as.collections.AbuseReports().FindOneAndUpdate(ctx,
+		bson.M{"_id": reportID},
+		bson.M{"$set": bson.M{
+			"status":       req.Status,
+			"review_notes": req.Notes,
+			"reviewed_by":  adminID,
+			"reviewed_at":  now,
+			"updated_at":   now,
+		}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&report)
+	if err != nil {
+		return nil, ErrAbuseReportNotFound
+	}
+
+	if report.LinkDisabled {
+		return &report, nil
+	}
+
+	disable := req.DisableLink
+	if !disable && report.Status == models.AbuseReportConfirmed {
+		if threshold := RuntimeSettingInt64(RuntimeSettingAbuseDisableThreshold); threshold > 0 {
+			confirmed, err := as.collections.AbuseReports().CountDocuments(ctx, bson.M{
+				"token":  report.Token,
+				"status": models.AbuseReportConfirmed,
+			})
+			if err != nil {
+				return nil, err
+			}
+			disable = confirmed >= threshold
+		}
+	}
+
+	if disable {
+		if err := as.disableLink(ctx, &report, adminID, req.DisableLink); err != nil {
+			return nil, err
+		}
+		report.LinkDisabled = true
+	}
+
+	return &report, nil
+}
+
+// GetReportedFiles groups the reports with a given status by file, most
+// recently reported first
+func (as *AbuseReportService) GetReportedFiles(ctx context.Context, status string, page, limit int) ([]map[string]interface{}, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	match := bson.M{"file_id": bson.M{"$exists": true}}
+	if status != "" {
+		match["status"] = status
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.M{
+			"_id":               "$file_id",
+			"owner_id":          bson.M{"$first": "$owner_id"},
+			"reports":           bson.M{"$sum": 1},
+			"reasons":           bson.M{"$addToSet": "$reason"},
+			"tokens":            bson.M{"$addToSet": "$token"},
+			"link_disabled":     bson.M{"$max": "$link_disabled"},
+			"first_reported_at": bson.M{"$min": "$created_at"},
+			"last_reported_at":  bson.M{"$max": "$created_at"},
+		}}},
+		{{Key: "$sort", Value: bson.M{"last_reported_at": -1}}},
+		{{Key: "$facet", Value: bson.M{
+			"total": bson.A{bson.M{"$count": "count"}},
+			"files": bson.A{
+				bson.M{"$skip": (page - 1) * limit},
+				bson.M{"$limit": limit},
+				bson.M{"$lookup": bson.M{
+					"from":         database.FilesCollection,
+					"localField":   "_id",
+					"foreignField": "_id",
+					"as":           "file",
+				}},
+				bson.M{"$unwind": bson.M{"path": "$file", "preserveNullAndEmptyArrays": true}},
+			},
+		}}},
+	}
+
+	cursor, err := as.collections.AbuseReports().Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var result []struct {
+		Total []struct {
+			Count int `bson:"count"`
+		} `bson:"total"`
+		Files []struct {
+			FileID          primitive.ObjectID `bson:"_id"`
+			OwnerID         primitive.ObjectID `bson:"owner_id"`
+			Reports         int                `bson:"reports"`
+			Reasons         []string           `bson:"reasons"`
+			Tokens          []string           `bson:"tokens"`
+			LinkDisabled    bool               `bson:"link_disabled"`
+			FirstReportedAt time.Time          `bson:"first_reported_at"`
+			LastReportedAt  time.Time          `bson:"last_reported_at"`
+			File            *models.File       `bson:"file"`
+		} `bson:"files"`
+	}
+	if err := cursor.All(ctx, &result); err != nil {
+		return nil, 0, err
+	}
+
+	files := []map[string]interface{}{}
+	if len(result) == 0 {
+		return files, 0, nil
+	}
+	for _, group := range result[0].Files {
+		files = append(files, map[string]interface{}{
+			"file_id":           group.FileID,
+			"file":              group.File,
+			"owner_id":          group.OwnerID,
+			"reports":           group.Reports,
+			"reasons":           group.Reasons,
+			"tokens":            group.Tokens,
+			"link_disabled":     group.LinkDisabled,
+			"first_reported_at": group.FirstReportedAt,
+			"last_reported_at":  group.LastReportedAt,
+		})
+	}
+
+	total := 0
+	if len(result[0].Total) > 0 {
+		total = result[0].Total[0].Count
+	}
+	return files, total, nil
+}
+
+// resolveLink finds what a share token points to: a file or folder share
+// link, or a public file or folder
+func (as *AbuseReportService) resolveLink(ctx context.Context, token string) (*reportedLink, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var share models.FileShare
+	err := as.collections.FileShares().FindOne(ctx, bson.M{"token": token, "is_active": true}).Decode(&share)
+	if err == nil {
+		return &reportedLink{linkType: "file_share", fileID: &share.FileID, ownerID: share.UserID}, nil
+	}
+
+	// Folder shares are kept apart from file shares
+	err = as.collections.Collection("folder_shares").FindOne(ctx, bson.M{"token": token, "is_active": true}).Decode(&share)
+	if err == nil {
+		return &reportedLink{linkType: "folder_share", folderID: &share.FileID, ownerID: share.UserID}, nil
+	}
+
+	var file models.File
+	err = as.collections.Files().FindOne(ctx, bson.M{"share_token": token, "is_public": true, "is_deleted": false}).Decode(&file)
+	if err == nil {
+		return &reportedLink{linkType: "public_file", fileID: &file.ID, ownerID: file.UserID}, nil
+	}
+
+	var folder models.Folder
+	err = as.collections.Folders().FindOne(ctx, bson.M{"share_token": token, "is_public": true, "is_deleted": false}).Decode(&folder)
+	if err == nil {
+		return &reportedLink{linkType: "public_folder", folderID: &folder.ID, ownerID: folder.UserID}, nil
+	}
+
+	return nil, ErrReportedLinkNotFound
+}
+
+// disableLink takes down the reported link, marks every report about it and
+// tells the owner
+func (as *AbuseReportService) disableLink(ctx context.Context, report *models.AbuseReport, adminID primitive.ObjectID, manual bool) error {
+	now := time.Now()
+	unshare := bson.M{
+		"$set":   bson.M{"is_shared": false, "is_public": false, "updated_at": now},
+		"$unset": bson.M{"share_token": ""},
+	}
+
+	var err error
+	switch {
+	case report.FileID != nil:
+		_, err = as.collections.FileShares().UpdateMany(ctx, bson.M{"token": report.Token}, bson.M{"$set": bson.M{"is_active": false}})
+		if err == nil {
+			_, err = as.collections.Files().UpdateOne(ctx, bson.M{"_id": *report.FileID, "share_token": report.Token}, unshare)
+		}
+	case report.FolderID != nil:
+		_, err = as.collections.Collection("folder_shares").UpdateMany(ctx, bson.M{"token": report.Token}, bson.M{"$set": bson.M{"is_active": false}})
+		if err == nil {
+			_, err = as.collections.Folders().UpdateOne(ctx, bson.M{"_id": *report.FolderID, "share_token": report.Token}, unshare)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to disable link: %v", err)
+	}
+
+	if _, err := as.collections.AbuseReports().UpdateMany(ctx,
+		bson.M{"token": report.Token},
+		bson.M{"$set": bson.M{"link_disabled": true, "updated_at": now}},
+	); err != nil {
+		log.Printf("Failed to mark reports of disabled link %s: %v", report.ID.Hex(), err)
+	}
+
+	resourceType, resourceID := "folder", ""
+	if report.FileID != nil {
+		resourceType, resourceID = "file", report.FileID.Hex()
+	} else if report.FolderID != nil {
+		resourceID = report.FolderID.Hex()
+	}
+	as.auditService.Record(ctx, &models.AuditLog{
+		ActorType:    "admin",
+		ActorID:      &adminID,
+		Action:       "share.disabled_for_abuse",
+		ResourceType: resourceType,
+		ResourceID:   resourceID,
+		Outcome:      "success",
+		Details:      map[string]interface{}{"report_id": report.ID, "link_type": report.LinkType, "manual": manual},
+	})
+
+	as.collections.Notifications().InsertOne(ctx, bson.M{
+		"_id":     primitive.NewObjectID(),
+		"user_id": report.OwnerID,
+		"type":    "share_disabled",
+		"title":   "A share link was disabled",
+		"message": "One of your share links was disabled after it was reported for " + report.Reason + ".",
+		"data": bson.M{
+			"file_id":   report.FileID,
+			"folder_id": report.FolderID,
+			"link_type": report.LinkType,
+		},
+		"is_read":    false,
+		"created_at": now,
+	})
+
+	return nil
+}
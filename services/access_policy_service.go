@@ -0,0 +1,440 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"oncloud/models"
+	"oncloud/utils"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	AccessScopeGlobal = "global"
+	AccessScopeUser   = "user"
+
+	// Policies are consulted on every request, so they are cached briefly
+	accessPolicyCacheTTL = 30 * time.Second
+
+	maxAccessPolicyRules = 100
+)
+
+// AccessDecision is the outcome of checking a client against an IP access policy
+type AccessDecision struct {
+	Allowed bool   `json:"allowed"`
+	Scope   string `json:"scope,omitempty"`
+	Reason  string `json:"reason,omitempty"` // ip_denied, country_denied, not_allowed
+	Message string `json:"message,omitempty"`
+	Country string `json:"country,omitempty"`
+}
+
+// compiledPolicy is a policy with its CIDRs parsed, as held in the cache
+type compiledPolicy struct {
+	policy    *models.IPAccessPolicy
+	allowNets []*net.IPNet
+	denyNets  []*net.IPNet
+	loadedAt  time.Time
+}
+
+var accessPolicyCache = struct {
+	entries map[string]*compiledPolicy
+	mutex   sync.RWMutex
+}{entries: make(map[string]*compiledPolicy)}
+
+type AccessPolicyService struct {
+	*BaseService
+}
+
+func NewAccessPolicyService() *AccessPolicyService {
+	return NewAccessPolicyServiceWith(Dependencies{})
+}
+
+// NewAccessPolicyServiceWith creates an access policy service on the given
+// dependencies
+func NewAccessPolicyServiceWith(deps Dependencies) *AccessPolicyService {
+	return &AccessPolicyService{
+		BaseService: NewBaseServiceWith(deps),
+	}
+}
+
+// GetGlobalPolicy returns the deployment-wide policy, or an empty disabled one
+func (ps *AccessPolicyService) GetGlobalPolicy(ctx context.Context) (*models.IPAccessPolicy, error) {
+	return ps.getPolicy(ctx, AccessScopeGlobal, nil)
+}
+
+// GetUserPolicy returns a user's own policy, or an empty disabled one
+func (ps *AccessPolicyService) GetUserPolicy(ctx context.Context, userID primitive.ObjectID) (*models.IPAccessPolicy, error) {
+	return ps.getPolicy(ctx, AccessScopeUser, &userID)
+}
+
+// SaveGlobalPolicy replaces the deployment-wide policy
+func (ps *AccessPolicyService) SaveGlobalPolicy(ctx context.Context, req *models.IPAccessPolicyRequest, adminID primitive.ObjectID) (*models.IPAccessPolicy, error) {
+	return ps.savePolicy(ctx, AccessScopeGlobal, nil, req, &adminID)
+}
+
+// SaveUserPolicy replaces a user's policy. A policy that would lock out the
+// client saving it is rejected.
+func (ps *AccessPolicyService) SaveUserPolicy(ctx context.Context, userID primitive.ObjectID, req *models.IPAccessPolicyRequest, clientIP string) (*models.IPAccessPolicy, error) {
+	if req.IsEnabled {
+		compiled, err := compilePolicy(policyFromRequest(req))
+		if err != nil {
+			return nil, err
+		}
+		if reason, _ := compiled.evaluate(clientIP); reason != "" {
+			return nil, errors.New("policy would block your current IP address")
+		}
+	}
+
+	return ps.savePolicy(ctx, AccessScopeUser, &userID, req, &userID)
+}
+
+// DeleteUserPolicy removes a user's policy
+func (ps *AccessPolicyService) DeleteUserPolicy(ctx context.Context, userID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := ps.collections.IPAccessPolicies().DeleteOne(ctx, bson.M{"scope": AccessScopeUser, "user_id": userID})
+	if err != nil {
+		return fmt.Errorf("failed to delete access policy: %v", err)
+	}
+
+	invalidateAccessPolicy(AccessScopeUser, &userID)
+	return nil
+}
+
+// CheckGlobalAccess checks a client against the deployment-wide policy
+func (ps *AccessPolicyService) CheckGlobalAccess(ctx context.Context, clientIP string) *AccessDecision {
+	return ps.checkAccess(ctx, AccessScopeGlobal, nil, clientIP, false)
+}
+
+// CheckUserAccess checks a client against a user's policy. With forShare set
+// the policy only applies if the user extended it to their share links.
+func (ps *AccessPolicyService) CheckUserAccess(ctx context.Context, userID primitive.ObjectID, clientIP string, forShare bool) *AccessDecision {
+	return ps.checkAccess(ctx, AccessScopeUser, &userID, clientIP, forShare)
+}
+
+// GetShareOwner returns the owner of an active share link or public file link
+func (ps *AccessPolicyService) GetShareOwner(ctx context.Context, token string) (primitive.ObjectID, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	ownerOnly := options.FindOne().SetProjection(bson.M{"user_id": 1})
+
+	var share models.FileShare
+	err := ps.collections.FileShares().FindOne(ctx, bson.M{"token": token, "is_active": true}, ownerOnly).Decode(&share)
+	if err == nil {
+		return share.UserID, nil
+	}
+
+	// Folder shares are kept apart from file shares
+	err = ps.collections.Collection("folder_shares").FindOne(ctx, bson.M{"token": token, "is_active": true}, ownerOnly).Decode(&share)
+	if err == nil {
+		return share.UserID, nil
+	}
+
+	var file models.File
+	err = ps.collections.Files().FindOne(ctx, bson.M{"share_token": token, "is_deleted": false}, ownerOnly).Decode(&file)
+	if err == nil {
+		return file.UserID, nil
+	}
+
+	return primitive.NilObjectID, errors.New("share not found")
+}
+
+// GetEmbedOwner returns the owner of the active file share an embed token
+// was signed for
+func (ps *AccessPolicyService) GetEmbedOwner(ctx context.Context, shareID primitive.ObjectID) (primitive.ObjectID, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var share models.FileShare
+	err := ps.collections.FileShares().FindOne(ctx,
+		bson.M{"_id": shareID, "is_active": true},
+		options.FindOne().SetProjection(bson.M{"user_id": 1}),
+	).Decode(&share)
+	if err != nil {
+		return primitive.NilObjectID, errors.New("share not found")
+	}
+	return share.UserID, nil
+}
+
+func (ps *AccessPolicyService) checkAccess(ctx context.Context, scope string, userID *primitive.ObjectID, clientIP string, forShare bool) *AccessDecision {
+	compiled, err := ps.loadCompiledPolicy(ctx, scope, userID)
+	if err != nil {
+		// Fail open: a database hiccup must not lock every user out
+		log.Printf("Failed to load %s access policy: %v", scope, err)
+		return &AccessDecision{Allowed: true}
+	}
+	if compiled == nil || !compiled.policy.IsEnabled || (forShare && !compiled.policy.ApplyToShares) {
+		return &AccessDecision{Allowed: true}
+	}
+
+	reason, country := compiled.evaluate(clientIP)
+	if reason == "" {
+		return &AccessDecision{Allowed: true, Country: country}
+	}
+
+	decision := &AccessDecision{Scope: scope, Reason: reason, Country: country}
+	switch reason {
+	case "ip_denied":
+		decision.Message = "Access denied: your IP address is blocked"
+	case "country_denied":
+		decision.Message = fmt.Sprintf("Access denied: access from your country (%s) is blocked", country)
+	default:
+		decision.Message = "Access denied: your IP address or country is not on the allow list"
+	}
+	if scope == AccessScopeUser {
+		decision.Message += " by the account owner"
+	}
+
+	return decision
+}
+
+func (ps *AccessPolicyService) getPolicy(ctx context.Context, scope string, userID *primitive.ObjectID) (*models.IPAccessPolicy, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var policy models.IPAccessPolicy
+	err := ps.collections.IPAccessPolicies().FindOne(ctx, policyFilter(scope, userID)).Decode(&policy)
+	if err == mongo.ErrNoDocuments {
+		return &models.IPAccessPolicy{
+			Scope:          scope,
+			UserID:         userID,
+			AllowCIDRs:     []string{},
+			DenyCIDRs:      []string{},
+			AllowCountries: []string{},
+			DenyCountries:  []string{},
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &policy, nil
+}
+
+func (ps *AccessPolicyService) savePolicy(ctx context.Context, scope string, userID *primitive.ObjectID, req *models.IPAccessPolicyRequest, updatedBy *primitive.ObjectID) (*models.IPAccessPolicy, error) {
+	policy := policyFromRequest(req)
+	if _, err := compilePolicy(policy); err != nil {
+		return nil, err
+	}
+	if len(policy.AllowCountries)+len(policy.DenyCountries) > 0 && !utils.GeoIPEnabled() {
+		return nil, errors.New("country rules require a GeoIP database to be configured")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+
+	_, err := ps.collections.IPAccessPolicies().UpdateOne(ctx,
+		policyFilter(scope, userID),
+		bson.M{
+			"$set": bson.M{
+				"is_enabled":      policy.IsEnabled,
+				"allow_cidrs":     policy.AllowCIDRs,
+				"deny_cidrs":      policy.DenyCIDRs,
+				"allow_countries": policy.AllowCountries,
+				"deny_countries":  policy.DenyCountries,
+				"apply_to_shares": policy.ApplyToShares,
+				"updated_by":      updatedBy,
+				"updated_at":      now,
+			},
+			"$setOnInsert": bson.M{"_id": primitive.NewObjectID(), "created_at": now},
+		},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save access policy: %v", err)
+	}
+
+	invalidateAccessPolicy(scope, userID)
+	return ps.getPolicy(ctx, scope, userID)
+}
+
+// loadCompiledPolicy returns the cached policy, reloading it once stale.
+// A nil result means no policy exists.
+func (ps *AccessPolicyService) loadCompiledPolicy(ctx context.Context, scope string, userID *primitive.ObjectID) (*compiledPolicy, error) {
+	key := accessPolicyCacheKey(scope, userID)
+
+	accessPolicyCache.mutex.RLock()
+	cached, ok := accessPolicyCache.entries[key]
+	accessPolicyCache.mutex.RUnlock()
+	if ok && time.Since(cached.loadedAt) < accessPolicyCacheTTL {
+		if cached.policy == nil {
+			return nil, nil
+		}
+		return cached, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	compiled := &compiledPolicy{loadedAt: time.Now()}
+	var policy models.IPAccessPolicy
+	err := ps.collections.IPAccessPolicies().FindOne(ctx, policyFilter(scope, userID)).Decode(&policy)
+	switch {
+	case err == mongo.ErrNoDocuments:
+	case err != nil:
+		return nil, err
+	default:
+		if compiled, err = compilePolicy(&policy); err != nil {
+			return nil, err
+		}
+		compiled.loadedAt = time.Now()
+	}
+
+	accessPolicyCache.mutex.Lock()
+	accessPolicyCache.entries[key] = compiled
+	accessPolicyCache.mutex.Unlock()
+
+	if compiled.policy == nil {
+		return nil, nil
+	}
+	return compiled, nil
+}
+
+// evaluate returns why the client is denied, or "" if it is allowed, along
+// with the client's country when the policy has country rules
+func (cp *compiledPolicy) evaluate(clientIP string) (string, string) {
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return "not_allowed", ""
+	}
+
+	country := ""
+	if len(cp.policy.AllowCountries)+len(cp.policy.DenyCountries) > 0 {
+		if location := utils.LookupIP(clientIP); location != nil {
+			country = location.Country
+		}
+	}
+
+	for _, network := range cp.denyNets {
+		if network.Contains(ip) {
+			return "ip_denied", country
+		}
+	}
+	if country != "" && utils.SliceContains(cp.policy.DenyCountries, country) {
+		return "country_denied", country
+	}
+
+	if len(cp.allowNets) == 0 && len(cp.policy.AllowCountries) == 0 {
+		return "", country
+	}
+	for _, network := range cp.allowNets {
+		if network.Contains(ip) {
+			return "", country
+		}
+	}
+	if country != "" && utils.SliceContains(cp.policy.AllowCountries, country) {
+		return "", country
+	}
+
+	return "not_allowed", country
+}
+
+// compilePolicy validates and parses a policy's rules
+func compilePolicy(policy *models.IPAccessPolicy) (*compiledPolicy, error) {
+	if len(policy.AllowCIDRs)+len(policy.DenyCIDRs) > maxAccessPolicyRules ||
+		len(policy.AllowCountries)+len(policy.DenyCountries) > maxAccessPolicyRules {
+		return nil, fmt.Errorf("a policy may have at most %d IP and %d country rules", maxAccessPolicyRules, maxAccessPolicyRules)
+	}
+
+	compiled := &compiledPolicy{policy: policy}
+	var err error
+	if compiled.allowNets, err = parseNetworks(policy.AllowCIDRs); err != nil {
+		return nil, err
+	}
+	if compiled.denyNets, err = parseNetworks(policy.DenyCIDRs); err != nil {
+		return nil, err
+	}
+
+	for _, country := range append(append([]string{}, policy.AllowCountries...), policy.DenyCountries...) {
+		if len(country) != 2 || strings.ToUpper(country) != country {
+			return nil, fmt.Errorf("invalid country code: %s", country)
+		}
+	}
+
+	return compiled, nil
+}
+
+// parseNetworks parses CIDRs, treating bare addresses as single hosts
+func parseNetworks(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address: %s", cidr)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			cidr = fmt.Sprintf("%s/%d", cidr, bits)
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR: %s", cidr)
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+// policyFromRequest normalizes the rules of a policy request
+func policyFromRequest(req *models.IPAccessPolicyRequest) *models.IPAccessPolicy {
+	return &models.IPAccessPolicy{
+		IsEnabled:      req.IsEnabled,
+		AllowCIDRs:     normalizeRules(req.AllowCIDRs, false),
+		DenyCIDRs:      normalizeRules(req.DenyCIDRs, false),
+		AllowCountries: normalizeRules(req.AllowCountries, true),
+		DenyCountries:  normalizeRules(req.DenyCountries, true),
+		ApplyToShares:  req.ApplyToShares,
+	}
+}
+
+func normalizeRules(rules []string, upper bool) []string {
+	normalized := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		rule = strings.TrimSpace(rule)
+		if upper {
+			rule = strings.ToUpper(rule)
+		}
+		if rule != "" && !utils.SliceContains(normalized, rule) {
+			normalized = append(normalized, rule)
+		}
+	}
+	return normalized
+}
+
+func policyFilter(scope string, userID *primitive.ObjectID) bson.M {
+	if userID == nil {
+		return bson.M{"scope": scope}
+	}
+	return bson.M{"scope": scope, "user_id": *userID}
+}
+
+func accessPolicyCacheKey(scope string, userID *primitive.ObjectID) string {
+	if userID == nil {
+		return scope
+	}
+	return scope + ":" + userID.Hex()
+}
+
+func invalidateAccessPolicy(scope string, userID *primitive.ObjectID) {
+	accessPolicyCache.mutex.Lock()
+	delete(accessPolicyCache.entries, accessPolicyCacheKey(scope, userID))
+	accessPolicyCache.mutex.Unlock()
+}
@@ -0,0 +1,330 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"oncloud/models"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ActivityArchiveOptions configures moving old activities to cold storage
+type ActivityArchiveOptions struct {
+	// RetentionDays is how many days of activities are kept in the database;
+	// whole UTC days older than that are archived
+	RetentionDays int
+}
+
+var activityArchiveOptions = ActivityArchiveOptions{RetentionDays: 90}
+
+const (
+	// activityArchiveMaxDays caps the days archived per run, so catching up
+	// on a large backlog is spread over several runs
+	activityArchiveMaxDays = 30
+	// activityArchiveDeleteBatch is how many archived activities are deleted
+	// at a time
+	activityArchiveDeleteBatch = 1000
+	// archivedQueryMaxRange caps the range of a query on archived activities,
+	// each day of which is downloaded and read in full
+	archivedQueryMaxRange = 31 * 24 * time.Hour
+	// archivedQueryMaxLine is the longest activity read back from an archive
+	archivedQueryMaxLine = 16 * 1024 * 1024
+)
+
+var (
+	ErrActivityArchiveRunning = errors.New("activity archival is already running")
+	ErrArchivedRangeInvalid   = fmt.Errorf("from must be before to and at most %d days apart", int(archivedQueryMaxRange/(24*time.Hour)))
+)
+
+// activityArchiveRunning keeps scheduled and admin-triggered runs from
+// archiving the same day twice
+var activityArchiveRunning sync.Mutex
+
+// InitActivityArchives configures moving old activities to cold storage
+func InitActivityArchives(opts ActivityArchiveOptions) {
+	if opts.RetentionDays <= 0 {
+		opts.RetentionDays = 90
+	}
+	activityArchiveOptions = opts
+}
+
+// ActivityArchiveService moves activities past the retention period out of
+// the activities collection into gzipped NDJSON files on the default storage
+// provider, one or more per UTC day, and reads them back on demand
+type ActivityArchiveService struct {
+	*BaseService
+	storageService ObjectStore
+}
+
+func NewActivityArchiveService() *ActivityArchiveService {
+	return NewActivityArchiveServiceWith(Dependencies{})
+}
+
+// NewActivityArchiveServiceWith creates an activity archive service on the
+// given dependencies
+func NewActivityArchiveServiceWith(deps Dependencies) *ActivityArchiveService {
+	return &ActivityArchiveService{
+		BaseService:    NewBaseServiceWith(deps),
+		storageService: deps.storage(),
+	}
+}
+
+// ArchiveOldActivities archives the activities of every UTC day before the
+// retention cutoff, oldest first, and returns the archives written. Each
+// day's activities are deleted once its archive is stored and recorded.
+func (aas *ActivityArchiveService) ArchiveOldActivities(ctx context.Context) ([]models.ActivityArchive, error) {
+	if !activityArchiveRunning.TryLock() {
+		return nil, ErrActivityArchiveRunning
+	}
+	defer activityArchiveRunning.Unlock()
+
+	cutoff := time.Now().UTC().AddDate(0, 0, -activityArchiveOptions.RetentionDays).Truncate(24 * time.Hour)
+
+	archives := []models.ActivityArchive{}
+	for len(archives) < activityArchiveMaxDays {
+		day, found, err := aas.oldestActivityDay(ctx, cutoff)
+		if err != nil {
+			return archives, err
+		}
+		if !found {
+			break
+		}
+
+		archive, err := aas.archiveDay(ctx, day)
+		if err != nil {
+			return archives, fmt.Errorf("failed to archive activities of %s: %v", day.Format("2006-01-02"), err)
+		}
+		archives = append(archives, *archive)
+		log.Printf("Archived %d activities of %s", archive.Count, day.Format("2006-01-02"))
+	}
+
+	return archives, nil
+}
+
+// ListArchives returns the archives of the days in a range, oldest first
+func (aas *ActivityArchiveService) ListArchives(ctx context.Context, from, to time.Time) ([]models.ActivityArchive, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{}
+	day := bson.M{}
+	if !from.IsZero() {
+		day["$gte"] = from.UTC().Truncate(24 * time.Hour)
+	}
+	if !to.IsZero() {
+		day["$lte"] = to.UTC()
+	}
+	if len(day) > 0 {
+		filter["day"] = day
+	}
+
+	cursor, err := aas.collections.ActivityArchives().Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "day", Value: 1}, {Key: "from", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list activity archives: %v", err)
+	}
+
+	archives := []models.ActivityArchive{}
+	if err := cursor.All(ctx, &archives); err != nil {
+		return nil, fmt.Errorf("failed to list activity archives: %v", err)
+	}
+	return archives, nil
+}
+
+// QueryArchived reads the archived activities in a range back from storage,
+// optionally only those of a user or with an action
+func (aas *ActivityArchiveService) QueryArchived(ctx context.Context, query *models.ArchivedActivityQuery) (*models.ArchivedActivities, error) {
+	if !query.From.Before(query.To) || query.To.Sub(query.From) > archivedQueryMaxRange {
+		return nil, ErrArchivedRangeInvalid
+	}
+
+	archives, err := aas.ListArchives(ctx, query.From, query.To)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &models.ArchivedActivities{Activities: []map[string]interface{}{}}
+	for _, archive := range archives {
+		if archive.To.Before(query.From) || archive.From.After(query.To) {
+			continue
+		}
+		result.Archives++
+
+		done, err := aas.readArchive(ctx, &archive, query, result)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read activity archive %s: %v", archive.ID.Hex(), err)
+		}
+		if done {
+			break
+		}
+	}
+
+	return result, nil
+}
+
+// oldestActivityDay returns the UTC day of the oldest activity before cutoff
+func (aas *ActivityArchiveService) oldestActivityDay(ctx context.Context, cutoff time.Time) (time.Time, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var oldest struct {
+		CreatedAt time.Time `bson:"created_at"`
+	}
+	err := aas.collections.Activities().FindOne(ctx,
+		bson.M{"created_at": bson.M{"$lt": cutoff}},
+		options.FindOne().
+			SetSort(bson.M{"created_at": 1}).
+			SetProjection(bson.M{"created_at": 1}),
+	).Decode(&oldest)
+	if err == mongo.ErrNoDocuments {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("failed to find old activities: %v", err)
+	}
+	return oldest.CreatedAt.UTC().Truncate(24 * time.Hour), true, nil
+}
+
+// archiveDay writes the activities of a UTC day to an archive, records it
+// and deletes the archived activities
+func (aas *ActivityArchiveService) archiveDay(ctx context.Context, day time.Time) (*models.ActivityArchive, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Minute)
+	defer cancel()
+
+	cursor, err := aas.collections.Activities().Find(ctx,
+		bson.M{"created_at": bson.M{"$gte": day, "$lt": day.AddDate(0, 0, 1)}},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list activities: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	archive := &models.ActivityArchive{
+		ID:  primitive.NewObjectID(),
+		Day: day,
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	var archivedIDs []interface{}
+	for cursor.Next(ctx) {
+		var activity bson.M
+		if err := cursor.Decode(&activity); err != nil {
+			return nil, fmt.Errorf("failed to read activity: %v", err)
+		}
+		line, err := bson.MarshalExtJSON(activity, false, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode activity: %v", err)
+		}
+		gz.Write(line)
+		gz.Write([]byte{'\n'})
+
+		if createdAt, ok := activity["created_at"].(primitive.DateTime); ok {
+			if archive.From.IsZero() {
+				archive.From = createdAt.Time().UTC()
+			}
+			archive.To = createdAt.Time().UTC()
+		}
+		archivedIDs = append(archivedIDs, activity["_id"])
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list activities: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress activities: %v", err)
+	}
+	if archive.From.IsZero() {
+		archive.From, archive.To = day, day
+	}
+
+	provider, err := NewFileService().getDefaultStorageProvider(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("no storage provider available: %v", err)
+	}
+	key := fmt.Sprintf("activity-archives/%s-%s.ndjson.gz", day.Format("2006/01/02"), archive.ID.Hex())
+	if err := aas.storageService.UploadFile(ctx, provider.Type, key, buf.Bytes()); err != nil {
+		return nil, fmt.Errorf("failed to store archive: %v", err)
+	}
+
+	archive.Count = int64(len(archivedIDs))
+	archive.Size = int64(buf.Len())
+	archive.StorageProvider = provider.Type
+	archive.StorageKey = key
+	archive.CreatedAt = time.Now()
+	if _, err := aas.collections.ActivityArchives().InsertOne(ctx, archive); err != nil {
+		if delErr := aas.storageService.DeleteFile(ctx, provider.Type, key); delErr != nil {
+			log.Printf("Failed to delete unrecorded activity archive %s: %v", key, delErr)
+		}
+		return nil, fmt.Errorf("failed to record archive: %v", err)
+	}
+
+	// Only what was archived is deleted, not activities of the day written
+	// since it was read
+	for start := 0; start < len(archivedIDs); start += activityArchiveDeleteBatch {
+		end := start + activityArchiveDeleteBatch
+		if end > len(archivedIDs) {
+			end = len(archivedIDs)
+		}
+		if _, err := aas.collections.Activities().DeleteMany(ctx, bson.M{"_id": bson.M{"$in": archivedIDs[start:end]}}); err != nil {
+			return nil, fmt.Errorf("failed to delete archived activities: %v", err)
+		}
+	}
+
+	return archive, nil
+}
+
+// readArchive adds the activities of an archive matching a query to result
+// and reports whether the limit was reached
+func (aas *ActivityArchiveService) readArchive(ctx context.Context, archive *models.ActivityArchive, query *models.ArchivedActivityQuery, result *models.ArchivedActivities) (bool, error) {
+	content, err := aas.storageService.DownloadFile(ctx, archive.StorageProvider, archive.StorageKey)
+	if err != nil {
+		return false, err
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return false, err
+	}
+	defer gz.Close()
+
+	scanner := bufio.NewScanner(gz)
+	scanner.Buffer(make([]byte, 64*1024), archivedQueryMaxLine)
+	for scanner.Scan() {
+		var activity bson.M
+		if err := bson.UnmarshalExtJSON(scanner.Bytes(), false, &activity); err != nil {
+			return false, err
+		}
+
+		createdAt, _ := activity["created_at"].(primitive.DateTime)
+		if createdAt.Time().Before(query.From) {
+			continue
+		}
+		if createdAt.Time().After(query.To) {
+			// Archives are sorted by created_at
+			return false, nil
+		}
+		if query.UserID != nil && activity["user_id"] != *query.UserID {
+			continue
+		}
+		if query.Action != "" && activity["action"] != query.Action {
+			continue
+		}
+
+		if len(result.Activities) == query.Limit {
+			result.Truncated = true
+			return true, nil
+		}
+		result.Activities = append(result.Activities, activity)
+	}
+	return false, scanner.Err()
+}
@@ -17,17 +17,24 @@ import (
 
 type AdminService struct {
 	*BaseService
+	storageService *StorageService
 }
 
 func NewAdminService() *AdminService {
+	return NewAdminServiceWith(Dependencies{})
+}
+
+// NewAdminServiceWith creates an admin service on the given dependencies
+func NewAdminServiceWith(deps Dependencies) *AdminService {
 	return &AdminService{
-		BaseService: NewBaseService(),
+		BaseService:    NewBaseServiceWith(deps),
+		storageService: NewStorageServiceWith(deps),
 	}
 }
 
 // Admin Service - Login Function
-func (as *AdminService) Login(email, password string) (*models.Admin, string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (as *AdminService) Login(ctx context.Context, email, password string) (*models.Admin, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	var admin models.Admin
@@ -57,8 +64,8 @@ func (as *AdminService) Login(email, password string) (*models.Admin, string, er
 	_, err = as.collections.Admins().UpdateOne(ctx,
 		bson.M{"_id": admin.ID},
 		bson.M{"$set": bson.M{
-			"last_login_at": time.Now(),
-			"updated_at":    time.Now(),
+			"last_login_at": as.clock.Now(),
+			"updated_at":    as.clock.Now(),
 		}},
 	)
 	if err != nil {
@@ -69,8 +76,8 @@ func (as *AdminService) Login(email, password string) (*models.Admin, string, er
 }
 
 // Admin Management
-func (as *AdminService) GetAllAdmins(page, limit int) ([]models.Admin, int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (as *AdminService) GetAllAdmins(ctx context.Context, page, limit int) ([]models.Admin, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	skip := (page - 1) * limit
@@ -99,8 +106,8 @@ func (as *AdminService) GetAllAdmins(page, limit int) ([]models.Admin, int, erro
 	return admins, int(total), nil
 }
 
-func (as *AdminService) GetAdminByID(adminID primitive.ObjectID) (*models.Admin, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (as *AdminService) GetAdminByID(ctx context.Context, adminID primitive.ObjectID) (*models.Admin, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	var admin models.Admin
@@ -112,8 +119,8 @@ func (as *AdminService) GetAdminByID(adminID primitive.ObjectID) (*models.Admin,
 	return &admin, nil
 }
 
-func (as *AdminService) CreateAdmin(admin *models.Admin) (*models.Admin, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (as *AdminService) CreateAdmin(ctx context.Context, admin *models.Admin) (*models.Admin, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	// Check if admin email already exists
@@ -134,8 +141,8 @@ func (as *AdminService) CreateAdmin(admin *models.Admin) (*models.Admin, error)
 	admin.ID = primitive.NewObjectID()
 	admin.Password = hashedPassword
 	admin.IsActive = true
-	admin.CreatedAt = time.Now()
-	admin.UpdatedAt = time.Now()
+	admin.CreatedAt = as.clock.Now()
+	admin.UpdatedAt = as.clock.Now()
 
 	_, err = as.collections.Admins().InsertOne(ctx, admin)
 	if err != nil {
@@ -147,8 +154,8 @@ func (as *AdminService) CreateAdmin(admin *models.Admin) (*models.Admin, error)
 	return admin, nil
 }
 
-func (as *AdminService) UpdateAdmin(adminID primitive.ObjectID, updates map[string]interface{}) (*models.Admin, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (as *AdminService) UpdateAdmin(ctx context.Context, adminID primitive.ObjectID, updates map[string]interface{}) (*models.Admin, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	// Handle password update
@@ -160,7 +167,7 @@ func (as *AdminService) UpdateAdmin(adminID primitive.ObjectID, updates map[stri
 		updates["password"] = hashedPassword
 	}
 
-	updates["updated_at"] = time.Now()
+	updates["updated_at"] = as.clock.Now()
 
 	_, err := as.collections.Admins().UpdateOne(ctx,
 		bson.M{"_id": adminID},
@@ -170,11 +177,11 @@ func (as *AdminService) UpdateAdmin(adminID primitive.ObjectID, updates map[stri
 		return nil, fmt.Errorf("failed to update admin: %v", err)
 	}
 
-	return as.GetAdminByID(adminID)
+	return as.GetAdminByID(ctx, adminID)
 }
 
-func (as *AdminService) DeleteAdmin(adminID primitive.ObjectID) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (as *AdminService) DeleteAdmin(ctx context.Context, adminID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	_, err := as.collections.Admins().DeleteOne(ctx, bson.M{"_id": adminID})
@@ -185,31 +192,31 @@ func (as *AdminService) DeleteAdmin(adminID primitive.ObjectID) error {
 	return nil
 }
 
-func (as *AdminService) ActivateAdmin(adminID primitive.ObjectID) error {
-	return as.UpdateAdminStatus(adminID, true)
+func (as *AdminService) ActivateAdmin(ctx context.Context, adminID primitive.ObjectID) error {
+	return as.UpdateAdminStatus(ctx, adminID, true)
 }
 
-func (as *AdminService) DeactivateAdmin(adminID primitive.ObjectID) error {
-	return as.UpdateAdminStatus(adminID, false)
+func (as *AdminService) DeactivateAdmin(ctx context.Context, adminID primitive.ObjectID) error {
+	return as.UpdateAdminStatus(ctx, adminID, false)
 }
 
-func (as *AdminService) UpdateAdminStatus(adminID primitive.ObjectID, isActive bool) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (as *AdminService) UpdateAdminStatus(ctx context.Context, adminID primitive.ObjectID, isActive bool) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	_, err := as.collections.Admins().UpdateOne(ctx,
 		bson.M{"_id": adminID},
 		bson.M{"$set": bson.M{
 			"is_active":  isActive,
-			"updated_at": time.Now(),
+			"updated_at": as.clock.Now(),
 		}},
 	)
 	return err
 }
 
 // Dashboard and System Management
-func (as *AdminService) GetDashboardStats() (map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+func (as *AdminService) GetDashboardStats(ctx context.Context) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
 	defer cancel()
 
 	stats := make(map[string]interface{})
@@ -222,7 +229,7 @@ func (as *AdminService) GetDashboardStats() (map[string]interface{}, error) {
 	stats["total_users"] = userCount
 
 	// Active users (logged in last 30 days)
-	thirtyDaysAgo := time.Now().AddDate(0, 0, -30)
+	thirtyDaysAgo := as.clock.Now().AddDate(0, 0, -30)
 	activeUserCount, err := as.collections.Users().CountDocuments(ctx, bson.M{
 		"last_login_at": bson.M{"$gte": thirtyDaysAgo},
 	})
@@ -254,7 +261,7 @@ func (as *AdminService) GetDashboardStats() (map[string]interface{}, error) {
 		},
 	}
 
-	cursor, err := as.collections.Files().Aggregate(ctx, pipeline)
+	cursor, err := reportAggregate(ctx, as.collections.Files(), pipeline)
 	if err != nil {
 		return nil, err
 	}
@@ -281,7 +288,7 @@ func (as *AdminService) GetDashboardStats() (map[string]interface{}, error) {
 	stats["total_plans"] = planCount
 
 	// Recent registrations (last 7 days)
-	sevenDaysAgo := time.Now().AddDate(0, 0, -7)
+	sevenDaysAgo := as.clock.Now().AddDate(0, 0, -7)
 	recentUsers, err := as.collections.Users().CountDocuments(ctx, bson.M{
 		"created_at": bson.M{"$gte": sevenDaysAgo},
 	})
@@ -293,8 +300,8 @@ func (as *AdminService) GetDashboardStats() (map[string]interface{}, error) {
 	return stats, nil
 }
 
-func (as *AdminService) GetSystemInfo() (map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (as *AdminService) GetSystemInfo(ctx context.Context) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	info := make(map[string]interface{})
@@ -313,12 +320,12 @@ func (as *AdminService) GetSystemInfo() (map[string]interface{}, error) {
 		info["server"] = serverInfo
 	}
 
-	info["timestamp"] = time.Now()
+	info["timestamp"] = as.clock.Now()
 	return info, nil
 }
 
-func (as *AdminService) GetSystemHealth() (map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (as *AdminService) GetSystemHealth(ctx context.Context) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	health := make(map[string]interface{})
@@ -336,6 +343,25 @@ func (as *AdminService) GetSystemHealth() (map[string]interface{}, error) {
 		}
 	}
 
+	// Storage provider breakers, and the reads sent to replicas over the
+	// last day
+	storageHealth := map[string]interface{}{
+		"status":   "healthy",
+		"breakers": GetProviderBreakerStates(),
+	}
+	for _, breaker := range storageHealth["breakers"].([]*models.ProviderBreakerState) {
+		if breaker.State == BreakerOpen {
+			storageHealth["status"] = "degraded"
+		}
+	}
+	failovers, failoverErr := as.storageService.GetStorageFailoverSummary(ctx, as.clock.Now().Add(-24*time.Hour))
+	if failoverErr != nil {
+		storageHealth["failovers_error"] = failoverErr.Error()
+	} else {
+		storageHealth["failovers_24h"] = failovers
+	}
+	health["storage"] = storageHealth
+
 	// Overall status
 	overallHealthy := err == nil
 	status := "unhealthy"
@@ -344,7 +370,7 @@ func (as *AdminService) GetSystemHealth() (map[string]interface{}, error) {
 	}
 	health["overall"] = map[string]interface{}{
 		"status":    status,
-		"timestamp": time.Now(),
+		"timestamp": as.clock.Now(),
 	}
 
 	return health, nil
@@ -357,20 +383,20 @@ func (as *AdminService) ClearCache() error {
 	return nil
 }
 
-func (as *AdminService) ClearLogs() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+func (as *AdminService) ClearLogs(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
 	// Keep logs from last 30 days only
-	thirtyDaysAgo := time.Now().AddDate(0, 0, -30)
+	thirtyDaysAgo := as.clock.Now().AddDate(0, 0, -30)
 	_, err := as.collections.Logs().DeleteMany(ctx, bson.M{
 		"created_at": bson.M{"$lt": thirtyDaysAgo},
 	})
 	return err
 }
 
-func (as *AdminService) GetLogs(page, limit int, level string) ([]map[string]interface{}, int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (as *AdminService) GetLogs(ctx context.Context, page, limit int, level string) ([]map[string]interface{}, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	filter := bson.M{}
@@ -404,15 +430,15 @@ func (as *AdminService) GetLogs(page, limit int, level string) ([]map[string]int
 	return logs, int(total), nil
 }
 
-func (as *AdminService) CreateSystemBackup() (map[string]interface{}, error) {
-	_, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+func (as *AdminService) CreateSystemBackup(ctx context.Context) (map[string]interface{}, error) {
+	_, cancel := context.WithTimeout(ctx, 60*time.Second)
 	defer cancel()
 
 	backupID := primitive.NewObjectID()
 	backup := map[string]interface{}{
 		"_id":        backupID,
 		"status":     "initiated",
-		"created_at": time.Now(),
+		"created_at": as.clock.Now(),
 		"size":       0,
 		"collections": []string{
 			"users", "files", "plans", "admins", "settings",
@@ -433,7 +459,7 @@ func (as *AdminService) GetSystemBackups() ([]map[string]interface{}, error) {
 	backups := []map[string]interface{}{
 		{
 			"id":         primitive.NewObjectID(),
-			"created_at": time.Now().AddDate(0, 0, -1),
+			"created_at": as.clock.Now().AddDate(0, 0, -1),
 			"size":       1024 * 1024,
 			"status":     "completed",
 		},
@@ -443,8 +469,8 @@ func (as *AdminService) GetSystemBackups() ([]map[string]interface{}, error) {
 }
 
 // Authentication
-func (as *AdminService) AuthenticateAdmin(email, password string) (*models.Admin, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (as *AdminService) AuthenticateAdmin(ctx context.Context, email, password string) (*models.Admin, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	var admin models.Admin
@@ -463,7 +489,7 @@ func (as *AdminService) AuthenticateAdmin(email, password string) (*models.Admin
 	// Update last login
 	as.collections.Admins().UpdateOne(ctx,
 		bson.M{"_id": admin.ID},
-		bson.M{"$set": bson.M{"last_login_at": time.Now()}},
+		bson.M{"$set": bson.M{"last_login_at": as.clock.Now()}},
 	)
 
 	// Clear password from response
@@ -471,13 +497,13 @@ func (as *AdminService) AuthenticateAdmin(email, password string) (*models.Admin
 	return &admin, nil
 }
 
-func (as *AdminService) UpdateLastLogin(adminID primitive.ObjectID) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (as *AdminService) UpdateLastLogin(ctx context.Context, adminID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	_, err := as.collections.Admins().UpdateOne(ctx,
 		bson.M{"_id": adminID},
-		bson.M{"$set": bson.M{"last_login_at": time.Now()}},
+		bson.M{"$set": bson.M{"last_login_at": as.clock.Now()}},
 	)
 	return err
 }
@@ -5,7 +5,7 @@ import (
 	"encoding/csv"
 	"fmt"
 	"math"
-	"oncloud/database"
+	"oncloud/models"
 	"oncloud/utils"
 	"os"
 	"path/filepath"
@@ -23,14 +23,20 @@ type AnalyticsService struct {
 }
 
 func NewAnalyticsService() *AnalyticsService {
+	return NewAnalyticsServiceWith(Dependencies{})
+}
+
+// NewAnalyticsServiceWith creates an analytics service on the given
+// dependencies
+func NewAnalyticsServiceWith(deps Dependencies) *AnalyticsService {
 	return &AnalyticsService{
-		BaseService: NewBaseService(),
+		BaseService: NewBaseServiceWith(deps),
 	}
 }
 
 // Dashboard Analytics
-func (as *AnalyticsService) GetDashboard() (map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+func (as *AnalyticsService) GetDashboard(ctx context.Context) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
 	dashboard := make(map[string]interface{})
@@ -85,8 +91,8 @@ func (as *AnalyticsService) GetDashboard() (map[string]interface{}, error) {
 }
 
 // User Analytics
-func (as *AnalyticsService) GetUserAnalytics(period, groupBy string) (map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+func (as *AnalyticsService) GetUserAnalytics(ctx context.Context, period, groupBy string) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
 	defer cancel()
 
 	days, _ := strconv.Atoi(period)
@@ -121,12 +127,21 @@ func (as *AnalyticsService) GetUserAnalytics(period, groupBy string) (map[string
 	retentionData := as.getUserRetention(ctx, startDate)
 	analytics["retention"] = retentionData
 
+	// Signup cohort retention (D1/D7/D30), cohorts bucketed like the trend
+	cohortGranularity := "week"
+	if groupBy == "month" {
+		cohortGranularity = "month"
+	}
+	if cohorts, err := as.GetCohortRetention(ctx, cohortGranularity, 0, false); err == nil {
+		analytics["cohorts"] = cohorts
+	}
+
 	return analytics, nil
 }
 
 // File Analytics
-func (as *AnalyticsService) GetFileAnalytics(period, groupBy string) (map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+func (as *AnalyticsService) GetFileAnalytics(ctx context.Context, period, groupBy string) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 20*time.Second)
 	defer cancel()
 
 	days, _ := strconv.Atoi(period)
@@ -153,6 +168,9 @@ func (as *AnalyticsService) GetFileAnalytics(period, groupBy string) (map[string
 	downloadMetrics := as.getDownloadMetrics(ctx, startDate)
 	analytics["downloads"] = downloadMetrics
 
+	// Where downloads come from
+	analytics["download_geography"] = as.getDownloadGeography(ctx, startDate)
+
 	// Storage usage by user
 	storageByUser := as.getStorageByUser(ctx, 10) // Top 10 users
 	analytics["storage_by_user"] = storageByUser
@@ -169,8 +187,8 @@ func (as *AnalyticsService) GetFileAnalytics(period, groupBy string) (map[string
 }
 
 // Storage Analytics
-func (as *AnalyticsService) GetStorageAnalytics(period, groupBy, providerID string) (map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+func (as *AnalyticsService) GetStorageAnalytics(ctx context.Context, period, groupBy, providerID string) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 20*time.Second)
 	defer cancel()
 
 	days, _ := strconv.Atoi(period)
@@ -209,8 +227,8 @@ func (as *AnalyticsService) GetStorageAnalytics(period, groupBy, providerID stri
 }
 
 // Revenue Analytics
-func (as *AnalyticsService) GetRevenueAnalytics(period, groupBy, currency string) (map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+func (as *AnalyticsService) GetRevenueAnalytics(ctx context.Context, period, groupBy, currency string) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 20*time.Second)
 	defer cancel()
 
 	days, _ := strconv.Atoi(period)
@@ -233,6 +251,9 @@ func (as *AnalyticsService) GetRevenueAnalytics(period, groupBy, currency string
 	revenueByPlan := as.getRevenueByPlan(ctx, startDate, currency)
 	analytics["revenue_by_plan"] = revenueByPlan
 
+	// Net revenue, once refunds and lost chargebacks are taken off
+	analytics["net_revenue"] = as.getNetRevenue(ctx, startDate, currency)
+
 	// MRR (Monthly Recurring Revenue)
 	mrr := as.getMRR(ctx, currency)
 	analytics["mrr"] = mrr
@@ -261,8 +282,8 @@ func (as *AnalyticsService) GetRevenueAnalytics(period, groupBy, currency string
 }
 
 // Real-time Statistics
-func (as *AnalyticsService) GetRealTimeStats() (map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (as *AnalyticsService) GetRealTimeStats(ctx context.Context) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	stats := make(map[string]interface{})
@@ -304,8 +325,8 @@ func (as *AnalyticsService) GetRealTimeStats() (map[string]interface{}, error) {
 }
 
 // Top Files Analytics
-func (as *AnalyticsService) GetTopFiles(period string, limit int) ([]map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+func (as *AnalyticsService) GetTopFiles(ctx context.Context, period string, limit int) ([]map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
 	defer cancel()
 
 	days, _ := strconv.Atoi(period)
@@ -330,6 +351,13 @@ func (as *AnalyticsService) GetTopFiles(period string, limit int) ([]map[string]
 				"total_bytes":    bson.M{"$sum": "$bytes"},
 			},
 		},
+		// Rank before looking up files so only the top files are read
+		{
+			"$sort": bson.M{"download_count": -1},
+		},
+		{
+			"$limit": limit,
+		},
 		{
 			"$lookup": bson.M{
 				"from":         "files",
@@ -341,15 +369,9 @@ func (as *AnalyticsService) GetTopFiles(period string, limit int) ([]map[string]
 		{
 			"$unwind": "$file",
 		},
-		{
-			"$sort": bson.M{"download_count": -1},
-		},
-		{
-			"$limit": limit,
-		},
 	}
 
-	cursor, err := as.collections.Activities().Aggregate(ctx, pipeline)
+	cursor, err := reportAggregate(ctx, as.collections.Activities(), pipeline)
 	if err != nil {
 		return nil, err
 	}
@@ -364,44 +386,62 @@ func (as *AnalyticsService) GetTopFiles(period string, limit int) ([]map[string]
 }
 
 // Event Tracking
+
+// TrackEvent records an analytics event. Events are buffered and written in
+// batches by the process-wide EventWriter.
 func (as *AnalyticsService) TrackEvent(eventType, action string, userID *primitive.ObjectID, metadata map[string]interface{}) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	return as.TrackEventFrom(eventType, action, userID, metadata, nil)
+}
 
+// TrackEventFrom records an analytics event along with where the request came from
+func (as *AnalyticsService) TrackEventFrom(eventType, action string, userID *primitive.ObjectID, metadata map[string]interface{}, location *models.GeoLocation) error {
+	now := time.Now()
 	event := bson.M{
 		"_id":       primitive.NewObjectID(),
 		"type":      eventType,
 		"action":    action,
 		"user_id":   userID,
 		"metadata":  metadata,
-		"timestamp": time.Now(),
+		"timestamp": now,
+	}
+	if expiresAt := analyticsExpiry(eventType, now); expiresAt != nil {
+		event["expires_at"] = expiresAt
 	}
 
-	_, err := as.collections.Analytics().InsertOne(ctx, event)
-	if err != nil {
+	if location != nil {
+		if location.IP != "" {
+			event["ip_address"] = location.IP
+		}
+		if location.Country != "" {
+			event["country"] = location.Country
+			event["region"] = location.Region
+		}
+	}
+
+	if err := GetEventWriter().Enqueue(event); err != nil {
 		return fmt.Errorf("failed to track event: %v", err)
 	}
 
 	return nil
 }
 
-func (as *AnalyticsService) TrackUserActivity(userID primitive.ObjectID, action, resource string, metadata map[string]interface{}) error {
+func (as *AnalyticsService) TrackUserActivity(userID primitive.ObjectID, action, resource string, metadata map[string]interface{}, location *models.GeoLocation) error {
 	if metadata == nil {
 		metadata = make(map[string]interface{})
 	}
 	metadata["resource"] = resource
 
-	return as.TrackEvent("user_activity", action, &userID, metadata)
+	return as.TrackEventFrom("user_activity", action, &userID, metadata, location)
 }
 
-func (as *AnalyticsService) TrackFileActivity(userID, fileID primitive.ObjectID, action string, bytes int64) error {
+func (as *AnalyticsService) TrackFileActivity(userID, fileID primitive.ObjectID, action string, bytes int64, location *models.GeoLocation) error {
 	metadata := map[string]interface{}{
 		"file_id":  fileID,
 		"bytes":    bytes,
 		"resource": "file",
 	}
 
-	return as.TrackEvent("file_activity", action, &userID, metadata)
+	return as.TrackEventFrom("file_activity", action, &userID, metadata, location)
 }
 
 // Helper functions
@@ -418,7 +458,7 @@ func (as *AnalyticsService) getTotalRevenue(ctx context.Context) float64 {
 		},
 	}
 
-	cursor, err := as.collections.Payments().Aggregate(ctx, pipeline)
+	cursor, err := reportAggregate(ctx, as.collections.Payments(), pipeline)
 	if err != nil {
 		return 0
 	}
@@ -499,8 +539,8 @@ func (as *AnalyticsService) getGrowthMetrics(ctx context.Context, startDate time
 }
 
 // Analytics Service - ExportAnalytics Function
-func (as *AnalyticsService) ExportAnalytics(dataType, period, format, email, groupBy string) (map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+func (as *AnalyticsService) ExportAnalytics(ctx context.Context, dataType, period, format, email, groupBy string) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
 	defer cancel()
 
 	// Validate parameters
@@ -621,9 +661,11 @@ func (as *AnalyticsService) ExportAnalytics(dataType, period, format, email, gro
 	return result, nil
 }
 
-// Analytics Service - GetTopUsers Function
-func (as *AnalyticsService) GetTopUsers(limit int, sortBy string, period string) ([]map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+// Analytics Service - GetTopUsers Function. Users are ranked by storage
+// and files on the counters kept on them, and by downloads from the
+// period's download activity, so only the top users are ever looked up.
+func (as *AnalyticsService) GetTopUsers(ctx context.Context, limit int, sortBy string, period string) ([]map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
 	defer cancel()
 
 	days, _ := strconv.Atoi(period)
@@ -633,167 +675,57 @@ func (as *AnalyticsService) GetTopUsers(limit int, sortBy string, period string)
 
 	startDate := time.Now().AddDate(0, 0, -days)
 
+	collection := as.collections.Users()
 	var pipeline []bson.M
 
 	switch sortBy {
-	case "storage_used":
-		pipeline = []bson.M{
-			{
-				"$lookup": bson.M{
-					"from":         "files",
-					"localField":   "_id",
-					"foreignField": "user_id",
-					"as":           "files",
-				},
-			},
-			{
-				"$addFields": bson.M{
-					"total_storage": bson.M{
-						"$sum": bson.M{
-							"$map": bson.M{
-								"input": "$files",
-								"as":    "file",
-								"in": bson.M{
-									"$cond": bson.M{
-										"if":   bson.M{"$eq": []interface{}{"$$file.is_deleted", false}},
-										"then": "$$file.size",
-										"else": 0,
-									},
-								},
-							},
-						},
-					},
-					"file_count": bson.M{
-						"$size": bson.M{
-							"$filter": bson.M{
-								"input": "$files",
-								"cond":  bson.M{"$eq": []interface{}{"$$this.is_deleted", false}},
-							},
-						},
-					},
-				},
-			},
-			{
-				"$sort": bson.M{"total_storage": -1},
-			},
-		}
 	case "files_count":
 		pipeline = []bson.M{
-			{
-				"$lookup": bson.M{
-					"from":         "files",
-					"localField":   "_id",
-					"foreignField": "user_id",
-					"as":           "files",
-				},
-			},
-			{
-				"$addFields": bson.M{
-					"file_count": bson.M{
-						"$size": bson.M{
-							"$filter": bson.M{
-								"input": "$files",
-								"cond":  bson.M{"$eq": []interface{}{"$$this.is_deleted", false}},
-							},
-						},
-					},
-					"total_storage": bson.M{
-						"$sum": bson.M{
-							"$map": bson.M{
-								"input": "$files",
-								"as":    "file",
-								"in": bson.M{
-									"$cond": bson.M{
-										"if":   bson.M{"$eq": []interface{}{"$$file.is_deleted", false}},
-										"then": "$$file.size",
-										"else": 0,
-									},
-								},
-							},
-						},
-					},
-				},
-			},
-			{
-				"$sort": bson.M{"file_count": -1},
-			},
+			{"$sort": bson.M{"files_count": -1}},
+			{"$limit": int64(limit)},
 		}
 	case "downloads":
+		collection = as.collections.Activities()
 		pipeline = []bson.M{
 			{
-				"$lookup": bson.M{
-					"from": "activities",
-					"let":  bson.M{"userId": "$_id"},
-					"pipeline": []bson.M{
-						{
-							"$match": bson.M{
-								"$expr":      bson.M{"$eq": []interface{}{"$user_id", "$$userId"}},
-								"action":     "download",
-								"created_at": bson.M{"$gte": startDate},
-							},
-						},
-						{
-							"$group": bson.M{
-								"_id":   nil,
-								"count": bson.M{"$sum": 1},
-							},
-						},
-					},
-					"as": "download_stats",
+				"$match": bson.M{
+					"action":     "download",
+					"created_at": bson.M{"$gte": startDate},
+					"user_id":    bson.M{"$ne": nil},
 				},
 			},
 			{
-				"$addFields": bson.M{
-					"download_count": bson.M{
-						"$ifNull": []interface{}{
-							bson.M{"$arrayElemAt": []interface{}{"$download_stats.count", 0}},
-							0,
-						},
-					},
+				"$group": bson.M{
+					"_id":            "$user_id",
+					"download_count": bson.M{"$sum": 1},
 				},
 			},
+			{"$sort": bson.M{"download_count": -1}},
+			{"$limit": int64(limit)},
 			{
 				"$lookup": bson.M{
-					"from":         "files",
+					"from":         "users",
 					"localField":   "_id",
-					"foreignField": "user_id",
-					"as":           "files",
+					"foreignField": "_id",
+					"as":           "user",
 				},
 			},
+			{"$unwind": "$user"},
 			{
-				"$addFields": bson.M{
-					"file_count": bson.M{
-						"$size": bson.M{
-							"$filter": bson.M{
-								"input": "$files",
-								"cond":  bson.M{"$eq": []interface{}{"$$this.is_deleted", false}},
-							},
-						},
-					},
-					"total_storage": bson.M{
-						"$sum": bson.M{
-							"$map": bson.M{
-								"input": "$files",
-								"as":    "file",
-								"in": bson.M{
-									"$cond": bson.M{
-										"if":   bson.M{"$eq": []interface{}{"$$file.is_deleted", false}},
-										"then": "$$file.size",
-										"else": 0,
-									},
-								},
-							},
-						},
-					},
+				"$replaceRoot": bson.M{
+					"newRoot": bson.M{"$mergeObjects": []interface{}{
+						"$user",
+						bson.M{"download_count": "$download_count"},
+					}},
 				},
 			},
-			{
-				"$sort": bson.M{"download_count": -1},
-			},
 		}
 	default:
 		// Default to storage_used
-		return as.GetTopUsers(limit, "storage_used", period)
+		pipeline = []bson.M{
+			{"$sort": bson.M{"storage_used": -1}},
+			{"$limit": int64(limit)},
+		}
 	}
 
 	// Add common pipeline stages
@@ -821,19 +753,15 @@ func (as *AnalyticsService) GetTopUsers(limit int, sortBy string, period string)
 				"created_at":     1,
 				"last_login_at":  1,
 				"is_verified":    1,
-				"file_count":     bson.M{"$ifNull": []interface{}{"$file_count", 0}},
-				"total_storage":  bson.M{"$ifNull": []interface{}{"$total_storage", 0}},
+				"file_count":     bson.M{"$ifNull": []interface{}{"$files_count", 0}},
+				"total_storage":  bson.M{"$ifNull": []interface{}{"$storage_used", 0}},
 				"download_count": bson.M{"$ifNull": []interface{}{"$download_count", 0}},
 				"plan_name":      "$plan.name",
-				"files":          0, // Exclude files array to reduce response size
 			},
 		},
-		{
-			"$limit": int64(limit),
-		},
 	}...)
 
-	cursor, err := as.collections.Users().Aggregate(ctx, pipeline)
+	cursor, err := reportAggregate(ctx, collection, pipeline)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get top users: %v", err)
 	}
@@ -855,8 +783,8 @@ func (as *AnalyticsService) GetTopUsers(limit int, sortBy string, period string)
 }
 
 // Analytics Service - GetSystemMetrics Function
-func (as *AnalyticsService) GetSystemMetrics(period string) (map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+func (as *AnalyticsService) GetSystemMetrics(ctx context.Context, period string) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
 	defer cancel()
 
 	hours, _ := strconv.Atoi(period)
@@ -895,6 +823,12 @@ func (as *AnalyticsService) GetSystemMetrics(period string) (map[string]interfac
 		metrics["errors"] = errorMetrics
 	}
 
+	// Open usage and error anomalies
+	anomalies, err := NewAnomalyService().GetActiveAnomalies(ctx, 50)
+	if err == nil {
+		metrics["anomalies"] = anomalies
+	}
+
 	// Active connections and sessions
 	connectionMetrics, err := as.getConnectionMetrics(ctx)
 	if err == nil {
@@ -941,7 +875,7 @@ func (as *AnalyticsService) getStorageStats(ctx context.Context) map[string]inte
 		},
 	}
 
-	cursor, err := as.collections.Files().Aggregate(ctx, pipeline)
+	cursor, err := reportAggregate(ctx, as.collections.Files(), pipeline)
 	if err != nil {
 		return map[string]interface{}{}
 	}
@@ -978,7 +912,7 @@ func (as *AnalyticsService) getTopFiles(ctx context.Context, limit int) []map[st
 		},
 	}
 
-	cursor, err := as.collections.Files().Aggregate(ctx, pipeline)
+	cursor, err := reportAggregate(ctx, as.collections.Files(), pipeline)
 	if err != nil {
 		return []map[string]interface{}{}
 	}
@@ -1015,7 +949,7 @@ func (as *AnalyticsService) getRevenueTrend(ctx context.Context, days int) []map
 		},
 	}
 
-	cursor, err := as.collections.Payments().Aggregate(ctx, pipeline)
+	cursor, err := reportAggregate(ctx, as.collections.Payments(), pipeline)
 	if err != nil {
 		return []map[string]interface{}{}
 	}
@@ -1078,7 +1012,7 @@ func (as *AnalyticsService) getUserRegistrationTrend(ctx context.Context, startD
 		},
 	}
 
-	cursor, err := as.collections.Users().Aggregate(ctx, pipeline)
+	cursor, err := reportAggregate(ctx, as.collections.Users(), pipeline)
 	if err != nil {
 		return []map[string]interface{}{}
 	}
@@ -1115,7 +1049,7 @@ func (as *AnalyticsService) getUserActivityMetrics(ctx context.Context, startDat
 		},
 	}
 
-	cursor, _ := as.collections.Sessions().Aggregate(ctx, pipeline)
+	cursor, _ := reportAggregate(ctx, as.collections.Sessions(), pipeline)
 	defer cursor.Close(ctx)
 
 	var result []bson.M
@@ -1157,7 +1091,7 @@ func (as *AnalyticsService) getUserSegmentation(ctx context.Context) map[string]
 		},
 	}
 
-	cursor, err := as.collections.Users().Aggregate(ctx, pipeline)
+	cursor, err := reportAggregate(ctx, as.collections.Users(), pipeline)
 	if err != nil {
 		return map[string]interface{}{}
 	}
@@ -1196,7 +1130,7 @@ func (as *AnalyticsService) getPlanDistribution(ctx context.Context) []map[strin
 		},
 	}
 
-	cursor, err := as.collections.Users().Aggregate(ctx, pipeline)
+	cursor, err := reportAggregate(ctx, as.collections.Users(), pipeline)
 	if err != nil {
 		return []map[string]interface{}{}
 	}
@@ -1209,6 +1143,9 @@ func (as *AnalyticsService) getPlanDistribution(ctx context.Context) []map[strin
 
 func (as *AnalyticsService) getGeographicDistribution(ctx context.Context) []map[string]interface{} {
 	pipeline := []bson.M{
+		{
+			"$match": bson.M{"country": bson.M{"$nin": []interface{}{nil, ""}}},
+		},
 		{
 			"$group": bson.M{
 				"_id":   "$country",
@@ -1223,7 +1160,44 @@ func (as *AnalyticsService) getGeographicDistribution(ctx context.Context) []map
 		},
 	}
 
-	cursor, err := as.collections.Users().Aggregate(ctx, pipeline)
+	cursor, err := reportAggregate(ctx, as.collections.Users(), pipeline)
+	if err != nil {
+		return []map[string]interface{}{}
+	}
+	defer cursor.Close(ctx)
+
+	var distribution []map[string]interface{}
+	cursor.All(ctx, &distribution)
+	return distribution
+}
+
+// getDownloadGeography counts downloads by the country they were made from
+func (as *AnalyticsService) getDownloadGeography(ctx context.Context, startDate time.Time) []map[string]interface{} {
+	pipeline := []bson.M{
+		{
+			"$match": bson.M{
+				"type":      "file_activity",
+				"action":    "download",
+				"country":   bson.M{"$exists": true},
+				"timestamp": bson.M{"$gte": startDate},
+			},
+		},
+		{
+			"$group": bson.M{
+				"_id":   "$country",
+				"count": bson.M{"$sum": 1},
+				"bytes": bson.M{"$sum": "$metadata.bytes"},
+			},
+		},
+		{
+			"$sort": bson.M{"count": -1},
+		},
+		{
+			"$limit": 20,
+		},
+	}
+
+	cursor, err := reportAggregate(ctx, as.collections.Analytics(), pipeline)
 	if err != nil {
 		return []map[string]interface{}{}
 	}
@@ -1257,6 +1231,213 @@ func (as *AnalyticsService) getUserRetention(ctx context.Context, startDate time
 	}
 }
 
+// cohortRetentionDays are the Dn offsets reported in cohort analysis
+var cohortRetentionDays = []int{1, 7, 30}
+
+// cohortCacheTTL bounds how long a cached cohort matrix is served before it
+// is recomputed on read; the rollup job normally refreshes it sooner
+const cohortCacheTTL = 6 * time.Hour
+
+// GetCohortRetention returns the signup-cohort retention matrix for the last
+// `periods` weeks or months, served from the cohort cache when fresh
+func (as *AnalyticsService) GetCohortRetention(ctx context.Context, granularity string, periods int, refresh bool) (*models.CohortAnalysis, error) {
+	granularity, periods, err := normalizeCohortParams(granularity, periods)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+
+	key := fmt.Sprintf("%s_%d", granularity, periods)
+	if !refresh {
+		var cached models.CohortAnalysis
+		err := as.collections.CohortRetention().FindOne(ctx, bson.M{"key": key}).Decode(&cached)
+		if err == nil && time.Since(cached.ComputedAt) < cohortCacheTTL {
+			cached.Source = "cache"
+			return &cached, nil
+		}
+	}
+
+	analysis, err := as.computeCohortRetention(ctx, granularity, periods)
+	if err != nil {
+		return nil, err
+	}
+	analysis.Key = key
+
+	_, err = as.collections.CohortRetention().ReplaceOne(ctx,
+		bson.M{"key": key},
+		analysis,
+		options.Replace().SetUpsert(true),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to cache cohort retention: %v", err)
+	}
+
+	analysis.Source = "live"
+	return analysis, nil
+}
+
+func normalizeCohortParams(granularity string, periods int) (string, int, error) {
+	switch granularity {
+	case "", "week", "weekly":
+		granularity = "week"
+	case "month", "monthly":
+		granularity = "month"
+	default:
+		return "", 0, fmt.Errorf("unsupported cohort granularity: %s", granularity)
+	}
+
+	if periods <= 0 {
+		periods = 12
+	}
+	if periods > 52 {
+		periods = 52
+	}
+
+	return granularity, periods, nil
+}
+
+// computeCohortRetention groups users by signup week/month and, for each Dn,
+// counts users with at least one analytics event exactly n days after signup
+func (as *AnalyticsService) computeCohortRetention(ctx context.Context, granularity string, periods int) (*models.CohortAnalysis, error) {
+	started := time.Now()
+	now := started.UTC()
+
+	var startDate time.Time
+	if granularity == "month" {
+		startDate = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -(periods - 1), 0)
+	} else {
+		weekday := (int(now.Weekday()) + 6) % 7 // days since Monday
+		startDate = time.Date(now.Year(), now.Month(), now.Day()-weekday, 0, 0, 0, 0, time.UTC).AddDate(0, 0, -7*(periods-1))
+	}
+
+	const dayMs = int64(24 * time.Hour / time.Millisecond)
+	maxDay := cohortRetentionDays[len(cohortRetentionDays)-1]
+
+	group := bson.M{
+		"_id":   "$cohort",
+		"users": bson.M{"$sum": 1},
+	}
+	for _, day := range cohortRetentionDays {
+		group[fmt.Sprintf("retained_%d", day)] = bson.M{"$sum": bson.M{
+			"$cond": []interface{}{bson.M{"$in": []interface{}{day, "$active_days"}}, 1, 0},
+		}}
+		group[fmt.Sprintf("eligible_%d", day)] = bson.M{"$sum": bson.M{
+			"$cond": []interface{}{
+				bson.M{"$lte": []interface{}{bson.M{"$add": []interface{}{"$created_at", int64(day+1) * dayMs}}, now}},
+				1, 0,
+			},
+		}}
+	}
+
+	pipeline := []bson.M{
+		{"$match": bson.M{"created_at": bson.M{"$gte": startDate}}},
+		{"$project": bson.M{
+			"created_at": 1,
+			"cohort": bson.M{"$dateTrunc": bson.M{
+				"date":        "$created_at",
+				"unit":        granularity,
+				"startOfWeek": "monday",
+			}},
+		}},
+		{"$lookup": bson.M{
+			"from":         "analytics",
+			"localField":   "_id",
+			"foreignField": "user_id",
+			"let":          bson.M{"signup": "$created_at"},
+			"pipeline": []bson.M{
+				{"$match": bson.M{"$expr": bson.M{"$and": []interface{}{
+					bson.M{"$gte": []interface{}{"$timestamp", bson.M{"$add": []interface{}{"$$signup", dayMs}}}},
+					bson.M{"$lt": []interface{}{"$timestamp", bson.M{"$add": []interface{}{"$$signup", int64(maxDay+1) * dayMs}}}},
+				}}}},
+				{"$group": bson.M{"_id": bson.M{"$floor": bson.M{
+					"$divide": []interface{}{bson.M{"$subtract": []interface{}{"$timestamp", "$$signup"}}, dayMs},
+				}}}},
+			},
+			"as": "active",
+		}},
+		{"$project": bson.M{
+			"cohort":      1,
+			"created_at":  1,
+			"active_days": "$active._id",
+		}},
+		{"$group": group},
+		{"$sort": bson.M{"_id": 1}},
+	}
+
+	cursor, err := reportAggregate(ctx, as.collections.Users(), pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute cohort retention: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var rows []bson.M
+	if err = cursor.All(ctx, &rows); err != nil {
+		return nil, fmt.Errorf("failed to read cohort retention: %v", err)
+	}
+
+	overall := make([]models.RetentionPoint, len(cohortRetentionDays))
+	cohorts := make([]models.RetentionCohort, 0, len(rows))
+	for _, row := range rows {
+		cohort := models.RetentionCohort{Users: toInt64(row["users"])}
+		if start, ok := row["_id"].(primitive.DateTime); ok {
+			cohort.CohortStart = start.Time().UTC()
+		}
+
+		for i, day := range cohortRetentionDays {
+			point := models.RetentionPoint{
+				Day:      day,
+				Eligible: toInt64(row[fmt.Sprintf("eligible_%d", day)]),
+				Retained: toInt64(row[fmt.Sprintf("retained_%d", day)]),
+			}
+			point.Rate = retentionRate(point.Retained, point.Eligible)
+			cohort.Retention = append(cohort.Retention, point)
+
+			overall[i].Day = day
+			overall[i].Eligible += point.Eligible
+			overall[i].Retained += point.Retained
+		}
+		cohorts = append(cohorts, cohort)
+	}
+	for i := range overall {
+		overall[i].Day = cohortRetentionDays[i]
+		overall[i].Rate = retentionRate(overall[i].Retained, overall[i].Eligible)
+	}
+
+	return &models.CohortAnalysis{
+		Granularity:   granularity,
+		Periods:       periods,
+		Days:          cohortRetentionDays,
+		Cohorts:       cohorts,
+		Overall:       overall,
+		ComputedAt:    time.Now(),
+		ComputeTimeMs: time.Since(started).Milliseconds(),
+	}, nil
+}
+
+func retentionRate(retained, eligible int64) float64 {
+	if eligible == 0 {
+		return 0
+	}
+	return math.Round(float64(retained)/float64(eligible)*10000) / 100
+}
+
+// toInt64 converts a numeric aggregation result to int64
+func toInt64(value interface{}) int64 {
+	switch v := value.(type) {
+	case int32:
+		return int64(v)
+	case int64:
+		return v
+	case float64:
+		return int64(v)
+	case int:
+		return int64(v)
+	}
+	return 0
+}
+
 func (as *AnalyticsService) getFileUploadTrend(ctx context.Context, startDate time.Time, groupBy string) []map[string]interface{} {
 	// Similar to user registration trend but for files
 	var groupStage bson.M
@@ -1312,7 +1493,7 @@ func (as *AnalyticsService) getFileUploadTrend(ctx context.Context, startDate ti
 		},
 	}
 
-	cursor, err := as.collections.Files().Aggregate(ctx, pipeline)
+	cursor, err := reportAggregate(ctx, as.collections.Files(), pipeline)
 	if err != nil {
 		return []map[string]interface{}{}
 	}
@@ -1343,7 +1524,7 @@ func (as *AnalyticsService) getFileTypeDistribution(ctx context.Context, startDa
 		},
 	}
 
-	cursor, err := as.collections.Files().Aggregate(ctx, pipeline)
+	cursor, err := reportAggregate(ctx, as.collections.Files(), pipeline)
 	if err != nil {
 		return []map[string]interface{}{}
 	}
@@ -1382,7 +1563,7 @@ func (as *AnalyticsService) getFileSizeDistribution(ctx context.Context, startDa
 		},
 	}
 
-	cursor, err := as.collections.Files().Aggregate(ctx, pipeline)
+	cursor, err := reportAggregate(ctx, as.collections.Files(), pipeline)
 	if err != nil {
 		return map[string]interface{}{}
 	}
@@ -1421,7 +1602,7 @@ func (as *AnalyticsService) getDownloadMetrics(ctx context.Context, startDate ti
 		},
 	}
 
-	cursor, err := as.collections.Activities().Aggregate(ctx, pipeline)
+	cursor, err := reportAggregate(ctx, as.collections.Activities(), pipeline)
 	if err != nil {
 		return map[string]interface{}{}
 	}
@@ -1446,41 +1627,24 @@ func (as *AnalyticsService) getDownloadMetrics(ctx context.Context, startDate ti
 }
 
 func (as *AnalyticsService) getStorageByUser(ctx context.Context, limit int) []map[string]interface{} {
+	// Ranked on the counters kept on users rather than grouping every file
 	pipeline := []bson.M{
 		{
-			"$group": bson.M{
-				"_id":        "$user_id",
-				"file_count": bson.M{"$sum": 1},
-				"total_size": bson.M{"$sum": "$size"},
-			},
-		},
-		{
-			"$lookup": bson.M{
-				"from":         "users",
-				"localField":   "_id",
-				"foreignField": "_id",
-				"as":           "user",
-			},
-		},
-		{
-			"$unwind": "$user",
-		},
-		{
-			"$sort": bson.M{"total_size": -1},
+			"$sort": bson.M{"storage_used": -1},
 		},
 		{
 			"$limit": limit,
 		},
 		{
 			"$project": bson.M{
-				"user_email": "$user.email",
-				"file_count": 1,
-				"total_size": 1,
+				"user_email": "$email",
+				"file_count": "$files_count",
+				"total_size": "$storage_used",
 			},
 		},
 	}
 
-	cursor, err := as.collections.Files().Aggregate(ctx, pipeline)
+	cursor, err := reportAggregate(ctx, as.collections.Users(), pipeline)
 	if err != nil {
 		return []map[string]interface{}{}
 	}
@@ -1507,6 +1671,13 @@ func (as *AnalyticsService) getPopularFiles(ctx context.Context, startDate time.
 				"total_bytes":    bson.M{"$sum": "$bytes"},
 			},
 		},
+		// Rank before looking up files so only the top files are read
+		{
+			"$sort": bson.M{"download_count": -1},
+		},
+		{
+			"$limit": limit,
+		},
 		{
 			"$lookup": bson.M{
 				"from":         "files",
@@ -1518,12 +1689,6 @@ func (as *AnalyticsService) getPopularFiles(ctx context.Context, startDate time.
 		{
 			"$unwind": "$file",
 		},
-		{
-			"$sort": bson.M{"download_count": -1},
-		},
-		{
-			"$limit": limit,
-		},
 		{
 			"$project": bson.M{
 				"file_name":      "$file.name",
@@ -1534,7 +1699,7 @@ func (as *AnalyticsService) getPopularFiles(ctx context.Context, startDate time.
 		},
 	}
 
-	cursor, err := as.collections.Activities().Aggregate(ctx, pipeline)
+	cursor, err := reportAggregate(ctx, as.collections.Activities(), pipeline)
 	if err != nil {
 		return []map[string]interface{}{}
 	}
@@ -1603,7 +1768,7 @@ func (as *AnalyticsService) getFileLifecycleMetrics(ctx context.Context, startDa
 		},
 	}
 
-	cursor, err := as.collections.Files().Aggregate(ctx, pipeline)
+	cursor, err := reportAggregate(ctx, as.collections.Files(), pipeline)
 	if err != nil {
 		return map[string]interface{}{}
 	}
@@ -1658,7 +1823,7 @@ func (as *AnalyticsService) getProviderDistribution(ctx context.Context, provide
 		},
 	}
 
-	cursor, err := as.collections.Files().Aggregate(ctx, pipeline)
+	cursor, err := reportAggregate(ctx, as.collections.Files(), pipeline)
 	if err != nil {
 		return []map[string]interface{}{}
 	}
@@ -1724,7 +1889,7 @@ func (as *AnalyticsService) getBandwidthUsage(ctx context.Context, startDate tim
 		},
 	}
 
-	cursor, err := as.collections.Activities().Aggregate(ctx, pipeline)
+	cursor, err := reportAggregate(ctx, as.collections.Activities(), pipeline)
 	if err != nil {
 		return []map[string]interface{}{}
 	}
@@ -1755,7 +1920,7 @@ func (as *AnalyticsService) getStorageEfficiency(ctx context.Context) map[string
 		},
 	}
 
-	cursor, err := as.collections.Files().Aggregate(ctx, pipeline)
+	cursor, err := reportAggregate(ctx, as.collections.Files(), pipeline)
 	if err != nil {
 		return map[string]interface{}{}
 	}
@@ -1804,7 +1969,7 @@ func (as *AnalyticsService) getDuplicateFileCount(ctx context.Context) int64 {
 		},
 	}
 
-	cursor, err := as.collections.Files().Aggregate(ctx, pipeline)
+	cursor, err := reportAggregate(ctx, as.collections.Files(), pipeline)
 	if err != nil {
 		return 0
 	}
@@ -1822,9 +1987,62 @@ func (as *AnalyticsService) getDuplicateFileCount(ctx context.Context) int64 {
 	return 0
 }
 
+// storageClassAActions are billed as class A (write) operations
+var storageClassAActions = []string{
+	"upload", "upload_stream", "copy", "move",
+	"multipart_init", "multipart_upload_part", "multipart_complete",
+}
+
+// storageClassBActions are billed as class B (read) operations
+var storageClassBActions = []string{"download", "download_stream"}
+
 func (as *AnalyticsService) getStorageCostAnalysis(ctx context.Context, startDate time.Time) map[string]interface{} {
-	// Calculate estimated storage costs by provider
-	pipeline := []bson.M{
+	periodDays := time.Since(startDate).Hours() / 24
+	pricing := NewPricingService().loadPricingTable(ctx)
+
+	// Resolve each provider's type and region; files only record the provider
+	// type, so their storage is attributed to the type's primary region
+	providerRegions := make(map[primitive.ObjectID][2]string)
+	primaryRegion := make(map[string]string)
+	providerCursor, err := as.collections.StorageProviders().Find(ctx, bson.M{},
+		options.Find().SetSort(bson.D{{Key: "is_default", Value: -1}, {Key: "priority", Value: 1}}),
+	)
+	if err == nil {
+		var providers []models.StorageProvider
+		providerCursor.All(ctx, &providers)
+		providerCursor.Close(ctx)
+
+		for _, p := range providers {
+			providerRegions[p.ID] = [2]string{p.Type, p.Region}
+			if _, exists := primaryRegion[p.Type]; !exists {
+				primaryRegion[p.Type] = p.Region
+			}
+		}
+	}
+
+	type providerCost struct {
+		providerType string
+		region       string
+		sizeBytes    int64
+		fileCount    int64
+		egressBytes  int64
+		classAOps    int64
+		classBOps    int64
+	}
+	costs := make(map[string]*providerCost)
+	entry := func(providerType, region string) *providerCost {
+		key := providerType
+		if region != "" {
+			key = providerType + "/" + region
+		}
+		if costs[key] == nil {
+			costs[key] = &providerCost{providerType: providerType, region: region}
+		}
+		return costs[key]
+	}
+
+	// Stored bytes by provider
+	storagePipeline := []bson.M{
 		{
 			"$match": bson.M{
 				"created_at": bson.M{"$gte": startDate},
@@ -1840,45 +2058,121 @@ func (as *AnalyticsService) getStorageCostAnalysis(ctx context.Context, startDat
 		},
 	}
 
-	cursor, err := as.collections.Files().Aggregate(ctx, pipeline)
+	cursor, err := reportAggregate(ctx, as.collections.Files(), storagePipeline)
 	if err != nil {
 		return map[string]interface{}{}
 	}
-	defer cursor.Close(ctx)
-
 	var providerStats []bson.M
 	cursor.All(ctx, &providerStats)
+	cursor.Close(ctx)
 
-	// Estimate costs based on provider pricing (these would be configurable)
-	providerPricing := map[string]float64{
-		"s3":     0.023,  // per GB per month
-		"r2":     0.015,  // per GB per month
-		"wasabi": 0.0059, // per GB per month
+	for _, stat := range providerStats {
+		providerType, _ := stat["_id"].(string)
+		c := entry(providerType, primaryRegion[providerType])
+		c.sizeBytes += toInt64(stat["total_size"])
+		c.fileCount += toInt64(stat["file_count"])
 	}
 
-	totalCost := float64(0)
-	costByProvider := make(map[string]interface{})
-
-	for _, stat := range providerStats {
-		provider := stat["_id"].(string)
-		sizeGB := float64(stat["total_size"].(int64)) / (1024 * 1024 * 1024)
+	// Egress and operations by provider from storage activity logs
+	activityPipeline := []bson.M{
+		{
+			"$match": bson.M{
+				"created_at": bson.M{"$gte": startDate},
+				"action":     bson.M{"$in": append(append([]string{}, storageClassAActions...), storageClassBActions...)},
+			},
+		},
+		{
+			"$group": bson.M{
+				"_id": bson.M{
+					"provider_id": "$provider_id",
+					"provider":    "$provider",
+					"action":      "$action",
+				},
+				"bytes": bson.M{"$sum": "$size"},
+				"count": bson.M{"$sum": 1},
+			},
+		},
+	}
 
-		if pricing, exists := providerPricing[provider]; exists {
-			cost := sizeGB * pricing
-			totalCost += cost
+	activityCursor, err := reportAggregate(ctx, as.collections.StorageActivities(), activityPipeline)
+	if err == nil {
+		var activityStats []bson.M
+		activityCursor.All(ctx, &activityStats)
+		activityCursor.Close(ctx)
+
+		for _, stat := range activityStats {
+			id, _ := stat["_id"].(bson.M)
+			providerType, _ := id["provider"].(string)
+			region := primaryRegion[providerType]
+			if providerID, ok := id["provider_id"].(primitive.ObjectID); ok {
+				if resolved, exists := providerRegions[providerID]; exists {
+					providerType, region = resolved[0], resolved[1]
+				}
+			}
 
-			costByProvider[provider] = map[string]interface{}{
-				"size_gb":    sizeGB,
-				"cost_usd":   cost,
-				"file_count": stat["file_count"],
+			c := entry(providerType, region)
+			action, _ := id["action"].(string)
+			count := toInt64(stat["count"])
+			switch {
+			case utils.SliceContains(storageClassBActions, action):
+				c.classBOps += count
+				c.egressBytes += toInt64(stat["bytes"])
+			case utils.SliceContains(storageClassAActions, action):
+				c.classAOps += count
 			}
 		}
 	}
 
+	const gb = 1024 * 1024 * 1024
+	var storageCost, egressCost, operationsCost float64
+	costByProvider := make(map[string]interface{})
+	unpriced := []string{}
+
+	for key, c := range costs {
+		price := pricing.resolve(c.providerType, c.region)
+		if price == nil {
+			unpriced = append(unpriced, key)
+			continue
+		}
+
+		sizeGB := float64(c.sizeBytes) / gb
+		egressGB := float64(c.egressBytes) / gb
+		freeEgressGB := price.FreeEgressGB * periodDays / 30
+		billableEgressGB := math.Max(0, egressGB-freeEgressGB)
+
+		providerStorageCost := sizeGB * price.StoragePerGBMonth
+		providerEgressCost := billableEgressGB * price.EgressPerGB
+		providerOpsCost := float64(c.classAOps)/1000*price.ClassAPer1000 + float64(c.classBOps)/1000*price.ClassBPer1000
+
+		storageCost += providerStorageCost
+		egressCost += providerEgressCost
+		operationsCost += providerOpsCost
+
+		costByProvider[key] = map[string]interface{}{
+			"provider_type":       c.providerType,
+			"region":              c.region,
+			"pricing_id":          price.ID,
+			"size_gb":             sizeGB,
+			"file_count":          c.fileCount,
+			"storage_cost_usd":    providerStorageCost,
+			"egress_gb":           egressGB,
+			"billable_egress_gb":  billableEgressGB,
+			"egress_cost_usd":     providerEgressCost,
+			"class_a_operations":  c.classAOps,
+			"class_b_operations":  c.classBOps,
+			"operations_cost_usd": providerOpsCost,
+			"cost_usd":            providerStorageCost + providerEgressCost + providerOpsCost,
+		}
+	}
+
 	return map[string]interface{}{
-		"total_cost_usd": totalCost,
-		"by_provider":    costByProvider,
-		"period_days":    int(time.Since(startDate).Hours() / 24),
+		"total_cost_usd":      storageCost + egressCost + operationsCost,
+		"storage_cost_usd":    storageCost,
+		"egress_cost_usd":     egressCost,
+		"operations_cost_usd": operationsCost,
+		"by_provider":         costByProvider,
+		"unpriced_providers":  unpriced,
+		"period_days":         int(periodDays),
 	}
 }
 
@@ -1918,8 +2212,8 @@ func (as *AnalyticsService) getStoragePerformance(ctx context.Context, startDate
 		},
 	}
 
-	uploadCursor, _ := as.collections.Activities().Aggregate(ctx, uploadPipeline)
-	downloadCursor, _ := as.collections.Activities().Aggregate(ctx, downloadPipeline)
+	uploadCursor, _ := reportAggregate(ctx, as.collections.Activities(), uploadPipeline)
+	downloadCursor, _ := reportAggregate(ctx, as.collections.Activities(), downloadPipeline)
 
 	var uploadResult, downloadResult []bson.M
 	uploadCursor.All(ctx, &uploadResult)
@@ -2005,7 +2299,7 @@ func (as *AnalyticsService) getDetailedRevenueTrend(ctx context.Context, startDa
 		},
 	}
 
-	cursor, err := as.collections.Payments().Aggregate(ctx, pipeline)
+	cursor, err := reportAggregate(ctx, as.collections.Payments(), pipeline)
 	if err != nil {
 		return []map[string]interface{}{}
 	}
@@ -2016,6 +2310,25 @@ func (as *AnalyticsService) getDetailedRevenueTrend(ctx context.Context, startDa
 	return trend
 }
 
+// getNetRevenue takes the refunds made and chargebacks lost since
+// startDate off the payments completed since then
+func (as *AnalyticsService) getNetRevenue(ctx context.Context, startDate time.Time, currency string) *models.NetRevenue {
+	since := bson.M{"$gte": startDate}
+	gross := sumField(ctx, as.collections.Payments(), bson.M{"status": "completed", "currency": currency, "created_at": since}, "$amount")
+	refunds := sumField(ctx, as.collections.Refunds(), bson.M{"status": models.RefundSucceeded, "currency": currency, "updated_at": since}, "$amount")
+	chargebacks := sumField(ctx, as.collections.Disputes(), bson.M{"status": models.DisputeLost, "currency": currency, "closed_at": since}, "$amount")
+
+	return &models.NetRevenue{
+		Currency:    currency,
+		Gross:       gross.total,
+		Refunded:    refunds.total,
+		Refunds:     refunds.count,
+		ChargedBack: chargebacks.total,
+		Chargebacks: chargebacks.count,
+		Net:         roundAmount(gross.total - refunds.total - chargebacks.total),
+	}
+}
+
 func (as *AnalyticsService) getRevenueByPlan(ctx context.Context, startDate time.Time, currency string) []map[string]interface{} {
 	pipeline := []bson.M{
 		{
@@ -2060,7 +2373,7 @@ func (as *AnalyticsService) getRevenueByPlan(ctx context.Context, startDate time
 		},
 	}
 
-	cursor, err := as.collections.Payments().Aggregate(ctx, pipeline)
+	cursor, err := reportAggregate(ctx, as.collections.Payments(), pipeline)
 	if err != nil {
 		return []map[string]interface{}{}
 	}
@@ -2089,7 +2402,7 @@ func (as *AnalyticsService) getMRR(ctx context.Context, currency string) float64
 		},
 	}
 
-	cursor, err := database.GetCollection("subscriptions").Aggregate(ctx, pipeline)
+	cursor, err := reportAggregate(ctx, as.collections.Collection("subscriptions"), pipeline)
 	if err != nil {
 		return 0
 	}
@@ -2128,7 +2441,7 @@ func (as *AnalyticsService) getARR(ctx context.Context, currency string) float64
 		},
 	}
 
-	cursor, err := database.GetCollection("subscriptions").Aggregate(ctx, pipeline)
+	cursor, err := reportAggregate(ctx, as.collections.Collection("subscriptions"), pipeline)
 	if err != nil {
 		return mrr * 12
 	}
@@ -2167,12 +2480,12 @@ func (as *AnalyticsService) getCustomerLifetimeValue(ctx context.Context, curren
 
 func (as *AnalyticsService) getChurnAnalysis(ctx context.Context, startDate time.Time) map[string]interface{} {
 	// Calculate churn rate
-	activeStart, _ := database.GetCollection("subscriptions").CountDocuments(ctx, bson.M{
+	activeStart, _ := as.collections.Collection("subscriptions").CountDocuments(ctx, bson.M{
 		"status":     "active",
 		"created_at": bson.M{"$lt": startDate},
 	})
 
-	churned, _ := database.GetCollection("subscriptions").CountDocuments(ctx, bson.M{
+	churned, _ := as.collections.Collection("subscriptions").CountDocuments(ctx, bson.M{
 		"status":     bson.M{"$in": []string{"cancelled", "expired"}},
 		"updated_at": bson.M{"$gte": startDate},
 	})
@@ -2210,7 +2523,7 @@ func (as *AnalyticsService) getPaymentMethodDistribution(ctx context.Context, st
 		},
 	}
 
-	cursor, err := as.collections.Payments().Aggregate(ctx, pipeline)
+	cursor, err := reportAggregate(ctx, as.collections.Payments(), pipeline)
 	if err != nil {
 		return []map[string]interface{}{}
 	}
@@ -2331,17 +2644,22 @@ func (as *AnalyticsService) exportFileData(ctx context.Context, period, groupBy
 }
 
 func (as *AnalyticsService) exportStorageData(ctx context.Context, period, groupBy string) (interface{}, error) {
-	return as.GetStorageAnalytics(period, groupBy, "")
+	return as.GetStorageAnalytics(ctx, period, groupBy, "")
 }
 
 func (as *AnalyticsService) exportRevenueData(ctx context.Context, period, groupBy string) (interface{}, error) {
-	return as.GetRevenueAnalytics(period, groupBy, "USD")
+	return as.GetRevenueAnalytics(ctx, period, groupBy, "USD")
 }
 
 func (as *AnalyticsService) generateExportFile(data interface{}, format, dataType, period string) (string, error) {
 	// Generate filename
+	extension := format
+	switch format {
+	case "excel", "xlsx":
+		extension = "xlsx"
+	}
 	timestamp := time.Now().Format("20060102_150405")
-	fileName := fmt.Sprintf("%s_export_%s_%s.%s", dataType, period, timestamp, format)
+	fileName := fmt.Sprintf("%s_export_%s_%s.%s", dataType, period, timestamp, extension)
 
 	// Create exports directory if it doesn't exist
 	exportDir := "./exports"
@@ -2351,10 +2669,10 @@ func (as *AnalyticsService) generateExportFile(data interface{}, format, dataTyp
 	switch format {
 	case "csv":
 		return fileName, as.generateCSVFile(data, filePath)
-	case "excel":
-		return fileName, as.generateExcelFile(data, filePath)
+	case "excel", "xlsx":
+		return fileName, as.generateExcelFile(data, exportTitle(dataType, period), filePath)
 	case "pdf":
-		return fileName, as.generatePDFFile(data, filePath)
+		return fileName, as.generatePDFFile(data, exportTitle(dataType, period), filePath)
 	default:
 		return "", fmt.Errorf("unsupported format: %s", format)
 	}
@@ -2405,26 +2723,20 @@ func (as *AnalyticsService) generateCSVFile(data interface{}, filePath string) e
 	return nil
 }
 
-func (as *AnalyticsService) generateExcelFile(data interface{}, filePath string) error {
-	// For now, just generate CSV and rename extension
-	csvPath := strings.Replace(filePath, ".excel", ".csv", 1)
-	err := as.generateCSVFile(data, csvPath)
-	if err != nil {
-		return err
-	}
-	return os.Rename(csvPath, filePath)
+func (as *AnalyticsService) generateExcelFile(data interface{}, title, filePath string) error {
+	return writeExcelFile(title, buildExportSheets(data), filePath)
 }
 
-func (as *AnalyticsService) generatePDFFile(data interface{}, filePath string) error {
-	// Simple text file for PDF placeholder
-	file, err := os.Create(filePath)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
+func (as *AnalyticsService) generatePDFFile(data interface{}, title, filePath string) error {
+	return writePDFFile(title, buildExportSheets(data), filePath)
+}
 
-	_, err = file.WriteString("PDF Export - Data would be formatted here\n")
-	return err
+// exportTitle returns the human readable title used in document exports
+func exportTitle(dataType, period string) string {
+	if dataType == "" {
+		return fmt.Sprintf("Analytics - last %s days", period)
+	}
+	return fmt.Sprintf("%s%s analytics - last %s days", strings.ToUpper(dataType[:1]), dataType[1:], period)
 }
 
 func (as *AnalyticsService) sendExportEmail(email, fileName, dataType, format string) error {
@@ -2495,7 +2807,7 @@ func (as *AnalyticsService) getAPIMetrics(ctx context.Context, startTime time.Ti
 		},
 	}
 
-	cursor, err := as.collections.Logs().Aggregate(ctx, pipeline)
+	cursor, err := reportAggregate(ctx, as.collections.Logs(), pipeline)
 	if err != nil {
 		return nil, err
 	}
@@ -2556,7 +2868,7 @@ func (as *AnalyticsService) getStorageMetrics(ctx context.Context, startTime tim
 		},
 	}
 
-	cursor, err := as.collections.Files().Aggregate(ctx, pipeline)
+	cursor, err := reportAggregate(ctx, as.collections.Files(), pipeline)
 	if err != nil {
 		return nil, err
 	}
@@ -2602,7 +2914,7 @@ func (as *AnalyticsService) getErrorMetrics(ctx context.Context, startTime time.
 		},
 	}
 
-	cursor, err := as.collections.Logs().Aggregate(ctx, pipeline)
+	cursor, err := reportAggregate(ctx, as.collections.Logs(), pipeline)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,400 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"oncloud/models"
+	"oncloud/utils"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrAnnouncementNotFound = notFoundError("announcement not found")
+	// ErrInvalidAnnouncement wraps any rejected announcement
+	ErrInvalidAnnouncement = errors.New("invalid announcement")
+)
+
+type AnnouncementService struct {
+	*BaseService
+	notificationService *NotificationService
+}
+
+func NewAnnouncementService() *AnnouncementService {
+	return NewAnnouncementServiceWith(Dependencies{})
+}
+
+// NewAnnouncementServiceWith creates an announcement service on the given
+// dependencies
+func NewAnnouncementServiceWith(deps Dependencies) *AnnouncementService {
+	return &AnnouncementService{
+		BaseService:         NewBaseServiceWith(deps),
+		notificationService: NewNotificationServiceWith(deps),
+	}
+}
+
+// ListAnnouncements returns the announcements which have not ended, or every
+// announcement when includePast is set, latest first
+func (as *AnnouncementService) ListAnnouncements(ctx context.Context, includePast bool) ([]models.Announcement, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{}
+	if !includePast {
+		filter = notEndedAnnouncement(time.Now())
+	}
+	cursor, err := as.collections.Announcements().Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "starts_at", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	announcements := []models.Announcement{}
+	if err := cursor.All(ctx, &announcements); err != nil {
+		return nil, err
+	}
+	return announcements, nil
+}
+
+// CreateAnnouncement schedules an announcement
+func (as *AnnouncementService) CreateAnnouncement(ctx context.Context, req *models.AnnouncementRequest, adminID primitive.ObjectID) (*models.Announcement, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	announcement := &models.Announcement{
+		ID:        primitive.NewObjectID(),
+		CreatedBy: adminID,
+		CreatedAt: now,
+	}
+	if err := as.applyRequest(ctx, announcement, req, adminID); err != nil {
+		return nil, err
+	}
+
+	if _, err := as.collections.Announcements().InsertOne(ctx, announcement); err != nil {
+		return nil, fmt.Errorf("failed to create announcement: %v", err)
+	}
+	return announcement, nil
+}
+
+// UpdateAnnouncement replaces an announcement. One already emailed is not
+// emailed again.
+func (as *AnnouncementService) UpdateAnnouncement(ctx context.Context, announcementID primitive.ObjectID, req *models.AnnouncementRequest, adminID primitive.ObjectID) (*models.Announcement, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var announcement models.Announcement
+	if err := as.collections.Announcements().FindOne(ctx, bson.M{"_id": announcementID}).Decode(&announcement); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrAnnouncementNotFound
+		}
+		return nil, err
+	}
+	if err := as.applyRequest(ctx, &announcement, req, adminID); err != nil {
+		return nil, err
+	}
+
+	result, err := as.collections.Announcements().ReplaceOne(ctx, bson.M{"_id": announcementID}, announcement)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update announcement: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return nil, ErrAnnouncementNotFound
+	}
+	return &announcement, nil
+}
+
+// DeleteAnnouncement takes an announcement down along with its dismissals
+func (as *AnnouncementService) DeleteAnnouncement(ctx context.Context, announcementID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := as.collections.Announcements().DeleteOne(ctx, bson.M{"_id": announcementID})
+	if err != nil {
+		return fmt.Errorf("failed to delete announcement: %v", err)
+	}
+	if result.DeletedCount == 0 {
+		return ErrAnnouncementNotFound
+	}
+
+	as.collections.AnnouncementDismissals().DeleteMany(ctx, bson.M{"announcement_id": announcementID})
+	return nil
+}
+
+// ActiveAnnouncements returns the running announcements user is in the
+// audience of and has not dismissed, or those for everyone when user is nil
+func (as *AnnouncementService) ActiveAnnouncements(ctx context.Context, user *models.User) ([]models.Announcement, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	audiences := []bson.M{{"audience": models.AnnouncementAudienceAll}}
+	if user != nil {
+		audiences = append(audiences, bson.M{"audience": models.AnnouncementAudiencePlan, "plan_id": user.PlanID})
+
+		groupIDs, err := as.userGroupIDs(ctx, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		if len(groupIDs) > 0 {
+			audiences = append(audiences, bson.M{"audience": models.AnnouncementAudienceOrg, "group_id": bson.M{"$in": groupIDs}})
+		}
+	}
+
+	filter := notEndedAnnouncement(now)
+	filter["starts_at"] = bson.M{"$lte": now}
+	filter["$and"] = []bson.M{{"$or": audiences}}
+	cursor, err := as.collections.Announcements().Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "starts_at", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	announcements := []models.Announcement{}
+	if err := cursor.All(ctx, &announcements); err != nil {
+		return nil, err
+	}
+	if user == nil || len(announcements) == 0 {
+		return announcements, nil
+	}
+
+	ids := make([]primitive.ObjectID, len(announcements))
+	for i, announcement := range announcements {
+		ids[i] = announcement.ID
+	}
+	cursor, err = as.collections.AnnouncementDismissals().Find(ctx,
+		bson.M{"user_id": user.ID, "announcement_id": bson.M{"$in": ids}},
+		options.Find().SetProjection(bson.M{"announcement_id": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var dismissals []models.AnnouncementDismissal
+	if err := cursor.All(ctx, &dismissals); err != nil {
+		return nil, err
+	}
+	dismissed := make(map[primitive.ObjectID]bool, len(dismissals))
+	for _, dismissal := range dismissals {
+		dismissed[dismissal.AnnouncementID] = true
+	}
+
+	visible := announcements[:0]
+	for _, announcement := range announcements {
+		if !dismissed[announcement.ID] {
+			visible = append(visible, announcement)
+		}
+	}
+	return visible, nil
+}
+
+// DismissAnnouncement stops showing an announcement to a user
+func (as *AnnouncementService) DismissAnnouncement(ctx context.Context, announcementID, userID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var announcement models.Announcement
+	if err := as.collections.Announcements().FindOne(ctx, bson.M{"_id": announcementID}).Decode(&announcement); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return ErrAnnouncementNotFound
+		}
+		return err
+	}
+	if !announcement.Dismissible {
+		return fmt.Errorf("%w: the announcement can't be dismissed", ErrInvalidAnnouncement)
+	}
+
+	_, err := as.collections.AnnouncementDismissals().InsertOne(ctx, &models.AnnouncementDismissal{
+		ID:             primitive.NewObjectID(),
+		AnnouncementID: announcementID,
+		UserID:         userID,
+		DismissedAt:    time.Now(),
+	})
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("failed to dismiss announcement: %v", err)
+	}
+	return nil
+}
+
+// SendDueEmails emails the announcements which have started and are to be
+// emailed to their audience. Each is claimed before sending, so that only
+// one instance sends it. It returns how many emails were sent.
+func (as *AnnouncementService) SendDueEmails(ctx context.Context) (int, error) {
+	sent := 0
+	for {
+		claimCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		now := time.Now()
+		filter := notEndedAnnouncement(now)
+		filter["send_email"] = true
+		filter["emailed_at"] = bson.M{"$exists": false}
+		filter["starts_at"] = bson.M{"$lte": now}
+
+		var announcement models.Announcement
+		err := as.collections.Announcements().FindOneAndUpdate(claimCtx, filter,
+			bson.M{"$set": bson.M{"emailed_at": now}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&announcement)
+		cancel()
+		if err == mongo.ErrNoDocuments {
+			return sent, nil
+		}
+		if err != nil {
+			return sent, err
+		}
+
+		count := as.emailAudience(ctx, &announcement)
+		sent += count
+		log.Printf("Emailed announcement %s to %d users", announcement.ID.Hex(), count)
+	}
+}
+
+// emailAudience emails an announcement to each active user in its audience
+func (as *AnnouncementService) emailAudience(ctx context.Context, announcement *models.Announcement) int {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Hour)
+	defer cancel()
+
+	filter := bson.M{"is_active": true}
+	switch announcement.Audience {
+	case models.AnnouncementAudiencePlan:
+		filter["plan_id"] = announcement.PlanID
+	case models.AnnouncementAudienceOrg:
+		var group models.ScimGroup
+		if err := as.collections.ScimGroups().FindOne(ctx, bson.M{"_id": announcement.GroupID}).Decode(&group); err != nil {
+			log.Printf("Failed to email announcement %s: group not found: %v", announcement.ID.Hex(), err)
+			return 0
+		}
+		filter["_id"] = bson.M{"$in": group.Members}
+	}
+
+	cursor, err := as.collections.Users().Find(ctx, filter,
+		options.Find().SetProjection(bson.M{"email": 1, "first_name": 1, "last_name": 1}))
+	if err != nil {
+		log.Printf("Failed to email announcement %s: %v", announcement.ID.Hex(), err)
+		return 0
+	}
+	defer cursor.Close(ctx)
+
+	sent := 0
+	for cursor.Next(ctx) {
+		var user models.User
+		if err := cursor.Decode(&user); err != nil {
+			continue
+		}
+		err := as.notificationService.SendEmail(user.Email, "announcement", map[string]string{
+			"name":    user.FirstName + " " + user.LastName,
+			"title":   announcement.Title,
+			"message": announcement.Message,
+			"link":    announcement.LinkURL,
+		})
+		if err != nil {
+			log.Printf("Failed to email announcement %s to user %s: %v", announcement.ID.Hex(), user.ID.Hex(), err)
+			continue
+		}
+		sent++
+	}
+
+	as.collections.Announcements().UpdateOne(ctx,
+		bson.M{"_id": announcement.ID},
+		bson.M{"$set": bson.M{"emailed_count": sent}},
+	)
+	return sent
+}
+
+// applyRequest checks an announcement definition and sets it on announcement
+func (as *AnnouncementService) applyRequest(ctx context.Context, announcement *models.Announcement, req *models.AnnouncementRequest, adminID primitive.ObjectID) error {
+	if err := utils.ValidateStruct(req); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidAnnouncement, err)
+	}
+
+	announcement.Audience = req.Audience
+	announcement.PlanID = nil
+	announcement.GroupID = nil
+
+	switch req.Audience {
+	case models.AnnouncementAudiencePlan:
+		if req.PlanID == "" {
+			return fmt.Errorf("%w: plan_id is required for plan announcements", ErrInvalidAnnouncement)
+		}
+		planID, _ := utils.StringToObjectID(req.PlanID)
+		if count, err := as.collections.Plans().CountDocuments(ctx, bson.M{"_id": planID}); err != nil {
+			return err
+		} else if count == 0 {
+			return fmt.Errorf("%w: plan not found", ErrInvalidAnnouncement)
+		}
+		announcement.PlanID = &planID
+	case models.AnnouncementAudienceOrg:
+		if req.GroupID == "" {
+			return fmt.Errorf("%w: group_id is required for org announcements", ErrInvalidAnnouncement)
+		}
+		groupID, _ := utils.StringToObjectID(req.GroupID)
+		if count, err := as.collections.ScimGroups().CountDocuments(ctx, bson.M{"_id": groupID}); err != nil {
+			return err
+		} else if count == 0 {
+			return fmt.Errorf("%w: group not found", ErrInvalidAnnouncement)
+		}
+		announcement.GroupID = &groupID
+	}
+
+	now := time.Now()
+	announcement.StartsAt = now
+	if req.StartsAt != nil {
+		announcement.StartsAt = *req.StartsAt
+	}
+	announcement.EndsAt = req.EndsAt
+	if announcement.EndsAt != nil {
+		if !announcement.EndsAt.After(announcement.StartsAt) {
+			return fmt.Errorf("%w: ends_at must be after starts_at", ErrInvalidAnnouncement)
+		}
+		if !announcement.EndsAt.After(now) {
+			return fmt.Errorf("%w: the announcement must end in the future", ErrInvalidAnnouncement)
+		}
+	}
+
+	announcement.Title = strings.TrimSpace(req.Title)
+	announcement.Message = strings.TrimSpace(req.Message)
+	announcement.Kind = req.Kind
+	if announcement.Kind == "" {
+		announcement.Kind = "info"
+	}
+	announcement.LinkURL = req.LinkURL
+	announcement.Dismissible = req.Dismissible == nil || *req.Dismissible
+	announcement.SendEmail = req.SendEmail
+	announcement.UpdatedBy = adminID
+	announcement.UpdatedAt = now
+	return nil
+}
+
+// userGroupIDs returns the directory groups, the organizations, a user is a
+// member of
+func (as *AnnouncementService) userGroupIDs(ctx context.Context, userID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	cursor, err := as.collections.ScimGroups().Find(ctx,
+		bson.M{"members": userID},
+		options.Find().SetProjection(bson.M{"_id": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var groups []models.ScimGroup
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+
+	groupIDs := make([]primitive.ObjectID, len(groups))
+	for i, group := range groups {
+		groupIDs[i] = group.ID
+	}
+	return groupIDs, nil
+}
+
+// notEndedAnnouncement filters announcements which run past now
+func notEndedAnnouncement(now time.Time) bson.M {
+	return bson.M{"$or": []bson.M{
+		{"ends_at": nil},
+		{"ends_at": bson.M{"$gt": now}},
+	}}
+}
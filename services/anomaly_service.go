@@ -0,0 +1,629 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"oncloud/models"
+	"oncloud/utils"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	AnomalyDownloadSpike      = "download_spike"
+	AnomalyFailedLoginSpike   = "failed_login_spike"
+	AnomalyErrorRateSpike     = "error_rate_spike"
+	AnomalyStorageGrowthSpike = "storage_growth_spike"
+
+	AnomalyStatusActive       = "active"
+	AnomalyStatusAcknowledged = "acknowledged"
+	AnomalyStatusResolved     = "resolved"
+
+	// Hourly history the rolling baselines are computed over
+	anomalyBaselineHours = 7 * 24
+
+	// Minimum volumes before a deviation is worth alerting on
+	anomalyMinFailedLogins   = 20
+	anomalyMinUserDownloads  = 50
+	anomalyMinRequests       = 50
+	anomalyMinErrorRate      = 0.05
+	anomalyMinStorageGrowth  = 1 << 30
+	anomalyMaxUsersPerRun    = 100
+	anomalyErrorRateWindow   = 15 * time.Minute
+	anomalyMinStorageSamples = 24
+)
+
+// AnomalyDetectionOptions tunes a detection run
+type AnomalyDetectionOptions struct {
+	Sigma    float64       // standard deviations above the baseline mean that count as a spike
+	Interval time.Duration // how often detection runs; anomalies unseen for two intervals resolve
+}
+
+// spike is a value that deviates from its rolling baseline
+type spike struct {
+	value     float64
+	mean      float64
+	stdDev    float64
+	threshold float64
+	severity  string
+}
+
+type AnomalyService struct {
+	*BaseService
+}
+
+func NewAnomalyService() *AnomalyService {
+	return NewAnomalyServiceWith(Dependencies{})
+}
+
+// NewAnomalyServiceWith creates an anomaly service on the given dependencies
+func NewAnomalyServiceWith(deps Dependencies) *AnomalyService {
+	return &AnomalyService{
+		BaseService: NewBaseServiceWith(deps),
+	}
+}
+
+// DetectAnomalies compares recent usage and error volumes against their
+// rolling baselines, records anomalies and alerts admins about new ones.
+// Returns the number of anomalies detected in this run.
+func (as *AnomalyService) DetectAnomalies(ctx context.Context, opts AnomalyDetectionOptions) (int, error) {
+	if opts.Sigma <= 0 {
+		opts.Sigma = 3
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = 10 * time.Minute
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+
+	now := time.Now()
+	detectors := []func(context.Context, time.Time, float64) ([]*models.Anomaly, error){
+		as.detectFailedLoginSpikes,
+		as.detectDownloadSpikes,
+		as.detectErrorRateSpikes,
+		as.detectStorageGrowthSpikes,
+	}
+
+	detected := 0
+	var firstErr error
+	for _, detect := range detectors {
+		anomalies, err := detect(ctx, now, opts.Sigma)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		for _, anomaly := range anomalies {
+			if err := as.raiseAnomaly(ctx, anomaly); err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			detected++
+		}
+	}
+
+	// Anomalies that were not seen again for two runs have subsided
+	resolvedAt := time.Now()
+	as.collections.Anomalies().UpdateMany(ctx,
+		bson.M{
+			"status":           bson.M{"$in": []string{AnomalyStatusActive, AnomalyStatusAcknowledged}},
+			"last_detected_at": bson.M{"$lt": now.Add(-2 * opts.Interval)},
+		},
+		bson.M{"$set": bson.M{"status": AnomalyStatusResolved, "resolved_at": resolvedAt}},
+	)
+
+	return detected, firstErr
+}
+
+// GetActiveAnomalies returns open anomalies, most recent first
+func (as *AnomalyService) GetActiveAnomalies(ctx context.Context, limit int) ([]models.Anomaly, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	cursor, err := as.collections.Anomalies().Find(ctx,
+		bson.M{"status": bson.M{"$in": []string{AnomalyStatusActive, AnomalyStatusAcknowledged}}},
+		options.Find().SetSort(bson.M{"last_detected_at": -1}).SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var anomalies []models.Anomaly
+	if err = cursor.All(ctx, &anomalies); err != nil {
+		return nil, err
+	}
+
+	return anomalies, nil
+}
+
+// GetAnomalies returns paginated anomalies, optionally filtered by status and type
+func (as *AnomalyService) GetAnomalies(ctx context.Context, status, anomalyType string, page, limit int) ([]models.Anomaly, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{}
+	if status != "" {
+		filter["status"] = status
+	}
+	if anomalyType != "" {
+		filter["type"] = anomalyType
+	}
+
+	skip := (page - 1) * limit
+	cursor, err := as.collections.Anomalies().Find(ctx, filter,
+		options.Find().
+			SetSort(bson.M{"last_detected_at": -1}).
+			SetSkip(int64(skip)).
+			SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var anomalies []models.Anomaly
+	if err = cursor.All(ctx, &anomalies); err != nil {
+		return nil, 0, err
+	}
+
+	total, err := as.collections.Anomalies().CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return anomalies, int(total), nil
+}
+
+// AcknowledgeAnomaly marks an active anomaly as seen by an admin
+func (as *AnomalyService) AcknowledgeAnomaly(ctx context.Context, anomalyID, adminID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := as.collections.Anomalies().UpdateOne(ctx,
+		bson.M{"_id": anomalyID, "status": AnomalyStatusActive},
+		bson.M{"$set": bson.M{
+			"status":          AnomalyStatusAcknowledged,
+			"acknowledged_by": adminID,
+			"acknowledged_at": time.Now(),
+		}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to acknowledge anomaly: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("active anomaly not found")
+	}
+
+	return nil
+}
+
+// ResolveAnomaly closes an open anomaly
+func (as *AnomalyService) ResolveAnomaly(ctx context.Context, anomalyID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := as.collections.Anomalies().UpdateOne(ctx,
+		bson.M{
+			"_id":    anomalyID,
+			"status": bson.M{"$in": []string{AnomalyStatusActive, AnomalyStatusAcknowledged}},
+		},
+		bson.M{"$set": bson.M{"status": AnomalyStatusResolved, "resolved_at": time.Now()}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to resolve anomaly: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("open anomaly not found")
+	}
+
+	return nil
+}
+
+// detectFailedLoginSpikes compares failed logins in the last hour against the hourly baseline
+func (as *AnomalyService) detectFailedLoginSpikes(ctx context.Context, now time.Time, sigma float64) ([]*models.Anomaly, error) {
+	counts, err := as.hourlyEventCounts(ctx, bson.M{"type": "security", "action": "login_failed"}, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count failed logins: %v", err)
+	}
+
+	current := counts[len(counts)-1]
+	s, ok := evaluateSpike(current, counts[:len(counts)-1], sigma, 1, anomalyMinFailedLogins)
+	if !ok {
+		return nil, nil
+	}
+
+	return []*models.Anomaly{withSpike(&models.Anomaly{
+		Type:        AnomalyFailedLoginSpike,
+		Subject:     "global",
+		Message:     fmt.Sprintf("%d failed logins in the last hour (baseline %.1f per hour)", int64(current), s.mean),
+		WindowStart: now.Add(-time.Hour),
+		WindowEnd:   now,
+	}, s)}, nil
+}
+
+// detectDownloadSpikes compares each heavy downloader's last hour against
+// their own hourly baseline
+func (as *AnomalyService) detectDownloadSpikes(ctx context.Context, now time.Time, sigma float64) ([]*models.Anomaly, error) {
+	windowStart := now.Add(-time.Hour)
+	downloads := bson.M{"type": "file_activity", "action": "download"}
+
+	cursor, err := as.collections.Analytics().Aggregate(ctx, []bson.M{
+		{"$match": bson.M{
+			"type":      "file_activity",
+			"action":    "download",
+			"user_id":   bson.M{"$ne": nil},
+			"timestamp": bson.M{"$gte": windowStart, "$lt": now},
+		}},
+		{"$group": bson.M{"_id": "$user_id", "count": bson.M{"$sum": 1}}},
+		{"$match": bson.M{"count": bson.M{"$gte": anomalyMinUserDownloads}}},
+		{"$sort": bson.M{"count": -1}},
+		{"$limit": anomalyMaxUsersPerRun},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to count downloads: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var heavy []struct {
+		UserID primitive.ObjectID `bson:"_id"`
+		Count  int64              `bson:"count"`
+	}
+	if err = cursor.All(ctx, &heavy); err != nil {
+		return nil, err
+	}
+
+	var anomalies []*models.Anomaly
+	for _, h := range heavy {
+		match := bson.M{"user_id": h.UserID}
+		for k, v := range downloads {
+			match[k] = v
+		}
+
+		counts, err := as.hourlyEventCounts(ctx, match, now)
+		if err != nil {
+			return anomalies, fmt.Errorf("failed to count downloads: %v", err)
+		}
+
+		s, ok := evaluateSpike(float64(h.Count), counts[:len(counts)-1], sigma, 1, anomalyMinUserDownloads)
+		if !ok {
+			continue
+		}
+
+		userID := h.UserID
+		anomalies = append(anomalies, withSpike(&models.Anomaly{
+			Type:        AnomalyDownloadSpike,
+			Subject:     userID.Hex(),
+			UserID:      &userID,
+			Message:     fmt.Sprintf("User %s downloaded %d files in the last hour (baseline %.1f per hour)", userID.Hex(), h.Count, s.mean),
+			WindowStart: windowStart,
+			WindowEnd:   now,
+		}, s))
+	}
+
+	return anomalies, nil
+}
+
+// detectErrorRateSpikes compares the 5xx rate of the last window against
+// earlier windows of this process's request counters
+func (as *AnomalyService) detectErrorRateSpikes(ctx context.Context, now time.Time, sigma float64) ([]*models.Anomaly, error) {
+	buckets := utils.GetRequestStats(now.Add(-24 * time.Hour))
+	if len(buckets) == 0 {
+		return nil, nil
+	}
+
+	type window struct{ requests, errors int64 }
+	windows := make(map[int64]*window)
+	currentKey := now.Add(-anomalyErrorRateWindow).Unix() / int64(anomalyErrorRateWindow.Seconds())
+	for _, b := range buckets {
+		key := b.Minute.Unix() / int64(anomalyErrorRateWindow.Seconds())
+		if windows[key] == nil {
+			windows[key] = &window{}
+		}
+		windows[key].requests += b.Requests
+		windows[key].errors += b.ServerErrors
+	}
+
+	// The current window is the trailing one, which may span two aligned windows
+	var current window
+	var baseline []float64
+	windowStart := now.Add(-anomalyErrorRateWindow)
+	for _, b := range buckets {
+		if !b.Minute.Before(windowStart) {
+			current.requests += b.Requests
+			current.errors += b.ServerErrors
+		}
+	}
+	for key, w := range windows {
+		if key >= currentKey || w.requests == 0 {
+			continue
+		}
+		baseline = append(baseline, float64(w.errors)/float64(w.requests))
+	}
+
+	if current.requests < anomalyMinRequests {
+		return nil, nil
+	}
+
+	rate := float64(current.errors) / float64(current.requests)
+	s, ok := evaluateSpike(rate, baseline, sigma, 0.01, anomalyMinErrorRate)
+	if !ok {
+		return nil, nil
+	}
+
+	return []*models.Anomaly{withSpike(&models.Anomaly{
+		Type:    AnomalyErrorRateSpike,
+		Subject: "global",
+		Message: fmt.Sprintf("%.1f%% of requests failed with server errors in the last %s (%d of %d, baseline %.1f%%)",
+			rate*100, anomalyErrorRateWindow, current.errors, current.requests, s.mean*100),
+		WindowStart: windowStart,
+		WindowEnd:   now,
+	}, s)}, nil
+}
+
+// detectStorageGrowthSpikes compares bytes uploaded in the last complete hour
+// against the hourly stats rollups
+func (as *AnomalyService) detectStorageGrowthSpikes(ctx context.Context, now time.Time, sigma float64) ([]*models.Anomaly, error) {
+	lastHour := now.Truncate(time.Hour).Add(-time.Hour)
+
+	cursor, err := as.collections.StatsRollups().Find(ctx,
+		bson.M{
+			"granularity":  RollupHourly,
+			"period_start": bson.M{"$gte": lastHour.Add(-anomalyBaselineHours * time.Hour), "$lte": lastHour},
+		},
+		options.Find().SetProjection(bson.M{"period_start": 1, "uploaded_bytes": 1}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load hourly rollups: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var rollups []models.StatsRollup
+	if err = cursor.All(ctx, &rollups); err != nil {
+		return nil, err
+	}
+
+	var current *models.StatsRollup
+	var baseline []float64
+	for i := range rollups {
+		if rollups[i].PeriodStart.Equal(lastHour) {
+			current = &rollups[i]
+			continue
+		}
+		baseline = append(baseline, float64(rollups[i].UploadedBytes))
+	}
+	if current == nil || len(baseline) < anomalyMinStorageSamples {
+		return nil, nil
+	}
+
+	s, ok := evaluateSpike(float64(current.UploadedBytes), baseline, sigma, 1, anomalyMinStorageGrowth)
+	if !ok {
+		return nil, nil
+	}
+
+	return []*models.Anomaly{withSpike(&models.Anomaly{
+		Type:    AnomalyStorageGrowthSpike,
+		Subject: "global",
+		Message: fmt.Sprintf("%s uploaded between %s and %s (baseline %s per hour)",
+			utils.FormatFileSize(current.UploadedBytes), current.PeriodStart.Format("15:04"),
+			current.PeriodEnd.Format("15:04"), utils.FormatFileSize(int64(s.mean))),
+		WindowStart: current.PeriodStart,
+		WindowEnd:   current.PeriodEnd,
+	}, s)}, nil
+}
+
+// hourlyEventCounts counts analytics events matching filter in hourly buckets
+// ending at now. The last element is the trailing hour; the ones before it
+// form the baseline.
+func (as *AnomalyService) hourlyEventCounts(ctx context.Context, filter bson.M, now time.Time) ([]float64, error) {
+	buckets := anomalyBaselineHours + 1
+	from := now.Add(-time.Duration(buckets) * time.Hour)
+
+	match := bson.M{"timestamp": bson.M{"$gte": from, "$lt": now}}
+	for k, v := range filter {
+		match[k] = v
+	}
+
+	cursor, err := as.collections.Analytics().Aggregate(ctx, []bson.M{
+		{"$match": match},
+		{"$group": bson.M{
+			"_id": bson.M{"$floor": bson.M{"$divide": []interface{}{
+				bson.M{"$subtract": []interface{}{"$timestamp", from}},
+				int64(time.Hour / time.Millisecond),
+			}}},
+			"count": bson.M{"$sum": 1},
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var results []bson.M
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	counts := make([]float64, buckets)
+	for _, r := range results {
+		bucket := int(toInt64(r["_id"]))
+		if bucket >= 0 && bucket < buckets {
+			counts[bucket] = float64(toInt64(r["count"]))
+		}
+	}
+
+	return counts, nil
+}
+
+// raiseAnomaly records a detection, extending the open anomaly of the same
+// type and subject if there is one, and alerts admins when it is new
+func (as *AnomalyService) raiseAnomaly(ctx context.Context, anomaly *models.Anomaly) error {
+	now := time.Now()
+
+	setOnInsert := bson.M{
+		"_id":               primitive.NewObjectID(),
+		"status":            AnomalyStatusActive,
+		"first_detected_at": now,
+	}
+	if anomaly.UserID != nil {
+		setOnInsert["user_id"] = *anomaly.UserID
+	}
+
+	result, err := as.collections.Anomalies().UpdateOne(ctx,
+		bson.M{
+			"type":    anomaly.Type,
+			"subject": anomaly.Subject,
+			"status":  bson.M{"$in": []string{AnomalyStatusActive, AnomalyStatusAcknowledged}},
+		},
+		bson.M{
+			"$set": bson.M{
+				"severity":         anomaly.Severity,
+				"message":          anomaly.Message,
+				"value":            anomaly.Value,
+				"baseline":         anomaly.Baseline,
+				"std_dev":          anomaly.StdDev,
+				"threshold":        anomaly.Threshold,
+				"window_start":     anomaly.WindowStart,
+				"window_end":       anomaly.WindowEnd,
+				"last_detected_at": now,
+			},
+			"$inc":         bson.M{"occurrences": 1},
+			"$setOnInsert": setOnInsert,
+		},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record %s anomaly: %v", anomaly.Type, err)
+	}
+
+	if result.UpsertedCount > 0 {
+		log.Printf("Anomaly detected [%s/%s]: %s", anomaly.Type, anomaly.Severity, anomaly.Message)
+		as.notifyAdmins(ctx, anomaly, result.UpsertedID)
+	}
+
+	return nil
+}
+
+// notifyAdmins sends an in-app notification about a new anomaly to every active admin
+func (as *AnomalyService) notifyAdmins(ctx context.Context, anomaly *models.Anomaly, anomalyID interface{}) {
+	cursor, err := as.collections.Admins().Find(ctx,
+		bson.M{"is_active": true},
+		options.Find().SetProjection(bson.M{"_id": 1}),
+	)
+	if err != nil {
+		log.Printf("Failed to load admins for anomaly alert: %v", err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var admins []models.Admin
+	if err = cursor.All(ctx, &admins); err != nil {
+		return
+	}
+
+	notifications := make([]interface{}, 0, len(admins))
+	for _, admin := range admins {
+		notifications = append(notifications, bson.M{
+			"_id":            primitive.NewObjectID(),
+			"user_id":        admin.ID,
+			"recipient_type": "admin",
+			"type":           "anomaly_alert",
+			"title":          anomalyTitle(anomaly),
+			"message":        anomaly.Message,
+			"data": bson.M{
+				"anomaly_id": anomalyID,
+				"type":       anomaly.Type,
+				"severity":   anomaly.Severity,
+				"subject":    anomaly.Subject,
+			},
+			"is_read":    false,
+			"created_at": time.Now(),
+		})
+	}
+	if len(notifications) == 0 {
+		return
+	}
+
+	if _, err := as.collections.Notifications().InsertMany(ctx, notifications); err != nil {
+		log.Printf("Failed to send anomaly alert: %v", err)
+	}
+}
+
+func anomalyTitle(anomaly *models.Anomaly) string {
+	titles := map[string]string{
+		AnomalyDownloadSpike:      "Unusual download activity",
+		AnomalyFailedLoginSpike:   "Spike in failed logins",
+		AnomalyErrorRateSpike:     "Elevated server error rate",
+		AnomalyStorageGrowthSpike: "Unusual storage growth",
+	}
+
+	title := titles[anomaly.Type]
+	if title == "" {
+		title = "Anomaly detected"
+	}
+	if anomaly.Severity == "critical" {
+		title = "Critical: " + title
+	}
+
+	return title
+}
+
+// evaluateSpike reports whether value exceeds the baseline mean by more than
+// sigma standard deviations and is at least minValue. minStdDev keeps a flat
+// baseline from turning every small bump into a spike.
+func evaluateSpike(value float64, baseline []float64, sigma, minStdDev, minValue float64) (spike, bool) {
+	if value < minValue {
+		return spike{}, false
+	}
+
+	var mean float64
+	for _, v := range baseline {
+		mean += v
+	}
+	if len(baseline) > 0 {
+		mean /= float64(len(baseline))
+	}
+
+	var variance float64
+	for _, v := range baseline {
+		variance += (v - mean) * (v - mean)
+	}
+	if len(baseline) > 1 {
+		variance /= float64(len(baseline) - 1)
+	}
+	stdDev := math.Sqrt(variance)
+
+	spread := math.Max(stdDev, minStdDev)
+	threshold := mean + sigma*spread
+	if value <= threshold {
+		return spike{}, false
+	}
+
+	severity := "warning"
+	if value > mean+2*sigma*spread {
+		severity = "critical"
+	}
+
+	return spike{value: value, mean: mean, stdDev: stdDev, threshold: threshold, severity: severity}, true
+}
+
+// withSpike copies the spike measurements onto the anomaly
+func withSpike(anomaly *models.Anomaly, s spike) *models.Anomaly {
+	anomaly.Severity = s.severity
+	anomaly.Value = s.value
+	anomaly.Baseline = s.mean
+	anomaly.StdDev = s.stdDev
+	anomaly.Threshold = s.threshold
+	return anomaly
+}
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"context"
+	"log"
+	"oncloud/models"
+	"oncloud/utils"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AuditLogFilter narrows an audit log listing
+type AuditLogFilter struct {
+	ActorID *primitive.ObjectID
+	Action  string
+	Outcome string
+	From    *time.Time
+	To      *time.Time
+}
+
+type AuditService struct {
+	*BaseService
+}
+
+func NewAuditService() *AuditService {
+	return NewAuditServiceWith(Dependencies{})
+}
+
+// NewAuditServiceWith creates an audit service on the given dependencies
+func NewAuditServiceWith(deps Dependencies) *AuditService {
+	return &AuditService{
+		BaseService: NewBaseServiceWith(deps),
+	}
+}
+
+// Record writes an audit entry. entry.IPAddress is the raw client address; it
+// is located and anonymized before storage. Failures are logged rather than
+// returned so auditing never blocks the audited action.
+func (as *AuditService) Record(ctx context.Context, entry *models.AuditLog) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	entry.ID = primitive.NewObjectID()
+	entry.CreatedAt = time.Now()
+	if entry.Outcome == "" {
+		entry.Outcome = "success"
+	}
+	if entry.IPAddress != "" {
+		location := utils.LocateClient(entry.IPAddress)
+		entry.IPAddress = location.IP
+		entry.Country = location.Country
+	}
+
+	if _, err := as.collections.AuditLogs().InsertOne(ctx, entry); err != nil {
+		log.Printf("Failed to write audit log %s: %v", entry.Action, err)
+	}
+}
+
+// GetAuditLogs returns paginated audit entries, newest first
+func (as *AuditService) GetAuditLogs(ctx context.Context, filter AuditLogFilter, page, limit int) ([]models.AuditLog, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	query := bson.M{}
+	if filter.ActorID != nil {
+		query["actor_id"] = *filter.ActorID
+	}
+	if filter.Action != "" {
+		query["action"] = filter.Action
+	}
+	if filter.Outcome != "" {
+		query["outcome"] = filter.Outcome
+	}
+	if filter.From != nil || filter.To != nil {
+		createdAt := bson.M{}
+		if filter.From != nil {
+			createdAt["$gte"] = *filter.From
+		}
+		if filter.To != nil {
+			createdAt["$lte"] = *filter.To
+		}
+		query["created_at"] = createdAt
+	}
+
+	skip := (page - 1) * limit
+	cursor, err := as.collections.AuditLogs().Find(ctx, query,
+		options.Find().
+			SetSort(bson.M{"created_at": -1}).
+			SetSkip(int64(skip)).
+			SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var logs []models.AuditLog
+	if err = cursor.All(ctx, &logs); err != nil {
+		return nil, 0, err
+	}
+
+	total, err := as.collections.AuditLogs().CountDocuments(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return logs, int(total), nil
+}
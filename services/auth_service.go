@@ -5,7 +5,6 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"oncloud/database"
 	"oncloud/models"
 	"oncloud/utils"
 	"time"
@@ -20,14 +19,19 @@ type AuthService struct {
 }
 
 func NewAuthService() *AuthService {
+	return NewAuthServiceWith(Dependencies{})
+}
+
+// NewAuthServiceWith creates an auth service on the given dependencies
+func NewAuthServiceWith(deps Dependencies) *AuthService {
 	return &AuthService{
-		BaseService: NewBaseService(),
+		BaseService: NewBaseServiceWith(deps),
 	}
 }
 
 // Register creates a new user account
-func (as *AuthService) Register(req *models.RegisterRequest) (*models.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (as *AuthService) Register(ctx context.Context, req *models.RegisterRequest) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	// Check if user already exists
@@ -48,6 +52,17 @@ func (as *AuthService) Register(req *models.RegisterRequest) (*models.User, erro
 		return nil, fmt.Errorf("database error: %v", err)
 	}
 
+	// Enforce the password policy
+	err = NewSecurityPolicyService().ValidatePassword(ctx, req.Password, &models.User{
+		Username:  req.Username,
+		Email:     req.Email,
+		FirstName: req.FirstName,
+		LastName:  req.LastName,
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	// Hash password
 	hashedPassword, err := utils.HashPassword(req.Password)
 	if err != nil {
@@ -55,7 +70,7 @@ func (as *AuthService) Register(req *models.RegisterRequest) (*models.User, erro
 	}
 
 	// Get default plan
-	defaultPlan, err := as.getDefaultPlan()
+	defaultPlan, err := as.getDefaultPlan(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get default plan: %v", err)
 	}
@@ -87,8 +102,8 @@ func (as *AuthService) Register(req *models.RegisterRequest) (*models.User, erro
 	}
 
 	// Send verification email if required
-	if as.isEmailVerificationRequired() {
-		err = as.sendVerificationEmail(user)
+	if as.isEmailVerificationRequired(ctx) {
+		err = as.sendVerificationEmail(ctx, user)
 		if err != nil {
 			// Log error but don't fail registration
 			fmt.Printf("Failed to send verification email: %v\n", err)
@@ -111,9 +126,9 @@ func (as *AuthService) Register(req *models.RegisterRequest) (*models.User, erro
 	return user, nil
 }
 
-// Login authenticates a user
-func (as *AuthService) Login(email, password string) (*models.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+// Login authenticates a user signing in from clientIP
+func (as *AuthService) Login(ctx context.Context, email, password, clientIP string) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	// Find user by email
@@ -121,119 +136,70 @@ func (as *AuthService) Login(email, password string) (*models.User, error) {
 	err := as.collections.Users().FindOne(ctx, bson.M{"email": email}).Decode(&user)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
+			as.trackFailedLogin(email, nil, clientIP)
 			return nil, errors.New("invalid credentials")
 		}
 		return nil, fmt.Errorf("database error: %v", err)
 	}
 
+	// Locked accounts are refused before the password is even checked
+	policies := NewSecurityPolicyService()
+	if err := policies.CheckLockout(&user); err != nil {
+		return nil, err
+	}
+
 	// Check password
 	if !utils.CheckPasswordHash(password, user.Password) {
+		as.trackFailedLogin(email, &user.ID, clientIP)
+		if err := policies.RecordFailedLogin(ctx, &user, clientIP); err != nil {
+			return nil, err
+		}
 		return nil, errors.New("invalid credentials")
 	}
 
+	if user.FailedLogins > 0 || user.LockoutCount > 0 {
+		policies.ClearFailedLogins(ctx, user.ID)
+	}
+
 	// Check if user is active
 	if !user.IsActive {
 		return nil, errors.New("account is deactivated")
 	}
 
-	// Update last login
+	// Update last login, filling in the user's location from GeoIP unless
+	// they set a country themselves
+	location := utils.LocateClient(clientIP)
+	set := bson.M{"last_login_at": time.Now(), "last_login_from": location}
+	if user.Country == "" && location.Country != "" {
+		set["country"] = location.Country
+		set["region"] = location.Region
+		user.Country = location.Country
+		user.Region = location.Region
+	}
 	as.collections.Users().UpdateOne(ctx,
 		bson.M{"_id": user.ID},
-		bson.M{"$set": bson.M{"last_login_at": time.Now()}},
+		bson.M{"$set": set},
 	)
+	user.LastLoginFrom = location
+
+	// Record the login as activity for retention analytics
+	NewAnalyticsService().TrackUserActivity(user.ID, "login", "auth", nil, location)
 
 	// Clear password before returning
 	user.Password = ""
 	return &user, nil
 }
 
-// SendPasswordResetEmail sends password reset email
-func (as *AuthService) SendPasswordResetEmail(email string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	// Find user by email
-	var user models.User
-	err := as.collections.Users().FindOne(ctx, bson.M{"email": email}).Decode(&user)
-	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			// Don't reveal if email exists for security
-			return nil
-		}
-		return fmt.Errorf("database error: %v", err)
-	}
-
-	// Generate reset token
-	resetToken, err := utils.GenerateSecureToken(32)
-	if err != nil {
-		return fmt.Errorf("failed to generate reset token: %v", err)
-	}
-
-	// Store reset token with expiration
-	resetExpiry := time.Now().Add(24 * time.Hour)
-	_, err = as.collections.Users().UpdateOne(ctx,
-		bson.M{"_id": user.ID},
-		bson.M{"$set": bson.M{
-			"reset_token":            resetToken,
-			"reset_token_expires_at": resetExpiry,
-		}},
-	)
-	if err != nil {
-		return fmt.Errorf("failed to store reset token: %v", err)
-	}
-
-	// Send email (implement email service)
-	return as.sendPasswordResetEmailNotification(&user, resetToken)
-}
-
-// ResetPassword resets user password using token
-func (as *AuthService) ResetPassword(token, newPassword string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	// Find user by reset token
-	var user models.User
-	err := as.collections.Users().FindOne(ctx, bson.M{
-		"reset_token":            token,
-		"reset_token_expires_at": bson.M{"$gt": time.Now()},
-	}).Decode(&user)
-	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return errors.New("invalid or expired reset token")
-		}
-		return fmt.Errorf("database error: %v", err)
-	}
-
-	// Hash new password
-	hashedPassword, err := utils.HashPassword(newPassword)
-	if err != nil {
-		return fmt.Errorf("failed to hash password: %v", err)
-	}
-
-	// Update password and clear reset token
-	_, err = as.collections.Users().UpdateOne(ctx,
-		bson.M{"_id": user.ID},
-		bson.M{
-			"$set": bson.M{
-				"password":   hashedPassword,
-				"updated_at": time.Now(),
-			},
-			"$unset": bson.M{
-				"reset_token":            "",
-				"reset_token_expires_at": "",
-			},
-		},
-	)
-	if err != nil {
-		return fmt.Errorf("failed to update password: %v", err)
-	}
-
-	return nil
+// trackFailedLogin records a rejected login for anomaly detection
+func (as *AuthService) trackFailedLogin(email string, userID *primitive.ObjectID, clientIP string) {
+	NewAnalyticsService().TrackEventFrom("security", "login_failed", userID, map[string]interface{}{
+		"email": email,
+	}, utils.LocateClient(clientIP))
 }
 
 // VerifyEmail verifies user email using token
-func (as *AuthService) VerifyEmail(token string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (as *AuthService) VerifyEmail(ctx context.Context, token string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	// Find user by verification token
@@ -272,8 +238,8 @@ func (as *AuthService) VerifyEmail(token string) error {
 }
 
 // ResendVerificationEmail resends verification email
-func (as *AuthService) ResendVerificationEmail(email string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (as *AuthService) ResendVerificationEmail(ctx context.Context, email string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	// Find user by email
@@ -290,12 +256,12 @@ func (as *AuthService) ResendVerificationEmail(email string) error {
 	}
 
 	// Send verification email
-	return as.sendVerificationEmail(&user)
+	return as.sendVerificationEmail(ctx, &user)
 }
 
 // ChangePassword changes user password
-func (as *AuthService) ChangePassword(userID primitive.ObjectID, currentPassword, newPassword string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (as *AuthService) ChangePassword(ctx context.Context, userID primitive.ObjectID, currentPassword, newPassword string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	// Get user
@@ -310,6 +276,10 @@ func (as *AuthService) ChangePassword(userID primitive.ObjectID, currentPassword
 		return errors.New("current password is incorrect")
 	}
 
+	if err := NewSecurityPolicyService().ValidatePassword(ctx, newPassword, &user); err != nil {
+		return err
+	}
+
 	// Hash new password
 	hashedPassword, err := utils.HashPassword(newPassword)
 	if err != nil {
@@ -332,8 +302,8 @@ func (as *AuthService) ChangePassword(userID primitive.ObjectID, currentPassword
 }
 
 // DeleteAccount deletes user account
-func (as *AuthService) DeleteAccount(userID primitive.ObjectID, password string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (as *AuthService) DeleteAccount(ctx context.Context, userID primitive.ObjectID, password string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	// Get user
@@ -368,8 +338,8 @@ func (as *AuthService) DeleteAccount(userID primitive.ObjectID, password string)
 }
 
 // IsRegistrationAllowed checks if registration is enabled
-func (as *AuthService) IsRegistrationAllowed() bool {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (as *AuthService) IsRegistrationAllowed(ctx context.Context) bool {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	var setting models.AdminSettings
@@ -387,8 +357,8 @@ func (as *AuthService) IsRegistrationAllowed() bool {
 
 // Helper methods
 
-func (as *AuthService) getDefaultPlan() (*models.Plan, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (as *AuthService) getDefaultPlan(ctx context.Context) (*models.Plan, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	var plan models.Plan
@@ -413,8 +383,8 @@ func (as *AuthService) getDefaultPlan() (*models.Plan, error) {
 	return &plan, nil
 }
 
-func (as *AuthService) isEmailVerificationRequired() bool {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (as *AuthService) isEmailVerificationRequired(ctx context.Context) bool {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	var setting models.AdminSettings
@@ -430,7 +400,7 @@ func (as *AuthService) isEmailVerificationRequired() bool {
 	return true
 }
 
-func (as *AuthService) sendVerificationEmail(user *models.User) error {
+func (as *AuthService) sendVerificationEmail(ctx context.Context, user *models.User) error {
 	// Generate verification token
 	verificationToken, err := utils.GenerateSecureToken(32)
 	if err != nil {
@@ -438,7 +408,7 @@ func (as *AuthService) sendVerificationEmail(user *models.User) error {
 	}
 
 	// Store verification token
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	_, err = as.collections.Users().UpdateOne(ctx,
@@ -456,19 +426,8 @@ func (as *AuthService) sendVerificationEmail(user *models.User) error {
 	})
 }
 
-func (as *AuthService) sendPasswordResetEmailNotification(user *models.User, token string) error {
-	// Send email (implement email service)
-	return as.sendEmailNotification(user.Email, "reset", map[string]string{
-		"name":  user.FirstName + " " + user.LastName,
-		"token": token,
-	})
-}
-
 func (as *AuthService) sendEmailNotification(email, template string, data map[string]string) error {
-	// Implement email service integration
-	// This would integrate with services like SendGrid, AWS SES, etc.
-	fmt.Printf("Sending %s email to %s with data: %v\n", template, email, data)
-	return nil
+	return NewNotificationService().SendEmail(email, template, data)
 }
 
 func (as *AuthService) scheduleAccountCleanup(userID primitive.ObjectID) {
@@ -478,11 +437,11 @@ func (as *AuthService) scheduleAccountCleanup(userID primitive.ObjectID) {
 }
 
 // AdminLogin handles admin authentication
-func (as *AuthService) AdminLogin(email, password string) (*models.Admin, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (as *AuthService) AdminLogin(ctx context.Context, email, password string) (*models.Admin, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
-	adminCollection := database.GetCollection("admins")
+	adminCollection := as.collections.Collection("admins")
 
 	// Find admin by email
 	var admin models.Admin
@@ -516,13 +475,13 @@ func (as *AuthService) AdminLogin(email, password string) (*models.Admin, error)
 }
 
 // ValidateToken validates JWT token and returns user
-func (as *AuthService) ValidateToken(tokenString string) (*models.User, error) {
+func (as *AuthService) ValidateToken(ctx context.Context, tokenString string) (*models.User, error) {
 	claims, err := utils.ValidateToken(tokenString)
 	if err != nil {
 		return nil, err
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	var user models.User
@@ -540,16 +499,16 @@ func (as *AuthService) ValidateToken(tokenString string) (*models.User, error) {
 }
 
 // ValidateAdminToken validates admin JWT token and returns admin
-func (as *AuthService) ValidateAdminToken(tokenString string) (*models.Admin, error) {
+func (as *AuthService) ValidateAdminToken(ctx context.Context, tokenString string) (*models.Admin, error) {
 	claims, err := utils.ValidateAdminToken(tokenString)
 	if err != nil {
 		return nil, err
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	adminCollection := database.GetCollection("admins")
+	adminCollection := as.collections.Collection("admins")
 	var admin models.Admin
 	err = adminCollection.FindOne(ctx, bson.M{"_id": claims.AdminID}).Decode(&admin)
 	if err != nil {
@@ -565,8 +524,8 @@ func (as *AuthService) ValidateAdminToken(tokenString string) (*models.Admin, er
 }
 
 // GetUserByID retrieves user by ID
-func (as *AuthService) GetUserByID(userID primitive.ObjectID) (*models.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (as *AuthService) GetUserByID(ctx context.Context, userID primitive.ObjectID) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	var user models.User
@@ -580,11 +539,11 @@ func (as *AuthService) GetUserByID(userID primitive.ObjectID) (*models.User, err
 }
 
 // GetAdminByID retrieves admin by ID
-func (as *AuthService) GetAdminByID(adminID primitive.ObjectID) (*models.Admin, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (as *AuthService) GetAdminByID(ctx context.Context, adminID primitive.ObjectID) (*models.Admin, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	adminCollection := database.GetCollection("admins")
+	adminCollection := as.collections.Collection("admins")
 	var admin models.Admin
 	err := adminCollection.FindOne(ctx, bson.M{"_id": adminID}).Decode(&admin)
 	if err != nil {
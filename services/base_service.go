@@ -9,14 +9,22 @@ import (
 // BaseService provides common database access for all services
 type BaseService struct {
 	collections *database.Collections
-	manager     *database.Manager
+	manager     Database
+	clock       Clock
 }
 
 // NewBaseService creates a new base service instance
 func NewBaseService() *BaseService {
+	return NewBaseServiceWith(Dependencies{})
+}
+
+// NewBaseServiceWith creates a base service on the given dependencies
+func NewBaseServiceWith(deps Dependencies) *BaseService {
+	db := deps.database()
 	return &BaseService{
-		collections: database.NewCollections(),
-		manager:     database.GetManager(),
+		collections: database.NewCollectionsFrom(db),
+		manager:     db,
+		clock:       deps.clock(),
 	}
 }
 
@@ -0,0 +1,654 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"oncloud/models"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// disputeSuspensionReason is the suspension reason of users whose
+	// service a chargeback paused, so that only those are resumed when
+	// their disputes are settled
+	disputeSuspensionReason = "Payment disputed with the bank"
+
+	// Amounts closer than this are the same, as payments are in cents
+	amountTolerance = 0.005
+)
+
+var (
+	ErrPaymentGatewayNotConfigured = errors.New("no payment gateway is configured")
+	ErrPaymentNotFound             = notFoundError("payment not found")
+	ErrDisputeNotFound             = notFoundError("dispute not found")
+	ErrPaymentNotRefundable        = errors.New("the payment was not completed through the payment gateway")
+	ErrPaymentDisputed             = errors.New("the payment is disputed")
+	ErrPaymentFullyRefunded        = errors.New("the payment is already fully refunded")
+	ErrRefundExceedsPayment        = errors.New("the refund is more than is left of the payment")
+	ErrRefundFailed                = errors.New("the payment gateway did not make the refund")
+	ErrDisputeNotPaused            = errors.New("the dispute does not pause the user's service")
+)
+
+// RefundFilter narrows a refund listing
+type RefundFilter struct {
+	Status    string
+	PaymentID *primitive.ObjectID
+	UserID    *primitive.ObjectID
+}
+
+// DisputeFilter narrows a dispute listing
+type DisputeFilter struct {
+	Status string
+	UserID *primitive.ObjectID
+}
+
+// BillingService refunds payments through the payment gateway and follows
+// the chargebacks the gateway reports
+type BillingService struct {
+	*BaseService
+	auditService *AuditService
+}
+
+func NewBillingService() *BillingService {
+	return NewBillingServiceWith(Dependencies{})
+}
+
+// NewBillingServiceWith creates a billing service on the given dependencies
+func NewBillingServiceWith(deps Dependencies) *BillingService {
+	return &BillingService{
+		BaseService:  NewBaseServiceWith(deps),
+		auditService: NewAuditServiceWith(deps),
+	}
+}
+
+// IssueRefund refunds a payment through the payment gateway, all that is
+// left of it unless the request gives an amount, and records the refund
+// against the payment's invoice
+func (bs *BillingService) IssueRefund(ctx context.Context, paymentID primitive.ObjectID, req *models.RefundRequest, adminID primitive.ObjectID) (*models.Refund, error) {
+	gateway := activePaymentGateway
+	if gateway == nil {
+		return nil, ErrPaymentGatewayNotConfigured
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+
+	var payment models.Payment
+	if err := bs.collections.Payments().FindOne(ctx, bson.M{"_id": paymentID}).Decode(&payment); err != nil {
+		return nil, findError(err, ErrPaymentNotFound)
+	}
+	if payment.Status != "completed" || payment.TransactionID == "" {
+		return nil, ErrPaymentNotRefundable
+	}
+	if payment.Disputed {
+		return nil, ErrPaymentDisputed
+	}
+
+	remaining := roundAmount(payment.Amount - payment.AmountRefunded)
+	if remaining < amountTolerance {
+		return nil, ErrPaymentFullyRefunded
+	}
+	amount := remaining
+	if req.Amount > 0 {
+		amount = roundAmount(req.Amount)
+	}
+	if amount < amountTolerance || amount > remaining+amountTolerance {
+		return nil, ErrRefundExceedsPayment
+	}
+
+	// Set the amount aside on the payment first, so that refunds issued at
+	// the same time cannot give back more than was paid
+	result, err := bs.collections.Payments().UpdateOne(ctx,
+		bson.M{
+			"_id":      paymentID,
+			"status":   "completed",
+			"disputed": bson.M{"$ne": true},
+			"$expr": bson.M{"$lte": bson.A{
+				bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$amount_refunded", 0}}, amount}},
+				bson.M{"$add": bson.A{"$amount", amountTolerance}},
+			}},
+		},
+		bson.M{"$inc": bson.M{"amount_refunded": amount}},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update payment: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return nil, ErrRefundExceedsPayment
+	}
+
+	reason := req.Reason
+	if reason == "" {
+		reason = "requested_by_customer"
+	}
+	now := bs.clock.Now()
+	refund := &models.Refund{
+		ID:        primitive.NewObjectID(),
+		PaymentID: paymentID,
+		UserID:    payment.UserID,
+		InvoiceID: payment.InvoiceID,
+		Amount:    amount,
+		Currency:  payment.Currency,
+		Full:      amount > remaining-amountTolerance,
+		Reason:    reason,
+		Note:      req.Note,
+		Status:    models.RefundPending,
+		Gateway:   gateway.Name(),
+		IssuedBy:  adminID,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if _, err := bs.collections.Refunds().InsertOne(ctx, refund); err != nil {
+		bs.releaseRefundAmount(ctx, refund)
+		return nil, fmt.Errorf("failed to record refund: %v", err)
+	}
+
+	made, err := gateway.Refund(ctx, &payment, refund)
+	if err != nil {
+		bs.failRefund(ctx, refund, err.Error())
+		return nil, fmt.Errorf("%w: %v", ErrRefundFailed, err)
+	}
+	refund.GatewayRefundID = made.ID
+	if err := bs.settleRefund(ctx, refund, made.Status, made.FailureReason); err != nil {
+		return nil, err
+	}
+	if refund.Status == models.RefundFailed {
+		return nil, fmt.Errorf("%w: %s", ErrRefundFailed, refund.FailureReason)
+	}
+	return refund, nil
+}
+
+// settleRefund records the status the gateway gave a refund. A refund
+// that succeeded is recorded against the payment's invoice, and the amount
+// set aside for one that failed is given back to the payment.
+func (bs *BillingService) settleRefund(ctx context.Context, refund *models.Refund, status, failureReason string) error {
+	if status == models.RefundFailed {
+		if failureReason == "" {
+			failureReason = "refused by the payment gateway"
+		}
+		bs.failRefund(ctx, refund, failureReason)
+		return nil
+	}
+
+	refund.Status = status
+	refund.UpdatedAt = bs.clock.Now()
+	_, err := bs.collections.Refunds().UpdateOne(ctx,
+		bson.M{"_id": refund.ID},
+		bson.M{"$set": bson.M{
+			"status":            refund.Status,
+			"gateway_refund_id": refund.GatewayRefundID,
+			"updated_at":        refund.UpdatedAt,
+		}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update refund: %v", err)
+	}
+	if status != models.RefundSucceeded || refund.InvoiceID == nil {
+		return nil
+	}
+
+	var payment models.Payment
+	if err := bs.collections.Payments().FindOne(ctx, bson.M{"_id": refund.PaymentID}).Decode(&payment); err != nil {
+		return fmt.Errorf("failed to get payment: %v", err)
+	}
+	_, err = bs.collections.Invoices().UpdateOne(ctx,
+		bson.M{"_id": *refund.InvoiceID},
+		mongo.Pipeline{
+			{{Key: "$set", Value: bson.M{
+				"amount_refunded": bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$amount_refunded", 0}}, refund.Amount}},
+				"updated_at":      refund.UpdatedAt,
+			}}},
+			{{Key: "$set", Value: bson.M{
+				"refund_status": bson.M{"$cond": bson.A{
+					bson.M{"$gte": bson.A{"$amount_refunded", payment.Amount - amountTolerance}},
+					"refunded",
+					"partially_refunded",
+				}},
+			}}},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record refund on invoice: %v", err)
+	}
+	return nil
+}
+
+// failRefund records that a refund was not made and gives the amount set
+// aside for it back to the payment
+func (bs *BillingService) failRefund(ctx context.Context, refund *models.Refund, reason string) {
+	refund.Status = models.RefundFailed
+	refund.FailureReason = reason
+	refund.UpdatedAt = bs.clock.Now()
+	_, err := bs.collections.Refunds().UpdateOne(ctx,
+		bson.M{"_id": refund.ID},
+		bson.M{"$set": bson.M{
+			"status":            refund.Status,
+			"failure_reason":    refund.FailureReason,
+			"gateway_refund_id": refund.GatewayRefundID,
+			"updated_at":        refund.UpdatedAt,
+		}},
+	)
+	if err != nil {
+		log.Printf("Failed to record failed refund %s: %v", refund.ID.Hex(), err)
+	}
+	bs.releaseRefundAmount(ctx, refund)
+}
+
+func (bs *BillingService) releaseRefundAmount(ctx context.Context, refund *models.Refund) {
+	_, err := bs.collections.Payments().UpdateOne(ctx,
+		bson.M{"_id": refund.PaymentID},
+		bson.M{"$inc": bson.M{"amount_refunded": -refund.Amount}},
+	)
+	if err != nil {
+		log.Printf("Failed to release refund %s from payment %s: %v", refund.ID.Hex(), refund.PaymentID.Hex(), err)
+	}
+}
+
+// ListRefunds returns refunds, newest first
+func (bs *BillingService) ListRefunds(ctx context.Context, filter RefundFilter, page, limit int) ([]models.Refund, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	query := bson.M{}
+	if filter.Status != "" {
+		query["status"] = filter.Status
+	}
+	if filter.PaymentID != nil {
+		query["payment_id"] = *filter.PaymentID
+	}
+	if filter.UserID != nil {
+		query["user_id"] = *filter.UserID
+	}
+
+	cursor, err := bs.collections.Refunds().Find(ctx, query,
+		options.Find().
+			SetSort(bson.M{"created_at": -1}).
+			SetSkip(int64((page-1)*limit)).
+			SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	refunds := []models.Refund{}
+	if err := cursor.All(ctx, &refunds); err != nil {
+		return nil, 0, err
+	}
+
+	total, err := bs.collections.Refunds().CountDocuments(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return refunds, int(total), nil
+}
+
+// ListDisputes returns disputes, the most recently opened first
+func (bs *BillingService) ListDisputes(ctx context.Context, filter DisputeFilter, page, limit int) ([]models.Dispute, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	query := bson.M{}
+	if filter.Status != "" {
+		query["status"] = filter.Status
+	}
+	if filter.UserID != nil {
+		query["user_id"] = *filter.UserID
+	}
+
+	cursor, err := bs.collections.Disputes().Find(ctx, query,
+		options.Find().
+			SetSort(bson.M{"opened_at": -1}).
+			SetSkip(int64((page-1)*limit)).
+			SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	disputes := []models.Dispute{}
+	if err := cursor.All(ctx, &disputes); err != nil {
+		return nil, 0, err
+	}
+
+	total, err := bs.collections.Disputes().CountDocuments(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return disputes, int(total), nil
+}
+
+// ReleaseDispute lifts the pause a dispute put on its user's service. The
+// service resumes unless another dispute pauses it too.
+func (bs *BillingService) ReleaseDispute(ctx context.Context, disputeID, adminID primitive.ObjectID) (*models.Dispute, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	now := bs.clock.Now()
+	var dispute models.Dispute
+	err := bs.collections.Disputes().FindOneAndUpdate(ctx,
+		bson.M{"_id": disputeID, "account_paused": true},
+		bson.M{"$set": bson.M{
+			"account_paused": false,
+			"released_by":    adminID,
+			"released_at":    now,
+			"updated_at":     now,
+		}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&dispute)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		count, countErr := bs.collections.Disputes().CountDocuments(ctx, bson.M{"_id": disputeID})
+		if countErr == nil && count > 0 {
+			return nil, ErrDisputeNotPaused
+		}
+		return nil, ErrDisputeNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if dispute.UserID != nil {
+		if err := bs.resumeService(ctx, *dispute.UserID); err != nil {
+			return nil, err
+		}
+	}
+	return &dispute, nil
+}
+
+// stripeDisputeEvent is a charge.dispute.* webhook event
+type stripeDisputeEvent struct {
+	Type string `json:"type"`
+	Data struct {
+		Object struct {
+			ID            string `json:"id"`
+			Charge        string `json:"charge"`
+			PaymentIntent string `json:"payment_intent"`
+			Amount        int64  `json:"amount"`
+			Currency      string `json:"currency"`
+			Reason        string `json:"reason"`
+			Status        string `json:"status"`
+			Created       int64  `json:"created"`
+		} `json:"object"`
+	} `json:"data"`
+}
+
+// HandleStripeDispute records a chargeback Stripe reported, flags the
+// disputed payment's user and pauses or resumes their service as the
+// chargeback policy says
+func (bs *BillingService) HandleStripeDispute(ctx context.Context, payload []byte) error {
+	var event stripeDisputeEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return fmt.Errorf("invalid dispute event: %v", err)
+	}
+	object := event.Data.Object
+	if object.ID == "" {
+		return fmt.Errorf("dispute event without a dispute")
+	}
+
+	status := models.DisputeOpen
+	switch object.Status {
+	case "won", "warning_closed":
+		status = models.DisputeWon
+	case "lost":
+		status = models.DisputeLost
+	}
+
+	var payment *models.Payment
+	transactions := bson.A{}
+	for _, id := range []string{object.Charge, object.PaymentIntent} {
+		if id != "" {
+			transactions = append(transactions, id)
+		}
+	}
+	if len(transactions) > 0 {
+		var found models.Payment
+		err := bs.collections.Payments().FindOne(ctx, bson.M{"transaction_id": bson.M{"$in": transactions}}).Decode(&found)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			return fmt.Errorf("failed to get payment: %v", err)
+		}
+		if err == nil {
+			payment = &found
+		}
+	}
+
+	now := bs.clock.Now()
+	openedAt := now
+	if object.Created > 0 {
+		openedAt = time.Unix(object.Created, 0)
+	}
+	set := bson.M{
+		"transaction_id": object.Charge,
+		"amount":         fromStripeAmount(object.Amount, object.Currency),
+		"currency":       strings.ToUpper(object.Currency),
+		"reason":         object.Reason,
+		"status":         status,
+		"gateway_status": object.Status,
+		"updated_at":     now,
+	}
+	if payment != nil {
+		set["payment_id"] = payment.ID
+		set["user_id"] = payment.UserID
+	}
+	if status != models.DisputeOpen {
+		set["closed_at"] = now
+	}
+
+	var dispute models.Dispute
+	err := bs.collections.Disputes().FindOneAndUpdate(ctx,
+		bson.M{"gateway": PaymentGatewayStripe, "gateway_dispute_id": object.ID},
+		bson.M{
+			"$set": set,
+			"$setOnInsert": bson.M{
+				"_id":            primitive.NewObjectID(),
+				"account_paused": false,
+				"opened_at":      openedAt,
+				"created_at":     now,
+			},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&dispute)
+	if err != nil {
+		return fmt.Errorf("failed to record dispute: %v", err)
+	}
+
+	if payment == nil {
+		log.Printf("Dispute %s is about a charge with no recorded payment: %s", object.ID, object.Charge)
+		return nil
+	}
+
+	_, err = bs.collections.Payments().UpdateOne(ctx,
+		bson.M{"_id": payment.ID},
+		bson.M{"$set": bson.M{"disputed": status != models.DisputeWon}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to flag payment: %v", err)
+	}
+
+	if status == models.DisputeWon {
+		return bs.settleWonDispute(ctx, &dispute)
+	}
+
+	// Users are flagged for every chargeback, whatever the policy
+	_, err = bs.collections.Users().UpdateOne(ctx,
+		bson.M{"_id": payment.UserID},
+		bson.M{"$set": bson.M{"chargeback_at": now}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to flag user: %v", err)
+	}
+
+	pause := chargebackPause == ChargebackPauseOpened || (chargebackPause == ChargebackPauseLost && status == models.DisputeLost)
+	if !pause || dispute.AccountPaused || dispute.ReleasedAt != nil {
+		return nil
+	}
+	return bs.pauseService(ctx, &dispute)
+}
+
+// pauseService suspends the dispute's user, unless they are suspended
+// already for another reason. A read-only account keeps its state and goes
+// back to it when resumed.
+func (bs *BillingService) pauseService(ctx context.Context, dispute *models.Dispute) error {
+	now := bs.clock.Now()
+	result, err := bs.collections.Users().UpdateOne(ctx,
+		bson.M{"_id": *dispute.UserID, "suspended_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{
+			"is_active":         false,
+			"suspension_reason": disputeSuspensionReason,
+			"suspended_at":      now,
+			"state_changed_at":  now,
+		}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to pause user: %v", err)
+	}
+	if result.ModifiedCount == 0 {
+		return nil
+	}
+
+	_, err = bs.collections.Disputes().UpdateOne(ctx,
+		bson.M{"_id": dispute.ID},
+		bson.M{"$set": bson.M{"account_paused": true, "updated_at": now}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update dispute: %v", err)
+	}
+
+	bs.recordDisputeAction(ctx, "user.service_paused", dispute)
+	return nil
+}
+
+// settleWonDispute resumes the service a won dispute paused, and lifts the
+// user's flag when none of their chargebacks stand any more
+func (bs *BillingService) settleWonDispute(ctx context.Context, dispute *models.Dispute) error {
+	if dispute.AccountPaused {
+		_, err := bs.collections.Disputes().UpdateOne(ctx,
+			bson.M{"_id": dispute.ID},
+			bson.M{"$set": bson.M{"account_paused": false, "updated_at": bs.clock.Now()}},
+		)
+		if err != nil {
+			return fmt.Errorf("failed to update dispute: %v", err)
+		}
+		if err := bs.resumeService(ctx, *dispute.UserID); err != nil {
+			return err
+		}
+		bs.recordDisputeAction(ctx, "user.service_resumed", dispute)
+	}
+
+	standing, err := bs.collections.Disputes().CountDocuments(ctx, bson.M{
+		"user_id": *dispute.UserID,
+		"status":  bson.M{"$ne": models.DisputeWon},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to count disputes: %v", err)
+	}
+	if standing == 0 {
+		_, err = bs.collections.Users().UpdateOne(ctx,
+			bson.M{"_id": *dispute.UserID},
+			bson.M{"$unset": bson.M{"chargeback_at": ""}},
+		)
+		if err != nil {
+			return fmt.Errorf("failed to unflag user: %v", err)
+		}
+	}
+	return nil
+}
+
+// resumeService lifts the suspension chargebacks put on a user once none
+// of their disputes pauses their service
+func (bs *BillingService) resumeService(ctx context.Context, userID primitive.ObjectID) error {
+	paused, err := bs.collections.Disputes().CountDocuments(ctx, bson.M{"user_id": userID, "account_paused": true})
+	if err != nil {
+		return fmt.Errorf("failed to count disputes: %v", err)
+	}
+	if paused > 0 {
+		return nil
+	}
+
+	_, err = bs.collections.Users().UpdateOne(ctx,
+		bson.M{"_id": userID, "suspension_reason": disputeSuspensionReason},
+		bson.M{
+			"$set": bson.M{"is_active": true, "state_changed_at": bs.clock.Now()},
+			"$unset": bson.M{
+				"suspension_reason": "",
+				"suspended_at":      "",
+			},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to resume user: %v", err)
+	}
+	return nil
+}
+
+// stripeRefundEvent is a charge.refund.updated webhook event
+type stripeRefundEvent struct {
+	Data struct {
+		Object struct {
+			ID            string `json:"id"`
+			Status        string `json:"status"`
+			FailureReason string `json:"failure_reason"`
+		} `json:"object"`
+	} `json:"data"`
+}
+
+// HandleStripeRefund settles a refund the gateway left pending once Stripe
+// reports it made or failed
+func (bs *BillingService) HandleStripeRefund(ctx context.Context, payload []byte) error {
+	var event stripeRefundEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return fmt.Errorf("invalid refund event: %v", err)
+	}
+	object := event.Data.Object
+	status := stripeRefundStatus(object.Status)
+	if object.ID == "" || status == models.RefundPending {
+		return nil
+	}
+
+	// Only a pending refund is settled, once however often the event is
+	// delivered
+	var refund models.Refund
+	err := bs.collections.Refunds().FindOneAndUpdate(ctx,
+		bson.M{"gateway": PaymentGatewayStripe, "gateway_refund_id": object.ID, "status": models.RefundPending},
+		bson.M{"$set": bson.M{"status": status, "updated_at": bs.clock.Now()}},
+	).Decode(&refund)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get refund: %v", err)
+	}
+	return bs.settleRefund(ctx, &refund, status, object.FailureReason)
+}
+
+func (bs *BillingService) recordDisputeAction(ctx context.Context, action string, dispute *models.Dispute) {
+	bs.auditService.Record(ctx, &models.AuditLog{
+		ActorType:    "system",
+		Action:       action,
+		ResourceType: "user",
+		ResourceID:   dispute.UserID.Hex(),
+		Outcome:      "success",
+		Details: map[string]interface{}{
+			"dispute_id":         dispute.ID.Hex(),
+			"gateway_dispute_id": dispute.GatewayDisputeID,
+			"status":             dispute.Status,
+		},
+	})
+}
+
+// roundAmount rounds an amount to cents
+func roundAmount(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
@@ -0,0 +1,324 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"log"
+	"oncloud/database"
+	"oncloud/models"
+	"oncloud/utils"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultPrimaryColor = "#2563eb"
+	defaultAccentColor  = "#0ea5e9"
+
+	// The branding is reloaded this often, so changes made through another
+	// instance are picked up
+	brandingTTL = 30 * time.Second
+)
+
+var (
+	// ErrInvalidBranding wraps any rejected branding field
+	ErrInvalidBranding = errors.New("invalid branding")
+	ErrUnknownEmail    = errors.New("unknown email template")
+)
+
+// BrandingOptions holds the branding used until an admin customizes it
+type BrandingOptions struct {
+	ProductName string
+}
+
+var brandingOptions = BrandingOptions{ProductName: "OnCloud"}
+
+// brandingCache holds the stored branding, shared by every service instance
+var brandingCache struct {
+	sync.RWMutex
+	branding *models.Branding
+	loadedAt time.Time
+}
+
+// InitBranding sets the default branding
+func InitBranding(opts BrandingOptions) {
+	if opts.ProductName != "" {
+		brandingOptions = opts
+	}
+}
+
+// emailTemplates are the notification emails, as a subject and a plain text
+// body. The HTML version is built from the body.
+var emailTemplates = map[string]struct{ subject, body string }{
+	"verify": {
+		subject: "Verify your {{.product}} email address",
+		body:    "Hi {{.name}},\n\nUse this code to verify your email address:\n\n{{.token}}\n\nIf you did not create a {{.product}} account, you can ignore this email.",
+	},
+	"reset": {
+		subject: "Reset your {{.product}} password",
+		body:    "Hi {{.name}},\n\n{{if .link}}Open this link to choose a new password:\n\n{{.link}}{{else}}Use this code to choose a new password:\n\n{{.token}}{{end}}\n\nIt works once{{if .expires}}, for {{.expires}}{{end}}. If you did not ask to reset your password, you can ignore this email.",
+	},
+	"password_changed": {
+		subject: "Your {{.product}} password was changed",
+		body:    "Hi {{.name}},\n\nThe password of your account was reset at {{.time}} and every device signed in to it was signed out.\n\nIf you did not do this, reset your password again and contact support.",
+	},
+	"login_verification": {
+		subject: "Your {{.product}} sign-in code",
+		body:    "Hi {{.name}},\n\nSomeone is signing in to your account from {{.device}}{{if .location}} in {{.location}}{{end}}. If this was you, enter this code:\n\n{{.code}}\n\nIf it was not, change your password.",
+	},
+	"new_login": {
+		subject: "New sign-in to your {{.product}} account",
+		body:    "Hi {{.name}},\n\nYour account was signed in to from {{.device}}{{if .location}} in {{.location}}{{end}} at {{.time}}.\n\nIf this was not you, change your password.",
+	},
+	"announcement": {
+		subject: "{{.title}}",
+		body:    "Hi {{.name}},\n\n{{.message}}{{if .link}}\n\n{{.link}}{{end}}",
+	},
+	"takedown_notice": {
+		subject: "{{.item}} was taken down after a copyright notice",
+		body:    "Hi {{.name}},\n\nWe received a DMCA notice from {{.claimant}} claiming that {{.item}} infringes their copyright in: {{.work}}\n\nShare links to it were disabled and it cannot be shared again while the case ({{.case}}) is open.\n\nIf you believe it was taken down by mistake or misidentification, you may send a counter notice before {{.due}}. It must include your name, address, phone number and email, a statement under penalty of perjury that you have a good faith belief the content was removed by mistake, your consent to the jurisdiction of the federal court for your address, and your signature. Counter notices are forwarded to the claimant, and the content is restored after 14 days unless they tell us they have filed a court action.",
+	},
+	"takedown_counter_notice": {
+		subject: "Counter notice received for your {{.product}} takedown notice",
+		body:    "Hi {{.name}},\n\nThe owner of the content in case {{.case}}, which you asked us to take down for infringing {{.work}}, sent us a counter notice:\n\nName: {{.counter_name}}\nAddress: {{.counter_address}}\nPhone: {{.counter_phone}}\nEmail: {{.counter_email}}\n\n{{.statement}}\n\nThe content will be restored on {{.restore_at}} unless you tell us before then that you have filed a court action to stop the infringement.",
+	},
+	"file_expiring": {
+		subject: "{{.item}} expires on {{.expires}}",
+		body:    "Hi {{.name}},\n\n{{.item}} expires on {{.expires}}. It can no longer be opened or downloaded after that, links to it stop working, and it is moved to the trash.\n\nTo keep it, extend or remove its expiry before then.",
+	},
+	"takedown_closed": {
+		subject: "Takedown case {{.case}} is closed",
+		body:    "Hi {{.name}},\n\nThe takedown case about {{.item}} is closed: {{.outcome}}",
+	},
+	"storage_report": {
+		subject: "Your {{.product}} storage in {{.month}}",
+		body:    "Hi {{.name}},\n\nYou stored {{.storage}} at the end of {{.month}}{{if .change}}, {{.change}} since the month before{{end}}, and used {{.bandwidth}} of bandwidth.{{if .advice}}\n\n{{.advice}}{{end}}",
+	},
+}
+
+var emailLayout = htmltemplate.Must(htmltemplate.New("email").Parse(`<!DOCTYPE html>
+<html>
+<body style="margin:0;padding:24px;background:#f4f4f5;font-family:Arial,sans-serif;color:#18181b">
+<div style="max-width:560px;margin:0 auto;background:#ffffff;border-top:4px solid {{.Branding.PrimaryColor}};padding:24px">
+{{if .Branding.LogoURL}}<img src="{{.Branding.LogoURL}}" alt="{{.Branding.ProductName}}" style="max-height:40px;margin-bottom:16px">{{else}}<h2 style="color:{{.Branding.PrimaryColor}};margin-top:0">{{.Branding.ProductName}}</h2>{{end}}
+{{range .Paragraphs}}<p style="line-height:1.5">{{.}}</p>
+{{end}}{{range .Sections}}<h3 style="margin:24px 0 8px;font-size:15px;color:{{$.Branding.PrimaryColor}}">{{.Title}}</h3>
+<table style="width:100%;border-collapse:collapse;font-size:14px">
+{{range .Rows}}<tr><td style="padding:6px 0;border-bottom:1px solid #e4e4e7;color:#52525b">{{.Label}}</td><td style="padding:6px 0 6px 12px;border-bottom:1px solid #e4e4e7;text-align:right;white-space:nowrap">{{.Value}}</td></tr>
+{{end}}</table>
+{{end}}</div>
+{{if .Branding.EmailFooter}}<p style="max-width:560px;margin:16px auto 0;font-size:12px;color:#71717a;white-space:pre-line">{{.Branding.EmailFooter}}</p>{{end}}
+{{if .Unsubscribe}}<p style="max-width:560px;margin:8px auto 0;font-size:12px;color:#71717a"><a href="{{.Unsubscribe}}" style="color:#71717a">Unsubscribe</a> from these emails</p>{{end}}
+</body>
+</html>
+`))
+
+type BrandingService struct {
+	*BaseService
+}
+
+func NewBrandingService() *BrandingService {
+	return NewBrandingServiceWith(Dependencies{})
+}
+
+// NewBrandingServiceWith creates a branding service on the given
+// dependencies
+func NewBrandingServiceWith(deps Dependencies) *BrandingService {
+	return &BrandingService{
+		BaseService: NewBaseServiceWith(deps),
+	}
+}
+
+// CurrentBranding returns the branding with defaults filled in, for use in
+// templates
+func CurrentBranding() *models.Branding {
+	brandingCache.RLock()
+	branding, loadedAt := brandingCache.branding, brandingCache.loadedAt
+	brandingCache.RUnlock()
+	if branding != nil && time.Since(loadedAt) < brandingTTL {
+		return branding
+	}
+
+	brandingCache.Lock()
+	defer brandingCache.Unlock()
+	if brandingCache.branding != nil && time.Since(brandingCache.loadedAt) < brandingTTL {
+		return brandingCache.branding
+	}
+
+	stored, err := loadBranding(context.Background(), database.GetCollection(database.BrandingCollection))
+	if err != nil {
+		log.Printf("Failed to load branding: %v", err)
+		if brandingCache.branding != nil {
+			stored = brandingCache.branding
+		}
+	}
+	brandingCache.branding = withBrandingDefaults(stored)
+	brandingCache.loadedAt = time.Now()
+	return brandingCache.branding
+}
+
+// invalidateBranding makes the next read reload the branding
+func invalidateBranding() {
+	brandingCache.Lock()
+	brandingCache.loadedAt = time.Time{}
+	brandingCache.Unlock()
+}
+
+func loadBranding(ctx context.Context, collection *mongo.Collection) (*models.Branding, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var branding models.Branding
+	err := collection.FindOne(ctx, bson.M{}).Decode(&branding)
+	if err == mongo.ErrNoDocuments {
+		return &models.Branding{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &branding, nil
+}
+
+func withBrandingDefaults(stored *models.Branding) *models.Branding {
+	branding := models.Branding{}
+	if stored != nil {
+		branding = *stored
+	}
+	if branding.ProductName == "" {
+		branding.ProductName = brandingOptions.ProductName
+	}
+	if branding.PrimaryColor == "" {
+		branding.PrimaryColor = defaultPrimaryColor
+	}
+	if branding.AccentColor == "" {
+		branding.AccentColor = defaultAccentColor
+	}
+	return &branding
+}
+
+// GetBranding returns the stored branding without defaults, as admins edit it
+func (bs *BrandingService) GetBranding(ctx context.Context) (*models.Branding, error) {
+	return loadBranding(ctx, bs.collections.Branding())
+}
+
+// UpdateBranding replaces the branding
+func (bs *BrandingService) UpdateBranding(ctx context.Context, req *models.BrandingRequest, adminID primitive.ObjectID) (*models.Branding, error) {
+	if err := utils.ValidateStruct(req); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBranding, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	branding := &models.Branding{
+		ProductName:   strings.TrimSpace(req.ProductName),
+		LogoURL:       strings.TrimSpace(req.LogoURL),
+		PrimaryColor:  req.PrimaryColor,
+		AccentColor:   req.AccentColor,
+		EmailFooter:   strings.TrimSpace(req.EmailFooter),
+		SharePageHTML: req.SharePageHTML,
+		UpdatedBy:     adminID,
+		UpdatedAt:     &now,
+	}
+	_, err := bs.collections.Branding().ReplaceOne(ctx, bson.M{}, branding, options.Replace().SetUpsert(true))
+	if err != nil {
+		return nil, fmt.Errorf("failed to update branding: %v", err)
+	}
+
+	invalidateBranding()
+	return branding, nil
+}
+
+// ResetBranding removes all customization
+func (bs *BrandingService) ResetBranding(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if _, err := bs.collections.Branding().DeleteMany(ctx, bson.M{}); err != nil {
+		return fmt.Errorf("failed to reset branding: %v", err)
+	}
+
+	invalidateBranding()
+	return nil
+}
+
+// RenderEmail renders a notification email with the deployment's branding
+func (bs *BrandingService) RenderEmail(name string, data map[string]string) (*models.EmailMessage, error) {
+	return bs.RenderEmailWithSections(name, data, nil)
+}
+
+// RenderEmailWithSections renders a notification email with tables of
+// figures below its text. An "unsubscribe" link in data is shown at the
+// bottom.
+func (bs *BrandingService) RenderEmailWithSections(name string, data map[string]string, sections []models.EmailSection) (*models.EmailMessage, error) {
+	tmpl, exists := emailTemplates[name]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownEmail, name)
+	}
+
+	branding := CurrentBranding()
+	values := map[string]string{"product": branding.ProductName}
+	for key, value := range data {
+		values[key] = value
+	}
+
+	subject, err := executeTextTemplate(tmpl.subject, values)
+	if err != nil {
+		return nil, err
+	}
+	body, err := executeTextTemplate(tmpl.body, values)
+	if err != nil {
+		return nil, err
+	}
+
+	var text strings.Builder
+	text.WriteString(body)
+	for _, section := range sections {
+		fmt.Fprintf(&text, "\n\n%s", section.Title)
+		for _, row := range section.Rows {
+			fmt.Fprintf(&text, "\n  %s: %s", row.Label, row.Value)
+		}
+	}
+	if branding.EmailFooter != "" {
+		text.WriteString("\n\n--\n" + branding.EmailFooter)
+	}
+	if values["unsubscribe"] != "" {
+		text.WriteString("\n\nUnsubscribe: " + values["unsubscribe"])
+	}
+
+	var html bytes.Buffer
+	err = emailLayout.Execute(&html, map[string]interface{}{
+		"Branding":    branding,
+		"Paragraphs":  strings.Split(body, "\n\n"),
+		"Sections":    sections,
+		"Unsubscribe": values["unsubscribe"],
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to render email: %v", err)
+	}
+
+	return &models.EmailMessage{Subject: subject, Text: text.String(), HTML: html.String()}, nil
+}
+
+func executeTextTemplate(text string, data map[string]string) (string, error) {
+	tmpl, err := template.New("").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse email template: %v", err)
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", fmt.Errorf("failed to render email: %v", err)
+	}
+	return out.String(), nil
+}
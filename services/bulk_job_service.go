@@ -0,0 +1,411 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"oncloud/models"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// bulkWorkers bounds how many items of a bulk operation run at once
+	bulkWorkers = 8
+	// bulkJobThreshold is the most items run within the request; larger
+	// batches run as a background job
+	bulkJobThreshold = 50
+	// bulkJobStaleAfter is how long a running job goes without progress
+	// before it is taken to be interrupted
+	bulkJobStaleAfter = 2 * time.Minute
+)
+
+var (
+	ErrBulkJobNotFound          = notFoundError("bulk job not found")
+	ErrBulkOperationUnsupported = errors.New("unsupported bulk operation")
+)
+
+type BulkJobService struct {
+	*BaseService
+	fileService   *FileService
+	folderService *FolderService
+}
+
+func NewBulkJobService() *BulkJobService {
+	return NewBulkJobServiceWith(Dependencies{})
+}
+
+// NewBulkJobServiceWith creates a bulk job service on the given dependencies
+func NewBulkJobServiceWith(deps Dependencies) *BulkJobService {
+	return &BulkJobService{
+		BaseService:   NewBaseServiceWith(deps),
+		fileService:   NewFileServiceWith(deps),
+		folderService: NewFolderServiceWith(deps),
+	}
+}
+
+// Run runs a bulk operation on job.ItemIDs. Small batches run straight away
+// and their results are returned; larger ones are saved as a job which runs
+// in the background, and the job is returned instead.
+func (bs *BulkJobService) Run(ctx context.Context, job *models.BulkJob) (map[string]interface{}, *models.BulkJob, error) {
+	if len(job.ItemIDs) > bulkJobThreshold {
+		job, err := bs.queueJob(ctx, job)
+		return nil, job, err
+	}
+
+	outcomes := make([]models.BulkItemResult, len(job.ItemIDs))
+	if err := bs.process(ctx, job, job.ItemIDs, func(index int, outcome models.BulkItemResult) {
+		outcomes[index] = outcome
+	}); err != nil {
+		return nil, nil, err
+	}
+
+	results := map[string]interface{}{
+		"success": 0,
+		"failed":  0,
+		"errors":  []string{},
+	}
+	shares := []string{}
+	for _, outcome := range outcomes {
+		if outcome.Error != "" {
+			results["failed"] = results["failed"].(int) + 1
+			results["errors"] = append(results["errors"].([]string), outcome.Error)
+			continue
+		}
+		results["success"] = results["success"].(int) + 1
+		if outcome.Token != "" {
+			shares = append(shares, outcome.Token)
+		}
+	}
+	if job.Operation == models.BulkShareFiles || job.Operation == models.BulkShareFolders {
+		results["shares"] = shares
+	}
+
+	return results, nil, nil
+}
+
+// GetJobs returns the user's bulk jobs, newest first, without their items
+func (bs *BulkJobService) GetJobs(ctx context.Context, userID primitive.ObjectID, page, limit int) ([]models.BulkJob, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"user_id": userID}
+	cursor, err := bs.collections.BulkJobs().Find(ctx, filter,
+		options.Find().
+			SetSort(bson.M{"created_at": -1}).
+			SetSkip(int64((page-1)*limit)).
+			SetLimit(int64(limit)).
+			SetProjection(bson.M{"items": 0, "item_ids": 0}),
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	jobs := []models.BulkJob{}
+	if err := cursor.All(ctx, &jobs); err != nil {
+		return nil, 0, err
+	}
+
+	total, err := bs.collections.BulkJobs().CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return jobs, int(total), nil
+}
+
+// GetJob returns one of the user's bulk jobs with the outcomes of the items
+// which finished after the first after items
+func (bs *BulkJobService) GetJob(ctx context.Context, userID, jobID primitive.ObjectID, after int) (*models.BulkJob, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	projection := bson.M{"item_ids": 0}
+	if after > 0 {
+		// Bulk requests name at most 1000 items
+		projection["items"] = bson.M{"$slice": bson.A{after, 1000}}
+	}
+
+	var job models.BulkJob
+	err := bs.collections.BulkJobs().FindOne(ctx,
+		bson.M{"_id": jobID, "user_id": userID},
+		options.FindOne().SetProjection(projection),
+	).Decode(&job)
+	if err != nil {
+		return nil, ErrBulkJobNotFound
+	}
+	if job.Items == nil {
+		job.Items = []models.BulkItemResult{}
+	}
+	return &job, nil
+}
+
+// ResumeJobs continues bulk jobs left unfinished when the server stopped
+// with the items they had not reached
+func (bs *BulkJobService) ResumeJobs(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	cursor, err := bs.collections.BulkJobs().Find(ctx,
+		bson.M{"status": models.BulkJobRunning, "updated_at": bson.M{"$lt": time.Now().Add(-bulkJobStaleAfter)}},
+		options.Find().SetProjection(bson.M{"_id": 1, "updated_at": 1}),
+	)
+	if err != nil {
+		log.Printf("Failed to find unfinished bulk jobs: %v", err)
+		return
+	}
+	var jobs []struct {
+		ID        primitive.ObjectID `bson:"_id"`
+		UpdatedAt time.Time          `bson:"updated_at"`
+	}
+	if err := cursor.All(ctx, &jobs); err != nil {
+		log.Printf("Failed to find unfinished bulk jobs: %v", err)
+		return
+	}
+
+	for _, stale := range jobs {
+		// Claim the job so only one server resumes it
+		var job models.BulkJob
+		err := bs.collections.BulkJobs().FindOneAndUpdate(ctx,
+			bson.M{"_id": stale.ID, "status": models.BulkJobRunning, "updated_at": stale.UpdatedAt},
+			bson.M{"$set": bson.M{"updated_at": time.Now()}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&job)
+		if err != nil {
+			continue
+		}
+		go bs.runJob(context.Background(), &job)
+	}
+}
+
+func (bs *BulkJobService) queueJob(ctx context.Context, job *models.BulkJob) (*models.BulkJob, error) {
+	now := time.Now()
+	job.ID = primitive.NewObjectID()
+	job.Status = models.BulkJobRunning
+	job.Total = len(job.ItemIDs)
+	job.Items = []models.BulkItemResult{}
+	job.CreatedAt = now
+	job.UpdatedAt = now
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if _, err := bs.collections.BulkJobs().InsertOne(ctx, job); err != nil {
+		return nil, fmt.Errorf("failed to create bulk job: %v", err)
+	}
+
+	go bs.runJob(context.Background(), job)
+
+	return job, nil
+}
+
+// runJob runs the items of job without an outcome yet, recording each
+// outcome as it finishes
+func (bs *BulkJobService) runJob(ctx context.Context, job *models.BulkJob) {
+	done := make(map[primitive.ObjectID]bool, len(job.Items))
+	for _, item := range job.Items {
+		done[item.ID] = true
+	}
+	remaining := make([]primitive.ObjectID, 0, len(job.ItemIDs))
+	for _, id := range job.ItemIDs {
+		if !done[id] {
+			remaining = append(remaining, id)
+		}
+	}
+
+	err := bs.process(ctx, job, remaining, func(index int, outcome models.BulkItemResult) {
+		counter := "succeeded"
+		if outcome.Error != "" {
+			counter = "failed"
+		}
+		bs.updateJob(ctx, job.ID, bson.M{
+			"$push": bson.M{"items": outcome},
+			"$inc":  bson.M{counter: 1},
+		})
+	})
+
+	// The share settings, which may hold a password, are not kept
+	set := bson.M{"status": models.BulkJobCompleted, "completed_at": time.Now()}
+	if err != nil {
+		log.Printf("Bulk job %s failed: %v", job.ID.Hex(), err)
+		set["status"] = models.BulkJobFailed
+		set["error"] = err.Error()
+	}
+	bs.updateJob(ctx, job.ID, bson.M{"$set": set, "$unset": bson.M{"share_data": ""}})
+}
+
+// process runs the operation on ids with a bounded pool of workers and
+// calls onItem, from any worker, as each item finishes. Folders which could
+// interfere, one inside the other or sharing a name, run one after another
+// in the order given.
+func (bs *BulkJobService) process(ctx context.Context, job *models.BulkJob, ids []primitive.ObjectID, onItem func(index int, outcome models.BulkItemResult)) error {
+	lanes, err := bs.lanes(ctx, job, ids)
+	if err != nil {
+		return err
+	}
+
+	queue := make(chan []int)
+	var wg sync.WaitGroup
+	for i := 0; i < bulkWorkers && i < len(lanes); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for lane := range queue {
+				for _, index := range lane {
+					outcome := models.BulkItemResult{ID: ids[index]}
+					token, err := bs.runItem(ctx, job, ids[index])
+					if err != nil {
+						outcome.Error = err.Error()
+					}
+					outcome.Token = token
+					onItem(index, outcome)
+				}
+			}
+		}()
+	}
+	for _, lane := range lanes {
+		queue <- lane
+	}
+	close(queue)
+	wg.Wait()
+
+	return nil
+}
+
+// lanes splits ids into groups to be run in order, each by one worker.
+// Different files are independent of each other. Folders are grouped with
+// the folders of the batch they are inside of, and with those of the same
+// name, which a move or copy into one destination has to check in turn.
+func (bs *BulkJobService) lanes(ctx context.Context, job *models.BulkJob, ids []primitive.ObjectID) ([][]int, error) {
+	parent := make([]int, len(ids))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	// The same item named twice runs twice, one after the other
+	first := make(map[primitive.ObjectID]int, len(ids))
+	for i, id := range ids {
+		if other, ok := first[id]; ok {
+			parent[find(i)] = find(other)
+		} else {
+			first[id] = i
+		}
+	}
+
+	switch job.Operation {
+	case models.BulkDeleteFolders, models.BulkMoveFolders, models.BulkCopyFolders, models.BulkShareFolders:
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+
+		cursor, err := bs.collections.Folders().Find(ctx,
+			bson.M{"_id": bson.M{"$in": ids}, "user_id": job.UserID},
+			options.Find().SetProjection(bson.M{"name": 1, "ancestors": 1}),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load folders: %v", err)
+		}
+		var folders []models.Folder
+		if err := cursor.All(ctx, &folders); err != nil {
+			return nil, fmt.Errorf("failed to load folders: %v", err)
+		}
+
+		byName := map[string]int{}
+		for _, folder := range folders {
+			i := first[folder.ID]
+			if other, ok := byName[folder.Name]; ok {
+				parent[find(i)] = find(other)
+			} else {
+				byName[folder.Name] = i
+			}
+			for _, ancestor := range folder.Ancestors {
+				if other, ok := first[ancestor]; ok {
+					parent[find(i)] = find(other)
+				}
+			}
+		}
+	}
+
+	groups := map[int][]int{}
+	var roots []int
+	for i := range ids {
+		root := find(i)
+		if _, ok := groups[root]; !ok {
+			roots = append(roots, root)
+		}
+		groups[root] = append(groups[root], i)
+	}
+	lanes := make([][]int, 0, len(roots))
+	for _, root := range roots {
+		lanes = append(lanes, groups[root])
+	}
+	return lanes, nil
+}
+
+// runItem runs job's operation on one item, returning the share token of a
+// shared item
+func (bs *BulkJobService) runItem(ctx context.Context, job *models.BulkJob, id primitive.ObjectID) (string, error) {
+	switch job.Operation {
+	case models.BulkDeleteFiles:
+		return "", bs.fileService.DeleteFile(ctx, job.UserID, id, false)
+	case models.BulkMoveFiles:
+		return "", bs.fileService.MoveFile(ctx, job.UserID, id, job.DestID)
+	case models.BulkCopyFiles:
+		_, err := bs.fileService.CopyFile(ctx, job.UserID, id, job.DestID, "")
+		return "", err
+	case models.BulkShareFiles:
+		share, err := bs.fileService.CreateShare(ctx, job.UserID, id, bulkShareData(job))
+		if err != nil {
+			return "", err
+		}
+		return share.Token, nil
+	case models.BulkDeleteFolders:
+		return "", bs.folderService.DeleteFolder(ctx, job.UserID, id, false)
+	case models.BulkMoveFolders:
+		return "", bs.folderService.MoveFolder(ctx, job.UserID, id, job.DestID)
+	case models.BulkCopyFolders:
+		_, err := bs.folderService.CopyFolder(ctx, job.UserID, id, job.DestID, "")
+		return "", err
+	case models.BulkShareFolders:
+		share, err := bs.folderService.CreateShare(ctx, job.UserID, id, bulkShareData(job))
+		if err != nil {
+			return "", err
+		}
+		return share.Token, nil
+	default:
+		return "", ErrBulkOperationUnsupported
+	}
+}
+
+func bulkShareData(job *models.BulkJob) *models.ShareRequest {
+	if job.ShareData == nil {
+		return &models.ShareRequest{}
+	}
+	return job.ShareData
+}
+
+func (bs *BulkJobService) updateJob(ctx context.Context, jobID primitive.ObjectID, update bson.M) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if set, ok := update["$set"].(bson.M); ok {
+		set["updated_at"] = time.Now()
+	} else {
+		update["$set"] = bson.M{"updated_at": time.Now()}
+	}
+	if _, err := bs.collections.BulkJobs().UpdateOne(ctx, bson.M{"_id": jobID}, update); err != nil {
+		log.Printf("Failed to update bulk job %s: %v", jobID.Hex(), err)
+	}
+}
@@ -0,0 +1,469 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"mime"
+	"net/http"
+	"oncloud/models"
+	"oncloud/utils"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// How long a user has to send a link code to the bot
+	chatLinkCodeTTL = 10 * time.Minute
+
+	// Share links created from chat expire after this long
+	chatShareTTL = 7 * 24 * time.Hour
+
+	// How long handling one message, including downloading its files, may
+	// take
+	chatMessageTimeout = 10 * time.Minute
+)
+
+var (
+	ErrChatPlatformNotConfigured = errors.New("chat platform is not configured")
+	ErrChatAccountNotFound       = notFoundError("chat account not found")
+	ErrChatFolderNotFound        = notFoundError("folder not found")
+)
+
+// ChatBotOptions configures the chat platforms the bot is reachable on.
+// Platforms without a token are not offered.
+type ChatBotOptions struct {
+	Telegram TelegramBotOptions
+	Slack    SlackBotOptions
+}
+
+var chatConnectors = map[string]chatConnector{}
+
+// InitChatBots enables the bot on the configured chat platforms
+func InitChatBots(opts ChatBotOptions) {
+	if opts.Telegram.Token != "" {
+		chatConnectors[ChatPlatformTelegram] = &telegramConnector{options: opts.Telegram}
+	}
+	if opts.Slack.BotToken != "" {
+		chatConnectors[ChatPlatformSlack] = &slackConnector{options: opts.Slack}
+	}
+}
+
+type ChatBotService struct {
+	*BaseService
+	fileService *FileService
+}
+
+func NewChatBotService() *ChatBotService {
+	return NewChatBotServiceWith(Dependencies{})
+}
+
+// NewChatBotServiceWith creates a chat bot service on the given dependencies
+func NewChatBotServiceWith(deps Dependencies) *ChatBotService {
+	return &ChatBotService{
+		BaseService: NewBaseServiceWith(deps),
+		fileService: NewFileServiceWith(deps),
+	}
+}
+
+// GetPlatforms returns the chat platforms the bot is reachable on
+func (cbs *ChatBotService) GetPlatforms() []string {
+	platforms := make([]string, 0, len(chatConnectors))
+	for name := range chatConnectors {
+		platforms = append(platforms, name)
+	}
+	sort.Strings(platforms)
+	return platforms
+}
+
+// CreateLinkCode returns a code the user sends to the bot to link their
+// chat account
+func (cbs *ChatBotService) CreateLinkCode(ctx context.Context, userID primitive.ObjectID) (*models.ChatLinkCode, error) {
+	if len(chatConnectors) == 0 {
+		return nil, ErrChatPlatformNotConfigured
+	}
+
+	code, err := generateChatLinkCode()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate link code: %v", err)
+	}
+
+	now := time.Now()
+	linkCode := &models.ChatLinkCode{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		Code:      code,
+		ExpiresAt: now.Add(chatLinkCodeTTL),
+		CreatedAt: now,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if _, err := cbs.collections.ChatLinkCodes().InsertOne(ctx, linkCode); err != nil {
+		return nil, fmt.Errorf("failed to create link code: %v", err)
+	}
+	return linkCode, nil
+}
+
+// GetAccounts returns the user's linked chat accounts
+func (cbs *ChatBotService) GetAccounts(ctx context.Context, userID primitive.ObjectID) ([]models.ChatAccount, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	cursor, err := cbs.collections.ChatAccounts().Find(ctx,
+		bson.M{"user_id": userID},
+		options.Find().SetSort(bson.M{"created_at": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	accounts := []models.ChatAccount{}
+	if err := cursor.All(ctx, &accounts); err != nil {
+		return nil, err
+	}
+	return accounts, nil
+}
+
+// UpdateAccount changes the folder files sent from a chat account are
+// saved into
+func (cbs *ChatBotService) UpdateAccount(ctx context.Context, userID, accountID primitive.ObjectID, req *models.ChatAccountUpdateRequest) (*models.ChatAccount, error) {
+	update := bson.M{"$set": bson.M{"updated_at": time.Now()}}
+	if req.FolderID == "" {
+		update["$unset"] = bson.M{"folder_id": ""}
+	} else {
+		folderID, err := utils.StringToObjectID(req.FolderID)
+		if err != nil {
+			return nil, ErrChatFolderNotFound
+		}
+		if _, err := NewFolderService().GetUserFolder(ctx, userID, folderID); err != nil {
+			return nil, ErrChatFolderNotFound
+		}
+		update["$set"].(bson.M)["folder_id"] = folderID
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var account models.ChatAccount
+	err := cbs.collections.ChatAccounts().FindOneAndUpdate(ctx,
+		bson.M{"_id": accountID, "user_id": userID},
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&account)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrChatAccountNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update chat account: %v", err)
+	}
+	return &account, nil
+}
+
+// DeleteAccount unlinks a chat account
+func (cbs *ChatBotService) DeleteAccount(ctx context.Context, userID, accountID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := cbs.collections.ChatAccounts().DeleteOne(ctx, bson.M{"_id": accountID, "user_id": userID})
+	if err != nil {
+		return fmt.Errorf("failed to delete chat account: %v", err)
+	}
+	if result.DeletedCount == 0 {
+		return ErrChatAccountNotFound
+	}
+	return nil
+}
+
+// HandleWebhook verifies a platform's webhook request and handles the
+// messages in it in the background, since platforms expect a quick answer.
+// It returns the response the platform expects, if any.
+func (cbs *ChatBotService) HandleWebhook(platform string, header http.Header, body []byte) (interface{}, error) {
+	connector, ok := chatConnectors[platform]
+	if !ok {
+		return nil, ErrChatPlatformNotConfigured
+	}
+
+	webhook, err := connector.ParseWebhook(header, body)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, message := range webhook.Messages {
+		go cbs.handleMessage(context.Background(), connector, message)
+	}
+	return webhook.Response, nil
+}
+
+const chatHelpText = `Send me files to save them to your storage.
+
+Commands:
+link <code> - link this account, with a code from your account settings
+share <file name> - get a share link for a file
+recent - list your latest files
+unlink - unlink this account`
+
+func (cbs *ChatBotService) handleMessage(ctx context.Context, connector chatConnector, message ChatMessage) {
+	ctx, cancel := context.WithTimeout(ctx, chatMessageTimeout)
+	defer cancel()
+
+	reply := func(text string) {
+		if err := connector.Reply(ctx, &message, text); err != nil {
+			log.Printf("Failed to reply on %s: %v", message.Platform, err)
+		}
+	}
+
+	command, args := parseChatCommand(message.Text)
+	if command == "link" {
+		reply(cbs.linkAccount(ctx, &message, args))
+		return
+	}
+
+	account, err := cbs.findAccount(ctx, message.Platform, message.UserID)
+	if err != nil {
+		reply("This account is not linked yet. Create a link code in your account settings and send it to me as: link <code>")
+		return
+	}
+	cbs.touchAccount(ctx, account.ID)
+
+	if len(message.Files) > 0 {
+		for _, file := range message.Files {
+			reply(cbs.saveFile(ctx, connector, account, &file))
+		}
+		return
+	}
+
+	switch command {
+	case "share":
+		reply(cbs.shareFile(ctx, account.UserID, args))
+	case "recent":
+		reply(cbs.recentFiles(ctx, account.UserID))
+	case "unlink":
+		if err := cbs.DeleteAccount(ctx, account.UserID, account.ID); err != nil {
+			reply("Could not unlink this account, please try again")
+			return
+		}
+		reply("This account is no longer linked")
+	case "help", "start", "":
+		reply(chatHelpText)
+	default:
+		reply("Unknown command.\n\n" + chatHelpText)
+	}
+}
+
+// linkAccount links the sender's chat account to the user a link code was
+// created for. An account linked before is moved to the new user.
+func (cbs *ChatBotService) linkAccount(ctx context.Context, message *ChatMessage, code string) string {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var linkCode models.ChatLinkCode
+	err := cbs.collections.ChatLinkCodes().FindOneAndDelete(ctx, bson.M{
+		"code":       strings.ToUpper(strings.TrimSpace(code)),
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).Decode(&linkCode)
+	if err != nil {
+		return "This link code is invalid or has expired. Create a new one in your account settings."
+	}
+
+	now := time.Now()
+	_, err = cbs.collections.ChatAccounts().UpdateOne(ctx,
+		bson.M{"platform": message.Platform, "external_user_id": message.UserID},
+		bson.M{
+			"$set": bson.M{
+				"user_id":       linkCode.UserID,
+				"external_name": message.UserName,
+				"updated_at":    now,
+			},
+			"$unset":       bson.M{"folder_id": ""},
+			"$setOnInsert": bson.M{"created_at": now},
+		},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		log.Printf("Failed to link %s account: %v", message.Platform, err)
+		return "Could not link this account, please try again"
+	}
+	return "This account is now linked. Send me files to save them.\n\n" + chatHelpText
+}
+
+// saveFile downloads a file sent to the bot into the account's folder and
+// returns the reply to send
+func (cbs *ChatBotService) saveFile(ctx context.Context, connector chatConnector, account *models.ChatAccount, file *ChatFile) string {
+	name := file.Name
+	if name == "" {
+		name = "file_" + time.Now().Format("20060102_150405")
+		if extensions, err := mime.ExtensionsByType(file.MimeType); err == nil && len(extensions) > 0 {
+			name += extensions[0]
+		}
+	}
+
+	plan, err := cbs.fileService.GetUserPlan(ctx, account.UserID)
+	if err != nil {
+		return fmt.Sprintf("Could not save %s, please try again", name)
+	}
+	if file.Size > plan.MaxFileSize {
+		return fmt.Sprintf("Could not save %s: file size exceeds limit of %s", name, utils.FormatFileSize(plan.MaxFileSize))
+	}
+
+	body, err := connector.Download(ctx, file)
+	if err != nil {
+		log.Printf("Failed to download %s file: %v", account.Platform, err)
+		return fmt.Sprintf("Could not download %s, please try again", name)
+	}
+	defer body.Close()
+
+	content, err := io.ReadAll(io.LimitReader(body, plan.MaxFileSize+1))
+	if err != nil {
+		return fmt.Sprintf("Could not download %s, please try again", name)
+	}
+	if int64(len(content)) > plan.MaxFileSize {
+		return fmt.Sprintf("Could not save %s: file size exceeds limit of %s", name, utils.FormatFileSize(plan.MaxFileSize))
+	}
+
+	req := &models.FileUploadRequest{
+		Metadata: map[string]string{"chat_platform": account.Platform},
+		Source:   models.UploadSourceChat,
+	}
+	if account.FolderID != nil {
+		req.FolderID = account.FolderID.Hex()
+	}
+
+	saved, err := cbs.fileService.UploadContent(ctx, account.UserID, name, content, req)
+	if err != nil {
+		return fmt.Sprintf("Could not save %s: %v", name, err)
+	}
+	return fmt.Sprintf("Saved %s (%s)", saved.OriginalName, utils.FormatFileSize(saved.Size))
+}
+
+// shareFile returns a share link for the user's file best matching name,
+// reusing its active share when it has one
+func (cbs *ChatBotService) shareFile(ctx context.Context, userID primitive.ObjectID, name string) string {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "Which file? Send: share <file name>"
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	cursor, err := cbs.collections.Files().Find(ctx,
+		bson.M{
+			"user_id":       userID,
+			"is_deleted":    false,
+			"original_name": bson.M{"$regex": regexp.QuoteMeta(name), "$options": "i"},
+		},
+		options.Find().SetSort(bson.M{"created_at": -1}).SetLimit(20),
+	)
+	if err != nil {
+		return "Could not search your files, please try again"
+	}
+	var files []models.File
+	if err := cursor.All(ctx, &files); err != nil || len(files) == 0 {
+		return fmt.Sprintf("No file matching %q found", name)
+	}
+
+	file := files[0]
+	for _, candidate := range files {
+		if strings.EqualFold(candidate.OriginalName, name) {
+			file = candidate
+			break
+		}
+	}
+
+	if _, err := cbs.fileService.GetShare(ctx, userID, file.ID); err != nil {
+		expiresAt := time.Now().Add(chatShareTTL)
+		if _, err := cbs.fileService.CreateShare(ctx, userID, file.ID, &models.ShareRequest{ExpiresAt: &expiresAt}); err != nil {
+			return fmt.Sprintf("Could not share %s, please try again", file.OriginalName)
+		}
+	}
+	shareURL, err := cbs.fileService.GetShareURL(ctx, userID, file.ID)
+	if err != nil {
+		return fmt.Sprintf("Could not share %s, please try again", file.OriginalName)
+	}
+	return fmt.Sprintf("%s\n%s", file.OriginalName, shareURL)
+}
+
+func (cbs *ChatBotService) recentFiles(ctx context.Context, userID primitive.ObjectID) string {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	cursor, err := cbs.collections.Files().Find(ctx,
+		bson.M{"user_id": userID, "is_deleted": false},
+		options.Find().SetSort(bson.M{"created_at": -1}).SetLimit(10),
+	)
+	if err != nil {
+		return "Could not list your files, please try again"
+	}
+	var files []models.File
+	if err := cursor.All(ctx, &files); err != nil {
+		return "Could not list your files, please try again"
+	}
+	if len(files) == 0 {
+		return "You have no files yet"
+	}
+
+	lines := make([]string, 0, len(files))
+	for _, file := range files {
+		lines = append(lines, fmt.Sprintf("%s (%s)", file.OriginalName, utils.FormatFileSize(file.Size)))
+	}
+	return "Your latest files:\n" + strings.Join(lines, "\n")
+}
+
+func (cbs *ChatBotService) findAccount(ctx context.Context, platform, externalUserID string) (*models.ChatAccount, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var account models.ChatAccount
+	err := cbs.collections.ChatAccounts().FindOne(ctx, bson.M{"platform": platform, "external_user_id": externalUserID}).Decode(&account)
+	if err != nil {
+		return nil, ErrChatAccountNotFound
+	}
+	return &account, nil
+}
+
+func (cbs *ChatBotService) touchAccount(ctx context.Context, accountID primitive.ObjectID) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	cbs.collections.ChatAccounts().UpdateOne(ctx, bson.M{"_id": accountID}, bson.M{"$set": bson.M{"last_used_at": time.Now()}})
+}
+
+// parseChatCommand splits a message into a lowercase command and its
+// arguments. Commands work with or without a leading slash, since Slack
+// keeps slashes for its own commands, and Telegram's @botname suffix is
+// ignored.
+func parseChatCommand(text string) (string, string) {
+	command, args, _ := strings.Cut(strings.TrimSpace(text), " ")
+	command = strings.TrimPrefix(strings.ToLower(command), "/")
+	command, _, _ = strings.Cut(command, "@")
+	return command, strings.TrimSpace(args)
+}
+
+// generateChatLinkCode returns a random code without easily confused
+// characters, like K7QM-2XPD
+func generateChatLinkCode() (string, error) {
+	const alphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	code := make([]byte, 8)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(alphabet))))
+		if err != nil {
+			return "", err
+		}
+		code[i] = alphabet[n.Int64()]
+	}
+	return string(code[:4]) + "-" + string(code[4:]), nil
+}
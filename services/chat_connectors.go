@@ -0,0 +1,374 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ChatPlatformTelegram = "telegram"
+	ChatPlatformSlack    = "slack"
+
+	// Slack requests older than this are rejected as replays
+	slackSignatureMaxAge = 5 * time.Minute
+)
+
+// ErrChatWebhookUnverified is returned for webhook requests that were not
+// sent by the chat platform
+var ErrChatWebhookUnverified = errors.New("chat webhook could not be verified")
+
+// ChatFile is a file sent to the bot
+type ChatFile struct {
+	ID       string
+	Name     string
+	Size     int64
+	MimeType string
+	URL      string
+}
+
+// ChatMessage is a message sent to the bot. UserID identifies the sender
+// on the platform and ChatID the conversation to reply in.
+type ChatMessage struct {
+	Platform string
+	UserID   string
+	UserName string
+	ChatID   string
+	Text     string
+	Files    []ChatFile
+}
+
+// chatWebhook is a decoded webhook request. Response, when set, is sent
+// back as the webhook's JSON response instead of the default.
+type chatWebhook struct {
+	Messages []ChatMessage
+	Response interface{}
+}
+
+// chatConnector is a chat platform the bot is reachable on. Adding a
+// platform means implementing this and registering it in InitChatBots.
+type chatConnector interface {
+	// ParseWebhook verifies a webhook request sent by the platform and
+	// decodes the messages in it
+	ParseWebhook(header http.Header, body []byte) (*chatWebhook, error)
+	Reply(ctx context.Context, message *ChatMessage, text string) error
+	Download(ctx context.Context, file *ChatFile) (io.ReadCloser, error)
+}
+
+// TelegramBotOptions configures the Telegram bot. WebhookSecret is the
+// secret_token the webhook was registered with.
+type TelegramBotOptions struct {
+	Token         string
+	WebhookSecret string
+}
+
+// SlackBotOptions configures the Slack app the bot runs as
+type SlackBotOptions struct {
+	BotToken      string
+	SigningSecret string
+}
+
+// Telegram
+
+const telegramAPIURL = "https://api.telegram.org"
+
+type telegramConnector struct {
+	options TelegramBotOptions
+}
+
+type telegramFile struct {
+	FileID   string `json:"file_id"`
+	FileName string `json:"file_name"`
+	MimeType string `json:"mime_type"`
+	FileSize int64  `json:"file_size"`
+}
+
+type telegramUpdate struct {
+	Message *struct {
+		From struct {
+			ID        int64  `json:"id"`
+			Username  string `json:"username"`
+			FirstName string `json:"first_name"`
+			IsBot     bool   `json:"is_bot"`
+		} `json:"from"`
+		Chat struct {
+			ID int64 `json:"id"`
+		} `json:"chat"`
+		Text     string         `json:"text"`
+		Caption  string         `json:"caption"`
+		Document *telegramFile  `json:"document"`
+		Video    *telegramFile  `json:"video"`
+		Audio    *telegramFile  `json:"audio"`
+		Photo    []telegramFile `json:"photo"`
+	} `json:"message"`
+}
+
+func (t *telegramConnector) ParseWebhook(header http.Header, body []byte) (*chatWebhook, error) {
+	secret := header.Get("X-Telegram-Bot-Api-Secret-Token")
+	if t.options.WebhookSecret == "" || subtle.ConstantTimeCompare([]byte(secret), []byte(t.options.WebhookSecret)) != 1 {
+		return nil, ErrChatWebhookUnverified
+	}
+
+	var update telegramUpdate
+	if err := json.Unmarshal(body, &update); err != nil {
+		return nil, fmt.Errorf("invalid update: %v", err)
+	}
+	webhook := &chatWebhook{}
+	message := update.Message
+	if message == nil || message.From.IsBot {
+		return webhook, nil
+	}
+
+	chatMessage := ChatMessage{
+		Platform: ChatPlatformTelegram,
+		UserID:   strconv.FormatInt(message.From.ID, 10),
+		UserName: message.From.Username,
+		ChatID:   strconv.FormatInt(message.Chat.ID, 10),
+		Text:     message.Text,
+	}
+	if chatMessage.UserName == "" {
+		chatMessage.UserName = message.From.FirstName
+	}
+	if chatMessage.Text == "" {
+		chatMessage.Text = message.Caption
+	}
+
+	for _, file := range []*telegramFile{message.Document, message.Video, message.Audio} {
+		if file != nil {
+			chatMessage.Files = append(chatMessage.Files, ChatFile{
+				ID:       file.FileID,
+				Name:     file.FileName,
+				Size:     file.FileSize,
+				MimeType: file.MimeType,
+			})
+		}
+	}
+	// Photos come in several sizes, largest last, and without a name
+	if len(message.Photo) > 0 {
+		photo := message.Photo[len(message.Photo)-1]
+		chatMessage.Files = append(chatMessage.Files, ChatFile{
+			ID:       photo.FileID,
+			Name:     fmt.Sprintf("photo_%s.jpg", time.Now().Format("20060102_150405")),
+			Size:     photo.FileSize,
+			MimeType: "image/jpeg",
+		})
+	}
+
+	webhook.Messages = append(webhook.Messages, chatMessage)
+	return webhook, nil
+}
+
+func (t *telegramConnector) Reply(ctx context.Context, message *ChatMessage, text string) error {
+	payload, _ := json.Marshal(map[string]interface{}{
+		"chat_id":                  message.ChatID,
+		"text":                     text,
+		"disable_web_page_preview": true,
+	})
+
+	var result struct {
+		OK bool `json:"ok"`
+	}
+	err := cloudJSON(ctx, &result, func() (*http.Request, error) {
+		return t.request(http.MethodPost, "sendMessage", payload)
+	})
+	return t.redact(err)
+}
+
+func (t *telegramConnector) Download(ctx context.Context, file *ChatFile) (io.ReadCloser, error) {
+	payload, _ := json.Marshal(map[string]string{"file_id": file.ID})
+
+	var result struct {
+		OK     bool `json:"ok"`
+		Result struct {
+			FilePath string `json:"file_path"`
+		} `json:"result"`
+	}
+	err := cloudJSON(ctx, &result, func() (*http.Request, error) {
+		return t.request(http.MethodPost, "getFile", payload)
+	})
+	if err != nil {
+		return nil, t.redact(err)
+	}
+	if result.Result.FilePath == "" {
+		return nil, errors.New("file is not available for download")
+	}
+
+	fileURL := fmt.Sprintf("%s/file/bot%s/%s", telegramAPIURL, t.options.Token, result.Result.FilePath)
+	resp, err := cloudRequest(ctx, func() (*http.Request, error) {
+		return http.NewRequest(http.MethodGet, fileURL, nil)
+	})
+	if err != nil {
+		return nil, t.redact(err)
+	}
+	return resp.Body, nil
+}
+
+// redact removes the bot token, which is part of every API URL, from
+// request errors
+func (t *telegramConnector) redact(err error) error {
+	if err == nil || !strings.Contains(err.Error(), t.options.Token) {
+		return err
+	}
+	return errors.New(strings.ReplaceAll(err.Error(), t.options.Token, "<token>"))
+}
+
+func (t *telegramConnector) request(method, apiMethod string, payload []byte) (*http.Request, error) {
+	req, err := http.NewRequest(method, fmt.Sprintf("%s/bot%s/%s", telegramAPIURL, t.options.Token, apiMethod), bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+// Slack
+
+const slackAPIURL = "https://slack.com/api"
+
+type slackConnector struct {
+	options SlackBotOptions
+}
+
+type slackEventCallback struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	TeamID    string `json:"team_id"`
+	Event     struct {
+		Type    string `json:"type"`
+		Subtype string `json:"subtype"`
+		User    string `json:"user"`
+		BotID   string `json:"bot_id"`
+		Channel string `json:"channel"`
+		Text    string `json:"text"`
+		Files   []struct {
+			ID                 string `json:"id"`
+			Name               string `json:"name"`
+			Mimetype           string `json:"mimetype"`
+			Size               int64  `json:"size"`
+			URLPrivateDownload string `json:"url_private_download"`
+		} `json:"files"`
+	} `json:"event"`
+}
+
+func (s *slackConnector) ParseWebhook(header http.Header, body []byte) (*chatWebhook, error) {
+	if err := s.verify(header, body); err != nil {
+		return nil, err
+	}
+
+	var callback slackEventCallback
+	if err := json.Unmarshal(body, &callback); err != nil {
+		return nil, fmt.Errorf("invalid event: %v", err)
+	}
+
+	webhook := &chatWebhook{}
+	switch callback.Type {
+	case "url_verification":
+		webhook.Response = map[string]string{"challenge": callback.Challenge}
+		return webhook, nil
+	case "event_callback":
+	default:
+		return webhook, nil
+	}
+
+	// Slack retries events it did not get an answer to in time; the first
+	// delivery is already being handled
+	if header.Get("X-Slack-Retry-Num") != "" {
+		return webhook, nil
+	}
+
+	event := callback.Event
+	if event.Type != "message" || event.BotID != "" || event.User == "" ||
+		(event.Subtype != "" && event.Subtype != "file_share") {
+		return webhook, nil
+	}
+
+	message := ChatMessage{
+		Platform: ChatPlatformSlack,
+		UserID:   callback.TeamID + ":" + event.User,
+		UserName: event.User,
+		ChatID:   event.Channel,
+		Text:     event.Text,
+	}
+	for _, file := range event.Files {
+		message.Files = append(message.Files, ChatFile{
+			ID:       file.ID,
+			Name:     file.Name,
+			Size:     file.Size,
+			MimeType: file.Mimetype,
+			URL:      file.URLPrivateDownload,
+		})
+	}
+
+	webhook.Messages = append(webhook.Messages, message)
+	return webhook, nil
+}
+
+// verify checks Slack's v0 request signature
+func (s *slackConnector) verify(header http.Header, body []byte) error {
+	timestamp := header.Get("X-Slack-Request-Timestamp")
+	sentAt, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || time.Since(time.Unix(sentAt, 0)).Abs() > slackSignatureMaxAge {
+		return ErrChatWebhookUnverified
+	}
+
+	mac := hmac.New(sha256.New, []byte(s.options.SigningSecret))
+	mac.Write([]byte("v0:" + timestamp + ":"))
+	mac.Write(body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(header.Get("X-Slack-Signature"))) {
+		return ErrChatWebhookUnverified
+	}
+	return nil
+}
+
+func (s *slackConnector) Reply(ctx context.Context, message *ChatMessage, text string) error {
+	payload, _ := json.Marshal(map[string]interface{}{
+		"channel":      message.ChatID,
+		"text":         text,
+		"unfurl_links": false,
+	})
+
+	var result struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	err := cloudJSON(ctx, &result, func() (*http.Request, error) {
+		return cloudAuthorizedRequest(http.MethodPost, slackAPIURL+"/chat.postMessage", s.options.BotToken, payload)
+	})
+	if err != nil {
+		return err
+	}
+	if !result.OK {
+		return fmt.Errorf("slack: %s", result.Error)
+	}
+	return nil
+}
+
+func (s *slackConnector) Download(ctx context.Context, file *ChatFile) (io.ReadCloser, error) {
+	// Only follow download links to Slack itself, which the bot token is
+	// sent to
+	fileURL, err := url.Parse(file.URL)
+	if err != nil || fileURL.Scheme != "https" || !strings.HasSuffix(fileURL.Hostname(), ".slack.com") {
+		return nil, errors.New("invalid file URL")
+	}
+
+	resp, err := cloudRequest(ctx, func() (*http.Request, error) {
+		return cloudAuthorizedRequest(http.MethodGet, file.URL, s.options.BotToken, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
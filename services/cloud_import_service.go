@@ -0,0 +1,980 @@
+package services
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"oncloud/models"
+	"oncloud/utils"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// How long a user has to approve access at the provider
+	cloudAuthStateTTL = 15 * time.Minute
+
+	// A running job that has not reported progress for this long was left
+	// behind by a server that stopped, and is picked up again
+	cloudImportStaleAfter = 15 * time.Minute
+
+	// Only the first errors of a job are kept
+	maxCloudImportErrors = 100
+
+	maxCloudImportDepth = 100
+
+	// ImportProviderURL marks jobs that fetch a single file from a URL
+	ImportProviderURL = "url"
+
+	// How long fetching a file from a URL may take in all
+	urlImportTimeout = 30 * time.Minute
+
+	// How often a URL import's download progress is saved
+	urlImportProgressInterval = 2 * time.Second
+)
+
+var (
+	ErrCloudProviderNotConfigured = errors.New("cloud provider is not configured")
+	ErrCloudConnectionNotFound    = notFoundError("cloud connection not found")
+	ErrCloudImportNotFound        = notFoundError("import job not found")
+	ErrInvalidCloudAuthState      = errors.New("invalid or expired authorization state")
+	ErrCloudImportFinished        = errors.New("import job has already finished")
+	ErrCloudImportFolderNotFound  = notFoundError("destination folder not found")
+	ErrInvalidImportURL           = errors.New("invalid import URL")
+)
+
+// CloudImportOptions configures the OAuth clients users link their cloud
+// accounts through. Providers without a client ID are not offered.
+type CloudImportOptions struct {
+	// RedirectURL is where providers send the user back to after they
+	// approve access, with the code and state to complete linking with
+	RedirectURL string
+	Dropbox     CloudProviderCredentials
+	GoogleDrive CloudProviderCredentials
+	OneDrive    CloudProviderCredentials
+}
+
+var (
+	cloudImportRedirectURL string
+	cloudProviders         = map[string]cloudProvider{}
+)
+
+// InitCloudImport enables importing from the configured cloud providers
+func InitCloudImport(opts CloudImportOptions) {
+	cloudImportRedirectURL = opts.RedirectURL
+	if opts.Dropbox.ClientID != "" {
+		cloudProviders[CloudProviderDropbox] = &dropboxProvider{credentials: opts.Dropbox}
+	}
+	if opts.GoogleDrive.ClientID != "" {
+		cloudProviders[CloudProviderGoogleDrive] = &googleDriveProvider{credentials: opts.GoogleDrive}
+	}
+	if opts.OneDrive.ClientID != "" {
+		cloudProviders[CloudProviderOneDrive] = &oneDriveProvider{credentials: opts.OneDrive}
+	}
+}
+
+type CloudImportService struct {
+	*BaseService
+	fileService *FileService
+}
+
+func NewCloudImportService() *CloudImportService {
+	return NewCloudImportServiceWith(Dependencies{})
+}
+
+// NewCloudImportServiceWith creates a cloud import service on the given
+// dependencies
+func NewCloudImportServiceWith(deps Dependencies) *CloudImportService {
+	return &CloudImportService{
+		BaseService: NewBaseServiceWith(deps),
+		fileService: NewFileServiceWith(deps),
+	}
+}
+
+// GetProviders returns the cloud providers users can import from
+func (cis *CloudImportService) GetProviders() []string {
+	providers := make([]string, 0, len(cloudProviders))
+	for name := range cloudProviders {
+		providers = append(providers, name)
+	}
+	sort.Strings(providers)
+	return providers
+}
+
+// Authorize starts linking a cloud account, returning the provider URL to
+// send the user to
+func (cis *CloudImportService) Authorize(ctx context.Context, userID primitive.ObjectID, providerName string) (*models.CloudAuthorizeResponse, error) {
+	provider, ok := cloudProviders[providerName]
+	if !ok {
+		return nil, ErrCloudProviderNotConfigured
+	}
+
+	// The state is encrypted, and so can't be forged, to tie the callback
+	// to this user and provider without storing anything
+	expiresAt := time.Now().Add(cloudAuthStateTTL).Unix()
+	state, err := utils.EncryptString(fmt.Sprintf("%s|%s|%d", userID.Hex(), providerName, expiresAt))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create authorization state: %v", err)
+	}
+
+	return &models.CloudAuthorizeResponse{
+		AuthURL: provider.AuthURL(state, cloudImportRedirectURL),
+		State:   state,
+	}, nil
+}
+
+// Connect completes linking a cloud account with the code the provider
+// redirected back with. Linking the same account again replaces its
+// tokens.
+func (cis *CloudImportService) Connect(ctx context.Context, userID primitive.ObjectID, providerName string, req *models.CloudConnectRequest) (*models.CloudConnection, error) {
+	provider, ok := cloudProviders[providerName]
+	if !ok {
+		return nil, ErrCloudProviderNotConfigured
+	}
+
+	state, err := utils.DecryptString(req.State)
+	if err != nil {
+		return nil, ErrInvalidCloudAuthState
+	}
+	parts := strings.Split(state, "|")
+	if len(parts) != 3 || parts[0] != userID.Hex() || parts[1] != providerName {
+		return nil, ErrInvalidCloudAuthState
+	}
+	if expiresAt, err := strconv.ParseInt(parts[2], 10, 64); err != nil || time.Now().Unix() > expiresAt {
+		return nil, ErrInvalidCloudAuthState
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	token, err := provider.ExchangeCode(ctx, req.Code, cloudImportRedirectURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to link account: %v", err)
+	}
+	account, err := provider.Account(ctx, token.AccessToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get account details: %v", err)
+	}
+
+	now := time.Now()
+	set := bson.M{
+		"account_email": account.Email,
+		"account_name":  account.Name,
+		"updated_at":    now,
+	}
+	if err := setCloudTokens(set, token); err != nil {
+		return nil, err
+	}
+
+	var connection models.CloudConnection
+	err = cis.collections.CloudConnections().FindOneAndUpdate(ctx,
+		bson.M{"user_id": userID, "provider": providerName, "account_id": account.ID},
+		bson.M{
+			"$set":         set,
+			"$setOnInsert": bson.M{"created_at": now},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&connection)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save connection: %v", err)
+	}
+
+	return &connection, nil
+}
+
+// GetConnections returns the user's linked cloud accounts
+func (cis *CloudImportService) GetConnections(ctx context.Context, userID primitive.ObjectID) ([]models.CloudConnection, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	cursor, err := cis.collections.CloudConnections().Find(ctx,
+		bson.M{"user_id": userID},
+		options.Find().SetSort(bson.D{{Key: "provider", Value: 1}, {Key: "created_at", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	connections := []models.CloudConnection{}
+	if err := cursor.All(ctx, &connections); err != nil {
+		return nil, err
+	}
+	return connections, nil
+}
+
+// DeleteConnection unlinks a cloud account and cancels its unfinished
+// imports
+func (cis *CloudImportService) DeleteConnection(ctx context.Context, userID, connectionID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	result, err := cis.collections.CloudConnections().DeleteOne(ctx, bson.M{"_id": connectionID, "user_id": userID})
+	if err != nil {
+		return fmt.Errorf("failed to delete connection: %v", err)
+	}
+	if result.DeletedCount == 0 {
+		return ErrCloudConnectionNotFound
+	}
+
+	now := time.Now()
+	cis.collections.CloudImportJobs().UpdateMany(ctx,
+		bson.M{"connection_id": connectionID, "status": bson.M{"$in": bson.A{"pending", "running"}}},
+		bson.M{"$set": bson.M{"status": "cancelled", "completed_at": now, "updated_at": now}},
+	)
+
+	return nil
+}
+
+// Browse lists a folder of a linked cloud account, or its root when
+// folderID is empty
+func (cis *CloudImportService) Browse(ctx context.Context, userID, connectionID primitive.ObjectID, folderID string) ([]models.CloudItem, error) {
+	session, err := cis.newSession(ctx, userID, connectionID)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+
+	var items []models.CloudItem
+	err = session.do(ctx, func(accessToken string) error {
+		items, err = session.provider.List(ctx, accessToken, folderID)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list folder: %v", err)
+	}
+	return items, nil
+}
+
+// StartImport queues an import of the picked files and folders into
+// folderID, or the root, and starts it in the background
+func (cis *CloudImportService) StartImport(ctx context.Context, userID primitive.ObjectID, req *models.CloudImportRequest) (*models.CloudImportJob, error) {
+	connectionID, err := utils.StringToObjectID(req.ConnectionID)
+	if err != nil {
+		return nil, ErrCloudConnectionNotFound
+	}
+	connection, err := cis.getConnection(ctx, userID, connectionID)
+	if err != nil {
+		return nil, err
+	}
+
+	folderID, err := cis.destinationFolder(ctx, userID, req.FolderID)
+	if err != nil {
+		return nil, err
+	}
+
+	return cis.queueJob(ctx, &models.CloudImportJob{
+		UserID:       userID,
+		ConnectionID: &connection.ID,
+		Provider:     connection.Provider,
+		Sources:      req.Sources,
+		FolderID:     folderID,
+	})
+}
+
+// StartURLImport queues fetching a file from a URL into folderID, or the
+// root, and starts it in the background
+func (cis *CloudImportService) StartURLImport(ctx context.Context, userID primitive.ObjectID, req *models.URLImportRequest) (*models.CloudImportJob, error) {
+	if _, err := utils.ValidateRemoteURL(req.URL); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImportURL, err)
+	}
+	folderID, err := cis.destinationFolder(ctx, userID, req.FolderID)
+	if err != nil {
+		return nil, err
+	}
+
+	return cis.queueJob(ctx, &models.CloudImportJob{
+		UserID:    userID,
+		Provider:  ImportProviderURL,
+		Sources:   []models.CloudImportSource{},
+		SourceURL: req.URL,
+		FileName:  strings.TrimSpace(req.Name),
+		FolderID:  folderID,
+	})
+}
+
+// destinationFolder checks the folder an import goes into, if any
+func (cis *CloudImportService) destinationFolder(ctx context.Context, userID primitive.ObjectID, folderID string) (*primitive.ObjectID, error) {
+	if folderID == "" {
+		return nil, nil
+	}
+	fid, err := utils.StringToObjectID(folderID)
+	if err != nil {
+		return nil, ErrCloudImportFolderNotFound
+	}
+	if _, err := NewFolderService().GetUserFolder(ctx, userID, fid); err != nil {
+		return nil, ErrCloudImportFolderNotFound
+	}
+	return &fid, nil
+}
+
+// queueJob saves a new import job and starts it in the background
+func (cis *CloudImportService) queueJob(ctx context.Context, job *models.CloudImportJob) (*models.CloudImportJob, error) {
+	now := time.Now()
+	job.ID = primitive.NewObjectID()
+	job.Status = "pending"
+	job.Errors = []models.CloudImportError{}
+	job.CreatedAt = now
+	job.UpdatedAt = now
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if _, err := cis.collections.CloudImportJobs().InsertOne(ctx, job); err != nil {
+		return nil, fmt.Errorf("failed to create import job: %v", err)
+	}
+
+	go cis.runJob(context.Background(), job.ID)
+
+	return job, nil
+}
+
+// GetJobs returns the user's import jobs, newest first
+func (cis *CloudImportService) GetJobs(ctx context.Context, userID primitive.ObjectID, page, limit int) ([]models.CloudImportJob, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"user_id": userID}
+	cursor, err := cis.collections.CloudImportJobs().Find(ctx, filter,
+		options.Find().
+			SetSort(bson.M{"created_at": -1}).
+			SetSkip(int64((page-1)*limit)).
+			SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	jobs := []models.CloudImportJob{}
+	if err := cursor.All(ctx, &jobs); err != nil {
+		return nil, 0, err
+	}
+
+	total, err := cis.collections.CloudImportJobs().CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return jobs, int(total), nil
+}
+
+// GetJob returns one of the user's import jobs
+func (cis *CloudImportService) GetJob(ctx context.Context, userID, jobID primitive.ObjectID) (*models.CloudImportJob, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var job models.CloudImportJob
+	if err := cis.collections.CloudImportJobs().FindOne(ctx, bson.M{"_id": jobID, "user_id": userID}).Decode(&job); err != nil {
+		return nil, ErrCloudImportNotFound
+	}
+	return &job, nil
+}
+
+// CancelJob stops an unfinished import job. Files already imported are
+// kept.
+func (cis *CloudImportService) CancelJob(ctx context.Context, userID, jobID primitive.ObjectID) (*models.CloudImportJob, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	var job models.CloudImportJob
+	err := cis.collections.CloudImportJobs().FindOneAndUpdate(ctx,
+		bson.M{"_id": jobID, "user_id": userID, "status": bson.M{"$in": bson.A{"pending", "running"}}},
+		bson.M{"$set": bson.M{"status": "cancelled", "completed_at": now, "updated_at": now}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&job)
+	if err == mongo.ErrNoDocuments {
+		if _, err := cis.GetJob(ctx, userID, jobID); err != nil {
+			return nil, err
+		}
+		return nil, ErrCloudImportFinished
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to cancel import job: %v", err)
+	}
+
+	return &job, nil
+}
+
+// ResumeJobs restarts import jobs left unfinished when the server stopped.
+// Files imported before are skipped as duplicates.
+func (cis *CloudImportService) ResumeJobs(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	cis.collections.CloudImportJobs().UpdateMany(ctx,
+		bson.M{"status": "running", "updated_at": bson.M{"$lt": time.Now().Add(-cloudImportStaleAfter)}},
+		bson.M{"$set": bson.M{"status": "pending"}},
+	)
+
+	cursor, err := cis.collections.CloudImportJobs().Find(ctx,
+		bson.M{"status": "pending"},
+		options.Find().SetProjection(bson.M{"_id": 1}),
+	)
+	if err != nil {
+		log.Printf("Failed to find unfinished import jobs: %v", err)
+		return
+	}
+	var jobs []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &jobs); err != nil {
+		log.Printf("Failed to find unfinished import jobs: %v", err)
+		return
+	}
+
+	for _, job := range jobs {
+		go cis.runJob(context.Background(), job.ID)
+	}
+}
+
+// cloudImportTask is a file found in the picked sources, to be imported
+// into folderID
+type cloudImportTask struct {
+	item     models.CloudItem
+	path     string
+	folderID *primitive.ObjectID
+}
+
+// runJob claims a pending job and imports its files or URL
+func (cis *CloudImportService) runJob(ctx context.Context, jobID primitive.ObjectID) {
+	claimCtx, claimCancel := context.WithTimeout(ctx, 5*time.Second)
+	now := time.Now()
+	var job models.CloudImportJob
+	err := cis.collections.CloudImportJobs().FindOneAndUpdate(claimCtx,
+		bson.M{"_id": jobID, "status": "pending"},
+		bson.M{"$set": bson.M{
+			"status":     "running",
+			"progress":   models.CloudImportProgress{},
+			"errors":     []models.CloudImportError{},
+			"started_at": now,
+			"updated_at": now,
+		}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&job)
+	claimCancel()
+	if err != nil {
+		return // claimed elsewhere or cancelled
+	}
+
+	importJob := cis.importFiles
+	if job.Provider == ImportProviderURL {
+		importJob = cis.importURL
+	}
+	if err := importJob(ctx, &job); err != nil {
+		log.Printf("Import job %s failed: %v", job.ID.Hex(), err)
+		cis.finishJob(ctx, job.ID, "failed", err.Error())
+		return
+	}
+	cis.finishJob(ctx, job.ID, "completed", "")
+}
+
+func (cis *CloudImportService) importFiles(ctx context.Context, job *models.CloudImportJob) error {
+	if job.ConnectionID == nil {
+		return ErrCloudConnectionNotFound
+	}
+	session, err := cis.newSession(ctx, job.UserID, *job.ConnectionID)
+	if err != nil {
+		return err
+	}
+	plan, err := cis.fileService.GetUserPlan(ctx, job.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to get user plan: %v", err)
+	}
+
+	// Walk the picked folders first, recreating them, so progress has a
+	// total to count towards
+	var tasks []cloudImportTask
+	for _, source := range job.Sources {
+		item := models.CloudItem{
+			ID:       source.ID,
+			Name:     source.Name,
+			IsFolder: source.IsFolder,
+			Size:     source.Size,
+			MimeType: source.MimeType,
+		}
+		if err := cis.collectTasks(ctx, session, job, item, job.FolderID, "/"+source.Name, 0, &tasks); err != nil {
+			return err
+		}
+	}
+
+	var totalBytes int64
+	for _, task := range tasks {
+		totalBytes += task.item.Size
+	}
+	cis.updateJob(ctx, job.ID, bson.M{"$set": bson.M{
+		"progress.total_files": len(tasks),
+		"progress.total_bytes": totalBytes,
+	}})
+
+	for _, task := range tasks {
+		if cis.jobCancelled(ctx, job.ID) {
+			return nil
+		}
+
+		size, err := cis.importFile(ctx, session, job, plan, &task)
+		switch {
+		case err == errCloudImportDuplicate:
+			cis.updateJob(ctx, job.ID, bson.M{"$inc": bson.M{"progress.skipped_files": 1}})
+		case err != nil:
+			cis.recordFailure(ctx, job.ID, task.path, err)
+		default:
+			cis.updateJob(ctx, job.ID, bson.M{"$inc": bson.M{
+				"progress.imported_files": 1,
+				"progress.imported_bytes": size,
+			}})
+		}
+	}
+
+	return nil
+}
+
+// collectTasks adds item, or every file below it when it is a folder, to
+// tasks, creating the matching folders under folderID
+func (cis *CloudImportService) collectTasks(ctx context.Context, session *cloudSession, job *models.CloudImportJob, item models.CloudItem, folderID *primitive.ObjectID, path string, depth int, tasks *[]cloudImportTask) error {
+	if !item.IsFolder {
+		*tasks = append(*tasks, cloudImportTask{item: item, path: path, folderID: folderID})
+		return nil
+	}
+	if depth >= maxCloudImportDepth {
+		cis.recordFailure(ctx, job.ID, path, errors.New("folder is nested too deeply"))
+		return nil
+	}
+	if cis.jobCancelled(ctx, job.ID) {
+		return nil
+	}
+
+	var children []models.CloudItem
+	err := session.do(ctx, func(accessToken string) error {
+		var err error
+		children, err = session.provider.List(ctx, accessToken, item.ID)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list %s: %v", path, err)
+	}
+
+	childFolderID, err := cis.ensureFolder(ctx, job.UserID, folderID, item.Name)
+	if err != nil {
+		return fmt.Errorf("failed to create folder %s: %v", path, err)
+	}
+
+	for _, child := range children {
+		if err := cis.collectTasks(ctx, session, job, child, &childFolderID, path+"/"+child.Name, depth+1, tasks); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var errCloudImportDuplicate = errors.New("file already exists")
+
+// importFile downloads one file and stores it, returning its size. Files
+// whose content the user already has are skipped with
+// errCloudImportDuplicate.
+func (cis *CloudImportService) importFile(ctx context.Context, session *cloudSession, job *models.CloudImportJob, plan *models.Plan, task *cloudImportTask) (int64, error) {
+	if task.item.Size > plan.MaxFileSize {
+		return 0, quotaExceededError("file size exceeds limit of %s", utils.FormatFileSize(plan.MaxFileSize))
+	}
+
+	// Google Drive lists MD5 checksums, the hash files are deduplicated by,
+	// which saves downloading files the user already has
+	if task.item.MD5 != "" {
+		if duplicate, err := cis.fileService.findDuplicateFile(ctx, job.UserID, task.item.MD5); err == nil && duplicate != nil {
+			return 0, errCloudImportDuplicate
+		}
+	}
+
+	var content []byte
+	var name string
+	err := session.do(ctx, func(accessToken string) error {
+		body, downloadName, err := session.provider.Download(ctx, accessToken, &task.item)
+		if err != nil {
+			return err
+		}
+		defer body.Close()
+
+		content, err = io.ReadAll(io.LimitReader(body, plan.MaxFileSize+1))
+		name = downloadName
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("download failed: %v", err)
+	}
+	if int64(len(content)) > plan.MaxFileSize {
+		return 0, quotaExceededError("file size exceeds limit of %s", utils.FormatFileSize(plan.MaxFileSize))
+	}
+
+	if duplicate, err := cis.fileService.findDuplicateFile(ctx, job.UserID, fmt.Sprintf("%x", md5.Sum(content))); err == nil && duplicate != nil {
+		return 0, errCloudImportDuplicate
+	}
+
+	req := &models.FileUploadRequest{
+		Metadata: map[string]string{
+			"import_provider":  job.Provider,
+			"import_source_id": task.item.ID,
+		},
+		Source: models.UploadSourceImport,
+	}
+	if task.folderID != nil {
+		req.FolderID = task.folderID.Hex()
+	}
+
+	file, err := cis.fileService.UploadContent(ctx, job.UserID, name, content, req)
+	if err != nil {
+		return 0, err
+	}
+	return file.Size, nil
+}
+
+// importURL fetches a job's URL into the user's storage. Unlike a cloud
+// import, a job of one file fails when the file does.
+func (cis *CloudImportService) importURL(ctx context.Context, job *models.CloudImportJob) error {
+	plan, err := cis.fileService.GetUserPlan(ctx, job.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to get user plan: %v", err)
+	}
+	cis.updateJob(ctx, job.ID, bson.M{"$set": bson.M{"progress.total_files": 1}})
+
+	size, err := cis.fetchURL(ctx, job, plan)
+	switch {
+	case err == errCloudImportDuplicate:
+		cis.updateJob(ctx, job.ID, bson.M{"$inc": bson.M{"progress.skipped_files": 1}})
+	case err != nil:
+		cis.recordFailure(ctx, job.ID, job.SourceURL, err)
+		return err
+	default:
+		cis.updateJob(ctx, job.ID, bson.M{"$inc": bson.M{
+			"progress.imported_files": 1,
+			"progress.imported_bytes": size,
+		}})
+	}
+	return nil
+}
+
+// fetchURL downloads a job's URL and stores it, returning its size. The
+// size and type are checked against the user's plan from the response
+// headers before the body is read, and the size again while reading.
+func (cis *CloudImportService) fetchURL(ctx context.Context, job *models.CloudImportJob, plan *models.Plan) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, urlImportTimeout)
+	defer cancel()
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, job.SourceURL, nil)
+	if err != nil {
+		return 0, fmt.Errorf("invalid URL: %v", err)
+	}
+	httpReq.Header.Set("User-Agent", "oncloud")
+
+	resp, err := urlImportClient.Do(httpReq)
+	if errors.Is(err, utils.ErrRemoteAddressNotAllowed) {
+		return 0, errors.New("URL points to a private or reserved address")
+	}
+	if err != nil {
+		return 0, fmt.Errorf("download failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("download failed: status %d", resp.StatusCode)
+	}
+	if resp.ContentLength > plan.MaxFileSize {
+		return 0, quotaExceededError("file size exceeds limit of %s", utils.FormatFileSize(plan.MaxFileSize))
+	}
+
+	mediaType := ""
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		if mediaType, _, err = mime.ParseMediaType(contentType); err != nil {
+			return 0, fmt.Errorf("invalid content type %q", contentType)
+		}
+	}
+
+	name := job.FileName
+	if name == "" {
+		name = utils.RemoteFileName(resp, mediaType)
+	} else if filepath.Ext(name) == "" {
+		name += filepath.Ext(utils.RemoteFileName(resp, mediaType))
+	}
+	ext := strings.ToLower(filepath.Ext(name))
+	if ext == "" {
+		return 0, errors.New("could not tell the file type, set a name with an extension")
+	}
+	// An HTML answer to a URL for anything else is a login or error page
+	if mediaType == "text/html" && ext != ".html" && ext != ".htm" {
+		return 0, errors.New("URL returned a web page instead of a file")
+	}
+	if len(plan.AllowedTypes) > 0 && !utils.SliceContains(plan.AllowedTypes, ext) {
+		return 0, fmt.Errorf("file type %s is not allowed", ext)
+	}
+
+	if resp.ContentLength > 0 {
+		cis.updateJob(ctx, job.ID, bson.M{"$set": bson.M{"progress.total_bytes": resp.ContentLength}})
+	}
+
+	body := &urlImportReader{reader: resp.Body, report: func(read int64) {
+		cis.updateJob(ctx, job.ID, bson.M{"$set": bson.M{"progress.downloaded_bytes": read}})
+	}}
+	content, err := io.ReadAll(io.LimitReader(body, plan.MaxFileSize+1))
+	if err != nil {
+		return 0, fmt.Errorf("download failed: %v", err)
+	}
+	if int64(len(content)) > plan.MaxFileSize {
+		return 0, quotaExceededError("file size exceeds limit of %s", utils.FormatFileSize(plan.MaxFileSize))
+	}
+	cis.updateJob(ctx, job.ID, bson.M{"$set": bson.M{"progress.downloaded_bytes": len(content)}})
+
+	if duplicate, err := cis.fileService.findDuplicateFile(ctx, job.UserID, fmt.Sprintf("%x", md5.Sum(content))); err == nil && duplicate != nil {
+		return 0, errCloudImportDuplicate
+	}
+
+	req := &models.FileUploadRequest{
+		Metadata: map[string]string{
+			"import_provider":   ImportProviderURL,
+			"import_source_url": job.SourceURL,
+		},
+		Source: models.UploadSourceImport,
+	}
+	if job.FolderID != nil {
+		req.FolderID = job.FolderID.Hex()
+	}
+
+	file, err := cis.fileService.UploadContent(ctx, job.UserID, name, content, req)
+	if err != nil {
+		return 0, err
+	}
+	return file.Size, nil
+}
+
+// urlImportClient only connects to public addresses; fetchURL bounds each
+// download with its own deadline
+var urlImportClient = utils.NewRemoteFetchClient(0)
+
+// urlImportReader reports how much of a download has been read, at most
+// every urlImportProgressInterval
+type urlImportReader struct {
+	reader   io.Reader
+	read     int64
+	reported time.Time
+	report   func(read int64)
+}
+
+func (r *urlImportReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.read += int64(n)
+	if time.Since(r.reported) >= urlImportProgressInterval {
+		r.reported = time.Now()
+		r.report(r.read)
+	}
+	return n, err
+}
+
+// ensureFolder returns the user's folder named name in parentID, creating
+// it when there is none
+func (cis *CloudImportService) ensureFolder(ctx context.Context, userID primitive.ObjectID, parentID *primitive.ObjectID, name string) (primitive.ObjectID, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	name = strings.ReplaceAll(name, "/", "_")
+	filter := bson.M{"user_id": userID, "name": name, "is_deleted": false}
+	req := &models.FolderCreateRequest{Name: name}
+	if parentID != nil {
+		filter["parent_id"] = *parentID
+		req.ParentID = parentID.Hex()
+	} else {
+		filter["parent_id"] = bson.M{"$exists": false}
+	}
+
+	var existing models.Folder
+	if err := cis.collections.Folders().FindOne(ctx, filter).Decode(&existing); err == nil {
+		return existing.ID, nil
+	}
+
+	folder, err := NewFolderService().CreateFolder(ctx, userID, req)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return folder.ID, nil
+}
+
+func (cis *CloudImportService) jobCancelled(ctx context.Context, jobID primitive.ObjectID) bool {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	count, err := cis.collections.CloudImportJobs().CountDocuments(ctx, bson.M{"_id": jobID, "status": "cancelled"})
+	return err == nil && count > 0
+}
+
+func (cis *CloudImportService) recordFailure(ctx context.Context, jobID primitive.ObjectID, path string, err error) {
+	cis.updateJob(ctx, jobID, bson.M{
+		"$inc": bson.M{"progress.failed_files": 1},
+		"$push": bson.M{"errors": bson.M{
+			"$each":  bson.A{models.CloudImportError{Path: path, Message: err.Error()}},
+			"$slice": maxCloudImportErrors,
+		}},
+	})
+}
+
+func (cis *CloudImportService) updateJob(ctx context.Context, jobID primitive.ObjectID, update bson.M) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if set, ok := update["$set"].(bson.M); ok {
+		set["updated_at"] = time.Now()
+	} else {
+		update["$set"] = bson.M{"updated_at": time.Now()}
+	}
+	if _, err := cis.collections.CloudImportJobs().UpdateOne(ctx, bson.M{"_id": jobID}, update); err != nil {
+		log.Printf("Failed to update import job %s: %v", jobID.Hex(), err)
+	}
+}
+
+// finishJob records a job's outcome unless it was cancelled meanwhile
+func (cis *CloudImportService) finishJob(ctx context.Context, jobID primitive.ObjectID, status, message string) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	set := bson.M{"status": status, "completed_at": now, "updated_at": now}
+	if message != "" {
+		set["error"] = message
+	}
+	cis.collections.CloudImportJobs().UpdateOne(ctx,
+		bson.M{"_id": jobID, "status": "running"},
+		bson.M{"$set": set},
+	)
+}
+
+func (cis *CloudImportService) getConnection(ctx context.Context, userID, connectionID primitive.ObjectID) (*models.CloudConnection, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var connection models.CloudConnection
+	err := cis.collections.CloudConnections().FindOne(ctx, bson.M{"_id": connectionID, "user_id": userID}).Decode(&connection)
+	if err != nil {
+		return nil, ErrCloudConnectionNotFound
+	}
+	return &connection, nil
+}
+
+// cloudSession calls a provider with a connection's access token,
+// refreshing the token when it expires
+type cloudSession struct {
+	service     *CloudImportService
+	provider    cloudProvider
+	connection  *models.CloudConnection
+	accessToken string
+}
+
+func (cis *CloudImportService) newSession(ctx context.Context, userID, connectionID primitive.ObjectID) (*cloudSession, error) {
+	connection, err := cis.getConnection(ctx, userID, connectionID)
+	if err != nil {
+		return nil, err
+	}
+	provider, ok := cloudProviders[connection.Provider]
+	if !ok {
+		return nil, ErrCloudProviderNotConfigured
+	}
+	accessToken, err := utils.DecryptString(connection.AccessToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read access token: %v", err)
+	}
+
+	return &cloudSession{
+		service:     cis,
+		provider:    provider,
+		connection:  connection,
+		accessToken: accessToken,
+	}, nil
+}
+
+// do calls fn with a valid access token, refreshing it first when it is
+// about to expire and again if the provider rejects it
+func (cs *cloudSession) do(ctx context.Context, fn func(accessToken string) error) error {
+	if expiresAt := cs.connection.TokenExpiresAt; expiresAt != nil && time.Until(*expiresAt) < time.Minute {
+		if err := cs.refresh(ctx); err != nil {
+			return err
+		}
+	}
+
+	err := fn(cs.accessToken)
+	if errors.Is(err, errCloudUnauthorized) && cs.connection.RefreshToken != "" {
+		if err := cs.refresh(ctx); err != nil {
+			return err
+		}
+		err = fn(cs.accessToken)
+	}
+	return err
+}
+
+func (cs *cloudSession) refresh(ctx context.Context) error {
+	refreshToken, err := utils.DecryptString(cs.connection.RefreshToken)
+	if err != nil || refreshToken == "" {
+		return errors.New("cloud account must be linked again")
+	}
+
+	token, err := cs.provider.RefreshToken(ctx, refreshToken)
+	if err != nil {
+		return fmt.Errorf("failed to refresh access token: %v", err)
+	}
+	if token.RefreshToken == "" {
+		token.RefreshToken = refreshToken
+	}
+
+	set := bson.M{"updated_at": time.Now()}
+	if err := setCloudTokens(set, token); err != nil {
+		return err
+	}
+	if _, err := cs.service.collections.CloudConnections().UpdateOne(ctx,
+		bson.M{"_id": cs.connection.ID},
+		bson.M{"$set": set},
+	); err != nil {
+		return fmt.Errorf("failed to save access token: %v", err)
+	}
+
+	cs.accessToken = token.AccessToken
+	cs.connection.RefreshToken, _ = set["refresh_token"].(string)
+	if expiresAt, ok := set["token_expires_at"].(time.Time); ok {
+		cs.connection.TokenExpiresAt = &expiresAt
+	} else {
+		cs.connection.TokenExpiresAt = nil
+	}
+	return nil
+}
+
+// setCloudTokens adds a token's encrypted fields to a $set document
+func setCloudTokens(set bson.M, token *cloudToken) error {
+	accessToken, err := utils.EncryptString(token.AccessToken)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt access token: %v", err)
+	}
+	refreshToken := ""
+	if token.RefreshToken != "" {
+		if refreshToken, err = utils.EncryptString(token.RefreshToken); err != nil {
+			return fmt.Errorf("failed to encrypt refresh token: %v", err)
+		}
+	}
+
+	set["access_token"] = accessToken
+	set["refresh_token"] = refreshToken
+	if token.ExpiresIn > 0 {
+		set["token_expires_at"] = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	} else {
+		set["token_expires_at"] = nil
+	}
+	return nil
+}
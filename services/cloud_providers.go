@@ -0,0 +1,577 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"oncloud/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	CloudProviderDropbox     = "dropbox"
+	CloudProviderGoogleDrive = "google_drive"
+	CloudProviderOneDrive    = "onedrive"
+
+	// Rate limited and failed requests are retried with backoff up to
+	// cloudMaxAttempts times, waiting at most cloudMaxRetryWait at a time
+	cloudMaxAttempts  = 6
+	cloudMaxRetryWait = 2 * time.Minute
+)
+
+// errCloudUnauthorized is returned when the provider rejects the access
+// token, which is then refreshed
+var errCloudUnauthorized = errors.New("cloud access token rejected")
+
+// cloudToken is the result of an OAuth code exchange or token refresh
+type cloudToken struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// cloudAccount identifies the account a token belongs to
+type cloudAccount struct {
+	ID    string
+	Email string
+	Name  string
+}
+
+// cloudProvider is the API of a cloud storage service files are imported
+// from. Folder IDs are the provider's own; the empty ID is the root.
+type cloudProvider interface {
+	AuthURL(state, redirectURL string) string
+	ExchangeCode(ctx context.Context, code, redirectURL string) (*cloudToken, error)
+	RefreshToken(ctx context.Context, refreshToken string) (*cloudToken, error)
+	Account(ctx context.Context, accessToken string) (*cloudAccount, error)
+	List(ctx context.Context, accessToken, folderID string) ([]models.CloudItem, error)
+	// Download returns the item's content and, for documents that are
+	// exported on download, the name to store it under
+	Download(ctx context.Context, accessToken string, item *models.CloudItem) (io.ReadCloser, string, error)
+}
+
+// CloudProviderCredentials is an OAuth client registered with a provider
+type CloudProviderCredentials struct {
+	ClientID     string
+	ClientSecret string
+}
+
+var cloudHTTPClient = &http.Client{Timeout: 10 * time.Minute}
+
+// cloudRequest sends a request built by newRequest, retrying with backoff
+// when the provider rate limits it or fails temporarily. It honours the
+// Retry-After header. The caller closes the returned response's body.
+func cloudRequest(ctx context.Context, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		req, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := cloudHTTPClient.Do(req.WithContext(ctx))
+		if err == nil {
+			if resp.StatusCode < 300 {
+				return resp, nil
+			}
+
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusUnauthorized {
+				return nil, errCloudUnauthorized
+			}
+			err = fmt.Errorf("%s %s: %s: %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(body)))
+			if !cloudRetryable(resp.StatusCode, body) {
+				return nil, err
+			}
+		} else if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		if attempt >= cloudMaxAttempts {
+			return nil, err
+		}
+
+		wait := time.Duration(math.Pow(2, float64(attempt))) * time.Second
+		if resp != nil {
+			if seconds, convErr := strconv.Atoi(resp.Header.Get("Retry-After")); convErr == nil {
+				wait = time.Duration(seconds) * time.Second
+			}
+		}
+		if wait > cloudMaxRetryWait {
+			wait = cloudMaxRetryWait
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// cloudRetryable reports whether a failed request is worth retrying. Google
+// reports some rate limiting as 403 Forbidden.
+func cloudRetryable(status int, body []byte) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	case http.StatusForbidden:
+		return bytes.Contains(body, []byte("ateLimitExceeded"))
+	}
+	return false
+}
+
+// cloudJSON sends a request and decodes its JSON response into out
+func cloudJSON(ctx context.Context, out interface{}, newRequest func() (*http.Request, error)) error {
+	resp, err := cloudRequest(ctx, newRequest)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("invalid response from provider: %v", err)
+	}
+	return nil
+}
+
+// cloudTokenRequest posts an OAuth token request form
+func cloudTokenRequest(ctx context.Context, tokenURL string, form url.Values) (*cloudToken, error) {
+	var token cloudToken
+	err := cloudJSON(ctx, &token, func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if token.AccessToken == "" {
+		return nil, errors.New("provider returned no access token")
+	}
+	return &token, nil
+}
+
+func cloudAuthorizedRequest(method, rawURL, accessToken string, body []byte) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, rawURL, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
+
+func parseCloudTime(value string) *time.Time {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
+// Dropbox
+
+type dropboxProvider struct {
+	credentials CloudProviderCredentials
+}
+
+func (p *dropboxProvider) AuthURL(state, redirectURL string) string {
+	return "https://www.dropbox.com/oauth2/authorize?" + url.Values{
+		"client_id":         {p.credentials.ClientID},
+		"response_type":     {"code"},
+		"redirect_uri":      {redirectURL},
+		"state":             {state},
+		"token_access_type": {"offline"},
+	}.Encode()
+}
+
+func (p *dropboxProvider) ExchangeCode(ctx context.Context, code, redirectURL string) (*cloudToken, error) {
+	return cloudTokenRequest(ctx, "https://api.dropboxapi.com/oauth2/token", url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURL},
+		"client_id":     {p.credentials.ClientID},
+		"client_secret": {p.credentials.ClientSecret},
+	})
+}
+
+func (p *dropboxProvider) RefreshToken(ctx context.Context, refreshToken string) (*cloudToken, error) {
+	return cloudTokenRequest(ctx, "https://api.dropboxapi.com/oauth2/token", url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+		"client_id":     {p.credentials.ClientID},
+		"client_secret": {p.credentials.ClientSecret},
+	})
+}
+
+func (p *dropboxProvider) Account(ctx context.Context, accessToken string) (*cloudAccount, error) {
+	var account struct {
+		AccountID string `json:"account_id"`
+		Email     string `json:"email"`
+		Name      struct {
+			DisplayName string `json:"display_name"`
+		} `json:"name"`
+	}
+	err := cloudJSON(ctx, &account, func() (*http.Request, error) {
+		return cloudAuthorizedRequest(http.MethodPost, "https://api.dropboxapi.com/2/users/get_current_account", accessToken, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &cloudAccount{ID: account.AccountID, Email: account.Email, Name: account.Name.DisplayName}, nil
+}
+
+func (p *dropboxProvider) List(ctx context.Context, accessToken, folderID string) ([]models.CloudItem, error) {
+	type dropboxEntry struct {
+		Tag            string `json:".tag"`
+		ID             string `json:"id"`
+		Name           string `json:"name"`
+		PathDisplay    string `json:"path_display"`
+		Size           int64  `json:"size"`
+		ServerModified string `json:"server_modified"`
+	}
+	var page struct {
+		Entries []dropboxEntry `json:"entries"`
+		Cursor  string         `json:"cursor"`
+		HasMore bool           `json:"has_more"`
+	}
+
+	endpoint := "https://api.dropboxapi.com/2/files/list_folder"
+	body, _ := json.Marshal(map[string]interface{}{"path": folderID, "limit": 2000})
+
+	items := []models.CloudItem{}
+	for {
+		page.Entries, page.HasMore = nil, false
+		err := cloudJSON(ctx, &page, func() (*http.Request, error) {
+			return cloudAuthorizedRequest(http.MethodPost, endpoint, accessToken, body)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range page.Entries {
+			if entry.Tag != "file" && entry.Tag != "folder" {
+				continue
+			}
+			items = append(items, models.CloudItem{
+				ID:         entry.ID,
+				Name:       entry.Name,
+				Path:       entry.PathDisplay,
+				IsFolder:   entry.Tag == "folder",
+				Size:       entry.Size,
+				ModifiedAt: parseCloudTime(entry.ServerModified),
+			})
+		}
+
+		if !page.HasMore {
+			return items, nil
+		}
+		endpoint = "https://api.dropboxapi.com/2/files/list_folder/continue"
+		body, _ = json.Marshal(map[string]string{"cursor": page.Cursor})
+	}
+}
+
+func (p *dropboxProvider) Download(ctx context.Context, accessToken string, item *models.CloudItem) (io.ReadCloser, string, error) {
+	arg, _ := json.Marshal(map[string]string{"path": item.ID})
+	resp, err := cloudRequest(ctx, func() (*http.Request, error) {
+		req, err := cloudAuthorizedRequest(http.MethodPost, "https://content.dropboxapi.com/2/files/download", accessToken, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Dropbox-API-Arg", string(arg))
+		return req, nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return resp.Body, item.Name, nil
+}
+
+// Google Drive
+
+const googleFolderMimeType = "application/vnd.google-apps.folder"
+
+// googleExportFormats maps Google Docs, Sheets, Slides and Drawings, which
+// have no content of their own, to the format they are exported as
+var googleExportFormats = map[string]struct{ mimeType, extension string }{
+	"application/vnd.google-apps.document":     {"application/vnd.openxmlformats-officedocument.wordprocessingml.document", ".docx"},
+	"application/vnd.google-apps.spreadsheet":  {"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", ".xlsx"},
+	"application/vnd.google-apps.presentation": {"application/vnd.openxmlformats-officedocument.presentationml.presentation", ".pptx"},
+	"application/vnd.google-apps.drawing":      {"image/png", ".png"},
+}
+
+type googleDriveProvider struct {
+	credentials CloudProviderCredentials
+}
+
+func (p *googleDriveProvider) AuthURL(state, redirectURL string) string {
+	return "https://accounts.google.com/o/oauth2/v2/auth?" + url.Values{
+		"client_id":     {p.credentials.ClientID},
+		"response_type": {"code"},
+		"redirect_uri":  {redirectURL},
+		"scope":         {"https://www.googleapis.com/auth/drive.readonly"},
+		"access_type":   {"offline"},
+		"prompt":        {"consent"},
+		"state":         {state},
+	}.Encode()
+}
+
+func (p *googleDriveProvider) ExchangeCode(ctx context.Context, code, redirectURL string) (*cloudToken, error) {
+	return cloudTokenRequest(ctx, "https://oauth2.googleapis.com/token", url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURL},
+		"client_id":     {p.credentials.ClientID},
+		"client_secret": {p.credentials.ClientSecret},
+	})
+}
+
+func (p *googleDriveProvider) RefreshToken(ctx context.Context, refreshToken string) (*cloudToken, error) {
+	return cloudTokenRequest(ctx, "https://oauth2.googleapis.com/token", url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+		"client_id":     {p.credentials.ClientID},
+		"client_secret": {p.credentials.ClientSecret},
+	})
+}
+
+func (p *googleDriveProvider) Account(ctx context.Context, accessToken string) (*cloudAccount, error) {
+	var about struct {
+		User struct {
+			PermissionID string `json:"permissionId"`
+			DisplayName  string `json:"displayName"`
+			EmailAddress string `json:"emailAddress"`
+		} `json:"user"`
+	}
+	err := cloudJSON(ctx, &about, func() (*http.Request, error) {
+		return cloudAuthorizedRequest(http.MethodGet,
+			"https://www.googleapis.com/drive/v3/about?fields=user(permissionId,displayName,emailAddress)", accessToken, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &cloudAccount{ID: about.User.PermissionID, Email: about.User.EmailAddress, Name: about.User.DisplayName}, nil
+}
+
+func (p *googleDriveProvider) List(ctx context.Context, accessToken, folderID string) ([]models.CloudItem, error) {
+	if folderID == "" {
+		folderID = "root"
+	}
+
+	var page struct {
+		NextPageToken string `json:"nextPageToken"`
+		Files         []struct {
+			ID           string `json:"id"`
+			Name         string `json:"name"`
+			MimeType     string `json:"mimeType"`
+			Size         string `json:"size"`
+			MD5Checksum  string `json:"md5Checksum"`
+			ModifiedTime string `json:"modifiedTime"`
+		} `json:"files"`
+	}
+
+	query := url.Values{
+		"q":        {fmt.Sprintf("'%s' in parents and trashed = false", strings.ReplaceAll(folderID, "'", `\'`))},
+		"fields":   {"nextPageToken,files(id,name,mimeType,size,md5Checksum,modifiedTime)"},
+		"pageSize": {"1000"},
+		"orderBy":  {"folder,name"},
+	}
+
+	items := []models.CloudItem{}
+	for {
+		page.NextPageToken, page.Files = "", nil
+		err := cloudJSON(ctx, &page, func() (*http.Request, error) {
+			return cloudAuthorizedRequest(http.MethodGet, "https://www.googleapis.com/drive/v3/files?"+query.Encode(), accessToken, nil)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range page.Files {
+			size, _ := strconv.ParseInt(file.Size, 10, 64)
+			items = append(items, models.CloudItem{
+				ID:         file.ID,
+				Name:       file.Name,
+				IsFolder:   file.MimeType == googleFolderMimeType,
+				Size:       size,
+				MimeType:   file.MimeType,
+				MD5:        file.MD5Checksum,
+				ModifiedAt: parseCloudTime(file.ModifiedTime),
+			})
+		}
+
+		if page.NextPageToken == "" {
+			return items, nil
+		}
+		query.Set("pageToken", page.NextPageToken)
+	}
+}
+
+func (p *googleDriveProvider) Download(ctx context.Context, accessToken string, item *models.CloudItem) (io.ReadCloser, string, error) {
+	name := item.Name
+	endpoint := "https://www.googleapis.com/drive/v3/files/" + url.PathEscape(item.ID) + "?alt=media"
+	if strings.HasPrefix(item.MimeType, "application/vnd.google-apps.") {
+		format, ok := googleExportFormats[item.MimeType]
+		if !ok {
+			return nil, "", fmt.Errorf("%s files cannot be imported", strings.TrimPrefix(item.MimeType, "application/vnd.google-apps."))
+		}
+		endpoint = "https://www.googleapis.com/drive/v3/files/" + url.PathEscape(item.ID) + "/export?" +
+			url.Values{"mimeType": {format.mimeType}}.Encode()
+		if !strings.HasSuffix(strings.ToLower(name), format.extension) {
+			name += format.extension
+		}
+	}
+
+	resp, err := cloudRequest(ctx, func() (*http.Request, error) {
+		return cloudAuthorizedRequest(http.MethodGet, endpoint, accessToken, nil)
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return resp.Body, name, nil
+}
+
+// OneDrive
+
+type oneDriveProvider struct {
+	credentials CloudProviderCredentials
+}
+
+const oneDriveScope = "offline_access Files.Read.All User.Read"
+
+func (p *oneDriveProvider) AuthURL(state, redirectURL string) string {
+	return "https://login.microsoftonline.com/common/oauth2/v2.0/authorize?" + url.Values{
+		"client_id":     {p.credentials.ClientID},
+		"response_type": {"code"},
+		"redirect_uri":  {redirectURL},
+		"scope":         {oneDriveScope},
+		"state":         {state},
+	}.Encode()
+}
+
+func (p *oneDriveProvider) ExchangeCode(ctx context.Context, code, redirectURL string) (*cloudToken, error) {
+	return cloudTokenRequest(ctx, "https://login.microsoftonline.com/common/oauth2/v2.0/token", url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURL},
+		"scope":         {oneDriveScope},
+		"client_id":     {p.credentials.ClientID},
+		"client_secret": {p.credentials.ClientSecret},
+	})
+}
+
+func (p *oneDriveProvider) RefreshToken(ctx context.Context, refreshToken string) (*cloudToken, error) {
+	return cloudTokenRequest(ctx, "https://login.microsoftonline.com/common/oauth2/v2.0/token", url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+		"scope":         {oneDriveScope},
+		"client_id":     {p.credentials.ClientID},
+		"client_secret": {p.credentials.ClientSecret},
+	})
+}
+
+func (p *oneDriveProvider) Account(ctx context.Context, accessToken string) (*cloudAccount, error) {
+	var me struct {
+		ID                string `json:"id"`
+		DisplayName       string `json:"displayName"`
+		Mail              string `json:"mail"`
+		UserPrincipalName string `json:"userPrincipalName"`
+	}
+	err := cloudJSON(ctx, &me, func() (*http.Request, error) {
+		return cloudAuthorizedRequest(http.MethodGet, "https://graph.microsoft.com/v1.0/me", accessToken, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	email := me.Mail
+	if email == "" {
+		email = me.UserPrincipalName
+	}
+	return &cloudAccount{ID: me.ID, Email: email, Name: me.DisplayName}, nil
+}
+
+func (p *oneDriveProvider) List(ctx context.Context, accessToken, folderID string) ([]models.CloudItem, error) {
+	endpoint := "https://graph.microsoft.com/v1.0/me/drive/root/children"
+	if folderID != "" {
+		endpoint = "https://graph.microsoft.com/v1.0/me/drive/items/" + url.PathEscape(folderID) + "/children"
+	}
+	endpoint += "?$top=200&$select=id,name,size,folder,file,lastModifiedDateTime,parentReference"
+
+	var page struct {
+		NextLink string `json:"@odata.nextLink"`
+		Value    []struct {
+			ID     string           `json:"id"`
+			Name   string           `json:"name"`
+			Size   int64            `json:"size"`
+			Folder *json.RawMessage `json:"folder"`
+			File   *struct {
+				MimeType string `json:"mimeType"`
+			} `json:"file"`
+			LastModifiedDateTime string `json:"lastModifiedDateTime"`
+			ParentReference      struct {
+				Path string `json:"path"`
+			} `json:"parentReference"`
+		} `json:"value"`
+	}
+
+	items := []models.CloudItem{}
+	for endpoint != "" {
+		page.NextLink, page.Value = "", nil
+		err := cloudJSON(ctx, &page, func() (*http.Request, error) {
+			return cloudAuthorizedRequest(http.MethodGet, endpoint, accessToken, nil)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range page.Value {
+			if entry.Folder == nil && entry.File == nil {
+				continue // e.g. OneNote notebooks
+			}
+			item := models.CloudItem{
+				ID:         entry.ID,
+				Name:       entry.Name,
+				Path:       strings.TrimPrefix(entry.ParentReference.Path, "/drive/root:") + "/" + entry.Name,
+				IsFolder:   entry.Folder != nil,
+				Size:       entry.Size,
+				ModifiedAt: parseCloudTime(entry.LastModifiedDateTime),
+			}
+			if entry.File != nil {
+				item.MimeType = entry.File.MimeType
+			}
+			items = append(items, item)
+		}
+		endpoint = page.NextLink
+	}
+	return items, nil
+}
+
+func (p *oneDriveProvider) Download(ctx context.Context, accessToken string, item *models.CloudItem) (io.ReadCloser, string, error) {
+	resp, err := cloudRequest(ctx, func() (*http.Request, error) {
+		return cloudAuthorizedRequest(http.MethodGet,
+			"https://graph.microsoft.com/v1.0/me/drive/items/"+url.PathEscape(item.ID)+"/content", accessToken, nil)
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return resp.Body, item.Name, nil
+}
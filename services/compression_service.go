@@ -0,0 +1,172 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"oncloud/database"
+	"oncloud/models"
+	"oncloud/utils"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// minCompressibleSize is the smallest file compressed at rest; smaller
+	// ones don't save enough to pay for decompressing them
+	minCompressibleSize = 1024
+	// maxCompressedRatio is how big compressed content can be relative to
+	// the original and still be stored compressed
+	maxCompressedRatio = 0.9
+)
+
+var ErrInvalidSavingsGrouping = errors.New("savings can be grouped by user or provider")
+
+type CompressionService struct {
+	*BaseService
+}
+
+func NewCompressionService() *CompressionService {
+	return NewCompressionServiceWith(Dependencies{})
+}
+
+// NewCompressionServiceWith creates a compression service on the given
+// dependencies
+func NewCompressionServiceWith(deps Dependencies) *CompressionService {
+	return &CompressionService{
+		BaseService: NewBaseServiceWith(deps),
+	}
+}
+
+// compressForStorage returns the bytes to store for a file's content: the
+// content compressed with the encoding set for compression at rest when the
+// file's type is worth compressing and compressing it pays off, or the
+// content itself with no compression metadata otherwise
+func compressForStorage(mimeType, ext string, content []byte) ([]byte, *models.FileCompression) {
+	encoding := RuntimeSettingString(RuntimeSettingCompressionAtRest)
+	if encoding == "" || len(content) < minCompressibleSize || !utils.IsCompressible(mimeType, ext) {
+		return content, nil
+	}
+
+	compressed, err := utils.Compress(encoding, content)
+	if err != nil {
+		log.Printf("Failed to compress content with %s: %v", encoding, err)
+		return content, nil
+	}
+	if float64(len(compressed)) > float64(len(content))*maxCompressedRatio {
+		return content, nil
+	}
+
+	return compressed, &models.FileCompression{
+		Encoding:     encoding,
+		OriginalSize: int64(len(content)),
+		StoredSize:   int64(len(compressed)),
+	}
+}
+
+// ReadFileContent returns a file's content as it was uploaded, decompressed
+// when it is stored compressed
+func (ss *StorageService) ReadFileContent(ctx context.Context, file *models.File) ([]byte, error) {
+	return ss.readStoredContent(ctx, file.StorageProvider, file.StorageKey, file.Compression)
+}
+
+// readStoredContent reads an object and decompresses it when it was stored
+// compressed
+func (ss *StorageService) readStoredContent(ctx context.Context, providerType, storageKey string, compression *models.FileCompression) ([]byte, error) {
+	content, err := ss.DownloadFile(ctx, providerType, storageKey)
+	if err != nil || compression == nil {
+		return content, err
+	}
+
+	original, err := utils.Decompress(compression.Encoding, content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress %s: %v", storageKey, err)
+	}
+	return original, nil
+}
+
+// GetSavings reports the space compression at rest saves, grouped by the
+// files' owner or storage provider, biggest savings first
+func (cs *CompressionService) GetSavings(ctx context.Context, groupBy string) (*models.CompressionSavingsReport, error) {
+	var key string
+	switch groupBy {
+	case "user":
+		key = "$user_id"
+	case "provider":
+		key = "$storage_provider"
+	default:
+		return nil, ErrInvalidSavingsGrouping
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	pipeline := []bson.M{
+		{"$match": bson.M{"compression": bson.M{"$exists": true}, "is_deleted": false}},
+		{"$group": bson.M{
+			"_id":            key,
+			"files":          bson.M{"$sum": 1},
+			"original_bytes": bson.M{"$sum": "$compression.original_size"},
+			"stored_bytes":   bson.M{"$sum": "$compression.stored_size"},
+		}},
+		{"$addFields": bson.M{"saved_bytes": bson.M{"$subtract": []string{"$original_bytes", "$stored_bytes"}}}},
+		{"$sort": bson.M{"saved_bytes": -1}},
+	}
+	if groupBy == "user" {
+		pipeline = append(pipeline,
+			bson.M{"$lookup": bson.M{
+				"from":         database.UsersCollection,
+				"localField":   "_id",
+				"foreignField": "_id",
+				"as":           "user",
+			}},
+			bson.M{"$addFields": bson.M{"email": bson.M{"$first": "$user.email"}}},
+			bson.M{"$project": bson.M{"user": 0}},
+		)
+	}
+
+	cursor, err := cs.collections.Files().Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate compression savings: %v", err)
+	}
+	var groups []struct {
+		ID            interface{} `bson:"_id"`
+		Email         string      `bson:"email"`
+		Files         int64       `bson:"files"`
+		OriginalBytes int64       `bson:"original_bytes"`
+		StoredBytes   int64       `bson:"stored_bytes"`
+		SavedBytes    int64       `bson:"saved_bytes"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, fmt.Errorf("failed to decode compression savings: %v", err)
+	}
+
+	report := &models.CompressionSavingsReport{
+		GroupBy:  groupBy,
+		Encoding: RuntimeSettingString(RuntimeSettingCompressionAtRest),
+		Groups:   make([]models.CompressionSavings, 0, len(groups)),
+	}
+	for _, group := range groups {
+		savings := models.CompressionSavings{
+			Key:           fmt.Sprint(group.ID),
+			Email:         group.Email,
+			Files:         group.Files,
+			OriginalBytes: group.OriginalBytes,
+			StoredBytes:   group.StoredBytes,
+			SavedBytes:    group.SavedBytes,
+		}
+		if id, ok := group.ID.(primitive.ObjectID); ok {
+			savings.Key = id.Hex()
+		}
+		report.Groups = append(report.Groups, savings)
+
+		report.Total.Files += group.Files
+		report.Total.OriginalBytes += group.OriginalBytes
+		report.Total.StoredBytes += group.StoredBytes
+		report.Total.SavedBytes += group.SavedBytes
+	}
+	return report, nil
+}
@@ -0,0 +1,88 @@
+package services
+
+import (
+	"context"
+	"oncloud/database"
+	"oncloud/models"
+	"oncloud/utils"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Database is the database services work on. database.Manager is the
+// application's.
+type Database interface {
+	database.CollectionSource
+	GetDatabase() *mongo.Database
+}
+
+// ObjectStore stores file contents on the storage providers. StorageService
+// is the application's.
+type ObjectStore interface {
+	UploadFile(ctx context.Context, providerType, storageKey string, fileContent []byte) error
+	DownloadFile(ctx context.Context, providerType, storageKey string) ([]byte, error)
+	DeleteFile(ctx context.Context, providerType, storageKey string) error
+	CopyFile(ctx context.Context, sourceProviderType, sourceKey, destProviderType, destKey string) error
+	ReadFileContent(ctx context.Context, file *models.File) ([]byte, error)
+	GetPresignedURL(ctx context.Context, providerType, storageKey string, expiration time.Duration, operation string) (string, error)
+	NewStorageKey(ctx context.Context, providerType string, vars utils.StorageKeyVars) (string, error)
+}
+
+// Mailer delivers an email
+type Mailer interface {
+	Send(to, subject, text, html string) error
+}
+
+// Clock tells the time
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the clock of the machine
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Dependencies are what services are built on. NewApplication wires the
+// application's and passes them to the NewXWith functions of the services
+// it builds; tests pass fakes. Services built with their New functions, and
+// unset fields, use the application's defaults.
+type Dependencies struct {
+	Database Database
+	Storage  ObjectStore
+	Mailer   Mailer
+	Clock    Clock
+}
+
+func (d Dependencies) database() Database {
+	if d.Database != nil {
+		return d.Database
+	}
+	return database.GetManager()
+}
+
+// storage returns the object store, a new StorageService on the database
+// by default as it looks its collections up when built
+func (d Dependencies) storage() ObjectStore {
+	if d.Storage != nil {
+		return d.Storage
+	}
+	return NewStorageServiceWith(d)
+}
+
+func (d Dependencies) mailer() Mailer {
+	if d.Mailer != nil {
+		return d.Mailer
+	}
+	return SMTPMailer{}
+}
+
+func (d Dependencies) clock() Clock {
+	if d.Clock != nil {
+		return d.Clock
+	}
+	return SystemClock{}
+}
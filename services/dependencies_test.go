@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"oncloud/models"
+	"oncloud/utils"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// fakeClock is a clock tests set and move
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+// fakeObjectStore keeps objects in memory by provider and key
+type fakeObjectStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	reads   int
+}
+
+func newFakeObjectStore() *fakeObjectStore {
+	return &fakeObjectStore{objects: make(map[string][]byte)}
+}
+
+func (s *fakeObjectStore) UploadFile(ctx context.Context, providerType, storageKey string, fileContent []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[providerType+"/"+storageKey] = append([]byte(nil), fileContent...)
+	return nil
+}
+
+func (s *fakeObjectStore) DownloadFile(ctx context.Context, providerType, storageKey string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reads++
+	content, ok := s.objects[providerType+"/"+storageKey]
+	if !ok {
+		return nil, fmt.Errorf("object %s not found", storageKey)
+	}
+	return content, nil
+}
+
+func (s *fakeObjectStore) DeleteFile(ctx context.Context, providerType, storageKey string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, providerType+"/"+storageKey)
+	return nil
+}
+
+func (s *fakeObjectStore) CopyFile(ctx context.Context, sourceProviderType, sourceKey, destProviderType, destKey string) error {
+	content, err := s.DownloadFile(ctx, sourceProviderType, sourceKey)
+	if err != nil {
+		return err
+	}
+	return s.UploadFile(ctx, destProviderType, destKey, content)
+}
+
+func (s *fakeObjectStore) ReadFileContent(ctx context.Context, file *models.File) ([]byte, error) {
+	return s.DownloadFile(ctx, file.StorageProvider, file.StorageKey)
+}
+
+func (s *fakeObjectStore) GetPresignedURL(ctx context.Context, providerType, storageKey string, expiration time.Duration, operation string) (string, error) {
+	return fmt.Sprintf("https://%s.example.com/%s?op=%s", providerType, storageKey, operation), nil
+}
+
+func (s *fakeObjectStore) NewStorageKey(ctx context.Context, providerType string, vars utils.StorageKeyVars) (string, error) {
+	return fmt.Sprintf("%s/%s", vars.UserID, vars.Name), nil
+}
+
+// testDatabaseSource serves a test database to services
+type testDatabaseSource struct {
+	*mongo.Database
+}
+
+func (s testDatabaseSource) GetCollection(name string) *mongo.Collection {
+	return s.Collection(name)
+}
+
+func (s testDatabaseSource) GetDatabase() *mongo.Database {
+	return s.Database
+}
+
+// offlineDatabase returns a database on a client that never connects, for
+// services that only need collections to be built
+func offlineDatabase(t *testing.T) testDatabaseSource {
+	t.Helper()
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI("mongodb://127.0.0.1:1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+	return testDatabaseSource{client.Database("oncloud_offline")}
+}
+
+func TestFileServiceBuildsCollaboratorsOnItsDependencies(t *testing.T) {
+	db := offlineDatabase(t)
+	clock := &fakeClock{now: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)}
+	store := newFakeObjectStore()
+	fs := NewFileServiceWith(Dependencies{Database: db, Storage: store, Clock: clock})
+
+	if fs.storageService != store {
+		t.Error("file service does not use the injected object store")
+	}
+	if fs.storageClasses.fileService != fs {
+		t.Error("storage class service works on another file service")
+	}
+	for name, base := range map[string]*BaseService{
+		"file":          fs.BaseService,
+		"usage":         fs.usage.BaseService,
+		"upload rules":  fs.uploadRules.BaseService,
+		"virus scans":   fs.virusScans.BaseService,
+		"receipts":      fs.receipts.BaseService,
+		"downloads":     fs.downloads.BaseService,
+		"abuse reports": fs.abuseReports.BaseService,
+		"folder usage":  fs.uploadRules.folderService.usage.BaseService,
+	} {
+		if base.clock != clock {
+			t.Errorf("%s service does not use the injected clock", name)
+		}
+		if base.GetDatabase() != db.Database {
+			t.Errorf("%s service does not use the injected database", name)
+		}
+	}
+	if fs.uploadRules.folderService.folderCollection.Database() != db.Database {
+		t.Error("folder service does not use the injected database")
+	}
+}
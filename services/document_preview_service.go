@@ -0,0 +1,269 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"oncloud/models"
+	"oncloud/utils"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DocumentPreviewOptions configures rendering PDF pages to images with
+// poppler's pdftoppm
+type DocumentPreviewOptions struct {
+	Renderer string // path to pdftoppm
+	DPI      int
+	Timeout  time.Duration // to render one page
+	MaxPages int           // later pages are not shown
+}
+
+var documentPreviewOptions *DocumentPreviewOptions
+
+var (
+	// ErrShareViewOnly is returned when a view-only share is asked for its
+	// file rather than its page images
+	ErrShareViewOnly = errors.New("this file can only be viewed, not downloaded")
+	// ErrPreviewUnavailable is returned for files whose pages cannot be
+	// rendered, so a view-only share shows nothing of them
+	ErrPreviewUnavailable  = errors.New("this file cannot be viewed in the browser")
+	ErrPreviewPageNotFound = notFoundError("page not found")
+)
+
+// InitDocumentPreview enables rendering PDF pages
+func InitDocumentPreview(opts DocumentPreviewOptions) {
+	if opts.DPI <= 0 {
+		opts.DPI = 110
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 30 * time.Second
+	}
+	if opts.MaxPages <= 0 {
+		opts.MaxPages = 300
+	}
+	documentPreviewOptions = &opts
+}
+
+// DocumentPreviewEnabled reports whether PDF pages are rendered
+func DocumentPreviewEnabled() bool {
+	return documentPreviewOptions != nil
+}
+
+type DocumentPreviewService struct {
+	*BaseService
+	storageService ObjectStore
+	fileService    *FileService
+}
+
+func NewDocumentPreviewService() *DocumentPreviewService {
+	return NewDocumentPreviewServiceWith(Dependencies{})
+}
+
+// NewDocumentPreviewServiceWith creates a document preview service on the
+// given dependencies
+func NewDocumentPreviewServiceWith(deps Dependencies) *DocumentPreviewService {
+	return &DocumentPreviewService{
+		BaseService:    NewBaseServiceWith(deps),
+		storageService: deps.storage(),
+		fileService:    NewFileServiceWith(deps),
+	}
+}
+
+// Previewable reports whether file can be shown as page images: JPEG, PNG
+// and GIF images, and PDFs once page rendering is enabled
+func Previewable(file *models.File) bool {
+	switch strings.ToLower(file.MimeType) {
+	case "image/jpeg", "image/png", "image/gif":
+		return true
+	case "application/pdf":
+		return DocumentPreviewEnabled()
+	}
+	return false
+}
+
+// GetSharedPages lists the page images of the file a view-only share shows.
+// Shares that watermark show them to viewers who give their email, which
+// the page URLs carry.
+func (dps *DocumentPreviewService) GetSharedPages(ctx context.Context, token, email string) (*models.SharePages, error) {
+	share, file, err := dps.viewOnlyFile(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if share.Watermark && email == "" {
+		return nil, ErrShareRecipientRequired
+	}
+
+	count := 1
+	if strings.EqualFold(file.MimeType, "application/pdf") {
+		content, err := dps.storageService.ReadFileContent(ctx, file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get file content: %v", err)
+		}
+		if count, err = utils.PDFPageCount(content); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrPreviewUnavailable, err)
+		}
+	}
+
+	pages := &models.SharePages{
+		Name:      fileDisplayName(file),
+		MimeType:  file.MimeType,
+		PageCount: count,
+		Pages:     []models.SharePage{},
+	}
+	if DocumentPreviewEnabled() {
+		count = min(count, documentPreviewOptions.MaxPages)
+	}
+	query := ""
+	if email != "" {
+		query = "?email=" + url.QueryEscape(email)
+	}
+	for number := 1; number <= count; number++ {
+		pages.Pages = append(pages.Pages, models.SharePage{
+			Number: number,
+			URL:    fmt.Sprintf("/api/v1/shared/%s/pages/%d%s", share.Token, number, query),
+		})
+	}
+	return pages, nil
+}
+
+// ServeSharedPage serves a page of the file a view-only share shows, as an
+// image watermarked with the viewer's email, or their IP address when the
+// share does not ask for it, and the time
+func (dps *DocumentPreviewService) ServeSharedPage(ctx context.Context, token string, number int, email, clientIP string, w http.ResponseWriter, r *http.Request) error {
+	share, file, err := dps.viewOnlyFile(ctx, token)
+	if err != nil {
+		return err
+	}
+	viewer := email
+	if viewer == "" {
+		if share.Watermark {
+			return ErrShareRecipientRequired
+		}
+		viewer = clientIP
+	}
+
+	var page []byte
+	if strings.EqualFold(file.MimeType, "application/pdf") {
+		if number < 1 || number > documentPreviewOptions.MaxPages {
+			return ErrPreviewPageNotFound
+		}
+		page, err = dps.renderedPage(ctx, file, number)
+	} else {
+		if number != 1 {
+			return ErrPreviewPageNotFound
+		}
+		page, err = dps.storageService.ReadFileContent(ctx, file)
+	}
+	if err != nil {
+		return err
+	}
+
+	lines := []string{viewer, time.Now().UTC().Format("2006-01-02 15:04 MST")}
+	watermarked, contentType, err := utils.WatermarkImage(page, lines)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPreviewUnavailable, err)
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(watermarked)))
+	w.Header().Set("Content-Disposition", "inline")
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		w.Write(watermarked)
+	}
+	return nil
+}
+
+// viewOnlyFile returns the view-only share behind a token and the file it
+// shows, if the file may be shown
+func (dps *DocumentPreviewService) viewOnlyFile(ctx context.Context, token string) (*models.FileShare, *models.File, error) {
+	share, file, err := dps.fileService.GetSharedFile(ctx, token)
+	if err != nil || !share.ViewOnly {
+		return nil, nil, ErrShareLinkNotFound
+	}
+	if err := checkTakedown(file.TakedownID); err != nil {
+		return nil, nil, err
+	}
+	if err := checkFileScan(file); err != nil {
+		return nil, nil, err
+	}
+	if !Previewable(file) {
+		return nil, nil, ErrPreviewUnavailable
+	}
+	return share, file, nil
+}
+
+// renderedPage returns a page of a PDF rendered to PNG, rendering it when
+// it has not been rendered from the file's current content yet
+func (dps *DocumentPreviewService) renderedPage(ctx context.Context, file *models.File, number int) ([]byte, error) {
+	version := file.Hash
+	if version == "" {
+		version = strconv.FormatInt(file.UpdatedAt.Unix(), 10)
+	}
+	key := fmt.Sprintf("previews/%s/%s/page-%d.png", file.ID.Hex(), version, number)
+	if page, err := dps.storageService.DownloadFile(ctx, file.StorageProvider, key); err == nil {
+		return page, nil
+	}
+
+	content, err := dps.storageService.ReadFileContent(ctx, file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file content: %v", err)
+	}
+	page, err := renderPDFPage(content, number)
+	if err != nil {
+		return nil, err
+	}
+
+	// A page that cannot be cached is still shown, and rendered again next time
+	if err := dps.storageService.UploadFile(ctx, file.StorageProvider, key, page); err != nil {
+		log.Printf("Failed to cache page %d of file %s: %v", number, file.ID.Hex(), err)
+	}
+	return page, nil
+}
+
+// renderPDFPage renders a page of a PDF to PNG with pdftoppm
+func renderPDFPage(content []byte, number int) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "preview-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create preview directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	input := filepath.Join(dir, "document.pdf")
+	if err := os.WriteFile(input, content, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write preview input: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), documentPreviewOptions.Timeout)
+	defer cancel()
+
+	page := strconv.Itoa(number)
+	output := filepath.Join(dir, "page")
+	cmd := exec.CommandContext(ctx, documentPreviewOptions.Renderer,
+		"-png", "-r", strconv.Itoa(documentPreviewOptions.DPI),
+		"-f", page, "-l", page, "-singlefile",
+		input, output,
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if strings.Contains(string(out), "Wrong page range") {
+			return nil, ErrPreviewPageNotFound
+		}
+		return nil, fmt.Errorf("%w: %v: %s", ErrPreviewUnavailable, err, strings.TrimSpace(string(out)))
+	}
+
+	rendered, err := os.ReadFile(output + ".png")
+	if err != nil {
+		return nil, ErrPreviewPageNotFound
+	}
+	return rendered, nil
+}
@@ -0,0 +1,263 @@
+package services
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"oncloud/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DownloadReceiptOptions configures signing download receipts
+type DownloadReceiptOptions struct {
+	SigningKey ed25519.PrivateKey
+}
+
+var downloadReceiptOptions *DownloadReceiptOptions
+
+var (
+	ErrDownloadReceiptsDisabled = errors.New("download receipts are not enabled")
+	ErrDownloadReceiptNotFound  = notFoundError("download receipt not found")
+
+	// ErrReceiptUnavailable is returned for downloads refused because their
+	// receipt could not be issued
+	ErrReceiptUnavailable = errors.New("download receipt could not be issued")
+)
+
+// InitDownloadReceipts enables signing receipts for downloads of files whose
+// owners ask for them
+func InitDownloadReceipts(opts DownloadReceiptOptions) {
+	downloadReceiptOptions = &opts
+}
+
+// DownloadReceiptsEnabled reports whether receipts can be signed
+func DownloadReceiptsEnabled() bool {
+	return downloadReceiptOptions != nil
+}
+
+// DownloadReceiptPublicKey returns the key receipts are verified with
+func DownloadReceiptPublicKey() (*models.DownloadReceiptKey, error) {
+	if !DownloadReceiptsEnabled() {
+		return nil, ErrDownloadReceiptsDisabled
+	}
+
+	publicKey := downloadReceiptOptions.SigningKey.Public().(ed25519.PublicKey)
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &models.DownloadReceiptKey{
+		KeyID:     downloadReceiptKeyID(),
+		Algorithm: "Ed25519",
+		PublicKey: base64.StdEncoding.EncodeToString(publicKey),
+		PEM:       string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+	}, nil
+}
+
+// downloadReceiptKeyID names the signing key, so receipts can be matched to
+// the key they were signed with after it is rotated
+func downloadReceiptKeyID() string {
+	sum := sha256.Sum256(downloadReceiptOptions.SigningKey.Public().(ed25519.PublicKey))
+	return hex.EncodeToString(sum[:8])
+}
+
+type DownloadReceiptService struct {
+	*BaseService
+	storageService ObjectStore
+}
+
+func NewDownloadReceiptService() *DownloadReceiptService {
+	return NewDownloadReceiptServiceWith(Dependencies{})
+}
+
+// NewDownloadReceiptServiceWith creates a download receipt service on the
+// given dependencies
+func NewDownloadReceiptServiceWith(deps Dependencies) *DownloadReceiptService {
+	return &DownloadReceiptService{
+		BaseService:    NewBaseServiceWith(deps),
+		storageService: deps.storage(),
+	}
+}
+
+// SetFileReceipts turns receipts for every download of the owner's file on or
+// off. Turning them on hashes the file's content.
+func (rs *DownloadReceiptService) SetFileReceipts(ctx context.Context, userID, fileID primitive.ObjectID, enabled bool) (*models.File, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	var file models.File
+	err := rs.collections.Files().FindOne(ctx, bson.M{"_id": fileID, "user_id": userID, "is_deleted": false}).Decode(&file)
+	if err != nil {
+		return nil, errors.New("file not found")
+	}
+
+	if !enabled {
+		if _, err := rs.collections.Files().UpdateOne(ctx,
+			bson.M{"_id": fileID},
+			bson.M{"$unset": bson.M{"receipts": ""}},
+		); err != nil {
+			return nil, fmt.Errorf("failed to disable receipts: %v", err)
+		}
+		file.Receipts = nil
+		return &file, nil
+	}
+
+	if !DownloadReceiptsEnabled() {
+		return nil, ErrDownloadReceiptsDisabled
+	}
+	if file.Receipts != nil {
+		return &file, nil
+	}
+
+	receipts := &models.FileReceipts{EnabledAt: time.Now()}
+	if receipts.SHA256, err = rs.hashContent(ctx, &file); err != nil {
+		return nil, err
+	}
+	receipts.StorageKey = file.StorageKey
+
+	if _, err := rs.collections.Files().UpdateOne(ctx,
+		bson.M{"_id": fileID, "storage_key": file.StorageKey},
+		bson.M{"$set": bson.M{"receipts": receipts}},
+	); err != nil {
+		return nil, fmt.Errorf("failed to enable receipts: %v", err)
+	}
+	file.Receipts = receipts
+	return &file, nil
+}
+
+// IssueReceipt signs and stores a receipt for a download of file, if the file
+// asks for receipts. Downloads must not go ahead when this fails, so every
+// error wraps ErrReceiptUnavailable.
+func (rs *DownloadReceiptService) IssueReceipt(ctx context.Context, file *models.File, downloader models.ReceiptDownloader) (*models.DownloadReceipt, error) {
+	if file.Receipts == nil {
+		return nil, nil
+	}
+	if !DownloadReceiptsEnabled() {
+		return nil, fmt.Errorf("%w: %v", ErrReceiptUnavailable, ErrDownloadReceiptsDisabled)
+	}
+
+	digest, err := rs.contentSHA256(ctx, file)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrReceiptUnavailable, err)
+	}
+
+	name := fileDisplayName(file)
+	receipt := &models.DownloadReceipt{
+		ID:           primitive.NewObjectID(),
+		FileID:       file.ID,
+		OwnerID:      file.UserID,
+		SHA256:       digest,
+		Downloader:   downloader,
+		DownloadedAt: time.Now().UTC(),
+		KeyID:        downloadReceiptKeyID(),
+	}
+	payload, err := json.Marshal(models.DownloadReceiptPayload{
+		ReceiptID:    receipt.ID.Hex(),
+		FileID:       file.ID.Hex(),
+		FileName:     name,
+		FileSize:     file.Size,
+		SHA256:       digest,
+		Downloader:   downloader,
+		DownloadedAt: receipt.DownloadedAt,
+		KeyID:        receipt.KeyID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrReceiptUnavailable, err)
+	}
+	receipt.Payload = string(payload)
+	receipt.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(downloadReceiptOptions.SigningKey, payload))
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if _, err := rs.collections.DownloadReceipts().InsertOne(ctx, receipt); err != nil {
+		return nil, fmt.Errorf("%w: failed to save: %v", ErrReceiptUnavailable, err)
+	}
+	return receipt, nil
+}
+
+// ListFileReceipts returns the receipts for downloads of the owner's file,
+// newest first
+func (rs *DownloadReceiptService) ListFileReceipts(ctx context.Context, userID, fileID primitive.ObjectID, page, limit int) ([]models.DownloadReceipt, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	query := bson.M{"file_id": fileID, "owner_id": userID}
+	cursor, err := rs.collections.DownloadReceipts().Find(ctx, query,
+		options.Find().
+			SetSort(bson.M{"downloaded_at": -1}).
+			SetSkip(int64((page-1)*limit)).
+			SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	receipts := []models.DownloadReceipt{}
+	if err := cursor.All(ctx, &receipts); err != nil {
+		return nil, 0, err
+	}
+
+	total, err := rs.collections.DownloadReceipts().CountDocuments(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return receipts, int(total), nil
+}
+
+// GetReceipt returns one receipt for a download of the owner's files
+func (rs *DownloadReceiptService) GetReceipt(ctx context.Context, userID, receiptID primitive.ObjectID) (*models.DownloadReceipt, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var receipt models.DownloadReceipt
+	err := rs.collections.DownloadReceipts().FindOne(ctx, bson.M{"_id": receiptID, "owner_id": userID}).Decode(&receipt)
+	if err != nil {
+		return nil, ErrDownloadReceiptNotFound
+	}
+	return &receipt, nil
+}
+
+// contentSHA256 returns the digest of file's content, hashing it again when
+// the content changed since it was last hashed
+func (rs *DownloadReceiptService) contentSHA256(ctx context.Context, file *models.File) (string, error) {
+	if file.Receipts.StorageKey == file.StorageKey && file.Receipts.SHA256 != "" {
+		return file.Receipts.SHA256, nil
+	}
+
+	digest, err := rs.hashContent(ctx, file)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	rs.collections.Files().UpdateOne(ctx,
+		bson.M{"_id": file.ID, "storage_key": file.StorageKey, "receipts": bson.M{"$exists": true}},
+		bson.M{"$set": bson.M{"receipts.sha256": digest, "receipts.storage_key": file.StorageKey}},
+	)
+	return digest, nil
+}
+
+func (rs *DownloadReceiptService) hashContent(ctx context.Context, file *models.File) (string, error) {
+	content, err := rs.storageService.ReadFileContent(ctx, file)
+	if err != nil {
+		return "", fmt.Errorf("failed to read file: %v", err)
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,396 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"oncloud/models"
+	"oncloud/utils"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultDownloadTokenTTL = 1 * time.Hour
+	maxDownloadTokenTTL     = 24 * time.Hour
+)
+
+// Download token errors. Tokens that fail to verify or are unknown are
+// reported alike, so tokens cannot be probed.
+var (
+	ErrDownloadTokenInvalid = forbiddenError("invalid or expired download token")
+	ErrDownloadTokenRevoked = forbiddenError("download token has been revoked")
+	ErrDownloadTokenExpired = forbiddenError("download token has expired")
+)
+
+type DownloadService struct {
+	*BaseService
+	hotFileService *HotFileCacheService
+	receipts       *DownloadReceiptService
+	analytics      *AnalyticsService
+}
+
+func NewDownloadService() *DownloadService {
+	return NewDownloadServiceWith(Dependencies{})
+}
+
+// NewDownloadServiceWith creates a download service on the given
+// dependencies
+func NewDownloadServiceWith(deps Dependencies) *DownloadService {
+	return &DownloadService{
+		BaseService:    NewBaseServiceWith(deps),
+		hotFileService: NewHotFileCacheServiceWith(deps),
+		receipts:       NewDownloadReceiptServiceWith(deps),
+		analytics:      NewAnalyticsServiceWith(deps),
+	}
+}
+
+// CreateDownloadToken issues a short-lived signed token for ranged access to one file
+func (ds *DownloadService) CreateDownloadToken(ctx context.Context, userID, fileID primitive.ObjectID, ttl time.Duration) (*models.DownloadTokenResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var file models.File
+	err := ds.collections.Files().FindOne(ctx, bson.M{
+		"_id":        fileID,
+		"user_id":    userID,
+		"is_deleted": false,
+	}).Decode(&file)
+	if err != nil {
+		return nil, fmt.Errorf("file not found: %v", err)
+	}
+
+	if ttl <= 0 {
+		ttl = defaultDownloadTokenTTL
+	}
+	if ttl > maxDownloadTokenTTL {
+		ttl = maxDownloadTokenTTL
+	}
+
+	now := ds.clock.Now()
+	record := &models.DownloadToken{
+		ID:        primitive.NewObjectID(),
+		FileID:    fileID,
+		UserID:    userID,
+		FileSize:  file.Size,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	}
+
+	signed, err := utils.GenerateDownloadToken(record.ID, fileID, record.ExpiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign download token: %v", err)
+	}
+
+	if _, err := ds.collections.DownloadTokens().InsertOne(ctx, record); err != nil {
+		return nil, fmt.Errorf("failed to create download token: %v", err)
+	}
+
+	return &models.DownloadTokenResponse{
+		Token:       signed,
+		DownloadURL: fmt.Sprintf("/api/v1/downloads/%s", signed),
+		Download:    record,
+	}, nil
+}
+
+// proxiedDownloadURL issues a token for the server to serve a file through
+// in place of a presigned URL, for files its provider can't serve as they
+// were uploaded. The download was already receipted and counted by the
+// caller, so serving the token doesn't do either again.
+func (ds *DownloadService) proxiedDownloadURL(ctx context.Context, file *models.File, ttl time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	now := ds.clock.Now()
+	record := &models.DownloadToken{
+		ID:        primitive.NewObjectID(),
+		FileID:    file.ID,
+		UserID:    file.UserID,
+		FileSize:  file.Size,
+		Proxied:   true,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	}
+
+	signed, err := utils.GenerateDownloadToken(record.ID, file.ID, record.ExpiresAt)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign download token: %v", err)
+	}
+	if _, err := ds.collections.DownloadTokens().InsertOne(ctx, record); err != nil {
+		return "", fmt.Errorf("failed to create download token: %v", err)
+	}
+
+	return fmt.Sprintf("/api/v1/downloads/%s", signed), nil
+}
+
+// GetUserDownloadTokens returns the user's download tokens, optionally for one file
+func (ds *DownloadService) GetUserDownloadTokens(ctx context.Context, userID primitive.ObjectID, fileID *primitive.ObjectID, page, limit int) ([]models.DownloadToken, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{"user_id": userID, "proxied": bson.M{"$ne": true}}
+	if fileID != nil {
+		filter["file_id"] = *fileID
+	}
+
+	skip := (page - 1) * limit
+	cursor, err := ds.collections.DownloadTokens().Find(ctx, filter,
+		options.Find().
+			SetSort(bson.M{"created_at": -1}).
+			SetSkip(int64(skip)).
+			SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var tokens []models.DownloadToken
+	if err = cursor.All(ctx, &tokens); err != nil {
+		return nil, 0, err
+	}
+
+	total, err := ds.collections.DownloadTokens().CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return tokens, int(total), nil
+}
+
+// RevokeDownloadToken revokes a download token so it can no longer be used
+func (ds *DownloadService) RevokeDownloadToken(ctx context.Context, userID, tokenID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	now := ds.clock.Now()
+	result, err := ds.collections.DownloadTokens().UpdateOne(ctx,
+		bson.M{"_id": tokenID, "user_id": userID},
+		bson.M{"$set": bson.M{
+			"is_revoked": true,
+			"revoked_at": now,
+		}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to revoke download token: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("download token not found")
+	}
+
+	return nil
+}
+
+// RevokeFileDownloadTokens revokes every outstanding token for a file
+func (ds *DownloadService) RevokeFileDownloadTokens(ctx context.Context, userID, fileID primitive.ObjectID) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := ds.collections.DownloadTokens().UpdateMany(ctx,
+		bson.M{"file_id": fileID, "user_id": userID, "is_revoked": false},
+		bson.M{"$set": bson.M{
+			"is_revoked": true,
+			"revoked_at": ds.clock.Now(),
+		}},
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke download tokens: %v", err)
+	}
+
+	return result.ModifiedCount, nil
+}
+
+// ServeDownload serves the file behind a download token, honouring Range and
+// If-Range so interrupted downloads can be resumed, and records the bytes sent
+func (ds *DownloadService) ServeDownload(ctx context.Context, tokenString, clientIP string, w http.ResponseWriter, r *http.Request) error {
+	record, file, err := ds.resolveDownloadToken(ctx, tokenString)
+	if err != nil {
+		return err
+	}
+
+	// Resumed requests continue the download the first request was receipted for
+	if record.Requests == 0 && !record.Proxied {
+		_, err := ds.receipts.IssueReceipt(ctx, file, models.ReceiptDownloader{
+			Type:      "download_token",
+			UserID:    record.UserID.Hex(),
+			IPAddress: clientIP,
+			UserAgent: r.UserAgent(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	content, err := ds.hotFileService.Content(ctx, file)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	w.Header().Set("ETag", utils.FileETag(file, ""))
+	w.Header().Set("Content-Disposition", utils.ContentDisposition("attachment", file.OriginalName))
+	if file.MimeType != "" {
+		w.Header().Set("Content-Type", file.MimeType)
+	}
+
+	counter := &countingResponseWriter{ResponseWriter: w}
+	http.ServeContent(counter, r, file.OriginalName, file.UpdatedAt, content)
+
+	ds.recordDownloadProgress(ctx, record, file, servedRangeStart(counter.Header()), counter.written, clientIP)
+	return nil
+}
+
+// resolveDownloadToken validates the signature and the stored token state
+func (ds *DownloadService) resolveDownloadToken(ctx context.Context, tokenString string) (*models.DownloadToken, *models.File, error) {
+	claims, err := utils.ValidateDownloadToken(tokenString)
+	if err != nil {
+		return nil, nil, ErrDownloadTokenInvalid
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var record models.DownloadToken
+	err = ds.collections.DownloadTokens().FindOne(ctx, bson.M{
+		"_id":     claims.TokenID,
+		"file_id": claims.FileID,
+	}).Decode(&record)
+	if err != nil {
+		return nil, nil, ErrDownloadTokenInvalid
+	}
+
+	if record.IsRevoked {
+		return nil, nil, ErrDownloadTokenRevoked
+	}
+	if record.ExpiresAt.Before(ds.clock.Now()) {
+		return nil, nil, ErrDownloadTokenExpired
+	}
+
+	var file models.File
+	err = ds.collections.Files().FindOne(ctx, bson.M{
+		"_id":        record.FileID,
+		"is_deleted": false,
+	}).Decode(&file)
+	if err != nil {
+		return nil, nil, findError(err, ErrFileNotFound)
+	}
+	if err := checkFileExpiry(&file); err != nil {
+		return nil, nil, err
+	}
+	if err := checkFileArchived(&file); err != nil {
+		return nil, nil, err
+	}
+
+	return &record, &file, nil
+}
+
+// recordDownloadProgress updates the token's progress and the owner's bandwidth usage
+func (ds *DownloadService) recordDownloadProgress(ctx context.Context, record *models.DownloadToken, file *models.File, offset, written int64, clientIP string) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	reached := offset + written
+	set := bson.M{"last_accessed_at": ds.clock.Now()}
+	if reached >= file.Size && written > 0 {
+		set["is_completed"] = true
+	}
+
+	update := bson.M{
+		"$set": set,
+		"$inc": bson.M{"bytes_served": written, "requests": 1},
+	}
+	if reached < file.Size {
+		update["$max"] = bson.M{"highest_offset": reached}
+	}
+	ds.collections.DownloadTokens().UpdateOne(ctx, bson.M{"_id": record.ID}, update)
+
+	if written > 0 {
+		ds.collections.Users().UpdateOne(ctx,
+			bson.M{"_id": file.UserID},
+			bson.M{"$inc": bson.M{"bandwidth_used": written}},
+		)
+	}
+
+	if reached < file.Size || written == 0 {
+		return
+	}
+
+	// Count the download once, on the request that first delivers the final
+	// byte; concurrent requests race on the filter and only one moves it
+	result, err := ds.collections.DownloadTokens().UpdateOne(ctx,
+		bson.M{"_id": record.ID, "highest_offset": bson.M{"$lt": file.Size}},
+		bson.M{"$max": bson.M{"highest_offset": reached}},
+	)
+	if err != nil || result.ModifiedCount != 1 || record.Proxied {
+		return
+	}
+	ds.collections.Files().UpdateOne(ctx,
+		bson.M{"_id": file.ID},
+		bson.M{"$inc": bson.M{"downloads": 1}},
+	)
+	ds.analytics.TrackFileActivity(record.UserID, file.ID, "download", file.Size, utils.LocateClient(clientIP))
+}
+
+// countingResponseWriter counts body bytes written to the client
+type countingResponseWriter struct {
+	http.ResponseWriter
+	written int64
+}
+
+func (w *countingResponseWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.written += int64(n)
+	return n, err
+}
+
+// servedRangeStart returns the first byte offset of the range http.ServeContent
+// served, read from the response's Content-Range, or 0 for a whole file
+func servedRangeStart(header http.Header) int64 {
+	spec, ok := strings.CutPrefix(header.Get("Content-Range"), "bytes ")
+	if !ok {
+		return 0
+	}
+
+	start, _, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0
+	}
+	offset, err := strconv.ParseInt(start, 10, 64)
+	if err != nil || offset < 0 {
+		return 0
+	}
+
+	return offset
+}
+
+// parseRangeStart returns the first byte offset a request's single range asks
+// for, resolving a suffix range "bytes=-N" against the size of the content
+func parseRangeStart(header string, size int64) int64 {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0
+	}
+
+	start, end, _ := strings.Cut(spec, "-")
+	start = strings.TrimSpace(start)
+	if start == "" {
+		suffix, err := strconv.ParseInt(strings.TrimSpace(end), 10, 64)
+		if err != nil || suffix <= 0 || suffix >= size {
+			return 0
+		}
+		return size - suffix
+	}
+
+	offset, err := strconv.ParseInt(start, 10, 64)
+	if err != nil || offset < 0 {
+		return 0
+	}
+
+	return offset
+}
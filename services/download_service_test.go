@@ -0,0 +1,113 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"oncloud/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestServedRangeStart(t *testing.T) {
+	modified := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	content := bytes.Repeat([]byte("0123456789"), 100)
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    int64
+	}{
+		{"whole file", nil, 0},
+		{"open range", map[string]string{"Range": "bytes=400-"}, 400},
+		{"closed range", map[string]string{"Range": "bytes=100-199"}, 100},
+		{"suffix range", map[string]string{"Range": "bytes=-100"}, 900},
+		{"suffix longer than file", map[string]string{"Range": "bytes=-5000"}, 0},
+		{"stale If-Range", map[string]string{"Range": "bytes=400-", "If-Range": `"old"`}, 0},
+		{"unsatisfiable", map[string]string{"Range": "bytes=5000-"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/download", nil)
+			for name, value := range tt.headers {
+				r.Header.Set(name, value)
+			}
+			w := httptest.NewRecorder()
+			w.Header().Set("ETag", `"current"`)
+			http.ServeContent(w, r, "data.bin", modified, bytes.NewReader(content))
+
+			if got := servedRangeStart(w.Header()); got != tt.want {
+				t.Errorf("servedRangeStart() = %d, want %d (Content-Range %q)", got, tt.want, w.Header().Get("Content-Range"))
+			}
+		})
+	}
+}
+
+func TestParseRangeStart(t *testing.T) {
+	for header, want := range map[string]int64{
+		"":                0,
+		"bytes=0-":        0,
+		"bytes=250-":      250,
+		"bytes=250-499":   250,
+		"bytes=-100":      900,
+		"bytes=-1000":     0,
+		"bytes=-0":        0,
+		"bytes=0-9,20-29": 0,
+		"items=5-":        0,
+		"bytes=abc-":      0,
+	} {
+		if got := parseRangeStart(header, 1000); got != want {
+			t.Errorf("parseRangeStart(%q) = %d, want %d", header, got, want)
+		}
+	}
+}
+
+func TestRecordDownloadProgressCountsCompletedDownloadOnce(t *testing.T) {
+	db := testDatabase(t)
+	ds := NewDownloadServiceWith(Dependencies{Database: testDatabaseSource{db}})
+	ctx := context.Background()
+
+	file := &models.File{ID: primitive.NewObjectID(), UserID: primitive.NewObjectID(), Size: 1000}
+	if _, err := db.Collection("files").InsertOne(ctx, file); err != nil {
+		t.Fatal(err)
+	}
+	record := &models.DownloadToken{ID: primitive.NewObjectID(), FileID: file.ID, UserID: file.UserID, FileSize: file.Size}
+	if _, err := db.Collection("download_tokens").InsertOne(ctx, record); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first half, then several requests for the rest racing each other,
+	// all holding the token as it was before any of them finished
+	ds.recordDownloadProgress(ctx, record, file, 0, 500, "203.0.113.7")
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ds.recordDownloadProgress(ctx, record, file, 500, 500, "203.0.113.7")
+		}()
+	}
+	wg.Wait()
+
+	var stored models.File
+	if err := db.Collection("files").FindOne(ctx, bson.M{"_id": file.ID}).Decode(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Downloads != 1 {
+		t.Fatalf("download counted %d times, want once", stored.Downloads)
+	}
+
+	var token models.DownloadToken
+	if err := db.Collection("download_tokens").FindOne(ctx, bson.M{"_id": record.ID}).Decode(&token); err != nil {
+		t.Fatal(err)
+	}
+	if token.HighestOffset != file.Size || !token.IsCompleted || token.Requests != 6 {
+		t.Fatalf("token at %d, completed %v, %d requests", token.HighestOffset, token.IsCompleted, token.Requests)
+	}
+}
@@ -0,0 +1,354 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"oncloud/models"
+	"oncloud/utils"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Mailgun signatures older than this are rejected as replays
+const mailgunSignatureMaxAge = 15 * time.Minute
+
+var (
+	ErrEmailInboxNotConfigured  = errors.New("inbound email is not configured")
+	ErrEmailInboxFolderNotFound = notFoundError("inbox folder not found")
+	ErrInboundEmailUnverified   = errors.New("inbound email webhook could not be verified")
+)
+
+// EmailInboxOptions configures receiving files by email. Mail for Domain
+// is delivered by a Mailgun inbound route, verified with
+// MailgunSigningKey, or posted as a raw message with WebhookSecret by
+// anything else that receives it (an SES rule, an MTA pipe).
+type EmailInboxOptions struct {
+	Domain            string
+	MailgunSigningKey string
+	WebhookSecret     string
+}
+
+var emailInboxOptions EmailInboxOptions
+
+// InitEmailInbox enables inbound email addresses
+func InitEmailInbox(opts EmailInboxOptions) {
+	opts.Domain = strings.ToLower(strings.TrimPrefix(opts.Domain, "@"))
+	emailInboxOptions = opts
+}
+
+type EmailInboxService struct {
+	*BaseService
+	fileService *FileService
+}
+
+func NewEmailInboxService() *EmailInboxService {
+	return NewEmailInboxServiceWith(Dependencies{})
+}
+
+// NewEmailInboxServiceWith creates an email inbox service on the given
+// dependencies
+func NewEmailInboxServiceWith(deps Dependencies) *EmailInboxService {
+	return &EmailInboxService{
+		BaseService: NewBaseServiceWith(deps),
+		fileService: NewFileServiceWith(deps),
+	}
+}
+
+// GetInbox returns the user's inbound email address, creating it on first
+// use
+func (eis *EmailInboxService) GetInbox(ctx context.Context, userID primitive.ObjectID) (*models.EmailInbox, error) {
+	if emailInboxOptions.Domain == "" {
+		return nil, ErrEmailInboxNotConfigured
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var inbox models.EmailInbox
+	err := eis.collections.EmailInboxes().FindOne(ctx, bson.M{"user_id": userID}).Decode(&inbox)
+	if err == mongo.ErrNoDocuments {
+		return eis.createInbox(ctx, userID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get inbox: %v", err)
+	}
+
+	inbox.Address = inboxAddress(inbox.Token)
+	return &inbox, nil
+}
+
+func (eis *EmailInboxService) createInbox(ctx context.Context, userID primitive.ObjectID) (*models.EmailInbox, error) {
+	token, err := utils.GenerateSecureToken(8)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate address: %v", err)
+	}
+
+	now := time.Now()
+	inbox := &models.EmailInbox{
+		ID:             primitive.NewObjectID(),
+		UserID:         userID,
+		Token:          token,
+		AllowedSenders: []string{},
+		IsEnabled:      true,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	if _, err := eis.collections.EmailInboxes().InsertOne(ctx, inbox); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			// Created by a concurrent request
+			return eis.GetInbox(ctx, userID)
+		}
+		return nil, fmt.Errorf("failed to create inbox: %v", err)
+	}
+
+	inbox.Address = inboxAddress(inbox.Token)
+	return inbox, nil
+}
+
+// UpdateInbox changes where attachments are saved and who may send them
+func (eis *EmailInboxService) UpdateInbox(ctx context.Context, userID primitive.ObjectID, req *models.EmailInboxUpdateRequest) (*models.EmailInbox, error) {
+	if _, err := eis.GetInbox(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	set := bson.M{"updated_at": time.Now()}
+	unset := bson.M{}
+	if req.FolderID != nil {
+		if *req.FolderID == "" {
+			unset["folder_id"] = ""
+		} else {
+			folderID, err := utils.StringToObjectID(*req.FolderID)
+			if err != nil {
+				return nil, ErrEmailInboxFolderNotFound
+			}
+			if _, err := NewFolderService().GetUserFolder(ctx, userID, folderID); err != nil {
+				return nil, ErrEmailInboxFolderNotFound
+			}
+			set["folder_id"] = folderID
+		}
+	}
+	if req.AllowedSenders != nil {
+		senders := make([]string, 0, len(req.AllowedSenders))
+		for _, sender := range req.AllowedSenders {
+			senders = append(senders, strings.ToLower(strings.TrimSpace(sender)))
+		}
+		set["allowed_senders"] = utils.SliceUnique(senders)
+	}
+	if req.IsEnabled != nil {
+		set["is_enabled"] = *req.IsEnabled
+	}
+
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var inbox models.EmailInbox
+	err := eis.collections.EmailInboxes().FindOneAndUpdate(ctx,
+		bson.M{"user_id": userID},
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&inbox)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update inbox: %v", err)
+	}
+
+	inbox.Address = inboxAddress(inbox.Token)
+	return &inbox, nil
+}
+
+// RegenerateAddress replaces the user's inbound address, so mail to the old
+// one is no longer accepted
+func (eis *EmailInboxService) RegenerateAddress(ctx context.Context, userID primitive.ObjectID) (*models.EmailInbox, error) {
+	if _, err := eis.GetInbox(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	token, err := utils.GenerateSecureToken(8)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate address: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var inbox models.EmailInbox
+	err = eis.collections.EmailInboxes().FindOneAndUpdate(ctx,
+		bson.M{"user_id": userID},
+		bson.M{"$set": bson.M{"token": token, "updated_at": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&inbox)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update inbox: %v", err)
+	}
+
+	inbox.Address = inboxAddress(inbox.Token)
+	return &inbox, nil
+}
+
+// VerifyMailgunSignature checks that an inbound webhook was sent by Mailgun
+func (eis *EmailInboxService) VerifyMailgunSignature(timestamp, token, signature string) error {
+	if emailInboxOptions.MailgunSigningKey == "" {
+		return ErrEmailInboxNotConfigured
+	}
+
+	sentAt, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || time.Since(time.Unix(sentAt, 0)).Abs() > mailgunSignatureMaxAge {
+		return ErrInboundEmailUnverified
+	}
+
+	mac := hmac.New(sha256.New, []byte(emailInboxOptions.MailgunSigningKey))
+	mac.Write([]byte(timestamp + token))
+	expected := hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(signature))) {
+		return ErrInboundEmailUnverified
+	}
+	return nil
+}
+
+// VerifyWebhookSecret checks the shared secret raw messages are posted with
+func (eis *EmailInboxService) VerifyWebhookSecret(secret string) error {
+	if emailInboxOptions.WebhookSecret == "" {
+		return ErrEmailInboxNotConfigured
+	}
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(emailInboxOptions.WebhookSecret)) != 1 {
+		return ErrInboundEmailUnverified
+	}
+	return nil
+}
+
+// Deliver saves the attachments of an inbound email into the inboxes it was
+// sent to. Mail for unknown or disabled inboxes, or from senders an inbox
+// does not allow, is dropped. Each attachment is checked against the
+// owner's plan and storage quota like an upload.
+func (eis *EmailInboxService) Deliver(ctx context.Context, email *utils.InboundEmail) *models.InboundEmailResult {
+	result := &models.InboundEmailResult{}
+
+	for _, token := range eis.recipientTokens(email.Recipients) {
+		inbox, user, err := eis.findInbox(ctx, token)
+		if err != nil {
+			continue
+		}
+		if !inboxAllowsSender(inbox, user, email.Sender) {
+			log.Printf("Inbound email to inbox %s rejected: sender %q is not allowed", inbox.ID.Hex(), email.Sender)
+			eis.recordDelivery(ctx, inbox.ID, 0, fmt.Sprintf("Email from %s was rejected, the sender is not allowed", email.Sender))
+			result.RejectedFiles += len(email.Attachments)
+			continue
+		}
+
+		saved := 0
+		lastError := ""
+		for _, attachment := range email.Attachments {
+			req := &models.FileUploadRequest{
+				Metadata: map[string]string{
+					"email_sender":  email.Sender,
+					"email_subject": email.Subject,
+				},
+				Source: models.UploadSourceEmail,
+			}
+			if inbox.FolderID != nil {
+				req.FolderID = inbox.FolderID.Hex()
+			}
+
+			if _, err := eis.fileService.UploadContent(ctx, inbox.UserID, attachment.FileName, attachment.Content, req); err != nil {
+				lastError = fmt.Sprintf("%s: %v", attachment.FileName, err)
+				result.RejectedFiles++
+				result.Errors = append(result.Errors, lastError)
+				continue
+			}
+			saved++
+		}
+
+		result.SavedFiles += saved
+		eis.recordDelivery(ctx, inbox.ID, saved, lastError)
+	}
+
+	return result
+}
+
+// recipientTokens returns the inbox tokens of the recipients at the inbound
+// domain. A +tag after the token is ignored.
+func (eis *EmailInboxService) recipientTokens(recipients []string) []string {
+	var tokens []string
+	for _, recipient := range recipients {
+		local, domain, found := strings.Cut(strings.ToLower(strings.TrimSpace(recipient)), "@")
+		if !found || domain != emailInboxOptions.Domain {
+			continue
+		}
+		local, _, _ = strings.Cut(local, "+")
+		tokens = append(tokens, local)
+	}
+	return utils.SliceUnique(tokens)
+}
+
+func (eis *EmailInboxService) findInbox(ctx context.Context, token string) (*models.EmailInbox, *models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var inbox models.EmailInbox
+	if err := eis.collections.EmailInboxes().FindOne(ctx, bson.M{"token": token, "is_enabled": true}).Decode(&inbox); err != nil {
+		return nil, nil, err
+	}
+	var user models.User
+	if err := eis.collections.Users().FindOne(ctx, bson.M{"_id": inbox.UserID, "is_active": true}).Decode(&user); err != nil {
+		return nil, nil, err
+	}
+	return &inbox, &user, nil
+}
+
+func (eis *EmailInboxService) recordDelivery(ctx context.Context, inboxID primitive.ObjectID, savedFiles int, lastError string) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	set := bson.M{"last_received_at": now, "updated_at": now}
+	update := bson.M{
+		"$set": set,
+		"$inc": bson.M{"received_emails": 1, "received_files": savedFiles},
+	}
+	if lastError != "" {
+		set["last_error"] = lastError
+	} else {
+		update["$unset"] = bson.M{"last_error": ""}
+	}
+	eis.collections.EmailInboxes().UpdateOne(ctx, bson.M{"_id": inboxID}, update)
+}
+
+// inboxAllowsSender checks a sender against an inbox's allowlist of
+// addresses and @domains. An empty list only allows the owner's own email.
+func inboxAllowsSender(inbox *models.EmailInbox, user *models.User, sender string) bool {
+	sender = strings.ToLower(strings.TrimSpace(sender))
+	if sender == "" {
+		return false
+	}
+	if len(inbox.AllowedSenders) == 0 {
+		return sender == strings.ToLower(user.Email)
+	}
+
+	_, domain, _ := strings.Cut(sender, "@")
+	for _, allowed := range inbox.AllowedSenders {
+		if allowed == sender || allowed == "@"+domain {
+			return true
+		}
+	}
+	return false
+}
+
+func inboxAddress(token string) string {
+	return token + "@" + emailInboxOptions.Domain
+}
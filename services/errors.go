@@ -0,0 +1,58 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Error kinds. Errors callers need to tell apart are of one of these
+// kinds, or ErrProviderUnavailable, so that the API answers them with the
+// same status and error code whichever service they come from; check them
+// with errors.Is.
+var (
+	ErrNotFound      = errors.New("not found")
+	ErrForbidden     = errors.New("forbidden")
+	ErrQuotaExceeded = errors.New("quota exceeded")
+)
+
+var (
+	ErrFileNotFound   = notFoundError("file not found")
+	ErrFolderNotFound = notFoundError("folder not found")
+)
+
+// kindError is an error of a kind with a message of its own
+type kindError struct {
+	kind    error
+	message string
+}
+
+func (e *kindError) Error() string {
+	return e.message
+}
+
+func (e *kindError) Unwrap() error {
+	return e.kind
+}
+
+func notFoundError(message string) error {
+	return &kindError{kind: ErrNotFound, message: message}
+}
+
+func forbiddenError(message string) error {
+	return &kindError{kind: ErrForbidden, message: message}
+}
+
+func quotaExceededError(format string, args ...interface{}) error {
+	return &kindError{kind: ErrQuotaExceeded, message: fmt.Sprintf(format, args...)}
+}
+
+// findError returns notFound when a lookup matched no document, and the
+// lookup's error otherwise
+func findError(err, notFound error) error {
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return notFound
+	}
+	return err
+}
@@ -0,0 +1,239 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"oncloud/database"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrEventBufferFull = errors.New("analytics event buffer is full")
+
+// EventWriter buffers analytics events in memory and writes them to MongoDB in
+// batches, so tracking an event never costs a database round trip per request
+type EventWriter struct {
+	collections   *database.Collections
+	events        chan interface{}
+	batchSize     int
+	flushInterval time.Duration
+	enqueueWait   time.Duration
+	flushNow      chan struct{}
+	done          chan struct{}
+	stopOnce      sync.Once
+	wg            sync.WaitGroup
+
+	written int64
+	dropped int64
+	failed  int64
+}
+
+var (
+	eventWriter     *EventWriter
+	eventWriterOnce sync.Once
+)
+
+// NewEventWriter creates and starts a buffered event writer
+func NewEventWriter(bufferSize, batchSize int, flushInterval, enqueueWait time.Duration) *EventWriter {
+	if bufferSize <= 0 {
+		bufferSize = 10000
+	}
+	if batchSize <= 0 {
+		batchSize = 500
+	}
+	if flushInterval <= 0 {
+		flushInterval = 5 * time.Second
+	}
+
+	ew := &EventWriter{
+		collections:   database.NewCollections(),
+		events:        make(chan interface{}, bufferSize),
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		enqueueWait:   enqueueWait,
+		flushNow:      make(chan struct{}, 1),
+		done:          make(chan struct{}),
+	}
+
+	ew.wg.Add(1)
+	go ew.run()
+
+	return ew
+}
+
+// InitEventWriter starts the process-wide event writer with the given limits.
+// It has no effect once the writer has been started.
+func InitEventWriter(bufferSize, batchSize int, flushInterval, enqueueWait time.Duration) *EventWriter {
+	eventWriterOnce.Do(func() {
+		eventWriter = NewEventWriter(bufferSize, batchSize, flushInterval, enqueueWait)
+	})
+	return eventWriter
+}
+
+// GetEventWriter returns the process-wide event writer, starting it with
+// default limits if InitEventWriter has not been called
+func GetEventWriter() *EventWriter {
+	return InitEventWriter(10000, 500, 5*time.Second, 50*time.Millisecond)
+}
+
+// Enqueue adds an event to the buffer. When the buffer is full it waits up to
+// the enqueue timeout for room and then drops the event, so a slow database
+// applies backpressure without blocking request handlers indefinitely.
+func (ew *EventWriter) Enqueue(event interface{}) error {
+	select {
+	case <-ew.done:
+		return errors.New("analytics event writer is stopped")
+	default:
+	}
+
+	select {
+	case ew.events <- event:
+		ew.signalFlushIfFull()
+		return nil
+	default:
+	}
+
+	// Buffer is full: ask for an immediate flush and wait briefly for room
+	ew.requestFlush()
+	if ew.enqueueWait > 0 {
+		timer := time.NewTimer(ew.enqueueWait)
+		defer timer.Stop()
+
+		select {
+		case ew.events <- event:
+			return nil
+		case <-timer.C:
+		}
+	}
+
+	atomic.AddInt64(&ew.dropped, 1)
+	return ErrEventBufferFull
+}
+
+// Stop flushes all buffered events and stops the background writer. Events
+// still buffered when ctx expires are lost.
+func (ew *EventWriter) Stop(ctx context.Context) error {
+	ew.stopOnce.Do(func() {
+		close(ew.done)
+	})
+
+	finished := make(chan struct{})
+	go func() {
+		ew.wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stats returns buffer utilisation and write counters
+func (ew *EventWriter) Stats() map[string]interface{} {
+	return map[string]interface{}{
+		"buffered":       len(ew.events),
+		"buffer_size":    cap(ew.events),
+		"batch_size":     ew.batchSize,
+		"flush_interval": ew.flushInterval.String(),
+		"written":        atomic.LoadInt64(&ew.written),
+		"dropped":        atomic.LoadInt64(&ew.dropped),
+		"failed":         atomic.LoadInt64(&ew.failed),
+	}
+}
+
+// GetEventWriterStats returns utilisation of the process-wide event writer
+func GetEventWriterStats() map[string]interface{} {
+	return GetEventWriter().Stats()
+}
+
+// StopEventWriter flushes and stops the process-wide event writer if it was started
+func StopEventWriter(ctx context.Context) error {
+	if eventWriter == nil {
+		return nil
+	}
+	return eventWriter.Stop(ctx)
+}
+
+func (ew *EventWriter) run() {
+	defer ew.wg.Done()
+
+	ticker := time.NewTicker(ew.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]interface{}, 0, ew.batchSize)
+	for {
+		select {
+		case event := <-ew.events:
+			batch = append(batch, event)
+			if len(batch) >= ew.batchSize {
+				batch = ew.flush(batch)
+			}
+		case <-ticker.C:
+			batch = ew.flush(batch)
+		case <-ew.flushNow:
+			batch = ew.drain(batch)
+		case <-ew.done:
+			ew.drain(batch)
+			return
+		}
+	}
+}
+
+// drain writes everything currently buffered
+func (ew *EventWriter) drain(batch []interface{}) []interface{} {
+	for {
+		select {
+		case event := <-ew.events:
+			batch = append(batch, event)
+			if len(batch) >= ew.batchSize {
+				batch = ew.flush(batch)
+			}
+		default:
+			return ew.flush(batch)
+		}
+	}
+}
+
+// flush writes a batch with InsertMany and returns the emptied slice
+func (ew *EventWriter) flush(batch []interface{}) []interface{} {
+	if len(batch) == 0 {
+		return batch
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := ew.collections.Analytics().InsertMany(ctx, batch, options.InsertMany().SetOrdered(false))
+	inserted := 0
+	if result != nil {
+		inserted = len(result.InsertedIDs)
+	}
+	atomic.AddInt64(&ew.written, int64(inserted))
+
+	if err != nil {
+		atomic.AddInt64(&ew.failed, int64(len(batch)-inserted))
+		log.Printf("Failed to write %d analytics events: %v", len(batch)-inserted, err)
+	}
+
+	return batch[:0]
+}
+
+func (ew *EventWriter) signalFlushIfFull() {
+	if len(ew.events) >= ew.batchSize {
+		ew.requestFlush()
+	}
+}
+
+func (ew *EventWriter) requestFlush() {
+	select {
+	case ew.flushNow <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,535 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-pdf/fpdf"
+	"github.com/xuri/excelize/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	maxSheetNameLength = 31
+	maxChartRows       = 366
+	maxPDFColumns      = 8
+	maxPDFCellChars    = 40
+)
+
+// exportSheet is one table of an export, rendered as a worksheet in XLSX and
+// as a section in PDF
+type exportSheet struct {
+	Name    string
+	Headers []string
+	Rows    [][]interface{}
+	NoChart bool
+}
+
+// buildExportSheets converts export data into typed tables. Lists of
+// documents become one sheet; analytics maps become a summary sheet of scalar
+// values plus one sheet per nested list.
+func buildExportSheets(data interface{}) []exportSheet {
+	switch v := data.(type) {
+	case []bson.M:
+		docs := make([]map[string]interface{}, len(v))
+		for i, doc := range v {
+			docs[i] = doc
+		}
+		return []exportSheet{documentsSheet("Data", docs)}
+	case []map[string]interface{}:
+		return []exportSheet{documentsSheet("Data", v)}
+	case map[string]interface{}:
+		summary := exportSheet{Name: "Summary", Headers: []string{"Metric", "Value"}, NoChart: true}
+		var sheets []exportSheet
+
+		for _, key := range sortedKeys(v) {
+			if docs, ok := asDocumentList(v[key]); ok {
+				sheets = append(sheets, documentsSheet(key, docs))
+				continue
+			}
+			flattenSummary(&summary, key, v[key])
+		}
+
+		return append([]exportSheet{summary}, sheets...)
+	default:
+		return []exportSheet{{
+			Name:    "Data",
+			Headers: []string{"Value"},
+			Rows:    [][]interface{}{{exportCellValue(v)}},
+		}}
+	}
+}
+
+// documentsSheet builds a sheet whose columns are the union of document keys,
+// with _id first
+func documentsSheet(name string, docs []map[string]interface{}) exportSheet {
+	seen := make(map[string]bool)
+	var headers []string
+	for _, doc := range docs {
+		for key := range doc {
+			if !seen[key] {
+				seen[key] = true
+				headers = append(headers, key)
+			}
+		}
+	}
+	sort.Slice(headers, func(i, j int) bool {
+		if headers[i] == "_id" || headers[j] == "_id" {
+			return headers[i] == "_id"
+		}
+		return headers[i] < headers[j]
+	})
+
+	rows := make([][]interface{}, 0, len(docs))
+	for _, doc := range docs {
+		row := make([]interface{}, len(headers))
+		for i, header := range headers {
+			row[i] = exportCellValue(doc[header])
+		}
+		rows = append(rows, row)
+	}
+
+	return exportSheet{Name: name, Headers: headers, Rows: rows}
+}
+
+// flattenSummary adds a scalar value, or a nested map's scalars under dotted
+// keys, to the summary sheet
+func flattenSummary(summary *exportSheet, key string, value interface{}) {
+	var nested map[string]interface{}
+	switch m := value.(type) {
+	case map[string]interface{}:
+		nested = m
+	case bson.M:
+		nested = m
+	}
+
+	if nested == nil {
+		summary.Rows = append(summary.Rows, []interface{}{key, exportCellValue(value)})
+		return
+	}
+	for _, sub := range sortedKeys(nested) {
+		flattenSummary(summary, key+"."+sub, nested[sub])
+	}
+}
+
+// asDocumentList reports whether value is a list of documents
+func asDocumentList(value interface{}) ([]map[string]interface{}, bool) {
+	switch v := value.(type) {
+	case []map[string]interface{}:
+		return v, true
+	case []bson.M:
+		docs := make([]map[string]interface{}, len(v))
+		for i, doc := range v {
+			docs[i] = doc
+		}
+		return docs, true
+	case primitive.A:
+		return interfaceDocuments(v)
+	case []interface{}:
+		return interfaceDocuments(v)
+	}
+	return nil, false
+}
+
+func interfaceDocuments(items []interface{}) ([]map[string]interface{}, bool) {
+	docs := make([]map[string]interface{}, 0, len(items))
+	for _, item := range items {
+		switch doc := item.(type) {
+		case map[string]interface{}:
+			docs = append(docs, doc)
+		case bson.M:
+			docs = append(docs, doc)
+		default:
+			return nil, false
+		}
+	}
+	return docs, true
+}
+
+// exportCellValue converts a BSON value into a type spreadsheets understand:
+// numbers, booleans and times stay typed, everything else becomes a string
+func exportCellValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string, bool, int, int32, int64, float32, float64:
+		return v
+	case time.Time:
+		return v.UTC()
+	case primitive.DateTime:
+		return v.Time().UTC()
+	case primitive.ObjectID:
+		return v.Hex()
+	case primitive.Decimal128:
+		return v.String()
+	case *primitive.ObjectID:
+		if v == nil {
+			return ""
+		}
+		return v.Hex()
+	case map[string]interface{}, bson.M, primitive.A, []interface{}:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprintf("%v", v)
+		}
+		return string(encoded)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// chartColumns picks the first text column as labels and the first numeric
+// column as values so a sheet can be charted. Returns ok=false when the sheet
+// is not chartable.
+func chartColumns(sheet exportSheet) (labelCol, valueCol int, ok bool) {
+	if sheet.NoChart || len(sheet.Rows) < 2 || len(sheet.Rows) > maxChartRows {
+		return 0, 0, false
+	}
+
+	labelCol, valueCol = -1, -1
+	for col, header := range sheet.Headers {
+		if header == "_id" && len(sheet.Headers) > 2 {
+			continue
+		}
+
+		numeric := true
+		for _, row := range sheet.Rows {
+			if _, isNumber := toFloat(row[col]); !isNumber {
+				numeric = false
+				break
+			}
+		}
+
+		if numeric && valueCol < 0 {
+			valueCol = col
+		} else if !numeric && labelCol < 0 {
+			labelCol = col
+		}
+	}
+
+	if labelCol < 0 || valueCol < 0 {
+		return 0, 0, false
+	}
+	return labelCol, valueCol, true
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// excelSheetName returns a unique, Excel-safe sheet name
+func excelSheetName(name string, used map[string]bool) string {
+	name = strings.NewReplacer("[", "", "]", "", ":", "", "*", "", "?", "", "/", "", "\\", "").Replace(name)
+	name = strings.ReplaceAll(name, "_", " ")
+	if name == "" {
+		name = "Sheet"
+	}
+	if len(name) > maxSheetNameLength {
+		name = name[:maxSheetNameLength]
+	}
+
+	candidate := name
+	for i := 2; used[strings.ToLower(candidate)]; i++ {
+		suffix := fmt.Sprintf(" (%d)", i)
+		base := name
+		if len(base)+len(suffix) > maxSheetNameLength {
+			base = base[:maxSheetNameLength-len(suffix)]
+		}
+		candidate = base + suffix
+	}
+	used[strings.ToLower(candidate)] = true
+	return candidate
+}
+
+// writeExcelFile renders export sheets into an XLSX workbook with a styled,
+// frozen header row, autofilter, date formatting and a chart for series data
+func writeExcelFile(title string, sheets []exportSheet, filePath string) error {
+	f := excelize.NewFile()
+	defer f.Close()
+
+	headerStyle, err := f.NewStyle(&excelize.Style{
+		Font:   &excelize.Font{Bold: true, Color: "#FFFFFF"},
+		Fill:   excelize.Fill{Type: "pattern", Color: []string{"#2F5597"}, Pattern: 1},
+		Border: []excelize.Border{{Type: "bottom", Color: "#1F3864", Style: 1}},
+	})
+	if err != nil {
+		return err
+	}
+	dateStyle, err := f.NewStyle(&excelize.Style{NumFmt: 22}) // m/d/yy h:mm
+	if err != nil {
+		return err
+	}
+
+	used := make(map[string]bool)
+	for i, sheet := range sheets {
+		name := excelSheetName(sheet.Name, used)
+		if i == 0 {
+			if err := f.SetSheetName("Sheet1", name); err != nil {
+				return err
+			}
+		} else if _, err := f.NewSheet(name); err != nil {
+			return err
+		}
+
+		if err := writeExcelSheet(f, name, sheet, headerStyle, dateStyle); err != nil {
+			return fmt.Errorf("failed to write sheet %s: %v", name, err)
+		}
+	}
+
+	f.SetActiveSheet(0)
+	f.SetDocProps(&excelize.DocProperties{
+		Title:   title,
+		Creator: "OnCloud",
+		Created: time.Now().UTC().Format(time.RFC3339),
+	})
+
+	return f.SaveAs(filePath)
+}
+
+func writeExcelSheet(f *excelize.File, name string, sheet exportSheet, headerStyle, dateStyle int) error {
+	if len(sheet.Headers) == 0 {
+		return nil
+	}
+
+	headers := make([]interface{}, len(sheet.Headers))
+	for i, header := range sheet.Headers {
+		headers[i] = header
+	}
+	if err := f.SetSheetRow(name, "A1", &headers); err != nil {
+		return err
+	}
+
+	lastCol, _ := excelize.ColumnNumberToName(len(sheet.Headers))
+	f.SetCellStyle(name, "A1", lastCol+"1", headerStyle)
+
+	for r, row := range sheet.Rows {
+		cell, _ := excelize.CoordinatesToCellName(1, r+2)
+		values := row
+		if err := f.SetSheetRow(name, cell, &values); err != nil {
+			return err
+		}
+		for c, value := range row {
+			if _, isTime := value.(time.Time); isTime {
+				dateCell, _ := excelize.CoordinatesToCellName(c+1, r+2)
+				f.SetCellStyle(name, dateCell, dateCell, dateStyle)
+			}
+		}
+	}
+
+	f.SetColWidth(name, "A", lastCol, 20)
+	f.SetPanes(name, &excelize.Panes{
+		Freeze:      true,
+		YSplit:      1,
+		TopLeftCell: "A2",
+		ActivePane:  "bottomLeft",
+	})
+	if len(sheet.Rows) > 0 {
+		f.AutoFilter(name, fmt.Sprintf("A1:%s%d", lastCol, len(sheet.Rows)+1), nil)
+	}
+
+	labelCol, valueCol, ok := chartColumns(sheet)
+	if !ok {
+		return nil
+	}
+
+	labelName, _ := excelize.ColumnNumberToName(labelCol + 1)
+	valueName, _ := excelize.ColumnNumberToName(valueCol + 1)
+	lastRow := len(sheet.Rows) + 1
+	anchor, _ := excelize.CoordinatesToCellName(len(sheet.Headers)+2, 2)
+
+	return f.AddChart(name, anchor, &excelize.Chart{
+		Type: excelize.Col,
+		Series: []excelize.ChartSeries{{
+			Name:       fmt.Sprintf("'%s'!$%s$1", name, valueName),
+			Categories: fmt.Sprintf("'%s'!$%s$2:$%s$%d", name, labelName, labelName, lastRow),
+			Values:     fmt.Sprintf("'%s'!$%s$2:$%s$%d", name, valueName, valueName, lastRow),
+		}},
+		Title:  []excelize.RichTextRun{{Text: sheet.Headers[valueCol] + " by " + sheet.Headers[labelCol]}},
+		Legend: excelize.ChartLegend{Position: "none"},
+	})
+}
+
+// writePDFFile renders export sheets into a landscape PDF report with a bar
+// chart for series data followed by a paginated table for each sheet
+func writePDFFile(title string, sheets []exportSheet, filePath string) error {
+	pdf := fpdf.New("L", "mm", "A4", "")
+	pdf.SetTitle(title, true)
+	pdf.SetCreator("OnCloud", true)
+	pdf.SetAutoPageBreak(true, 15)
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-12)
+		pdf.SetFont("Helvetica", "I", 8)
+		pdf.SetTextColor(120, 120, 120)
+		pdf.CellFormat(0, 8, fmt.Sprintf("Page %d", pdf.PageNo()), "", 0, "C", false, 0, "")
+	})
+
+	pdf.AddPage()
+	pdf.SetFont("Helvetica", "B", 18)
+	pdf.CellFormat(0, 10, tr(title), "", 1, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 9)
+	pdf.SetTextColor(100, 100, 100)
+	pdf.CellFormat(0, 6, "Generated "+time.Now().UTC().Format("2006-01-02 15:04 MST"), "", 1, "L", false, 0, "")
+	pdf.SetTextColor(0, 0, 0)
+	pdf.Ln(4)
+
+	for _, sheet := range sheets {
+		pdf.SetFont("Helvetica", "B", 13)
+		pdf.CellFormat(0, 8, tr(strings.ReplaceAll(sheet.Name, "_", " ")), "", 1, "L", false, 0, "")
+		pdf.Ln(1)
+
+		if labelCol, valueCol, ok := chartColumns(sheet); ok {
+			drawPDFBarChart(pdf, tr, sheet, labelCol, valueCol)
+		}
+		drawPDFTable(pdf, tr, sheet)
+		pdf.Ln(6)
+	}
+
+	return pdf.OutputFileAndClose(filePath)
+}
+
+func drawPDFBarChart(pdf *fpdf.Fpdf, tr func(string) string, sheet exportSheet, labelCol, valueCol int) {
+	const chartHeight = 60.0
+
+	left, _, right, _ := pdf.GetMargins()
+	pageWidth, pageHeight := pdf.GetPageSize()
+	width := pageWidth - left - right
+
+	if pdf.GetY()+chartHeight+12 > pageHeight-15 {
+		pdf.AddPage()
+	}
+
+	maxValue := 0.0
+	for _, row := range sheet.Rows {
+		if v, _ := toFloat(row[valueCol]); v > maxValue {
+			maxValue = v
+		}
+	}
+	if maxValue == 0 {
+		maxValue = 1
+	}
+
+	top := pdf.GetY()
+	baseline := top + chartHeight
+	slot := width / float64(len(sheet.Rows))
+
+	pdf.SetDrawColor(180, 180, 180)
+	pdf.Line(left, baseline, left+width, baseline)
+	pdf.SetFillColor(47, 85, 151)
+	for i, row := range sheet.Rows {
+		v, _ := toFloat(row[valueCol])
+		barHeight := (v / maxValue) * (chartHeight - 5)
+		pdf.Rect(left+float64(i)*slot+slot*0.15, baseline-barHeight, slot*0.7, barHeight, "F")
+	}
+
+	// Label the first, middle and last bars to keep the axis readable
+	pdf.SetFont("Helvetica", "", 7)
+	for _, i := range []int{0, len(sheet.Rows) / 2, len(sheet.Rows) - 1} {
+		label := truncateCell(formatPDFCell(sheet.Rows[i][labelCol]), 20)
+		pdf.SetXY(left+float64(i)*slot-10+slot/2, baseline+1)
+		pdf.CellFormat(20, 4, tr(label), "", 0, "C", false, 0, "")
+	}
+
+	pdf.SetXY(left, top)
+	pdf.SetFont("Helvetica", "", 8)
+	pdf.CellFormat(width, 4, tr(fmt.Sprintf("%s (max %s)", sheet.Headers[valueCol], formatPDFCell(maxValue))), "", 0, "R", false, 0, "")
+	pdf.SetXY(left, baseline+7)
+}
+
+func drawPDFTable(pdf *fpdf.Fpdf, tr func(string) string, sheet exportSheet) {
+	if len(sheet.Headers) == 0 {
+		return
+	}
+
+	columns := len(sheet.Headers)
+	if columns > maxPDFColumns {
+		columns = maxPDFColumns
+	}
+
+	left, _, right, _ := pdf.GetMargins()
+	pageWidth, pageHeight := pdf.GetPageSize()
+	colWidth := (pageWidth - left - right) / float64(columns)
+
+	header := func() {
+		pdf.SetFont("Helvetica", "B", 8)
+		pdf.SetFillColor(47, 85, 151)
+		pdf.SetTextColor(255, 255, 255)
+		for _, h := range sheet.Headers[:columns] {
+			pdf.CellFormat(colWidth, 7, tr(truncateCell(h, maxPDFCellChars)), "1", 0, "L", true, 0, "")
+		}
+		pdf.Ln(-1)
+		pdf.SetTextColor(0, 0, 0)
+		pdf.SetFont("Helvetica", "", 8)
+	}
+
+	header()
+	for i, row := range sheet.Rows {
+		if pdf.GetY()+6 > pageHeight-15 {
+			pdf.AddPage()
+			header()
+		}
+
+		fill := i%2 == 1
+		pdf.SetFillColor(242, 242, 242)
+		for c := 0; c < columns; c++ {
+			align := "L"
+			if _, isNumber := toFloat(row[c]); isNumber {
+				align = "R"
+			}
+			pdf.CellFormat(colWidth, 6, tr(truncateCell(formatPDFCell(row[c]), maxPDFCellChars)), "1", 0, align, fill, 0, "")
+		}
+		pdf.Ln(-1)
+	}
+
+	if len(sheet.Headers) > columns {
+		pdf.SetFont("Helvetica", "I", 7)
+		pdf.CellFormat(0, 5, fmt.Sprintf("%d more columns omitted; use the Excel or CSV export for the full data", len(sheet.Headers)-columns), "", 1, "L", false, 0, "")
+	}
+}
+
+func formatPDFCell(value interface{}) string {
+	switch v := value.(type) {
+	case time.Time:
+		return v.Format("2006-01-02 15:04")
+	case float64:
+		return fmt.Sprintf("%.2f", v)
+	case float32:
+		return fmt.Sprintf("%.2f", v)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+func truncateCell(value string, max int) string {
+	runes := []rune(value)
+	if len(runes) <= max {
+		return value
+	}
+	return string(runes[:max-3]) + "..."
+}
@@ -0,0 +1,158 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"oncloud/database"
+	"oncloud/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// FavoriteFilters narrows and orders the combined favorites list
+type FavoriteFilters struct {
+	Type      string // file, folder or empty for both
+	SortBy    string
+	SortOrder string
+}
+
+var favoriteSortFields = map[string]bool{
+	"favorited_at": true,
+	"name":         true,
+	"size":         true,
+	"type":         true,
+	"created_at":   true,
+	"updated_at":   true,
+}
+
+type FavoriteService struct {
+	*BaseService
+}
+
+func NewFavoriteService() *FavoriteService {
+	return NewFavoriteServiceWith(Dependencies{})
+}
+
+// NewFavoriteServiceWith creates a favorite service on the given
+// dependencies
+func NewFavoriteServiceWith(deps Dependencies) *FavoriteService {
+	return &FavoriteService{
+		BaseService: NewBaseServiceWith(deps),
+	}
+}
+
+// GetFavorites returns the user's favorite files and folders as one list,
+// most recently starred first unless another sort is requested
+func (fs *FavoriteService) GetFavorites(ctx context.Context, userID primitive.ObjectID, page, limit int, filters *FavoriteFilters) ([]models.FavoriteItem, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	sortBy := filters.SortBy
+	if !favoriteSortFields[sortBy] {
+		sortBy = "favorited_at"
+	}
+	sortOrder := -1
+	if filters.SortOrder == "asc" {
+		sortOrder = 1
+	}
+
+	match := bson.D{{Key: "$match", Value: bson.M{
+		"user_id":     userID,
+		"is_favorite": true,
+		"is_deleted":  false,
+	}}}
+	fileProject := bson.D{{Key: "$project", Value: bson.M{
+		"type":          bson.M{"$literal": "file"},
+		"name":          1,
+		"parent_id":     "$folder_id",
+		"path":          1,
+		"size":          1,
+		"mime_type":     1,
+		"extension":     1,
+		"thumbnail_url": 1,
+		"tags":          1,
+		"favorited_at":  1,
+		"created_at":    1,
+		"updated_at":    1,
+	}}}
+	folderProject := bson.D{{Key: "$project", Value: bson.M{
+		"type":         bson.M{"$literal": "folder"},
+		"name":         1,
+		"parent_id":    1,
+		"path":         1,
+		"size":         1,
+		"color":        1,
+		"files_count":  1,
+		"tags":         1,
+		"favorited_at": 1,
+		"created_at":   1,
+		"updated_at":   1,
+	}}}
+
+	var collection *mongo.Collection
+	var pipeline mongo.Pipeline
+	switch filters.Type {
+	case "file":
+		collection = fs.collections.Files()
+		pipeline = mongo.Pipeline{match, fileProject}
+	case "folder":
+		collection = fs.collections.Folders()
+		pipeline = mongo.Pipeline{match, folderProject}
+	default:
+		collection = fs.collections.Files()
+		pipeline = mongo.Pipeline{
+			match,
+			fileProject,
+			{{Key: "$unionWith", Value: bson.M{
+				"coll":     database.FoldersCollection,
+				"pipeline": mongo.Pipeline{match, folderProject},
+			}}},
+		}
+	}
+
+	skip := (page - 1) * limit
+	pipeline = append(pipeline,
+		bson.D{{Key: "$sort", Value: bson.D{{Key: sortBy, Value: sortOrder}, {Key: "_id", Value: sortOrder}}}},
+		bson.D{{Key: "$facet", Value: bson.M{
+			"items": bson.A{
+				bson.M{"$skip": skip},
+				bson.M{"$limit": limit},
+			},
+			"total": bson.A{
+				bson.M{"$count": "count"},
+			},
+		}}},
+	)
+
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get favorites: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var results []struct {
+		Items []models.FavoriteItem `bson:"items"`
+		Total []struct {
+			Count int `bson:"count"`
+		} `bson:"total"`
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode favorites: %v", err)
+	}
+
+	items := []models.FavoriteItem{}
+	total := 0
+	if len(results) > 0 {
+		if results[0].Items != nil {
+			items = results[0].Items
+		}
+		if len(results[0].Total) > 0 {
+			total = results[0].Total[0].Count
+		}
+	}
+
+	return items, total, nil
+}
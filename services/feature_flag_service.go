@@ -0,0 +1,431 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"oncloud/models"
+	"oncloud/utils"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Built-in feature flags gate subsystems of the server, so they can be
+// turned off, or rolled out again, without a deploy
+const (
+	FeatureUploadDedup       = "upload_dedup"
+	FeatureUsageTransactions = "usage_transactions"
+)
+
+// Flags are reloaded this often, so changes made through another instance
+// are picked up without a restart
+const featureFlagsTTL = 30 * time.Second
+
+var (
+	ErrFeatureFlagNotFound = notFoundError("feature flag not found")
+	// ErrInvalidFeatureFlag wraps any rejected feature flag
+	ErrInvalidFeatureFlag = errors.New("invalid feature flag")
+)
+
+var featureFlagKeyPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,63}$`)
+
+// builtinFeatureFlags are the flags the server checks, on for everyone or
+// off for everyone until an admin sets them
+var builtinFeatureFlags = []struct {
+	key         string
+	description string
+	enabled     bool
+}{
+	{
+		key:         FeatureUploadDedup,
+		description: "Refuse uploads of a file the user already has",
+		enabled:     true,
+	},
+	{
+		key:         FeatureUsageTransactions,
+		description: "Move usage counters in the same transaction as the file change, where MongoDB supports transactions",
+		enabled:     true,
+	},
+}
+
+// featureFlagCache holds the flags, shared by every service instance
+var featureFlagCache struct {
+	sync.RWMutex
+	flags    map[string]*models.FeatureFlag
+	loadedAt time.Time
+}
+
+type FeatureFlagService struct {
+	*BaseService
+}
+
+func NewFeatureFlagService() *FeatureFlagService {
+	return NewFeatureFlagServiceWith(Dependencies{})
+}
+
+// NewFeatureFlagServiceWith creates a feature flag service on the given
+// dependencies
+func NewFeatureFlagServiceWith(deps Dependencies) *FeatureFlagService {
+	return &FeatureFlagService{
+		BaseService: NewBaseServiceWith(deps),
+	}
+}
+
+// InvalidateFeatureFlags makes the next check reload the flags
+func InvalidateFeatureFlags() {
+	featureFlagCache.Lock()
+	featureFlagCache.loadedAt = time.Time{}
+	featureFlagCache.Unlock()
+}
+
+// ListFlags returns every feature flag, with the built-in flags no admin has
+// set at their defaults, by key
+func (ffs *FeatureFlagService) ListFlags(ctx context.Context) ([]models.FeatureFlag, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	cursor, err := ffs.collections.FeatureFlags().Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var stored []models.FeatureFlag
+	if err := cursor.All(ctx, &stored); err != nil {
+		return nil, err
+	}
+
+	flags := withBuiltinFeatureFlags(stored)
+	list := make([]models.FeatureFlag, 0, len(flags))
+	for _, flag := range flags {
+		list = append(list, *flag)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Key < list[j].Key })
+	return list, nil
+}
+
+// SetFlag sets a feature flag, creating it if needed. It takes effect
+// immediately on this instance and within featureFlagsTTL on others.
+func (ffs *FeatureFlagService) SetFlag(ctx context.Context, key string, req *models.FeatureFlagRequest, adminID primitive.ObjectID) (*models.FeatureFlag, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	key = strings.TrimSpace(key)
+	if !featureFlagKeyPattern.MatchString(key) {
+		return nil, fmt.Errorf("%w: keys are up to 64 lowercase letters, digits, dots, dashes and underscores", ErrInvalidFeatureFlag)
+	}
+
+	userIDs, err := ffs.targetIDs(ctx, req.UserIDs, ffs.collections.Users(), "users")
+	if err != nil {
+		return nil, err
+	}
+	planIDs, err := ffs.targetIDs(ctx, req.PlanIDs, ffs.collections.Plans(), "plans")
+	if err != nil {
+		return nil, err
+	}
+	groupIDs, err := ffs.targetIDs(ctx, req.GroupIDs, ffs.collections.ScimGroups(), "groups")
+	if err != nil {
+		return nil, err
+	}
+
+	description := strings.TrimSpace(req.Description)
+	if description == "" {
+		for _, builtin := range builtinFeatureFlags {
+			if builtin.key == key {
+				description = builtin.description
+			}
+		}
+	}
+
+	now := time.Now()
+	var flag models.FeatureFlag
+	err = ffs.collections.FeatureFlags().FindOneAndUpdate(ctx,
+		bson.M{"key": key},
+		bson.M{
+			"$set": bson.M{
+				"description": description,
+				"enabled":     req.Enabled,
+				"percentage":  req.Percentage,
+				"user_ids":    userIDs,
+				"plan_ids":    planIDs,
+				"group_ids":   groupIDs,
+				"updated_by":  adminID,
+				"updated_at":  now,
+			},
+			"$setOnInsert": bson.M{"created_at": now},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&flag)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save feature flag: %v", err)
+	}
+	flag.Builtin = isBuiltinFeatureFlag(key)
+
+	InvalidateFeatureFlags()
+	return &flag, nil
+}
+
+// DeleteFlag removes a feature flag. Built-in flags go back to their
+// defaults.
+func (ffs *FeatureFlagService) DeleteFlag(ctx context.Context, key string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := ffs.collections.FeatureFlags().DeleteOne(ctx, bson.M{"key": key})
+	if err != nil {
+		return fmt.Errorf("failed to delete feature flag: %v", err)
+	}
+	if result.DeletedCount == 0 {
+		return ErrFeatureFlagNotFound
+	}
+
+	InvalidateFeatureFlags()
+	return nil
+}
+
+// IsEnabled reports whether a feature flag is on for a user. Unknown flags
+// are off.
+func (ffs *FeatureFlagService) IsEnabled(ctx context.Context, key string, userID primitive.ObjectID) bool {
+	flag, exists := ffs.flags()[key]
+	if !exists {
+		return false
+	}
+	return ffs.evaluate(ctx, flag, &featureFlagSubject{userID: &userID})
+}
+
+// UserFlags returns whether each feature flag is on for a user, or for an
+// anonymous visitor when userID is nil
+func (ffs *FeatureFlagService) UserFlags(ctx context.Context, userID *primitive.ObjectID) map[string]bool {
+	subject := &featureFlagSubject{userID: userID}
+
+	states := make(map[string]bool)
+	for key, flag := range ffs.flags() {
+		states[key] = ffs.evaluate(ctx, flag, subject)
+	}
+	return states
+}
+
+// featureFlagSubject is who a flag is checked for. Their plan and groups
+// are only looked up when a flag targets plans or groups.
+type featureFlagSubject struct {
+	userID   *primitive.ObjectID
+	loaded   bool
+	planID   primitive.ObjectID
+	groupIDs []primitive.ObjectID
+}
+
+func (ffs *FeatureFlagService) evaluate(ctx context.Context, flag *models.FeatureFlag, subject *featureFlagSubject) bool {
+	if !flag.Enabled {
+		return false
+	}
+	if flag.Percentage >= 100 {
+		return true
+	}
+	if subject.userID == nil {
+		return false
+	}
+
+	userID := *subject.userID
+	if containsObjectID(flag.UserIDs, userID) || featureFlagBucket(flag.Key, userID) < flag.Percentage {
+		return true
+	}
+	if len(flag.PlanIDs) == 0 && len(flag.GroupIDs) == 0 {
+		return false
+	}
+
+	if !subject.loaded {
+		if err := ffs.loadSubject(ctx, subject); err != nil {
+			log.Printf("Failed to look up user %s for feature flags: %v", userID.Hex(), err)
+			return false
+		}
+	}
+	if containsObjectID(flag.PlanIDs, subject.planID) {
+		return true
+	}
+	for _, groupID := range subject.groupIDs {
+		if containsObjectID(flag.GroupIDs, groupID) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadSubject looks up the plan and directory groups of a user
+func (ffs *FeatureFlagService) loadSubject(ctx context.Context, subject *featureFlagSubject) error {
+	// A failed lookup is not retried for the other flags
+	subject.loaded = true
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var user struct {
+		PlanID primitive.ObjectID `bson:"plan_id"`
+	}
+	err := ffs.collections.Users().FindOne(ctx, bson.M{"_id": *subject.userID},
+		options.FindOne().SetProjection(bson.M{"plan_id": 1}),
+	).Decode(&user)
+	if err != nil {
+		return err
+	}
+
+	cursor, err := ffs.collections.ScimGroups().Find(ctx,
+		bson.M{"members": *subject.userID},
+		options.Find().SetProjection(bson.M{"_id": 1}),
+	)
+	if err != nil {
+		return err
+	}
+	var groups []models.ScimGroup
+	if err := cursor.All(ctx, &groups); err != nil {
+		return err
+	}
+
+	subject.planID = user.PlanID
+	for _, group := range groups {
+		subject.groupIDs = append(subject.groupIDs, group.ID)
+	}
+	return nil
+}
+
+// targetIDs parses the IDs a flag targets and checks they all exist
+func (ffs *FeatureFlagService) targetIDs(ctx context.Context, ids []string, collection *mongo.Collection, what string) ([]primitive.ObjectID, error) {
+	objIDs := []primitive.ObjectID{}
+	for _, id := range ids {
+		objID, err := utils.StringToObjectID(id)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid ID %q in %s", ErrInvalidFeatureFlag, id, what)
+		}
+		if !containsObjectID(objIDs, objID) {
+			objIDs = append(objIDs, objID)
+		}
+	}
+	if len(objIDs) == 0 {
+		return objIDs, nil
+	}
+
+	count, err := collection.CountDocuments(ctx, bson.M{"_id": bson.M{"$in": objIDs}})
+	if err != nil {
+		return nil, err
+	}
+	if int(count) != len(objIDs) {
+		return nil, fmt.Errorf("%w: some of the %s were not found", ErrInvalidFeatureFlag, what)
+	}
+	return objIDs, nil
+}
+
+// flags returns the cached flags, reloading them once they are stale. When
+// the database cannot be reached the previous flags stay in use until the
+// next reload.
+func (ffs *FeatureFlagService) flags() map[string]*models.FeatureFlag {
+	featureFlagCache.RLock()
+	flags, loadedAt := featureFlagCache.flags, featureFlagCache.loadedAt
+	featureFlagCache.RUnlock()
+	if time.Since(loadedAt) < featureFlagsTTL {
+		return flags
+	}
+
+	featureFlagCache.Lock()
+	defer featureFlagCache.Unlock()
+	if time.Since(featureFlagCache.loadedAt) < featureFlagsTTL {
+		return featureFlagCache.flags
+	}
+
+	stored, err := ffs.loadFlags()
+	if err != nil {
+		log.Printf("Failed to load feature flags: %v", err)
+		if featureFlagCache.flags == nil {
+			featureFlagCache.flags = withBuiltinFeatureFlags(nil)
+		}
+	} else {
+		featureFlagCache.flags = withBuiltinFeatureFlags(stored)
+	}
+	featureFlagCache.loadedAt = time.Now()
+	return featureFlagCache.flags
+}
+
+func (ffs *FeatureFlagService) loadFlags() ([]models.FeatureFlag, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := ffs.collections.FeatureFlags().Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var flags []models.FeatureFlag
+	if err := cursor.All(ctx, &flags); err != nil {
+		return nil, err
+	}
+	return flags, nil
+}
+
+// withBuiltinFeatureFlags returns the stored flags by key, along with the
+// built-in flags not stored at their defaults
+func withBuiltinFeatureFlags(stored []models.FeatureFlag) map[string]*models.FeatureFlag {
+	flags := make(map[string]*models.FeatureFlag, len(stored)+len(builtinFeatureFlags))
+	for i := range stored {
+		stored[i].Builtin = isBuiltinFeatureFlag(stored[i].Key)
+		flags[stored[i].Key] = &stored[i]
+	}
+
+	for _, builtin := range builtinFeatureFlags {
+		if _, exists := flags[builtin.key]; exists {
+			continue
+		}
+		flag := &models.FeatureFlag{
+			Key:         builtin.key,
+			Description: builtin.description,
+			Enabled:     builtin.enabled,
+			UserIDs:     []primitive.ObjectID{},
+			PlanIDs:     []primitive.ObjectID{},
+			GroupIDs:    []primitive.ObjectID{},
+			Builtin:     true,
+			IsDefault:   true,
+		}
+		if builtin.enabled {
+			flag.Percentage = 100
+		}
+		flags[builtin.key] = flag
+	}
+	return flags
+}
+
+func isBuiltinFeatureFlag(key string) bool {
+	for _, builtin := range builtinFeatureFlags {
+		if builtin.key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// featureFlagBucket places a user in the rollout of a flag, from 0 to 99.
+// The place depends on the flag too, so the same users are not always the
+// first to get every feature.
+func featureFlagBucket(key string, userID primitive.ObjectID) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	h.Write([]byte{':'})
+	h.Write(userID[:])
+	return int(h.Sum32() % 100)
+}
+
+func containsObjectID(ids []primitive.ObjectID, id primitive.ObjectID) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
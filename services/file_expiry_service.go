@@ -0,0 +1,263 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"oncloud/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// fileExpiryBatch bounds the files warned or trashed in one run
+const fileExpiryBatch = 500
+
+var (
+	ErrExpiryFileNotFound = notFoundError("file not found")
+	// ErrFileExpired is returned for files past their expiry, which cannot
+	// be opened, downloaded or shared until it is extended
+	ErrFileExpired = errors.New("this file has expired")
+	// ErrInvalidFileExpiry wraps any rejected expiry
+	ErrInvalidFileExpiry = errors.New("invalid file expiry")
+)
+
+// checkFileExpiry returns ErrFileExpired if file is past its expiry
+func checkFileExpiry(file *models.File) error {
+	if file.ExpiresAt != nil && !file.ExpiresAt.After(time.Now()) {
+		return ErrFileExpired
+	}
+	return nil
+}
+
+type FileExpiryService struct {
+	*BaseService
+	fileService *FileService
+}
+
+func NewFileExpiryService() *FileExpiryService {
+	return NewFileExpiryServiceWith(Dependencies{})
+}
+
+// NewFileExpiryServiceWith creates a file expiry service on the given
+// dependencies
+func NewFileExpiryServiceWith(deps Dependencies) *FileExpiryService {
+	return &FileExpiryService{
+		BaseService: NewBaseServiceWith(deps),
+		fileService: NewFileServiceWith(deps),
+	}
+}
+
+// SetExpiry sets when one of the user's files expires, or extends its
+// expiry. A file that has already expired but is not in the trash yet
+// becomes accessible again.
+func (fes *FileExpiryService) SetExpiry(ctx context.Context, userID, fileID primitive.ObjectID, req *models.FileExpiryRequest) (*models.File, error) {
+	file, err := fes.fileService.GetUserFile(ctx, userID, fileID)
+	if err != nil {
+		return nil, ErrExpiryFileNotFound
+	}
+	if err := checkFileLock(file, userID); err != nil {
+		return nil, err
+	}
+
+	now := fes.clock.Now()
+	var expiresAt time.Time
+	switch {
+	case req.ExtendDays > 0 && req.ExpiresAt != nil:
+		return nil, fmt.Errorf("%w: give either expires_at or extend_days", ErrInvalidFileExpiry)
+	case req.ExtendDays > 0:
+		from := now
+		if file.ExpiresAt != nil && file.ExpiresAt.After(now) {
+			from = *file.ExpiresAt
+		}
+		expiresAt = from.AddDate(0, 0, req.ExtendDays)
+	case req.ExpiresAt != nil:
+		if !req.ExpiresAt.After(now) {
+			return nil, fmt.Errorf("%w: expires_at must be in the future", ErrInvalidFileExpiry)
+		}
+		expiresAt = *req.ExpiresAt
+	default:
+		return nil, fmt.Errorf("%w: expires_at or extend_days is required", ErrInvalidFileExpiry)
+	}
+
+	return fes.updateExpiry(ctx, userID, fileID, bson.M{
+		"$set":   bson.M{"expires_at": expiresAt, "updated_at": now},
+		"$unset": bson.M{"expiry_warned_at": ""},
+	})
+}
+
+// ClearExpiry keeps one of the user's files until it is deleted
+func (fes *FileExpiryService) ClearExpiry(ctx context.Context, userID, fileID primitive.ObjectID) (*models.File, error) {
+	file, err := fes.fileService.GetUserFile(ctx, userID, fileID)
+	if err != nil {
+		return nil, ErrExpiryFileNotFound
+	}
+	if err := checkFileLock(file, userID); err != nil {
+		return nil, err
+	}
+
+	return fes.updateExpiry(ctx, userID, fileID, bson.M{
+		"$set":   bson.M{"updated_at": time.Now()},
+		"$unset": bson.M{"expires_at": "", "expiry_warned_at": ""},
+	})
+}
+
+func (fes *FileExpiryService) updateExpiry(ctx context.Context, userID, fileID primitive.ObjectID, update bson.M) (*models.File, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var file models.File
+	err := fes.collections.Files().FindOneAndUpdate(ctx,
+		bson.M{"_id": fileID, "user_id": userID, "is_deleted": false},
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&file)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrExpiryFileNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update file expiry: %v", err)
+	}
+	return &file, nil
+}
+
+// ProcessExpiries warns the owners of files expiring within warnBefore,
+// once per expiry, and moves expired files to the trash. It returns how
+// many files it warned of and trashed.
+func (fes *FileExpiryService) ProcessExpiries(ctx context.Context, warnBefore time.Duration) (int, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	now := fes.clock.Now()
+	warned, err := fes.warnExpiring(ctx, now, warnBefore)
+	if err != nil {
+		return warned, 0, err
+	}
+	trashed, err := fes.trashExpired(ctx, now)
+	return warned, trashed, err
+}
+
+func (fes *FileExpiryService) warnExpiring(ctx context.Context, now time.Time, warnBefore time.Duration) (int, error) {
+	if warnBefore <= 0 {
+		return 0, nil
+	}
+
+	cursor, err := fes.collections.Files().Find(ctx, bson.M{
+		"is_deleted":       false,
+		"expires_at":       bson.M{"$gt": now, "$lte": now.Add(warnBefore)},
+		"expiry_warned_at": bson.M{"$exists": false},
+	}, options.Find().SetLimit(fileExpiryBatch))
+	if err != nil {
+		return 0, fmt.Errorf("failed to find expiring files: %v", err)
+	}
+	var files []models.File
+	if err := cursor.All(ctx, &files); err != nil {
+		return 0, fmt.Errorf("failed to find expiring files: %v", err)
+	}
+
+	warned := 0
+	for i := range files {
+		file := &files[i]
+		// Claim the warning so it is sent once, even by concurrent runs
+		result, err := fes.collections.Files().UpdateOne(ctx,
+			bson.M{"_id": file.ID, "expires_at": file.ExpiresAt, "expiry_warned_at": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"expiry_warned_at": now}},
+		)
+		if err != nil {
+			log.Printf("Failed to record expiry warning of file %s: %v", file.ID.Hex(), err)
+			continue
+		}
+		if result.ModifiedCount == 0 {
+			continue
+		}
+
+		name := fileDisplayName(file)
+		expires := file.ExpiresAt.UTC().Format("January 2, 2006 15:04 MST")
+		fes.notify(ctx, file, "file_expiring", name+" expires soon",
+			fmt.Sprintf("%s expires on %s and will then be moved to the trash. Extend its expiry to keep it.", name, expires))
+
+		var owner models.User
+		if err := fes.collections.Users().FindOne(ctx, bson.M{"_id": file.UserID}).Decode(&owner); err == nil {
+			NewAuthService().sendEmailNotification(owner.Email, "file_expiring", map[string]string{
+				"name":    owner.FirstName + " " + owner.LastName,
+				"item":    name,
+				"expires": expires,
+			})
+		}
+		warned++
+	}
+	return warned, nil
+}
+
+func (fes *FileExpiryService) trashExpired(ctx context.Context, now time.Time) (int, error) {
+	cursor, err := fes.collections.Files().Find(ctx, bson.M{
+		"is_deleted": false,
+		"expires_at": bson.M{"$lte": now},
+	}, options.Find().SetLimit(fileExpiryBatch))
+	if err != nil {
+		return 0, fmt.Errorf("failed to find expired files: %v", err)
+	}
+	var files []models.File
+	if err := cursor.All(ctx, &files); err != nil {
+		return 0, fmt.Errorf("failed to find expired files: %v", err)
+	}
+
+	trashed := 0
+	for i := range files {
+		file := &files[i]
+		// The expiry may have been extended since the file was found
+		modified := int64(0)
+		err := fes.fileService.usage.ApplyChange(ctx, file.UserID, func(ctx context.Context) (int64, int, error) {
+			result, err := fes.collections.Files().UpdateOne(ctx,
+				bson.M{"_id": file.ID, "is_deleted": false, "expires_at": bson.M{"$lte": now}},
+				bson.M{"$set": bson.M{
+					"is_deleted": true,
+					"deleted_at": now,
+					"updated_at": now,
+				}},
+			)
+			if err != nil {
+				return 0, 0, err
+			}
+			modified = result.ModifiedCount
+			return 0, -int(modified), nil
+		})
+		if err != nil {
+			log.Printf("Failed to trash expired file %s: %v", file.ID.Hex(), err)
+			continue
+		}
+		if modified == 0 {
+			continue
+		}
+
+		fes.fileService.refreshTags(ctx, file)
+		fes.fileService.trackFolderUsage(ctx, file, -1)
+
+		name := fileDisplayName(file)
+		fes.notify(ctx, file, "file_expired", name+" expired",
+			name+" expired and was moved to the trash. Restore it from the trash to keep it.")
+		trashed++
+	}
+	return trashed, nil
+}
+
+// notify adds a notification about a file's expiry for its owner
+func (fes *FileExpiryService) notify(ctx context.Context, file *models.File, kind, title, message string) {
+	fes.collections.Notifications().InsertOne(ctx, bson.M{
+		"_id":     primitive.NewObjectID(),
+		"user_id": file.UserID,
+		"type":    kind,
+		"title":   title,
+		"message": message,
+		"data": bson.M{
+			"file_id":    file.ID,
+			"expires_at": file.ExpiresAt,
+		},
+		"is_read":    false,
+		"created_at": time.Now(),
+	})
+}
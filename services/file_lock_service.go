@@ -0,0 +1,287 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"oncloud/models"
+	"oncloud/utils"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const defaultFileLockTTL = 30 * time.Minute
+
+// FileLockedError is returned when a file is locked for editing by someone else
+type FileLockedError struct {
+	Lock *models.FileLock
+}
+
+func (e *FileLockedError) Error() string {
+	return "file is locked for editing by another user"
+}
+
+// ErrFileNotLocked is returned when an operation expects a lock that is not held
+var ErrFileNotLocked = errors.New("file is not locked")
+
+// liveLock returns the file's lock unless it has expired
+func liveLock(file *models.File) *models.FileLock {
+	if file.Lock == nil || !time.Now().Before(file.Lock.ExpiresAt) {
+		return nil
+	}
+	return file.Lock
+}
+
+// checkFileLock returns a FileLockedError if file holds a live lock that
+// belongs to someone other than userID
+func checkFileLock(file *models.File, userID primitive.ObjectID) error {
+	if lock := liveLock(file); lock != nil && lock.LockedBy != userID {
+		return &FileLockedError{Lock: lock}
+	}
+	return nil
+}
+
+type FileLockService struct {
+	*BaseService
+}
+
+func NewFileLockService() *FileLockService {
+	return NewFileLockServiceWith(Dependencies{})
+}
+
+// NewFileLockServiceWith creates a file lock service on the given
+// dependencies
+func NewFileLockServiceWith(deps Dependencies) *FileLockService {
+	return &FileLockService{
+		BaseService: NewBaseServiceWith(deps),
+	}
+}
+
+// GetLock returns the live lock on a user's file, or nil if it is unlocked
+func (fls *FileLockService) GetLock(ctx context.Context, userID, fileID primitive.ObjectID) (*models.FileLock, error) {
+	file, err := fls.getUserFile(ctx, userID, fileID)
+	if err != nil {
+		return nil, err
+	}
+	return liveLock(file), nil
+}
+
+// LockFile takes an exclusive edit lock on a file, or extends the caller's
+// existing lock. Expired locks held by others are taken over.
+func (fls *FileLockService) LockFile(ctx context.Context, userID, fileID primitive.ObjectID, req *models.FileLockRequest) (*models.FileLock, error) {
+	if err := utils.ValidateStruct(req); err != nil {
+		return nil, err
+	}
+
+	file, err := fls.getUserFile(ctx, userID, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkFileLock(file, userID); err != nil {
+		return nil, err
+	}
+
+	ttl := defaultFileLockTTL
+	if req.TTLSeconds > 0 {
+		ttl = time.Duration(req.TTLSeconds) * time.Second
+	}
+
+	now := time.Now()
+	lock := &models.FileLock{
+		LockedBy:  userID,
+		Note:      req.Note,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+
+	// A refresh keeps the original token so clients holding it stay valid
+	if file.Lock != nil && file.Lock.LockedBy == userID && now.Before(file.Lock.ExpiresAt) {
+		lock.Token = file.Lock.Token
+		lock.CreatedAt = file.Lock.CreatedAt
+	} else {
+		token, err := utils.GenerateSecureToken(16)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate lock token: %v", err)
+		}
+		lock.Token = token
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	// The filter makes acquisition atomic: it only matches while the file is
+	// unlocked, the lock has expired or the caller already holds it
+	result, err := fls.collections.Files().UpdateOne(ctx,
+		bson.M{
+			"_id":        fileID,
+			"user_id":    userID,
+			"is_deleted": false,
+			"$or": []bson.M{
+				{"lock": bson.M{"$exists": false}},
+				{"lock.expires_at": bson.M{"$lte": now}},
+				{"lock.locked_by": userID},
+			},
+		},
+		bson.M{"$set": bson.M{"lock": lock}},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock file: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		// Someone else locked the file since it was read
+		if current, err := fls.getUserFile(ctx, userID, fileID); err == nil && current.Lock != nil {
+			return nil, &FileLockedError{Lock: current.Lock}
+		}
+		return nil, errors.New("failed to lock file")
+	}
+
+	return lock, nil
+}
+
+// UnlockFile releases a lock held by the caller. With force the file's owner
+// can also release a lock held by someone else.
+func (fls *FileLockService) UnlockFile(ctx context.Context, userID, fileID primitive.ObjectID, force bool) (*models.FileLock, error) {
+	file, err := fls.getUserFile(ctx, userID, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if file.Lock == nil {
+		return nil, nil
+	}
+	if !force {
+		if err := checkFileLock(file, userID); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err = fls.collections.Files().UpdateOne(ctx,
+		bson.M{"_id": fileID, "lock.token": file.Lock.Token},
+		bson.M{"$unset": bson.M{"lock": ""}},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unlock file: %v", err)
+	}
+
+	return file.Lock, nil
+}
+
+// ForceUnlock releases any lock on a file (admin only) and returns the lock
+// that was removed
+func (fls *FileLockService) ForceUnlock(ctx context.Context, fileID primitive.ObjectID) (*models.FileLock, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var file models.File
+	err := fls.collections.Files().FindOneAndUpdate(ctx,
+		bson.M{"_id": fileID},
+		bson.M{"$unset": bson.M{"lock": ""}},
+	).Decode(&file)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("file not found")
+		}
+		return nil, fmt.Errorf("failed to unlock file: %v", err)
+	}
+
+	return file.Lock, nil
+}
+
+// LockWithToken takes or refreshes a lock identified by a client-chosen
+// token, as office editors do over WOPI. A lock with a different token is
+// only replaced when oldToken matches it.
+func (fls *FileLockService) LockWithToken(ctx context.Context, file *models.File, userID primitive.ObjectID, token, oldToken string, ttl time.Duration) (*models.FileLock, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	lock := &models.FileLock{
+		Token:     token,
+		LockedBy:  userID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	if current := liveLock(file); current != nil && current.Token == token {
+		lock.LockedBy = current.LockedBy
+		lock.CreatedAt = current.CreatedAt
+	}
+
+	filter := bson.M{"_id": file.ID, "is_deleted": false}
+	if oldToken != "" {
+		filter["lock.token"] = oldToken
+		filter["lock.expires_at"] = bson.M{"$gt": now}
+	} else {
+		filter["$or"] = []bson.M{
+			{"lock": bson.M{"$exists": false}},
+			{"lock.expires_at": bson.M{"$lte": now}},
+			{"lock.token": token},
+		}
+	}
+
+	result, err := fls.collections.Files().UpdateOne(ctx, filter, bson.M{"$set": bson.M{"lock": lock}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock file: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return nil, fls.lockConflict(ctx, file.ID)
+	}
+
+	return lock, nil
+}
+
+// UnlockWithToken releases a lock if token matches it
+func (fls *FileLockService) UnlockWithToken(ctx context.Context, fileID primitive.ObjectID, token string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := fls.collections.Files().UpdateOne(ctx,
+		bson.M{"_id": fileID, "lock.token": token, "lock.expires_at": bson.M{"$gt": time.Now()}},
+		bson.M{"$unset": bson.M{"lock": ""}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to unlock file: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return fls.lockConflict(ctx, fileID)
+	}
+
+	return nil
+}
+
+// lockConflict describes why a token lock operation did not match: the file
+// holds someone else's lock, or none at all
+func (fls *FileLockService) lockConflict(ctx context.Context, fileID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var file models.File
+	if err := fls.collections.Files().FindOne(ctx, bson.M{"_id": fileID}).Decode(&file); err != nil {
+		return fmt.Errorf("file not found: %v", err)
+	}
+	if lock := liveLock(&file); lock != nil {
+		return &FileLockedError{Lock: lock}
+	}
+	return ErrFileNotLocked
+}
+
+func (fls *FileLockService) getUserFile(ctx context.Context, userID, fileID primitive.ObjectID) (*models.File, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var file models.File
+	err := fls.collections.Files().FindOne(ctx, bson.M{
+		"_id":        fileID,
+		"user_id":    userID,
+		"is_deleted": false,
+	}).Decode(&file)
+	if err != nil {
+		return nil, fmt.Errorf("file not found: %v", err)
+	}
+
+	return &file, nil
+}
@@ -0,0 +1,393 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"oncloud/models"
+	"oncloud/utils"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrFileRequestNotFound       = notFoundError("file request not found")
+	ErrFileRequestFolderNotFound = notFoundError("folder not found")
+	// ErrFileRequestGuest is returned when a guest leaves out a name or
+	// email the file request requires, or gives an invalid email
+	ErrFileRequestGuest = errors.New("uploader details are missing or invalid")
+	// ErrFileRequestGuestLimit is returned when an upload would take a guest
+	// past the file request's per-guest limits
+	ErrFileRequestGuestLimit = quotaExceededError("upload limit for this file request reached")
+	// ErrFileRequestOwnerFull is returned when the folder's owner has no
+	// room left for the upload. Guests are not told the owner's limits.
+	ErrFileRequestOwnerFull = quotaExceededError("this file request cannot receive more files")
+)
+
+type FileRequestService struct {
+	*BaseService
+	fileService   *FileService
+	folderService *FolderService
+}
+
+func NewFileRequestService() *FileRequestService {
+	return NewFileRequestServiceWith(Dependencies{})
+}
+
+// NewFileRequestServiceWith creates a file request service on the given
+// dependencies
+func NewFileRequestServiceWith(deps Dependencies) *FileRequestService {
+	return &FileRequestService{
+		BaseService:   NewBaseServiceWith(deps),
+		fileService:   NewFileServiceWith(deps),
+		folderService: NewFolderServiceWith(deps),
+	}
+}
+
+// GetRequest returns the file request of one of the user's folders
+func (frs *FileRequestService) GetRequest(ctx context.Context, userID, folderID primitive.ObjectID) (*models.FileRequest, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var request models.FileRequest
+	err := frs.collections.FileRequests().FindOne(ctx, bson.M{"folder_id": folderID, "user_id": userID}).Decode(&request)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrFileRequestNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file request: %v", err)
+	}
+	return &request, nil
+}
+
+// SaveRequest creates the folder's file request, or changes the one it has
+func (frs *FileRequestService) SaveRequest(ctx context.Context, userID, folderID primitive.ObjectID, req *models.FileRequestRequest) (*models.FileRequest, error) {
+	folder, err := frs.folderService.GetUserFolder(ctx, userID, folderID)
+	if err != nil {
+		return nil, ErrFileRequestFolderNotFound
+	}
+
+	existing, err := frs.GetRequest(ctx, userID, folderID)
+	if errors.Is(err, ErrFileRequestNotFound) {
+		return frs.createRequest(ctx, folder, req)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	set := bson.M{"updated_at": time.Now()}
+	if req.Title != nil {
+		title := strings.TrimSpace(*req.Title)
+		if title == "" {
+			title = folder.Name
+		}
+		set["title"] = title
+	}
+	if req.Message != nil {
+		set["message"] = strings.TrimSpace(*req.Message)
+	}
+	if req.RequireName != nil {
+		set["require_name"] = *req.RequireName
+	}
+	if req.RequireEmail != nil {
+		set["require_email"] = *req.RequireEmail
+	}
+	if req.MaxFilesPerGuest != nil {
+		set["max_files_per_guest"] = *req.MaxFilesPerGuest
+	}
+	if req.MaxBytesPerGuest != nil {
+		set["max_bytes_per_guest"] = *req.MaxBytesPerGuest
+	}
+	if req.ExpiresAt != nil {
+		set["expires_at"] = *req.ExpiresAt
+	}
+	if req.IsActive != nil {
+		set["is_active"] = *req.IsActive
+	}
+
+	var request models.FileRequest
+	err = frs.collections.FileRequests().FindOneAndUpdate(ctx,
+		bson.M{"_id": existing.ID},
+		bson.M{"$set": set},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&request)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrFileRequestNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update file request: %v", err)
+	}
+	return &request, nil
+}
+
+func (frs *FileRequestService) createRequest(ctx context.Context, folder *models.Folder, req *models.FileRequestRequest) (*models.FileRequest, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	token, err := utils.GenerateSecureToken(16)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate token: %v", err)
+	}
+
+	now := time.Now()
+	request := &models.FileRequest{
+		ID:        primitive.NewObjectID(),
+		UserID:    folder.UserID,
+		FolderID:  folder.ID,
+		Token:     token,
+		Title:     folder.Name,
+		IsActive:  true,
+		ExpiresAt: req.ExpiresAt,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if req.Title != nil && strings.TrimSpace(*req.Title) != "" {
+		request.Title = strings.TrimSpace(*req.Title)
+	}
+	if req.Message != nil {
+		request.Message = strings.TrimSpace(*req.Message)
+	}
+	if req.RequireName != nil {
+		request.RequireName = *req.RequireName
+	}
+	if req.RequireEmail != nil {
+		request.RequireEmail = *req.RequireEmail
+	}
+	if req.MaxFilesPerGuest != nil {
+		request.MaxFilesPerGuest = *req.MaxFilesPerGuest
+	}
+	if req.MaxBytesPerGuest != nil {
+		request.MaxBytesPerGuest = *req.MaxBytesPerGuest
+	}
+	if req.IsActive != nil {
+		request.IsActive = *req.IsActive
+	}
+
+	if _, err := frs.collections.FileRequests().InsertOne(ctx, request); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			// Created by a concurrent request
+			return frs.SaveRequest(ctx, folder.UserID, folder.ID, req)
+		}
+		return nil, fmt.Errorf("failed to create file request: %v", err)
+	}
+	return request, nil
+}
+
+// DeleteRequest closes the folder's file request. Files already uploaded
+// through it are kept along with their uploader.
+func (frs *FileRequestService) DeleteRequest(ctx context.Context, userID, folderID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := frs.collections.FileRequests().DeleteOne(ctx, bson.M{"folder_id": folderID, "user_id": userID})
+	if err != nil {
+		return fmt.Errorf("failed to delete file request: %v", err)
+	}
+	if result.DeletedCount == 0 {
+		return ErrFileRequestNotFound
+	}
+	return nil
+}
+
+// GetPage returns what guests are shown for an open file request
+func (frs *FileRequestService) GetPage(ctx context.Context, token string) (*models.FileRequestPage, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	request, folder, err := frs.openRequest(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.FileRequestPage{
+		Title:            request.Title,
+		Message:          request.Message,
+		FolderName:       folder.Name,
+		RequireName:      request.RequireName,
+		RequireEmail:     request.RequireEmail,
+		MaxFilesPerGuest: request.MaxFilesPerGuest,
+		MaxBytesPerGuest: request.MaxBytesPerGuest,
+		ExpiresAt:        request.ExpiresAt,
+	}, nil
+}
+
+// Upload saves a guest's file into the file request's folder, recording
+// who sent it. Guests are told apart by email, or by clientIP when they give
+// none, for the per-guest limits.
+func (frs *FileRequestService) Upload(ctx context.Context, token string, fileHeader *multipart.FileHeader, form *models.FileRequestUploadForm, clientIP string) (*models.File, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	request, folder, err := frs.openRequest(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	uploader, err := fileRequestUploader(request, form, clientIP)
+	if err != nil {
+		return nil, err
+	}
+	if err := frs.checkGuestLimits(ctx, request, uploader.GuestKey, fileHeader.Size); err != nil {
+		return nil, err
+	}
+
+	plan, err := frs.fileService.GetUserPlan(ctx, request.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user plan: %v", err)
+	}
+	var owner models.User
+	if err := frs.collections.Users().FindOne(ctx, bson.M{"_id": request.UserID}).Decode(&owner); err != nil {
+		return nil, fmt.Errorf("failed to get folder owner: %v", err)
+	}
+	if err := frs.fileService.CheckUploadLimits(&owner, plan, fileHeader.Size); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFileRequestOwnerFull, err)
+	}
+
+	src, err := fileHeader.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %v", err)
+	}
+	defer src.Close()
+	content, err := io.ReadAll(src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %v", err)
+	}
+
+	file, err := frs.fileService.UploadContent(ctx, request.UserID, fileHeader.Filename, content, &models.FileUploadRequest{
+		FolderID: folder.ID.Hex(),
+		Source:   models.UploadSourceRequest,
+		Uploader: uploader,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if _, err := frs.collections.FileRequests().UpdateOne(ctx,
+		bson.M{"_id": request.ID},
+		bson.M{
+			"$inc": bson.M{"uploads": 1, "bytes_received": file.Size},
+			"$set": bson.M{"last_upload_at": now},
+		},
+	); err != nil {
+		fmt.Printf("Failed to update file request %s: %v\n", request.ID.Hex(), err)
+	}
+
+	return file, nil
+}
+
+// openRequest finds the active, unexpired file request behind token and its
+// folder, which must not be in the trash
+func (frs *FileRequestService) openRequest(ctx context.Context, token string) (*models.FileRequest, *models.Folder, error) {
+	var request models.FileRequest
+	err := frs.collections.FileRequests().FindOne(ctx, bson.M{"token": token, "is_active": true}).Decode(&request)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil, ErrFileRequestNotFound
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get file request: %v", err)
+	}
+	if request.ExpiresAt != nil && request.ExpiresAt.Before(time.Now()) {
+		return nil, nil, ErrFileRequestNotFound
+	}
+
+	var folder models.Folder
+	err = frs.collections.Folders().FindOne(ctx, bson.M{
+		"_id":        request.FolderID,
+		"user_id":    request.UserID,
+		"is_deleted": false,
+	}).Decode(&folder)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil, ErrFileRequestNotFound
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get folder: %v", err)
+	}
+	return &request, &folder, nil
+}
+
+// checkGuestLimits checks that an upload of size bytes keeps the guest
+// within the file request's per-guest limits. Files the owner has since
+// deleted still count.
+func (frs *FileRequestService) checkGuestLimits(ctx context.Context, request *models.FileRequest, guestKey string, size int64) error {
+	if request.MaxFilesPerGuest <= 0 && request.MaxBytesPerGuest <= 0 {
+		return nil
+	}
+
+	cursor, err := frs.collections.Files().Aggregate(ctx, []bson.M{
+		{"$match": bson.M{
+			"uploader.file_request_id": request.ID,
+			"uploader.guest_key":       guestKey,
+		}},
+		{"$group": bson.M{
+			"_id":   nil,
+			"files": bson.M{"$sum": 1},
+			"bytes": bson.M{"$sum": "$size"},
+		}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to total guest uploads: %v", err)
+	}
+	var totals []struct {
+		Files int   `bson:"files"`
+		Bytes int64 `bson:"bytes"`
+	}
+	if err := cursor.All(ctx, &totals); err != nil {
+		return fmt.Errorf("failed to total guest uploads: %v", err)
+	}
+
+	var files int
+	var bytes int64
+	if len(totals) > 0 {
+		files, bytes = totals[0].Files, totals[0].Bytes
+	}
+	if request.MaxFilesPerGuest > 0 && files+1 > request.MaxFilesPerGuest {
+		return fmt.Errorf("%w: at most %d files per uploader", ErrFileRequestGuestLimit, request.MaxFilesPerGuest)
+	}
+	if request.MaxBytesPerGuest > 0 && bytes+size > request.MaxBytesPerGuest {
+		return fmt.Errorf("%w: at most %s per uploader", ErrFileRequestGuestLimit, utils.FormatFileSize(request.MaxBytesPerGuest))
+	}
+	return nil
+}
+
+// fileRequestUploader checks the guest's details against what the file
+// request requires
+func fileRequestUploader(request *models.FileRequest, form *models.FileRequestUploadForm, clientIP string) (*models.FileUploader, error) {
+	name := strings.TrimSpace(form.Name)
+	email := strings.ToLower(strings.TrimSpace(form.Email))
+
+	if len(name) > 100 {
+		return nil, fmt.Errorf("%w: name is too long", ErrFileRequestGuest)
+	}
+	if request.RequireName && name == "" {
+		return nil, fmt.Errorf("%w: name is required", ErrFileRequestGuest)
+	}
+	if request.RequireEmail && email == "" {
+		return nil, fmt.Errorf("%w: email is required", ErrFileRequestGuest)
+	}
+	if email != "" && !utils.IsValidEmail(email) {
+		return nil, fmt.Errorf("%w: email is invalid", ErrFileRequestGuest)
+	}
+
+	guestKey := "ip:" + clientIP
+	if email != "" {
+		guestKey = "email:" + email
+	}
+
+	return &models.FileUploader{
+		Name:          name,
+		Email:         email,
+		FileRequestID: request.ID,
+		GuestKey:      guestKey,
+	}, nil
+}
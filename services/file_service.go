@@ -3,25 +3,46 @@ package services
 
 import (
 	"context"
+	"crypto/md5"
 	"errors"
 	"fmt"
+	"io"
+	"mime"
 	"mime/multipart"
 	"net/http"
 	"oncloud/models"
 	"oncloud/utils"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type FileService struct {
 	*BaseService
-	storageService *StorageService
+	storageService ObjectStore
+	hotFileService *HotFileCacheService
+	featureFlags   *FeatureFlagService
+	usage          *UserUsageService
+	uploadRules    *UploadRuleService
+	typePolicies   *FileTypePolicyService
+	storageClasses *StorageClassService
+	virusScans     *VirusScanService
+	receipts       *DownloadReceiptService
+	downloads      *DownloadService
+	tags           *TagService
+	folderUsage    *FolderUsageService
+	metadata       *MetadataService
+	watermarks     *ShareWatermarkService
+	abuseReports   *AbuseReportService
 }
 
 type FileFilters struct {
@@ -30,6 +51,13 @@ type FileFilters struct {
 	FileType  string
 	SortBy    string
 	SortOrder string
+	Metadata  map[string]string // custom metadata conditions, see MetadataService.BuildFilter
+
+	// Media filters, matched against metadata extracted on upload
+	TakenFrom   *time.Time
+	TakenTo     *time.Time
+	Camera      string
+	HasLocation bool
 }
 
 type FileAdminFilters struct {
@@ -42,15 +70,37 @@ type FileAdminFilters struct {
 }
 
 func NewFileService() *FileService {
-	return &FileService{
-		BaseService:    NewBaseService(),
-		storageService: NewStorageService(),
-	}
+	return NewFileServiceWith(Dependencies{})
+}
+
+// NewFileServiceWith creates a file service on the given dependencies
+func NewFileServiceWith(deps Dependencies) *FileService {
+	fs := &FileService{
+		BaseService:    NewBaseServiceWith(deps),
+		storageService: deps.storage(),
+		hotFileService: NewHotFileCacheServiceWith(deps),
+		featureFlags:   NewFeatureFlagServiceWith(deps),
+		usage:          NewUserUsageServiceWith(deps),
+		uploadRules:    NewUploadRuleServiceWith(deps),
+		typePolicies:   NewFileTypePolicyServiceWith(deps),
+		virusScans:     NewVirusScanServiceWith(deps),
+		receipts:       NewDownloadReceiptServiceWith(deps),
+		downloads:      NewDownloadServiceWith(deps),
+		tags:           NewTagServiceWith(deps),
+		folderUsage:    NewFolderUsageServiceWith(deps),
+		metadata:       NewMetadataServiceWith(deps),
+		watermarks:     NewShareWatermarkServiceWith(deps),
+		abuseReports:   NewAbuseReportServiceWith(deps),
+	}
+	// The storage class service works on this one's files, rather than
+	// building another file service
+	fs.storageClasses = &StorageClassService{BaseService: fs.BaseService, fileService: fs}
+	return fs
 }
 
 // GetUserFiles returns paginated user files with filters
-func (fs *FileService) GetUserFiles(userID primitive.ObjectID, page, limit int, filters *FileFilters) ([]models.File, int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (fs *FileService) GetUserFiles(ctx context.Context, userID primitive.ObjectID, page, limit int, filters *FileFilters) ([]models.File, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	// Build filter query
@@ -95,6 +145,42 @@ func (fs *FileService) GetUserFiles(userID primitive.ObjectID, page, limit int,
 		}
 	}
 
+	var clauses []bson.M
+	if len(filters.Metadata) > 0 {
+		metadataClauses, err := fs.metadata.BuildFilter(ctx, userID, filters.Metadata)
+		if err != nil {
+			return nil, 0, err
+		}
+		clauses = append(clauses, metadataClauses...)
+	}
+
+	if filters.TakenFrom != nil || filters.TakenTo != nil {
+		taken := bson.M{}
+		if filters.TakenFrom != nil {
+			taken["$gte"] = *filters.TakenFrom
+		}
+		if filters.TakenTo != nil {
+			taken["$lt"] = *filters.TakenTo
+		}
+		filter["media.captured_at"] = taken
+	}
+
+	if filters.Camera != "" {
+		camera := primitive.Regex{Pattern: regexp.QuoteMeta(filters.Camera), Options: "i"}
+		clauses = append(clauses, bson.M{"$or": []bson.M{
+			{"media.camera_make": camera},
+			{"media.camera_model": camera},
+		}})
+	}
+
+	if filters.HasLocation {
+		filter["media.location"] = bson.M{"$exists": true}
+	}
+
+	if len(clauses) > 0 {
+		filter["$and"] = clauses
+	}
+
 	// Set sort options
 	sortField := "created_at"
 	if filters.SortBy != "" {
@@ -136,8 +222,8 @@ func (fs *FileService) GetUserFiles(userID primitive.ObjectID, page, limit int,
 }
 
 // GetUserFile returns a specific file for user
-func (fs *FileService) GetUserFile(userID, fileID primitive.ObjectID) (*models.File, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (fs *FileService) GetUserFile(ctx context.Context, userID, fileID primitive.ObjectID) (*models.File, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	var file models.File
@@ -147,19 +233,50 @@ func (fs *FileService) GetUserFile(userID, fileID primitive.ObjectID) (*models.F
 		"is_deleted": false,
 	}).Decode(&file)
 	if err != nil {
-		return nil, fmt.Errorf("file not found: %v", err)
+		return nil, findError(err, ErrFileNotFound)
 	}
 
 	return &file, nil
 }
 
-// UploadFile handles file upload
-func (fs *FileService) UploadFile(userID primitive.ObjectID, fileHeader *multipart.FileHeader, req *models.FileUploadRequest) (*models.File, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+// FileTypeMismatchError is returned when an upload's content is not what
+// its extension says and the upload is rejected for it
+type FileTypeMismatchError struct {
+	Extension string
+	Check     *models.FileTypeCheck
+}
+
+func (e *FileTypeMismatchError) Error() string {
+	if e.Check.Executable {
+		return fmt.Sprintf("file content is an executable (%s), not a %s file", e.Check.DetectedType, e.Extension)
+	}
+	return fmt.Sprintf("file content (%s) does not match its extension %s", e.Check.DetectedType, e.Extension)
+}
 
+// checkUploadType checks uploaded content against its extension, refusing
+// disguised executables, and mismatched content too when the runtime
+// setting says so. Other mismatches are logged and recorded on the file.
+// The file type policies over the user then apply to what the upload is
+// named as and what its content is.
+func (fs *FileService) checkUploadType(ctx context.Context, userID primitive.ObjectID, name, ext, mimeType string, content []byte) (*models.FileTypeCheck, error) {
+	check := utils.CheckFileType(ext, content)
+	if check.Status == models.TypeCheckMismatch {
+		if check.Executable || RuntimeSettingBool(RuntimeSettingRejectTypeMismatch) {
+			return nil, &FileTypeMismatchError{Extension: ext, Check: check}
+		}
+		fmt.Printf("Upload %q of user %s is %s, not what its extension says\n", name, userID.Hex(), check.DetectedType)
+	}
+
+	if err := fs.typePolicies.Check(ctx, userID, ext, mimeType, check); err != nil {
+		return nil, err
+	}
+	return check, nil
+}
+
+// UploadFile handles file upload
+func (fs *FileService) UploadFile(ctx context.Context, userID primitive.ObjectID, fileHeader *multipart.FileHeader, req *models.FileUploadRequest) (*models.File, error) {
 	// Get user's plan for validation
-	plan, err := fs.GetUserPlan(userID)
+	plan, err := fs.GetUserPlan(ctx, userID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user plan: %v", err)
 	}
@@ -195,18 +312,78 @@ func (fs *FileService) UploadFile(userID primitive.ObjectID, fileHeader *multipa
 		return nil, fmt.Errorf("failed to read file: %v", err)
 	}
 
-	// Get storage provider
-	provider, err := fs.getDefaultStorageProvider()
+	return fs.createFile(ctx, userID, fileInfo, fileContent, req, uploadConfig.GenerateThumbnail)
+}
+
+// UploadContent stores content that did not come from a multipart upload,
+// such as a file imported from another service, as a new file. Unlike
+// UploadFile it checks the user's storage limit itself.
+func (fs *FileService) UploadContent(ctx context.Context, userID primitive.ObjectID, fileName string, content []byte, req *models.FileUploadRequest) (*models.File, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	plan, err := fs.GetUserPlan(ctx, userID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get storage provider: %v", err)
+		return nil, fmt.Errorf("failed to get user plan: %v", err)
+	}
+
+	var user models.User
+	if err := fs.collections.Users().FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+		return nil, fmt.Errorf("user not found: %v", err)
+	}
+	if err := fs.CheckUploadLimits(&user, plan, int64(len(content))); err != nil {
+		return nil, err
 	}
 
-	// Upload to storage
-	err = fs.storageService.UploadFile(provider.Type, fileInfo.Path, fileContent)
+	uploadConfig := &utils.UploadConfig{
+		MaxFileSize:       plan.MaxFileSize,
+		AllowedTypes:      plan.AllowedTypes,
+		StorageProvider:   "default",
+		GenerateThumbnail: utils.IsImageFile(fileName),
+	}
+
+	fileInfo, err := utils.ProcessFileContent(fileName, content, uploadConfig)
 	if err != nil {
-		return nil, fmt.Errorf("failed to upload to storage: %v", err)
+		return nil, fmt.Errorf("failed to process file: %v", err)
+	}
+
+	return fs.createFile(ctx, userID, fileInfo, content, req, uploadConfig.GenerateThumbnail)
+}
+
+// createFile stores processed file content and creates its file record
+func (fs *FileService) createFile(ctx context.Context, userID primitive.ObjectID, fileInfo *utils.FileInfo, fileContent []byte, req *models.FileUploadRequest, generateThumbnail bool) (*models.File, error) {
+	if err := checkAccountWritable(ctx, fs.collections.Users(), userID); err != nil {
+		return nil, err
 	}
 
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	typeCheck, err := fs.checkUploadType(ctx, userID, fileInfo.OriginalName, fileInfo.Extension, fileInfo.MimeType, fileContent)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check for duplicates
+	if fs.featureFlags.IsEnabled(ctx, FeatureUploadDedup, userID) {
+		if duplicate, err := fs.findDuplicateFile(ctx, userID, fileInfo.Hash); err == nil && duplicate != nil {
+			return nil, fmt.Errorf("file already exists: %s", duplicate.Name)
+		}
+	}
+
+	// The user's upload rules may file it elsewhere
+	source := req.Source
+	if source == "" {
+		source = models.UploadSourceUpload
+	}
+	fs.uploadRules.Apply(ctx, userID, &UploadAttributes{
+		FileName: fileInfo.OriginalName,
+		MimeType: fileInfo.MimeType,
+		Size:     fileInfo.Size,
+		Source:   source,
+		FolderID: req.FolderID,
+	}, req)
+
 	// Handle folder
 	var folderObjID *primitive.ObjectID
 	if req.FolderID != "" && utils.IsValidObjectID(req.FolderID) {
@@ -214,57 +391,111 @@ func (fs *FileService) UploadFile(userID primitive.ObjectID, fileHeader *multipa
 		folderObjID = &fid
 
 		// Verify folder belongs to user
-		if err := fs.validateFolderOwnership(userID, fid); err != nil {
+		if err := fs.validateFolderOwnership(ctx, userID, fid); err != nil {
 			return nil, err
 		}
 	}
 
+	var (
+		provider     *models.StorageProvider
+		fileID       primitive.ObjectID
+		storageKey   string
+		storageClass string
+		compression  *models.FileCompression
+	)
+	if req.Stored != nil {
+		// Content uploaded straight to a bucket is recorded where it is
+		provider = &models.StorageProvider{Type: req.Stored.ProviderType, Bucket: req.Stored.Bucket}
+		fileID = req.Stored.FileID
+		storageKey = req.Stored.Key
+	} else {
+		// Get storage provider
+		provider, err = fs.getDefaultStorageProvider(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get storage provider: %v", err)
+		}
+
+		if req.StorageClass != "" {
+			if storageClass, err = validateStorageClass(provider.Type, req.StorageClass); err != nil {
+				return nil, err
+			}
+		}
+
+		// Store under the key the provider's template gives the file
+		fileID = primitive.NewObjectID()
+		storageKey, err = utils.RenderStorageKey(provider.KeyTemplate, utils.StorageKeyVars{
+			UserID: userID.Hex(),
+			FileID: fileID.Hex(),
+			Name:   fileInfo.Name,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to build storage key: %v", err)
+		}
+
+		// Upload to storage, compressed when compression at rest is on
+		var stored []byte
+		stored, compression = compressForStorage(fileInfo.MimeType, fileInfo.Extension, fileContent)
+		if storageClass != "" {
+			err = fs.storageClasses.UploadWithClass(ctx, provider.Type, storageKey, stored, storageClass)
+		} else {
+			err = fs.storageService.UploadFile(ctx, provider.Type, storageKey, stored)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to upload to storage: %w", err)
+		}
+	}
+
 	// Create file record
 	fileModel := &models.File{
-		ID:              primitive.NewObjectID(),
+		ID:              fileID,
 		UserID:          userID,
 		FolderID:        folderObjID,
 		Name:            fileInfo.Name,
 		OriginalName:    fileInfo.OriginalName,
 		DisplayName:     req.Name,
 		Description:     req.Description,
-		Path:            fileInfo.Path,
+		Path:            storageKey,
 		Size:            fileInfo.Size,
 		MimeType:        fileInfo.MimeType,
 		Extension:       fileInfo.Extension,
 		Hash:            fileInfo.Hash,
+		SHA256:          sha256Hex(fileContent),
 		StorageProvider: provider.Type,
-		StorageKey:      fileInfo.Path,
+		StorageKey:      storageKey,
 		StorageBucket:   provider.Bucket,
+		StorageClass:    storageClass,
+		Compression:     compression,
 		IsPublic:        req.IsPublic,
-		Tags:            req.Tags,
+		Tags:            NormalizeTags(req.Tags),
 		Metadata:        convertStringMapToInterface(req.Metadata),
-		CreatedAt:       time.Now(),
-		UpdatedAt:       time.Now(),
-	}
-
-	// Check for duplicates
-	if duplicate, err := fs.findDuplicateFile(userID, fileInfo.Hash); err == nil && duplicate != nil {
-		return nil, fmt.Errorf("file already exists: %s", duplicate.Name)
-	}
-
-	// Insert file record
-	_, err = fs.collections.Files().InsertOne(ctx, fileModel)
+		Media:           utils.ExtractMediaMetadata(fileContent),
+		Scan:            newPendingScan(),
+		TypeCheck:       typeCheck,
+		Uploader:        req.Uploader,
+		ContributorID:   req.Contributor,
+		CreatedAt:       fs.clock.Now(),
+		UpdatedAt:       fs.clock.Now(),
+	}
+
+	// Insert file record along with the user's usage
+	err = fs.usage.ApplyChange(ctx, userID, func(ctx context.Context) (int64, int, error) {
+		if _, err := fs.collections.Files().InsertOne(ctx, fileModel); err != nil {
+			return 0, 0, err
+		}
+		return fileModel.Size, 1, nil
+	})
 	if err != nil {
 		// Cleanup uploaded file on database error
-		fs.storageService.DeleteFile(provider.Type, fileInfo.Path)
+		fs.storageService.DeleteFile(ctx, provider.Type, storageKey)
 		return nil, fmt.Errorf("failed to save file record: %v", err)
 	}
 
-	// Update user storage usage
-	err = fs.updateUserStorageUsage(userID, fileInfo.Size, true)
-	if err != nil {
-		// Log error but don't fail the upload
-		fmt.Printf("Failed to update user storage usage: %v\n", err)
-	}
+	fs.refreshTags(ctx, fileModel)
+	fs.trackFolderUsage(ctx, fileModel, 1)
+	fs.virusScans.ScanContentAsync(fileModel, fileContent)
 
 	// Generate thumbnail if needed
-	if uploadConfig.GenerateThumbnail {
+	if generateThumbnail {
 		go fs.generateThumbnailAsync(fileModel)
 	}
 
@@ -275,25 +506,97 @@ func (fs *FileService) UploadFile(userID primitive.ObjectID, fileHeader *multipa
 func (fs *FileService) CheckUploadLimits(user *models.User, plan *models.Plan, fileSize int64) error {
 	// Check storage limit
 	if user.StorageUsed+fileSize > plan.StorageLimit {
-		return fmt.Errorf("upload would exceed storage limit of %s", utils.FormatFileSize(plan.StorageLimit))
+		return quotaExceededError("upload would exceed storage limit of %s", utils.FormatFileSize(plan.StorageLimit))
 	}
 
 	// Check file count limit
 	if plan.FilesLimit > 0 && user.FilesCount >= plan.FilesLimit {
-		return fmt.Errorf("file limit of %d reached", plan.FilesLimit)
+		return quotaExceededError("file limit of %d reached", plan.FilesLimit)
 	}
 
 	// Check file size limit
 	if fileSize > plan.MaxFileSize {
-		return fmt.Errorf("file size exceeds limit of %s", utils.FormatFileSize(plan.MaxFileSize))
+		return quotaExceededError("file size exceeds limit of %s", utils.FormatFileSize(plan.MaxFileSize))
 	}
 
 	return nil
 }
 
+// PreflightUpload runs the checks an upload of the described file would go
+// through, without the file's content, and reports every check it would fail
+func (fs *FileService) PreflightUpload(ctx context.Context, user *models.User, req *models.UploadPreflightRequest) (*models.UploadPreflightResult, error) {
+	plan, err := fs.GetUserPlan(ctx, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user plan: %v", err)
+	}
+
+	// The request size limit applies before the plan's limit is checked
+	maxFileSize := plan.MaxFileSize
+	if maxUploadSize := RuntimeSettingInt64(RuntimeSettingMaxUploadSize); maxUploadSize > 0 && maxUploadSize < maxFileSize {
+		maxFileSize = maxUploadSize
+	}
+
+	result := &models.UploadPreflightResult{
+		MaxFileSize: maxFileSize,
+		StorageLeft: plan.StorageLimit - user.StorageUsed,
+		Issues:      []models.PreflightIssue{},
+	}
+	if result.StorageLeft < 0 {
+		result.StorageLeft = 0
+	}
+	addIssue := func(code, format string, args ...interface{}) {
+		result.Issues = append(result.Issues, models.PreflightIssue{Code: code, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if user.StorageUsed+req.Size > plan.StorageLimit {
+		addIssue(models.PreflightStorageLimit, "upload would exceed storage limit of %s", utils.FormatFileSize(plan.StorageLimit))
+	}
+	if plan.FilesLimit > 0 && user.FilesCount >= plan.FilesLimit {
+		addIssue(models.PreflightFilesLimit, "file limit of %d reached", plan.FilesLimit)
+	}
+	if req.Size > maxFileSize {
+		addIssue(models.PreflightMaxFileSize, "file size exceeds limit of %s", utils.FormatFileSize(maxFileSize))
+	}
+
+	// Files are typed by extension, whatever MIME type the client reports
+	ext := strings.ToLower(filepath.Ext(req.FileName))
+	if ext == "" {
+		addIssue(models.PreflightFileType, "file must have an extension")
+	} else if len(plan.AllowedTypes) > 0 && !utils.SliceContains(plan.AllowedTypes, ext) {
+		addIssue(models.PreflightFileType, "file type %s not allowed", ext)
+	}
+	result.MimeType = mime.TypeByExtension(ext)
+	if result.MimeType == "" {
+		result.MimeType = "application/octet-stream"
+	}
+	if ext != "" {
+		var blockedErr *FileTypeBlockedError
+		if err := fs.typePolicies.Check(ctx, user.ID, ext, result.MimeType, nil); errors.As(err, &blockedErr) {
+			addIssue(models.PreflightFileType, "%s", blockedErr.Error())
+		}
+	}
+
+	if req.FolderID != "" {
+		folderID, _ := utils.StringToObjectID(req.FolderID)
+		if err := fs.validateFolderOwnership(ctx, user.ID, folderID); err != nil {
+			addIssue(models.PreflightFolder, "folder not found or access denied")
+		}
+	}
+
+	if req.Hash != "" && fs.featureFlags.IsEnabled(ctx, FeatureUploadDedup, user.ID) {
+		if duplicate, err := fs.findDuplicateFile(ctx, user.ID, strings.ToLower(req.Hash)); err == nil && duplicate != nil {
+			addIssue(models.PreflightDuplicate, "file already exists: %s", duplicate.Name)
+			result.DuplicateFile = &models.PreflightFileRef{ID: duplicate.ID.Hex(), Name: duplicate.Name}
+		}
+	}
+
+	result.Allowed = len(result.Issues) == 0
+	return result, nil
+}
+
 // GetUserPlan gets user's plan
-func (fs *FileService) GetUserPlan(userID primitive.ObjectID) (*models.Plan, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (fs *FileService) GetUserPlan(ctx context.Context, userID primitive.ObjectID) (*models.Plan, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	// Get user
@@ -314,9 +617,10 @@ func (fs *FileService) GetUserPlan(userID primitive.ObjectID) (*models.Plan, err
 }
 
 // UploadChunk handles chunked upload
-func (fs *FileService) UploadChunk(userID primitive.ObjectID, uploadID string, chunkNumber, totalChunks int, chunk *multipart.FileHeader) (map[string]interface{}, error) {
-	// Store chunk temporarily
-	fmt.Sprintf("chunks/%s/%d", uploadID, chunkNumber)
+func (fs *FileService) UploadChunk(ctx context.Context, userID primitive.ObjectID, uploadID string, chunkNumber, totalChunks int, chunk *multipart.FileHeader) (map[string]interface{}, error) {
+	if err := checkAccountWritable(ctx, fs.collections.Users(), userID); err != nil {
+		return nil, err
+	}
 
 	// Read chunk content
 	file, err := chunk.Open()
@@ -332,9 +636,9 @@ func (fs *FileService) UploadChunk(userID primitive.ObjectID, uploadID string, c
 	}
 
 	// Store chunk (implement temporary storage)
-	err = fs.storeChunk(uploadID, chunkNumber, chunkContent)
+	err = fs.storeChunk(userID, uploadID, chunkNumber, chunkContent)
 	if err != nil {
-		return nil, fmt.Errorf("failed to store chunk: %v", err)
+		return nil, fmt.Errorf("failed to store chunk: %w", err)
 	}
 
 	result := map[string]interface{}{
@@ -342,7 +646,7 @@ func (fs *FileService) UploadChunk(userID primitive.ObjectID, uploadID string, c
 		"chunk_number": chunkNumber,
 		"total_chunks": totalChunks,
 		"chunk_size":   chunk.Size,
-		"uploaded_at":  time.Now(),
+		"uploaded_at":  fs.clock.Now(),
 	}
 
 	return result, nil
@@ -351,7 +655,7 @@ func (fs *FileService) UploadChunk(userID primitive.ObjectID, uploadID string, c
 // CompleteChunkUpload assembles chunks into final file
 func (fs *FileService) CompleteChunkUpload(userID primitive.ObjectID, uploadID, fileName, folderID string) (*models.File, error) {
 	// Assemble chunks into final file
-	finalContent, err := fs.assembleChunks(uploadID)
+	finalContent, err := fs.assembleChunks(userID, uploadID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to assemble chunks: %v", err)
 	}
@@ -377,26 +681,40 @@ func (fs *FileService) CompleteChunkUpload(userID primitive.ObjectID, uploadID,
 	// Implementation would create the file record and upload to storage
 
 	// Cleanup chunks
-	go fs.cleanupChunks(uploadID)
+	go fs.cleanupChunks(userID, uploadID)
 
 	return file, nil
 }
 
 // UpdateFile updates file metadata
-func (fs *FileService) UpdateFile(userID, fileID primitive.ObjectID, req interface{}) (*models.File, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (fs *FileService) UpdateFile(ctx context.Context, userID, fileID primitive.ObjectID, req *models.FileUpdateRequest) (*models.File, error) {
+	if err := checkAccountWritable(ctx, fs.collections.Users(), userID); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	// Verify file ownership
-	_, err := fs.GetUserFile(userID, fileID)
+	file, err := fs.GetUserFile(ctx, userID, fileID)
 	if err != nil {
 		return nil, err
 	}
+	if err := checkFileLock(file, userID); err != nil {
+		return nil, err
+	}
 
-	// Update fields based on request type
-	updates := bson.M{"updated_at": time.Now()}
-
-	// Implementation would handle different update request types
+	updates := bson.M{"updated_at": fs.clock.Now()}
+	if req.Name != nil {
+		updates["name"] = *req.Name
+		updates["display_name"] = *req.Name
+	}
+	if req.Description != nil {
+		updates["description"] = *req.Description
+	}
+	if req.Tags != nil {
+		updates["tags"] = req.Tags
+	}
 
 	_, err = fs.collections.Files().UpdateOne(ctx,
 		bson.M{"_id": fileID, "user_id": userID},
@@ -406,83 +724,211 @@ func (fs *FileService) UpdateFile(userID, fileID primitive.ObjectID, req interfa
 		return nil, fmt.Errorf("failed to update file: %v", err)
 	}
 
-	return fs.GetUserFile(userID, fileID)
+	return fs.GetUserFile(ctx, userID, fileID)
 }
 
 // DeleteFile handles file deletion (soft or hard)
-func (fs *FileService) DeleteFile(userID, fileID primitive.ObjectID, permanent bool) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (fs *FileService) DeleteFile(ctx context.Context, userID, fileID primitive.ObjectID, permanent bool) error {
+	if err := checkAccountWritable(ctx, fs.collections.Users(), userID); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	// Get file
-	file, err := fs.GetUserFile(userID, fileID)
+	file, err := fs.GetUserFile(ctx, userID, fileID)
 	if err != nil {
 		return err
 	}
+	if err := checkFileLock(file, userID); err != nil {
+		return err
+	}
 
 	if permanent {
 		// Hard delete - remove from storage and database
-		err = fs.storageService.DeleteFile(file.StorageProvider, file.StorageKey)
+		err = fs.storageService.DeleteFile(ctx, file.StorageProvider, file.StorageKey)
 		if err != nil {
 			return fmt.Errorf("failed to delete from storage: %v", err)
 		}
 
-		_, err = fs.collections.Files().DeleteOne(ctx, bson.M{"_id": fileID})
+		err = fs.deleteFileRecord(ctx, file)
 		if err != nil {
 			return fmt.Errorf("failed to delete file record: %v", err)
 		}
 
-		// Update user storage usage
-		fs.updateUserStorageUsage(userID, -file.Size, false)
+		fs.refreshTags(ctx, file)
+		fs.trackFolderUsage(ctx, file, -1)
 	} else {
 		// Soft delete - mark as deleted
-		_, err = fs.collections.Files().UpdateOne(ctx,
-			bson.M{"_id": fileID, "user_id": userID},
-			bson.M{"$set": bson.M{
-				"is_deleted": true,
-				"deleted_at": time.Now(),
-				"updated_at": time.Now(),
-			}},
-		)
+		err = fs.usage.ApplyChange(ctx, userID, func(ctx context.Context) (int64, int, error) {
+			result, err := fs.collections.Files().UpdateOne(ctx,
+				bson.M{"_id": fileID, "user_id": userID, "is_deleted": false},
+				bson.M{"$set": bson.M{
+					"is_deleted": true,
+					"deleted_at": fs.clock.Now(),
+					"updated_at": fs.clock.Now(),
+				}},
+			)
+			if err != nil {
+				return 0, 0, err
+			}
+			return 0, -int(result.ModifiedCount), nil
+		})
 		if err != nil {
 			return fmt.Errorf("failed to mark file as deleted: %v", err)
 		}
+		fs.refreshTags(ctx, file)
+		fs.trackFolderUsage(ctx, file, -1)
 	}
 
 	return nil
 }
 
-// RestoreFile restores a soft-deleted file
-func (fs *FileService) RestoreFile(userID, fileID primitive.ObjectID) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// RestoreFile restores a soft-deleted file into the folder it was deleted
+// from, or the one chosen in req. Without a choice a file whose folder is gone
+// or in the trash goes back to the root. It is renamed when a file of the
+// same name is already there.
+func (fs *FileService) RestoreFile(ctx context.Context, userID, fileID primitive.ObjectID, req *models.RestoreRequest) (*models.File, error) {
+	if err := checkAccountWritable(ctx, fs.collections.Users(), userID); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
-	_, err := fs.collections.Files().UpdateOne(ctx,
-		bson.M{"_id": fileID, "user_id": userID, "is_deleted": true},
-		bson.M{
-			"$set": bson.M{
-				"is_deleted": false,
-				"updated_at": time.Now(),
-			},
-			"$unset": bson.M{"deleted_at": ""},
-		},
+	var file models.File
+	err := fs.collections.Files().FindOne(ctx, bson.M{"_id": fileID, "user_id": userID, "is_deleted": true}).Decode(&file)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotInTrash
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore file: %v", err)
+	}
+
+	folderID, err := restoreTarget(ctx, fs.collections.Folders(), userID, file.FolderID, req)
+	if err != nil {
+		return nil, err
+	}
+
+	siblings := bson.M{"user_id": userID, "is_deleted": false, "folder_id": bson.M{"$exists": false}}
+	if folderID != nil {
+		siblings["folder_id"] = *folderID
+	}
+	name, err := freeRestoreName(ctx, fs.collections.Files(), siblings, file.Name, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore file: %v", err)
+	}
+
+	set := bson.M{"is_deleted": false, "name": name, "updated_at": fs.clock.Now()}
+	unset := bson.M{"deleted_at": "", "deletion_id": ""}
+	// A file trashed when it expired comes back without its expiry
+	if checkFileExpiry(&file) != nil {
+		unset["expires_at"] = ""
+		unset["expiry_warned_at"] = ""
+	}
+	if name != file.Name {
+		set["display_name"] = name
+	}
+	if folderID != nil {
+		set["folder_id"] = *folderID
+	} else {
+		unset["folder_id"] = ""
+	}
+
+	err = fs.usage.ApplyChange(ctx, userID, func(ctx context.Context) (int64, int, error) {
+		err := fs.collections.Files().FindOneAndUpdate(ctx,
+			bson.M{"_id": fileID, "user_id": userID, "is_deleted": true},
+			bson.M{"$set": set, "$unset": unset},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&file)
+		if err != nil {
+			return 0, 0, err
+		}
+		return 0, 1, nil
+	})
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotInTrash
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore file: %v", err)
+	}
+
+	fs.refreshTags(ctx, &file)
+	fs.trackFolderUsage(ctx, &file, 1)
+
+	return &file, nil
+}
+
+// GetDeletedFiles returns the files in the user's trash which were deleted on
+// their own, with where they were deleted from. Files deleted along with a
+// folder are restored with it.
+func (fs *FileService) GetDeletedFiles(ctx context.Context, userID primitive.ObjectID, page, limit int) ([]models.TrashedFile, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"user_id":     userID,
+		"is_deleted":  true,
+		"deletion_id": bson.M{"$exists": false},
+	}
+
+	cursor, err := fs.collections.Files().Find(ctx, filter,
+		options.Find().
+			SetSort(bson.M{"deleted_at": -1}).
+			SetSkip(int64((page-1)*limit)).
+			SetLimit(int64(limit)),
 	)
 	if err != nil {
-		return fmt.Errorf("failed to restore file: %v", err)
+		return nil, 0, err
+	}
+	var files []models.File
+	if err := cursor.All(ctx, &files); err != nil {
+		return nil, 0, err
 	}
 
-	return nil
+	total, err := fs.collections.Files().CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	parentIDs := make([]*primitive.ObjectID, len(files))
+	for i := range files {
+		parentIDs[i] = files[i].FolderID
+	}
+	parents, err := trashLocations(ctx, fs.collections.Folders(), userID, parentIDs)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	trashed := make([]models.TrashedFile, len(files))
+	for i, file := range files {
+		trashed[i] = models.TrashedFile{File: file, OriginalLocation: trashLocation(parents, file.FolderID)}
+	}
+
+	return trashed, int(total), nil
 }
 
 // GetDownloadURL generates download URL for file
-func (fs *FileService) GetDownloadURL(userID, fileID primitive.ObjectID) (string, error) {
-	file, err := fs.GetUserFile(userID, fileID)
+// GetDownloadURL returns a URL the owner downloads their file from, after
+// issuing a receipt for the download if the file asks for one
+func (fs *FileService) GetDownloadURL(ctx context.Context, userID, fileID primitive.ObjectID, downloader models.ReceiptDownloader) (string, error) {
+	file, err := fs.GetUserFile(ctx, userID, fileID)
 	if err != nil {
 		return "", err
 	}
+	if err := checkFileExpiry(file); err != nil {
+		return "", err
+	}
+	if err := checkFileArchived(file); err != nil {
+		return "", err
+	}
+	if _, err := fs.receipts.IssueReceipt(ctx, file, downloader); err != nil {
+		return "", err
+	}
 
 	// Generate presigned URL
-	url, err := fs.storageService.GetPresignedURL(file.StorageProvider, file.StorageKey, 1*time.Hour, file.StorageBucket)
+	url, err := fs.contentURL(ctx, file)
 	if err != nil {
 		return "", fmt.Errorf("failed to generate download URL: %v", err)
 	}
@@ -490,15 +936,36 @@ func (fs *FileService) GetDownloadURL(userID, fileID primitive.ObjectID) (string
 	return url, nil
 }
 
+// contentURL returns a URL file's content is downloaded from for an hour:
+// a presigned URL of its provider, or a URL the server serves it through
+// when it is stored compressed
+func (fs *FileService) contentURL(ctx context.Context, file *models.File) (string, error) {
+	if file.Compression != nil {
+		return fs.downloads.proxiedDownloadURL(ctx, file, 1*time.Hour)
+	}
+	return fs.storageService.GetPresignedURL(ctx, file.StorageProvider, file.StorageKey, 1*time.Hour, file.StorageBucket)
+}
+
 // StreamFile streams file content
-func (fs *FileService) StreamFile(userID, fileID primitive.ObjectID, w http.ResponseWriter, r *http.Request) error {
-	file, err := fs.GetUserFile(userID, fileID)
+func (fs *FileService) StreamFile(ctx context.Context, userID, fileID primitive.ObjectID, w http.ResponseWriter, r *http.Request) error {
+	file, err := fs.GetUserFile(ctx, userID, fileID)
 	if err != nil {
 		return err
 	}
+	if err := checkFileExpiry(file); err != nil {
+		return err
+	}
+	if err := checkFileArchived(file); err != nil {
+		return err
+	}
+
+	// Skip the transfer entirely when the client already has this version
+	if utils.CheckNotModified(w, r, utils.FileETag(file, ""), file.UpdatedAt) {
+		return nil
+	}
 
 	// Get file content from storage
-	content, err := fs.storageService.DownloadFile(file.StorageProvider, file.StorageKey)
+	content, err := fs.storageService.ReadFileContent(ctx, file)
 	if err != nil {
 		return fmt.Errorf("failed to get file content: %v", err)
 	}
@@ -506,7 +973,7 @@ func (fs *FileService) StreamFile(userID, fileID primitive.ObjectID, w http.Resp
 	// Set headers
 	w.Header().Set("Content-Type", file.MimeType)
 	w.Header().Set("Content-Length", fmt.Sprintf("%d", file.Size))
-	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"%s\"", file.OriginalName))
+	w.Header().Set("Content-Disposition", utils.ContentDisposition("inline", file.OriginalName))
 
 	// Write content
 	_, err = w.Write(content)
@@ -514,8 +981,8 @@ func (fs *FileService) StreamFile(userID, fileID primitive.ObjectID, w http.Resp
 }
 
 // IncrementDownloadCount increments file download counter
-func (fs *FileService) IncrementDownloadCount(fileID primitive.ObjectID) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (fs *FileService) IncrementDownloadCount(ctx context.Context, fileID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	_, err := fs.collections.Files().UpdateOne(ctx,
@@ -526,15 +993,25 @@ func (fs *FileService) IncrementDownloadCount(fileID primitive.ObjectID) error {
 }
 
 // File sharing methods
-func (fs *FileService) CreateShare(userID, fileID primitive.ObjectID, req *models.ShareRequest) (*models.FileShare, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (fs *FileService) CreateShare(ctx context.Context, userID, fileID primitive.ObjectID, req *models.ShareRequest) (*models.FileShare, error) {
+	if err := checkAccountWritable(ctx, fs.collections.Users(), userID); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	// Verify file ownership
-	_, err := fs.GetUserFile(userID, fileID)
+	file, err := fs.GetUserFile(ctx, userID, fileID)
 	if err != nil {
 		return nil, err
 	}
+	if err := checkTakedown(file.TakedownID); err != nil {
+		return nil, err
+	}
+	if err := checkFileExpiry(file); err != nil {
+		return nil, err
+	}
 
 	// Generate share token
 	shareToken, err := utils.GenerateSecureToken(32)
@@ -561,7 +1038,9 @@ func (fs *FileService) CreateShare(userID, fileID primitive.ObjectID, req *model
 		ExpiresAt:    req.ExpiresAt,
 		MaxDownloads: req.MaxDownloads,
 		IsActive:     true,
-		CreatedAt:    time.Now(),
+		CreatedAt:    fs.clock.Now(),
+		Watermark:    req.Watermark != nil && *req.Watermark,
+		ViewOnly:     req.ViewOnly != nil && *req.ViewOnly,
 	}
 
 	_, err = fs.collections.FileShares().InsertOne(ctx, share)
@@ -575,15 +1054,15 @@ func (fs *FileService) CreateShare(userID, fileID primitive.ObjectID, req *model
 		bson.M{"$set": bson.M{
 			"is_shared":   true,
 			"share_token": shareToken,
-			"updated_at":  time.Now(),
+			"updated_at":  fs.clock.Now(),
 		}},
 	)
 
 	return share, nil
 }
 
-func (fs *FileService) GetShare(userID, fileID primitive.ObjectID) (*models.FileShare, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (fs *FileService) GetShare(ctx context.Context, userID, fileID primitive.ObjectID) (*models.FileShare, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	var share models.FileShare
@@ -596,14 +1075,33 @@ func (fs *FileService) GetShare(userID, fileID primitive.ObjectID) (*models.File
 		return nil, fmt.Errorf("share not found: %v", err)
 	}
 
+	// Owners see whether the link serves the file or is held back by a scan
+	if file, err := fs.GetUserFile(ctx, userID, fileID); err == nil {
+		share.FileScan = file.Scan
+	}
+
 	return &share, nil
 }
 
-func (fs *FileService) UpdateShare(userID, fileID primitive.ObjectID, req *models.ShareRequest) (*models.FileShare, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// RescanFile asks for the owner's file to be scanned for viruses again, to
+// clear a failed scan or a false positive which blocks its share links
+func (fs *FileService) RescanFile(ctx context.Context, userID, fileID primitive.ObjectID) (*models.FileScan, error) {
+	file, err := fs.GetUserFile(ctx, userID, fileID)
+	if err != nil {
+		return nil, err
+	}
+	return fs.virusScans.Rescan(ctx, file, true)
+}
+
+func (fs *FileService) UpdateShare(ctx context.Context, userID, fileID primitive.ObjectID, req *models.ShareRequest) (*models.FileShare, error) {
+	if err := checkAccountWritable(ctx, fs.collections.Users(), userID); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	updates := bson.M{"updated_at": time.Now()}
+	updates := bson.M{"updated_at": fs.clock.Now()}
 
 	if req.ExpiresAt != nil {
 		updates["expires_at"] = req.ExpiresAt
@@ -611,6 +1109,12 @@ func (fs *FileService) UpdateShare(userID, fileID primitive.ObjectID, req *model
 	if req.MaxDownloads > 0 {
 		updates["max_downloads"] = req.MaxDownloads
 	}
+	if req.Watermark != nil {
+		updates["watermark"] = *req.Watermark
+	}
+	if req.ViewOnly != nil {
+		updates["view_only"] = *req.ViewOnly
+	}
 	if req.Password != "" {
 		hashedPassword, err := utils.HashPassword(req.Password)
 		if err != nil {
@@ -627,18 +1131,21 @@ func (fs *FileService) UpdateShare(userID, fileID primitive.ObjectID, req *model
 		return nil, fmt.Errorf("failed to update share: %v", err)
 	}
 
-	return fs.GetShare(userID, fileID)
+	return fs.GetShare(ctx, userID, fileID)
 }
 
-func (fs *FileService) DeleteShare(userID, fileID primitive.ObjectID) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (fs *FileService) DeleteShare(ctx context.Context, userID, fileID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
+	var share models.FileShare
+	shareFilter := bson.M{"file_id": fileID, "user_id": userID}
+	if err := fs.collections.FileShares().FindOne(ctx, shareFilter).Decode(&share); err == nil {
+		fs.watermarks.PurgeShare(ctx, share.ID)
+	}
+
 	// Delete share record
-	_, err := fs.collections.FileShares().DeleteOne(ctx, bson.M{
-		"file_id": fileID,
-		"user_id": userID,
-	})
+	_, err := fs.collections.FileShares().DeleteOne(ctx, shareFilter)
 	if err != nil {
 		return fmt.Errorf("failed to delete share: %v", err)
 	}
@@ -649,7 +1156,7 @@ func (fs *FileService) DeleteShare(userID, fileID primitive.ObjectID) error {
 		bson.M{
 			"$set": bson.M{
 				"is_shared":  false,
-				"updated_at": time.Now(),
+				"updated_at": fs.clock.Now(),
 			},
 			"$unset": bson.M{"share_token": ""},
 		},
@@ -657,29 +1164,26 @@ func (fs *FileService) DeleteShare(userID, fileID primitive.ObjectID) error {
 	return err
 }
 
-func (fs *FileService) GetShareURL(userID, fileID primitive.ObjectID) (string, error) {
-	share, err := fs.GetShare(userID, fileID)
+func (fs *FileService) GetShareURL(ctx context.Context, userID, fileID primitive.ObjectID) (string, error) {
+	share, err := fs.GetShare(ctx, userID, fileID)
 	if err != nil {
 		return "", err
 	}
 
-	// Generate share URL
-	baseURL := os.Getenv("BASE_URL")
-	if baseURL == "" {
-		baseURL = "http://localhost:8080"
-	}
-	shareURL := fmt.Sprintf("%s/shared/%s", baseURL, share.Token)
-
-	return shareURL, nil
+	return ShareLinkURL(ctx, share, ShareKindFile), nil
 }
 
 // File operations
-func (fs *FileService) CopyFile(userID, fileID primitive.ObjectID, destFolderID, newName string) (*models.File, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (fs *FileService) CopyFile(ctx context.Context, userID, fileID primitive.ObjectID, destFolderID, newName string) (*models.File, error) {
+	if err := checkAccountWritable(ctx, fs.collections.Users(), userID); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	// Get original file
-	originalFile, err := fs.GetUserFile(userID, fileID)
+	originalFile, err := fs.GetUserFile(ctx, userID, fileID)
 	if err != nil {
 		return nil, err
 	}
@@ -689,7 +1193,7 @@ func (fs *FileService) CopyFile(userID, fileID primitive.ObjectID, destFolderID,
 	if destFolderID != "" && utils.IsValidObjectID(destFolderID) {
 		fid, _ := utils.StringToObjectID(destFolderID)
 		destFolderObjID = &fid
-		if err := fs.validateFolderOwnership(userID, fid); err != nil {
+		if err := fs.validateFolderOwnership(ctx, userID, fid); err != nil {
 			return nil, err
 		}
 	}
@@ -700,15 +1204,23 @@ func (fs *FileService) CopyFile(userID, fileID primitive.ObjectID, destFolderID,
 	}
 
 	// Copy file content in storage
-	newStorageKey := fmt.Sprintf("users/%s/%s", userID.Hex(), newName)
-	err = fs.storageService.CopyFile(originalFile.StorageProvider, originalFile.StorageKey, newStorageKey, originalFile.StorageBucket)
+	newFileID := primitive.NewObjectID()
+	newStorageKey, err := fs.storageService.NewStorageKey(ctx, originalFile.StorageProvider, utils.StorageKeyVars{
+		UserID: userID.Hex(),
+		FileID: newFileID.Hex(),
+		Name:   utils.UniqueStorageName(newName, originalFile.Extension),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to build storage key: %v", err)
+	}
+	err = fs.storageService.CopyFile(ctx, originalFile.StorageProvider, originalFile.StorageKey, originalFile.StorageProvider, newStorageKey)
 	if err != nil {
 		return nil, fmt.Errorf("failed to copy file in storage: %v", err)
 	}
 
 	// Create new file record
 	newFile := &models.File{
-		ID:              primitive.NewObjectID(),
+		ID:              newFileID,
 		UserID:          userID,
 		FolderID:        destFolderObjID,
 		Name:            newName,
@@ -719,92 +1231,143 @@ func (fs *FileService) CopyFile(userID, fileID primitive.ObjectID, destFolderID,
 		Size:            originalFile.Size,
 		MimeType:        originalFile.MimeType,
 		Extension:       originalFile.Extension,
+		SHA256:          originalFile.SHA256,
 		StorageProvider: originalFile.StorageProvider,
 		StorageKey:      newStorageKey,
 		StorageBucket:   originalFile.StorageBucket,
+		Compression:     originalFile.Compression,
 		Tags:            originalFile.Tags,
 		Metadata:        originalFile.Metadata,
-		CreatedAt:       time.Now(),
-		UpdatedAt:       time.Now(),
+		CustomMetadata:  originalFile.CustomMetadata,
+		MetadataIndex:   originalFile.MetadataIndex,
+		Media:           originalFile.Media,
+		Scan:            originalFile.Scan,
+		CreatedAt:       fs.clock.Now(),
+		UpdatedAt:       fs.clock.Now(),
 	}
 
-	_, err = fs.collections.Files().InsertOne(ctx, newFile)
+	err = fs.usage.ApplyChange(ctx, userID, func(ctx context.Context) (int64, int, error) {
+		if _, err := fs.collections.Files().InsertOne(ctx, newFile); err != nil {
+			return 0, 0, err
+		}
+		return newFile.Size, 1, nil
+	})
 	if err != nil {
 		// Cleanup on error
-		fs.storageService.DeleteFile(originalFile.StorageProvider, newStorageKey)
+		fs.storageService.DeleteFile(ctx, originalFile.StorageProvider, newStorageKey)
 		return nil, fmt.Errorf("failed to create file record: %v", err)
 	}
 
-	// Update user storage usage
-	fs.updateUserStorageUsage(userID, originalFile.Size, true)
+	fs.refreshTags(ctx, newFile)
+	fs.trackFolderUsage(ctx, newFile, 1)
 
 	return newFile, nil
 }
 
-func (fs *FileService) MoveFile(userID, fileID primitive.ObjectID, destFolderID string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (fs *FileService) MoveFile(ctx context.Context, userID, fileID primitive.ObjectID, destFolderID string) error {
+	if err := checkAccountWritable(ctx, fs.collections.Users(), userID); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
+	file, err := fs.GetUserFile(ctx, userID, fileID)
+	if err != nil {
+		return err
+	}
+	if err := checkFileLock(file, userID); err != nil {
+		return err
+	}
+
 	// Validate destination folder
 	var destFolderObjID *primitive.ObjectID
 	if destFolderID != "" && utils.IsValidObjectID(destFolderID) {
 		fid, _ := utils.StringToObjectID(destFolderID)
 		destFolderObjID = &fid
-		if err := fs.validateFolderOwnership(userID, fid); err != nil {
+		if err := fs.validateFolderOwnership(ctx, userID, fid); err != nil {
 			return err
 		}
 	}
 
 	// Update file folder
-	updates := bson.M{"updated_at": time.Now()}
-	if destFolderObjID != nil {
-		updates["folder_id"] = *destFolderObjID
-	} else {
-		updates["$unset"] = bson.M{"folder_id": ""}
+	update := bson.M{"$set": bson.M{"folder_id": destFolderObjID, "updated_at": fs.clock.Now()}}
+	if destFolderObjID == nil {
+		update = bson.M{
+			"$set":   bson.M{"updated_at": fs.clock.Now()},
+			"$unset": bson.M{"folder_id": ""},
+		}
 	}
 
-	_, err := fs.collections.Files().UpdateOne(ctx,
+	_, err = fs.collections.Files().UpdateOne(ctx,
 		bson.M{"_id": fileID, "user_id": userID},
-		bson.M{"$set": updates},
+		update,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+
+	fs.trackFolderUsage(ctx, file, -1)
+	file.FolderID = destFolderObjID
+	fs.trackFolderUsage(ctx, file, 1)
+
+	return nil
 }
 
-func (fs *FileService) ToggleFavorite(userID, fileID primitive.ObjectID, isFavorite bool) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (fs *FileService) ToggleFavorite(ctx context.Context, userID, fileID primitive.ObjectID, isFavorite bool) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
+	// favorited_at orders the favorites list by when items were starred
+	now := fs.clock.Now()
+	update := bson.M{"$set": bson.M{"is_favorite": true, "favorited_at": now, "updated_at": now}}
+	if !isFavorite {
+		update = bson.M{
+			"$set":   bson.M{"is_favorite": false, "updated_at": now},
+			"$unset": bson.M{"favorited_at": ""},
+		}
+	}
+
 	_, err := fs.collections.Files().UpdateOne(ctx,
 		bson.M{"_id": fileID, "user_id": userID},
-		bson.M{"$set": bson.M{
-			"is_favorite": isFavorite,
-			"updated_at":  time.Now(),
-		}},
+		update,
 	)
 	return err
 }
 
-func (fs *FileService) UpdateTags(userID, fileID primitive.ObjectID, tags []string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (fs *FileService) UpdateTags(ctx context.Context, userID, fileID primitive.ObjectID, tags []string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
-	_, err := fs.collections.Files().UpdateOne(ctx,
+	file, err := fs.GetUserFile(ctx, userID, fileID)
+	if err != nil {
+		return err
+	}
+
+	tags = NormalizeTags(tags)
+	_, err = fs.collections.Files().UpdateOne(ctx,
 		bson.M{"_id": fileID, "user_id": userID},
 		bson.M{"$set": bson.M{
 			"tags":       tags,
-			"updated_at": time.Now(),
+			"updated_at": fs.clock.Now(),
 		}},
 	)
-	return err
+	if err != nil {
+		return err
+	}
+
+	// Recount both the removed and the added tags
+	fs.tags.RefreshTags(ctx, userID, append(file.Tags, tags...))
+	return nil
 }
 
 // File versions
-func (fs *FileService) GetFileVersions(userID, fileID primitive.ObjectID) ([]models.FileVersion, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (fs *FileService) GetFileVersions(ctx context.Context, userID, fileID primitive.ObjectID) ([]models.FileVersion, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	// Verify file ownership
-	_, err := fs.GetUserFile(userID, fileID)
+	_, err := fs.GetUserFile(ctx, userID, fileID)
 	if err != nil {
 		return nil, err
 	}
@@ -826,17 +1389,182 @@ func (fs *FileService) GetFileVersions(userID, fileID primitive.ObjectID) ([]mod
 	return versions, nil
 }
 
-func (fs *FileService) CreateFileVersion(userID, fileID primitive.ObjectID, fileHeader *multipart.FileHeader) (*models.FileVersion, error) {
-	// Implementation for creating file versions
-	return nil, errors.New("not implemented")
+func (fs *FileService) CreateFileVersion(ctx context.Context, userID, fileID primitive.ObjectID, fileHeader *multipart.FileHeader) (*models.FileVersion, error) {
+	if err := checkAccountWritable(ctx, fs.collections.Users(), userID); err != nil {
+		return nil, err
+	}
+
+	file, err := fs.GetUserFile(ctx, userID, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkFileLock(file, userID); err != nil {
+		return nil, err
+	}
+
+	src, err := fileHeader.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %v", err)
+	}
+	defer src.Close()
+
+	content, err := io.ReadAll(src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %v", err)
+	}
+
+	_, version, err := fs.SaveFileContent(ctx, file, content)
+	return version, err
 }
 
-func (fs *FileService) GetFileVersion(userID, fileID primitive.ObjectID, versionNumber int) (*models.FileVersion, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// SaveFileContent replaces a file's content. The previous content is kept in
+// storage and recorded as the next file version.
+func (fs *FileService) SaveFileContent(ctx context.Context, file *models.File, content []byte) (*models.File, *models.FileVersion, error) {
+	if err := checkAccountWritable(ctx, fs.collections.Users(), file.UserID); err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+
+	size := int64(len(content))
+
+	plan, err := fs.GetUserPlan(ctx, file.UserID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get user plan: %v", err)
+	}
+	var user models.User
+	if err := fs.collections.Users().FindOne(ctx, bson.M{"_id": file.UserID}).Decode(&user); err != nil {
+		return nil, nil, fmt.Errorf("user not found: %v", err)
+	}
+	if plan.MaxFileSize > 0 && size > plan.MaxFileSize {
+		return nil, nil, quotaExceededError("file size exceeds limit of %s", utils.FormatFileSize(plan.MaxFileSize))
+	}
+	if user.StorageUsed+size > plan.StorageLimit {
+		return nil, nil, quotaExceededError("saving would exceed storage limit of %s", utils.FormatFileSize(plan.StorageLimit))
+	}
+	typeCheck, err := fs.checkUploadType(ctx, file.UserID, file.OriginalName, file.Extension, file.MimeType, content)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	versionNumber := 1
+	var latest models.FileVersion
+	err = fs.collections.FileVersions().FindOne(ctx,
+		bson.M{"file_id": file.ID},
+		options.FindOne().SetSort(bson.M{"version_number": -1}),
+	).Decode(&latest)
+	if err == nil {
+		versionNumber = latest.VersionNumber + 1
+	} else if err != mongo.ErrNoDocuments {
+		return nil, nil, fmt.Errorf("failed to get file versions: %v", err)
+	}
+
+	now := fs.clock.Now()
+	storageKey, err := fs.storageService.NewStorageKey(ctx, file.StorageProvider, utils.StorageKeyVars{
+		UserID: file.UserID.Hex(),
+		FileID: file.ID.Hex(),
+		Name:   fmt.Sprintf("%s_v%d_%d%s", file.ID.Hex(), versionNumber+1, now.Unix(), file.Extension),
+		Time:   now,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to build storage key: %v", err)
+	}
+
+	stored, compression := compressForStorage(file.MimeType, file.Extension, content)
+	if err := fs.storageService.UploadFile(ctx, file.StorageProvider, storageKey, stored); err != nil {
+		return nil, nil, fmt.Errorf("failed to upload to storage: %w", err)
+	}
+
+	set := bson.M{
+		"storage_key": storageKey,
+		"path":        storageKey,
+		"size":        size,
+		"hash":        fmt.Sprintf("%x", md5.Sum(content)),
+		"sha256":      sha256Hex(content),
+		"type_check":  typeCheck,
+		"updated_at":  now,
+	}
+	if scan := newPendingScan(); scan != nil {
+		set["scan"] = scan
+	}
+	if file.Receipts != nil {
+		set["receipts.sha256"] = set["sha256"]
+		set["receipts.storage_key"] = storageKey
+	}
+	unset := bson.M{}
+	if media := utils.ExtractMediaMetadata(content); media != nil {
+		set["media"] = media
+	} else {
+		unset["media"] = ""
+	}
+	if compression != nil {
+		set["compression"] = compression
+	} else {
+		unset["compression"] = ""
+	}
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	version := &models.FileVersion{
+		ID:            primitive.NewObjectID(),
+		FileID:        file.ID,
+		VersionNumber: versionNumber,
+		Size:          file.Size,
+		StorageKey:    file.StorageKey,
+		Hash:          file.Hash,
+		Compression:   file.Compression,
+		CreatedAt:     now,
+	}
+
+	// Only swap the content in if nobody else saved since the file was read.
+	// The previous content is kept as a version, so usage grows by the new
+	// size.
+	errConcurrent := errors.New("file was modified concurrently, please retry")
+	swapped := false
+	err = fs.usage.ApplyChange(ctx, file.UserID, func(ctx context.Context) (int64, int, error) {
+		result, err := fs.collections.Files().UpdateOne(ctx,
+			bson.M{"_id": file.ID, "storage_key": file.StorageKey},
+			update,
+		)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to update file: %v", err)
+		}
+		if result.MatchedCount == 0 {
+			return 0, 0, errConcurrent
+		}
+		swapped = true
+		if _, err := fs.collections.FileVersions().InsertOne(ctx, version); err != nil {
+			return 0, 0, fmt.Errorf("failed to record file version: %v", err)
+		}
+		return size, 0, nil
+	})
+	if err != nil {
+		// New content the file may point to is left for the orphan collector
+		if !swapped {
+			fs.storageService.DeleteFile(ctx, file.StorageProvider, storageKey)
+		}
+		return nil, nil, err
+	}
+	fs.folderUsage.AddFileUsage(ctx, file.UserID, file.FolderID, size-file.Size, 0)
+
+	var updated models.File
+	if err := fs.collections.Files().FindOne(ctx, bson.M{"_id": file.ID}).Decode(&updated); err != nil {
+		return nil, nil, findError(err, ErrFileNotFound)
+	}
+	fs.virusScans.ScanContentAsync(&updated, content)
+
+	return &updated, version, nil
+}
+
+func (fs *FileService) GetFileVersion(ctx context.Context, userID, fileID primitive.ObjectID, versionNumber int) (*models.FileVersion, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	// Verify file ownership
-	_, err := fs.GetUserFile(userID, fileID)
+	_, err := fs.GetUserFile(ctx, userID, fileID)
 	if err != nil {
 		return nil, err
 	}
@@ -853,77 +1581,92 @@ func (fs *FileService) GetFileVersion(userID, fileID primitive.ObjectID, version
 	return &version, nil
 }
 
-func (fs *FileService) RestoreFileVersion(userID, fileID primitive.ObjectID, versionNumber int) error {
+func (fs *FileService) RestoreFileVersion(ctx context.Context, userID, fileID primitive.ObjectID, versionNumber int) error {
+	if err := checkAccountWritable(ctx, fs.collections.Users(), userID); err != nil {
+		return err
+	}
+
+	file, err := fs.GetUserFile(ctx, userID, fileID)
+	if err != nil {
+		return err
+	}
+	if err := checkFileLock(file, userID); err != nil {
+		return err
+	}
+
 	// Implementation for restoring file versions
 	return errors.New("not implemented")
 }
 
-func (fs *FileService) DeleteFileVersion(userID, fileID primitive.ObjectID, versionNumber int) error {
+func (fs *FileService) DeleteFileVersion(ctx context.Context, userID, fileID primitive.ObjectID, versionNumber int) error {
+	if err := checkAccountWritable(ctx, fs.collections.Users(), userID); err != nil {
+		return err
+	}
+
+	file, err := fs.GetUserFile(ctx, userID, fileID)
+	if err != nil {
+		return err
+	}
+	if err := checkFileLock(file, userID); err != nil {
+		return err
+	}
+
 	// Implementation for deleting file versions
 	return errors.New("not implemented")
 }
 
-// Bulk operations
-func (fs *FileService) BulkDeleteFiles(userID primitive.ObjectID, fileIDs []primitive.ObjectID) (map[string]interface{}, error) {
-	results := map[string]interface{}{
-		"success": 0,
-		"failed":  0,
-		"errors":  []string{},
-	}
+// BatchGetFiles fetches the user's files among fileIDs in one query. IDs of
+// files which do not exist, are in the trash or belong to someone else are
+// returned as missing.
+func (fs *FileService) BatchGetFiles(ctx context.Context, userID primitive.ObjectID, fileIDs []primitive.ObjectID) (*models.FileBatch, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
 
-	for _, fileID := range fileIDs {
-		err := fs.DeleteFile(userID, fileID, false)
-		if err != nil {
-			results["failed"] = results["failed"].(int) + 1
-			results["errors"] = append(results["errors"].([]string), err.Error())
-		} else {
-			results["success"] = results["success"].(int) + 1
-		}
+	fileIDs = uniqueObjectIDs(fileIDs)
+	cursor, err := fs.collections.Files().Find(ctx, bson.M{
+		"_id":        bson.M{"$in": fileIDs},
+		"user_id":    userID,
+		"is_deleted": false,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get files: %v", err)
 	}
+	defer cursor.Close(ctx)
 
-	return results, nil
-}
-
-func (fs *FileService) BulkMoveFiles(userID primitive.ObjectID, fileIDs []primitive.ObjectID, destFolderID string) (map[string]interface{}, error) {
-	results := map[string]interface{}{
-		"success": 0,
-		"failed":  0,
-		"errors":  []string{},
+	var files []models.File
+	if err := cursor.All(ctx, &files); err != nil {
+		return nil, fmt.Errorf("failed to get files: %v", err)
+	}
+	byID := make(map[primitive.ObjectID]models.File, len(files))
+	for _, file := range files {
+		byID[file.ID] = file
 	}
 
-	for _, fileID := range fileIDs {
-		err := fs.MoveFile(userID, fileID, destFolderID)
-		if err != nil {
-			results["failed"] = results["failed"].(int) + 1
-			results["errors"] = append(results["errors"].([]string), err.Error())
+	batch := &models.FileBatch{Found: []models.File{}, Missing: []string{}}
+	for _, id := range fileIDs {
+		if file, ok := byID[id]; ok {
+			batch.Found = append(batch.Found, file)
 		} else {
-			results["success"] = results["success"].(int) + 1
+			batch.Missing = append(batch.Missing, id.Hex())
 		}
 	}
-
-	return results, nil
+	return batch, nil
 }
 
-func (fs *FileService) BulkCopyFiles(userID primitive.ObjectID, fileIDs []primitive.ObjectID, destFolderID string) (map[string]interface{}, error) {
-	results := map[string]interface{}{
-		"success": 0,
-		"failed":  0,
-		"errors":  []string{},
-	}
-
-	for _, fileID := range fileIDs {
-		_, err := fs.CopyFile(userID, fileID, destFolderID, "")
-		if err != nil {
-			results["failed"] = results["failed"].(int) + 1
-			results["errors"] = append(results["errors"].([]string), err.Error())
-		} else {
-			results["success"] = results["success"].(int) + 1
+// uniqueObjectIDs drops repeated IDs, keeping the first of each
+func uniqueObjectIDs(ids []primitive.ObjectID) []primitive.ObjectID {
+	seen := make(map[primitive.ObjectID]bool, len(ids))
+	unique := make([]primitive.ObjectID, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
 		}
 	}
-
-	return results, nil
+	return unique
 }
 
+// Bulk operations
 func (fs *FileService) CreateBulkDownload(userID primitive.ObjectID, fileIDs []primitive.ObjectID) (string, error) {
 	// Create ZIP archive of files
 	zipToken, err := utils.GenerateSecureToken(32)
@@ -937,31 +1680,9 @@ func (fs *FileService) CreateBulkDownload(userID primitive.ObjectID, fileIDs []p
 	return downloadURL, nil
 }
 
-func (fs *FileService) BulkShareFiles(userID primitive.ObjectID, fileIDs []primitive.ObjectID, shareData *models.ShareRequest) (map[string]interface{}, error) {
-	results := map[string]interface{}{
-		"success": 0,
-		"failed":  0,
-		"errors":  []string{},
-		"shares":  []string{},
-	}
-
-	for _, fileID := range fileIDs {
-		share, err := fs.CreateShare(userID, fileID, shareData)
-		if err != nil {
-			results["failed"] = results["failed"].(int) + 1
-			results["errors"] = append(results["errors"].([]string), err.Error())
-		} else {
-			results["success"] = results["success"].(int) + 1
-			results["shares"] = append(results["shares"].([]string), share.Token)
-		}
-	}
-
-	return results, nil
-}
-
 // Public file access
-func (fs *FileService) GetPublicDownloadURL(token string) (string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (fs *FileService) GetPublicDownloadURL(ctx context.Context, token string, downloader models.ReceiptDownloader) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	var file models.File
@@ -973,9 +1694,27 @@ func (fs *FileService) GetPublicDownloadURL(token string) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("file not found or not public: %v", err)
 	}
+	if err := checkFileExpiry(&file); err != nil {
+		return "", err
+	}
+	if err := checkFileArchived(&file); err != nil {
+		return "", err
+	}
+	if err := checkFileScan(&file); err != nil {
+		return "", err
+	}
+	if _, err := fs.receipts.IssueReceipt(ctx, &file, downloader); err != nil {
+		return "", err
+	}
+
+	// Hot files are sent to the CDN they were warmed in
+	fs.hotFileService.RecordHit(file.ID)
+	if url := fs.hotFileService.EdgeURL(ctx, &file); url != "" {
+		return url, nil
+	}
 
 	// Generate download URL
-	url, err := fs.storageService.GetPresignedURL(file.StorageProvider, file.StorageKey, 1*time.Hour, file.StorageBucket)
+	url, err := fs.contentURL(ctx, &file)
 	if err != nil {
 		return "", fmt.Errorf("failed to generate download URL: %v", err)
 	}
@@ -983,8 +1722,10 @@ func (fs *FileService) GetPublicDownloadURL(token string) (string, error) {
 	return url, nil
 }
 
-func (fs *FileService) GetSharedDownloadURL(token string) (string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// GetSharedFile returns an active share and its file without counting a
+// download
+func (fs *FileService) GetSharedFile(ctx context.Context, token string) (*models.FileShare, *models.File, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	// Find share by token
@@ -994,17 +1735,17 @@ func (fs *FileService) GetSharedDownloadURL(token string) (string, error) {
 		"is_active": true,
 	}).Decode(&share)
 	if err != nil {
-		return "", fmt.Errorf("share not found: %v", err)
+		return nil, nil, fmt.Errorf("share not found: %v", err)
 	}
 
 	// Check expiration
-	if share.ExpiresAt != nil && share.ExpiresAt.Before(time.Now()) {
-		return "", errors.New("share has expired")
+	if share.ExpiresAt != nil && share.ExpiresAt.Before(fs.clock.Now()) {
+		return nil, nil, errors.New("share has expired")
 	}
 
 	// Check download limit
 	if share.MaxDownloads > 0 && share.Downloads >= share.MaxDownloads {
-		return "", errors.New("download limit reached")
+		return nil, nil, errors.New("download limit reached")
 	}
 
 	// Get file
@@ -1014,13 +1755,45 @@ func (fs *FileService) GetSharedDownloadURL(token string) (string, error) {
 		"is_deleted": false,
 	}).Decode(&file)
 	if err != nil {
-		return "", fmt.Errorf("file not found: %v", err)
+		return nil, nil, findError(err, ErrFileNotFound)
+	}
+	if err := checkFileExpiry(&file); err != nil {
+		return nil, nil, err
 	}
 
-	// Generate download URL
-	url, err := fs.storageService.GetPresignedURL(file.StorageProvider, file.StorageKey, 1*time.Hour, file.StorageBucket)
+	return &share, &file, nil
+}
+
+func (fs *FileService) GetSharedDownloadURL(ctx context.Context, token string, downloader models.ReceiptDownloader) (string, error) {
+	share, file, err := fs.GetSharedFile(ctx, token)
 	if err != nil {
-		return "", fmt.Errorf("failed to generate download URL: %v", err)
+		return "", err
+	}
+	if share.ViewOnly {
+		return "", ErrShareViewOnly
+	}
+	if err := checkFileScan(file); err != nil {
+		return "", err
+	}
+	if err := checkFileArchived(file); err != nil {
+		return "", err
+	}
+	downloader.ShareID = share.ID.Hex()
+	if _, err := fs.receipts.IssueReceipt(ctx, file, downloader); err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	// Hot files are sent to the CDN they were warmed in
+	fs.hotFileService.RecordHit(file.ID)
+	url := fs.hotFileService.EdgeURL(ctx, file)
+	if url == "" {
+		url, err = fs.contentURL(ctx, file)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate download URL: %v", err)
+		}
 	}
 
 	// Increment download count
@@ -1032,8 +1805,8 @@ func (fs *FileService) GetSharedDownloadURL(token string) (string, error) {
 	return url, nil
 }
 
-func (fs *FileService) VerifySharePassword(token, password string) (map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (fs *FileService) VerifySharePassword(ctx context.Context, token, password string) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	var share models.FileShare
@@ -1060,8 +1833,8 @@ func (fs *FileService) VerifySharePassword(token, password string) (map[string]i
 }
 
 // File preview and thumbnails
-func (fs *FileService) GeneratePreview(userID, fileID primitive.ObjectID) (string, error) {
-	_, err := fs.GetUserFile(userID, fileID)
+func (fs *FileService) GeneratePreview(ctx context.Context, userID, fileID primitive.ObjectID) (string, error) {
+	_, err := fs.GetUserFile(ctx, userID, fileID)
 	if err != nil {
 		return "", err
 	}
@@ -1071,8 +1844,8 @@ func (fs *FileService) GeneratePreview(userID, fileID primitive.ObjectID) (strin
 	return previewURL, nil
 }
 
-func (fs *FileService) GetThumbnail(userID, fileID primitive.ObjectID) (string, error) {
-	file, err := fs.GetUserFile(userID, fileID)
+func (fs *FileService) GetThumbnail(ctx context.Context, userID, fileID primitive.ObjectID) (string, error) {
+	file, err := fs.GetUserFile(ctx, userID, fileID)
 	if err != nil {
 		return "", err
 	}
@@ -1084,8 +1857,8 @@ func (fs *FileService) GetThumbnail(userID, fileID primitive.ObjectID) (string,
 	return "", errors.New("thumbnail not available")
 }
 
-func (fs *FileService) GenerateThumbnail(userID, fileID primitive.ObjectID) (string, error) {
-	file, err := fs.GetUserFile(userID, fileID)
+func (fs *FileService) GenerateThumbnail(ctx context.Context, userID, fileID primitive.ObjectID) (string, error) {
+	file, err := fs.GetUserFile(ctx, userID, fileID)
 	if err != nil {
 		return "", err
 	}
@@ -1099,7 +1872,7 @@ func (fs *FileService) GenerateThumbnail(userID, fileID primitive.ObjectID) (str
 	thumbnailURL := fmt.Sprintf("/thumbnails/%s_thumb.jpg", fileID.Hex())
 
 	// Update file record with thumbnail URL
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	fs.collections.Files().UpdateOne(ctx,
@@ -1111,8 +1884,8 @@ func (fs *FileService) GenerateThumbnail(userID, fileID primitive.ObjectID) (str
 }
 
 // Admin methods
-func (fs *FileService) GetFilesForAdmin(page, limit int, filters *FileAdminFilters) ([]models.File, int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (fs *FileService) GetFilesForAdmin(ctx context.Context, page, limit int, filters *FileAdminFilters) ([]models.File, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	// Build filter
@@ -1138,6 +1911,8 @@ func (fs *FileService) GetFilesForAdmin(page, limit int, filters *FileAdminFilte
 			filter["is_deleted"] = true
 		case "reported":
 			filter["is_reported"] = true
+		case "mismatched":
+			filter["type_check.status"] = models.TypeCheckMismatch
 		}
 	}
 
@@ -1182,21 +1957,21 @@ func (fs *FileService) GetFilesForAdmin(page, limit int, filters *FileAdminFilte
 	return files, int(total), nil
 }
 
-func (fs *FileService) GetFileForAdmin(fileID primitive.ObjectID) (*models.File, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (fs *FileService) GetFileForAdmin(ctx context.Context, fileID primitive.ObjectID) (*models.File, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	var file models.File
 	err := fs.collections.Files().FindOne(ctx, bson.M{"_id": fileID}).Decode(&file)
 	if err != nil {
-		return nil, fmt.Errorf("file not found: %v", err)
+		return nil, findError(err, ErrFileNotFound)
 	}
 
 	return &file, nil
 }
 
-func (fs *FileService) DeleteFileByAdmin(fileID primitive.ObjectID, reason string, permanent bool) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (fs *FileService) DeleteFileByAdmin(ctx context.Context, fileID primitive.ObjectID, reason string, permanent bool) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	if permanent {
@@ -1204,57 +1979,110 @@ func (fs *FileService) DeleteFileByAdmin(fileID primitive.ObjectID, reason strin
 		var file models.File
 		err := fs.collections.Files().FindOne(ctx, bson.M{"_id": fileID}).Decode(&file)
 		if err != nil {
-			return fmt.Errorf("file not found: %v", err)
+			return findError(err, ErrFileNotFound)
 		}
 
 		// Delete from storage
-		fs.storageService.DeleteFile(file.StorageProvider, file.StorageKey)
+		fs.storageService.DeleteFile(ctx, file.StorageProvider, file.StorageKey)
 
 		// Delete from database
-		_, err = fs.collections.Files().DeleteOne(ctx, bson.M{"_id": fileID})
+		err = fs.deleteFileRecord(ctx, &file)
+		if err == nil {
+			fs.refreshTags(ctx, &file)
+			if !file.IsDeleted {
+				fs.trackFolderUsage(ctx, &file, -1)
+			}
+		}
 		return err
 	} else {
 		// Soft delete
-		_, err := fs.collections.Files().UpdateOne(ctx,
-			bson.M{"_id": fileID},
-			bson.M{"$set": bson.M{
-				"is_deleted":       true,
-				"deleted_at":       time.Now(),
-				"deletion_reason":  reason,
-				"deleted_by_admin": true,
-			}},
-		)
+		var file models.File
+		err := fs.collections.Files().FindOne(ctx, bson.M{"_id": fileID}).Decode(&file)
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		err = fs.usage.ApplyChange(ctx, file.UserID, func(ctx context.Context) (int64, int, error) {
+			err := fs.collections.Files().FindOneAndUpdate(ctx,
+				bson.M{"_id": fileID},
+				bson.M{"$set": bson.M{
+					"is_deleted":       true,
+					"deleted_at":       fs.clock.Now(),
+					"deletion_reason":  reason,
+					"deleted_by_admin": true,
+				}},
+			).Decode(&file)
+			if err != nil || file.IsDeleted {
+				return 0, 0, err
+			}
+			return 0, -1, nil
+		})
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+		if err == nil {
+			fs.refreshTags(ctx, &file)
+			if !file.IsDeleted {
+				fs.trackFolderUsage(ctx, &file, -1)
+			}
+		}
 		return err
 	}
 }
 
-func (fs *FileService) RestoreFileByAdmin(fileID primitive.ObjectID) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (fs *FileService) RestoreFileByAdmin(ctx context.Context, fileID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
-	_, err := fs.collections.Files().UpdateOne(ctx,
-		bson.M{"_id": fileID},
-		bson.M{
-			"$set": bson.M{"is_deleted": false},
-			"$unset": bson.M{
-				"deleted_at":       "",
-				"deletion_reason":  "",
-				"deleted_by_admin": "",
+	var file models.File
+	err := fs.collections.Files().FindOne(ctx, bson.M{"_id": fileID}).Decode(&file)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	err = fs.usage.ApplyChange(ctx, file.UserID, func(ctx context.Context) (int64, int, error) {
+		err := fs.collections.Files().FindOneAndUpdate(ctx,
+			bson.M{"_id": fileID},
+			bson.M{
+				"$set": bson.M{"is_deleted": false},
+				"$unset": bson.M{
+					"deleted_at":       "",
+					"deletion_id":      "",
+					"deletion_reason":  "",
+					"deleted_by_admin": "",
+				},
 			},
-		},
-	)
+		).Decode(&file)
+		if err != nil || !file.IsDeleted {
+			return 0, 0, err
+		}
+		return 0, 1, nil
+	})
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err == nil {
+		fs.refreshTags(ctx, &file)
+		if file.IsDeleted {
+			fs.trackFolderUsage(ctx, &file, 1)
+		}
+	}
 	return err
 }
 
-func (fs *FileService) ModerateFile(fileID primitive.ObjectID, action, reason, notes string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (fs *FileService) ModerateFile(ctx context.Context, fileID primitive.ObjectID, action, reason, notes string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	updates := bson.M{
 		"moderation_action": action,
 		"moderation_reason": reason,
 		"moderation_notes":  notes,
-		"moderated_at":      time.Now(),
+		"moderated_at":      fs.clock.Now(),
 	}
 
 	switch action {
@@ -1275,19 +2103,31 @@ func (fs *FileService) ModerateFile(fileID primitive.ObjectID, action, reason, n
 	return err
 }
 
-func (fs *FileService) GetReportedFiles(status string, page, limit int) ([]map[string]interface{}, int, error) {
-	// Implementation for getting reported files
-	return []map[string]interface{}{}, 0, nil
+// GetReportedFiles returns the files reported through their share links,
+// grouping the reports with the given status by file
+func (fs *FileService) GetReportedFiles(ctx context.Context, status string, page, limit int) ([]map[string]interface{}, int, error) {
+	return fs.abuseReports.GetReportedFiles(ctx, status, page, limit)
 }
 
-func (fs *FileService) ScanFile(fileID primitive.ObjectID, scanType string, force bool) (map[string]interface{}, error) {
-	// Implementation for file scanning (virus, malware, content)
+// ScanFile scans a file for viruses. ClamAV checks for viruses and malware
+// alike, so every scan type runs the same scan.
+func (fs *FileService) ScanFile(ctx context.Context, fileID primitive.ObjectID, scanType string, force bool) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var file models.File
+	if err := fs.collections.Files().FindOne(ctx, bson.M{"_id": fileID}).Decode(&file); err != nil {
+		return nil, findError(err, ErrFileNotFound)
+	}
+
+	scan, err := fs.virusScans.Rescan(ctx, &file, force)
+	if err != nil && !errors.Is(err, ErrVirusScanRunning) {
+		return nil, err
+	}
 	return map[string]interface{}{
-		"scan_id":    primitive.NewObjectID(),
-		"file_id":    fileID,
-		"scan_type":  scanType,
-		"status":     "initiated",
-		"started_at": time.Now(),
+		"file_id":   fileID,
+		"scan_type": scanType,
+		"scan":      scan,
 	}, nil
 }
 
@@ -1295,7 +2135,7 @@ func (fs *FileService) ScanFile(fileID primitive.ObjectID, scanType string, forc
 func (fs *FileService) validateFileUpload(header *multipart.FileHeader, plan *models.Plan) error {
 	// Check file size
 	if header.Size > plan.MaxFileSize {
-		return fmt.Errorf("file size exceeds limit of %s", utils.FormatFileSize(plan.MaxFileSize))
+		return quotaExceededError("file size exceeds limit of %s", utils.FormatFileSize(plan.MaxFileSize))
 	}
 
 	// Check file type if restricted
@@ -1309,8 +2149,8 @@ func (fs *FileService) validateFileUpload(header *multipart.FileHeader, plan *mo
 	return nil
 }
 
-func (fs *FileService) validateFolderOwnership(userID, folderID primitive.ObjectID) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (fs *FileService) validateFolderOwnership(ctx context.Context, userID, folderID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	var folder models.Folder
@@ -1325,8 +2165,8 @@ func (fs *FileService) validateFolderOwnership(userID, folderID primitive.Object
 	return nil
 }
 
-func (fs *FileService) findDuplicateFile(userID primitive.ObjectID, hash string) (*models.File, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (fs *FileService) findDuplicateFile(ctx context.Context, userID primitive.ObjectID, hash string) (*models.File, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	var file models.File
@@ -1342,35 +2182,60 @@ func (fs *FileService) findDuplicateFile(userID primitive.ObjectID, hash string)
 	return &file, nil
 }
 
-func (fs *FileService) updateUserStorageUsage(userID primitive.ObjectID, sizeChange int64, increment bool) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	update := bson.M{}
-	if increment {
-		update["$inc"] = bson.M{
-			"storage_used": sizeChange,
-			"files_count":  1,
+// deleteFileRecord removes a file's record, taking the file and the older
+// versions kept of it off the owner's usage
+func (fs *FileService) deleteFileRecord(ctx context.Context, file *models.File) error {
+	usage := fs.usage
+	return usage.ApplyChange(ctx, file.UserID, func(ctx context.Context) (int64, int, error) {
+		result, err := fs.collections.Files().DeleteOne(ctx, bson.M{"_id": file.ID})
+		if err != nil || result.DeletedCount == 0 {
+			return 0, 0, err
 		}
-	} else {
-		update["$inc"] = bson.M{
-			"storage_used": -sizeChange,
-			"files_count":  -1,
+		versions, err := usage.versionBytes(ctx, []primitive.ObjectID{file.ID})
+		if err != nil {
+			return 0, 0, err
+		}
+		files := 0
+		if !file.IsDeleted {
+			files = -1
 		}
+		return -(file.Size + versions), files, nil
+	})
+}
+
+// refreshTags recounts a file's tags after it is created, trashed, restored
+// or deleted
+func (fs *FileService) refreshTags(ctx context.Context, file *models.File) {
+	if len(file.Tags) > 0 {
+		fs.tags.RefreshTags(ctx, file.UserID, file.Tags)
 	}
+}
 
-	_, err := fs.collections.Users().UpdateOne(ctx,
-		bson.M{"_id": userID},
-		update,
-	)
-	return err
+// trackFolderUsage counts a file into (sign 1) or out of (sign -1) the size
+// of its folder and the folder's ancestors
+func (fs *FileService) trackFolderUsage(ctx context.Context, file *models.File, sign int) {
+	fs.folderUsage.AddFileUsage(ctx, file.UserID, file.FolderID, int64(sign)*file.Size, sign)
 }
 
-func (fs *FileService) getDefaultStorageProvider() (*models.StorageProvider, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (fs *FileService) getDefaultStorageProvider(ctx context.Context) (*models.StorageProvider, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	var provider models.StorageProvider
+
+	// An admin choosing a provider type at runtime takes precedence over the
+	// provider marked as default
+	if providerType, overridden := runtimeSettingValue(RuntimeSettingDefaultStorageProvider); overridden {
+		opts := options.FindOne().SetSort(bson.D{{Key: "is_default", Value: -1}, {Key: "priority", Value: -1}})
+		err := fs.collections.StorageProviders().FindOne(ctx, bson.M{
+			"type":      providerType,
+			"is_active": true,
+		}, opts).Decode(&provider)
+		if err == nil {
+			return &provider, nil
+		}
+	}
+
 	err := fs.collections.StorageProviders().FindOne(ctx, bson.M{
 		"is_default": true,
 		"is_active":  true,
@@ -1382,18 +2247,52 @@ func (fs *FileService) getDefaultStorageProvider() (*models.StorageProvider, err
 	return &provider, nil
 }
 
-func (fs *FileService) storeChunk(uploadID string, chunkNumber int, content []byte) error {
-	// Implementation for storing file chunks temporarily
-	return nil
+// storeChunk keeps a chunk of an upload on disk until the upload is
+// completed. Chunks left behind are removed by the upload cleanup job.
+func (fs *FileService) storeChunk(userID primitive.ObjectID, uploadID string, chunkNumber int, content []byte) error {
+	dir, err := chunkUploadDir(userID.Hex(), uploadID)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, strconv.Itoa(chunkNumber)), content, 0600)
 }
 
-func (fs *FileService) assembleChunks(uploadID string) ([]byte, error) {
-	// Implementation for assembling chunks into final file
-	return []byte{}, nil
+func (fs *FileService) assembleChunks(userID primitive.ObjectID, uploadID string) ([]byte, error) {
+	dir, err := chunkUploadDir(userID.Hex(), uploadID)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("upload not found: %v", err)
+	}
+
+	numbers := make([]int, 0, len(entries))
+	for _, entry := range entries {
+		if number, err := strconv.Atoi(entry.Name()); err == nil {
+			numbers = append(numbers, number)
+		}
+	}
+	sort.Ints(numbers)
+
+	var content []byte
+	for _, number := range numbers {
+		chunk, err := os.ReadFile(filepath.Join(dir, strconv.Itoa(number)))
+		if err != nil {
+			return nil, err
+		}
+		content = append(content, chunk...)
+	}
+	return content, nil
 }
 
-func (fs *FileService) cleanupChunks(uploadID string) {
-	// Implementation for cleaning up temporary chunks
+func (fs *FileService) cleanupChunks(userID primitive.ObjectID, uploadID string) {
+	if dir, err := chunkUploadDir(userID.Hex(), uploadID); err == nil {
+		os.RemoveAll(dir)
+	}
 }
 
 func (fs *FileService) generateThumbnailAsync(file *models.File) {
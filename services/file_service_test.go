@@ -0,0 +1,64 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"oncloud/models"
+	"oncloud/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestCreateFileRemovesStoredContentWhenRecordFails(t *testing.T) {
+	db := testDatabase(t)
+	store := newFakeObjectStore()
+	fs := NewFileServiceWith(Dependencies{Database: testDatabaseSource{db}, Storage: store})
+	ctx := context.Background()
+
+	userID := primitive.NewObjectID()
+	if _, err := db.Collection("users").InsertOne(ctx, models.User{ID: userID, IsActive: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Collection("storage_providers").InsertOne(ctx, models.StorageProvider{
+		Name:        "default",
+		Type:        "s3",
+		IsActive:    true,
+		IsDefault:   true,
+		KeyTemplate: "{user_id}/{file_id}",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// A validator no file matches makes every file record insert fail
+	if err := db.CreateCollection(ctx, "files",
+		options.CreateCollection().SetValidator(bson.M{"rejected": bson.M{"$exists": true}})); err != nil {
+		t.Fatal(err)
+	}
+
+	content := []byte("quarterly numbers")
+	fileInfo := &utils.FileInfo{
+		Name:         "report.txt",
+		OriginalName: "report.txt",
+		Size:         int64(len(content)),
+		Extension:    ".txt",
+		MimeType:     "text/plain",
+		Hash:         "report-hash",
+		Path:         "uploads/report.txt",
+	}
+	if _, err := fs.createFile(ctx, userID, fileInfo, content, &models.FileUploadRequest{}, false); err == nil {
+		t.Fatal("file created although its record could not be saved")
+	}
+
+	for key := range store.objects {
+		t.Fatalf("stored content left behind at %s", key)
+	}
+	var user models.User
+	if err := db.Collection("users").FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+		t.Fatal(err)
+	}
+	if user.StorageUsed != 0 || user.FilesCount != 0 {
+		t.Fatalf("usage changed to %d bytes in %d files", user.StorageUsed, user.FilesCount)
+	}
+}
@@ -0,0 +1,399 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"oncloud/models"
+	"oncloud/utils"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrFileTypePolicyNotFound   = notFoundError("file type policy not found")
+	ErrFileTypeOverrideNotFound = notFoundError("file type override not found")
+	ErrOverrideUserNotFound     = notFoundError("user not found")
+	// ErrInvalidFileTypePolicy wraps any rejected policy definition
+	ErrInvalidFileTypePolicy = errors.New("invalid file type policy")
+)
+
+// FileTypeBlockedError is returned when a file type policy blocks an
+// upload. Match says what it was blocked for.
+type FileTypeBlockedError struct {
+	Policy *models.FileTypePolicy
+	Match  string
+}
+
+func (e *FileTypeBlockedError) Error() string {
+	if e.Policy.Message != "" {
+		return e.Policy.Message
+	}
+	return fmt.Sprintf("uploads of %s are not allowed", e.Match)
+}
+
+type FileTypePolicyService struct {
+	*BaseService
+}
+
+func NewFileTypePolicyService() *FileTypePolicyService {
+	return NewFileTypePolicyServiceWith(Dependencies{})
+}
+
+// NewFileTypePolicyServiceWith creates a file type policy service on the
+// given dependencies
+func NewFileTypePolicyServiceWith(deps Dependencies) *FileTypePolicyService {
+	return &FileTypePolicyService{
+		BaseService: NewBaseServiceWith(deps),
+	}
+}
+
+// ListPolicies returns every file type policy, global ones first
+func (ftps *FileTypePolicyService) ListPolicies(ctx context.Context) ([]models.FileTypePolicy, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	cursor, err := ftps.collections.FileTypePolicies().Find(ctx, bson.M{},
+		options.Find().SetSort(bson.D{{Key: "scope", Value: 1}, {Key: "created_at", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	policies := []models.FileTypePolicy{}
+	if err := cursor.All(ctx, &policies); err != nil {
+		return nil, err
+	}
+	return policies, nil
+}
+
+// CreatePolicy adds a file type policy
+func (ftps *FileTypePolicyService) CreatePolicy(ctx context.Context, req *models.FileTypePolicyRequest, adminID primitive.ObjectID) (*models.FileTypePolicy, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	policy := &models.FileTypePolicy{
+		ID:        primitive.NewObjectID(),
+		IsEnabled: req.IsEnabled == nil || *req.IsEnabled,
+		CreatedBy: adminID,
+		CreatedAt: now,
+	}
+	if err := ftps.applyRequest(ctx, policy, req, adminID); err != nil {
+		return nil, err
+	}
+
+	if _, err := ftps.collections.FileTypePolicies().InsertOne(ctx, policy); err != nil {
+		return nil, fmt.Errorf("failed to create file type policy: %v", err)
+	}
+	return policy, nil
+}
+
+// UpdatePolicy replaces a file type policy
+func (ftps *FileTypePolicyService) UpdatePolicy(ctx context.Context, policyID primitive.ObjectID, req *models.FileTypePolicyRequest, adminID primitive.ObjectID) (*models.FileTypePolicy, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var policy models.FileTypePolicy
+	err := ftps.collections.FileTypePolicies().FindOne(ctx, bson.M{"_id": policyID}).Decode(&policy)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrFileTypePolicyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if req.IsEnabled != nil {
+		policy.IsEnabled = *req.IsEnabled
+	}
+	if err := ftps.applyRequest(ctx, &policy, req, adminID); err != nil {
+		return nil, err
+	}
+
+	if _, err := ftps.collections.FileTypePolicies().ReplaceOne(ctx, bson.M{"_id": policyID}, &policy); err != nil {
+		return nil, fmt.Errorf("failed to update file type policy: %v", err)
+	}
+	return &policy, nil
+}
+
+// DeletePolicy removes a file type policy
+func (ftps *FileTypePolicyService) DeletePolicy(ctx context.Context, policyID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := ftps.collections.FileTypePolicies().DeleteOne(ctx, bson.M{"_id": policyID})
+	if err != nil {
+		return fmt.Errorf("failed to delete file type policy: %v", err)
+	}
+	if result.DeletedCount == 0 {
+		return ErrFileTypePolicyNotFound
+	}
+	return nil
+}
+
+// applyRequest checks a policy definition and sets it on policy
+func (ftps *FileTypePolicyService) applyRequest(ctx context.Context, policy *models.FileTypePolicy, req *models.FileTypePolicyRequest, adminID primitive.ObjectID) error {
+	policy.Scope = req.Scope
+	policy.PlanID = nil
+	policy.GroupID = nil
+
+	switch req.Scope {
+	case models.FileTypePolicyPlan:
+		if req.PlanID == "" {
+			return fmt.Errorf("%w: plan_id is required for plan policies", ErrInvalidFileTypePolicy)
+		}
+		planID, _ := utils.StringToObjectID(req.PlanID)
+		if count, err := ftps.collections.Plans().CountDocuments(ctx, bson.M{"_id": planID}); err != nil {
+			return err
+		} else if count == 0 {
+			return fmt.Errorf("%w: plan not found", ErrInvalidFileTypePolicy)
+		}
+		policy.PlanID = &planID
+	case models.FileTypePolicyGroup:
+		if req.GroupID == "" {
+			return fmt.Errorf("%w: group_id is required for group policies", ErrInvalidFileTypePolicy)
+		}
+		groupID, _ := utils.StringToObjectID(req.GroupID)
+		if count, err := ftps.collections.ScimGroups().CountDocuments(ctx, bson.M{"_id": groupID}); err != nil {
+			return err
+		} else if count == 0 {
+			return fmt.Errorf("%w: group not found", ErrInvalidFileTypePolicy)
+		}
+		policy.GroupID = &groupID
+	}
+
+	policy.Extensions = normalizePolicyExtensions(req.Extensions)
+	policy.MimeTypes = normalizePolicyMimeTypes(req.MimeTypes)
+	policy.DetectedTypes = normalizePolicyMimeTypes(req.DetectedTypes)
+	policy.BlockExecutables = req.BlockExecutables
+	if len(policy.Extensions) == 0 && len(policy.MimeTypes) == 0 && len(policy.DetectedTypes) == 0 && !policy.BlockExecutables {
+		return fmt.Errorf("%w: a policy must block extensions, MIME types, detected types or executables", ErrInvalidFileTypePolicy)
+	}
+
+	policy.Name = strings.TrimSpace(req.Name)
+	policy.Message = strings.TrimSpace(req.Message)
+	policy.UpdatedBy = adminID
+	policy.UpdatedAt = time.Now()
+	return nil
+}
+
+// normalizePolicyExtensions lowercases extensions and gives them the
+// leading dot files are typed with
+func normalizePolicyExtensions(extensions []string) []string {
+	normalized := []string{}
+	for _, ext := range extensions {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" || ext == "." {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		if !utils.SliceContains(normalized, ext) {
+			normalized = append(normalized, ext)
+		}
+	}
+	return normalized
+}
+
+func normalizePolicyMimeTypes(mimeTypes []string) []string {
+	normalized := []string{}
+	for _, mimeType := range mimeTypes {
+		mimeType = strings.ToLower(strings.TrimSpace(mimeType))
+		if mimeType != "" && !utils.SliceContains(normalized, mimeType) {
+			normalized = append(normalized, mimeType)
+		}
+	}
+	return normalized
+}
+
+// ListOverrides returns the users allowed past file type policies
+func (ftps *FileTypePolicyService) ListOverrides(ctx context.Context) ([]models.FileTypeOverride, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	cursor, err := ftps.collections.FileTypeOverrides().Find(ctx, bson.M{},
+		options.Find().SetSort(bson.M{"created_at": -1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	overrides := []models.FileTypeOverride{}
+	if err := cursor.All(ctx, &overrides); err != nil {
+		return nil, err
+	}
+	return overrides, nil
+}
+
+// SetOverride grants a user an override of file type policies, replacing
+// any they had
+func (ftps *FileTypePolicyService) SetOverride(ctx context.Context, userID primitive.ObjectID, req *models.FileTypeOverrideRequest, adminID primitive.ObjectID) (*models.FileTypeOverride, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if count, err := ftps.collections.Users().CountDocuments(ctx, bson.M{"_id": userID}); err != nil {
+		return nil, err
+	} else if count == 0 {
+		return nil, ErrOverrideUserNotFound
+	}
+
+	now := time.Now()
+	var override models.FileTypeOverride
+	err := ftps.collections.FileTypeOverrides().FindOneAndUpdate(ctx,
+		bson.M{"user_id": userID},
+		bson.M{
+			"$set": bson.M{
+				"reason":     strings.TrimSpace(req.Reason),
+				"expires_at": req.ExpiresAt,
+				"created_by": adminID,
+				"updated_at": now,
+			},
+			"$setOnInsert": bson.M{"created_at": now},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&override)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save file type override: %v", err)
+	}
+	return &override, nil
+}
+
+// RemoveOverride subjects a user to file type policies again
+func (ftps *FileTypePolicyService) RemoveOverride(ctx context.Context, userID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := ftps.collections.FileTypeOverrides().DeleteOne(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return fmt.Errorf("failed to delete file type override: %v", err)
+	}
+	if result.DeletedCount == 0 {
+		return ErrFileTypeOverrideNotFound
+	}
+	return nil
+}
+
+// Check applies the file type policies over the user to an upload, unless
+// the user has an override. ext and mimeType are what the upload is named
+// as; check is what its content was found to be, nil when the content is
+// not known yet.
+func (ftps *FileTypePolicyService) Check(ctx context.Context, userID primitive.ObjectID, ext, mimeType string, check *models.FileTypeCheck) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var user models.User
+	if err := ftps.collections.Users().FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+		return fmt.Errorf("user not found: %v", err)
+	}
+
+	overridden, err := ftps.collections.FileTypeOverrides().CountDocuments(ctx, bson.M{
+		"user_id": userID,
+		"$or": []bson.M{
+			{"expires_at": nil},
+			{"expires_at": bson.M{"$gt": time.Now()}},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to check file type override: %v", err)
+	}
+	if overridden > 0 {
+		return nil
+	}
+
+	policies, err := ftps.userPolicies(ctx, &user)
+	if err != nil {
+		return fmt.Errorf("failed to load file type policies: %v", err)
+	}
+
+	ext = strings.ToLower(ext)
+	mimeType, _, _ = strings.Cut(strings.ToLower(mimeType), ";")
+	mimeType = strings.TrimSpace(mimeType)
+	for i := range policies {
+		if match := fileTypePolicyMatch(&policies[i], ext, mimeType, check); match != "" {
+			return &FileTypeBlockedError{Policy: &policies[i], Match: match}
+		}
+	}
+	return nil
+}
+
+// userPolicies returns the enabled policies over a user: global ones, their
+// plan's and their directory groups'
+func (ftps *FileTypePolicyService) userPolicies(ctx context.Context, user *models.User) ([]models.FileTypePolicy, error) {
+	scopes := []bson.M{
+		{"scope": models.FileTypePolicyGlobal},
+		{"scope": models.FileTypePolicyPlan, "plan_id": user.PlanID},
+	}
+
+	cursor, err := ftps.collections.ScimGroups().Find(ctx,
+		bson.M{"members": user.ID},
+		options.Find().SetProjection(bson.M{"_id": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var groups []models.ScimGroup
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+	if len(groups) > 0 {
+		groupIDs := make([]primitive.ObjectID, len(groups))
+		for i, group := range groups {
+			groupIDs[i] = group.ID
+		}
+		scopes = append(scopes, bson.M{"scope": models.FileTypePolicyGroup, "group_id": bson.M{"$in": groupIDs}})
+	}
+
+	cursor, err = ftps.collections.FileTypePolicies().Find(ctx,
+		bson.M{"is_enabled": true, "$or": scopes},
+		options.Find().SetSort(bson.D{{Key: "scope", Value: 1}, {Key: "created_at", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	policies := []models.FileTypePolicy{}
+	if err := cursor.All(ctx, &policies); err != nil {
+		return nil, err
+	}
+	return policies, nil
+}
+
+// fileTypePolicyMatch returns what of an upload a policy blocks, or "" when
+// it allows the upload
+func fileTypePolicyMatch(policy *models.FileTypePolicy, ext, mimeType string, check *models.FileTypeCheck) string {
+	if ext != "" && utils.SliceContains(policy.Extensions, ext) {
+		return ext + " files"
+	}
+	if mimeType != "" && mimeTypeListed(policy.MimeTypes, mimeType) {
+		return mimeType + " files"
+	}
+	if check == nil {
+		return ""
+	}
+	if policy.BlockExecutables && check.Executable {
+		return "executable files (" + check.DetectedType + ")"
+	}
+	if check.DetectedType != "" && mimeTypeListed(policy.DetectedTypes, check.DetectedType) {
+		return check.DetectedType + " content"
+	}
+	return ""
+}
+
+// mimeTypeListed reports whether a list of MIME types, which may name a
+// family as type/*, holds mimeType
+func mimeTypeListed(list []string, mimeType string) bool {
+	family, _, _ := strings.Cut(mimeType, "/")
+	for _, listed := range list {
+		if listed == mimeType || listed == family+"/*" {
+			return true
+		}
+	}
+	return false
+}
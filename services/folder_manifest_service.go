@@ -0,0 +1,489 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"oncloud/models"
+	"oncloud/utils"
+	"sort"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// folderManifestInlineLimit is how many folders and files a manifest
+	// may list to be returned at once; bigger trees are exported in the
+	// background
+	folderManifestInlineLimit = 5000
+	// folderManifestRetention is how long an exported manifest is kept
+	folderManifestRetention = 7 * 24 * time.Hour
+	// folderManifestStaleAfter is how long a manifest export may run before
+	// it is taken to be interrupted
+	folderManifestStaleAfter = 30 * time.Minute
+)
+
+var (
+	ErrManifestFolderNotFound = notFoundError("folder not found")
+	ErrManifestExportNotFound = notFoundError("manifest export not found")
+	// ErrManifestNotReady is returned for downloads of exports that are
+	// still running or have failed
+	ErrManifestNotReady = errors.New("manifest is not ready")
+)
+
+// FolderManifest is a manifest small enough to return at once
+type FolderManifest struct {
+	Name        string
+	ContentType string
+	Content     []byte
+}
+
+// manifestTotals counts what a manifest lists
+type manifestTotals struct {
+	folders int
+	files   int
+	bytes   int64
+}
+
+type FolderManifestService struct {
+	*BaseService
+	storageService ObjectStore
+}
+
+func NewFolderManifestService() *FolderManifestService {
+	return NewFolderManifestServiceWith(Dependencies{})
+}
+
+// NewFolderManifestServiceWith creates a folder manifest service on the
+// given dependencies
+func NewFolderManifestServiceWith(deps Dependencies) *FolderManifestService {
+	return &FolderManifestService{
+		BaseService:    NewBaseServiceWith(deps),
+		storageService: deps.storage(),
+	}
+}
+
+// ExportManifest lists the paths, sizes, hashes and modification dates of
+// everything in one of the user's folders as CSV or JSON. Small trees are
+// returned at once; for bigger ones an export is started in the background
+// and returned instead.
+func (fms *FolderManifestService) ExportManifest(ctx context.Context, userID, folderID primitive.ObjectID, format string) (*FolderManifest, *models.FolderManifestExport, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	var folder models.Folder
+	err := fms.collections.Folders().FindOne(ctx, bson.M{
+		"_id":        folderID,
+		"user_id":    userID,
+		"is_deleted": false,
+	}).Decode(&folder)
+	if err != nil {
+		return nil, nil, ErrManifestFolderNotFound
+	}
+
+	subfolders, err := fms.collections.Folders().CountDocuments(ctx, bson.M{
+		"user_id":    userID,
+		"is_deleted": false,
+		"ancestors":  folderID,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to count subfolders: %v", err)
+	}
+
+	if int(subfolders)+folder.TotalFiles <= folderManifestInlineLimit {
+		var buf bytes.Buffer
+		if _, err := fms.writeManifest(ctx, &buf, &folder, format); err != nil {
+			return nil, nil, err
+		}
+		return &FolderManifest{
+			Name:        manifestFileName(&folder, format),
+			ContentType: manifestContentType(format),
+			Content:     buf.Bytes(),
+		}, nil, nil
+	}
+
+	now := time.Now()
+	export := &models.FolderManifestExport{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		FolderID:  folderID,
+		Format:    format,
+		Status:    models.FolderManifestRunning,
+		CreatedAt: now,
+		UpdatedAt: now,
+		ExpiresAt: now.Add(folderManifestRetention),
+	}
+	if _, err := fms.collections.FolderManifests().InsertOne(ctx, export); err != nil {
+		return nil, nil, fmt.Errorf("failed to start manifest export: %v", err)
+	}
+
+	go fms.runExport(context.Background(), export, &folder)
+
+	return nil, export, nil
+}
+
+// GetExport returns one of the manifest exports of a user's folder
+func (fms *FolderManifestService) GetExport(ctx context.Context, userID, folderID, exportID primitive.ObjectID) (*models.FolderManifestExport, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var export models.FolderManifestExport
+	err := fms.collections.FolderManifests().FindOne(ctx, bson.M{
+		"_id":        exportID,
+		"user_id":    userID,
+		"folder_id":  folderID,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).Decode(&export)
+	if err != nil {
+		return nil, ErrManifestExportNotFound
+	}
+	return &export, nil
+}
+
+// ListExports returns the manifest exports of a user's folder, newest first
+func (fms *FolderManifestService) ListExports(ctx context.Context, userID, folderID primitive.ObjectID) ([]models.FolderManifestExport, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	cursor, err := fms.collections.FolderManifests().Find(ctx, bson.M{
+		"user_id":    userID,
+		"folder_id":  folderID,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(50))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get manifest exports: %v", err)
+	}
+
+	exports := []models.FolderManifestExport{}
+	if err := cursor.All(ctx, &exports); err != nil {
+		return nil, fmt.Errorf("failed to get manifest exports: %v", err)
+	}
+	return exports, nil
+}
+
+// ServeExport writes a completed manifest export to w as an attachment
+func (fms *FolderManifestService) ServeExport(ctx context.Context, userID, folderID, exportID primitive.ObjectID, w http.ResponseWriter, r *http.Request) error {
+	export, err := fms.GetExport(ctx, userID, folderID, exportID)
+	if err != nil {
+		return err
+	}
+	if export.Status != models.FolderManifestCompleted {
+		return ErrManifestNotReady
+	}
+
+	content, err := fms.storageService.DownloadFile(ctx, export.StorageProvider, export.StorageKey)
+	if err != nil {
+		return fmt.Errorf("failed to get manifest: %v", err)
+	}
+
+	name := "manifest." + export.Format
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	var folder models.Folder
+	if err := fms.collections.Folders().FindOne(ctx, bson.M{"_id": folderID}).Decode(&folder); err == nil {
+		name = manifestFileName(&folder, export.Format)
+	}
+
+	w.Header().Set("Content-Type", manifestContentType(export.Format))
+	w.Header().Set("Content-Disposition", utils.ContentDisposition("attachment", name))
+	http.ServeContent(w, r, name, *export.CompletedAt, bytes.NewReader(content))
+	return nil
+}
+
+// runExport builds the manifest of an export and stores it with the
+// default storage provider
+func (fms *FolderManifestService) runExport(ctx context.Context, export *models.FolderManifestExport, folder *models.Folder) {
+	set := bson.M{"status": models.FolderManifestCompleted}
+	if err := fms.buildExport(ctx, export, folder, set); err != nil {
+		log.Printf("Folder manifest export %s failed: %v", export.ID.Hex(), err)
+		set = bson.M{"status": models.FolderManifestFailed, "error": err.Error()}
+	}
+
+	now := time.Now()
+	set["completed_at"] = now
+	set["updated_at"] = now
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if _, err := fms.collections.FolderManifests().UpdateOne(ctx, bson.M{"_id": export.ID}, bson.M{"$set": set}); err != nil {
+		log.Printf("Failed to update folder manifest export %s: %v", export.ID.Hex(), err)
+	}
+}
+
+func (fms *FolderManifestService) buildExport(ctx context.Context, export *models.FolderManifestExport, folder *models.Folder, set bson.M) error {
+	ctx, cancel := context.WithTimeout(ctx, folderManifestStaleAfter)
+	defer cancel()
+
+	var buf bytes.Buffer
+	totals, err := fms.writeManifest(ctx, &buf, folder, export.Format)
+	if err != nil {
+		return err
+	}
+
+	provider, err := NewFileService().getDefaultStorageProvider(ctx)
+	if err != nil {
+		return fmt.Errorf("no storage provider available: %v", err)
+	}
+	key := fmt.Sprintf("manifests/%s/%s.%s", export.UserID.Hex(), export.ID.Hex(), export.Format)
+	if err := fms.storageService.UploadFile(ctx, provider.Type, key, buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to store manifest: %v", err)
+	}
+
+	set["folders"] = totals.folders
+	set["files"] = totals.files
+	set["total_bytes"] = totals.bytes
+	set["size"] = int64(buf.Len())
+	set["storage_provider"] = provider.Type
+	set["storage_key"] = key
+	return nil
+}
+
+// writeManifest writes the manifest of a folder tree to w: its subfolders
+// by path, then its files grouped by folder
+func (fms *FolderManifestService) writeManifest(ctx context.Context, w io.Writer, folder *models.Folder, format string) (*manifestTotals, error) {
+	cursor, err := fms.collections.Folders().Find(ctx, bson.M{
+		"user_id":    folder.UserID,
+		"is_deleted": false,
+		"ancestors":  folder.ID,
+	}, options.Find().SetProjection(bson.M{"_id": 1, "parent_id": 1, "name": 1, "ancestors": 1, "total_size": 1, "updated_at": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list subfolders: %v", err)
+	}
+	var subfolders []models.Folder
+	if err := cursor.All(ctx, &subfolders); err != nil {
+		return nil, fmt.Errorf("failed to list subfolders: %v", err)
+	}
+
+	// Parents come before their children when sorted by depth
+	sort.Slice(subfolders, func(i, j int) bool {
+		return len(subfolders[i].Ancestors) < len(subfolders[j].Ancestors)
+	})
+	paths := map[primitive.ObjectID]string{folder.ID: ""}
+	var entries []models.FolderManifestEntry
+	for _, sub := range subfolders {
+		if sub.ParentID == nil {
+			continue
+		}
+		parent, ok := paths[*sub.ParentID]
+		if !ok {
+			continue
+		}
+		paths[sub.ID] = manifestPath(parent, sub.Name)
+		entries = append(entries, models.FolderManifestEntry{
+			Path:       paths[sub.ID],
+			Type:       "folder",
+			Size:       sub.TotalSize,
+			ModifiedAt: sub.UpdatedAt,
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+
+	enc, err := newManifestEncoder(w, format, folder.Path)
+	if err != nil {
+		return nil, err
+	}
+	totals := &manifestTotals{}
+	for _, entry := range entries {
+		if err := enc.write(entry); err != nil {
+			return nil, err
+		}
+		totals.folders++
+	}
+
+	folderIDs := make([]primitive.ObjectID, 0, len(paths))
+	for id := range paths {
+		folderIDs = append(folderIDs, id)
+	}
+	files, err := fms.collections.Files().Find(ctx, bson.M{
+		"user_id":    folder.UserID,
+		"is_deleted": false,
+		"folder_id":  bson.M{"$in": folderIDs},
+	}, options.Find().
+		SetProjection(bson.M{"_id": 1, "folder_id": 1, "original_name": 1, "display_name": 1, "size": 1, "hash": 1, "mime_type": 1, "updated_at": 1}).
+		SetSort(bson.D{{Key: "folder_id", Value: 1}, {Key: "original_name", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files: %v", err)
+	}
+	defer files.Close(ctx)
+
+	for files.Next(ctx) {
+		var file models.File
+		if err := files.Decode(&file); err != nil || file.FolderID == nil {
+			continue
+		}
+		if err := enc.write(models.FolderManifestEntry{
+			Path:       manifestPath(paths[*file.FolderID], fileDisplayName(&file)),
+			Type:       "file",
+			Size:       file.Size,
+			MD5:        file.Hash,
+			MimeType:   file.MimeType,
+			ModifiedAt: file.UpdatedAt,
+		}); err != nil {
+			return nil, err
+		}
+		totals.files++
+		totals.bytes += file.Size
+	}
+	if err := files.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list files: %v", err)
+	}
+
+	if err := enc.close(totals); err != nil {
+		return nil, err
+	}
+	return totals, nil
+}
+
+// FailInterruptedExports marks manifest exports left running when the
+// server stopped as failed
+func (fms *FolderManifestService) FailInterruptedExports(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	_, err := fms.collections.FolderManifests().UpdateMany(ctx,
+		bson.M{"status": models.FolderManifestRunning, "updated_at": bson.M{"$lt": now.Add(-folderManifestStaleAfter)}},
+		bson.M{"$set": bson.M{
+			"status":       models.FolderManifestFailed,
+			"error":        "interrupted by a restart",
+			"completed_at": now,
+			"updated_at":   now,
+		}},
+	)
+	if err != nil {
+		log.Printf("Failed to fail interrupted folder manifest exports: %v", err)
+	}
+}
+
+// PurgeExpiredExports deletes manifest exports past their retention along
+// with their stored manifests, returning how many it deleted
+func (fms *FolderManifestService) PurgeExpiredExports(ctx context.Context) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	cursor, err := fms.collections.FolderManifests().Find(ctx, bson.M{
+		"expires_at": bson.M{"$lte": time.Now()},
+	}, options.Find().SetLimit(500))
+	if err != nil {
+		return 0, fmt.Errorf("failed to find expired manifest exports: %v", err)
+	}
+	var exports []models.FolderManifestExport
+	if err := cursor.All(ctx, &exports); err != nil {
+		return 0, fmt.Errorf("failed to find expired manifest exports: %v", err)
+	}
+
+	purged := 0
+	for _, export := range exports {
+		if export.StorageKey != "" {
+			if err := fms.storageService.DeleteFile(ctx, export.StorageProvider, export.StorageKey); err != nil {
+				log.Printf("Failed to delete manifest of export %s: %v", export.ID.Hex(), err)
+				continue
+			}
+		}
+		result, err := fms.collections.FolderManifests().DeleteOne(ctx, bson.M{"_id": export.ID})
+		if err != nil {
+			log.Printf("Failed to delete folder manifest export %s: %v", export.ID.Hex(), err)
+			continue
+		}
+		purged += int(result.DeletedCount)
+	}
+	return purged, nil
+}
+
+// manifestEncoder writes manifest entries as CSV rows or as the entries of
+// a JSON document, without holding them all
+type manifestEncoder struct {
+	w      io.Writer
+	csv    *csv.Writer
+	listed bool
+}
+
+func newManifestEncoder(w io.Writer, format, root string) (*manifestEncoder, error) {
+	if format == models.FolderManifestCSV {
+		enc := &manifestEncoder{w: w, csv: csv.NewWriter(w)}
+		if err := enc.csv.Write([]string{"path", "type", "size", "md5", "mime_type", "modified_at"}); err != nil {
+			return nil, fmt.Errorf("failed to write manifest: %v", err)
+		}
+		return enc, nil
+	}
+
+	header, _ := json.Marshal(map[string]interface{}{"folder": root, "generated_at": time.Now().UTC()})
+	if _, err := fmt.Fprintf(w, "%s,\"entries\":[", header[:len(header)-1]); err != nil {
+		return nil, fmt.Errorf("failed to write manifest: %v", err)
+	}
+	return &manifestEncoder{w: w}, nil
+}
+
+func (e *manifestEncoder) write(entry models.FolderManifestEntry) error {
+	if e.csv != nil {
+		if err := e.csv.Write([]string{
+			entry.Path,
+			entry.Type,
+			strconv.FormatInt(entry.Size, 10),
+			entry.MD5,
+			entry.MimeType,
+			entry.ModifiedAt.UTC().Format(time.RFC3339),
+		}); err != nil {
+			return fmt.Errorf("failed to write manifest: %v", err)
+		}
+		return nil
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to write manifest: %v", err)
+	}
+	if e.listed {
+		data = append([]byte{','}, data...)
+	}
+	e.listed = true
+	if _, err := e.w.Write(data); err != nil {
+		return fmt.Errorf("failed to write manifest: %v", err)
+	}
+	return nil
+}
+
+// close ends the manifest; JSON manifests end with their totals
+func (e *manifestEncoder) close(totals *manifestTotals) error {
+	if e.csv != nil {
+		e.csv.Flush()
+		if err := e.csv.Error(); err != nil {
+			return fmt.Errorf("failed to write manifest: %v", err)
+		}
+		return nil
+	}
+
+	if _, err := fmt.Fprintf(e.w, "],\"folders\":%d,\"files\":%d,\"total_bytes\":%d}", totals.folders, totals.files, totals.bytes); err != nil {
+		return fmt.Errorf("failed to write manifest: %v", err)
+	}
+	return nil
+}
+
+// manifestPath joins a name to the path of its folder within the manifest
+func manifestPath(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "/" + name
+}
+
+func manifestFileName(folder *models.Folder, format string) string {
+	return folder.Name + "-manifest." + format
+}
+
+func manifestContentType(format string) string {
+	if format == models.FolderManifestCSV {
+		return "text/csv; charset=utf-8"
+	}
+	return "application/json"
+}
@@ -0,0 +1,568 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"oncloud/models"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrFolderMemberNotFound = notFoundError("folder member not found")
+	ErrFolderMemberExists   = errors.New("folder is already shared with this user")
+	// ErrFolderMemberUser is returned when a folder is shared with an email
+	// no other active user has
+	ErrFolderMemberUser = errors.New("no other user with this email")
+	// ErrSharedFolderNotMember is returned when the folder, or the item in it,
+	// does not exist or is not shared with the user
+	ErrSharedFolderNotMember = notFoundError("shared folder or item not found")
+	ErrSharedFolderReadOnly  = forbiddenError("only editors can change this folder")
+	// ErrSharedFolderRoot is returned when a member tries to rename or
+	// delete the shared folder itself, which only its owner may do
+	ErrSharedFolderRoot = errors.New("the shared folder itself cannot be changed")
+	// ErrSharedFolderOwnerFull is returned when the folder's owner has no
+	// room left for an upload
+	ErrSharedFolderOwnerFull = quotaExceededError("the folder owner's storage is full")
+)
+
+type FolderMemberService struct {
+	*BaseService
+	fileService   *FileService
+	folderService *FolderService
+}
+
+func NewFolderMemberService() *FolderMemberService {
+	return NewFolderMemberServiceWith(Dependencies{})
+}
+
+// NewFolderMemberServiceWith creates a folder member service on the given
+// dependencies
+func NewFolderMemberServiceWith(deps Dependencies) *FolderMemberService {
+	return &FolderMemberService{
+		BaseService:   NewBaseServiceWith(deps),
+		fileService:   NewFileServiceWith(deps),
+		folderService: NewFolderServiceWith(deps),
+	}
+}
+
+// ListMembers lists the users one of the owner's folders is shared with
+func (fms *FolderMemberService) ListMembers(ctx context.Context, ownerID, folderID primitive.ObjectID) ([]models.FolderMember, error) {
+	if _, err := fms.folderService.GetUserFolder(ctx, ownerID, folderID); err != nil {
+		return nil, ErrSharedFolderNotMember
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	cursor, err := fms.collections.FolderMembers().Find(ctx,
+		bson.M{"folder_id": folderID, "owner_id": ownerID},
+		options.Find().SetSort(bson.M{"created_at": 1}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get folder members: %v", err)
+	}
+	members := []models.FolderMember{}
+	if err := cursor.All(ctx, &members); err != nil {
+		return nil, fmt.Errorf("failed to get folder members: %v", err)
+	}
+	return members, nil
+}
+
+// AddMember shares one of the owner's folders with another user
+func (fms *FolderMemberService) AddMember(ctx context.Context, ownerID, folderID primitive.ObjectID, req *models.FolderMemberRequest) (*models.FolderMember, error) {
+	if _, err := fms.folderService.GetUserFolder(ctx, ownerID, folderID); err != nil {
+		return nil, ErrSharedFolderNotMember
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var user models.User
+	err := fms.collections.Users().FindOne(ctx, bson.M{
+		"email":     strings.TrimSpace(req.Email),
+		"is_active": true,
+	}).Decode(&user)
+	if err == mongo.ErrNoDocuments || (err == nil && user.ID == ownerID) {
+		return nil, ErrFolderMemberUser
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find user: %v", err)
+	}
+
+	now := time.Now()
+	member := &models.FolderMember{
+		ID:        primitive.NewObjectID(),
+		FolderID:  folderID,
+		OwnerID:   ownerID,
+		UserID:    user.ID,
+		Email:     user.Email,
+		Username:  user.Username,
+		Role:      req.Role,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if _, err := fms.collections.FolderMembers().InsertOne(ctx, member); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrFolderMemberExists
+		}
+		return nil, fmt.Errorf("failed to add folder member: %v", err)
+	}
+	return member, nil
+}
+
+// UpdateMember changes a member's role
+func (fms *FolderMemberService) UpdateMember(ctx context.Context, ownerID, folderID, userID primitive.ObjectID, req *models.FolderMemberUpdateRequest) (*models.FolderMember, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var member models.FolderMember
+	err := fms.collections.FolderMembers().FindOneAndUpdate(ctx,
+		bson.M{"folder_id": folderID, "owner_id": ownerID, "user_id": userID},
+		bson.M{"$set": bson.M{"role": req.Role, "updated_at": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&member)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrFolderMemberNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update folder member: %v", err)
+	}
+	return &member, nil
+}
+
+// RemoveMember stops sharing a folder with a user. What they uploaded stays
+// in the folder, and their activity is kept.
+func (fms *FolderMemberService) RemoveMember(ctx context.Context, ownerID, folderID, userID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := fms.collections.FolderMembers().DeleteOne(ctx, bson.M{"folder_id": folderID, "owner_id": ownerID, "user_id": userID})
+	if err != nil {
+		return fmt.Errorf("failed to remove folder member: %v", err)
+	}
+	if result.DeletedCount == 0 {
+		return ErrFolderMemberNotFound
+	}
+	return nil
+}
+
+// GetMemberActivity lists what a member did in one of the owner's folders,
+// newest first
+func (fms *FolderMemberService) GetMemberActivity(ctx context.Context, ownerID, folderID, userID primitive.ObjectID, page, limit int) ([]models.FolderActivity, int, error) {
+	if _, err := fms.folderService.GetUserFolder(ctx, ownerID, folderID); err != nil {
+		return nil, 0, ErrSharedFolderNotMember
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{"folder_id": folderID, "owner_id": ownerID, "user_id": userID}
+	total, err := fms.collections.FolderActivity().CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count folder activity: %v", err)
+	}
+
+	cursor, err := fms.collections.FolderActivity().Find(ctx, filter,
+		options.Find().
+			SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+			SetSkip(int64((page-1)*limit)).
+			SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get folder activity: %v", err)
+	}
+	activity := []models.FolderActivity{}
+	if err := cursor.All(ctx, &activity); err != nil {
+		return nil, 0, fmt.Errorf("failed to get folder activity: %v", err)
+	}
+	return activity, int(total), nil
+}
+
+// SharedWithUser lists the folders other users shared with userID that are
+// not in their owner's trash
+func (fms *FolderMemberService) SharedWithUser(ctx context.Context, userID primitive.ObjectID) ([]models.SharedFolder, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	cursor, err := fms.collections.FolderMembers().Find(ctx,
+		bson.M{"user_id": userID},
+		options.Find().SetSort(bson.M{"created_at": -1}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get shared folders: %v", err)
+	}
+	var memberships []models.FolderMember
+	if err := cursor.All(ctx, &memberships); err != nil {
+		return nil, fmt.Errorf("failed to get shared folders: %v", err)
+	}
+
+	shared := []models.SharedFolder{}
+	for _, member := range memberships {
+		var folder models.Folder
+		err := fms.collections.Folders().FindOne(ctx, bson.M{
+			"_id":        member.FolderID,
+			"user_id":    member.OwnerID,
+			"is_deleted": false,
+		}).Decode(&folder)
+		if err != nil {
+			continue
+		}
+
+		ownerName := ""
+		var owner models.User
+		if err := fms.collections.Users().FindOne(ctx, bson.M{"_id": member.OwnerID}).Decode(&owner); err == nil {
+			ownerName = owner.Username
+		}
+
+		shared = append(shared, models.SharedFolder{
+			Folder:    folder,
+			Role:      member.Role,
+			OwnerName: ownerName,
+			SharedAt:  member.CreatedAt,
+		})
+	}
+	return shared, nil
+}
+
+// GetSharedContents lists a folder shared with userID, or one of its
+// subfolders when folderID is given
+func (fms *FolderMemberService) GetSharedContents(ctx context.Context, userID, sharedID primitive.ObjectID, folderID *primitive.ObjectID, page, limit int) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	member, root, err := fms.membership(ctx, userID, sharedID, false)
+	if err != nil {
+		return nil, err
+	}
+
+	folder := root
+	if folderID != nil {
+		if folder, err = fms.folderInShare(ctx, root, *folderID); err != nil {
+			return nil, err
+		}
+	}
+
+	subfolders, err := fms.folderService.getFolderSubfolders(ctx, root.UserID, folder.ID, "name", "asc")
+	if err != nil {
+		return nil, err
+	}
+	files, filesTotal, err := fms.folderService.getFolderFiles(ctx, root.UserID, folder.ID, page, limit, "name", "asc")
+	if err != nil {
+		return nil, err
+	}
+	hideUploaders(files)
+
+	return map[string]interface{}{
+		"folder":      folder,
+		"role":        member.Role,
+		"subfolders":  subfolders,
+		"files":       files,
+		"files_total": filesTotal,
+		"page":        page,
+		"limit":       limit,
+	}, nil
+}
+
+// Upload saves an editor's file into a folder shared with them, or into one
+// of its subfolders, on behalf of the folder's owner
+func (fms *FolderMemberService) Upload(ctx context.Context, userID, sharedID primitive.ObjectID, folderID *primitive.ObjectID, fileHeader *multipart.FileHeader) (*models.File, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	member, root, err := fms.membership(ctx, userID, sharedID, true)
+	if err != nil {
+		return nil, err
+	}
+
+	folder := root
+	if folderID != nil {
+		if folder, err = fms.folderInShare(ctx, root, *folderID); err != nil {
+			return nil, err
+		}
+	}
+
+	plan, err := fms.fileService.GetUserPlan(ctx, root.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user plan: %v", err)
+	}
+	var owner models.User
+	if err := fms.collections.Users().FindOne(ctx, bson.M{"_id": root.UserID}).Decode(&owner); err != nil {
+		return nil, fmt.Errorf("failed to get folder owner: %v", err)
+	}
+	if err := fms.fileService.CheckUploadLimits(&owner, plan, fileHeader.Size); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSharedFolderOwnerFull, err)
+	}
+
+	src, err := fileHeader.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %v", err)
+	}
+	defer src.Close()
+	content, err := io.ReadAll(src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %v", err)
+	}
+
+	file, err := fms.fileService.UploadContent(ctx, root.UserID, fileHeader.Filename, content, &models.FileUploadRequest{
+		FolderID:    folder.ID.Hex(),
+		Contributor: &userID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	fms.recordActivity(ctx, member, &models.FolderActivity{
+		Action:   models.FolderActivityUpload,
+		ItemType: "file",
+		ItemID:   file.ID,
+		Name:     file.Name,
+		Size:     file.Size,
+	})
+	return file, nil
+}
+
+// RenameFile renames a file inside a folder shared with an editor
+func (fms *FolderMemberService) RenameFile(ctx context.Context, userID, sharedID, fileID primitive.ObjectID, name string) (*models.File, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	member, root, err := fms.membership(ctx, userID, sharedID, true)
+	if err != nil {
+		return nil, err
+	}
+	file, err := fms.fileInShare(ctx, root, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkFileLock(file, userID); err != nil {
+		return nil, err
+	}
+
+	updated, err := fms.fileService.UpdateFile(ctx, root.UserID, fileID, &models.FileUpdateRequest{Name: &name})
+	if err != nil {
+		return nil, err
+	}
+
+	fms.recordActivity(ctx, member, &models.FolderActivity{
+		Action:   models.FolderActivityRename,
+		ItemType: "file",
+		ItemID:   fileID,
+		Name:     name,
+		OldName:  file.Name,
+	})
+	return updated, nil
+}
+
+// DeleteFile moves a file inside a folder shared with an editor to its
+// owner's trash
+func (fms *FolderMemberService) DeleteFile(ctx context.Context, userID, sharedID, fileID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	member, root, err := fms.membership(ctx, userID, sharedID, true)
+	if err != nil {
+		return err
+	}
+	file, err := fms.fileInShare(ctx, root, fileID)
+	if err != nil {
+		return err
+	}
+	if err := checkFileLock(file, userID); err != nil {
+		return err
+	}
+
+	if err := fms.fileService.DeleteFile(ctx, root.UserID, fileID, false); err != nil {
+		return err
+	}
+
+	fms.recordActivity(ctx, member, &models.FolderActivity{
+		Action:   models.FolderActivityDelete,
+		ItemType: "file",
+		ItemID:   fileID,
+		Name:     file.Name,
+		Size:     file.Size,
+	})
+	return nil
+}
+
+// RenameFolder renames a subfolder of a folder shared with an editor
+func (fms *FolderMemberService) RenameFolder(ctx context.Context, userID, sharedID, folderID primitive.ObjectID, name string) (*models.Folder, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	member, root, err := fms.membership(ctx, userID, sharedID, true)
+	if err != nil {
+		return nil, err
+	}
+	if folderID == root.ID {
+		return nil, ErrSharedFolderRoot
+	}
+	folder, err := fms.folderInShare(ctx, root, folderID)
+	if err != nil {
+		return nil, err
+	}
+
+	updated, err := fms.folderService.UpdateFolder(ctx, root.UserID, folderID, &models.FolderUpdateRequest{Name: &name})
+	if err != nil {
+		return nil, err
+	}
+
+	fms.recordActivity(ctx, member, &models.FolderActivity{
+		Action:   models.FolderActivityRename,
+		ItemType: "folder",
+		ItemID:   folderID,
+		Name:     name,
+		OldName:  folder.Name,
+	})
+	return updated, nil
+}
+
+// DeleteFolder moves a subfolder of a folder shared with an editor, and
+// everything in it, to its owner's trash
+func (fms *FolderMemberService) DeleteFolder(ctx context.Context, userID, sharedID, folderID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	member, root, err := fms.membership(ctx, userID, sharedID, true)
+	if err != nil {
+		return err
+	}
+	if folderID == root.ID {
+		return ErrSharedFolderRoot
+	}
+	folder, err := fms.folderInShare(ctx, root, folderID)
+	if err != nil {
+		return err
+	}
+
+	if err := fms.folderService.DeleteFolder(ctx, root.UserID, folderID, false); err != nil {
+		return err
+	}
+
+	fms.recordActivity(ctx, member, &models.FolderActivity{
+		Action:   models.FolderActivityDelete,
+		ItemType: "folder",
+		ItemID:   folderID,
+		Name:     folder.Name,
+		Size:     folder.TotalSize,
+	})
+	return nil
+}
+
+// membership returns userID's membership of the shared folder and the
+// folder, which must not be in the trash. Viewers are refused when edit is
+// set.
+func (fms *FolderMemberService) membership(ctx context.Context, userID, sharedID primitive.ObjectID, edit bool) (*models.FolderMember, *models.Folder, error) {
+	var member models.FolderMember
+	err := fms.collections.FolderMembers().FindOne(ctx, bson.M{"folder_id": sharedID, "user_id": userID}).Decode(&member)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil, ErrSharedFolderNotMember
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get folder member: %v", err)
+	}
+
+	var folder models.Folder
+	err = fms.collections.Folders().FindOne(ctx, bson.M{
+		"_id":        sharedID,
+		"user_id":    member.OwnerID,
+		"is_deleted": false,
+	}).Decode(&folder)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil, ErrSharedFolderNotMember
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get shared folder: %v", err)
+	}
+
+	if edit && member.Role != models.FolderRoleEditor {
+		return nil, nil, ErrSharedFolderReadOnly
+	}
+	return &member, &folder, nil
+}
+
+// folderInShare returns the shared folder root or a live subfolder of it
+func (fms *FolderMemberService) folderInShare(ctx context.Context, root *models.Folder, folderID primitive.ObjectID) (*models.Folder, error) {
+	if folderID == root.ID {
+		return root, nil
+	}
+
+	var folder models.Folder
+	err := fms.collections.Folders().FindOne(ctx, bson.M{
+		"_id":        folderID,
+		"user_id":    root.UserID,
+		"ancestors":  root.ID,
+		"is_deleted": false,
+	}).Decode(&folder)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrSharedFolderNotMember
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get folder: %v", err)
+	}
+	return &folder, nil
+}
+
+// fileInShare returns a live file in the shared folder root or below it
+func (fms *FolderMemberService) fileInShare(ctx context.Context, root *models.Folder, fileID primitive.ObjectID) (*models.File, error) {
+	var file models.File
+	err := fms.collections.Files().FindOne(ctx, bson.M{
+		"_id":        fileID,
+		"user_id":    root.UserID,
+		"is_deleted": false,
+	}).Decode(&file)
+	if err == mongo.ErrNoDocuments || (err == nil && file.FolderID == nil) {
+		return nil, ErrSharedFolderNotMember
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file: %v", err)
+	}
+	if _, err := fms.folderInShare(ctx, root, *file.FolderID); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// recordActivity logs a member's change and adds it to the member's counts.
+// Failures are only logged; the change itself was made.
+func (fms *FolderMemberService) recordActivity(ctx context.Context, member *models.FolderMember, activity *models.FolderActivity) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	activity.ID = primitive.NewObjectID()
+	activity.FolderID = member.FolderID
+	activity.OwnerID = member.OwnerID
+	activity.UserID = member.UserID
+	activity.CreatedAt = now
+	if _, err := fms.collections.FolderActivity().InsertOne(ctx, activity); err != nil {
+		log.Printf("Failed to record activity in folder %s: %v", member.FolderID.Hex(), err)
+	}
+
+	inc := bson.M{}
+	switch activity.Action {
+	case models.FolderActivityUpload:
+		inc["uploads"] = 1
+		inc["uploaded_bytes"] = activity.Size
+	case models.FolderActivityRename:
+		inc["renames"] = 1
+	case models.FolderActivityDelete:
+		inc["deletes"] = 1
+	}
+	if _, err := fms.collections.FolderMembers().UpdateOne(ctx,
+		bson.M{"_id": member.ID},
+		bson.M{"$inc": inc, "$set": bson.M{"last_active_at": now}},
+	); err != nil {
+		log.Printf("Failed to update member %s of folder %s: %v", member.UserID.Hex(), member.FolderID.Hex(), err)
+	}
+}
@@ -4,11 +4,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"oncloud/database"
+	"log"
 	"oncloud/models"
 	"oncloud/utils"
-	"os"
+	"regexp"
+	"sort"
 	"time"
+	"unicode/utf8"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -16,25 +18,54 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+var (
+	ErrSharedFolderNotFound = notFoundError("folder not found or access denied")
+	// ErrSharePasswordRequired is returned when a password protected share is
+	// searched without its password, or with a wrong one
+	ErrSharePasswordRequired = errors.New("share password required")
+	ErrFolderCopyLimit       = quotaExceededError("folder copy exceeds the plan's limits")
+	ErrFolderCopyJobNotFound = notFoundError("folder copy not found")
+)
+
+const (
+	// maxFolderCopyErrors caps the errors recorded on a folder copy
+	maxFolderCopyErrors = 100
+	// folderCopyStaleAfter is how long a running folder copy goes without
+	// progress before it is taken to be interrupted
+	folderCopyStaleAfter = 2 * time.Minute
+)
+
 type FolderService struct {
-	folderCollection *mongo.Collection
-	fileCollection   *mongo.Collection
-	userCollection   *mongo.Collection
-	shareCollection  *mongo.Collection
+	folderCollection  *mongo.Collection
+	fileCollection    *mongo.Collection
+	userCollection    *mongo.Collection
+	shareCollection   *mongo.Collection
+	copyJobCollection *mongo.Collection
+	usage             *UserUsageService
+	folderUsage       *FolderUsageService
 }
 
 func NewFolderService() *FolderService {
+	return NewFolderServiceWith(Dependencies{})
+}
+
+// NewFolderServiceWith creates a folder service on the given dependencies
+func NewFolderServiceWith(deps Dependencies) *FolderService {
+	db := deps.database()
 	return &FolderService{
-		folderCollection: database.GetCollection("folders"),
-		fileCollection:   database.GetCollection("files"),
-		userCollection:   database.GetCollection("users"),
-		shareCollection:  database.GetCollection("folder_shares"),
+		folderCollection:  db.GetCollection("folders"),
+		fileCollection:    db.GetCollection("files"),
+		userCollection:    db.GetCollection("users"),
+		shareCollection:   db.GetCollection("folder_shares"),
+		copyJobCollection: db.GetCollection("folder_copy_jobs"),
+		usage:             NewUserUsageServiceWith(deps),
+		folderUsage:       NewFolderUsageServiceWith(deps),
 	}
 }
 
 // GetUserFolders returns paginated user folders
-func (fs *FolderService) GetUserFolders(userID primitive.ObjectID, parentID, search string, page, limit int) ([]models.Folder, int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (fs *FolderService) GetUserFolders(ctx context.Context, userID primitive.ObjectID, parentID, search string, page, limit int) ([]models.Folder, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	// Build filter query
@@ -90,8 +121,8 @@ func (fs *FolderService) GetUserFolders(userID primitive.ObjectID, parentID, sea
 }
 
 // GetUserFolder returns a specific folder for user
-func (fs *FolderService) GetUserFolder(userID, folderID primitive.ObjectID) (*models.Folder, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (fs *FolderService) GetUserFolder(ctx context.Context, userID, folderID primitive.ObjectID) (*models.Folder, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	var folder models.Folder
@@ -101,15 +132,19 @@ func (fs *FolderService) GetUserFolder(userID, folderID primitive.ObjectID) (*mo
 		"is_deleted": false,
 	}).Decode(&folder)
 	if err != nil {
-		return nil, fmt.Errorf("folder not found: %v", err)
+		return nil, findError(err, ErrFolderNotFound)
 	}
 
 	return &folder, nil
 }
 
 // CreateFolder creates a new folder
-func (fs *FolderService) CreateFolder(userID primitive.ObjectID, req *models.FolderCreateRequest) (*models.Folder, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (fs *FolderService) CreateFolder(ctx context.Context, userID primitive.ObjectID, req *models.FolderCreateRequest) (*models.Folder, error) {
+	if err := checkAccountWritable(ctx, fs.userCollection, userID); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	// Validate parent folder if specified
@@ -119,18 +154,18 @@ func (fs *FolderService) CreateFolder(userID primitive.ObjectID, req *models.Fol
 		parentObjID = &pid
 
 		// Verify parent folder exists and belongs to user
-		if err := fs.validateFolderOwnership(userID, pid); err != nil {
+		if err := fs.validateFolderOwnership(ctx, userID, pid); err != nil {
 			return nil, fmt.Errorf("invalid parent folder: %v", err)
 		}
 	}
 
 	// Check for duplicate folder name in same parent
-	if err := fs.checkDuplicateFolderName(userID, req.Name, parentObjID); err != nil {
+	if err := fs.checkDuplicateFolderName(ctx, userID, req.Name, parentObjID); err != nil {
 		return nil, err
 	}
 
 	// Generate folder path
-	path, err := fs.generateFolderPath(userID, req.Name, parentObjID)
+	path, ancestors, err := fs.folderLineage(ctx, userID, req.Name, parentObjID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate folder path: %v", err)
 	}
@@ -143,6 +178,7 @@ func (fs *FolderService) CreateFolder(userID primitive.ObjectID, req *models.Fol
 		Name:        req.Name,
 		Description: req.Description,
 		Path:        path,
+		Ancestors:   ancestors,
 		Color:       req.Color,
 		Icon:        req.Icon,
 		IsPublic:    req.IsPublic,
@@ -157,33 +193,99 @@ func (fs *FolderService) CreateFolder(userID primitive.ObjectID, req *models.Fol
 	}
 
 	// Update user folder count
-	fs.updateUserFolderCount(userID, 1)
+	fs.updateUserFolderCount(ctx, userID, 1)
 
 	return folder, nil
 }
 
+// ResolveFolderPath returns the ID of the user's live folder at path, such as
+// /Photos/2024, or nil for the root. Folders missing along the path are
+// created when create is set; otherwise nil is returned for a missing folder.
+func (fs *FolderService) ResolveFolderPath(ctx context.Context, userID primitive.ObjectID, path string, create bool) (*primitive.ObjectID, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var parentID *primitive.ObjectID
+	for _, name := range utils.FolderPathSegments(path) {
+		filter := bson.M{"user_id": userID, "name": name, "is_deleted": false, "parent_id": bson.M{"$exists": false}}
+		if parentID != nil {
+			filter["parent_id"] = *parentID
+		}
+
+		var folder models.Folder
+		err := fs.folderCollection.FindOne(ctx, filter).Decode(&folder)
+		if err == mongo.ErrNoDocuments {
+			if !create {
+				return nil, nil
+			}
+			req := &models.FolderCreateRequest{Name: name}
+			if parentID != nil {
+				req.ParentID = parentID.Hex()
+			}
+			created, createErr := fs.CreateFolder(ctx, userID, req)
+			if createErr != nil {
+				// Another upload may have created it in the meantime
+				if err := fs.folderCollection.FindOne(ctx, filter).Decode(&folder); err != nil {
+					return nil, createErr
+				}
+			} else {
+				folder = *created
+			}
+		} else if err != nil {
+			return nil, err
+		}
+
+		id := folder.ID
+		parentID = &id
+	}
+
+	return parentID, nil
+}
+
 // UpdateFolder updates folder information
-func (fs *FolderService) UpdateFolder(userID, folderID primitive.ObjectID, req interface{}) (*models.Folder, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (fs *FolderService) UpdateFolder(ctx context.Context, userID, folderID primitive.ObjectID, req *models.FolderUpdateRequest) (*models.Folder, error) {
+	if err := checkAccountWritable(ctx, fs.userCollection, userID); err != nil {
+		return nil, err
+	}
+
+	// A rename rewrites the paths below the folder, which takes a while in
+	// large trees
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
 	// Verify folder ownership
-	_, err := fs.GetUserFolder(userID, folderID)
+	folder, err := fs.GetUserFolder(ctx, userID, folderID)
 	if err != nil {
 		return nil, err
 	}
 
-	// Build updates based on request
 	updates := bson.M{"updated_at": time.Now()}
+	if req.Description != nil {
+		updates["description"] = *req.Description
+	}
+	if req.Color != nil {
+		updates["color"] = *req.Color
+	}
+	if req.Icon != nil {
+		updates["icon"] = *req.Icon
+	}
+	if req.Tags != nil {
+		updates["tags"] = req.Tags
+	}
 
-	// This would be implemented based on the actual request structure
-	// For now, we'll use a generic approach
-	if reqMap, ok := req.(*map[string]interface{}); ok {
-		for key, value := range *reqMap {
-			if key != "_id" && key != "user_id" && key != "created_at" {
-				updates[key] = value
-			}
+	// Renaming changes the path of the folder and everything below it
+	renamed := req.Name != nil && *req.Name != folder.Name
+	var newPath string
+	if renamed {
+		if err := fs.checkDuplicateFolderName(ctx, userID, *req.Name, folder.ParentID); err != nil {
+			return nil, err
+		}
+		newPath, _, err = fs.folderLineage(ctx, userID, *req.Name, folder.ParentID)
+		if err != nil {
+			return nil, err
 		}
+		updates["name"] = *req.Name
+		updates["path"] = newPath
 	}
 
 	// Update folder
@@ -195,22 +297,32 @@ func (fs *FolderService) UpdateFolder(userID, folderID primitive.ObjectID, req i
 		return nil, fmt.Errorf("failed to update folder: %v", err)
 	}
 
-	return fs.GetUserFolder(userID, folderID)
+	if renamed {
+		if err := fs.updateDescendantLineage(ctx, userID, folder, newPath, append(folder.Ancestors, folderID)); err != nil {
+			return nil, fmt.Errorf("failed to update subfolder paths: %v", err)
+		}
+	}
+
+	return fs.GetUserFolder(ctx, userID, folderID)
 }
 
 // DeleteFolder handles folder deletion (soft or hard)
-func (fs *FolderService) DeleteFolder(userID, folderID primitive.ObjectID, permanent bool) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+func (fs *FolderService) DeleteFolder(ctx context.Context, userID, folderID primitive.ObjectID, permanent bool) error {
+	if err := checkAccountWritable(ctx, fs.userCollection, userID); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
 	// Get folder
-	_, err := fs.GetUserFolder(userID, folderID)
+	folder, err := fs.GetUserFolder(ctx, userID, folderID)
 	if err != nil {
 		return err
 	}
 
 	if permanent {
-		// Hard delete - recursively delete all contents
+		// Hard delete - delete all contents
 		if err := fs.deleteAllFolderContents(ctx, userID, folderID); err != nil {
 			return fmt.Errorf("failed to delete folder contents: %v", err)
 		}
@@ -221,77 +333,168 @@ func (fs *FolderService) DeleteFolder(userID, folderID primitive.ObjectID, perma
 			return fmt.Errorf("failed to delete folder: %v", err)
 		}
 	} else {
-		// Soft delete - mark as deleted
-		_, err = fs.folderCollection.UpdateOne(ctx,
-			bson.M{"_id": folderID, "user_id": userID},
-			bson.M{"$set": bson.M{
-				"is_deleted": true,
-				"deleted_at": time.Now(),
-				"updated_at": time.Now(),
-			}},
-		)
-		if err != nil {
-			return fmt.Errorf("failed to mark folder as deleted: %v", err)
+		// Soft delete - mark the folder and everything below it as deleted
+		// together, so that restoring it brings back just those
+		if err := fs.softDeleteFolderTree(ctx, userID, folderID); err != nil {
+			return err
 		}
-
-		// Soft delete all files in folder
-		fs.fileCollection.UpdateMany(ctx,
-			bson.M{"folder_id": folderID, "user_id": userID},
-			bson.M{"$set": bson.M{
-				"is_deleted": true,
-				"deleted_at": time.Now(),
-			}},
-		)
-
-		// Soft delete all subfolders
-		fs.softDeleteSubfolders(ctx, userID, folderID)
 	}
 
+	// Either way the subtree no longer counts towards its ancestors
+	fs.folderUsage.AddSubtreeUsage(ctx, userID, folder.ParentID, -folder.TotalSize, -folder.TotalFiles)
+
 	// Update user folder count
-	fs.updateUserFolderCount(userID, -1)
+	fs.updateUserFolderCount(ctx, userID, -1)
 
 	return nil
 }
 
-// RestoreFolder restores a soft-deleted folder
-func (fs *FolderService) RestoreFolder(userID, folderID primitive.ObjectID) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+// RestoreFolder restores a soft-deleted folder together with the subfolders
+// and files deleted along with it. Items deleted on their own before the
+// folder stay in the trash. The folder goes back where it was deleted from,
+// or into the folder chosen in req; without a choice it goes to the root once
+// its parent is gone or in the trash. It is renamed when a folder of the same
+// name is already there.
+func (fs *FolderService) RestoreFolder(ctx context.Context, userID, folderID primitive.ObjectID, req *models.RestoreRequest) (*models.Folder, error) {
+	if err := checkAccountWritable(ctx, fs.userCollection, userID); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
 	defer cancel()
 
-	// Restore folder
-	_, err := fs.folderCollection.UpdateOne(ctx,
+	var folder models.Folder
+	err := fs.folderCollection.FindOne(ctx, bson.M{"_id": folderID, "user_id": userID, "is_deleted": true}).Decode(&folder)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotInTrash
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore folder: %v", err)
+	}
+
+	parentID, err := restoreTarget(ctx, fs.folderCollection, userID, folder.ParentID, req)
+	if err != nil {
+		return nil, err
+	}
+	if parentID != nil {
+		if err := fs.checkCircularReference(ctx, userID, folderID, *parentID); err != nil {
+			return nil, ErrRestoreTarget
+		}
+	}
+
+	siblings := bson.M{"user_id": userID, "is_deleted": false, "parent_id": bson.M{"$exists": false}}
+	if parentID != nil {
+		siblings["parent_id"] = *parentID
+	}
+	name, err := freeRestoreName(ctx, fs.folderCollection, siblings, folder.Name, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore folder: %v", err)
+	}
+
+	restore := bson.M{
+		"$set":   bson.M{"is_deleted": false, "updated_at": time.Now()},
+		"$unset": bson.M{"deleted_at": "", "deletion_id": ""},
+	}
+
+	// The folder itself may come back elsewhere or under another name
+	moved := (parentID == nil) != (folder.ParentID == nil) || (parentID != nil && *parentID != *folder.ParentID)
+	var newPath string
+	var newAncestors []primitive.ObjectID
+	rootSet := bson.M{"is_deleted": false, "updated_at": time.Now()}
+	rootUnset := bson.M{"deleted_at": "", "deletion_id": ""}
+	if moved || name != folder.Name {
+		newPath, newAncestors, err = fs.folderLineage(ctx, userID, name, parentID)
+		if err != nil {
+			return nil, err
+		}
+		rootSet["name"] = name
+		rootSet["path"] = newPath
+		rootSet["ancestors"] = newAncestors
+		if parentID != nil {
+			rootSet["parent_id"] = *parentID
+		} else {
+			rootUnset["parent_id"] = ""
+		}
+	}
+
+	result, err := fs.folderCollection.UpdateOne(ctx,
 		bson.M{"_id": folderID, "user_id": userID, "is_deleted": true},
-		bson.M{
-			"$set": bson.M{
-				"is_deleted": false,
-				"updated_at": time.Now(),
-			},
-			"$unset": bson.M{"deleted_at": ""},
-		},
+		bson.M{"$set": rootSet, "$unset": rootUnset},
 	)
 	if err != nil {
-		return fmt.Errorf("failed to restore folder: %v", err)
+		return nil, fmt.Errorf("failed to restore folder: %v", err)
+	}
+	if result.ModifiedCount == 0 {
+		return nil, ErrNotInTrash
 	}
 
-	// Restore files in folder
-	fs.fileCollection.UpdateMany(ctx,
-		bson.M{"folder_id": folderID, "user_id": userID, "is_deleted": true},
-		bson.M{
-			"$set":   bson.M{"is_deleted": false},
-			"$unset": bson.M{"deleted_at": ""},
-		},
-	)
+	if newPath != "" {
+		if err := fs.updateDescendantLineage(ctx, userID, &folder, newPath, append(newAncestors, folderID)); err != nil {
+			return nil, fmt.Errorf("failed to update subfolder paths: %v", err)
+		}
+	}
 
-	return nil
+	folderIDs := []primitive.ObjectID{folderID}
+	fileFilter := bson.M{"user_id": userID, "folder_id": folderID, "is_deleted": true}
+
+	if folder.DeletionID != nil {
+		// The part of the deletion at and below this folder; a subfolder can
+		// be restored without the folder it was deleted with
+		cursor, err := fs.folderCollection.Find(ctx,
+			bson.M{"user_id": userID, "deletion_id": *folder.DeletionID, "ancestors": folderID},
+			options.Find().SetProjection(bson.M{"_id": 1}),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find subfolders: %v", err)
+		}
+		var subfolders []models.Folder
+		if err := cursor.All(ctx, &subfolders); err != nil {
+			return nil, fmt.Errorf("failed to find subfolders: %v", err)
+		}
+		for _, subfolder := range subfolders {
+			folderIDs = append(folderIDs, subfolder.ID)
+		}
+
+		if _, err := fs.folderCollection.UpdateMany(ctx,
+			bson.M{"_id": bson.M{"$in": folderIDs[1:]}, "user_id": userID, "is_deleted": true},
+			restore,
+		); err != nil {
+			return nil, fmt.Errorf("failed to restore subfolders: %v", err)
+		}
+
+		fileFilter = bson.M{
+			"user_id":     userID,
+			"folder_id":   bson.M{"$in": folderIDs},
+			"deletion_id": *folder.DeletionID,
+		}
+	}
+
+	err = fs.usage.ApplyChange(ctx, userID, func(ctx context.Context) (int64, int, error) {
+		result, err := fs.fileCollection.UpdateMany(ctx, fileFilter, restore)
+		if err != nil {
+			return 0, 0, err
+		}
+		return 0, int(result.ModifiedCount), nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore files: %v", err)
+	}
+
+	// Which files and subfolders came back is easier to recount than track
+	if err := fs.folderUsage.RecalculateUsage(ctx, userID); err != nil {
+		log.Printf("Failed to recalculate folder usage: %v", err)
+	}
+
+	return fs.GetUserFolder(ctx, userID, folderID)
 }
 
 // GetFolderContents returns folder contents (files and subfolders)
-func (fs *FolderService) GetFolderContents(userID, folderID primitive.ObjectID, page, limit int, sortBy, sortOrder string) (map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (fs *FolderService) GetFolderContents(ctx context.Context, userID, folderID primitive.ObjectID, page, limit int, sortBy, sortOrder string) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	// Verify folder ownership
-	_, err := fs.GetUserFolder(userID, folderID)
+	_, err := fs.GetUserFolder(ctx, userID, folderID)
 	if err != nil {
 		return nil, err
 	}
@@ -324,67 +527,97 @@ func (fs *FolderService) GetFolderContents(userID, folderID primitive.ObjectID,
 	}, nil
 }
 
-// GetFolderTree returns hierarchical folder tree
-func (fs *FolderService) GetFolderTree(userID, rootFolderID primitive.ObjectID) (*models.FolderTree, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+// GetFolderTree returns the folder tree below rootFolderID, or below the
+// root when it is zero, depth levels deep or complete when depth is 0. The
+// whole subtree is fetched in one query on the folders' ancestors. page
+// and limit apply to the first level; deeper levels hold their first limit
+// subfolders and report how many more there are.
+func (fs *FolderService) GetFolderTree(ctx context.Context, userID, rootFolderID primitive.ObjectID, depth, page, limit int) (*models.FolderTree, error) {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
 	defer cancel()
 
 	// If rootFolderID is nil, start from root
 	var rootFolder *models.Folder
 	if !rootFolderID.IsZero() {
 		var err error
-		rootFolder, err = fs.GetUserFolder(userID, rootFolderID)
+		rootFolder, err = fs.GetUserFolder(ctx, userID, rootFolderID)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	// Build tree recursively
-	tree, err := fs.buildFolderTree(ctx, userID, rootFolder, 3) // Max depth of 3
+	folders, err := findFolderSubtree(ctx, fs.folderCollection, userID, rootFolder, depth,
+		options.Find().SetSort(bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}}),
+	)
 	if err != nil {
 		return nil, err
 	}
 
+	// Group the folders by parent; root folders are keyed by the nil ObjectID
+	children := make(map[primitive.ObjectID][]*models.Folder)
+	for i := range folders {
+		parentID := primitive.NilObjectID
+		if folders[i].ParentID != nil {
+			parentID = *folders[i].ParentID
+		}
+		children[parentID] = append(children[parentID], &folders[i])
+	}
+
+	tree := &models.FolderTree{Folder: rootFolder}
+	if rootFolder == nil {
+		attachFolderChildren(tree, primitive.NilObjectID, children, (page-1)*limit, limit)
+	} else {
+		attachFolderChildren(tree, rootFolder.ID, children, (page-1)*limit, limit)
+	}
+
 	return tree, nil
 }
 
 // GetBreadcrumb returns folder breadcrumb path
-func (fs *FolderService) GetBreadcrumb(userID, folderID primitive.ObjectID) ([]models.Folder, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (fs *FolderService) GetBreadcrumb(ctx context.Context, userID, folderID primitive.ObjectID) ([]models.Folder, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
-	var breadcrumb []models.Folder
-	currentFolderID := folderID
+	folder, err := fs.GetUserFolder(ctx, userID, folderID)
+	if err != nil {
+		return []models.Folder{}, nil
+	}
 
-	// Traverse up the folder hierarchy
-	for !currentFolderID.IsZero() {
-		var folder models.Folder
-		err := fs.folderCollection.FindOne(ctx, bson.M{
-			"_id":        currentFolderID,
-			"user_id":    userID,
-			"is_deleted": false,
-		}).Decode(&folder)
-		if err != nil {
-			break
-		}
+	cursor, err := fs.folderCollection.Find(ctx, bson.M{
+		"_id":        bson.M{"$in": folder.Ancestors},
+		"user_id":    userID,
+		"is_deleted": false,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var ancestors []models.Folder
+	if err := cursor.All(ctx, &ancestors); err != nil {
+		return nil, err
+	}
 
-		// Prepend to breadcrumb (so we get root -> ... -> current)
-		breadcrumb = append([]models.Folder{folder}, breadcrumb...)
+	byID := make(map[primitive.ObjectID]models.Folder, len(ancestors))
+	for _, ancestor := range ancestors {
+		byID[ancestor.ID] = ancestor
+	}
 
-		// Move to parent
-		if folder.ParentID != nil {
-			currentFolderID = *folder.ParentID
-		} else {
+	// Walk up from the parent, stopping at the first ancestor in the trash,
+	// and prepend so we get root -> ... -> current
+	breadcrumb := []models.Folder{*folder}
+	for i := len(folder.Ancestors) - 1; i >= 0; i-- {
+		ancestor, ok := byID[folder.Ancestors[i]]
+		if !ok {
 			break
 		}
+		breadcrumb = append([]models.Folder{ancestor}, breadcrumb...)
 	}
 
 	return breadcrumb, nil
 }
 
 // GetRootFolderContents returns root folder contents
-func (fs *FolderService) GetRootFolderContents(userID primitive.ObjectID, page, limit int) (map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (fs *FolderService) GetRootFolderContents(ctx context.Context, userID primitive.ObjectID, page, limit int) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	// Get root folders (folders without parent)
@@ -409,8 +642,8 @@ func (fs *FolderService) GetRootFolderContents(userID primitive.ObjectID, page,
 }
 
 // GetRecentFolders returns recently accessed folders
-func (fs *FolderService) GetRecentFolders(userID primitive.ObjectID, limit int) ([]models.Folder, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (fs *FolderService) GetRecentFolders(ctx context.Context, userID primitive.ObjectID, limit int) ([]models.Folder, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	cursor, err := fs.folderCollection.Find(ctx,
@@ -436,8 +669,8 @@ func (fs *FolderService) GetRecentFolders(userID primitive.ObjectID, limit int)
 }
 
 // GetFavoriteFolders returns user's favorite folders
-func (fs *FolderService) GetFavoriteFolders(userID primitive.ObjectID, page, limit int) ([]models.Folder, int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (fs *FolderService) GetFavoriteFolders(ctx context.Context, userID primitive.ObjectID, page, limit int) ([]models.Folder, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	skip := (page - 1) * limit
@@ -476,18 +709,25 @@ func (fs *FolderService) GetFavoriteFolders(userID primitive.ObjectID, page, lim
 	return folders, int(total), nil
 }
 
-// GetDeletedFolders returns deleted folders (trash)
-func (fs *FolderService) GetDeletedFolders(userID primitive.ObjectID, page, limit int) ([]models.Folder, int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+// GetDeletedFolders returns the folders in the user's trash, with where they
+// were deleted from. Subfolders deleted along with a folder are left out;
+// they are restored with it.
+func (fs *FolderService) GetDeletedFolders(ctx context.Context, userID primitive.ObjectID, page, limit int) ([]models.TrashedFolder, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	skip := (page - 1) * limit
 
-	cursor, err := fs.folderCollection.Find(ctx,
-		bson.M{
-			"user_id":    userID,
-			"is_deleted": true,
+	filter := bson.M{
+		"user_id":    userID,
+		"is_deleted": true,
+		"$or": bson.A{
+			bson.M{"deletion_id": bson.M{"$exists": false}},
+			bson.M{"$expr": bson.M{"$eq": bson.A{"$deletion_id", "$_id"}}},
 		},
+	}
+
+	cursor, err := fs.folderCollection.Find(ctx, filter,
 		options.Find().
 			SetSort(bson.M{"deleted_at": -1}).
 			SetSkip(int64(skip)).
@@ -504,24 +744,39 @@ func (fs *FolderService) GetDeletedFolders(userID primitive.ObjectID, page, limi
 	}
 
 	// Get total count
-	total, err := fs.folderCollection.CountDocuments(ctx, bson.M{
-		"user_id":    userID,
-		"is_deleted": true,
-	})
+	total, err := fs.folderCollection.CountDocuments(ctx, filter)
 	if err != nil {
 		return nil, 0, err
 	}
 
-	return folders, int(total), nil
+	parentIDs := make([]*primitive.ObjectID, len(folders))
+	for i := range folders {
+		parentIDs[i] = folders[i].ParentID
+	}
+	parents, err := trashLocations(ctx, fs.folderCollection, userID, parentIDs)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	trashed := make([]models.TrashedFolder, len(folders))
+	for i, folder := range folders {
+		trashed[i] = models.TrashedFolder{Folder: folder, OriginalLocation: trashLocation(parents, folder.ParentID)}
+	}
+
+	return trashed, int(total), nil
 }
 
 // Folder operations
-func (fs *FolderService) CopyFolder(userID, folderID primitive.ObjectID, destParentID, newName string) (*models.Folder, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+func (fs *FolderService) CopyFolder(ctx context.Context, userID, folderID primitive.ObjectID, destParentID, newName string) (*models.Folder, error) {
+	if err := checkAccountWritable(ctx, fs.userCollection, userID); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
 	// Get original folder
-	originalFolder, err := fs.GetUserFolder(userID, folderID)
+	originalFolder, err := fs.GetUserFolder(ctx, userID, folderID)
 	if err != nil {
 		return nil, err
 	}
@@ -531,7 +786,7 @@ func (fs *FolderService) CopyFolder(userID, folderID primitive.ObjectID, destPar
 	if destParentID != "" && utils.IsValidObjectID(destParentID) {
 		pid, _ := utils.StringToObjectID(destParentID)
 		destParentObjID = &pid
-		if err := fs.validateFolderOwnership(userID, pid); err != nil {
+		if err := fs.validateFolderOwnership(ctx, userID, pid); err != nil {
 			return nil, err
 		}
 	}
@@ -542,7 +797,12 @@ func (fs *FolderService) CopyFolder(userID, folderID primitive.ObjectID, destPar
 	}
 
 	// Check for duplicate name
-	if err := fs.checkDuplicateFolderName(userID, newName, destParentObjID); err != nil {
+	if err := fs.checkDuplicateFolderName(ctx, userID, newName, destParentObjID); err != nil {
+		return nil, err
+	}
+
+	// Refuse copies which cannot fit before copying anything
+	if err := fs.checkCopyLimits(ctx, userID, originalFolder); err != nil {
 		return nil, err
 	}
 
@@ -561,32 +821,79 @@ func (fs *FolderService) CopyFolder(userID, folderID primitive.ObjectID, destPar
 	}
 
 	// Generate new path
-	newFolder.Path, err = fs.generateFolderPath(userID, newName, destParentObjID)
+	newFolder.Path, newFolder.Ancestors, err = fs.folderLineage(ctx, userID, newName, destParentObjID)
 	if err != nil {
 		return nil, err
 	}
 
+	// The contents are copied in the background, tracked by a job
+	now := time.Now()
+	job := &models.FolderCopyJob{
+		ID:             primitive.NewObjectID(),
+		UserID:         userID,
+		SourceFolderID: folderID,
+		FolderID:       newFolder.ID,
+		Status:         models.FolderCopyRunning,
+		Errors:         []models.FolderCopyError{},
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	newFolder.CopyJobID = &job.ID
+
 	// Insert new folder
 	_, err = fs.folderCollection.InsertOne(ctx, newFolder)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create folder copy: %v", err)
 	}
 
+	if _, err := fs.copyJobCollection.InsertOne(ctx, job); err != nil {
+		return nil, fmt.Errorf("failed to start folder copy: %v", err)
+	}
+
 	// Copy all contents recursively
-	go fs.copyFolderContentsAsync(userID, folderID, newFolder.ID)
+	go fs.copyFolderContents(job)
 
 	// Update user folder count
-	fs.updateUserFolderCount(userID, 1)
+	fs.updateUserFolderCount(ctx, userID, 1)
 
 	return newFolder, nil
 }
 
-func (fs *FolderService) MoveFolder(userID, folderID primitive.ObjectID, destParentID string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+// checkCopyLimits checks that copying folder's contents stays within the
+// user's storage and file limits
+func (fs *FolderService) checkCopyLimits(ctx context.Context, userID primitive.ObjectID, folder *models.Folder) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	plan, err := NewFileService().GetUserPlan(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to get user plan: %v", err)
+	}
+	var user models.User
+	if err := fs.userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+		return fmt.Errorf("user not found: %v", err)
+	}
+
+	if user.StorageUsed+folder.TotalSize > plan.StorageLimit {
+		return fmt.Errorf("%w: copy would exceed storage limit of %s", ErrFolderCopyLimit, utils.FormatFileSize(plan.StorageLimit))
+	}
+	if plan.FilesLimit > 0 && user.FilesCount+folder.TotalFiles > plan.FilesLimit {
+		return fmt.Errorf("%w: copy would exceed file limit of %d", ErrFolderCopyLimit, plan.FilesLimit)
+	}
+	return nil
+}
+
+func (fs *FolderService) MoveFolder(ctx context.Context, userID, folderID primitive.ObjectID, destParentID string) error {
+	if err := checkAccountWritable(ctx, fs.userCollection, userID); err != nil {
+		return err
+	}
+
+	// Large trees take a while to rewrite
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
 	// Get folder
-	folder, err := fs.GetUserFolder(userID, folderID)
+	folder, err := fs.GetUserFolder(ctx, userID, folderID)
 	if err != nil {
 		return err
 	}
@@ -597,23 +904,32 @@ func (fs *FolderService) MoveFolder(userID, folderID primitive.ObjectID, destPar
 		pid, _ := utils.StringToObjectID(destParentID)
 		destParentObjID = &pid
 
+		// Moving into the folder it is already in changes nothing
+		if folder.ParentID != nil && *folder.ParentID == pid {
+			return nil
+		}
+
 		// Check for circular reference
-		if err := fs.checkCircularReference(userID, folderID, pid); err != nil {
+		if err := fs.checkCircularReference(ctx, userID, folderID, pid); err != nil {
 			return err
 		}
 
-		if err := fs.validateFolderOwnership(userID, pid); err != nil {
+		if err := fs.validateFolderOwnership(ctx, userID, pid); err != nil {
 			return err
 		}
 	}
 
+	if destParentObjID == nil && folder.ParentID == nil {
+		return nil
+	}
+
 	// Check for duplicate name in destination
-	if err := fs.checkDuplicateFolderName(userID, folder.Name, destParentObjID); err != nil {
+	if err := fs.checkDuplicateFolderName(ctx, userID, folder.Name, destParentObjID); err != nil {
 		return err
 	}
 
 	// Generate new path
-	newPath, err := fs.generateFolderPath(userID, folder.Name, destParentObjID)
+	newPath, newAncestors, err := fs.folderLineage(ctx, userID, folder.Name, destParentObjID)
 	if err != nil {
 		return err
 	}
@@ -621,45 +937,59 @@ func (fs *FolderService) MoveFolder(userID, folderID primitive.ObjectID, destPar
 	// Update folder
 	updates := bson.M{
 		"path":       newPath,
+		"ancestors":  newAncestors,
 		"updated_at": time.Now(),
 	}
+	update := bson.M{"$set": updates}
 
 	if destParentObjID != nil {
 		updates["parent_id"] = *destParentObjID
 	} else {
-		updates["$unset"] = bson.M{"parent_id": ""}
+		update["$unset"] = bson.M{"parent_id": ""}
 	}
 
 	_, err = fs.folderCollection.UpdateOne(ctx,
 		bson.M{"_id": folderID, "user_id": userID},
-		bson.M{"$set": updates},
+		update,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to move folder: %v", err)
 	}
 
+	fs.folderUsage.AddSubtreeUsage(ctx, userID, folder.ParentID, -folder.TotalSize, -folder.TotalFiles)
+	fs.folderUsage.AddSubtreeUsage(ctx, userID, destParentObjID, folder.TotalSize, folder.TotalFiles)
+
 	// Update paths of all subfolders
-	go fs.updateSubfolderPathsAsync(userID, folderID, newPath)
+	if err := fs.updateDescendantLineage(ctx, userID, folder, newPath, append(newAncestors, folderID)); err != nil {
+		return fmt.Errorf("failed to update subfolder paths: %v", err)
+	}
 
 	return nil
 }
 
-func (fs *FolderService) ToggleFavorite(userID, folderID primitive.ObjectID, isFavorite bool) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (fs *FolderService) ToggleFavorite(ctx context.Context, userID, folderID primitive.ObjectID, isFavorite bool) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
+	// favorited_at orders the favorites list by when items were starred
+	now := time.Now()
+	update := bson.M{"$set": bson.M{"is_favorite": true, "favorited_at": now, "updated_at": now}}
+	if !isFavorite {
+		update = bson.M{
+			"$set":   bson.M{"is_favorite": false, "updated_at": now},
+			"$unset": bson.M{"favorited_at": ""},
+		}
+	}
+
 	_, err := fs.folderCollection.UpdateOne(ctx,
 		bson.M{"_id": folderID, "user_id": userID},
-		bson.M{"$set": bson.M{
-			"is_favorite": isFavorite,
-			"updated_at":  time.Now(),
-		}},
+		update,
 	)
 	return err
 }
 
-func (fs *FolderService) UpdateTags(userID, folderID primitive.ObjectID, tags []string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (fs *FolderService) UpdateTags(ctx context.Context, userID, folderID primitive.ObjectID, tags []string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	_, err := fs.folderCollection.UpdateOne(ctx,
@@ -673,15 +1003,22 @@ func (fs *FolderService) UpdateTags(userID, folderID primitive.ObjectID, tags []
 }
 
 // Folder sharing
-func (fs *FolderService) CreateShare(userID, folderID primitive.ObjectID, req *models.ShareRequest) (*models.FileShare, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (fs *FolderService) CreateShare(ctx context.Context, userID, folderID primitive.ObjectID, req *models.ShareRequest) (*models.FileShare, error) {
+	if err := checkAccountWritable(ctx, fs.userCollection, userID); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	// Verify folder ownership
-	_, err := fs.GetUserFolder(userID, folderID)
+	folder, err := fs.GetUserFolder(ctx, userID, folderID)
 	if err != nil {
 		return nil, err
 	}
+	if err := checkTakedown(folder.TakedownID); err != nil {
+		return nil, err
+	}
 
 	// Generate share token
 	shareToken, err := utils.GenerateSecureToken(32)
@@ -729,8 +1066,8 @@ func (fs *FolderService) CreateShare(userID, folderID primitive.ObjectID, req *m
 	return share, nil
 }
 
-func (fs *FolderService) GetShare(userID, folderID primitive.ObjectID) (*models.FileShare, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (fs *FolderService) GetShare(ctx context.Context, userID, folderID primitive.ObjectID) (*models.FileShare, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	var share models.FileShare
@@ -746,8 +1083,12 @@ func (fs *FolderService) GetShare(userID, folderID primitive.ObjectID) (*models.
 	return &share, nil
 }
 
-func (fs *FolderService) UpdateShare(userID, folderID primitive.ObjectID, req *models.ShareRequest) (*models.FileShare, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (fs *FolderService) UpdateShare(ctx context.Context, userID, folderID primitive.ObjectID, req *models.ShareRequest) (*models.FileShare, error) {
+	if err := checkAccountWritable(ctx, fs.userCollection, userID); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	updates := bson.M{"updated_at": time.Now()}
@@ -774,11 +1115,11 @@ func (fs *FolderService) UpdateShare(userID, folderID primitive.ObjectID, req *m
 		return nil, fmt.Errorf("failed to update share: %v", err)
 	}
 
-	return fs.GetShare(userID, folderID)
+	return fs.GetShare(ctx, userID, folderID)
 }
 
-func (fs *FolderService) DeleteShare(userID, folderID primitive.ObjectID) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (fs *FolderService) DeleteShare(ctx context.Context, userID, folderID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	// Delete share record
@@ -804,126 +1145,75 @@ func (fs *FolderService) DeleteShare(userID, folderID primitive.ObjectID) error
 	return err
 }
 
-func (fs *FolderService) GetShareURL(userID, folderID primitive.ObjectID) (string, error) {
-	share, err := fs.GetShare(userID, folderID)
+func (fs *FolderService) GetShareURL(ctx context.Context, userID, folderID primitive.ObjectID) (string, error) {
+	share, err := fs.GetShare(ctx, userID, folderID)
 	if err != nil {
 		return "", err
 	}
 
-	// Generate share URL
-	baseURL := os.Getenv("BASE_URL")
-	shareURL := fmt.Sprintf("%s/shared/folder/%s", baseURL, share.Token)
-
-	return shareURL, nil
+	return ShareLinkURL(ctx, share, ShareKindFolder), nil
 }
 
 // Folder statistics
-func (fs *FolderService) GetFolderStats(userID, folderID primitive.ObjectID) (map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (fs *FolderService) GetFolderStats(ctx context.Context, userID, folderID primitive.ObjectID) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	return fs.calculateFolderStats(ctx, userID, folderID)
 }
 
-func (fs *FolderService) GetFolderSize(userID, folderID primitive.ObjectID) (int64, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	// Calculate total size recursively
-	totalSize, err := fs.calculateFolderSizeRecursive(ctx, userID, folderID)
+// GetFolderSize returns the size of a folder including its subfolders, as
+// kept up to date by FolderUsageService
+func (fs *FolderService) GetFolderSize(ctx context.Context, userID, folderID primitive.ObjectID) (int64, error) {
+	folder, err := fs.GetUserFolder(ctx, userID, folderID)
 	if err != nil {
 		return 0, err
 	}
 
-	return totalSize, nil
-}
-
-// Bulk operations
-func (fs *FolderService) BulkDeleteFolders(userID primitive.ObjectID, folderIDs []primitive.ObjectID) (map[string]interface{}, error) {
-	results := map[string]interface{}{
-		"success": 0,
-		"failed":  0,
-		"errors":  []string{},
-	}
-
-	for _, folderID := range folderIDs {
-		err := fs.DeleteFolder(userID, folderID, false)
-		if err != nil {
-			results["failed"] = results["failed"].(int) + 1
-			results["errors"] = append(results["errors"].([]string), err.Error())
-		} else {
-			results["success"] = results["success"].(int) + 1
-		}
-	}
-
-	return results, nil
+	return folder.TotalSize, nil
 }
 
-func (fs *FolderService) BulkMoveFolders(userID primitive.ObjectID, folderIDs []primitive.ObjectID, destParentID string) (map[string]interface{}, error) {
-	results := map[string]interface{}{
-		"success": 0,
-		"failed":  0,
-		"errors":  []string{},
-	}
-
-	for _, folderID := range folderIDs {
-		err := fs.MoveFolder(userID, folderID, destParentID)
-		if err != nil {
-			results["failed"] = results["failed"].(int) + 1
-			results["errors"] = append(results["errors"].([]string), err.Error())
-		} else {
-			results["success"] = results["success"].(int) + 1
-		}
-	}
-
-	return results, nil
-}
+// BatchGetFolders fetches the user's folders among folderIDs in one query.
+// IDs of folders which do not exist, are in the trash or belong to someone
+// else are returned as missing.
+func (fs *FolderService) BatchGetFolders(ctx context.Context, userID primitive.ObjectID, folderIDs []primitive.ObjectID) (*models.FolderBatch, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
 
-func (fs *FolderService) BulkCopyFolders(userID primitive.ObjectID, folderIDs []primitive.ObjectID, destParentID string) (map[string]interface{}, error) {
-	results := map[string]interface{}{
-		"success": 0,
-		"failed":  0,
-		"errors":  []string{},
+	folderIDs = uniqueObjectIDs(folderIDs)
+	cursor, err := fs.folderCollection.Find(ctx, bson.M{
+		"_id":        bson.M{"$in": folderIDs},
+		"user_id":    userID,
+		"is_deleted": false,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get folders: %v", err)
 	}
+	defer cursor.Close(ctx)
 
-	for _, folderID := range folderIDs {
-		_, err := fs.CopyFolder(userID, folderID, destParentID, "")
-		if err != nil {
-			results["failed"] = results["failed"].(int) + 1
-			results["errors"] = append(results["errors"].([]string), err.Error())
-		} else {
-			results["success"] = results["success"].(int) + 1
-		}
+	var folders []models.Folder
+	if err := cursor.All(ctx, &folders); err != nil {
+		return nil, fmt.Errorf("failed to get folders: %v", err)
 	}
-
-	return results, nil
-}
-
-func (fs *FolderService) BulkShareFolders(userID primitive.ObjectID, folderIDs []primitive.ObjectID, shareData *models.ShareRequest) (map[string]interface{}, error) {
-	results := map[string]interface{}{
-		"success": 0,
-		"failed":  0,
-		"errors":  []string{},
-		"shares":  []string{},
+	byID := make(map[primitive.ObjectID]models.Folder, len(folders))
+	for _, folder := range folders {
+		byID[folder.ID] = folder
 	}
 
-	for _, folderID := range folderIDs {
-		share, err := fs.CreateShare(userID, folderID, shareData)
-		if err != nil {
-			results["failed"] = results["failed"].(int) + 1
-			results["errors"] = append(results["errors"].([]string), err.Error())
+	batch := &models.FolderBatch{Found: []models.Folder{}, Missing: []string{}}
+	for _, id := range folderIDs {
+		if folder, ok := byID[id]; ok {
+			batch.Found = append(batch.Found, folder)
 		} else {
-			results["success"] = results["success"].(int) + 1
-			results["shares"] = append(results["shares"].([]string), share.Token)
+			batch.Missing = append(batch.Missing, id.Hex())
 		}
 	}
-
-	return results, nil
+	return batch, nil
 }
 
 // Public folder access
-func (fs *FolderService) GetPublicFolderContents(token string) (map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (fs *FolderService) GetPublicFolderContents(ctx context.Context, token string) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	// Find folder by token
@@ -947,6 +1237,7 @@ func (fs *FolderService) GetPublicFolderContents(token string) (map[string]inter
 	if err != nil {
 		return nil, err
 	}
+	hideUploaders(files)
 
 	return map[string]interface{}{
 		"folder":     folder,
@@ -955,8 +1246,8 @@ func (fs *FolderService) GetPublicFolderContents(token string) (map[string]inter
 	}, nil
 }
 
-func (fs *FolderService) GetSharedFolderContents(token string) (map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (fs *FolderService) GetSharedFolderContents(ctx context.Context, token string) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	// Find share by token
@@ -981,7 +1272,7 @@ func (fs *FolderService) GetSharedFolderContents(token string) (map[string]inter
 		"is_deleted": false,
 	}).Decode(&folder)
 	if err != nil {
-		return nil, fmt.Errorf("folder not found: %v", err)
+		return nil, findError(err, ErrFolderNotFound)
 	}
 
 	// Get folder contents
@@ -994,6 +1285,7 @@ func (fs *FolderService) GetSharedFolderContents(token string) (map[string]inter
 	if err != nil {
 		return nil, err
 	}
+	hideUploaders(files)
 
 	return map[string]interface{}{
 		"folder":     folder,
@@ -1003,9 +1295,167 @@ func (fs *FolderService) GetSharedFolderContents(token string) (map[string]inter
 	}, nil
 }
 
+// Search inside shared folders
+
+// SearchSharedFolder searches the folder behind a share link. Matches are
+// limited to the shared folder and its subfolders.
+func (fs *FolderService) SearchSharedFolder(ctx context.Context, token, password, query string, limit int) (*models.SharedFolderSearch, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var share models.FileShare
+	err := fs.shareCollection.FindOne(ctx, bson.M{
+		"token":     token,
+		"is_active": true,
+	}).Decode(&share)
+	if err != nil {
+		return nil, ErrSharedFolderNotFound
+	}
+	if share.ExpiresAt != nil && share.ExpiresAt.Before(time.Now()) {
+		return nil, ErrSharedFolderNotFound
+	}
+	if share.Password != "" && !utils.CheckPasswordHash(password, share.Password) {
+		return nil, ErrSharePasswordRequired
+	}
+
+	var folder models.Folder
+	err = fs.folderCollection.FindOne(ctx, bson.M{
+		"_id":        share.FileID, // Using file_id field for folder_id
+		"user_id":    share.UserID,
+		"is_deleted": false,
+	}).Decode(&folder)
+	if err != nil {
+		return nil, ErrSharedFolderNotFound
+	}
+
+	return fs.searchFolderTree(ctx, &folder, query, limit)
+}
+
+// SearchPublicFolder searches a public folder and its subfolders
+func (fs *FolderService) SearchPublicFolder(ctx context.Context, token, query string, limit int) (*models.SharedFolderSearch, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var folder models.Folder
+	err := fs.folderCollection.FindOne(ctx, bson.M{
+		"share_token": token,
+		"is_public":   true,
+		"is_deleted":  false,
+	}).Decode(&folder)
+	if err != nil {
+		return nil, ErrSharedFolderNotFound
+	}
+
+	return fs.searchFolderTree(ctx, &folder, query, limit)
+}
+
+// searchFolderTree finds the folders and files below root whose name or tags
+// match query. Every match is checked against the tree before it is returned.
+func (fs *FolderService) searchFolderTree(ctx context.Context, root *models.Folder, query string, limit int) (*models.SharedFolderSearch, error) {
+	pattern := primitive.Regex{Pattern: regexp.QuoteMeta(query), Options: "i"}
+	matcher := regexp.MustCompile("(?i)" + regexp.QuoteMeta(query))
+
+	// Folders of the tree, which scope the file search
+	cursor, err := fs.folderCollection.Find(ctx, bson.M{
+		"user_id":    root.UserID,
+		"ancestors":  root.ID,
+		"is_deleted": false,
+	}, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var subfolders []models.Folder
+	if err = cursor.All(ctx, &subfolders); err != nil {
+		return nil, err
+	}
+
+	inTree := map[primitive.ObjectID]bool{root.ID: true}
+	for _, subfolder := range subfolders {
+		inTree[subfolder.ID] = true
+	}
+
+	result := &models.SharedFolderSearch{
+		Folder:  *root,
+		Query:   query,
+		Folders: []models.Folder{},
+		Files:   []models.File{},
+	}
+	for _, subfolder := range subfolders {
+		if len(result.Folders) == limit {
+			break
+		}
+		if folderMatches(&subfolder, matcher) && isBelowFolder(&subfolder, root) {
+			result.Folders = append(result.Folders, subfolder)
+		}
+	}
+
+	treeIDs := make([]primitive.ObjectID, 0, len(inTree))
+	for id := range inTree {
+		treeIDs = append(treeIDs, id)
+	}
+	cursor, err = fs.fileCollection.Find(ctx,
+		bson.M{
+			"user_id":    root.UserID,
+			"folder_id":  bson.M{"$in": treeIDs},
+			"is_deleted": false,
+			"$or": []bson.M{
+				{"name": pattern},
+				{"original_name": pattern},
+				{"display_name": pattern},
+				{"tags": pattern},
+			},
+		},
+		options.Find().
+			SetSort(bson.M{"name": 1}).
+			SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var files []models.File
+	if err = cursor.All(ctx, &files); err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if file.UserID == root.UserID && file.FolderID != nil && inTree[*file.FolderID] && !file.IsDeleted {
+			file.Uploader = nil
+			result.Files = append(result.Files, file)
+		}
+	}
+
+	return result, nil
+}
+
+// folderMatches reports whether the name, description or a tag of folder
+// matches re
+func folderMatches(folder *models.Folder, re *regexp.Regexp) bool {
+	if re.MatchString(folder.Name) || re.MatchString(folder.Description) {
+		return true
+	}
+	for _, tag := range folder.Tags {
+		if re.MatchString(tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// isBelowFolder reports whether folder lies inside root's tree
+func isBelowFolder(folder, root *models.Folder) bool {
+	if folder.UserID != root.UserID || folder.IsDeleted {
+		return false
+	}
+	for _, ancestor := range folder.Ancestors {
+		if ancestor == root.ID {
+			return true
+		}
+	}
+	return false
+}
+
 // Helper methods
-func (fs *FolderService) validateFolderOwnership(userID, folderID primitive.ObjectID) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (fs *FolderService) validateFolderOwnership(ctx context.Context, userID, folderID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	var folder models.Folder
@@ -1020,8 +1470,8 @@ func (fs *FolderService) validateFolderOwnership(userID, folderID primitive.Obje
 	return nil
 }
 
-func (fs *FolderService) checkDuplicateFolderName(userID primitive.ObjectID, name string, parentID *primitive.ObjectID) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (fs *FolderService) checkDuplicateFolderName(ctx context.Context, userID primitive.ObjectID, name string, parentID *primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	filter := bson.M{
@@ -1048,46 +1498,47 @@ func (fs *FolderService) checkDuplicateFolderName(userID primitive.ObjectID, nam
 	return nil
 }
 
-func (fs *FolderService) generateFolderPath(userID primitive.ObjectID, folderName string, parentID *primitive.ObjectID) (string, error) {
+// folderLineage returns the path and ancestors of a folder named
+// folderName placed in parentID
+func (fs *FolderService) folderLineage(ctx context.Context, userID primitive.ObjectID, folderName string, parentID *primitive.ObjectID) (string, []primitive.ObjectID, error) {
 	if parentID == nil {
-		return "/" + folderName, nil
+		return "/" + folderName, []primitive.ObjectID{}, nil
 	}
 
 	// Get parent path
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	var parent models.Folder
 	err := fs.folderCollection.FindOne(ctx, bson.M{"_id": *parentID}).Decode(&parent)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
-	return parent.Path + "/" + folderName, nil
+	ancestors := make([]primitive.ObjectID, 0, len(parent.Ancestors)+1)
+	ancestors = append(append(ancestors, parent.Ancestors...), parent.ID)
+	return parent.Path + "/" + folderName, ancestors, nil
 }
 
-func (fs *FolderService) checkCircularReference(userID, folderID, newParentID primitive.ObjectID) error {
+func (fs *FolderService) checkCircularReference(ctx context.Context, userID, folderID, newParentID primitive.ObjectID) error {
 	// Check if newParentID is a descendant of folderID
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	currentParentID := newParentID
-	for !currentParentID.IsZero() {
-		if currentParentID == folderID {
-			return errors.New("cannot move folder into its own subfolder")
-		}
-
-		var parent models.Folder
-		err := fs.folderCollection.FindOne(ctx, bson.M{"_id": currentParentID}).Decode(&parent)
-		if err != nil {
-			break
-		}
+	if newParentID == folderID {
+		return errors.New("cannot move folder into its own subfolder")
+	}
 
-		if parent.ParentID != nil {
-			currentParentID = *parent.ParentID
-		} else {
-			break
-		}
+	count, err := fs.folderCollection.CountDocuments(ctx, bson.M{
+		"_id":       newParentID,
+		"user_id":   userID,
+		"ancestors": folderID,
+	})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("cannot move folder into its own subfolder")
 	}
 
 	return nil
@@ -1120,6 +1571,14 @@ func (fs *FolderService) getFolderSubfolders(ctx context.Context, userID, folder
 	return subfolders, nil
 }
 
+// hideUploaders drops the guests who sent files through a file request from
+// files shown outside the owner's account
+func hideUploaders(files []models.File) {
+	for i := range files {
+		files[i].Uploader = nil
+	}
+}
+
 func (fs *FolderService) getFolderFiles(ctx context.Context, userID, folderID primitive.ObjectID, page, limit int, sortBy, sortOrder string) ([]models.File, int, error) {
 	sort := bson.M{sortBy: 1}
 	if sortOrder == "desc" {
@@ -1281,190 +1740,452 @@ func (fs *FolderService) calculateFolderStats(ctx context.Context, userID, folde
 	}, nil
 }
 
-func (fs *FolderService) calculateFolderSizeRecursive(ctx context.Context, userID, folderID primitive.ObjectID) (int64, error) {
-	// Get direct files size
-	pipeline := []bson.M{
-		{"$match": bson.M{
-			"user_id":    userID,
-			"folder_id":  folderID,
-			"is_deleted": false,
-		}},
-		{"$group": bson.M{
-			"_id":   nil,
-			"total": bson.M{"$sum": "$size"},
-		}},
+// attachFolderChildren adds up to limit of parentID's subfolders, starting
+// at skip, to node and recursively their own first limit subfolders
+func attachFolderChildren(node *models.FolderTree, parentID primitive.ObjectID, children map[primitive.ObjectID][]*models.Folder, skip, limit int) {
+	subfolders := children[parentID]
+	node.ChildrenCount = len(subfolders)
+
+	if skip > len(subfolders) {
+		skip = len(subfolders)
+	}
+	end := skip + limit
+	if end > len(subfolders) {
+		end = len(subfolders)
 	}
+	node.HasMore = end < len(subfolders)
 
-	cursor, err := fs.fileCollection.Aggregate(ctx, pipeline)
+	for _, subfolder := range subfolders[skip:end] {
+		child := &models.FolderTree{Folder: subfolder}
+		attachFolderChildren(child, subfolder.ID, children, 0, limit)
+		node.Children = append(node.Children, child)
+	}
+}
+
+// findFolderSubtree returns the user's live folders below root, or all of
+// them when root is nil, down to depth levels or all when depth is 0. It
+// relies on the stored ancestors instead of walking the tree level by
+// level.
+func findFolderSubtree(ctx context.Context, collection *mongo.Collection, userID primitive.ObjectID, root *models.Folder, depth int, opts ...*options.FindOptions) ([]models.Folder, error) {
+	filter := bson.M{"user_id": userID, "is_deleted": false}
+	base := 0
+	if root != nil {
+		filter["ancestors"] = root.ID
+		base = len(root.Ancestors) + 1
+	}
+	if depth > 0 {
+		// A folder depth levels down has base+depth-1 ancestors
+		filter[fmt.Sprintf("ancestors.%d", base+depth-1)] = bson.M{"$exists": false}
+	}
+
+	cursor, err := collection.Find(ctx, filter, opts...)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 	defer cursor.Close(ctx)
 
-	var result []bson.M
-	if err = cursor.All(ctx, &result); err != nil {
-		return 0, err
+	var folders []models.Folder
+	if err := cursor.All(ctx, &folders); err != nil {
+		return nil, err
 	}
+	return folders, nil
+}
 
-	directSize := int64(0)
-	if len(result) > 0 {
-		if size, ok := result[0]["total"].(int64); ok {
-			directSize = size
-		}
-	}
+func (fs *FolderService) updateUserFolderCount(ctx context.Context, userID primitive.ObjectID, change int) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
 
-	// Get subfolders
-	subfolders, err := fs.getFolderSubfolders(ctx, userID, folderID, "name", "asc")
+	fs.userCollection.UpdateOne(ctx,
+		bson.M{"_id": userID},
+		bson.M{"$inc": bson.M{"folders_count": change}},
+	)
+}
+
+func (fs *FolderService) deleteAllFolderContents(ctx context.Context, userID, folderID primitive.ObjectID) error {
+	// Find all subfolders, however deep
+	cursor, err := fs.folderCollection.Find(ctx,
+		bson.M{"user_id": userID, "ancestors": folderID},
+		options.Find().SetProjection(bson.M{"_id": 1}),
+	)
 	if err != nil {
-		return directSize, err
+		return err
+	}
+	var subfolders []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &subfolders); err != nil {
+		return err
 	}
 
-	// Calculate subfolder sizes recursively
+	folderIDs := make([]primitive.ObjectID, 0, len(subfolders)+1)
+	folderIDs = append(folderIDs, folderID)
 	for _, subfolder := range subfolders {
-		subfolderSize, err := fs.calculateFolderSizeRecursive(ctx, userID, subfolder.ID)
+		folderIDs = append(folderIDs, subfolder.ID)
+	}
+
+	// Delete all files in the folder and its subfolders, taking them and
+	// their older versions off the user's usage
+	err = fs.usage.ApplyChange(ctx, userID, func(ctx context.Context) (int64, int, error) {
+		filter := bson.M{"user_id": userID, "folder_id": bson.M{"$in": folderIDs}}
+		cursor, err := fs.fileCollection.Find(ctx, filter,
+			options.Find().SetProjection(bson.M{"_id": 1, "size": 1, "is_deleted": 1}),
+		)
 		if err != nil {
-			continue // Skip on error
+			return 0, 0, err
 		}
-		directSize += subfolderSize
+		var files []models.File
+		if err := cursor.All(ctx, &files); err != nil {
+			return 0, 0, err
+		}
+
+		fileIDs := make([]primitive.ObjectID, len(files))
+		storage, live := int64(0), 0
+		for i, file := range files {
+			fileIDs[i] = file.ID
+			storage += file.Size
+			if !file.IsDeleted {
+				live++
+			}
+		}
+		versions, err := fs.usage.versionBytes(ctx, fileIDs)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		if _, err := fs.fileCollection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": fileIDs}}); err != nil {
+			return 0, 0, err
+		}
+		return -(storage + versions), -live, nil
+	})
+	if err != nil {
+		return err
 	}
 
-	return directSize, nil
+	// Delete all subfolders
+	_, err = fs.folderCollection.DeleteMany(ctx, bson.M{
+		"user_id":   userID,
+		"ancestors": folderID,
+	})
+
+	return err
 }
 
-func (fs *FolderService) buildFolderTree(ctx context.Context, userID primitive.ObjectID, rootFolder *models.Folder, maxDepth int) (*models.FolderTree, error) {
-	if maxDepth <= 0 {
-		return nil, nil
+// softDeleteFolderTree marks a folder, its live subfolders however deep and
+// the live files in all of them as deleted under one deletion ID
+func (fs *FolderService) softDeleteFolderTree(ctx context.Context, userID, folderID primitive.ObjectID) error {
+	cursor, err := fs.folderCollection.Find(ctx,
+		bson.M{"user_id": userID, "ancestors": folderID, "is_deleted": false},
+		options.Find().SetProjection(bson.M{"_id": 1}),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to find subfolders: %v", err)
+	}
+	var subfolders []models.Folder
+	if err := cursor.All(ctx, &subfolders); err != nil {
+		return fmt.Errorf("failed to find subfolders: %v", err)
 	}
 
-	var tree *models.FolderTree
-	var folderID primitive.ObjectID
+	folderIDs := []primitive.ObjectID{folderID}
+	for _, subfolder := range subfolders {
+		folderIDs = append(folderIDs, subfolder.ID)
+	}
+
+	// The deletion is named after the folder deleted, which tells it apart
+	// from the subfolders deleted along with it
+	now := time.Now()
+	deleted := bson.M{"$set": bson.M{
+		"is_deleted":  true,
+		"deleted_at":  now,
+		"deletion_id": folderID,
+		"updated_at":  now,
+	}}
+
+	// Files first, so a failure leaves the folders in place
+	err = fs.usage.ApplyChange(ctx, userID, func(ctx context.Context) (int64, int, error) {
+		result, err := fs.fileCollection.UpdateMany(ctx,
+			bson.M{"user_id": userID, "folder_id": bson.M{"$in": folderIDs}, "is_deleted": false},
+			deleted,
+		)
+		if err != nil {
+			return 0, 0, err
+		}
+		return 0, -int(result.ModifiedCount), nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to mark files as deleted: %v", err)
+	}
 
-	if rootFolder != nil {
-		tree = &models.FolderTree{Folder: rootFolder}
-		folderID = rootFolder.ID
-	} else {
-		// Root level
-		tree = &models.FolderTree{}
+	if _, err := fs.folderCollection.UpdateMany(ctx,
+		bson.M{"user_id": userID, "_id": bson.M{"$in": folderIDs}, "is_deleted": false},
+		deleted,
+	); err != nil {
+		return fmt.Errorf("failed to mark folder as deleted: %v", err)
 	}
 
-	// Get subfolders
+	return nil
+}
+
+// copyFolderContents fills the folder copied by job with copies of the
+// source folder's subfolders and files, recording progress as it goes
+func (fs *FolderService) copyFolderContents(job *models.FolderCopyJob) {
+	set := bson.M{"status": models.FolderCopyCompleted}
+	if err := fs.runFolderCopy(context.Background(), job); err != nil {
+		log.Printf("Folder copy %s failed: %v", job.ID.Hex(), err)
+		set["status"] = models.FolderCopyFailed
+		set["error"] = err.Error()
+	}
+	set["completed_at"] = time.Now()
+	fs.updateCopyJob(context.Background(), job.ID, bson.M{"$set": set})
+}
+
+func (fs *FolderService) runFolderCopy(ctx context.Context, job *models.FolderCopyJob) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	// Take the source tree as it is now. The copy may have been placed
+	// inside it, so it is left out.
+	cursor, err := fs.folderCollection.Find(ctx, bson.M{
+		"user_id":    job.UserID,
+		"is_deleted": false,
+		"$and": bson.A{
+			bson.M{"ancestors": job.SourceFolderID},
+			bson.M{"ancestors": bson.M{"$ne": job.FolderID}},
+		},
+		"_id": bson.M{"$ne": job.FolderID},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list subfolders: %v", err)
+	}
 	var subfolders []models.Folder
-	var err error
+	if err := cursor.All(ctx, &subfolders); err != nil {
+		return fmt.Errorf("failed to list subfolders: %v", err)
+	}
+	// Parents before their children
+	sort.SliceStable(subfolders, func(i, j int) bool {
+		return len(subfolders[i].Ancestors) < len(subfolders[j].Ancestors)
+	})
 
-	if rootFolder == nil {
-		// Get root folders
-		subfolders, err = fs.getRootFolders(ctx, userID)
-	} else {
-		subfolders, err = fs.getFolderSubfolders(ctx, userID, folderID, "name", "asc")
+	sourceIDs := make([]primitive.ObjectID, 0, len(subfolders)+1)
+	sourceIDs = append(sourceIDs, job.SourceFolderID)
+	for _, subfolder := range subfolders {
+		sourceIDs = append(sourceIDs, subfolder.ID)
 	}
 
+	cursor, err = fs.fileCollection.Find(ctx,
+		bson.M{"user_id": job.UserID, "folder_id": bson.M{"$in": sourceIDs}, "is_deleted": false},
+		options.Find().SetProjection(bson.M{"_id": 1, "folder_id": 1, "name": 1, "size": 1}),
+	)
 	if err != nil {
-		return tree, err
+		return fmt.Errorf("failed to list files: %v", err)
+	}
+	var files []models.File
+	if err := cursor.All(ctx, &files); err != nil {
+		return fmt.Errorf("failed to list files: %v", err)
+	}
+
+	var totalBytes int64
+	for _, file := range files {
+		totalBytes += file.Size
 	}
+	fs.updateCopyJob(ctx, job.ID, bson.M{"$set": bson.M{
+		"progress.total_folders": len(subfolders),
+		"progress.total_files":   len(files),
+		"progress.total_bytes":   totalBytes,
+	}})
 
-	// Build child trees
+	// Recreate the subfolders under the copy
+	copies := map[primitive.ObjectID]primitive.ObjectID{job.SourceFolderID: job.FolderID}
+	// Paths relative to the source folder, for reporting errors
+	paths := map[primitive.ObjectID]string{job.SourceFolderID: ""}
+	created := 0
 	for _, subfolder := range subfolders {
-		childTree, err := fs.buildFolderTree(ctx, userID, &subfolder, maxDepth-1)
+		parentID, ok := copies[*subfolder.ParentID]
+		if !ok {
+			continue // inside a folder in the trash, or one not copied
+		}
+		path := paths[*subfolder.ParentID] + "/" + subfolder.Name
+
+		copied, err := fs.copyFolderRecord(ctx, job.UserID, &subfolder, parentID)
 		if err != nil {
-			continue // Skip on error
+			fs.recordCopyFailure(ctx, job.ID, path, err, false)
+			continue
 		}
-		tree.Children = append(tree.Children, childTree)
+		copies[subfolder.ID] = copied.ID
+		paths[subfolder.ID] = path
+		created++
+		fs.updateCopyJob(ctx, job.ID, bson.M{"$inc": bson.M{"progress.copied_folders": 1}})
+	}
+	if created > 0 {
+		fs.updateUserFolderCount(ctx, job.UserID, created)
 	}
 
-	// Get files if this is a leaf or near-leaf node
-	if maxDepth <= 2 {
-		var files []models.File
-		if rootFolder == nil {
-			files, _, err = fs.getRootFiles(ctx, userID, 1, 20)
-		} else {
-			files, _, err = fs.getFolderFiles(ctx, userID, folderID, 1, 20, "name", "asc")
+	// Copy the files, which must fit within the user's limits one by one
+	fileService := NewFileService()
+	plan, err := fileService.GetUserPlan(ctx, job.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to get user plan: %v", err)
+	}
+
+	for _, file := range files {
+		destID, ok := copies[*file.FolderID]
+		if !ok {
+			continue
 		}
-		if err == nil {
-			// Convert []models.File to []*models.File
-			filePointers := make([]*models.File, len(files))
-			for i := range files {
-				filePointers[i] = &files[i]
-			}
-			tree.Files = filePointers
+		path := paths[*file.FolderID] + "/" + file.Name
+
+		// Other uploads may have used up the room checked for at the start
+		if err := fs.checkCopyRoom(ctx, job.UserID, plan, file.Size); err != nil {
+			return err
+		}
+
+		if _, err := fileService.CopyFile(ctx, job.UserID, file.ID, destID.Hex(), file.Name); err != nil {
+			fs.recordCopyFailure(ctx, job.ID, path, err, true)
+			continue
 		}
+		fs.updateCopyJob(ctx, job.ID, bson.M{"$inc": bson.M{
+			"progress.copied_files": 1,
+			"progress.copied_bytes": file.Size,
+		}})
 	}
 
-	return tree, nil
+	return nil
 }
 
-func (fs *FolderService) updateUserFolderCount(userID primitive.ObjectID, change int) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// copyFolderRecord creates a copy of folder, without its contents, in
+// parentID
+func (fs *FolderService) copyFolderRecord(ctx context.Context, userID primitive.ObjectID, folder *models.Folder, parentID primitive.ObjectID) (*models.Folder, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	fs.userCollection.UpdateOne(ctx,
-		bson.M{"_id": userID},
-		bson.M{"$inc": bson.M{"folders_count": change}},
-	)
-}
+	copied := &models.Folder{
+		ID:          primitive.NewObjectID(),
+		UserID:      userID,
+		ParentID:    &parentID,
+		Name:        folder.Name,
+		Description: folder.Description,
+		Color:       folder.Color,
+		Icon:        folder.Icon,
+		Tags:        folder.Tags,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
 
-func (fs *FolderService) deleteAllFolderContents(ctx context.Context, userID, folderID primitive.ObjectID) error {
-	// Delete all files in folder
-	_, err := fs.fileCollection.DeleteMany(ctx, bson.M{
-		"user_id":   userID,
-		"folder_id": folderID,
-	})
+	var err error
+	copied.Path, copied.Ancestors, err = fs.folderLineage(ctx, userID, folder.Name, &parentID)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if _, err := fs.folderCollection.InsertOne(ctx, copied); err != nil {
+		return nil, fmt.Errorf("failed to create folder copy: %v", err)
 	}
+	return copied, nil
+}
 
-	// Get all subfolders
-	subfolders, err := fs.getFolderSubfolders(ctx, userID, folderID, "name", "asc")
-	if err != nil {
-		return err
+// checkCopyRoom checks that one more file of size fits within plan
+func (fs *FolderService) checkCopyRoom(ctx context.Context, userID primitive.ObjectID, plan *models.Plan, size int64) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var user models.User
+	if err := fs.userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+		return fmt.Errorf("user not found: %v", err)
+	}
+	if user.StorageUsed+size > plan.StorageLimit {
+		return fmt.Errorf("%w: copy would exceed storage limit of %s", ErrFolderCopyLimit, utils.FormatFileSize(plan.StorageLimit))
 	}
+	if plan.FilesLimit > 0 && user.FilesCount >= plan.FilesLimit {
+		return fmt.Errorf("%w: file limit of %d reached", ErrFolderCopyLimit, plan.FilesLimit)
+	}
+	return nil
+}
 
-	// Recursively delete subfolder contents
-	for _, subfolder := range subfolders {
-		if err := fs.deleteAllFolderContents(ctx, userID, subfolder.ID); err != nil {
-			continue // Continue with other folders
-		}
+// GetCopyJob returns the job which filled, or is filling, one of the user's
+// copied folders
+func (fs *FolderService) GetCopyJob(ctx context.Context, userID, folderID primitive.ObjectID) (*models.FolderCopyJob, error) {
+	folder, err := fs.GetUserFolder(ctx, userID, folderID)
+	if err != nil {
+		return nil, err
+	}
+	if folder.CopyJobID == nil {
+		return nil, ErrFolderCopyJobNotFound
 	}
 
-	// Delete all subfolders
-	_, err = fs.folderCollection.DeleteMany(ctx, bson.M{
-		"user_id":   userID,
-		"parent_id": folderID,
-	})
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
 
-	return err
+	var job models.FolderCopyJob
+	if err := fs.copyJobCollection.FindOne(ctx, bson.M{"_id": *folder.CopyJobID, "user_id": userID}).Decode(&job); err != nil {
+		return nil, ErrFolderCopyJobNotFound
+	}
+	return &job, nil
 }
 
-func (fs *FolderService) softDeleteSubfolders(ctx context.Context, userID, folderID primitive.ObjectID) {
-	// Mark all subfolders as deleted
-	fs.folderCollection.UpdateMany(ctx,
-		bson.M{
-			"user_id":   userID,
-			"parent_id": folderID,
-		},
+// FailInterruptedCopies marks folder copies left running when the server
+// stopped as failed; the files copied before are kept
+func (fs *FolderService) FailInterruptedCopies() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	_, err := fs.copyJobCollection.UpdateMany(ctx,
+		bson.M{"status": models.FolderCopyRunning, "updated_at": bson.M{"$lt": now.Add(-folderCopyStaleAfter)}},
 		bson.M{"$set": bson.M{
-			"is_deleted": true,
-			"deleted_at": time.Now(),
+			"status":       models.FolderCopyFailed,
+			"error":        "interrupted by a restart",
+			"completed_at": now,
+			"updated_at":   now,
 		}},
 	)
-
-	// Get subfolders for recursive deletion
-	subfolders, err := fs.getFolderSubfolders(ctx, userID, folderID, "name", "asc")
 	if err != nil {
-		return
+		log.Printf("Failed to fail interrupted folder copies: %v", err)
 	}
+}
 
-	// Recursively soft delete
-	for _, subfolder := range subfolders {
-		fs.softDeleteSubfolders(ctx, userID, subfolder.ID)
+func (fs *FolderService) recordCopyFailure(ctx context.Context, jobID primitive.ObjectID, path string, err error, isFile bool) {
+	update := bson.M{"$push": bson.M{"errors": bson.M{
+		"$each":  bson.A{models.FolderCopyError{Path: path, Message: err.Error()}},
+		"$slice": maxFolderCopyErrors,
+	}}}
+	if isFile {
+		update["$inc"] = bson.M{"progress.failed_files": 1}
 	}
+	fs.updateCopyJob(ctx, jobID, update)
 }
 
-func (fs *FolderService) copyFolderContentsAsync(userID, sourceFolderID, destFolderID primitive.ObjectID) {
-	// Implementation for async folder copying
-	// This would copy all files and subfolders recursively
+func (fs *FolderService) updateCopyJob(ctx context.Context, jobID primitive.ObjectID, update bson.M) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if set, ok := update["$set"].(bson.M); ok {
+		set["updated_at"] = time.Now()
+	} else {
+		update["$set"] = bson.M{"updated_at": time.Now()}
+	}
+	if _, err := fs.copyJobCollection.UpdateOne(ctx, bson.M{"_id": jobID}, update); err != nil {
+		log.Printf("Failed to update folder copy %s: %v", jobID.Hex(), err)
+	}
 }
 
-func (fs *FolderService) updateSubfolderPathsAsync(userID, folderID primitive.ObjectID, newBasePath string) {
-	// Implementation for async path updates
-	// This would update all subfolder paths recursively
+// updateDescendantLineage rewrites the path and ancestors of every folder
+// below a moved folder in one update, replacing the moved folder's old
+// lineage prefix with the new one
+func (fs *FolderService) updateDescendantLineage(ctx context.Context, userID primitive.ObjectID, folder *models.Folder, newPath string, newPrefix []primitive.ObjectID) error {
+	_, err := fs.folderCollection.UpdateMany(ctx,
+		bson.M{"user_id": userID, "ancestors": folder.ID},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{
+			"ancestors": bson.M{"$concatArrays": bson.A{
+				newPrefix,
+				bson.M{"$slice": bson.A{
+					"$ancestors",
+					bson.M{"$add": bson.A{bson.M{"$indexOfArray": bson.A{"$ancestors", folder.ID}}, 1}},
+					bson.M{"$size": "$ancestors"},
+				}},
+			}},
+			"path": bson.M{"$concat": bson.A{
+				newPath,
+				bson.M{"$substrCP": bson.A{"$path", utf8.RuneCountInString(folder.Path), bson.M{"$strLenCP": "$path"}}},
+			}},
+		}}}},
+	)
+	return err
 }
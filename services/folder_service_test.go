@@ -0,0 +1,127 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"oncloud/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// testDatabase returns a database of its own on the MongoDB at
+// TEST_MONGO_URI, dropped when the test ends, and skips the test when no
+// test database is configured
+func testDatabase(t *testing.T) *mongo.Database {
+	t.Helper()
+	uri := os.Getenv("TEST_MONGO_URI")
+	if uri == "" {
+		t.Skip("TEST_MONGO_URI is not set")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("failed to connect to test database: %v", err)
+	}
+	db := client.Database("oncloud_test_" + primitive.NewObjectID().Hex())
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		db.Drop(ctx)
+		client.Disconnect(ctx)
+	})
+	return db
+}
+
+// insertFolderChain stores a chain of depth folders under parent, each the
+// parent of the next, and returns them in order
+func insertFolderChain(t *testing.T, collection *mongo.Collection, userID primitive.ObjectID, parent *models.Folder, names ...string) []*models.Folder {
+	t.Helper()
+	folders := make([]*models.Folder, len(names))
+	docs := make([]interface{}, len(names))
+	for i, name := range names {
+		folder := &models.Folder{ID: primitive.NewObjectID(), UserID: userID, Name: name, Ancestors: []primitive.ObjectID{}}
+		above := parent
+		if i > 0 {
+			above = folders[i-1]
+		}
+		if above != nil {
+			folder.ParentID = &above.ID
+			folder.Path = above.Path + "/" + name
+			folder.Ancestors = append(append([]primitive.ObjectID{}, above.Ancestors...), above.ID)
+		} else {
+			folder.Path = "/" + name
+		}
+		folders[i], docs[i] = folder, folder
+	}
+	if _, err := collection.InsertMany(context.Background(), docs); err != nil {
+		t.Fatal(err)
+	}
+	return folders
+}
+
+func TestUpdateDescendantLineageRewritesDeepChain(t *testing.T) {
+	db := testDatabase(t)
+	fs := &FolderService{folderCollection: db.Collection("folders")}
+	ctx := context.Background()
+	userID := primitive.NewObjectID()
+
+	// Multibyte names above the moved folder check the path is cut by
+	// characters rather than bytes
+	const depth, moved = 150, 40
+	names := make([]string, depth)
+	for i := range names {
+		names[i] = fmt.Sprintf("dossier-é%d", i)
+	}
+	chain := insertFolderChain(t, fs.folderCollection, userID, nil, names...)
+	target := insertFolderChain(t, fs.folderCollection, userID, nil, "archive", "2024")[1]
+	other := insertFolderChain(t, fs.folderCollection, primitive.NewObjectID(), nil, names[:moved+5]...)
+
+	// Move the folder as MoveFolder does, then rewrite what is below it
+	folder := chain[moved]
+	newPath := target.Path + "/" + folder.Name
+	newAncestors := append(append([]primitive.ObjectID{}, target.Ancestors...), target.ID)
+	if err := fs.updateDescendantLineage(ctx, userID, folder, newPath, append(newAncestors, folder.ID)); err != nil {
+		t.Fatal(err)
+	}
+
+	stored := func(id primitive.ObjectID) models.Folder {
+		var folder models.Folder
+		if err := fs.folderCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&folder); err != nil {
+			t.Fatal(err)
+		}
+		return folder
+	}
+
+	wantPath := newPath
+	wantAncestors := append(newAncestors, folder.ID)
+	for i := moved + 1; i < depth; i++ {
+		wantPath += "/" + names[i]
+		got := stored(chain[i].ID)
+		if got.Path != wantPath {
+			t.Fatalf("folder %d: path %q, want %q", i, got.Path, wantPath)
+		}
+		if !reflect.DeepEqual(got.Ancestors, wantAncestors) {
+			t.Fatalf("folder %d: ancestors %v, want %v", i, got.Ancestors, wantAncestors)
+		}
+		wantAncestors = append(append([]primitive.ObjectID{}, wantAncestors...), chain[i].ID)
+	}
+
+	// Folders above the moved one and other users' folders are untouched
+	for _, unchanged := range slices.Concat(chain[:moved+1], other) {
+		got := stored(unchanged.ID)
+		if got.Path != unchanged.Path || !reflect.DeepEqual(got.Ancestors, unchanged.Ancestors) {
+			t.Fatalf("folder %s changed to %q %v", unchanged.Path, got.Path, got.Ancestors)
+		}
+	}
+}
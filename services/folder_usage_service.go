@@ -0,0 +1,328 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"oncloud/models"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const maxUsageTreeDepth = 10
+
+// FolderUsageService keeps each folder's size up to date as files come and
+// go. A folder's size and files_count cover the files directly inside it;
+// total_size and total_files also include all of its live subfolders.
+type FolderUsageService struct {
+	*BaseService
+}
+
+func NewFolderUsageService() *FolderUsageService {
+	return NewFolderUsageServiceWith(Dependencies{})
+}
+
+// NewFolderUsageServiceWith creates a folder usage service on the given
+// dependencies
+func NewFolderUsageServiceWith(deps Dependencies) *FolderUsageService {
+	return &FolderUsageService{
+		BaseService: NewBaseServiceWith(deps),
+	}
+}
+
+// AddFileUsage records files (negative when removed) totalling size bytes
+// added directly to folderID. A nil folderID is the root, which has no
+// record to update.
+func (fus *FolderUsageService) AddFileUsage(ctx context.Context, userID primitive.ObjectID, folderID *primitive.ObjectID, size int64, files int) {
+	if folderID == nil || (size == 0 && files == 0) {
+		return
+	}
+	if err := fus.incrementUsage(ctx, userID, *folderID, size, files, true); err != nil {
+		log.Printf("Failed to update usage of folder %s: %v", folderID.Hex(), err)
+	}
+}
+
+// AddSubtreeUsage records a whole folder subtree moving into (or, when
+// negative, out of) parentID
+func (fus *FolderUsageService) AddSubtreeUsage(ctx context.Context, userID primitive.ObjectID, parentID *primitive.ObjectID, size int64, files int) {
+	if parentID == nil || (size == 0 && files == 0) {
+		return
+	}
+	if err := fus.incrementUsage(ctx, userID, *parentID, size, files, false); err != nil {
+		log.Printf("Failed to update usage of folder %s: %v", parentID.Hex(), err)
+	}
+}
+
+// RecalculateUsage rebuilds the sizes of all of the user's live folders from
+// their files, e.g. after a restore brings back part of a deleted subtree
+func (fus *FolderUsageService) RecalculateUsage(ctx context.Context, userID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+
+	cursor, err := fus.collections.Folders().Find(ctx,
+		bson.M{"user_id": userID, "is_deleted": false},
+		options.Find().SetProjection(bson.M{"_id": 1, "parent_id": 1}),
+	)
+	if err != nil {
+		return err
+	}
+	var folders []models.Folder
+	if err := cursor.All(ctx, &folders); err != nil {
+		return err
+	}
+
+	direct, err := fus.directUsage(ctx, bson.M{"user_id": userID, "is_deleted": false, "folder_id": bson.M{"$ne": nil}})
+	if err != nil {
+		return err
+	}
+
+	type usage struct {
+		size, totalSize   int64
+		files, totalFiles int
+	}
+	usages := make(map[primitive.ObjectID]*usage, len(folders))
+	parents := make(map[primitive.ObjectID]*primitive.ObjectID, len(folders))
+	for _, folder := range folders {
+		d := direct[folder.ID]
+		usages[folder.ID] = &usage{size: d.Size, files: d.Count}
+		parents[folder.ID] = folder.ParentID
+	}
+
+	// Add each folder's direct files to itself and every live ancestor
+	for id, u := range usages {
+		seen := make(map[primitive.ObjectID]bool)
+		for current := &id; current != nil && !seen[*current]; current = parents[*current] {
+			ancestor, ok := usages[*current]
+			if !ok {
+				break
+			}
+			seen[*current] = true
+			ancestor.totalSize += u.size
+			ancestor.totalFiles += u.files
+		}
+	}
+
+	if len(usages) == 0 {
+		return nil
+	}
+
+	writes := make([]mongo.WriteModel, 0, len(usages))
+	for id, u := range usages {
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": id}).
+			SetUpdate(bson.M{"$set": bson.M{
+				"size":        u.size,
+				"files_count": u.files,
+				"total_size":  u.totalSize,
+				"total_files": u.totalFiles,
+			}}))
+	}
+	if _, err := fus.collections.Folders().BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false)); err != nil {
+		return fmt.Errorf("failed to save folder usage: %v", err)
+	}
+
+	return nil
+}
+
+// GetUsageTree returns the recursive size of a folder, or of the root when
+// folderID is nil, and of its subfolders down to depth levels, largest
+// first
+func (fus *FolderUsageService) GetUsageTree(ctx context.Context, userID primitive.ObjectID, folderID *primitive.ObjectID, depth int) (*models.FolderUsage, error) {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	if depth < 1 {
+		depth = 1
+	}
+	if depth > maxUsageTreeDepth {
+		depth = maxUsageTreeDepth
+	}
+
+	var root *models.FolderUsage
+	var rootFolder *models.Folder
+	if folderID != nil {
+		var folder models.Folder
+		err := fus.collections.Folders().FindOne(ctx, bson.M{
+			"_id":        *folderID,
+			"user_id":    userID,
+			"is_deleted": false,
+		}).Decode(&folder)
+		if err != nil {
+			return nil, fmt.Errorf("folder not found: %v", err)
+		}
+		root = folderUsageNode(&folder)
+		rootFolder = &folder
+	} else {
+		direct, err := fus.directUsage(ctx, bson.M{"user_id": userID, "is_deleted": false, "folder_id": nil})
+		if err != nil {
+			return nil, err
+		}
+		root = &models.FolderUsage{
+			Name:       "/",
+			Path:       "/",
+			Size:       direct[primitive.NilObjectID].Size,
+			FilesCount: direct[primitive.NilObjectID].Count,
+		}
+		root.TotalSize, root.TotalFiles = root.Size, root.FilesCount
+	}
+
+	// Fetch the whole subtree at once through the folders' ancestors
+	folders, err := findFolderSubtree(ctx, fus.collections.Folders(), userID, rootFolder, depth)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make(map[primitive.ObjectID]*models.FolderUsage, len(folders)+1)
+	all := []*models.FolderUsage{root}
+	if root.ID != nil {
+		nodes[*root.ID] = root
+	}
+	for i := range folders {
+		node := folderUsageNode(&folders[i])
+		nodes[folders[i].ID] = node
+		all = append(all, node)
+	}
+	for i := range folders {
+		parent := root
+		if folders[i].ParentID != nil {
+			var ok bool
+			if parent, ok = nodes[*folders[i].ParentID]; !ok {
+				continue
+			}
+		}
+		child := nodes[folders[i].ID]
+		parent.Children = append(parent.Children, child)
+
+		// The root has no record, so its totals are summed here
+		if parent == root && root.ID == nil {
+			root.TotalSize += child.TotalSize
+			root.TotalFiles += child.TotalFiles
+		}
+	}
+	for _, node := range all {
+		sort.Slice(node.Children, func(i, j int) bool {
+			return node.Children[i].TotalSize > node.Children[j].TotalSize
+		})
+	}
+
+	return root, nil
+}
+
+// incrementUsage adds to the totals of folderID and all of its live
+// ancestors, and with direct also to the folder's own file usage. Nothing
+// is updated when the folder is in the trash: its ancestors already
+// stopped counting it. Likewise the ancestors above one in the trash are
+// left alone.
+func (fus *FolderUsageService) incrementUsage(ctx context.Context, userID, folderID primitive.ObjectID, size int64, files int, direct bool) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var folder models.Folder
+	err := fus.collections.Folders().FindOne(ctx,
+		bson.M{"_id": folderID, "user_id": userID, "is_deleted": false},
+		options.FindOne().SetProjection(bson.M{"ancestors": 1}),
+	).Decode(&folder)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	ancestors := folder.Ancestors
+	if len(ancestors) > 0 {
+		cursor, err := fus.collections.Folders().Find(ctx,
+			bson.M{"_id": bson.M{"$in": ancestors}, "is_deleted": true},
+			options.Find().SetProjection(bson.M{"_id": 1}),
+		)
+		if err != nil {
+			return err
+		}
+		var deleted []struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err := cursor.All(ctx, &deleted); err != nil {
+			return err
+		}
+		for _, d := range deleted {
+			for i, id := range ancestors {
+				if id == d.ID {
+					ancestors = ancestors[i+1:]
+					break
+				}
+			}
+		}
+	}
+
+	if direct {
+		if _, err := fus.collections.Folders().UpdateOne(ctx,
+			bson.M{"_id": folderID},
+			bson.M{"$inc": bson.M{"size": size, "files_count": files}},
+		); err != nil {
+			return err
+		}
+	}
+
+	ids := append(append([]primitive.ObjectID{}, ancestors...), folderID)
+	_, err = fus.collections.Folders().UpdateMany(ctx,
+		bson.M{"_id": bson.M{"$in": ids}},
+		bson.M{"$inc": bson.M{"total_size": size, "total_files": files}},
+	)
+	return err
+}
+
+type folderFileUsage struct {
+	Size  int64 `bson:"size"`
+	Count int   `bson:"count"`
+}
+
+// directUsage sums the size of the files matching filter per folder. Root
+// files are keyed by the nil ObjectID.
+func (fus *FolderUsageService) directUsage(ctx context.Context, filter bson.M) (map[primitive.ObjectID]folderFileUsage, error) {
+	cursor, err := fus.collections.Files().Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   "$folder_id",
+			"size":  bson.M{"$sum": "$size"},
+			"count": bson.M{"$sum": 1},
+		}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var groups []struct {
+		FolderID        *primitive.ObjectID `bson:"_id"`
+		folderFileUsage `bson:",inline"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+
+	usage := make(map[primitive.ObjectID]folderFileUsage, len(groups))
+	for _, group := range groups {
+		id := primitive.NilObjectID
+		if group.FolderID != nil {
+			id = *group.FolderID
+		}
+		usage[id] = group.folderFileUsage
+	}
+	return usage, nil
+}
+
+func folderUsageNode(folder *models.Folder) *models.FolderUsage {
+	id := folder.ID
+	return &models.FolderUsage{
+		ID:         &id,
+		Name:       folder.Name,
+		Path:       folder.Path,
+		Size:       folder.Size,
+		FilesCount: folder.FilesCount,
+		TotalSize:  folder.TotalSize,
+		TotalFiles: folder.TotalFiles,
+	}
+}
@@ -0,0 +1,735 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"oncloud/database"
+	"oncloud/graphql"
+	"oncloud/models"
+	"oncloud/utils"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	graphqlDefaultLimit = 20
+	graphqlMaxLimit     = 100
+
+	// Most children or files listed under each folder of a result
+	graphqlMaxNestedLimit = 200
+)
+
+var ErrGraphQLNotEnabled = errors.New("the GraphQL API is not enabled")
+
+// graphqlSchema is built by InitGraphQL; the API is off while it is nil
+var graphqlSchema *graphql.Schema
+
+// InitGraphQL enables the GraphQL API
+func InitGraphQL() error {
+	schema, err := newGraphQLSchema()
+	if err != nil {
+		return fmt.Errorf("invalid GraphQL schema: %v", err)
+	}
+	graphqlSchema = schema
+	return nil
+}
+
+type GraphQLService struct {
+	*BaseService
+}
+
+func NewGraphQLService() *GraphQLService {
+	return NewGraphQLServiceWith(Dependencies{})
+}
+
+// NewGraphQLServiceWith creates a GraphQL service on the given
+// dependencies
+func NewGraphQLServiceWith(deps Dependencies) *GraphQLService {
+	return &GraphQLService{
+		BaseService: NewBaseServiceWith(deps),
+	}
+}
+
+// Execute runs a query for user. Related records are fetched through
+// loaders scoped to the request, so each level of the query costs one
+// lookup per kind of record rather than one per parent.
+func (gs *GraphQLService) Execute(ctx context.Context, user *models.User, req *graphql.Request) (*graphql.Response, error) {
+	if graphqlSchema == nil {
+		return nil, ErrGraphQLNotEnabled
+	}
+	ctx = context.WithValue(ctx, graphqlRequestKey{}, gs.newGraphQLRequest(user))
+	return graphqlSchema.Execute(ctx, req), nil
+}
+
+// SDL returns the schema in the GraphQL schema definition language
+func (gs *GraphQLService) SDL() (string, error) {
+	if graphqlSchema == nil {
+		return "", ErrGraphQLNotEnabled
+	}
+	return graphqlSchema.SDL(), nil
+}
+
+type graphqlRequestKey struct{}
+
+// graphqlRequest holds the user and loaders of one GraphQL request. Every
+// loader only sees the user's own records, except plans.
+type graphqlRequest struct {
+	*GraphQLService
+	user         *models.User
+	files        *graphql.Loader
+	folders      *graphql.Loader
+	plans        *graphql.Loader
+	fileShares   *graphql.Loader // by file ID
+	folderShares *graphql.Loader // by folder ID
+}
+
+func (gs *GraphQLService) newGraphQLRequest(user *models.User) *graphqlRequest {
+	owned := bson.M{"user_id": user.ID, "is_deleted": false}
+	activeShares := bson.M{"user_id": user.ID, "is_active": true}
+
+	return &graphqlRequest{
+		GraphQLService: gs,
+		user:           user,
+		files: graphql.NewLoader(func(ctx context.Context, keys []string) (map[string]interface{}, error) {
+			return loadGraphQLRecords(ctx, gs.collections.Files(), "_id", keys, owned,
+				func(f *models.File) primitive.ObjectID { return f.ID })
+		}),
+		folders: graphql.NewLoader(func(ctx context.Context, keys []string) (map[string]interface{}, error) {
+			return loadGraphQLRecords(ctx, gs.collections.Folders(), "_id", keys, owned,
+				func(f *models.Folder) primitive.ObjectID { return f.ID })
+		}),
+		plans: graphql.NewLoader(func(ctx context.Context, keys []string) (map[string]interface{}, error) {
+			return loadGraphQLRecords(ctx, gs.collections.Plans(), "_id", keys, bson.M{},
+				func(p *models.Plan) primitive.ObjectID { return p.ID })
+		}),
+		fileShares: graphql.NewLoader(func(ctx context.Context, keys []string) (map[string]interface{}, error) {
+			return loadGraphQLRecords(ctx, gs.collections.FileShares(), "file_id", keys, activeShares,
+				func(s *models.FileShare) primitive.ObjectID { return s.FileID })
+		}),
+		folderShares: graphql.NewLoader(func(ctx context.Context, keys []string) (map[string]interface{}, error) {
+			return loadGraphQLRecords(ctx, gs.collections.Collection("folder_shares"), "file_id", keys, activeShares,
+				func(s *models.FileShare) primitive.ObjectID { return s.FileID })
+		}),
+	}
+}
+
+func graphqlRequestFrom(ctx context.Context) *graphqlRequest {
+	return ctx.Value(graphqlRequestKey{}).(*graphqlRequest)
+}
+
+// loadGraphQLRecords fetches the records whose field holds one of keys, in
+// a single query, and returns them keyed by that field
+func loadGraphQLRecords[T any](ctx context.Context, collection *mongo.Collection, field string, keys []string, filter bson.M, keyOf func(*T) primitive.ObjectID) (map[string]interface{}, error) {
+	ids := make([]primitive.ObjectID, 0, len(keys))
+	for _, key := range keys {
+		if id, err := primitive.ObjectIDFromHex(key); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	records := make(map[string]interface{}, len(ids))
+	if len(ids) == 0 {
+		return records, nil
+	}
+
+	query := bson.M{field: bson.M{"$in": ids}}
+	for k, v := range filter {
+		query[k] = v
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	cursor, err := collection.Find(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	var results []T
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	for i := range results {
+		records[keyOf(&results[i]).Hex()] = &results[i]
+	}
+	return records, nil
+}
+
+// loadGraphQLGroups fetches up to limit records, sorted by name, for each
+// of the parent IDs in field, in a single aggregation
+func loadGraphQLGroups[T any](ctx context.Context, collection *mongo.Collection, field string, parentIDs []primitive.ObjectID, filter bson.M, limit int) (map[primitive.ObjectID][]*T, error) {
+	match := bson.M{field: bson.M{"$in": parentIDs}}
+	for k, v := range filter {
+		match[k] = v
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$sort", Value: bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$" + field, "records": bson.M{"$push": "$$ROOT"}}}},
+		{{Key: "$project", Value: bson.M{"records": bson.M{"$slice": bson.A{"$records", limit}}}}},
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	cursor, err := collection.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, err
+	}
+	var results []struct {
+		ID      primitive.ObjectID `bson:"_id"`
+		Records []*T               `bson:"records"`
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	groups := make(map[primitive.ObjectID][]*T, len(results))
+	for _, result := range results {
+		groups[result.ID] = result.Records
+	}
+	return groups, nil
+}
+
+// Schema
+
+// graphqlShare is a file or folder share. Folder shares are stored in the
+// same shape as file shares, with the folder's ID in FileID.
+type graphqlShare struct {
+	*models.FileShare
+	kind string // file or folder
+}
+
+// graphqlUsage is the user's usage against their plan's limits
+type graphqlUsage struct {
+	user *models.User
+	plan *models.Plan
+}
+
+// graphqlPage is one page of a list, with its pagination details
+type graphqlPage struct {
+	items interface{}
+	meta  *models.Meta
+}
+
+func newGraphQLSchema() (*graphql.Schema, error) {
+	nonNull := func(t graphql.Type) graphql.Type { return &graphql.NonNull{OfType: t} }
+	listOf := func(t graphql.Type) graphql.Type { return &graphql.NonNull{OfType: &graphql.List{OfType: nonNull(t)}} }
+	limitArg := &graphql.ArgumentDefinition{Name: "limit", Type: graphql.Int, DefaultValue: 50}
+
+	file := &graphql.Object{Name: "File"}
+	folder := &graphql.Object{Name: "Folder"}
+	share := &graphql.Object{Name: "Share", Description: "A public link to a file or folder"}
+	plan := &graphql.Object{Name: "Plan"}
+	usage := &graphql.Object{Name: "Usage", Description: "Usage against the plan's limits. A limit of 0 is unlimited."}
+	user := &graphql.Object{Name: "User"}
+
+	file.Fields = []*graphql.FieldDefinition{
+		graphqlField("id", nonNull(graphql.ID), func(f *models.File) interface{} { return f.ID.Hex() }),
+		graphqlField("name", nonNull(graphql.String), func(f *models.File) interface{} { return f.Name }),
+		graphqlField("displayName", nonNull(graphql.String), func(f *models.File) interface{} { return f.DisplayName }),
+		graphqlField("originalName", nonNull(graphql.String), func(f *models.File) interface{} { return f.OriginalName }),
+		graphqlField("description", nonNull(graphql.String), func(f *models.File) interface{} { return f.Description }),
+		graphqlField("path", nonNull(graphql.String), func(f *models.File) interface{} { return f.Path }),
+		graphqlField("size", nonNull(graphql.Long), func(f *models.File) interface{} { return f.Size }),
+		graphqlField("mimeType", nonNull(graphql.String), func(f *models.File) interface{} { return f.MimeType }),
+		graphqlField("extension", nonNull(graphql.String), func(f *models.File) interface{} { return f.Extension }),
+		graphqlField("md5", nonNull(graphql.String), func(f *models.File) interface{} { return f.Hash }),
+		graphqlField("thumbnailUrl", nonNull(graphql.String), func(f *models.File) interface{} { return f.ThumbnailURL }),
+		graphqlField("isPublic", nonNull(graphql.Boolean), func(f *models.File) interface{} { return f.IsPublic }),
+		graphqlField("isShared", nonNull(graphql.Boolean), func(f *models.File) interface{} { return f.IsShared }),
+		graphqlField("isFavorite", nonNull(graphql.Boolean), func(f *models.File) interface{} { return f.IsFavorite }),
+		graphqlField("tags", listOf(graphql.String), func(f *models.File) interface{} { return nonNilStrings(f.Tags) }),
+		graphqlField("downloads", nonNull(graphql.Int), func(f *models.File) interface{} { return f.Downloads }),
+		graphqlField("views", nonNull(graphql.Int), func(f *models.File) interface{} { return f.Views }),
+		graphqlField("createdAt", nonNull(graphql.Time), func(f *models.File) interface{} { return f.CreatedAt }),
+		graphqlField("updatedAt", nonNull(graphql.Time), func(f *models.File) interface{} { return f.UpdatedAt }),
+		graphqlRelation("folder", folder, "The folder holding the file, or null at the root",
+			func(r *graphqlRequest) *graphql.Loader { return r.folders },
+			func(f *models.File) string { return objectIDKey(f.FolderID) }),
+		graphqlShareRelation(share, "file",
+			func(r *graphqlRequest) *graphql.Loader { return r.fileShares },
+			func(f *models.File) string { return f.ID.Hex() }),
+	}
+
+	folder.Fields = []*graphql.FieldDefinition{
+		graphqlField("id", nonNull(graphql.ID), func(f *models.Folder) interface{} { return f.ID.Hex() }),
+		graphqlField("name", nonNull(graphql.String), func(f *models.Folder) interface{} { return f.Name }),
+		graphqlField("description", nonNull(graphql.String), func(f *models.Folder) interface{} { return f.Description }),
+		graphqlField("path", nonNull(graphql.String), func(f *models.Folder) interface{} { return f.Path }),
+		graphqlField("color", nonNull(graphql.String), func(f *models.Folder) interface{} { return f.Color }),
+		graphqlField("icon", nonNull(graphql.String), func(f *models.Folder) interface{} { return f.Icon }),
+		graphqlField("isShared", nonNull(graphql.Boolean), func(f *models.Folder) interface{} { return f.IsShared }),
+		graphqlField("isFavorite", nonNull(graphql.Boolean), func(f *models.Folder) interface{} { return f.IsFavorite }),
+		graphqlField("filesCount", nonNull(graphql.Int), func(f *models.Folder) interface{} { return f.FilesCount }),
+		graphqlField("size", nonNull(graphql.Long), func(f *models.Folder) interface{} { return f.Size }),
+		graphqlField("totalFiles", nonNull(graphql.Int), func(f *models.Folder) interface{} { return f.TotalFiles }),
+		graphqlField("totalSize", nonNull(graphql.Long), func(f *models.Folder) interface{} { return f.TotalSize }),
+		graphqlField("tags", listOf(graphql.String), func(f *models.Folder) interface{} { return nonNilStrings(f.Tags) }),
+		graphqlField("createdAt", nonNull(graphql.Time), func(f *models.Folder) interface{} { return f.CreatedAt }),
+		graphqlField("updatedAt", nonNull(graphql.Time), func(f *models.Folder) interface{} { return f.UpdatedAt }),
+		graphqlRelation("parent", folder, "The parent folder, or null at the root",
+			func(r *graphqlRequest) *graphql.Loader { return r.folders },
+			func(f *models.Folder) string { return objectIDKey(f.ParentID) }),
+		graphqlShareRelation(share, "folder",
+			func(r *graphqlRequest) *graphql.Loader { return r.folderShares },
+			func(f *models.Folder) string { return f.ID.Hex() }),
+		{
+			Name:         "children",
+			Description:  "Subfolders by name",
+			Type:         &graphql.List{OfType: nonNull(folder)},
+			Args:         []*graphql.ArgumentDefinition{limitArg},
+			BatchResolve: resolveGraphQLChildren,
+		},
+		{
+			Name:         "files",
+			Description:  "Files directly in the folder by name",
+			Type:         &graphql.List{OfType: nonNull(file)},
+			Args:         []*graphql.ArgumentDefinition{limitArg},
+			BatchResolve: resolveGraphQLFolderFiles,
+		},
+	}
+
+	share.Fields = []*graphql.FieldDefinition{
+		graphqlField("id", nonNull(graphql.ID), func(s *graphqlShare) interface{} { return s.ID.Hex() }),
+		graphqlField("kind", nonNull(graphql.String), func(s *graphqlShare) interface{} { return s.kind }),
+		graphqlField("token", nonNull(graphql.String), func(s *graphqlShare) interface{} { return s.Token }),
+		{
+			Name: "url",
+			Type: nonNull(graphql.String),
+			Resolve: func(ctx context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+				return source.(*graphqlShare).url(ctx), nil
+			},
+		},
+		graphqlField("hasPassword", nonNull(graphql.Boolean), func(s *graphqlShare) interface{} { return s.Password != "" }),
+		graphqlField("downloads", nonNull(graphql.Int), func(s *graphqlShare) interface{} { return s.Downloads }),
+		graphqlField("maxDownloads", nonNull(graphql.Int), func(s *graphqlShare) interface{} { return s.MaxDownloads }),
+		graphqlField("expiresAt", graphql.Time, func(s *graphqlShare) interface{} { return s.ExpiresAt }),
+		graphqlField("createdAt", nonNull(graphql.Time), func(s *graphqlShare) interface{} { return s.CreatedAt }),
+		graphqlRelation("file", file, "The shared file, for file shares",
+			func(r *graphqlRequest) *graphql.Loader { return r.files },
+			func(s *graphqlShare) string { return s.targetKey("file") }),
+		graphqlRelation("folder", folder, "The shared folder, for folder shares",
+			func(r *graphqlRequest) *graphql.Loader { return r.folders },
+			func(s *graphqlShare) string { return s.targetKey("folder") }),
+	}
+
+	plan.Fields = []*graphql.FieldDefinition{
+		graphqlField("id", nonNull(graphql.ID), func(p *models.Plan) interface{} { return p.ID.Hex() }),
+		graphqlField("name", nonNull(graphql.String), func(p *models.Plan) interface{} { return p.Name }),
+		graphqlField("slug", nonNull(graphql.String), func(p *models.Plan) interface{} { return p.Slug }),
+		graphqlField("description", nonNull(graphql.String), func(p *models.Plan) interface{} { return p.Description }),
+		graphqlField("storageLimit", nonNull(graphql.Long), func(p *models.Plan) interface{} { return p.StorageLimit }),
+		graphqlField("bandwidthLimit", nonNull(graphql.Long), func(p *models.Plan) interface{} { return p.BandwidthLimit }),
+		graphqlField("filesLimit", nonNull(graphql.Int), func(p *models.Plan) interface{} { return p.FilesLimit }),
+		graphqlField("foldersLimit", nonNull(graphql.Int), func(p *models.Plan) interface{} { return p.FoldersLimit }),
+		graphqlField("maxFileSize", nonNull(graphql.Long), func(p *models.Plan) interface{} { return p.MaxFileSize }),
+		graphqlField("price", nonNull(graphql.Float), func(p *models.Plan) interface{} { return p.Price }),
+		graphqlField("currency", nonNull(graphql.String), func(p *models.Plan) interface{} { return p.Currency }),
+		graphqlField("billingCycle", nonNull(graphql.String), func(p *models.Plan) interface{} { return p.BillingCycle }),
+		graphqlField("features", listOf(graphql.String), func(p *models.Plan) interface{} { return nonNilStrings(p.Features) }),
+		graphqlField("isFree", nonNull(graphql.Boolean), func(p *models.Plan) interface{} { return p.IsFree }),
+		graphqlField("trialDays", nonNull(graphql.Int), func(p *models.Plan) interface{} { return p.TrialDays }),
+	}
+
+	usage.Fields = []*graphql.FieldDefinition{
+		graphqlField("storageUsed", nonNull(graphql.Long), func(u *graphqlUsage) interface{} { return u.user.StorageUsed }),
+		graphqlField("storageLimit", nonNull(graphql.Long), func(u *graphqlUsage) interface{} { return u.plan.StorageLimit }),
+		graphqlField("storagePercentage", nonNull(graphql.Float), func(u *graphqlUsage) interface{} {
+			return utils.CalculatePercentage(u.user.StorageUsed, u.plan.StorageLimit)
+		}),
+		graphqlField("bandwidthUsed", nonNull(graphql.Long), func(u *graphqlUsage) interface{} { return u.user.BandwidthUsed }),
+		graphqlField("bandwidthLimit", nonNull(graphql.Long), func(u *graphqlUsage) interface{} { return u.plan.BandwidthLimit }),
+		graphqlField("bandwidthPercentage", nonNull(graphql.Float), func(u *graphqlUsage) interface{} {
+			return utils.CalculatePercentage(u.user.BandwidthUsed, u.plan.BandwidthLimit)
+		}),
+		graphqlField("filesCount", nonNull(graphql.Int), func(u *graphqlUsage) interface{} { return u.user.FilesCount }),
+		graphqlField("filesLimit", nonNull(graphql.Int), func(u *graphqlUsage) interface{} { return u.plan.FilesLimit }),
+		graphqlField("foldersCount", nonNull(graphql.Int), func(u *graphqlUsage) interface{} { return u.user.FoldersCount }),
+		graphqlField("foldersLimit", nonNull(graphql.Int), func(u *graphqlUsage) interface{} { return u.plan.FoldersLimit }),
+		graphqlField("plan", nonNull(plan), func(u *graphqlUsage) interface{} { return u.plan }),
+	}
+
+	user.Fields = []*graphql.FieldDefinition{
+		graphqlField("id", nonNull(graphql.ID), func(u *models.User) interface{} { return u.ID.Hex() }),
+		graphqlField("username", nonNull(graphql.String), func(u *models.User) interface{} { return u.Username }),
+		graphqlField("email", nonNull(graphql.String), func(u *models.User) interface{} { return u.Email }),
+		graphqlField("firstName", nonNull(graphql.String), func(u *models.User) interface{} { return u.FirstName }),
+		graphqlField("lastName", nonNull(graphql.String), func(u *models.User) interface{} { return u.LastName }),
+		graphqlField("avatar", nonNull(graphql.String), func(u *models.User) interface{} { return u.Avatar }),
+		graphqlField("isVerified", nonNull(graphql.Boolean), func(u *models.User) interface{} { return u.IsVerified }),
+		graphqlField("createdAt", nonNull(graphql.Time), func(u *models.User) interface{} { return u.CreatedAt }),
+		graphqlRelation("plan", plan, "",
+			func(r *graphqlRequest) *graphql.Loader { return r.plans },
+			func(u *models.User) string { return u.PlanID.Hex() }),
+	}
+
+	pageInfo := &graphql.Object{Name: "PageInfo", Fields: []*graphql.FieldDefinition{
+		graphqlField("page", nonNull(graphql.Int), func(m *models.Meta) interface{} { return m.Page }),
+		graphqlField("limit", nonNull(graphql.Int), func(m *models.Meta) interface{} { return m.Limit }),
+		graphqlField("total", nonNull(graphql.Int), func(m *models.Meta) interface{} { return m.Total }),
+		graphqlField("totalPages", nonNull(graphql.Int), func(m *models.Meta) interface{} { return m.TotalPages }),
+		graphqlField("hasNext", nonNull(graphql.Boolean), func(m *models.Meta) interface{} { return m.HasNext }),
+		graphqlField("hasPrev", nonNull(graphql.Boolean), func(m *models.Meta) interface{} { return m.HasPrev }),
+	}}
+	pageOf := func(name string, item *graphql.Object) *graphql.Object {
+		return &graphql.Object{Name: name, Fields: []*graphql.FieldDefinition{
+			graphqlField("items", listOf(item), func(p *graphqlPage) interface{} { return p.items }),
+			graphqlField("pageInfo", nonNull(pageInfo), func(p *graphqlPage) interface{} { return p.meta }),
+		}}
+	}
+	pageArgs := []*graphql.ArgumentDefinition{
+		{Name: "search", Type: graphql.String},
+		{Name: "page", Type: graphql.Int, DefaultValue: 1},
+		{Name: "limit", Type: graphql.Int, DefaultValue: graphqlDefaultLimit},
+	}
+	idArg := []*graphql.ArgumentDefinition{{Name: "id", Type: nonNull(graphql.ID)}}
+
+	query := &graphql.Object{Name: "Query", Fields: []*graphql.FieldDefinition{
+		{
+			Name: "me",
+			Type: nonNull(user),
+			Resolve: func(ctx context.Context, _ interface{}, _ map[string]interface{}) (interface{}, error) {
+				return graphqlRequestFrom(ctx).user, nil
+			},
+		},
+		{
+			Name:    "file",
+			Type:    file,
+			Args:    idArg,
+			Resolve: resolveGraphQLByID(func(r *graphqlRequest) *graphql.Loader { return r.files }),
+		},
+		{
+			Name:        "files",
+			Description: "The user's files, in one folder when folderId is given (\"root\" for the root)",
+			Type:        nonNull(pageOf("FilePage", file)),
+			Args:        append([]*graphql.ArgumentDefinition{{Name: "folderId", Type: graphql.ID}}, pageArgs...),
+			Resolve:     resolveGraphQLFiles,
+		},
+		{
+			Name:    "folder",
+			Type:    folder,
+			Args:    idArg,
+			Resolve: resolveGraphQLByID(func(r *graphqlRequest) *graphql.Loader { return r.folders }),
+		},
+		{
+			Name:        "folders",
+			Description: "The subfolders of parentId, or of the root when it is not given",
+			Type:        nonNull(pageOf("FolderPage", folder)),
+			Args:        append([]*graphql.ArgumentDefinition{{Name: "parentId", Type: graphql.ID}}, pageArgs...),
+			Resolve:     resolveGraphQLFolders,
+		},
+		{
+			Name:        "shares",
+			Description: "The user's active share links, newest first, optionally only of one kind (file or folder)",
+			Type:        listOf(share),
+			Args:        []*graphql.ArgumentDefinition{{Name: "kind", Type: graphql.String}},
+			Resolve:     resolveGraphQLShares,
+		},
+		{
+			Name:    "usage",
+			Type:    usage,
+			Resolve: resolveGraphQLUsage,
+		},
+		{
+			Name: "plans",
+			Type: listOf(plan),
+			Resolve: func(ctx context.Context, _ interface{}, _ map[string]interface{}) (interface{}, error) {
+				plans, err := NewPlanService().GetPlans(ctx)
+				if err != nil {
+					return nil, fmt.Errorf("failed to get plans")
+				}
+				return graphqlPointers(plans), nil
+			},
+		},
+		{
+			Name:    "plan",
+			Type:    plan,
+			Args:    idArg,
+			Resolve: resolveGraphQLByID(func(r *graphqlRequest) *graphql.Loader { return r.plans }),
+		},
+	}}
+
+	return graphql.NewSchema(query)
+}
+
+// graphqlField is a field read straight from a record of type T
+func graphqlField[T any](name string, t graphql.Type, get func(*T) interface{}) *graphql.FieldDefinition {
+	return &graphql.FieldDefinition{
+		Name: name,
+		Type: t,
+		Resolve: func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+			return get(source.(*T)), nil
+		},
+	}
+}
+
+// graphqlRelation is a field holding the record another ID refers to,
+// loaded for every parent record at once
+func graphqlRelation[T any](name string, t graphql.Type, description string, loader func(*graphqlRequest) *graphql.Loader, key func(*T) string) *graphql.FieldDefinition {
+	return &graphql.FieldDefinition{
+		Name:        name,
+		Description: description,
+		Type:        t,
+		BatchResolve: func(ctx context.Context, sources []interface{}, _ map[string]interface{}) ([]interface{}, error) {
+			keys := make([]string, len(sources))
+			for i, source := range sources {
+				keys[i] = key(source.(*T))
+			}
+			values, err := loader(graphqlRequestFrom(ctx)).LoadMany(ctx, keys)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load %s", name)
+			}
+			return values, nil
+		},
+	}
+}
+
+// graphqlShareRelation is the share field of a file or folder
+func graphqlShareRelation[T any](share *graphql.Object, kind string, loader func(*graphqlRequest) *graphql.Loader, key func(*T) string) *graphql.FieldDefinition {
+	field := graphqlRelation("share", share, "The "+kind+"'s active share link", loader, key)
+	load := field.BatchResolve
+	field.BatchResolve = func(ctx context.Context, sources []interface{}, args map[string]interface{}) ([]interface{}, error) {
+		values, err := load(ctx, sources, args)
+		if err != nil {
+			return nil, err
+		}
+		for i, value := range values {
+			if value != nil {
+				values[i] = &graphqlShare{FileShare: value.(*models.FileShare), kind: kind}
+			}
+		}
+		return values, nil
+	}
+	return field
+}
+
+func resolveGraphQLByID(loader func(*graphqlRequest) *graphql.Loader) graphql.ResolveFunc {
+	return func(ctx context.Context, _ interface{}, args map[string]interface{}) (interface{}, error) {
+		id := args["id"].(string)
+		if !utils.IsValidObjectID(id) {
+			return nil, fmt.Errorf("invalid ID")
+		}
+		values, err := loader(graphqlRequestFrom(ctx)).LoadMany(ctx, []string{id})
+		if err != nil {
+			return nil, fmt.Errorf("failed to load record")
+		}
+		return values[0], nil
+	}
+}
+
+func resolveGraphQLFiles(ctx context.Context, _ interface{}, args map[string]interface{}) (interface{}, error) {
+	r := graphqlRequestFrom(ctx)
+	page, limit := graphqlPagination(args)
+
+	filters := &FileFilters{SortBy: "name", SortOrder: "asc"}
+	filters.FolderID, _ = args["folderId"].(string)
+	filters.Search, _ = args["search"].(string)
+	if filters.FolderID != "" && filters.FolderID != "root" && !utils.IsValidObjectID(filters.FolderID) {
+		return nil, fmt.Errorf("invalid folder ID")
+	}
+
+	files, total, err := NewFileService().GetUserFiles(ctx, r.user.ID, page, limit, filters)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get files")
+	}
+	items := graphqlPointers(files)
+	for _, f := range items {
+		r.files.Prime(f.ID.Hex(), f)
+	}
+	return &graphqlPage{items: items, meta: newGraphQLMeta(page, limit, total)}, nil
+}
+
+func resolveGraphQLFolders(ctx context.Context, _ interface{}, args map[string]interface{}) (interface{}, error) {
+	r := graphqlRequestFrom(ctx)
+	page, limit := graphqlPagination(args)
+
+	parentID, _ := args["parentId"].(string)
+	search, _ := args["search"].(string)
+	if parentID != "" && parentID != "root" && !utils.IsValidObjectID(parentID) {
+		return nil, fmt.Errorf("invalid parent folder ID")
+	}
+
+	folders, total, err := NewFolderService().GetUserFolders(ctx, r.user.ID, parentID, search, page, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get folders")
+	}
+	items := graphqlPointers(folders)
+	for _, f := range items {
+		r.folders.Prime(f.ID.Hex(), f)
+	}
+	return &graphqlPage{items: items, meta: newGraphQLMeta(page, limit, total)}, nil
+}
+
+func resolveGraphQLShares(ctx context.Context, _ interface{}, args map[string]interface{}) (interface{}, error) {
+	r := graphqlRequestFrom(ctx)
+	kind, _ := args["kind"].(string)
+	if kind != "" && kind != "file" && kind != "folder" {
+		return nil, fmt.Errorf("kind must be file or folder")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var shares []*graphqlShare
+	collections := map[string]*mongo.Collection{
+		"file":   r.collections.FileShares(),
+		"folder": database.GetCollection("folder_shares"),
+	}
+	for _, k := range []string{"file", "folder"} {
+		if kind != "" && kind != k {
+			continue
+		}
+		cursor, err := collections[k].Find(ctx,
+			bson.M{"user_id": r.user.ID, "is_active": true},
+			options.Find().SetSort(bson.M{"created_at": -1}),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get shares")
+		}
+		var found []models.FileShare
+		if err := cursor.All(ctx, &found); err != nil {
+			return nil, fmt.Errorf("failed to get shares")
+		}
+		for i := range found {
+			shares = append(shares, &graphqlShare{FileShare: &found[i], kind: k})
+		}
+	}
+
+	// Newest first across both kinds
+	sort.SliceStable(shares, func(i, j int) bool {
+		return shares[i].CreatedAt.After(shares[j].CreatedAt)
+	})
+	return shares, nil
+}
+
+func resolveGraphQLUsage(ctx context.Context, _ interface{}, _ map[string]interface{}) (interface{}, error) {
+	r := graphqlRequestFrom(ctx)
+	plans, err := r.plans.LoadMany(ctx, []string{r.user.PlanID.Hex()})
+	if err != nil || plans[0] == nil {
+		return nil, fmt.Errorf("failed to get plan")
+	}
+	return &graphqlUsage{user: r.user, plan: plans[0].(*models.Plan)}, nil
+}
+
+// resolveGraphQLChildren lists the subfolders of every folder at once
+func resolveGraphQLChildren(ctx context.Context, sources []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	r := graphqlRequestFrom(ctx)
+	parentIDs := graphqlSourceIDs(sources)
+	groups, err := loadGraphQLGroups[models.Folder](ctx, r.collections.Folders(), "parent_id", parentIDs,
+		bson.M{"user_id": r.user.ID, "is_deleted": false}, graphqlNestedLimit(args))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load subfolders")
+	}
+
+	values := make([]interface{}, len(sources))
+	for i, id := range parentIDs {
+		children := groups[id]
+		for _, child := range children {
+			r.folders.Prime(child.ID.Hex(), child)
+		}
+		values[i] = append([]*models.Folder{}, children...)
+	}
+	return values, nil
+}
+
+// resolveGraphQLFolderFiles lists the files of every folder at once
+func resolveGraphQLFolderFiles(ctx context.Context, sources []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	r := graphqlRequestFrom(ctx)
+	folderIDs := graphqlSourceIDs(sources)
+	groups, err := loadGraphQLGroups[models.File](ctx, r.collections.Files(), "folder_id", folderIDs,
+		bson.M{"user_id": r.user.ID, "is_deleted": false}, graphqlNestedLimit(args))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load files")
+	}
+
+	values := make([]interface{}, len(sources))
+	for i, id := range folderIDs {
+		files := groups[id]
+		for _, f := range files {
+			r.files.Prime(f.ID.Hex(), f)
+		}
+		values[i] = append([]*models.File{}, files...)
+	}
+	return values, nil
+}
+
+func (s *graphqlShare) url(ctx context.Context) string {
+	return ShareLinkURL(ctx, s.FileShare, s.kind)
+}
+
+// targetKey is the ID of the shared record when the share is of kind
+func (s *graphqlShare) targetKey(kind string) string {
+	if s.kind != kind {
+		return ""
+	}
+	return s.FileID.Hex()
+}
+
+func graphqlSourceIDs(sources []interface{}) []primitive.ObjectID {
+	ids := make([]primitive.ObjectID, len(sources))
+	for i, source := range sources {
+		ids[i] = source.(*models.Folder).ID
+	}
+	return ids
+}
+
+func graphqlPagination(args map[string]interface{}) (int, int) {
+	page, _ := args["page"].(int)
+	limit, _ := args["limit"].(int)
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > graphqlMaxLimit {
+		limit = graphqlDefaultLimit
+	}
+	return page, limit
+}
+
+func graphqlNestedLimit(args map[string]interface{}) int {
+	limit, _ := args["limit"].(int)
+	if limit < 1 {
+		return 1
+	}
+	return min(limit, graphqlMaxNestedLimit)
+}
+
+func newGraphQLMeta(page, limit, total int) *models.Meta {
+	totalPages := (total + limit - 1) / limit
+	return &models.Meta{
+		Page:       page,
+		Limit:      limit,
+		Total:      total,
+		TotalPages: totalPages,
+		HasNext:    page < totalPages,
+		HasPrev:    page > 1,
+	}
+}
+
+// graphqlPointers returns pointers to the records, which is what the
+// schema's resolvers take as their source
+func graphqlPointers[T any](records []T) []*T {
+	pointers := make([]*T, len(records))
+	for i := range records {
+		pointers[i] = &records[i]
+	}
+	return pointers
+}
+
+func objectIDKey(id *primitive.ObjectID) string {
+	if id == nil {
+		return ""
+	}
+	return id.Hex()
+}
+
+func nonNilStrings(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
@@ -0,0 +1,322 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"oncloud/models"
+	"strconv"
+	"strings"
+)
+
+const (
+	HelpdeskZendesk   = "zendesk"
+	HelpdeskFreshdesk = "freshdesk"
+	HelpdeskWebhook   = "webhook"
+)
+
+// errHelpdeskUnauthorized is returned when the helpdesk rejects the
+// configured credentials
+var errHelpdeskUnauthorized = errors.New("helpdesk rejected the configured credentials")
+
+// HelpdeskOptions configures the external helpdesk tickets are exported to.
+// URL is the helpdesk's base URL, or the endpoint for webhooks. Email is
+// the Zendesk agent the API token belongs to; WebhookSecret signs webhook
+// deliveries.
+type HelpdeskOptions struct {
+	Provider      string
+	URL           string
+	Email         string
+	APIKey        string
+	WebhookSecret string
+	AutoExport    bool
+}
+
+// helpdeskTicket is what is exported of a support ticket
+type helpdeskTicket struct {
+	Ticket *models.SupportTicket
+	User   *models.User
+}
+
+// helpdeskExporter is an external helpdesk support tickets are exported to.
+// Adding a helpdesk means implementing this and registering it in
+// InitHelpdesk.
+type helpdeskExporter interface {
+	Name() string
+	// Export creates the ticket in the helpdesk and returns where it is
+	Export(ctx context.Context, ticket *helpdeskTicket) (*models.SupportTicketExternal, error)
+}
+
+var (
+	activeHelpdesk     helpdeskExporter
+	helpdeskAutoExport bool
+)
+
+// InitHelpdesk enables exporting support tickets to the configured helpdesk
+func InitHelpdesk(opts HelpdeskOptions) {
+	baseURL := strings.TrimRight(opts.URL, "/")
+	switch opts.Provider {
+	case HelpdeskZendesk:
+		activeHelpdesk = &zendeskExporter{baseURL: baseURL, email: opts.Email, apiToken: opts.APIKey}
+	case HelpdeskFreshdesk:
+		activeHelpdesk = &freshdeskExporter{baseURL: baseURL, apiKey: opts.APIKey}
+	case HelpdeskWebhook:
+		activeHelpdesk = &webhookExporter{url: opts.URL, secret: opts.WebhookSecret}
+	default:
+		activeHelpdesk = nil
+	}
+	helpdeskAutoExport = activeHelpdesk != nil && opts.AutoExport
+}
+
+// helpdeskRequest sends a JSON request to the helpdesk and decodes its JSON
+// response into out
+func helpdeskRequest(ctx context.Context, out interface{}, newRequest func() (*http.Request, error)) error {
+	resp, err := cloudRequest(ctx, newRequest)
+	if err != nil {
+		if errors.Is(err, errCloudUnauthorized) {
+			return errHelpdeskUnauthorized
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("invalid response from helpdesk: %v", err)
+	}
+	return nil
+}
+
+func helpdeskJSONRequest(method, rawURL string, payload []byte) (*http.Request, error) {
+	req, err := http.NewRequest(method, rawURL, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	return req, nil
+}
+
+// helpdeskDescription is the ticket's description followed by the account
+// and files it is about, for agents working in the helpdesk
+func helpdeskDescription(ticket *helpdeskTicket) string {
+	var b strings.Builder
+	b.WriteString(ticket.Ticket.Description)
+	if b.Len() > 0 {
+		b.WriteString("\n\n")
+	}
+	fmt.Fprintf(&b, "User ID: %s", ticket.User.ID.Hex())
+	for _, fileID := range ticket.Ticket.FileIDs {
+		fmt.Fprintf(&b, "\nFile ID: %s", fileID.Hex())
+	}
+	return b.String()
+}
+
+func helpdeskRequesterName(user *models.User) string {
+	name := strings.TrimSpace(user.FirstName + " " + user.LastName)
+	if name == "" {
+		return user.Username
+	}
+	return name
+}
+
+// Zendesk
+
+type zendeskExporter struct {
+	baseURL  string
+	email    string
+	apiToken string
+}
+
+// zendeskStatuses maps ticket statuses to Zendesk's
+var zendeskStatuses = map[string]string{
+	models.TicketOpen:     "open",
+	models.TicketPending:  "pending",
+	models.TicketResolved: "solved",
+	models.TicketClosed:   "closed",
+}
+
+func (z *zendeskExporter) Name() string {
+	return HelpdeskZendesk
+}
+
+func (z *zendeskExporter) Export(ctx context.Context, ticket *helpdeskTicket) (*models.SupportTicketExternal, error) {
+	payload, err := json.Marshal(map[string]interface{}{
+		"ticket": map[string]interface{}{
+			"subject":     ticket.Ticket.Subject,
+			"comment":     map[string]interface{}{"body": helpdeskDescription(ticket), "public": false},
+			"priority":    ticket.Ticket.Priority,
+			"status":      zendeskStatuses[ticket.Ticket.Status],
+			"external_id": ticket.Ticket.ID.Hex(),
+			"tags":        []string{"oncloud"},
+			"requester": map[string]interface{}{
+				"name":  helpdeskRequesterName(ticket.User),
+				"email": ticket.User.Email,
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var created struct {
+		Ticket struct {
+			ID int64 `json:"id"`
+		} `json:"ticket"`
+	}
+	err = helpdeskRequest(ctx, &created, func() (*http.Request, error) {
+		req, err := helpdeskJSONRequest(http.MethodPost, z.baseURL+"/api/v2/tickets.json", payload)
+		if err != nil {
+			return nil, err
+		}
+		req.SetBasicAuth(z.email+"/token", z.apiToken)
+		return req, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	id := strconv.FormatInt(created.Ticket.ID, 10)
+	return &models.SupportTicketExternal{
+		Provider: HelpdeskZendesk,
+		ID:       id,
+		URL:      z.baseURL + "/agent/tickets/" + id,
+	}, nil
+}
+
+// Freshdesk
+
+type freshdeskExporter struct {
+	baseURL string
+	apiKey  string
+}
+
+// Freshdesk identifies priorities and statuses by number
+var (
+	freshdeskPriorities = map[string]int{
+		models.TicketPriorityLow:    1,
+		models.TicketPriorityNormal: 2,
+		models.TicketPriorityHigh:   3,
+		models.TicketPriorityUrgent: 4,
+	}
+	freshdeskStatuses = map[string]int{
+		models.TicketOpen:     2,
+		models.TicketPending:  3,
+		models.TicketResolved: 4,
+		models.TicketClosed:   5,
+	}
+)
+
+func (f *freshdeskExporter) Name() string {
+	return HelpdeskFreshdesk
+}
+
+func (f *freshdeskExporter) Export(ctx context.Context, ticket *helpdeskTicket) (*models.SupportTicketExternal, error) {
+	description := strings.ReplaceAll(html.EscapeString(helpdeskDescription(ticket)), "\n", "<br>")
+	payload, err := json.Marshal(map[string]interface{}{
+		"subject":     ticket.Ticket.Subject,
+		"description": description,
+		"email":       ticket.User.Email,
+		"name":        helpdeskRequesterName(ticket.User),
+		"priority":    freshdeskPriorities[ticket.Ticket.Priority],
+		"status":      freshdeskStatuses[ticket.Ticket.Status],
+		"tags":        []string{"oncloud"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	err = helpdeskRequest(ctx, &created, func() (*http.Request, error) {
+		req, err := helpdeskJSONRequest(http.MethodPost, f.baseURL+"/api/v2/tickets", payload)
+		if err != nil {
+			return nil, err
+		}
+		// Freshdesk takes the API key as the username with any password
+		req.SetBasicAuth(f.apiKey, "X")
+		return req, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	id := strconv.FormatInt(created.ID, 10)
+	return &models.SupportTicketExternal{
+		Provider: HelpdeskFreshdesk,
+		ID:       id,
+		URL:      f.baseURL + "/a/tickets/" + id,
+	}, nil
+}
+
+// Webhook
+
+// webhookExporter posts tickets to an endpoint of the operator's own, for
+// helpdesks without a built-in exporter. Deliveries are signed with
+// HMAC-SHA256 of the body in the X-OnCloud-Signature header. The endpoint
+// may answer with the id and url of the ticket it created.
+type webhookExporter struct {
+	url    string
+	secret string
+}
+
+func (w *webhookExporter) Name() string {
+	return HelpdeskWebhook
+}
+
+func (w *webhookExporter) Export(ctx context.Context, ticket *helpdeskTicket) (*models.SupportTicketExternal, error) {
+	payload, err := json.Marshal(map[string]interface{}{
+		"event":  "support_ticket.exported",
+		"ticket": ticket.Ticket,
+		"user": map[string]interface{}{
+			"id":       ticket.User.ID,
+			"email":    ticket.User.Email,
+			"username": ticket.User.Username,
+			"name":     helpdeskRequesterName(ticket.User),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		ID  string `json:"id"`
+		URL string `json:"url"`
+	}
+	resp, err := cloudRequest(ctx, func() (*http.Request, error) {
+		req, err := helpdeskJSONRequest(http.MethodPost, w.url, payload)
+		if err != nil {
+			return nil, err
+		}
+		if w.secret != "" {
+			mac := hmac.New(sha256.New, []byte(w.secret))
+			mac.Write(payload)
+			req.Header.Set("X-OnCloud-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+		}
+		return req, nil
+	})
+	if err != nil {
+		if errors.Is(err, errCloudUnauthorized) {
+			return nil, errHelpdeskUnauthorized
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// The response body is optional
+	json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&response)
+
+	return &models.SupportTicketExternal{
+		Provider: HelpdeskWebhook,
+		ID:       response.ID,
+		URL:      response.URL,
+	}, nil
+}
@@ -0,0 +1,546 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"oncloud/models"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// HotFileCacheOptions configures pre-warming the caches with popular files
+type HotFileCacheOptions struct {
+	// Window is how far back downloads are counted when looking for hot files
+	Window time.Duration
+	// LocalDir holds local copies of hot files for the downloads the server
+	// streams itself. Files are only cached locally when it is set.
+	LocalDir string
+}
+
+var hotFileCacheOptions = &HotFileCacheOptions{Window: 24 * time.Hour}
+
+const (
+	// fileHitRetention is how long hourly download counts are kept
+	fileHitRetention = 7 * 24 * time.Hour
+	// maxHotFiles caps the files considered on a warming run
+	maxHotFiles = 1000
+	// edgeRewarmInterval is how often files warmed in the CDN are fetched
+	// through it again, so edges that evicted them get them back
+	edgeRewarmInterval = 6 * time.Hour
+)
+
+var ErrCacheWarmRunning = errors.New("cache warming is already running")
+
+// cacheWarmMu keeps a warming run started by an admin from racing the
+// scheduled one
+var cacheWarmMu sync.Mutex
+
+// InitHotFileCache configures pre-warming the caches with popular files
+func InitHotFileCache(opts HotFileCacheOptions) {
+	hotFileCacheOptions = &opts
+	if opts.LocalDir != "" {
+		if err := os.MkdirAll(opts.LocalDir, 0755); err != nil {
+			log.Printf("Warning: Could not create local cache directory %s: %v", opts.LocalDir, err)
+		}
+	}
+}
+
+type HotFileCacheService struct {
+	*BaseService
+	storageService *StorageService
+	httpClient     *http.Client
+}
+
+func NewHotFileCacheService() *HotFileCacheService {
+	return NewHotFileCacheServiceWith(Dependencies{})
+}
+
+// NewHotFileCacheServiceWith creates a hot file cache service on the given
+// dependencies
+func NewHotFileCacheServiceWith(deps Dependencies) *HotFileCacheService {
+	return &HotFileCacheService{
+		BaseService:    NewBaseServiceWith(deps),
+		storageService: NewStorageServiceWith(deps),
+		httpClient:     &http.Client{Timeout: 5 * time.Minute},
+	}
+}
+
+// hotFileCandidate is a file downloaded at least the threshold times within
+// the window
+type hotFileCandidate struct {
+	file *models.File
+	hits int64
+}
+
+// RecordHit counts a download of a public or shared file in the background,
+// so downloads never wait on it
+func (hs *HotFileCacheService) RecordHit(fileID primitive.ObjectID) {
+	if RuntimeSettingInt64(RuntimeSettingHotFileThreshold) <= 0 {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		hour := hs.clock.Now().UTC().Truncate(time.Hour)
+		filter := bson.M{"file_id": fileID, "hour": hour}
+		update := bson.M{
+			"$inc":         bson.M{"count": 1},
+			"$setOnInsert": bson.M{"expires_at": hour.Add(fileHitRetention)},
+		}
+		_, err := hs.collections.FileHits().UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+		// Concurrent first hits of an hour race to insert its bucket
+		if mongo.IsDuplicateKeyError(err) {
+			_, err = hs.collections.FileHits().UpdateOne(ctx, filter, update)
+		}
+		if err != nil {
+			log.Printf("Failed to record download of file %s: %v", fileID.Hex(), err)
+		}
+	}()
+}
+
+// EdgeURL returns the CDN URL of a hot public file warmed in the CDN, or ""
+// when its downloads should not be sent there
+func (hs *HotFileCacheService) EdgeURL(ctx context.Context, file *models.File) string {
+	if !file.IsPublic || file.Receipts != nil || file.Compression != nil || RuntimeSettingInt64(RuntimeSettingHotFileThreshold) <= 0 {
+		return ""
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	record, err := hs.findCurrent(ctx, file, bson.M{"edge_url": bson.M{"$exists": true}})
+	if err != nil {
+		return ""
+	}
+	return record.EdgeURL
+}
+
+// Content returns a file's content for the server to stream, read from the
+// local cache when the file is hot. The caller closes it.
+func (hs *HotFileCacheService) Content(ctx context.Context, file *models.File) (io.ReadSeekCloser, error) {
+	if cached := hs.openCached(ctx, file); cached != nil {
+		return cached, nil
+	}
+
+	content, err := hs.storageService.OpenFileContent(ctx, file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file content: %w", err)
+	}
+	return content, nil
+}
+
+// bufferedContent is file content downloaded into memory
+type bufferedContent struct {
+	*bytes.Reader
+}
+
+func (bufferedContent) Close() error { return nil }
+
+// openCached opens the local copy of a hot file, or returns nil when it is
+// not cached locally
+func (hs *HotFileCacheService) openCached(ctx context.Context, file *models.File) *os.File {
+	if hotFileCacheOptions.LocalDir == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	record, err := hs.findCurrent(ctx, file, bson.M{"local_path": bson.M{"$exists": true}})
+	if err != nil {
+		return nil
+	}
+	cached, err := os.Open(record.LocalPath)
+	if err != nil {
+		return nil
+	}
+	return cached
+}
+
+// findCurrent returns the hot file record of a file, provided it still
+// caches the file's current content
+func (hs *HotFileCacheService) findCurrent(ctx context.Context, file *models.File, filter bson.M) (*models.HotFile, error) {
+	filter["_id"] = file.ID
+	filter["storage_key"] = file.StorageKey
+	filter["hash"] = file.Hash
+
+	var record models.HotFile
+	if err := hs.collections.HotFiles().FindOne(ctx, filter).Decode(&record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// ListHotFiles lists the files kept warm in the caches, most downloaded first
+func (hs *HotFileCacheService) ListHotFiles(ctx context.Context, page, limit int) ([]models.HotFile, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	total, err := hs.collections.HotFiles().CountDocuments(ctx, bson.M{})
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count hot files: %v", err)
+	}
+
+	skip := (page - 1) * limit
+	cursor, err := hs.collections.HotFiles().Find(ctx, bson.M{}, options.Find().
+		SetSort(bson.D{{Key: "hits", Value: -1}, {Key: "_id", Value: 1}}).
+		SetSkip(int64(skip)).
+		SetLimit(int64(limit)))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get hot files: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	files := []models.HotFile{}
+	if err := cursor.All(ctx, &files); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode hot files: %v", err)
+	}
+
+	return files, int(total), nil
+}
+
+// WarmCache keeps the most downloaded public and shared files warm in the
+// caches, within the edge and local cache budgets. Files are taken in order
+// of popularity; those that no longer fit, are no longer hot or can no
+// longer be downloaded are evicted.
+func (hs *HotFileCacheService) WarmCache(ctx context.Context) (*models.CacheWarmRun, error) {
+	if !cacheWarmMu.TryLock() {
+		return nil, ErrCacheWarmRunning
+	}
+	defer cacheWarmMu.Unlock()
+
+	run := &models.CacheWarmRun{StartedAt: hs.clock.Now()}
+
+	candidates, err := hs.findHotFiles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	current, err := hs.cachedFiles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cdnURLs, err := hs.cdnURLs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	run.HotFiles = len(candidates)
+
+	edgeBudget := RuntimeSettingInt64(RuntimeSettingEdgeCacheBudget)
+	localBudget := RuntimeSettingInt64(RuntimeSettingLocalCacheBudget)
+	if hotFileCacheOptions.LocalDir == "" {
+		localBudget = 0
+	}
+
+	kept := make(map[primitive.ObjectID]bool)
+	for _, candidate := range candidates {
+		file := candidate.file
+		record := current[file.ID]
+		if record != nil && (record.StorageKey != file.StorageKey || record.Hash != file.Hash) {
+			// The file's content changed since it was cached
+			hs.dropEdge(ctx, record)
+			hs.dropLocal(record)
+		}
+		if record == nil {
+			record = &models.HotFile{ID: file.ID, CreatedAt: hs.clock.Now()}
+		}
+		record.UserID = file.UserID
+		record.Name = file.OriginalName
+		record.StorageProvider = file.StorageProvider
+		record.StorageKey = file.StorageKey
+		record.Hash = file.Hash
+		record.Size = file.Size
+		record.Hits = candidate.hits
+
+		// Only public files are sent to the CDN, whose URLs bypass share
+		// passwords, expiries and download limits. Files stored compressed
+		// are not, since the CDN would serve them compressed.
+		cdnURL := cdnURLs[file.StorageProvider]
+		if file.IsPublic && file.Receipts == nil && file.Compression == nil && cdnURL != "" && run.EdgeBytes+file.Size <= edgeBudget {
+			warmed, err := hs.warmEdge(record, cdnURL)
+			if err != nil {
+				log.Printf("Failed to warm file %s in the CDN: %v", file.ID.Hex(), err)
+				run.Failed++
+				hs.dropEdge(ctx, record)
+			} else {
+				run.EdgeFiles++
+				run.EdgeBytes += file.Size
+				if warmed {
+					run.Warmed++
+				}
+			}
+		} else {
+			hs.dropEdge(ctx, record)
+		}
+
+		if run.LocalBytes+file.Size <= localBudget {
+			cached, err := hs.cacheLocally(ctx, record, file)
+			if err != nil {
+				log.Printf("Failed to cache file %s locally: %v", file.ID.Hex(), err)
+				run.Failed++
+				hs.dropLocal(record)
+			} else {
+				run.LocalFiles++
+				run.LocalBytes += file.Size
+				if cached {
+					run.Warmed++
+				}
+			}
+		} else {
+			hs.dropLocal(record)
+		}
+
+		if record.EdgeURL == "" && record.LocalPath == "" {
+			continue
+		}
+		if err := hs.saveHotFile(ctx, record); err != nil {
+			log.Printf("Failed to save hot file %s: %v", file.ID.Hex(), err)
+			continue
+		}
+		kept[file.ID] = true
+	}
+
+	for id, record := range current {
+		if kept[id] {
+			continue
+		}
+		hs.dropEdge(ctx, record)
+		hs.dropLocal(record)
+		if err := hs.deleteHotFile(ctx, id); err != nil {
+			log.Printf("Failed to delete hot file %s: %v", id.Hex(), err)
+			continue
+		}
+		run.Evicted++
+	}
+
+	run.CompletedAt = hs.clock.Now()
+	return run, nil
+}
+
+// findHotFiles returns the public and shared files downloaded at least the
+// threshold times within the window that can still be downloaded, most
+// downloaded first
+func (hs *HotFileCacheService) findHotFiles(ctx context.Context) ([]hotFileCandidate, error) {
+	threshold := RuntimeSettingInt64(RuntimeSettingHotFileThreshold)
+	if threshold <= 0 {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	since := hs.clock.Now().UTC().Add(-hotFileCacheOptions.Window).Truncate(time.Hour)
+	cursor, err := hs.collections.FileHits().Aggregate(ctx, []bson.M{
+		{"$match": bson.M{"hour": bson.M{"$gte": since}}},
+		{"$group": bson.M{"_id": "$file_id", "hits": bson.M{"$sum": "$count"}}},
+		{"$match": bson.M{"hits": bson.M{"$gte": threshold}}},
+		{"$sort": bson.D{{Key: "hits", Value: -1}, {Key: "_id", Value: 1}}},
+		{"$limit": maxHotFiles},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to count downloads: %v", err)
+	}
+	var counts []struct {
+		FileID primitive.ObjectID `bson:"_id"`
+		Hits   int64              `bson:"hits"`
+	}
+	if err := cursor.All(ctx, &counts); err != nil {
+		return nil, fmt.Errorf("failed to decode download counts: %v", err)
+	}
+	if len(counts) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]primitive.ObjectID, len(counts))
+	for i, count := range counts {
+		ids[i] = count.FileID
+	}
+	cursor, err = hs.collections.Files().Find(ctx, bson.M{
+		"_id":        bson.M{"$in": ids},
+		"is_deleted": false,
+		"$or":        []bson.M{{"is_public": true}, {"is_shared": true}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get hot files: %v", err)
+	}
+	var files []models.File
+	if err := cursor.All(ctx, &files); err != nil {
+		return nil, fmt.Errorf("failed to decode hot files: %v", err)
+	}
+
+	byID := make(map[primitive.ObjectID]*models.File, len(files))
+	for i := range files {
+		file := &files[i]
+		if checkFileExpiry(file) != nil || checkFileArchived(file) != nil ||
+			checkFileScan(file) != nil || checkTakedown(file.TakedownID) != nil {
+			continue
+		}
+		byID[file.ID] = file
+	}
+
+	candidates := []hotFileCandidate{}
+	for _, count := range counts {
+		if file, ok := byID[count.FileID]; ok {
+			candidates = append(candidates, hotFileCandidate{file: file, hits: count.Hits})
+		}
+	}
+	return candidates, nil
+}
+
+// cachedFiles returns the files currently kept warm by file ID
+func (hs *HotFileCacheService) cachedFiles(ctx context.Context) (map[primitive.ObjectID]*models.HotFile, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	cursor, err := hs.collections.HotFiles().Find(ctx, bson.M{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get hot files: %v", err)
+	}
+	var records []models.HotFile
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, fmt.Errorf("failed to decode hot files: %v", err)
+	}
+
+	cached := make(map[primitive.ObjectID]*models.HotFile, len(records))
+	for i := range records {
+		cached[records[i].ID] = &records[i]
+	}
+	return cached, nil
+}
+
+// cdnURLs returns the CDN URL of each active provider served through one
+func (hs *HotFileCacheService) cdnURLs(ctx context.Context) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	cursor, err := hs.collections.StorageProviders().Find(ctx, bson.M{
+		"is_active": true,
+		"cdn_url":   bson.M{"$nin": []interface{}{"", nil}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get storage providers: %v", err)
+	}
+	var providers []models.StorageProvider
+	if err := cursor.All(ctx, &providers); err != nil {
+		return nil, fmt.Errorf("failed to decode storage providers: %v", err)
+	}
+
+	urls := make(map[string]string, len(providers))
+	for _, provider := range providers {
+		urls[provider.Type] = strings.TrimRight(provider.CDNUrl, "/")
+	}
+	return urls, nil
+}
+
+// warmEdge fetches a file through the CDN so its edges cache it, unless it
+// was warmed recently. It reports whether the file was fetched.
+func (hs *HotFileCacheService) warmEdge(record *models.HotFile, cdnURL string) (bool, error) {
+	url := fmt.Sprintf("%s/%s", cdnURL, record.StorageKey)
+	if record.EdgeURL == url && record.EdgeWarmedAt != nil && hs.clock.Now().Sub(*record.EdgeWarmedAt) < edgeRewarmInterval {
+		return false, nil
+	}
+
+	resp, err := hs.httpClient.Get(url)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("CDN answered %s", resp.Status)
+	}
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return false, err
+	}
+
+	now := hs.clock.Now()
+	record.EdgeURL = url
+	record.EdgeWarmedAt = &now
+	return true, nil
+}
+
+// dropEdge stops sending a file's downloads to the CDN and invalidates it
+// there, so files made private or blocked stop being served by the edges
+func (hs *HotFileCacheService) dropEdge(ctx context.Context, record *models.HotFile) {
+	if record.EdgeURL == "" {
+		return
+	}
+	if _, err := hs.storageService.InvalidateCDN(ctx, []string{"/" + record.StorageKey}); err != nil {
+		log.Printf("Failed to invalidate file %s in the CDN: %v", record.ID.Hex(), err)
+	}
+	record.EdgeURL = ""
+	record.EdgeWarmedAt = nil
+}
+
+// cacheLocally copies a file's content, decompressed, to the local cache
+// unless it is there already. It reports whether the file was copied.
+func (hs *HotFileCacheService) cacheLocally(ctx context.Context, record *models.HotFile, file *models.File) (bool, error) {
+	if record.LocalPath != "" {
+		if _, err := os.Stat(record.LocalPath); err == nil {
+			return false, nil
+		}
+	}
+
+	content, err := hs.storageService.ReadFileContent(ctx, file)
+	if err != nil {
+		return false, err
+	}
+
+	// Written aside and renamed, so downloads never read a partial copy
+	path := filepath.Join(hotFileCacheOptions.LocalDir, record.ID.Hex())
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0644); err != nil {
+		return false, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return false, err
+	}
+
+	now := hs.clock.Now()
+	record.LocalPath = path
+	record.LocalCachedAt = &now
+	return true, nil
+}
+
+// dropLocal removes a file's local copy
+func (hs *HotFileCacheService) dropLocal(record *models.HotFile) {
+	if record.LocalPath == "" {
+		return
+	}
+	if err := os.Remove(record.LocalPath); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove cached copy of file %s: %v", record.ID.Hex(), err)
+	}
+	record.LocalPath = ""
+	record.LocalCachedAt = nil
+}
+
+func (hs *HotFileCacheService) saveHotFile(ctx context.Context, record *models.HotFile) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	record.UpdatedAt = hs.clock.Now()
+	_, err := hs.collections.HotFiles().ReplaceOne(ctx, bson.M{"_id": record.ID}, record, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (hs *HotFileCacheService) deleteHotFile(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := hs.collections.HotFiles().DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
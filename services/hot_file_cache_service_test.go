@@ -0,0 +1,42 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"oncloud/models"
+	"testing"
+	"time"
+)
+
+func TestWarmEdgeRewarmsOnlyAfterInterval(t *testing.T) {
+	fetches := 0
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		w.Write([]byte("content"))
+	}))
+	defer cdn.Close()
+
+	clock := &fakeClock{now: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)}
+	hs := NewHotFileCacheServiceWith(Dependencies{Clock: clock})
+	record := &models.HotFile{StorageKey: "users/1/report.pdf"}
+
+	if fetched, err := hs.warmEdge(record, cdn.URL); err != nil || !fetched {
+		t.Fatalf("first warm fetched %v, err %v", fetched, err)
+	}
+	if !record.EdgeWarmedAt.Equal(clock.Now()) {
+		t.Fatalf("warmed at %v, want %v", record.EdgeWarmedAt, clock.Now())
+	}
+
+	clock.Advance(edgeRewarmInterval - time.Minute)
+	if fetched, err := hs.warmEdge(record, cdn.URL); err != nil || fetched {
+		t.Fatalf("warm before the interval fetched %v, err %v", fetched, err)
+	}
+
+	clock.Advance(time.Minute)
+	if fetched, err := hs.warmEdge(record, cdn.URL); err != nil || !fetched {
+		t.Fatalf("warm after the interval fetched %v, err %v", fetched, err)
+	}
+	if fetches != 2 {
+		t.Fatalf("CDN fetched %d times, want 2", fetches)
+	}
+}
@@ -0,0 +1,334 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"oncloud/models"
+	"oncloud/utils"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// InstantUploadOptions configures whose content an instant upload may reuse
+type InstantUploadOptions struct {
+	// Shared lets users reuse content stored by other users once they prove
+	// they have it. Otherwise only their own files, including those in the
+	// trash, are reused.
+	Shared bool
+}
+
+var instantUploadOptions = &InstantUploadOptions{}
+
+const (
+	uploadChallengeTTL = 5 * time.Minute
+	// uploadChallengeMaxLength caps how much content a challenge covers, and
+	// so how much a client has to read to answer it
+	uploadChallengeMaxLength = 64 * 1024
+)
+
+var (
+	ErrUploadChallengeInvalid = errors.New("upload challenge not found or expired")
+	ErrUploadProofMismatch    = forbiddenError("upload proof does not match the content")
+)
+
+// InitInstantUploads sets whose content instant uploads may reuse
+func InitInstantUploads(opts InstantUploadOptions) {
+	instantUploadOptions = &opts
+}
+
+// InstantUpload creates a file from content already in storage, copied
+// within the provider instead of sent by the client. The upload goes through
+// the same checks as any other. Content the user stored themselves is reused
+// as is; content stored by other users only after the client answers a
+// challenge proving it has the content, so knowing a hash is not enough to
+// obtain a file.
+func (fs *FileService) InstantUpload(ctx context.Context, user *models.User, req *models.InstantUploadRequest) (*models.InstantUploadResult, error) {
+	if req.ChallengeID != "" && req.Proof == "" {
+		return nil, ErrUploadProofMismatch
+	}
+
+	preflight, err := fs.PreflightUpload(ctx, user, &models.UploadPreflightRequest{
+		FileName: req.FileName,
+		Size:     req.Size,
+		FolderID: req.FolderID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !preflight.Allowed {
+		return &models.InstantUploadResult{Status: models.InstantUploadRefused, Issues: preflight.Issues}, nil
+	}
+
+	hash := strings.ToLower(req.SHA256)
+
+	// Files in use come before those in the trash
+	own, err := fs.findStoredContent(ctx, bson.M{
+		"user_id":     user.ID,
+		"sha256":      hash,
+		"size":        req.Size,
+		"takedown_id": bson.M{"$exists": false},
+	}, options.FindOne().SetSort(bson.M{"is_deleted": 1}))
+	if err != nil {
+		return nil, err
+	}
+	if own != nil {
+		if !own.IsDeleted {
+			return &models.InstantUploadResult{
+				Status:        models.InstantUploadRefused,
+				Issues:        []models.PreflightIssue{{Code: models.PreflightDuplicate, Message: "file already exists: " + own.Name}},
+				DuplicateFile: &models.PreflightFileRef{ID: own.ID.Hex(), Name: own.Name},
+			}, nil
+		}
+		return fs.createFromStoredContent(ctx, user.ID, own, preflight.MimeType, req)
+	}
+
+	if !instantUploadOptions.Shared {
+		return &models.InstantUploadResult{Status: models.InstantUploadNotFound}, nil
+	}
+
+	if req.ChallengeID == "" {
+		source, err := fs.findStoredContent(ctx, bson.M{
+			"user_id":     bson.M{"$ne": user.ID},
+			"sha256":      hash,
+			"size":        req.Size,
+			"takedown_id": bson.M{"$exists": false},
+			"scan.status": bson.M{"$nin": []string{models.ScanStatusPending, models.ScanStatusInfected}},
+		}, nil)
+		if err != nil {
+			return nil, err
+		}
+		if source == nil {
+			return &models.InstantUploadResult{Status: models.InstantUploadNotFound}, nil
+		}
+
+		challenge, err := fs.issueUploadChallenge(ctx, user.ID, source)
+		if err != nil {
+			return nil, err
+		}
+		return &models.InstantUploadResult{Status: models.InstantUploadChallenge, Challenge: challenge}, nil
+	}
+
+	source, err := fs.verifyUploadChallenge(ctx, user.ID, hash, req)
+	if err != nil {
+		return nil, err
+	}
+	if source == nil {
+		// The content was removed or replaced since the challenge was issued
+		return &models.InstantUploadResult{Status: models.InstantUploadNotFound}, nil
+	}
+	return fs.createFromStoredContent(ctx, user.ID, source, preflight.MimeType, req)
+}
+
+// findStoredContent returns the first file matching filter, or nil
+func (fs *FileService) findStoredContent(ctx context.Context, filter bson.M, opts *options.FindOneOptions) (*models.File, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if opts == nil {
+		opts = options.FindOne()
+	}
+
+	var file models.File
+	err := fs.collections.Files().FindOne(ctx, filter, opts).Decode(&file)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up content: %v", err)
+	}
+	return &file, nil
+}
+
+// issueUploadChallenge picks a random range of source's content for the user
+// to hash
+func (fs *FileService) issueUploadChallenge(ctx context.Context, userID primitive.ObjectID, source *models.File) (*models.UploadChallenge, error) {
+	nonce, err := utils.GenerateSecureToken(16)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate challenge: %v", err)
+	}
+
+	length := source.Size
+	if length > uploadChallengeMaxLength {
+		length = uploadChallengeMaxLength
+	}
+	offset, err := rand.Int(rand.Reader, big.NewInt(source.Size-length+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate challenge: %v", err)
+	}
+
+	now := fs.clock.Now()
+	challenge := &models.UploadChallenge{
+		ID:           primitive.NewObjectID(),
+		UserID:       userID,
+		SourceFileID: source.ID,
+		SHA256:       source.SHA256,
+		Size:         source.Size,
+		Nonce:        nonce,
+		Offset:       offset.Int64(),
+		Length:       length,
+		ExpiresAt:    now.Add(uploadChallengeTTL),
+		CreatedAt:    now,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if _, err := fs.collections.UploadChallenges().InsertOne(ctx, challenge); err != nil {
+		return nil, fmt.Errorf("failed to save challenge: %v", err)
+	}
+	return challenge, nil
+}
+
+// verifyUploadChallenge consumes the user's challenge and checks the proof
+// against the stored content. It returns the file holding the content, or nil
+// once no file does.
+func (fs *FileService) verifyUploadChallenge(ctx context.Context, userID primitive.ObjectID, hash string, req *models.InstantUploadRequest) (*models.File, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	challengeID, _ := utils.StringToObjectID(req.ChallengeID)
+
+	// A challenge is answered once, right or wrong
+	var challenge models.UploadChallenge
+	err := fs.collections.UploadChallenges().FindOneAndDelete(ctx, bson.M{
+		"_id":        challengeID,
+		"user_id":    userID,
+		"sha256":     hash,
+		"size":       req.Size,
+		"expires_at": bson.M{"$gt": fs.clock.Now()},
+	}).Decode(&challenge)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrUploadChallengeInvalid
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find challenge: %v", err)
+	}
+
+	source, err := fs.findStoredContent(ctx, bson.M{
+		"_id":         challenge.SourceFileID,
+		"sha256":      challenge.SHA256,
+		"takedown_id": bson.M{"$exists": false},
+	}, nil)
+	if err != nil || source == nil {
+		return nil, err
+	}
+
+	content, err := fs.storageService.ReadFileContent(ctx, source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stored content: %v", err)
+	}
+	if int64(len(content)) < challenge.Offset+challenge.Length {
+		return nil, nil
+	}
+
+	digest := sha256.New()
+	digest.Write([]byte(challenge.Nonce))
+	digest.Write(content[challenge.Offset : challenge.Offset+challenge.Length])
+	expected := hex.EncodeToString(digest.Sum(nil))
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(strings.ToLower(req.Proof))) != 1 {
+		return nil, ErrUploadProofMismatch
+	}
+
+	return source, nil
+}
+
+// createFromStoredContent creates the user's file from a copy of source's
+// content. The copy keeps source's scan and media metadata, which describe
+// the same bytes.
+func (fs *FileService) createFromStoredContent(ctx context.Context, userID primitive.ObjectID, source *models.File, mimeType string, req *models.InstantUploadRequest) (*models.InstantUploadResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	// The user's upload rules may file it elsewhere
+	upload := &models.FileUploadRequest{FolderID: req.FolderID}
+	fs.uploadRules.Apply(ctx, userID, &UploadAttributes{
+		FileName: req.FileName,
+		MimeType: mimeType,
+		Size:     source.Size,
+		Source:   models.UploadSourceUpload,
+		FolderID: req.FolderID,
+	}, upload)
+
+	var folderID *primitive.ObjectID
+	if upload.FolderID != "" {
+		fid, _ := utils.StringToObjectID(upload.FolderID)
+		folderID = &fid
+	}
+
+	ext := strings.ToLower(filepath.Ext(req.FileName))
+	name := utils.UniqueStorageName(req.FileName, ext)
+
+	fileID := primitive.NewObjectID()
+	storageKey, err := fs.storageService.NewStorageKey(ctx, source.StorageProvider, utils.StorageKeyVars{
+		UserID: userID.Hex(),
+		FileID: fileID.Hex(),
+		Name:   name,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to build storage key: %v", err)
+	}
+	if err := fs.storageService.CopyFile(ctx, source.StorageProvider, source.StorageKey, source.StorageProvider, storageKey); err != nil {
+		return nil, fmt.Errorf("failed to copy file in storage: %v", err)
+	}
+
+	file := &models.File{
+		ID:              fileID,
+		UserID:          userID,
+		FolderID:        folderID,
+		Name:            name,
+		OriginalName:    req.FileName,
+		Path:            storageKey,
+		Size:            source.Size,
+		MimeType:        mimeType,
+		Extension:       ext,
+		Hash:            source.Hash,
+		SHA256:          source.SHA256,
+		StorageProvider: source.StorageProvider,
+		StorageKey:      storageKey,
+		StorageBucket:   source.StorageBucket,
+		Compression:     source.Compression,
+		Tags:            NormalizeTags(upload.Tags),
+		Metadata:        map[string]interface{}{},
+		Media:           source.Media,
+		Scan:            source.Scan,
+		CreatedAt:       fs.clock.Now(),
+		UpdatedAt:       fs.clock.Now(),
+	}
+
+	err = fs.usage.ApplyChange(ctx, userID, func(ctx context.Context) (int64, int, error) {
+		if _, err := fs.collections.Files().InsertOne(ctx, file); err != nil {
+			return 0, 0, err
+		}
+		return file.Size, 1, nil
+	})
+	if err != nil {
+		fs.storageService.DeleteFile(ctx, source.StorageProvider, storageKey)
+		return nil, fmt.Errorf("failed to save file record: %v", err)
+	}
+
+	fs.refreshTags(ctx, file)
+	fs.trackFolderUsage(ctx, file, 1)
+
+	if utils.IsImageFile(req.FileName) {
+		go fs.generateThumbnailAsync(file)
+	}
+
+	return &models.InstantUploadResult{Status: models.InstantUploadCreated, File: file}, nil
+}
+
+func sha256Hex(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+
+	"oncloud/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// challengeFixture is another user's stored file and a service to challenge
+// uploads of its content with
+type challengeFixture struct {
+	fs      *FileService
+	clock   *fakeClock
+	source  *models.File
+	content []byte
+	userID  primitive.ObjectID
+}
+
+func newChallengeFixture(t *testing.T, size int) *challengeFixture {
+	t.Helper()
+	db := testDatabase(t)
+	store := newFakeObjectStore()
+	clock := &fakeClock{now: time.Date(2026, 4, 2, 9, 0, 0, 0, time.UTC)}
+	fs := NewFileServiceWith(Dependencies{Database: testDatabaseSource{db}, Storage: store, Clock: clock})
+	ctx := context.Background()
+
+	content := bytes.Repeat([]byte("instant upload challenge content "), size/33+1)[:size]
+	source := &models.File{
+		ID:              primitive.NewObjectID(),
+		UserID:          primitive.NewObjectID(),
+		Name:            "dataset.bin",
+		Size:            int64(size),
+		SHA256:          sha256Hex(content),
+		StorageProvider: "s3",
+		StorageKey:      "shared/dataset.bin",
+	}
+	if err := store.UploadFile(ctx, source.StorageProvider, source.StorageKey, content); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Collection("files").InsertOne(ctx, source); err != nil {
+		t.Fatal(err)
+	}
+	return &challengeFixture{fs: fs, clock: clock, source: source, content: content, userID: primitive.NewObjectID()}
+}
+
+// issue issues a challenge to the fixture's user for the source's content
+func (f *challengeFixture) issue(t *testing.T) *models.UploadChallenge {
+	t.Helper()
+	challenge, err := f.fs.issueUploadChallenge(context.Background(), f.userID, f.source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return challenge
+}
+
+// answer submits a proof over the given range of the content
+func (f *challengeFixture) answer(challenge *models.UploadChallenge, offset, length int64) (*models.File, error) {
+	digest := sha256.New()
+	digest.Write([]byte(challenge.Nonce))
+	digest.Write(f.content[offset : offset+length])
+	return f.fs.verifyUploadChallenge(context.Background(), f.userID, f.source.SHA256, &models.InstantUploadRequest{
+		FileName:    "dataset.bin",
+		Size:        f.source.Size,
+		SHA256:      f.source.SHA256,
+		ChallengeID: challenge.ID.Hex(),
+		Proof:       hex.EncodeToString(digest.Sum(nil)),
+	})
+}
+
+func TestVerifyUploadChallengeAcceptsCorrectProof(t *testing.T) {
+	f := newChallengeFixture(t, 200*1024)
+	challenge := f.issue(t)
+	if challenge.Length != uploadChallengeMaxLength {
+		t.Fatalf("challenge covers %d bytes, want %d", challenge.Length, uploadChallengeMaxLength)
+	}
+
+	source, err := f.answer(challenge, challenge.Offset, challenge.Length)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source == nil || source.ID != f.source.ID {
+		t.Fatalf("verified against %v, want the source file", source)
+	}
+
+	// A challenge is answered once
+	if _, err := f.answer(challenge, challenge.Offset, challenge.Length); !errors.Is(err, ErrUploadChallengeInvalid) {
+		t.Fatalf("second answer: %v, want ErrUploadChallengeInvalid", err)
+	}
+}
+
+func TestVerifyUploadChallengeRejectsWrongRange(t *testing.T) {
+	f := newChallengeFixture(t, 200*1024)
+	challenge := f.issue(t)
+
+	offset := challenge.Offset + 1
+	if offset+challenge.Length > f.source.Size {
+		offset = challenge.Offset - 1
+	}
+	if _, err := f.answer(challenge, offset, challenge.Length); !errors.Is(err, ErrUploadProofMismatch) {
+		t.Fatalf("proof over the wrong range: %v, want ErrUploadProofMismatch", err)
+	}
+
+	// A wrong answer uses the challenge up too
+	if _, err := f.answer(challenge, challenge.Offset, challenge.Length); !errors.Is(err, ErrUploadChallengeInvalid) {
+		t.Fatalf("right answer after a wrong one: %v, want ErrUploadChallengeInvalid", err)
+	}
+}
+
+func TestVerifyUploadChallengeRejectsExpiredChallenge(t *testing.T) {
+	f := newChallengeFixture(t, 200*1024)
+	challenge := f.issue(t)
+
+	f.clock.Advance(uploadChallengeTTL + time.Second)
+	if _, err := f.answer(challenge, challenge.Offset, challenge.Length); !errors.Is(err, ErrUploadChallengeInvalid) {
+		t.Fatalf("expired challenge: %v, want ErrUploadChallengeInvalid", err)
+	}
+}
+
+func TestVerifyUploadChallengeCoversWholeSmallFile(t *testing.T) {
+	f := newChallengeFixture(t, 10*1024)
+	challenge := f.issue(t)
+	if challenge.Offset != 0 || challenge.Length != f.source.Size {
+		t.Fatalf("challenge covers %d bytes from %d, want the whole %d byte file", challenge.Length, challenge.Offset, f.source.Size)
+	}
+
+	source, err := f.answer(challenge, 0, f.source.Size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source == nil || source.ID != f.source.ID {
+		t.Fatalf("verified against %v, want the source file", source)
+	}
+}
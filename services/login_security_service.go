@@ -0,0 +1,308 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"oncloud/models"
+	"oncloud/utils"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	loginChallengeTTL         = 10 * time.Minute
+	loginChallengeMaxAttempts = 5
+)
+
+// Reasons a login is considered suspicious
+const (
+	LoginReasonNewDevice   = "new_device"
+	LoginReasonNewLocation = "new_location"
+)
+
+var (
+	ErrLoginChallengeInvalid = errors.New("invalid verification code")
+	ErrLoginChallengeExpired = errors.New("verification has expired, please sign in again")
+)
+
+// LoginAssessment describes how familiar a login looks compared to the
+// user's previous sessions
+type LoginAssessment struct {
+	Suspicious bool
+	Reasons    []string
+	Device     string
+	Location   *models.GeoLocation
+}
+
+type LoginSecurityService struct {
+	*BaseService
+}
+
+func NewLoginSecurityService() *LoginSecurityService {
+	return NewLoginSecurityServiceWith(Dependencies{})
+}
+
+// NewLoginSecurityServiceWith creates a login security service on the given
+// dependencies
+func NewLoginSecurityServiceWith(deps Dependencies) *LoginSecurityService {
+	return &LoginSecurityService{
+		BaseService: NewBaseServiceWith(deps),
+	}
+}
+
+// AssessLogin flags logins from a device or country the user has never signed
+// in from before. Users without any session history are not flagged, and
+// lookup failures fail open.
+func (ls *LoginSecurityService) AssessLogin(ctx context.Context, user *models.User, clientIP, userAgent string) *LoginAssessment {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	assessment := &LoginAssessment{
+		Device:   utils.DescribeUserAgent(userAgent),
+		Location: utils.LocateClient(clientIP),
+	}
+
+	sessions := ls.collections.Sessions()
+	history, err := sessions.CountDocuments(ctx, bson.M{"user_id": user.ID})
+	if err != nil || history == 0 {
+		return assessment
+	}
+
+	known, err := sessions.CountDocuments(ctx, bson.M{"user_id": user.ID, "device": assessment.Device})
+	if err == nil && known == 0 {
+		assessment.Reasons = append(assessment.Reasons, LoginReasonNewDevice)
+	}
+
+	if country := assessment.Location.Country; country != "" {
+		known, err = sessions.CountDocuments(ctx, bson.M{"user_id": user.ID, "country": country})
+		if err == nil && known == 0 {
+			assessment.Reasons = append(assessment.Reasons, LoginReasonNewLocation)
+		}
+	}
+
+	assessment.Suspicious = len(assessment.Reasons) > 0
+	return assessment
+}
+
+// VerificationRequired reports whether suspicious logins must be verified for
+// the user. Admins can switch it off site-wide with the login_verification
+// setting and users can opt out in their own settings.
+func (ls *LoginSecurityService) VerificationRequired(ctx context.Context, userID primitive.ObjectID) bool {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var setting models.AdminSettings
+	if err := ls.collections.Settings().FindOne(ctx, bson.M{"key": "login_verification"}).Decode(&setting); err == nil {
+		if enabled, ok := setting.Value.(bool); ok && !enabled {
+			return false
+		}
+	}
+
+	userSettings, err := NewSettingsService().GetUserSettings(ctx, userID)
+	if err != nil {
+		return true
+	}
+	enabled, ok := userSettings["login_verification"].(bool)
+	return !ok || enabled
+}
+
+// StartChallenge holds a suspicious login for verification. Users with 2FA
+// confirm with their authenticator; everyone else is emailed a 6-digit code.
+func (ls *LoginSecurityService) StartChallenge(ctx context.Context, user *models.User, assessment *LoginAssessment, clientIP string) (*models.LoginChallenge, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	challengeID, err := utils.GenerateSecureToken(32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate challenge ID: %v", err)
+	}
+
+	now := time.Now()
+	challenge := &models.LoginChallenge{
+		ID:          primitive.NewObjectID(),
+		ChallengeID: challengeID,
+		UserID:      user.ID,
+		Method:      "email",
+		Reasons:     assessment.Reasons,
+		IPAddress:   assessment.Location.IP,
+		Location:    assessment.Location,
+		Device:      assessment.Device,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(loginChallengeTTL),
+	}
+
+	var code string
+	if ls.totpSecret(ctx, user.ID) != "" {
+		challenge.Method = "totp"
+	} else {
+		code, err = utils.GenerateNumericCode(6)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate verification code: %v", err)
+		}
+		challenge.CodeHash = utils.HashSHA256(code)
+	}
+
+	if _, err := ls.collections.LoginChallenges().InsertOne(ctx, challenge); err != nil {
+		return nil, fmt.Errorf("failed to create login challenge: %v", err)
+	}
+
+	if challenge.Method == "email" {
+		err = NewAuthService().sendEmailNotification(user.Email, "login_verification", map[string]string{
+			"name":     user.FirstName + " " + user.LastName,
+			"code":     code,
+			"device":   assessment.Device,
+			"location": describeLocation(assessment.Location),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to send verification code: %v", err)
+		}
+	}
+
+	return challenge, nil
+}
+
+// VerifyChallenge checks the code for a pending challenge and consumes it on
+// success. Each challenge allows a limited number of attempts.
+func (ls *LoginSecurityService) VerifyChallenge(ctx context.Context, challengeID, code string) (*models.LoginChallenge, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	filter := bson.M{
+		"challenge_id": challengeID,
+		"verified_at":  bson.M{"$exists": false},
+		"expires_at":   bson.M{"$gt": now},
+	}
+
+	var challenge models.LoginChallenge
+	if err := ls.collections.LoginChallenges().FindOne(ctx, filter).Decode(&challenge); err != nil {
+		return nil, ErrLoginChallengeExpired
+	}
+	if challenge.Attempts >= loginChallengeMaxAttempts {
+		return nil, ErrLoginChallengeExpired
+	}
+
+	code = strings.TrimSpace(code)
+	var valid bool
+	switch challenge.Method {
+	case "totp":
+		valid = utils.ValidateTOTP(ls.totpSecret(ctx, challenge.UserID), code)
+	default:
+		valid = subtle.ConstantTimeCompare([]byte(utils.HashSHA256(code)), []byte(challenge.CodeHash)) == 1
+	}
+
+	if !valid {
+		ls.collections.LoginChallenges().UpdateOne(ctx,
+			bson.M{"_id": challenge.ID},
+			bson.M{"$inc": bson.M{"attempts": 1}},
+		)
+		return nil, ErrLoginChallengeInvalid
+	}
+
+	// Consume the challenge; a concurrent request with the same code loses
+	filter["_id"] = challenge.ID
+	result, err := ls.collections.LoginChallenges().UpdateOne(ctx, filter,
+		bson.M{"$set": bson.M{"verified_at": now}},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to complete login challenge: %v", err)
+	}
+	if result.ModifiedCount == 0 {
+		return nil, ErrLoginChallengeExpired
+	}
+
+	challenge.VerifiedAt = &now
+	return &challenge, nil
+}
+
+// AssessmentFromChallenge rebuilds the assessment a challenge was created for
+func AssessmentFromChallenge(challenge *models.LoginChallenge) *LoginAssessment {
+	return &LoginAssessment{
+		Suspicious: true,
+		Reasons:    challenge.Reasons,
+		Device:     challenge.Device,
+		Location:   challenge.Location,
+	}
+}
+
+// NotifyLogin tells the user about a sign-in from a new device or location,
+// in-app and, unless they turned login alerts off, by email
+func (ls *LoginSecurityService) NotifyLogin(ctx context.Context, user *models.User, assessment *LoginAssessment) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	location := describeLocation(assessment.Location)
+	message := fmt.Sprintf("New sign-in to your account from %s", assessment.Device)
+	if location != "" {
+		message += " in " + location
+	}
+
+	_, err := ls.collections.Notifications().InsertOne(ctx, bson.M{
+		"_id":     primitive.NewObjectID(),
+		"user_id": user.ID,
+		"type":    "new_login",
+		"title":   "New sign-in to your account",
+		"message": message,
+		"data": bson.M{
+			"device":   assessment.Device,
+			"location": assessment.Location,
+			"reasons":  assessment.Reasons,
+		},
+		"is_read":    false,
+		"created_at": time.Now(),
+	})
+	if err != nil {
+		log.Printf("Failed to notify user %s of new login: %v", user.ID.Hex(), err)
+	}
+
+	userSettings, err := NewSettingsService().GetUserSettings(ctx, user.ID)
+	if err == nil {
+		if alerts, ok := userSettings["login_alerts"].(bool); ok && !alerts {
+			return
+		}
+	}
+
+	NewAuthService().sendEmailNotification(user.Email, "new_login", map[string]string{
+		"name":     user.FirstName + " " + user.LastName,
+		"device":   assessment.Device,
+		"location": location,
+		"time":     time.Now().UTC().Format(time.RFC1123),
+	})
+}
+
+// totpSecret returns the user's authenticator secret when 2FA is enabled
+func (ls *LoginSecurityService) totpSecret(ctx context.Context, userID primitive.ObjectID) string {
+	userSettings, err := NewSettingsService().GetUserSettings(ctx, userID)
+	if err != nil {
+		return ""
+	}
+	if enabled, _ := userSettings["two_factor_enabled"].(bool); !enabled {
+		return ""
+	}
+	secret, _ := userSettings["two_factor_secret"].(string)
+	return secret
+}
+
+// describeLocation formats a location as "City, Region, Country" for messages
+func describeLocation(location *models.GeoLocation) string {
+	if location == nil {
+		return ""
+	}
+
+	var parts []string
+	for _, part := range []string{location.City, location.RegionName, location.CountryName} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	if len(parts) == 0 && location.Country != "" {
+		return location.Country
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,317 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"oncloud/database"
+	"oncloud/models"
+	"oncloud/utils"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultMaintenanceMessage = "We are performing scheduled maintenance and will be back shortly."
+
+	// Scheduled windows are reloaded this often, so windows created through
+	// another instance start on time
+	maintenanceWindowsTTL = 30 * time.Second
+
+	// Users are notified of maintenance in batches of this size
+	maintenanceNotifyBatch = 500
+)
+
+var (
+	ErrMaintenanceWindowNotFound = notFoundError("maintenance window not found")
+	// ErrInvalidMaintenanceWindow wraps any rejected maintenance window
+	ErrInvalidMaintenanceWindow = errors.New("invalid maintenance window")
+)
+
+// maintenanceWindowsCache holds the windows which have not ended yet
+var maintenanceWindowsCache struct {
+	sync.RWMutex
+	windows  []models.MaintenanceWindow
+	loadedAt time.Time
+}
+
+type MaintenanceService struct {
+	*BaseService
+	settingsService *SettingsService
+}
+
+func NewMaintenanceService() *MaintenanceService {
+	return NewMaintenanceServiceWith(Dependencies{})
+}
+
+// NewMaintenanceServiceWith creates a maintenance service on the given
+// dependencies
+func NewMaintenanceServiceWith(deps Dependencies) *MaintenanceService {
+	return &MaintenanceService{
+		BaseService:     NewBaseServiceWith(deps),
+		settingsService: NewSettingsServiceWith(deps),
+	}
+}
+
+// CurrentMaintenance returns whether the service is under maintenance, from
+// the maintenance mode setting or a scheduled window, and the windows to come
+func CurrentMaintenance() *models.MaintenanceStatus {
+	status := &models.MaintenanceStatus{Upcoming: []models.MaintenanceWindow{}}
+	now := time.Now()
+
+	for _, window := range scheduledMaintenanceWindows() {
+		switch {
+		case !window.EndsAt.After(now):
+			continue
+		case window.StartsAt.After(now):
+			status.Upcoming = append(status.Upcoming, window)
+		default:
+			// Overlapping windows run until the last of them ends
+			if status.Until == nil || window.EndsAt.After(*status.Until) {
+				until := window.EndsAt
+				status.Until = &until
+			}
+			if !status.Active {
+				status.Active = true
+				status.Message = window.Message
+			}
+		}
+	}
+
+	// Maintenance switched on by hand has no known end
+	if RuntimeSettingBool(RuntimeSettingMaintenanceMode) {
+		status.Active = true
+		status.Until = nil
+		if message := RuntimeSettingString(RuntimeSettingMaintenanceMessage); message != "" {
+			status.Message = message
+		}
+	}
+	if status.Active && status.Message == "" {
+		status.Message = defaultMaintenanceMessage
+	}
+	return status
+}
+
+// scheduledMaintenanceWindows returns the cached windows, reloading them once
+// they are stale
+func scheduledMaintenanceWindows() []models.MaintenanceWindow {
+	maintenanceWindowsCache.RLock()
+	windows, loadedAt := maintenanceWindowsCache.windows, maintenanceWindowsCache.loadedAt
+	maintenanceWindowsCache.RUnlock()
+	if time.Since(loadedAt) < maintenanceWindowsTTL {
+		return windows
+	}
+
+	maintenanceWindowsCache.Lock()
+	defer maintenanceWindowsCache.Unlock()
+	if time.Since(maintenanceWindowsCache.loadedAt) < maintenanceWindowsTTL {
+		return maintenanceWindowsCache.windows
+	}
+
+	windows, err := loadMaintenanceWindows(context.Background(), database.GetCollection(database.MaintenanceWindowsCollection), false)
+	if err != nil {
+		log.Printf("Failed to load maintenance windows: %v", err)
+	} else {
+		maintenanceWindowsCache.windows = windows
+	}
+	maintenanceWindowsCache.loadedAt = time.Now()
+	return maintenanceWindowsCache.windows
+}
+
+// invalidateMaintenanceWindows makes the next read reload the windows
+func invalidateMaintenanceWindows() {
+	maintenanceWindowsCache.Lock()
+	maintenanceWindowsCache.loadedAt = time.Time{}
+	maintenanceWindowsCache.Unlock()
+}
+
+// loadMaintenanceWindows returns the windows in collection which have not
+// ended and are not cancelled, or every window when includePast is set
+func loadMaintenanceWindows(ctx context.Context, collection *mongo.Collection, includePast bool) ([]models.MaintenanceWindow, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{}
+	if !includePast {
+		filter["ends_at"] = bson.M{"$gt": time.Now()}
+		filter["cancelled_at"] = bson.M{"$exists": false}
+	}
+	cursor, err := collection.Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "starts_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	windows := []models.MaintenanceWindow{}
+	if err := cursor.All(ctx, &windows); err != nil {
+		return nil, err
+	}
+	return windows, nil
+}
+
+// SetMaintenanceMode switches maintenance mode on or off. An empty message
+// returns to the environment's message.
+func (ms *MaintenanceService) SetMaintenanceMode(ctx context.Context, req *models.MaintenanceModeRequest, adminID primitive.ObjectID) error {
+	if err := utils.ValidateStruct(req); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidRuntimeSetting, err)
+	}
+
+	var err error
+	if req.Message != "" {
+		err = ms.settingsService.UpdateRuntimeSetting(ctx, RuntimeSettingMaintenanceMessage, req.Message, adminID)
+	} else {
+		err = ms.settingsService.ResetRuntimeSetting(ctx, RuntimeSettingMaintenanceMessage)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := ms.settingsService.UpdateRuntimeSetting(ctx, RuntimeSettingMaintenanceMode, req.Enabled, adminID); err != nil {
+		return err
+	}
+
+	if req.Enabled {
+		log.Printf("Maintenance mode switched on by admin %s", adminID.Hex())
+	} else {
+		log.Printf("Maintenance mode switched off by admin %s", adminID.Hex())
+	}
+	return nil
+}
+
+// ListWindows returns the scheduled windows, optionally with past and
+// cancelled ones
+func (ms *MaintenanceService) ListWindows(ctx context.Context, includePast bool) ([]models.MaintenanceWindow, error) {
+	return loadMaintenanceWindows(ctx, ms.collections.MaintenanceWindows(), includePast)
+}
+
+// ScheduleWindow schedules maintenance, announcing it to users when asked to
+func (ms *MaintenanceService) ScheduleWindow(ctx context.Context, req *models.MaintenanceWindowRequest, adminID primitive.ObjectID) (*models.MaintenanceWindow, error) {
+	if err := utils.ValidateStruct(req); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMaintenanceWindow, err)
+	}
+	if !req.EndsAt.After(time.Now()) {
+		return nil, fmt.Errorf("%w: the window must end in the future", ErrInvalidMaintenanceWindow)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	window := &models.MaintenanceWindow{
+		ID:        primitive.NewObjectID(),
+		Title:     req.Title,
+		Message:   req.Message,
+		StartsAt:  req.StartsAt,
+		EndsAt:    req.EndsAt,
+		CreatedBy: adminID,
+		CreatedAt: time.Now(),
+	}
+	if _, err := ms.collections.MaintenanceWindows().InsertOne(ctx, window); err != nil {
+		return nil, fmt.Errorf("failed to schedule maintenance: %v", err)
+	}
+	invalidateMaintenanceWindows()
+
+	if req.Notify {
+		go ms.notifyUsers(context.Background(), window, false)
+	}
+	return window, nil
+}
+
+// CancelWindow cancels scheduled maintenance. Users are told about the
+// cancellation when the window had not started yet.
+func (ms *MaintenanceService) CancelWindow(ctx context.Context, windowID primitive.ObjectID, notify bool) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	var window models.MaintenanceWindow
+	err := ms.collections.MaintenanceWindows().FindOneAndUpdate(ctx,
+		bson.M{"_id": windowID, "cancelled_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"cancelled_at": now}},
+	).Decode(&window)
+	if err != nil {
+		return ErrMaintenanceWindowNotFound
+	}
+	invalidateMaintenanceWindows()
+
+	if notify && window.StartsAt.After(now) {
+		go ms.notifyUsers(context.Background(), &window, true)
+	}
+	return nil
+}
+
+// notifyUsers adds a notification about a window for every active user
+func (ms *MaintenanceService) notifyUsers(ctx context.Context, window *models.MaintenanceWindow, cancelled bool) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+	defer cancel()
+
+	title := "Scheduled maintenance: " + window.Title
+	message := fmt.Sprintf("The service will be unavailable from %s until %s (UTC).",
+		window.StartsAt.UTC().Format("2 Jan 2006 15:04"), window.EndsAt.UTC().Format("2 Jan 2006 15:04"))
+	if window.Message != "" {
+		message += " " + window.Message
+	}
+	if cancelled {
+		title = "Maintenance cancelled: " + window.Title
+		message = fmt.Sprintf("The maintenance planned for %s has been cancelled.", window.StartsAt.UTC().Format("2 Jan 2006 15:04"))
+	}
+
+	cursor, err := ms.collections.Users().Find(ctx, bson.M{"is_active": true},
+		options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		log.Printf("Failed to notify users of maintenance %s: %v", window.ID.Hex(), err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	batch := make([]interface{}, 0, maintenanceNotifyBatch)
+	notified := 0
+	flush := func() bool {
+		if len(batch) == 0 {
+			return true
+		}
+		if _, err := ms.collections.Notifications().InsertMany(ctx, batch); err != nil {
+			log.Printf("Failed to notify users of maintenance %s: %v", window.ID.Hex(), err)
+			return false
+		}
+		notified += len(batch)
+		batch = batch[:0]
+		return true
+	}
+
+	for cursor.Next(ctx) {
+		var user struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err := cursor.Decode(&user); err != nil {
+			continue
+		}
+		batch = append(batch, bson.M{
+			"_id":     primitive.NewObjectID(),
+			"user_id": user.ID,
+			"type":    "maintenance",
+			"title":   title,
+			"message": message,
+			"data": bson.M{
+				"window_id": window.ID,
+				"starts_at": window.StartsAt,
+				"ends_at":   window.EndsAt,
+				"cancelled": cancelled,
+			},
+			"is_read":    false,
+			"created_at": time.Now(),
+		})
+		if len(batch) == maintenanceNotifyBatch && !flush() {
+			return
+		}
+	}
+	if flush() {
+		log.Printf("Notified %d users of maintenance %s", notified, window.ID.Hex())
+	}
+}
@@ -0,0 +1,248 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"oncloud/models"
+	"oncloud/utils"
+	"path"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// markdownMaxSize is the size of the biggest document rendered
+	markdownMaxSize = 1024 * 1024
+	// markdownAssetMaxSize is the size of the biggest image a shared
+	// document shows from its folder
+	markdownAssetMaxSize = 20 * 1024 * 1024
+)
+
+var (
+	// ErrNotMarkdown is returned for shared files that are not Markdown
+	// documents. It is an ErrPreviewUnavailable.
+	ErrNotMarkdown        = fmt.Errorf("%w: it is not a Markdown document", ErrPreviewUnavailable)
+	ErrShareAssetNotFound = notFoundError("image not found")
+)
+
+// IsMarkdown reports whether file is a Markdown document
+func IsMarkdown(file *models.File) bool {
+	switch strings.ToLower(path.Ext(fileDisplayName(file))) {
+	case ".md", ".markdown", ".mdown", ".mkd":
+		return true
+	}
+	return strings.EqualFold(file.MimeType, "text/markdown")
+}
+
+// MarkdownPreviewService renders shared Markdown documents, such as READMEs,
+// to HTML for their share pages. The images a document shows from its own
+// folder are served through the share, so they show without being shared
+// themselves.
+type MarkdownPreviewService struct {
+	*BaseService
+	storageService ObjectStore
+	fileService    *FileService
+}
+
+func NewMarkdownPreviewService() *MarkdownPreviewService {
+	return NewMarkdownPreviewServiceWith(Dependencies{})
+}
+
+// NewMarkdownPreviewServiceWith creates a markdown preview service on the
+// given dependencies
+func NewMarkdownPreviewServiceWith(deps Dependencies) *MarkdownPreviewService {
+	return &MarkdownPreviewService{
+		BaseService:    NewBaseServiceWith(deps),
+		storageService: deps.storage(),
+		fileService:    NewFileServiceWith(deps),
+	}
+}
+
+// RenderShared renders the Markdown document a share links to
+func (mps *MarkdownPreviewService) RenderShared(ctx context.Context, token string) (*models.ShareDocument, error) {
+	share, file, err := mps.sharedDocument(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	rendered, _, err := mps.render(ctx, share, file)
+	if err != nil {
+		return nil, err
+	}
+	return &models.ShareDocument{Name: fileDisplayName(file), HTML: rendered}, nil
+}
+
+// ServeSharedAsset serves an image the Markdown document a share links to
+// shows, by the path it is written with relative to the document. Only
+// images the document shows from its folder or the folders below are
+// served.
+func (mps *MarkdownPreviewService) ServeSharedAsset(ctx context.Context, token, assetPath string, w http.ResponseWriter, r *http.Request) error {
+	share, file, err := mps.sharedDocument(ctx, token)
+	if err != nil {
+		return err
+	}
+	_, assets, err := mps.render(ctx, share, file)
+	if err != nil {
+		return err
+	}
+	assetPath = strings.TrimPrefix(assetPath, "/")
+	if !assets[assetPath] {
+		return ErrShareAssetNotFound
+	}
+
+	asset, err := mps.findAsset(ctx, file, assetPath)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(strings.ToLower(asset.MimeType), "image/") || asset.Size > markdownAssetMaxSize {
+		return ErrShareAssetNotFound
+	}
+	for _, check := range []error{checkTakedown(asset.TakedownID), checkFileScan(asset), checkFileExpiry(asset), checkFileArchived(asset)} {
+		if check != nil {
+			return ErrShareAssetNotFound
+		}
+	}
+
+	content, err := mps.storageService.ReadFileContent(ctx, asset)
+	if err != nil {
+		return fmt.Errorf("failed to get image content: %w", err)
+	}
+
+	w.Header().Set("Content-Type", asset.MimeType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	// SVG images can carry script, which must not run when one is opened
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; sandbox")
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		w.Write(content)
+	}
+	return nil
+}
+
+// sharedDocument returns the share behind a token and the Markdown
+// document it links to, if the document may be shown
+func (mps *MarkdownPreviewService) sharedDocument(ctx context.Context, token string) (*models.FileShare, *models.File, error) {
+	share, file, err := mps.fileService.GetSharedFile(ctx, token)
+	if errors.Is(err, ErrFileExpired) {
+		return nil, nil, err
+	}
+	if err != nil {
+		return nil, nil, ErrShareLinkNotFound
+	}
+	if err := checkTakedown(file.TakedownID); err != nil {
+		return nil, nil, err
+	}
+	if err := checkFileScan(file); err != nil {
+		return nil, nil, err
+	}
+	if err := checkFileArchived(file); err != nil {
+		return nil, nil, err
+	}
+	if !IsMarkdown(file) || file.Size > markdownMaxSize {
+		return nil, nil, ErrNotMarkdown
+	}
+	return share, file, nil
+}
+
+// render renders a shared document, with its relative images pointed at
+// the share, and returns the paths of those images
+func (mps *MarkdownPreviewService) render(ctx context.Context, share *models.FileShare, file *models.File) (string, map[string]bool, error) {
+	content, err := mps.storageService.ReadFileContent(ctx, file)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to get file content: %w", err)
+	}
+	decoded, err := utils.DecodeText(content, markdownMaxSize)
+	if err != nil {
+		return "", nil, ErrNotMarkdown
+	}
+
+	assets := make(map[string]bool)
+	rendered := utils.RenderMarkdown(decoded.Text, utils.MarkdownOptions{
+		ImageURL: func(src string) string {
+			if u, err := url.Parse(src); err != nil || u.Scheme != "" || u.Host != "" {
+				return src
+			}
+			assetPath, ok := relativeAssetPath(src)
+			if !ok {
+				return ""
+			}
+			assets[assetPath] = true
+			return shareAssetURL(share.Token, assetPath)
+		},
+	})
+	return rendered, assets, nil
+}
+
+// relativeAssetPath cleans the path of an image relative to its document,
+// and reports whether it stays in the document's folder or below
+func relativeAssetPath(src string) (string, bool) {
+	src, _, _ = strings.Cut(src, "#")
+	src, _, _ = strings.Cut(src, "?")
+	if unescaped, err := url.PathUnescape(src); err == nil {
+		src = unescaped
+	}
+	if src == "" || strings.HasPrefix(src, "/") {
+		return "", false
+	}
+	cleaned := path.Clean(src)
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", false
+	}
+	return cleaned, true
+}
+
+func shareAssetURL(token, assetPath string) string {
+	segments := strings.Split(assetPath, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return fmt.Sprintf("/api/v1/shared/%s/assets/%s", url.PathEscape(token), strings.Join(segments, "/"))
+}
+
+// findAsset finds the file at a path relative to the document's folder,
+// among the files of the document's owner
+func (mps *MarkdownPreviewService) findAsset(ctx context.Context, document *models.File, assetPath string) (*models.File, error) {
+	segments := strings.Split(assetPath, "/")
+	folderID := document.FolderID
+	for _, name := range segments[:len(segments)-1] {
+		var folder models.Folder
+		err := mps.collections.Folders().FindOne(ctx, bson.M{
+			"user_id":    document.UserID,
+			"parent_id":  folderIDOrNil(folderID),
+			"name":       name,
+			"is_deleted": false,
+		}).Decode(&folder)
+		if err != nil {
+			return nil, findError(err, ErrShareAssetNotFound)
+		}
+		folderID = &folder.ID
+	}
+
+	var asset models.File
+	err := mps.collections.Files().FindOne(ctx, bson.M{
+		"user_id":    document.UserID,
+		"folder_id":  folderIDOrNil(folderID),
+		"name":       segments[len(segments)-1],
+		"is_deleted": false,
+	}).Decode(&asset)
+	if err != nil {
+		return nil, findError(err, ErrShareAssetNotFound)
+	}
+	return &asset, nil
+}
+
+// folderIDOrNil matches the folder, or the root, where the folder ID is
+// left out
+func folderIDOrNil(folderID *primitive.ObjectID) interface{} {
+	if folderID == nil {
+		return nil
+	}
+	return *folderID
+}
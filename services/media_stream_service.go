@@ -0,0 +1,601 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"oncloud/models"
+	"oncloud/utils"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MediaStreamOptions configures transcoding shared video and audio to HLS
+// with ffmpeg
+type MediaStreamOptions struct {
+	Transcoder      string        // path to ffmpeg
+	SegmentDuration time.Duration // of each segment, but the last
+	Timeout         time.Duration // to transcode one file
+	MaxFileSize     int64
+	SegmentURLTTL   time.Duration // on top of the length of the stream
+	Retention       time.Duration // since a stream was last played
+}
+
+var mediaStreamOptions *MediaStreamOptions
+
+// mediaStreamMaxAttempts is how many times a file is transcoded before its
+// stream is given up on
+const mediaStreamMaxAttempts = 3
+
+var (
+	// ErrStreamPreparing is returned while a shared file is being transcoded
+	ErrStreamPreparing = errors.New("this file is being prepared for streaming, please try again shortly")
+	// ErrNotStreamable, ErrStreamTooLarge and ErrStreamFailed are
+	// ErrPreviewUnavailable
+	ErrNotStreamable         = fmt.Errorf("%w: it is not a video or audio file that can be streamed", ErrPreviewUnavailable)
+	ErrStreamTooLarge        = fmt.Errorf("%w: it is too large to stream", ErrPreviewUnavailable)
+	ErrStreamFailed          = fmt.Errorf("%w: it could not be prepared for streaming", ErrPreviewUnavailable)
+	ErrStreamSegmentNotFound = notFoundError("segment not found")
+	// ErrStreamTokenInvalid is returned for segment URLs that were not
+	// signed here or have expired, so players fetch the playlist again
+	ErrStreamTokenInvalid = forbiddenError("this stream link is invalid or has expired")
+)
+
+// InitMediaStreams enables streaming shared video and audio
+func InitMediaStreams(opts MediaStreamOptions) {
+	if opts.SegmentDuration <= 0 {
+		opts.SegmentDuration = 6 * time.Second
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 30 * time.Minute
+	}
+	if opts.MaxFileSize <= 0 {
+		opts.MaxFileSize = 1024 * 1024 * 1024
+	}
+	if opts.SegmentURLTTL <= 0 {
+		opts.SegmentURLTTL = time.Hour
+	}
+	if opts.Retention <= 0 {
+		opts.Retention = 30 * 24 * time.Hour
+	}
+	mediaStreamOptions = &opts
+}
+
+// MediaStreamsEnabled reports whether shared video and audio is streamed
+func MediaStreamsEnabled() bool {
+	return mediaStreamOptions != nil
+}
+
+// Streamable reports whether file can be streamed over HLS: video and
+// audio files, once streaming is enabled
+func Streamable(file *models.File) bool {
+	if !MediaStreamsEnabled() {
+		return false
+	}
+	mimeType := strings.ToLower(file.MimeType)
+	return strings.HasPrefix(mimeType, "video/") || strings.HasPrefix(mimeType, "audio/")
+}
+
+// MediaStreamService streams shared video and audio over HLS. Files are
+// transcoded in the background the first time their share is played, and
+// their segments served through URLs signed for the share, counted against
+// the bandwidth of the file's owner.
+type MediaStreamService struct {
+	*BaseService
+	storageService ObjectStore
+	fileService    *FileService
+}
+
+func NewMediaStreamService() *MediaStreamService {
+	return NewMediaStreamServiceWith(Dependencies{})
+}
+
+// NewMediaStreamServiceWith creates a media stream service on the given
+// dependencies
+func NewMediaStreamServiceWith(deps Dependencies) *MediaStreamService {
+	return &MediaStreamService{
+		BaseService:    NewBaseServiceWith(deps),
+		storageService: deps.storage(),
+		fileService:    NewFileServiceWith(deps),
+	}
+}
+
+// GetSharedPlaylist returns the HLS playlist of the video or audio file a
+// share links to. The first request for a file queues it to be transcoded
+// and returns ErrStreamPreparing, as do those made until it is ready.
+func (mss *MediaStreamService) GetSharedPlaylist(ctx context.Context, token string) (string, error) {
+	share, file, err := mss.sharedMedia(ctx, token)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	// Streams are made once for each content of a file; the unique index
+	// on both keeps viewers arriving together to one
+	now := mss.clock.Now()
+	source := mediaStreamSource(file)
+	var stream models.MediaStream
+	err = mss.collections.MediaStreams().FindOneAndUpdate(ctx,
+		bson.M{"file_id": file.ID, "source": source},
+		bson.M{"$setOnInsert": models.MediaStream{
+			ID:              primitive.NewObjectID(),
+			FileID:          file.ID,
+			UserID:          file.UserID,
+			Source:          source,
+			Status:          models.MediaStreamPending,
+			StorageProvider: file.StorageProvider,
+			Segments:        []models.MediaSegment{},
+			CreatedAt:       now,
+			UpdatedAt:       now,
+		}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&stream)
+	if mongo.IsDuplicateKeyError(err) {
+		err = mss.collections.MediaStreams().FindOne(ctx, bson.M{"file_id": file.ID, "source": source}).Decode(&stream)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get stream: %v", err)
+	}
+
+	switch stream.Status {
+	case models.MediaStreamReady:
+	case models.MediaStreamFailed:
+		return "", ErrStreamFailed
+	default:
+		return "", ErrStreamPreparing
+	}
+
+	// Segment URLs last long enough to play the whole stream from the
+	// start, but never beyond the share's own expiry
+	var length float64
+	for _, segment := range stream.Segments {
+		length += segment.Duration
+	}
+	expiresAt := now.Add(mediaStreamOptions.SegmentURLTTL + time.Duration(length*float64(time.Second)))
+	if share.ExpiresAt != nil && share.ExpiresAt.Before(expiresAt) {
+		expiresAt = *share.ExpiresAt
+	}
+	streamToken, err := utils.GenerateStreamToken(share.ID, stream.ID, expiresAt)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign stream token: %v", err)
+	}
+
+	var playlist strings.Builder
+	playlist.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	fmt.Fprintf(&playlist, "#EXT-X-TARGETDURATION:%d\n", stream.TargetDuration)
+	playlist.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n")
+	for i, segment := range stream.Segments {
+		fmt.Fprintf(&playlist, "#EXTINF:%.3f,\n/api/v1/streams/%s/%d.ts\n", segment.Duration, streamToken, i)
+	}
+	playlist.WriteString("#EXT-X-ENDLIST\n")
+	return playlist.String(), nil
+}
+
+// ServeSegment serves a segment of a shared media stream, by its signed
+// URL, and counts it against the bandwidth of the file's owner
+func (mss *MediaStreamService) ServeSegment(ctx context.Context, tokenString, name string, w http.ResponseWriter, r *http.Request) error {
+	claims, err := utils.ValidateStreamToken(tokenString)
+	if err != nil {
+		return ErrStreamTokenInvalid
+	}
+	index, err := strconv.Atoi(strings.TrimSuffix(name, ".ts"))
+	if err != nil || index < 0 || !strings.HasSuffix(name, ".ts") {
+		return ErrStreamSegmentNotFound
+	}
+
+	lookupCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	// The share may have been disabled, or the file taken down, since the
+	// playlist was signed
+	var share models.FileShare
+	err = mss.collections.FileShares().FindOne(lookupCtx, bson.M{"_id": claims.ShareID, "is_active": true}).Decode(&share)
+	if err != nil || (share.ExpiresAt != nil && share.ExpiresAt.Before(mss.clock.Now())) {
+		return ErrShareLinkNotFound
+	}
+	var stream models.MediaStream
+	err = mss.collections.MediaStreams().FindOne(lookupCtx, bson.M{
+		"_id":     claims.StreamID,
+		"file_id": share.FileID,
+		"status":  models.MediaStreamReady,
+	}).Decode(&stream)
+	if err != nil {
+		return findError(err, ErrStreamSegmentNotFound)
+	}
+	if index >= len(stream.Segments) {
+		return ErrStreamSegmentNotFound
+	}
+	var file models.File
+	err = mss.collections.Files().FindOne(lookupCtx, bson.M{"_id": share.FileID, "is_deleted": false}).Decode(&file)
+	if err != nil {
+		return findError(err, ErrShareLinkNotFound)
+	}
+	for _, check := range []error{checkTakedown(file.TakedownID), checkFileScan(&file), checkFileExpiry(&file), checkFileArchived(&file)} {
+		if check != nil {
+			return check
+		}
+	}
+
+	segment := stream.Segments[index]
+	content, err := mss.storageService.DownloadFile(ctx, stream.StorageProvider, segment.Key)
+	if err != nil {
+		return fmt.Errorf("failed to get segment: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "video/mp2t")
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	w.Header().Set("Cross-Origin-Resource-Policy", "cross-origin")
+	counter := &countingResponseWriter{ResponseWriter: w}
+	http.ServeContent(counter, r, name, stream.UpdatedAt, bytes.NewReader(content))
+
+	if counter.written > 0 {
+		update := bson.M{
+			"$inc": bson.M{"bytes_served": counter.written},
+			"$set": bson.M{"last_served_at": mss.clock.Now()},
+		}
+		// A segment's later range requests belong to the play its first counted
+		if servedRangeStart(counter.Header()) == 0 {
+			update["$inc"].(bson.M)["segments_served"] = 1
+		}
+		mss.collections.MediaStreams().UpdateOne(ctx, bson.M{"_id": stream.ID}, update)
+		mss.collections.Users().UpdateOne(ctx,
+			bson.M{"_id": file.UserID},
+			bson.M{"$inc": bson.M{"bandwidth_used": counter.written}},
+		)
+	}
+	return nil
+}
+
+// sharedMedia returns the share behind a token and the video or audio
+// file it links to, if the file may be streamed
+func (mss *MediaStreamService) sharedMedia(ctx context.Context, token string) (*models.FileShare, *models.File, error) {
+	share, file, err := mss.fileService.GetSharedFile(ctx, token)
+	if errors.Is(err, ErrFileExpired) {
+		return nil, nil, err
+	}
+	if err != nil {
+		return nil, nil, ErrShareLinkNotFound
+	}
+	if err := checkTakedown(file.TakedownID); err != nil {
+		return nil, nil, err
+	}
+	if err := checkFileScan(file); err != nil {
+		return nil, nil, err
+	}
+	if err := checkFileArchived(file); err != nil {
+		return nil, nil, err
+	}
+	if !Streamable(file) {
+		return nil, nil, ErrNotStreamable
+	}
+	if file.Size > mediaStreamOptions.MaxFileSize {
+		return nil, nil, ErrStreamTooLarge
+	}
+	return share, file, nil
+}
+
+// mediaStreamSource names the content of a file a stream is made from
+func mediaStreamSource(file *models.File) string {
+	if file.Hash != "" {
+		return file.Hash
+	}
+	return strconv.FormatInt(file.UpdatedAt.Unix(), 10)
+}
+
+// ProcessPending transcodes the streams waiting to be, one at a time,
+// including those whose transcoding was cut short by a restart
+func (mss *MediaStreamService) ProcessPending(ctx context.Context) {
+	if !MediaStreamsEnabled() {
+		return
+	}
+
+	for ctx.Err() == nil {
+		stream, err := mss.claimPending(ctx)
+		if err != nil {
+			if !errors.Is(err, mongo.ErrNoDocuments) {
+				log.Printf("Failed to claim media stream: %v", err)
+			}
+			return
+		}
+
+		if err := mss.transcode(ctx, stream); err != nil {
+			mss.failStream(ctx, stream, err)
+		}
+	}
+}
+
+// claimPending marks the oldest stream waiting to be transcoded as being
+// transcoded and returns it
+func (mss *MediaStreamService) claimPending(ctx context.Context) (*models.MediaStream, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	now := mss.clock.Now()
+	stale := now.Add(-2 * mediaStreamOptions.Timeout)
+
+	// Streams whose last attempt was cut short are given up on when it was
+	// their last
+	mss.collections.MediaStreams().UpdateMany(ctx,
+		bson.M{
+			"status":     models.MediaStreamProcessing,
+			"updated_at": bson.M{"$lt": stale},
+			"attempts":   bson.M{"$gte": mediaStreamMaxAttempts},
+		},
+		bson.M{"$set": bson.M{"status": models.MediaStreamFailed, "error": "transcoding did not finish", "updated_at": now}},
+	)
+
+	var stream models.MediaStream
+	err := mss.collections.MediaStreams().FindOneAndUpdate(ctx,
+		bson.M{
+			"$or": []bson.M{
+				{"status": models.MediaStreamPending},
+				{"status": models.MediaStreamProcessing, "updated_at": bson.M{"$lt": stale}},
+			},
+			"attempts": bson.M{"$lt": mediaStreamMaxAttempts},
+		},
+		bson.M{
+			"$set": bson.M{"status": models.MediaStreamProcessing, "updated_at": now},
+			"$inc": bson.M{"attempts": 1},
+		},
+		options.FindOneAndUpdate().SetSort(bson.M{"updated_at": 1}).SetReturnDocument(options.After),
+	).Decode(&stream)
+	if err != nil {
+		return nil, err
+	}
+	return &stream, nil
+}
+
+// transcode transcodes a stream's file to HLS segments and stores them on
+// the file's provider
+func (mss *MediaStreamService) transcode(ctx context.Context, stream *models.MediaStream) error {
+	findCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	var file models.File
+	err := mss.collections.Files().FindOne(findCtx, bson.M{"_id": stream.FileID, "is_deleted": false}).Decode(&file)
+	cancel()
+	if err == mongo.ErrNoDocuments || (err == nil && mediaStreamSource(&file) != stream.Source) {
+		// The file is gone or has changed, and a new stream is made when
+		// its share is played again
+		mss.deleteStream(ctx, stream)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get file: %v", err)
+	}
+
+	dir, err := os.MkdirTemp("", "stream-")
+	if err != nil {
+		return fmt.Errorf("failed to create stream directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	content, err := mss.storageService.ReadFileContent(ctx, &file)
+	if err != nil {
+		return fmt.Errorf("failed to get file content: %v", err)
+	}
+	input := filepath.Join(dir, "input")
+	if err := os.WriteFile(input, content, 0600); err != nil {
+		return fmt.Errorf("failed to write stream input: %v", err)
+	}
+
+	output := filepath.Join(dir, "out")
+	if err := os.Mkdir(output, 0700); err != nil {
+		return fmt.Errorf("failed to create stream directory: %v", err)
+	}
+	if err := runTranscoder(ctx, input, output, strings.HasPrefix(strings.ToLower(file.MimeType), "audio/")); err != nil {
+		return err
+	}
+	segments, err := readHLSPlaylist(filepath.Join(output, "index.m3u8"))
+	if err != nil {
+		return err
+	}
+
+	targetDuration := 0
+	for i := range segments {
+		name := segments[i].Key
+		data, err := os.ReadFile(filepath.Join(output, filepath.Base(name)))
+		if err != nil {
+			mss.deleteSegments(ctx, stream.StorageProvider, segments[:i])
+			return fmt.Errorf("failed to read segment %s: %v", name, err)
+		}
+		segments[i].Key = fmt.Sprintf("streams/%s/%s/%s", file.ID.Hex(), stream.ID.Hex(), filepath.Base(name))
+		segments[i].Size = int64(len(data))
+		if err := mss.storageService.UploadFile(ctx, stream.StorageProvider, segments[i].Key, data); err != nil {
+			mss.deleteSegments(ctx, stream.StorageProvider, segments[:i])
+			return fmt.Errorf("failed to store segment %s: %v", name, err)
+		}
+		targetDuration = max(targetDuration, int(math.Ceil(segments[i].Duration)))
+	}
+
+	updateCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	now := mss.clock.Now()
+	_, err = mss.collections.MediaStreams().UpdateOne(updateCtx,
+		bson.M{"_id": stream.ID},
+		bson.M{
+			"$set": bson.M{
+				"status":          models.MediaStreamReady,
+				"segments":        segments,
+				"target_duration": targetDuration,
+				"ready_at":        now,
+				"updated_at":      now,
+			},
+			"$unset": bson.M{"error": ""},
+		},
+	)
+	if err != nil {
+		mss.deleteSegments(ctx, stream.StorageProvider, segments)
+		return fmt.Errorf("failed to save stream: %v", err)
+	}
+
+	// Streams of the file's earlier content are no longer played
+	cursor, err := mss.collections.MediaStreams().Find(updateCtx, bson.M{"file_id": file.ID, "_id": bson.M{"$ne": stream.ID}})
+	if err == nil {
+		var older []models.MediaStream
+		cursor.All(updateCtx, &older)
+		for i := range older {
+			mss.deleteStream(ctx, &older[i])
+		}
+	}
+	return nil
+}
+
+// runTranscoder transcodes input to an HLS playlist and its segments in
+// dir with ffmpeg: video to H.264 no taller than 720p, audio to AAC
+func runTranscoder(ctx context.Context, input, dir string, audioOnly bool) error {
+	ctx, cancel := context.WithTimeout(ctx, mediaStreamOptions.Timeout)
+	defer cancel()
+
+	seconds := strconv.FormatFloat(mediaStreamOptions.SegmentDuration.Seconds(), 'f', -1, 64)
+	args := []string{"-hide_banner", "-loglevel", "error", "-nostdin", "-y", "-i", input}
+	if audioOnly {
+		args = append(args, "-map", "0:a:0", "-vn")
+	} else {
+		args = append(args,
+			"-map", "0:v:0", "-map", "0:a:0?",
+			"-c:v", "libx264", "-preset", "veryfast", "-crf", "23", "-pix_fmt", "yuv420p",
+			"-vf", "scale=-2:'min(720,ih)'",
+			// Keyframes on segment boundaries, so segments are the length asked
+			"-force_key_frames", "expr:gte(t,n_forced*"+seconds+")",
+		)
+	}
+	args = append(args,
+		"-c:a", "aac", "-b:a", "128k", "-ac", "2",
+		"-f", "hls", "-hls_time", seconds, "-hls_playlist_type", "vod",
+		"-hls_segment_filename", filepath.Join(dir, "seg-%05d.ts"),
+		filepath.Join(dir, "index.m3u8"),
+	)
+
+	cmd := exec.CommandContext(ctx, mediaStreamOptions.Transcoder, args...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("transcoding took longer than %s", mediaStreamOptions.Timeout)
+		}
+		return fmt.Errorf("transcoding failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// readHLSPlaylist reads the segments a VOD playlist lists, keyed by their
+// file names
+func readHLSPlaylist(playlistPath string) ([]models.MediaSegment, error) {
+	playlist, err := os.Open(playlistPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read playlist: %v", err)
+	}
+	defer playlist.Close()
+
+	segments := []models.MediaSegment{}
+	duration := -1.0
+	scanner := bufio.NewScanner(playlist)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "#EXTINF:"):
+			value, _, _ := strings.Cut(strings.TrimPrefix(line, "#EXTINF:"), ",")
+			if duration, err = strconv.ParseFloat(value, 64); err != nil {
+				return nil, fmt.Errorf("invalid playlist duration %q", value)
+			}
+		case line == "" || strings.HasPrefix(line, "#"):
+		case duration >= 0:
+			segments = append(segments, models.MediaSegment{Key: line, Duration: duration})
+			duration = -1
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read playlist: %v", err)
+	}
+	if len(segments) == 0 {
+		return nil, errors.New("the file has no playable media")
+	}
+	return segments, nil
+}
+
+// failStream records why a stream could not be transcoded, leaving it to
+// be tried again unless that was its last attempt
+func (mss *MediaStreamService) failStream(ctx context.Context, stream *models.MediaStream, err error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	status := models.MediaStreamPending
+	if stream.Attempts >= mediaStreamMaxAttempts {
+		status = models.MediaStreamFailed
+		log.Printf("Failed to transcode file %s for streaming: %v", stream.FileID.Hex(), err)
+	}
+	mss.collections.MediaStreams().UpdateOne(ctx,
+		bson.M{"_id": stream.ID},
+		bson.M{"$set": bson.M{"status": status, "error": err.Error(), "updated_at": mss.clock.Now()}},
+	)
+}
+
+// PruneStreams deletes the streams, with their segments, that have not
+// been played for the retention period, and the failed ones as long after
+// they failed, so their files are tried again
+func (mss *MediaStreamService) PruneStreams(ctx context.Context) {
+	if !MediaStreamsEnabled() {
+		return
+	}
+
+	findCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	cutoff := mss.clock.Now().Add(-mediaStreamOptions.Retention)
+	cursor, err := mss.collections.MediaStreams().Find(findCtx, bson.M{
+		"status": bson.M{"$in": []string{models.MediaStreamReady, models.MediaStreamFailed}},
+		"$or": []bson.M{
+			{"last_served_at": bson.M{"$lt": cutoff}},
+			{"last_served_at": bson.M{"$exists": false}, "updated_at": bson.M{"$lt": cutoff}},
+		},
+	})
+	if err != nil {
+		log.Printf("Failed to find unplayed media streams: %v", err)
+		return
+	}
+	var streams []models.MediaStream
+	if err := cursor.All(findCtx, &streams); err != nil {
+		log.Printf("Failed to find unplayed media streams: %v", err)
+		return
+	}
+
+	for i := range streams {
+		mss.deleteStream(ctx, &streams[i])
+	}
+	if len(streams) > 0 {
+		log.Printf("Pruned %d unplayed media streams", len(streams))
+	}
+}
+
+// deleteStream deletes a stream's segments, then the stream
+func (mss *MediaStreamService) deleteStream(ctx context.Context, stream *models.MediaStream) {
+	mss.deleteSegments(ctx, stream.StorageProvider, stream.Segments)
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	mss.collections.MediaStreams().DeleteOne(ctx, bson.M{"_id": stream.ID})
+}
+
+// deleteSegments deletes stored segments, leaving those it fails to delete
+// to the orphan collector
+func (mss *MediaStreamService) deleteSegments(ctx context.Context, providerType string, segments []models.MediaSegment) {
+	for _, segment := range segments {
+		if err := mss.storageService.DeleteFile(ctx, providerType, segment.Key); err != nil {
+			log.Printf("Failed to delete stream segment %s: %v", segment.Key, err)
+		}
+	}
+}
@@ -0,0 +1,470 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"oncloud/models"
+	"oncloud/utils"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	maxMetadataFields      = 50
+	maxMetadataStringValue = 1000
+)
+
+var (
+	ErrMetadataFieldNotFound = notFoundError("metadata field not found")
+	ErrMetadataFieldExists   = errors.New("metadata field already exists")
+	// ErrInvalidMetadata wraps any rejected field definition, value or filter
+	ErrInvalidMetadata = errors.New("invalid metadata")
+)
+
+var metadataKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,39}$`)
+
+// metadataDateLayouts are the accepted formats for date values
+var metadataDateLayouts = []string{time.RFC3339, "2006-01-02"}
+
+type MetadataService struct {
+	*BaseService
+}
+
+func NewMetadataService() *MetadataService {
+	return NewMetadataServiceWith(Dependencies{})
+}
+
+// NewMetadataServiceWith creates a metadata service on the given
+// dependencies
+func NewMetadataServiceWith(deps Dependencies) *MetadataService {
+	return &MetadataService{
+		BaseService: NewBaseServiceWith(deps),
+	}
+}
+
+// ListFields returns the user's metadata fields ordered by key
+func (ms *MetadataService) ListFields(ctx context.Context, userID primitive.ObjectID) ([]models.MetadataField, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	cursor, err := ms.collections.MetadataFields().Find(ctx,
+		bson.M{"user_id": userID},
+		options.Find().SetSort(bson.M{"key": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	fields := []models.MetadataField{}
+	if err := cursor.All(ctx, &fields); err != nil {
+		return nil, err
+	}
+
+	return fields, nil
+}
+
+// CreateField defines a new metadata field for the user's files
+func (ms *MetadataService) CreateField(ctx context.Context, userID primitive.ObjectID, req *models.MetadataFieldRequest) (*models.MetadataField, error) {
+	if !metadataKeyPattern.MatchString(req.Key) {
+		return nil, fmt.Errorf("%w: key must start with a lowercase letter and contain only lowercase letters, digits and underscores", ErrInvalidMetadata)
+	}
+	choices, err := metadataOptions(req.Type, req.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	count, err := ms.collections.MetadataFields().CountDocuments(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return nil, err
+	}
+	if count >= maxMetadataFields {
+		return nil, fmt.Errorf("%w: at most %d metadata fields can be defined", ErrInvalidMetadata, maxMetadataFields)
+	}
+
+	label := strings.TrimSpace(req.Label)
+	if label == "" {
+		label = req.Key
+	}
+
+	now := time.Now()
+	field := &models.MetadataField{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		Key:       req.Key,
+		Label:     label,
+		Type:      req.Type,
+		Options:   choices,
+		Required:  req.Required,
+		Indexed:   req.Indexed,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	if _, err := ms.collections.MetadataFields().InsertOne(ctx, field); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrMetadataFieldExists
+		}
+		return nil, fmt.Errorf("failed to create metadata field: %v", err)
+	}
+
+	return field, nil
+}
+
+// UpdateField changes a metadata field. Turning indexing on or off rebuilds
+// the index entries of the user's files for that field.
+func (ms *MetadataService) UpdateField(ctx context.Context, userID, fieldID primitive.ObjectID, req *models.MetadataFieldUpdateRequest) (*models.MetadataField, error) {
+	field, err := ms.GetField(ctx, userID, fieldID)
+	if err != nil {
+		return nil, err
+	}
+
+	updates := bson.M{"updated_at": time.Now()}
+	if req.Label != nil {
+		label := strings.TrimSpace(*req.Label)
+		if label == "" {
+			label = field.Key
+		}
+		updates["label"] = label
+	}
+	if req.Options != nil {
+		choices, err := metadataOptions(field.Type, req.Options)
+		if err != nil {
+			return nil, err
+		}
+		updates["options"] = choices
+	}
+	if req.Required != nil {
+		updates["required"] = *req.Required
+	}
+	if req.Indexed != nil {
+		updates["indexed"] = *req.Indexed
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	if _, err := ms.collections.MetadataFields().UpdateOne(ctx,
+		bson.M{"_id": fieldID, "user_id": userID},
+		bson.M{"$set": updates},
+	); err != nil {
+		return nil, fmt.Errorf("failed to update metadata field: %v", err)
+	}
+
+	if req.Indexed != nil && *req.Indexed != field.Indexed {
+		if err := ms.reindexField(ctx, userID, field.Key, *req.Indexed); err != nil {
+			return nil, err
+		}
+	}
+
+	return ms.GetField(ctx, userID, fieldID)
+}
+
+// DeleteField removes a metadata field and its values from all of the
+// user's files
+func (ms *MetadataService) DeleteField(ctx context.Context, userID, fieldID primitive.ObjectID) error {
+	field, err := ms.GetField(ctx, userID, fieldID)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	if _, err := ms.collections.Files().UpdateMany(ctx,
+		bson.M{"user_id": userID, "custom_metadata." + field.Key: bson.M{"$exists": true}},
+		bson.M{
+			"$unset": bson.M{"custom_metadata." + field.Key: ""},
+			"$pull":  bson.M{"metadata_index": bson.M{"k": field.Key}},
+		},
+	); err != nil {
+		return fmt.Errorf("failed to remove metadata values: %v", err)
+	}
+
+	if _, err := ms.collections.MetadataFields().DeleteOne(ctx, bson.M{"_id": fieldID}); err != nil {
+		return fmt.Errorf("failed to delete metadata field: %v", err)
+	}
+
+	return nil
+}
+
+// GetField returns one of the user's metadata fields
+func (ms *MetadataService) GetField(ctx context.Context, userID, fieldID primitive.ObjectID) (*models.MetadataField, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var field models.MetadataField
+	if err := ms.collections.MetadataFields().FindOne(ctx, bson.M{"_id": fieldID, "user_id": userID}).Decode(&field); err != nil {
+		return nil, ErrMetadataFieldNotFound
+	}
+	return &field, nil
+}
+
+// SetFileMetadata validates values against the user's metadata fields and
+// merges them into the file's custom metadata
+func (ms *MetadataService) SetFileMetadata(ctx context.Context, userID, fileID primitive.ObjectID, values map[string]interface{}) (*models.File, error) {
+	file, err := NewFileService().GetUserFile(ctx, userID, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkFileLock(file, userID); err != nil {
+		return nil, err
+	}
+
+	fields, err := ms.fieldsByKey(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := make(map[string]interface{}, len(file.CustomMetadata)+len(values))
+	for key, value := range file.CustomMetadata {
+		merged[key] = value
+	}
+
+	var problems []string
+	for key, value := range values {
+		field, ok := fields[key]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s is not a defined metadata field", key))
+			continue
+		}
+		if value == nil {
+			delete(merged, key)
+			continue
+		}
+		coerced, err := coerceMetadataValue(field, value)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		merged[key] = coerced
+	}
+	for key, field := range fields {
+		if _, ok := merged[key]; field.Required && !ok {
+			problems = append(problems, fmt.Sprintf("%s is required", key))
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return nil, fmt.Errorf("%w: %s", ErrInvalidMetadata, strings.Join(problems, "; "))
+	}
+
+	index := []models.MetadataIndexEntry{}
+	for key, value := range merged {
+		if field, ok := fields[key]; ok && field.Indexed {
+			index = append(index, models.MetadataIndexEntry{Key: key, Value: value})
+		}
+	}
+	sort.Slice(index, func(i, j int) bool { return index[i].Key < index[j].Key })
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if _, err := ms.collections.Files().UpdateOne(ctx,
+		bson.M{"_id": fileID, "user_id": userID},
+		bson.M{"$set": bson.M{
+			"custom_metadata": merged,
+			"metadata_index":  index,
+			"updated_at":      time.Now(),
+		}},
+	); err != nil {
+		return nil, fmt.Errorf("failed to update file metadata: %v", err)
+	}
+
+	return NewFileService().GetUserFile(ctx, userID, fileID)
+}
+
+// BuildFilter turns metadata query conditions into file filter clauses.
+// Conditions are keyed "<key>" for equality or "<key>.<op>" where op is one
+// of eq, ne, gt, gte, lt, lte, in (comma separated) or contains. Indexed
+// fields are matched through the metadata index.
+func (ms *MetadataService) BuildFilter(ctx context.Context, userID primitive.ObjectID, conditions map[string]string) ([]bson.M, error) {
+	fields, err := ms.fieldsByKey(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	clauses := make([]bson.M, 0, len(conditions))
+	for name, raw := range conditions {
+		key, op := name, "eq"
+		if i := strings.LastIndex(name, "."); i > 0 {
+			key, op = name[:i], name[i+1:]
+		}
+
+		field, ok := fields[key]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s is not a defined metadata field", ErrInvalidMetadata, key)
+		}
+
+		var cond interface{}
+		switch op {
+		case "eq", "ne", "gt", "gte", "lt", "lte":
+			if (field.Type == "string" || field.Type == "enum") && op != "eq" && op != "ne" {
+				return nil, fmt.Errorf("%w: %s cannot be compared with %s", ErrInvalidMetadata, key, op)
+			}
+			value, err := coerceMetadataValue(field, raw)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidMetadata, err)
+			}
+			cond = value
+			if op != "eq" {
+				cond = bson.M{"$" + op: value}
+			}
+		case "in":
+			values := bson.A{}
+			for _, part := range strings.Split(raw, ",") {
+				value, err := coerceMetadataValue(field, strings.TrimSpace(part))
+				if err != nil {
+					return nil, fmt.Errorf("%w: %v", ErrInvalidMetadata, err)
+				}
+				values = append(values, value)
+			}
+			cond = bson.M{"$in": values}
+		case "contains":
+			if field.Type != "string" {
+				return nil, fmt.Errorf("%w: contains only applies to string fields", ErrInvalidMetadata)
+			}
+			cond = primitive.Regex{Pattern: regexp.QuoteMeta(raw), Options: "i"}
+		default:
+			return nil, fmt.Errorf("%w: unknown operator %s", ErrInvalidMetadata, op)
+		}
+
+		// A negated match has to look at the value itself: files without
+		// the field have no index entry to match against
+		if field.Indexed && op != "ne" {
+			clauses = append(clauses, bson.M{"metadata_index": bson.M{"$elemMatch": bson.M{"k": key, "v": cond}}})
+		} else {
+			clauses = append(clauses, bson.M{"custom_metadata." + key: cond})
+		}
+	}
+
+	return clauses, nil
+}
+
+// reindexField adds or removes the index entries for one field on all of
+// the user's files that have a value for it
+func (ms *MetadataService) reindexField(ctx context.Context, userID primitive.ObjectID, key string, indexed bool) error {
+	entries := bson.A{}
+	if indexed {
+		entries = append(entries, bson.M{"k": key, "v": "$custom_metadata." + key})
+	}
+
+	_, err := ms.collections.Files().UpdateMany(ctx,
+		bson.M{"user_id": userID, "custom_metadata." + key: bson.M{"$exists": true}},
+		mongo.Pipeline{
+			{{Key: "$set", Value: bson.M{
+				"metadata_index": bson.M{"$concatArrays": bson.A{
+					bson.M{"$filter": bson.M{
+						"input": bson.M{"$ifNull": bson.A{"$metadata_index", bson.A{}}},
+						"cond":  bson.M{"$ne": bson.A{"$$this.k", key}},
+					}},
+					entries,
+				}},
+			}}},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to reindex metadata field: %v", err)
+	}
+
+	return nil
+}
+
+func (ms *MetadataService) fieldsByKey(ctx context.Context, userID primitive.ObjectID) (map[string]models.MetadataField, error) {
+	fields, err := ms.ListFields(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	byKey := make(map[string]models.MetadataField, len(fields))
+	for _, field := range fields {
+		byKey[field.Key] = field
+	}
+	return byKey, nil
+}
+
+// metadataOptions checks the options given for a field of the given type
+func metadataOptions(fieldType string, choices []string) ([]string, error) {
+	if fieldType != "enum" {
+		if len(choices) > 0 {
+			return nil, fmt.Errorf("%w: options only apply to enum fields", ErrInvalidMetadata)
+		}
+		return nil, nil
+	}
+
+	var cleaned []string
+	for _, choice := range choices {
+		if choice = strings.TrimSpace(choice); choice != "" {
+			cleaned = append(cleaned, choice)
+		}
+	}
+	cleaned = utils.SliceUnique(cleaned)
+	if len(cleaned) == 0 {
+		return nil, fmt.Errorf("%w: enum fields need at least one option", ErrInvalidMetadata)
+	}
+	return cleaned, nil
+}
+
+// coerceMetadataValue converts a JSON or query string value to the field's
+// type. Dates are stored as BSON dates so they sort and compare.
+func coerceMetadataValue(field models.MetadataField, value interface{}) (interface{}, error) {
+	switch field.Type {
+	case "number":
+		switch v := value.(type) {
+		case float64:
+			return v, nil
+		case int:
+			return float64(v), nil
+		case int64:
+			return float64(v), nil
+		case string:
+			if n, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+				return n, nil
+			}
+		}
+		return nil, fmt.Errorf("%s must be a number", field.Key)
+
+	case "date":
+		switch v := value.(type) {
+		case time.Time:
+			return v.UTC(), nil
+		case primitive.DateTime:
+			return v.Time().UTC(), nil
+		case string:
+			for _, layout := range metadataDateLayouts {
+				if t, err := time.Parse(layout, strings.TrimSpace(v)); err == nil {
+					return t.UTC(), nil
+				}
+			}
+		}
+		return nil, fmt.Errorf("%s must be a date (YYYY-MM-DD or RFC 3339)", field.Key)
+
+	case "enum":
+		if s, ok := value.(string); ok && utils.SliceContains(field.Options, s) {
+			return s, nil
+		}
+		return nil, fmt.Errorf("%s must be one of: %s", field.Key, strings.Join(field.Options, ", "))
+
+	default:
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s must be a string", field.Key)
+		}
+		if len([]rune(s)) > maxMetadataStringValue {
+			return nil, fmt.Errorf("%s must be at most %d characters long", field.Key, maxMetadataStringValue)
+		}
+		return s, nil
+	}
+}
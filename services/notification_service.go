@@ -0,0 +1,228 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"oncloud/models"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// NotificationOptions configures how notification emails are delivered.
+// Without an SMTP host, emails are logged instead of sent.
+type NotificationOptions struct {
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	// SMTPPassword returns the current SMTP password, which may be rotated
+	// while running
+	SMTPPassword func() string
+	From         string
+	Timeout      time.Duration
+}
+
+var notificationOptions = NotificationOptions{SMTPPort: 587, Timeout: 30 * time.Second}
+
+// InitNotifications sets how notification emails are delivered
+func InitNotifications(opts NotificationOptions) {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 30 * time.Second
+	}
+	notificationOptions = opts
+}
+
+// NotificationService tells users about what happens to their account, in
+// the app and by email
+type NotificationService struct {
+	*BaseService
+	mailer Mailer
+}
+
+func NewNotificationService() *NotificationService {
+	return NewNotificationServiceWith(Dependencies{})
+}
+
+// NewNotificationServiceWith creates a notification service on the given
+// dependencies
+func NewNotificationServiceWith(deps Dependencies) *NotificationService {
+	return &NotificationService{
+		BaseService: NewBaseServiceWith(deps),
+		mailer:      deps.mailer(),
+	}
+}
+
+// Notify records an in-app notification for a user
+func (ns *NotificationService) Notify(ctx context.Context, userID primitive.ObjectID, notificationType, title, message string, data bson.M) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := ns.collections.Notifications().InsertOne(ctx, bson.M{
+		"_id":        primitive.NewObjectID(),
+		"user_id":    userID,
+		"type":       notificationType,
+		"title":      title,
+		"message":    message,
+		"data":       data,
+		"is_read":    false,
+		"created_at": ns.clock.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record notification: %v", err)
+	}
+	return nil
+}
+
+// SendEmail renders a notification email with the deployment's branding and
+// sends it to email
+func (ns *NotificationService) SendEmail(email, template string, data map[string]string) error {
+	return ns.SendEmailWithSections(email, template, data, nil)
+}
+
+// SendEmailWithSections sends a notification email with tables of figures
+// below its text
+func (ns *NotificationService) SendEmailWithSections(email, template string, data map[string]string, sections []models.EmailSection) error {
+	message, err := NewBrandingService().RenderEmailWithSections(template, data, sections)
+	if err != nil {
+		return err
+	}
+
+	if err := ns.mailer.Send(email, message.Subject, message.Text, message.HTML); err != nil {
+		return fmt.Errorf("failed to send %s email: %v", template, err)
+	}
+	return nil
+}
+
+// SMTPMailer sends emails through the SMTP server set with
+// InitNotifications, or logs them when there is none
+type SMTPMailer struct{}
+
+func (SMTPMailer) Send(to, subject, text, html string) error {
+	opts := notificationOptions
+	if opts.SMTPHost == "" {
+		log.Printf("Sending email to %s: %s\n%s", to, subject, text)
+		return nil
+	}
+
+	body, err := buildEmail(opts.From, to, subject, text, html)
+	if err != nil {
+		return err
+	}
+	return sendSMTP(opts, to, body)
+}
+
+// buildEmail builds a multipart/alternative message with a plain text and
+// an HTML part
+func buildEmail(from, to, subject, text, html string) ([]byte, error) {
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=UTF-8", text},
+		{"text/html; charset=UTF-8", html},
+	} {
+		writer, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		encoder := quotedprintable.NewWriter(writer)
+		if _, err := encoder.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+		if err := encoder.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", from)
+	fmt.Fprintf(&message, "To: %s\r\n", to)
+	fmt.Fprintf(&message, "Subject: %s\r\n", mime.QEncoding.Encode("UTF-8", subject))
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&message, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&message, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", parts.Boundary())
+	message.Write(body.Bytes())
+	return message.Bytes(), nil
+}
+
+// sendSMTP delivers a message, over implicit TLS on port 465 and with
+// STARTTLS when the server offers it otherwise
+func sendSMTP(opts NotificationOptions, to string, message []byte) error {
+	addr := net.JoinHostPort(opts.SMTPHost, strconv.Itoa(opts.SMTPPort))
+	dialer := &net.Dialer{Timeout: opts.Timeout}
+
+	var conn net.Conn
+	var err error
+	if opts.SMTPPort == 465 {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: opts.SMTPHost})
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(opts.Timeout))
+
+	client, err := smtp.NewClient(conn, opts.SMTPHost)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok && opts.SMTPPort != 465 {
+		if err := client.StartTLS(&tls.Config{ServerName: opts.SMTPHost}); err != nil {
+			return err
+		}
+	}
+	if opts.SMTPUsername != "" {
+		password := ""
+		if opts.SMTPPassword != nil {
+			password = opts.SMTPPassword()
+		}
+		if err := client.Auth(smtp.PlainAuth("", opts.SMTPUsername, password, opts.SMTPHost)); err != nil {
+			return err
+		}
+	}
+
+	// From may carry a display name, which the envelope can't
+	sender := opts.From
+	if address, err := mail.ParseAddress(opts.From); err == nil {
+		sender = address.Address
+	}
+	if err := client.Mail(sender); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to); err != nil {
+		return err
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(message); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"log"
+	"oncloud/storage"
+	"strings"
+)
+
+// ObjectCacheOptions configures caching objects read from remote providers
+// on local disk
+type ObjectCacheOptions struct {
+	// Dir holds the cached objects
+	Dir string
+	// MaxSize is how many bytes of objects are kept
+	MaxSize int64
+	// MaxObjectSize is the size of the biggest object cached
+	MaxObjectSize int64
+}
+
+// objectCache keeps objects read from S3, Wasabi and R2 so repeated
+// downloads and previews of a file don't pay egress again. It is nil when
+// disabled.
+var objectCache *storage.DiskCache
+
+// InitObjectCache enables caching objects read from remote providers
+func InitObjectCache(opts ObjectCacheOptions) error {
+	cache, err := storage.NewDiskCache(opts.Dir, opts.MaxSize, opts.MaxObjectSize)
+	if err != nil {
+		return err
+	}
+	objectCache = cache
+	return nil
+}
+
+// ObjectCacheStats returns the counters of the object cache, or nil when it
+// is disabled
+func ObjectCacheStats() *storage.DiskCacheStats {
+	if objectCache == nil {
+		return nil
+	}
+	return objectCache.Stats()
+}
+
+// PurgeObjectCache drops every cached object and returns how many there were
+func PurgeObjectCache() int {
+	if objectCache == nil {
+		return 0
+	}
+	return objectCache.Purge()
+}
+
+// objectCacheKey is the key an object is cached under, or "" for objects
+// that are not cached because they already are on local disk
+func objectCacheKey(providerType, storageKey string) string {
+	if objectCache == nil || strings.EqualFold(providerType, "local") {
+		return ""
+	}
+	return strings.ToLower(providerType) + "/" + storageKey
+}
+
+// uncacheObject drops an object overwritten or deleted at its provider
+func uncacheObject(providerType, storageKey string) {
+	if key := objectCacheKey(providerType, storageKey); key != "" {
+		objectCache.Remove(key)
+	}
+}
+
+// cacheObject caches an object read from its provider at generation
+func cacheObject(key string, content []byte, generation uint64) {
+	if err := objectCache.Put(key, content, generation); err != nil {
+		log.Printf("Failed to cache object %s: %v", key, err)
+	}
+}
@@ -0,0 +1,410 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"oncloud/models"
+	"oncloud/storage"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// OrphanGCOptions configures collecting orphaned objects
+type OrphanGCOptions struct {
+	// SafetyWindow is how old an orphan must be before it is deleted. Objects
+	// are uploaded before the records referring to them are saved, so new
+	// objects look orphaned for a moment.
+	SafetyWindow time.Duration
+	// DeleteOrphans makes scheduled scans delete the orphans they find
+	DeleteOrphans bool
+}
+
+var orphanGCOptions = &OrphanGCOptions{SafetyWindow: 7 * 24 * time.Hour}
+
+const (
+	// orphanScanMaxListed caps the orphans and missing objects listed on a
+	// scan; the counts cover all of them
+	orphanScanMaxListed = 1000
+	// orphanScanProgressInterval is how many objects are listed between
+	// progress updates of a scan
+	orphanScanProgressInterval = 1000
+)
+
+var (
+	ErrOrphanScanNotFound    = notFoundError("orphan scan not found")
+	ErrOrphanScanRunning     = errors.New("an orphan scan is already running for this provider")
+	ErrOrphanScanUnsupported = errors.New("storage provider cannot list its objects")
+)
+
+// InitOrphanGC configures collecting orphaned objects
+func InitOrphanGC(opts OrphanGCOptions) {
+	orphanGCOptions = &opts
+}
+
+type OrphanGCService struct {
+	*BaseService
+	auditService *AuditService
+}
+
+func NewOrphanGCService() *OrphanGCService {
+	return NewOrphanGCServiceWith(Dependencies{})
+}
+
+// NewOrphanGCServiceWith creates an orphan GC service on the given
+// dependencies
+func NewOrphanGCServiceWith(deps Dependencies) *OrphanGCService {
+	return &OrphanGCService{
+		BaseService:  NewBaseServiceWith(deps),
+		auditService: NewAuditServiceWith(deps),
+	}
+}
+
+// objectRef is a record referring to a stored object
+type objectRef struct {
+	kind   string
+	fileID primitive.ObjectID
+	seen   bool
+}
+
+// StartScan starts reconciling a provider's objects with the records
+// referring to them in the background. With deleteOrphans, orphans older
+// than the safety window are deleted.
+func (gs *OrphanGCService) StartScan(ctx context.Context, providerID primitive.ObjectID, deleteOrphans bool, adminID *primitive.ObjectID) (*models.OrphanScan, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var provider models.StorageProvider
+	if err := gs.collections.StorageProviders().FindOne(ctx, bson.M{"_id": providerID}).Decode(&provider); err != nil {
+		return nil, ErrStorageProviderNotFound
+	}
+
+	running, err := gs.collections.OrphanScans().CountDocuments(ctx, bson.M{
+		"provider_id": providerID,
+		"status":      models.OrphanScanRunning,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if running > 0 {
+		return nil, ErrOrphanScanRunning
+	}
+
+	client, err := storage.NewStorageClient(&provider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to storage provider: %v", err)
+	}
+	lister, ok := client.(storage.ObjectLister)
+	if !ok {
+		return nil, ErrOrphanScanUnsupported
+	}
+
+	scan := &models.OrphanScan{
+		ID:            primitive.NewObjectID(),
+		ProviderID:    provider.ID,
+		ProviderType:  provider.Type,
+		Status:        models.OrphanScanRunning,
+		DeleteOrphans: deleteOrphans,
+		SafetyWindow:  orphanGCOptions.SafetyWindow.String(),
+		Orphans:       []models.OrphanObject{},
+		Missing:       []models.MissingObject{},
+		StartedBy:     adminID,
+		StartedAt:     time.Now(),
+	}
+	if _, err := gs.collections.OrphanScans().InsertOne(ctx, scan); err != nil {
+		return nil, fmt.Errorf("failed to create orphan scan: %v", err)
+	}
+
+	if deleteOrphans && adminID != nil {
+		gs.auditService.Record(ctx, &models.AuditLog{
+			ActorType:    "admin",
+			ActorID:      adminID,
+			Action:       "orphan_gc_started",
+			ResourceType: "storage_provider",
+			ResourceID:   provider.ID.Hex(),
+			Outcome:      "success",
+			Details:      map[string]interface{}{"scan_id": scan.ID.Hex()},
+		})
+	}
+
+	go gs.runScan(context.Background(), *scan, client, lister)
+	return scan, nil
+}
+
+// RunScheduledScans scans every active provider which can list its objects,
+// deleting orphans when configured to
+func (gs *OrphanGCService) RunScheduledScans(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var providers []models.StorageProvider
+	cursor, err := gs.collections.StorageProviders().Find(ctx, bson.M{"is_active": true})
+	if err != nil {
+		log.Printf("Orphan scan: failed to list storage providers: %v", err)
+		return
+	}
+	if err := cursor.All(ctx, &providers); err != nil {
+		log.Printf("Orphan scan: failed to list storage providers: %v", err)
+		return
+	}
+
+	for _, provider := range providers {
+		_, err := gs.StartScan(ctx, provider.ID, orphanGCOptions.DeleteOrphans, nil)
+		if err != nil && !errors.Is(err, ErrOrphanScanUnsupported) && !errors.Is(err, ErrOrphanScanRunning) {
+			log.Printf("Orphan scan of %s failed to start: %v", provider.Name, err)
+		}
+	}
+}
+
+// ListScans returns a provider's orphan scans, newest first
+func (gs *OrphanGCService) ListScans(ctx context.Context, providerID primitive.ObjectID, page, limit int) ([]models.OrphanScan, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	query := bson.M{"provider_id": providerID}
+	cursor, err := gs.collections.OrphanScans().Find(ctx, query,
+		options.Find().
+			SetSort(bson.M{"started_at": -1}).
+			SetSkip(int64((page-1)*limit)).
+			SetLimit(int64(limit)).
+			SetProjection(bson.M{"orphans": 0, "missing": 0}),
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	scans := []models.OrphanScan{}
+	if err := cursor.All(ctx, &scans); err != nil {
+		return nil, 0, err
+	}
+
+	total, err := gs.collections.OrphanScans().CountDocuments(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return scans, int(total), nil
+}
+
+// GetScan returns one of a provider's orphan scans with the objects it found
+func (gs *OrphanGCService) GetScan(ctx context.Context, providerID, scanID primitive.ObjectID) (*models.OrphanScan, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var scan models.OrphanScan
+	err := gs.collections.OrphanScans().FindOne(ctx, bson.M{"_id": scanID, "provider_id": providerID}).Decode(&scan)
+	if err != nil {
+		return nil, ErrOrphanScanNotFound
+	}
+	return &scan, nil
+}
+
+func (gs *OrphanGCService) runScan(ctx context.Context, scan models.OrphanScan, client storage.StorageInterface, lister storage.ObjectLister) {
+	refs, err := gs.loadObjectRefs(ctx, scan.ProviderType)
+	if err != nil {
+		gs.finishScan(ctx, &scan, err)
+		return
+	}
+
+	deleteBefore := scan.StartedAt.Add(-orphanGCOptions.SafetyWindow)
+	err = lister.ListObjects(func(object storage.ObjectInfo) bool {
+		scan.ObjectsScanned++
+		if scan.ObjectsScanned%orphanScanProgressInterval == 0 {
+			gs.saveScan(ctx, &scan)
+		}
+
+		if ref, ok := refs[object.Key]; ok {
+			ref.seen = true
+			return true
+		}
+		// Objects written since the references were loaded are not judged
+		if object.LastModified.After(scan.StartedAt) {
+			return true
+		}
+
+		orphan := models.OrphanObject{Key: object.Key, Size: object.Size, LastModified: object.LastModified}
+		if scan.DeleteOrphans && object.LastModified.Before(deleteBefore) && !gs.isReferenced(ctx, scan.ProviderType, object.Key) {
+			if err := client.Delete(object.Key); err != nil {
+				log.Printf("Orphan scan: failed to delete %s: %v", object.Key, err)
+			} else {
+				orphan.Deleted = true
+				scan.DeletedCount++
+				scan.DeletedBytes += object.Size
+			}
+		}
+
+		scan.OrphanCount++
+		scan.OrphanBytes += object.Size
+		if len(scan.Orphans) < orphanScanMaxListed {
+			scan.Orphans = append(scan.Orphans, orphan)
+		}
+		return true
+	})
+	if err != nil {
+		gs.finishScan(ctx, &scan, err)
+		return
+	}
+
+	for key, ref := range refs {
+		if ref.seen {
+			continue
+		}
+		scan.MissingCount++
+		if len(scan.Missing) < orphanScanMaxListed {
+			scan.Missing = append(scan.Missing, models.MissingObject{Kind: ref.kind, FileID: ref.fileID, Key: key})
+		}
+	}
+
+	gs.finishScan(ctx, &scan, nil)
+}
+
+// loadObjectRefs returns the keys of the objects files, versions,
+// thumbnails and media streams on providers of providerType refer to
+func (gs *OrphanGCService) loadObjectRefs(ctx context.Context, providerType string) (map[string]*objectRef, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+	defer cancel()
+
+	refs := map[string]*objectRef{}
+
+	// Trashed files keep their objects until they are purged
+	cursor, err := gs.collections.Files().Find(ctx,
+		bson.M{"storage_provider": providerType},
+		options.Find().SetProjection(bson.M{"_id": 1, "storage_key": 1, "thumbnail_url": 1}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load files: %v", err)
+	}
+	fileIDs := map[primitive.ObjectID]bool{}
+	for cursor.Next(ctx) {
+		var file models.File
+		if err := cursor.Decode(&file); err != nil {
+			continue
+		}
+		fileIDs[file.ID] = true
+		if file.StorageKey != "" {
+			refs[file.StorageKey] = &objectRef{kind: "file", fileID: file.ID}
+		}
+		if key := thumbnailKey(file.ThumbnailURL); key != "" {
+			refs[key] = &objectRef{kind: "thumbnail", fileID: file.ID}
+		}
+	}
+	cursor.Close(ctx)
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("failed to load files: %v", err)
+	}
+
+	// Versions are stored on the provider of their file
+	cursor, err = gs.collections.FileVersions().Find(ctx, bson.M{},
+		options.Find().SetProjection(bson.M{"file_id": 1, "storage_key": 1}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load file versions: %v", err)
+	}
+	for cursor.Next(ctx) {
+		var version models.FileVersion
+		if err := cursor.Decode(&version); err != nil {
+			continue
+		}
+		if !fileIDs[version.FileID] || version.StorageKey == "" {
+			continue
+		}
+		if _, ok := refs[version.StorageKey]; !ok {
+			refs[version.StorageKey] = &objectRef{kind: "version", fileID: version.FileID}
+		}
+	}
+	cursor.Close(ctx)
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("failed to load file versions: %v", err)
+	}
+
+	// Media streams keep the HLS segments they were transcoded to
+	cursor, err = gs.collections.MediaStreams().Find(ctx,
+		bson.M{"storage_provider": providerType},
+		options.Find().SetProjection(bson.M{"file_id": 1, "segments.key": 1}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load media streams: %v", err)
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var stream models.MediaStream
+		if err := cursor.Decode(&stream); err != nil {
+			continue
+		}
+		for _, segment := range stream.Segments {
+			refs[segment.Key] = &objectRef{kind: "stream_segment", fileID: stream.FileID}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("failed to load media streams: %v", err)
+	}
+
+	return refs, nil
+}
+
+// isReferenced checks again, just before an orphan is deleted, that no
+// record has come to refer to it since the scan started
+func (gs *OrphanGCService) isReferenced(ctx context.Context, providerType, key string) bool {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	count, err := gs.collections.Files().CountDocuments(ctx, bson.M{"storage_provider": providerType, "storage_key": key})
+	if err != nil || count > 0 {
+		return true
+	}
+	count, err = gs.collections.FileVersions().CountDocuments(ctx, bson.M{"storage_key": key})
+	if err != nil || count > 0 {
+		return true
+	}
+	count, err = gs.collections.MediaStreams().CountDocuments(ctx, bson.M{"storage_provider": providerType, "segments.key": key})
+	return err != nil || count > 0
+}
+
+// thumbnailKey returns the key of a thumbnail stored alongside files, or ""
+// for thumbnails served from elsewhere
+func thumbnailKey(thumbnailURL string) string {
+	if !strings.HasPrefix(thumbnailURL, "/") || strings.HasPrefix(thumbnailURL, "//") {
+		return ""
+	}
+	return strings.TrimPrefix(thumbnailURL, "/")
+}
+
+func (gs *OrphanGCService) saveScan(ctx context.Context, scan *models.OrphanScan) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	gs.collections.OrphanScans().ReplaceOne(ctx, bson.M{"_id": scan.ID}, scan)
+}
+
+func (gs *OrphanGCService) finishScan(ctx context.Context, scan *models.OrphanScan, err error) {
+	now := time.Now()
+	scan.CompletedAt = &now
+	scan.Status = models.OrphanScanCompleted
+	if err != nil {
+		scan.Status = models.OrphanScanFailed
+		scan.Error = err.Error()
+		log.Printf("Orphan scan %s failed: %v", scan.ID.Hex(), err)
+	}
+	gs.saveScan(ctx, scan)
+
+	if scan.DeletedCount > 0 {
+		gs.auditService.Record(ctx, &models.AuditLog{
+			ActorType:    "system",
+			Action:       "orphan_gc_deleted",
+			ResourceType: "storage_provider",
+			ResourceID:   scan.ProviderID.Hex(),
+			Outcome:      "success",
+			Details: map[string]interface{}{
+				"scan_id":       scan.ID.Hex(),
+				"deleted_count": scan.DeletedCount,
+				"deleted_bytes": scan.DeletedBytes,
+			},
+		})
+	}
+}
@@ -0,0 +1,304 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"oncloud/models"
+	"oncloud/utils"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrPasswordResetThrottled    = errors.New("too many password reset requests, try again later")
+	ErrPasswordResetTokenInvalid = errors.New("invalid or expired reset token")
+)
+
+// PasswordResetOptions configures password reset tokens and the throttling
+// of requests for them
+type PasswordResetOptions struct {
+	// TokenTTL is how long a reset link works
+	TokenTTL time.Duration
+	// MaxPerEmail and MaxPerIP are how many resets can be asked for an email
+	// address, and from an IP address, per Window
+	MaxPerEmail int
+	MaxPerIP    int
+	Window      time.Duration
+	// ResetURL is the page of the web app the emailed link opens, which gets
+	// the token in its "token" query parameter
+	ResetURL string
+}
+
+var passwordResetOptions = PasswordResetOptions{
+	TokenTTL:    time.Hour,
+	MaxPerEmail: 3,
+	MaxPerIP:    10,
+	Window:      time.Hour,
+}
+
+// InitPasswordResets sets how long reset links work and how often they can
+// be asked for
+func InitPasswordResets(opts PasswordResetOptions) {
+	passwordResetOptions = opts
+}
+
+// PasswordResetService lets users who forgot their password choose a new
+// one through a single-use link sent to their email address
+type PasswordResetService struct {
+	*BaseService
+	notificationService *NotificationService
+	sessions            *SessionService
+	policies            *SecurityPolicyService
+}
+
+func NewPasswordResetService() *PasswordResetService {
+	return NewPasswordResetServiceWith(Dependencies{})
+}
+
+// NewPasswordResetServiceWith creates a password reset service on the given
+// dependencies
+func NewPasswordResetServiceWith(deps Dependencies) *PasswordResetService {
+	return &PasswordResetService{
+		BaseService:         NewBaseServiceWith(deps),
+		notificationService: NewNotificationServiceWith(deps),
+		sessions:            NewSessionServiceWith(deps),
+		policies:            NewSecurityPolicyServiceWith(deps),
+	}
+}
+
+// RequestReset emails a reset link to the account with email, replacing any
+// link sent to it before. Whether the account exists is not revealed: both
+// cases count towards the throttling, and failures to send the email are
+// only logged.
+func (ps *PasswordResetService) RequestReset(ctx context.Context, email, clientIP, userAgent string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	email = strings.ToLower(strings.TrimSpace(email))
+	if err := ps.checkThrottle(ctx, email, clientIP); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	reset := &models.PasswordReset{
+		ID:        primitive.NewObjectID(),
+		Email:     email,
+		IPAddress: clientIP,
+		UserAgent: userAgent,
+		ExpiresAt: now.Add(passwordResetOptions.TokenTTL),
+		CreatedAt: now,
+	}
+
+	var user models.User
+	err := ps.collections.Users().FindOne(ctx, bson.M{"email": email}).Decode(&user)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return fmt.Errorf("database error: %v", err)
+	}
+	found := err == nil && user.IsActive
+
+	var token string
+	if found {
+		if token, err = utils.GenerateSecureToken(32); err != nil {
+			return fmt.Errorf("failed to generate reset token: %v", err)
+		}
+		reset.UserID = &user.ID
+		reset.TokenHash = utils.HashSHA256(token)
+
+		// Only the latest link works
+		if _, err := ps.collections.PasswordResets().UpdateMany(ctx,
+			bson.M{"user_id": user.ID, "used_at": bson.M{"$exists": false}, "expires_at": bson.M{"$gt": now}},
+			bson.M{"$set": bson.M{"expires_at": now}},
+		); err != nil {
+			return fmt.Errorf("failed to replace reset tokens: %v", err)
+		}
+	}
+
+	if _, err := ps.collections.PasswordResets().InsertOne(ctx, reset); err != nil {
+		return fmt.Errorf("failed to store reset token: %v", err)
+	}
+	if !found {
+		return nil
+	}
+
+	err = ps.notificationService.SendEmail(user.Email, "reset", map[string]string{
+		"name":    user.FirstName + " " + user.LastName,
+		"token":   token,
+		"link":    passwordResetLink(token),
+		"expires": describeDuration(passwordResetOptions.TokenTTL),
+	})
+	if err != nil {
+		// Failing here would tell that the account exists
+		log.Printf("Failed to send password reset email to user %s: %v", user.ID.Hex(), err)
+	}
+	return nil
+}
+
+// CheckToken reports whether token can still reset a password, so the reset
+// page can tell the link is stale before a new password is typed in
+func (ps *PasswordResetService) CheckToken(ctx context.Context, token string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := ps.findReset(ctx, token)
+	return err
+}
+
+// ResetPassword sets the password of the account token was sent to. The
+// token is used up, other reset links are invalidated, every session of the
+// account is ended, and the user is told their password changed.
+func (ps *PasswordResetService) ResetPassword(ctx context.Context, token, newPassword string) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	reset, err := ps.findReset(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	var user models.User
+	if err := ps.collections.Users().FindOne(ctx, bson.M{"_id": reset.UserID, "is_active": true}).Decode(&user); err != nil {
+		return nil, ErrPasswordResetTokenInvalid
+	}
+	if err := ps.policies.ValidatePassword(ctx, newPassword, &user); err != nil {
+		return nil, err
+	}
+	hashedPassword, err := utils.HashPassword(newPassword)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %v", err)
+	}
+
+	// Claiming the token first keeps two requests from both using it
+	now := time.Now()
+	result, err := ps.collections.PasswordResets().UpdateOne(ctx,
+		bson.M{"_id": reset.ID, "used_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"used_at": now}},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to use reset token: %v", err)
+	}
+	if result.ModifiedCount == 0 {
+		return nil, ErrPasswordResetTokenInvalid
+	}
+
+	_, err = ps.collections.Users().UpdateOne(ctx,
+		bson.M{"_id": user.ID},
+		bson.M{"$set": bson.M{
+			"password":   hashedPassword,
+			"updated_at": now,
+		}},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update password: %v", err)
+	}
+
+	ps.collections.PasswordResets().UpdateMany(ctx,
+		bson.M{"user_id": user.ID, "used_at": bson.M{"$exists": false}, "expires_at": bson.M{"$gt": now}},
+		bson.M{"$set": bson.M{"expires_at": now}},
+	)
+
+	// Whoever held the old password must not stay signed in
+	ps.sessions.RevokeOtherSessions(ctx, user.ID, "", "password_reset")
+
+	if err := ps.notificationService.Notify(ctx, user.ID, "password_changed", "Your password was changed",
+		"Your password was reset and every device signed out.", bson.M{"ip_address": reset.IPAddress}); err != nil {
+		log.Printf("Failed to notify user %s of password reset: %v", user.ID.Hex(), err)
+	}
+	if err := ps.notificationService.SendEmail(user.Email, "password_changed", map[string]string{
+		"name": user.FirstName + " " + user.LastName,
+		"time": now.UTC().Format(time.RFC1123),
+	}); err != nil {
+		log.Printf("Failed to email user %s of password reset: %v", user.ID.Hex(), err)
+	}
+
+	user.Password = ""
+	return &user, nil
+}
+
+// findReset returns the unused, unexpired reset token was issued as
+func (ps *PasswordResetService) findReset(ctx context.Context, token string) (*models.PasswordReset, error) {
+	if token == "" {
+		return nil, ErrPasswordResetTokenInvalid
+	}
+
+	var reset models.PasswordReset
+	err := ps.collections.PasswordResets().FindOne(ctx, bson.M{
+		"token_hash": utils.HashSHA256(token),
+		"used_at":    bson.M{"$exists": false},
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).Decode(&reset)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrPasswordResetTokenInvalid
+		}
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	if reset.UserID == nil {
+		return nil, ErrPasswordResetTokenInvalid
+	}
+	return &reset, nil
+}
+
+// checkThrottle refuses requests past the limits for the email address or
+// the IP address within the window
+func (ps *PasswordResetService) checkThrottle(ctx context.Context, email, clientIP string) error {
+	opts := passwordResetOptions
+	since := time.Now().Add(-opts.Window)
+
+	if opts.MaxPerEmail > 0 {
+		count, err := ps.collections.PasswordResets().CountDocuments(ctx, bson.M{"email": email, "created_at": bson.M{"$gt": since}})
+		if err != nil {
+			return fmt.Errorf("database error: %v", err)
+		}
+		if count >= int64(opts.MaxPerEmail) {
+			return ErrPasswordResetThrottled
+		}
+	}
+	if opts.MaxPerIP > 0 && clientIP != "" {
+		count, err := ps.collections.PasswordResets().CountDocuments(ctx, bson.M{"ip_address": clientIP, "created_at": bson.M{"$gt": since}})
+		if err != nil {
+			return fmt.Errorf("database error: %v", err)
+		}
+		if count >= int64(opts.MaxPerIP) {
+			return ErrPasswordResetThrottled
+		}
+	}
+	return nil
+}
+
+// passwordResetLink is the link to the reset page for token, or "" when no
+// reset page is configured and the token is entered by hand
+func passwordResetLink(token string) string {
+	if passwordResetOptions.ResetURL == "" {
+		return ""
+	}
+	link, err := url.Parse(passwordResetOptions.ResetURL)
+	if err != nil {
+		return ""
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+	return link.String()
+}
+
+// describeDuration words a duration of whole minutes or hours for emails
+func describeDuration(d time.Duration) string {
+	switch {
+	case d >= time.Hour && d%time.Hour == 0:
+		if d == time.Hour {
+			return "1 hour"
+		}
+		return fmt.Sprintf("%d hours", d/time.Hour)
+	case d == time.Minute:
+		return "1 minute"
+	default:
+		return fmt.Sprintf("%d minutes", d/time.Minute)
+	}
+}
@@ -0,0 +1,205 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"oncloud/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	PaymentGatewayStripe = "stripe"
+
+	// When the service of a user with a chargeback is paused
+	ChargebackPauseOpened = "opened"
+	ChargebackPauseLost   = "lost"
+	ChargebackPauseNever  = "never"
+
+	// Stripe webhooks signed longer ago than this are rejected as replays
+	stripeSignatureMaxAge = 5 * time.Minute
+)
+
+// ErrPaymentWebhookUnverified is returned for webhook requests that were
+// not signed by the payment gateway
+var ErrPaymentWebhookUnverified = errors.New("payment webhook could not be verified")
+
+// gatewayRefund is a refund as the payment gateway made it
+type gatewayRefund struct {
+	ID            string
+	Status        string // a refund status
+	FailureReason string
+}
+
+// paymentGateway is a payment processor payments are taken and refunded
+// through. Adding one means implementing this and registering it in
+// InitPaymentGateway.
+type paymentGateway interface {
+	Name() string
+	// Refund gives back refund.Amount of the payment. It may be called
+	// again for the same refund, which the gateway makes once.
+	Refund(ctx context.Context, payment *models.Payment, refund *models.Refund) (*gatewayRefund, error)
+}
+
+// StripeGatewayOptions configures the Stripe account payments are taken
+// with
+type StripeGatewayOptions struct {
+	SecretKey     string
+	WebhookSecret string
+}
+
+// PaymentGatewayOptions configures the gateway refunds are issued through
+// and what chargebacks do to the accounts they are against
+type PaymentGatewayOptions struct {
+	Gateway         string
+	Stripe          StripeGatewayOptions
+	ChargebackPause string // ChargebackPauseOpened, ChargebackPauseLost or ChargebackPauseNever
+}
+
+var (
+	activePaymentGateway paymentGateway
+	stripeWebhookSecret  string
+	chargebackPause      = ChargebackPauseOpened
+)
+
+// InitPaymentGateway sets the payment gateway refunds are issued through,
+// when it is configured, and the chargeback policy
+func InitPaymentGateway(opts PaymentGatewayOptions) {
+	stripeWebhookSecret = opts.Stripe.WebhookSecret
+	if opts.ChargebackPause != "" {
+		chargebackPause = opts.ChargebackPause
+	}
+	if opts.Gateway == PaymentGatewayStripe && opts.Stripe.SecretKey != "" {
+		activePaymentGateway = &stripeGateway{options: opts.Stripe}
+	}
+}
+
+// Stripe
+
+const stripeAPIURL = "https://api.stripe.com/v1"
+
+// stripeZeroDecimalCurrencies are the currencies Stripe takes amounts of in
+// whole units rather than cents
+var stripeZeroDecimalCurrencies = map[string]bool{
+	"bif": true, "clp": true, "djf": true, "gnf": true, "jpy": true, "kmf": true,
+	"krw": true, "mga": true, "pyg": true, "rwf": true, "ugx": true, "vnd": true,
+	"vuv": true, "xaf": true, "xof": true, "xpf": true,
+}
+
+type stripeGateway struct {
+	options StripeGatewayOptions
+}
+
+func (s *stripeGateway) Name() string {
+	return PaymentGatewayStripe
+}
+
+func (s *stripeGateway) Refund(ctx context.Context, payment *models.Payment, refund *models.Refund) (*gatewayRefund, error) {
+	form := url.Values{}
+	// Payments are recorded with their charge or, for newer payments,
+	// their payment intent
+	if strings.HasPrefix(payment.TransactionID, "pi_") {
+		form.Set("payment_intent", payment.TransactionID)
+	} else {
+		form.Set("charge", payment.TransactionID)
+	}
+	form.Set("amount", strconv.FormatInt(stripeAmount(refund.Amount, refund.Currency), 10))
+	form.Set("reason", refund.Reason)
+	form.Set("metadata[refund_id]", refund.ID.Hex())
+
+	var result struct {
+		ID            string `json:"id"`
+		Status        string `json:"status"`
+		FailureReason string `json:"failure_reason"`
+	}
+	err := cloudJSON(ctx, &result, func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPost, stripeAPIURL+"/refunds", strings.NewReader(form.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+s.options.SecretKey)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		// Retried requests must not refund twice
+		req.Header.Set("Idempotency-Key", "refund-"+refund.ID.Hex())
+		return req, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("stripe: %w", err)
+	}
+
+	return &gatewayRefund{
+		ID:            result.ID,
+		Status:        stripeRefundStatus(result.Status),
+		FailureReason: result.FailureReason,
+	}, nil
+}
+
+// stripeRefundStatus is the refund status of a Stripe refund status
+func stripeRefundStatus(status string) string {
+	switch status {
+	case "succeeded":
+		return models.RefundSucceeded
+	case "failed", "canceled":
+		return models.RefundFailed
+	default: // pending, requires_action
+		return models.RefundPending
+	}
+}
+
+// stripeAmount is an amount in the smallest unit of its currency, as Stripe
+// takes amounts
+func stripeAmount(amount float64, currency string) int64 {
+	if stripeZeroDecimalCurrencies[strings.ToLower(currency)] {
+		return int64(math.Round(amount))
+	}
+	return int64(math.Round(amount * 100))
+}
+
+// fromStripeAmount is an amount Stripe gave in the smallest unit of its
+// currency
+func fromStripeAmount(amount int64, currency string) float64 {
+	if stripeZeroDecimalCurrencies[strings.ToLower(currency)] {
+		return float64(amount)
+	}
+	return float64(amount) / 100
+}
+
+// verifyStripeSignature checks the Stripe-Signature header of a webhook
+// request: a timestamp and HMAC-SHA256 signatures of it and the payload
+func verifyStripeSignature(payload []byte, header, secret string, now time.Time) error {
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+
+	signedAt, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || now.Sub(time.Unix(signedAt, 0)).Abs() > stripeSignatureMaxAge {
+		return ErrPaymentWebhookUnverified
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	expected := hex.EncodeToString(mac.Sum(nil))
+	for _, signature := range signatures {
+		if hmac.Equal([]byte(expected), []byte(signature)) {
+			return nil
+		}
+	}
+	return ErrPaymentWebhookUnverified
+}
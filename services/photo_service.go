@@ -0,0 +1,254 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"oncloud/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const maxMapClusters = 1000
+
+// timelineFormats maps a timeline granularity to its bucket key format
+var timelineFormats = map[string]string{
+	"year":  "%Y",
+	"month": "%Y-%m",
+	"day":   "%Y-%m-%d",
+}
+
+// GeoBounds is a map viewport. MinLongitude is greater than MaxLongitude
+// when the viewport crosses the antimeridian.
+type GeoBounds struct {
+	MinLatitude  float64
+	MinLongitude float64
+	MaxLatitude  float64
+	MaxLongitude float64
+}
+
+// PhotoFilters narrows the photos shown in the timeline and map views
+type PhotoFilters struct {
+	From          *time.Time
+	To            *time.Time
+	Bounds        *GeoBounds
+	IncludeVideos bool
+}
+
+type PhotoService struct {
+	*BaseService
+}
+
+func NewPhotoService() *PhotoService {
+	return NewPhotoServiceWith(Dependencies{})
+}
+
+// NewPhotoServiceWith creates a photo service on the given dependencies
+func NewPhotoServiceWith(deps Dependencies) *PhotoService {
+	return &PhotoService{
+		BaseService: NewBaseServiceWith(deps),
+	}
+}
+
+// GetTimeline groups the user's photos by the day, month or year they were
+// taken, newest first. Capture dates are bucketed as recorded by the camera.
+func (ps *PhotoService) GetTimeline(ctx context.Context, userID primitive.ObjectID, granularity string, filters *PhotoFilters, page, limit int) ([]models.TimelineBucket, int, error) {
+	format, ok := timelineFormats[granularity]
+	if !ok {
+		return nil, 0, fmt.Errorf("invalid granularity: %s", granularity)
+	}
+
+	pipeline := ps.photoPipeline(userID, filters, false)
+	pipeline = append(pipeline,
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "taken_at", Value: -1}, {Key: "_id", Value: -1}}}},
+		bson.D{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"$dateToString": bson.M{"format": format, "date": "$taken_at"}},
+			"count": bson.M{"$sum": 1},
+			"start": bson.M{"$min": "$taken_at"},
+			"end":   bson.M{"$max": "$taken_at"},
+			"cover": bson.M{"$first": "$$ROOT"},
+		}}},
+		bson.D{{Key: "$sort", Value: bson.M{"_id": -1}}},
+	)
+
+	var buckets []models.TimelineBucket
+	total, err := ps.aggregatePage(ctx, pipeline, page, limit, &buckets)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get timeline: %v", err)
+	}
+	if buckets == nil {
+		buckets = []models.TimelineBucket{}
+	}
+
+	return buckets, total, nil
+}
+
+// GetPhotos lists the user's photos newest first, e.g. the photos of one
+// timeline bucket or map cluster
+func (ps *PhotoService) GetPhotos(ctx context.Context, userID primitive.ObjectID, filters *PhotoFilters, page, limit int) ([]models.PhotoItem, int, error) {
+	pipeline := ps.photoPipeline(userID, filters, false)
+	pipeline = append(pipeline,
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "taken_at", Value: -1}, {Key: "_id", Value: -1}}}},
+	)
+
+	var photos []models.PhotoItem
+	total, err := ps.aggregatePage(ctx, pipeline, page, limit, &photos)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get photos: %v", err)
+	}
+	if photos == nil {
+		photos = []models.PhotoItem{}
+	}
+
+	return photos, total, nil
+}
+
+// GetMapClusters groups the user's geotagged photos into grid cells sized
+// for the map zoom level (0-20), largest clusters first
+func (ps *PhotoService) GetMapClusters(ctx context.Context, userID primitive.ObjectID, zoom int, filters *PhotoFilters) ([]models.MapCluster, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	// Roughly four cells across each 256px map tile
+	cell := 360 / (4 * math.Pow(2, float64(zoom)))
+
+	pipeline := ps.photoPipeline(userID, filters, true)
+	pipeline = append(pipeline,
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "taken_at", Value: -1}, {Key: "_id", Value: -1}}}},
+		bson.D{{Key: "$group", Value: bson.M{
+			"_id": bson.M{
+				"lat": bson.M{"$floor": bson.M{"$divide": bson.A{"$location.latitude", cell}}},
+				"lon": bson.M{"$floor": bson.M{"$divide": bson.A{"$location.longitude", cell}}},
+			},
+			"count":         bson.M{"$sum": 1},
+			"latitude":      bson.M{"$avg": "$location.latitude"},
+			"longitude":     bson.M{"$avg": "$location.longitude"},
+			"min_latitude":  bson.M{"$min": "$location.latitude"},
+			"max_latitude":  bson.M{"$max": "$location.latitude"},
+			"min_longitude": bson.M{"$min": "$location.longitude"},
+			"max_longitude": bson.M{"$max": "$location.longitude"},
+			"cover":         bson.M{"$first": "$$ROOT"},
+		}}},
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+		bson.D{{Key: "$limit", Value: maxMapClusters}},
+	)
+
+	cursor, err := ps.collections.Files().Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get map clusters: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	clusters := []models.MapCluster{}
+	if err := cursor.All(ctx, &clusters); err != nil {
+		return nil, fmt.Errorf("failed to decode map clusters: %v", err)
+	}
+
+	return clusters, nil
+}
+
+// photoPipeline matches the user's photos and shapes them as PhotoItems.
+// Photos without a capture date fall back to their upload date.
+func (ps *PhotoService) photoPipeline(userID primitive.ObjectID, filters *PhotoFilters, geotagged bool) mongo.Pipeline {
+	mimeTypes := "^image/"
+	if filters.IncludeVideos {
+		mimeTypes = "^(image|video)/"
+	}
+
+	match := bson.M{
+		"user_id":    userID,
+		"is_deleted": false,
+		"mime_type":  bson.M{"$regex": mimeTypes},
+	}
+	if geotagged || filters.Bounds != nil {
+		match["media.location"] = bson.M{"$exists": true}
+	}
+	if b := filters.Bounds; b != nil {
+		match["media.location.latitude"] = bson.M{"$gte": b.MinLatitude, "$lte": b.MaxLatitude}
+		if b.MinLongitude <= b.MaxLongitude {
+			match["media.location.longitude"] = bson.M{"$gte": b.MinLongitude, "$lte": b.MaxLongitude}
+		} else {
+			match["$or"] = []bson.M{
+				{"media.location.longitude": bson.M{"$gte": b.MinLongitude}},
+				{"media.location.longitude": bson.M{"$lte": b.MaxLongitude}},
+			}
+		}
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$project", Value: bson.M{
+			"folder_id":     1,
+			"name":          1,
+			"mime_type":     1,
+			"size":          1,
+			"thumbnail_url": 1,
+			"width":         "$media.width",
+			"height":        "$media.height",
+			"location":      "$media.location",
+			"taken_at":      bson.M{"$ifNull": bson.A{"$media.captured_at", "$created_at"}},
+		}}},
+	}
+
+	if filters.From != nil || filters.To != nil {
+		taken := bson.M{}
+		if filters.From != nil {
+			taken["$gte"] = *filters.From
+		}
+		if filters.To != nil {
+			taken["$lt"] = *filters.To
+		}
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.M{"taken_at": taken}}})
+	}
+
+	return pipeline
+}
+
+// aggregatePage runs pipeline and decodes one page of its results into
+// results, returning the total number of results
+func (ps *PhotoService) aggregatePage(ctx context.Context, pipeline mongo.Pipeline, page, limit int, results interface{}) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	pipeline = append(pipeline, bson.D{{Key: "$facet", Value: bson.M{
+		"items": bson.A{
+			bson.M{"$skip": (page - 1) * limit},
+			bson.M{"$limit": limit},
+		},
+		"total": bson.A{
+			bson.M{"$count": "count"},
+		},
+	}}})
+
+	cursor, err := ps.collections.Files().Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var facets []struct {
+		Items bson.RawValue `bson:"items"`
+		Total []struct {
+			Count int `bson:"count"`
+		} `bson:"total"`
+	}
+	if err := cursor.All(ctx, &facets); err != nil {
+		return 0, err
+	}
+	if len(facets) == 0 {
+		return 0, nil
+	}
+
+	if err := facets[0].Items.Unmarshal(results); err != nil {
+		return 0, err
+	}
+	total := 0
+	if len(facets[0].Total) > 0 {
+		total = facets[0].Total[0].Count
+	}
+	return total, nil
+}
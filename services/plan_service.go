@@ -19,28 +19,38 @@ import (
 )
 
 type PlanService struct {
-	planCollection         *mongo.Collection
-	userCollection         *mongo.Collection
-	subscriptionCollection *mongo.Collection
-	usageCollection        *mongo.Collection
-	billingCollection      *mongo.Collection
-	invoiceCollection      *mongo.Collection
+	planCollection          *mongo.Collection
+	userCollection          *mongo.Collection
+	subscriptionCollection  *mongo.Collection
+	usageCollection         *mongo.Collection
+	billingCollection       *mongo.Collection
+	invoiceCollection       *mongo.Collection
+	paymentMethodCollection *mongo.Collection
+	webhookLogCollection    *mongo.Collection
 }
 
 func NewPlanService() *PlanService {
+	return NewPlanServiceWith(Dependencies{})
+}
+
+// NewPlanServiceWith creates a plan service on the given dependencies
+func NewPlanServiceWith(deps Dependencies) *PlanService {
+	db := deps.database()
 	return &PlanService{
-		planCollection:         database.GetCollection("plans"),
-		userCollection:         database.GetCollection("users"),
-		subscriptionCollection: database.GetCollection("subscriptions"),
-		usageCollection:        database.GetCollection("usage_tracking"),
-		billingCollection:      database.GetCollection("billing_history"),
-		invoiceCollection:      database.GetCollection("invoices"),
+		planCollection:          db.GetCollection("plans"),
+		userCollection:          db.GetCollection("users"),
+		subscriptionCollection:  db.GetCollection("subscriptions"),
+		usageCollection:         db.GetCollection("usage_tracking"),
+		billingCollection:       db.GetCollection("billing_history"),
+		invoiceCollection:       db.GetCollection("invoices"),
+		paymentMethodCollection: db.GetCollection("payment_methods"),
+		webhookLogCollection:    db.GetCollection(database.WebhookLogsCollection),
 	}
 }
 
 // Public Plan Operations (for users)
-func (ps *PlanService) GetPlans() ([]models.Plan, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (ps *PlanService) GetPlans(ctx context.Context) ([]models.Plan, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	cursor, err := ps.planCollection.Find(ctx, bson.M{"is_active": true},
@@ -59,8 +69,8 @@ func (ps *PlanService) GetPlans() ([]models.Plan, error) {
 	return plans, nil
 }
 
-func (ps *PlanService) GetPlan(planID primitive.ObjectID) (*models.Plan, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (ps *PlanService) GetPlan(ctx context.Context, planID primitive.ObjectID) (*models.Plan, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	var plan models.Plan
@@ -76,8 +86,8 @@ func (ps *PlanService) GetPlan(planID primitive.ObjectID) (*models.Plan, error)
 }
 
 // Plan Service - GetPlansForAdmin Function
-func (ps *PlanService) GetPlansForAdmin(page, limit int, includeInactive bool) ([]models.Plan, int64, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (ps *PlanService) GetPlansForAdmin(ctx context.Context, page, limit int, includeInactive bool) ([]models.Plan, int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	filter := bson.M{}
@@ -113,8 +123,8 @@ func (ps *PlanService) GetPlansForAdmin(page, limit int, includeInactive bool) (
 	return plans, total, nil
 }
 
-func (ps *PlanService) ComparePlans() ([]models.Plan, error) {
-	plans, err := ps.GetPlans()
+func (ps *PlanService) ComparePlans(ctx context.Context) ([]models.Plan, error) {
+	plans, err := ps.GetPlans(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -122,8 +132,8 @@ func (ps *PlanService) ComparePlans() ([]models.Plan, error) {
 	return plans, nil
 }
 
-func (ps *PlanService) GetPricing() (map[string]interface{}, error) {
-	plans, err := ps.GetPlans()
+func (ps *PlanService) GetPricing(ctx context.Context) (map[string]interface{}, error) {
+	plans, err := ps.GetPlans(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -144,8 +154,8 @@ func (ps *PlanService) GetPricing() (map[string]interface{}, error) {
 }
 
 // User Plan Management
-func (ps *PlanService) GetUserPlan(userID primitive.ObjectID) (*models.Plan, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (ps *PlanService) GetUserPlan(ctx context.Context, userID primitive.ObjectID) (*models.Plan, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	// Get user
@@ -165,12 +175,12 @@ func (ps *PlanService) GetUserPlan(userID primitive.ObjectID) (*models.Plan, err
 	return &plan, nil
 }
 
-func (ps *PlanService) Subscribe(userID, planID primitive.ObjectID, paymentMethodID string) (map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+func (ps *PlanService) Subscribe(ctx context.Context, userID, planID primitive.ObjectID, paymentMethodID string) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
 	// Get plan
-	plan, err := ps.GetPlan(planID)
+	plan, err := ps.GetPlan(ctx, planID)
 	if err != nil {
 		return nil, err
 	}
@@ -227,18 +237,18 @@ func (ps *PlanService) Subscribe(userID, planID primitive.ObjectID, paymentMetho
 	return result, nil
 }
 
-func (ps *PlanService) UpgradePlan(userID, newPlanID primitive.ObjectID, paymentMethodID string) (map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+func (ps *PlanService) UpgradePlan(ctx context.Context, userID, newPlanID primitive.ObjectID, paymentMethodID string) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
 	// Get current plan
-	currentPlan, err := ps.GetUserPlan(userID)
+	currentPlan, err := ps.GetUserPlan(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
 
 	// Get new plan
-	newPlan, err := ps.GetPlan(newPlanID)
+	newPlan, err := ps.GetPlan(ctx, newPlanID)
 	if err != nil {
 		return nil, err
 	}
@@ -290,18 +300,18 @@ func (ps *PlanService) UpgradePlan(userID, newPlanID primitive.ObjectID, payment
 	return result, nil
 }
 
-func (ps *PlanService) DowngradePlan(userID, newPlanID primitive.ObjectID) (map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+func (ps *PlanService) DowngradePlan(ctx context.Context, userID, newPlanID primitive.ObjectID) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
 	// Get current plan
-	currentPlan, err := ps.GetUserPlan(userID)
+	currentPlan, err := ps.GetUserPlan(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
 
 	// Get new plan
-	newPlan, err := ps.GetPlan(newPlanID)
+	newPlan, err := ps.GetPlan(ctx, newPlanID)
 	if err != nil {
 		return nil, err
 	}
@@ -342,12 +352,12 @@ func (ps *PlanService) DowngradePlan(userID, newPlanID primitive.ObjectID) (map[
 	return result, nil
 }
 
-func (ps *PlanService) CancelSubscription(userID primitive.ObjectID) (map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+func (ps *PlanService) CancelSubscription(ctx context.Context, userID primitive.ObjectID) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
 	// Get current plan
-	currentPlan, err := ps.GetUserPlan(userID)
+	currentPlan, err := ps.GetUserPlan(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -392,12 +402,12 @@ func (ps *PlanService) CancelSubscription(userID primitive.ObjectID) (map[string
 	return result, nil
 }
 
-func (ps *PlanService) RenewSubscription(userID primitive.ObjectID, paymentMethod, billingCycle string) (map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+func (ps *PlanService) RenewSubscription(ctx context.Context, userID primitive.ObjectID, paymentMethod, billingCycle string) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
 	// Get current plan
-	plan, err := ps.GetUserPlan(userID)
+	plan, err := ps.GetUserPlan(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -436,8 +446,8 @@ func (ps *PlanService) RenewSubscription(userID primitive.ObjectID, paymentMetho
 }
 
 // Billing and Usage
-func (ps *PlanService) GetBillingHistory(userID primitive.ObjectID, page, limit int) ([]map[string]interface{}, int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (ps *PlanService) GetBillingHistory(ctx context.Context, userID primitive.ObjectID, page, limit int) ([]map[string]interface{}, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	skip := (page - 1) * limit
@@ -466,8 +476,8 @@ func (ps *PlanService) GetBillingHistory(userID primitive.ObjectID, page, limit
 	return history, int(total), nil
 }
 
-func (ps *PlanService) GetInvoices(userID primitive.ObjectID, page, limit int) ([]map[string]interface{}, int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (ps *PlanService) GetInvoices(ctx context.Context, userID primitive.ObjectID, page, limit int) ([]map[string]interface{}, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	skip := (page - 1) * limit
@@ -496,8 +506,8 @@ func (ps *PlanService) GetInvoices(userID primitive.ObjectID, page, limit int) (
 	return invoices, int(total), nil
 }
 
-func (ps *PlanService) DownloadInvoice(userID, invoiceID primitive.ObjectID) (string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (ps *PlanService) DownloadInvoice(ctx context.Context, userID, invoiceID primitive.ObjectID) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	var invoice map[string]interface{}
@@ -515,8 +525,8 @@ func (ps *PlanService) DownloadInvoice(userID, invoiceID primitive.ObjectID) (st
 }
 
 // Usage Tracking
-func (ps *PlanService) GetUsage(userID primitive.ObjectID) (map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (ps *PlanService) GetUsage(ctx context.Context, userID primitive.ObjectID) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	// Get user
@@ -527,7 +537,7 @@ func (ps *PlanService) GetUsage(userID primitive.ObjectID) (map[string]interface
 	}
 
 	// Get plan
-	plan, err := ps.GetUserPlan(userID)
+	plan, err := ps.GetUserPlan(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -566,8 +576,8 @@ func (ps *PlanService) GetUsage(userID primitive.ObjectID) (map[string]interface
 	return usage, nil
 }
 
-func (ps *PlanService) GetUsageHistory(userID primitive.ObjectID, period, usageType string) ([]map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (ps *PlanService) GetUsageHistory(ctx context.Context, userID primitive.ObjectID, period, usageType string) ([]map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	days, _ := strconv.Atoi(period)
@@ -601,8 +611,8 @@ func (ps *PlanService) GetUsageHistory(userID primitive.ObjectID, period, usageT
 	return history, nil
 }
 
-func (ps *PlanService) GetLimits(userID primitive.ObjectID) (map[string]interface{}, error) {
-	plan, err := ps.GetUserPlan(userID)
+func (ps *PlanService) GetLimits(ctx context.Context, userID primitive.ObjectID) (map[string]interface{}, error) {
+	plan, err := ps.GetUserPlan(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -641,8 +651,8 @@ func (ps *PlanService) GetLimits(userID primitive.ObjectID) (map[string]interfac
 }
 
 // Admin Plan Management (for AdminController)
-func (ps *PlanService) GetAllPlansForAdmin() ([]models.Plan, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (ps *PlanService) GetAllPlansForAdmin(ctx context.Context) ([]models.Plan, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	cursor, err := ps.planCollection.Find(ctx, bson.M{},
@@ -661,8 +671,8 @@ func (ps *PlanService) GetAllPlansForAdmin() ([]models.Plan, error) {
 	return plans, nil
 }
 
-func (ps *PlanService) GetPlanForAdmin(planID primitive.ObjectID) (*models.Plan, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (ps *PlanService) GetPlanForAdmin(ctx context.Context, planID primitive.ObjectID) (*models.Plan, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	var plan models.Plan
@@ -674,8 +684,8 @@ func (ps *PlanService) GetPlanForAdmin(planID primitive.ObjectID) (*models.Plan,
 	return &plan, nil
 }
 
-func (ps *PlanService) CreatePlan(plan *models.Plan) (*models.Plan, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (ps *PlanService) CreatePlan(ctx context.Context, plan *models.Plan) (*models.Plan, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	// Generate slug if not provided
@@ -704,8 +714,8 @@ func (ps *PlanService) CreatePlan(plan *models.Plan) (*models.Plan, error) {
 	return plan, nil
 }
 
-func (ps *PlanService) UpdatePlan(planID primitive.ObjectID, updates map[string]interface{}) (*models.Plan, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (ps *PlanService) UpdatePlan(ctx context.Context, planID primitive.ObjectID, updates map[string]interface{}) (*models.Plan, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	updates["updated_at"] = time.Now()
@@ -718,11 +728,11 @@ func (ps *PlanService) UpdatePlan(planID primitive.ObjectID, updates map[string]
 		return nil, fmt.Errorf("failed to update plan: %v", err)
 	}
 
-	return ps.GetPlanForAdmin(planID)
+	return ps.GetPlanForAdmin(ctx, planID)
 }
 
-func (ps *PlanService) DeletePlan(planID primitive.ObjectID) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (ps *PlanService) DeletePlan(ctx context.Context, planID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	// Check if plan is in use
@@ -742,8 +752,8 @@ func (ps *PlanService) DeletePlan(planID primitive.ObjectID) error {
 	return nil
 }
 
-func (ps *PlanService) SetPlanStatus(planID primitive.ObjectID, isActive bool) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (ps *PlanService) SetPlanStatus(ctx context.Context, planID primitive.ObjectID, isActive bool) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	_, err := ps.planCollection.UpdateOne(ctx,
@@ -776,8 +786,8 @@ func (ps *PlanService) calculateNextRenewal(billingCycle string) time.Time {
 }
 
 // GetAvailablePlans returns available plans with optional inactive plans
-func (ps *PlanService) GetAvailablePlans(includeInactive bool) ([]models.Plan, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (ps *PlanService) GetAvailablePlans(ctx context.Context, includeInactive bool) ([]models.Plan, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	filter := bson.M{}
@@ -802,8 +812,8 @@ func (ps *PlanService) GetAvailablePlans(includeInactive bool) ([]models.Plan, e
 }
 
 // GetPlanComparison returns comparison data for specified plans or all active plans
-func (ps *PlanService) GetPlanComparison(planIDs []primitive.ObjectID) (map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (ps *PlanService) GetPlanComparison(ctx context.Context, planIDs []primitive.ObjectID) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	filter := bson.M{"is_active": true}
@@ -849,8 +859,8 @@ func (ps *PlanService) GetPlanComparison(planIDs []primitive.ObjectID) (map[stri
 }
 
 // GetInvoiceDownloadURL generates a download URL for an invoice
-func (ps *PlanService) GetInvoiceDownloadURL(userID, invoiceID primitive.ObjectID) (string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (ps *PlanService) GetInvoiceDownloadURL(ctx context.Context, userID, invoiceID primitive.ObjectID) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	var invoice map[string]interface{}
@@ -874,13 +884,13 @@ func (ps *PlanService) GetInvoiceDownloadURL(userID, invoiceID primitive.ObjectI
 }
 
 // AddPaymentMethod adds a new payment method for a user
-func (ps *PlanService) AddPaymentMethod(userID primitive.ObjectID, paymentType, token string, isDefault bool, metadata map[string]string) (map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+func (ps *PlanService) AddPaymentMethod(ctx context.Context, userID primitive.ObjectID, paymentType, token string, isDefault bool, metadata map[string]string) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
 	defer cancel()
 
 	// If this is set as default, update existing payment methods
 	if isDefault {
-		_, err := database.GetCollection("payment_methods").UpdateMany(ctx,
+		_, err := ps.paymentMethodCollection.UpdateMany(ctx,
 			bson.M{"user_id": userID, "is_default": true},
 			bson.M{"$set": bson.M{
 				"is_default": false,
@@ -936,7 +946,7 @@ func (ps *PlanService) AddPaymentMethod(userID primitive.ObjectID, paymentType,
 		}
 	}
 
-	result, err := database.GetCollection("payment_methods").InsertOne(ctx, paymentMethod)
+	result, err := ps.paymentMethodCollection.InsertOne(ctx, paymentMethod)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create payment method: %v", err)
 	}
@@ -950,11 +960,11 @@ func (ps *PlanService) AddPaymentMethod(userID primitive.ObjectID, paymentType,
 }
 
 // GetPaymentMethods returns all payment methods for a user
-func (ps *PlanService) GetPaymentMethods(userID primitive.ObjectID) ([]map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (ps *PlanService) GetPaymentMethods(ctx context.Context, userID primitive.ObjectID) ([]map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
-	cursor, err := database.GetCollection("payment_methods").Find(ctx,
+	cursor, err := ps.paymentMethodCollection.Find(ctx,
 		bson.M{"user_id": userID, "is_active": true},
 		options.Find().SetSort(bson.M{"is_default": -1, "created_at": -1}),
 	)
@@ -977,13 +987,13 @@ func (ps *PlanService) GetPaymentMethods(userID primitive.ObjectID) ([]map[strin
 }
 
 // UpdatePaymentMethod updates an existing payment method
-func (ps *PlanService) UpdatePaymentMethod(userID, methodID primitive.ObjectID, isDefault bool, metadata map[string]string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+func (ps *PlanService) UpdatePaymentMethod(ctx context.Context, userID, methodID primitive.ObjectID, isDefault bool, metadata map[string]string) error {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
 	defer cancel()
 
 	// Verify payment method belongs to user
 	var existingMethod map[string]interface{}
-	err := database.GetCollection("payment_methods").FindOne(ctx, bson.M{
+	err := ps.paymentMethodCollection.FindOne(ctx, bson.M{
 		"_id":     methodID,
 		"user_id": userID,
 	}).Decode(&existingMethod)
@@ -1005,7 +1015,7 @@ func (ps *PlanService) UpdatePaymentMethod(userID, methodID primitive.ObjectID,
 
 	// If setting as default, unset other default methods
 	if isDefault {
-		_, err := database.GetCollection("payment_methods").UpdateMany(ctx,
+		_, err := ps.paymentMethodCollection.UpdateMany(ctx,
 			bson.M{
 				"user_id":    userID,
 				"is_default": true,
@@ -1021,7 +1031,7 @@ func (ps *PlanService) UpdatePaymentMethod(userID, methodID primitive.ObjectID,
 		}
 	}
 
-	_, err = database.GetCollection("payment_methods").UpdateOne(ctx,
+	_, err = ps.paymentMethodCollection.UpdateOne(ctx,
 		bson.M{"_id": methodID, "user_id": userID},
 		bson.M{"$set": updates},
 	)
@@ -1033,12 +1043,12 @@ func (ps *PlanService) UpdatePaymentMethod(userID, methodID primitive.ObjectID,
 }
 
 // DeletePaymentMethod removes a payment method
-func (ps *PlanService) DeletePaymentMethod(userID, methodID primitive.ObjectID) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (ps *PlanService) DeletePaymentMethod(ctx context.Context, userID, methodID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	// Check if this is the user's only payment method
-	count, err := database.GetCollection("payment_methods").CountDocuments(ctx, bson.M{
+	count, err := ps.paymentMethodCollection.CountDocuments(ctx, bson.M{
 		"user_id":   userID,
 		"is_active": true,
 	})
@@ -1052,7 +1062,7 @@ func (ps *PlanService) DeletePaymentMethod(userID, methodID primitive.ObjectID)
 
 	// Check if this is the default payment method
 	var method map[string]interface{}
-	err = database.GetCollection("payment_methods").FindOne(ctx, bson.M{
+	err = ps.paymentMethodCollection.FindOne(ctx, bson.M{
 		"_id":     methodID,
 		"user_id": userID,
 	}).Decode(&method)
@@ -1063,7 +1073,7 @@ func (ps *PlanService) DeletePaymentMethod(userID, methodID primitive.ObjectID)
 	isDefault, _ := method["is_default"].(bool)
 
 	// Soft delete the payment method
-	_, err = database.GetCollection("payment_methods").UpdateOne(ctx,
+	_, err = ps.paymentMethodCollection.UpdateOne(ctx,
 		bson.M{"_id": methodID, "user_id": userID},
 		bson.M{"$set": bson.M{
 			"is_active":  false,
@@ -1077,7 +1087,7 @@ func (ps *PlanService) DeletePaymentMethod(userID, methodID primitive.ObjectID)
 
 	// If this was the default, set another payment method as default
 	if isDefault {
-		_, err = database.GetCollection("payment_methods").UpdateOne(ctx,
+		_, err = ps.paymentMethodCollection.UpdateOne(ctx,
 			bson.M{
 				"user_id":   userID,
 				"is_active": true,
@@ -1097,8 +1107,8 @@ func (ps *PlanService) DeletePaymentMethod(userID, methodID primitive.ObjectID)
 }
 
 // GetUserLimits returns user's current plan limits and usage
-func (ps *PlanService) GetUserLimits(userID primitive.ObjectID) (map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (ps *PlanService) GetUserLimits(ctx context.Context, userID primitive.ObjectID) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	// Get user
@@ -1109,7 +1119,7 @@ func (ps *PlanService) GetUserLimits(userID primitive.ObjectID) (map[string]inte
 	}
 
 	// Get plan
-	plan, err := ps.GetUserPlan(userID)
+	plan, err := ps.GetUserPlan(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -1164,8 +1174,8 @@ func (ps *PlanService) GetUserLimits(userID primitive.ObjectID) (map[string]inte
 }
 
 // HandleStripeWebhook processes Stripe webhook events
-func (ps *PlanService) HandleStripeWebhook(payload []byte, signature string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+func (ps *PlanService) HandleStripeWebhook(ctx context.Context, payload []byte, signature string) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
 	// Parse the webhook event
@@ -1190,6 +1200,17 @@ func (ps *PlanService) HandleStripeWebhook(payload []byte, signature string) err
 		return ps.handlePaymentMethodAttached(ctx, event)
 	case "payment_method.detached":
 		return ps.handlePaymentMethodDetached(ctx, event)
+	case "charge.dispute.created", "charge.dispute.updated", "charge.dispute.closed", "charge.refund.updated":
+		// Chargebacks pause accounts and refunds settle invoices, so they
+		// are only taken from webhooks known to come from Stripe
+		if stripeWebhookSecret == "" {
+			ps.logWebhookEvent(event, "unverified")
+			return nil
+		}
+		if event["type"] == "charge.refund.updated" {
+			return NewBillingService().HandleStripeRefund(ctx, payload)
+		}
+		return NewBillingService().HandleStripeDispute(ctx, payload)
 	default:
 		// Log unhandled event type
 		ps.logWebhookEvent(event, "unhandled")
@@ -1264,8 +1285,12 @@ func (ps *PlanService) getRecommendations(plans []models.Plan) map[string]interf
 
 // Stripe webhook helper functions
 func (ps *PlanService) parseStripeEvent(payload []byte, signature string) (map[string]interface{}, error) {
-	// In real implementation, verify signature with Stripe webhook secret
-	// For now, just parse the JSON payload
+	if stripeWebhookSecret != "" {
+		if err := verifyStripeSignature(payload, signature, stripeWebhookSecret, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+
 	var event map[string]interface{}
 	if err := json.Unmarshal(payload, &event); err != nil {
 		return nil, err
@@ -1401,7 +1426,7 @@ func (ps *PlanService) handlePaymentMethodAttached(ctx context.Context, event ma
 	paymentMethodID := object["id"].(string)
 	customerID := object["customer"].(string)
 
-	_, err := database.GetCollection("payment_methods").UpdateOne(ctx,
+	_, err := ps.paymentMethodCollection.UpdateOne(ctx,
 		bson.M{"stripe_payment_method_id": paymentMethodID},
 		bson.M{"$set": bson.M{
 			"stripe_customer_id": customerID,
@@ -1420,7 +1445,7 @@ func (ps *PlanService) handlePaymentMethodDetached(ctx context.Context, event ma
 
 	paymentMethodID := object["id"].(string)
 
-	_, err := database.GetCollection("payment_methods").UpdateOne(ctx,
+	_, err := ps.paymentMethodCollection.UpdateOne(ctx,
 		bson.M{"stripe_payment_method_id": paymentMethodID},
 		bson.M{"$set": bson.M{
 			"is_active":  false,
@@ -1437,16 +1462,20 @@ func (ps *PlanService) logWebhookEvent(event map[string]interface{}, status stri
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
+		now := time.Now()
 		log := bson.M{
 			"_id":        primitive.NewObjectID(),
 			"event_type": event["type"].(string),
 			"event_id":   event["id"].(string),
 			"status":     status,
 			"data":       event,
-			"created_at": time.Now(),
+			"created_at": now,
+		}
+		if expiresAt := webhookLogExpiry(now); expiresAt != nil {
+			log["expires_at"] = expiresAt
 		}
 
-		database.GetCollection("webhook_logs").InsertOne(ctx, log)
+		ps.webhookLogCollection.InsertOne(ctx, log)
 	}()
 }
 
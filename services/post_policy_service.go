@@ -0,0 +1,242 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"oncloud/models"
+	"oncloud/storage"
+	"oncloud/utils"
+	"path"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// postPolicyFileName is the variable S3 replaces with the name of the
+	// file a form uploads
+	postPolicyFileName = "${filename}"
+	// defaultPostPolicyExpiry is how long a form can be posted for
+	defaultPostPolicyExpiry = time.Hour
+	// postPolicyCompletionGrace is how long after its form expires an
+	// upload can still be reported complete
+	postPolicyCompletionGrace = time.Hour
+)
+
+var (
+	ErrPostPolicyUnsupported    = errors.New("the storage provider does not accept form uploads")
+	ErrPostPolicyLimitReached   = quotaExceededError("the plan has no room for another upload")
+	ErrPostPolicyUploadNotFound = notFoundError("upload not found")
+	ErrPostPolicyKeyMismatch    = errors.New("key is not one the upload's policy allows")
+	ErrPostPolicyObjectMissing  = errors.New("nothing was uploaded under the key")
+	ErrPostPolicyTooLarge       = errors.New("uploaded file exceeds the policy's size limit")
+)
+
+type PostPolicyService struct {
+	*BaseService
+	fileService    *FileService
+	storageService ObjectStore
+}
+
+func NewPostPolicyService() *PostPolicyService {
+	return NewPostPolicyServiceWith(Dependencies{})
+}
+
+// NewPostPolicyServiceWith creates a post policy service on the given
+// dependencies
+func NewPostPolicyServiceWith(deps Dependencies) *PostPolicyService {
+	return &PostPolicyService{
+		BaseService:    NewBaseServiceWith(deps),
+		fileService:    NewFileServiceWith(deps),
+		storageService: deps.storage(),
+	}
+}
+
+// CreatePolicy signs a POST policy letting a browser form upload one file to
+// the default provider's bucket. The policy caps the file's size at what the
+// user's plan has room for, and its type at req.ContentType when given.
+func (ps *PostPolicyService) CreatePolicy(ctx context.Context, user *models.User, req *models.PostPolicyUploadRequest) (*models.PostPolicyForm, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	plan, err := ps.fileService.GetUserPlan(ctx, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user plan: %v", err)
+	}
+	if err := ps.fileService.CheckUploadLimits(user, plan, 1); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPostPolicyLimitReached, err)
+	}
+	maxSize := plan.MaxFileSize
+	if remaining := plan.StorageLimit - user.StorageUsed; remaining < maxSize {
+		maxSize = remaining
+	}
+	if maxUploadSize := RuntimeSettingInt64(RuntimeSettingMaxUploadSize); maxUploadSize > 0 && maxUploadSize < maxSize {
+		maxSize = maxUploadSize
+	}
+	if req.MaxSize > 0 && req.MaxSize < maxSize {
+		maxSize = req.MaxSize
+	}
+
+	provider, err := ps.fileService.getDefaultStorageProvider(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get storage provider: %v", err)
+	}
+	client, err := storage.NewStorageClient(provider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage client: %v", err)
+	}
+	signer, ok := client.(storage.PostPolicySigner)
+	if !ok {
+		return nil, ErrPostPolicyUnsupported
+	}
+
+	// The file gets the upload's ID, so the key made for it now is the one
+	// its template would give it
+	upload := &models.PostPolicyUpload{
+		ID:           primitive.NewObjectID(),
+		UserID:       user.ID,
+		ProviderType: provider.Type,
+		Bucket:       provider.Bucket,
+		FolderID:     req.FolderID,
+		ContentType:  req.ContentType,
+		MaxSize:      maxSize,
+		Status:       models.PostPolicyUploadPending,
+	}
+	key, err := utils.RenderStorageKey(provider.KeyTemplate, utils.StorageKeyVars{
+		UserID: user.ID.Hex(),
+		FileID: upload.ID.Hex(),
+		Name:   postPolicyFileName,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to build storage key: %v", err)
+	}
+	conditions := storage.PostPolicyConditions{
+		MinSize:           1,
+		MaxSize:           maxSize,
+		ContentType:       req.ContentType,
+		SuccessRedirect:   req.SuccessRedirect,
+		SuccessStatusCode: 201,
+		Expiry:            defaultPostPolicyExpiry,
+	}
+	if req.ExpiresIn > 0 {
+		conditions.Expiry = time.Duration(req.ExpiresIn) * time.Second
+	}
+	if index := strings.Index(key, postPolicyFileName); index >= 0 {
+		upload.KeyPrefix = key[:index]
+		conditions.KeyPrefix = upload.KeyPrefix
+	} else {
+		upload.Key = key
+		conditions.Key = key
+	}
+
+	policy, err := signer.SignPostPolicy(conditions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign upload policy: %v", err)
+	}
+
+	now := time.Now()
+	upload.ExpiresAt = policy.ExpiresAt.Add(postPolicyCompletionGrace)
+	upload.CreatedAt = now
+	upload.UpdatedAt = now
+	if _, err := ps.collections.PostPolicyUploads().InsertOne(ctx, upload); err != nil {
+		return nil, fmt.Errorf("failed to save upload: %v", err)
+	}
+
+	return &models.PostPolicyForm{
+		UploadID:  upload.ID.Hex(),
+		URL:       policy.URL,
+		Fields:    policy.Fields,
+		MaxSize:   maxSize,
+		ExpiresAt: policy.ExpiresAt,
+	}, nil
+}
+
+// CompleteUpload creates the file a form uploaded under key. The content is
+// read back to be checked like any upload's, but stays where the browser put
+// it. Content too big or of a blocked type is deleted from the bucket; after
+// other failures, completing the upload can be retried.
+func (ps *PostPolicyService) CompleteUpload(ctx context.Context, userID, uploadID primitive.ObjectID, req *models.PostPolicyCompleteRequest) (*models.File, error) {
+	upload, err := ps.getUpload(ctx, userID, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if upload.Status == models.PostPolicyUploadCompleted && upload.FileID != nil {
+		return ps.fileService.GetUserFile(ctx, userID, *upload.FileID)
+	}
+
+	switch {
+	case upload.Key != "" && req.Key != upload.Key:
+		return nil, ErrPostPolicyKeyMismatch
+	case upload.Key == "" && (!strings.HasPrefix(req.Key, upload.KeyPrefix) || len(req.Key) == len(upload.KeyPrefix)):
+		return nil, ErrPostPolicyKeyMismatch
+	}
+
+	content, err := ps.storageService.DownloadFile(ctx, upload.ProviderType, req.Key)
+	if err != nil {
+		if isMissingObjectError(err) {
+			return nil, ErrPostPolicyObjectMissing
+		}
+		return nil, fmt.Errorf("failed to read uploaded file: %v", err)
+	}
+
+	name := req.Name
+	if name == "" {
+		name = path.Base(req.Key)
+	}
+	var file *models.File
+	if int64(len(content)) > upload.MaxSize {
+		err = ErrPostPolicyTooLarge
+	} else {
+		file, err = ps.fileService.UploadContent(ctx, userID, name, content, &models.FileUploadRequest{
+			FolderID:    upload.FolderID,
+			Description: req.Description,
+			Source:      models.UploadSourceUpload,
+			Stored: &models.StoredObject{
+				FileID:       upload.ID,
+				ProviderType: upload.ProviderType,
+				Bucket:       upload.Bucket,
+				Key:          req.Key,
+			},
+		})
+	}
+	if err != nil {
+		var mismatchErr *FileTypeMismatchError
+		var blockedErr *FileTypeBlockedError
+		if errors.Is(err, ErrPostPolicyTooLarge) || errors.As(err, &mismatchErr) || errors.As(err, &blockedErr) {
+			if deleteErr := ps.storageService.DeleteFile(ctx, upload.ProviderType, req.Key); deleteErr != nil {
+				log.Printf("Failed to delete rejected form upload %s: %v", req.Key, deleteErr)
+			}
+		}
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	_, err = ps.collections.PostPolicyUploads().UpdateOne(ctx, bson.M{"_id": upload.ID}, bson.M{
+		"$set": bson.M{"status": models.PostPolicyUploadCompleted, "file_id": file.ID, "updated_at": time.Now()},
+	})
+	if err != nil {
+		log.Printf("Failed to mark form upload %s completed: %v", upload.ID.Hex(), err)
+	}
+	return file, nil
+}
+
+func (ps *PostPolicyService) getUpload(ctx context.Context, userID, uploadID primitive.ObjectID) (*models.PostPolicyUpload, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var upload models.PostPolicyUpload
+	err := ps.collections.PostPolicyUploads().FindOne(ctx, bson.M{"_id": uploadID, "user_id": userID}).Decode(&upload)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrPostPolicyUploadNotFound
+		}
+		return nil, fmt.Errorf("failed to get upload: %v", err)
+	}
+	return &upload, nil
+}
@@ -0,0 +1,194 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"oncloud/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// pricingUpdatableFields are the price list fields admins may change
+var pricingUpdatableFields = map[string]bool{
+	"region":               true,
+	"storage_per_gb_month": true,
+	"egress_per_gb":        true,
+	"free_egress_gb":       true,
+	"class_a_per_1000":     true,
+	"class_b_per_1000":     true,
+	"notes":                true,
+}
+
+type PricingService struct {
+	*BaseService
+}
+
+func NewPricingService() *PricingService {
+	return NewPricingServiceWith(Dependencies{})
+}
+
+// NewPricingServiceWith creates a pricing service on the given dependencies
+func NewPricingServiceWith(deps Dependencies) *PricingService {
+	return &PricingService{
+		BaseService: NewBaseServiceWith(deps),
+	}
+}
+
+// GetStoragePricing returns all price lists, optionally for one provider type
+func (ps *PricingService) GetStoragePricing(ctx context.Context, providerType string) ([]models.StoragePricing, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{}
+	if providerType != "" {
+		filter["provider_type"] = providerType
+	}
+
+	cursor, err := ps.collections.StoragePricing().Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "provider_type", Value: 1}, {Key: "region", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var pricing []models.StoragePricing
+	if err = cursor.All(ctx, &pricing); err != nil {
+		return nil, err
+	}
+
+	return pricing, nil
+}
+
+// GetStoragePricingByID returns one price list
+func (ps *PricingService) GetStoragePricingByID(ctx context.Context, pricingID primitive.ObjectID) (*models.StoragePricing, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var pricing models.StoragePricing
+	err := ps.collections.StoragePricing().FindOne(ctx, bson.M{"_id": pricingID}).Decode(&pricing)
+	if err != nil {
+		return nil, errors.New("storage pricing not found")
+	}
+
+	return &pricing, nil
+}
+
+// CreateStoragePricing adds a price list for a provider type and region
+func (ps *PricingService) CreateStoragePricing(ctx context.Context, pricing *models.StoragePricing) (*models.StoragePricing, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	pricing.ID = primitive.NewObjectID()
+	pricing.CreatedAt = time.Now()
+	pricing.UpdatedAt = time.Now()
+
+	if _, err := ps.collections.StoragePricing().InsertOne(ctx, pricing); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, fmt.Errorf("pricing for %s in region %q already exists", pricing.ProviderType, pricing.Region)
+		}
+		return nil, fmt.Errorf("failed to create storage pricing: %v", err)
+	}
+
+	return pricing, nil
+}
+
+// UpdateStoragePricing updates price fields of a price list
+func (ps *PricingService) UpdateStoragePricing(ctx context.Context, pricingID primitive.ObjectID, updates map[string]interface{}) (*models.StoragePricing, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	set := bson.M{}
+	for field, value := range updates {
+		if !pricingUpdatableFields[field] {
+			continue
+		}
+		if price, ok := value.(float64); ok && price < 0 {
+			return nil, fmt.Errorf("%s cannot be negative", field)
+		}
+		set[field] = value
+	}
+	if len(set) == 0 {
+		return nil, errors.New("no valid fields to update")
+	}
+	set["updated_at"] = time.Now()
+
+	result, err := ps.collections.StoragePricing().UpdateOne(ctx,
+		bson.M{"_id": pricingID},
+		bson.M{"$set": set},
+	)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, errors.New("pricing for this provider and region already exists")
+		}
+		return nil, fmt.Errorf("failed to update storage pricing: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return nil, errors.New("storage pricing not found")
+	}
+
+	return ps.GetStoragePricingByID(ctx, pricingID)
+}
+
+// DeleteStoragePricing removes a price list
+func (ps *PricingService) DeleteStoragePricing(ctx context.Context, pricingID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := ps.collections.StoragePricing().DeleteOne(ctx, bson.M{"_id": pricingID})
+	if err != nil {
+		return fmt.Errorf("failed to delete storage pricing: %v", err)
+	}
+	if result.DeletedCount == 0 {
+		return errors.New("storage pricing not found")
+	}
+
+	return nil
+}
+
+// pricingTable indexes price lists by provider type and region for lookups
+type pricingTable map[string]map[string]*models.StoragePricing
+
+// loadPricingTable loads all price lists into memory
+func (ps *PricingService) loadPricingTable(ctx context.Context) pricingTable {
+	table := make(pricingTable)
+
+	cursor, err := ps.collections.StoragePricing().Find(ctx, bson.M{})
+	if err != nil {
+		return table
+	}
+	defer cursor.Close(ctx)
+
+	var pricing []models.StoragePricing
+	if err = cursor.All(ctx, &pricing); err != nil {
+		return table
+	}
+
+	for i := range pricing {
+		p := &pricing[i]
+		if table[p.ProviderType] == nil {
+			table[p.ProviderType] = make(map[string]*models.StoragePricing)
+		}
+		table[p.ProviderType][p.Region] = p
+	}
+
+	return table
+}
+
+// resolve returns the region-specific price list, falling back to the
+// provider-wide one
+func (t pricingTable) resolve(providerType, region string) *models.StoragePricing {
+	regions := t[providerType]
+	if regions == nil {
+		return nil
+	}
+	if p, ok := regions[region]; ok {
+		return p
+	}
+	return regions[""]
+}
@@ -0,0 +1,343 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"oncloud/models"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Circuit breaker states
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+)
+
+// maxRetryBackoff caps the delay between two attempts of a provider call
+const maxRetryBackoff = 10 * time.Second
+
+var (
+	ErrProviderUnavailable = errors.New("storage provider is unavailable")
+	ErrProviderTimeout     = errors.New("storage provider call timed out")
+)
+
+// ProviderResilienceOptions are the retry, timeout and circuit breaker
+// policy of providers that don't override it
+type ProviderResilienceOptions struct {
+	MaxRetries       int
+	RetryBackoff     time.Duration
+	Timeout          time.Duration
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+}
+
+var providerResilienceOptions = ProviderResilienceOptions{
+	MaxRetries:       2,
+	RetryBackoff:     200 * time.Millisecond,
+	Timeout:          2 * time.Minute,
+	BreakerThreshold: 5,
+	BreakerCooldown:  30 * time.Second,
+}
+
+// InitProviderResilience sets the default policy of provider calls
+func InitProviderResilience(opts ProviderResilienceOptions) {
+	providerResilienceOptions = opts
+}
+
+// resiliencePolicy resolves a provider's policy against the defaults
+func resiliencePolicy(provider *models.StorageProvider) ProviderResilienceOptions {
+	policy := providerResilienceOptions
+	overrides := provider.Resilience
+	if overrides == nil {
+		return policy
+	}
+	if overrides.MaxRetries != nil {
+		policy.MaxRetries = *overrides.MaxRetries
+	}
+	if overrides.RetryBackoffMs > 0 {
+		policy.RetryBackoff = time.Duration(overrides.RetryBackoffMs) * time.Millisecond
+	}
+	if overrides.TimeoutSeconds > 0 {
+		policy.Timeout = time.Duration(overrides.TimeoutSeconds) * time.Second
+	}
+	if overrides.BreakerThreshold > 0 {
+		policy.BreakerThreshold = overrides.BreakerThreshold
+	}
+	if overrides.BreakerCooldownSeconds > 0 {
+		policy.BreakerCooldown = time.Duration(overrides.BreakerCooldownSeconds) * time.Second
+	}
+	return policy
+}
+
+// circuitBreaker stops calling a provider after consecutive failures, so
+// a flaky provider fails fast instead of tying up every request using it
+type circuitBreaker struct {
+	mutex               sync.Mutex
+	state               string
+	consecutiveFailures int
+	probing             bool
+	lastError           string
+	lastFailureAt       time.Time
+	openedAt            time.Time
+	cooldown            time.Duration
+	opens               int64
+	rejected            int64
+	failovers           int64
+}
+
+// providerBreakers holds a breaker per provider type, shared by every
+// service instance
+var providerBreakers = struct {
+	sync.Mutex
+	breakers map[string]*circuitBreaker
+}{breakers: make(map[string]*circuitBreaker)}
+
+func breakerFor(providerType string) *circuitBreaker {
+	providerType = strings.ToLower(providerType)
+
+	providerBreakers.Lock()
+	defer providerBreakers.Unlock()
+	breaker, exists := providerBreakers.breakers[providerType]
+	if !exists {
+		breaker = &circuitBreaker{state: BreakerClosed}
+		providerBreakers.breakers[providerType] = breaker
+	}
+	return breaker
+}
+
+// allow reports whether a call may go to the provider. Once the cooldown
+// of an open breaker passes, one call is let through to probe it.
+func (b *circuitBreaker) allow(cooldown time.Duration) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < cooldown {
+			b.rejected++
+			return false
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+		return true
+	case BreakerHalfOpen:
+		if b.probing {
+			b.rejected++
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// record records the outcome of a call let through
+func (b *circuitBreaker) record(providerType string, err error, policy ProviderResilienceOptions) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.probing = false
+	if err == nil {
+		if b.state != BreakerClosed {
+			log.Printf("Storage provider %s recovered, closing its circuit breaker", providerType)
+		}
+		b.state = BreakerClosed
+		b.consecutiveFailures = 0
+		return
+	}
+
+	b.consecutiveFailures++
+	b.lastError = err.Error()
+	b.lastFailureAt = time.Now()
+	if b.state == BreakerHalfOpen || (b.state == BreakerClosed && b.consecutiveFailures >= policy.BreakerThreshold) {
+		if b.state == BreakerClosed {
+			log.Printf("Storage provider %s failed %d times in a row, opening its circuit breaker: %v", providerType, b.consecutiveFailures, err)
+		}
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+		b.cooldown = policy.BreakerCooldown
+		b.opens++
+	}
+}
+
+// rejecting reports whether the breaker is open and would fail a call now
+func (b *circuitBreaker) rejecting() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.state == BreakerOpen && time.Since(b.openedAt) < b.cooldown
+}
+
+// release lets another call through after one let through was given up on
+// without an outcome
+func (b *circuitBreaker) release() {
+	b.mutex.Lock()
+	b.probing = false
+	b.mutex.Unlock()
+}
+
+func (b *circuitBreaker) recordFailover() {
+	b.mutex.Lock()
+	b.failovers++
+	b.mutex.Unlock()
+}
+
+func (b *circuitBreaker) snapshot(providerType string) *models.ProviderBreakerState {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	state := &models.ProviderBreakerState{
+		ProviderType:        providerType,
+		State:               b.state,
+		ConsecutiveFailures: b.consecutiveFailures,
+		LastError:           b.lastError,
+		Opens:               b.opens,
+		Rejected:            b.rejected,
+		Failovers:           b.failovers,
+	}
+	if !b.lastFailureAt.IsZero() {
+		lastFailureAt := b.lastFailureAt
+		state.LastFailureAt = &lastFailureAt
+	}
+	if b.state != BreakerClosed {
+		openedAt := b.openedAt
+		retryAt := b.openedAt.Add(b.cooldown)
+		state.OpenedAt = &openedAt
+		state.RetryAt = &retryAt
+	}
+	return state
+}
+
+// GetProviderBreakerStates returns the circuit breaker of every provider
+// called since the process started, by provider type
+func GetProviderBreakerStates() []*models.ProviderBreakerState {
+	providerBreakers.Lock()
+	types := make([]string, 0, len(providerBreakers.breakers))
+	breakers := make(map[string]*circuitBreaker, len(providerBreakers.breakers))
+	for providerType, breaker := range providerBreakers.breakers {
+		types = append(types, providerType)
+		breakers[providerType] = breaker
+	}
+	providerBreakers.Unlock()
+
+	sort.Strings(types)
+	states := make([]*models.ProviderBreakerState, 0, len(types))
+	for _, providerType := range types {
+		states = append(states, breakers[providerType].snapshot(providerType))
+	}
+	return states
+}
+
+// providerBreakerState returns the circuit breaker of a provider type
+func providerBreakerState(providerType string) *models.ProviderBreakerState {
+	return breakerFor(providerType).snapshot(strings.ToLower(providerType))
+}
+
+// callProvider runs a call to a provider under its policy: each attempt is
+// timed out, failures are retried with exponential backoff, and calls fail
+// fast with ErrProviderUnavailable while the provider's breaker is open.
+// Missing objects are an answer, not a failure, and are neither retried nor
+// counted against the provider. Neither is a call given up on because ctx
+// ended, which is returned right away.
+func callProvider(ctx context.Context, provider *models.StorageProvider, call func() error) error {
+	policy := resiliencePolicy(provider)
+	breaker := breakerFor(provider.Type)
+
+	var err error
+	for attempt := 0; attempt <= policy.MaxRetries; attempt++ {
+		if attempt > 0 {
+			if waitErr := sleepContext(ctx, retryBackoff(policy.RetryBackoff, attempt)); waitErr != nil {
+				return waitErr
+			}
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if !breaker.allow(policy.BreakerCooldown) {
+			if err == nil {
+				return ErrProviderUnavailable
+			}
+			return fmt.Errorf("%w: %v", ErrProviderUnavailable, err)
+		}
+
+		err = callWithTimeout(ctx, policy.Timeout, call)
+		if ctxErr := ctx.Err(); ctxErr != nil && err != nil {
+			breaker.release()
+			return ctxErr
+		}
+		if err == nil || isMissingObjectError(err) {
+			breaker.record(provider.Type, nil, policy)
+			return err
+		}
+		breaker.record(provider.Type, err, policy)
+	}
+	return err
+}
+
+// retryBackoff is the delay before an attempt: the base backoff doubled on
+// every retry, with jitter so instances don't retry in lockstep
+func retryBackoff(base time.Duration, attempt int) time.Duration {
+	backoff := base << (attempt - 1)
+	if backoff <= 0 || backoff > maxRetryBackoff {
+		backoff = maxRetryBackoff
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+// callWithTimeout runs a call, giving up on it after timeout or once ctx
+// ends. The provider clients don't take a context, so a call given up on
+// finishes in the background; retrying it is safe as uploads, deletes and
+// copies of the same key are idempotent.
+func callWithTimeout(ctx context.Context, timeout time.Duration, call func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- call()
+	}()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-expired:
+		return fmt.Errorf("%w after %s", ErrProviderTimeout, timeout)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// sleepContext waits for d, or until ctx ends
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// isMissingObjectError reports whether a provider answered that an object
+// does not exist. Provider errors are flattened into messages on their way
+// up, so they are matched by text.
+func isMissingObjectError(err error) bool {
+	message := err.Error()
+	for _, marker := range []string{"NoSuchKey", "NotFound", "NOT_FOUND", "no such file or directory", "status code: 404"} {
+		if strings.Contains(message, marker) {
+			return true
+		}
+	}
+	return false
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"oncloud/database"
 	"oncloud/models"
 	"oncloud/storage"
 	"strings"
@@ -23,15 +22,21 @@ type R2Service struct {
 }
 
 func NewR2Service() *R2Service {
+	return NewR2ServiceWith(Dependencies{})
+}
+
+// NewR2ServiceWith creates an R2 service on the given dependencies
+func NewR2ServiceWith(deps Dependencies) *R2Service {
+	db := deps.database()
 	return &R2Service{
-		providerCollection: database.GetCollection("storage_providers"),
-		fileCollection:     database.GetCollection("files"),
+		providerCollection: db.GetCollection("storage_providers"),
+		fileCollection:     db.GetCollection("files"),
 	}
 }
 
 // Initialize R2 client with provider configuration
-func (r2s *R2Service) InitializeClient(providerID primitive.ObjectID) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (r2s *R2Service) InitializeClient(ctx context.Context, providerID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	var provider models.StorageProvider
@@ -55,8 +60,8 @@ func (r2s *R2Service) InitializeClient(providerID primitive.ObjectID) error {
 }
 
 // Initialize with default R2 provider
-func (r2s *R2Service) InitializeDefaultClient() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (r2s *R2Service) InitializeDefaultClient(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	var provider models.StorageProvider
@@ -80,9 +85,9 @@ func (r2s *R2Service) InitializeDefaultClient() error {
 }
 
 // File Operations
-func (r2s *R2Service) UploadFile(key string, data []byte, options *storage.UploadOptions) error {
+func (r2s *R2Service) UploadFile(ctx context.Context, key string, data []byte, options *storage.UploadOptions) error {
 	if r2s.client == nil {
-		if err := r2s.InitializeDefaultClient(); err != nil {
+		if err := r2s.InitializeDefaultClient(ctx); err != nil {
 			return err
 		}
 	}
@@ -104,9 +109,9 @@ func (r2s *R2Service) UploadFile(key string, data []byte, options *storage.Uploa
 	return nil
 }
 
-func (r2s *R2Service) UploadStream(key string, reader io.Reader, size int64) error {
+func (r2s *R2Service) UploadStream(ctx context.Context, key string, reader io.Reader, size int64) error {
 	if r2s.client == nil {
-		if err := r2s.InitializeDefaultClient(); err != nil {
+		if err := r2s.InitializeDefaultClient(ctx); err != nil {
 			return err
 		}
 	}
@@ -122,9 +127,9 @@ func (r2s *R2Service) UploadStream(key string, reader io.Reader, size int64) err
 	return nil
 }
 
-func (r2s *R2Service) DownloadFile(key string) ([]byte, error) {
+func (r2s *R2Service) DownloadFile(ctx context.Context, key string) ([]byte, error) {
 	if r2s.client == nil {
-		if err := r2s.InitializeDefaultClient(); err != nil {
+		if err := r2s.InitializeDefaultClient(ctx); err != nil {
 			return nil, err
 		}
 	}
@@ -140,9 +145,9 @@ func (r2s *R2Service) DownloadFile(key string) ([]byte, error) {
 	return data, nil
 }
 
-func (r2s *R2Service) DownloadStream(key string) (io.ReadCloser, error) {
+func (r2s *R2Service) DownloadStream(ctx context.Context, key string) (io.ReadCloser, error) {
 	if r2s.client == nil {
-		if err := r2s.InitializeDefaultClient(); err != nil {
+		if err := r2s.InitializeDefaultClient(ctx); err != nil {
 			return nil, err
 		}
 	}
@@ -158,9 +163,9 @@ func (r2s *R2Service) DownloadStream(key string) (io.ReadCloser, error) {
 	return stream, nil
 }
 
-func (r2s *R2Service) DeleteFile(key string) error {
+func (r2s *R2Service) DeleteFile(ctx context.Context, key string) error {
 	if r2s.client == nil {
-		if err := r2s.InitializeDefaultClient(); err != nil {
+		if err := r2s.InitializeDefaultClient(ctx); err != nil {
 			return err
 		}
 	}
@@ -176,9 +181,9 @@ func (r2s *R2Service) DeleteFile(key string) error {
 	return nil
 }
 
-func (r2s *R2Service) DeleteMultipleFiles(keys []string) error {
+func (r2s *R2Service) DeleteMultipleFiles(ctx context.Context, keys []string) error {
 	if r2s.client == nil {
-		if err := r2s.InitializeDefaultClient(); err != nil {
+		if err := r2s.InitializeDefaultClient(ctx); err != nil {
 			return err
 		}
 	}
@@ -196,9 +201,9 @@ func (r2s *R2Service) DeleteMultipleFiles(keys []string) error {
 	return nil
 }
 
-func (r2s *R2Service) FileExists(key string) (bool, error) {
+func (r2s *R2Service) FileExists(ctx context.Context, key string) (bool, error) {
 	if r2s.client == nil {
-		if err := r2s.InitializeDefaultClient(); err != nil {
+		if err := r2s.InitializeDefaultClient(ctx); err != nil {
 			return false, err
 		}
 	}
@@ -211,9 +216,9 @@ func (r2s *R2Service) FileExists(key string) (bool, error) {
 	return exists, nil
 }
 
-func (r2s *R2Service) GetFileSize(key string) (int64, error) {
+func (r2s *R2Service) GetFileSize(ctx context.Context, key string) (int64, error) {
 	if r2s.client == nil {
-		if err := r2s.InitializeDefaultClient(); err != nil {
+		if err := r2s.InitializeDefaultClient(ctx); err != nil {
 			return 0, err
 		}
 	}
@@ -227,9 +232,9 @@ func (r2s *R2Service) GetFileSize(key string) (int64, error) {
 }
 
 // URL Operations
-func (r2s *R2Service) GetPublicURL(key string) (string, error) {
+func (r2s *R2Service) GetPublicURL(ctx context.Context, key string) (string, error) {
 	if r2s.client == nil {
-		if err := r2s.InitializeDefaultClient(); err != nil {
+		if err := r2s.InitializeDefaultClient(ctx); err != nil {
 			return "", err
 		}
 	}
@@ -242,9 +247,9 @@ func (r2s *R2Service) GetPublicURL(key string) (string, error) {
 	return url, nil
 }
 
-func (r2s *R2Service) GetPresignedDownloadURL(key string, expiry time.Duration) (string, error) {
+func (r2s *R2Service) GetPresignedDownloadURL(ctx context.Context, key string, expiry time.Duration) (string, error) {
 	if r2s.client == nil {
-		if err := r2s.InitializeDefaultClient(); err != nil {
+		if err := r2s.InitializeDefaultClient(ctx); err != nil {
 			return "", err
 		}
 	}
@@ -257,9 +262,9 @@ func (r2s *R2Service) GetPresignedDownloadURL(key string, expiry time.Duration)
 	return url, nil
 }
 
-func (r2s *R2Service) GetPresignedUploadURL(key string, expiry time.Duration, maxSize int64) (string, error) {
+func (r2s *R2Service) GetPresignedUploadURL(ctx context.Context, key string, expiry time.Duration, maxSize int64) (string, error) {
 	if r2s.client == nil {
-		if err := r2s.InitializeDefaultClient(); err != nil {
+		if err := r2s.InitializeDefaultClient(ctx); err != nil {
 			return "", err
 		}
 	}
@@ -273,9 +278,9 @@ func (r2s *R2Service) GetPresignedUploadURL(key string, expiry time.Duration, ma
 }
 
 // Multipart Upload Operations
-func (r2s *R2Service) InitiateMultipartUpload(key string) (*storage.MultipartUpload, error) {
+func (r2s *R2Service) InitiateMultipartUpload(ctx context.Context, key string) (*storage.MultipartUpload, error) {
 	if r2s.client == nil {
-		if err := r2s.InitializeDefaultClient(); err != nil {
+		if err := r2s.InitializeDefaultClient(ctx); err != nil {
 			return nil, err
 		}
 	}
@@ -293,9 +298,9 @@ func (r2s *R2Service) InitiateMultipartUpload(key string) (*storage.MultipartUpl
 	return upload, nil
 }
 
-func (r2s *R2Service) UploadPart(uploadID, key string, partNumber int, data []byte) (*storage.UploadPart, error) {
+func (r2s *R2Service) UploadPart(ctx context.Context, uploadID, key string, partNumber int, data []byte) (*storage.UploadPart, error) {
 	if r2s.client == nil {
-		if err := r2s.InitializeDefaultClient(); err != nil {
+		if err := r2s.InitializeDefaultClient(ctx); err != nil {
 			return nil, err
 		}
 	}
@@ -315,9 +320,9 @@ func (r2s *R2Service) UploadPart(uploadID, key string, partNumber int, data []by
 	return part, nil
 }
 
-func (r2s *R2Service) CompleteMultipartUpload(uploadID, key string, parts []storage.UploadPart) error {
+func (r2s *R2Service) CompleteMultipartUpload(ctx context.Context, uploadID, key string, parts []storage.UploadPart) error {
 	if r2s.client == nil {
-		if err := r2s.InitializeDefaultClient(); err != nil {
+		if err := r2s.InitializeDefaultClient(ctx); err != nil {
 			return err
 		}
 	}
@@ -342,9 +347,9 @@ func (r2s *R2Service) CompleteMultipartUpload(uploadID, key string, parts []stor
 	return nil
 }
 
-func (r2s *R2Service) AbortMultipartUpload(uploadID, key string) error {
+func (r2s *R2Service) AbortMultipartUpload(ctx context.Context, uploadID, key string) error {
 	if r2s.client == nil {
-		if err := r2s.InitializeDefaultClient(); err != nil {
+		if err := r2s.InitializeDefaultClient(ctx); err != nil {
 			return err
 		}
 	}
@@ -363,9 +368,9 @@ func (r2s *R2Service) AbortMultipartUpload(uploadID, key string) error {
 }
 
 // File Management Operations
-func (r2s *R2Service) CopyFile(sourceKey, destKey string) error {
+func (r2s *R2Service) CopyFile(ctx context.Context, sourceKey, destKey string) error {
 	if r2s.client == nil {
-		if err := r2s.InitializeDefaultClient(); err != nil {
+		if err := r2s.InitializeDefaultClient(ctx); err != nil {
 			return err
 		}
 	}
@@ -383,9 +388,9 @@ func (r2s *R2Service) CopyFile(sourceKey, destKey string) error {
 	return nil
 }
 
-func (r2s *R2Service) MoveFile(sourceKey, destKey string) error {
+func (r2s *R2Service) MoveFile(ctx context.Context, sourceKey, destKey string) error {
 	if r2s.client == nil {
-		if err := r2s.InitializeDefaultClient(); err != nil {
+		if err := r2s.InitializeDefaultClient(ctx); err != nil {
 			return err
 		}
 	}
@@ -404,9 +409,9 @@ func (r2s *R2Service) MoveFile(sourceKey, destKey string) error {
 }
 
 // Provider Management
-func (r2s *R2Service) GetProviderInfo() (*storage.ProviderInfo, error) {
+func (r2s *R2Service) GetProviderInfo(ctx context.Context) (*storage.ProviderInfo, error) {
 	if r2s.client == nil {
-		if err := r2s.InitializeDefaultClient(); err != nil {
+		if err := r2s.InitializeDefaultClient(ctx); err != nil {
 			return nil, err
 		}
 	}
@@ -414,9 +419,9 @@ func (r2s *R2Service) GetProviderInfo() (*storage.ProviderInfo, error) {
 	return r2s.client.GetProviderInfo(), nil
 }
 
-func (r2s *R2Service) HealthCheck() error {
+func (r2s *R2Service) HealthCheck(ctx context.Context) error {
 	if r2s.client == nil {
-		if err := r2s.InitializeDefaultClient(); err != nil {
+		if err := r2s.InitializeDefaultClient(ctx); err != nil {
 			return err
 		}
 	}
@@ -429,9 +434,9 @@ func (r2s *R2Service) HealthCheck() error {
 	return nil
 }
 
-func (r2s *R2Service) GetStorageStats() (*storage.StorageStats, error) {
+func (r2s *R2Service) GetStorageStats(ctx context.Context) (*storage.StorageStats, error) {
 	if r2s.client == nil {
-		if err := r2s.InitializeDefaultClient(); err != nil {
+		if err := r2s.InitializeDefaultClient(ctx); err != nil {
 			return nil, err
 		}
 	}
@@ -440,8 +445,8 @@ func (r2s *R2Service) GetStorageStats() (*storage.StorageStats, error) {
 }
 
 // Provider Configuration
-func (r2s *R2Service) CreateProvider(provider *models.StorageProvider) (*models.StorageProvider, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (r2s *R2Service) CreateProvider(ctx context.Context, provider *models.StorageProvider) (*models.StorageProvider, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	// Validate R2 provider configuration
@@ -472,8 +477,8 @@ func (r2s *R2Service) CreateProvider(provider *models.StorageProvider) (*models.
 	return provider, nil
 }
 
-func (r2s *R2Service) UpdateProvider(providerID primitive.ObjectID, updates map[string]interface{}) (*models.StorageProvider, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (r2s *R2Service) UpdateProvider(ctx context.Context, providerID primitive.ObjectID, updates map[string]interface{}) (*models.StorageProvider, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	updates["updated_at"] = time.Now()
@@ -495,8 +500,8 @@ func (r2s *R2Service) UpdateProvider(providerID primitive.ObjectID, updates map[
 	return &provider, nil
 }
 
-func (r2s *R2Service) DeleteProvider(providerID primitive.ObjectID) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (r2s *R2Service) DeleteProvider(ctx context.Context, providerID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	// Check if provider is in use
@@ -522,8 +527,8 @@ func (r2s *R2Service) DeleteProvider(providerID primitive.ObjectID) error {
 	return nil
 }
 
-func (r2s *R2Service) TestConnection(providerID primitive.ObjectID) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (r2s *R2Service) TestConnection(ctx context.Context, providerID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	var provider models.StorageProvider
@@ -605,7 +610,7 @@ func (r2s *R2Service) logActivity(action, key string, size int64, metadata map[s
 			activity["provider_id"] = r2s.provider.ID
 		}
 
-		collection := database.GetCollection("storage_activities")
+		collection := r2s.providerCollection.Database().Collection("storage_activities")
 		collection.InsertOne(ctx, activity)
 	}()
 }
@@ -0,0 +1,50 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ReportAggregationOptions bounds the aggregations behind admin reports,
+// which scan whole collections
+type ReportAggregationOptions struct {
+	// MaxTime stops an aggregation on the server once it has run this long.
+	// Cancelling the request's context alone leaves it running there.
+	MaxTime time.Duration
+	// MaxResults caps the documents an aggregation returns
+	MaxResults int
+}
+
+var reportAggregationOptions = ReportAggregationOptions{
+	MaxTime:    30 * time.Second,
+	MaxResults: 10000,
+}
+
+// InitReportAggregations sets the limits admin report aggregations run with
+func InitReportAggregations(opts ReportAggregationOptions) {
+	if opts.MaxTime <= 0 {
+		opts.MaxTime = 30 * time.Second
+	}
+	if opts.MaxResults <= 0 {
+		opts.MaxResults = 10000
+	}
+	reportAggregationOptions = opts
+}
+
+// reportAggregate runs an admin report pipeline allowed to spill large
+// sorts and groups to disk rather than fail on the memory limit, stopped
+// after the configured time and capped at the configured number of results
+func reportAggregate(ctx context.Context, collection *mongo.Collection, pipeline []bson.M) (*mongo.Cursor, error) {
+	guarded := make([]bson.M, len(pipeline), len(pipeline)+1)
+	copy(guarded, pipeline)
+	guarded = append(guarded, bson.M{"$limit": reportAggregationOptions.MaxResults})
+
+	opts := options.Aggregate().
+		SetAllowDiskUse(true).
+		SetMaxTime(reportAggregationOptions.MaxTime)
+	return collection.Aggregate(ctx, guarded, opts)
+}
@@ -0,0 +1,192 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RetentionOptions configures how long analytics events, API request logs
+// and webhook logs are kept. A retention of 0 keeps them forever.
+type RetentionOptions struct {
+	// Analytics applies to analytics events of the types not listed in
+	// AnalyticsByType
+	Analytics       time.Duration
+	AnalyticsByType map[string]time.Duration
+	APIMetrics      time.Duration
+	WebhookLogs     time.Duration
+}
+
+var retentionOptions = RetentionOptions{}
+
+// InitRetention configures how long analytics events and logs are kept
+func InitRetention(opts RetentionOptions) {
+	retentionOptions = opts
+}
+
+// retentionPolicy is how long the documents of a collection matching filter
+// are kept after their timeField. They expire through a TTL index on
+// expires_at; time-series collections were not used as existing collections
+// cannot be converted to them and they expire everything after one
+// collection-wide period.
+type retentionPolicy struct {
+	key       string
+	filter    bson.M
+	timeField string
+	retention time.Duration
+}
+
+// RetentionService keeps the expires_at of analytics events and logs in line
+// with the configured retention
+type RetentionService struct {
+	*BaseService
+}
+
+func NewRetentionService() *RetentionService {
+	return NewRetentionServiceWith(Dependencies{})
+}
+
+// NewRetentionServiceWith creates a retention service on the given
+// dependencies
+func NewRetentionServiceWith(deps Dependencies) *RetentionService {
+	return &RetentionService{
+		BaseService: NewBaseServiceWith(deps),
+	}
+}
+
+// analyticsExpiry returns when an analytics event of a type recorded at
+// recordedAt expires, or nil when it is kept forever
+func analyticsExpiry(eventType string, recordedAt time.Time) interface{} {
+	retention, ok := retentionOptions.AnalyticsByType[eventType]
+	if !ok {
+		retention = retentionOptions.Analytics
+	}
+	return retentionExpiry(retention, recordedAt)
+}
+
+// webhookLogExpiry returns when a webhook log written at createdAt expires,
+// or nil when it is kept forever
+func webhookLogExpiry(createdAt time.Time) interface{} {
+	return retentionExpiry(retentionOptions.WebhookLogs, createdAt)
+}
+
+func retentionExpiry(retention time.Duration, at time.Time) interface{} {
+	if retention <= 0 {
+		return nil
+	}
+	return at.Add(retention)
+}
+
+// ApplyRetention sets expires_at on the documents written without one. When
+// the retention of a collection changed since it was last applied, the
+// expires_at of all of its documents is recomputed.
+func (rs *RetentionService) ApplyRetention(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Minute)
+	defer cancel()
+
+	collections := []struct {
+		collection *mongo.Collection
+		policies   []retentionPolicy
+	}{
+		{rs.collections.Analytics(), rs.analyticsPolicies()},
+		{rs.collections.Logs(), []retentionPolicy{
+			{key: "api_request", filter: bson.M{"type": "api_request"}, timeField: "created_at", retention: retentionOptions.APIMetrics},
+		}},
+		{rs.collections.WebhookLogs(), []retentionPolicy{
+			{key: "*", filter: bson.M{}, timeField: "created_at", retention: retentionOptions.WebhookLogs},
+		}},
+	}
+
+	for _, c := range collections {
+		if err := rs.applyPolicies(ctx, c.collection, c.policies); err != nil {
+			return fmt.Errorf("failed to apply retention to %s: %v", c.collection.Name(), err)
+		}
+	}
+	return nil
+}
+
+// analyticsPolicies returns a policy per event type with its own retention
+// and one for all other types
+func (rs *RetentionService) analyticsPolicies() []retentionPolicy {
+	types := make([]string, 0, len(retentionOptions.AnalyticsByType))
+	for eventType := range retentionOptions.AnalyticsByType {
+		types = append(types, eventType)
+	}
+	sort.Strings(types)
+
+	policies := make([]retentionPolicy, 0, len(types)+1)
+	for _, eventType := range types {
+		policies = append(policies, retentionPolicy{
+			key:       eventType,
+			filter:    bson.M{"type": eventType},
+			timeField: "timestamp",
+			retention: retentionOptions.AnalyticsByType[eventType],
+		})
+	}
+	return append(policies, retentionPolicy{
+		key:       "*",
+		filter:    bson.M{"type": bson.M{"$nin": types}},
+		timeField: "timestamp",
+		retention: retentionOptions.Analytics,
+	})
+}
+
+func (rs *RetentionService) applyPolicies(ctx context.Context, collection *mongo.Collection, policies []retentionPolicy) error {
+	retentions := make(map[string]int64, len(policies))
+	for _, policy := range policies {
+		retentions[policy.key] = int64(policy.retention / time.Second)
+	}
+
+	var applied struct {
+		Retentions map[string]int64 `bson:"retentions"`
+	}
+	err := rs.collections.RetentionPolicies().FindOne(ctx, bson.M{"_id": collection.Name()}).Decode(&applied)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+	changed := !reflect.DeepEqual(applied.Retentions, retentions)
+
+	for _, policy := range policies {
+		filter := bson.M{}
+		for k, v := range policy.filter {
+			filter[k] = v
+		}
+		if !changed {
+			filter["expires_at"] = bson.M{"$exists": false}
+			if policy.retention <= 0 {
+				continue
+			}
+		}
+
+		update := bson.A{bson.M{"$unset": "expires_at"}}
+		if policy.retention > 0 {
+			update = bson.A{bson.M{"$set": bson.M{
+				"expires_at": bson.M{"$add": bson.A{"$" + policy.timeField, policy.retention.Milliseconds()}},
+			}}}
+		}
+		result, err := collection.UpdateMany(ctx, filter, update)
+		if err != nil {
+			return err
+		}
+		if changed && result.ModifiedCount > 0 {
+			log.Printf("Applied %s retention of %s to %d documents in %s", policy.key, policy.retention, result.ModifiedCount, collection.Name())
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+	_, err = rs.collections.RetentionPolicies().UpdateOne(ctx,
+		bson.M{"_id": collection.Name()},
+		bson.M{"$set": bson.M{"retentions": retentions, "applied_at": time.Now()}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
@@ -0,0 +1,398 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"oncloud/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	RollupHourly = "hourly"
+	RollupDaily  = "daily"
+
+	dashboardSnapshotKey = "admin_dashboard"
+
+	// Rollups older than this are reported as stale to the dashboard
+	dashboardStaleAfter = 30 * time.Minute
+)
+
+// ErrNoDashboardRollup is returned when the dashboard has not been rolled up yet
+var ErrNoDashboardRollup = errors.New("dashboard has not been rolled up yet")
+
+// RollupService materializes hourly/daily statistics into summary collections
+// so dashboards can be served without running heavy aggregations per request
+type RollupService struct {
+	*BaseService
+	analyticsService *AnalyticsService
+}
+
+func NewRollupService() *RollupService {
+	return NewRollupServiceWith(Dependencies{})
+}
+
+// NewRollupServiceWith creates a rollup service on the given dependencies
+func NewRollupServiceWith(deps Dependencies) *RollupService {
+	return &RollupService{
+		BaseService:      NewBaseServiceWith(deps),
+		analyticsService: NewAnalyticsServiceWith(deps),
+	}
+}
+
+// RunScheduledRollups refreshes the current and previous hourly/daily rollups,
+// the dashboard snapshot and cached cohort matrices. Called periodically by
+// the background scheduler.
+func (rs *RollupService) RunScheduledRollups(ctx context.Context) error {
+	now := time.Now()
+	currentHour := now.Truncate(time.Hour)
+	today := startOfDay(now)
+
+	periods := []struct {
+		granularity string
+		start       time.Time
+	}{
+		{RollupHourly, currentHour.Add(-time.Hour)},
+		{RollupHourly, currentHour},
+		{RollupDaily, today.AddDate(0, 0, -1)},
+		{RollupDaily, today},
+	}
+
+	for _, p := range periods {
+		if _, err := rs.ComputeRollup(ctx, p.granularity, p.start); err != nil {
+			return err
+		}
+	}
+
+	if err := rs.RefreshDashboardSnapshot(ctx); err != nil {
+		return err
+	}
+
+	// Keep the default cohort matrices warm for the user analytics page
+	for _, granularity := range []string{"week", "month"} {
+		if _, err := rs.analyticsService.GetCohortRetention(ctx, granularity, 0, true); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// BackfillDailyRollups computes daily rollups for the last N days
+func (rs *RollupService) BackfillDailyRollups(ctx context.Context, days int) (int, error) {
+	today := startOfDay(time.Now())
+	computed := 0
+	for i := days; i >= 0; i-- {
+		if _, err := rs.ComputeRollup(ctx, RollupDaily, today.AddDate(0, 0, -i)); err != nil {
+			return computed, err
+		}
+		computed++
+	}
+	return computed, nil
+}
+
+// ComputeRollup aggregates one period and upserts it into the rollups collection
+func (rs *RollupService) ComputeRollup(ctx context.Context, granularity string, periodStart time.Time) (*models.StatsRollup, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+
+	var periodEnd time.Time
+	switch granularity {
+	case RollupHourly:
+		periodEnd = periodStart.Add(time.Hour)
+	case RollupDaily:
+		periodEnd = periodStart.AddDate(0, 0, 1)
+	default:
+		return nil, fmt.Errorf("unsupported rollup granularity: %s", granularity)
+	}
+
+	started := time.Now()
+	inPeriod := bson.M{"$gte": periodStart, "$lt": periodEnd}
+
+	rollup := &models.StatsRollup{
+		Granularity: granularity,
+		PeriodStart: periodStart,
+		PeriodEnd:   periodEnd,
+	}
+
+	rollup.NewUsers, _ = rs.collections.Users().CountDocuments(ctx, bson.M{"created_at": inPeriod})
+	rollup.ActiveUsers, _ = rs.collections.Users().CountDocuments(ctx, bson.M{"last_login_at": inPeriod})
+	rollup.Downloads, _ = rs.collections.Analytics().CountDocuments(ctx, bson.M{
+		"type":      "file_activity",
+		"action":    "download",
+		"timestamp": inPeriod,
+	})
+
+	uploads := sumField(ctx, rs.collections.Files(), bson.M{"created_at": inPeriod}, "$size")
+	rollup.NewFiles = uploads.count
+	rollup.UploadedBytes = int64(uploads.total)
+
+	revenue := sumField(ctx, rs.collections.Payments(), bson.M{"status": "completed", "created_at": inPeriod}, "$amount")
+	rollup.Payments = revenue.count
+	rollup.Revenue = revenue.total
+
+	// Point-in-time totals as of the end of the period
+	asOf := bson.M{"$lt": periodEnd}
+	rollup.TotalUsers, _ = rs.collections.Users().CountDocuments(ctx, bson.M{"created_at": asOf})
+	storage := sumField(ctx, rs.collections.Files(), bson.M{"is_deleted": false, "created_at": asOf}, "$size")
+	rollup.TotalFiles = storage.count
+	rollup.TotalStorage = int64(storage.total)
+
+	rollup.ComputedAt = time.Now()
+	rollup.ComputeTimeMs = time.Since(started).Milliseconds()
+
+	_, err := rs.collections.StatsRollups().UpdateOne(ctx,
+		bson.M{"granularity": granularity, "period_start": periodStart},
+		bson.M{
+			"$set": bson.M{
+				"period_end":      rollup.PeriodEnd,
+				"new_users":       rollup.NewUsers,
+				"new_files":       rollup.NewFiles,
+				"uploaded_bytes":  rollup.UploadedBytes,
+				"downloads":       rollup.Downloads,
+				"revenue":         rollup.Revenue,
+				"payments":        rollup.Payments,
+				"active_users":    rollup.ActiveUsers,
+				"total_users":     rollup.TotalUsers,
+				"total_files":     rollup.TotalFiles,
+				"total_storage":   rollup.TotalStorage,
+				"computed_at":     rollup.ComputedAt,
+				"compute_time_ms": rollup.ComputeTimeMs,
+			},
+			"$setOnInsert": bson.M{"_id": primitive.NewObjectID()},
+		},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store %s rollup: %v", granularity, err)
+	}
+
+	return rollup, nil
+}
+
+// GetRollups returns rollups of a granularity within a time range
+func (rs *RollupService) GetRollups(ctx context.Context, granularity string, from, to time.Time) ([]models.StatsRollup, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	cursor, err := rs.collections.StatsRollups().Find(ctx,
+		bson.M{
+			"granularity":  granularity,
+			"period_start": bson.M{"$gte": from, "$lt": to},
+		},
+		options.Find().SetSort(bson.M{"period_start": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var rollups []models.StatsRollup
+	if err = cursor.All(ctx, &rollups); err != nil {
+		return nil, err
+	}
+
+	return rollups, nil
+}
+
+// RefreshDashboardSnapshot recomputes the parts of the dashboard that cannot be
+// derived from rollups (storage by provider, top files) and stores them
+func (rs *RollupService) RefreshDashboardSnapshot(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+
+	started := time.Now()
+	data := map[string]interface{}{
+		"overview": map[string]interface{}{
+			"total_revenue": rs.analyticsService.getTotalRevenue(ctx),
+			"active_users":  rs.analyticsService.getActiveUsers(ctx, 30),
+		},
+		"storage":   rs.analyticsService.getStorageStats(ctx),
+		"top_files": rs.analyticsService.getTopFiles(ctx, 5),
+	}
+
+	_, err := rs.collections.DashboardSnapshots().UpdateOne(ctx,
+		bson.M{"key": dashboardSnapshotKey},
+		bson.M{
+			"$set": bson.M{
+				"data":            data,
+				"computed_at":     time.Now(),
+				"compute_time_ms": time.Since(started).Milliseconds(),
+			},
+			"$setOnInsert": bson.M{"_id": primitive.NewObjectID()},
+		},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to store dashboard snapshot: %v", err)
+	}
+
+	return nil
+}
+
+// GetDashboard serves the admin dashboard from rollups, falling back to a live
+// computation when no rollups have been materialized yet
+func (rs *RollupService) GetDashboard(ctx context.Context) (map[string]interface{}, error) {
+	dashboard, err := rs.GetRollupDashboard(ctx)
+	if !errors.Is(err, ErrNoDashboardRollup) {
+		return dashboard, err
+	}
+
+	dashboard, err = rs.analyticsService.GetDashboard(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dashboard["freshness"] = models.RollupFreshness{Source: "live"}
+	return dashboard, nil
+}
+
+// GetRollupDashboard serves the admin dashboard from rollups only, with their
+// freshness. When none have been materialized yet it starts computing them
+// and returns ErrNoDashboardRollup, leaving the fallback to the caller.
+func (rs *RollupService) GetRollupDashboard(ctx context.Context) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var snapshot models.DashboardSnapshot
+	err := rs.collections.DashboardSnapshots().FindOne(ctx, bson.M{"key": dashboardSnapshotKey}).Decode(&snapshot)
+	if err == mongo.ErrNoDocuments {
+		// Materialize in the background so the next request is cheap
+		go func() {
+			if err := rs.RunScheduledRollups(context.Background()); err != nil {
+				log.Printf("Failed to compute dashboard rollups: %v", err)
+			}
+		}()
+		return nil, ErrNoDashboardRollup
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load dashboard snapshot: %v", err)
+	}
+
+	now := time.Now()
+	today := startOfDay(now)
+	daily, err := rs.GetRollups(ctx, RollupDaily, today.AddDate(0, 0, -60), today.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load daily rollups: %v", err)
+	}
+
+	dashboard := snapshot.Data
+	if dashboard == nil {
+		dashboard = make(map[string]interface{})
+	}
+
+	overview, _ := dashboard["overview"].(map[string]interface{})
+	if overview == nil {
+		overview = make(map[string]interface{})
+	}
+	if len(daily) > 0 {
+		latest := daily[len(daily)-1]
+		overview["total_users"] = latest.TotalUsers
+		overview["total_files"] = latest.TotalFiles
+		overview["total_storage"] = latest.TotalStorage
+	}
+	dashboard["overview"] = overview
+
+	startOfWeek := today.AddDate(0, 0, -int(now.Weekday()))
+	startOfMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	dashboard["growth"] = map[string]interface{}{
+		"daily":   rollupGrowth(daily, today, today.AddDate(0, 0, -1), "day"),
+		"weekly":  rollupGrowth(daily, startOfWeek, startOfWeek.AddDate(0, 0, -7), "week"),
+		"monthly": rollupGrowth(daily, startOfMonth, startOfMonth.AddDate(0, -1, 0), "month"),
+	}
+
+	revenueTrend := make([]map[string]interface{}, 0, 30)
+	trendStart := today.AddDate(0, 0, -30)
+	for _, r := range daily {
+		if r.PeriodStart.Before(trendStart) {
+			continue
+		}
+		revenueTrend = append(revenueTrend, map[string]interface{}{
+			"date":    r.PeriodStart.Format("2006-01-02"),
+			"revenue": r.Revenue,
+			"count":   r.Payments,
+		})
+	}
+	dashboard["revenue_trend"] = revenueTrend
+
+	// Recent activity is an indexed, bounded read and stays live
+	dashboard["recent_activity"] = rs.analyticsService.getRecentActivity(ctx, 10)
+
+	computedAt := snapshot.ComputedAt
+	age := now.Sub(computedAt)
+	dashboard["freshness"] = models.RollupFreshness{
+		ComputedAt: &computedAt,
+		AgeSeconds: int64(age.Seconds()),
+		IsStale:    age > dashboardStaleAfter,
+		Source:     "rollup",
+	}
+
+	return dashboard, nil
+}
+
+// rollupGrowth sums daily rollups for the current and previous period and
+// returns the same shape as AnalyticsService.getGrowthMetrics
+func rollupGrowth(daily []models.StatsRollup, currentStart, previousStart time.Time, period string) map[string]interface{} {
+	var newUsers, newFiles, prevUsers, prevFiles int64
+	for _, r := range daily {
+		switch {
+		case !r.PeriodStart.Before(currentStart):
+			newUsers += r.NewUsers
+			newFiles += r.NewFiles
+		case !r.PeriodStart.Before(previousStart):
+			prevUsers += r.NewUsers
+			prevFiles += r.NewFiles
+		}
+	}
+
+	return map[string]interface{}{
+		"new_users":   newUsers,
+		"new_files":   newFiles,
+		"user_growth": calculateGrowthRate(prevUsers, newUsers),
+		"file_growth": calculateGrowthRate(prevFiles, newFiles),
+		"period":      period,
+	}
+}
+
+type rollupSum struct {
+	count int64
+	total float64
+}
+
+// sumField counts matching documents and sums a numeric field in one pass
+func sumField(ctx context.Context, collection *mongo.Collection, match bson.M, field string) rollupSum {
+	pipeline := []bson.M{
+		{"$match": match},
+		{"$group": bson.M{
+			"_id":   nil,
+			"count": bson.M{"$sum": 1},
+			"total": bson.M{"$sum": field},
+		}},
+	}
+
+	cursor, err := reportAggregate(ctx, collection, pipeline)
+	if err != nil {
+		return rollupSum{}
+	}
+	defer cursor.Close(ctx)
+
+	var result []struct {
+		Count int64   `bson:"count"`
+		Total float64 `bson:"total"`
+	}
+	if err = cursor.All(ctx, &result); err != nil || len(result) == 0 {
+		return rollupSum{}
+	}
+
+	return rollupSum{count: result[0].Count, total: result[0].Total}
+}
+
+// startOfDay truncates t to local midnight
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
@@ -0,0 +1,440 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"oncloud/database"
+	"oncloud/models"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Runtime settings are read from the environment at startup and can be
+// overridden by an admin while the server runs
+const (
+	RuntimeSettingMaxUploadSize          = "max_upload_size"
+	RuntimeSettingMaxUploadsPerUser      = "max_concurrent_uploads_per_user"
+	RuntimeSettingDefaultStorageProvider = "default_storage_provider"
+	RuntimeSettingAdminPanelEnabled      = "admin_panel_enabled"
+	RuntimeSettingMaintenanceMode        = "maintenance_mode"
+	RuntimeSettingMaintenanceMessage     = "maintenance_message"
+	RuntimeSettingAbuseDisableThreshold  = "abuse_report_disable_threshold"
+	RuntimeSettingRejectTypeMismatch     = "reject_type_mismatch"
+	RuntimeSettingHotFileThreshold       = "hot_file_threshold"
+	RuntimeSettingEdgeCacheBudget        = "edge_cache_budget"
+	RuntimeSettingLocalCacheBudget       = "local_cache_budget"
+	RuntimeSettingCompressionAtRest      = "compression_at_rest"
+)
+
+// Overrides are reloaded this often, so changes made through another
+// instance are picked up without a restart
+const runtimeSettingsTTL = 30 * time.Second
+
+var (
+	ErrRuntimeSettingNotFound = notFoundError("runtime setting not found")
+	// ErrInvalidRuntimeSetting wraps any rejected runtime setting value
+	ErrInvalidRuntimeSetting = errors.New("invalid runtime setting value")
+)
+
+// RuntimeSettingsOptions holds the environment values of the runtime
+// settings, used until an admin overrides them
+type RuntimeSettingsOptions struct {
+	MaxUploadSize          int64
+	MaxUploadsPerUser      int
+	DefaultStorageProvider string
+	AdminPanelEnabled      bool
+	MaintenanceMode        bool
+	MaintenanceMessage     string
+	AbuseDisableThreshold  int
+	RejectTypeMismatch     bool
+	HotFileThreshold       int
+	EdgeCacheBudget        int64
+	LocalCacheBudget       int64
+	CompressionAtRest      string
+}
+
+type runtimeSettingDefinition struct {
+	key          string
+	settingType  string
+	group        string
+	label        string
+	description  string
+	rules        []string
+	defaultValue interface{}
+}
+
+var runtimeSettingDefinitions = []*runtimeSettingDefinition{
+	{
+		key:          RuntimeSettingMaxUploadSize,
+		settingType:  "int",
+		group:        "files",
+		label:        "Max Upload Size",
+		description:  "Maximum file upload size in bytes, 0 for no limit",
+		rules:        []string{"min:0"},
+		defaultValue: int64(0),
+	},
+	{
+		key:          RuntimeSettingMaxUploadsPerUser,
+		settingType:  "int",
+		group:        "files",
+		label:        "Concurrent Uploads Per User",
+		description:  "Number of uploads a user can run at the same time, 0 for no limit",
+		rules:        []string{"min:0"},
+		defaultValue: int64(0),
+	},
+	{
+		key:          RuntimeSettingRejectTypeMismatch,
+		settingType:  "bool",
+		group:        "files",
+		label:        "Reject Mismatched Uploads",
+		description:  "Reject uploads whose content is not what their extension says instead of flagging them. Disguised executables are always rejected.",
+		defaultValue: false,
+	},
+	{
+		key:          RuntimeSettingDefaultStorageProvider,
+		settingType:  "string",
+		group:        "storage",
+		label:        "Default Storage Provider",
+		description:  "Type of the storage provider new uploads are stored with, such as local or s3",
+		defaultValue: "",
+	},
+	{
+		key:          RuntimeSettingCompressionAtRest,
+		settingType:  "string",
+		group:        "storage",
+		label:        "Compression At Rest",
+		description:  "Encoding new text-like files are stored compressed with, gzip or zstd, empty to store them as uploaded",
+		rules:        []string{"regex:^(gzip|zstd)?$"},
+		defaultValue: "",
+	},
+	{
+		key:          RuntimeSettingHotFileThreshold,
+		settingType:  "int",
+		group:        "cache",
+		label:        "Hot File Threshold",
+		description:  "Downloads within the hot file window after which a public or shared file is pre-warmed in the caches, 0 to never pre-warm",
+		rules:        []string{"min:0"},
+		defaultValue: int64(0),
+	},
+	{
+		key:          RuntimeSettingEdgeCacheBudget,
+		settingType:  "int",
+		group:        "cache",
+		label:        "Edge Cache Budget",
+		description:  "Bytes of hot public files kept warm in the CDN, 0 to not warm the CDN",
+		rules:        []string{"min:0"},
+		defaultValue: int64(0),
+	},
+	{
+		key:          RuntimeSettingLocalCacheBudget,
+		settingType:  "int",
+		group:        "cache",
+		label:        "Local Cache Budget",
+		description:  "Bytes of hot files kept on local disk for the downloads the server streams itself, 0 to not cache locally",
+		rules:        []string{"min:0"},
+		defaultValue: int64(0),
+	},
+	{
+		key:          RuntimeSettingAdminPanelEnabled,
+		settingType:  "bool",
+		group:        "admin",
+		label:        "Admin Panel",
+		description:  "Serve the HTML admin panel",
+		defaultValue: false,
+	},
+	{
+		key:          RuntimeSettingMaintenanceMode,
+		settingType:  "bool",
+		group:        "maintenance",
+		label:        "Maintenance Mode",
+		description:  "Answer users with 503 while admins and health checks keep working",
+		defaultValue: false,
+	},
+	{
+		key:          RuntimeSettingMaintenanceMessage,
+		settingType:  "string",
+		group:        "maintenance",
+		label:        "Maintenance Message",
+		description:  "Shown to users while maintenance mode is on",
+		rules:        []string{"max:500"},
+		defaultValue: "",
+	},
+	{
+		key:          RuntimeSettingAbuseDisableThreshold,
+		settingType:  "int",
+		group:        "moderation",
+		label:        "Abuse Reports Before Disabling",
+		description:  "Confirmed abuse reports after which a share link is disabled, 0 to never disable links automatically",
+		rules:        []string{"min:0"},
+		defaultValue: int64(0),
+	},
+}
+
+// runtimeSettingsCache holds the overrides, shared by every service instance
+var runtimeSettingsCache struct {
+	sync.RWMutex
+	values   map[string]interface{}
+	loadedAt time.Time
+}
+
+// InitRuntimeSettings sets the environment values of the runtime settings
+func InitRuntimeSettings(opts RuntimeSettingsOptions) {
+	defaults := map[string]interface{}{
+		RuntimeSettingMaxUploadSize:          opts.MaxUploadSize,
+		RuntimeSettingMaxUploadsPerUser:      int64(opts.MaxUploadsPerUser),
+		RuntimeSettingDefaultStorageProvider: opts.DefaultStorageProvider,
+		RuntimeSettingAdminPanelEnabled:      opts.AdminPanelEnabled,
+		RuntimeSettingMaintenanceMode:        opts.MaintenanceMode,
+		RuntimeSettingMaintenanceMessage:     opts.MaintenanceMessage,
+		RuntimeSettingAbuseDisableThreshold:  int64(opts.AbuseDisableThreshold),
+		RuntimeSettingRejectTypeMismatch:     opts.RejectTypeMismatch,
+		RuntimeSettingHotFileThreshold:       int64(opts.HotFileThreshold),
+		RuntimeSettingEdgeCacheBudget:        opts.EdgeCacheBudget,
+		RuntimeSettingLocalCacheBudget:       opts.LocalCacheBudget,
+		RuntimeSettingCompressionAtRest:      opts.CompressionAtRest,
+	}
+	for _, def := range runtimeSettingDefinitions {
+		def.defaultValue = defaults[def.key]
+	}
+}
+
+// InvalidateRuntimeSettings makes the next read reload the overrides
+func InvalidateRuntimeSettings() {
+	runtimeSettingsCache.Lock()
+	runtimeSettingsCache.loadedAt = time.Time{}
+	runtimeSettingsCache.Unlock()
+}
+
+// RuntimeSettingInt64 returns the value of an int runtime setting
+func RuntimeSettingInt64(key string) int64 {
+	value, _ := runtimeSettingValue(key)
+	n, _ := settingInt64(value)
+	return n
+}
+
+// RuntimeSettingBool returns the value of a bool runtime setting
+func RuntimeSettingBool(key string) bool {
+	value, _ := runtimeSettingValue(key)
+	b, _ := value.(bool)
+	return b
+}
+
+// RuntimeSettingString returns the value of a string runtime setting
+func RuntimeSettingString(key string) string {
+	value, _ := runtimeSettingValue(key)
+	s, _ := value.(string)
+	return s
+}
+
+// runtimeSettingValue returns the value of a runtime setting and whether it
+// is overridden
+func runtimeSettingValue(key string) (interface{}, bool) {
+	def := findRuntimeSetting(key)
+	if def == nil {
+		return nil, false
+	}
+	if value, exists := runtimeSettingOverrides()[key]; exists {
+		return value, true
+	}
+	return def.defaultValue, false
+}
+
+// runtimeSettingOverrides returns the cached overrides, reloading them once
+// they are stale. When the database cannot be reached the previous overrides
+// stay in use until the next reload.
+func runtimeSettingOverrides() map[string]interface{} {
+	runtimeSettingsCache.RLock()
+	values, loadedAt := runtimeSettingsCache.values, runtimeSettingsCache.loadedAt
+	runtimeSettingsCache.RUnlock()
+	if time.Since(loadedAt) < runtimeSettingsTTL {
+		return values
+	}
+
+	runtimeSettingsCache.Lock()
+	defer runtimeSettingsCache.Unlock()
+	if time.Since(runtimeSettingsCache.loadedAt) < runtimeSettingsTTL {
+		return runtimeSettingsCache.values
+	}
+
+	overrides, err := loadRuntimeSettingOverrides(context.Background(), database.GetCollection(database.RuntimeSettingsCollection))
+	if err != nil {
+		log.Printf("Failed to load runtime settings: %v", err)
+	} else {
+		runtimeSettingsCache.values = make(map[string]interface{}, len(overrides))
+		for _, override := range overrides {
+			runtimeSettingsCache.values[override.Key] = override.Value
+		}
+	}
+	runtimeSettingsCache.loadedAt = time.Now()
+	return runtimeSettingsCache.values
+}
+
+func loadRuntimeSettingOverrides(ctx context.Context, collection *mongo.Collection) ([]models.RuntimeSettingOverride, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	cursor, err := collection.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var overrides []models.RuntimeSettingOverride
+	if err := cursor.All(ctx, &overrides); err != nil {
+		return nil, err
+	}
+	return overrides, nil
+}
+
+func findRuntimeSetting(key string) *runtimeSettingDefinition {
+	for _, def := range runtimeSettingDefinitions {
+		if def.key == key {
+			return def
+		}
+	}
+	return nil
+}
+
+// GetRuntimeSettings returns every runtime setting with the value in use
+func (ss *SettingsService) GetRuntimeSettings(ctx context.Context) ([]models.RuntimeSetting, error) {
+	overrides, err := loadRuntimeSettingOverrides(ctx, ss.runtimeSettingsCollection)
+	if err != nil {
+		return nil, err
+	}
+	byKey := make(map[string]models.RuntimeSettingOverride, len(overrides))
+	for _, override := range overrides {
+		byKey[override.Key] = override
+	}
+
+	settings := make([]models.RuntimeSetting, 0, len(runtimeSettingDefinitions))
+	for _, def := range runtimeSettingDefinitions {
+		setting := models.RuntimeSetting{
+			Key:         def.key,
+			Type:        def.settingType,
+			Group:       def.group,
+			Label:       def.label,
+			Description: def.description,
+			Rules:       def.rules,
+			Value:       def.defaultValue,
+			Default:     def.defaultValue,
+		}
+		if override, exists := byKey[def.key]; exists {
+			updatedAt := override.UpdatedAt
+			setting.Value = override.Value
+			setting.Overridden = true
+			setting.UpdatedAt = &updatedAt
+		}
+		settings = append(settings, setting)
+	}
+	return settings, nil
+}
+
+// UpdateRuntimeSetting overrides a runtime setting. It takes effect
+// immediately on this instance and within runtimeSettingsTTL on others.
+func (ss *SettingsService) UpdateRuntimeSetting(ctx context.Context, key string, value interface{}, adminID primitive.ObjectID) error {
+	def := findRuntimeSetting(key)
+	if def == nil {
+		return ErrRuntimeSettingNotFound
+	}
+
+	value, err := ss.normalizeRuntimeSetting(ctx, def, value)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err = ss.runtimeSettingsCollection.UpdateOne(ctx,
+		bson.M{"key": key},
+		bson.M{"$set": bson.M{
+			"value":      value,
+			"updated_by": adminID,
+			"updated_at": time.Now(),
+		}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update runtime setting: %v", err)
+	}
+
+	InvalidateRuntimeSettings()
+	return nil
+}
+
+// ResetRuntimeSetting removes the override of a runtime setting, returning
+// it to its environment value
+func (ss *SettingsService) ResetRuntimeSetting(ctx context.Context, key string) error {
+	if findRuntimeSetting(key) == nil {
+		return ErrRuntimeSettingNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if _, err := ss.runtimeSettingsCollection.DeleteOne(ctx, bson.M{"key": key}); err != nil {
+		return fmt.Errorf("failed to reset runtime setting: %v", err)
+	}
+
+	InvalidateRuntimeSettings()
+	return nil
+}
+
+// normalizeRuntimeSetting checks a value against the setting's type and
+// rules, converting JSON numbers to int64
+func (ss *SettingsService) normalizeRuntimeSetting(ctx context.Context, def *runtimeSettingDefinition, value interface{}) (interface{}, error) {
+	if def.settingType == "int" {
+		n, ok := settingInt64(value)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s must be a whole number", ErrInvalidRuntimeSetting, def.key)
+		}
+		value = n
+	}
+	if err := ss.validateSettingValue(def.settingType, value); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRuntimeSetting, err)
+	}
+	if err := ss.validateSettingRules(def.rules, value); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRuntimeSetting, err)
+	}
+
+	if def.key == RuntimeSettingDefaultStorageProvider {
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+
+		count, err := ss.settingsCollection.Database().Collection("storage_providers").CountDocuments(ctx, bson.M{"type": value, "is_active": true})
+		if err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			return nil, fmt.Errorf("%w: no active %q storage provider", ErrInvalidRuntimeSetting, value)
+		}
+	}
+	return value, nil
+}
+
+// settingInt64 converts the integer types settings are decoded as. Floats are
+// accepted when they hold a whole number, as JSON numbers decode to float64.
+func settingInt64(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case float64:
+		if v != math.Trunc(v) || math.Abs(v) > math.MaxInt64 {
+			return 0, false
+		}
+		return int64(v), true
+	}
+	return 0, false
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"oncloud/database"
 	"oncloud/models"
 	"oncloud/storage"
 	"strings"
@@ -23,15 +22,21 @@ type S3Service struct {
 }
 
 func NewS3Service() *S3Service {
+	return NewS3ServiceWith(Dependencies{})
+}
+
+// NewS3ServiceWith creates an S3 service on the given dependencies
+func NewS3ServiceWith(deps Dependencies) *S3Service {
+	db := deps.database()
 	return &S3Service{
-		providerCollection: database.GetCollection("storage_providers"),
-		fileCollection:     database.GetCollection("files"),
+		providerCollection: db.GetCollection("storage_providers"),
+		fileCollection:     db.GetCollection("files"),
 	}
 }
 
 // Initialize S3 client with provider configuration
-func (s3s *S3Service) InitializeClient(providerID primitive.ObjectID) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (s3s *S3Service) InitializeClient(ctx context.Context, providerID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	var provider models.StorageProvider
@@ -55,8 +60,8 @@ func (s3s *S3Service) InitializeClient(providerID primitive.ObjectID) error {
 }
 
 // Initialize with default S3 provider
-func (s3s *S3Service) InitializeDefaultClient() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (s3s *S3Service) InitializeDefaultClient(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	var provider models.StorageProvider
@@ -80,9 +85,9 @@ func (s3s *S3Service) InitializeDefaultClient() error {
 }
 
 // File Operations
-func (s3s *S3Service) UploadFile(key string, data []byte, options *storage.UploadOptions) error {
+func (s3s *S3Service) UploadFile(ctx context.Context, key string, data []byte, options *storage.UploadOptions) error {
 	if s3s.client == nil {
-		if err := s3s.InitializeDefaultClient(); err != nil {
+		if err := s3s.InitializeDefaultClient(ctx); err != nil {
 			return err
 		}
 	}
@@ -104,9 +109,9 @@ func (s3s *S3Service) UploadFile(key string, data []byte, options *storage.Uploa
 	return nil
 }
 
-func (s3s *S3Service) UploadStream(key string, reader io.Reader, size int64) error {
+func (s3s *S3Service) UploadStream(ctx context.Context, key string, reader io.Reader, size int64) error {
 	if s3s.client == nil {
-		if err := s3s.InitializeDefaultClient(); err != nil {
+		if err := s3s.InitializeDefaultClient(ctx); err != nil {
 			return err
 		}
 	}
@@ -122,9 +127,9 @@ func (s3s *S3Service) UploadStream(key string, reader io.Reader, size int64) err
 	return nil
 }
 
-func (s3s *S3Service) DownloadFile(key string) ([]byte, error) {
+func (s3s *S3Service) DownloadFile(ctx context.Context, key string) ([]byte, error) {
 	if s3s.client == nil {
-		if err := s3s.InitializeDefaultClient(); err != nil {
+		if err := s3s.InitializeDefaultClient(ctx); err != nil {
 			return nil, err
 		}
 	}
@@ -140,9 +145,9 @@ func (s3s *S3Service) DownloadFile(key string) ([]byte, error) {
 	return data, nil
 }
 
-func (s3s *S3Service) DownloadStream(key string) (io.ReadCloser, error) {
+func (s3s *S3Service) DownloadStream(ctx context.Context, key string) (io.ReadCloser, error) {
 	if s3s.client == nil {
-		if err := s3s.InitializeDefaultClient(); err != nil {
+		if err := s3s.InitializeDefaultClient(ctx); err != nil {
 			return nil, err
 		}
 	}
@@ -158,9 +163,9 @@ func (s3s *S3Service) DownloadStream(key string) (io.ReadCloser, error) {
 	return stream, nil
 }
 
-func (s3s *S3Service) DeleteFile(key string) error {
+func (s3s *S3Service) DeleteFile(ctx context.Context, key string) error {
 	if s3s.client == nil {
-		if err := s3s.InitializeDefaultClient(); err != nil {
+		if err := s3s.InitializeDefaultClient(ctx); err != nil {
 			return err
 		}
 	}
@@ -176,9 +181,9 @@ func (s3s *S3Service) DeleteFile(key string) error {
 	return nil
 }
 
-func (s3s *S3Service) DeleteMultipleFiles(keys []string) error {
+func (s3s *S3Service) DeleteMultipleFiles(ctx context.Context, keys []string) error {
 	if s3s.client == nil {
-		if err := s3s.InitializeDefaultClient(); err != nil {
+		if err := s3s.InitializeDefaultClient(ctx); err != nil {
 			return err
 		}
 	}
@@ -196,9 +201,9 @@ func (s3s *S3Service) DeleteMultipleFiles(keys []string) error {
 	return nil
 }
 
-func (s3s *S3Service) FileExists(key string) (bool, error) {
+func (s3s *S3Service) FileExists(ctx context.Context, key string) (bool, error) {
 	if s3s.client == nil {
-		if err := s3s.InitializeDefaultClient(); err != nil {
+		if err := s3s.InitializeDefaultClient(ctx); err != nil {
 			return false, err
 		}
 	}
@@ -211,9 +216,9 @@ func (s3s *S3Service) FileExists(key string) (bool, error) {
 	return exists, nil
 }
 
-func (s3s *S3Service) GetFileSize(key string) (int64, error) {
+func (s3s *S3Service) GetFileSize(ctx context.Context, key string) (int64, error) {
 	if s3s.client == nil {
-		if err := s3s.InitializeDefaultClient(); err != nil {
+		if err := s3s.InitializeDefaultClient(ctx); err != nil {
 			return 0, err
 		}
 	}
@@ -227,9 +232,9 @@ func (s3s *S3Service) GetFileSize(key string) (int64, error) {
 }
 
 // URL Operations
-func (s3s *S3Service) GetPublicURL(key string) (string, error) {
+func (s3s *S3Service) GetPublicURL(ctx context.Context, key string) (string, error) {
 	if s3s.client == nil {
-		if err := s3s.InitializeDefaultClient(); err != nil {
+		if err := s3s.InitializeDefaultClient(ctx); err != nil {
 			return "", err
 		}
 	}
@@ -242,9 +247,9 @@ func (s3s *S3Service) GetPublicURL(key string) (string, error) {
 	return url, nil
 }
 
-func (s3s *S3Service) GetPresignedDownloadURL(key string, expiry time.Duration) (string, error) {
+func (s3s *S3Service) GetPresignedDownloadURL(ctx context.Context, key string, expiry time.Duration) (string, error) {
 	if s3s.client == nil {
-		if err := s3s.InitializeDefaultClient(); err != nil {
+		if err := s3s.InitializeDefaultClient(ctx); err != nil {
 			return "", err
 		}
 	}
@@ -257,9 +262,9 @@ func (s3s *S3Service) GetPresignedDownloadURL(key string, expiry time.Duration)
 	return url, nil
 }
 
-func (s3s *S3Service) GetPresignedUploadURL(key string, expiry time.Duration, maxSize int64) (string, error) {
+func (s3s *S3Service) GetPresignedUploadURL(ctx context.Context, key string, expiry time.Duration, maxSize int64) (string, error) {
 	if s3s.client == nil {
-		if err := s3s.InitializeDefaultClient(); err != nil {
+		if err := s3s.InitializeDefaultClient(ctx); err != nil {
 			return "", err
 		}
 	}
@@ -273,9 +278,9 @@ func (s3s *S3Service) GetPresignedUploadURL(key string, expiry time.Duration, ma
 }
 
 // Multipart Upload Operations
-func (s3s *S3Service) InitiateMultipartUpload(key string) (*storage.MultipartUpload, error) {
+func (s3s *S3Service) InitiateMultipartUpload(ctx context.Context, key string) (*storage.MultipartUpload, error) {
 	if s3s.client == nil {
-		if err := s3s.InitializeDefaultClient(); err != nil {
+		if err := s3s.InitializeDefaultClient(ctx); err != nil {
 			return nil, err
 		}
 	}
@@ -293,9 +298,9 @@ func (s3s *S3Service) InitiateMultipartUpload(key string) (*storage.MultipartUpl
 	return upload, nil
 }
 
-func (s3s *S3Service) UploadPart(uploadID, key string, partNumber int, data []byte) (*storage.UploadPart, error) {
+func (s3s *S3Service) UploadPart(ctx context.Context, uploadID, key string, partNumber int, data []byte) (*storage.UploadPart, error) {
 	if s3s.client == nil {
-		if err := s3s.InitializeDefaultClient(); err != nil {
+		if err := s3s.InitializeDefaultClient(ctx); err != nil {
 			return nil, err
 		}
 	}
@@ -315,9 +320,9 @@ func (s3s *S3Service) UploadPart(uploadID, key string, partNumber int, data []by
 	return part, nil
 }
 
-func (s3s *S3Service) CompleteMultipartUpload(uploadID, key string, parts []storage.UploadPart) error {
+func (s3s *S3Service) CompleteMultipartUpload(ctx context.Context, uploadID, key string, parts []storage.UploadPart) error {
 	if s3s.client == nil {
-		if err := s3s.InitializeDefaultClient(); err != nil {
+		if err := s3s.InitializeDefaultClient(ctx); err != nil {
 			return err
 		}
 	}
@@ -342,9 +347,9 @@ func (s3s *S3Service) CompleteMultipartUpload(uploadID, key string, parts []stor
 	return nil
 }
 
-func (s3s *S3Service) AbortMultipartUpload(uploadID, key string) error {
+func (s3s *S3Service) AbortMultipartUpload(ctx context.Context, uploadID, key string) error {
 	if s3s.client == nil {
-		if err := s3s.InitializeDefaultClient(); err != nil {
+		if err := s3s.InitializeDefaultClient(ctx); err != nil {
 			return err
 		}
 	}
@@ -363,9 +368,9 @@ func (s3s *S3Service) AbortMultipartUpload(uploadID, key string) error {
 }
 
 // File Management Operations
-func (s3s *S3Service) CopyFile(sourceKey, destKey string) error {
+func (s3s *S3Service) CopyFile(ctx context.Context, sourceKey, destKey string) error {
 	if s3s.client == nil {
-		if err := s3s.InitializeDefaultClient(); err != nil {
+		if err := s3s.InitializeDefaultClient(ctx); err != nil {
 			return err
 		}
 	}
@@ -383,9 +388,9 @@ func (s3s *S3Service) CopyFile(sourceKey, destKey string) error {
 	return nil
 }
 
-func (s3s *S3Service) MoveFile(sourceKey, destKey string) error {
+func (s3s *S3Service) MoveFile(ctx context.Context, sourceKey, destKey string) error {
 	if s3s.client == nil {
-		if err := s3s.InitializeDefaultClient(); err != nil {
+		if err := s3s.InitializeDefaultClient(ctx); err != nil {
 			return err
 		}
 	}
@@ -404,9 +409,9 @@ func (s3s *S3Service) MoveFile(sourceKey, destKey string) error {
 }
 
 // Provider Management
-func (s3s *S3Service) GetProviderInfo() (*storage.ProviderInfo, error) {
+func (s3s *S3Service) GetProviderInfo(ctx context.Context) (*storage.ProviderInfo, error) {
 	if s3s.client == nil {
-		if err := s3s.InitializeDefaultClient(); err != nil {
+		if err := s3s.InitializeDefaultClient(ctx); err != nil {
 			return nil, err
 		}
 	}
@@ -414,9 +419,9 @@ func (s3s *S3Service) GetProviderInfo() (*storage.ProviderInfo, error) {
 	return s3s.client.GetProviderInfo(), nil
 }
 
-func (s3s *S3Service) HealthCheck() error {
+func (s3s *S3Service) HealthCheck(ctx context.Context) error {
 	if s3s.client == nil {
-		if err := s3s.InitializeDefaultClient(); err != nil {
+		if err := s3s.InitializeDefaultClient(ctx); err != nil {
 			return err
 		}
 	}
@@ -429,9 +434,9 @@ func (s3s *S3Service) HealthCheck() error {
 	return nil
 }
 
-func (s3s *S3Service) GetStorageStats() (*storage.StorageStats, error) {
+func (s3s *S3Service) GetStorageStats(ctx context.Context) (*storage.StorageStats, error) {
 	if s3s.client == nil {
-		if err := s3s.InitializeDefaultClient(); err != nil {
+		if err := s3s.InitializeDefaultClient(ctx); err != nil {
 			return nil, err
 		}
 	}
@@ -440,8 +445,8 @@ func (s3s *S3Service) GetStorageStats() (*storage.StorageStats, error) {
 }
 
 // Provider Configuration
-func (s3s *S3Service) CreateProvider(provider *models.StorageProvider) (*models.StorageProvider, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (s3s *S3Service) CreateProvider(ctx context.Context, provider *models.StorageProvider) (*models.StorageProvider, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	// Validate S3 provider configuration
@@ -472,8 +477,8 @@ func (s3s *S3Service) CreateProvider(provider *models.StorageProvider) (*models.
 	return provider, nil
 }
 
-func (s3s *S3Service) UpdateProvider(providerID primitive.ObjectID, updates map[string]interface{}) (*models.StorageProvider, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (s3s *S3Service) UpdateProvider(ctx context.Context, providerID primitive.ObjectID, updates map[string]interface{}) (*models.StorageProvider, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	updates["updated_at"] = time.Now()
@@ -495,8 +500,8 @@ func (s3s *S3Service) UpdateProvider(providerID primitive.ObjectID, updates map[
 	return &provider, nil
 }
 
-func (s3s *S3Service) DeleteProvider(providerID primitive.ObjectID) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (s3s *S3Service) DeleteProvider(ctx context.Context, providerID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	// Check if provider is in use
@@ -522,8 +527,8 @@ func (s3s *S3Service) DeleteProvider(providerID primitive.ObjectID) error {
 	return nil
 }
 
-func (s3s *S3Service) TestConnection(providerID primitive.ObjectID) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (s3s *S3Service) TestConnection(ctx context.Context, providerID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	var provider models.StorageProvider
@@ -644,7 +649,7 @@ func (s3s *S3Service) logActivity(action, key string, size int64, metadata map[s
 			activity["provider_id"] = s3s.provider.ID
 		}
 
-		collection := database.GetCollection("storage_activities")
+		collection := s3s.providerCollection.Database().Collection("storage_activities")
 		collection.InsertOne(ctx, activity)
 	}()
 }
@@ -0,0 +1,1030 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"oncloud/models"
+	"oncloud/utils"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	ProvisionedByScim = "scim"
+
+	scimMaxPageSize = 200
+)
+
+// SCIM errors, mapped to SCIM status codes and scimType values by the controller
+var (
+	ErrScimNotFound      = notFoundError("resource not found")
+	ErrScimUniqueness    = errors.New("resource already exists")
+	ErrScimInvalidFilter = errors.New("unsupported filter")
+	ErrScimInvalidValue  = errors.New("invalid attribute value")
+)
+
+// scimFilterPattern matches the simple `attribute eq "value"` filters
+// identity providers send when looking up a resource before creating it
+var scimFilterPattern = regexp.MustCompile(`^\s*([A-Za-z.]+)\s+eq\s+"((?:[^"\\]|\\.)*)"\s*$`)
+
+// scimMemberFilterPattern matches patch paths like members[value eq "id"]
+var scimMemberFilterPattern = regexp.MustCompile(`^members\[\s*value\s+eq\s+"([^"]+)"\s*\]$`)
+
+var scimUserFilterFields = map[string]string{
+	"username":     "username",
+	"externalid":   "external_id",
+	"emails.value": "email",
+	"emails":       "email",
+}
+
+var scimGroupFilterFields = map[string]string{
+	"displayname": "display_name",
+	"externalid":  "external_id",
+}
+
+type ScimService struct {
+	*BaseService
+	sessions *SessionService
+}
+
+func NewScimService() *ScimService {
+	return NewScimServiceWith(Dependencies{})
+}
+
+// NewScimServiceWith creates a SCIM service on the given dependencies
+func NewScimServiceWith(deps Dependencies) *ScimService {
+	return &ScimService{
+		BaseService: NewBaseServiceWith(deps),
+		sessions:    NewSessionServiceWith(deps),
+	}
+}
+
+// CreateToken issues a SCIM bearer token. The plaintext token is only
+// returned here; just its hash is stored.
+func (ss *ScimService) CreateToken(ctx context.Context, name string, adminID primitive.ObjectID) (string, *models.ScimToken, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	secret, err := utils.GenerateSecureToken(32)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to generate token: %v", err)
+	}
+	token := "scim_" + secret
+
+	record := &models.ScimToken{
+		ID:          primitive.NewObjectID(),
+		Name:        name,
+		TokenHash:   utils.HashSHA256(token),
+		TokenPrefix: token[:12],
+		IsActive:    true,
+		CreatedBy:   adminID,
+		CreatedAt:   time.Now(),
+	}
+
+	if _, err := ss.collections.ScimTokens().InsertOne(ctx, record); err != nil {
+		return "", nil, fmt.Errorf("failed to create token: %v", err)
+	}
+
+	return token, record, nil
+}
+
+// GetTokens lists SCIM tokens, newest first
+func (ss *ScimService) GetTokens(ctx context.Context) ([]models.ScimToken, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	cursor, err := ss.collections.ScimTokens().Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"created_at": -1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	tokens := []models.ScimToken{}
+	if err = cursor.All(ctx, &tokens); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// RevokeToken disables a SCIM token
+func (ss *ScimService) RevokeToken(ctx context.Context, tokenID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := ss.collections.ScimTokens().UpdateOne(ctx,
+		bson.M{"_id": tokenID},
+		bson.M{"$set": bson.M{"is_active": false}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to revoke token: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("token not found")
+	}
+
+	return nil
+}
+
+// AuthenticateToken resolves an active SCIM bearer token
+func (ss *ScimService) AuthenticateToken(ctx context.Context, token string) (*models.ScimToken, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var record models.ScimToken
+	err := ss.collections.ScimTokens().FindOne(ctx, bson.M{
+		"token_hash": utils.HashSHA256(token),
+		"is_active":  true,
+	}).Decode(&record)
+	if err != nil {
+		return nil, errors.New("invalid SCIM token")
+	}
+
+	ss.collections.ScimTokens().UpdateOne(ctx,
+		bson.M{"_id": record.ID},
+		bson.M{"$set": bson.M{"last_used_at": time.Now()}},
+	)
+
+	return &record, nil
+}
+
+// ListUsers returns users matching a SCIM filter. startIndex is 1-based.
+// Deprovisioned users are hidden.
+func (ss *ScimService) ListUsers(ctx context.Context, filter string, startIndex, count int) ([]models.User, int, error) {
+	query, err := parseScimFilter(filter, scimUserFilterFields)
+	if err != nil {
+		return nil, 0, err
+	}
+	query["deprovisioned_at"] = bson.M{"$exists": false}
+
+	var users []models.User
+	total, err := ss.list(ctx, ss.collections.Users(), query, startIndex, count, &users)
+	return users, total, err
+}
+
+// GetUser returns a provisionable user
+func (ss *ScimService) GetUser(ctx context.Context, userID primitive.ObjectID) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var user models.User
+	err := ss.collections.Users().FindOne(ctx, bson.M{
+		"_id":              userID,
+		"deprovisioned_at": bson.M{"$exists": false},
+	}).Decode(&user)
+	if err != nil {
+		return nil, ErrScimNotFound
+	}
+
+	user.Password = ""
+	return &user, nil
+}
+
+// CreateUser provisions a user from the directory. A previously deprovisioned
+// account with the same userName or email is restored instead.
+func (ss *ScimService) CreateUser(ctx context.Context, resource *models.ScimUser) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	fields, err := scimUserFields(resource)
+	if err != nil {
+		return nil, err
+	}
+
+	var existing models.User
+	err = ss.collections.Users().FindOne(ctx, bson.M{
+		"$or": []bson.M{{"username": fields["username"]}, {"email": fields["email"]}},
+	}).Decode(&existing)
+	if err == nil {
+		if existing.DeprovisionedAt == nil {
+			return nil, ErrScimUniqueness
+		}
+		fields["is_active"] = resource.Active == nil || *resource.Active
+		fields["provisioned_by"] = ProvisionedByScim
+		return ss.updateUser(ctx, existing.ID, fields, bson.M{
+			"deprovisioned_at":  "",
+			"suspension_reason": "",
+			"suspended_at":      "",
+		})
+	} else if err != mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+
+	defaultPlan, err := NewAuthService().getDefaultPlan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get default plan: %v", err)
+	}
+
+	// Directory users sign in via password reset or SSO; the initial
+	// password is random and never disclosed
+	secret, err := utils.GenerateSecureToken(32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate password: %v", err)
+	}
+	hashedPassword, err := utils.HashPassword(secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %v", err)
+	}
+
+	now := time.Now()
+	user := &models.User{
+		ID:              primitive.NewObjectID(),
+		Username:        fields["username"].(string),
+		Email:           fields["email"].(string),
+		Password:        hashedPassword,
+		FirstName:       fields["first_name"].(string),
+		LastName:        fields["last_name"].(string),
+		PlanID:          defaultPlan.ID,
+		IsActive:        resource.Active == nil || *resource.Active,
+		IsVerified:      true,
+		IsPremium:       !defaultPlan.IsFree,
+		EmailVerifiedAt: &now,
+		ExternalID:      resource.ExternalID,
+		ProvisionedBy:   ProvisionedByScim,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+
+	if _, err := ss.collections.Users().InsertOne(ctx, user); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrScimUniqueness
+		}
+		return nil, fmt.Errorf("failed to create user: %v", err)
+	}
+
+	user.Password = ""
+	return user, nil
+}
+
+// ReplaceUser overwrites a user's directory-managed attributes
+func (ss *ScimService) ReplaceUser(ctx context.Context, userID primitive.ObjectID, resource *models.ScimUser) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	if _, err := ss.GetUser(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	fields, err := scimUserFields(resource)
+	if err != nil {
+		return nil, err
+	}
+	if resource.Active != nil {
+		fields["is_active"] = *resource.Active
+	}
+
+	return ss.updateUser(ctx, userID, fields, nil)
+}
+
+// PatchUser applies SCIM PATCH operations to a user. Identity providers
+// mostly use it to toggle "active" when deprovisioning.
+func (ss *ScimService) PatchUser(ctx context.Context, userID primitive.ObjectID, patch *models.ScimPatchRequest) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	if _, err := ss.GetUser(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	fields := bson.M{}
+	for _, op := range patch.Operations {
+		switch strings.ToLower(op.Op) {
+		case "add", "replace":
+		default:
+			return nil, fmt.Errorf("%w: unsupported operation %q on users", ErrScimInvalidValue, op.Op)
+		}
+
+		// Without a path the value is an object of attribute/value pairs
+		if op.Path == "" {
+			values, ok := op.Value.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%w: patch value must be an object", ErrScimInvalidValue)
+			}
+			for path, value := range values {
+				if err := setScimUserAttribute(fields, path, value); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		if err := setScimUserAttribute(fields, op.Path, op.Value); err != nil {
+			return nil, err
+		}
+	}
+
+	return ss.updateUser(ctx, userID, fields, nil)
+}
+
+// DeprovisionUser deactivates a user removed from the directory and drops them
+// from all groups. The account and its files are kept so it can be restored.
+func (ss *ScimService) DeprovisionUser(ctx context.Context, userID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	if _, err := ss.GetUser(ctx, userID); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	_, err := ss.collections.Users().UpdateOne(ctx,
+		bson.M{"_id": userID},
+		bson.M{"$set": bson.M{
+			"is_active":         false,
+			"deprovisioned_at":  now,
+			"suspension_reason": "Deprovisioned by directory",
+			"suspended_at":      now,
+			"updated_at":        now,
+		}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to deprovision user: %v", err)
+	}
+
+	ss.collections.ScimGroups().UpdateMany(ctx,
+		bson.M{"members": userID},
+		bson.M{"$pull": bson.M{"members": userID}},
+	)
+	ss.sessions.RevokeOtherSessions(ctx, userID, "", "deprovisioned")
+
+	return nil
+}
+
+// GetUserGroups returns the groups a user belongs to
+func (ss *ScimService) GetUserGroups(ctx context.Context, userID primitive.ObjectID) ([]models.ScimGroup, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	cursor, err := ss.collections.ScimGroups().Find(ctx, bson.M{"members": userID},
+		options.Find().SetProjection(bson.M{"display_name": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var groups []models.ScimGroup
+	if err = cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+
+	return groups, nil
+}
+
+// ListGroups returns groups matching a SCIM filter. startIndex is 1-based.
+func (ss *ScimService) ListGroups(ctx context.Context, filter string, startIndex, count int) ([]models.ScimGroup, int, error) {
+	query, err := parseScimFilter(filter, scimGroupFilterFields)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var groups []models.ScimGroup
+	total, err := ss.list(ctx, ss.collections.ScimGroups(), query, startIndex, count, &groups)
+	return groups, total, err
+}
+
+// GetGroup returns a group
+func (ss *ScimService) GetGroup(ctx context.Context, groupID primitive.ObjectID) (*models.ScimGroup, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var group models.ScimGroup
+	if err := ss.collections.ScimGroups().FindOne(ctx, bson.M{"_id": groupID}).Decode(&group); err != nil {
+		return nil, ErrScimNotFound
+	}
+
+	return &group, nil
+}
+
+// CreateGroup stores a directory group
+func (ss *ScimService) CreateGroup(ctx context.Context, resource *models.ScimGroupResource) (*models.ScimGroup, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	if strings.TrimSpace(resource.DisplayName) == "" {
+		return nil, fmt.Errorf("%w: displayName is required", ErrScimInvalidValue)
+	}
+	members, err := ss.resolveMembers(ctx, resource.Members)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	group := &models.ScimGroup{
+		ID:          primitive.NewObjectID(),
+		ExternalID:  resource.ExternalID,
+		DisplayName: strings.TrimSpace(resource.DisplayName),
+		Members:     members,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	if _, err := ss.collections.ScimGroups().InsertOne(ctx, group); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrScimUniqueness
+		}
+		return nil, fmt.Errorf("failed to create group: %v", err)
+	}
+
+	return group, nil
+}
+
+// ReplaceGroup overwrites a group's name and members
+func (ss *ScimService) ReplaceGroup(ctx context.Context, groupID primitive.ObjectID, resource *models.ScimGroupResource) (*models.ScimGroup, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	group, err := ss.GetGroup(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(resource.DisplayName) == "" {
+		return nil, fmt.Errorf("%w: displayName is required", ErrScimInvalidValue)
+	}
+	members, err := ss.resolveMembers(ctx, resource.Members)
+	if err != nil {
+		return nil, err
+	}
+
+	previous := group.Members
+	group.DisplayName = strings.TrimSpace(resource.DisplayName)
+	group.ExternalID = resource.ExternalID
+	group.Members = members
+
+	if err := ss.saveGroup(ctx, group); err != nil {
+		return nil, err
+	}
+	ss.syncMemberPlans(ctx, group, previous)
+
+	return group, nil
+}
+
+// PatchGroup applies SCIM PATCH operations to a group: renaming it and
+// adding, removing or replacing members
+func (ss *ScimService) PatchGroup(ctx context.Context, groupID primitive.ObjectID, patch *models.ScimPatchRequest) (*models.ScimGroup, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	group, err := ss.GetGroup(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+	previous := group.Members
+
+	members := make(map[primitive.ObjectID]bool, len(group.Members))
+	for _, id := range group.Members {
+		members[id] = true
+	}
+
+	for _, op := range patch.Operations {
+		operation := strings.ToLower(op.Op)
+		path := strings.TrimSpace(op.Path)
+
+		// members[value eq "id"] targets a single member
+		if match := scimMemberFilterPattern.FindStringSubmatch(path); match != nil {
+			if operation != "remove" {
+				return nil, fmt.Errorf("%w: unsupported operation %q on a member", ErrScimInvalidValue, op.Op)
+			}
+			if id, err := primitive.ObjectIDFromHex(match[1]); err == nil {
+				delete(members, id)
+			}
+			continue
+		}
+
+		switch {
+		case strings.EqualFold(path, "members"):
+			ids, err := ss.resolveMembers(ctx, scimMembersFromValue(op.Value))
+			if err != nil {
+				return nil, err
+			}
+			switch operation {
+			case "add":
+				for _, id := range ids {
+					members[id] = true
+				}
+			case "remove":
+				// Removing without a value clears the group
+				if op.Value == nil {
+					members = map[primitive.ObjectID]bool{}
+				}
+				for _, id := range ids {
+					delete(members, id)
+				}
+			case "replace":
+				members = make(map[primitive.ObjectID]bool, len(ids))
+				for _, id := range ids {
+					members[id] = true
+				}
+			default:
+				return nil, fmt.Errorf("%w: unsupported operation %q", ErrScimInvalidValue, op.Op)
+			}
+		case strings.EqualFold(path, "displayName"):
+			name, _ := op.Value.(string)
+			if operation == "remove" || strings.TrimSpace(name) == "" {
+				return nil, fmt.Errorf("%w: displayName is required", ErrScimInvalidValue)
+			}
+			group.DisplayName = strings.TrimSpace(name)
+		case strings.EqualFold(path, "externalId"):
+			group.ExternalID, _ = op.Value.(string)
+		case path == "":
+			// Azure AD sends replace operations with an object value
+			values, ok := op.Value.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%w: patch value must be an object", ErrScimInvalidValue)
+			}
+			if name, ok := values["displayName"].(string); ok && strings.TrimSpace(name) != "" {
+				group.DisplayName = strings.TrimSpace(name)
+			}
+			if externalID, ok := values["externalId"].(string); ok {
+				group.ExternalID = externalID
+			}
+		default:
+			return nil, fmt.Errorf("%w: unsupported path %q", ErrScimInvalidValue, op.Path)
+		}
+	}
+
+	group.Members = make([]primitive.ObjectID, 0, len(members))
+	for id := range members {
+		group.Members = append(group.Members, id)
+	}
+
+	if err := ss.saveGroup(ctx, group); err != nil {
+		return nil, err
+	}
+	ss.syncMemberPlans(ctx, group, previous)
+
+	return group, nil
+}
+
+// DeleteGroup removes a group, moving its former members off its plan
+func (ss *ScimService) DeleteGroup(ctx context.Context, groupID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	group, err := ss.GetGroup(ctx, groupID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := ss.collections.ScimGroups().DeleteOne(ctx, bson.M{"_id": groupID}); err != nil {
+		return fmt.Errorf("failed to delete group: %v", err)
+	}
+
+	if group.PlanID != nil {
+		for _, userID := range group.Members {
+			ss.syncUserPlan(ctx, userID)
+		}
+	}
+
+	return nil
+}
+
+// SetGroupPlan maps a group to a plan, or removes the mapping when planID is
+// nil, and moves the group's members accordingly
+func (ss *ScimService) SetGroupPlan(ctx context.Context, groupID primitive.ObjectID, planID *primitive.ObjectID) (*models.ScimGroup, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	group, err := ss.GetGroup(ctx, groupID)
+	if err != nil {
+		return nil, errors.New("group not found")
+	}
+
+	update := bson.M{"$set": bson.M{"plan_id": planID, "updated_at": time.Now()}}
+	if planID == nil {
+		update = bson.M{"$unset": bson.M{"plan_id": ""}, "$set": bson.M{"updated_at": time.Now()}}
+	} else {
+		count, err := ss.collections.Plans().CountDocuments(ctx, bson.M{"_id": *planID, "is_active": true})
+		if err != nil || count == 0 {
+			return nil, errors.New("plan not found or inactive")
+		}
+	}
+
+	if _, err := ss.collections.ScimGroups().UpdateOne(ctx, bson.M{"_id": groupID}, update); err != nil {
+		return nil, fmt.Errorf("failed to map group: %v", err)
+	}
+
+	group.PlanID = planID
+	for _, userID := range group.Members {
+		ss.syncUserPlan(ctx, userID)
+	}
+
+	return group, nil
+}
+
+// GetGroupsForAdmin lists all groups with their plan mappings
+func (ss *ScimService) GetGroupsForAdmin(ctx context.Context) ([]models.ScimGroup, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	cursor, err := ss.collections.ScimGroups().Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"display_name": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	groups := []models.ScimGroup{}
+	if err = cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+
+	return groups, nil
+}
+
+func (ss *ScimService) list(ctx context.Context, collection *mongo.Collection, query bson.M, startIndex, count int, results interface{}) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	if startIndex < 1 {
+		startIndex = 1
+	}
+	if count < 0 {
+		count = 0
+	}
+	if count > scimMaxPageSize {
+		count = scimMaxPageSize
+	}
+
+	total, err := collection.CountDocuments(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	if count == 0 {
+		return int(total), nil
+	}
+
+	cursor, err := collection.Find(ctx, query,
+		options.Find().
+			SetSort(bson.M{"created_at": 1}).
+			SetSkip(int64(startIndex-1)).
+			SetLimit(int64(count)),
+	)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	if err = cursor.All(ctx, results); err != nil {
+		return 0, err
+	}
+
+	return int(total), nil
+}
+
+func (ss *ScimService) updateUser(ctx context.Context, userID primitive.ObjectID, fields, unset bson.M) (*models.User, error) {
+	if len(fields) == 0 && len(unset) == 0 {
+		return ss.GetUser(ctx, userID)
+	}
+
+	// Reactivation clears the suspension left by an earlier deprovisioning
+	if active, ok := fields["is_active"].(bool); ok && active && unset == nil {
+		unset = bson.M{"suspension_reason": "", "suspended_at": ""}
+	}
+
+	fields["updated_at"] = time.Now()
+	update := bson.M{"$set": fields}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	if _, err := ss.collections.Users().UpdateOne(ctx, bson.M{"_id": userID}, update); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrScimUniqueness
+		}
+		return nil, fmt.Errorf("failed to update user: %v", err)
+	}
+
+	if active, ok := fields["is_active"].(bool); ok && !active {
+		ss.sessions.RevokeOtherSessions(ctx, userID, "", "deprovisioned")
+	}
+
+	return ss.GetUser(ctx, userID)
+}
+
+func (ss *ScimService) saveGroup(ctx context.Context, group *models.ScimGroup) error {
+	group.UpdatedAt = time.Now()
+	_, err := ss.collections.ScimGroups().UpdateOne(ctx,
+		bson.M{"_id": group.ID},
+		bson.M{"$set": bson.M{
+			"display_name": group.DisplayName,
+			"external_id":  group.ExternalID,
+			"members":      group.Members,
+			"updated_at":   group.UpdatedAt,
+		}},
+	)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrScimUniqueness
+		}
+		return fmt.Errorf("failed to update group: %v", err)
+	}
+	return nil
+}
+
+// resolveMembers validates member references, returning the IDs of existing users
+func (ss *ScimService) resolveMembers(ctx context.Context, members []models.ScimMember) ([]primitive.ObjectID, error) {
+	ids := make([]primitive.ObjectID, 0, len(members))
+	for _, member := range members {
+		id, err := primitive.ObjectIDFromHex(member.Value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: unknown member %q", ErrScimInvalidValue, member.Value)
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return ids, nil
+	}
+
+	count, err := ss.collections.Users().CountDocuments(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+	if int(count) != len(ids) {
+		return nil, fmt.Errorf("%w: group references unknown users", ErrScimInvalidValue)
+	}
+
+	return ids, nil
+}
+
+// syncMemberPlans re-evaluates the plan of everyone who joined or left a
+// plan-mapped group
+func (ss *ScimService) syncMemberPlans(ctx context.Context, group *models.ScimGroup, previous []primitive.ObjectID) {
+	if group.PlanID == nil {
+		return
+	}
+
+	before := make(map[primitive.ObjectID]bool, len(previous))
+	for _, id := range previous {
+		before[id] = true
+	}
+	for _, id := range group.Members {
+		if before[id] {
+			delete(before, id)
+			continue
+		}
+		ss.syncUserPlan(ctx, id)
+	}
+	for id := range before {
+		ss.syncUserPlan(ctx, id)
+	}
+}
+
+// syncUserPlan puts a user on the most expensive plan mapped to any of their
+// groups, or back on the default plan when they are in no mapped group
+func (ss *ScimService) syncUserPlan(ctx context.Context, userID primitive.ObjectID) {
+	cursor, err := ss.collections.ScimGroups().Find(ctx, bson.M{
+		"members": userID,
+		"plan_id": bson.M{"$exists": true},
+	})
+	if err != nil {
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var groups []models.ScimGroup
+	if err = cursor.All(ctx, &groups); err != nil {
+		return
+	}
+
+	var target *models.Plan
+	for _, group := range groups {
+		var plan models.Plan
+		if err := ss.collections.Plans().FindOne(ctx, bson.M{"_id": *group.PlanID, "is_active": true}).Decode(&plan); err != nil {
+			continue
+		}
+		if target == nil || plan.Price > target.Price {
+			target = &plan
+		}
+	}
+	if target == nil {
+		if target, err = NewAuthService().getDefaultPlan(ctx); err != nil {
+			return
+		}
+	}
+
+	ss.collections.Users().UpdateOne(ctx,
+		bson.M{"_id": userID},
+		bson.M{"$set": bson.M{
+			"plan_id":    target.ID,
+			"is_premium": !target.IsFree,
+			"updated_at": time.Now(),
+		}},
+	)
+}
+
+// ToScimUser converts a user to its SCIM representation
+func ToScimUser(user *models.User, groups []models.ScimGroup, baseURL string) *models.ScimUser {
+	active := user.IsActive
+	resource := &models.ScimUser{
+		Schemas:    []string{models.ScimUserSchema},
+		ID:         user.ID.Hex(),
+		ExternalID: user.ExternalID,
+		UserName:   user.Username,
+		Name: &models.ScimName{
+			Formatted:  strings.TrimSpace(user.FirstName + " " + user.LastName),
+			GivenName:  user.FirstName,
+			FamilyName: user.LastName,
+		},
+		DisplayName: strings.TrimSpace(user.FirstName + " " + user.LastName),
+		Emails:      []models.ScimMultiValue{{Value: user.Email, Type: "work", Primary: true}},
+		Active:      &active,
+		Meta: &models.ScimMeta{
+			ResourceType: "User",
+			Created:      user.CreatedAt,
+			LastModified: user.UpdatedAt,
+			Location:     baseURL + "/Users/" + user.ID.Hex(),
+		},
+	}
+
+	for _, group := range groups {
+		resource.Groups = append(resource.Groups, models.ScimMember{
+			Value:   group.ID.Hex(),
+			Display: group.DisplayName,
+			Ref:     baseURL + "/Groups/" + group.ID.Hex(),
+		})
+	}
+
+	return resource
+}
+
+// ToScimGroup converts a group to its SCIM representation
+func ToScimGroup(group *models.ScimGroup, baseURL string) *models.ScimGroupResource {
+	resource := &models.ScimGroupResource{
+		Schemas:     []string{models.ScimGroupSchema},
+		ID:          group.ID.Hex(),
+		ExternalID:  group.ExternalID,
+		DisplayName: group.DisplayName,
+		Members:     []models.ScimMember{},
+		Meta: &models.ScimMeta{
+			ResourceType: "Group",
+			Created:      group.CreatedAt,
+			LastModified: group.UpdatedAt,
+			Location:     baseURL + "/Groups/" + group.ID.Hex(),
+		},
+	}
+
+	for _, id := range group.Members {
+		resource.Members = append(resource.Members, models.ScimMember{
+			Value: id.Hex(),
+			Ref:   baseURL + "/Users/" + id.Hex(),
+		})
+	}
+
+	return resource
+}
+
+// parseScimFilter turns an `attribute eq "value"` filter into a query
+func parseScimFilter(filter string, fields map[string]string) (bson.M, error) {
+	if strings.TrimSpace(filter) == "" {
+		return bson.M{}, nil
+	}
+
+	match := scimFilterPattern.FindStringSubmatch(filter)
+	if match == nil {
+		return nil, ErrScimInvalidFilter
+	}
+	field, ok := fields[strings.ToLower(match[1])]
+	if !ok {
+		return nil, ErrScimInvalidFilter
+	}
+
+	value := strings.ReplaceAll(match[2], `\"`, `"`)
+	// userName and email comparisons are case-insensitive per RFC 7643
+	if field == "username" || field == "email" {
+		return bson.M{field: primitive.Regex{Pattern: "^" + regexp.QuoteMeta(value) + "$", Options: "i"}}, nil
+	}
+	return bson.M{field: value}, nil
+}
+
+// scimUserFields maps a SCIM user onto user document fields
+func scimUserFields(resource *models.ScimUser) (bson.M, error) {
+	userName := strings.TrimSpace(resource.UserName)
+	if userName == "" {
+		return nil, fmt.Errorf("%w: userName is required", ErrScimInvalidValue)
+	}
+
+	email := primaryScimEmail(resource.Emails)
+	if email == "" && utils.IsValidEmail(userName) {
+		email = userName
+	}
+	if !utils.IsValidEmail(email) {
+		return nil, fmt.Errorf("%w: a valid email is required", ErrScimInvalidValue)
+	}
+
+	var firstName, lastName string
+	if resource.Name != nil {
+		firstName, lastName = resource.Name.GivenName, resource.Name.FamilyName
+	}
+	if firstName == "" && lastName == "" && resource.DisplayName != "" {
+		firstName, lastName, _ = strings.Cut(resource.DisplayName, " ")
+	}
+
+	return bson.M{
+		"username":    userName,
+		"email":       strings.ToLower(email),
+		"first_name":  firstName,
+		"last_name":   lastName,
+		"external_id": resource.ExternalID,
+	}, nil
+}
+
+// setScimUserAttribute maps one patched SCIM attribute onto user fields
+func setScimUserAttribute(fields bson.M, path string, value interface{}) error {
+	str, _ := value.(string)
+
+	switch strings.ToLower(path) {
+	case "active":
+		active, ok := value.(bool)
+		if !ok {
+			// Some providers send booleans as strings
+			switch strings.ToLower(str) {
+			case "true":
+				active = true
+			case "false":
+				active = false
+			default:
+				return fmt.Errorf("%w: active must be a boolean", ErrScimInvalidValue)
+			}
+		}
+		fields["is_active"] = active
+	case "username":
+		if strings.TrimSpace(str) == "" {
+			return fmt.Errorf("%w: userName is required", ErrScimInvalidValue)
+		}
+		fields["username"] = strings.TrimSpace(str)
+	case "externalid":
+		fields["external_id"] = str
+	case "name.givenname":
+		fields["first_name"] = str
+	case "name.familyname":
+		fields["last_name"] = str
+	case "name":
+		if name, ok := value.(map[string]interface{}); ok {
+			if given, ok := name["givenName"].(string); ok {
+				fields["first_name"] = given
+			}
+			if family, ok := name["familyName"].(string); ok {
+				fields["last_name"] = family
+			}
+		}
+	case "displayname":
+		// Derived from the name; accepted and ignored
+	case "emails", `emails[type eq "work"].value`, "emails.value":
+		email := str
+		if list, ok := value.([]interface{}); ok {
+			var values []models.ScimMultiValue
+			for _, item := range list {
+				if entry, ok := item.(map[string]interface{}); ok {
+					v, _ := entry["value"].(string)
+					primary, _ := entry["primary"].(bool)
+					values = append(values, models.ScimMultiValue{Value: v, Primary: primary})
+				}
+			}
+			email = primaryScimEmail(values)
+		}
+		if !utils.IsValidEmail(email) {
+			return fmt.Errorf("%w: a valid email is required", ErrScimInvalidValue)
+		}
+		fields["email"] = strings.ToLower(email)
+	default:
+		return fmt.Errorf("%w: unsupported path %q", ErrScimInvalidValue, path)
+	}
+
+	return nil
+}
+
+func primaryScimEmail(emails []models.ScimMultiValue) string {
+	for _, email := range emails {
+		if email.Primary {
+			return email.Value
+		}
+	}
+	if len(emails) > 0 {
+		return emails[0].Value
+	}
+	return ""
+}
+
+// scimMembersFromValue reads a patch value holding member references
+func scimMembersFromValue(value interface{}) []models.ScimMember {
+	list, ok := value.([]interface{})
+	if !ok {
+		list = []interface{}{value}
+	}
+
+	var members []models.ScimMember
+	for _, item := range list {
+		if entry, ok := item.(map[string]interface{}); ok {
+			if id, ok := entry["value"].(string); ok {
+				members = append(members, models.ScimMember{Value: id})
+			}
+		}
+	}
+	return members
+}
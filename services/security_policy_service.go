@@ -0,0 +1,319 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"oncloud/models"
+	"oncloud/utils"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	securityPolicyKey = "global"
+
+	// The policy is consulted on every login, so it is cached briefly
+	securityPolicyCacheTTL = 30 * time.Second
+)
+
+var securityPolicyCache = struct {
+	policy   *models.SecurityPolicy
+	loadedAt time.Time
+	mutex    sync.RWMutex
+}{}
+
+// PasswordPolicyError lists the rules a rejected password breaks
+type PasswordPolicyError struct {
+	Violations []string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return "password does not meet the password policy: " + strings.Join(e.Violations, "; ")
+}
+
+// AccountLockedError is returned while an account is locked out after
+// repeated failed logins
+type AccountLockedError struct {
+	Until time.Time
+}
+
+func (e *AccountLockedError) Error() string {
+	return "account is temporarily locked after too many failed login attempts"
+}
+
+// DefaultSecurityPolicy is used until an admin saves a policy
+func DefaultSecurityPolicy() *models.SecurityPolicy {
+	return &models.SecurityPolicy{
+		Key: securityPolicyKey,
+		Password: models.PasswordPolicy{
+			MinLength:            8,
+			RequireUppercase:     true,
+			RequireLowercase:     true,
+			RequireDigit:         true,
+			DisallowPersonalInfo: true,
+			CheckBreached:        true,
+		},
+		Lockout: models.LockoutPolicy{
+			Enabled:            true,
+			MaxAttempts:        5,
+			WindowMinutes:      15,
+			BaseLockoutMinutes: 5,
+			MaxLockoutMinutes:  24 * 60,
+		},
+	}
+}
+
+type SecurityPolicyService struct {
+	*BaseService
+}
+
+func NewSecurityPolicyService() *SecurityPolicyService {
+	return NewSecurityPolicyServiceWith(Dependencies{})
+}
+
+// NewSecurityPolicyServiceWith creates a security policy service on the
+// given dependencies
+func NewSecurityPolicyServiceWith(deps Dependencies) *SecurityPolicyService {
+	return &SecurityPolicyService{
+		BaseService: NewBaseServiceWith(deps),
+	}
+}
+
+// GetPolicy returns the saved security policy, or the defaults
+func (ss *SecurityPolicyService) GetPolicy(ctx context.Context) *models.SecurityPolicy {
+	securityPolicyCache.mutex.RLock()
+	if securityPolicyCache.policy != nil && time.Since(securityPolicyCache.loadedAt) < securityPolicyCacheTTL {
+		policy := securityPolicyCache.policy
+		securityPolicyCache.mutex.RUnlock()
+		return policy
+	}
+	securityPolicyCache.mutex.RUnlock()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var policy models.SecurityPolicy
+	if err := ss.collections.SecurityPolicies().FindOne(ctx, bson.M{"key": securityPolicyKey}).Decode(&policy); err != nil {
+		policy = *DefaultSecurityPolicy()
+	}
+
+	securityPolicyCache.mutex.Lock()
+	securityPolicyCache.policy = &policy
+	securityPolicyCache.loadedAt = time.Now()
+	securityPolicyCache.mutex.Unlock()
+
+	return &policy
+}
+
+// SavePolicy replaces the security policy
+func (ss *SecurityPolicyService) SavePolicy(ctx context.Context, req *models.SecurityPolicyRequest, adminID primitive.ObjectID) (*models.SecurityPolicy, error) {
+	if err := utils.ValidateStruct(req); err != nil {
+		return nil, err
+	}
+	if req.Lockout.MaxLockoutMinutes < req.Lockout.BaseLockoutMinutes {
+		return nil, errors.New("max_lockout_minutes cannot be less than base_lockout_minutes")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := ss.collections.SecurityPolicies().UpdateOne(ctx,
+		bson.M{"key": securityPolicyKey},
+		bson.M{"$set": bson.M{
+			"password":   req.Password,
+			"lockout":    req.Lockout,
+			"updated_by": adminID,
+			"updated_at": time.Now(),
+		}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save security policy: %v", err)
+	}
+
+	securityPolicyCache.mutex.Lock()
+	securityPolicyCache.policy = nil
+	securityPolicyCache.mutex.Unlock()
+
+	return ss.GetPolicy(ctx), nil
+}
+
+// ValidatePassword checks a new password against the password policy. user
+// supplies the personal details the password must not contain.
+func (ss *SecurityPolicyService) ValidatePassword(ctx context.Context, password string, user *models.User) error {
+	policy := ss.GetPolicy(ctx).Password
+
+	var violations []string
+	if len([]rune(password)) < policy.MinLength {
+		violations = append(violations, fmt.Sprintf("must be at least %d characters long", policy.MinLength))
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case !unicode.IsLetter(r) && !unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+	if policy.RequireUppercase && !hasUpper {
+		violations = append(violations, "must contain an uppercase letter")
+	}
+	if policy.RequireLowercase && !hasLower {
+		violations = append(violations, "must contain a lowercase letter")
+	}
+	if policy.RequireDigit && !hasDigit {
+		violations = append(violations, "must contain a digit")
+	}
+	if policy.RequireSymbol && !hasSymbol {
+		violations = append(violations, "must contain a symbol")
+	}
+
+	if policy.DisallowPersonalInfo && user != nil && containsPersonalInfo(password, user) {
+		violations = append(violations, "must not contain your name, username or email")
+	}
+
+	// Only spend a network round trip on passwords that pass the local rules
+	if policy.CheckBreached && len(violations) == 0 {
+		count, err := utils.PasswordBreachCount(password)
+		if err != nil {
+			log.Printf("Skipping password breach check: %v", err)
+		} else if count > 0 {
+			violations = append(violations, "has appeared in a known data breach, please choose another")
+		}
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// CheckLockout returns an AccountLockedError while the user is locked out
+func (ss *SecurityPolicyService) CheckLockout(user *models.User) error {
+	if user.LockedUntil != nil && time.Now().Before(*user.LockedUntil) {
+		return &AccountLockedError{Until: *user.LockedUntil}
+	}
+	return nil
+}
+
+// RecordFailedLogin counts a wrong password against the user and locks the
+// account once the policy's limit is reached. Consecutive lockouts double in
+// length; the streak resets after a successful login or once the account has
+// stayed unlocked for the maximum lockout period.
+func (ss *SecurityPolicyService) RecordFailedLogin(ctx context.Context, user *models.User, clientIP string) error {
+	policy := ss.GetPolicy(ctx).Lockout
+	if !policy.Enabled {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	window := time.Duration(policy.WindowMinutes) * time.Minute
+	maxLockout := time.Duration(policy.MaxLockoutMinutes) * time.Minute
+
+	attempts := user.FailedLogins + 1
+	if user.LastFailedLogin == nil || now.Sub(*user.LastFailedLogin) > window {
+		attempts = 1
+	}
+
+	if attempts < policy.MaxAttempts {
+		ss.collections.Users().UpdateOne(ctx,
+			bson.M{"_id": user.ID},
+			bson.M{"$set": bson.M{"failed_logins": attempts, "last_failed_login_at": now}},
+		)
+		return nil
+	}
+
+	streak := user.LockoutCount
+	if user.LockedUntil != nil && now.Sub(*user.LockedUntil) > maxLockout {
+		streak = 0
+	}
+
+	lockout := time.Duration(policy.BaseLockoutMinutes) * time.Minute
+	for i := 0; i < streak && lockout < maxLockout; i++ {
+		lockout *= 2
+	}
+	if lockout > maxLockout {
+		lockout = maxLockout
+	}
+	until := now.Add(lockout)
+
+	ss.collections.Users().UpdateOne(ctx,
+		bson.M{"_id": user.ID},
+		bson.M{
+			"$set": bson.M{"lockout_count": streak + 1, "locked_until": until},
+			"$unset": bson.M{
+				"failed_logins":        "",
+				"last_failed_login_at": "",
+			},
+		},
+	)
+
+	NewAuditService().Record(ctx, &models.AuditLog{
+		ActorType:    "user",
+		ActorID:      &user.ID,
+		Action:       "auth.account_locked",
+		ResourceType: "user",
+		ResourceID:   user.ID.Hex(),
+		Outcome:      "denied",
+		IPAddress:    clientIP,
+		Details:      map[string]interface{}{"locked_until": until, "lockout_count": streak + 1},
+	})
+
+	return &AccountLockedError{Until: until}
+}
+
+// ClearFailedLogins resets the user's failure count and lockout streak
+func (ss *SecurityPolicyService) ClearFailedLogins(ctx context.Context, userID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := ss.collections.Users().UpdateOne(ctx,
+		bson.M{"_id": userID},
+		bson.M{"$unset": bson.M{
+			"failed_logins":        "",
+			"last_failed_login_at": "",
+			"lockout_count":        "",
+			"locked_until":         "",
+		}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to clear failed logins: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("user not found")
+	}
+
+	return nil
+}
+
+// containsPersonalInfo reports whether the password contains the user's
+// username, email name or first/last name
+func containsPersonalInfo(password string, user *models.User) bool {
+	lower := strings.ToLower(password)
+	localPart, _, _ := strings.Cut(user.Email, "@")
+
+	for _, info := range []string{user.Username, localPart, user.FirstName, user.LastName} {
+		info = strings.ToLower(strings.TrimSpace(info))
+		if len(info) >= 3 && strings.Contains(lower, info) {
+			return true
+		}
+	}
+	return false
+}
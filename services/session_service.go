@@ -0,0 +1,354 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"oncloud/models"
+	"oncloud/utils"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// sessionTouchInterval limits how often last_activity is written per session
+const sessionTouchInterval = time.Minute
+
+var (
+	ErrSessionEnded          = errors.New("session has ended")
+	ErrRefreshTokenReused    = errors.New("refresh token has already been used")
+	ErrSessionDeviceMismatch = errors.New("refresh token belongs to another device")
+	ErrTokenRevoked          = errors.New("token has been revoked")
+)
+
+// SessionOptions configures how long sessions last and what they are bound to
+type SessionOptions struct {
+	// MaxLifetime is how long a session can be kept going by refreshing its
+	// tokens, however active it is. Zero lets sessions slide forever.
+	MaxLifetime time.Duration
+	// BindDevice turns away refresh tokens presented by a device other than
+	// the one the session was started on
+	BindDevice bool
+}
+
+var sessionOptions = SessionOptions{
+	MaxLifetime: 30 * 24 * time.Hour,
+	BindDevice:  true,
+}
+
+// InitSessions sets how long sessions last and whether they are bound to
+// their device
+func InitSessions(opts SessionOptions) {
+	sessionOptions = opts
+}
+
+type SessionService struct {
+	*BaseService
+}
+
+func NewSessionService() *SessionService {
+	return NewSessionServiceWith(Dependencies{})
+}
+
+// NewSessionServiceWith creates a session service on the given dependencies
+func NewSessionServiceWith(deps Dependencies) *SessionService {
+	return &SessionService{
+		BaseService: NewBaseServiceWith(deps),
+	}
+}
+
+// DeviceFingerprint identifies the device a request comes from, by the ID
+// clients may send in X-Device-ID or else by the browser and system its user
+// agent names, which don't change with their versions
+func DeviceFingerprint(deviceID, userAgent string) string {
+	if deviceID != "" {
+		return utils.HashSHA256("device:" + deviceID)
+	}
+	return utils.HashSHA256("agent:" + utils.DescribeUserAgent(userAgent))
+}
+
+// StartSession records a new signed-in device for the user and issues the
+// tokens bound to it
+func (ss *SessionService) StartSession(ctx context.Context, user *models.User, clientIP, userAgent, deviceID string) (*models.Session, *utils.TokenPair, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	sessionID, err := utils.GenerateSecureToken(32)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate session ID: %v", err)
+	}
+	tokens, err := utils.GenerateTokenPair(user.ID, user.Email, user.Username, "user", user.PlanID, sessionID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate tokens: %v", err)
+	}
+
+	location := utils.LocateClient(clientIP)
+	now := time.Now()
+	session := &models.Session{
+		ID:                primitive.NewObjectID(),
+		SessionID:         sessionID,
+		RefreshTokenID:    tokens.RefreshTokenID,
+		DeviceFingerprint: DeviceFingerprint(deviceID, userAgent),
+		UserID:            user.ID,
+		IPAddress:         location.IP,
+		Country:           location.Country,
+		Region:            location.Region,
+		UserAgent:         userAgent,
+		Device:            utils.DescribeUserAgent(userAgent),
+		IsActive:          true,
+		CreatedAt:         now,
+		LastActivity:      now,
+		ExpiresAt:         sessionExpiry(now, now),
+	}
+
+	if _, err := ss.collections.Sessions().InsertOne(ctx, session); err != nil {
+		return nil, nil, fmt.Errorf("failed to create session: %v", err)
+	}
+
+	return session, tokens, nil
+}
+
+// ValidateSession checks that the session behind a token is still active and
+// records activity on it
+func (ss *SessionService) ValidateSession(ctx context.Context, sessionID string, userID primitive.ObjectID) (*models.Session, error) {
+	if sessionID == "" {
+		return nil, ErrSessionEnded
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var session models.Session
+	err := ss.collections.Sessions().FindOne(ctx, bson.M{"session_id": sessionID, "user_id": userID}).Decode(&session)
+	if err != nil {
+		return nil, ErrSessionEnded
+	}
+
+	now := time.Now()
+	if !session.IsActive || now.After(session.ExpiresAt) {
+		return nil, ErrSessionEnded
+	}
+
+	if now.Sub(session.LastActivity) > sessionTouchInterval {
+		ss.collections.Sessions().UpdateOne(ctx,
+			bson.M{"_id": session.ID},
+			bson.M{"$set": bson.M{"last_activity": now}},
+		)
+		session.LastActivity = now
+	}
+
+	return &session, nil
+}
+
+// ValidateToken checks that an access token hasn't been revoked and that its
+// session is still active
+func (ss *SessionService) ValidateToken(ctx context.Context, claims *utils.Claims, userID primitive.ObjectID) (*models.Session, error) {
+	if ss.IsTokenRevoked(ctx, claims.ID) {
+		return nil, ErrTokenRevoked
+	}
+	return ss.ValidateSession(ctx, claims.SessionID, userID)
+}
+
+// RefreshSession issues new tokens for the session a refresh token belongs
+// to, pushing back its expiry up to its maximum lifetime. The refresh token
+// is rotated: presenting one the session has already replaced means it
+// leaked, so the session is ended, as it is when the token comes from
+// another device than the session's.
+func (ss *SessionService) RefreshSession(ctx context.Context, claims *utils.Claims, user *models.User, fingerprint string) (*utils.TokenPair, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var session models.Session
+	err := ss.collections.Sessions().FindOne(ctx, bson.M{"session_id": claims.SessionID, "user_id": user.ID}).Decode(&session)
+	if err != nil {
+		return nil, ErrSessionEnded
+	}
+	now := time.Now()
+	if !session.IsActive || now.After(session.ExpiresAt) {
+		return nil, ErrSessionEnded
+	}
+
+	if session.RefreshTokenID != claims.ID {
+		ss.endSessions(ctx, bson.M{"_id": session.ID}, "refresh_token_reused")
+		return nil, ErrRefreshTokenReused
+	}
+	if sessionOptions.BindDevice && session.DeviceFingerprint != "" && session.DeviceFingerprint != fingerprint {
+		ss.endSessions(ctx, bson.M{"_id": session.ID}, "device_mismatch")
+		return nil, ErrSessionDeviceMismatch
+	}
+
+	tokens, err := utils.GenerateTokenPair(user.ID, user.Email, user.Username, "user", user.PlanID, session.SessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate tokens: %v", err)
+	}
+
+	set := bson.M{
+		"refresh_token_id": tokens.RefreshTokenID,
+		"expires_at":       sessionExpiry(session.CreatedAt, now),
+		"last_activity":    now,
+		"refreshed_at":     now,
+	}
+	// Sessions started before they were bound to their device are bound to
+	// the first one refreshing them
+	if session.DeviceFingerprint == "" {
+		set["device_fingerprint"] = fingerprint
+	}
+	var currentTokenID interface{} = claims.ID
+	if claims.ID == "" {
+		currentTokenID = nil
+	}
+
+	// A concurrent refresh with the same token rotates it first
+	result, err := ss.collections.Sessions().UpdateOne(ctx,
+		bson.M{"_id": session.ID, "is_active": true, "refresh_token_id": currentTokenID},
+		bson.M{"$set": set},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to refresh session: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		ss.endSessions(ctx, bson.M{"_id": session.ID}, "refresh_token_reused")
+		return nil, ErrRefreshTokenReused
+	}
+
+	return tokens, nil
+}
+
+// RevokeToken adds an access token to the revocation list, so it is turned
+// away until it expires
+func (ss *SessionService) RevokeToken(ctx context.Context, claims *utils.Claims, reason string) error {
+	if claims.ID == "" || claims.ExpiresAt == nil {
+		return errors.New("token cannot be revoked on its own")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	_, err := ss.collections.RevokedTokens().InsertOne(ctx, &models.RevokedToken{
+		TokenID:   claims.ID,
+		UserID:    claims.UserID,
+		SessionID: claims.SessionID,
+		Reason:    reason,
+		RevokedAt: now,
+		ExpiresAt: claims.ExpiresAt.Time,
+	})
+	// Revoking a token twice is not an error
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to revoke token: %v", err)
+	}
+	return nil
+}
+
+// IsTokenRevoked reports whether the access token with tokenID is on the
+// revocation list
+func (ss *SessionService) IsTokenRevoked(ctx context.Context, tokenID string) bool {
+	if tokenID == "" {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	count, err := ss.collections.RevokedTokens().CountDocuments(ctx, bson.M{"token_id": tokenID}, options.Count().SetLimit(1))
+	return err == nil && count > 0
+}
+
+// sessionExpiry is when a session started at startedAt and used at now
+// expires if it isn't refreshed again
+func sessionExpiry(startedAt, now time.Time) time.Time {
+	expiresAt := now.Add(utils.RefreshTokenTTL())
+	if sessionOptions.MaxLifetime > 0 {
+		if limit := startedAt.Add(sessionOptions.MaxLifetime); limit.Before(expiresAt) {
+			return limit
+		}
+	}
+	return expiresAt
+}
+
+// GetActiveSessions lists the user's signed-in devices, flagging the one
+// identified by currentSessionID
+func (ss *SessionService) GetActiveSessions(ctx context.Context, userID primitive.ObjectID, currentSessionID string) ([]models.Session, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	cursor, err := ss.collections.Sessions().Find(ctx,
+		bson.M{
+			"user_id":    userID,
+			"is_active":  true,
+			"expires_at": bson.M{"$gt": time.Now()},
+		},
+		options.Find().SetSort(bson.M{"last_activity": -1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	sessions := []models.Session{}
+	if err = cursor.All(ctx, &sessions); err != nil {
+		return nil, err
+	}
+
+	for i := range sessions {
+		sessions[i].IsCurrent = sessions[i].SessionID == currentSessionID
+	}
+
+	return sessions, nil
+}
+
+// EndSession ends the session a token belongs to, e.g. on logout
+func (ss *SessionService) EndSession(ctx context.Context, sessionID string, userID primitive.ObjectID, reason string) error {
+	_, err := ss.endSessions(ctx, bson.M{"session_id": sessionID, "user_id": userID}, reason)
+	return err
+}
+
+// RevokeSession ends one of the user's sessions by its ID
+func (ss *SessionService) RevokeSession(ctx context.Context, userID, sessionObjID primitive.ObjectID) error {
+	ended, err := ss.endSessions(ctx, bson.M{"_id": sessionObjID, "user_id": userID}, "revoked")
+	if err != nil {
+		return err
+	}
+	if ended == 0 {
+		return errors.New("session not found")
+	}
+
+	return nil
+}
+
+// RevokeOtherSessions ends every session of the user except keepSessionID.
+// An empty keepSessionID ends all of them.
+func (ss *SessionService) RevokeOtherSessions(ctx context.Context, userID primitive.ObjectID, keepSessionID, reason string) (int64, error) {
+	filter := bson.M{"user_id": userID}
+	if keepSessionID != "" {
+		filter["session_id"] = bson.M{"$ne": keepSessionID}
+	}
+
+	return ss.endSessions(ctx, filter, reason)
+}
+
+func (ss *SessionService) endSessions(ctx context.Context, filter bson.M, reason string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	filter["is_active"] = true
+	now := time.Now()
+	result, err := ss.collections.Sessions().UpdateMany(ctx, filter,
+		bson.M{"$set": bson.M{
+			"is_active":  false,
+			"ended_at":   now,
+			"end_reason": reason,
+		}},
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to end sessions: %v", err)
+	}
+
+	return result.ModifiedCount, nil
+}
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"oncloud/models"
+	"oncloud/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const testUserAgent = "Mozilla/5.0 (Macintosh; Intel Mac OS X 14_4) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Safari/605.1.15"
+
+// startTestSession signs a user in on a device-bound session and returns
+// the service, the user and the session's first tokens
+func startTestSession(t *testing.T) (*SessionService, *models.User, *utils.TokenPair) {
+	t.Helper()
+	db := testDatabase(t)
+
+	previous := sessionOptions
+	InitSessions(SessionOptions{MaxLifetime: 30 * 24 * time.Hour, BindDevice: true})
+	t.Cleanup(func() { InitSessions(previous) })
+
+	ss := NewSessionServiceWith(Dependencies{Database: testDatabaseSource{db}})
+	user := &models.User{ID: primitive.NewObjectID(), Email: "ada@example.com", Username: "ada", IsActive: true}
+	_, tokens, err := ss.StartSession(context.Background(), user, "203.0.113.7", testUserAgent, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ss, user, tokens
+}
+
+// refresh presents a refresh token from the given device
+func refresh(ss *SessionService, user *models.User, tokens *utils.TokenPair, fingerprint string) (*utils.TokenPair, error) {
+	claims, err := utils.ValidateRefreshToken(tokens.RefreshToken)
+	if err != nil {
+		return nil, err
+	}
+	return ss.RefreshSession(context.Background(), claims, user, fingerprint)
+}
+
+// storedSession returns the user's only session
+func storedSession(t *testing.T, ss *SessionService, user *models.User) models.Session {
+	t.Helper()
+	var session models.Session
+	if err := ss.collections.Sessions().FindOne(context.Background(), bson.M{"user_id": user.ID}).Decode(&session); err != nil {
+		t.Fatal(err)
+	}
+	return session
+}
+
+func TestRefreshSessionRotatesRefreshToken(t *testing.T) {
+	ss, user, first := startTestSession(t)
+	device := DeviceFingerprint("", testUserAgent)
+
+	second, err := refresh(ss, user, first, device)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.RefreshTokenID == first.RefreshTokenID {
+		t.Fatal("refresh token was not rotated")
+	}
+	if session := storedSession(t, ss, user); session.RefreshTokenID != second.RefreshTokenID || !session.IsActive {
+		t.Fatalf("session holds refresh token %s, active %v", session.RefreshTokenID, session.IsActive)
+	}
+
+	// The rotated token keeps the session going in turn
+	third, err := refresh(ss, user, second, device)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third.RefreshTokenID == second.RefreshTokenID {
+		t.Fatal("refresh token was not rotated again")
+	}
+}
+
+func TestRefreshSessionReplayEndsSession(t *testing.T) {
+	ss, user, first := startTestSession(t)
+	device := DeviceFingerprint("", testUserAgent)
+
+	second, err := refresh(ss, user, first, device)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Someone holding the replaced token presents it again
+	if _, err := refresh(ss, user, first, device); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("replayed token: %v, want ErrRefreshTokenReused", err)
+	}
+
+	// The whole session is ended, so the token issued to the rightful
+	// holder is turned away too
+	if _, err := refresh(ss, user, second, device); !errors.Is(err, ErrSessionEnded) {
+		t.Fatalf("latest token after replay: %v, want ErrSessionEnded", err)
+	}
+	if session := storedSession(t, ss, user); session.IsActive || session.EndReason != "refresh_token_reused" {
+		t.Fatalf("session active %v, ended for %q", session.IsActive, session.EndReason)
+	}
+}
+
+func TestRefreshSessionDeviceMismatchEndsSession(t *testing.T) {
+	ss, user, first := startTestSession(t)
+
+	other := DeviceFingerprint("android-3f9c2e", "okhttp/4.12.0")
+	if _, err := refresh(ss, user, first, other); !errors.Is(err, ErrSessionDeviceMismatch) {
+		t.Fatalf("token from another device: %v, want ErrSessionDeviceMismatch", err)
+	}
+
+	// The session's own device can't carry on with it either
+	if _, err := refresh(ss, user, first, DeviceFingerprint("", testUserAgent)); !errors.Is(err, ErrSessionEnded) {
+		t.Fatalf("own device after mismatch: %v, want ErrSessionEnded", err)
+	}
+	if session := storedSession(t, ss, user); session.IsActive || session.EndReason != "device_mismatch" {
+		t.Fatalf("session active %v, ended for %q", session.IsActive, session.EndReason)
+	}
+}
@@ -18,14 +18,15 @@ import (
 )
 
 type SettingsService struct {
-	settingsCollection       *mongo.Collection
-	userSettingsCollection   *mongo.Collection
-	settingsBackupCollection *mongo.Collection
-	userCollection           *mongo.Collection
-	planCollection           *mongo.Collection
-	cacheExpiry              time.Duration
-	cache                    map[string]interface{}
-	lastCacheUpdate          time.Time
+	settingsCollection        *mongo.Collection
+	userSettingsCollection    *mongo.Collection
+	settingsBackupCollection  *mongo.Collection
+	userCollection            *mongo.Collection
+	planCollection            *mongo.Collection
+	runtimeSettingsCollection *mongo.Collection
+	cacheExpiry               time.Duration
+	cache                     map[string]interface{}
+	lastCacheUpdate           time.Time
 }
 
 type SettingsBackup struct {
@@ -38,20 +39,28 @@ type SettingsBackup struct {
 }
 
 func NewSettingsService() *SettingsService {
+	return NewSettingsServiceWith(Dependencies{})
+}
+
+// NewSettingsServiceWith creates a settings service on the given
+// dependencies
+func NewSettingsServiceWith(deps Dependencies) *SettingsService {
+	db := deps.database()
 	return &SettingsService{
-		settingsCollection:       database.GetCollection("settings"),
-		userSettingsCollection:   database.GetCollection("user_settings"),
-		settingsBackupCollection: database.GetCollection("settings_backups"),
-		userCollection:           database.GetCollection("users"),
-		planCollection:           database.GetCollection("plans"),
-		cacheExpiry:              5 * time.Minute,
-		cache:                    make(map[string]interface{}),
+		settingsCollection:        db.GetCollection("settings"),
+		userSettingsCollection:    db.GetCollection("user_settings"),
+		settingsBackupCollection:  db.GetCollection("settings_backups"),
+		userCollection:            db.GetCollection("users"),
+		planCollection:            db.GetCollection("plans"),
+		runtimeSettingsCollection: db.GetCollection(database.RuntimeSettingsCollection),
+		cacheExpiry:               5 * time.Minute,
+		cache:                     make(map[string]interface{}),
 	}
 }
 
 // Admin Settings Management
-func (ss *SettingsService) GetSettings(group string, includePrivate bool) (map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (ss *SettingsService) GetSettings(ctx context.Context, group string, includePrivate bool) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	filter := bson.M{}
@@ -88,12 +97,12 @@ func (ss *SettingsService) GetSettings(group string, includePrivate bool) (map[s
 	return settings, nil
 }
 
-func (ss *SettingsService) GetSettingsByGroup(group string) (map[string]interface{}, error) {
-	return ss.GetSettings(group, true)
+func (ss *SettingsService) GetSettingsByGroup(ctx context.Context, group string) (map[string]interface{}, error) {
+	return ss.GetSettings(ctx, group, true)
 }
 
-func (ss *SettingsService) GetSettingGroups() ([]map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (ss *SettingsService) GetSettingGroups(ctx context.Context) ([]map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	pipeline := []bson.M{
@@ -119,7 +128,7 @@ func (ss *SettingsService) GetSettingGroups() ([]map[string]interface{}, error)
 	return groups, nil
 }
 
-func (ss *SettingsService) GetSetting(key string) (interface{}, error) {
+func (ss *SettingsService) GetSetting(ctx context.Context, key string) (interface{}, error) {
 	// Check cache first
 	if ss.isCacheValid() {
 		if value, exists := ss.cache[key]; exists {
@@ -127,7 +136,7 @@ func (ss *SettingsService) GetSetting(key string) (interface{}, error) {
 		}
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	var setting models.AdminSettings
@@ -143,8 +152,8 @@ func (ss *SettingsService) GetSetting(key string) (interface{}, error) {
 	return setting.Value, nil
 }
 
-func (ss *SettingsService) UpdateSetting(key string, value interface{}) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (ss *SettingsService) UpdateSetting(ctx context.Context, key string, value interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	// Validate the value based on setting type
@@ -181,19 +190,19 @@ func (ss *SettingsService) UpdateSetting(key string, value interface{}) error {
 	ss.lastCacheUpdate = time.Now()
 
 	// Handle special settings that require additional actions
-	if err := ss.handleSpecialSettings(key, value); err != nil {
+	if err := ss.handleSpecialSettings(ctx, key, value); err != nil {
 		return fmt.Errorf("failed to handle special setting %s: %v", key, err)
 	}
 
 	return nil
 }
 
-func (ss *SettingsService) UpdateSettings(settings map[string]interface{}) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+func (ss *SettingsService) UpdateSettings(ctx context.Context, settings map[string]interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
 	// Start transaction for atomic updates
-	session, err := database.GetClient().StartSession()
+	session, err := ss.settingsCollection.Database().Client().StartSession()
 	if err != nil {
 		return fmt.Errorf("failed to start session: %v", err)
 	}
@@ -201,7 +210,7 @@ func (ss *SettingsService) UpdateSettings(settings map[string]interface{}) error
 
 	callback := func(sessCtx mongo.SessionContext) (interface{}, error) {
 		for key, value := range settings {
-			err := ss.UpdateSetting(key, value)
+			err := ss.UpdateSetting(ctx, key, value)
 			if err != nil {
 				return nil, err
 			}
@@ -220,8 +229,8 @@ func (ss *SettingsService) UpdateSettings(settings map[string]interface{}) error
 	return nil
 }
 
-func (ss *SettingsService) CreateSetting(key, label, description, settingType, group string, value interface{}, isPublic bool, options []models.SettingOption, rules []string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (ss *SettingsService) CreateSetting(ctx context.Context, key, label, description, settingType, group string, value interface{}, isPublic bool, options []models.SettingOption, rules []string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	// Check if setting already exists
@@ -264,8 +273,8 @@ func (ss *SettingsService) CreateSetting(key, label, description, settingType, g
 	return nil
 }
 
-func (ss *SettingsService) DeleteSetting(key string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (ss *SettingsService) DeleteSetting(ctx context.Context, key string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	// Check if setting is protected
@@ -292,8 +301,8 @@ func (ss *SettingsService) DeleteSetting(key string) error {
 }
 
 // User Settings Management
-func (ss *SettingsService) GetUserSettings(userID primitive.ObjectID) (map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (ss *SettingsService) GetUserSettings(ctx context.Context, userID primitive.ObjectID) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	// Default user settings
@@ -308,6 +317,7 @@ func (ss *SettingsService) GetUserSettings(userID primitive.ObjectID) (map[strin
 		"two_factor_enabled":     false,
 		"storage_quota_alerts":   true,
 		"login_alerts":           true,
+		"login_verification":     true,
 		"security_notifications": true,
 		"marketing_emails":       false,
 		"data_export_format":     "json",
@@ -331,15 +341,15 @@ func (ss *SettingsService) GetUserSettings(userID primitive.ObjectID) (map[strin
 	return defaults, nil
 }
 
-func (ss *SettingsService) UpdateUserSettings(userID primitive.ObjectID, settings map[string]interface{}) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (ss *SettingsService) UpdateUserSettings(ctx context.Context, userID primitive.ObjectID, settings map[string]interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	// Validate settings
 	validKeys := []string{
 		"email_notifications", "push_notifications", "auto_sync", "public_profile",
 		"theme", "language", "timezone", "two_factor_enabled", "storage_quota_alerts",
-		"login_alerts", "security_notifications", "marketing_emails", "data_export_format",
+		"login_alerts", "login_verification", "security_notifications", "marketing_emails", "data_export_format",
 		"auto_backup", "file_versioning", "link_expiry_days",
 	}
 
@@ -377,15 +387,15 @@ func (ss *SettingsService) UpdateUserSettings(userID primitive.ObjectID, setting
 	}
 
 	// Handle special user settings
-	if err := ss.handleSpecialUserSettings(userID, settings); err != nil {
+	if err := ss.handleSpecialUserSettings(ctx, userID, settings); err != nil {
 		return fmt.Errorf("failed to handle special user settings: %v", err)
 	}
 
 	return nil
 }
 
-func (ss *SettingsService) ResetUserSettings(userID primitive.ObjectID) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (ss *SettingsService) ResetUserSettings(ctx context.Context, userID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	_, err := ss.userSettingsCollection.DeleteOne(ctx, bson.M{"user_id": userID})
@@ -393,12 +403,12 @@ func (ss *SettingsService) ResetUserSettings(userID primitive.ObjectID) error {
 }
 
 // Settings Backup and Restore
-func (ss *SettingsService) BackupSettings(name, description string) (*SettingsBackup, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (ss *SettingsService) BackupSettings(ctx context.Context, name, description string) (*SettingsBackup, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	// Get all current settings
-	settings, err := ss.GetSettings("", true)
+	settings, err := ss.GetSettings(ctx, "", true)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get current settings: %v", err)
 	}
@@ -419,8 +429,8 @@ func (ss *SettingsService) BackupSettings(name, description string) (*SettingsBa
 	return backup, nil
 }
 
-func (ss *SettingsService) RestoreSettings(backupID primitive.ObjectID) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+func (ss *SettingsService) RestoreSettings(ctx context.Context, backupID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
 	// Get backup
@@ -431,7 +441,7 @@ func (ss *SettingsService) RestoreSettings(backupID primitive.ObjectID) error {
 	}
 
 	// Start transaction
-	session, err := database.GetClient().StartSession()
+	session, err := ss.settingsCollection.Database().Client().StartSession()
 	if err != nil {
 		return fmt.Errorf("failed to start session: %v", err)
 	}
@@ -442,7 +452,7 @@ func (ss *SettingsService) RestoreSettings(backupID primitive.ObjectID) error {
 		for key, settingData := range backup.Settings {
 			if settingMap, ok := settingData.(map[string]interface{}); ok {
 				if value, exists := settingMap["value"]; exists {
-					err := ss.UpdateSetting(key, value)
+					err := ss.UpdateSetting(ctx, key, value)
 					if err != nil {
 						return nil, fmt.Errorf("failed to restore setting %s: %v", key, err)
 					}
@@ -463,8 +473,8 @@ func (ss *SettingsService) RestoreSettings(backupID primitive.ObjectID) error {
 	return nil
 }
 
-func (ss *SettingsService) GetBackups() ([]SettingsBackup, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (ss *SettingsService) GetBackups(ctx context.Context) ([]SettingsBackup, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	cursor, err := ss.settingsBackupCollection.Find(ctx, bson.M{},
@@ -483,8 +493,8 @@ func (ss *SettingsService) GetBackups() ([]SettingsBackup, error) {
 	return backups, nil
 }
 
-func (ss *SettingsService) DeleteBackup(backupID primitive.ObjectID) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (ss *SettingsService) DeleteBackup(ctx context.Context, backupID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	_, err := ss.settingsBackupCollection.DeleteOne(ctx, bson.M{"_id": backupID})
@@ -492,8 +502,8 @@ func (ss *SettingsService) DeleteBackup(backupID primitive.ObjectID) error {
 }
 
 // System Configuration
-func (ss *SettingsService) GetSystemSettings() (*models.SystemSettings, error) {
-	settings, err := ss.GetSettings("", false)
+func (ss *SettingsService) GetSystemSettings(ctx context.Context) (*models.SystemSettings, error) {
+	settings, err := ss.GetSettings(ctx, "", false)
 	if err != nil {
 		return nil, err
 	}
@@ -616,7 +626,7 @@ func (ss *SettingsService) validateSettingRules(rules []string, value interface{
 	}
 	return nil
 }
-func (ss *SettingsService) handleSpecialSettings(key string, value interface{}) error {
+func (ss *SettingsService) handleSpecialSettings(ctx context.Context, key string, value interface{}) error {
 	switch key {
 	case "maintenance_mode":
 		if enabled, ok := value.(bool); ok && enabled {
@@ -628,7 +638,7 @@ func (ss *SettingsService) handleSpecialSettings(key string, value interface{})
 		if planID, ok := value.(string); ok {
 			if utils.IsValidObjectID(planID) {
 				objID, _ := utils.StringToObjectID(planID)
-				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 				defer cancel()
 
 				count, err := ss.planCollection.CountDocuments(ctx, bson.M{"_id": objID, "is_active": true})
@@ -654,8 +664,8 @@ func (ss *SettingsService) handleSpecialSettings(key string, value interface{})
 	return nil
 }
 
-func (ss *SettingsService) handleSpecialUserSettings(userID primitive.ObjectID, settings map[string]interface{}) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (ss *SettingsService) handleSpecialUserSettings(ctx context.Context, userID primitive.ObjectID, settings map[string]interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	// Handle two-factor authentication
@@ -735,8 +745,8 @@ func (ps *SettingsService) clearCache() {
 	ps.lastCacheUpdate = time.Time{}
 }
 
-func (ps *SettingsService) preloadCache() error {
-	settings, err := ps.GetSettings("", false)
+func (ps *SettingsService) preloadCache(ctx context.Context) error {
+	settings, err := ps.GetSettings(ctx, "", false)
 	if err != nil {
 		return err
 	}
@@ -755,8 +765,8 @@ func (ps *SettingsService) preloadCache() error {
 }
 
 // Settings Import/Export
-func (ps *SettingsService) ExportSettings(group string) (map[string]interface{}, error) {
-	settings, err := ps.GetSettings(group, true)
+func (ps *SettingsService) ExportSettings(ctx context.Context, group string) (map[string]interface{}, error) {
+	settings, err := ps.GetSettings(ctx, group, true)
 	if err != nil {
 		return nil, err
 	}
@@ -771,7 +781,7 @@ func (ps *SettingsService) ExportSettings(group string) (map[string]interface{},
 	return export, nil
 }
 
-func (ps *SettingsService) ImportSettings(importData map[string]interface{}) error {
+func (ps *SettingsService) ImportSettings(ctx context.Context, importData map[string]interface{}) error {
 	settingsData, ok := importData["settings"].(map[string]interface{})
 	if !ok {
 		return fmt.Errorf("invalid import data format")
@@ -787,7 +797,7 @@ func (ps *SettingsService) ImportSettings(importData map[string]interface{}) err
 		}
 	}
 
-	return ps.UpdateSettings(settingsToImport)
+	return ps.UpdateSettings(ctx, settingsToImport)
 }
 
 // Settings Validation Helpers
@@ -952,18 +962,18 @@ func (ps *SettingsService) GetSettingTemplate(templateName string) (map[string]i
 	return template, nil
 }
 
-func (ps *SettingsService) ApplySettingTemplate(templateName string) error {
+func (ps *SettingsService) ApplySettingTemplate(ctx context.Context, templateName string) error {
 	template, err := ps.GetSettingTemplate(templateName)
 	if err != nil {
 		return err
 	}
 
-	return ps.UpdateSettings(template)
+	return ps.UpdateSettings(ctx, template)
 }
 
 // Settings Health Check
-func (ps *SettingsService) HealthCheck() (map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (ps *SettingsService) HealthCheck(ctx context.Context) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	health := map[string]interface{}{
@@ -986,7 +996,7 @@ func (ps *SettingsService) HealthCheck() (map[string]interface{}, error) {
 	// Check critical settings
 	criticalSettings := []string{"site_name", "default_plan_id", "allow_registration"}
 	for _, setting := range criticalSettings {
-		_, err := ps.GetSetting(setting)
+		_, err := ps.GetSetting(ctx, setting)
 		if err != nil {
 			health["status"] = "degraded"
 			health["issues"] = append(health["issues"].([]string),
@@ -995,7 +1005,7 @@ func (ps *SettingsService) HealthCheck() (map[string]interface{}, error) {
 	}
 
 	// Check database connection
-	err = database.GetCollection("settings").Database().Client().Ping(ctx, nil)
+	err = ps.settingsCollection.Database().Client().Ping(ctx, nil)
 	if err != nil {
 		health["status"] = "unhealthy"
 		health["issues"] = append(health["issues"].([]string), "Database connection failed")
@@ -1005,8 +1015,8 @@ func (ps *SettingsService) HealthCheck() (map[string]interface{}, error) {
 }
 
 // Settings Monitoring
-func (ps *SettingsService) GetSettingsStats() (map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (ps *SettingsService) GetSettingsStats(ctx context.Context) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	// Count settings by group
@@ -1071,22 +1081,22 @@ func (ps *SettingsService) GetSettingsStats() (map[string]interface{}, error) {
 }
 
 // Settings Migration
-func (ps *SettingsService) MigrateSettings(fromVersion, toVersion string) error {
+func (ps *SettingsService) MigrateSettings(ctx context.Context, fromVersion, toVersion string) error {
 	// Settings migration logic for version upgrades
-	migrations := map[string]func() error{
+	migrations := map[string]func(context.Context) error{
 		"1.0_to_1.1": ps.migrateV1ToV11,
 		"1.1_to_1.2": ps.migrateV11ToV12,
 	}
 
 	migrationKey := fmt.Sprintf("%s_to_%s", fromVersion, toVersion)
 	if migration, exists := migrations[migrationKey]; exists {
-		return migration()
+		return migration(ctx)
 	}
 
 	return fmt.Errorf("no migration available from %s to %s", fromVersion, toVersion)
 }
 
-func (ps *SettingsService) migrateV1ToV11() error {
+func (ps *SettingsService) migrateV1ToV11(ctx context.Context) error {
 	// Example migration: Add new settings for v1.1
 	newSettings := map[string]interface{}{
 		"api_rate_limit":   1000,
@@ -1095,7 +1105,7 @@ func (ps *SettingsService) migrateV1ToV11() error {
 	}
 
 	for key, value := range newSettings {
-		err := ps.CreateSetting(key, strings.Title(strings.ReplaceAll(key, "_", " ")),
+		err := ps.CreateSetting(ctx, key, strings.Title(strings.ReplaceAll(key, "_", " ")),
 			"", "int", "api", value, false, nil, nil)
 		if err != nil && !strings.Contains(err.Error(), "already exists") {
 			return err
@@ -1105,25 +1115,25 @@ func (ps *SettingsService) migrateV1ToV11() error {
 	return nil
 }
 
-func (ps *SettingsService) migrateV11ToV12() error {
+func (ps *SettingsService) migrateV11ToV12(ctx context.Context) error {
 	// Example migration: Update existing settings for v1.2
 	updates := map[string]interface{}{
 		"max_upload_size": 209715200, // Increase to 200MB
 	}
 
-	return ps.UpdateSettings(updates)
+	return ps.UpdateSettings(ctx, updates)
 }
 
 // Settings Synchronization
-func (ps *SettingsService) SyncSettings() error {
+func (ps *SettingsService) SyncSettings(ctx context.Context) error {
 	// Force reload all settings from database
 	ps.clearCache()
-	return ps.preloadCache()
+	return ps.preloadCache(ctx)
 }
 
 // Settings Cleanup
-func (ps *SettingsService) CleanupOrphanedSettings() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+func (ps *SettingsService) CleanupOrphanedSettings(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
 	// Remove settings that are no longer used
@@ -0,0 +1,396 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"oncloud/models"
+	"oncloud/utils"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultShareEmbedTTL = 24 * time.Hour
+	maxShareReferrers    = 20
+)
+
+var (
+	ErrShareEmbedNotFound = notFoundError("embed not found")
+	ErrShareEmbedMedia    = errors.New("only images and videos can be embedded")
+	ErrShareEmbedPassword = errors.New("password protected shares cannot be embedded")
+	ErrShareEmbedReceipts = errors.New("files with download receipts cannot be embedded")
+	// ErrShareEmbedWatermarked is returned for files their share watermarks,
+	// as embeds have no recipient to watermark them for
+	ErrShareEmbedWatermarked = errors.New("watermarked shares cannot be embedded")
+	ErrShareEmbedViewOnly    = errors.New("view-only shares cannot be embedded")
+	ErrShareEmbedReferrer    = errors.New("allowed referrers must be host names, or *. followed by a host name")
+	// ErrShareEmbedHotlink is returned when an embed is requested from a
+	// site its share does not allow
+	ErrShareEmbedHotlink = forbiddenError("embedding is not allowed from this site")
+)
+
+var embedReferrerPattern = regexp.MustCompile(`^(\*\.)?([a-z0-9]([a-z0-9-]*[a-z0-9])?\.)*[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+type ShareEmbedService struct {
+	*BaseService
+	hotFileService *HotFileCacheService
+}
+
+func NewShareEmbedService() *ShareEmbedService {
+	return NewShareEmbedServiceWith(Dependencies{})
+}
+
+// NewShareEmbedServiceWith creates a share embed service on the given
+// dependencies
+func NewShareEmbedServiceWith(deps Dependencies) *ShareEmbedService {
+	return &ShareEmbedService{
+		BaseService:    NewBaseServiceWith(deps),
+		hotFileService: NewHotFileCacheServiceWith(deps),
+	}
+}
+
+// SetEmbed sets the hotlink protection of the embeds of the user's share of
+// a file
+func (ses *ShareEmbedService) SetEmbed(ctx context.Context, userID, fileID primitive.ObjectID, req *models.ShareEmbedRequest) (*models.FileShare, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	embed := &models.ShareEmbed{BlockEmptyReferrer: req.BlockEmptyReferrer}
+	seen := make(map[string]bool)
+	for _, referrer := range req.AllowedReferrers {
+		referrer = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(referrer)), ".")
+		if !embedReferrerPattern.MatchString(referrer) {
+			return nil, fmt.Errorf("%w: %q", ErrShareEmbedReferrer, referrer)
+		}
+		if !seen[referrer] {
+			seen[referrer] = true
+			embed.AllowedReferrers = append(embed.AllowedReferrers, referrer)
+		}
+	}
+
+	var share models.FileShare
+	err := ses.collections.FileShares().FindOneAndUpdate(ctx,
+		bson.M{"file_id": fileID, "user_id": userID, "is_active": true},
+		bson.M{"$set": bson.M{"embed": embed}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&share)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrShareLinkNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update share: %v", err)
+	}
+
+	return &share, nil
+}
+
+// CreateEmbedURL signs a URL embedding the user's shared image or video,
+// valid for ttl but never beyond the share's own expiry
+func (ses *ShareEmbedService) CreateEmbedURL(ctx context.Context, userID, fileID primitive.ObjectID, ttl time.Duration) (*models.ShareEmbedURL, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	share, err := ses.userShare(ctx, userID, fileID)
+	if err != nil {
+		return nil, err
+	}
+	file, err := ses.embeddableFile(ctx, share)
+	if err != nil {
+		return nil, err
+	}
+
+	if ttl <= 0 {
+		ttl = defaultShareEmbedTTL
+	}
+	expiresAt := ses.clock.Now().Add(ttl)
+	if share.ExpiresAt != nil && share.ExpiresAt.Before(expiresAt) {
+		expiresAt = *share.ExpiresAt
+	}
+
+	token, err := utils.GenerateEmbedToken(share.ID, file.ID, expiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign embed token: %v", err)
+	}
+
+	baseURL := os.Getenv("BASE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:8080"
+	}
+	embedURL := fmt.Sprintf("%s/api/v1/embed/%s", baseURL, token)
+
+	tag := fmt.Sprintf(`<img src="%s" alt="%s">`, html.EscapeString(embedURL), html.EscapeString(fileDisplayName(file)))
+	if strings.HasPrefix(file.MimeType, "video/") {
+		tag = fmt.Sprintf(`<video src="%s" controls></video>`, html.EscapeString(embedURL))
+	}
+
+	return &models.ShareEmbedURL{
+		EmbedURL:  embedURL,
+		HTML:      tag,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// ServeEmbed streams the shared image or video behind an embed token to the
+// sites its share allows, honouring Range so videos can seek, and counts the
+// view or the blocked hotlink
+func (ses *ShareEmbedService) ServeEmbed(ctx context.Context, tokenString string, w http.ResponseWriter, r *http.Request) error {
+	claims, err := utils.ValidateEmbedToken(tokenString)
+	if err != nil {
+		return ErrShareEmbedNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var share models.FileShare
+	err = ses.collections.FileShares().FindOne(ctx, bson.M{
+		"_id":       claims.ShareID,
+		"file_id":   claims.FileID,
+		"is_active": true,
+	}).Decode(&share)
+	if err != nil {
+		return ErrShareEmbedNotFound
+	}
+	file, err := ses.embeddableFile(ctx, &share)
+	if err != nil {
+		return err
+	}
+
+	referrer, allowed := embedReferrerAllowed(share.Embed, r.Referer())
+	if !allowed {
+		ses.recordEmbedView(ctx, &share, referrer, false)
+		return ErrShareEmbedHotlink
+	}
+
+	content, err := ses.hotFileService.Content(ctx, file)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	w.Header().Set("ETag", utils.FileETag(file, ""))
+	w.Header().Set("Content-Type", file.MimeType)
+	w.Header().Set("Content-Disposition", utils.ContentDisposition("inline", file.OriginalName))
+	w.Header().Set("Cross-Origin-Resource-Policy", "cross-origin")
+	w.Header().Set("Cache-Control", "private, max-age=300")
+
+	counter := &countingResponseWriter{ResponseWriter: w}
+	http.ServeContent(counter, r, file.OriginalName, file.UpdatedAt, content)
+
+	// A video's later range requests belong to the view its first one counted
+	if r.Method == http.MethodGet && servedRangeStart(counter.Header()) == 0 {
+		ses.recordEmbedView(ctx, &share, referrer, true)
+		ses.hotFileService.RecordHit(file.ID)
+	}
+	if counter.written > 0 {
+		ses.collections.Users().UpdateOne(ctx,
+			bson.M{"_id": share.UserID},
+			bson.M{"$inc": bson.M{"bandwidth_used": counter.written}},
+		)
+	}
+	return nil
+}
+
+// GetAnalytics returns how the user's share of a file has been used, with
+// its embed views over the last days days
+func (ses *ShareEmbedService) GetAnalytics(ctx context.Context, userID, fileID primitive.ObjectID, days int) (*models.ShareAnalytics, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	share, err := ses.userShare(ctx, userID, fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	since := embedViewDay(ses.clock.Now()).AddDate(0, 0, -(days - 1))
+	match := bson.M{"$match": bson.M{"share_id": share.ID, "day": bson.M{"$gte": since}}}
+
+	analytics := &models.ShareAnalytics{
+		ShareID:         share.ID,
+		Downloads:       share.Downloads,
+		MaxDownloads:    share.MaxDownloads,
+		EmbedViews:      share.EmbedViews,
+		EmbedBlocked:    share.EmbedBlocked,
+		LastEmbedViewAt: share.LastEmbedViewAt,
+		Days:            []models.ShareEmbedDay{},
+		Referrers:       []models.ShareEmbedReferrer{},
+	}
+
+	cursor, err := ses.collections.ShareEmbedViews().Aggregate(ctx, []bson.M{
+		match,
+		{"$group": bson.M{
+			"_id":     bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": "$day"}},
+			"views":   bson.M{"$sum": "$views"},
+			"blocked": bson.M{"$sum": "$blocked"},
+		}},
+		{"$sort": bson.M{"_id": 1}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get embed views: %v", err)
+	}
+	if err := cursor.All(ctx, &analytics.Days); err != nil {
+		return nil, fmt.Errorf("failed to decode embed views: %v", err)
+	}
+
+	cursor, err = ses.collections.ShareEmbedViews().Aggregate(ctx, []bson.M{
+		match,
+		{"$group": bson.M{
+			"_id":     "$referrer",
+			"views":   bson.M{"$sum": "$views"},
+			"blocked": bson.M{"$sum": "$blocked"},
+		}},
+		{"$sort": bson.D{{Key: "views", Value: -1}, {Key: "blocked", Value: -1}}},
+		{"$limit": maxShareReferrers},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get embed referrers: %v", err)
+	}
+	if err := cursor.All(ctx, &analytics.Referrers); err != nil {
+		return nil, fmt.Errorf("failed to decode embed referrers: %v", err)
+	}
+
+	return analytics, nil
+}
+
+// userShare returns the user's active share of a file
+func (ses *ShareEmbedService) userShare(ctx context.Context, userID, fileID primitive.ObjectID) (*models.FileShare, error) {
+	var share models.FileShare
+	err := ses.collections.FileShares().FindOne(ctx, bson.M{
+		"file_id":   fileID,
+		"user_id":   userID,
+		"is_active": true,
+	}).Decode(&share)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrShareLinkNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get share: %v", err)
+	}
+	return &share, nil
+}
+
+// embeddableFile returns the file of a share that can be embedded: a public
+// image or video its share still serves
+func (ses *ShareEmbedService) embeddableFile(ctx context.Context, share *models.FileShare) (*models.File, error) {
+	if share.Password != "" {
+		return nil, ErrShareEmbedPassword
+	}
+	if share.ViewOnly {
+		return nil, ErrShareEmbedViewOnly
+	}
+	if share.ExpiresAt != nil && share.ExpiresAt.Before(ses.clock.Now()) {
+		return nil, ErrShareEmbedNotFound
+	}
+	if share.MaxDownloads > 0 && share.Downloads >= share.MaxDownloads {
+		return nil, ErrShareEmbedNotFound
+	}
+
+	var file models.File
+	err := ses.collections.Files().FindOne(ctx, bson.M{
+		"_id":        share.FileID,
+		"is_deleted": false,
+	}).Decode(&file)
+	if err != nil {
+		return nil, ErrShareEmbedNotFound
+	}
+
+	if !strings.HasPrefix(file.MimeType, "image/") && !strings.HasPrefix(file.MimeType, "video/") {
+		return nil, ErrShareEmbedMedia
+	}
+	if file.Receipts != nil {
+		return nil, ErrShareEmbedReceipts
+	}
+	if share.Watermark && Watermarkable(&file) {
+		return nil, ErrShareEmbedWatermarked
+	}
+	if err := checkTakedown(file.TakedownID); err != nil {
+		return nil, err
+	}
+	if err := checkFileExpiry(&file); err != nil {
+		return nil, err
+	}
+	if err := checkFileArchived(&file); err != nil {
+		return nil, err
+	}
+	if err := checkFileScan(&file); err != nil {
+		return nil, err
+	}
+
+	return &file, nil
+}
+
+// recordEmbedView counts an embed view, or a blocked hotlink, on the share
+// and on its day's counter for the referrer
+func (ses *ShareEmbedService) recordEmbedView(ctx context.Context, share *models.FileShare, referrer string, viewed bool) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	now := ses.clock.Now()
+	field := "blocked"
+	shareUpdate := bson.M{"$inc": bson.M{"embed_blocked": 1}}
+	if viewed {
+		field = "views"
+		shareUpdate = bson.M{
+			"$inc": bson.M{"embed_views": 1},
+			"$set": bson.M{"last_embed_view_at": now},
+		}
+	}
+
+	ses.collections.FileShares().UpdateOne(ctx, bson.M{"_id": share.ID}, shareUpdate)
+	ses.collections.ShareEmbedViews().UpdateOne(ctx,
+		bson.M{"share_id": share.ID, "day": embedViewDay(now), "referrer": referrer},
+		bson.M{
+			"$inc":         bson.M{field: 1},
+			"$setOnInsert": bson.M{"user_id": share.UserID},
+		},
+		options.Update().SetUpsert(true),
+	)
+}
+
+// embedReferrerAllowed returns the host of a Referer header, "" for none,
+// and whether embed's hotlink protection lets that site embed
+func embedReferrerAllowed(embed *models.ShareEmbed, referer string) (string, bool) {
+	host := ""
+	if referer != "" {
+		if parsed, err := url.Parse(referer); err == nil {
+			host = strings.ToLower(parsed.Hostname())
+		}
+	}
+
+	if embed == nil {
+		return host, true
+	}
+	if host == "" {
+		return host, !embed.BlockEmptyReferrer
+	}
+	if len(embed.AllowedReferrers) == 0 {
+		return host, true
+	}
+
+	for _, allowed := range embed.AllowedReferrers {
+		if suffix, ok := strings.CutPrefix(allowed, "*"); ok {
+			if strings.HasSuffix(host, suffix) {
+				return host, true
+			}
+		} else if host == allowed {
+			return host, true
+		}
+	}
+	return host, false
+}
+
+// embedViewDay is the UTC day embed views at t are counted on
+func embedViewDay(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
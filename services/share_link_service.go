@@ -0,0 +1,398 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"oncloud/database"
+	"oncloud/models"
+	"oncloud/utils"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The kinds of share links, whose shares are kept in separate collections
+const (
+	ShareKindFile   = "file"
+	ShareKindFolder = "folder"
+)
+
+var (
+	ErrShareLinkNotFound = notFoundError("share not found")
+	// ErrShareLinkPlan is returned when a user on a free plan sets a slug or
+	// a domain
+	ErrShareLinkPlan     = errors.New("custom share links require a paid plan")
+	ErrShareSlugInvalid  = errors.New("slug must be 3 to 64 lowercase letters, digits and dashes, starting and ending with a letter or digit")
+	ErrShareSlugReserved = errors.New("slug is reserved")
+	ErrShareSlugTaken    = errors.New("slug is already in use")
+	ErrShareDomainTaken  = errors.New("domain is already in use")
+	// ErrShareDomainUnverified is returned when the verification TXT record
+	// of a share domain cannot be found
+	ErrShareDomainUnverified = errors.New("domain verification record not found")
+	ErrShareDomainNotFound   = notFoundError("share domain not found")
+)
+
+var shareSlugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,62}[a-z0-9]$`)
+
+// reservedShareSlugs cannot be used as slugs, so short links never shadow
+// pages or look like they come from the service itself
+var reservedShareSlugs = map[string]bool{
+	"about": true, "account": true, "admin": true, "api": true, "app": true,
+	"auth": true, "billing": true, "blog": true, "dashboard": true, "docs": true,
+	"download": true, "downloads": true, "files": true, "folders": true,
+	"help": true, "home": true, "login": true, "logout": true, "oncloud": true,
+	"password": true, "plans": true, "pricing": true, "privacy": true,
+	"public": true, "register": true, "reset": true, "security": true,
+	"settings": true, "share": true, "shared": true, "signin": true,
+	"signup": true, "static": true, "status": true, "support": true,
+	"terms": true, "upload": true, "uploads": true, "www": true,
+}
+
+// shareDomainVerifyPrefix is the label the verification TXT record of a
+// share domain goes under
+const shareDomainVerifyPrefix = "_oncloud-verify."
+
+type ShareLinkService struct {
+	*BaseService
+	fileService *FileService
+}
+
+func NewShareLinkService() *ShareLinkService {
+	return NewShareLinkServiceWith(Dependencies{})
+}
+
+// NewShareLinkServiceWith creates a share link service on the given
+// dependencies
+func NewShareLinkServiceWith(deps Dependencies) *ShareLinkService {
+	return &ShareLinkService{
+		BaseService: NewBaseServiceWith(deps),
+		fileService: NewFileServiceWith(deps),
+	}
+}
+
+// shareCollection holds the shares of kind
+func shareCollection(kind string) *mongo.Collection {
+	if kind == ShareKindFolder {
+		return database.GetCollection("folder_shares")
+	}
+	return database.GetCollection("file_shares")
+}
+
+// SetSlug gives the active share of one of the user's files or folders a
+// custom name
+func (sls *ShareLinkService) SetSlug(ctx context.Context, userID, itemID primitive.ObjectID, kind, slug string) (*models.FileShare, error) {
+	if err := sls.checkPaidPlan(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	slug = strings.ToLower(strings.TrimSpace(slug))
+	if !shareSlugPattern.MatchString(slug) {
+		return nil, ErrShareSlugInvalid
+	}
+	if reservedShareSlugs[slug] {
+		return nil, ErrShareSlugReserved
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var share models.FileShare
+	err := shareCollection(kind).FindOne(ctx, bson.M{"file_id": itemID, "user_id": userID, "is_active": true}).Decode(&share)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrShareLinkNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get share: %v", err)
+	}
+	if share.Slug == slug {
+		return &share, nil
+	}
+
+	// Slugs and tokens share the /s/ namespace across both kinds of share
+	taken := bson.M{"_id": bson.M{"$ne": share.ID}, "$or": []bson.M{{"slug": slug}, {"token": slug}}}
+	for _, k := range []string{ShareKindFile, ShareKindFolder} {
+		count, err := shareCollection(k).CountDocuments(ctx, taken)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check slug: %v", err)
+		}
+		if count > 0 {
+			return nil, ErrShareSlugTaken
+		}
+	}
+
+	if _, err := shareCollection(kind).UpdateOne(ctx, bson.M{"_id": share.ID}, bson.M{"$set": bson.M{"slug": slug}}); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrShareSlugTaken
+		}
+		return nil, fmt.Errorf("failed to set slug: %v", err)
+	}
+
+	share.Slug = slug
+	return &share, nil
+}
+
+// ClearSlug removes the custom name of a share. Its token link keeps
+// working.
+func (sls *ShareLinkService) ClearSlug(ctx context.Context, userID, itemID primitive.ObjectID, kind string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := shareCollection(kind).UpdateOne(ctx,
+		bson.M{"file_id": itemID, "user_id": userID, "is_active": true},
+		bson.M{"$unset": bson.M{"slug": ""}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to clear slug: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrShareLinkNotFound
+	}
+	return nil
+}
+
+// GetDomain returns the domain the user's share links are served on
+func (sls *ShareLinkService) GetDomain(ctx context.Context, userID primitive.ObjectID) (*models.ShareDomain, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var domain models.ShareDomain
+	err := sls.collections.ShareDomains().FindOne(ctx, bson.M{"user_id": userID}).Decode(&domain)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrShareDomainNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get share domain: %v", err)
+	}
+	domain.VerificationHost = shareDomainVerifyPrefix + domain.Domain
+	return &domain, nil
+}
+
+// SetDomain sets the domain the user's share links are served on. Links
+// keep their current address until the domain is verified.
+func (sls *ShareLinkService) SetDomain(ctx context.Context, userID primitive.ObjectID, req *models.ShareDomainRequest) (*models.ShareDomain, error) {
+	if err := sls.checkPaidPlan(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	name := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(req.Domain)), ".")
+	if current, err := sls.GetDomain(ctx, userID); err == nil && current.Domain == name {
+		return current, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	// Only verifying a domain claims it, so nobody can hold one they do not
+	// control
+	if ownerID, ok := sls.domainOwner(ctx, name); ok && ownerID != userID {
+		return nil, ErrShareDomainTaken
+	}
+
+	token, err := utils.GenerateSecureToken(16)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate verification token: %v", err)
+	}
+
+	now := time.Now()
+	var domain models.ShareDomain
+	err = sls.collections.ShareDomains().FindOneAndUpdate(ctx,
+		bson.M{"user_id": userID},
+		bson.M{
+			"$set": bson.M{
+				"domain":             name,
+				"verification_token": token,
+				"updated_at":         now,
+			},
+			"$unset":       bson.M{"verified_at": ""},
+			"$setOnInsert": bson.M{"created_at": now},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&domain)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set share domain: %v", err)
+	}
+
+	domain.VerificationHost = shareDomainVerifyPrefix + domain.Domain
+	return &domain, nil
+}
+
+// VerifyDomain looks up the verification TXT record of the user's share
+// domain and, when it holds the verification token, starts using the domain
+func (sls *ShareLinkService) VerifyDomain(ctx context.Context, userID primitive.ObjectID) (*models.ShareDomain, error) {
+	domain, err := sls.GetDomain(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if domain.VerifiedAt != nil {
+		return domain, nil
+	}
+
+	records, err := net.LookupTXT(domain.VerificationHost)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrShareDomainUnverified, err)
+	}
+	found := false
+	for _, record := range records {
+		if strings.TrimSpace(record) == domain.VerificationToken {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, ErrShareDomainUnverified
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	if _, err := sls.collections.ShareDomains().UpdateOne(ctx,
+		bson.M{"_id": domain.ID, "verification_token": domain.VerificationToken},
+		bson.M{"$set": bson.M{"verified_at": now, "updated_at": now}},
+	); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			// Verified by another user first
+			return nil, ErrShareDomainTaken
+		}
+		return nil, fmt.Errorf("failed to verify share domain: %v", err)
+	}
+
+	domain.VerifiedAt = &now
+	return domain, nil
+}
+
+// DeleteDomain stops serving the user's share links on their domain
+func (sls *ShareLinkService) DeleteDomain(ctx context.Context, userID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := sls.collections.ShareDomains().DeleteOne(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return fmt.Errorf("failed to delete share domain: %v", err)
+	}
+	if result.DeletedCount == 0 {
+		return ErrShareDomainNotFound
+	}
+	return nil
+}
+
+// Resolve finds the active share a short link names, by slug or by token.
+// On a user's verified share domain only that user's shares are found.
+func (sls *ShareLinkService) Resolve(ctx context.Context, key, host string) (*models.FileShare, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"is_active": true}
+	if ownerID, ok := sls.domainOwner(ctx, host); ok {
+		filter["user_id"] = ownerID
+	}
+
+	for _, field := range []string{"slug", "token"} {
+		filter[field] = key
+		for _, kind := range []string{ShareKindFile, ShareKindFolder} {
+			var share models.FileShare
+			err := shareCollection(kind).FindOne(ctx, filter).Decode(&share)
+			if err == nil {
+				return &share, kind, nil
+			}
+			if err != mongo.ErrNoDocuments {
+				return nil, "", fmt.Errorf("failed to get share: %v", err)
+			}
+		}
+		delete(filter, field)
+	}
+	return nil, "", ErrShareLinkNotFound
+}
+
+// CanonicalURL returns the short link a token link of kind redirects to,
+// when its share has a slug or its owner a verified share domain, and ""
+// otherwise
+func (sls *ShareLinkService) CanonicalURL(ctx context.Context, kind, token string) string {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var share models.FileShare
+	if err := shareCollection(kind).FindOne(ctx, bson.M{"token": token, "is_active": true}).Decode(&share); err != nil {
+		return ""
+	}
+	if share.Slug == "" && verifiedShareDomain(ctx, share.UserID) == "" {
+		return ""
+	}
+	return ShareLinkURL(ctx, &share, kind)
+}
+
+// domainOwner returns the user whose verified share domain host is
+func (sls *ShareLinkService) domainOwner(ctx context.Context, host string) (primitive.ObjectID, bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	var domain models.ShareDomain
+	err := sls.collections.ShareDomains().FindOne(ctx, bson.M{
+		"domain":      strings.ToLower(host),
+		"verified_at": bson.M{"$exists": true},
+	}).Decode(&domain)
+	if err != nil {
+		return primitive.NilObjectID, false
+	}
+	return domain.UserID, true
+}
+
+func (sls *ShareLinkService) checkPaidPlan(ctx context.Context, userID primitive.ObjectID) error {
+	plan, err := sls.fileService.GetUserPlan(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to get user plan: %v", err)
+	}
+	if plan.IsFree || plan.Price <= 0 {
+		return ErrShareLinkPlan
+	}
+	return nil
+}
+
+// ShareLinkURL is the address of a share of kind: a short link on its
+// owner's verified share domain, a short link named by its slug, or its
+// token link
+func ShareLinkURL(ctx context.Context, share *models.FileShare, kind string) string {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	key := share.Token
+	if share.Slug != "" {
+		key = share.Slug
+	}
+	if domain := verifiedShareDomain(ctx, share.UserID); domain != "" {
+		return fmt.Sprintf("https://%s/s/%s", domain, key)
+	}
+
+	baseURL := os.Getenv("BASE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:8080"
+	}
+	if share.Slug != "" {
+		return fmt.Sprintf("%s/s/%s", baseURL, share.Slug)
+	}
+	if kind == ShareKindFolder {
+		return fmt.Sprintf("%s/shared/folder/%s", baseURL, share.Token)
+	}
+	return fmt.Sprintf("%s/shared/%s", baseURL, share.Token)
+}
+
+// verifiedShareDomain returns the user's verified share domain, or ""
+func verifiedShareDomain(ctx context.Context, userID primitive.ObjectID) string {
+	var domain models.ShareDomain
+	err := database.GetCollection(database.ShareDomainsCollection).FindOne(ctx, bson.M{
+		"user_id":     userID,
+		"verified_at": bson.M{"$exists": true},
+	}).Decode(&domain)
+	if err != nil {
+		return ""
+	}
+	return domain.Domain
+}
@@ -0,0 +1,199 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"oncloud/models"
+	"oncloud/utils"
+	"path"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// ErrShareRecipientRequired is returned when a watermarked share is
+	// downloaded without saying who by
+	ErrShareRecipientRequired = errors.New("an email address is required to download this file")
+	// ErrShareWatermarkFailed is returned when a file of a type shares
+	// watermark cannot be watermarked, so it is not served at all
+	ErrShareWatermarkFailed = errors.New("this file could not be watermarked")
+)
+
+type ShareWatermarkService struct {
+	*BaseService
+	storageService ObjectStore
+}
+
+func NewShareWatermarkService() *ShareWatermarkService {
+	return NewShareWatermarkServiceWith(Dependencies{})
+}
+
+// NewShareWatermarkServiceWith creates a share watermark service on the
+// given dependencies
+func NewShareWatermarkServiceWith(deps Dependencies) *ShareWatermarkService {
+	return &ShareWatermarkService{
+		BaseService:    NewBaseServiceWith(deps),
+		storageService: deps.storage(),
+	}
+}
+
+// Watermarkable reports whether shares that watermark burn the watermark
+// into file: PDFs and JPEG, PNG and GIF images. Other files are served as
+// they are.
+func Watermarkable(file *models.File) bool {
+	switch strings.ToLower(file.MimeType) {
+	case "application/pdf", "image/jpeg", "image/png", "image/gif":
+		return true
+	}
+	return false
+}
+
+// ServeShared serves the copy of a shared file watermarked for recipient,
+// making it on their first download and reusing it until the file changes,
+// and counts the download
+func (sws *ShareWatermarkService) ServeShared(ctx context.Context, share *models.FileShare, file *models.File, recipient string, downloader models.ReceiptDownloader, w http.ResponseWriter, r *http.Request) error {
+	if recipient == "" {
+		return ErrShareRecipientRequired
+	}
+	if err := checkFileScan(file); err != nil {
+		return err
+	}
+	if err := checkFileArchived(file); err != nil {
+		return err
+	}
+
+	watermarked, content, err := sws.watermarkedCopy(ctx, share, file, recipient)
+	if err != nil {
+		return err
+	}
+
+	// Count and receipt each download once, not each resumed range of it
+	counted := r.Method == http.MethodGet && parseRangeStart(r.Header.Get("Range"), int64(len(content))) == 0
+	if counted {
+		downloader.ShareID = share.ID.Hex()
+		downloader.Email = recipient
+		if _, err := NewDownloadReceiptService().IssueReceipt(ctx, file, downloader); err != nil {
+			return err
+		}
+	}
+
+	name := fileDisplayName(file)
+	if watermarked.ContentType != file.MimeType {
+		name = strings.TrimSuffix(name, path.Ext(name)) + ".png"
+	}
+	w.Header().Set("Content-Type", watermarked.ContentType)
+	w.Header().Set("Content-Disposition", utils.ContentDisposition("attachment", name))
+	w.Header().Set("Cache-Control", "private, no-store")
+	http.ServeContent(w, r, name, watermarked.CreatedAt, bytes.NewReader(content))
+
+	if counted {
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		sws.collections.FileShares().UpdateOne(ctx,
+			bson.M{"_id": share.ID},
+			bson.M{"$inc": bson.M{"downloads": 1}},
+		)
+	}
+	return nil
+}
+
+// watermarkedCopy returns the recipient's watermarked copy of a shared file
+// and its content, making it when there is none of the file's current
+// content
+func (sws *ShareWatermarkService) watermarkedCopy(ctx context.Context, share *models.FileShare, file *models.File, recipient string) (*models.ShareWatermark, []byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var cached models.ShareWatermark
+	err := sws.collections.ShareWatermarks().FindOne(ctx, bson.M{
+		"share_id":  share.ID,
+		"recipient": recipient,
+	}).Decode(&cached)
+	if err == nil && cached.FileHash == file.Hash {
+		content, err := sws.storageService.DownloadFile(ctx, cached.StorageProvider, cached.StorageKey)
+		if err == nil {
+			return &cached, content, nil
+		}
+		log.Printf("Failed to read watermarked copy %s: %v", cached.StorageKey, err)
+	}
+
+	original, err := sws.storageService.ReadFileContent(ctx, file)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get file content: %v", err)
+	}
+
+	now := time.Now().UTC()
+	lines := []string{recipient, now.Format("2006-01-02 15:04 MST")}
+	content, contentType := []byte(nil), file.MimeType
+	if strings.EqualFold(file.MimeType, "application/pdf") {
+		content, err = utils.WatermarkPDF(original, lines)
+	} else {
+		content, contentType, err = utils.WatermarkImage(original, lines)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrShareWatermarkFailed, err)
+	}
+
+	digest := sha256.Sum256([]byte(recipient))
+	watermarked := &models.ShareWatermark{
+		ShareID:         share.ID,
+		FileID:          file.ID,
+		Recipient:       recipient,
+		FileHash:        file.Hash,
+		StorageProvider: file.StorageProvider,
+		StorageKey:      fmt.Sprintf("watermarks/%s/%s", share.ID.Hex(), hex.EncodeToString(digest[:16])),
+		ContentType:     contentType,
+		Size:            int64(len(content)),
+		CreatedAt:       now,
+	}
+
+	// A copy that cannot be cached is still served, and made again next time
+	if err := sws.storageService.UploadFile(ctx, watermarked.StorageProvider, watermarked.StorageKey, content); err != nil {
+		log.Printf("Failed to cache watermarked copy of share %s: %v", share.ID.Hex(), err)
+		return watermarked, content, nil
+	}
+	_, err = sws.collections.ShareWatermarks().UpdateOne(ctx,
+		bson.M{"share_id": share.ID, "recipient": recipient},
+		bson.M{"$set": watermarked, "$setOnInsert": bson.M{"_id": primitive.NewObjectID()}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		log.Printf("Failed to record watermarked copy of share %s: %v", share.ID.Hex(), err)
+	}
+
+	return watermarked, content, nil
+}
+
+// PurgeShare deletes the watermarked copies made for a share
+func (sws *ShareWatermarkService) PurgeShare(ctx context.Context, shareID primitive.ObjectID) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	cursor, err := sws.collections.ShareWatermarks().Find(ctx, bson.M{"share_id": shareID})
+	if err != nil {
+		log.Printf("Failed to list watermarked copies of share %s: %v", shareID.Hex(), err)
+		return
+	}
+	var copies []models.ShareWatermark
+	if err := cursor.All(ctx, &copies); err != nil {
+		log.Printf("Failed to list watermarked copies of share %s: %v", shareID.Hex(), err)
+		return
+	}
+
+	for _, watermarked := range copies {
+		if err := sws.storageService.DeleteFile(ctx, watermarked.StorageProvider, watermarked.StorageKey); err != nil {
+			log.Printf("Failed to delete watermarked copy %s: %v", watermarked.StorageKey, err)
+		}
+	}
+	sws.collections.ShareWatermarks().DeleteMany(ctx, bson.M{"share_id": shareID})
+}
@@ -0,0 +1,347 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"oncloud/models"
+	"oncloud/storage"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// The status page is built at most this often, as it is public
+	statusPageTTL = 30 * time.Second
+
+	// Components not checked for this long, such as removed storage
+	// providers, are left off the status page
+	statusComponentStaleAfter = 24 * time.Hour
+
+	// Database pings slower than this report the database as degraded
+	statusSlowDatabase = time.Second
+)
+
+// StatusOptions configures the health checks behind the status page
+type StatusOptions struct {
+	// PaymentsURL is requested to check the payment processor can be
+	// reached; payments are left off the status page without it
+	PaymentsURL string
+	// Timeout bounds each check
+	Timeout time.Duration
+}
+
+var statusOptions = StatusOptions{Timeout: 10 * time.Second}
+
+// InitStatus sets how components are checked
+func InitStatus(opts StatusOptions) {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	statusOptions = opts
+}
+
+// statusPageCache holds the last status page built
+var statusPageCache struct {
+	sync.Mutex
+	page    *models.StatusPage
+	builtAt time.Time
+}
+
+// statusSeverity orders component statuses from best to worst
+var statusSeverity = map[string]int{
+	models.ComponentOperational: 0,
+	models.ComponentDegraded:    1,
+	models.ComponentDown:        2,
+}
+
+type StatusService struct {
+	*BaseService
+}
+
+func NewStatusService() *StatusService {
+	return NewStatusServiceWith(Dependencies{})
+}
+
+// NewStatusServiceWith creates a status service on the given dependencies
+func NewStatusServiceWith(deps Dependencies) *StatusService {
+	return &StatusService{
+		BaseService: NewBaseServiceWith(deps),
+	}
+}
+
+// RunChecks checks the API, the database, every active storage provider and
+// the payment processor, and records the results
+func (ss *StatusService) RunChecks(ctx context.Context) ([]models.HealthCheck, error) {
+	now := time.Now()
+	checks := []models.HealthCheck{
+		{Component: "api", Name: "API", Status: models.ComponentOperational},
+		ss.checkDatabase(ctx),
+	}
+
+	providers, err := ss.activeProviders(ctx)
+	if err != nil {
+		log.Printf("Failed to list storage providers for health checks: %v", err)
+	}
+	results := make([]models.HealthCheck, len(providers))
+	var wg sync.WaitGroup
+	for i := range providers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = ss.checkProvider(&providers[i])
+		}(i)
+	}
+	wg.Wait()
+	checks = append(checks, results...)
+
+	if statusOptions.PaymentsURL != "" {
+		checks = append(checks, ss.checkPayments(ctx))
+	}
+
+	documents := make([]interface{}, len(checks))
+	for i := range checks {
+		checks[i].ID = primitive.NewObjectID()
+		checks[i].CheckedAt = now
+		documents[i] = checks[i]
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if _, err := ss.collections.HealthChecks().InsertMany(ctx, documents); err != nil {
+		return checks, fmt.Errorf("failed to record health checks: %v", err)
+	}
+	return checks, nil
+}
+
+// StatusPage returns the current status of each component with its uptime
+// over the last day, week and month
+func (ss *StatusService) StatusPage(ctx context.Context) (*models.StatusPage, error) {
+	statusPageCache.Lock()
+	defer statusPageCache.Unlock()
+	if statusPageCache.page != nil && time.Since(statusPageCache.builtAt) < statusPageTTL {
+		return statusPageCache.page, nil
+	}
+
+	page, err := ss.buildStatusPage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	statusPageCache.page = page
+	statusPageCache.builtAt = time.Now()
+	return page, nil
+}
+
+func (ss *StatusService) buildStatusPage(ctx context.Context) (*models.StatusPage, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	day, week, month := now.Add(-24*time.Hour), now.Add(-7*24*time.Hour), now.Add(-30*24*time.Hour)
+	countSince := func(since time.Time, onlyUp bool) bson.M {
+		cond := []interface{}{bson.M{"$gte": []interface{}{"$checked_at", since}}}
+		if onlyUp {
+			cond = append(cond, bson.M{"$ne": []interface{}{"$status", models.ComponentDown}})
+		}
+		return bson.M{"$sum": bson.M{"$cond": []interface{}{bson.M{"$and": cond}, 1, 0}}}
+	}
+
+	cursor, err := ss.collections.HealthChecks().Aggregate(ctx, []bson.M{
+		{"$match": bson.M{"checked_at": bson.M{"$gte": month}}},
+		{"$sort": bson.M{"checked_at": -1}},
+		{"$group": bson.M{
+			"_id":             "$component",
+			"name":            bson.M{"$first": "$name"},
+			"status":          bson.M{"$first": "$status"},
+			"last_checked_at": bson.M{"$first": "$checked_at"},
+			"day_total":       countSince(day, false),
+			"day_up":          countSince(day, true),
+			"week_total":      countSince(week, false),
+			"week_up":         countSince(week, true),
+			"month_total":     countSince(month, false),
+			"month_up":        countSince(month, true),
+		}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute uptime: %v", err)
+	}
+
+	var rows []struct {
+		Component     string    `bson:"_id"`
+		Name          string    `bson:"name"`
+		Status        string    `bson:"status"`
+		LastCheckedAt time.Time `bson:"last_checked_at"`
+		DayTotal      int64     `bson:"day_total"`
+		DayUp         int64     `bson:"day_up"`
+		WeekTotal     int64     `bson:"week_total"`
+		WeekUp        int64     `bson:"week_up"`
+		MonthTotal    int64     `bson:"month_total"`
+		MonthUp       int64     `bson:"month_up"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, fmt.Errorf("failed to compute uptime: %v", err)
+	}
+
+	page := &models.StatusPage{
+		Status:     models.ComponentOperational,
+		Components: []models.ComponentStatus{},
+		UpdatedAt:  now,
+	}
+	for _, row := range rows {
+		if now.Sub(row.LastCheckedAt) > statusComponentStaleAfter {
+			continue
+		}
+		page.Components = append(page.Components, models.ComponentStatus{
+			ID:            row.Component,
+			Name:          row.Name,
+			Status:        row.Status,
+			LastCheckedAt: row.LastCheckedAt,
+			Uptime24h:     uptimePercent(row.DayUp, row.DayTotal),
+			Uptime7d:      uptimePercent(row.WeekUp, row.WeekTotal),
+			Uptime30d:     uptimePercent(row.MonthUp, row.MonthTotal),
+		})
+		if statusSeverity[row.Status] > statusSeverity[page.Status] {
+			page.Status = row.Status
+		}
+	}
+
+	// The API and database first, then storage providers and payments
+	sort.SliceStable(page.Components, func(i, j int) bool {
+		return componentOrder(page.Components[i].ID) < componentOrder(page.Components[j].ID) ||
+			componentOrder(page.Components[i].ID) == componentOrder(page.Components[j].ID) && page.Components[i].Name < page.Components[j].Name
+	})
+	return page, nil
+}
+
+func (ss *StatusService) checkDatabase(ctx context.Context) models.HealthCheck {
+	check := models.HealthCheck{Component: "database", Name: "Database", Status: models.ComponentOperational}
+
+	ctx, cancel := context.WithTimeout(ctx, statusOptions.Timeout)
+	defer cancel()
+
+	started := time.Now()
+	err := ss.collections.Users().Database().Client().Ping(ctx, nil)
+	latency := time.Since(started)
+	check.LatencyMs = latency.Milliseconds()
+	switch {
+	case err != nil:
+		check.Status = models.ComponentDown
+		check.Error = err.Error()
+	case latency > statusSlowDatabase:
+		check.Status = models.ComponentDegraded
+	}
+	return check
+}
+
+// checkProvider checks a storage provider can be reached. One reachable
+// while its circuit breaker is open is degraded, as calls to it are still
+// failing.
+func (ss *StatusService) checkProvider(provider *models.StorageProvider) models.HealthCheck {
+	check := models.HealthCheck{
+		Component: "storage:" + provider.ID.Hex(),
+		Name:      provider.Name,
+		Status:    models.ComponentOperational,
+	}
+
+	client, err := storage.NewStorageClient(provider)
+	if err != nil {
+		check.Status = models.ComponentDown
+		check.Error = err.Error()
+		return check
+	}
+
+	// Health checks of storage clients take no context, so they are only
+	// waited on until the timeout
+	started := time.Now()
+	result := make(chan error, 1)
+	go func() { result <- client.HealthCheck() }()
+	select {
+	case err = <-result:
+	case <-time.After(statusOptions.Timeout):
+		err = fmt.Errorf("health check timed out after %s", statusOptions.Timeout)
+	}
+	check.LatencyMs = time.Since(started).Milliseconds()
+
+	switch {
+	case err != nil:
+		check.Status = models.ComponentDown
+		check.Error = err.Error()
+	case providerBreakerState(provider.Type).State == BreakerOpen:
+		check.Status = models.ComponentDegraded
+	}
+	return check
+}
+
+// checkPayments checks the payment processor answers. Any answer short of
+// a server error means it is up.
+func (ss *StatusService) checkPayments(ctx context.Context) models.HealthCheck {
+	check := models.HealthCheck{Component: "payments", Name: "Payments", Status: models.ComponentOperational}
+
+	ctx, cancel := context.WithTimeout(ctx, statusOptions.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, statusOptions.PaymentsURL, nil)
+	if err != nil {
+		check.Status = models.ComponentDown
+		check.Error = err.Error()
+		return check
+	}
+
+	started := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	check.LatencyMs = time.Since(started).Milliseconds()
+	if err != nil {
+		check.Status = models.ComponentDown
+		check.Error = err.Error()
+		return check
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		check.Status = models.ComponentDown
+		check.Error = fmt.Sprintf("payment processor answered %d", resp.StatusCode)
+	}
+	return check
+}
+
+func (ss *StatusService) activeProviders(ctx context.Context) ([]models.StorageProvider, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	cursor, err := ss.collections.StorageProviders().Find(ctx, bson.M{"is_active": true})
+	if err != nil {
+		return nil, err
+	}
+	providers := []models.StorageProvider{}
+	if err := cursor.All(ctx, &providers); err != nil {
+		return nil, err
+	}
+	return providers, nil
+}
+
+// uptimePercent returns the share of checks a component was up in, rounded
+// to hundredths of a percent, or nil without checks
+func uptimePercent(up, total int64) *float64 {
+	if total == 0 {
+		return nil
+	}
+	percent := float64(up*10000/total) / 100
+	return &percent
+}
+
+func componentOrder(component string) int {
+	switch {
+	case component == "api":
+		return 0
+	case component == "database":
+		return 1
+	case component == "payments":
+		return 3
+	default:
+		return 2
+	}
+}
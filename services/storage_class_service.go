@@ -0,0 +1,365 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"oncloud/models"
+	"oncloud/storage"
+	"oncloud/utils"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// fileStorageClasses are the storage classes files can be kept in, for each
+// provider type which offers more than one. The first is the standard class.
+var fileStorageClasses = map[string][]string{
+	"s3": {"STANDARD", "STANDARD_IA", "ONEZONE_IA", "INTELLIGENT_TIERING", "GLACIER_IR", "GLACIER", "DEEP_ARCHIVE"},
+	"r2": {"STANDARD", "STANDARD_IA"},
+}
+
+const (
+	fileRestoreDefaultTier = "Standard"
+	// fileRestorePollBatch bounds the restores checked in one run
+	fileRestorePollBatch = 200
+)
+
+var (
+	ErrStorageClassFileNotFound = notFoundError("file not found")
+	ErrStorageClassUnsupported  = errors.New("storage provider does not offer storage classes")
+	// ErrInvalidStorageClass wraps any storage class or restore the provider
+	// does not offer
+	ErrInvalidStorageClass = errors.New("invalid storage class")
+	// ErrFileArchived is returned for archived files without a restored
+	// copy, which cannot be downloaded
+	ErrFileArchived    = errors.New("this file is archived and must be restored before it can be downloaded")
+	ErrFileNotArchived = errors.New("file is not archived")
+)
+
+// checkFileArchived returns ErrFileArchived if file is archived and has no
+// restored copy to download
+func checkFileArchived(file *models.File) error {
+	if !utils.SliceContains(archiveStorageClasses, file.StorageClass) {
+		return nil
+	}
+	if restore := file.Restore; restore != nil && restore.Status == models.FileRestoreCompleted &&
+		restore.RestoredUntil != nil && restore.RestoredUntil.After(time.Now()) {
+		return nil
+	}
+	return ErrFileArchived
+}
+
+// FileDownloadable reports whether file's content can be downloaded now,
+// which archived files can only while they have a restored copy
+func FileDownloadable(file *models.File) bool {
+	return checkFileArchived(file) == nil
+}
+
+// validateStorageClass returns the storage class a provider type names
+// class by, if it offers it
+func validateStorageClass(providerType, class string) (string, error) {
+	classes, ok := fileStorageClasses[providerType]
+	if !ok {
+		return "", ErrStorageClassUnsupported
+	}
+	class = strings.ToUpper(strings.TrimSpace(class))
+	if !utils.SliceContains(classes, class) {
+		return "", fmt.Errorf("%w: %s does not offer %s", ErrInvalidStorageClass, providerType, class)
+	}
+	return class, nil
+}
+
+type StorageClassService struct {
+	*BaseService
+	fileService *FileService
+}
+
+func NewStorageClassService() *StorageClassService {
+	return NewStorageClassServiceWith(Dependencies{})
+}
+
+// NewStorageClassServiceWith creates a storage class service on the given
+// dependencies
+func NewStorageClassServiceWith(deps Dependencies) *StorageClassService {
+	return &StorageClassService{
+		BaseService: NewBaseServiceWith(deps),
+		fileService: NewFileServiceWith(deps),
+	}
+}
+
+// GetStorageClasses returns the storage classes of the provider uploads are
+// stored with
+func (scs *StorageClassService) GetStorageClasses(ctx context.Context) (*models.ProviderStorageClasses, error) {
+	provider, err := scs.fileService.getDefaultStorageProvider(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get storage provider: %v", err)
+	}
+
+	result := &models.ProviderStorageClasses{Provider: provider.Type, Classes: []models.StorageClassOption{}}
+	for _, class := range fileStorageClasses[provider.Type] {
+		result.Classes = append(result.Classes, models.StorageClassOption{
+			Name:    class,
+			Archive: utils.SliceContains(archiveStorageClasses, class),
+		})
+	}
+	return result, nil
+}
+
+// UploadWithClass uploads content into a storage class of a provider
+func (scs *StorageClassService) UploadWithClass(ctx context.Context, providerType, storageKey string, content []byte, class string) error {
+	manager, err := scs.classManager(ctx, providerType)
+	if err != nil {
+		return err
+	}
+	return manager.UploadWithClass(storageKey, content, class)
+}
+
+// ChangeStorageClass moves one of the user's files to another storage
+// class. Archived files must be restored first, as only a readable copy
+// can be moved.
+func (scs *StorageClassService) ChangeStorageClass(ctx context.Context, userID, fileID primitive.ObjectID, class string) (*models.File, error) {
+	file, err := scs.fileService.GetUserFile(ctx, userID, fileID)
+	if err != nil {
+		return nil, ErrStorageClassFileNotFound
+	}
+	if err := checkFileLock(file, userID); err != nil {
+		return nil, err
+	}
+
+	class, err = validateStorageClass(file.StorageProvider, class)
+	if err != nil {
+		return nil, err
+	}
+	current := file.StorageClass
+	if current == "" {
+		current = fileStorageClasses[file.StorageProvider][0]
+	}
+	if class == current {
+		return file, nil
+	}
+	if err := checkFileArchived(file); err != nil {
+		return nil, err
+	}
+
+	manager, err := scs.classManager(ctx, file.StorageProvider)
+	if err != nil {
+		return nil, err
+	}
+	if err := manager.SetStorageClass(file.StorageKey, class); err != nil {
+		return nil, fmt.Errorf("failed to change storage class: %v", err)
+	}
+
+	return scs.updateFile(ctx, userID, fileID, bson.M{
+		"$set":   bson.M{"storage_class": class, "updated_at": time.Now()},
+		"$unset": bson.M{"restore": ""},
+	})
+}
+
+// RestoreFile starts restoring a readable copy of one of the user's
+// archived files for days. Restores take minutes to hours depending on the
+// tier; GetRestore reports when the copy is ready.
+func (scs *StorageClassService) RestoreFile(ctx context.Context, userID, fileID primitive.ObjectID, req *models.FileRestoreRequest) (*models.File, error) {
+	file, err := scs.fileService.GetUserFile(ctx, userID, fileID)
+	if err != nil {
+		return nil, ErrStorageClassFileNotFound
+	}
+	if !utils.SliceContains(archiveStorageClasses, file.StorageClass) {
+		return nil, ErrFileNotArchived
+	}
+	if file.Restore != nil && file.Restore.Status == models.FileRestoreInProgress {
+		return file, nil
+	}
+
+	tier := req.Tier
+	if tier == "" {
+		tier = fileRestoreDefaultTier
+	}
+	if tier == "Expedited" && file.StorageClass == "DEEP_ARCHIVE" {
+		return nil, fmt.Errorf("%w: DEEP_ARCHIVE cannot be restored at the Expedited tier", ErrInvalidStorageClass)
+	}
+
+	manager, err := scs.classManager(ctx, file.StorageProvider)
+	if err != nil {
+		return nil, err
+	}
+	if err := manager.RestoreObject(file.StorageKey, req.Days, tier); err != nil && !errors.Is(err, storage.ErrRestoreInProgress) {
+		return nil, fmt.Errorf("failed to restore file: %v", err)
+	}
+
+	return scs.updateFile(ctx, userID, fileID, bson.M{"$set": bson.M{
+		"restore": models.FileRestore{
+			Status:      models.FileRestoreInProgress,
+			Tier:        tier,
+			Days:        req.Days,
+			RequestedAt: time.Now(),
+		},
+		"updated_at": time.Now(),
+	}})
+}
+
+// GetRestore returns one of the user's files with the state of its restore
+// checked with the provider
+func (scs *StorageClassService) GetRestore(ctx context.Context, userID, fileID primitive.ObjectID) (*models.File, error) {
+	file, err := scs.fileService.GetUserFile(ctx, userID, fileID)
+	if err != nil {
+		return nil, ErrStorageClassFileNotFound
+	}
+	if file.Restore == nil || file.Restore.Status != models.FileRestoreInProgress {
+		return file, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if _, err := scs.refreshRestore(ctx, file); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// PollRestores checks the restores in progress with their providers,
+// notifying the owners of files whose restored copy is ready, and forgets
+// restored copies which have lapsed. It returns how many restores
+// completed.
+func (scs *StorageClassService) PollRestores(ctx context.Context) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	if _, err := scs.collections.Files().UpdateMany(ctx,
+		bson.M{"restore.status": models.FileRestoreCompleted, "restore.restored_until": bson.M{"$lte": time.Now()}},
+		bson.M{"$unset": bson.M{"restore": ""}},
+	); err != nil {
+		return 0, fmt.Errorf("failed to clear lapsed restores: %v", err)
+	}
+
+	cursor, err := scs.collections.Files().Find(ctx, bson.M{
+		"is_deleted":     false,
+		"restore.status": models.FileRestoreInProgress,
+	}, options.Find().SetSort(bson.D{{Key: "restore.requested_at", Value: 1}}).SetLimit(fileRestorePollBatch))
+	if err != nil {
+		return 0, fmt.Errorf("failed to find restores in progress: %v", err)
+	}
+	var files []models.File
+	if err := cursor.All(ctx, &files); err != nil {
+		return 0, fmt.Errorf("failed to find restores in progress: %v", err)
+	}
+
+	completed := 0
+	for i := range files {
+		file := &files[i]
+		done, err := scs.refreshRestore(ctx, file)
+		if err != nil {
+			log.Printf("Failed to check restore of file %s: %v", file.ID.Hex(), err)
+			continue
+		}
+		if !done {
+			continue
+		}
+
+		name := fileDisplayName(file)
+		scs.collections.Notifications().InsertOne(ctx, bson.M{
+			"_id":     primitive.NewObjectID(),
+			"user_id": file.UserID,
+			"type":    "file_restored",
+			"title":   name + " is restored",
+			"message": fmt.Sprintf("%s can be downloaded until %s.", name,
+				file.Restore.RestoredUntil.UTC().Format("January 2, 2006 15:04 MST")),
+			"data": bson.M{
+				"file_id":        file.ID,
+				"restored_until": file.Restore.RestoredUntil,
+			},
+			"is_read":    false,
+			"created_at": time.Now(),
+		})
+		completed++
+	}
+	return completed, nil
+}
+
+// refreshRestore updates a file's restore in progress from the provider,
+// reporting whether it has just completed
+func (scs *StorageClassService) refreshRestore(ctx context.Context, file *models.File) (bool, error) {
+	manager, err := scs.classManager(ctx, file.StorageProvider)
+	if err != nil {
+		return false, err
+	}
+	object, err := manager.GetObjectClass(file.StorageKey)
+	if err != nil {
+		return false, fmt.Errorf("failed to check restore: %v", err)
+	}
+	if object.Restoring {
+		return false, nil
+	}
+
+	filter := bson.M{"_id": file.ID, "restore.status": models.FileRestoreInProgress}
+	if object.RestoredUntil == nil || !object.RestoredUntil.After(time.Now()) {
+		// The restore was lost or its copy has lapsed already
+		_, err := scs.collections.Files().UpdateOne(ctx, filter, bson.M{"$unset": bson.M{"restore": ""}})
+		file.Restore = nil
+		return false, err
+	}
+
+	result, err := scs.collections.Files().UpdateOne(ctx, filter, bson.M{"$set": bson.M{
+		"restore.status":         models.FileRestoreCompleted,
+		"restore.restored_until": object.RestoredUntil,
+	}})
+	if err != nil {
+		return false, fmt.Errorf("failed to update restore: %v", err)
+	}
+	file.Restore.Status = models.FileRestoreCompleted
+	file.Restore.RestoredUntil = object.RestoredUntil
+	return result.ModifiedCount > 0, nil
+}
+
+// classManager returns the client managing the storage classes of an
+// active provider
+func (scs *StorageClassService) classManager(ctx context.Context, providerType string) (storage.StorageClassManager, error) {
+	if _, ok := fileStorageClasses[providerType]; !ok {
+		return nil, ErrStorageClassUnsupported
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var provider models.StorageProvider
+	if err := scs.collections.StorageProviders().FindOne(ctx, bson.M{
+		"type":      providerType,
+		"is_active": true,
+	}).Decode(&provider); err != nil {
+		return nil, ErrStorageProviderNotFound
+	}
+
+	client, err := storage.NewStorageClient(&provider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to storage provider: %v", err)
+	}
+	manager, ok := client.(storage.StorageClassManager)
+	if !ok {
+		return nil, ErrStorageClassUnsupported
+	}
+	return manager, nil
+}
+
+func (scs *StorageClassService) updateFile(ctx context.Context, userID, fileID primitive.ObjectID, update bson.M) (*models.File, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var file models.File
+	err := scs.collections.Files().FindOneAndUpdate(ctx,
+		bson.M{"_id": fileID, "user_id": userID, "is_deleted": false},
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&file)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrStorageClassFileNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update file: %v", err)
+	}
+	return &file, nil
+}
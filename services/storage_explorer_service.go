@@ -0,0 +1,290 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"oncloud/models"
+	"oncloud/storage"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrStorageExplorerUnsupported = errors.New("storage provider cannot be browsed")
+	ErrStorageObjectNotFound      = notFoundError("object not found")
+	ErrStorageObjectReferenced    = errors.New("object is referred to by files")
+)
+
+// StorageExplorerService lets admins browse the raw objects in providers'
+// buckets, cross-referenced with the records referring to them, to debug
+// orphaned and missing objects
+type StorageExplorerService struct {
+	*BaseService
+	auditService *AuditService
+}
+
+func NewStorageExplorerService() *StorageExplorerService {
+	return NewStorageExplorerServiceWith(Dependencies{})
+}
+
+// NewStorageExplorerServiceWith creates a storage explorer service on the
+// given dependencies
+func NewStorageExplorerServiceWith(deps Dependencies) *StorageExplorerService {
+	return &StorageExplorerService{
+		BaseService:  NewBaseServiceWith(deps),
+		auditService: NewAuditServiceWith(deps),
+	}
+}
+
+// ListObjects returns a page of the objects and folders under prefix in a
+// provider's bucket, each object with the records referring to it
+func (es *StorageExplorerService) ListObjects(ctx context.Context, providerID primitive.ObjectID, prefix, cursor string, limit int) (*models.StorageObjectListing, error) {
+	provider, _, browser, err := es.providerBrowser(ctx, providerID)
+	if err != nil {
+		return nil, err
+	}
+
+	page, err := browser.BrowseObjects(prefix, cursor, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects: %v", err)
+	}
+
+	keys := make([]string, 0, len(page.Objects))
+	for _, object := range page.Objects {
+		keys = append(keys, object.Key)
+	}
+	refs, err := es.objectRefs(ctx, provider.Type, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	listing := &models.StorageObjectListing{
+		ProviderID:   provider.ID,
+		ProviderType: provider.Type,
+		Bucket:       provider.Bucket,
+		Prefix:       prefix,
+		Prefixes:     page.Prefixes,
+		Objects:      make([]models.StorageObject, 0, len(page.Objects)),
+		NextCursor:   page.NextCursor,
+	}
+	for _, object := range page.Objects {
+		objectRefs := refs[object.Key]
+		if objectRefs == nil {
+			objectRefs = []models.StorageObjectRef{}
+		}
+		listing.Objects = append(listing.Objects, models.StorageObject{
+			Key:          object.Key,
+			Size:         object.Size,
+			LastModified: object.LastModified,
+			Refs:         objectRefs,
+			Orphaned:     len(objectRefs) == 0,
+		})
+	}
+	return listing, nil
+}
+
+// HeadObject describes an object as its provider stores it, with the records
+// referring to it. An object records refer to but the provider doesn't have
+// is reported missing rather than not found.
+func (es *StorageExplorerService) HeadObject(ctx context.Context, providerID primitive.ObjectID, key string) (*models.StorageObjectDetails, error) {
+	provider, _, browser, err := es.providerBrowser(ctx, providerID)
+	if err != nil {
+		return nil, err
+	}
+
+	head, err := browser.HeadObject(key)
+	if err != nil && !isMissingObjectError(err) {
+		return nil, fmt.Errorf("failed to inspect object: %v", err)
+	}
+	refs, refErr := es.objectRefs(ctx, provider.Type, []string{key})
+	if refErr != nil {
+		return nil, refErr
+	}
+
+	details := &models.StorageObjectDetails{
+		Key:  key,
+		Refs: refs[key],
+	}
+	if details.Refs == nil {
+		details.Refs = []models.StorageObjectRef{}
+	}
+	if head == nil {
+		if len(details.Refs) == 0 {
+			return nil, ErrStorageObjectNotFound
+		}
+		details.Missing = true
+		return details, nil
+	}
+
+	details.Exists = true
+	details.Size = head.Size
+	details.LastModified = &head.LastModified
+	details.ContentType = head.ContentType
+	details.ETag = head.ETag
+	details.StorageClass = head.StorageClass
+	details.Metadata = head.Metadata
+	details.Orphaned = len(details.Refs) == 0
+	return details, nil
+}
+
+// DeleteObject deletes an object from a provider's bucket. Objects records
+// refer to are only deleted with force, as their files would lose their
+// content.
+func (es *StorageExplorerService) DeleteObject(ctx context.Context, providerID primitive.ObjectID, key string, force bool, adminID primitive.ObjectID) error {
+	provider, client, _, err := es.providerBrowser(ctx, providerID)
+	if err != nil {
+		return err
+	}
+
+	refs, err := es.objectRefs(ctx, provider.Type, []string{key})
+	if err != nil {
+		return err
+	}
+	if len(refs[key]) > 0 && !force {
+		return ErrStorageObjectReferenced
+	}
+
+	if err := client.Delete(key); err != nil {
+		if isMissingObjectError(err) {
+			return ErrStorageObjectNotFound
+		}
+		return fmt.Errorf("failed to delete object: %v", err)
+	}
+
+	es.auditService.Record(ctx, &models.AuditLog{
+		ActorType:    "admin",
+		ActorID:      &adminID,
+		Action:       "storage_object_deleted",
+		ResourceType: "storage_provider",
+		ResourceID:   provider.ID.Hex(),
+		Outcome:      "success",
+		Details:      map[string]interface{}{"key": key, "refs": len(refs[key]), "force": force},
+	})
+	return nil
+}
+
+// providerBrowser returns a provider with its client, once it is known the
+// client can browse its objects
+func (es *StorageExplorerService) providerBrowser(ctx context.Context, providerID primitive.ObjectID) (*models.StorageProvider, storage.StorageInterface, storage.ObjectBrowser, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var provider models.StorageProvider
+	if err := es.collections.StorageProviders().FindOne(ctx, bson.M{"_id": providerID}).Decode(&provider); err != nil {
+		return nil, nil, nil, ErrStorageProviderNotFound
+	}
+
+	client, err := storage.NewStorageClient(&provider)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to connect to storage provider: %v", err)
+	}
+	browser, ok := client.(storage.ObjectBrowser)
+	if !ok {
+		return nil, nil, nil, ErrStorageExplorerUnsupported
+	}
+	return &provider, client, browser, nil
+}
+
+// objectRefs returns the files, versions and thumbnails referring to each of
+// keys on providers of providerType, the way orphan scans match them
+func (es *StorageExplorerService) objectRefs(ctx context.Context, providerType string, keys []string) (map[string][]models.StorageObjectRef, error) {
+	refs := map[string][]models.StorageObjectRef{}
+	if len(keys) == 0 {
+		return refs, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	thumbnailURLs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		thumbnailURLs = append(thumbnailURLs, "/"+key)
+	}
+	fileProjection := options.Find().SetProjection(bson.M{
+		"_id": 1, "name": 1, "user_id": 1, "storage_key": 1, "thumbnail_url": 1, "is_deleted": 1,
+	})
+
+	// Trashed files keep their objects until they are purged
+	var files []models.File
+	cursor, err := es.collections.Files().Find(ctx, bson.M{
+		"storage_provider": providerType,
+		"$or": []bson.M{
+			{"storage_key": bson.M{"$in": keys}},
+			{"thumbnail_url": bson.M{"$in": thumbnailURLs}},
+		},
+	}, fileProjection)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load files: %v", err)
+	}
+	if err := cursor.All(ctx, &files); err != nil {
+		return nil, fmt.Errorf("failed to load files: %v", err)
+	}
+	// A file matched on one of its keys may have the other outside of keys
+	wanted := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		wanted[key] = true
+	}
+	for _, file := range files {
+		ref := models.StorageObjectRef{FileID: file.ID, FileName: file.Name, UserID: file.UserID, IsDeleted: file.IsDeleted}
+		if wanted[file.StorageKey] {
+			ref.Kind = "file"
+			refs[file.StorageKey] = append(refs[file.StorageKey], ref)
+		}
+		if key := thumbnailKey(file.ThumbnailURL); wanted[key] {
+			ref.Kind = "thumbnail"
+			refs[key] = append(refs[key], ref)
+		}
+	}
+
+	// Versions are stored on the provider of their file
+	var versions []models.FileVersion
+	cursor, err = es.collections.FileVersions().Find(ctx, bson.M{"storage_key": bson.M{"$in": keys}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load file versions: %v", err)
+	}
+	if err := cursor.All(ctx, &versions); err != nil {
+		return nil, fmt.Errorf("failed to load file versions: %v", err)
+	}
+	if len(versions) == 0 {
+		return refs, nil
+	}
+
+	fileIDs := make([]primitive.ObjectID, 0, len(versions))
+	for _, version := range versions {
+		fileIDs = append(fileIDs, version.FileID)
+	}
+	cursor, err = es.collections.Files().Find(ctx, bson.M{
+		"_id":              bson.M{"$in": fileIDs},
+		"storage_provider": providerType,
+	}, fileProjection)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load files: %v", err)
+	}
+	files = nil
+	if err := cursor.All(ctx, &files); err != nil {
+		return nil, fmt.Errorf("failed to load files: %v", err)
+	}
+	versionFiles := make(map[primitive.ObjectID]models.File, len(files))
+	for _, file := range files {
+		versionFiles[file.ID] = file
+	}
+	for _, version := range versions {
+		file, ok := versionFiles[version.FileID]
+		if !ok {
+			continue
+		}
+		refs[version.StorageKey] = append(refs[version.StorageKey], models.StorageObjectRef{
+			Kind:          "version",
+			FileID:        file.ID,
+			FileName:      file.Name,
+			UserID:        file.UserID,
+			VersionNumber: version.VersionNumber,
+			IsDeleted:     file.IsDeleted,
+		})
+	}
+	return refs, nil
+}
@@ -0,0 +1,166 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"oncloud/database"
+	"oncloud/models"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// readFromReplicas reads an object its provider failed to serve from the
+// first of the provider's replicas that is healthy and holds it. Objects the
+// provider answered are missing are not looked for, since replication only
+// copies what the provider has.
+func (ss *StorageService) readFromReplicas(ctx context.Context, provider *models.StorageProvider, storageKey string, providerErr error) ([]byte, error) {
+	if isMissingObjectError(providerErr) {
+		return nil, providerErr
+	}
+	replicas := ss.healthyReplicas(ctx, provider)
+	if len(replicas) == 0 {
+		return nil, providerErr
+	}
+
+	failover := &models.StorageFailover{
+		ProviderType: strings.ToLower(provider.Type),
+		Operation:    "download",
+		StorageKey:   storageKey,
+		Error:        providerErr.Error(),
+	}
+	breakerFor(provider.Type).recordFailover()
+	for _, replica := range replicas {
+		failover.ReplicaType = strings.ToLower(replica.Type)
+		content, err := ss.downloadWithPolicy(ctx, replica, storageKey)
+		if err != nil {
+			failover.ReplicaError = err.Error()
+			continue
+		}
+
+		failover.Succeeded = true
+		failover.ReplicaError = ""
+		recordStorageFailover(failover)
+		log.Printf("Read %s from replica %s of storage provider %s: %v", storageKey, replica.Type, provider.Type, providerErr)
+		return content, nil
+	}
+
+	recordStorageFailover(failover)
+	return nil, providerErr
+}
+
+// replicaForLinks returns the first healthy replica of a provider, which
+// links to its objects are handed out for while it is unavailable, or nil
+func (ss *StorageService) replicaForLinks(ctx context.Context, provider *models.StorageProvider, storageKey string) *models.StorageProvider {
+	replicas := ss.healthyReplicas(ctx, provider)
+	if len(replicas) == 0 {
+		return nil
+	}
+
+	breakerFor(provider.Type).recordFailover()
+	recordStorageFailover(&models.StorageFailover{
+		ProviderType: strings.ToLower(provider.Type),
+		ReplicaType:  strings.ToLower(replicas[0].Type),
+		Operation:    "presign",
+		StorageKey:   storageKey,
+		Error:        ErrProviderUnavailable.Error(),
+		Succeeded:    true,
+	})
+	return replicas[0]
+}
+
+// healthyReplicas returns the active replicas of a provider whose breakers
+// are not open, in failover order
+func (ss *StorageService) healthyReplicas(ctx context.Context, provider *models.StorageProvider) []*models.StorageProvider {
+	var replicas []*models.StorageProvider
+	for _, replicaType := range provider.ReplicaTypes {
+		if strings.EqualFold(replicaType, provider.Type) || breakerFor(replicaType).rejecting() {
+			continue
+		}
+		replica, err := ss.findActiveProvider(ctx, replicaType)
+		if err != nil {
+			log.Printf("Replica %s of storage provider %s is not available: %v", replicaType, provider.Type, err)
+			continue
+		}
+		replicas = append(replicas, replica)
+	}
+	return replicas
+}
+
+// recordStorageFailover records a failover for the health dashboard in the
+// background, so reads don't wait on it
+func recordStorageFailover(failover *models.StorageFailover) {
+	record := *failover
+	record.CreatedAt = time.Now()
+	go func() {
+		if database.GetDatabase() == nil {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if _, err := database.GetCollection(database.StorageFailoversCollection).InsertOne(ctx, record); err != nil {
+			log.Printf("Failed to record failover of storage provider %s: %v", record.ProviderType, err)
+		}
+	}()
+}
+
+// GetStorageFailovers returns recorded failovers, newest first, optionally
+// of one provider type
+func (ss *StorageService) GetStorageFailovers(ctx context.Context, providerType string, page, limit int) ([]models.StorageFailover, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{}
+	if providerType != "" {
+		filter["provider_type"] = strings.ToLower(providerType)
+	}
+
+	collection := ss.collection(database.StorageFailoversCollection)
+	cursor, err := collection.Find(ctx, filter,
+		options.Find().
+			SetSort(bson.M{"created_at": -1}).
+			SetSkip(int64((page-1)*limit)).
+			SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get storage failovers: %v", err)
+	}
+	failovers := []models.StorageFailover{}
+	if err := cursor.All(ctx, &failovers); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode storage failovers: %v", err)
+	}
+
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count storage failovers: %v", err)
+	}
+	return failovers, int(total), nil
+}
+
+// GetStorageFailoverSummary counts each provider's failovers since a time
+func (ss *StorageService) GetStorageFailoverSummary(ctx context.Context, since time.Time) ([]models.StorageFailoverSummary, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	cursor, err := ss.collection(database.StorageFailoversCollection).Aggregate(ctx, []bson.M{
+		{"$match": bson.M{"created_at": bson.M{"$gte": since}}},
+		{"$group": bson.M{
+			"_id":       "$provider_type",
+			"failovers": bson.M{"$sum": 1},
+			"failed":    bson.M{"$sum": bson.M{"$cond": []interface{}{"$succeeded", 0, 1}}},
+			"last_at":   bson.M{"$max": "$created_at"},
+		}},
+		{"$sort": bson.M{"_id": 1}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to summarize storage failovers: %v", err)
+	}
+	summary := []models.StorageFailoverSummary{}
+	if err := cursor.All(ctx, &summary); err != nil {
+		return nil, fmt.Errorf("failed to decode storage failover summary: %v", err)
+	}
+	return summary, nil
+}
@@ -0,0 +1,322 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"oncloud/models"
+	"oncloud/utils"
+	"path"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// rekeyProgressInterval is how many files are re-keyed between progress
+	// updates of a job
+	rekeyProgressInterval = 100
+	rekeyMaxSamples       = 20
+	rekeyMaxErrors        = 50
+)
+
+var (
+	ErrStorageProviderNotFound = notFoundError("storage provider not found")
+	ErrRekeyJobNotFound        = notFoundError("re-key job not found")
+	ErrRekeyJobRunning         = errors.New("a re-key job is already running for this provider")
+)
+
+// NewStorageKey returns the key a new object is stored under on the active
+// provider of providerType, following the provider's key template
+func (ss *StorageService) NewStorageKey(ctx context.Context, providerType string, vars utils.StorageKeyVars) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var provider models.StorageProvider
+	err := ss.providerCollection.FindOne(ctx, bson.M{"type": providerType, "is_active": true}).Decode(&provider)
+	if err != nil {
+		return "", fmt.Errorf("storage provider not found: %v", err)
+	}
+	return utils.RenderStorageKey(provider.KeyTemplate, vars)
+}
+
+// storageKeyVars returns the template values for an existing file. The name
+// is taken from its current key, which is already unique.
+func storageKeyVars(file *models.File) utils.StorageKeyVars {
+	return utils.StorageKeyVars{
+		UserID: file.UserID.Hex(),
+		FileID: file.ID.Hex(),
+		Name:   path.Base(file.StorageKey),
+		Time:   file.CreatedAt,
+	}
+}
+
+type StorageKeyService struct {
+	*BaseService
+	storageService ObjectStore
+	auditService   *AuditService
+}
+
+func NewStorageKeyService() *StorageKeyService {
+	return NewStorageKeyServiceWith(Dependencies{})
+}
+
+// NewStorageKeyServiceWith creates a storage key service on the given
+// dependencies
+func NewStorageKeyServiceWith(deps Dependencies) *StorageKeyService {
+	return &StorageKeyService{
+		BaseService:    NewBaseServiceWith(deps),
+		storageService: deps.storage(),
+		auditService:   NewAuditServiceWith(deps),
+	}
+}
+
+// StartRekey starts moving the objects of a provider's files to the keys its
+// key template gives them. The files are re-keyed in the background; the job
+// reports progress.
+func (ks *StorageKeyService) StartRekey(ctx context.Context, providerID, adminID primitive.ObjectID, dryRun bool) (*models.StorageRekeyJob, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var provider models.StorageProvider
+	if err := ks.collections.StorageProviders().FindOne(ctx, bson.M{"_id": providerID}).Decode(&provider); err != nil {
+		return nil, ErrStorageProviderNotFound
+	}
+
+	running, err := ks.collections.StorageRekeyJobs().CountDocuments(ctx, bson.M{
+		"provider_id": providerID,
+		"status":      models.RekeyJobRunning,
+		"dry_run":     false,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if running > 0 && !dryRun {
+		return nil, ErrRekeyJobRunning
+	}
+
+	template := provider.KeyTemplate
+	if template == "" {
+		template = utils.DefaultStorageKeyTemplate
+	}
+
+	job := &models.StorageRekeyJob{
+		ID:           primitive.NewObjectID(),
+		ProviderID:   provider.ID,
+		ProviderType: provider.Type,
+		KeyTemplate:  template,
+		DryRun:       dryRun,
+		Status:       models.RekeyJobRunning,
+		Samples:      []models.RekeySample{},
+		Errors:       []string{},
+		StartedBy:    adminID,
+		StartedAt:    time.Now(),
+	}
+	if _, err := ks.collections.StorageRekeyJobs().InsertOne(ctx, job); err != nil {
+		return nil, fmt.Errorf("failed to create re-key job: %v", err)
+	}
+
+	if !dryRun {
+		ks.auditService.Record(ctx, &models.AuditLog{
+			ActorType:    "admin",
+			ActorID:      &adminID,
+			Action:       "storage_rekey_started",
+			ResourceType: "storage_provider",
+			ResourceID:   provider.ID.Hex(),
+			Outcome:      "success",
+			Details:      map[string]interface{}{"job_id": job.ID.Hex(), "key_template": template},
+		})
+	}
+
+	go ks.runRekey(context.Background(), *job)
+	return job, nil
+}
+
+// ListRekeyJobs returns a provider's re-key jobs, newest first
+func (ks *StorageKeyService) ListRekeyJobs(ctx context.Context, providerID primitive.ObjectID, page, limit int) ([]models.StorageRekeyJob, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	query := bson.M{"provider_id": providerID}
+	cursor, err := ks.collections.StorageRekeyJobs().Find(ctx, query,
+		options.Find().
+			SetSort(bson.M{"started_at": -1}).
+			SetSkip(int64((page-1)*limit)).
+			SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	jobs := []models.StorageRekeyJob{}
+	if err := cursor.All(ctx, &jobs); err != nil {
+		return nil, 0, err
+	}
+
+	total, err := ks.collections.StorageRekeyJobs().CountDocuments(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return jobs, int(total), nil
+}
+
+// GetRekeyJob returns one of a provider's re-key jobs
+func (ks *StorageKeyService) GetRekeyJob(ctx context.Context, providerID, jobID primitive.ObjectID) (*models.StorageRekeyJob, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var job models.StorageRekeyJob
+	err := ks.collections.StorageRekeyJobs().FindOne(ctx, bson.M{"_id": jobID, "provider_id": providerID}).Decode(&job)
+	if err != nil {
+		return nil, ErrRekeyJobNotFound
+	}
+	return &job, nil
+}
+
+// runRekey re-keys every file on the job's provider. Only the current
+// content of files moves; earlier versions keep the keys they were saved
+// under.
+func (ks *StorageKeyService) runRekey(ctx context.Context, job models.StorageRekeyJob) {
+
+	cursor, err := ks.collections.Files().Find(ctx, bson.M{"storage_provider": job.ProviderType})
+	if err != nil {
+		ks.finishRekey(ctx, &job, err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var file models.File
+		if err := cursor.Decode(&file); err != nil {
+			continue
+		}
+		job.Total++
+
+		newKey, err := utils.RenderStorageKey(job.KeyTemplate, storageKeyVars(&file))
+		if err == nil && newKey != file.StorageKey {
+			if len(job.Samples) < rekeyMaxSamples {
+				job.Samples = append(job.Samples, models.RekeySample{FileID: file.ID, From: file.StorageKey, To: newKey})
+			}
+			if !job.DryRun {
+				err = ks.rekeyFile(ctx, &file, newKey)
+			}
+		}
+
+		switch {
+		case err != nil:
+			job.Failed++
+			if len(job.Errors) < rekeyMaxErrors {
+				job.Errors = append(job.Errors, fmt.Sprintf("%s: %v", file.ID.Hex(), err))
+			}
+		case newKey == file.StorageKey:
+			job.Unchanged++
+		default:
+			job.Rekeyed++
+		}
+
+		if job.Total%rekeyProgressInterval == 0 {
+			ks.saveRekeyProgress(ctx, &job)
+		}
+	}
+
+	ks.finishRekey(ctx, &job, cursor.Err())
+}
+
+// rekeyFile copies a file's object to newKey and points the file at it. The
+// old object is deleted unless a version of the file still uses it.
+func (ks *StorageKeyService) rekeyFile(ctx context.Context, file *models.File, newKey string) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+
+	taken, err := ks.collections.Files().CountDocuments(ctx, bson.M{
+		"storage_provider": file.StorageProvider,
+		"storage_key":      newKey,
+	})
+	if err != nil {
+		return err
+	}
+	if taken > 0 {
+		return fmt.Errorf("key %s is already used by another file", newKey)
+	}
+
+	if err := ks.storageService.CopyFile(ctx, file.StorageProvider, file.StorageKey, file.StorageProvider, newKey); err != nil {
+		return err
+	}
+
+	set := bson.M{"storage_key": newKey, "path": newKey}
+	if file.Receipts != nil && file.Receipts.StorageKey == file.StorageKey {
+		set["receipts.storage_key"] = newKey
+	}
+
+	// Only move the file if its content did not change while it was copied
+	result, err := ks.collections.Files().UpdateOne(ctx,
+		bson.M{"_id": file.ID, "storage_key": file.StorageKey},
+		bson.M{"$set": set},
+	)
+	if err != nil || result.MatchedCount == 0 {
+		ks.storageService.DeleteFile(ctx, file.StorageProvider, newKey)
+		if err == nil {
+			err = errors.New("file content changed while it was re-keyed")
+		}
+		return err
+	}
+
+	versions, err := ks.collections.FileVersions().CountDocuments(ctx, bson.M{"storage_key": file.StorageKey})
+	if err == nil && versions == 0 {
+		ks.storageService.DeleteFile(ctx, file.StorageProvider, file.StorageKey)
+	}
+	return nil
+}
+
+func (ks *StorageKeyService) saveRekeyProgress(ctx context.Context, job *models.StorageRekeyJob) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	ks.collections.StorageRekeyJobs().UpdateOne(ctx,
+		bson.M{"_id": job.ID},
+		bson.M{"$set": bson.M{
+			"total":     job.Total,
+			"rekeyed":   job.Rekeyed,
+			"unchanged": job.Unchanged,
+			"failed":    job.Failed,
+			"samples":   job.Samples,
+			"errors":    job.Errors,
+		}},
+	)
+}
+
+func (ks *StorageKeyService) finishRekey(ctx context.Context, job *models.StorageRekeyJob, err error) {
+	now := time.Now()
+	job.CompletedAt = &now
+	job.Status = models.RekeyJobCompleted
+	outcome := "success"
+	if err != nil {
+		job.Status, outcome = models.RekeyJobFailed, "failure"
+		job.Error = err.Error()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	ks.collections.StorageRekeyJobs().ReplaceOne(ctx, bson.M{"_id": job.ID}, job)
+
+	if !job.DryRun {
+		ks.auditService.Record(ctx, &models.AuditLog{
+			ActorType:    "system",
+			Action:       "storage_rekey_" + job.Status,
+			ResourceType: "storage_provider",
+			ResourceID:   job.ProviderID.Hex(),
+			Outcome:      outcome,
+			Details: map[string]interface{}{
+				"job_id":  job.ID.Hex(),
+				"total":   job.Total,
+				"rekeyed": job.Rekeyed,
+				"failed":  job.Failed,
+			},
+		})
+	}
+}
@@ -0,0 +1,282 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"oncloud/models"
+	"oncloud/storage"
+	"oncloud/utils"
+	"reflect"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// lifecycleStorageClasses are the storage classes lifecycle rules can move
+// objects to, for each provider type whose buckets have lifecycle rules
+var lifecycleStorageClasses = map[string][]string{
+	"s3": {"STANDARD_IA", "ONEZONE_IA", "INTELLIGENT_TIERING", "GLACIER_IR", "GLACIER", "DEEP_ARCHIVE"},
+	"r2": {"STANDARD_IA"},
+}
+
+// archiveStorageClasses hold objects which must be restored before they can
+// be downloaded
+var archiveStorageClasses = []string{"GLACIER", "DEEP_ARCHIVE"}
+
+var (
+	ErrLifecycleUnsupported = errors.New("storage provider does not support lifecycle rules")
+	ErrLifecycleRuleInvalid = errors.New("invalid lifecycle rule")
+)
+
+// LifecycleConflictError is returned for lifecycle rules which would expire
+// or archive objects oncloud still serves files from
+type LifecycleConflictError struct {
+	Conflicts []models.LifecycleConflict
+}
+
+func (e *LifecycleConflictError) Error() string {
+	return fmt.Sprintf("%d lifecycle rules conflict with stored files", len(e.Conflicts))
+}
+
+type StorageLifecycleService struct {
+	*BaseService
+	auditService *AuditService
+}
+
+func NewStorageLifecycleService() *StorageLifecycleService {
+	return NewStorageLifecycleServiceWith(Dependencies{})
+}
+
+// NewStorageLifecycleServiceWith creates a storage lifecycle service on the
+// given dependencies
+func NewStorageLifecycleServiceWith(deps Dependencies) *StorageLifecycleService {
+	return &StorageLifecycleService{
+		BaseService:  NewBaseServiceWith(deps),
+		auditService: NewAuditServiceWith(deps),
+	}
+}
+
+// GetLifecycle compares the lifecycle rules oncloud manages for a provider
+// with the rules its bucket has
+func (ls *StorageLifecycleService) GetLifecycle(ctx context.Context, providerID primitive.ObjectID) (*models.ProviderLifecycle, error) {
+	provider, manager, err := ls.lifecycleProvider(ctx, providerID)
+	if err != nil {
+		return nil, err
+	}
+
+	live, err := manager.GetLifecycleRules()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bucket lifecycle: %v", err)
+	}
+	return buildProviderLifecycle(provider, live), nil
+}
+
+// SetLifecycleRules replaces the lifecycle rules of a provider's bucket.
+// Rules which would expire or archive the objects of stored files are
+// refused.
+func (ls *StorageLifecycleService) SetLifecycleRules(ctx context.Context, providerID primitive.ObjectID, rules []models.LifecycleRule, adminID primitive.ObjectID) (*models.ProviderLifecycle, error) {
+	provider, manager, err := ls.lifecycleProvider(ctx, providerID)
+	if err != nil {
+		return nil, err
+	}
+	return ls.applyLifecycle(ctx, provider, manager, rules, "storage_lifecycle_updated", adminID)
+}
+
+// ReconcileLifecycle brings the bucket's lifecycle rules and the rules oncloud
+// manages back in line. The managed rules are applied to the bucket again,
+// unless adopt is set, in which case the bucket's rules become the managed
+// ones.
+func (ls *StorageLifecycleService) ReconcileLifecycle(ctx context.Context, providerID primitive.ObjectID, adopt bool, adminID primitive.ObjectID) (*models.ProviderLifecycle, error) {
+	provider, manager, err := ls.lifecycleProvider(ctx, providerID)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := provider.LifecycleRules
+	action := "storage_lifecycle_reapplied"
+	if adopt {
+		if rules, err = manager.GetLifecycleRules(); err != nil {
+			return nil, fmt.Errorf("failed to read bucket lifecycle: %v", err)
+		}
+		action = "storage_lifecycle_adopted"
+	}
+	return ls.applyLifecycle(ctx, provider, manager, rules, action, adminID)
+}
+
+func (ls *StorageLifecycleService) applyLifecycle(ctx context.Context, provider *models.StorageProvider, manager storage.LifecycleManager, rules []models.LifecycleRule, action string, adminID primitive.ObjectID) (*models.ProviderLifecycle, error) {
+	if rules == nil {
+		rules = []models.LifecycleRule{}
+	}
+	if err := validateLifecycleRules(provider.Type, rules); err != nil {
+		return nil, err
+	}
+	if conflicts := lifecycleConflicts(provider, rules); len(conflicts) > 0 {
+		return nil, &LifecycleConflictError{Conflicts: conflicts}
+	}
+
+	if err := manager.PutLifecycleRules(rules); err != nil {
+		return nil, fmt.Errorf("failed to apply bucket lifecycle: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	if _, err := ls.collections.StorageProviders().UpdateOne(ctx,
+		bson.M{"_id": provider.ID},
+		bson.M{"$set": bson.M{
+			"lifecycle_rules":      rules,
+			"lifecycle_applied_at": now,
+			"updated_at":           now,
+		}},
+	); err != nil {
+		return nil, fmt.Errorf("failed to save lifecycle rules: %v", err)
+	}
+	provider.LifecycleRules = rules
+	provider.LifecycleAppliedAt = &now
+
+	ls.auditService.Record(ctx, &models.AuditLog{
+		ActorType:    "admin",
+		ActorID:      &adminID,
+		Action:       action,
+		ResourceType: "storage_provider",
+		ResourceID:   provider.ID.Hex(),
+		Outcome:      "success",
+		Details:      map[string]interface{}{"rules": len(rules)},
+	})
+
+	return buildProviderLifecycle(provider, rules), nil
+}
+
+// lifecycleProvider returns a provider along with the client managing its
+// bucket's lifecycle
+func (ls *StorageLifecycleService) lifecycleProvider(ctx context.Context, providerID primitive.ObjectID) (*models.StorageProvider, storage.LifecycleManager, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var provider models.StorageProvider
+	if err := ls.collections.StorageProviders().FindOne(ctx, bson.M{"_id": providerID}).Decode(&provider); err != nil {
+		return nil, nil, ErrStorageProviderNotFound
+	}
+	if _, ok := lifecycleStorageClasses[provider.Type]; !ok {
+		return nil, nil, ErrLifecycleUnsupported
+	}
+
+	client, err := storage.NewStorageClient(&provider)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to storage provider: %v", err)
+	}
+	manager, ok := client.(storage.LifecycleManager)
+	if !ok {
+		return nil, nil, ErrLifecycleUnsupported
+	}
+	return &provider, manager, nil
+}
+
+func validateLifecycleRules(providerType string, rules []models.LifecycleRule) error {
+	seen := map[string]bool{}
+	for _, rule := range rules {
+		if seen[rule.ID] {
+			return fmt.Errorf("%w: rule ID %q is used twice", ErrLifecycleRuleInvalid, rule.ID)
+		}
+		seen[rule.ID] = true
+
+		if rule.AbortMultipartAfterDays == 0 && rule.ExpireAfterDays == 0 && len(rule.Transitions) == 0 {
+			return fmt.Errorf("%w: rule %q has no actions", ErrLifecycleRuleInvalid, rule.ID)
+		}
+		for _, transition := range rule.Transitions {
+			if !utils.SliceContains(lifecycleStorageClasses[providerType], transition.StorageClass) {
+				return fmt.Errorf("%w: rule %q moves objects to storage class %s, which %s does not offer",
+					ErrLifecycleRuleInvalid, rule.ID, transition.StorageClass, providerType)
+			}
+			if rule.ExpireAfterDays > 0 && transition.Days >= rule.ExpireAfterDays {
+				return fmt.Errorf("%w: rule %q moves objects after they expire", ErrLifecycleRuleInvalid, rule.ID)
+			}
+		}
+	}
+	return nil
+}
+
+// lifecycleConflicts reconciles lifecycle rules with the files oncloud keeps
+// on the provider. Objects under the provider's key prefix belong to files
+// oncloud still serves, so rules must not expire them or move them to
+// classes they have to be restored from before every download.
+func lifecycleConflicts(provider *models.StorageProvider, rules []models.LifecycleRule) []models.LifecycleConflict {
+	keyPrefix := utils.StorageKeyPrefix(provider.KeyTemplate)
+
+	conflicts := []models.LifecycleConflict{}
+	for _, rule := range rules {
+		if !rule.Enabled {
+			continue
+		}
+		if !strings.HasPrefix(rule.Prefix, keyPrefix) && !strings.HasPrefix(keyPrefix, rule.Prefix) {
+			continue
+		}
+
+		if rule.ExpireAfterDays > 0 {
+			conflicts = append(conflicts, models.LifecycleConflict{
+				RuleID: rule.ID,
+				Reason: "expiring objects would delete the content of stored files",
+			})
+		}
+		for _, transition := range rule.Transitions {
+			if utils.SliceContains(archiveStorageClasses, transition.StorageClass) {
+				conflicts = append(conflicts, models.LifecycleConflict{
+					RuleID: rule.ID,
+					Reason: fmt.Sprintf("objects in %s must be restored before they can be downloaded", transition.StorageClass),
+				})
+			}
+		}
+	}
+	return conflicts
+}
+
+func buildProviderLifecycle(provider *models.StorageProvider, live []models.LifecycleRule) *models.ProviderLifecycle {
+	managed := provider.LifecycleRules
+	if managed == nil {
+		managed = []models.LifecycleRule{}
+	}
+
+	liveByID := map[string]models.LifecycleRule{}
+	for _, rule := range live {
+		liveByID[rule.ID] = rule
+	}
+
+	drift := []models.LifecycleDrift{}
+	managedIDs := map[string]bool{}
+	for _, rule := range managed {
+		managedIDs[rule.ID] = true
+		liveRule, ok := liveByID[rule.ID]
+		switch {
+		case !ok:
+			drift = append(drift, models.LifecycleDrift{RuleID: rule.ID, Issue: "missing"})
+		case !lifecycleRulesEqual(rule, liveRule):
+			drift = append(drift, models.LifecycleDrift{RuleID: rule.ID, Issue: "changed"})
+		}
+	}
+	for _, rule := range live {
+		if !managedIDs[rule.ID] {
+			drift = append(drift, models.LifecycleDrift{RuleID: rule.ID, Issue: "unmanaged"})
+		}
+	}
+
+	return &models.ProviderLifecycle{
+		ProviderID: provider.ID,
+		Managed:    managed,
+		Live:       live,
+		InSync:     len(drift) == 0,
+		Drift:      drift,
+		Conflicts:  lifecycleConflicts(provider, live),
+		AppliedAt:  provider.LifecycleAppliedAt,
+	}
+}
+
+func lifecycleRulesEqual(a, b models.LifecycleRule) bool {
+	if len(a.Transitions) == 0 && len(b.Transitions) == 0 {
+		a.Transitions, b.Transitions = nil, nil
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,577 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"oncloud/models"
+	"oncloud/utils"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// StorageReportOptions configures the monthly storage reports emailed to
+// users
+type StorageReportOptions struct {
+	// SendDay is the day of the month the reports of the month before are
+	// sent, from
+	SendDay int
+	// UnsubscribeURL is the page unsubscribe links open, with their token
+	// in the token query parameter, which posts it back to unsubscribe
+	UnsubscribeURL string
+}
+
+var storageReportOptions *StorageReportOptions
+
+const (
+	// storageReportsSetting is the user setting, and the list unsubscribe
+	// links name, that turns the report emails off when false
+	storageReportsSetting = "storage_reports"
+	// storageReportBiggestFiles is how many of a user's files the report
+	// lists by size
+	storageReportBiggestFiles = 5
+	// unsubscribeTokenTTL is how long the unsubscribe link of an email works
+	unsubscribeTokenTTL = 365 * 24 * time.Hour
+	// planHeadroom is how much above their usage a user's plan should hold,
+	// and planNearlyFull the share of its limits past which a bigger plan is
+	// suggested
+	planHeadroom    = 1.25
+	planNearlyFull  = 90.0
+	bytesPerGB      = 1024 * 1024 * 1024
+	planAdviceCheap = "cheaper"
+	planAdviceRoom  = "more_room"
+)
+
+// ErrUnsubscribeToken is returned for unsubscribe links that were not
+// signed here or have expired
+var ErrUnsubscribeToken = errors.New("this unsubscribe link is invalid or has expired")
+
+// InitStorageReports enables the monthly storage reports
+func InitStorageReports(opts StorageReportOptions) {
+	if opts.SendDay < 1 || opts.SendDay > 28 {
+		opts.SendDay = 1
+	}
+	storageReportOptions = &opts
+}
+
+// StorageReportsEnabled reports whether monthly storage reports are made
+func StorageReportsEnabled() bool {
+	return storageReportOptions != nil
+}
+
+// StorageReportService makes users' monthly storage reports: how their
+// storage grew, their biggest files, the duplicates they keep, the
+// bandwidth they used, and what their plan costs against that usage
+type StorageReportService struct {
+	*BaseService
+	notificationService *NotificationService
+}
+
+func NewStorageReportService() *StorageReportService {
+	return NewStorageReportServiceWith(Dependencies{})
+}
+
+// NewStorageReportServiceWith creates a storage report service on the given
+// dependencies
+func NewStorageReportServiceWith(deps Dependencies) *StorageReportService {
+	return &StorageReportService{
+		BaseService:         NewBaseServiceWith(deps),
+		notificationService: NewNotificationServiceWith(deps),
+	}
+}
+
+// SendDueReports makes the reports of the month before for the active users
+// who have none yet, once the send day has come, and emails those who have
+// not unsubscribed. Each report is stored before it is emailed, so that
+// only one instance emails it. It returns how many reports were emailed.
+func (srs *StorageReportService) SendDueReports(ctx context.Context) (int, error) {
+	if !StorageReportsEnabled() {
+		return 0, nil
+	}
+	now := srs.clock.Now().UTC()
+	if now.Day() < storageReportOptions.SendDay {
+		return 0, nil
+	}
+	periodEnd := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	periodStart := periodEnd.AddDate(0, -1, 0)
+	period := periodStart.Format("2006-01")
+
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Hour)
+	defer cancel()
+
+	reported, err := srs.collections.StorageReports().Distinct(ctx, "user_id", bson.M{"period": period})
+	if err != nil {
+		return 0, fmt.Errorf("failed to load storage reports: %v", err)
+	}
+	done := make(map[primitive.ObjectID]bool, len(reported))
+	for _, id := range reported {
+		if userID, ok := id.(primitive.ObjectID); ok {
+			done[userID] = true
+		}
+	}
+	unsubscribed, err := srs.unsubscribedUsers(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	cursor, err := srs.collections.Users().Find(ctx, bson.M{
+		"is_active":  true,
+		"created_at": bson.M{"$lt": periodEnd},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to load users: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	sent := 0
+	for cursor.Next(ctx) {
+		var user models.User
+		if err := cursor.Decode(&user); err != nil || done[user.ID] {
+			continue
+		}
+
+		report, err := srs.buildReport(ctx, &user, period, periodStart, periodEnd)
+		if err != nil {
+			log.Printf("Failed to make the storage report of user %s: %v", user.ID.Hex(), err)
+			continue
+		}
+		if _, err := srs.collections.StorageReports().InsertOne(ctx, report); err != nil {
+			if !mongo.IsDuplicateKeyError(err) {
+				log.Printf("Failed to store the storage report of user %s: %v", user.ID.Hex(), err)
+			}
+			continue
+		}
+		if unsubscribed[user.ID] || user.Email == "" {
+			continue
+		}
+
+		if err := srs.emailReport(&user, report); err != nil {
+			log.Printf("Failed to email the storage report of user %s: %v", user.ID.Hex(), err)
+			continue
+		}
+		emailedAt := srs.clock.Now()
+		srs.collections.StorageReports().UpdateOne(ctx,
+			bson.M{"_id": report.ID},
+			bson.M{"$set": bson.M{"emailed": true, "emailed_at": emailedAt}},
+		)
+		sent++
+	}
+	if err := cursor.Err(); err != nil {
+		return sent, fmt.Errorf("failed to load users: %v", err)
+	}
+
+	if sent > 0 {
+		log.Printf("Emailed %d storage reports for %s", sent, period)
+	}
+	return sent, nil
+}
+
+// ListReports returns the user's storage reports, newest first
+func (srs *StorageReportService) ListReports(ctx context.Context, userID primitive.ObjectID, page, limit int) ([]models.StorageReport, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{"user_id": userID}
+	cursor, err := srs.collections.StorageReports().Find(ctx, filter,
+		options.Find().
+			SetSort(bson.M{"period": -1}).
+			SetSkip(int64((page-1)*limit)).
+			SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	reports := []models.StorageReport{}
+	if err := cursor.All(ctx, &reports); err != nil {
+		return nil, 0, err
+	}
+
+	total, err := srs.collections.StorageReports().CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return reports, int(total), nil
+}
+
+// Unsubscribe turns off the emails an unsubscribe link was sent with, in
+// the settings of the user it was sent to
+func (srs *StorageReportService) Unsubscribe(ctx context.Context, token string) error {
+	claims, err := utils.ValidateUnsubscribeToken(token)
+	if err != nil || claims.List != storageReportsSetting {
+		return ErrUnsubscribeToken
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err = srs.collections.Collection("user_settings").UpdateOne(ctx,
+		bson.M{"user_id": claims.UserID},
+		bson.M{"$set": bson.M{storageReportsSetting: false, "updated_at": srs.clock.Now()}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save settings: %v", err)
+	}
+	return nil
+}
+
+// unsubscribedUsers returns the users who turned off storage reports, or
+// notification emails altogether
+func (srs *StorageReportService) unsubscribedUsers(ctx context.Context) (map[primitive.ObjectID]bool, error) {
+	ids, err := srs.collections.Collection("user_settings").Distinct(ctx, "user_id", bson.M{
+		"$or": []bson.M{
+			{storageReportsSetting: false},
+			{"email_notifications": false},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load user settings: %v", err)
+	}
+
+	unsubscribed := make(map[primitive.ObjectID]bool, len(ids))
+	for _, id := range ids {
+		if userID, ok := id.(primitive.ObjectID); ok {
+			unsubscribed[userID] = true
+		}
+	}
+	return unsubscribed, nil
+}
+
+// buildReport sums up the user's storage at the end of a month
+func (srs *StorageReportService) buildReport(ctx context.Context, user *models.User, period string, start, end time.Time) (*models.StorageReport, error) {
+	report := &models.StorageReport{
+		ID:             primitive.NewObjectID(),
+		UserID:         user.ID,
+		Period:         period,
+		PeriodStart:    start,
+		PeriodEnd:      end,
+		StorageUsed:    user.StorageUsed,
+		FilesCount:     user.FilesCount,
+		BiggestFiles:   []models.ReportFile{},
+		BandwidthUsed:  user.BandwidthUsed,
+		BandwidthTotal: user.BandwidthUsed,
+		CreatedAt:      srs.clock.Now(),
+	}
+
+	// Growth and bandwidth are told against the report of the month before
+	var previous models.StorageReport
+	err := srs.collections.StorageReports().FindOne(ctx, bson.M{
+		"user_id": user.ID,
+		"period":  start.AddDate(0, -1, 0).Format("2006-01"),
+	}).Decode(&previous)
+	switch {
+	case err == nil:
+		change := user.StorageUsed - previous.StorageUsed
+		report.StorageChange = &change
+		if user.BandwidthUsed >= previous.BandwidthTotal {
+			report.BandwidthUsed = user.BandwidthUsed - previous.BandwidthTotal
+		}
+	case err != mongo.ErrNoDocuments:
+		return nil, fmt.Errorf("failed to load the previous report: %v", err)
+	}
+
+	var uploaded []struct {
+		Count int   `bson:"count"`
+		Bytes int64 `bson:"bytes"`
+	}
+	cursor, err := srs.collections.Files().Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"user_id":    user.ID,
+			"is_deleted": false,
+			"created_at": bson.M{"$gte": start, "$lt": end},
+		}}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "count": bson.M{"$sum": 1}, "bytes": bson.M{"$sum": "$size"}}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to sum uploads: %v", err)
+	}
+	if err := cursor.All(ctx, &uploaded); err != nil {
+		return nil, fmt.Errorf("failed to sum uploads: %v", err)
+	}
+	if len(uploaded) > 0 {
+		report.FilesUploaded, report.BytesUploaded = uploaded[0].Count, uploaded[0].Bytes
+	}
+
+	cursor, err = srs.collections.Files().Find(ctx,
+		bson.M{"user_id": user.ID, "is_deleted": false},
+		options.Find().
+			SetSort(bson.M{"size": -1}).
+			SetLimit(storageReportBiggestFiles).
+			SetProjection(bson.M{"original_name": 1, "display_name": 1, "size": 1}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the biggest files: %v", err)
+	}
+	var biggest []models.File
+	if err := cursor.All(ctx, &biggest); err != nil {
+		return nil, fmt.Errorf("failed to find the biggest files: %v", err)
+	}
+	for i := range biggest {
+		report.BiggestFiles = append(report.BiggestFiles, models.ReportFile{
+			ID:   biggest[i].ID,
+			Name: fileDisplayName(&biggest[i]),
+			Size: biggest[i].Size,
+		})
+	}
+
+	// Files are duplicates when their content hashes and sizes match
+	var duplicates []models.ReportDuplicates
+	cursor, err = srs.collections.Files().Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"user_id": user.ID, "is_deleted": false, "hash": bson.M{"$nin": []interface{}{nil, ""}}}}},
+		{{Key: "$group", Value: bson.M{"_id": bson.M{"hash": "$hash", "size": "$size"}, "count": bson.M{"$sum": 1}}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":         nil,
+			"groups":      bson.M{"$sum": 1},
+			"copies":      bson.M{"$sum": bson.M{"$subtract": bson.A{"$count", 1}}},
+			"reclaimable": bson.M{"$sum": bson.M{"$multiply": bson.A{"$_id.size", bson.M{"$subtract": bson.A{"$count", 1}}}}},
+		}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find duplicates: %v", err)
+	}
+	if err := cursor.All(ctx, &duplicates); err != nil {
+		return nil, fmt.Errorf("failed to find duplicates: %v", err)
+	}
+	if len(duplicates) > 0 {
+		report.Duplicates = duplicates[0]
+	}
+
+	report.Plan = srs.planReport(ctx, user, report.BandwidthUsed)
+	return report, nil
+}
+
+// planReport weighs the user's plan against their usage, and looks for a
+// plan that fits it better
+func (srs *StorageReportService) planReport(ctx context.Context, user *models.User, bandwidthUsed int64) *models.ReportPlan {
+	var plan models.Plan
+	if err := srs.collections.Plans().FindOne(ctx, bson.M{"_id": user.PlanID}).Decode(&plan); err != nil {
+		return nil
+	}
+
+	report := &models.ReportPlan{
+		Name:             plan.Name,
+		MonthlyPrice:     monthlyPlanPrice(&plan),
+		Currency:         plan.Currency,
+		StorageLimit:     plan.StorageLimit,
+		BandwidthLimit:   plan.BandwidthLimit,
+		StoragePercent:   utils.CalculateStorageUsage(user.StorageUsed, plan.StorageLimit),
+		BandwidthPercent: utils.CalculateStorageUsage(bandwidthUsed, plan.BandwidthLimit),
+	}
+	// Below a gigabyte the price per gigabyte says little
+	if report.MonthlyPrice > 0 && user.StorageUsed >= bytesPerGB {
+		report.CostPerGB = report.MonthlyPrice / (float64(user.StorageUsed) / bytesPerGB)
+	}
+
+	cursor, err := srs.collections.Plans().Find(ctx, bson.M{"is_active": true, "_id": bson.M{"$ne": plan.ID}})
+	if err != nil {
+		return report
+	}
+	var plans []models.Plan
+	if err := cursor.All(ctx, &plans); err != nil {
+		return report
+	}
+
+	nearlyFull := report.StoragePercent >= planNearlyFull || report.BandwidthPercent >= planNearlyFull
+	for i := range plans {
+		candidate := &plans[i]
+		if candidate.Currency != plan.Currency && !candidate.IsFree {
+			continue
+		}
+		price := monthlyPlanPrice(candidate)
+		if !planHolds(candidate, user.StorageUsed, bandwidthUsed) {
+			continue
+		}
+
+		reason := ""
+		switch {
+		case nearlyFull && planLarger(candidate, &plan):
+			reason = planAdviceRoom
+		case !nearlyFull && price < report.MonthlyPrice:
+			reason = planAdviceCheap
+		default:
+			continue
+		}
+		if report.Suggested == nil || price < report.Suggested.MonthlyPrice {
+			report.Suggested = &models.PlanAdvice{
+				PlanID:       candidate.ID,
+				Name:         candidate.Name,
+				MonthlyPrice: price,
+				Reason:       reason,
+			}
+		}
+	}
+	return report
+}
+
+// monthlyPlanPrice is what a plan costs a month, whatever its billing cycle
+func monthlyPlanPrice(plan *models.Plan) float64 {
+	if plan.IsFree {
+		return 0
+	}
+	switch plan.BillingCycle {
+	case "daily":
+		return plan.Price * 365 / 12
+	case "weekly":
+		return plan.Price * 52 / 12
+	case "quarterly":
+		return plan.Price / 3
+	case "yearly":
+		return plan.Price / 12
+	default:
+		return plan.Price
+	}
+}
+
+// planHolds reports whether a plan holds a usage with room to grow. A limit
+// of zero is no limit.
+func planHolds(plan *models.Plan, storageUsed, bandwidthUsed int64) bool {
+	if plan.StorageLimit > 0 && float64(plan.StorageLimit) < float64(storageUsed)*planHeadroom {
+		return false
+	}
+	if plan.BandwidthLimit > 0 && float64(plan.BandwidthLimit) < float64(bandwidthUsed)*planHeadroom {
+		return false
+	}
+	return true
+}
+
+// planLarger reports whether a plan allows more storage than another, and
+// no less bandwidth
+func planLarger(plan, than *models.Plan) bool {
+	storage := than.StorageLimit > 0 && (plan.StorageLimit == 0 || plan.StorageLimit > than.StorageLimit)
+	bandwidth := than.BandwidthLimit == 0 || plan.BandwidthLimit == 0 || plan.BandwidthLimit >= than.BandwidthLimit
+	return storage && bandwidth
+}
+
+// emailReport emails a user their report, as text with tables of figures
+func (srs *StorageReportService) emailReport(user *models.User, report *models.StorageReport) error {
+	data := map[string]string{
+		"name":        user.FirstName + " " + user.LastName,
+		"month":       report.PeriodStart.Format("January 2006"),
+		"storage":     utils.FormatFileSize(report.StorageUsed),
+		"bandwidth":   utils.FormatFileSize(report.BandwidthUsed),
+		"unsubscribe": srs.unsubscribeLink(user.ID),
+	}
+	if report.StorageChange != nil {
+		data["change"] = describeStorageChange(*report.StorageChange)
+	}
+
+	storage := models.EmailSection{Title: "Storage", Rows: []models.EmailRow{
+		{Label: "Stored", Value: utils.FormatFileSize(report.StorageUsed)},
+	}}
+	if report.StorageChange != nil {
+		storage.Rows = append(storage.Rows, models.EmailRow{Label: "Since last month", Value: describeStorageChange(*report.StorageChange)})
+	}
+	storage.Rows = append(storage.Rows,
+		models.EmailRow{Label: "Uploaded this month", Value: fmt.Sprintf("%d files, %s", report.FilesUploaded, utils.FormatFileSize(report.BytesUploaded))},
+		models.EmailRow{Label: "Files", Value: strconv.Itoa(report.FilesCount)},
+	)
+	sections := []models.EmailSection{storage}
+
+	if len(report.BiggestFiles) > 0 {
+		biggest := models.EmailSection{Title: "Biggest files"}
+		for _, file := range report.BiggestFiles {
+			biggest.Rows = append(biggest.Rows, models.EmailRow{Label: file.Name, Value: utils.FormatFileSize(file.Size)})
+		}
+		sections = append(sections, biggest)
+	}
+
+	if report.Duplicates.Groups > 0 {
+		sections = append(sections, models.EmailSection{Title: "Duplicates", Rows: []models.EmailRow{
+			{Label: "Files kept more than once", Value: strconv.Itoa(report.Duplicates.Groups)},
+			{Label: "Extra copies", Value: strconv.Itoa(report.Duplicates.Copies)},
+			{Label: "Space they take", Value: utils.FormatFileSize(report.Duplicates.Reclaimable)},
+		}})
+	}
+
+	// The first report has no earlier total to tell the month's use from
+	used := "Used this month"
+	if report.StorageChange == nil {
+		used = "Used so far"
+	}
+	sections = append(sections, models.EmailSection{Title: "Bandwidth", Rows: []models.EmailRow{
+		{Label: used, Value: utils.FormatFileSize(report.BandwidthUsed)},
+	}})
+
+	if plan := report.Plan; plan != nil {
+		rows := []models.EmailRow{
+			{Label: "Plan", Value: plan.Name},
+			{Label: "Price", Value: describeMonthlyPrice(plan.MonthlyPrice, plan.Currency)},
+			{Label: "Storage", Value: describeLimitUse(report.StorageUsed, plan.StorageLimit, plan.StoragePercent)},
+			{Label: "Bandwidth", Value: describeLimitUse(report.BandwidthUsed, plan.BandwidthLimit, plan.BandwidthPercent)},
+		}
+		if plan.CostPerGB > 0 {
+			rows = append(rows, models.EmailRow{Label: "Cost per GB stored", Value: describePrice(plan.CostPerGB, plan.Currency)})
+		}
+		sections = append(sections, models.EmailSection{Title: "Your plan", Rows: rows})
+
+		if advice := plan.Suggested; advice != nil {
+			price := describeMonthlyPrice(advice.MonthlyPrice, plan.Currency)
+			if advice.Reason == planAdviceRoom {
+				data["advice"] = fmt.Sprintf("You are close to the limits of your plan. The %s plan (%s) gives you more room.", advice.Name, price)
+			} else {
+				data["advice"] = fmt.Sprintf("The %s plan (%s) would hold what you store and use for less.", advice.Name, price)
+			}
+		}
+	}
+
+	return srs.notificationService.SendEmailWithSections(user.Email, "storage_report", data, sections)
+}
+
+// unsubscribeLink returns the link that turns the user's storage reports
+// off, or "" when there is no unsubscribe page
+func (srs *StorageReportService) unsubscribeLink(userID primitive.ObjectID) string {
+	if storageReportOptions.UnsubscribeURL == "" {
+		return ""
+	}
+	link, err := url.Parse(storageReportOptions.UnsubscribeURL)
+	if err != nil {
+		return ""
+	}
+	token, err := utils.GenerateUnsubscribeToken(userID, storageReportsSetting, srs.clock.Now().Add(unsubscribeTokenTTL))
+	if err != nil {
+		return ""
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+	return link.String()
+}
+
+func describeStorageChange(change int64) string {
+	switch {
+	case change > 0:
+		return "up " + utils.FormatFileSize(change)
+	case change < 0:
+		return "down " + utils.FormatFileSize(-change)
+	}
+	return "unchanged"
+}
+
+func describePrice(amount float64, currency string) string {
+	return fmt.Sprintf("%.2f %s", amount, currency)
+}
+
+func describeMonthlyPrice(amount float64, currency string) string {
+	if amount == 0 {
+		return "free"
+	}
+	return describePrice(amount, currency) + " a month"
+}
+
+func describeLimitUse(used, limit int64, percent float64) string {
+	if limit <= 0 {
+		return utils.FormatFileSize(used) + " (no limit)"
+	}
+	return fmt.Sprintf("%s of %s (%.0f%%)", utils.FormatFileSize(used), utils.FormatFileSize(limit), percent)
+}
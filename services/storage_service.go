@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"io"
 	"mime"
-	"oncloud/database"
 	"oncloud/models"
 	"oncloud/storage"
 	"oncloud/utils"
@@ -27,25 +26,40 @@ type StorageService struct {
 	syncCollection     *mongo.Collection
 	backupCollection   *mongo.Collection
 	activityCollection *mongo.Collection
+	db                 *mongo.Database
 }
 
 func NewStorageService() *StorageService {
+	return NewStorageServiceWith(Dependencies{})
+}
+
+// NewStorageServiceWith creates a storage service on the given dependencies'
+// database
+func NewStorageServiceWith(deps Dependencies) *StorageService {
 	service := &StorageService{}
 
 	// Only initialize if database is available
-	if database.GetDatabase() != nil {
-		service.fileCollection = database.GetCollection("files")
-		service.providerCollection = database.GetCollection("storage_providers")
-		service.syncCollection = database.GetCollection("sync_jobs")
-		service.backupCollection = database.GetCollection("backups")
+	if db := deps.database().GetDatabase(); db != nil {
+		service.db = db
+		service.fileCollection = db.Collection("files")
+		service.providerCollection = db.Collection("storage_providers")
+		service.userCollection = db.Collection("users")
+		service.syncCollection = db.Collection("sync_jobs")
+		service.backupCollection = db.Collection("backups")
 	}
 
 	return service
 }
 
+// collection returns one of the collections of the service's database
+// without a field of its own
+func (ss *StorageService) collection(name string) *mongo.Collection {
+	return ss.db.Collection(name)
+}
+
 // Storage Service - GetProvidersForAdmin Function
-func (ss *StorageService) GetProvidersForAdmin(page, limit int) ([]models.StorageProvider, int64, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (ss *StorageService) GetProvidersForAdmin(ctx context.Context, page, limit int) ([]models.StorageProvider, int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	skip := (page - 1) * limit
@@ -105,8 +119,8 @@ func (ss *StorageService) GetProvidersForAdmin(page, limit int) ([]models.Storag
 }
 
 // Storage Service - GetProviderForAdmin Function
-func (ss *StorageService) GetProviderForAdmin(providerID primitive.ObjectID) (*models.StorageProvider, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (ss *StorageService) GetProviderForAdmin(ctx context.Context, providerID primitive.ObjectID) (*models.StorageProvider, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	// Get provider with detailed statistics
@@ -159,8 +173,8 @@ func (ss *StorageService) GetProviderForAdmin(providerID primitive.ObjectID) (*m
 }
 
 // Storage Service - CreateProvider Function
-func (ss *StorageService) CreateProvider(provider *models.StorageProvider) (*models.StorageProvider, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+func (ss *StorageService) CreateProvider(ctx context.Context, provider *models.StorageProvider) (*models.StorageProvider, error) {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
 	defer cancel()
 
 	// Validate provider configuration
@@ -200,8 +214,8 @@ func (ss *StorageService) CreateProvider(provider *models.StorageProvider) (*mod
 }
 
 // Storage Service - UpdateProvider Function
-func (ss *StorageService) UpdateProvider(providerID primitive.ObjectID, updates map[string]interface{}) (*models.StorageProvider, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+func (ss *StorageService) UpdateProvider(ctx context.Context, providerID primitive.ObjectID, updates map[string]interface{}) (*models.StorageProvider, error) {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
 	defer cancel()
 
 	// Handle default provider logic
@@ -240,8 +254,8 @@ func (ss *StorageService) UpdateProvider(providerID primitive.ObjectID, updates
 }
 
 // Storage Service - DeleteProvider Function
-func (ss *StorageService) DeleteProvider(providerID primitive.ObjectID) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+func (ss *StorageService) DeleteProvider(ctx context.Context, providerID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
 	defer cancel()
 
 	// Get provider info first
@@ -289,8 +303,8 @@ func (ss *StorageService) DeleteProvider(providerID primitive.ObjectID) error {
 }
 
 // Storage Service - TestProvider Function
-func (ss *StorageService) TestProvider(providerID primitive.ObjectID) (map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+func (ss *StorageService) TestProvider(ctx context.Context, providerID primitive.ObjectID) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
 	// Get provider
@@ -343,8 +357,8 @@ func (ss *StorageService) TestProvider(providerID primitive.ObjectID) (map[strin
 }
 
 // Storage Service - SyncProvider Function
-func (ss *StorageService) SyncProvider(providerID primitive.ObjectID) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+func (ss *StorageService) SyncProvider(ctx context.Context, providerID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
 	defer cancel()
 
 	// Get provider
@@ -476,8 +490,8 @@ func (ss *StorageService) syncR2Provider(provider *models.StorageProvider) error
 }
 
 // Provider Management
-func (ss *StorageService) GetProviders() ([]models.StorageProvider, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (ss *StorageService) GetProviders(ctx context.Context) ([]models.StorageProvider, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	cursor, err := ss.providerCollection.Find(ctx, bson.M{},
@@ -496,8 +510,8 @@ func (ss *StorageService) GetProviders() ([]models.StorageProvider, error) {
 	return providers, nil
 }
 
-func (ss *StorageService) GetProvider(providerID primitive.ObjectID) (*models.StorageProvider, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (ss *StorageService) GetProvider(ctx context.Context, providerID primitive.ObjectID) (*models.StorageProvider, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	var provider models.StorageProvider
@@ -510,8 +524,8 @@ func (ss *StorageService) GetProvider(providerID primitive.ObjectID) (*models.St
 }
 
 // Storage Statistics
-func (ss *StorageService) GetStorageStats() (map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+func (ss *StorageService) GetStorageStats(ctx context.Context) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
 	defer cancel()
 
 	stats := make(map[string]interface{})
@@ -600,8 +614,8 @@ func (ss *StorageService) GetStorageStats() (map[string]interface{}, error) {
 	return stats, nil
 }
 
-func (ss *StorageService) GetStorageUsage() (map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+func (ss *StorageService) GetStorageUsage(ctx context.Context) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
 	defer cancel()
 
 	// Get usage by user (top 10)
@@ -700,8 +714,8 @@ func (ss *StorageService) GetStorageUsage() (map[string]interface{}, error) {
 }
 
 // File Synchronization and Migration
-func (ss *StorageService) SyncFiles() (map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (ss *StorageService) SyncFiles(ctx context.Context) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	// Create sync job
@@ -723,7 +737,7 @@ func (ss *StorageService) SyncFiles() (map[string]interface{}, error) {
 	}
 
 	// Start sync process asynchronously
-	go ss.processSyncJob(result.InsertedID.(primitive.ObjectID))
+	go ss.processSyncJob(context.Background(), result.InsertedID.(primitive.ObjectID))
 
 	return map[string]interface{}{
 		"job_id":     result.InsertedID,
@@ -732,8 +746,8 @@ func (ss *StorageService) SyncFiles() (map[string]interface{}, error) {
 	}, nil
 }
 
-func (ss *StorageService) MigrateFiles() (map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (ss *StorageService) MigrateFiles(ctx context.Context) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	// Create migration job
@@ -755,7 +769,7 @@ func (ss *StorageService) MigrateFiles() (map[string]interface{}, error) {
 	}
 
 	// Start migration process asynchronously
-	go ss.processMigrationJob(result.InsertedID.(primitive.ObjectID))
+	go ss.processMigrationJob(context.Background(), result.InsertedID.(primitive.ObjectID))
 
 	return map[string]interface{}{
 		"job_id":     result.InsertedID,
@@ -765,11 +779,11 @@ func (ss *StorageService) MigrateFiles() (map[string]interface{}, error) {
 }
 
 // Health Monitoring
-func (ss *StorageService) CheckProvidersHealth() (map[string]interface{}, error) {
-	_, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+func (ss *StorageService) CheckProvidersHealth(ctx context.Context) (map[string]interface{}, error) {
+	_, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
-	providers, err := ss.GetProviders()
+	providers, err := ss.GetProviders(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -798,6 +812,18 @@ func (ss *StorageService) CheckProvidersHealth() (map[string]interface{}, error)
 			overallHealthy = false
 		}
 
+		// A provider whose breaker is open is failing calls on this instance
+		breaker := providerBreakerState(provider.Type)
+		providerHealth["breaker"] = breaker
+		if len(provider.ReplicaTypes) > 0 {
+			providerHealth["replica_types"] = provider.ReplicaTypes
+		}
+		if providerHealth["status"] == "healthy" && breaker.State == BreakerOpen {
+			providerHealth["status"] = "unhealthy"
+			providerHealth["error"] = fmt.Sprintf("circuit breaker open: %s", breaker.LastError)
+			overallHealthy = false
+		}
+
 		health["providers"].(map[string]interface{})[provider.ID.Hex()] = providerHealth
 	}
 
@@ -812,10 +838,14 @@ func (ss *StorageService) CheckProvidersHealth() (map[string]interface{}, error)
 }
 
 // Upload Operations
-func (ss *StorageService) GetUploadURL(userID primitive.ObjectID, fileName string, fileSize int64) (map[string]interface{}, error) {
+func (ss *StorageService) GetUploadURL(ctx context.Context, userID primitive.ObjectID, fileName string, fileSize int64) (map[string]interface{}, error) {
+	if err := checkAccountWritable(ctx, ss.userCollection, userID); err != nil {
+		return nil, err
+	}
+
 	// Get user's plan to validate limits
 	var user models.User
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	err := ss.userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
@@ -847,11 +877,15 @@ func (ss *StorageService) GetUploadURL(userID primitive.ObjectID, fileName strin
 	}, nil
 }
 
-func (ss *StorageService) InitiateMultipartUpload(userID primitive.ObjectID, fileName string, fileSize int64) (map[string]interface{}, error) {
+func (ss *StorageService) InitiateMultipartUpload(ctx context.Context, userID primitive.ObjectID, fileName string, fileSize int64) (map[string]interface{}, error) {
+	if err := checkAccountWritable(ctx, ss.userCollection, userID); err != nil {
+		return nil, err
+	}
+
 	uploadID := primitive.NewObjectID().Hex()
 
 	// Store multipart upload session
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	session := bson.M{
@@ -862,10 +896,10 @@ func (ss *StorageService) InitiateMultipartUpload(userID primitive.ObjectID, fil
 		"status":     "initiated",
 		"parts":      []interface{}{},
 		"created_at": time.Now(),
-		"expires_at": time.Now().Add(24 * time.Hour),
+		"expires_at": time.Now().Add(chunkUploadOptions.SessionTTL),
 	}
 
-	_, err := database.GetCollection("multipart_uploads").InsertOne(ctx, session)
+	_, err := ss.collection("multipart_uploads").InsertOne(ctx, session)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initiate multipart upload: %v", err)
 	}
@@ -878,12 +912,12 @@ func (ss *StorageService) InitiateMultipartUpload(userID primitive.ObjectID, fil
 	}, nil
 }
 
-func (ss *StorageService) UploadPart(uploadID string, partNumber int, partSize int64) (map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (ss *StorageService) UploadPart(ctx context.Context, uploadID string, partNumber int, partSize int64) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	// Update multipart upload session
-	_, err := database.GetCollection("multipart_uploads").UpdateOne(ctx,
+	_, err := ss.collection("multipart_uploads").UpdateOne(ctx,
 		bson.M{"_id": uploadID},
 		bson.M{"$push": bson.M{"parts": bson.M{
 			"part_number": partNumber,
@@ -903,19 +937,19 @@ func (ss *StorageService) UploadPart(uploadID string, partNumber int, partSize i
 	}, nil
 }
 
-func (ss *StorageService) CompleteMultipartUpload(uploadID string) (map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (ss *StorageService) CompleteMultipartUpload(ctx context.Context, uploadID string) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	// Get multipart upload session
 	var session bson.M
-	err := database.GetCollection("multipart_uploads").FindOne(ctx, bson.M{"_id": uploadID}).Decode(&session)
+	err := ss.collection("multipart_uploads").FindOne(ctx, bson.M{"_id": uploadID}).Decode(&session)
 	if err != nil {
 		return nil, fmt.Errorf("multipart upload session not found: %v", err)
 	}
 
 	// Mark as completed
-	_, err = database.GetCollection("multipart_uploads").UpdateOne(ctx,
+	_, err = ss.collection("multipart_uploads").UpdateOne(ctx,
 		bson.M{"_id": uploadID},
 		bson.M{"$set": bson.M{
 			"status":       "completed",
@@ -934,11 +968,11 @@ func (ss *StorageService) CompleteMultipartUpload(uploadID string) (map[string]i
 	}, nil
 }
 
-func (ss *StorageService) AbortMultipartUpload(uploadID string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (ss *StorageService) AbortMultipartUpload(ctx context.Context, uploadID string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	_, err := database.GetCollection("multipart_uploads").UpdateOne(ctx,
+	_, err := ss.collection("multipart_uploads").UpdateOne(ctx,
 		bson.M{"_id": uploadID},
 		bson.M{"$set": bson.M{
 			"status":     "aborted",
@@ -949,9 +983,9 @@ func (ss *StorageService) AbortMultipartUpload(uploadID string) error {
 }
 
 // CDN Operations
-func (ss *StorageService) InvalidateCDN(paths []string) (map[string]interface{}, error) {
+func (ss *StorageService) InvalidateCDN(ctx context.Context, paths []string) (map[string]interface{}, error) {
 	// Create CDN invalidation job
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	invalidation := bson.M{
@@ -961,13 +995,13 @@ func (ss *StorageService) InvalidateCDN(paths []string) (map[string]interface{},
 		"created_at": time.Now(),
 	}
 
-	result, err := database.GetCollection("cdn_invalidations").InsertOne(ctx, invalidation)
+	result, err := ss.collection("cdn_invalidations").InsertOne(ctx, invalidation)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create CDN invalidation: %v", err)
 	}
 
 	// Process invalidation asynchronously
-	go ss.processCDNInvalidation(result.InsertedID.(primitive.ObjectID), paths)
+	go ss.processCDNInvalidation(context.Background(), result.InsertedID.(primitive.ObjectID), paths)
 
 	return map[string]interface{}{
 		"invalidation_id": result.InsertedID,
@@ -997,8 +1031,8 @@ func (ss *StorageService) GetCDNStats() (map[string]interface{}, error) {
 }
 
 // Image Optimization
-func (ss *StorageService) OptimizeImages() (map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (ss *StorageService) OptimizeImages(ctx context.Context) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	// Find images that need optimization
@@ -1025,13 +1059,13 @@ func (ss *StorageService) OptimizeImages() (map[string]interface{}, error) {
 		"created_at":   time.Now(),
 	}
 
-	result, err := database.GetCollection("optimization_jobs").InsertOne(ctx, optimizationJob)
+	result, err := ss.collection("optimization_jobs").InsertOne(ctx, optimizationJob)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create optimization job: %v", err)
 	}
 
 	// Process optimization asynchronously
-	go ss.processImageOptimization(result.InsertedID.(primitive.ObjectID), images)
+	go ss.processImageOptimization(context.Background(), result.InsertedID.(primitive.ObjectID), images)
 
 	return map[string]interface{}{
 		"job_id":       result.InsertedID,
@@ -1042,8 +1076,8 @@ func (ss *StorageService) OptimizeImages() (map[string]interface{}, error) {
 }
 
 // Backup Operations
-func (ss *StorageService) CreateBackup() (map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (ss *StorageService) CreateBackup(ctx context.Context) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	// Create backup job
@@ -1064,7 +1098,7 @@ func (ss *StorageService) CreateBackup() (map[string]interface{}, error) {
 	}
 
 	// Start backup process asynchronously
-	go ss.processBackup(result.InsertedID.(primitive.ObjectID))
+	go ss.processBackup(context.Background(), result.InsertedID.(primitive.ObjectID))
 
 	return map[string]interface{}{
 		"backup_id":  result.InsertedID,
@@ -1073,8 +1107,8 @@ func (ss *StorageService) CreateBackup() (map[string]interface{}, error) {
 	}, nil
 }
 
-func (ss *StorageService) GetBackups() ([]map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (ss *StorageService) GetBackups(ctx context.Context) ([]map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	cursor, err := ss.backupCollection.Find(ctx, bson.M{},
@@ -1093,8 +1127,8 @@ func (ss *StorageService) GetBackups() ([]map[string]interface{}, error) {
 	return backups, nil
 }
 
-func (ss *StorageService) RestoreBackup(backupID primitive.ObjectID) (map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (ss *StorageService) RestoreBackup(ctx context.Context, backupID primitive.ObjectID) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	// Get backup info
@@ -1113,13 +1147,13 @@ func (ss *StorageService) RestoreBackup(backupID primitive.ObjectID) (map[string
 		"created_at": time.Now(),
 	}
 
-	result, err := database.GetCollection("restore_jobs").InsertOne(ctx, restore)
+	result, err := ss.collection("restore_jobs").InsertOne(ctx, restore)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create restore job: %v", err)
 	}
 
 	// Start restore process asynchronously
-	go ss.processRestore(result.InsertedID.(primitive.ObjectID), backupID)
+	go ss.processRestore(context.Background(), result.InsertedID.(primitive.ObjectID), backupID)
 
 	return map[string]interface{}{
 		"restore_id": result.InsertedID,
@@ -1129,8 +1163,8 @@ func (ss *StorageService) RestoreBackup(backupID primitive.ObjectID) (map[string
 	}, nil
 }
 
-func (ss *StorageService) DeleteBackup(backupID primitive.ObjectID) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (ss *StorageService) DeleteBackup(ctx context.Context, backupID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	_, err := ss.backupCollection.DeleteOne(ctx, bson.M{"_id": backupID})
@@ -1164,11 +1198,11 @@ func (ss *StorageService) countHealthyProviders(providers map[string]interface{}
 }
 
 // Background job processors
-func (ss *StorageService) processSyncJob(jobID primitive.ObjectID) {
+func (ss *StorageService) processSyncJob(ctx context.Context, jobID primitive.ObjectID) {
 	// Implementation for processing sync jobs
 	time.Sleep(5 * time.Second) // Simulate work
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	ss.syncCollection.UpdateOne(ctx,
@@ -1180,11 +1214,11 @@ func (ss *StorageService) processSyncJob(jobID primitive.ObjectID) {
 	)
 }
 
-func (ss *StorageService) processMigrationJob(jobID primitive.ObjectID) {
+func (ss *StorageService) processMigrationJob(ctx context.Context, jobID primitive.ObjectID) {
 	// Implementation for processing migration jobs
 	time.Sleep(10 * time.Second) // Simulate work
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	ss.syncCollection.UpdateOne(ctx,
@@ -1196,14 +1230,14 @@ func (ss *StorageService) processMigrationJob(jobID primitive.ObjectID) {
 	)
 }
 
-func (ss *StorageService) processCDNInvalidation(invalidationID primitive.ObjectID, paths []string) {
+func (ss *StorageService) processCDNInvalidation(ctx context.Context, invalidationID primitive.ObjectID, paths []string) {
 	// Implementation for CDN invalidation
 	time.Sleep(2 * time.Second) // Simulate CDN processing
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	database.GetCollection("cdn_invalidations").UpdateOne(ctx,
+	ss.collection("cdn_invalidations").UpdateOne(ctx,
 		bson.M{"_id": invalidationID},
 		bson.M{"$set": bson.M{
 			"status":       "completed",
@@ -1212,9 +1246,9 @@ func (ss *StorageService) processCDNInvalidation(invalidationID primitive.Object
 	)
 }
 
-func (ss *StorageService) processImageOptimization(jobID primitive.ObjectID, images []bson.M) {
+func (ss *StorageService) processImageOptimization(ctx context.Context, jobID primitive.ObjectID, images []bson.M) {
 	// Implementation for image optimization
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
 	for i, image := range images {
@@ -1222,7 +1256,7 @@ func (ss *StorageService) processImageOptimization(jobID primitive.ObjectID, ima
 		time.Sleep(500 * time.Millisecond)
 
 		// Update progress
-		database.GetCollection("optimization_jobs").UpdateOne(ctx,
+		ss.collection("optimization_jobs").UpdateOne(ctx,
 			bson.M{"_id": jobID},
 			bson.M{"$set": bson.M{
 				"processed":  i + 1,
@@ -1240,7 +1274,7 @@ func (ss *StorageService) processImageOptimization(jobID primitive.ObjectID, ima
 	}
 
 	// Mark job as completed
-	database.GetCollection("optimization_jobs").UpdateOne(ctx,
+	ss.collection("optimization_jobs").UpdateOne(ctx,
 		bson.M{"_id": jobID},
 		bson.M{"$set": bson.M{
 			"status":       "completed",
@@ -1249,9 +1283,9 @@ func (ss *StorageService) processImageOptimization(jobID primitive.ObjectID, ima
 	)
 }
 
-func (ss *StorageService) processBackup(backupID primitive.ObjectID) {
+func (ss *StorageService) processBackup(ctx context.Context, backupID primitive.ObjectID) {
 	// Implementation for backup processing
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
 	defer cancel()
 
 	// Count total files
@@ -1281,14 +1315,14 @@ func (ss *StorageService) processBackup(backupID primitive.ObjectID) {
 	)
 }
 
-func (ss *StorageService) processRestore(restoreID, backupID primitive.ObjectID) {
+func (ss *StorageService) processRestore(ctx context.Context, restoreID, backupID primitive.ObjectID) {
 	// Implementation for restore processing
 	time.Sleep(20 * time.Second) // Simulate restore work
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	database.GetCollection("restore_jobs").UpdateOne(ctx,
+	ss.collection("restore_jobs").UpdateOne(ctx,
 		bson.M{"_id": restoreID},
 		bson.M{"$set": bson.M{
 			"status":       "completed",
@@ -1298,68 +1332,74 @@ func (ss *StorageService) processRestore(restoreID, backupID primitive.ObjectID)
 }
 
 // UploadFile uploads a file to the specified storage provider
-func (ss *StorageService) UploadFile(providerType, storageKey string, fileContent []byte) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+func (ss *StorageService) UploadFile(ctx context.Context, providerType, storageKey string, fileContent []byte) error {
+	lookupCtx, cancel := context.WithTimeout(ctx, 60*time.Second)
 	defer cancel()
 
 	// Get provider configuration
 	var provider models.StorageProvider
-	err := ss.providerCollection.FindOne(ctx, bson.M{
+	err := ss.providerCollection.FindOne(lookupCtx, bson.M{
 		"type":      providerType,
 		"is_active": true,
 	}).Decode(&provider)
 	if err != nil {
 		return fmt.Errorf("provider not found: %v", err)
 	}
+	defer uncacheObject(providerType, storageKey)
 
 	// Handle upload based on provider type
-	switch strings.ToLower(providerType) {
-	case "local":
-		return ss.uploadToLocal(&provider, storageKey, fileContent)
-	case "s3":
-		return ss.uploadToS3(&provider, storageKey, fileContent)
-	case "wasabi":
-		return ss.uploadToWasabi(&provider, storageKey, fileContent)
-	case "r2":
-		return ss.uploadToR2(&provider, storageKey, fileContent)
-	default:
-		return fmt.Errorf("unsupported storage provider: %s", providerType)
-	}
+	return callProvider(ctx, &provider, func() error {
+		switch strings.ToLower(providerType) {
+		case "local":
+			return ss.uploadToLocal(ctx, &provider, storageKey, fileContent)
+		case "s3":
+			return ss.uploadToS3(ctx, &provider, storageKey, fileContent)
+		case "wasabi":
+			return ss.uploadToWasabi(ctx, &provider, storageKey, fileContent)
+		case "r2":
+			return ss.uploadToR2(ctx, &provider, storageKey, fileContent)
+		default:
+			return fmt.Errorf("unsupported storage provider: %s", providerType)
+		}
+	})
 }
 
 // DeleteFile deletes a file from the specified storage provider
-func (ss *StorageService) DeleteFile(providerType, storageKey string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+func (ss *StorageService) DeleteFile(ctx context.Context, providerType, storageKey string) error {
+	lookupCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
 	// Get provider configuration
 	var provider models.StorageProvider
-	err := ss.providerCollection.FindOne(ctx, bson.M{
+	err := ss.providerCollection.FindOne(lookupCtx, bson.M{
 		"type":      providerType,
 		"is_active": true,
 	}).Decode(&provider)
 	if err != nil {
 		return fmt.Errorf("provider not found: %v", err)
 	}
+	defer uncacheObject(providerType, storageKey)
 
 	// Handle deletion based on provider type
-	switch strings.ToLower(providerType) {
-	case "local":
-		return ss.deleteFromLocal(&provider, storageKey)
-	case "s3":
-		return ss.deleteFromS3(&provider, storageKey)
-	case "wasabi":
-		return ss.deleteFromWasabi(&provider, storageKey)
-	case "r2":
-		return ss.deleteFromR2(&provider, storageKey)
-	default:
-		return fmt.Errorf("unsupported storage provider: %s", providerType)
-	}
+	return callProvider(ctx, &provider, func() error {
+		switch strings.ToLower(providerType) {
+		case "local":
+			return ss.deleteFromLocal(ctx, &provider, storageKey)
+		case "s3":
+			return ss.deleteFromS3(ctx, &provider, storageKey)
+		case "wasabi":
+			return ss.deleteFromWasabi(ctx, &provider, storageKey)
+		case "r2":
+			return ss.deleteFromR2(ctx, &provider, storageKey)
+		default:
+			return fmt.Errorf("unsupported storage provider: %s", providerType)
+		}
+	})
 }
 
 // GetPresignedURL generates a presigned URL for file access
-func (ss *StorageService) GetPresignedURL(providerType, storageKey string, expiration time.Duration, operation string) (string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (ss *StorageService) GetPresignedURL(ctx context.Context, providerType, storageKey string, expiration time.Duration, operation string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	// Get provider configuration
@@ -1372,10 +1412,18 @@ func (ss *StorageService) GetPresignedURL(providerType, storageKey string, expir
 		return "", fmt.Errorf("provider not found: %v", err)
 	}
 
+	// Links are handed out to a replica while the provider is unavailable
+	if breakerFor(providerType).rejecting() {
+		if replica := ss.replicaForLinks(ctx, &provider, storageKey); replica != nil {
+			provider = *replica
+			providerType = replica.Type
+		}
+	}
+
 	// Handle presigned URL generation based on provider type
 	switch strings.ToLower(providerType) {
 	case "local":
-		return ss.getLocalPresignedURL(&provider, storageKey, expiration, operation)
+		return ss.getLocalPresignedURL(ctx, &provider, storageKey, expiration, operation)
 	case "s3":
 		return ss.getS3PresignedURL(&provider, storageKey, expiration, operation)
 	case "wasabi":
@@ -1388,13 +1436,13 @@ func (ss *StorageService) GetPresignedURL(providerType, storageKey string, expir
 }
 
 // DownloadFile downloads a file from the specified storage provider
-func (ss *StorageService) DownloadFile(providerType, storageKey string) ([]byte, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+func (ss *StorageService) DownloadFile(ctx context.Context, providerType, storageKey string) ([]byte, error) {
+	lookupCtx, cancel := context.WithTimeout(ctx, 60*time.Second)
 	defer cancel()
 
 	// Get provider configuration
 	var provider models.StorageProvider
-	err := ss.providerCollection.FindOne(ctx, bson.M{
+	err := ss.providerCollection.FindOne(lookupCtx, bson.M{
 		"type":      providerType,
 		"is_active": true,
 	}).Decode(&provider)
@@ -1402,38 +1450,87 @@ func (ss *StorageService) DownloadFile(providerType, storageKey string) ([]byte,
 		return nil, fmt.Errorf("provider not found: %v", err)
 	}
 
-	// Handle download based on provider type
-	switch strings.ToLower(providerType) {
-	case "local":
-		return ss.downloadFromLocal(&provider, storageKey)
-	case "s3":
-		return ss.downloadFromS3(&provider, storageKey)
-	case "wasabi":
-		return ss.downloadFromWasabi(&provider, storageKey)
-	case "r2":
-		return ss.downloadFromR2(&provider, storageKey)
-	default:
-		return nil, fmt.Errorf("unsupported storage provider: %s", providerType)
+	// Objects of remote providers are read from the disk cache when cached
+	cacheKey := objectCacheKey(providerType, storageKey)
+	var generation uint64
+	if cacheKey != "" {
+		if content, ok := objectCache.Get(cacheKey); ok {
+			return content, nil
+		}
+		generation = objectCache.Generation()
+	}
+
+	// Reads the provider fails fail over to its replicas
+	content, err := ss.downloadWithPolicy(ctx, &provider, storageKey)
+	if err != nil {
+		if content, err = ss.readFromReplicas(ctx, &provider, storageKey, err); err != nil {
+			return nil, err
+		}
+	}
+
+	if cacheKey != "" {
+		cacheObject(cacheKey, content, generation)
+	}
+	return content, nil
+}
+
+// downloadWithPolicy downloads an object from a provider under its retry,
+// timeout and circuit breaker policy
+func (ss *StorageService) downloadWithPolicy(ctx context.Context, provider *models.StorageProvider, storageKey string) ([]byte, error) {
+	var content []byte
+	err := callProvider(ctx, provider, func() error {
+		var err error
+		switch strings.ToLower(provider.Type) {
+		case "local":
+			content, err = ss.downloadFromLocal(ctx, provider, storageKey)
+		case "s3":
+			content, err = ss.downloadFromS3(ctx, provider, storageKey)
+		case "wasabi":
+			content, err = ss.downloadFromWasabi(ctx, provider, storageKey)
+		case "r2":
+			content, err = ss.downloadFromR2(ctx, provider, storageKey)
+		default:
+			err = fmt.Errorf("unsupported storage provider: %s", provider.Type)
+		}
+		return err
+	})
+	return content, err
+}
+
+// findActiveProvider returns the active provider of a type
+func (ss *StorageService) findActiveProvider(ctx context.Context, providerType string) (*models.StorageProvider, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var provider models.StorageProvider
+	err := ss.providerCollection.FindOne(ctx, bson.M{
+		"type":      providerType,
+		"is_active": true,
+	}).Decode(&provider)
+	if err != nil {
+		return nil, fmt.Errorf("provider not found: %v", err)
 	}
+	return &provider, nil
 }
 
 // CopyFile copies a file within or between storage providers
-func (ss *StorageService) CopyFile(sourceProviderType, sourceKey, destProviderType, destKey string) error {
-	_, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+func (ss *StorageService) CopyFile(ctx context.Context, sourceProviderType, sourceKey, destProviderType, destKey string) error {
+	_, cancel := context.WithTimeout(ctx, 120*time.Second)
 	defer cancel()
+	defer uncacheObject(destProviderType, destKey)
 
 	// If same provider, use provider-specific copy
 	if sourceProviderType == destProviderType {
-		return ss.copyWithinProvider(sourceProviderType, sourceKey, destKey)
+		return ss.copyWithinProvider(ctx, sourceProviderType, sourceKey, destKey)
 	}
 
 	// Cross-provider copy: download from source and upload to destination
-	fileContent, err := ss.DownloadFile(sourceProviderType, sourceKey)
+	fileContent, err := ss.DownloadFile(ctx, sourceProviderType, sourceKey)
 	if err != nil {
 		return fmt.Errorf("failed to download source file: %v", err)
 	}
 
-	err = ss.UploadFile(destProviderType, destKey, fileContent)
+	err = ss.UploadFile(ctx, destProviderType, destKey, fileContent)
 	if err != nil {
 		return fmt.Errorf("failed to upload to destination: %v", err)
 	}
@@ -1442,7 +1539,7 @@ func (ss *StorageService) CopyFile(sourceProviderType, sourceKey, destProviderTy
 }
 
 // Local Storage Implementation
-func (ss *StorageService) uploadToLocal(provider *models.StorageProvider, storageKey string, fileContent []byte) error {
+func (ss *StorageService) uploadToLocal(ctx context.Context, provider *models.StorageProvider, storageKey string, fileContent []byte) error {
 	basePath, exists := provider.Settings["base_path"].(string)
 	if !exists || basePath == "" {
 		basePath = "./uploads"
@@ -1461,11 +1558,11 @@ func (ss *StorageService) uploadToLocal(provider *models.StorageProvider, storag
 	}
 
 	// Log activity
-	ss.logStorageActivity("upload", provider.ID, storageKey, int64(len(fileContent)), nil)
+	ss.logStorageActivity(ctx, "upload", provider.ID, storageKey, int64(len(fileContent)), nil)
 	return nil
 }
 
-func (ss *StorageService) deleteFromLocal(provider *models.StorageProvider, storageKey string) error {
+func (ss *StorageService) deleteFromLocal(ctx context.Context, provider *models.StorageProvider, storageKey string) error {
 	basePath, exists := provider.Settings["base_path"].(string)
 	if !exists || basePath == "" {
 		basePath = "./uploads"
@@ -1477,11 +1574,11 @@ func (ss *StorageService) deleteFromLocal(provider *models.StorageProvider, stor
 	}
 
 	// Log activity
-	ss.logStorageActivity("delete", provider.ID, storageKey, 0, nil)
+	ss.logStorageActivity(ctx, "delete", provider.ID, storageKey, 0, nil)
 	return nil
 }
 
-func (ss *StorageService) downloadFromLocal(provider *models.StorageProvider, storageKey string) ([]byte, error) {
+func (ss *StorageService) downloadFromLocal(ctx context.Context, provider *models.StorageProvider, storageKey string) ([]byte, error) {
 	basePath, exists := provider.Settings["base_path"].(string)
 	if !exists || basePath == "" {
 		basePath = "./uploads"
@@ -1494,16 +1591,16 @@ func (ss *StorageService) downloadFromLocal(provider *models.StorageProvider, st
 	}
 
 	// Log activity
-	ss.logStorageActivity("download", provider.ID, storageKey, int64(len(content)), nil)
+	ss.logStorageActivity(ctx, "download", provider.ID, storageKey, int64(len(content)), nil)
 	return content, nil
 }
 
-func (ss *StorageService) getLocalPresignedURL(provider *models.StorageProvider, storageKey string, expiration time.Duration, operation string) (string, error) {
+func (ss *StorageService) getLocalPresignedURL(ctx context.Context, provider *models.StorageProvider, storageKey string, expiration time.Duration, operation string) (string, error) {
 	// For local storage, generate a temporary access token
 	token := utils.GenerateRandomString(32)
 
 	// Store token in cache/database with expiration
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	tokenDoc := bson.M{
@@ -1530,23 +1627,23 @@ func (ss *StorageService) getLocalPresignedURL(provider *models.StorageProvider,
 }
 
 // S3 Storage Implementation
-func (ss *StorageService) uploadToS3(provider *models.StorageProvider, storageKey string, fileContent []byte) error {
+func (ss *StorageService) uploadToS3(ctx context.Context, provider *models.StorageProvider, storageKey string, fileContent []byte) error {
 	client, err := storage.NewS3Client(provider)
 	if err != nil {
 		return fmt.Errorf("failed to create S3 client: %v", err)
 	}
 
-	err = client.Upload(storageKey, fileContent)
+	err = uploadObject(client, storageKey, fileContent)
 	if err != nil {
 		return fmt.Errorf("S3 upload failed: %v", err)
 	}
 
 	// Log activity
-	ss.logStorageActivity("upload", provider.ID, storageKey, int64(len(fileContent)), nil)
+	ss.logStorageActivity(ctx, "upload", provider.ID, storageKey, int64(len(fileContent)), nil)
 	return nil
 }
 
-func (ss *StorageService) deleteFromS3(provider *models.StorageProvider, storageKey string) error {
+func (ss *StorageService) deleteFromS3(ctx context.Context, provider *models.StorageProvider, storageKey string) error {
 	client, err := storage.NewS3Client(provider)
 	if err != nil {
 		return fmt.Errorf("failed to create S3 client: %v", err)
@@ -1558,23 +1655,23 @@ func (ss *StorageService) deleteFromS3(provider *models.StorageProvider, storage
 	}
 
 	// Log activity
-	ss.logStorageActivity("delete", provider.ID, storageKey, 0, nil)
+	ss.logStorageActivity(ctx, "delete", provider.ID, storageKey, 0, nil)
 	return nil
 }
 
-func (ss *StorageService) downloadFromS3(provider *models.StorageProvider, storageKey string) ([]byte, error) {
+func (ss *StorageService) downloadFromS3(ctx context.Context, provider *models.StorageProvider, storageKey string) ([]byte, error) {
 	client, err := storage.NewS3Client(provider)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create S3 client: %v", err)
 	}
 
-	content, err := client.Download(storageKey)
+	content, err := downloadObject(client, storageKey)
 	if err != nil {
 		return nil, fmt.Errorf("S3 download failed: %v", err)
 	}
 
 	// Log activity
-	ss.logStorageActivity("download", provider.ID, storageKey, int64(len(content)), nil)
+	ss.logStorageActivity(ctx, "download", provider.ID, storageKey, int64(len(content)), nil)
 	return content, nil
 }
 
@@ -1602,23 +1699,23 @@ func (ss *StorageService) getS3PresignedURL(provider *models.StorageProvider, st
 }
 
 // Wasabi Storage Implementation
-func (ss *StorageService) uploadToWasabi(provider *models.StorageProvider, storageKey string, fileContent []byte) error {
+func (ss *StorageService) uploadToWasabi(ctx context.Context, provider *models.StorageProvider, storageKey string, fileContent []byte) error {
 	client, err := storage.NewWasabiClient(provider)
 	if err != nil {
 		return fmt.Errorf("failed to create Wasabi client: %v", err)
 	}
 
-	err = client.Upload(storageKey, fileContent)
+	err = uploadObject(client, storageKey, fileContent)
 	if err != nil {
 		return fmt.Errorf("Wasabi upload failed: %v", err)
 	}
 
 	// Log activity
-	ss.logStorageActivity("upload", provider.ID, storageKey, int64(len(fileContent)), nil)
+	ss.logStorageActivity(ctx, "upload", provider.ID, storageKey, int64(len(fileContent)), nil)
 	return nil
 }
 
-func (ss *StorageService) deleteFromWasabi(provider *models.StorageProvider, storageKey string) error {
+func (ss *StorageService) deleteFromWasabi(ctx context.Context, provider *models.StorageProvider, storageKey string) error {
 	client, err := storage.NewWasabiClient(provider)
 	if err != nil {
 		return fmt.Errorf("failed to create Wasabi client: %v", err)
@@ -1630,23 +1727,23 @@ func (ss *StorageService) deleteFromWasabi(provider *models.StorageProvider, sto
 	}
 
 	// Log activity
-	ss.logStorageActivity("delete", provider.ID, storageKey, 0, nil)
+	ss.logStorageActivity(ctx, "delete", provider.ID, storageKey, 0, nil)
 	return nil
 }
 
-func (ss *StorageService) downloadFromWasabi(provider *models.StorageProvider, storageKey string) ([]byte, error) {
+func (ss *StorageService) downloadFromWasabi(ctx context.Context, provider *models.StorageProvider, storageKey string) ([]byte, error) {
 	client, err := storage.NewWasabiClient(provider)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Wasabi client: %v", err)
 	}
 
-	content, err := client.Download(storageKey)
+	content, err := downloadObject(client, storageKey)
 	if err != nil {
 		return nil, fmt.Errorf("Wasabi download failed: %v", err)
 	}
 
 	// Log activity
-	ss.logStorageActivity("download", provider.ID, storageKey, int64(len(content)), nil)
+	ss.logStorageActivity(ctx, "download", provider.ID, storageKey, int64(len(content)), nil)
 	return content, nil
 }
 
@@ -1674,23 +1771,23 @@ func (ss *StorageService) getWasabiPresignedURL(provider *models.StorageProvider
 }
 
 // R2 Storage Implementation
-func (ss *StorageService) uploadToR2(provider *models.StorageProvider, storageKey string, fileContent []byte) error {
+func (ss *StorageService) uploadToR2(ctx context.Context, provider *models.StorageProvider, storageKey string, fileContent []byte) error {
 	client, err := storage.NewR2Client(provider)
 	if err != nil {
 		return fmt.Errorf("failed to create R2 client: %v", err)
 	}
 
-	err = client.Upload(storageKey, fileContent)
+	err = uploadObject(client, storageKey, fileContent)
 	if err != nil {
 		return fmt.Errorf("R2 upload failed: %v", err)
 	}
 
 	// Log activity
-	ss.logStorageActivity("upload", provider.ID, storageKey, int64(len(fileContent)), nil)
+	ss.logStorageActivity(ctx, "upload", provider.ID, storageKey, int64(len(fileContent)), nil)
 	return nil
 }
 
-func (ss *StorageService) deleteFromR2(provider *models.StorageProvider, storageKey string) error {
+func (ss *StorageService) deleteFromR2(ctx context.Context, provider *models.StorageProvider, storageKey string) error {
 	client, err := storage.NewR2Client(provider)
 	if err != nil {
 		return fmt.Errorf("failed to create R2 client: %v", err)
@@ -1702,23 +1799,23 @@ func (ss *StorageService) deleteFromR2(provider *models.StorageProvider, storage
 	}
 
 	// Log activity
-	ss.logStorageActivity("delete", provider.ID, storageKey, 0, nil)
+	ss.logStorageActivity(ctx, "delete", provider.ID, storageKey, 0, nil)
 	return nil
 }
 
-func (ss *StorageService) downloadFromR2(provider *models.StorageProvider, storageKey string) ([]byte, error) {
+func (ss *StorageService) downloadFromR2(ctx context.Context, provider *models.StorageProvider, storageKey string) ([]byte, error) {
 	client, err := storage.NewR2Client(provider)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create R2 client: %v", err)
 	}
 
-	content, err := client.Download(storageKey)
+	content, err := downloadObject(client, storageKey)
 	if err != nil {
 		return nil, fmt.Errorf("R2 download failed: %v", err)
 	}
 
 	// Log activity
-	ss.logStorageActivity("download", provider.ID, storageKey, int64(len(content)), nil)
+	ss.logStorageActivity(ctx, "download", provider.ID, storageKey, int64(len(content)), nil)
 	return content, nil
 }
 
@@ -1746,13 +1843,13 @@ func (ss *StorageService) getR2PresignedURL(provider *models.StorageProvider, st
 }
 
 // Helper Functions
-func (ss *StorageService) copyWithinProvider(providerType, sourceKey, destKey string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+func (ss *StorageService) copyWithinProvider(ctx context.Context, providerType, sourceKey, destKey string) error {
+	lookupCtx, cancel := context.WithTimeout(ctx, 60*time.Second)
 	defer cancel()
 
 	// Get provider configuration
 	var provider models.StorageProvider
-	err := ss.providerCollection.FindOne(ctx, bson.M{
+	err := ss.providerCollection.FindOne(lookupCtx, bson.M{
 		"type":      providerType,
 		"is_active": true,
 	}).Decode(&provider)
@@ -1760,26 +1857,28 @@ func (ss *StorageService) copyWithinProvider(providerType, sourceKey, destKey st
 		return fmt.Errorf("provider not found: %v", err)
 	}
 
-	switch strings.ToLower(providerType) {
-	case "local":
-		return ss.copyLocalFile(&provider, sourceKey, destKey)
-	case "s3":
-		return ss.copyS3File(&provider, sourceKey, destKey)
-	case "wasabi":
-		return ss.copyWasabiFile(&provider, sourceKey, destKey)
-	case "r2":
-		return ss.copyR2File(&provider, sourceKey, destKey)
-	default:
-		// Fallback to download/upload
-		content, err := ss.downloadFromProvider(&provider, sourceKey)
-		if err != nil {
-			return err
+	return callProvider(ctx, &provider, func() error {
+		switch strings.ToLower(providerType) {
+		case "local":
+			return ss.copyLocalFile(ctx, &provider, sourceKey, destKey)
+		case "s3":
+			return ss.copyS3File(ctx, &provider, sourceKey, destKey)
+		case "wasabi":
+			return ss.copyWasabiFile(ctx, &provider, sourceKey, destKey)
+		case "r2":
+			return ss.copyR2File(ctx, &provider, sourceKey, destKey)
+		default:
+			// Fallback to download/upload
+			content, err := ss.downloadFromProvider(ctx, &provider, sourceKey)
+			if err != nil {
+				return err
+			}
+			return ss.uploadToProvider(ctx, &provider, destKey, content)
 		}
-		return ss.uploadToProvider(&provider, destKey, content)
-	}
+	})
 }
 
-func (ss *StorageService) copyLocalFile(provider *models.StorageProvider, sourceKey, destKey string) error {
+func (ss *StorageService) copyLocalFile(ctx context.Context, provider *models.StorageProvider, sourceKey, destKey string) error {
 	basePath, exists := provider.Settings["base_path"].(string)
 	if !exists || basePath == "" {
 		basePath = "./uploads"
@@ -1813,14 +1912,14 @@ func (ss *StorageService) copyLocalFile(provider *models.StorageProvider, source
 	}
 
 	// Log activity
-	ss.logStorageActivity("copy", provider.ID, destKey, 0, map[string]interface{}{
+	ss.logStorageActivity(ctx, "copy", provider.ID, destKey, 0, map[string]interface{}{
 		"source_key": sourceKey,
 	})
 
 	return nil
 }
 
-func (ss *StorageService) copyS3File(provider *models.StorageProvider, sourceKey, destKey string) error {
+func (ss *StorageService) copyS3File(ctx context.Context, provider *models.StorageProvider, sourceKey, destKey string) error {
 	client, err := storage.NewS3Client(provider)
 	if err != nil {
 		return fmt.Errorf("failed to create S3 client: %v", err)
@@ -1832,14 +1931,14 @@ func (ss *StorageService) copyS3File(provider *models.StorageProvider, sourceKey
 	}
 
 	// Log activity
-	ss.logStorageActivity("copy", provider.ID, destKey, 0, map[string]interface{}{
+	ss.logStorageActivity(ctx, "copy", provider.ID, destKey, 0, map[string]interface{}{
 		"source_key": sourceKey,
 	})
 
 	return nil
 }
 
-func (ss *StorageService) copyWasabiFile(provider *models.StorageProvider, sourceKey, destKey string) error {
+func (ss *StorageService) copyWasabiFile(ctx context.Context, provider *models.StorageProvider, sourceKey, destKey string) error {
 	client, err := storage.NewWasabiClient(provider)
 	if err != nil {
 		return fmt.Errorf("failed to create Wasabi client: %v", err)
@@ -1851,14 +1950,14 @@ func (ss *StorageService) copyWasabiFile(provider *models.StorageProvider, sourc
 	}
 
 	// Log activity
-	ss.logStorageActivity("copy", provider.ID, destKey, 0, map[string]interface{}{
+	ss.logStorageActivity(ctx, "copy", provider.ID, destKey, 0, map[string]interface{}{
 		"source_key": sourceKey,
 	})
 
 	return nil
 }
 
-func (ss *StorageService) copyR2File(provider *models.StorageProvider, sourceKey, destKey string) error {
+func (ss *StorageService) copyR2File(ctx context.Context, provider *models.StorageProvider, sourceKey, destKey string) error {
 	client, err := storage.NewR2Client(provider)
 	if err != nil {
 		return fmt.Errorf("failed to create R2 client: %v", err)
@@ -1870,45 +1969,45 @@ func (ss *StorageService) copyR2File(provider *models.StorageProvider, sourceKey
 	}
 
 	// Log activity
-	ss.logStorageActivity("copy", provider.ID, destKey, 0, map[string]interface{}{
+	ss.logStorageActivity(ctx, "copy", provider.ID, destKey, 0, map[string]interface{}{
 		"source_key": sourceKey,
 	})
 
 	return nil
 }
 
-func (ss *StorageService) downloadFromProvider(provider *models.StorageProvider, storageKey string) ([]byte, error) {
+func (ss *StorageService) downloadFromProvider(ctx context.Context, provider *models.StorageProvider, storageKey string) ([]byte, error) {
 	switch strings.ToLower(provider.Type) {
 	case "local":
-		return ss.downloadFromLocal(provider, storageKey)
+		return ss.downloadFromLocal(ctx, provider, storageKey)
 	case "s3":
-		return ss.downloadFromS3(provider, storageKey)
+		return ss.downloadFromS3(ctx, provider, storageKey)
 	case "wasabi":
-		return ss.downloadFromWasabi(provider, storageKey)
+		return ss.downloadFromWasabi(ctx, provider, storageKey)
 	case "r2":
-		return ss.downloadFromR2(provider, storageKey)
+		return ss.downloadFromR2(ctx, provider, storageKey)
 	default:
 		return nil, fmt.Errorf("unsupported provider type: %s", provider.Type)
 	}
 }
 
-func (ss *StorageService) uploadToProvider(provider *models.StorageProvider, storageKey string, content []byte) error {
+func (ss *StorageService) uploadToProvider(ctx context.Context, provider *models.StorageProvider, storageKey string, content []byte) error {
 	switch strings.ToLower(provider.Type) {
 	case "local":
-		return ss.uploadToLocal(provider, storageKey, content)
+		return ss.uploadToLocal(ctx, provider, storageKey, content)
 	case "s3":
-		return ss.uploadToS3(provider, storageKey, content)
+		return ss.uploadToS3(ctx, provider, storageKey, content)
 	case "wasabi":
-		return ss.uploadToWasabi(provider, storageKey, content)
+		return ss.uploadToWasabi(ctx, provider, storageKey, content)
 	case "r2":
-		return ss.uploadToR2(provider, storageKey, content)
+		return ss.uploadToR2(ctx, provider, storageKey, content)
 	default:
 		return fmt.Errorf("unsupported provider type: %s", provider.Type)
 	}
 }
 
-func (ss *StorageService) logStorageActivity(action string, providerID primitive.ObjectID, storageKey string, size int64, metadata map[string]interface{}) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (ss *StorageService) logStorageActivity(ctx context.Context, action string, providerID primitive.ObjectID, storageKey string, size int64, metadata map[string]interface{}) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	activity := bson.M{
@@ -0,0 +1,531 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"oncloud/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// helpdeskExportTimeout bounds an export, retries included
+const helpdeskExportTimeout = 30 * time.Second
+
+var (
+	ErrSupportNoteNotFound     = notFoundError("support note not found")
+	ErrSupportTicketNotFound   = notFoundError("support ticket not found")
+	ErrSupportUserNotFound     = notFoundError("user not found")
+	ErrSupportFileNotFound     = errors.New("file not found in the user's account")
+	ErrSupportAssigneeNotFound = errors.New("assignee not found")
+	ErrHelpdeskNotConfigured   = errors.New("no helpdesk is configured")
+	ErrTicketAlreadyExported   = errors.New("ticket was already exported to the helpdesk")
+	ErrHelpdeskExportFailed    = errors.New("helpdesk export failed")
+)
+
+// openTicketStatuses are the statuses of tickets still being worked on
+var openTicketStatuses = []string{models.TicketOpen, models.TicketPending}
+
+// SupportTicketFilter narrows a support ticket listing
+type SupportTicketFilter struct {
+	Status     string
+	Priority   string
+	UserID     *primitive.ObjectID
+	FileID     *primitive.ObjectID
+	AssignedTo *primitive.ObjectID
+}
+
+type SupportService struct {
+	*BaseService
+	auditService *AuditService
+}
+
+func NewSupportService() *SupportService {
+	return NewSupportServiceWith(Dependencies{})
+}
+
+// NewSupportServiceWith creates a support service on the given dependencies
+func NewSupportServiceWith(deps Dependencies) *SupportService {
+	return &SupportService{
+		BaseService:  NewBaseServiceWith(deps),
+		auditService: NewAuditServiceWith(deps),
+	}
+}
+
+// HelpdeskProvider returns the helpdesk tickets are exported to, or "" when
+// none is configured
+func (ss *SupportService) HelpdeskProvider() string {
+	if activeHelpdesk == nil {
+		return ""
+	}
+	return activeHelpdesk.Name()
+}
+
+// Notes
+
+// ListNotes returns the internal notes on a user's account, pinned notes
+// first and then newest first
+func (ss *SupportService) ListNotes(ctx context.Context, userID primitive.ObjectID) ([]models.SupportNote, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	cursor, err := ss.collections.SupportNotes().Find(ctx, bson.M{"user_id": userID},
+		options.Find().SetSort(bson.D{{Key: "pinned", Value: -1}, {Key: "created_at", Value: -1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	notes := []models.SupportNote{}
+	if err := cursor.All(ctx, &notes); err != nil {
+		return nil, err
+	}
+	return notes, nil
+}
+
+// AddNote adds an internal note to a user's account
+func (ss *SupportService) AddNote(ctx context.Context, userID primitive.ObjectID, req *models.SupportNoteRequest, adminID primitive.ObjectID) (*models.SupportNote, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if err := ss.checkUser(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	note := &models.SupportNote{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		Body:      req.Body,
+		Pinned:    req.Pinned,
+		CreatedBy: adminID,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if _, err := ss.collections.SupportNotes().InsertOne(ctx, note); err != nil {
+		return nil, fmt.Errorf("failed to add note: %v", err)
+	}
+
+	ss.record(ctx, "support_note.created", adminID, "user", userID.Hex(), map[string]interface{}{"note_id": note.ID})
+	return note, nil
+}
+
+// UpdateNote edits one of the notes on a user's account
+func (ss *SupportService) UpdateNote(ctx context.Context, userID, noteID primitive.ObjectID, req *models.SupportNoteRequest, adminID primitive.ObjectID) (*models.SupportNote, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var note models.SupportNote
+	err := ss.collections.SupportNotes().FindOneAndUpdate(ctx,
+		bson.M{"_id": noteID, "user_id": userID},
+		bson.M{"$set": bson.M{
+			"body":       req.Body,
+			"pinned":     req.Pinned,
+			"updated_by": adminID,
+			"updated_at": time.Now(),
+		}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&note)
+	if err != nil {
+		return nil, ErrSupportNoteNotFound
+	}
+
+	ss.record(ctx, "support_note.updated", adminID, "user", userID.Hex(), map[string]interface{}{"note_id": noteID})
+	return &note, nil
+}
+
+// DeleteNote removes one of the notes on a user's account
+func (ss *SupportService) DeleteNote(ctx context.Context, userID, noteID primitive.ObjectID, adminID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := ss.collections.SupportNotes().DeleteOne(ctx, bson.M{"_id": noteID, "user_id": userID})
+	if err != nil {
+		return fmt.Errorf("failed to delete note: %v", err)
+	}
+	if result.DeletedCount == 0 {
+		return ErrSupportNoteNotFound
+	}
+
+	ss.record(ctx, "support_note.deleted", adminID, "user", userID.Hex(), map[string]interface{}{"note_id": noteID})
+	return nil
+}
+
+// Tickets
+
+// CreateTicket opens a ticket for a user. The files it is about must be
+// the user's. It is exported to the helpdesk straight away when automatic
+// export is on, or when req.Export asks for it; a failed export is kept in
+// the ticket's history and can be retried with ExportTicket.
+func (ss *SupportService) CreateTicket(ctx context.Context, req *models.SupportTicketRequest, adminID primitive.ObjectID) (*models.SupportTicket, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	userID, _ := primitive.ObjectIDFromHex(req.UserID)
+	if err := ss.checkUser(dbCtx, userID); err != nil {
+		return nil, err
+	}
+	fileIDs, err := ss.userFileIDs(dbCtx, userID, req.FileIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	ticket := &models.SupportTicket{
+		ID:          primitive.NewObjectID(),
+		UserID:      userID,
+		FileIDs:     fileIDs,
+		Subject:     req.Subject,
+		Description: req.Description,
+		Status:      models.TicketOpen,
+		Priority:    req.Priority,
+		Category:    req.Category,
+		History: []models.SupportTicketEvent{{
+			Action:  "created",
+			ActorID: &adminID,
+			At:      now,
+		}},
+		CreatedBy: adminID,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if ticket.Priority == "" {
+		ticket.Priority = models.TicketPriorityNormal
+	}
+	if req.AssignedTo != "" {
+		assignee, err := ss.assignee(dbCtx, req.AssignedTo)
+		if err != nil {
+			return nil, err
+		}
+		ticket.AssignedTo = &assignee
+	}
+
+	if _, err := ss.collections.SupportTickets().InsertOne(dbCtx, ticket); err != nil {
+		return nil, fmt.Errorf("failed to create ticket: %v", err)
+	}
+	ss.record(ctx, "support_ticket.created", adminID, "support_ticket", ticket.ID.Hex(), map[string]interface{}{
+		"user_id":  userID,
+		"priority": ticket.Priority,
+	})
+
+	export := helpdeskAutoExport
+	if req.Export != nil {
+		export = *req.Export && activeHelpdesk != nil
+	}
+	if export {
+		if exported, err := ss.ExportTicket(ctx, ticket.ID, adminID); err == nil {
+			ticket = exported
+		} else if refreshed, findErr := ss.GetTicket(ctx, ticket.ID); findErr == nil {
+			ticket = refreshed
+		}
+	}
+
+	return ticket, nil
+}
+
+// ListTickets returns support tickets, newest first
+func (ss *SupportService) ListTickets(ctx context.Context, filter SupportTicketFilter, page, limit int) ([]models.SupportTicket, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	query := bson.M{}
+	switch filter.Status {
+	case "":
+	case "active":
+		query["status"] = bson.M{"$in": openTicketStatuses}
+	default:
+		query["status"] = filter.Status
+	}
+	if filter.Priority != "" {
+		query["priority"] = filter.Priority
+	}
+	if filter.UserID != nil {
+		query["user_id"] = *filter.UserID
+	}
+	if filter.FileID != nil {
+		query["file_ids"] = *filter.FileID
+	}
+	if filter.AssignedTo != nil {
+		query["assigned_to"] = *filter.AssignedTo
+	}
+
+	cursor, err := ss.collections.SupportTickets().Find(ctx, query,
+		options.Find().
+			SetSort(bson.M{"created_at": -1}).
+			SetSkip(int64((page-1)*limit)).
+			SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	tickets := []models.SupportTicket{}
+	if err := cursor.All(ctx, &tickets); err != nil {
+		return nil, 0, err
+	}
+
+	total, err := ss.collections.SupportTickets().CountDocuments(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return tickets, int(total), nil
+}
+
+// GetTicket returns one support ticket
+func (ss *SupportService) GetTicket(ctx context.Context, ticketID primitive.ObjectID) (*models.SupportTicket, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var ticket models.SupportTicket
+	if err := ss.collections.SupportTickets().FindOne(ctx, bson.M{"_id": ticketID}).Decode(&ticket); err != nil {
+		return nil, ErrSupportTicketNotFound
+	}
+	return &ticket, nil
+}
+
+// UpdateTicket changes a ticket's status, priority, category or assignee.
+// Each change is kept in the ticket's history with the optional note.
+func (ss *SupportService) UpdateTicket(ctx context.Context, ticketID primitive.ObjectID, req *models.SupportTicketUpdateRequest, adminID primitive.ObjectID) (*models.SupportTicket, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	ticket, err := ss.GetTicket(ctx, ticketID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	set := bson.M{"updated_at": now}
+	unset := bson.M{}
+	changes := map[string]string{}
+
+	if req.Status != nil && *req.Status != ticket.Status {
+		set["status"] = *req.Status
+		changes["status"] = ticket.Status + " -> " + *req.Status
+		switch *req.Status {
+		case models.TicketResolved, models.TicketClosed:
+			set["closed_at"] = now
+		default:
+			unset["closed_at"] = ""
+		}
+	}
+	if req.Priority != nil && *req.Priority != ticket.Priority {
+		set["priority"] = *req.Priority
+		changes["priority"] = ticket.Priority + " -> " + *req.Priority
+	}
+	if req.Category != nil && *req.Category != ticket.Category {
+		set["category"] = *req.Category
+		changes["category"] = ticket.Category + " -> " + *req.Category
+	}
+	if req.AssignedTo != nil {
+		previous := ""
+		if ticket.AssignedTo != nil {
+			previous = ticket.AssignedTo.Hex()
+		}
+		if *req.AssignedTo != previous {
+			if *req.AssignedTo == "" {
+				unset["assigned_to"] = ""
+			} else {
+				assignee, err := ss.assignee(ctx, *req.AssignedTo)
+				if err != nil {
+					return nil, err
+				}
+				set["assigned_to"] = assignee
+			}
+			changes["assigned_to"] = previous + " -> " + *req.AssignedTo
+		}
+	}
+
+	if len(changes) == 0 && req.Notes == "" {
+		return ticket, nil
+	}
+
+	update := bson.M{
+		"$set": set,
+		"$push": bson.M{"history": models.SupportTicketEvent{
+			Action:  "updated",
+			ActorID: &adminID,
+			Notes:   req.Notes,
+			Changes: changes,
+			At:      now,
+		}},
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	var updated models.SupportTicket
+	err = ss.collections.SupportTickets().FindOneAndUpdate(ctx,
+		bson.M{"_id": ticketID},
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if err != nil {
+		return nil, ErrSupportTicketNotFound
+	}
+
+	ss.record(ctx, "support_ticket.updated", adminID, "support_ticket", ticketID.Hex(), map[string]interface{}{
+		"changes": changes,
+	})
+	return &updated, nil
+}
+
+// AddComment adds a support agent's comment to a ticket's history
+func (ss *SupportService) AddComment(ctx context.Context, ticketID primitive.ObjectID, notes string, adminID primitive.ObjectID) (*models.SupportTicket, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	var ticket models.SupportTicket
+	err := ss.collections.SupportTickets().FindOneAndUpdate(ctx,
+		bson.M{"_id": ticketID},
+		bson.M{
+			"$set": bson.M{"updated_at": now},
+			"$push": bson.M{"history": models.SupportTicketEvent{
+				Action:  "comment",
+				ActorID: &adminID,
+				Notes:   notes,
+				At:      now,
+			}},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&ticket)
+	if err != nil {
+		return nil, ErrSupportTicketNotFound
+	}
+	return &ticket, nil
+}
+
+// ExportTicket creates the ticket in the configured helpdesk and links it
+// to its copy there. Failures are kept in the ticket's history.
+func (ss *SupportService) ExportTicket(ctx context.Context, ticketID primitive.ObjectID, adminID primitive.ObjectID) (*models.SupportTicket, error) {
+	if activeHelpdesk == nil {
+		return nil, ErrHelpdeskNotConfigured
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, helpdeskExportTimeout)
+	defer cancel()
+
+	ticket, err := ss.GetTicket(ctx, ticketID)
+	if err != nil {
+		return nil, err
+	}
+	if ticket.External != nil && ticket.External.Provider == activeHelpdesk.Name() {
+		return nil, ErrTicketAlreadyExported
+	}
+
+	var user models.User
+	if err := ss.collections.Users().FindOne(ctx, bson.M{"_id": ticket.UserID}).Decode(&user); err != nil {
+		return nil, ErrSupportUserNotFound
+	}
+
+	external, exportErr := activeHelpdesk.Export(ctx, &helpdeskTicket{Ticket: ticket, User: &user})
+	now := time.Now()
+	event := models.SupportTicketEvent{ActorID: &adminID, At: now}
+	set := bson.M{"updated_at": now}
+	if exportErr != nil {
+		log.Printf("Failed to export support ticket %s to %s: %v", ticketID.Hex(), activeHelpdesk.Name(), exportErr)
+		event.Action = "export_failed"
+		event.Notes = exportErr.Error()
+	} else {
+		external.ExportedAt = now
+		event.Action = "exported"
+		event.Notes = activeHelpdesk.Name()
+		set["external"] = external
+	}
+
+	var updated models.SupportTicket
+	err = ss.collections.SupportTickets().FindOneAndUpdate(ctx,
+		bson.M{"_id": ticketID},
+		bson.M{"$set": set, "$push": bson.M{"history": event}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record export: %v", err)
+	}
+
+	if exportErr != nil {
+		return nil, fmt.Errorf("%w: %v", ErrHelpdeskExportFailed, exportErr)
+	}
+	ss.record(ctx, "support_ticket.exported", adminID, "support_ticket", ticketID.Hex(), map[string]interface{}{
+		"provider":    external.Provider,
+		"external_id": external.ID,
+	})
+	return &updated, nil
+}
+
+func (ss *SupportService) checkUser(ctx context.Context, userID primitive.ObjectID) error {
+	count, err := ss.collections.Users().CountDocuments(ctx, bson.M{"_id": userID})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrSupportUserNotFound
+	}
+	return nil
+}
+
+// userFileIDs parses file IDs, checking each is a file of the user's
+func (ss *SupportService) userFileIDs(ctx context.Context, userID primitive.ObjectID, ids []string) ([]primitive.ObjectID, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	fileIDs := make([]primitive.ObjectID, 0, len(ids))
+	for _, id := range ids {
+		fileID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return nil, ErrSupportFileNotFound
+		}
+		fileIDs = append(fileIDs, fileID)
+	}
+	fileIDs = uniqueObjectIDs(fileIDs)
+
+	count, err := ss.collections.Files().CountDocuments(ctx, bson.M{
+		"_id":     bson.M{"$in": fileIDs},
+		"user_id": userID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if int(count) != len(fileIDs) {
+		return nil, ErrSupportFileNotFound
+	}
+	return fileIDs, nil
+}
+
+// assignee parses the ID of the admin a ticket is assigned to, checking
+// they exist
+func (ss *SupportService) assignee(ctx context.Context, id string) (primitive.ObjectID, error) {
+	adminID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return primitive.NilObjectID, ErrSupportAssigneeNotFound
+	}
+	count, err := ss.collections.Admins().CountDocuments(ctx, bson.M{"_id": adminID, "is_active": true})
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	if count == 0 {
+		return primitive.NilObjectID, ErrSupportAssigneeNotFound
+	}
+	return adminID, nil
+}
+
+func (ss *SupportService) record(ctx context.Context, action string, adminID primitive.ObjectID, resourceType, resourceID string, details map[string]interface{}) {
+	ss.auditService.Record(ctx, &models.AuditLog{
+		ActorType:    "admin",
+		ActorID:      &adminID,
+		Action:       action,
+		ResourceType: resourceType,
+		ResourceID:   resourceID,
+		Outcome:      "success",
+		Details:      details,
+	})
+}
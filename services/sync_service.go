@@ -0,0 +1,197 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"oncloud/models"
+	"oncloud/utils"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	DefaultSyncChangesLimit = 200
+	MaxSyncChangesLimit     = 1000
+
+	// Changes are only reported once they are this old. updated_at is set
+	// before the write commits, so a write stamped earlier may become
+	// visible after a later one; waiting keeps the cursor from passing it.
+	syncSettleDelay = 2 * time.Second
+)
+
+var ErrInvalidSyncCursor = errors.New("invalid sync cursor")
+
+// syncPosition is a point in the change feed, ordered by updated_at and
+// then ID
+type syncPosition struct {
+	UpdatedAt time.Time
+	ID        primitive.ObjectID
+}
+
+func (p syncPosition) before(other syncPosition) bool {
+	if !p.UpdatedAt.Equal(other.UpdatedAt) {
+		return p.UpdatedAt.Before(other.UpdatedAt)
+	}
+	return bytes.Compare(p.ID[:], other.ID[:]) < 0
+}
+
+// SyncService provides the change feed and file transfers used by sync
+// clients
+type SyncService struct {
+	*BaseService
+	storageService ObjectStore
+}
+
+func NewSyncService() *SyncService {
+	return NewSyncServiceWith(Dependencies{})
+}
+
+// NewSyncServiceWith creates a sync service on the given dependencies
+func NewSyncServiceWith(deps Dependencies) *SyncService {
+	return &SyncService{
+		BaseService:    NewBaseServiceWith(deps),
+		storageService: deps.storage(),
+	}
+}
+
+// GetChanges returns the user's files and folders changed after cursor,
+// oldest first. An empty cursor starts from the beginning and leaves out
+// items already in the trash, so it doubles as the initial listing.
+// Permanently deleted items are not reported; they are in the trash, and
+// so already reported as deleted, before they can be removed.
+func (ss *SyncService) GetChanges(ctx context.Context, userID primitive.ObjectID, cursor string, limit int) (*models.SyncChanges, error) {
+	if limit <= 0 || limit > MaxSyncChangesLimit {
+		limit = DefaultSyncChangesLimit
+	}
+
+	filter := bson.M{
+		"user_id":    userID,
+		"updated_at": bson.M{"$lte": time.Now().Add(-syncSettleDelay)},
+	}
+	var after *syncPosition
+	if cursor == "" {
+		filter["is_deleted"] = false
+	} else {
+		position, err := decodeSyncCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		after = position
+		filter["$or"] = []bson.M{
+			{"updated_at": bson.M{"$gt": position.UpdatedAt}},
+			{"updated_at": position.UpdatedAt, "_id": bson.M{"$gt": position.ID}},
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	// Both collections are read in feed order, one more than a page each,
+	// and merged; anything past the page is returned next time
+	opts := options.Find().
+		SetSort(bson.D{{Key: "updated_at", Value: 1}, {Key: "_id", Value: 1}}).
+		SetLimit(int64(limit + 1))
+
+	var files []models.File
+	if err := findSyncChanges(ctx, ss.collections.Files(), filter, opts, &files); err != nil {
+		return nil, fmt.Errorf("failed to get file changes: %v", err)
+	}
+	var folders []models.Folder
+	if err := findSyncChanges(ctx, ss.collections.Folders(), filter, opts, &folders); err != nil {
+		return nil, fmt.Errorf("failed to get folder changes: %v", err)
+	}
+
+	changes := make([]models.SyncChange, 0, len(files)+len(folders))
+	for i := range files {
+		changes = append(changes, models.SyncChange{File: &files[i]})
+	}
+	for i := range folders {
+		changes = append(changes, models.SyncChange{Folder: &folders[i]})
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return syncChangePosition(changes[i]).before(syncChangePosition(changes[j]))
+	})
+
+	result := &models.SyncChanges{Cursor: cursor}
+	if len(changes) > limit {
+		changes = changes[:limit]
+		result.HasMore = true
+	}
+	result.Changes = changes
+	if len(changes) > 0 {
+		last := syncChangePosition(changes[len(changes)-1])
+		after = &last
+	}
+	if after != nil {
+		result.Cursor = encodeSyncCursor(*after)
+	}
+	return result, nil
+}
+
+// ReadFile returns a file of the user's with its content. The file is
+// returned with the error when only its content could not be read.
+func (ss *SyncService) ReadFile(ctx context.Context, userID, fileID primitive.ObjectID) (*models.File, []byte, error) {
+	file, err := NewFileService().GetUserFile(ctx, userID, fileID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	content, err := ss.storageService.ReadFileContent(ctx, file)
+	if err != nil {
+		return file, nil, fmt.Errorf("failed to get file content: %v", err)
+	}
+	return file, content, nil
+}
+
+func findSyncChanges(ctx context.Context, collection *mongo.Collection, filter bson.M, opts *options.FindOptions, results interface{}) error {
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	return cursor.All(ctx, results)
+}
+
+func syncChangePosition(change models.SyncChange) syncPosition {
+	if change.File != nil {
+		return syncPosition{UpdatedAt: change.File.UpdatedAt, ID: change.File.ID}
+	}
+	return syncPosition{UpdatedAt: change.Folder.UpdatedAt, ID: change.Folder.ID}
+}
+
+// Cursors are opaque to clients: base64 of "<updated_at unix ms>.<id>".
+// Mongo stores times with millisecond precision, which the cursor keeps.
+func encodeSyncCursor(position syncPosition) string {
+	raw := strconv.FormatInt(position.UpdatedAt.UnixMilli(), 10) + "." + position.ID.Hex()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeSyncCursor(cursor string) (*syncPosition, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidSyncCursor
+	}
+	millis, id, found := strings.Cut(string(raw), ".")
+	if !found {
+		return nil, ErrInvalidSyncCursor
+	}
+	updatedAt, err := strconv.ParseInt(millis, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidSyncCursor
+	}
+	objectID, err := utils.StringToObjectID(id)
+	if err != nil {
+		return nil, ErrInvalidSyncCursor
+	}
+	return &syncPosition{UpdatedAt: time.UnixMilli(updatedAt), ID: objectID}, nil
+}
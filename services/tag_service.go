@@ -0,0 +1,295 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"oncloud/models"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const maxTagLength = 50
+
+var ErrTagNotFound = notFoundError("tag not found")
+
+type TagService struct {
+	*BaseService
+}
+
+func NewTagService() *TagService {
+	return NewTagServiceWith(Dependencies{})
+}
+
+// NewTagServiceWith creates a tag service on the given dependencies
+func NewTagServiceWith(deps Dependencies) *TagService {
+	return &TagService{
+		BaseService: NewBaseServiceWith(deps),
+	}
+}
+
+// NormalizeTags trims tags, collapses inner whitespace and drops empty and
+// duplicate entries, keeping the first spelling of each
+func NormalizeTags(tags []string) []string {
+	normalized := make([]string, 0, len(tags))
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		tag = strings.Join(strings.Fields(tag), " ")
+		if tag == "" {
+			continue
+		}
+		if runes := []rune(tag); len(runes) > maxTagLength {
+			tag = string(runes[:maxTagLength])
+		}
+		key := strings.ToLower(tag)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		normalized = append(normalized, tag)
+	}
+	return normalized
+}
+
+// ListTags returns the user's tags sorted by name or by usage
+func (ts *TagService) ListTags(ctx context.Context, userID primitive.ObjectID, sortBy string, page, limit int) ([]models.Tag, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	sort := bson.D{{Key: "name", Value: 1}}
+	if sortBy == "count" {
+		sort = bson.D{{Key: "count", Value: -1}, {Key: "name", Value: 1}}
+	}
+
+	filter := bson.M{"user_id": userID}
+	cursor, err := ts.collections.Tags().Find(ctx, filter,
+		options.Find().
+			SetSort(sort).
+			SetSkip(int64((page-1)*limit)).
+			SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	tags := []models.Tag{}
+	if err := cursor.All(ctx, &tags); err != nil {
+		return nil, 0, err
+	}
+
+	total, err := ts.collections.Tags().CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return tags, int(total), nil
+}
+
+// Autocomplete returns the user's most used tags starting with prefix,
+// ignoring case
+func (ts *TagService) Autocomplete(ctx context.Context, userID primitive.ObjectID, prefix string, limit int) ([]models.Tag, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"user_id": userID}
+	if prefix = strings.TrimSpace(prefix); prefix != "" {
+		filter["name"] = primitive.Regex{Pattern: "^" + regexp.QuoteMeta(prefix), Options: "i"}
+	}
+
+	cursor, err := ts.collections.Tags().Find(ctx, filter,
+		options.Find().
+			SetSort(bson.D{{Key: "count", Value: -1}, {Key: "name", Value: 1}}).
+			SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	tags := []models.Tag{}
+	if err := cursor.All(ctx, &tags); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// GetTag returns one of the user's tags
+func (ts *TagService) GetTag(ctx context.Context, userID, tagID primitive.ObjectID) (*models.Tag, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var tag models.Tag
+	if err := ts.collections.Tags().FindOne(ctx, bson.M{"_id": tagID, "user_id": userID}).Decode(&tag); err != nil {
+		return nil, ErrTagNotFound
+	}
+	return &tag, nil
+}
+
+// GetFilesByTag returns the user's files carrying a tag, newest first
+func (ts *TagService) GetFilesByTag(ctx context.Context, userID primitive.ObjectID, tag *models.Tag, page, limit int) ([]models.File, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"user_id":    userID,
+		"tags":       tag.Name,
+		"is_deleted": false,
+	}
+
+	cursor, err := ts.collections.Files().Find(ctx, filter,
+		options.Find().
+			SetSort(bson.M{"created_at": -1}).
+			SetSkip(int64((page-1)*limit)).
+			SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	files := []models.File{}
+	if err := cursor.All(ctx, &files); err != nil {
+		return nil, 0, err
+	}
+
+	total, err := ts.collections.Files().CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return files, int(total), nil
+}
+
+// RenameTag renames a tag on all of the user's files. Renaming onto an
+// existing tag merges the two.
+func (ts *TagService) RenameTag(ctx context.Context, userID, tagID primitive.ObjectID, newName string) (*models.Tag, error) {
+	return ts.MergeTags(ctx, userID, []primitive.ObjectID{tagID}, newName)
+}
+
+// MergeTags replaces each source tag with target on all of the user's
+// files, including those in the trash. target may be a new tag name.
+func (ts *TagService) MergeTags(ctx context.Context, userID primitive.ObjectID, sourceIDs []primitive.ObjectID, target string) (*models.Tag, error) {
+	normalized := NormalizeTags([]string{target})
+	if len(normalized) == 0 {
+		return nil, errors.New("tag name is required")
+	}
+	target = normalized[0]
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	var merge []string
+	for _, sourceID := range sourceIDs {
+		source, err := ts.GetTag(ctx, userID, sourceID)
+		if err != nil {
+			return nil, err
+		}
+		if source.Name != target {
+			merge = append(merge, source.Name)
+		}
+	}
+	if len(merge) == 0 {
+		return ts.getTag(ctx, userID, target)
+	}
+
+	filter := bson.M{"user_id": userID, "tags": bson.M{"$in": merge}}
+	if _, err := ts.collections.Files().UpdateMany(ctx, filter,
+		bson.M{"$addToSet": bson.M{"tags": target}},
+	); err != nil {
+		return nil, fmt.Errorf("failed to merge tags: %v", err)
+	}
+	if _, err := ts.collections.Files().UpdateMany(ctx, filter,
+		bson.M{"$pull": bson.M{"tags": bson.M{"$in": merge}}},
+	); err != nil {
+		return nil, fmt.Errorf("failed to merge tags: %v", err)
+	}
+
+	ts.RefreshTags(ctx, userID, append(merge, target))
+
+	// Tags only found in the trash are not counted, so there may be no record
+	if tag, err := ts.getTag(ctx, userID, target); err == nil {
+		return tag, nil
+	}
+	return &models.Tag{UserID: userID, Name: target}, nil
+}
+
+// DeleteTag removes a tag from all of the user's files
+func (ts *TagService) DeleteTag(ctx context.Context, userID, tagID primitive.ObjectID) error {
+	tag, err := ts.GetTag(ctx, userID, tagID)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	if _, err := ts.collections.Files().UpdateMany(ctx,
+		bson.M{"user_id": userID, "tags": tag.Name},
+		bson.M{"$pull": bson.M{"tags": tag.Name}},
+	); err != nil {
+		return fmt.Errorf("failed to delete tag: %v", err)
+	}
+
+	if _, err := ts.collections.Tags().DeleteOne(ctx, bson.M{"_id": tag.ID}); err != nil {
+		return fmt.Errorf("failed to delete tag: %v", err)
+	}
+
+	return nil
+}
+
+// RefreshTags recounts the given tags from the user's files, dropping tags
+// no longer in use. It is called after any change to a file's tags or
+// whether the file is in the trash.
+func (ts *TagService) RefreshTags(ctx context.Context, userID primitive.ObjectID, names []string) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		count, err := ts.collections.Files().CountDocuments(ctx, bson.M{
+			"user_id":    userID,
+			"tags":       name,
+			"is_deleted": false,
+		})
+		if err != nil {
+			log.Printf("Failed to count tag %q: %v", name, err)
+			continue
+		}
+
+		if count == 0 {
+			ts.collections.Tags().DeleteOne(ctx, bson.M{"user_id": userID, "name": name})
+			continue
+		}
+
+		ts.collections.Tags().UpdateOne(ctx,
+			bson.M{"user_id": userID, "name": name},
+			bson.M{
+				"$set":         bson.M{"count": count, "updated_at": now},
+				"$setOnInsert": bson.M{"created_at": now},
+			},
+			options.Update().SetUpsert(true),
+		)
+	}
+}
+
+func (ts *TagService) getTag(ctx context.Context, userID primitive.ObjectID, name string) (*models.Tag, error) {
+	var tag models.Tag
+	if err := ts.collections.Tags().FindOne(ctx, bson.M{"user_id": userID, "name": name}).Decode(&tag); err != nil {
+		return nil, ErrTagNotFound
+	}
+	return &tag, nil
+}
@@ -0,0 +1,663 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"oncloud/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// The owner has this long after a notice to send a counter notice before
+	// the takedown is upheld
+	takedownCounterNoticeWindow = 14 * 24 * time.Hour
+
+	// Content is restored this long after a counter notice unless the
+	// claimant reports a court action first
+	takedownRestoreDelay = 14 * 24 * time.Hour
+)
+
+var (
+	ErrTakedownNotFound       = notFoundError("takedown case not found")
+	ErrTakedownTargetNotFound = notFoundError("file or folder not found")
+	ErrTakedownTargetRequired = errors.New("a file, folder or link token is required")
+	ErrTakedownExists         = errors.New("the content already has an open takedown case")
+	ErrTakedownClosed         = errors.New("takedown case is closed")
+	ErrCounterNoticeNotOpen   = errors.New("counter notices are not accepted for this case")
+	ErrContentTakenDown       = errors.New("the content was taken down after a copyright notice")
+)
+
+// openTakedownStatuses are the statuses of cases whose content is disabled
+// and which still await a decision
+var openTakedownStatuses = []string{models.TakedownActive, models.TakedownCounterNoticed}
+
+// TakedownFilter narrows a takedown case listing
+type TakedownFilter struct {
+	Status  string
+	OwnerID *primitive.ObjectID
+	FileID  *primitive.ObjectID
+}
+
+type TakedownService struct {
+	*BaseService
+	auditService *AuditService
+}
+
+func NewTakedownService() *TakedownService {
+	return NewTakedownServiceWith(Dependencies{})
+}
+
+// NewTakedownServiceWith creates a takedown service on the given
+// dependencies
+func NewTakedownServiceWith(deps Dependencies) *TakedownService {
+	return &TakedownService{
+		BaseService:  NewBaseServiceWith(deps),
+		auditService: NewAuditServiceWith(deps),
+	}
+}
+
+// takedownTarget is the file or folder a notice is about
+type takedownTarget struct {
+	fileID     *primitive.ObjectID
+	folderID   *primitive.ObjectID
+	ownerID    primitive.ObjectID
+	name       string
+	shareToken string
+	isShared   bool
+	isPublic   bool
+}
+
+// CreateCase registers a DMCA notice, disables every link to the content and
+// tells its owner how to send a counter notice
+func (ts *TakedownService) CreateCase(ctx context.Context, req *models.TakedownNoticeRequest, adminID primitive.ObjectID) (*models.TakedownCase, error) {
+	target, err := ts.resolveTarget(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	open, err := ts.collections.TakedownCases().CountDocuments(ctx, ts.targetFilter(target.fileID, target.folderID, bson.M{
+		"status": bson.M{"$in": openTakedownStatuses},
+	}))
+	if err != nil {
+		return nil, err
+	}
+	if open > 0 {
+		return nil, ErrTakedownExists
+	}
+
+	now := time.Now()
+	receivedAt := now
+	if req.NoticeReceivedAt != nil && req.NoticeReceivedAt.Before(now) {
+		receivedAt = *req.NoticeReceivedAt
+	}
+
+	takedown := &models.TakedownCase{
+		ID:                 primitive.NewObjectID(),
+		FileID:             target.fileID,
+		FolderID:           target.folderID,
+		OwnerID:            target.ownerID,
+		Status:             models.TakedownActive,
+		Claimant:           req.Claimant,
+		CopyrightedWork:    req.CopyrightedWork,
+		InfringingURLs:     req.InfringingURLs,
+		NoticeReceivedAt:   receivedAt,
+		ShareToken:         target.shareToken,
+		WasShared:          target.isShared,
+		WasPublic:          target.isPublic,
+		CounterNoticeDueAt: now.Add(takedownCounterNoticeWindow),
+		History: []models.TakedownEvent{
+			{Action: "notice_registered", ActorType: "admin", ActorID: &adminID, Notes: req.Notes, At: now},
+		},
+		CreatedBy: adminID,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	takedown.DisabledShares, err = ts.disableContent(ctx, takedown)
+	if err != nil {
+		return nil, err
+	}
+	takedown.History = append(takedown.History, models.TakedownEvent{
+		Action:    "content_disabled",
+		ActorType: "system",
+		Notes:     fmt.Sprintf("%d share links disabled", len(takedown.DisabledShares)),
+		At:        now,
+	})
+
+	if _, err := ts.collections.TakedownCases().InsertOne(ctx, takedown); err != nil {
+		// Give the content back rather than leave it disabled without a case
+		if restoreErr := ts.restoreContent(ctx, takedown); restoreErr != nil {
+			log.Printf("Failed to restore content of unsaved takedown case %s: %v", takedown.ID.Hex(), restoreErr)
+		}
+		return nil, fmt.Errorf("failed to save takedown case: %v", err)
+	}
+
+	ts.record(ctx, takedown, "takedown.created", "admin", &adminID, nil)
+	ts.notifyOwner(ctx, takedown, target.name, "takedown_notice",
+		"Content taken down after a copyright notice",
+		target.name+" was taken down after a DMCA notice. You can send a counter notice until "+takedown.CounterNoticeDueAt.Format("January 2, 2006")+".")
+
+	owner := ts.owner(ctx, takedown.OwnerID)
+	if owner != nil {
+		NewAuthService().sendEmailNotification(owner.Email, "takedown_notice", map[string]string{
+			"name":     owner.FirstName + " " + owner.LastName,
+			"item":     target.name,
+			"claimant": takedownClaimantName(takedown.Claimant),
+			"work":     takedown.CopyrightedWork,
+			"case":     takedown.ID.Hex(),
+			"due":      takedown.CounterNoticeDueAt.Format("January 2, 2006"),
+		})
+	}
+
+	return takedown, nil
+}
+
+// ListCases returns takedown cases, newest first
+func (ts *TakedownService) ListCases(ctx context.Context, filter TakedownFilter, page, limit int) ([]models.TakedownCase, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	query := bson.M{}
+	if filter.Status != "" {
+		query["status"] = filter.Status
+	}
+	if filter.OwnerID != nil {
+		query["owner_id"] = *filter.OwnerID
+	}
+	if filter.FileID != nil {
+		query["file_id"] = *filter.FileID
+	}
+
+	cursor, err := ts.collections.TakedownCases().Find(ctx, query,
+		options.Find().
+			SetSort(bson.M{"created_at": -1}).
+			SetSkip(int64((page-1)*limit)).
+			SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	cases := []models.TakedownCase{}
+	if err := cursor.All(ctx, &cases); err != nil {
+		return nil, 0, err
+	}
+
+	total, err := ts.collections.TakedownCases().CountDocuments(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return cases, int(total), nil
+}
+
+// GetCase returns one takedown case
+func (ts *TakedownService) GetCase(ctx context.Context, caseID primitive.ObjectID) (*models.TakedownCase, error) {
+	return ts.findCase(ctx, bson.M{"_id": caseID})
+}
+
+// GetOwnerCase returns one of the cases about an owner's content
+func (ts *TakedownService) GetOwnerCase(ctx context.Context, ownerID, caseID primitive.ObjectID) (*models.TakedownCase, error) {
+	return ts.findCase(ctx, bson.M{"_id": caseID, "owner_id": ownerID})
+}
+
+// SubmitCounterNotice records the owner's dispute of an active case and
+// schedules the content to be restored. The claimant is sent the counter
+// notice so they can take the matter to court before then.
+func (ts *TakedownService) SubmitCounterNotice(ctx context.Context, ownerID, caseID primitive.ObjectID, req *models.TakedownCounterNoticeRequest) (*models.TakedownCase, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	restoreAt := now.Add(takedownRestoreDelay)
+	var takedown models.TakedownCase
+	err := ts.collections.TakedownCases().FindOneAndUpdate(ctx,
+		bson.M{
+			"_id":                   caseID,
+			"owner_id":              ownerID,
+			"status":                models.TakedownActive,
+			"counter_notice_due_at": bson.M{"$gt": now},
+		},
+		bson.M{
+			"$set": bson.M{
+				"status": models.TakedownCounterNoticed,
+				"counter_notice": models.TakedownCounterNotice{
+					Name:        req.Name,
+					Email:       req.Email,
+					Address:     req.Address,
+					Phone:       req.Phone,
+					Statement:   req.Statement,
+					Signature:   req.Signature,
+					SubmittedAt: now,
+				},
+				"restore_at": restoreAt,
+				"updated_at": now,
+			},
+			"$push": bson.M{"history": models.TakedownEvent{
+				Action:    "counter_notice_received",
+				ActorType: "user",
+				ActorID:   &ownerID,
+				At:        now,
+			}},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&takedown)
+	if err != nil {
+		if _, findErr := ts.GetOwnerCase(ctx, ownerID, caseID); findErr != nil {
+			return nil, findErr
+		}
+		return nil, ErrCounterNoticeNotOpen
+	}
+
+	ts.record(ctx, &takedown, "takedown.counter_noticed", "user", &ownerID, nil)
+
+	NewAuthService().sendEmailNotification(takedown.Claimant.Email, "takedown_counter_notice", map[string]string{
+		"name":            takedown.Claimant.Name,
+		"case":            takedown.ID.Hex(),
+		"work":            takedown.CopyrightedWork,
+		"counter_name":    req.Name,
+		"counter_address": req.Address,
+		"counter_phone":   req.Phone,
+		"counter_email":   req.Email,
+		"statement":       req.Statement,
+		"restore_at":      restoreAt.Format("January 2, 2006"),
+	})
+
+	return &takedown, nil
+}
+
+// AddNote adds an admin's note to a case's history
+func (ts *TakedownService) AddNote(ctx context.Context, caseID primitive.ObjectID, notes string, adminID primitive.ObjectID) (*models.TakedownCase, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	var takedown models.TakedownCase
+	err := ts.collections.TakedownCases().FindOneAndUpdate(ctx,
+		bson.M{"_id": caseID},
+		bson.M{
+			"$set": bson.M{"updated_at": now},
+			"$push": bson.M{"history": models.TakedownEvent{
+				Action:    "note",
+				ActorType: "admin",
+				ActorID:   &adminID,
+				Notes:     notes,
+				At:        now,
+			}},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&takedown)
+	if err != nil {
+		return nil, ErrTakedownNotFound
+	}
+	return &takedown, nil
+}
+
+// ResolveCase closes an open case. Restoring or withdrawing brings back the
+// links that were disabled; upholding keeps the content disabled for good.
+func (ts *TakedownService) ResolveCase(ctx context.Context, caseID primitive.ObjectID, req *models.TakedownResolveRequest, adminID primitive.ObjectID) (*models.TakedownCase, error) {
+	status := map[string]string{
+		"restore":  models.TakedownRestored,
+		"uphold":   models.TakedownUpheld,
+		"withdraw": models.TakedownWithdrawn,
+	}[req.Action]
+
+	return ts.closeCase(ctx, bson.M{"_id": caseID}, status, models.TakedownEvent{
+		Action:    req.Action,
+		ActorType: "admin",
+		ActorID:   &adminID,
+		Notes:     req.Notes,
+		At:        time.Now(),
+	})
+}
+
+// ProcessDeadlines restores content whose counter notice went unanswered by a
+// court action and upholds takedowns nobody disputed in time. It returns the
+// number of cases closed.
+func (ts *TakedownService) ProcessDeadlines(ctx context.Context) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	cursor, err := ts.collections.TakedownCases().Find(ctx, bson.M{"$or": bson.A{
+		bson.M{"status": models.TakedownCounterNoticed, "restore_at": bson.M{"$lte": now}},
+		bson.M{"status": models.TakedownActive, "counter_notice_due_at": bson.M{"$lte": now}},
+	}}, options.Find().SetProjection(bson.M{"_id": 1, "status": 1}))
+	if err != nil {
+		return 0, err
+	}
+	var due []models.TakedownCase
+	err = cursor.All(ctx, &due)
+	cursor.Close(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	closed := 0
+	for _, takedown := range due {
+		status, action, notes := models.TakedownUpheld, "uphold", "No counter notice was received in time"
+		if takedown.Status == models.TakedownCounterNoticed {
+			status, action, notes = models.TakedownRestored, "restore", "No court action was reported after the counter notice"
+		}
+
+		// The status in the filter keeps a case an admin just resolved, or
+		// one which just got a counter notice, from being closed here
+		_, err := ts.closeCase(ctx, bson.M{"_id": takedown.ID, "status": takedown.Status}, status, models.TakedownEvent{
+			Action:    action,
+			ActorType: "system",
+			Notes:     notes,
+			At:        now,
+		})
+		if err != nil {
+			if !errors.Is(err, ErrTakedownClosed) {
+				log.Printf("Failed to close takedown case %s: %v", takedown.ID.Hex(), err)
+			}
+			continue
+		}
+		closed++
+	}
+
+	return closed, nil
+}
+
+// checkTakedown returns ErrContentTakenDown if the file or folder with
+// takedownID is disabled by a takedown, so it may not be shared
+func checkTakedown(takedownID *primitive.ObjectID) error {
+	if takedownID != nil {
+		return ErrContentTakenDown
+	}
+	return nil
+}
+
+// closeCase moves an open case matching filter to status, restoring its
+// content unless the takedown is upheld, and tells the owner
+func (ts *TakedownService) closeCase(ctx context.Context, filter bson.M, status string, event models.TakedownEvent) (*models.TakedownCase, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	if _, ok := filter["status"]; !ok {
+		filter["status"] = bson.M{"$in": openTakedownStatuses}
+	}
+
+	now := time.Now()
+	var takedown models.TakedownCase
+	err := ts.collections.TakedownCases().FindOneAndUpdate(ctx, filter,
+		bson.M{
+			"$set":  bson.M{"status": status, "closed_at": now, "updated_at": now},
+			"$push": bson.M{"history": event},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&takedown)
+	if err != nil {
+		if _, findErr := ts.findCase(ctx, bson.M{"_id": filter["_id"]}); findErr != nil {
+			return nil, findErr
+		}
+		return nil, ErrTakedownClosed
+	}
+
+	if status != models.TakedownUpheld {
+		if err := ts.restoreContent(ctx, &takedown); err != nil {
+			return nil, err
+		}
+		ts.collections.TakedownCases().UpdateOne(ctx, bson.M{"_id": takedown.ID}, bson.M{
+			"$push": bson.M{"history": models.TakedownEvent{Action: "content_restored", ActorType: "system", At: now}},
+		})
+	}
+
+	ts.record(ctx, &takedown, "takedown."+status, event.ActorType, event.ActorID, map[string]interface{}{"notes": event.Notes})
+
+	outcome := "the content was restored and its share links work again."
+	if status == models.TakedownUpheld {
+		outcome = "the takedown was upheld and the content stays unavailable for sharing."
+	}
+	name := ts.targetName(ctx, &takedown)
+	ts.notifyOwner(ctx, &takedown, name, "takedown_closed", "Takedown case closed", name+": "+outcome)
+	if owner := ts.owner(ctx, takedown.OwnerID); owner != nil {
+		NewAuthService().sendEmailNotification(owner.Email, "takedown_closed", map[string]string{
+			"name":    owner.FirstName + " " + owner.LastName,
+			"item":    name,
+			"case":    takedown.ID.Hex(),
+			"outcome": outcome,
+		})
+	}
+
+	return &takedown, nil
+}
+
+// disableContent deactivates the share links to a case's content, makes it
+// private and marks it as taken down. It returns the shares it deactivated.
+func (ts *TakedownService) disableContent(ctx context.Context, takedown *models.TakedownCase) ([]primitive.ObjectID, error) {
+	shares, items, targetID := ts.targetCollections(takedown)
+
+	cursor, err := shares.Find(ctx, bson.M{"file_id": targetID, "is_active": true},
+		options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find share links: %v", err)
+	}
+	var active []models.FileShare
+	err = cursor.All(ctx, &active)
+	cursor.Close(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find share links: %v", err)
+	}
+
+	shareIDs := []primitive.ObjectID{}
+	for _, share := range active {
+		shareIDs = append(shareIDs, share.ID)
+	}
+	if len(shareIDs) > 0 {
+		if _, err := shares.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": shareIDs}}, bson.M{"$set": bson.M{"is_active": false}}); err != nil {
+			return nil, fmt.Errorf("failed to disable share links: %v", err)
+		}
+	}
+
+	_, err = items.UpdateOne(ctx, bson.M{"_id": targetID}, bson.M{
+		"$set":   bson.M{"is_shared": false, "is_public": false, "takedown_id": takedown.ID, "updated_at": time.Now()},
+		"$unset": bson.M{"share_token": ""},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to disable content: %v", err)
+	}
+
+	return shareIDs, nil
+}
+
+// restoreContent undoes disableContent
+func (ts *TakedownService) restoreContent(ctx context.Context, takedown *models.TakedownCase) error {
+	shares, items, targetID := ts.targetCollections(takedown)
+
+	if len(takedown.DisabledShares) > 0 {
+		if _, err := shares.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": takedown.DisabledShares}}, bson.M{"$set": bson.M{"is_active": true}}); err != nil {
+			return fmt.Errorf("failed to restore share links: %v", err)
+		}
+	}
+
+	update := bson.M{
+		"$set":   bson.M{"is_shared": takedown.WasShared, "is_public": takedown.WasPublic, "updated_at": time.Now()},
+		"$unset": bson.M{"takedown_id": ""},
+	}
+	if takedown.ShareToken != "" {
+		update["$set"].(bson.M)["share_token"] = takedown.ShareToken
+	}
+	if _, err := items.UpdateOne(ctx, bson.M{"_id": targetID, "takedown_id": takedown.ID}, update); err != nil {
+		return fmt.Errorf("failed to restore content: %v", err)
+	}
+	return nil
+}
+
+// targetCollections returns the share and item collections of a case's
+// content and its ID. Folder shares are kept apart from file shares.
+func (ts *TakedownService) targetCollections(takedown *models.TakedownCase) (*mongo.Collection, *mongo.Collection, primitive.ObjectID) {
+	if takedown.FolderID != nil {
+		return ts.collections.Collection("folder_shares"), ts.collections.Folders(), *takedown.FolderID
+	}
+	return ts.collections.FileShares(), ts.collections.Files(), *takedown.FileID
+}
+
+// resolveTarget finds the file or folder a notice is about
+func (ts *TakedownService) resolveTarget(ctx context.Context, req *models.TakedownNoticeRequest) (*takedownTarget, error) {
+	var fileID, folderID *primitive.ObjectID
+	switch {
+	case req.FileID != "":
+		id, _ := primitive.ObjectIDFromHex(req.FileID)
+		fileID = &id
+	case req.FolderID != "":
+		id, _ := primitive.ObjectIDFromHex(req.FolderID)
+		folderID = &id
+	case req.Token != "":
+		link, err := NewAbuseReportService().resolveLink(ctx, req.Token)
+		if err != nil {
+			return nil, ErrTakedownTargetNotFound
+		}
+		fileID, folderID = link.fileID, link.folderID
+	default:
+		return nil, ErrTakedownTargetRequired
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if fileID != nil {
+		var file models.File
+		if err := ts.collections.Files().FindOne(ctx, bson.M{"_id": *fileID, "is_deleted": false}).Decode(&file); err != nil {
+			return nil, ErrTakedownTargetNotFound
+		}
+		return &takedownTarget{
+			fileID:     fileID,
+			ownerID:    file.UserID,
+			name:       fileDisplayName(&file),
+			shareToken: file.ShareToken,
+			isShared:   file.IsShared,
+			isPublic:   file.IsPublic,
+		}, nil
+	}
+
+	var folder models.Folder
+	if err := ts.collections.Folders().FindOne(ctx, bson.M{"_id": *folderID, "is_deleted": false}).Decode(&folder); err != nil {
+		return nil, ErrTakedownTargetNotFound
+	}
+	return &takedownTarget{
+		folderID:   folderID,
+		ownerID:    folder.UserID,
+		name:       folder.Name,
+		shareToken: folder.ShareToken,
+		isShared:   folder.IsShared,
+		isPublic:   folder.IsPublic,
+	}, nil
+}
+
+// targetFilter adds the case's file or folder to filter
+func (ts *TakedownService) targetFilter(fileID, folderID *primitive.ObjectID, filter bson.M) bson.M {
+	if fileID != nil {
+		filter["file_id"] = *fileID
+	} else {
+		filter["folder_id"] = *folderID
+	}
+	return filter
+}
+
+// targetName returns the name of a case's file or folder, as shown to its
+// owner
+func (ts *TakedownService) targetName(ctx context.Context, takedown *models.TakedownCase) string {
+	if takedown.FileID != nil {
+		var file models.File
+		if err := ts.collections.Files().FindOne(ctx, bson.M{"_id": *takedown.FileID}).Decode(&file); err == nil {
+			return fileDisplayName(&file)
+		}
+		return "A file"
+	}
+	var folder models.Folder
+	if err := ts.collections.Folders().FindOne(ctx, bson.M{"_id": *takedown.FolderID}).Decode(&folder); err == nil {
+		return folder.Name
+	}
+	return "A folder"
+}
+
+func (ts *TakedownService) findCase(ctx context.Context, filter bson.M) (*models.TakedownCase, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var takedown models.TakedownCase
+	if err := ts.collections.TakedownCases().FindOne(ctx, filter).Decode(&takedown); err != nil {
+		return nil, ErrTakedownNotFound
+	}
+	return &takedown, nil
+}
+
+func (ts *TakedownService) owner(ctx context.Context, ownerID primitive.ObjectID) *models.User {
+	var user models.User
+	if err := ts.collections.Users().FindOne(ctx, bson.M{"_id": ownerID}).Decode(&user); err != nil {
+		log.Printf("Failed to find owner %s of taken down content: %v", ownerID.Hex(), err)
+		return nil
+	}
+	return &user
+}
+
+func (ts *TakedownService) notifyOwner(ctx context.Context, takedown *models.TakedownCase, name, notificationType, title, message string) {
+	ts.collections.Notifications().InsertOne(ctx, bson.M{
+		"_id":     primitive.NewObjectID(),
+		"user_id": takedown.OwnerID,
+		"type":    notificationType,
+		"title":   title,
+		"message": message,
+		"data": bson.M{
+			"takedown_id": takedown.ID,
+			"file_id":     takedown.FileID,
+			"folder_id":   takedown.FolderID,
+			"name":        name,
+		},
+		"is_read":    false,
+		"created_at": time.Now(),
+	})
+}
+
+func (ts *TakedownService) record(ctx context.Context, takedown *models.TakedownCase, action, actorType string, actorID *primitive.ObjectID, details map[string]interface{}) {
+	if details == nil {
+		details = map[string]interface{}{}
+	}
+	details["takedown_id"] = takedown.ID
+	details["status"] = takedown.Status
+
+	resourceType, resourceID := "file", ""
+	if takedown.FileID != nil {
+		resourceID = takedown.FileID.Hex()
+	} else {
+		resourceType, resourceID = "folder", takedown.FolderID.Hex()
+	}
+	ts.auditService.Record(ctx, &models.AuditLog{
+		ActorType:    actorType,
+		ActorID:      actorID,
+		Action:       action,
+		ResourceType: resourceType,
+		ResourceID:   resourceID,
+		Outcome:      "success",
+		Details:      details,
+	})
+}
+
+// fileDisplayName returns the name of a file as shown to its owner
+func fileDisplayName(file *models.File) string {
+	if file.DisplayName != "" {
+		return file.DisplayName
+	}
+	return file.OriginalName
+}
+
+// takedownClaimantName names the claimant as told to the owner
+func takedownClaimantName(claimant models.TakedownParty) string {
+	if claimant.Organization != "" {
+		return claimant.Organization
+	}
+	return claimant.Name
+}
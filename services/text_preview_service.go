@@ -0,0 +1,171 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"oncloud/models"
+	"oncloud/utils"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TextPreviewOptions configures previews of text and code files
+type TextPreviewOptions struct {
+	// MaxFileSize is the size of the biggest file previewed, which is read
+	// whole to count its lines
+	MaxFileSize int64
+}
+
+var textPreviewOptions = TextPreviewOptions{MaxFileSize: 20 * 1024 * 1024}
+
+const (
+	DefaultTextPreviewKB = 64
+	MaxTextPreviewKB     = 512
+
+	// textPreviewCacheSize caps the previews kept, so repeated views of a
+	// file are not read from its provider again
+	textPreviewCacheSize = 256
+	textPreviewCacheTTL  = 10 * time.Minute
+)
+
+var (
+	// ErrTextPreviewTooLarge is returned for files above the size previewed
+	ErrTextPreviewTooLarge = errors.New("this file is too large to preview")
+	// ErrNotTextFile is returned for files whose content is not text. It is
+	// an ErrPreviewUnavailable.
+	ErrNotTextFile = fmt.Errorf("%w: it is not a text file", ErrPreviewUnavailable)
+)
+
+// InitTextPreview configures previews of text and code files
+func InitTextPreview(opts TextPreviewOptions) {
+	if opts.MaxFileSize <= 0 {
+		opts.MaxFileSize = 20 * 1024 * 1024
+	}
+	textPreviewOptions = opts
+}
+
+type textPreviewEntry struct {
+	preview  *models.TextPreview
+	storedAt time.Time
+}
+
+// textPreviewCache holds recent previews by file, content and length,
+// shared by every service instance
+var textPreviewCache struct {
+	sync.Mutex
+	entries map[string]textPreviewEntry
+}
+
+type TextPreviewService struct {
+	*BaseService
+	storageService ObjectStore
+	fileService    *FileService
+}
+
+func NewTextPreviewService() *TextPreviewService {
+	return NewTextPreviewServiceWith(Dependencies{})
+}
+
+// NewTextPreviewServiceWith creates a text preview service on the given
+// dependencies
+func NewTextPreviewServiceWith(deps Dependencies) *TextPreviewService {
+	return &TextPreviewService{
+		BaseService:    NewBaseServiceWith(deps),
+		storageService: deps.storage(),
+		fileService:    NewFileServiceWith(deps),
+	}
+}
+
+// GetTextPreview returns the first kb kilobytes of the user's text or code
+// file, decoded to UTF-8, with its language, encoding and line count
+func (tps *TextPreviewService) GetTextPreview(ctx context.Context, userID, fileID primitive.ObjectID, kb int) (*models.TextPreview, error) {
+	file, err := tps.fileService.GetUserFile(ctx, userID, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkFileExpiry(file); err != nil {
+		return nil, err
+	}
+	if err := checkFileArchived(file); err != nil {
+		return nil, err
+	}
+	if file.Size > textPreviewOptions.MaxFileSize {
+		return nil, ErrTextPreviewTooLarge
+	}
+	if kb <= 0 {
+		kb = DefaultTextPreviewKB
+	}
+	kb = min(kb, MaxTextPreviewKB)
+
+	// Previews are cached until the file changes, by a new version or a
+	// rename that may change its language
+	key := fmt.Sprintf("%s/%s/%d/%d", file.ID.Hex(), file.Hash, file.UpdatedAt.UnixNano(), kb)
+	if preview := tps.cachedPreview(key); preview != nil {
+		return preview, nil
+	}
+
+	content, err := tps.storageService.ReadFileContent(ctx, file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file content: %w", err)
+	}
+	decoded, err := utils.DecodeText(content, kb*1024)
+	if err != nil {
+		return nil, ErrNotTextFile
+	}
+
+	name := fileDisplayName(file)
+	preview := &models.TextPreview{
+		FileID:    file.ID,
+		Name:      name,
+		MimeType:  file.MimeType,
+		Language:  utils.DetectLanguage(name, file.MimeType, decoded.Text),
+		Encoding:  decoded.Encoding,
+		BOM:       decoded.BOM,
+		LineCount: decoded.Lines,
+		Size:      file.Size,
+		Truncated: decoded.Truncated,
+		Content:   decoded.Text,
+	}
+	tps.cachePreview(key, preview)
+	return preview, nil
+}
+
+func (tps *TextPreviewService) cachedPreview(key string) *models.TextPreview {
+	textPreviewCache.Lock()
+	defer textPreviewCache.Unlock()
+
+	entry, ok := textPreviewCache.entries[key]
+	if !ok || tps.clock.Now().Sub(entry.storedAt) > textPreviewCacheTTL {
+		return nil
+	}
+	return entry.preview
+}
+
+// cachePreview keeps a preview, making room by dropping expired previews,
+// or the oldest one when none has expired
+func (tps *TextPreviewService) cachePreview(key string, preview *models.TextPreview) {
+	textPreviewCache.Lock()
+	defer textPreviewCache.Unlock()
+
+	now := tps.clock.Now()
+	if textPreviewCache.entries == nil {
+		textPreviewCache.entries = make(map[string]textPreviewEntry)
+	}
+	if len(textPreviewCache.entries) >= textPreviewCacheSize {
+		oldest := ""
+		for k, entry := range textPreviewCache.entries {
+			if now.Sub(entry.storedAt) > textPreviewCacheTTL {
+				delete(textPreviewCache.entries, k)
+			} else if oldest == "" || entry.storedAt.Before(textPreviewCache.entries[oldest].storedAt) {
+				oldest = k
+			}
+		}
+		if len(textPreviewCache.entries) >= textPreviewCacheSize {
+			delete(textPreviewCache.entries, oldest)
+		}
+	}
+	textPreviewCache.entries[key] = textPreviewEntry{preview: preview, storedAt: now}
+}
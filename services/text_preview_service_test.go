@@ -0,0 +1,56 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"oncloud/models"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestGetTextPreviewReadsObjectStore(t *testing.T) {
+	db := testDatabase(t)
+	store := newFakeObjectStore()
+	tps := NewTextPreviewServiceWith(Dependencies{Database: testDatabaseSource{db}, Storage: store})
+	ctx := context.Background()
+
+	userID := primitive.NewObjectID()
+	file := &models.File{
+		ID:              primitive.NewObjectID(),
+		UserID:          userID,
+		Name:            "main.go",
+		OriginalName:    "main.go",
+		MimeType:        "text/plain",
+		Size:            28,
+		StorageProvider: "local",
+		StorageKey:      "users/main.go",
+		UpdatedAt:       time.Now(),
+	}
+	if _, err := db.Collection("files").InsertOne(ctx, file); err != nil {
+		t.Fatal(err)
+	}
+	store.UploadFile(ctx, file.StorageProvider, file.StorageKey, []byte("package main\n\nfunc main() {}\n"))
+
+	preview, err := tps.GetTextPreview(ctx, userID, file.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preview.Language != "go" || preview.LineCount != 3 || preview.Truncated {
+		t.Fatalf("preview language %q, %d lines, truncated %v", preview.Language, preview.LineCount, preview.Truncated)
+	}
+
+	// A second preview is served from the cache
+	if _, err := tps.GetTextPreview(ctx, userID, file.ID, 0); err != nil {
+		t.Fatal(err)
+	}
+	if store.reads != 1 {
+		t.Fatalf("object store read %d times, want 1", store.reads)
+	}
+
+	// Other users' files are not found
+	if _, err := tps.GetTextPreview(ctx, primitive.NewObjectID(), file.ID, 0); !errors.Is(err, ErrFileNotFound) {
+		t.Fatalf("preview of another user's file: %v", err)
+	}
+}
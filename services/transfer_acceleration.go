@@ -0,0 +1,50 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"oncloud/storage"
+)
+
+// transferOptions configures moving large objects to and from providers as
+// ranged parts in parallel. Objects are moved in a single stream while
+// Concurrency is 1.
+var transferOptions = storage.TransferOptions{Concurrency: 1, PartSize: storage.MinTransferPartSize}
+
+// InitTransferAcceleration configures moving large objects to and from
+// providers as ranged parts in parallel
+func InitTransferAcceleration(opts storage.TransferOptions) {
+	transferOptions = opts
+}
+
+// uploadObject uploads content as parallel parts when transfers are
+// accelerated and the client supports it, falling back to a single stream
+// when the parallel upload fails
+func uploadObject(client storage.StorageInterface, key string, content []byte) error {
+	if parallel, ok := client.(storage.ParallelTransferer); ok && transferOptions.Concurrency > 1 {
+		err := parallel.UploadParallel(key, content, transferOptions)
+		if err == nil {
+			return nil
+		}
+		log.Printf("Parallel upload of %s failed, retrying as a single stream: %v", key, err)
+	}
+	return client.Upload(key, content)
+}
+
+// downloadObject downloads an object as parallel ranged parts when transfers
+// are accelerated and the client supports it, falling back to a single
+// stream when the parallel download fails
+func downloadObject(client storage.StorageInterface, key string) ([]byte, error) {
+	if parallel, ok := client.(storage.ParallelTransferer); ok && transferOptions.Concurrency > 1 {
+		content, err := parallel.DownloadParallel(key, transferOptions)
+		if err == nil {
+			return content, nil
+		}
+		var storageErr *storage.StorageError
+		if errors.As(err, &storageErr) && storageErr.Code == "NOT_FOUND" {
+			return nil, err
+		}
+		log.Printf("Parallel download of %s failed, retrying as a single stream: %v", key, err)
+	}
+	return client.Download(key)
+}
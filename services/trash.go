@@ -0,0 +1,129 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"oncloud/models"
+	"oncloud/utils"
+	"path/filepath"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrNotInTrash = notFoundError("item not found in trash")
+	// ErrRestoreTarget is returned when the folder chosen to restore into
+	// does not exist, is in the trash or is inside the folder restored
+	ErrRestoreTarget = errors.New("restore folder not found or not allowed")
+)
+
+// maxRestoreRenames bounds the numbered names tried for a restored item
+// whose name is taken
+const maxRestoreRenames = 1000
+
+// trashLocations looks up the folders items in the trash were deleted from
+func trashLocations(ctx context.Context, folders *mongo.Collection, userID primitive.ObjectID, parentIDs []*primitive.ObjectID) (map[primitive.ObjectID]models.Folder, error) {
+	ids := []primitive.ObjectID{}
+	for _, id := range parentIDs {
+		if id != nil {
+			ids = append(ids, *id)
+		}
+	}
+
+	found := map[primitive.ObjectID]models.Folder{}
+	if len(ids) == 0 {
+		return found, nil
+	}
+
+	cursor, err := folders.Find(ctx,
+		bson.M{"_id": bson.M{"$in": ids}, "user_id": userID},
+		options.Find().SetProjection(bson.M{"_id": 1, "path": 1, "is_deleted": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var parents []models.Folder
+	if err := cursor.All(ctx, &parents); err != nil {
+		return nil, err
+	}
+	for _, parent := range parents {
+		found[parent.ID] = parent
+	}
+	return found, nil
+}
+
+// trashLocation describes parentID using the folders from trashLocations
+func trashLocation(parents map[primitive.ObjectID]models.Folder, parentID *primitive.ObjectID) models.TrashLocation {
+	if parentID == nil {
+		return models.TrashLocation{Path: "/", Available: true}
+	}
+	parent, ok := parents[*parentID]
+	if !ok {
+		return models.TrashLocation{FolderID: parentID}
+	}
+	return models.TrashLocation{FolderID: parentID, Path: parent.Path, Available: !parent.IsDeleted}
+}
+
+// restoreTarget picks the folder an item from the trash goes back into: the
+// one chosen in req, else the one it was deleted from while that is still
+// available, else the root
+func restoreTarget(ctx context.Context, folders *mongo.Collection, userID primitive.ObjectID, original *primitive.ObjectID, req *models.RestoreRequest) (*primitive.ObjectID, error) {
+	if req != nil && req.Root {
+		return nil, nil
+	}
+
+	if req != nil && req.FolderID != "" {
+		folderID, _ := utils.StringToObjectID(req.FolderID)
+		count, err := folders.CountDocuments(ctx, bson.M{"_id": folderID, "user_id": userID, "is_deleted": false})
+		if err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			return nil, ErrRestoreTarget
+		}
+		return &folderID, nil
+	}
+
+	if original == nil {
+		return nil, nil
+	}
+	count, err := folders.CountDocuments(ctx, bson.M{"_id": *original, "user_id": userID, "is_deleted": false})
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, nil
+	}
+	return original, nil
+}
+
+// freeRestoreName returns name, or the first of "name (2)", "name (3)"...
+// with the extension kept, for which no live item matches filter
+func freeRestoreName(ctx context.Context, collection *mongo.Collection, filter bson.M, name string, keepExt bool) (string, error) {
+	base, ext := name, ""
+	if keepExt {
+		ext = filepath.Ext(name)
+		base = strings.TrimSuffix(name, ext)
+	}
+
+	candidate := name
+	for i := 1; i <= maxRestoreRenames; i++ {
+		if i > 1 {
+			candidate = fmt.Sprintf("%s (%d)%s", base, i, ext)
+		}
+		filter["name"] = candidate
+		count, err := collection.CountDocuments(ctx, filter)
+		if err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("no free name for %s", name)
+}
@@ -0,0 +1,477 @@
+package services
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"oncloud/models"
+	"oncloud/utils"
+	"path"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TrashOptions configures emptying the trash
+type TrashOptions struct {
+	// ConfirmWindow is how long a request to empty the trash waits for
+	// confirmation
+	ConfirmWindow time.Duration
+	// EmptyDelay is how long after confirmation the trash is emptied, during
+	// which it can still be downloaded or the request canceled
+	EmptyDelay time.Duration
+}
+
+var trashOptions = TrashOptions{ConfirmWindow: 15 * time.Minute, EmptyDelay: 10 * time.Minute}
+
+// trashEmptyStaleAfter is how long a running empty can go without progress
+// before it is taken to have been interrupted by a restart, and run again
+const trashEmptyStaleAfter = time.Hour
+
+var (
+	ErrTrashEmpty          = errors.New("the trash is empty")
+	ErrTrashEmptyNotFound  = notFoundError("no request to empty the trash found")
+	ErrTrashEmptyScheduled = errors.New("emptying the trash is already scheduled")
+	// ErrTrashEmptyToken is returned for a confirmation token that does not
+	// match a pending request, or whose request lapsed
+	ErrTrashEmptyToken = errors.New("invalid or expired confirmation token")
+)
+
+// InitTrash configures emptying the trash
+func InitTrash(opts TrashOptions) {
+	if opts.ConfirmWindow <= 0 {
+		opts.ConfirmWindow = 15 * time.Minute
+	}
+	if opts.EmptyDelay < 0 {
+		opts.EmptyDelay = 0
+	}
+	trashOptions = opts
+}
+
+// TrashService empties users' trash, after they confirm, and packs what is
+// in it into an archive they can download first
+type TrashService struct {
+	*BaseService
+	storageService ObjectStore
+	fileService    *FileService
+	auditService   *AuditService
+}
+
+func NewTrashService() *TrashService {
+	return NewTrashServiceWith(Dependencies{})
+}
+
+// NewTrashServiceWith creates a trash service on the given dependencies
+func NewTrashServiceWith(deps Dependencies) *TrashService {
+	return &TrashService{
+		BaseService:    NewBaseServiceWith(deps),
+		storageService: deps.storage(),
+		fileService:    NewFileServiceWith(deps),
+		auditService:   NewAuditServiceWith(deps),
+	}
+}
+
+// RequestEmpty asks for the user's trash to be emptied, replacing any
+// request still waiting for confirmation. The request is returned with the
+// token that confirms it, which is only ever given out here.
+func (ts *TrashService) RequestEmpty(ctx context.Context, userID primitive.ObjectID) (*models.TrashEmptyJob, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	active, err := ts.collections.TrashEmptyJobs().CountDocuments(ctx, bson.M{
+		"user_id": userID,
+		"status":  bson.M{"$in": []string{models.TrashEmptyScheduled, models.TrashEmptyRunning}},
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	if active > 0 {
+		return nil, "", ErrTrashEmptyScheduled
+	}
+
+	now := ts.clock.Now()
+	job := &models.TrashEmptyJob{
+		ID:            primitive.NewObjectID(),
+		UserID:        userID,
+		Status:        models.TrashEmptyPending,
+		ConfirmBy:     now.Add(trashOptions.ConfirmWindow),
+		TrashedBefore: now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	if err := ts.countTrash(ctx, job); err != nil {
+		return nil, "", fmt.Errorf("failed to count the trash: %v", err)
+	}
+	if job.Files == 0 && job.Folders == 0 {
+		return nil, "", ErrTrashEmpty
+	}
+
+	token, err := utils.GenerateSecureToken(32)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to generate confirmation token: %v", err)
+	}
+	job.TokenHash = utils.HashSHA256(token)
+
+	if _, err := ts.collections.TrashEmptyJobs().UpdateMany(ctx,
+		bson.M{"user_id": userID, "status": models.TrashEmptyPending},
+		bson.M{"$set": bson.M{"status": models.TrashEmptyCanceled, "updated_at": now}},
+	); err != nil {
+		return nil, "", fmt.Errorf("failed to replace the previous request: %v", err)
+	}
+	if _, err := ts.collections.TrashEmptyJobs().InsertOne(ctx, job); err != nil {
+		return nil, "", fmt.Errorf("failed to save the request: %v", err)
+	}
+	return job, token, nil
+}
+
+// ConfirmEmpty schedules the request the token confirms to run once the
+// grace period is over
+func (ts *TrashService) ConfirmEmpty(ctx context.Context, userID primitive.ObjectID, token string) (*models.TrashEmptyJob, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	now := ts.clock.Now()
+	var job models.TrashEmptyJob
+	err := ts.collections.TrashEmptyJobs().FindOneAndUpdate(ctx,
+		bson.M{
+			"user_id":    userID,
+			"status":     models.TrashEmptyPending,
+			"token_hash": utils.HashSHA256(token),
+			"confirm_by": bson.M{"$gt": now},
+		},
+		bson.M{"$set": bson.M{
+			"status":     models.TrashEmptyScheduled,
+			"run_at":     now.Add(trashOptions.EmptyDelay),
+			"updated_at": now,
+		}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&job)
+	if err != nil {
+		return nil, findError(err, ErrTrashEmptyToken)
+	}
+
+	ts.auditService.Record(ctx, &models.AuditLog{
+		ActorType:    "user",
+		ActorID:      &userID,
+		Action:       "trash.empty_scheduled",
+		ResourceType: "trash_empty_job",
+		ResourceID:   job.ID.Hex(),
+		Outcome:      "success",
+		Details: map[string]interface{}{
+			"files":   job.Files,
+			"folders": job.Folders,
+			"size":    job.Size,
+			"run_at":  job.RunAt,
+		},
+	})
+	return &job, nil
+}
+
+// CancelEmpty cancels the user's request to empty the trash while it waits
+// for confirmation or for its grace period
+func (ts *TrashService) CancelEmpty(ctx context.Context, userID primitive.ObjectID) (*models.TrashEmptyJob, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var job models.TrashEmptyJob
+	err := ts.collections.TrashEmptyJobs().FindOneAndUpdate(ctx,
+		bson.M{
+			"user_id": userID,
+			"status":  bson.M{"$in": []string{models.TrashEmptyPending, models.TrashEmptyScheduled}},
+		},
+		bson.M{"$set": bson.M{"status": models.TrashEmptyCanceled, "updated_at": ts.clock.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&job)
+	if err != nil {
+		return nil, findError(err, ErrTrashEmptyNotFound)
+	}
+	return &job, nil
+}
+
+// GetEmptyJob returns the user's latest request to empty the trash
+func (ts *TrashService) GetEmptyJob(ctx context.Context, userID primitive.ObjectID) (*models.TrashEmptyJob, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var job models.TrashEmptyJob
+	err := ts.collections.TrashEmptyJobs().FindOne(ctx,
+		bson.M{"user_id": userID},
+		options.FindOne().SetSort(bson.M{"created_at": -1}),
+	).Decode(&job)
+	if err != nil {
+		return nil, findError(err, ErrTrashEmptyNotFound)
+	}
+	return &job, nil
+}
+
+// RunScheduledEmpties empties the trash of the requests whose grace period
+// is over, and of those interrupted by a restart, and returns how many ran
+func (ts *TrashService) RunScheduledEmpties(ctx context.Context) (int, error) {
+	ran := 0
+	for ctx.Err() == nil {
+		now := ts.clock.Now()
+		var job models.TrashEmptyJob
+		err := ts.collections.TrashEmptyJobs().FindOneAndUpdate(ctx,
+			bson.M{"$or": []bson.M{
+				{"status": models.TrashEmptyScheduled, "run_at": bson.M{"$lte": now}},
+				{"status": models.TrashEmptyRunning, "updated_at": bson.M{"$lt": now.Add(-trashEmptyStaleAfter)}},
+			}},
+			bson.M{"$set": bson.M{"status": models.TrashEmptyRunning, "updated_at": now}},
+			options.FindOneAndUpdate().SetSort(bson.M{"run_at": 1}).SetReturnDocument(options.After),
+		).Decode(&job)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ran, nil
+		}
+		if err != nil {
+			return ran, err
+		}
+
+		ts.runEmpty(ctx, &job)
+		ran++
+	}
+	return ran, ctx.Err()
+}
+
+// runEmpty permanently deletes the files and folders trashed before the
+// request, and records the outcome on it
+func (ts *TrashService) runEmpty(ctx context.Context, job *models.TrashEmptyJob) {
+	purgedFiles, keptFiles, err := ts.purgeFiles(ctx, job)
+	purgedFolders := 0
+	if err == nil {
+		purgedFolders, err = ts.purgeFolders(ctx, job)
+	}
+
+	now := ts.clock.Now()
+	set := bson.M{
+		"status":         models.TrashEmptyCompleted,
+		"purged_files":   purgedFiles,
+		"purged_folders": purgedFolders,
+		"kept_files":     keptFiles,
+		"updated_at":     now,
+		"completed_at":   now,
+	}
+	if err != nil {
+		log.Printf("Failed to empty the trash of user %s: %v", job.UserID.Hex(), err)
+		set["status"] = models.TrashEmptyFailed
+		set["error"] = err.Error()
+	}
+
+	updateCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	if _, err := ts.collections.TrashEmptyJobs().UpdateOne(updateCtx, bson.M{"_id": job.ID}, bson.M{"$set": set}); err != nil {
+		log.Printf("Failed to record emptying the trash of user %s: %v", job.UserID.Hex(), err)
+	}
+}
+
+// purgeFiles deletes the objects and records of the files trashed before
+// the request. Files under a takedown are kept as evidence, and files whose
+// object could not be deleted are kept for a later try.
+func (ts *TrashService) purgeFiles(ctx context.Context, job *models.TrashEmptyJob) (int, int, error) {
+	cursor, err := ts.collections.Files().Find(ctx, trashedBefore(job))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	purged, kept := 0, 0
+	for cursor.Next(ctx) {
+		var file models.File
+		if err := cursor.Decode(&file); err != nil {
+			return purged, kept, err
+		}
+		if file.TakedownID != nil {
+			kept++
+			continue
+		}
+
+		if err := ts.storageService.DeleteFile(ctx, file.StorageProvider, file.StorageKey); err != nil {
+			if ctx.Err() != nil {
+				return purged, kept, ctx.Err()
+			}
+			log.Printf("Failed to delete the object of trashed file %s: %v", file.ID.Hex(), err)
+			kept++
+			continue
+		}
+		if err := ts.fileService.deleteFileRecord(ctx, &file); err != nil {
+			return purged, kept, fmt.Errorf("failed to delete file record: %v", err)
+		}
+		purged++
+
+		// Show progress, so a long run is not taken for an interrupted one
+		if purged%100 == 0 {
+			ts.collections.TrashEmptyJobs().UpdateOne(ctx, bson.M{"_id": job.ID},
+				bson.M{"$set": bson.M{"purged_files": purged, "updated_at": ts.clock.Now()}})
+		}
+	}
+	return purged, kept, cursor.Err()
+}
+
+// purgeFolders deletes the folders trashed before the request that no
+// longer hold any file
+func (ts *TrashService) purgeFolders(ctx context.Context, job *models.TrashEmptyJob) (int, error) {
+	holding, err := ts.collections.Files().Distinct(ctx, "folder_id", bson.M{
+		"user_id":   job.UserID,
+		"folder_id": bson.M{"$exists": true},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	filter := trashedBefore(job)
+	if len(holding) > 0 {
+		filter["_id"] = bson.M{"$nin": holding}
+	}
+	result, err := ts.collections.Folders().DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	return int(result.DeletedCount), nil
+}
+
+// countTrash counts what is in the user's trash into the request
+func (ts *TrashService) countTrash(ctx context.Context, job *models.TrashEmptyJob) error {
+	cursor, err := ts.collections.Files().Aggregate(ctx, []bson.M{
+		{"$match": trashedBefore(job)},
+		{"$group": bson.M{"_id": nil, "files": bson.M{"$sum": 1}, "size": bson.M{"$sum": "$size"}}},
+	})
+	if err != nil {
+		return err
+	}
+	var totals []struct {
+		Files int   `bson:"files"`
+		Size  int64 `bson:"size"`
+	}
+	if err := cursor.All(ctx, &totals); err != nil {
+		return err
+	}
+	if len(totals) > 0 {
+		job.Files, job.Size = totals[0].Files, totals[0].Size
+	}
+
+	folders, err := ts.collections.Folders().CountDocuments(ctx, trashedBefore(job))
+	if err != nil {
+		return err
+	}
+	job.Folders = int(folders)
+	return nil
+}
+
+func trashedBefore(job *models.TrashEmptyJob) bson.M {
+	return bson.M{
+		"user_id":    job.UserID,
+		"is_deleted": true,
+		"deleted_at": bson.M{"$lte": job.TrashedBefore},
+	}
+}
+
+// WriteTrashArchive writes the files in the user's trash to w as a zip
+// archive, each under the path of the folder it was deleted from. Files
+// whose content cannot be read, such as archived or taken down ones, are
+// left out and listed in SKIPPED.txt at the end of the archive.
+func (ts *TrashService) WriteTrashArchive(ctx context.Context, userID primitive.ObjectID, w io.Writer) error {
+	cursor, err := ts.collections.Folders().Find(ctx,
+		bson.M{"user_id": userID},
+		options.Find().SetProjection(bson.M{"_id": 1, "path": 1}),
+	)
+	if err != nil {
+		return err
+	}
+	var folders []models.Folder
+	if err := cursor.All(ctx, &folders); err != nil {
+		return err
+	}
+	paths := make(map[primitive.ObjectID]string, len(folders))
+	for _, folder := range folders {
+		paths[folder.ID] = folder.Path
+	}
+
+	cursor, err = ts.collections.Files().Find(ctx,
+		bson.M{"user_id": userID, "is_deleted": true},
+		options.Find().SetSort(bson.M{"deleted_at": 1}),
+	)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	archive := zip.NewWriter(w)
+	names := make(map[string]bool)
+	var skipped []string
+	for cursor.Next(ctx) {
+		var file models.File
+		if err := cursor.Decode(&file); err != nil {
+			return err
+		}
+
+		name := trashArchiveName(paths, &file, names)
+		if file.TakedownID != nil {
+			skipped = append(skipped, name+": under a takedown")
+			continue
+		}
+		if err := checkFileArchived(&file); err != nil {
+			skipped = append(skipped, name+": in archive storage, restore it first")
+			continue
+		}
+		content, err := ts.storageService.ReadFileContent(ctx, &file)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			skipped = append(skipped, name+": could not be read")
+			continue
+		}
+
+		entry, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     name,
+			Method:   zip.Deflate,
+			Modified: file.UpdatedAt,
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := entry.Write(content); err != nil {
+			return err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+
+	if len(skipped) > 0 {
+		entry, err := archive.Create("SKIPPED.txt")
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(entry, strings.Join(skipped, "\n")+"\n"); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+// trashArchiveName returns the name of a file in the trash archive, under
+// the path of its folder, numbered when the name is already taken
+func trashArchiveName(paths map[primitive.ObjectID]string, file *models.File, taken map[string]bool) string {
+	dir := ""
+	if file.FolderID != nil {
+		dir = strings.TrimPrefix(paths[*file.FolderID], "/")
+	}
+
+	base := strings.ReplaceAll(fileDisplayName(file), "/", "_")
+	name := path.Join(dir, base)
+
+	ext := path.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	for n := 2; taken[name]; n++ {
+		name = fmt.Sprintf("%s (%d)%s", stem, n, ext)
+	}
+	taken[name] = true
+	return name
+}
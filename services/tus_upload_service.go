@@ -0,0 +1,420 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"oncloud/models"
+	"oncloud/utils"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// The tus protocol version and extensions served at /api/tus
+const (
+	TusVersion            = "1.0.0"
+	TusExtensions         = "creation,creation-with-upload,checksum,termination,expiration"
+	TusChecksumAlgorithms = "md5,sha1,sha256"
+)
+
+// tusDataFile is the file an upload's bytes are appended to, in its chunk
+// upload directory
+const tusDataFile = "data"
+
+var (
+	ErrTusUploadNotFound      = notFoundError("upload not found")
+	ErrTusUploadLocked        = errors.New("upload is being written by another request")
+	ErrTusOffsetMismatch      = errors.New("upload offset does not match the upload")
+	ErrTusUploadTooLarge      = errors.New("upload exceeds its length")
+	ErrTusInvalidLength       = errors.New("invalid upload length")
+	ErrTusInvalidMetadata     = errors.New("invalid upload metadata")
+	ErrTusUnsupportedChecksum = errors.New("unsupported checksum algorithm")
+	ErrTusChecksumMismatch    = errors.New("checksum mismatch")
+)
+
+// TusChecksum is the checksum a client sent of a PATCH request's body
+type TusChecksum struct {
+	Algorithm string
+	Digest    []byte
+}
+
+// tusUploadLocks holds the uploads being appended to, so two requests never
+// write to the same upload at once
+var tusUploadLocks = struct {
+	sync.Mutex
+	held map[primitive.ObjectID]bool
+}{held: make(map[primitive.ObjectID]bool)}
+
+func lockTusUpload(id primitive.ObjectID) bool {
+	tusUploadLocks.Lock()
+	defer tusUploadLocks.Unlock()
+	if tusUploadLocks.held[id] {
+		return false
+	}
+	tusUploadLocks.held[id] = true
+	return true
+}
+
+func unlockTusUpload(id primitive.ObjectID) {
+	tusUploadLocks.Lock()
+	delete(tusUploadLocks.held, id)
+	tusUploadLocks.Unlock()
+}
+
+type TusUploadService struct {
+	*BaseService
+	fileService *FileService
+}
+
+func NewTusUploadService() *TusUploadService {
+	return NewTusUploadServiceWith(Dependencies{})
+}
+
+// NewTusUploadServiceWith creates a tus upload service on the given
+// dependencies
+func NewTusUploadServiceWith(deps Dependencies) *TusUploadService {
+	return &TusUploadService{
+		BaseService: NewBaseServiceWith(deps),
+		fileService: NewFileServiceWith(deps),
+	}
+}
+
+// TusMaxSize is the biggest upload accepted, or 0 when there is no limit
+// beyond each plan's
+func TusMaxSize() int64 {
+	return RuntimeSettingInt64(RuntimeSettingMaxUploadSize)
+}
+
+// ParseTusMetadata decodes an Upload-Metadata header: comma separated pairs
+// of a key and its base64 encoded value, which may be left out
+func ParseTusMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		parts := strings.Fields(pair)
+		if len(parts) == 0 || len(parts) > 2 {
+			return nil, ErrTusInvalidMetadata
+		}
+		if _, exists := metadata[parts[0]]; exists {
+			return nil, ErrTusInvalidMetadata
+		}
+
+		value := ""
+		if len(parts) == 2 {
+			decoded, err := base64.StdEncoding.DecodeString(parts[1])
+			if err != nil {
+				return nil, ErrTusInvalidMetadata
+			}
+			value = string(decoded)
+		}
+		metadata[parts[0]] = value
+	}
+	return metadata, nil
+}
+
+// EncodeTusMetadata encodes metadata as an Upload-Metadata header
+func EncodeTusMetadata(metadata map[string]string) string {
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if metadata[key] == "" {
+			pairs = append(pairs, key)
+			continue
+		}
+		pairs = append(pairs, key+" "+base64.StdEncoding.EncodeToString([]byte(metadata[key])))
+	}
+	return strings.Join(pairs, ",")
+}
+
+// ParseTusChecksum decodes an Upload-Checksum header: the algorithm and the
+// base64 encoded digest
+func ParseTusChecksum(header string) (*TusChecksum, error) {
+	parts := strings.Fields(header)
+	if len(parts) != 2 {
+		return nil, ErrTusUnsupportedChecksum
+	}
+	if newTusHash(parts[0]) == nil {
+		return nil, ErrTusUnsupportedChecksum
+	}
+	digest, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid checksum: %v", err)
+	}
+	return &TusChecksum{Algorithm: parts[0], Digest: digest}, nil
+}
+
+func newTusHash(algorithm string) hash.Hash {
+	switch algorithm {
+	case "md5":
+		return md5.New()
+	case "sha1":
+		return sha1.New()
+	case "sha256":
+		return sha256.New()
+	default:
+		return nil
+	}
+}
+
+// CreateUpload starts an upload of length bytes. The file is named after the
+// filename (or name) metadata and created in the folder_id one.
+func (ts *TusUploadService) CreateUpload(ctx context.Context, userID primitive.ObjectID, length int64, metadata map[string]string) (*models.TusUpload, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if length < 0 {
+		return nil, ErrTusInvalidLength
+	}
+	if maxSize := TusMaxSize(); maxSize > 0 && length > maxSize {
+		return nil, ErrTusUploadTooLarge
+	}
+
+	fileName := metadata["filename"]
+	if fileName == "" {
+		fileName = metadata["name"]
+	}
+	if fileName == "" {
+		return nil, fmt.Errorf("%w: a filename is required", ErrTusInvalidMetadata)
+	}
+	folderID := metadata["folder_id"]
+	if folderID != "" && !utils.IsValidObjectID(folderID) {
+		return nil, fmt.Errorf("%w: invalid folder_id", ErrTusInvalidMetadata)
+	}
+
+	now := time.Now()
+	upload := &models.TusUpload{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		Length:    length,
+		Metadata:  metadata,
+		FileName:  fileName,
+		FolderID:  folderID,
+		Status:    models.TusUploadInProgress,
+		ExpiresAt: now.Add(chunkUploadOptions.SessionTTL),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	dir, err := chunkUploadDir(userID.Hex(), upload.ID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create upload: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, tusDataFile), nil, 0600); err != nil {
+		return nil, fmt.Errorf("failed to create upload: %v", err)
+	}
+
+	if _, err := ts.collections.TusUploads().InsertOne(ctx, upload); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to create upload: %v", err)
+	}
+	return upload, nil
+}
+
+// GetUpload returns one of the user's uploads
+func (ts *TusUploadService) GetUpload(ctx context.Context, userID, uploadID primitive.ObjectID) (*models.TusUpload, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var upload models.TusUpload
+	err := ts.collections.TusUploads().FindOne(ctx, bson.M{"_id": uploadID, "user_id": userID}).Decode(&upload)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrTusUploadNotFound
+		}
+		return nil, fmt.Errorf("failed to get upload: %v", err)
+	}
+	// The TTL monitor runs once a minute, so expired uploads can linger
+	if upload.ExpiresAt.Before(time.Now()) {
+		return nil, ErrTusUploadNotFound
+	}
+	return &upload, nil
+}
+
+// AppendChunk appends body to an upload at offset, which must be where the
+// upload is at. Without a checksum, the bytes received before the client
+// went away are kept so it can resume from them; with one, they are only
+// kept when they match it. The file is created once the upload is complete.
+func (ts *TusUploadService) AppendChunk(ctx context.Context, userID, uploadID primitive.ObjectID, offset int64, body io.Reader, checksum *TusChecksum) (*models.TusUpload, error) {
+	if !lockTusUpload(uploadID) {
+		return nil, ErrTusUploadLocked
+	}
+	defer unlockTusUpload(uploadID)
+
+	upload, err := ts.GetUpload(ctx, userID, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if offset != upload.Offset {
+		return upload, ErrTusOffsetMismatch
+	}
+	if upload.Status == models.TusUploadCompleted {
+		return upload, nil
+	}
+
+	dir, err := chunkUploadDir(userID.Hex(), uploadID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.OpenFile(filepath.Join(dir, tusDataFile), os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open upload: %v", err)
+	}
+	defer data.Close()
+
+	// Bytes past the recorded offset are from a request that failed before
+	// they were recorded
+	if err := data.Truncate(upload.Offset); err != nil {
+		return nil, fmt.Errorf("failed to write upload: %v", err)
+	}
+	if _, err := data.Seek(upload.Offset, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to write upload: %v", err)
+	}
+
+	var writer io.Writer = data
+	var hasher hash.Hash
+	if checksum != nil {
+		hasher = newTusHash(checksum.Algorithm)
+		writer = io.MultiWriter(data, hasher)
+	}
+
+	remaining := upload.Length - upload.Offset
+	written, copyErr := io.Copy(writer, io.LimitReader(body, remaining+1))
+	switch {
+	case written > remaining:
+		data.Truncate(upload.Offset)
+		return upload, ErrTusUploadTooLarge
+	case checksum != nil && (copyErr != nil || !bytes.Equal(hasher.Sum(nil), checksum.Digest)):
+		data.Truncate(upload.Offset)
+		if copyErr != nil {
+			return upload, fmt.Errorf("failed to read upload data: %v", copyErr)
+		}
+		return upload, ErrTusChecksumMismatch
+	}
+	if err := data.Sync(); err != nil {
+		return nil, fmt.Errorf("failed to write upload: %v", err)
+	}
+
+	if written > 0 {
+		if err := ts.recordOffset(ctx, upload, upload.Offset+written); err != nil {
+			return nil, err
+		}
+	}
+	if copyErr != nil {
+		return upload, fmt.Errorf("failed to read upload data: %v", copyErr)
+	}
+
+	if upload.Offset == upload.Length {
+		if err := ts.complete(ctx, upload); err != nil {
+			return upload, err
+		}
+	}
+	return upload, nil
+}
+
+// recordOffset moves an upload to offset and extends its expiry
+func (ts *TusUploadService) recordOffset(ctx context.Context, upload *models.TusUpload, offset int64) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	expiresAt := now.Add(chunkUploadOptions.SessionTTL)
+	_, err := ts.collections.TusUploads().UpdateOne(ctx, bson.M{"_id": upload.ID}, bson.M{
+		"$set": bson.M{"offset": offset, "expires_at": expiresAt, "updated_at": now},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update upload: %v", err)
+	}
+
+	upload.Offset = offset
+	upload.ExpiresAt = expiresAt
+	upload.UpdatedAt = now
+	return nil
+}
+
+// complete creates the file of an upload whose bytes have all been received.
+// When creating it fails, the bytes are kept so that an empty PATCH at the
+// upload's length retries it.
+func (ts *TusUploadService) complete(ctx context.Context, upload *models.TusUpload) error {
+	dir, err := chunkUploadDir(upload.UserID.Hex(), upload.ID.Hex())
+	if err != nil {
+		return err
+	}
+	content, err := os.ReadFile(filepath.Join(dir, tusDataFile))
+	if err != nil {
+		return fmt.Errorf("failed to read upload: %v", err)
+	}
+
+	file, err := ts.fileService.UploadContent(ctx, upload.UserID, upload.FileName, content, &models.FileUploadRequest{
+		FolderID: upload.FolderID,
+		Source:   models.UploadSourceUpload,
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	_, err = ts.collections.TusUploads().UpdateOne(ctx, bson.M{"_id": upload.ID}, bson.M{
+		"$set": bson.M{"status": models.TusUploadCompleted, "file_id": file.ID, "updated_at": time.Now()},
+	})
+	if err != nil {
+		log.Printf("Failed to mark tus upload %s completed: %v", upload.ID.Hex(), err)
+	}
+	upload.Status = models.TusUploadCompleted
+	upload.FileID = &file.ID
+
+	os.RemoveAll(dir)
+	return nil
+}
+
+// TerminateUpload discards an upload and the bytes received for it. A file
+// already created from it is left alone.
+func (ts *TusUploadService) TerminateUpload(ctx context.Context, userID, uploadID primitive.ObjectID) error {
+	if !lockTusUpload(uploadID) {
+		return ErrTusUploadLocked
+	}
+	defer unlockTusUpload(uploadID)
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := ts.collections.TusUploads().DeleteOne(ctx, bson.M{"_id": uploadID, "user_id": userID})
+	if err != nil {
+		return fmt.Errorf("failed to terminate upload: %v", err)
+	}
+	if result.DeletedCount == 0 {
+		return ErrTusUploadNotFound
+	}
+
+	if dir, err := chunkUploadDir(userID.Hex(), uploadID.Hex()); err == nil {
+		os.RemoveAll(dir)
+	}
+	return nil
+}
@@ -0,0 +1,288 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"oncloud/models"
+	"oncloud/storage"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ChunkUploadOptions configures where chunked uploads are kept until they are
+// completed and how long unfinished uploads are kept
+type ChunkUploadOptions struct {
+	Dir        string
+	SessionTTL time.Duration
+}
+
+var chunkUploadOptions = &ChunkUploadOptions{
+	Dir:        filepath.Join(os.TempDir(), "oncloud-chunks"),
+	SessionTTL: 24 * time.Hour,
+}
+
+// uploadCleanupMaxErrors caps the errors recorded on a cleanup run
+const uploadCleanupMaxErrors = 50
+
+var ErrInvalidUploadID = errors.New("invalid upload ID")
+
+// Upload IDs are chosen by clients and become directory names
+var uploadIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// InitChunkUploads sets where chunks are stored and when unfinished uploads
+// are abandoned
+func InitChunkUploads(opts ChunkUploadOptions) {
+	chunkUploadOptions = &opts
+}
+
+// chunkUploadDir returns the directory holding the chunks of a user's upload
+func chunkUploadDir(userID, uploadID string) (string, error) {
+	if !uploadIDPattern.MatchString(uploadID) {
+		return "", ErrInvalidUploadID
+	}
+	return filepath.Join(chunkUploadOptions.Dir, userID, uploadID), nil
+}
+
+type UploadCleanupService struct {
+	*BaseService
+	storageService *StorageService
+}
+
+func NewUploadCleanupService() *UploadCleanupService {
+	return NewUploadCleanupServiceWith(Dependencies{})
+}
+
+// NewUploadCleanupServiceWith creates an upload cleanup service on the given
+// dependencies
+func NewUploadCleanupServiceWith(deps Dependencies) *UploadCleanupService {
+	return &UploadCleanupService{
+		BaseService:    NewBaseServiceWith(deps),
+		storageService: NewStorageServiceWith(deps),
+	}
+}
+
+// RunCleanup removes uploads abandoned for longer than the session TTL:
+// expired multipart sessions, the multipart uploads left in progress on
+// providers and the chunks of chunked uploads that were never completed. The
+// run is recorded with the space it reclaimed.
+func (us *UploadCleanupService) RunCleanup(ctx context.Context, trigger string) (*models.UploadCleanupRun, error) {
+	run := &models.UploadCleanupRun{
+		ID:        primitive.NewObjectID(),
+		Trigger:   trigger,
+		Errors:    []string{},
+		StartedAt: us.clock.Now(),
+	}
+	cutoff := run.StartedAt.Add(-chunkUploadOptions.SessionTTL)
+
+	if err := us.expireSessions(ctx, run); err != nil {
+		return nil, err
+	}
+	us.abortProviderUploads(ctx, run, cutoff)
+	us.removeChunkUploads(run, cutoff)
+
+	run.CompletedAt = us.clock.Now()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if _, err := us.collections.UploadCleanupRuns().InsertOne(ctx, run); err != nil {
+		return nil, fmt.Errorf("failed to save cleanup run: %v", err)
+	}
+	return run, nil
+}
+
+// ListRuns returns past cleanup runs, newest first
+func (us *UploadCleanupService) ListRuns(ctx context.Context, page, limit int) ([]models.UploadCleanupRun, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	cursor, err := us.collections.UploadCleanupRuns().Find(ctx, bson.M{},
+		options.Find().
+			SetSort(bson.M{"started_at": -1}).
+			SetSkip(int64((page-1)*limit)).
+			SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	runs := []models.UploadCleanupRun{}
+	if err := cursor.All(ctx, &runs); err != nil {
+		return nil, 0, err
+	}
+
+	total, err := us.collections.UploadCleanupRuns().CountDocuments(ctx, bson.M{})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return runs, int(total), nil
+}
+
+// expireSessions marks multipart sessions past their expiry as expired
+func (us *UploadCleanupService) expireSessions(ctx context.Context, run *models.UploadCleanupRun) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	query := bson.M{"status": "initiated", "expires_at": bson.M{"$lt": run.StartedAt}}
+	cursor, err := us.collections.MultipartUploads().Find(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to find expired upload sessions: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var session struct {
+			ID    string `bson:"_id"`
+			Parts []struct {
+				Size int64 `bson:"size"`
+			} `bson:"parts"`
+		}
+		if err := cursor.Decode(&session); err != nil {
+			continue
+		}
+
+		result, err := us.collections.MultipartUploads().UpdateOne(ctx,
+			bson.M{"_id": session.ID, "status": "initiated"},
+			bson.M{"$set": bson.M{"status": "expired", "aborted_at": us.clock.Now()}},
+		)
+		if err != nil || result.ModifiedCount == 0 {
+			continue
+		}
+		run.SessionsExpired++
+		for _, part := range session.Parts {
+			run.ReclaimedBytes += part.Size
+		}
+	}
+	return cursor.Err()
+}
+
+// abortProviderUploads aborts the multipart uploads started on active
+// providers before cutoff. Providers keep, and bill for, the parts of uploads
+// that are never completed or aborted.
+func (us *UploadCleanupService) abortProviderUploads(ctx context.Context, run *models.UploadCleanupRun, cutoff time.Time) {
+	providers, err := us.storageService.GetProviders(ctx)
+	if err != nil {
+		us.recordError(run, "failed to list storage providers: %v", err)
+		return
+	}
+
+	for i := range providers {
+		provider := &providers[i]
+		if !provider.IsActive {
+			continue
+		}
+		client, err := storage.NewStorageClient(provider)
+		if err != nil {
+			continue
+		}
+		lister, ok := client.(storage.MultipartLister)
+		if !ok {
+			continue
+		}
+
+		uploads, err := lister.ListMultipartUploads()
+		if err != nil {
+			us.recordError(run, "%s: %v", provider.Name, err)
+			continue
+		}
+		for _, upload := range uploads {
+			if !upload.Initiated.Before(cutoff) {
+				continue
+			}
+			if err := client.AbortMultipartUpload(upload.UploadID, upload.Key); err != nil {
+				us.recordError(run, "%s: %s: %v", provider.Name, upload.Key, err)
+				continue
+			}
+			run.ProviderUploadsAborted++
+			run.ReclaimedBytes += upload.Size
+		}
+	}
+}
+
+// removeChunkUploads deletes the chunks of chunked uploads which have not
+// received a chunk since cutoff
+func (us *UploadCleanupService) removeChunkUploads(run *models.UploadCleanupRun, cutoff time.Time) {
+	userDirs, err := os.ReadDir(chunkUploadOptions.Dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			us.recordError(run, "failed to read chunk directory: %v", err)
+		}
+		return
+	}
+
+	for _, userDir := range userDirs {
+		if !userDir.IsDir() {
+			continue
+		}
+		userPath := filepath.Join(chunkUploadOptions.Dir, userDir.Name())
+		uploadDirs, err := os.ReadDir(userPath)
+		if err != nil {
+			continue
+		}
+
+		for _, uploadDir := range uploadDirs {
+			if !uploadDir.IsDir() {
+				continue
+			}
+			uploadPath := filepath.Join(userPath, uploadDir.Name())
+			size, lastWrite, err := chunkUploadUsage(uploadPath)
+			if err != nil || lastWrite.After(cutoff) {
+				continue
+			}
+			if err := os.RemoveAll(uploadPath); err != nil {
+				us.recordError(run, "failed to remove %s: %v", uploadPath, err)
+				continue
+			}
+			run.ChunkUploadsRemoved++
+			run.ReclaimedBytes += size
+		}
+
+		// Drop the user's directory once their last upload is gone
+		os.Remove(userPath)
+	}
+}
+
+// chunkUploadUsage returns the size of the chunks in dir and when the last
+// was written
+func chunkUploadUsage(dir string) (int64, time.Time, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	lastWrite := info.ModTime()
+
+	chunks, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	var size int64
+	for _, chunk := range chunks {
+		chunkInfo, err := chunk.Info()
+		if err != nil {
+			continue
+		}
+		size += chunkInfo.Size()
+		if chunkInfo.ModTime().After(lastWrite) {
+			lastWrite = chunkInfo.ModTime()
+		}
+	}
+	return size, lastWrite, nil
+}
+
+func (us *UploadCleanupService) recordError(run *models.UploadCleanupRun, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	log.Printf("Upload cleanup: %s", message)
+	if len(run.Errors) < uploadCleanupMaxErrors {
+		run.Errors = append(run.Errors, message)
+	}
+}
@@ -0,0 +1,432 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"oncloud/models"
+	"oncloud/utils"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const maxUploadRules = 50
+
+var (
+	ErrUploadRuleNotFound = notFoundError("upload rule not found")
+	// ErrInvalidUploadRule wraps any rejected rule definition
+	ErrInvalidUploadRule = errors.New("invalid upload rule")
+)
+
+type UploadRuleService struct {
+	*BaseService
+	folderService *FolderService
+}
+
+func NewUploadRuleService() *UploadRuleService {
+	return NewUploadRuleServiceWith(Dependencies{})
+}
+
+// NewUploadRuleServiceWith creates an upload rule service on the given
+// dependencies
+func NewUploadRuleServiceWith(deps Dependencies) *UploadRuleService {
+	return &UploadRuleService{
+		BaseService:   NewBaseServiceWith(deps),
+		folderService: NewFolderServiceWith(deps),
+	}
+}
+
+// UploadAttributes describe an upload for the rules to place
+type UploadAttributes struct {
+	FileName string
+	MimeType string
+	Size     int64
+	Source   string
+	// FolderID is the folder the upload was made into, if any
+	FolderID string
+	Time     time.Time
+}
+
+// ListRules returns the user's upload rules in the order they are tried
+func (urs *UploadRuleService) ListRules(ctx context.Context, userID primitive.ObjectID) ([]models.UploadRule, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	cursor, err := urs.collections.UploadRules().Find(ctx,
+		bson.M{"user_id": userID},
+		options.Find().SetSort(bson.D{{Key: "position", Value: 1}, {Key: "created_at", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	rules := []models.UploadRule{}
+	if err := cursor.All(ctx, &rules); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// CreateRule adds an upload rule at the requested position, moving the
+// rules from there down, or last
+func (urs *UploadRuleService) CreateRule(ctx context.Context, userID primitive.ObjectID, req *models.UploadRuleRequest) (*models.UploadRule, error) {
+	match, err := normalizeUploadRuleMatch(req.Match)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	count, err := urs.collections.UploadRules().CountDocuments(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return nil, err
+	}
+	if count >= maxUploadRules {
+		return nil, fmt.Errorf("%w: at most %d upload rules can be defined", ErrInvalidUploadRule, maxUploadRules)
+	}
+
+	position := int(count)
+	if req.Position != nil && *req.Position < position {
+		position = *req.Position
+		if err := urs.shiftRules(ctx, userID, position, 1); err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	rule := &models.UploadRule{
+		ID:         primitive.NewObjectID(),
+		UserID:     userID,
+		Name:       strings.TrimSpace(req.Name),
+		Position:   position,
+		IsEnabled:  req.IsEnabled == nil || *req.IsEnabled,
+		Match:      match,
+		FolderPath: req.FolderPath,
+		Tags:       NormalizeTags(req.Tags),
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+
+	if _, err := urs.collections.UploadRules().InsertOne(ctx, rule); err != nil {
+		return nil, fmt.Errorf("failed to create upload rule: %v", err)
+	}
+
+	return rule, nil
+}
+
+// UpdateRule replaces an upload rule, moving it when a new position is
+// requested
+func (urs *UploadRuleService) UpdateRule(ctx context.Context, userID, ruleID primitive.ObjectID, req *models.UploadRuleRequest) (*models.UploadRule, error) {
+	match, err := normalizeUploadRuleMatch(req.Match)
+	if err != nil {
+		return nil, err
+	}
+
+	rule, err := urs.GetRule(ctx, userID, ruleID)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	position := rule.Position
+	if req.Position != nil && *req.Position != rule.Position {
+		count, err := urs.collections.UploadRules().CountDocuments(ctx, bson.M{"user_id": userID})
+		if err != nil {
+			return nil, err
+		}
+		position = *req.Position
+		if position > int(count)-1 {
+			position = int(count) - 1
+		}
+
+		// Close the gap the rule leaves, then open one where it goes
+		if err := urs.shiftRules(ctx, userID, rule.Position+1, -1); err != nil {
+			return nil, err
+		}
+		if err := urs.shiftRules(ctx, userID, position, 1); err != nil {
+			return nil, err
+		}
+	}
+
+	isEnabled := rule.IsEnabled
+	if req.IsEnabled != nil {
+		isEnabled = *req.IsEnabled
+	}
+
+	var updated models.UploadRule
+	err = urs.collections.UploadRules().FindOneAndUpdate(ctx,
+		bson.M{"_id": ruleID, "user_id": userID},
+		bson.M{"$set": bson.M{
+			"name":        strings.TrimSpace(req.Name),
+			"position":    position,
+			"is_enabled":  isEnabled,
+			"match":       match,
+			"folder_path": req.FolderPath,
+			"tags":        NormalizeTags(req.Tags),
+			"updated_at":  time.Now(),
+		}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrUploadRuleNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update upload rule: %v", err)
+	}
+
+	return &updated, nil
+}
+
+// GetRule returns one of the user's upload rules
+func (urs *UploadRuleService) GetRule(ctx context.Context, userID, ruleID primitive.ObjectID) (*models.UploadRule, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var rule models.UploadRule
+	err := urs.collections.UploadRules().FindOne(ctx, bson.M{"_id": ruleID, "user_id": userID}).Decode(&rule)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrUploadRuleNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// DeleteRule removes an upload rule; the rules after it move up
+func (urs *UploadRuleService) DeleteRule(ctx context.Context, userID, ruleID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var rule models.UploadRule
+	err := urs.collections.UploadRules().FindOneAndDelete(ctx, bson.M{"_id": ruleID, "user_id": userID}).Decode(&rule)
+	if err == mongo.ErrNoDocuments {
+		return ErrUploadRuleNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete upload rule: %v", err)
+	}
+
+	return urs.shiftRules(ctx, userID, rule.Position+1, -1)
+}
+
+// shiftRules moves the user's rules from position on by delta
+func (urs *UploadRuleService) shiftRules(ctx context.Context, userID primitive.ObjectID, from, delta int) error {
+	_, err := urs.collections.UploadRules().UpdateMany(ctx,
+		bson.M{"user_id": userID, "position": bson.M{"$gte": from}},
+		bson.M{"$inc": bson.M{"position": delta}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to reorder upload rules: %v", err)
+	}
+	return nil
+}
+
+// GetSettings returns the user's upload settings, empty until they are saved
+func (urs *UploadRuleService) GetSettings(ctx context.Context, userID primitive.ObjectID) (*models.UploadSettings, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	settings := models.UploadSettings{UserID: userID}
+	err := urs.collections.UploadSettings().FindOne(ctx, bson.M{"user_id": userID}).Decode(&settings)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// UpdateSettings replaces the user's upload settings
+func (urs *UploadRuleService) UpdateSettings(ctx context.Context, userID primitive.ObjectID, req *models.UploadSettingsRequest) (*models.UploadSettings, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	settings := &models.UploadSettings{
+		UserID:            userID,
+		DefaultFolderPath: req.DefaultFolderPath,
+		OverrideFolder:    req.OverrideFolder,
+		UpdatedAt:         time.Now(),
+	}
+
+	_, err := urs.collections.UploadSettings().ReplaceOne(ctx,
+		bson.M{"user_id": userID},
+		settings,
+		options.Replace().SetUpsert(true),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save upload settings: %v", err)
+	}
+
+	return settings, nil
+}
+
+// Preview shows where the rules would put an upload, without creating any
+// folders
+func (urs *UploadRuleService) Preview(ctx context.Context, userID primitive.ObjectID, req *models.UploadRulePreviewRequest) (*models.UploadPlacement, error) {
+	source := req.Source
+	if source == "" {
+		source = models.UploadSourceUpload
+	}
+	mimeType := mime.TypeByExtension(strings.ToLower(filepath.Ext(req.FileName)))
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+
+	placement, err := urs.Place(ctx, userID, &UploadAttributes{
+		FileName: req.FileName,
+		MimeType: mimeType,
+		Size:     req.Size,
+		Source:   source,
+		FolderID: req.FolderID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if placement.FolderPath != "" {
+		folderID, err := urs.folderService.ResolveFolderPath(ctx, userID, placement.FolderPath, false)
+		if err != nil {
+			return nil, err
+		}
+		placement.FolderExists = folderID != nil
+	}
+
+	return placement, nil
+}
+
+// Place evaluates the user's rules and settings for an upload. Rules only
+// apply to uploads made without a folder unless the user's settings
+// override chosen folders; the default folder path only to uploads made
+// without a folder that no rule matched.
+func (urs *UploadRuleService) Place(ctx context.Context, userID primitive.ObjectID, attrs *UploadAttributes) (*models.UploadPlacement, error) {
+	settings, err := urs.GetSettings(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	placement := &models.UploadPlacement{Tags: []string{}}
+	if attrs.FolderID != "" && !settings.OverrideFolder {
+		return placement, nil
+	}
+
+	rules, err := urs.ListRules(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	vars := utils.FolderPathVars{
+		Category:  utils.FileCategory(attrs.MimeType),
+		Extension: filepath.Ext(attrs.FileName),
+		Source:    attrs.Source,
+		Time:      attrs.Time,
+	}
+
+	for i := range rules {
+		rule := &rules[i]
+		if !rule.IsEnabled || !uploadRuleMatches(&rule.Match, attrs, vars.Category) {
+			continue
+		}
+		folderPath, err := utils.RenderFolderPath(rule.FolderPath, vars)
+		if err != nil {
+			return nil, err
+		}
+		placement.Rule = rule
+		placement.FolderPath = folderPath
+		placement.Tags = rule.Tags
+		return placement, nil
+	}
+
+	if attrs.FolderID == "" && settings.DefaultFolderPath != "" {
+		folderPath, err := utils.RenderFolderPath(settings.DefaultFolderPath, vars)
+		if err != nil {
+			return nil, err
+		}
+		placement.IsDefault = true
+		placement.FolderPath = folderPath
+	}
+
+	return placement, nil
+}
+
+// Apply files an upload according to the user's rules before it is stored,
+// changing the folder and adding tags in req. An upload is never refused
+// because of a rule; when placing it fails it stays where it was made.
+func (urs *UploadRuleService) Apply(ctx context.Context, userID primitive.ObjectID, attrs *UploadAttributes, req *models.FileUploadRequest) {
+	placement, err := urs.Place(ctx, userID, attrs)
+	if err != nil {
+		log.Printf("Failed to evaluate upload rules for user %s: %v", userID.Hex(), err)
+		return
+	}
+	if placement.FolderPath == "" {
+		return
+	}
+
+	folderID, err := urs.folderService.ResolveFolderPath(ctx, userID, placement.FolderPath, true)
+	if err != nil {
+		log.Printf("Failed to create upload folder %s for user %s: %v", placement.FolderPath, userID.Hex(), err)
+		return
+	}
+	if folderID != nil {
+		req.FolderID = folderID.Hex()
+	}
+	req.Tags = append(req.Tags, placement.Tags...)
+}
+
+func uploadRuleMatches(match *models.UploadRuleMatch, attrs *UploadAttributes, category string) bool {
+	if len(match.Sources) > 0 && !utils.SliceContains(match.Sources, attrs.Source) {
+		return false
+	}
+	if len(match.Categories) > 0 && !utils.SliceContains(match.Categories, category) {
+		return false
+	}
+	if len(match.Extensions) > 0 {
+		ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(attrs.FileName), "."))
+		if !utils.SliceContains(match.Extensions, ext) {
+			return false
+		}
+	}
+	if match.NamePattern != "" {
+		if ok, _ := path.Match(match.NamePattern, strings.ToLower(attrs.FileName)); !ok {
+			return false
+		}
+	}
+	if match.MinSize != nil && attrs.Size < *match.MinSize {
+		return false
+	}
+	if match.MaxSize != nil && attrs.Size > *match.MaxSize {
+		return false
+	}
+	return true
+}
+
+// normalizeUploadRuleMatch lowercases the conditions compared without case
+// and checks those the validator does not
+func normalizeUploadRuleMatch(match models.UploadRuleMatch) (models.UploadRuleMatch, error) {
+	extensions := make([]string, 0, len(match.Extensions))
+	for _, ext := range match.Extensions {
+		extensions = append(extensions, strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), ".")))
+	}
+	match.Extensions = extensions
+
+	match.NamePattern = strings.ToLower(strings.TrimSpace(match.NamePattern))
+	if _, err := path.Match(match.NamePattern, ""); err != nil {
+		return match, fmt.Errorf("%w: name_pattern is not a valid pattern", ErrInvalidUploadRule)
+	}
+	if match.MinSize != nil && match.MaxSize != nil && *match.MinSize > *match.MaxSize {
+		return match, fmt.Errorf("%w: min_size must not be larger than max_size", ErrInvalidUploadRule)
+	}
+	return match, nil
+}
@@ -5,7 +5,6 @@ import (
 	"errors"
 	"fmt"
 	"mime/multipart"
-	"oncloud/database"
 	"oncloud/models"
 	"oncloud/utils"
 	"strings"
@@ -13,9 +12,12 @@ import (
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+var ErrUserNotFound = notFoundError("user not found")
+
 type UserService struct {
 	*BaseService
 }
@@ -29,12 +31,17 @@ type UserFilters struct {
 }
 
 func NewUserService() *UserService {
+	return NewUserServiceWith(Dependencies{})
+}
+
+// NewUserServiceWith creates an user service on the given dependencies
+func NewUserServiceWith(deps Dependencies) *UserService {
 	return &UserService{
-		BaseService: NewBaseService(),
+		BaseService: NewBaseServiceWith(deps),
 	}
 }
-func (us *UserService) GetByID(userID primitive.ObjectID) (*models.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (us *UserService) GetByID(ctx context.Context, userID primitive.ObjectID) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	var user models.User
@@ -48,8 +55,8 @@ func (us *UserService) GetByID(userID primitive.ObjectID) (*models.User, error)
 }
 
 // UpdateUser updates user fields
-func (us *UserService) UpdateUser(userID primitive.ObjectID, updates bson.M) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (us *UserService) UpdateUser(ctx context.Context, userID primitive.ObjectID, updates bson.M) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	updates["updated_at"] = time.Now()
@@ -62,8 +69,8 @@ func (us *UserService) UpdateUser(userID primitive.ObjectID, updates bson.M) err
 }
 
 // UpdateLastLogin updates user's last login time
-func (us *UserService) UpdateLastLogin(userID primitive.ObjectID) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (us *UserService) UpdateLastLogin(ctx context.Context, userID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	_, err := us.collections.Users().UpdateOne(ctx,
@@ -74,8 +81,8 @@ func (us *UserService) UpdateLastLogin(userID primitive.ObjectID) error {
 }
 
 // GetUserPlan retrieves user's current plan
-func (us *UserService) GetUserPlan(userID primitive.ObjectID) (*models.Plan, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (us *UserService) GetUserPlan(ctx context.Context, userID primitive.ObjectID) (*models.Plan, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	// Get user to find plan ID
@@ -96,8 +103,8 @@ func (us *UserService) GetUserPlan(userID primitive.ObjectID) (*models.Plan, err
 }
 
 // UpdateProfile updates user profile information
-func (us *UserService) UpdateProfile(userID primitive.ObjectID, firstName, lastName, phone, country string) (*models.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (us *UserService) UpdateProfile(ctx context.Context, userID primitive.ObjectID, firstName, lastName, phone, country string) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	updates := bson.M{
@@ -116,11 +123,11 @@ func (us *UserService) UpdateProfile(userID primitive.ObjectID, firstName, lastN
 		return nil, fmt.Errorf("failed to update profile: %v", err)
 	}
 
-	return us.GetByID(userID)
+	return us.GetByID(ctx, userID)
 }
 
 // UploadAvatar uploads and sets user avatar
-func (us *UserService) UploadAvatar(userID primitive.ObjectID, file multipart.File, header *multipart.FileHeader) (string, error) {
+func (us *UserService) UploadAvatar(ctx context.Context, userID primitive.ObjectID, file multipart.File, header *multipart.FileHeader) (string, error) {
 	// Process avatar upload
 	uploadConfig := &utils.UploadConfig{
 		MaxFileSize:  5 * 1024 * 1024, // 5MB
@@ -136,7 +143,7 @@ func (us *UserService) UploadAvatar(userID primitive.ObjectID, file multipart.Fi
 	avatarURL := fmt.Sprintf("/uploads/avatars/%s", fileInfo.Name)
 
 	// Update user avatar
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	_, err = us.collections.Users().UpdateOne(ctx,
@@ -154,8 +161,8 @@ func (us *UserService) UploadAvatar(userID primitive.ObjectID, file multipart.Fi
 }
 
 // DeleteAvatar removes user avatar
-func (us *UserService) DeleteAvatar(userID primitive.ObjectID) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (us *UserService) DeleteAvatar(ctx context.Context, userID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	_, err := us.collections.Users().UpdateOne(ctx,
@@ -169,17 +176,17 @@ func (us *UserService) DeleteAvatar(userID primitive.ObjectID) error {
 }
 
 // GetUserStats calculates user statistics
-func (us *UserService) GetUserStats(userID primitive.ObjectID) (*models.UserStats, error) {
-	_, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (us *UserService) GetUserStats(ctx context.Context, userID primitive.ObjectID) (*models.UserStats, error) {
+	_, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	// Get user and plan
-	user, err := us.GetByID(userID)
+	user, err := us.GetByID(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
 
-	plan, err := us.GetUserPlan(userID)
+	plan, err := us.GetUserPlan(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -208,12 +215,12 @@ func (us *UserService) GetUserStats(userID primitive.ObjectID) (*models.UserStat
 }
 
 // GetDashboardData returns dashboard data for user
-func (us *UserService) GetDashboardData(userID primitive.ObjectID) (map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (us *UserService) GetDashboardData(ctx context.Context, userID primitive.ObjectID) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	// Get user stats
-	stats, err := us.GetUserStats(userID)
+	stats, err := us.GetUserStats(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -246,8 +253,8 @@ func (us *UserService) GetDashboardData(userID primitive.ObjectID) (map[string]i
 }
 
 // GetUserActivity returns user activity log
-func (us *UserService) GetUserActivity(userID primitive.ObjectID, page, limit int) ([]map[string]interface{}, int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (us *UserService) GetUserActivity(ctx context.Context, userID primitive.ObjectID, page, limit int) ([]map[string]interface{}, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	// Calculate skip
@@ -278,8 +285,8 @@ func (us *UserService) GetUserActivity(userID primitive.ObjectID, page, limit in
 }
 
 // GetNotifications returns user notifications
-func (us *UserService) GetNotifications(userID primitive.ObjectID, page, limit int) ([]map[string]interface{}, int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (us *UserService) GetNotifications(ctx context.Context, userID primitive.ObjectID, page, limit int) ([]map[string]interface{}, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	skip := (page - 1) * limit
@@ -307,8 +314,8 @@ func (us *UserService) GetNotifications(userID primitive.ObjectID, page, limit i
 }
 
 // MarkNotificationRead marks notification as read
-func (us *UserService) MarkNotificationRead(userID, notificationID primitive.ObjectID) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (us *UserService) MarkNotificationRead(ctx context.Context, userID, notificationID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	_, err := us.collections.Notifications().UpdateOne(ctx,
@@ -322,8 +329,8 @@ func (us *UserService) MarkNotificationRead(userID, notificationID primitive.Obj
 }
 
 // GetUserSettings returns user settings
-func (us *UserService) GetUserSettings(userID primitive.ObjectID) (map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (us *UserService) GetUserSettings(ctx context.Context, userID primitive.ObjectID) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	// Default user settings
@@ -336,10 +343,11 @@ func (us *UserService) GetUserSettings(userID primitive.ObjectID) (map[string]in
 		"language":            "en",
 		"timezone":            "UTC",
 		"two_factor_enabled":  false,
+		"storage_reports":     true, // monthly storage report emails
 	}
 
 	// Get user-specific settings from database if they exist
-	settingsCollection := database.GetCollection("user_settings")
+	settingsCollection := us.collections.Collection("user_settings")
 	var userSettings map[string]interface{}
 	err := settingsCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&userSettings)
 	if err == nil {
@@ -355,11 +363,11 @@ func (us *UserService) GetUserSettings(userID primitive.ObjectID) (map[string]in
 }
 
 // UpdateUserSettings updates user settings
-func (us *UserService) UpdateUserSettings(userID primitive.ObjectID, settings map[string]interface{}) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (us *UserService) UpdateUserSettings(ctx context.Context, userID primitive.ObjectID, settings map[string]interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	settingsCollection := database.GetCollection("user_settings")
+	settingsCollection := us.collections.Collection("user_settings")
 
 	// Add metadata
 	settings["user_id"] = userID
@@ -374,50 +382,9 @@ func (us *UserService) UpdateUserSettings(userID primitive.ObjectID, settings ma
 	return err
 }
 
-// GetActiveSessions returns user's active sessions
-func (us *UserService) GetActiveSessions(userID primitive.ObjectID) ([]map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	cursor, err := us.collections.Sessions().Find(ctx,
-		bson.M{
-			"user_id":    userID,
-			"is_active":  true,
-			"expires_at": bson.M{"$gt": time.Now()},
-		},
-		options.Find().SetSort(bson.M{"last_activity": -1}),
-	)
-	if err != nil {
-		return nil, err
-	}
-	defer cursor.Close(ctx)
-
-	var sessions []map[string]interface{}
-	if err = cursor.All(ctx, &sessions); err != nil {
-		return nil, err
-	}
-
-	return sessions, nil
-}
-
-// RevokeSession revokes a user session
-func (us *UserService) RevokeSession(userID primitive.ObjectID, sessionID string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	_, err := us.collections.Sessions().UpdateOne(ctx,
-		bson.M{"_id": sessionID, "user_id": userID},
-		bson.M{"$set": bson.M{
-			"is_active":  false,
-			"revoked_at": time.Now(),
-		}},
-	)
-	return err
-}
-
 // API Keys management
-func (us *UserService) GetAPIKeys(userID primitive.ObjectID) ([]map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (us *UserService) GetAPIKeys(ctx context.Context, userID primitive.ObjectID) ([]map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	cursor, err := us.collections.APIKeys().Find(ctx,
@@ -445,8 +412,8 @@ func (us *UserService) GetAPIKeys(userID primitive.ObjectID) ([]map[string]inter
 }
 
 // CreateAPIKey creates a new API key
-func (us *UserService) CreateAPIKey(userID primitive.ObjectID, name string, permissions []string, expiresAt *int64) (map[string]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (us *UserService) CreateAPIKey(ctx context.Context, userID primitive.ObjectID, name string, permissions []string, expiresAt *int64) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	// Generate API key
@@ -485,8 +452,8 @@ func (us *UserService) CreateAPIKey(userID primitive.ObjectID, name string, perm
 }
 
 // UpdateAPIKey updates API key
-func (us *UserService) UpdateAPIKey(userID, keyID primitive.ObjectID, name string, permissions []string, isActive *bool) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (us *UserService) UpdateAPIKey(ctx context.Context, userID, keyID primitive.ObjectID, name string, permissions []string, isActive *bool) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	updates := bson.M{"updated_at": time.Now()}
@@ -509,8 +476,8 @@ func (us *UserService) UpdateAPIKey(userID, keyID primitive.ObjectID, name strin
 }
 
 // DeleteAPIKey deletes API key
-func (us *UserService) DeleteAPIKey(userID, keyID primitive.ObjectID) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (us *UserService) DeleteAPIKey(ctx context.Context, userID, keyID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	_, err := us.collections.APIKeys().DeleteOne(ctx, bson.M{"_id": keyID, "user_id": userID})
@@ -518,8 +485,8 @@ func (us *UserService) DeleteAPIKey(userID, keyID primitive.ObjectID) error {
 }
 
 // 2FA methods
-func (us *UserService) Get2FAStatus(userID primitive.ObjectID) (map[string]interface{}, error) {
-	_, err := us.GetByID(userID)
+func (us *UserService) Get2FAStatus(ctx context.Context, userID primitive.ObjectID) (map[string]interface{}, error) {
+	_, err := us.GetByID(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -574,8 +541,8 @@ func (us *UserService) RegenerateBackupCodes(userID primitive.ObjectID) ([]strin
 }
 
 // Admin methods for user management
-func (us *UserService) GetUsersForAdmin(page, limit int, filters *UserFilters) ([]models.User, int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (us *UserService) GetUsersForAdmin(ctx context.Context, page, limit int, filters *UserFilters) ([]models.User, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	// Build filter query
@@ -591,10 +558,15 @@ func (us *UserService) GetUsersForAdmin(page, limit int, filters *UserFilters) (
 	}
 
 	if filters.Status != "" && filters.Status != "all" {
-		if filters.Status == "active" {
+		switch filters.Status {
+		case "active":
 			filter["is_active"] = true
-		} else if filters.Status == "inactive" {
+			filter["state"] = bson.M{"$exists": false}
+		case "inactive", models.AccountStateSuspended:
 			filter["is_active"] = false
+		case models.AccountStateReadOnly, models.AccountStatePendingDeletion:
+			filter["is_active"] = true
+			filter["state"] = filters.Status
 		}
 	}
 
@@ -648,17 +620,17 @@ func (us *UserService) GetUsersForAdmin(page, limit int, filters *UserFilters) (
 	return users, int(total), nil
 }
 
-func (us *UserService) GetUserForAdmin(userID primitive.ObjectID) (*models.User, error) {
-	return us.GetByID(userID)
+func (us *UserService) GetUserForAdmin(ctx context.Context, userID primitive.ObjectID) (*models.User, error) {
+	return us.GetByID(ctx, userID)
 }
 
-func (us *UserService) CreateUserByAdmin(req interface{}) (*models.User, error) {
+func (us *UserService) CreateUserByAdmin(req *models.AdminUserCreateRequest) (*models.User, error) {
 	// Implement admin user creation
 	return nil, errors.New("not implemented")
 }
 
-func (us *UserService) UpdateUserByAdmin(userID primitive.ObjectID, updates map[string]interface{}) (*models.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (us *UserService) UpdateUserByAdmin(ctx context.Context, userID primitive.ObjectID, updates map[string]interface{}) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	updates["updated_at"] = time.Now()
@@ -671,11 +643,11 @@ func (us *UserService) UpdateUserByAdmin(userID primitive.ObjectID, updates map[
 		return nil, err
 	}
 
-	return us.GetByID(userID)
+	return us.GetByID(ctx, userID)
 }
 
-func (us *UserService) DeleteUserByAdmin(userID primitive.ObjectID) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (us *UserService) DeleteUserByAdmin(ctx context.Context, userID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	_, err := us.collections.Users().UpdateOne(ctx,
@@ -688,40 +660,18 @@ func (us *UserService) DeleteUserByAdmin(userID primitive.ObjectID) error {
 	return err
 }
 
-func (us *UserService) SuspendUser(userID primitive.ObjectID, reason string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	_, err := us.collections.Users().UpdateOne(ctx,
-		bson.M{"_id": userID},
-		bson.M{"$set": bson.M{
-			"is_active":         false,
-			"suspension_reason": reason,
-			"suspended_at":      time.Now(),
-		}},
-	)
+func (us *UserService) SuspendUser(ctx context.Context, userID primitive.ObjectID, reason string) error {
+	_, err := us.SetAccountState(ctx, userID, models.AccountStateSuspended, reason)
 	return err
 }
 
-func (us *UserService) UnsuspendUser(userID primitive.ObjectID) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	_, err := us.collections.Users().UpdateOne(ctx,
-		bson.M{"_id": userID},
-		bson.M{
-			"$set": bson.M{"is_active": true},
-			"$unset": bson.M{
-				"suspension_reason": "",
-				"suspended_at":      "",
-			},
-		},
-	)
+func (us *UserService) UnsuspendUser(ctx context.Context, userID primitive.ObjectID) error {
+	_, err := us.SetAccountState(ctx, userID, models.AccountStateActive, "")
 	return err
 }
 
-func (us *UserService) VerifyUserByAdmin(userID primitive.ObjectID) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (us *UserService) VerifyUserByAdmin(ctx context.Context, userID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	_, err := us.collections.Users().UpdateOne(ctx,
@@ -734,8 +684,16 @@ func (us *UserService) VerifyUserByAdmin(userID primitive.ObjectID) error {
 	return err
 }
 
-func (us *UserService) ResetUserPasswordByAdmin(userID primitive.ObjectID, newPassword string, sendEmail bool) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (us *UserService) ResetUserPasswordByAdmin(ctx context.Context, userID primitive.ObjectID, newPassword string, sendEmail bool) error {
+	user, err := us.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := NewSecurityPolicyService().ValidatePassword(ctx, newPassword, user); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	hashedPassword, err := utils.HashPassword(newPassword)
@@ -753,8 +711,8 @@ func (us *UserService) ResetUserPasswordByAdmin(userID primitive.ObjectID, newPa
 	return err
 }
 
-func (us *UserService) GetUserFilesForAdmin(userID primitive.ObjectID, page, limit int) ([]models.File, int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (us *UserService) GetUserFilesForAdmin(ctx context.Context, userID primitive.ObjectID, page, limit int) ([]models.File, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	skip := (page - 1) * limit
@@ -784,8 +742,8 @@ func (us *UserService) GetUserFilesForAdmin(userID primitive.ObjectID, page, lim
 	return files, int(total), nil
 }
 
-func (us *UserService) GetUserActivityForAdmin(userID primitive.ObjectID, days, page, limit int) ([]map[string]interface{}, int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (us *UserService) GetUserActivityForAdmin(ctx context.Context, userID primitive.ObjectID, days, page, limit int) ([]map[string]interface{}, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	// Filter by date
@@ -821,6 +779,247 @@ func (us *UserService) GetUserActivityForAdmin(userID primitive.ObjectID, days,
 	return activities, int(total), nil
 }
 
+// snapshotActivityLimit and snapshotShareLimit are how many of a user's
+// latest activities and open shares their snapshot lists
+const (
+	snapshotActivityLimit = 20
+	snapshotShareLimit    = 20
+)
+
+// GetUserSnapshotForAdmin returns the account of userID along with its plan,
+// usage, latest activity, open shares, where its files are stored, billing
+// status and flags
+func (us *UserService) GetUserSnapshotForAdmin(ctx context.Context, userID primitive.ObjectID) (*models.UserSnapshot, error) {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	var user models.User
+	if err := us.collections.Users().FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to get user: %v", err)
+	}
+	user.Password = ""
+
+	snapshot := &models.UserSnapshot{
+		User:        &user,
+		Usage:       &models.UserStats{StorageUsed: user.StorageUsed, BandwidthUsed: user.BandwidthUsed, FilesCount: user.FilesCount, FoldersCount: user.FoldersCount},
+		GeneratedAt: time.Now(),
+	}
+
+	var plan models.Plan
+	if err := us.collections.Plans().FindOne(ctx, bson.M{"_id": user.PlanID}).Decode(&plan); err == nil {
+		snapshot.Plan = &plan
+		snapshot.Usage.StorageLimit = plan.StorageLimit
+		snapshot.Usage.BandwidthLimit = plan.BandwidthLimit
+		if plan.StorageLimit > 0 {
+			snapshot.Usage.StoragePercent = utils.CalculateStorageUsage(user.StorageUsed, plan.StorageLimit)
+		}
+		if plan.BandwidthLimit > 0 {
+			snapshot.Usage.BandwidthPercent = utils.CalculateStorageUsage(user.BandwidthUsed, plan.BandwidthLimit)
+		}
+	} else if err != mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("failed to get plan: %v", err)
+	}
+
+	cursor, err := us.collections.Activities().Find(ctx,
+		bson.M{"user_id": userID},
+		options.Find().SetSort(bson.M{"created_at": -1}).SetLimit(snapshotActivityLimit),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get activity: %v", err)
+	}
+	snapshot.RecentActivity = []map[string]interface{}{}
+	if err := cursor.All(ctx, &snapshot.RecentActivity); err != nil {
+		return nil, fmt.Errorf("failed to get activity: %v", err)
+	}
+
+	openShares := bson.M{
+		"user_id":   userID,
+		"is_active": true,
+		"$or": []bson.M{
+			{"expires_at": nil},
+			{"expires_at": bson.M{"$gt": time.Now()}},
+		},
+	}
+	cursor, err = us.collections.FileShares().Find(ctx, openShares,
+		options.Find().SetSort(bson.M{"created_at": -1}).SetLimit(snapshotShareLimit).SetProjection(bson.M{"password": 0}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get shares: %v", err)
+	}
+	snapshot.OpenShares = []models.FileShare{}
+	if err := cursor.All(ctx, &snapshot.OpenShares); err != nil {
+		return nil, fmt.Errorf("failed to get shares: %v", err)
+	}
+	if snapshot.OpenShareCount, err = us.collections.FileShares().CountDocuments(ctx, openShares); err != nil {
+		return nil, fmt.Errorf("failed to count shares: %v", err)
+	}
+
+	cursor, err = us.collections.Files().Aggregate(ctx, []bson.M{
+		{"$match": bson.M{"user_id": userID, "is_deleted": false}},
+		{"$group": bson.M{
+			"_id":   "$storage_provider",
+			"files": bson.M{"$sum": 1},
+			"size":  bson.M{"$sum": "$size"},
+		}},
+		{"$sort": bson.M{"size": -1}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get storage providers: %v", err)
+	}
+	snapshot.Providers = []models.ProviderUsage{}
+	if err := cursor.All(ctx, &snapshot.Providers); err != nil {
+		return nil, fmt.Errorf("failed to get storage providers: %v", err)
+	}
+
+	if err := us.fillSnapshotBilling(ctx, snapshot); err != nil {
+		return nil, err
+	}
+	if err := us.fillSnapshotFlags(ctx, snapshot); err != nil {
+		return nil, err
+	}
+
+	return snapshot, nil
+}
+
+// fillSnapshotBilling reads the latest subscription record and the payments
+// of the snapshot's user into its billing status
+func (us *UserService) fillSnapshotBilling(ctx context.Context, snapshot *models.UserSnapshot) error {
+	user := snapshot.User
+	billing := &snapshot.Billing
+	billing.IsPremium = user.IsPremium
+	billing.PlanExpiresAt = user.PlanExpiresAt
+
+	var subscription bson.M
+	err := us.collections.Subscriptions().FindOne(ctx,
+		bson.M{"user_id": user.ID},
+		options.FindOne().SetSort(bson.M{"created_at": -1}),
+	).Decode(&subscription)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return fmt.Errorf("failed to get subscription: %v", err)
+	}
+	if err == nil {
+		billing.Subscription = subscription
+	}
+
+	var payment models.Payment
+	err = us.collections.Payments().FindOne(ctx,
+		bson.M{"user_id": user.ID},
+		options.FindOne().SetSort(bson.M{"created_at": -1}),
+	).Decode(&payment)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return fmt.Errorf("failed to get payments: %v", err)
+	}
+	if err == nil {
+		billing.LastPayment = &payment
+	}
+
+	cursor, err := us.collections.Payments().Aggregate(ctx, []bson.M{
+		{"$match": bson.M{"user_id": user.ID, "status": "completed"}},
+		{"$group": bson.M{
+			"_id":   nil,
+			"total": bson.M{"$sum": "$amount"},
+			"count": bson.M{"$sum": 1},
+		}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to total payments: %v", err)
+	}
+	var totals []struct {
+		Total float64 `bson:"total"`
+		Count int64   `bson:"count"`
+	}
+	if err := cursor.All(ctx, &totals); err != nil {
+		return fmt.Errorf("failed to total payments: %v", err)
+	}
+	if len(totals) > 0 {
+		billing.TotalPaid = totals[0].Total
+		billing.CompletedPayments = totals[0].Count
+	}
+
+	switch {
+	case snapshot.Plan != nil && snapshot.Plan.IsFree:
+		billing.Status = "free"
+	case user.PlanExpiresAt != nil && user.PlanExpiresAt.Before(time.Now()):
+		billing.Status = "expired"
+	case subscription["status"] == "cancelled":
+		billing.Status = "cancelled"
+	default:
+		billing.Status = "active"
+	}
+	return nil
+}
+
+// fillSnapshotFlags sets the flags of the snapshot's user
+func (us *UserService) fillSnapshotFlags(ctx context.Context, snapshot *models.UserSnapshot) error {
+	user := snapshot.User
+	flags := &snapshot.Flags
+	now := time.Now()
+
+	// Suspension, deletion and chargebacks aren't part of the user model
+	var state struct {
+		SuspensionReason string     `bson:"suspension_reason"`
+		SuspendedAt      *time.Time `bson:"suspended_at"`
+		DeletedAt        *time.Time `bson:"deleted_at"`
+		ChargebackAt     *time.Time `bson:"chargeback_at"`
+	}
+	err := us.collections.Users().FindOne(ctx, bson.M{"_id": user.ID},
+		options.FindOne().SetProjection(bson.M{"suspension_reason": 1, "suspended_at": 1, "deleted_at": 1, "chargeback_at": 1}),
+	).Decode(&state)
+	if err != nil {
+		return fmt.Errorf("failed to get user state: %v", err)
+	}
+
+	flags.AccountState = user.AccountState()
+	flags.StateReason = user.StateReason
+	flags.Suspended = flags.AccountState == models.AccountStateSuspended
+	flags.SuspensionReason = state.SuspensionReason
+	flags.SuspendedAt = state.SuspendedAt
+	flags.Deleted = state.DeletedAt != nil
+	flags.Chargeback = state.ChargebackAt != nil
+	flags.Unverified = !user.IsVerified
+	flags.Locked = user.LockedUntil != nil && user.LockedUntil.After(now)
+	flags.ScimManaged = user.ProvisionedBy == "scim"
+	flags.Deprovisioned = user.DeprovisionedAt != nil
+	flags.OverStorageLimit = snapshot.Usage.StorageLimit > 0 && user.StorageUsed > snapshot.Usage.StorageLimit
+	flags.OverBandwidthLimit = snapshot.Usage.BandwidthLimit > 0 && user.BandwidthUsed > snapshot.Usage.BandwidthLimit
+
+	if flags.ActiveSessions, err = us.collections.Sessions().CountDocuments(ctx, bson.M{
+		"user_id":    user.ID,
+		"is_active":  true,
+		"expires_at": bson.M{"$gt": now},
+	}); err != nil {
+		return fmt.Errorf("failed to count sessions: %v", err)
+	}
+	if flags.OpenTakedowns, err = us.collections.TakedownCases().CountDocuments(ctx, bson.M{
+		"owner_id": user.ID,
+		"status":   bson.M{"$in": []string{models.TakedownActive, models.TakedownCounterNoticed}},
+	}); err != nil {
+		return fmt.Errorf("failed to count takedowns: %v", err)
+	}
+	if flags.OpenAbuseReports, err = us.collections.AbuseReports().CountDocuments(ctx, bson.M{
+		"owner_id": user.ID,
+		"status":   bson.M{"$in": []string{models.AbuseReportPending, models.AbuseReportReviewing}},
+	}); err != nil {
+		return fmt.Errorf("failed to count abuse reports: %v", err)
+	}
+	if flags.OpenTickets, err = us.collections.SupportTickets().CountDocuments(ctx, bson.M{
+		"user_id": user.ID,
+		"status":  bson.M{"$in": openTicketStatuses},
+	}); err != nil {
+		return fmt.Errorf("failed to count support tickets: %v", err)
+	}
+	if flags.OpenDisputes, err = us.collections.Disputes().CountDocuments(ctx, bson.M{
+		"user_id": user.ID,
+		"status":  models.DisputeOpen,
+	}); err != nil {
+		return fmt.Errorf("failed to count disputes: %v", err)
+	}
+	return nil
+}
+
 // Helper methods
 func (us *UserService) getRecentFiles(ctx context.Context, userID primitive.ObjectID, limit int) ([]models.File, error) {
 	cursor, err := us.collections.Files().Find(ctx,
@@ -0,0 +1,239 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"oncloud/database"
+	"oncloud/models"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// usageCheckBatchSize is how many users are checked against their files at
+// a time
+const usageCheckBatchSize = 500
+
+// transactionSupport records once whether the deployment is a replica set
+// or sharded cluster, which transactions need
+var transactionSupport struct {
+	sync.Once
+	supported bool
+}
+
+// UserUsageService keeps the storage_used and files_count counters on users
+// up to date as files come and go. storage_used covers all of a user's
+// files, in the trash or not, and the older versions kept of them;
+// files_count covers the files not in the trash.
+type UserUsageService struct {
+	*BaseService
+	featureFlags *FeatureFlagService
+}
+
+func NewUserUsageService() *UserUsageService {
+	return NewUserUsageServiceWith(Dependencies{})
+}
+
+// NewUserUsageServiceWith creates an user usage service on the given
+// dependencies
+func NewUserUsageServiceWith(deps Dependencies) *UserUsageService {
+	return &UserUsageService{
+		BaseService:  NewBaseServiceWith(deps),
+		featureFlags: NewFeatureFlagServiceWith(deps),
+	}
+}
+
+// ApplyChange runs change, which returns how much storage and how many files
+// it added to the user (negative when removed), and moves the user's
+// counters by as much in the same transaction. Without transaction support,
+// as on a standalone server or with the usage_transactions flag off for the
+// user, the counters are moved right after change.
+func (uus *UserUsageService) ApplyChange(ctx context.Context, userID primitive.ObjectID, change func(ctx context.Context) (int64, int, error)) error {
+	apply := func(ctx context.Context) error {
+		storage, files, err := change(ctx)
+		if err != nil {
+			return err
+		}
+		if storage == 0 && files == 0 {
+			return nil
+		}
+		if _, err := uus.collections.Users().UpdateOne(ctx,
+			bson.M{"_id": userID},
+			bson.M{"$inc": bson.M{"storage_used": storage, "files_count": files}},
+		); err != nil {
+			return fmt.Errorf("failed to update usage: %v", err)
+		}
+		return nil
+	}
+
+	if !uus.transactionsSupported(ctx) || !uus.featureFlags.IsEnabled(ctx, FeatureUsageTransactions, userID) {
+		return apply(ctx)
+	}
+
+	session, err := uus.collections.Users().Database().Client().StartSession()
+	if err != nil {
+		return fmt.Errorf("failed to start session: %v", err)
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		return nil, apply(sessCtx)
+	})
+	return err
+}
+
+// CheckUsage compares every user's counters with their files and returns
+// the users whose counters drifted, at most limit of them, largest storage
+// drift first. With repair the counters of all drifted users are corrected;
+// a user whose files changed during the check is left for the next one.
+func (uus *UserUsageService) CheckUsage(ctx context.Context, limit int, repair bool) (*models.UsageCheckReport, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+	defer cancel()
+
+	cursor, err := uus.collections.Users().Find(ctx, bson.M{},
+		options.Find().
+			SetProjection(bson.M{"_id": 1, "email": 1, "storage_used": 1, "files_count": 1}).
+			SetBatchSize(usageCheckBatchSize),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	report := &models.UsageCheckReport{Drift: []models.UsageDrift{}, CheckedAt: time.Now()}
+	check := func(users []models.User) error {
+		userIDs := make([]primitive.ObjectID, len(users))
+		for i := range users {
+			userIDs[i] = users[i].ID
+		}
+		usages, err := database.CountUserUsage(ctx, userIDs)
+		if err != nil {
+			return fmt.Errorf("failed to count usage: %v", err)
+		}
+
+		for _, user := range users {
+			report.CheckedUsers++
+			actual := usages[user.ID]
+			if actual.Storage == user.StorageUsed && actual.Files == user.FilesCount {
+				continue
+			}
+			report.DriftedUsers++
+
+			drift := models.UsageDrift{
+				UserID:        user.ID,
+				Email:         user.Email,
+				StorageUsed:   user.StorageUsed,
+				ActualStorage: actual.Storage,
+				FilesCount:    user.FilesCount,
+				ActualFiles:   actual.Files,
+			}
+			if repair {
+				drift.Repaired = uus.repairUsage(ctx, &user, actual)
+				if drift.Repaired {
+					report.RepairedUsers++
+				}
+			}
+			report.Drift = append(report.Drift, drift)
+		}
+		return nil
+	}
+
+	batch := make([]models.User, 0, usageCheckBatchSize)
+	for cursor.Next(ctx) {
+		var user models.User
+		if err := cursor.Decode(&user); err != nil {
+			return nil, fmt.Errorf("failed to read user: %v", err)
+		}
+		batch = append(batch, user)
+		if len(batch) == cap(batch) {
+			if err := check(batch); err != nil {
+				return nil, err
+			}
+			batch = batch[:0]
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list users: %v", err)
+	}
+	if len(batch) > 0 {
+		if err := check(batch); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(report.Drift, func(i, j int) bool {
+		return absInt64(report.Drift[i].StorageDrift()) > absInt64(report.Drift[j].StorageDrift())
+	})
+	if len(report.Drift) > limit {
+		report.Drift = report.Drift[:limit]
+	}
+	return report, nil
+}
+
+// repairUsage sets a user's counters to their actual usage, unless they
+// moved since they were read
+func (uus *UserUsageService) repairUsage(ctx context.Context, user *models.User, actual database.UserUsage) bool {
+	result, err := uus.collections.Users().UpdateOne(ctx,
+		bson.M{"_id": user.ID, "storage_used": user.StorageUsed, "files_count": user.FilesCount},
+		bson.M{"$set": bson.M{"storage_used": actual.Storage, "files_count": actual.Files}},
+	)
+	if err != nil {
+		log.Printf("Failed to repair usage of user %s: %v", user.ID.Hex(), err)
+		return false
+	}
+	return result.ModifiedCount > 0
+}
+
+// versionBytes sums the size of the older versions kept of files
+func (uus *UserUsageService) versionBytes(ctx context.Context, fileIDs []primitive.ObjectID) (int64, error) {
+	if len(fileIDs) == 0 {
+		return 0, nil
+	}
+
+	cursor, err := uus.collections.FileVersions().Aggregate(ctx, []bson.M{
+		{"$match": bson.M{"file_id": bson.M{"$in": fileIDs}}},
+		{"$group": bson.M{"_id": nil, "size": bson.M{"$sum": "$size"}}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	var result []struct {
+		Size int64 `bson:"size"`
+	}
+	if err := cursor.All(ctx, &result); err != nil || len(result) == 0 {
+		return 0, err
+	}
+	return result[0].Size, nil
+}
+
+func (uus *UserUsageService) transactionsSupported(ctx context.Context) bool {
+	transactionSupport.Do(func() {
+		var hello struct {
+			SetName string `bson:"setName"`
+			Msg     string `bson:"msg"`
+		}
+		err := uus.collections.Users().Database().RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+		if err != nil {
+			log.Printf("Failed to check for transaction support: %v", err)
+			return
+		}
+		transactionSupport.supported = hello.SetName != "" || hello.Msg == "isdbgrid"
+		if !transactionSupport.supported {
+			log.Println("Warning: MongoDB is a standalone server, usage counters are updated without transactions")
+		}
+	})
+	return transactionSupport.supported
+}
+
+func absInt64(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
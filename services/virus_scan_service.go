@@ -0,0 +1,285 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"oncloud/models"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// Content is streamed to clamd in chunks of this size
+	clamdChunkSize = 64 * 1024
+
+	// A pending scan younger than this is not started again
+	virusScanRetryAfter = 5 * time.Minute
+)
+
+// VirusScanOptions configures scanning file content with ClamAV
+type VirusScanOptions struct {
+	ClamAVAddress string // host:port of clamd
+	Timeout       time.Duration
+	MaxSize       int64 // larger files are not scanned
+}
+
+var virusScanOptions *VirusScanOptions
+
+var (
+	ErrVirusScanDisabled = errors.New("virus scanning is not enabled")
+	ErrVirusScanRunning  = errors.New("the file is already being scanned")
+)
+
+// FileScanBlockedError is returned when a file may not be downloaded through a
+// share link because it has not been found clean yet
+type FileScanBlockedError struct {
+	Scan *models.FileScan
+}
+
+func (e *FileScanBlockedError) Error() string {
+	if e.Scan.Status == models.ScanStatusInfected {
+		return "file failed its virus scan"
+	}
+	return "file is being scanned for viruses"
+}
+
+// checkFileScan returns a FileScanBlockedError if file may not be shared
+func checkFileScan(file *models.File) error {
+	if file.Scan.Blocked() {
+		return &FileScanBlockedError{Scan: file.Scan}
+	}
+	return nil
+}
+
+// InitVirusScan enables scanning uploads and edited files with clamd
+func InitVirusScan(opts VirusScanOptions) {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 2 * time.Minute
+	}
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = 25 * 1024 * 1024
+	}
+	virusScanOptions = &opts
+}
+
+// VirusScanEnabled reports whether file content is scanned
+func VirusScanEnabled() bool {
+	return virusScanOptions != nil
+}
+
+// newPendingScan returns the scan to store with new content, or nil when
+// scanning is disabled
+func newPendingScan() *models.FileScan {
+	if !VirusScanEnabled() {
+		return nil
+	}
+	return &models.FileScan{Status: models.ScanStatusPending, RequestedAt: time.Now()}
+}
+
+type VirusScanService struct {
+	*BaseService
+	storageService ObjectStore
+}
+
+func NewVirusScanService() *VirusScanService {
+	return NewVirusScanServiceWith(Dependencies{})
+}
+
+// NewVirusScanServiceWith creates a virus scan service on the given
+// dependencies
+func NewVirusScanServiceWith(deps Dependencies) *VirusScanService {
+	return &VirusScanService{
+		BaseService:    NewBaseServiceWith(deps),
+		storageService: deps.storage(),
+	}
+}
+
+// ScanContentAsync scans content just stored for file in the background. The
+// file must already carry a pending scan.
+func (vs *VirusScanService) ScanContentAsync(file *models.File, content []byte) {
+	if !VirusScanEnabled() {
+		return
+	}
+	go vs.scanAndRecord(context.Background(), file, content)
+}
+
+// Rescan scans the stored content of a file again. Without force a file
+// whose scan finished is left alone. A scan started recently is never
+// restarted, so owners cannot flood the scanner.
+func (vs *VirusScanService) Rescan(ctx context.Context, file *models.File, force bool) (*models.FileScan, error) {
+	if !VirusScanEnabled() {
+		return nil, ErrVirusScanDisabled
+	}
+
+	if file.Scan != nil {
+		if file.Scan.Status == models.ScanStatusPending && file.Scan.Error == "" &&
+			time.Since(file.Scan.RequestedAt) < virusScanRetryAfter {
+			return file.Scan, ErrVirusScanRunning
+		}
+		if file.Scan.Status != models.ScanStatusPending && !force {
+			return file.Scan, nil
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	scan := newPendingScan()
+	result, err := vs.collections.Files().UpdateOne(ctx,
+		bson.M{"_id": file.ID, "storage_key": file.StorageKey, "is_deleted": false},
+		bson.M{"$set": bson.M{"scan": scan}},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start scan: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return nil, errors.New("file not found")
+	}
+
+	scanned := *file
+	scanned.Scan = scan
+	go func() {
+		if file.Size > virusScanOptions.MaxSize {
+			vs.scanAndRecord(context.Background(), &scanned, nil)
+			return
+		}
+		content, err := vs.storageService.ReadFileContent(context.Background(), file)
+		if err != nil {
+			vs.recordScan(context.Background(), &scanned, &models.FileScan{
+				Status:      models.ScanStatusPending,
+				Error:       fmt.Sprintf("failed to read file: %v", err),
+				RequestedAt: scan.RequestedAt,
+			})
+			return
+		}
+		vs.scanAndRecord(context.Background(), &scanned, content)
+	}()
+
+	return scan, nil
+}
+
+// scanAndRecord scans content and stores the outcome on file. Failed scans
+// stay pending, so the file remains blocked until it is scanned again.
+func (vs *VirusScanService) scanAndRecord(ctx context.Context, file *models.File, content []byte) {
+	now := time.Now()
+	scan := &models.FileScan{RequestedAt: file.Scan.RequestedAt, ScannedAt: &now}
+
+	if file.Size > virusScanOptions.MaxSize || int64(len(content)) > virusScanOptions.MaxSize {
+		scan.Status = models.ScanStatusSkipped
+		vs.recordScan(ctx, file, scan)
+		return
+	}
+
+	signature, err := clamdScan(content)
+	switch {
+	case err != nil:
+		log.Printf("Failed to scan file %s: %v", file.ID.Hex(), err)
+		scan.Status = models.ScanStatusPending
+		scan.Error = err.Error()
+		scan.ScannedAt = nil
+	case signature != "":
+		log.Printf("File %s is infected with %s", file.ID.Hex(), signature)
+		scan.Status = models.ScanStatusInfected
+		scan.Signature = signature
+	default:
+		scan.Status = models.ScanStatusClean
+	}
+
+	if vs.recordScan(ctx, file, scan) && scan.Status == models.ScanStatusInfected {
+		vs.notifyOwner(ctx, file, signature)
+	}
+}
+
+// recordScan stores scan on file unless its content changed in the meantime,
+// and reports whether it did
+func (vs *VirusScanService) recordScan(ctx context.Context, file *models.File, scan *models.FileScan) bool {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := vs.collections.Files().UpdateOne(ctx,
+		bson.M{"_id": file.ID, "storage_key": file.StorageKey},
+		bson.M{"$set": bson.M{"scan": scan}},
+	)
+	if err != nil {
+		log.Printf("Failed to record scan of file %s: %v", file.ID.Hex(), err)
+		return false
+	}
+	return result.MatchedCount > 0
+}
+
+// notifyOwner tells the owner of an infected file that its share links no
+// longer serve it
+func (vs *VirusScanService) notifyOwner(ctx context.Context, file *models.File, signature string) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	name := fileDisplayName(file)
+	vs.collections.Notifications().InsertOne(ctx, bson.M{
+		"_id":     primitive.NewObjectID(),
+		"user_id": file.UserID,
+		"type":    "virus_found",
+		"title":   "Virus found in " + name,
+		"message": fmt.Sprintf("%s contains %s. Share links to it no longer work.", name, signature),
+		"data": bson.M{
+			"file_id":   file.ID,
+			"signature": signature,
+		},
+		"is_read":    false,
+		"created_at": time.Now(),
+	})
+}
+
+// clamdScan streams content to clamd and returns the name of the virus found,
+// or "" when the content is clean
+func clamdScan(content []byte) (string, error) {
+	conn, err := net.DialTimeout("tcp", virusScanOptions.ClamAVAddress, 10*time.Second)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to clamd: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(virusScanOptions.Timeout))
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return "", fmt.Errorf("failed to send to clamd: %v", err)
+	}
+	size := make([]byte, 4)
+	for start := 0; start < len(content); start += clamdChunkSize {
+		chunk := content[start:min(start+clamdChunkSize, len(content))]
+		binary.BigEndian.PutUint32(size, uint32(len(chunk)))
+		if _, err := conn.Write(size); err != nil {
+			return "", fmt.Errorf("failed to send to clamd: %v", err)
+		}
+		if _, err := conn.Write(chunk); err != nil {
+			return "", fmt.Errorf("failed to send to clamd: %v", err)
+		}
+	}
+	// A zero length chunk ends the stream
+	binary.BigEndian.PutUint32(size, 0)
+	if _, err := conn.Write(size); err != nil {
+		return "", fmt.Errorf("failed to send to clamd: %v", err)
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && reply == "" {
+		return "", fmt.Errorf("failed to read clamd reply: %v", err)
+	}
+	reply = strings.TrimSpace(strings.TrimSuffix(reply, "\x00"))
+	reply = strings.TrimPrefix(reply, "stream: ")
+
+	switch {
+	case reply == "OK":
+		return "", nil
+	case strings.HasSuffix(reply, " FOUND"):
+		return strings.TrimSuffix(reply, " FOUND"), nil
+	default:
+		return "", fmt.Errorf("clamd: %s", reply)
+	}
+}
@@ -0,0 +1,306 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"oncloud/models"
+	"oncloud/warehouse"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// Documents younger than this are not exported yet, so events still held by
+	// the buffered EventWriter cannot be skipped past by the checkpoint
+	warehouseExportSettleLag = 1 * time.Minute
+
+	// Upper bound on batches per source per run so one run cannot hog the job
+	warehouseMaxBatchesPerRun = 50
+)
+
+// exportSource describes a MongoDB collection streamed to the warehouse
+type exportSource struct {
+	name       string
+	collection func() *mongo.Collection
+	timeField  string
+}
+
+// WarehouseExportService streams analytics events and user activities to an
+// external warehouse, checkpointing progress per source collection
+type WarehouseExportService struct {
+	*BaseService
+	sink      warehouse.Sink
+	batchSize int
+	sources   []exportSource
+	running   sync.Mutex
+}
+
+var warehouseExportService *WarehouseExportService
+
+// NewWarehouseExportService creates a new export service writing to sink
+func NewWarehouseExportService(sink warehouse.Sink, batchSize int) *WarehouseExportService {
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+
+	ws := &WarehouseExportService{
+		BaseService: NewBaseService(),
+		sink:        sink,
+		batchSize:   batchSize,
+	}
+	ws.sources = []exportSource{
+		{name: "analytics", collection: ws.collections.Analytics, timeField: "timestamp"},
+		{name: "activities", collection: ws.collections.Activities, timeField: "created_at"},
+	}
+
+	return ws
+}
+
+// InitWarehouseExport registers the process-wide export service
+func InitWarehouseExport(sink warehouse.Sink, batchSize int) *WarehouseExportService {
+	warehouseExportService = NewWarehouseExportService(sink, batchSize)
+	return warehouseExportService
+}
+
+// GetWarehouseExportService returns the process-wide export service, or nil
+// when warehouse export is disabled
+func GetWarehouseExportService() *WarehouseExportService {
+	return warehouseExportService
+}
+
+// RunExport exports pending documents from every source and returns the
+// number of events written per source
+func (ws *WarehouseExportService) RunExport(ctx context.Context) (map[string]int64, error) {
+	if !ws.running.TryLock() {
+		return nil, errors.New("warehouse export is already running")
+	}
+	defer ws.running.Unlock()
+
+	exported := make(map[string]int64)
+	var firstErr error
+	for _, source := range ws.sources {
+		count, err := ws.exportSource(ctx, source)
+		exported[source.name] = count
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("failed to export %s: %v", source.name, err)
+		}
+	}
+
+	return exported, firstErr
+}
+
+// GetExportStatus returns the checkpoint of every source
+func (ws *WarehouseExportService) GetExportStatus(ctx context.Context) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	cursor, err := ws.collections.WarehouseExports().Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var states []models.WarehouseExportState
+	if err = cursor.All(ctx, &states); err != nil {
+		return nil, err
+	}
+
+	healthCtx, healthCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer healthCancel()
+
+	healthy := true
+	healthError := ""
+	if err := ws.sink.HealthCheck(healthCtx); err != nil {
+		healthy = false
+		healthError = err.Error()
+	}
+
+	return map[string]interface{}{
+		"sink":         ws.sink.Name(),
+		"healthy":      healthy,
+		"health_error": healthError,
+		"batch_size":   ws.batchSize,
+		"sources":      states,
+	}, nil
+}
+
+// exportSource streams one collection in _id order from its checkpoint
+func (ws *WarehouseExportService) exportSource(ctx context.Context, source exportSource) (int64, error) {
+	state, err := ws.getExportState(ctx, source.name)
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := primitive.NewObjectIDFromTimestamp(time.Now().Add(-warehouseExportSettleLag))
+	lastID := state.LastExportedID
+
+	var exported int64
+	for i := 0; i < warehouseMaxBatchesPerRun; i++ {
+		docs, err := ws.fetchBatch(ctx, source, lastID, cutoff)
+		if err != nil {
+			ws.saveExportState(ctx, source.name, lastID, nil, exported, err)
+			return exported, err
+		}
+		if len(docs) == 0 {
+			break
+		}
+
+		events := make([]warehouse.Event, 0, len(docs))
+		for _, doc := range docs {
+			events = append(events, toWarehouseEvent(source, doc))
+		}
+
+		writeCtx, cancel := context.WithTimeout(ctx, time.Minute)
+		err = ws.sink.WriteEvents(writeCtx, events)
+		cancel()
+		if err != nil {
+			ws.saveExportState(ctx, source.name, lastID, nil, exported, err)
+			return exported, err
+		}
+
+		lastEvent := events[len(events)-1]
+		lastID, _ = docs[len(docs)-1]["_id"].(primitive.ObjectID)
+		exported += int64(len(events))
+		ws.saveExportState(ctx, source.name, lastID, &lastEvent.Timestamp, int64(len(events)), nil)
+
+		if len(docs) < ws.batchSize {
+			break
+		}
+	}
+
+	return exported, nil
+}
+
+func (ws *WarehouseExportService) fetchBatch(ctx context.Context, source exportSource, after, before primitive.ObjectID) ([]bson.M, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	idFilter := bson.M{"$lt": before}
+	if !after.IsZero() {
+		idFilter["$gt"] = after
+	}
+
+	cursor, err := source.collection().Find(ctx,
+		bson.M{"_id": idFilter},
+		options.Find().SetSort(bson.M{"_id": 1}).SetLimit(int64(ws.batchSize)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var docs []bson.M
+	if err = cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+
+	return docs, nil
+}
+
+func (ws *WarehouseExportService) getExportState(ctx context.Context, source string) (*models.WarehouseExportState, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var state models.WarehouseExportState
+	err := ws.collections.WarehouseExports().FindOne(ctx, bson.M{"source": source}).Decode(&state)
+	if err == mongo.ErrNoDocuments {
+		return &models.WarehouseExportState{Source: source}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load export checkpoint: %v", err)
+	}
+
+	return &state, nil
+}
+
+// saveExportState advances the checkpoint and records the outcome of a batch
+func (ws *WarehouseExportService) saveExportState(ctx context.Context, source string, lastID primitive.ObjectID, lastEventAt *time.Time, exported int64, exportErr error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	set := bson.M{
+		"sink":        ws.sink.Name(),
+		"last_run_at": now,
+		"updated_at":  now,
+	}
+	if exportErr != nil {
+		set["last_error"] = exportErr.Error()
+	} else {
+		set["last_exported_id"] = lastID
+		set["last_success_at"] = now
+		set["last_error"] = ""
+		if lastEventAt != nil {
+			set["last_event_at"] = *lastEventAt
+		}
+	}
+
+	update := bson.M{
+		"$set":         set,
+		"$setOnInsert": bson.M{"_id": primitive.NewObjectID()},
+	}
+	if exportErr == nil {
+		update["$inc"] = bson.M{"exported_count": exported}
+	}
+
+	ws.collections.WarehouseExports().UpdateOne(ctx,
+		bson.M{"source": source},
+		update,
+		options.Update().SetUpsert(true),
+	)
+}
+
+// toWarehouseEvent flattens a MongoDB document into the warehouse row shape
+func toWarehouseEvent(source exportSource, doc bson.M) warehouse.Event {
+	event := warehouse.Event{
+		Source:       source.name,
+		Type:         stringField(doc, "type"),
+		Action:       stringField(doc, "action"),
+		UserID:       stringField(doc, "user_id"),
+		ResourceType: stringField(doc, "resource_type"),
+		ResourceID:   stringField(doc, "resource_id"),
+	}
+	if event.Type == "" {
+		event.Type = source.name
+	}
+
+	if id, ok := doc["_id"].(primitive.ObjectID); ok {
+		event.ID = id.Hex()
+		event.Timestamp = id.Timestamp()
+	}
+	if ts, ok := doc[source.timeField].(primitive.DateTime); ok {
+		event.Timestamp = ts.Time()
+	}
+	event.Timestamp = event.Timestamp.UTC()
+
+	if metadata, ok := doc["metadata"].(bson.M); ok && event.ResourceType == "" {
+		event.ResourceType = stringField(metadata, "resource")
+		event.ResourceID = stringField(metadata, "file_id")
+	}
+
+	if payload, err := bson.MarshalExtJSON(doc, false, false); err == nil {
+		event.Payload = string(payload)
+	}
+
+	return event
+}
+
+// stringField returns a document field as a string, rendering ObjectIDs as hex
+func stringField(doc bson.M, key string) string {
+	switch v := doc[key].(type) {
+	case string:
+		return v
+	case primitive.ObjectID:
+		return v.Hex()
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
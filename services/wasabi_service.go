@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"oncloud/database"
 	"oncloud/models"
 	"oncloud/storage"
 	"strings"
@@ -23,15 +22,21 @@ type WasabiService struct {
 }
 
 func NewWasabiService() *WasabiService {
+	return NewWasabiServiceWith(Dependencies{})
+}
+
+// NewWasabiServiceWith creates a Wasabi service on the given dependencies
+func NewWasabiServiceWith(deps Dependencies) *WasabiService {
+	db := deps.database()
 	return &WasabiService{
-		providerCollection: database.GetCollection("storage_providers"),
-		fileCollection:     database.GetCollection("files"),
+		providerCollection: db.GetCollection("storage_providers"),
+		fileCollection:     db.GetCollection("files"),
 	}
 }
 
 // Initialize Wasabi client with provider configuration
-func (ws *WasabiService) InitializeClient(providerID primitive.ObjectID) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (ws *WasabiService) InitializeClient(ctx context.Context, providerID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	var provider models.StorageProvider
@@ -55,8 +60,8 @@ func (ws *WasabiService) InitializeClient(providerID primitive.ObjectID) error {
 }
 
 // Initialize with default Wasabi provider
-func (ws *WasabiService) InitializeDefaultClient() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (ws *WasabiService) InitializeDefaultClient(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	var provider models.StorageProvider
@@ -80,9 +85,9 @@ func (ws *WasabiService) InitializeDefaultClient() error {
 }
 
 // File Operations
-func (ws *WasabiService) UploadFile(key string, data []byte, options *storage.UploadOptions) error {
+func (ws *WasabiService) UploadFile(ctx context.Context, key string, data []byte, options *storage.UploadOptions) error {
 	if ws.client == nil {
-		if err := ws.InitializeDefaultClient(); err != nil {
+		if err := ws.InitializeDefaultClient(ctx); err != nil {
 			return err
 		}
 	}
@@ -104,9 +109,9 @@ func (ws *WasabiService) UploadFile(key string, data []byte, options *storage.Up
 	return nil
 }
 
-func (ws *WasabiService) UploadStream(key string, reader io.Reader, size int64) error {
+func (ws *WasabiService) UploadStream(ctx context.Context, key string, reader io.Reader, size int64) error {
 	if ws.client == nil {
-		if err := ws.InitializeDefaultClient(); err != nil {
+		if err := ws.InitializeDefaultClient(ctx); err != nil {
 			return err
 		}
 	}
@@ -122,9 +127,9 @@ func (ws *WasabiService) UploadStream(key string, reader io.Reader, size int64)
 	return nil
 }
 
-func (ws *WasabiService) DownloadFile(key string) ([]byte, error) {
+func (ws *WasabiService) DownloadFile(ctx context.Context, key string) ([]byte, error) {
 	if ws.client == nil {
-		if err := ws.InitializeDefaultClient(); err != nil {
+		if err := ws.InitializeDefaultClient(ctx); err != nil {
 			return nil, err
 		}
 	}
@@ -140,9 +145,9 @@ func (ws *WasabiService) DownloadFile(key string) ([]byte, error) {
 	return data, nil
 }
 
-func (ws *WasabiService) DownloadStream(key string) (io.ReadCloser, error) {
+func (ws *WasabiService) DownloadStream(ctx context.Context, key string) (io.ReadCloser, error) {
 	if ws.client == nil {
-		if err := ws.InitializeDefaultClient(); err != nil {
+		if err := ws.InitializeDefaultClient(ctx); err != nil {
 			return nil, err
 		}
 	}
@@ -158,9 +163,9 @@ func (ws *WasabiService) DownloadStream(key string) (io.ReadCloser, error) {
 	return stream, nil
 }
 
-func (ws *WasabiService) DeleteFile(key string) error {
+func (ws *WasabiService) DeleteFile(ctx context.Context, key string) error {
 	if ws.client == nil {
-		if err := ws.InitializeDefaultClient(); err != nil {
+		if err := ws.InitializeDefaultClient(ctx); err != nil {
 			return err
 		}
 	}
@@ -176,9 +181,9 @@ func (ws *WasabiService) DeleteFile(key string) error {
 	return nil
 }
 
-func (ws *WasabiService) DeleteMultipleFiles(keys []string) error {
+func (ws *WasabiService) DeleteMultipleFiles(ctx context.Context, keys []string) error {
 	if ws.client == nil {
-		if err := ws.InitializeDefaultClient(); err != nil {
+		if err := ws.InitializeDefaultClient(ctx); err != nil {
 			return err
 		}
 	}
@@ -196,9 +201,9 @@ func (ws *WasabiService) DeleteMultipleFiles(keys []string) error {
 	return nil
 }
 
-func (ws *WasabiService) FileExists(key string) (bool, error) {
+func (ws *WasabiService) FileExists(ctx context.Context, key string) (bool, error) {
 	if ws.client == nil {
-		if err := ws.InitializeDefaultClient(); err != nil {
+		if err := ws.InitializeDefaultClient(ctx); err != nil {
 			return false, err
 		}
 	}
@@ -211,9 +216,9 @@ func (ws *WasabiService) FileExists(key string) (bool, error) {
 	return exists, nil
 }
 
-func (ws *WasabiService) GetFileSize(key string) (int64, error) {
+func (ws *WasabiService) GetFileSize(ctx context.Context, key string) (int64, error) {
 	if ws.client == nil {
-		if err := ws.InitializeDefaultClient(); err != nil {
+		if err := ws.InitializeDefaultClient(ctx); err != nil {
 			return 0, err
 		}
 	}
@@ -227,9 +232,9 @@ func (ws *WasabiService) GetFileSize(key string) (int64, error) {
 }
 
 // URL Operations
-func (ws *WasabiService) GetPublicURL(key string) (string, error) {
+func (ws *WasabiService) GetPublicURL(ctx context.Context, key string) (string, error) {
 	if ws.client == nil {
-		if err := ws.InitializeDefaultClient(); err != nil {
+		if err := ws.InitializeDefaultClient(ctx); err != nil {
 			return "", err
 		}
 	}
@@ -242,9 +247,9 @@ func (ws *WasabiService) GetPublicURL(key string) (string, error) {
 	return url, nil
 }
 
-func (ws *WasabiService) GetPresignedDownloadURL(key string, expiry time.Duration) (string, error) {
+func (ws *WasabiService) GetPresignedDownloadURL(ctx context.Context, key string, expiry time.Duration) (string, error) {
 	if ws.client == nil {
-		if err := ws.InitializeDefaultClient(); err != nil {
+		if err := ws.InitializeDefaultClient(ctx); err != nil {
 			return "", err
 		}
 	}
@@ -257,9 +262,9 @@ func (ws *WasabiService) GetPresignedDownloadURL(key string, expiry time.Duratio
 	return url, nil
 }
 
-func (ws *WasabiService) GetPresignedUploadURL(key string, expiry time.Duration, maxSize int64) (string, error) {
+func (ws *WasabiService) GetPresignedUploadURL(ctx context.Context, key string, expiry time.Duration, maxSize int64) (string, error) {
 	if ws.client == nil {
-		if err := ws.InitializeDefaultClient(); err != nil {
+		if err := ws.InitializeDefaultClient(ctx); err != nil {
 			return "", err
 		}
 	}
@@ -273,9 +278,9 @@ func (ws *WasabiService) GetPresignedUploadURL(key string, expiry time.Duration,
 }
 
 // Multipart Upload Operations
-func (ws *WasabiService) InitiateMultipartUpload(key string) (*storage.MultipartUpload, error) {
+func (ws *WasabiService) InitiateMultipartUpload(ctx context.Context, key string) (*storage.MultipartUpload, error) {
 	if ws.client == nil {
-		if err := ws.InitializeDefaultClient(); err != nil {
+		if err := ws.InitializeDefaultClient(ctx); err != nil {
 			return nil, err
 		}
 	}
@@ -293,9 +298,9 @@ func (ws *WasabiService) InitiateMultipartUpload(key string) (*storage.Multipart
 	return upload, nil
 }
 
-func (ws *WasabiService) UploadPart(uploadID, key string, partNumber int, data []byte) (*storage.UploadPart, error) {
+func (ws *WasabiService) UploadPart(ctx context.Context, uploadID, key string, partNumber int, data []byte) (*storage.UploadPart, error) {
 	if ws.client == nil {
-		if err := ws.InitializeDefaultClient(); err != nil {
+		if err := ws.InitializeDefaultClient(ctx); err != nil {
 			return nil, err
 		}
 	}
@@ -315,9 +320,9 @@ func (ws *WasabiService) UploadPart(uploadID, key string, partNumber int, data [
 	return part, nil
 }
 
-func (ws *WasabiService) CompleteMultipartUpload(uploadID, key string, parts []storage.UploadPart) error {
+func (ws *WasabiService) CompleteMultipartUpload(ctx context.Context, uploadID, key string, parts []storage.UploadPart) error {
 	if ws.client == nil {
-		if err := ws.InitializeDefaultClient(); err != nil {
+		if err := ws.InitializeDefaultClient(ctx); err != nil {
 			return err
 		}
 	}
@@ -342,9 +347,9 @@ func (ws *WasabiService) CompleteMultipartUpload(uploadID, key string, parts []s
 	return nil
 }
 
-func (ws *WasabiService) AbortMultipartUpload(uploadID, key string) error {
+func (ws *WasabiService) AbortMultipartUpload(ctx context.Context, uploadID, key string) error {
 	if ws.client == nil {
-		if err := ws.InitializeDefaultClient(); err != nil {
+		if err := ws.InitializeDefaultClient(ctx); err != nil {
 			return err
 		}
 	}
@@ -363,9 +368,9 @@ func (ws *WasabiService) AbortMultipartUpload(uploadID, key string) error {
 }
 
 // File Management Operations
-func (ws *WasabiService) CopyFile(sourceKey, destKey string) error {
+func (ws *WasabiService) CopyFile(ctx context.Context, sourceKey, destKey string) error {
 	if ws.client == nil {
-		if err := ws.InitializeDefaultClient(); err != nil {
+		if err := ws.InitializeDefaultClient(ctx); err != nil {
 			return err
 		}
 	}
@@ -383,9 +388,9 @@ func (ws *WasabiService) CopyFile(sourceKey, destKey string) error {
 	return nil
 }
 
-func (ws *WasabiService) MoveFile(sourceKey, destKey string) error {
+func (ws *WasabiService) MoveFile(ctx context.Context, sourceKey, destKey string) error {
 	if ws.client == nil {
-		if err := ws.InitializeDefaultClient(); err != nil {
+		if err := ws.InitializeDefaultClient(ctx); err != nil {
 			return err
 		}
 	}
@@ -404,9 +409,9 @@ func (ws *WasabiService) MoveFile(sourceKey, destKey string) error {
 }
 
 // Provider Management
-func (ws *WasabiService) GetProviderInfo() (*storage.ProviderInfo, error) {
+func (ws *WasabiService) GetProviderInfo(ctx context.Context) (*storage.ProviderInfo, error) {
 	if ws.client == nil {
-		if err := ws.InitializeDefaultClient(); err != nil {
+		if err := ws.InitializeDefaultClient(ctx); err != nil {
 			return nil, err
 		}
 	}
@@ -414,9 +419,9 @@ func (ws *WasabiService) GetProviderInfo() (*storage.ProviderInfo, error) {
 	return ws.client.GetProviderInfo(), nil
 }
 
-func (ws *WasabiService) HealthCheck() error {
+func (ws *WasabiService) HealthCheck(ctx context.Context) error {
 	if ws.client == nil {
-		if err := ws.InitializeDefaultClient(); err != nil {
+		if err := ws.InitializeDefaultClient(ctx); err != nil {
 			return err
 		}
 	}
@@ -429,9 +434,9 @@ func (ws *WasabiService) HealthCheck() error {
 	return nil
 }
 
-func (ws *WasabiService) GetStorageStats() (*storage.StorageStats, error) {
+func (ws *WasabiService) GetStorageStats(ctx context.Context) (*storage.StorageStats, error) {
 	if ws.client == nil {
-		if err := ws.InitializeDefaultClient(); err != nil {
+		if err := ws.InitializeDefaultClient(ctx); err != nil {
 			return nil, err
 		}
 	}
@@ -440,8 +445,8 @@ func (ws *WasabiService) GetStorageStats() (*storage.StorageStats, error) {
 }
 
 // Provider Configuration
-func (ws *WasabiService) CreateProvider(provider *models.StorageProvider) (*models.StorageProvider, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (ws *WasabiService) CreateProvider(ctx context.Context, provider *models.StorageProvider) (*models.StorageProvider, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	// Validate Wasabi provider configuration
@@ -472,8 +477,8 @@ func (ws *WasabiService) CreateProvider(provider *models.StorageProvider) (*mode
 	return provider, nil
 }
 
-func (ws *WasabiService) UpdateProvider(providerID primitive.ObjectID, updates map[string]interface{}) (*models.StorageProvider, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (ws *WasabiService) UpdateProvider(ctx context.Context, providerID primitive.ObjectID, updates map[string]interface{}) (*models.StorageProvider, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	updates["updated_at"] = time.Now()
@@ -495,8 +500,8 @@ func (ws *WasabiService) UpdateProvider(providerID primitive.ObjectID, updates m
 	return &provider, nil
 }
 
-func (ws *WasabiService) DeleteProvider(providerID primitive.ObjectID) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (ws *WasabiService) DeleteProvider(ctx context.Context, providerID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	// Check if provider is in use
@@ -522,8 +527,8 @@ func (ws *WasabiService) DeleteProvider(providerID primitive.ObjectID) error {
 	return nil
 }
 
-func (ws *WasabiService) TestConnection(providerID primitive.ObjectID) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (ws *WasabiService) TestConnection(ctx context.Context, providerID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	var provider models.StorageProvider
@@ -717,7 +722,7 @@ func (ws *WasabiService) logActivity(action, key string, size int64, metadata ma
 			activity["provider_id"] = ws.provider.ID
 		}
 
-		collection := database.GetCollection("storage_activities")
+		collection := ws.providerCollection.Database().Collection("storage_activities")
 		collection.InsertOne(ctx, activity)
 	}()
 }
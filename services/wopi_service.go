@@ -0,0 +1,421 @@
+package services
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"oncloud/models"
+	"oncloud/utils"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// WOPI clients expect locks to last 30 minutes and refresh them before then
+	wopiLockTTL = 30 * time.Minute
+
+	// A session counts as an active editor while it has called back recently
+	wopiActiveWindow = 30 * time.Minute
+
+	wopiDiscoveryCacheTTL = time.Hour
+)
+
+var (
+	ErrOfficeEditorDisabled = errors.New("office document editing is not enabled")
+	ErrWopiReadOnly         = errors.New("editor session is read-only")
+	ErrWopiUnsupportedType  = errors.New("file type cannot be opened in the office editor")
+)
+
+// OfficeEditorOptions configures the OnlyOffice or Collabora Online server
+// documents are opened in
+type OfficeEditorOptions struct {
+	// EditorURL is the editor server, whose /hosting/discovery lists the
+	// editor URL for each file extension
+	EditorURL string
+	// WOPIBaseURL is where the editor server reaches this API
+	WOPIBaseURL string
+	// TokenTTL is how long an editor session's access token is valid
+	TokenTTL time.Duration
+}
+
+var officeEditorOptions *OfficeEditorOptions
+
+var wopiDiscoveryCache = struct {
+	actions  map[string]map[string]string // extension -> action -> urlsrc
+	loadedAt time.Time
+	mutex    sync.Mutex
+}{}
+
+var (
+	wopiDiscoveryClient     = &http.Client{Timeout: 10 * time.Second}
+	wopiURLPlaceholderRegex = regexp.MustCompile(`<[^>]*>`)
+)
+
+// InitOfficeEditor enables office document editing through the given editor
+// server
+func InitOfficeEditor(opts OfficeEditorOptions) {
+	if opts.TokenTTL <= 0 {
+		opts.TokenTTL = 10 * time.Hour
+	}
+	opts.EditorURL = strings.TrimRight(opts.EditorURL, "/")
+	opts.WOPIBaseURL = strings.TrimRight(opts.WOPIBaseURL, "/")
+	officeEditorOptions = &opts
+}
+
+type WopiService struct {
+	*BaseService
+	fileService     *FileService
+	fileLockService *FileLockService
+	storageService  ObjectStore
+}
+
+func NewWopiService() *WopiService {
+	return NewWopiServiceWith(Dependencies{})
+}
+
+// NewWopiServiceWith creates a WOPI service on the given dependencies
+func NewWopiServiceWith(deps Dependencies) *WopiService {
+	return &WopiService{
+		BaseService:     NewBaseServiceWith(deps),
+		fileService:     NewFileServiceWith(deps),
+		fileLockService: NewFileLockServiceWith(deps),
+		storageService:  deps.storage(),
+	}
+}
+
+// OpenSession starts an editor session on one of the user's files. Edit
+// sessions are downgraded to view when someone else holds the file's lock.
+func (ws *WopiService) OpenSession(ctx context.Context, userID, fileID primitive.ObjectID, mode, clientIP, userAgent string) (*models.WopiEditorLaunch, error) {
+	if officeEditorOptions == nil {
+		return nil, ErrOfficeEditorDisabled
+	}
+
+	file, err := ws.fileService.GetUserFile(ctx, userID, fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	if mode != "view" {
+		mode = "edit"
+		if checkFileLock(file, userID) != nil {
+			mode = "view"
+		}
+	}
+
+	actionURL, err := ws.editorActionURL(file.Extension, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := utils.GenerateSecureToken(32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate access token: %v", err)
+	}
+
+	now := time.Now()
+	session := &models.WopiSession{
+		ID:           primitive.NewObjectID(),
+		FileID:       fileID,
+		UserID:       userID,
+		TokenHash:    utils.HashSHA256(token),
+		Mode:         mode,
+		IPAddress:    clientIP,
+		UserAgent:    userAgent,
+		LastActiveAt: now,
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(officeEditorOptions.TokenTTL),
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if _, err := ws.collections.WopiSessions().InsertOne(ctx, session); err != nil {
+		return nil, fmt.Errorf("failed to create editor session: %v", err)
+	}
+
+	wopiSrc := officeEditorOptions.WOPIBaseURL + "/wopi/files/" + fileID.Hex()
+	separator := "&"
+	if !strings.Contains(actionURL, "?") {
+		separator = "?"
+	} else if strings.HasSuffix(actionURL, "?") || strings.HasSuffix(actionURL, "&") {
+		separator = ""
+	}
+
+	return &models.WopiEditorLaunch{
+		Session:        session,
+		EditorURL:      actionURL + separator + "WOPISrc=" + url.QueryEscape(wopiSrc),
+		AccessToken:    token,
+		AccessTokenTTL: session.ExpiresAt.UnixMilli(),
+	}, nil
+}
+
+// Authenticate resolves a WOPI access token to its open session and file
+func (ws *WopiService) Authenticate(ctx context.Context, fileID primitive.ObjectID, token string) (*models.WopiSession, *models.File, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	var session models.WopiSession
+	err := ws.collections.WopiSessions().FindOneAndUpdate(ctx,
+		bson.M{
+			"token_hash": utils.HashSHA256(token),
+			"file_id":    fileID,
+			"ended_at":   bson.M{"$exists": false},
+			"expires_at": bson.M{"$gt": now},
+		},
+		bson.M{"$set": bson.M{"last_active_at": now}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&session)
+	if err != nil {
+		return nil, nil, errors.New("invalid or expired access token")
+	}
+
+	var file models.File
+	err = ws.collections.Files().FindOne(ctx, bson.M{
+		"_id":        fileID,
+		"is_deleted": false,
+	}).Decode(&file)
+	if err != nil {
+		return nil, nil, fmt.Errorf("file not found: %v", err)
+	}
+
+	return &session, &file, nil
+}
+
+// CheckFileInfo describes the file and the session's permissions to the editor
+func (ws *WopiService) CheckFileInfo(ctx context.Context, session *models.WopiSession, file *models.File) (*models.WopiCheckFileInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var user models.User
+	if err := ws.collections.Users().FindOne(ctx, bson.M{"_id": session.UserID}).Decode(&user); err != nil {
+		return nil, fmt.Errorf("user not found: %v", err)
+	}
+
+	name := strings.TrimSpace(user.FirstName + " " + user.LastName)
+	if name == "" {
+		name = user.Username
+	}
+
+	canWrite := session.Mode == "edit"
+	return &models.WopiCheckFileInfo{
+		BaseFileName:               file.Name,
+		OwnerId:                    file.UserID.Hex(),
+		Size:                       file.Size,
+		UserId:                     session.UserID.Hex(),
+		UserFriendlyName:           name,
+		Version:                    WopiFileVersion(file),
+		LastModifiedTime:           file.UpdatedAt.UTC().Format(time.RFC3339),
+		ReadOnly:                   !canWrite,
+		UserCanWrite:               canWrite,
+		UserCanNotWriteRelative:    true,
+		SupportsUpdate:             true,
+		SupportsLocks:              true,
+		SupportsGetLock:            true,
+		SupportsExtendedLockLength: true,
+	}, nil
+}
+
+// GetFileContent returns the file's current content
+func (ws *WopiService) GetFileContent(ctx context.Context, file *models.File) ([]byte, error) {
+	return ws.storageService.ReadFileContent(ctx, file)
+}
+
+// PutFileContent saves content from the editor as a new version of the
+// file. lockToken must match the file's lock, if any.
+func (ws *WopiService) PutFileContent(ctx context.Context, session *models.WopiSession, file *models.File, lockToken string, content []byte) (*models.File, error) {
+	if session.Mode != "edit" {
+		return nil, ErrWopiReadOnly
+	}
+
+	lock := liveLock(file)
+	if lock != nil && lock.Token != lockToken {
+		return nil, &FileLockedError{Lock: lock}
+	}
+	// Only a new, empty file may be written without taking a lock first
+	if lock == nil && file.Size > 0 {
+		return nil, ErrFileNotLocked
+	}
+
+	updated, _, err := ws.fileService.SaveFileContent(ctx, file, content)
+	return updated, err
+}
+
+// Lock takes or refreshes the editor's lock on the file. With oldToken set the
+// existing lock is swapped for token (WOPI UnlockAndRelock).
+func (ws *WopiService) Lock(ctx context.Context, session *models.WopiSession, file *models.File, token, oldToken string) error {
+	if session.Mode != "edit" {
+		return ErrWopiReadOnly
+	}
+	_, err := ws.fileLockService.LockWithToken(ctx, file, session.UserID, token, oldToken, wopiLockTTL)
+	return err
+}
+
+// RefreshLock extends the editor's lock, which must already be held
+func (ws *WopiService) RefreshLock(ctx context.Context, session *models.WopiSession, file *models.File, token string) error {
+	return ws.Lock(ctx, session, file, token, token)
+}
+
+// Unlock releases the editor's lock
+func (ws *WopiService) Unlock(ctx context.Context, session *models.WopiSession, file *models.File, token string) error {
+	if session.Mode != "edit" {
+		return ErrWopiReadOnly
+	}
+	return ws.fileLockService.UnlockWithToken(ctx, file.ID, token)
+}
+
+// GetLock returns the token of the file's live lock, or "" if it is unlocked
+func (ws *WopiService) GetLock(file *models.File) string {
+	if lock := liveLock(file); lock != nil {
+		return lock.Token
+	}
+	return ""
+}
+
+// GetActiveSessions lists editor sessions currently open on a user's file
+func (ws *WopiService) GetActiveSessions(ctx context.Context, userID, fileID primitive.ObjectID) ([]models.WopiSession, error) {
+	if _, err := ws.fileService.GetUserFile(ctx, userID, fileID); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	cursor, err := ws.collections.WopiSessions().Find(ctx,
+		bson.M{
+			"file_id":        fileID,
+			"ended_at":       bson.M{"$exists": false},
+			"expires_at":     bson.M{"$gt": now},
+			"last_active_at": bson.M{"$gt": now.Add(-wopiActiveWindow)},
+		},
+		options.Find().SetSort(bson.M{"last_active_at": -1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	sessions := []models.WopiSession{}
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// EndSession closes one of the user's editor sessions and revokes its token
+func (ws *WopiService) EndSession(ctx context.Context, userID, sessionID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := ws.collections.WopiSessions().UpdateOne(ctx,
+		bson.M{"_id": sessionID, "user_id": userID, "ended_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"ended_at": time.Now()}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to end editor session: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("editor session not found")
+	}
+
+	return nil
+}
+
+// WopiFileVersion identifies the file's current content for the editor
+func WopiFileVersion(file *models.File) string {
+	return strconv.FormatInt(file.UpdatedAt.UnixNano(), 10)
+}
+
+// editorActionURL returns the editor URL for a file extension. View sessions
+// fall back to the edit action, which the editor opens read-only.
+func (ws *WopiService) editorActionURL(extension, mode string) (string, error) {
+	actions, err := ws.discover()
+	if err != nil {
+		return "", err
+	}
+
+	byAction := actions[strings.ToLower(strings.TrimPrefix(extension, "."))]
+	candidates := []string{"edit"}
+	if mode == "view" {
+		candidates = []string{"view", "edit"}
+	}
+	for _, name := range candidates {
+		if urlsrc, ok := byAction[name]; ok {
+			return wopiURLPlaceholderRegex.ReplaceAllString(urlsrc, ""), nil
+		}
+	}
+
+	return "", ErrWopiUnsupportedType
+}
+
+type wopiDiscovery struct {
+	NetZones []struct {
+		Apps []struct {
+			Actions []struct {
+				Name   string `xml:"name,attr"`
+				Ext    string `xml:"ext,attr"`
+				URLSrc string `xml:"urlsrc,attr"`
+			} `xml:"action"`
+		} `xml:"app"`
+	} `xml:"net-zone"`
+}
+
+// discover loads the editor server's WOPI discovery document, cached for an hour
+func (ws *WopiService) discover() (map[string]map[string]string, error) {
+	wopiDiscoveryCache.mutex.Lock()
+	defer wopiDiscoveryCache.mutex.Unlock()
+
+	if wopiDiscoveryCache.actions != nil && time.Since(wopiDiscoveryCache.loadedAt) < wopiDiscoveryCacheTTL {
+		return wopiDiscoveryCache.actions, nil
+	}
+
+	resp, err := wopiDiscoveryClient.Get(officeEditorOptions.EditorURL + "/hosting/discovery")
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach office editor: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("office editor discovery failed: status %d", resp.StatusCode)
+	}
+
+	var discovery wopiDiscovery
+	if err := xml.NewDecoder(resp.Body).Decode(&discovery); err != nil {
+		return nil, fmt.Errorf("invalid office editor discovery: %v", err)
+	}
+
+	actions := make(map[string]map[string]string)
+	for _, zone := range discovery.NetZones {
+		for _, app := range zone.Apps {
+			for _, action := range app.Actions {
+				if action.Ext == "" || action.URLSrc == "" {
+					continue
+				}
+				ext := strings.ToLower(action.Ext)
+				if actions[ext] == nil {
+					actions[ext] = make(map[string]string)
+				}
+				if _, exists := actions[ext][action.Name]; !exists {
+					actions[ext][action.Name] = action.URLSrc
+				}
+			}
+		}
+	}
+
+	wopiDiscoveryCache.actions = actions
+	wopiDiscoveryCache.loadedAt = time.Now()
+
+	return actions, nil
+}
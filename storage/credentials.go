@@ -0,0 +1,22 @@
+package storage
+
+import (
+	"fmt"
+	"oncloud/models"
+	"oncloud/secrets"
+)
+
+// providerCredentials returns a provider's access and secret keys. Keys
+// stored as secret references are read from the secrets backend, whose cache
+// picks up rotated keys for the clients created after the rotation.
+func providerCredentials(provider *models.StorageProvider) (string, string, error) {
+	accessKey, err := secrets.ResolveValue(provider.AccessKey)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to resolve access key: %v", err)
+	}
+	secretKey, err := secrets.ResolveValue(provider.SecretKey)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to resolve secret key: %v", err)
+	}
+	return accessKey, secretKey, nil
+}
@@ -0,0 +1,292 @@
+package storage
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// maxTrackedRemovals caps how many removed keys a disk cache remembers to
+// reject stale puts; past it, every put started before is rejected
+const maxTrackedRemovals = 10000
+
+// DiskCache keeps recently read objects on local disk, evicting the least
+// recently used ones once it grows past its size limit. Objects cached by a
+// previous run are picked up again on start.
+type DiskCache struct {
+	dir           string
+	maxSize       int64
+	maxObjectSize int64
+
+	mutex   sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // most recently used first
+	size    int64
+
+	// Removals are numbered so a put of content read before the object was
+	// overwritten or deleted is rejected
+	generation uint64
+	removed    map[string]uint64
+	floor      uint64
+
+	hits       int64
+	misses     int64
+	stores     int64
+	evictions  int64
+	bytesSaved int64
+}
+
+type diskCacheEntry struct {
+	name string
+	size int64
+}
+
+// DiskCacheStats are the counters of a disk cache since the process started
+type DiskCacheStats struct {
+	Entries       int     `json:"entries"`
+	Size          int64   `json:"size"`
+	MaxSize       int64   `json:"max_size"`
+	MaxObjectSize int64   `json:"max_object_size"`
+	Hits          int64   `json:"hits"`
+	Misses        int64   `json:"misses"`
+	HitRate       float64 `json:"hit_rate"`
+	Stores        int64   `json:"stores"`
+	Evictions     int64   `json:"evictions"`
+	BytesSaved    int64   `json:"bytes_saved"` // served from disk instead of the provider
+}
+
+// NewDiskCache opens a disk cache in dir holding up to maxSize bytes of
+// objects no bigger than maxObjectSize
+func NewDiskCache(dir string, maxSize, maxObjectSize int64) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %v", err)
+	}
+
+	c := &DiskCache{
+		dir:           dir,
+		maxSize:       maxSize,
+		maxObjectSize: maxObjectSize,
+		entries:       make(map[string]*list.Element),
+		lru:           list.New(),
+		removed:       make(map[string]uint64),
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// load indexes the objects left by a previous run, the most recently
+// modified as the most recently used
+func (c *DiskCache) load() error {
+	dirEntries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read cache directory: %v", err)
+	}
+
+	type cachedFile struct {
+		name    string
+		size    int64
+		modTime int64
+	}
+	var files []cachedFile
+	for _, dirEntry := range dirEntries {
+		if dirEntry.IsDir() {
+			continue
+		}
+		// Leftovers of writes interrupted by a restart
+		if strings.HasSuffix(dirEntry.Name(), ".tmp") {
+			os.Remove(filepath.Join(c.dir, dirEntry.Name()))
+			continue
+		}
+		info, err := dirEntry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, cachedFile{name: dirEntry.Name(), size: info.Size(), modTime: info.ModTime().UnixNano()})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime > files[j].modTime })
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, file := range files {
+		c.entries[file.name] = c.lru.PushBack(&diskCacheEntry{name: file.name, size: file.size})
+		c.size += file.size
+	}
+	c.evict()
+	return nil
+}
+
+// Get returns a cached object
+func (c *DiskCache) Get(key string) ([]byte, bool) {
+	name := c.fileName(key)
+
+	c.mutex.Lock()
+	element, exists := c.entries[name]
+	if !exists {
+		c.misses++
+		c.mutex.Unlock()
+		return nil, false
+	}
+	c.lru.MoveToFront(element)
+	c.mutex.Unlock()
+
+	data, err := os.ReadFile(filepath.Join(c.dir, name))
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err != nil {
+		// Evicted while being read, or removed from disk behind our back
+		if element, exists := c.entries[name]; exists {
+			c.remove(element)
+		}
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.bytesSaved += int64(len(data))
+	return data, true
+}
+
+// Generation returns the current generation of the cache, taken before
+// reading an object from the provider and passed to Put
+func (c *DiskCache) Generation() uint64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.generation
+}
+
+// Put caches an object read at the given generation, unless it is too big
+// to be cached or was removed since
+func (c *DiskCache) Put(key string, data []byte, generation uint64) error {
+	size := int64(len(data))
+	if size > c.maxObjectSize || size > c.maxSize {
+		return nil
+	}
+
+	// Written aside and renamed, so reads never see a partial object
+	name := c.fileName(key)
+	path := filepath.Join(c.dir, name)
+	tmp, err := os.CreateTemp(c.dir, name+"-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create cache file: %v", err)
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write cache file: %v", err)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if generation < c.floor || c.removed[name] > generation {
+		os.Remove(tmp.Name())
+		return nil
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write cache file: %v", err)
+	}
+	if element, exists := c.entries[name]; exists {
+		c.size -= element.Value.(*diskCacheEntry).size
+		element.Value.(*diskCacheEntry).size = size
+		c.lru.MoveToFront(element)
+	} else {
+		c.entries[name] = c.lru.PushFront(&diskCacheEntry{name: name, size: size})
+	}
+	c.size += size
+	c.stores++
+	c.evict()
+	return nil
+}
+
+// Remove drops a cached object, such as one overwritten or deleted at the
+// provider
+func (c *DiskCache) Remove(key string) {
+	name := c.fileName(key)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.generation++
+	if len(c.removed) >= maxTrackedRemovals {
+		c.removed = make(map[string]uint64)
+		c.floor = c.generation
+	}
+	c.removed[name] = c.generation
+	if element, exists := c.entries[name]; exists {
+		c.remove(element)
+	}
+}
+
+// Purge drops every cached object and returns how many there were
+func (c *DiskCache) Purge() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.generation++
+	c.removed = make(map[string]uint64)
+	c.floor = c.generation
+
+	purged := len(c.entries)
+	for c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+	}
+	return purged
+}
+
+// Stats returns the cache's counters
+func (c *DiskCache) Stats() *DiskCacheStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	stats := &DiskCacheStats{
+		Entries:       len(c.entries),
+		Size:          c.size,
+		MaxSize:       c.maxSize,
+		MaxObjectSize: c.maxObjectSize,
+		Hits:          c.hits,
+		Misses:        c.misses,
+		Stores:        c.stores,
+		Evictions:     c.evictions,
+		BytesSaved:    c.bytesSaved,
+	}
+	if lookups := c.hits + c.misses; lookups > 0 {
+		stats.HitRate = float64(c.hits) / float64(lookups)
+	}
+	return stats
+}
+
+// evict drops the least recently used objects until the cache fits its
+// size limit. The caller holds the mutex.
+func (c *DiskCache) evict() {
+	for c.size > c.maxSize && c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+		c.evictions++
+	}
+}
+
+// remove drops a cached object. The caller holds the mutex.
+func (c *DiskCache) remove(element *list.Element) {
+	entry := element.Value.(*diskCacheEntry)
+	c.lru.Remove(element)
+	delete(c.entries, entry.name)
+	c.size -= entry.size
+	os.Remove(filepath.Join(c.dir, entry.name))
+}
+
+// fileName is the name an object is cached under; keys may hold slashes
+// and characters filesystems reject
+func (c *DiskCache) fileName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
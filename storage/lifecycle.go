@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"oncloud/models"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// LifecycleManager is implemented by clients whose buckets have native object
+// lifecycle rules
+type LifecycleManager interface {
+	GetLifecycleRules() ([]models.LifecycleRule, error)
+	PutLifecycleRules(rules []models.LifecycleRule) error
+}
+
+// GetLifecycleRules returns the lifecycle rules of the S3 bucket
+func (s *S3Client) GetLifecycleRules() ([]models.LifecycleRule, error) {
+	return getBucketLifecycle(s.client, s.bucket, "s3")
+}
+
+// PutLifecycleRules replaces the lifecycle rules of the S3 bucket
+func (s *S3Client) PutLifecycleRules(rules []models.LifecycleRule) error {
+	return putBucketLifecycle(s.client, s.bucket, "s3", rules)
+}
+
+// GetLifecycleRules returns the object lifecycle rules of the R2 bucket
+func (r *R2Client) GetLifecycleRules() ([]models.LifecycleRule, error) {
+	return getBucketLifecycle(r.client, r.bucket, "r2")
+}
+
+// PutLifecycleRules replaces the object lifecycle rules of the R2 bucket
+func (r *R2Client) PutLifecycleRules(rules []models.LifecycleRule) error {
+	return putBucketLifecycle(r.client, r.bucket, "r2", rules)
+}
+
+func getBucketLifecycle(client *s3.S3, bucket, provider string) ([]models.LifecycleRule, error) {
+	output, err := client.GetBucketLifecycleConfiguration(&s3.GetBucketLifecycleConfigurationInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NoSuchLifecycleConfiguration" {
+			return []models.LifecycleRule{}, nil
+		}
+		return nil, NewStorageError(provider, "LIFECYCLE_GET_FAILED", err.Error(), "")
+	}
+
+	rules := make([]models.LifecycleRule, 0, len(output.Rules))
+	for _, rule := range output.Rules {
+		converted := models.LifecycleRule{
+			ID:      aws.StringValue(rule.ID),
+			Prefix:  aws.StringValue(rule.Prefix),
+			Enabled: aws.StringValue(rule.Status) == s3.ExpirationStatusEnabled,
+		}
+		if rule.Filter != nil {
+			if rule.Filter.Prefix != nil {
+				converted.Prefix = aws.StringValue(rule.Filter.Prefix)
+			} else if rule.Filter.And != nil {
+				converted.Prefix = aws.StringValue(rule.Filter.And.Prefix)
+			}
+		}
+		if rule.AbortIncompleteMultipartUpload != nil {
+			converted.AbortMultipartAfterDays = int(aws.Int64Value(rule.AbortIncompleteMultipartUpload.DaysAfterInitiation))
+		}
+		if rule.Expiration != nil {
+			converted.ExpireAfterDays = int(aws.Int64Value(rule.Expiration.Days))
+		}
+		for _, transition := range rule.Transitions {
+			converted.Transitions = append(converted.Transitions, models.LifecycleTransition{
+				Days:         int(aws.Int64Value(transition.Days)),
+				StorageClass: aws.StringValue(transition.StorageClass),
+			})
+		}
+		rules = append(rules, converted)
+	}
+	return rules, nil
+}
+
+func putBucketLifecycle(client *s3.S3, bucket, provider string, rules []models.LifecycleRule) error {
+	// Buckets cannot be given an empty configuration, only have it removed
+	if len(rules) == 0 {
+		if _, err := client.DeleteBucketLifecycle(&s3.DeleteBucketLifecycleInput{
+			Bucket: aws.String(bucket),
+		}); err != nil {
+			return NewStorageError(provider, "LIFECYCLE_PUT_FAILED", err.Error(), "")
+		}
+		return nil
+	}
+
+	converted := make([]*s3.LifecycleRule, 0, len(rules))
+	for _, rule := range rules {
+		status := s3.ExpirationStatusDisabled
+		if rule.Enabled {
+			status = s3.ExpirationStatusEnabled
+		}
+		s3Rule := &s3.LifecycleRule{
+			ID:     aws.String(rule.ID),
+			Status: aws.String(status),
+			Filter: &s3.LifecycleRuleFilter{Prefix: aws.String(rule.Prefix)},
+		}
+		if rule.AbortMultipartAfterDays > 0 {
+			s3Rule.AbortIncompleteMultipartUpload = &s3.AbortIncompleteMultipartUpload{
+				DaysAfterInitiation: aws.Int64(int64(rule.AbortMultipartAfterDays)),
+			}
+		}
+		if rule.ExpireAfterDays > 0 {
+			s3Rule.Expiration = &s3.LifecycleExpiration{Days: aws.Int64(int64(rule.ExpireAfterDays))}
+		}
+		for _, transition := range rule.Transitions {
+			s3Rule.Transitions = append(s3Rule.Transitions, &s3.Transition{
+				Days:         aws.Int64(int64(transition.Days)),
+				StorageClass: aws.String(transition.StorageClass),
+			})
+		}
+		converted = append(converted, s3Rule)
+	}
+
+	if _, err := client.PutBucketLifecycleConfiguration(&s3.PutBucketLifecycleConfigurationInput{
+		Bucket:                 aws.String(bucket),
+		LifecycleConfiguration: &s3.BucketLifecycleConfiguration{Rules: converted},
+	}); err != nil {
+		return NewStorageError(provider, "LIFECYCLE_PUT_FAILED", err.Error(), "")
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// MultipartLister is implemented by clients which can list the multipart
+// uploads in progress in their bucket
+type MultipartLister interface {
+	ListMultipartUploads() ([]MultipartUploadInfo, error)
+}
+
+// MultipartUploadInfo describes a multipart upload in progress. Size is the
+// space taken by the parts uploaded so far.
+type MultipartUploadInfo struct {
+	Key       string    `json:"key"`
+	UploadID  string    `json:"upload_id"`
+	Initiated time.Time `json:"initiated"`
+	Size      int64     `json:"size"`
+}
+
+// ListMultipartUploads lists the multipart uploads in progress in the S3 bucket
+func (s *S3Client) ListMultipartUploads() ([]MultipartUploadInfo, error) {
+	return listBucketMultipartUploads(s.client, s.bucket, "s3")
+}
+
+// ListMultipartUploads lists the multipart uploads in progress in the R2 bucket
+func (r *R2Client) ListMultipartUploads() ([]MultipartUploadInfo, error) {
+	return listBucketMultipartUploads(r.client, r.bucket, "r2")
+}
+
+func listBucketMultipartUploads(client *s3.S3, bucket, provider string) ([]MultipartUploadInfo, error) {
+	uploads := []MultipartUploadInfo{}
+	err := client.ListMultipartUploadsPages(&s3.ListMultipartUploadsInput{
+		Bucket: aws.String(bucket),
+	}, func(page *s3.ListMultipartUploadsOutput, lastPage bool) bool {
+		for _, upload := range page.Uploads {
+			uploads = append(uploads, MultipartUploadInfo{
+				Key:       aws.StringValue(upload.Key),
+				UploadID:  aws.StringValue(upload.UploadId),
+				Initiated: aws.TimeValue(upload.Initiated),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, NewStorageError(provider, "MULTIPART_LIST_FAILED", err.Error(), "")
+	}
+
+	for i := range uploads {
+		// The size only informs reports, so uploads whose parts cannot be
+		// listed are still returned
+		client.ListPartsPages(&s3.ListPartsInput{
+			Bucket:   aws.String(bucket),
+			Key:      aws.String(uploads[i].Key),
+			UploadId: aws.String(uploads[i].UploadID),
+		}, func(page *s3.ListPartsOutput, lastPage bool) bool {
+			for _, part := range page.Parts {
+				uploads[i].Size += aws.Int64Value(part.Size)
+			}
+			return true
+		})
+	}
+	return uploads, nil
+}
@@ -0,0 +1,207 @@
+package storage
+
+import (
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// ObjectBrowser is implemented by clients whose objects can be browsed like
+// folders, a page at a time
+type ObjectBrowser interface {
+	// BrowseObjects lists the objects directly under prefix, and the prefixes
+	// of the "folders" under it, up to "/". Cursor is the NextCursor of the
+	// page before.
+	BrowseObjects(prefix, cursor string, limit int) (*ObjectPage, error)
+	// HeadObject describes an object without reading it
+	HeadObject(key string) (*ObjectHead, error)
+}
+
+// ObjectPage is a page of objects and folder prefixes. NextCursor is empty
+// on the last page.
+type ObjectPage struct {
+	Objects    []ObjectInfo `json:"objects"`
+	Prefixes   []string     `json:"prefixes"`
+	NextCursor string       `json:"next_cursor,omitempty"`
+}
+
+// ObjectHead describes a stored object
+type ObjectHead struct {
+	Key          string            `json:"key"`
+	Size         int64             `json:"size"`
+	LastModified time.Time         `json:"last_modified"`
+	ContentType  string            `json:"content_type,omitempty"`
+	ETag         string            `json:"etag,omitempty"`
+	StorageClass string            `json:"storage_class,omitempty"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+}
+
+// BrowseObjects lists the objects and folders under a prefix of the S3 bucket
+func (s *S3Client) BrowseObjects(prefix, cursor string, limit int) (*ObjectPage, error) {
+	return browseBucketObjects(s.client, s.bucket, "s3", prefix, cursor, limit)
+}
+
+// HeadObject describes an object of the S3 bucket
+func (s *S3Client) HeadObject(key string) (*ObjectHead, error) {
+	return headBucketObject(s.client, s.bucket, "s3", key)
+}
+
+// BrowseObjects lists the objects and folders under a prefix of the R2 bucket
+func (r *R2Client) BrowseObjects(prefix, cursor string, limit int) (*ObjectPage, error) {
+	return browseBucketObjects(r.client, r.bucket, "r2", prefix, cursor, limit)
+}
+
+// HeadObject describes an object of the R2 bucket
+func (r *R2Client) HeadObject(key string) (*ObjectHead, error) {
+	return headBucketObject(r.client, r.bucket, "r2", key)
+}
+
+// BrowseObjects lists the files and directories under a prefix of the base
+// path. Directory entries are sorted by name, so the cursor is the last name
+// of the page before.
+func (lc *LocalClient) BrowseObjects(prefix, cursor string, limit int) (*ObjectPage, error) {
+	dir, namePrefix := path.Split(prefix)
+	dirPath, err := lc.objectPath(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &ObjectPage{Objects: []ObjectInfo{}, Prefixes: []string{}}, nil
+		}
+		return nil, NewStorageError("local", "LIST_FAILED", err.Error(), prefix)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	page := &ObjectPage{Objects: []ObjectInfo{}, Prefixes: []string{}}
+	last := ""
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, namePrefix) || name <= cursor {
+			continue
+		}
+		// Parts of multipart uploads in progress are not objects
+		if entry.IsDir() && name == ".tmp" {
+			continue
+		}
+		if len(page.Objects)+len(page.Prefixes) == limit {
+			page.NextCursor = last
+			break
+		}
+
+		if entry.IsDir() {
+			page.Prefixes = append(page.Prefixes, dir+name+"/")
+			last = name
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		page.Objects = append(page.Objects, ObjectInfo{Key: dir + name, Size: info.Size(), LastModified: info.ModTime()})
+		last = name
+	}
+	return page, nil
+}
+
+// HeadObject describes a file under the base path
+func (lc *LocalClient) HeadObject(key string) (*ObjectHead, error) {
+	fullPath, err := lc.objectPath(key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, NewStorageError("local", "NOT_FOUND", err.Error(), key)
+		}
+		return nil, NewStorageError("local", "HEAD_FAILED", err.Error(), key)
+	}
+	if info.IsDir() {
+		return nil, NewStorageError("local", "NOT_FOUND", "key is a directory", key)
+	}
+
+	return &ObjectHead{
+		Key:          key,
+		Size:         info.Size(),
+		LastModified: info.ModTime(),
+		ContentType:  mime.TypeByExtension(filepath.Ext(key)),
+	}, nil
+}
+
+// objectPath is the path of a key under the base path. Keys can't reach
+// outside of it.
+func (lc *LocalClient) objectPath(key string) (string, error) {
+	fullPath := filepath.Join(lc.basePath, filepath.FromSlash(key))
+	rel, err := filepath.Rel(lc.basePath, fullPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", NewStorageError("local", "INVALID_KEY", "key is outside of the base path", key)
+	}
+	return fullPath, nil
+}
+
+func browseBucketObjects(client *s3.S3, bucket, provider, prefix, cursor string, limit int) (*ObjectPage, error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket:    aws.String(bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+		MaxKeys:   aws.Int64(int64(limit)),
+	}
+	if cursor != "" {
+		input.ContinuationToken = aws.String(cursor)
+	}
+
+	output, err := client.ListObjectsV2(input)
+	if err != nil {
+		return nil, NewStorageError(provider, "LIST_FAILED", err.Error(), prefix)
+	}
+
+	page := &ObjectPage{Objects: []ObjectInfo{}, Prefixes: []string{}}
+	for _, object := range output.Contents {
+		page.Objects = append(page.Objects, ObjectInfo{
+			Key:          aws.StringValue(object.Key),
+			Size:         aws.Int64Value(object.Size),
+			LastModified: aws.TimeValue(object.LastModified),
+		})
+	}
+	for _, commonPrefix := range output.CommonPrefixes {
+		page.Prefixes = append(page.Prefixes, aws.StringValue(commonPrefix.Prefix))
+	}
+	if aws.BoolValue(output.IsTruncated) {
+		page.NextCursor = aws.StringValue(output.NextContinuationToken)
+	}
+	return page, nil
+}
+
+func headBucketObject(client *s3.S3, bucket, provider, key string) (*ObjectHead, error) {
+	output, err := client.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.RequestFailure); ok && aerr.StatusCode() == 404 {
+			return nil, NewStorageError(provider, "NOT_FOUND", err.Error(), key)
+		}
+		return nil, NewStorageError(provider, "HEAD_FAILED", err.Error(), key)
+	}
+
+	return &ObjectHead{
+		Key:          key,
+		Size:         aws.Int64Value(output.ContentLength),
+		LastModified: aws.TimeValue(output.LastModified),
+		ContentType:  aws.StringValue(output.ContentType),
+		ETag:         strings.Trim(aws.StringValue(output.ETag), `"`),
+		StorageClass: aws.StringValue(output.StorageClass),
+		Metadata:     aws.StringValueMap(output.Metadata),
+	}, nil
+}
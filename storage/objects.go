@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// ObjectLister is implemented by clients which can list every object they
+// store
+type ObjectLister interface {
+	// ListObjects calls fn for each object until fn returns false
+	ListObjects(fn func(ObjectInfo) bool) error
+}
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+// ListObjects lists the objects in the S3 bucket
+func (s *S3Client) ListObjects(fn func(ObjectInfo) bool) error {
+	return listBucketObjects(s.client, s.bucket, "s3", fn)
+}
+
+// ListObjects lists the objects in the R2 bucket
+func (r *R2Client) ListObjects(fn func(ObjectInfo) bool) error {
+	return listBucketObjects(r.client, r.bucket, "r2", fn)
+}
+
+// ListObjects lists the files under the base path. Parts of multipart
+// uploads in progress are not objects and are skipped.
+func (lc *LocalClient) ListObjects(fn func(ObjectInfo) bool) error {
+	return filepath.WalkDir(lc.basePath, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if entry.Name() == ".tmp" && path != lc.basePath {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		key, err := filepath.Rel(lc.basePath, path)
+		if err != nil {
+			return nil
+		}
+		if !fn(ObjectInfo{Key: filepath.ToSlash(key), Size: info.Size(), LastModified: info.ModTime()}) {
+			return filepath.SkipAll
+		}
+		return nil
+	})
+}
+
+func listBucketObjects(client *s3.S3, bucket, provider string, fn func(ObjectInfo) bool) error {
+	err := client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			key := aws.StringValue(object.Key)
+			// Folder placeholders created by consoles are not objects
+			if strings.HasSuffix(key, "/") {
+				continue
+			}
+			if !fn(ObjectInfo{
+				Key:          key,
+				Size:         aws.Int64Value(object.Size),
+				LastModified: aws.TimeValue(object.LastModified),
+			}) {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return NewStorageError(provider, "LIST_FAILED", err.Error(), "")
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// PostPolicyConditions are what a browser form upload must satisfy. KeyPrefix
+// is matched as a prefix of the key, so the form can let S3 name the object
+// after the uploaded file with ${filename}.
+type PostPolicyConditions struct {
+	Key               string
+	KeyPrefix         string
+	MinSize           int64
+	MaxSize           int64
+	ContentType       string // the exact type, or a prefix ending in "/"
+	SuccessRedirect   string
+	SuccessStatusCode int
+	Expiry            time.Duration
+}
+
+// PostPolicy is the form a browser posts to upload to a bucket: URL is the
+// form's action and Fields its hidden inputs, followed by the file input
+type PostPolicy struct {
+	URL       string            `json:"url"`
+	Fields    map[string]string `json:"fields"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+// PostPolicySigner is implemented by clients whose buckets accept browser
+// form uploads, which S3 calls POST Object
+type PostPolicySigner interface {
+	// SignPostPolicy signs a POST policy enforcing conditions
+	SignPostPolicy(conditions PostPolicyConditions) (*PostPolicy, error)
+}
+
+// SignPostPolicy signs a POST policy for the S3 bucket with Signature V4
+func (s *S3Client) SignPostPolicy(conditions PostPolicyConditions) (*PostPolicy, error) {
+	creds, err := s.client.Config.Credentials.Get()
+	if err != nil {
+		return nil, NewStorageError("s3", "PRESIGN_FAILED", err.Error(), conditions.Key)
+	}
+
+	now := time.Now().UTC()
+	expiresAt := now.Add(conditions.Expiry)
+	date := now.Format("20060102")
+	region := s.region
+	if region == "" {
+		region = "us-east-1"
+	}
+	credential := fmt.Sprintf("%s/%s/%s/s3/aws4_request", creds.AccessKeyID, date, region)
+
+	fields := map[string]string{
+		"x-amz-algorithm":  "AWS4-HMAC-SHA256",
+		"x-amz-credential": credential,
+		"x-amz-date":       now.Format("20060102T150405Z"),
+	}
+	if creds.SessionToken != "" {
+		fields["x-amz-security-token"] = creds.SessionToken
+	}
+
+	policyConditions := []interface{}{map[string]string{"bucket": s.bucket}}
+	if conditions.Key != "" {
+		fields["key"] = conditions.Key
+		policyConditions = append(policyConditions, []string{"eq", "$key", conditions.Key})
+	} else {
+		fields["key"] = conditions.KeyPrefix + "${filename}"
+		policyConditions = append(policyConditions, []string{"starts-with", "$key", conditions.KeyPrefix})
+	}
+	if conditions.MaxSize > 0 {
+		policyConditions = append(policyConditions, []interface{}{"content-length-range", conditions.MinSize, conditions.MaxSize})
+	}
+	switch {
+	case strings.HasSuffix(conditions.ContentType, "/"):
+		policyConditions = append(policyConditions, []string{"starts-with", "$Content-Type", conditions.ContentType})
+	case conditions.ContentType != "":
+		fields["Content-Type"] = conditions.ContentType
+	}
+	if conditions.SuccessRedirect != "" {
+		fields["success_action_redirect"] = conditions.SuccessRedirect
+	} else if conditions.SuccessStatusCode != 0 {
+		fields["success_action_status"] = fmt.Sprint(conditions.SuccessStatusCode)
+	}
+	for name, value := range fields {
+		if name != "key" {
+			policyConditions = append(policyConditions, map[string]string{name: value})
+		}
+	}
+
+	document, err := json.Marshal(map[string]interface{}{
+		"expiration": expiresAt.Format("2006-01-02T15:04:05.000Z"),
+		"conditions": policyConditions,
+	})
+	if err != nil {
+		return nil, NewStorageError("s3", "PRESIGN_FAILED", err.Error(), conditions.Key)
+	}
+	policy := base64.StdEncoding.EncodeToString(document)
+
+	signingKey := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+	signingKey = hmacSHA256(signingKey, region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	fields["policy"] = policy
+	fields["x-amz-signature"] = hex.EncodeToString(hmacSHA256(signingKey, policy))
+
+	return &PostPolicy{
+		URL:       s.bucketURL(),
+		Fields:    fields,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// bucketURL is the URL of the bucket itself, which form uploads are posted to
+func (s *S3Client) bucketURL() string {
+	if s.provider.Endpoint != "" {
+		endpoint := strings.TrimSuffix(s.provider.Endpoint, "/")
+		if !strings.Contains(endpoint, "://") {
+			endpoint = "https://" + endpoint
+		}
+		return endpoint + "/" + s.bucket
+	}
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com", s.bucket, s.region)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
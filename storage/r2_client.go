@@ -46,10 +46,14 @@ func NewR2Client(provider *models.StorageProvider) (*R2Client, error) {
 	}
 
 	// Set credentials
-	if provider.AccessKey != "" && provider.SecretKey != "" {
+	accessKey, secretKey, err := providerCredentials(provider)
+	if err != nil {
+		return nil, err
+	}
+	if accessKey != "" && secretKey != "" {
 		config.Credentials = credentials.NewStaticCredentials(
-			provider.AccessKey,
-			provider.SecretKey,
+			accessKey,
+			secretKey,
 			"",
 		)
 	}
@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// RangeReader is implemented by clients which can stream an object from an
+// offset, so that resumed downloads need not be read from the start
+type RangeReader interface {
+	// DownloadFrom streams the object from offset to its end
+	DownloadFrom(key string, offset int64) (io.ReadCloser, error)
+}
+
+// DownloadFrom streams an S3 object from offset
+func (s *S3Client) DownloadFrom(key string, offset int64) (io.ReadCloser, error) {
+	return downloadBucketObjectFrom(s.client, s.bucket, "s3", key, offset)
+}
+
+// DownloadFrom streams an R2 object from offset
+func (r *R2Client) DownloadFrom(key string, offset int64) (io.ReadCloser, error) {
+	return downloadBucketObjectFrom(r.client, r.bucket, "r2", key, offset)
+}
+
+// DownloadFrom opens the file and seeks to offset
+func (lc *LocalClient) DownloadFrom(key string, offset int64) (io.ReadCloser, error) {
+	file, err := os.Open(filepath.Join(lc.basePath, key))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+func downloadBucketObjectFrom(client *s3.S3, bucket, provider, key string, offset int64) (io.ReadCloser, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}
+	if offset > 0 {
+		input.Range = aws.String(fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	result, err := client.GetObject(input)
+	if err != nil {
+		return nil, NewStorageError(provider, "DOWNLOAD_STREAM_FAILED", err.Error(), key)
+	}
+	return result.Body, nil
+}
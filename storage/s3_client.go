@@ -31,10 +31,14 @@ func NewS3Client(provider *models.StorageProvider) (*S3Client, error) {
 	}
 
 	// Set credentials if provided
-	if provider.AccessKey != "" && provider.SecretKey != "" {
+	accessKey, secretKey, err := providerCredentials(provider)
+	if err != nil {
+		return nil, err
+	}
+	if accessKey != "" && secretKey != "" {
 		config.Credentials = credentials.NewStaticCredentials(
-			provider.AccessKey,
-			provider.SecretKey,
+			accessKey,
+			secretKey,
 			"",
 		)
 	}
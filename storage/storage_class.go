@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// StorageClassManager is implemented by clients whose objects can be kept
+// in storage classes other than the standard one
+type StorageClassManager interface {
+	// UploadWithClass uploads data into a storage class
+	UploadWithClass(key string, data []byte, storageClass string) error
+	// SetStorageClass moves a stored object to another storage class
+	SetStorageClass(key, storageClass string) error
+	// GetObjectClass reports an object's storage class and, for archived
+	// objects, whether a copy has been restored
+	GetObjectClass(key string) (*ObjectClass, error)
+	// RestoreObject restores a readable copy of an archived object for days,
+	// retrieving it at the given tier
+	RestoreObject(key string, days int, tier string) error
+}
+
+// ObjectClass is the storage class of a stored object. Restoring is set
+// while an archived object is being restored, and RestoredUntil once its
+// restored copy is readable.
+type ObjectClass struct {
+	StorageClass  string     `json:"storage_class"`
+	Restoring     bool       `json:"restoring"`
+	RestoredUntil *time.Time `json:"restored_until,omitempty"`
+}
+
+// ErrRestoreInProgress is returned when an object is already being restored
+var ErrRestoreInProgress = errors.New("object is already being restored")
+
+// UploadWithClass uploads data to S3 in a storage class
+func (s *S3Client) UploadWithClass(key string, data []byte, storageClass string) error {
+	return putObjectWithClass(s.client, s.bucket, "s3", key, data, storageClass)
+}
+
+// SetStorageClass moves an S3 object to another storage class
+func (s *S3Client) SetStorageClass(key, storageClass string) error {
+	return copyObjectToClass(s.client, s.bucket, "s3", key, storageClass)
+}
+
+// GetObjectClass reports the storage class and restore state of an S3 object
+func (s *S3Client) GetObjectClass(key string) (*ObjectClass, error) {
+	return headObjectClass(s.client, s.bucket, "s3", key)
+}
+
+// RestoreObject restores an S3 object from Glacier Flexible Retrieval or
+// Deep Archive
+func (s *S3Client) RestoreObject(key string, days int, tier string) error {
+	_, err := s.client.RestoreObject(&s3.RestoreObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		RestoreRequest: &s3.RestoreRequest{
+			Days:                 aws.Int64(int64(days)),
+			GlacierJobParameters: &s3.GlacierJobParameters{Tier: aws.String(tier)},
+		},
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "RestoreAlreadyInProgress" {
+			return ErrRestoreInProgress
+		}
+		return NewStorageError("s3", "RESTORE_FAILED", err.Error(), key)
+	}
+	return nil
+}
+
+// UploadWithClass uploads data to R2 in a storage class
+func (r *R2Client) UploadWithClass(key string, data []byte, storageClass string) error {
+	return putObjectWithClass(r.client, r.bucket, "r2", key, data, storageClass)
+}
+
+// SetStorageClass moves an R2 object to another storage class
+func (r *R2Client) SetStorageClass(key, storageClass string) error {
+	return copyObjectToClass(r.client, r.bucket, "r2", key, storageClass)
+}
+
+// GetObjectClass reports the storage class of an R2 object
+func (r *R2Client) GetObjectClass(key string) (*ObjectClass, error) {
+	return headObjectClass(r.client, r.bucket, "r2", key)
+}
+
+// RestoreObject fails, as R2 has no archive storage classes
+func (r *R2Client) RestoreObject(key string, days int, tier string) error {
+	return NewStorageError("r2", "RESTORE_UNSUPPORTED", "R2 objects are never archived", key)
+}
+
+func putObjectWithClass(client *s3.S3, bucket, provider, key string, data []byte, storageClass string) error {
+	_, err := client.PutObject(&s3.PutObjectInput{
+		Bucket:       aws.String(bucket),
+		Key:          aws.String(key),
+		Body:         bytes.NewReader(data),
+		StorageClass: aws.String(storageClass),
+	})
+	if err != nil {
+		return NewStorageError(provider, "UPLOAD_FAILED", err.Error(), key)
+	}
+	return nil
+}
+
+// copyObjectToClass copies an object onto itself in another storage class,
+// keeping its metadata
+func copyObjectToClass(client *s3.S3, bucket, provider, key, storageClass string) error {
+	_, err := client.CopyObject(&s3.CopyObjectInput{
+		Bucket:            aws.String(bucket),
+		CopySource:        aws.String(fmt.Sprintf("%s/%s", bucket, key)),
+		Key:               aws.String(key),
+		StorageClass:      aws.String(storageClass),
+		MetadataDirective: aws.String(s3.MetadataDirectiveCopy),
+	})
+	if err != nil {
+		return NewStorageError(provider, "STORAGE_CLASS_FAILED", err.Error(), key)
+	}
+	return nil
+}
+
+// restoreHeader parses the x-amz-restore header, such as
+// ongoing-request="false", expiry-date="Fri, 21 Dec 2012 00:00:00 GMT"
+var restoreHeader = regexp.MustCompile(`ongoing-request="(true|false)"(?:,\s*expiry-date="([^"]+)")?`)
+
+func headObjectClass(client *s3.S3, bucket, provider, key string) (*ObjectClass, error) {
+	output, err := client.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, NewStorageError(provider, "HEAD_FAILED", err.Error(), key)
+	}
+
+	// Objects in the standard class are reported without one
+	class := &ObjectClass{StorageClass: aws.StringValue(output.StorageClass)}
+	if class.StorageClass == "" {
+		class.StorageClass = s3.StorageClassStandard
+	}
+	if match := restoreHeader.FindStringSubmatch(aws.StringValue(output.Restore)); match != nil {
+		class.Restoring = match[1] == "true"
+		if expiry, err := time.Parse(time.RFC1123, match[2]); err == nil {
+			class.RestoredUntil = &expiry
+		}
+	}
+	return class, nil
+}
@@ -0,0 +1,121 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requestContextKey holds the request body bound by middleware.ValidateJSON
+const requestContextKey = "request"
+
+// BindJSON decodes the request body into obj and validates it. An empty body
+// is validated as an empty object, and values of the wrong type are reported
+// as FieldErrors like failed validations.
+func BindJSON(c *gin.Context, obj interface{}) error {
+	if err := c.ShouldBindJSON(obj); err != nil && !errors.Is(err, io.EOF) {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			return FieldErrors{{
+				Field:   typeErr.Field,
+				Rule:    "type",
+				Message: fmt.Sprintf("%s must be %s", typeErr.Field, jsonTypeName(typeErr.Type)),
+			}}
+		}
+		return err
+	}
+	return ValidateStruct(obj)
+}
+
+// BindingErrorResponse answers a request BindJSON rejected: 422 listing the
+// failing fields, or 400 when the body is not JSON at all
+func BindingErrorResponse(c *gin.Context, err error) {
+	var fieldErrors FieldErrors
+	if errors.As(err, &fieldErrors) {
+		ValidationErrorResponse(c, err)
+		return
+	}
+	BadRequestResponse(c, "Invalid request data")
+}
+
+// SetRequestInContext stores a bound request body for the handler
+func SetRequestInContext(c *gin.Context, req interface{}) {
+	c.Set(requestContextKey, req)
+}
+
+// BoundRequest returns the request body bound by middleware.ValidateJSON.
+// Without the middleware the body is bound here. When binding fails the
+// error has been sent and ok is false.
+func BoundRequest[T any](c *gin.Context) (req *T, ok bool) {
+	if value, exists := c.Get(requestContextKey); exists {
+		if req, ok := value.(*T); ok {
+			return req, true
+		}
+	}
+
+	req = new(T)
+	if err := BindJSON(c, req); err != nil {
+		BindingErrorResponse(c, err)
+		return nil, false
+	}
+	SetRequestInContext(c, req)
+	return req, true
+}
+
+// jsonTypeName describes the JSON value expected for a Go type
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.String:
+		return "a string"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	case reflect.Ptr:
+		return jsonTypeName(t.Elem())
+	default:
+		return "valid"
+	}
+}
+
+// UpdateFields returns the fields set in a partial update request, keyed by
+// their bson tag. Nil pointers, slices and maps are left out, as are fields
+// tagged bson:"-".
+func UpdateFields(req interface{}) map[string]interface{} {
+	updates := map[string]interface{}{}
+	value := reflect.Indirect(reflect.ValueOf(req))
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		key := strings.SplitN(field.Tag.Get("bson"), ",", 2)[0]
+		if key == "" || key == "-" {
+			continue
+		}
+
+		fieldValue := value.Field(i)
+		switch fieldValue.Kind() {
+		case reflect.Ptr:
+			if !fieldValue.IsNil() {
+				updates[key] = fieldValue.Elem().Interface()
+			}
+		case reflect.Slice, reflect.Map:
+			if !fieldValue.IsNil() {
+				updates[key] = fieldValue.Interface()
+			}
+		default:
+			updates[key] = fieldValue.Interface()
+		}
+	}
+	return updates
+}
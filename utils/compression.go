@@ -0,0 +1,124 @@
+package utils
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Encodings files can be compressed with at rest
+const (
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// compressibleMimeTypes are the non-text types worth compressing at rest.
+// Every text/* type is.
+var compressibleMimeTypes = map[string]bool{
+	"application/json":         true,
+	"application/ld+json":      true,
+	"application/xml":          true,
+	"application/xhtml+xml":    true,
+	"application/javascript":   true,
+	"application/x-javascript": true,
+	"application/ecmascript":   true,
+	"application/x-yaml":       true,
+	"application/yaml":         true,
+	"application/toml":         true,
+	"application/sql":          true,
+	"application/x-sh":         true,
+	"application/x-tex":        true,
+	"application/rtf":          true,
+	"application/postscript":   true,
+	"application/x-ndjson":     true,
+	"application/geo+json":     true,
+	"application/vnd.ms-excel": true, // legacy .xls, unlike the zipped .xlsx
+	"application/msword":       true, // legacy .doc
+	"application/x-subrip":     true,
+	"image/svg+xml":            true,
+	"image/bmp":                true,
+	"image/x-ms-bmp":           true,
+	"image/tiff":               true,
+	"audio/wav":                true,
+	"audio/x-wav":              true,
+	"application/x-tar":        true,
+	"application/vnd.sqlite3":  true,
+	"application/x-sqlite3":    true,
+}
+
+// compressibleExtensions are compressed whatever type they were detected as,
+// since text files are often uploaded as application/octet-stream
+var compressibleExtensions = map[string]bool{
+	".txt": true, ".log": true, ".csv": true, ".tsv": true, ".json": true, ".ndjson": true,
+	".xml": true, ".html": true, ".htm": true, ".css": true, ".js": true, ".mjs": true,
+	".ts": true, ".md": true, ".yaml": true, ".yml": true, ".toml": true, ".ini": true,
+	".sql": true, ".svg": true, ".go": true, ".py": true, ".java": true, ".c": true,
+	".h": true, ".cpp": true, ".rs": true, ".rb": true, ".php": true, ".sh": true,
+	".tex": true, ".rtf": true, ".srt": true, ".vtt": true, ".ipynb": true, ".bmp": true,
+	".tif": true, ".tiff": true, ".wav": true, ".tar": true,
+}
+
+// IsCompressible reports whether files of a type are worth compressing at
+// rest. Formats that are compressed already, such as images, video, zip
+// archives and Office documents, are not.
+func IsCompressible(mimeType, ext string) bool {
+	mimeType = strings.ToLower(strings.TrimSpace(strings.SplitN(mimeType, ";", 2)[0]))
+	if strings.HasPrefix(mimeType, "text/") || compressibleMimeTypes[mimeType] {
+		return true
+	}
+	return compressibleExtensions[strings.ToLower(ext)]
+}
+
+// Compress compresses content with encoding
+func Compress(encoding string, content []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	switch encoding {
+	case CompressionGzip:
+		writer, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := writer.Write(content); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+	case CompressionZstd:
+		encoder, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedBetterCompression))
+		if err != nil {
+			return nil, err
+		}
+		defer encoder.Close()
+		return encoder.EncodeAll(content, make([]byte, 0, len(content)/2)), nil
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", encoding)
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress restores content compressed with encoding
+func Decompress(encoding string, content []byte) ([]byte, error) {
+	switch encoding {
+	case CompressionGzip:
+		reader, err := gzip.NewReader(bytes.NewReader(content))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return io.ReadAll(reader)
+	case CompressionZstd:
+		decoder, err := zstd.NewReader(nil)
+		if err != nil {
+			return nil, err
+		}
+		defer decoder.Close()
+		return decoder.DecodeAll(content, nil)
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", encoding)
+	}
+}
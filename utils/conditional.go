@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"fmt"
+	"net/http"
+	"oncloud/models"
+	"strings"
+	"time"
+)
+
+// FileETag builds a strong ETag for a file based on its content hash. Files
+// without a hash fall back to a validator derived from ID and modification time.
+func FileETag(file *models.File, variant string) string {
+	tag := file.Hash
+	if tag == "" {
+		tag = fmt.Sprintf("%s-%d", file.ID.Hex(), file.UpdatedAt.Unix())
+	}
+	if variant != "" {
+		tag = tag + "-" + variant
+	}
+	return `"` + tag + `"`
+}
+
+// CheckNotModified sets caching validators on the response and reports whether
+// the request's If-None-Match / If-Modified-Since headers match them. When it
+// returns true a 304 Not Modified has already been written.
+func CheckNotModified(w http.ResponseWriter, r *http.Request, etag string, lastModified time.Time) bool {
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+	w.Header().Set("Cache-Control", "private, max-age=0, must-revalidate")
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	// If-None-Match takes precedence over If-Modified-Since (RFC 7232 §6)
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if etag != "" && etagMatches(inm, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+		return false
+	}
+
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !lastModified.IsZero() {
+		since, err := http.ParseTime(ims)
+		if err == nil && !lastModified.Truncate(time.Second).After(since) {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+
+	return false
+}
+
+// NoStore keeps a response out of caches. Responses carrying a presigned
+// URL use it instead of validators, which only change with the file and so
+// would keep clients on a URL after it expired.
+func NoStore(w http.ResponseWriter) {
+	w.Header().Set("Cache-Control", "private, no-store")
+}
+
+// IsLocalURL reports whether url is a path on this server rather than a
+// provider's presigned URL. Such URLs stay valid for as long as the file is
+// unchanged, so responses carrying them can be revalidated.
+func IsLocalURL(url string) bool {
+	return strings.HasPrefix(url, "/") && !strings.HasPrefix(url, "//")
+}
+
+// etagMatches performs a weak comparison of an If-None-Match header value
+// against an ETag
+func etagMatches(header, etag string) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+
+	target := strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == target {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCheckNotModified(t *testing.T) {
+	modified := time.Date(2026, 5, 4, 10, 30, 0, 0, time.UTC)
+	const etag = `"abc123-preview"`
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    bool
+	}{
+		{"no validators", nil, false},
+		{"matching etag", map[string]string{"If-None-Match": etag}, true},
+		{"weak matching etag", map[string]string{"If-None-Match": `"other", W/` + etag}, true},
+		{"stale etag", map[string]string{"If-None-Match": `"old"`}, false},
+		{"etag wins over date", map[string]string{"If-None-Match": `"old"`, "If-Modified-Since": modified.Format(http.TimeFormat)}, false},
+		{"not modified since", map[string]string{"If-Modified-Since": modified.Format(http.TimeFormat)}, true},
+		{"modified since", map[string]string{"If-Modified-Since": modified.Add(-time.Hour).Format(http.TimeFormat)}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/files/1/preview", nil)
+			for name, value := range tt.headers {
+				r.Header.Set(name, value)
+			}
+			w := httptest.NewRecorder()
+
+			if got := CheckNotModified(w, r, etag, modified); got != tt.want {
+				t.Fatalf("CheckNotModified() = %v, want %v", got, tt.want)
+			}
+			if tt.want && w.Code != http.StatusNotModified {
+				t.Errorf("status %d, want 304", w.Code)
+			}
+			if w.Header().Get("ETag") != etag || w.Header().Get("Last-Modified") != modified.Format(http.TimeFormat) {
+				t.Errorf("validators %q %q", w.Header().Get("ETag"), w.Header().Get("Last-Modified"))
+			}
+		})
+	}
+}
+
+func TestIsLocalURL(t *testing.T) {
+	for url, want := range map[string]bool{
+		"/thumbnails/abc_thumb.jpg":                     true,
+		"/api/v1/files/abc/preview":                     true,
+		"https://bucket.s3.amazonaws.com/k?X-Amz-Sig=1": false,
+		"//cdn.example.com/abc_thumb.jpg":               false,
+		"":                                              false,
+	} {
+		if got := IsLocalURL(url); got != want {
+			t.Errorf("IsLocalURL(%q) = %v, want %v", url, got, want)
+		}
+	}
+}
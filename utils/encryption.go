@@ -3,12 +3,14 @@ package utils
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
 	"errors"
 	"io"
+	"math/big"
 
 	"golang.org/x/crypto/bcrypt"
 	"golang.org/x/crypto/scrypt"
@@ -100,6 +102,20 @@ func GenerateSecureToken(length int) (string, error) {
 	return hex.EncodeToString(bytes), nil
 }
 
+// GenerateNumericCode generates a cryptographically secure code of the given
+// number of digits, e.g. for emailed verification codes
+func GenerateNumericCode(digits int) (string, error) {
+	code := make([]byte, digits)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", err
+		}
+		code[i] = byte('0' + n.Int64())
+	}
+	return string(code), nil
+}
+
 // GenerateAPIKey generates a secure API key
 func GenerateAPIKey() (string, error) {
 	return GenerateSecureToken(32)
@@ -169,3 +185,20 @@ func DecryptFile(encryptedContent []byte, userKey string) ([]byte, error) {
 	nonce, ciphertext := encryptedContent[:nonceSize], encryptedContent[nonceSize:]
 	return gcm.Open(nil, nonce, ciphertext, nil)
 }
+
+// ParseEd25519PrivateKey decodes a base64 Ed25519 private key, given either as
+// its 32 byte seed or as the full 64 byte key
+func ParseEd25519PrivateKey(encoded string) (ed25519.PrivateKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.New("key is not valid base64")
+	}
+	switch len(raw) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(raw), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(raw), nil
+	default:
+		return nil, errors.New("key must be a 32 byte seed or a 64 byte private key")
+	}
+}
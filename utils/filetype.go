@@ -0,0 +1,220 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"oncloud/models"
+	"strings"
+	"time"
+)
+
+// fileSignature is a kind of content told apart by its magic bytes, and the
+// extensions files of that kind are named with
+type fileSignature struct {
+	mimeType   string
+	extensions []string
+	executable bool
+	match      func(content []byte) bool
+}
+
+func magicPrefix(magic string) func([]byte) bool {
+	return func(content []byte) bool { return bytes.HasPrefix(content, []byte(magic)) }
+}
+
+func magicAt(offset int, magic string) func([]byte) bool {
+	return func(content []byte) bool {
+		return len(content) >= offset+len(magic) && string(content[offset:offset+len(magic)]) == magic
+	}
+}
+
+// fileSignatures are checked in order, so more specific kinds come before
+// the containers they share magic bytes with
+var fileSignatures = []fileSignature{
+	// Executables, which are only accepted under executable extensions
+	{"application/vnd.microsoft.portable-executable", []string{".exe", ".dll", ".sys", ".scr", ".cpl", ".ocx", ".com", ".efi", ".mui"}, true, func(content []byte) bool {
+		if !bytes.HasPrefix(content, []byte("MZ")) || len(content) < 64 {
+			return false
+		}
+		// The DOS header points at the PE header
+		peOffset := int(binary.LittleEndian.Uint32(content[0x3c:]))
+		return peOffset >= 64 && peOffset+4 <= len(content) && string(content[peOffset:peOffset+4]) == "PE\x00\x00"
+	}},
+	{"application/x-elf", []string{".so", ".o", ".elf", ".bin", ".run", ".out", ".ko", ".axf", ".prx"}, true, magicPrefix("\x7fELF")},
+	{"application/x-mach-binary", []string{".dylib", ".bundle", ".o", ".bin", ".macho"}, true, func(content []byte) bool {
+		for _, magic := range []string{"\xfe\xed\xfa\xce", "\xfe\xed\xfa\xcf", "\xce\xfa\xed\xfe", "\xcf\xfa\xed\xfe"} {
+			if bytes.HasPrefix(content, []byte(magic)) {
+				return true
+			}
+		}
+		return false
+	}},
+	{"application/x-msi", []string{".msi", ".msp", ".msm"}, true, func(content []byte) bool {
+		return bytes.HasPrefix(content, []byte("\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1")) &&
+			bytes.Contains(content[:min(len(content), 64*1024)], []byte("\x84\x10\x0c\x00\x00\x00\x00\x00\xc0\x00\x00\x00\x00\x00\x00\x46"))
+	}},
+	{"text/x-shellscript", []string{".sh", ".bash", ".zsh", ".ksh", ".csh", ".py", ".pl", ".rb", ".php", ".js", ".mjs", ".lua", ".tcl", ".awk", ".r", ".command", ".cgi"}, true, magicPrefix("#!")},
+	{"application/java-vm", []string{".class"}, true, func(content []byte) bool {
+		// Fat Mach-O binaries share the magic, but count far fewer
+		// architectures than class files have major versions
+		return bytes.HasPrefix(content, []byte("\xca\xfe\xba\xbe")) && len(content) >= 8 && content[7] >= 45
+	}},
+	{"application/x-mach-binary", []string{".dylib", ".bundle", ".bin", ".macho"}, true, magicPrefix("\xca\xfe\xba\xbe")},
+	{"application/wasm", []string{".wasm"}, true, magicPrefix("\x00asm")},
+
+	// Images
+	{"image/jpeg", []string{".jpg", ".jpeg", ".jpe", ".jfif", ".pjpeg", ".pjp"}, false, magicPrefix("\xff\xd8\xff")},
+	{"image/png", []string{".png", ".apng"}, false, magicPrefix("\x89PNG\r\n\x1a\n")},
+	{"image/gif", []string{".gif"}, false, func(content []byte) bool {
+		return bytes.HasPrefix(content, []byte("GIF87a")) || bytes.HasPrefix(content, []byte("GIF89a"))
+	}},
+	{"image/webp", []string{".webp"}, false, func(content []byte) bool { return magicPrefix("RIFF")(content) && magicAt(8, "WEBP")(content) }},
+	{"image/bmp", []string{".bmp", ".dib"}, false, func(content []byte) bool { return magicPrefix("BM")(content) && len(content) >= 14 }},
+	{"image/tiff", []string{".tif", ".tiff", ".dng", ".nef", ".cr2", ".arw", ".orf", ".pef", ".srw", ".rw2"}, false, func(content []byte) bool {
+		return bytes.HasPrefix(content, []byte("II*\x00")) || bytes.HasPrefix(content, []byte("MM\x00*"))
+	}},
+	{"image/vnd.microsoft.icon", []string{".ico", ".cur"}, false, func(content []byte) bool {
+		return bytes.HasPrefix(content, []byte("\x00\x00\x01\x00")) || bytes.HasPrefix(content, []byte("\x00\x00\x02\x00"))
+	}},
+	{"image/vnd.adobe.photoshop", []string{".psd", ".psb"}, false, magicPrefix("8BPS")},
+	{"image/heic", []string{".heic", ".heif", ".avif"}, false, func(content []byte) bool {
+		if !magicAt(4, "ftyp")(content) || len(content) < 12 {
+			return false
+		}
+		switch string(content[8:12]) {
+		case "heic", "heix", "hevc", "hevx", "heim", "heis", "mif1", "msf1", "avif", "avis":
+			return true
+		}
+		return false
+	}},
+
+	// Documents
+	{"application/pdf", []string{".pdf", ".ai"}, false, func(content []byte) bool {
+		// Readers find the header within the first kilobyte
+		return bytes.Contains(content[:min(len(content), 1024)], []byte("%PDF-"))
+	}},
+	{"application/rtf", []string{".rtf", ".doc"}, false, magicPrefix("{\\rtf")},
+	{"application/x-ole-storage", []string{".doc", ".dot", ".xls", ".xlt", ".ppt", ".pot", ".pps", ".msg", ".vsd", ".pub", ".mpp", ".one"}, false, magicPrefix("\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1")},
+	{"application/x-sqlite3", []string{".sqlite", ".sqlite3", ".db", ".db3"}, false, magicPrefix("SQLite format 3\x00")},
+
+	// Archives. Office, OpenDocument, e-book and app packages are ZIPs.
+	{"application/zip", []string{
+		".zip", ".docx", ".docm", ".dotx", ".xlsx", ".xlsm", ".xltx", ".pptx", ".pptm", ".potx", ".ppsx", ".vsdx",
+		".odt", ".ods", ".odp", ".odg", ".odf", ".ott", ".epub", ".jar", ".war", ".ear", ".apk", ".aab", ".ipa",
+		".xpi", ".crx", ".kmz", ".cbz", ".3mf", ".whl", ".nupkg", ".vsix", ".appx", ".msix", ".sketch", ".pages",
+		".numbers", ".key", ".xps", ".oxps", ".usdz", ".idml",
+	}, false, func(content []byte) bool {
+		return bytes.HasPrefix(content, []byte("PK\x03\x04")) || bytes.HasPrefix(content, []byte("PK\x05\x06")) ||
+			bytes.HasPrefix(content, []byte("PK\x07\x08"))
+	}},
+	{"application/gzip", []string{".gz", ".tgz", ".svgz"}, false, magicPrefix("\x1f\x8b")},
+	{"application/x-bzip2", []string{".bz2", ".tbz2", ".tbz"}, false, magicPrefix("BZh")},
+	{"application/x-xz", []string{".xz", ".txz"}, false, magicPrefix("\xfd7zXZ\x00")},
+	{"application/zstd", []string{".zst", ".tzst"}, false, magicPrefix("\x28\xb5\x2f\xfd")},
+	{"application/x-7z-compressed", []string{".7z"}, false, magicPrefix("7z\xbc\xaf\x27\x1c")},
+	{"application/vnd.rar", []string{".rar", ".cbr"}, false, magicPrefix("Rar!\x1a\x07")},
+	{"application/x-tar", []string{".tar"}, false, magicAt(257, "ustar")},
+	{"application/x-iso9660-image", []string{".iso"}, false, func(content []byte) bool {
+		return magicAt(0x8001, "CD001")(content) || magicAt(0x8801, "CD001")(content) || magicAt(0x9001, "CD001")(content)
+	}},
+
+	// Audio and video
+	{"video/mp4", []string{".mp4", ".m4v", ".m4a", ".m4b", ".m4p", ".mov", ".qt", ".3gp", ".3g2", ".f4v"}, false, func(content []byte) bool {
+		for _, atom := range []string{"ftyp", "moov", "mdat", "wide", "free", "skip"} {
+			if magicAt(4, atom)(content) {
+				return true
+			}
+		}
+		return false
+	}},
+	{"video/webm", []string{".webm", ".mkv", ".mka", ".mk3d"}, false, magicPrefix("\x1a\x45\xdf\xa3")},
+	{"video/x-msvideo", []string{".avi"}, false, func(content []byte) bool { return magicPrefix("RIFF")(content) && magicAt(8, "AVI ")(content) }},
+	{"audio/wav", []string{".wav", ".wave"}, false, func(content []byte) bool { return magicPrefix("RIFF")(content) && magicAt(8, "WAVE")(content) }},
+	{"audio/ogg", []string{".ogg", ".oga", ".ogv", ".opus", ".spx"}, false, magicPrefix("OggS")},
+	{"audio/flac", []string{".flac"}, false, magicPrefix("fLaC")},
+	{"audio/mpeg", []string{".mp3"}, false, func(content []byte) bool {
+		return bytes.HasPrefix(content, []byte("ID3")) || (len(content) >= 2 && content[0] == 0xff && content[1]&0xe0 == 0xe0)
+	}},
+	{"video/x-flv", []string{".flv"}, false, magicPrefix("FLV\x01")},
+	{"audio/aiff", []string{".aif", ".aiff", ".aifc"}, false, func(content []byte) bool { return magicPrefix("FORM")(content) && magicAt(8, "AIF")(content) }},
+	{"video/mp2t", []string{".ts", ".m2ts", ".mts"}, false, func(content []byte) bool {
+		return len(content) >= 377 && content[0] == 0x47 && content[188] == 0x47 && content[376] == 0x47
+	}},
+
+	// Fonts
+	{"font/woff", []string{".woff"}, false, magicPrefix("wOFF")},
+	{"font/woff2", []string{".woff2"}, false, magicPrefix("wOF2")},
+	{"font/otf", []string{".otf", ".ttf", ".ttc"}, false, func(content []byte) bool {
+		return bytes.HasPrefix(content, []byte("OTTO")) || bytes.HasPrefix(content, []byte("\x00\x01\x00\x00")) ||
+			bytes.HasPrefix(content, []byte("ttcf"))
+	}},
+}
+
+// signedExtensions are the extensions whose files always start with magic
+// bytes, so content without them is not what its name says
+var signedExtensions = func() map[string]bool {
+	signed := make(map[string]bool)
+	for _, signature := range fileSignatures {
+		if signature.mimeType == "text/x-shellscript" || signature.mimeType == "application/rtf" {
+			continue
+		}
+		for _, ext := range signature.extensions {
+			signed[ext] = true
+		}
+	}
+	// Not every file so named is one of the binaries above
+	for _, ext := range []string{".bin", ".o", ".out", ".run", ".com", ".db", ".key", ".pages", ".numbers", ".ts", ".doc", ".ai"} {
+		delete(signed, ext)
+	}
+	return signed
+}()
+
+// CheckFileType compares the magic bytes of a file's content with its
+// extension. Content of a kind named with other extensions is a mismatch,
+// as is content without the magic bytes every file of its extension starts
+// with. Executable content is flagged whatever it is named.
+func CheckFileType(ext string, content []byte) *models.FileTypeCheck {
+	ext = strings.ToLower(ext)
+	check := &models.FileTypeCheck{Status: models.TypeCheckUnknown, CheckedAt: time.Now()}
+
+	var matched []fileSignature
+	for _, signature := range fileSignatures {
+		if signature.match(content) {
+			matched = append(matched, signature)
+		}
+	}
+
+	if len(matched) == 0 {
+		if signedExtensions[ext] {
+			check.Status = models.TypeCheckMismatch
+			check.DetectedType = "application/octet-stream"
+		}
+		return check
+	}
+
+	// Content may match several kinds, e.g. an MSI is also an OLE file.
+	// The first kind its extension names wins, but executables, which come
+	// first, cannot pass for the documents they are also read as.
+	detected := matched[0]
+	for _, signature := range matched {
+		if signature.executable != detected.executable {
+			break
+		}
+		if SliceContains(signature.extensions, ext) {
+			detected = signature
+			check.Status = models.TypeCheckMatch
+			break
+		}
+	}
+	check.DetectedType = detected.mimeType
+	check.Executable = detected.executable
+	if check.Status == models.TypeCheckMatch {
+		return check
+	}
+
+	// Unsigned extensions, such as .txt or .csv, are mismatched only by
+	// content that should never pass for them
+	if signedExtensions[ext] || detected.executable {
+		check.Status = models.TypeCheckMismatch
+	}
+	return check
+}
@@ -0,0 +1,106 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// FolderPathVars are the values placeholders in a folder path template stand
+// for
+type FolderPathVars struct {
+	// Category is the file's category, as FileCategory gives it
+	Category string
+	// Extension is the file's extension without the dot
+	Extension string
+	Source    string
+	Time      time.Time
+}
+
+var folderPathPlaceholder = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+// RenderFolderPath builds a folder path such as /Photos/2024/05 from template.
+// Placeholders are {year}, {month}, {day}, {category}, {ext} and {source};
+// the date is the upload's, in UTC. Placeholders without a value, such as the
+// {ext} of a file without an extension, render as "other".
+func RenderFolderPath(template string, vars FolderPathVars) (string, error) {
+	if err := ValidateFolderPathTemplate(template); err != nil {
+		return "", err
+	}
+
+	t := vars.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	t = t.UTC()
+
+	rendered := folderPathPlaceholder.ReplaceAllStringFunc(template, func(match string) string {
+		value := ""
+		switch folderPathPlaceholder.FindStringSubmatch(match)[1] {
+		case "year":
+			value = fmt.Sprintf("%d", t.Year())
+		case "month":
+			value = fmt.Sprintf("%02d", t.Month())
+		case "day":
+			value = fmt.Sprintf("%02d", t.Day())
+		case "category":
+			value = vars.Category
+		case "ext":
+			value = strings.ToLower(strings.TrimPrefix(vars.Extension, "."))
+		case "source":
+			value = vars.Source
+		}
+		if value == "" || !validFolderSegment(value) {
+			value = "other"
+		}
+		return value
+	})
+
+	return "/" + strings.Join(FolderPathSegments(rendered), "/"), nil
+}
+
+// ValidateFolderPathTemplate checks that template is an absolute folder path
+// whose folder names are valid and which only uses known placeholders
+func ValidateFolderPathTemplate(template string) error {
+	if !strings.HasPrefix(template, "/") {
+		return fmt.Errorf("folder path must start with /")
+	}
+	segments := FolderPathSegments(template)
+	if len(segments) == 0 {
+		return fmt.Errorf("folder path must name a folder")
+	}
+	for _, parts := range folderPathPlaceholder.FindAllStringSubmatch(template, -1) {
+		switch parts[1] {
+		case "year", "month", "day", "category", "ext", "source":
+		default:
+			return fmt.Errorf("unknown folder path placeholder {%s}", parts[1])
+		}
+	}
+	for _, segment := range segments {
+		if segment == "." || segment == ".." {
+			return fmt.Errorf("folder path must not contain %q segments", segment)
+		}
+		literal := folderPathPlaceholder.ReplaceAllString(segment, "x")
+		if strings.ContainsAny(literal, "{}") || !validFolderSegment(literal) {
+			return fmt.Errorf("folder name %q is invalid", segment)
+		}
+	}
+	return nil
+}
+
+// FolderPathSegments splits a folder path into its folder names, ignoring
+// empty ones
+func FolderPathSegments(path string) []string {
+	segments := []string{}
+	for _, segment := range strings.Split(path, "/") {
+		if segment = strings.TrimSpace(segment); segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	return segments
+}
+
+func validFolderSegment(name string) bool {
+	return len(name) <= 255 && !strings.ContainsAny(name, `<>:"/\|?*`)
+}
@@ -0,0 +1,165 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"oncloud/models"
+	"sync"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// IP anonymization modes applied before client addresses are stored
+const (
+	IPAnonymizationNone     = "none"     // store the full address
+	IPAnonymizationTruncate = "truncate" // zero the host part: /24 for IPv4, /48 for IPv6
+	IPAnonymizationDrop     = "drop"     // do not store the address at all
+)
+
+var geoIP = struct {
+	reader        *geoip2.Reader
+	cityLevel     bool
+	anonymization string
+	mutex         sync.RWMutex
+}{anonymization: IPAnonymizationTruncate}
+
+// IsValidIPAnonymization reports whether mode is a supported anonymization mode
+func IsValidIPAnonymization(mode string) bool {
+	return mode == IPAnonymizationNone || mode == IPAnonymizationTruncate || mode == IPAnonymizationDrop
+}
+
+// InitGeoIP opens a MaxMind GeoIP2/GeoLite2 City or Country database and sets
+// the IP anonymization mode. An empty path disables lookups.
+func InitGeoIP(databasePath, anonymization string) error {
+	if !IsValidIPAnonymization(anonymization) {
+		return fmt.Errorf("unsupported IP anonymization mode: %s", anonymization)
+	}
+
+	geoIP.mutex.Lock()
+	defer geoIP.mutex.Unlock()
+
+	geoIP.anonymization = anonymization
+	if databasePath == "" {
+		return nil
+	}
+
+	reader, err := geoip2.Open(databasePath)
+	if err != nil {
+		return fmt.Errorf("failed to open GeoIP database: %v", err)
+	}
+
+	switch reader.Metadata().DatabaseType {
+	case "GeoIP2-City", "GeoLite2-City", "GeoIP2-Enterprise":
+		geoIP.cityLevel = true
+	case "GeoIP2-Country", "GeoLite2-Country":
+		geoIP.cityLevel = false
+	default:
+		reader.Close()
+		return errors.New("GeoIP database must be a City or Country database")
+	}
+
+	if geoIP.reader != nil {
+		geoIP.reader.Close()
+	}
+	geoIP.reader = reader
+
+	return nil
+}
+
+// GeoIPEnabled reports whether a GeoIP database is loaded
+func GeoIPEnabled() bool {
+	geoIP.mutex.RLock()
+	defer geoIP.mutex.RUnlock()
+	return geoIP.reader != nil
+}
+
+// CloseGeoIP releases the GeoIP database
+func CloseGeoIP() {
+	geoIP.mutex.Lock()
+	defer geoIP.mutex.Unlock()
+
+	if geoIP.reader != nil {
+		geoIP.reader.Close()
+		geoIP.reader = nil
+	}
+}
+
+// LookupIP resolves the country and region of a public IP address. It returns
+// nil when no database is loaded or the address is private or unknown.
+func LookupIP(ipAddress string) *models.GeoLocation {
+	ip := net.ParseIP(ipAddress)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() {
+		return nil
+	}
+
+	geoIP.mutex.RLock()
+	defer geoIP.mutex.RUnlock()
+
+	if geoIP.reader == nil {
+		return nil
+	}
+
+	location := &models.GeoLocation{}
+	if geoIP.cityLevel {
+		record, err := geoIP.reader.City(ip)
+		if err != nil {
+			return nil
+		}
+		location.Country = record.Country.IsoCode
+		location.CountryName = record.Country.Names["en"]
+		if len(record.Subdivisions) > 0 {
+			location.Region = record.Country.IsoCode + "-" + record.Subdivisions[0].IsoCode
+			location.RegionName = record.Subdivisions[0].Names["en"]
+		}
+		location.City = record.City.Names["en"]
+	} else {
+		record, err := geoIP.reader.Country(ip)
+		if err != nil {
+			return nil
+		}
+		location.Country = record.Country.IsoCode
+		location.CountryName = record.Country.Names["en"]
+	}
+
+	if location.Country == "" {
+		return nil
+	}
+
+	return location
+}
+
+// AnonymizeIP applies the configured anonymization mode to an IP address
+func AnonymizeIP(ipAddress string) string {
+	geoIP.mutex.RLock()
+	mode := geoIP.anonymization
+	geoIP.mutex.RUnlock()
+
+	switch mode {
+	case IPAnonymizationNone:
+		return ipAddress
+	case IPAnonymizationDrop:
+		return ""
+	}
+
+	ip := net.ParseIP(ipAddress)
+	if ip == nil {
+		return ""
+	}
+	if ipv4 := ip.To4(); ipv4 != nil {
+		return ipv4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// LocateClient resolves a client address into a location safe to store: the
+// IP is anonymized and geographic fields are filled when GeoIP is available
+func LocateClient(ipAddress string) *models.GeoLocation {
+	location := LookupIP(ipAddress)
+	if location == nil {
+		location = &models.GeoLocation{}
+	}
+	location.IP = AnonymizeIP(ipAddress)
+
+	return location
+}
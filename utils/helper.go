@@ -1,8 +1,11 @@
 package utils
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha1"
 	"encoding/base32"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"math"
@@ -372,3 +375,75 @@ func GenerateTOTPSecret() string {
 	}
 	return base32.StdEncoding.EncodeToString(secretBytes)
 }
+
+// ValidateTOTP checks a 6-digit RFC 6238 code against a base32 secret,
+// allowing one 30-second step of clock drift either way
+func ValidateTOTP(secret, code string) bool {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(strings.ToUpper(secret), "="))
+	if err != nil || len(code) != 6 {
+		return false
+	}
+
+	counter := time.Now().Unix() / 30
+	for drift := int64(-1); drift <= 1; drift++ {
+		if hmac.Equal([]byte(totpCode(key, counter+drift)), []byte(code)) {
+			return true
+		}
+	}
+	return false
+}
+
+func totpCode(key []byte, counter int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000)
+}
+
+// DescribeUserAgent summarizes a User-Agent header as "Browser on OS"
+func DescribeUserAgent(userAgent string) string {
+	if userAgent == "" {
+		return "Unknown device"
+	}
+
+	browsers := []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+		{"okhttp", "Android app"},
+		{"CFNetwork", "iOS app"},
+	}
+	systems := []struct{ token, name string }{
+		{"Windows", "Windows"},
+		{"iPhone", "iOS"},
+		{"iPad", "iPadOS"},
+		{"Android", "Android"},
+		{"Mac OS X", "macOS"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	}
+
+	browser := "Unknown browser"
+	for _, b := range browsers {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+	for _, os := range systems {
+		if strings.Contains(userAgent, os.token) {
+			return browser + " on " + os.name
+		}
+	}
+
+	return browser
+}
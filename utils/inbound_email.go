@@ -0,0 +1,153 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"path"
+	"strings"
+)
+
+// maxMIMEDepth bounds how deeply multipart parts are followed
+const maxMIMEDepth = 10
+
+// EmailAttachment is a file attached to an inbound email
+type EmailAttachment struct {
+	FileName    string
+	ContentType string
+	Content     []byte
+}
+
+// InboundEmail is an email received for ingestion
+type InboundEmail struct {
+	Sender      string
+	Recipients  []string
+	Subject     string
+	Attachments []EmailAttachment
+}
+
+// ParseInboundEmail reads the sender, recipients, subject and attachments of
+// a raw RFC 5322 message. Recipients are taken from the To and Cc headers;
+// callers that know the envelope recipient should prefer it.
+func ParseInboundEmail(raw []byte) (*InboundEmail, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("invalid email: %v", err)
+	}
+
+	email := &InboundEmail{}
+	decoder := new(mime.WordDecoder)
+	if subject, err := decoder.DecodeHeader(msg.Header.Get("Subject")); err == nil {
+		email.Subject = subject
+	}
+	if from, err := mail.ParseAddress(msg.Header.Get("From")); err == nil {
+		email.Sender = strings.ToLower(from.Address)
+	}
+	for _, header := range []string{"To", "Cc"} {
+		addresses, err := msg.Header.AddressList(header)
+		if err != nil {
+			continue
+		}
+		for _, address := range addresses {
+			email.Recipients = append(email.Recipients, strings.ToLower(address.Address))
+		}
+	}
+
+	err = collectEmailAttachments(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Disposition"),
+		msg.Header.Get("Content-Transfer-Encoding"), msg.Body, 0, &email.Attachments)
+	if err != nil {
+		return nil, err
+	}
+	return email, nil
+}
+
+// collectEmailAttachments walks a MIME part, adding the parts with a file
+// name to attachments
+func collectEmailAttachments(contentType, disposition, encoding string, body io.Reader, depth int, attachments *[]EmailAttachment) error {
+	if depth > maxMIMEDepth {
+		return errors.New("email is nested too deeply")
+	}
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("invalid email part: %v", err)
+			}
+			err = collectEmailAttachments(part.Header.Get("Content-Type"), part.Header.Get("Content-Disposition"),
+				part.Header.Get("Content-Transfer-Encoding"), part, depth+1, attachments)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	fileName := emailPartFileName(disposition, params)
+	if fileName == "" {
+		return nil // message text
+	}
+
+	content, err := io.ReadAll(decodeTransferEncoding(encoding, body))
+	if err != nil {
+		return fmt.Errorf("invalid attachment %s: %v", fileName, err)
+	}
+	*attachments = append(*attachments, EmailAttachment{
+		FileName:    fileName,
+		ContentType: mediaType,
+		Content:     content,
+	})
+	return nil
+}
+
+// emailPartFileName returns the file name of an attachment part, or "" for
+// parts that are not attachments
+func emailPartFileName(disposition string, contentTypeParams map[string]string) string {
+	name := ""
+	if dispositionType, params, err := mime.ParseMediaType(disposition); err == nil {
+		if dispositionType != "attachment" && dispositionType != "inline" {
+			return ""
+		}
+		name = params["filename"]
+	}
+	if name == "" {
+		name = contentTypeParams["name"]
+	}
+	if name == "" {
+		return ""
+	}
+
+	if decoded, err := new(mime.WordDecoder).DecodeHeader(name); err == nil {
+		name = decoded
+	}
+	name = strings.TrimSpace(path.Base(strings.ReplaceAll(name, "\\", "/")))
+	if name == "." || name == "/" {
+		return ""
+	}
+	return name
+}
+
+func decodeTransferEncoding(encoding string, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	default:
+		return body
+	}
+}
@@ -3,6 +3,7 @@ package utils
 
 import (
 	"errors"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
@@ -10,11 +11,12 @@ import (
 )
 
 type Claims struct {
-	UserID   primitive.ObjectID `json:"user_id"`
-	Email    string             `json:"email"`
-	Username string             `json:"username"`
-	Role     string             `json:"role"`
-	PlanID   primitive.ObjectID `json:"plan_id"`
+	UserID    primitive.ObjectID `json:"user_id"`
+	Email     string             `json:"email"`
+	Username  string             `json:"username"`
+	Role      string             `json:"role"`
+	PlanID    primitive.ObjectID `json:"plan_id"`
+	SessionID string             `json:"sid,omitempty"`
 	jwt.RegisteredClaims
 }
 
@@ -27,51 +29,194 @@ type AdminClaims struct {
 	jwt.RegisteredClaims
 }
 
+type DownloadClaims struct {
+	TokenID primitive.ObjectID `json:"token_id"`
+	FileID  primitive.ObjectID `json:"file_id"`
+	jwt.RegisteredClaims
+}
+
+type EmbedClaims struct {
+	ShareID primitive.ObjectID `json:"share_id"`
+	FileID  primitive.ObjectID `json:"file_id"`
+	jwt.RegisteredClaims
+}
+
+type StreamClaims struct {
+	ShareID  primitive.ObjectID `json:"share_id"`
+	StreamID primitive.ObjectID `json:"stream_id"`
+	jwt.RegisteredClaims
+}
+
+type UnsubscribeClaims struct {
+	UserID primitive.ObjectID `json:"user_id"`
+	List   string             `json:"list"` // the emails unsubscribed from
+	jwt.RegisteredClaims
+}
+
 type TokenPair struct {
 	AccessToken  string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
 	ExpiresIn    int64  `json:"expires_in"`
 	TokenType    string `json:"token_type"`
+
+	// RefreshTokenID is the ID of the refresh token, which its session keeps
+	// to tell a rotated refresh token being used again
+	RefreshTokenID string `json:"-"`
 }
 
 var (
 	jwtSecret        = []byte(getEnv("JWT_SECRET", "your-secret-key"))
 	jwtRefreshSecret = []byte(getEnv("JWT_REFRESH_SECRET", "your-refresh-secret-key"))
-	accessTokenTTL   = 24 * time.Hour
+	accessTokenTTL   = 15 * time.Minute
 	refreshTokenTTL  = 7 * 24 * time.Hour
+
+	// Tokens signed with the secrets in use before the last rotation are
+	// accepted until the longest lived of them has expired
+	jwtSecretsMu          sync.RWMutex
+	previousJWTSecret     []byte
+	previousRefreshSecret []byte
+	jwtSecretsRotatedAt   time.Time
+	jwtSecretsSet         bool
 )
 
-// GenerateTokenPair generates both access and refresh tokens
-func GenerateTokenPair(userID primitive.ObjectID, email, username, role string, planID primitive.ObjectID) (*TokenPair, error) {
+// SetJWTSecrets replaces the secrets tokens are signed with. Tokens signed
+// with the secrets a later call replaces stay valid until they expire, so
+// rotating the secrets doesn't log everyone out; the first call replaces the
+// secrets read from the environment outright, as those may only have been
+// references to them. An empty secret is left unchanged.
+func SetJWTSecrets(secret, refreshSecret string) {
+	jwtSecretsMu.Lock()
+	defer jwtSecretsMu.Unlock()
+
+	if secret != "" && secret != string(jwtSecret) {
+		if jwtSecretsSet {
+			previousJWTSecret = jwtSecret
+			jwtSecretsRotatedAt = time.Now()
+		}
+		jwtSecret = []byte(secret)
+	}
+	if refreshSecret != "" && refreshSecret != string(jwtRefreshSecret) {
+		if jwtSecretsSet {
+			previousRefreshSecret = jwtRefreshSecret
+			jwtSecretsRotatedAt = time.Now()
+		}
+		jwtRefreshSecret = []byte(refreshSecret)
+	}
+	jwtSecretsSet = true
+}
+
+// signingSecret returns the secret new tokens are signed with
+func signingSecret(refresh bool) []byte {
+	jwtSecretsMu.RLock()
+	defer jwtSecretsMu.RUnlock()
+
+	if refresh {
+		return jwtRefreshSecret
+	}
+	return jwtSecret
+}
+
+// verificationSecrets returns the secrets tokens may have been signed with,
+// the current one first
+func verificationSecrets(refresh bool) [][]byte {
+	jwtSecretsMu.RLock()
+	defer jwtSecretsMu.RUnlock()
+
+	current, previous := jwtSecret, previousJWTSecret
+	if refresh {
+		current, previous = jwtRefreshSecret, previousRefreshSecret
+	}
+	if previous == nil || time.Since(jwtSecretsRotatedAt) > refreshTokenTTL {
+		return [][]byte{current}
+	}
+	return [][]byte{current, previous}
+}
+
+// parseWithClaims parses a token signed with any of the secrets it may have
+// been signed with
+func parseWithClaims(tokenString string, claims jwt.Claims, refresh bool) (*jwt.Token, error) {
+	var token *jwt.Token
+	var err error
+	for _, secret := range verificationSecrets(refresh) {
+		token, err = jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+			return secret, nil
+		})
+		var validationErr *jwt.ValidationError
+		if err == nil || !errors.As(err, &validationErr) || validationErr.Errors&jwt.ValidationErrorSignatureInvalid == 0 {
+			break
+		}
+	}
+	return token, err
+}
+
+// SetTokenTTLs sets how long access and refresh tokens last. Zero durations
+// are left unchanged.
+func SetTokenTTLs(access, refresh time.Duration) {
+	if access > 0 {
+		accessTokenTTL = access
+	}
+	if refresh > 0 {
+		refreshTokenTTL = refresh
+	}
+}
+
+// AccessTokenTTL returns how long an access token lasts
+func AccessTokenTTL() time.Duration {
+	return accessTokenTTL
+}
+
+// RefreshTokenTTL returns how long a refresh token, and so a login session
+// left unused, lasts
+func RefreshTokenTTL() time.Duration {
+	return refreshTokenTTL
+}
+
+// GenerateTokenPair generates both access and refresh tokens bound to a login
+// session. The refresh token gets a new ID, which the session must record for
+// the token to be accepted.
+func GenerateTokenPair(userID primitive.ObjectID, email, username, role string, planID primitive.ObjectID, sessionID string) (*TokenPair, error) {
 	// Generate access token
-	accessToken, err := GenerateAccessToken(userID, email, username, role, planID)
+	accessToken, err := GenerateAccessToken(userID, email, username, role, planID, sessionID)
 	if err != nil {
 		return nil, err
 	}
 
 	// Generate refresh token
-	refreshToken, err := GenerateRefreshToken(userID, email)
+	refreshTokenID, err := GenerateSecureToken(16)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := GenerateRefreshToken(userID, email, sessionID, refreshTokenID)
 	if err != nil {
 		return nil, err
 	}
 
 	return &TokenPair{
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
-		ExpiresIn:    int64(accessTokenTTL.Seconds()),
-		TokenType:    "Bearer",
+		AccessToken:    accessToken,
+		RefreshToken:   refreshToken,
+		ExpiresIn:      int64(accessTokenTTL.Seconds()),
+		TokenType:      "Bearer",
+		RefreshTokenID: refreshTokenID,
 	}, nil
 }
 
-// GenerateAccessToken creates a new JWT access token
-func GenerateAccessToken(userID primitive.ObjectID, email, username, role string, planID primitive.ObjectID) (string, error) {
+// GenerateAccessToken creates a new JWT access token. Its ID lets it be
+// revoked on its own.
+func GenerateAccessToken(userID primitive.ObjectID, email, username, role string, planID primitive.ObjectID, sessionID string) (string, error) {
+	tokenID, err := GenerateSecureToken(16)
+	if err != nil {
+		return "", err
+	}
+
 	claims := &Claims{
-		UserID:   userID,
-		Email:    email,
-		Username: username,
-		Role:     role,
-		PlanID:   planID,
+		UserID:    userID,
+		Email:     email,
+		Username:  username,
+		Role:      role,
+		PlanID:    planID,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(accessTokenTTL)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			NotBefore: jwt.NewNumericDate(time.Now()),
@@ -81,15 +226,17 @@ func GenerateAccessToken(userID primitive.ObjectID, email, username, role string
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	return token.SignedString(signingSecret(false))
 }
 
-// GenerateRefreshToken creates a new JWT refresh token
-func GenerateRefreshToken(userID primitive.ObjectID, email string) (string, error) {
+// GenerateRefreshToken creates a new JWT refresh token with the ID tokenID
+func GenerateRefreshToken(userID primitive.ObjectID, email, sessionID, tokenID string) (string, error) {
 	claims := &Claims{
-		UserID: userID,
-		Email:  email,
+		UserID:    userID,
+		Email:     email,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(refreshTokenTTL)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			NotBefore: jwt.NewNumericDate(time.Now()),
@@ -99,7 +246,7 @@ func GenerateRefreshToken(userID primitive.ObjectID, email string) (string, erro
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtRefreshSecret)
+	return token.SignedString(signingSecret(true))
 }
 
 // GenerateAdminToken creates a new JWT token for admin users
@@ -120,14 +267,12 @@ func GenerateAdminToken(adminID primitive.ObjectID, email, username, role string
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	return token.SignedString(signingSecret(false))
 }
 
 // ValidateToken validates and parses JWT token
 func ValidateToken(tokenString string) (*Claims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return jwtSecret, nil
-	})
+	token, err := parseWithClaims(tokenString, &Claims{}, false)
 
 	if err != nil {
 		return nil, err
@@ -142,9 +287,7 @@ func ValidateToken(tokenString string) (*Claims, error) {
 
 // ValidateRefreshToken validates refresh token
 func ValidateRefreshToken(tokenString string) (*Claims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return jwtRefreshSecret, nil
-	})
+	token, err := parseWithClaims(tokenString, &Claims{}, true)
 
 	if err != nil {
 		return nil, err
@@ -159,9 +302,7 @@ func ValidateRefreshToken(tokenString string) (*Claims, error) {
 
 // ValidateAdminToken validates admin JWT token
 func ValidateAdminToken(tokenString string) (*AdminClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &AdminClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return jwtSecret, nil
-	})
+	token, err := parseWithClaims(tokenString, &AdminClaims{}, false)
 
 	if err != nil {
 		return nil, err
@@ -173,3 +314,138 @@ func ValidateAdminToken(tokenString string) (*AdminClaims, error) {
 
 	return nil, errors.New("invalid admin token")
 }
+
+// GenerateDownloadToken creates a signed token granting access to a single file
+func GenerateDownloadToken(tokenID, fileID primitive.ObjectID, expiresAt time.Time) (string, error) {
+	claims := &DownloadClaims{
+		TokenID: tokenID,
+		FileID:  fileID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			NotBefore: jwt.NewNumericDate(time.Now()),
+			Issuer:    "cloudstorage-download",
+			Subject:   fileID.Hex(),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(signingSecret(false))
+}
+
+// ValidateDownloadToken validates a signed download token
+func ValidateDownloadToken(tokenString string) (*DownloadClaims, error) {
+	token, err := parseWithClaims(tokenString, &DownloadClaims{}, false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if claims, ok := token.Claims.(*DownloadClaims); ok && token.Valid && claims.Issuer == "cloudstorage-download" {
+		return claims, nil
+	}
+
+	return nil, errors.New("invalid download token")
+}
+
+// GenerateEmbedToken creates a signed token embedding a shared file until
+// expiresAt
+func GenerateEmbedToken(shareID, fileID primitive.ObjectID, expiresAt time.Time) (string, error) {
+	claims := &EmbedClaims{
+		ShareID: shareID,
+		FileID:  fileID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			NotBefore: jwt.NewNumericDate(time.Now()),
+			Issuer:    "cloudstorage-embed",
+			Subject:   fileID.Hex(),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(signingSecret(false))
+}
+
+// ValidateEmbedToken validates a signed embed token
+func ValidateEmbedToken(tokenString string) (*EmbedClaims, error) {
+	token, err := parseWithClaims(tokenString, &EmbedClaims{}, false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if claims, ok := token.Claims.(*EmbedClaims); ok && token.Valid && claims.Issuer == "cloudstorage-embed" {
+		return claims, nil
+	}
+
+	return nil, errors.New("invalid embed token")
+}
+
+// GenerateStreamToken creates a signed token for the segments of a shared
+// media stream until expiresAt
+func GenerateStreamToken(shareID, streamID primitive.ObjectID, expiresAt time.Time) (string, error) {
+	claims := &StreamClaims{
+		ShareID:  shareID,
+		StreamID: streamID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			NotBefore: jwt.NewNumericDate(time.Now()),
+			Issuer:    "cloudstorage-stream",
+			Subject:   streamID.Hex(),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(signingSecret(false))
+}
+
+// ValidateStreamToken validates a signed stream token
+func ValidateStreamToken(tokenString string) (*StreamClaims, error) {
+	token, err := parseWithClaims(tokenString, &StreamClaims{}, false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if claims, ok := token.Claims.(*StreamClaims); ok && token.Valid && claims.Issuer == "cloudstorage-stream" {
+		return claims, nil
+	}
+
+	return nil, errors.New("invalid stream token")
+}
+
+// GenerateUnsubscribeToken creates a signed token unsubscribing a user from
+// a list of emails until expiresAt
+func GenerateUnsubscribeToken(userID primitive.ObjectID, list string, expiresAt time.Time) (string, error) {
+	claims := &UnsubscribeClaims{
+		UserID: userID,
+		List:   list,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			NotBefore: jwt.NewNumericDate(time.Now()),
+			Issuer:    "cloudstorage-unsubscribe",
+			Subject:   userID.Hex(),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(signingSecret(false))
+}
+
+// ValidateUnsubscribeToken validates a signed unsubscribe token
+func ValidateUnsubscribeToken(tokenString string) (*UnsubscribeClaims, error) {
+	token, err := parseWithClaims(tokenString, &UnsubscribeClaims{}, false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if claims, ok := token.Claims.(*UnsubscribeClaims); ok && token.Valid && claims.Issuer == "cloudstorage-unsubscribe" {
+		return claims, nil
+	}
+
+	return nil, errors.New("invalid unsubscribe token")
+}
@@ -0,0 +1,814 @@
+package utils
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// MarkdownOptions configures rendering Markdown to HTML
+type MarkdownOptions struct {
+	// ImageURL maps the source of an image, as written, to the URL it is
+	// shown from, or to "" to show its alt text instead. Sources are used
+	// as written when it is nil.
+	ImageURL func(src string) string
+}
+
+// RenderMarkdown renders Markdown, CommonMark with GitHub's tables, task
+// lists, strikethrough and bare links, to HTML that is safe to embed in a
+// page: raw HTML is escaped, and links and images only keep http, https,
+// mailto and relative URLs.
+func RenderMarkdown(source string, opts MarkdownOptions) string {
+	source = strings.ReplaceAll(source, "\r\n", "\n")
+	source = strings.ReplaceAll(source, "\r", "\n")
+	source = strings.ReplaceAll(source, "\t", "    ")
+	source = strings.ReplaceAll(source, "\x00", "�")
+
+	r := &markdownRenderer{opts: opts, refs: make(map[string]markdownRef), headingIDs: make(map[string]int)}
+	lines := r.collectRefs(strings.Split(source, "\n"))
+
+	var b strings.Builder
+	r.blocks(&b, lines, false)
+	return b.String()
+}
+
+type markdownRef struct {
+	url, title string
+}
+
+type markdownRenderer struct {
+	opts       MarkdownOptions
+	refs       map[string]markdownRef
+	headingIDs map[string]int
+	inLink     bool
+}
+
+var (
+	mdFenceRe     = regexp.MustCompile("^ {0,3}(`{3,}|~{3,})[ ]*([^` ]*)")
+	mdHeadingRe   = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ ]+(.*?))?(?:[ ]+#+)?[ ]*$`)
+	mdRuleRe      = regexp.MustCompile(`^ {0,3}(?:(?:\*[ ]*){3,}|(?:-[ ]*){3,}|(?:_[ ]*){3,})$`)
+	mdSetextRe    = regexp.MustCompile(`^ {0,3}(=+|-+)[ ]*$`)
+	mdQuoteRe     = regexp.MustCompile(`^ {0,3}> ?`)
+	mdListItemRe  = regexp.MustCompile(`^( {0,3})([-*+]|\d{1,9}[.)])( {1,4}|$)`)
+	mdTaskRe      = regexp.MustCompile(`^\[([ xX])\] `)
+	mdTableRuleRe = regexp.MustCompile(`^ {0,3}\|?[ ]*:?-+:?[ ]*(\|[ ]*:?-+:?[ ]*)*\|?[ ]*$`)
+	mdRefDefRe    = regexp.MustCompile(`^ {0,3}\[([^\]]+)\]:[ ]*<?([^ >]+)>?(?:[ ]+(?:"([^"]*)"|'([^']*)'|\(([^)]*)\)))?[ ]*$`)
+	mdAutolinkRe  = regexp.MustCompile(`^<((?:https?://|mailto:)[^ <>]+)>`)
+	mdBareURLRe   = regexp.MustCompile(`^https?://[^ \n<]*[^ \n<.,:;"')\]!?*_~]`)
+)
+
+// collectRefs takes the link reference definitions out of the lines,
+// leaving those in code blocks
+func (r *markdownRenderer) collectRefs(lines []string) []string {
+	kept := lines[:0:0]
+	fence := ""
+	for _, line := range lines {
+		if m := mdFenceRe.FindStringSubmatch(line); m != nil {
+			if fence == "" {
+				fence = m[1]
+			} else if m[1][0] == fence[0] && len(m[1]) >= len(fence) {
+				fence = ""
+			}
+		}
+		if fence == "" {
+			if m := mdRefDefRe.FindStringSubmatch(line); m != nil {
+				label := normalizeRefLabel(m[1])
+				if _, ok := r.refs[label]; !ok {
+					r.refs[label] = markdownRef{url: m[2], title: m[3] + m[4] + m[5]}
+				}
+				continue
+			}
+		}
+		kept = append(kept, line)
+	}
+	return kept
+}
+
+func normalizeRefLabel(label string) string {
+	return strings.ToLower(strings.Join(strings.Fields(label), " "))
+}
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+func indentOf(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// startsBlock reports whether line starts a block other than a paragraph,
+// so it cannot continue one
+func startsBlock(line string) bool {
+	return mdFenceRe.MatchString(line) || mdHeadingRe.MatchString(line) || mdRuleRe.MatchString(line) ||
+		mdQuoteRe.MatchString(line) || mdListItemRe.MatchString(line)
+}
+
+// blocks renders lines as blocks. Paragraphs of tight list items are
+// rendered without <p>.
+func (r *markdownRenderer) blocks(b *strings.Builder, lines []string, tight bool) {
+	var para []string
+	flush := func() {
+		if len(para) == 0 {
+			return
+		}
+		text := strings.TrimSpace(strings.Join(para, "\n"))
+		if tight {
+			b.WriteString(r.inline(text))
+			b.WriteString("\n")
+		} else {
+			b.WriteString("<p>" + r.inline(text) + "</p>\n")
+		}
+		para = nil
+	}
+
+	for i := 0; i < len(lines); {
+		line := lines[i]
+
+		switch {
+		case isBlank(line):
+			flush()
+			i++
+
+		case len(para) > 0 && mdSetextRe.MatchString(line):
+			level := 2
+			if strings.TrimSpace(line)[0] == '=' {
+				level = 1
+			}
+			text := strings.TrimSpace(strings.Join(para, "\n"))
+			para = nil
+			r.heading(b, level, text)
+			i++
+
+		case mdFenceRe.MatchString(line):
+			flush()
+			i = r.fencedCode(b, lines, i)
+
+		case mdHeadingRe.MatchString(line):
+			flush()
+			m := mdHeadingRe.FindStringSubmatch(line)
+			r.heading(b, len(m[1]), m[2])
+			i++
+
+		case mdRuleRe.MatchString(line):
+			flush()
+			b.WriteString("<hr>\n")
+			i++
+
+		case mdQuoteRe.MatchString(line):
+			flush()
+			i = r.blockquote(b, lines, i)
+
+		case mdListItemRe.MatchString(line):
+			flush()
+			i = r.list(b, lines, i)
+
+		case len(para) == 0 && indentOf(line) >= 4:
+			i = r.indentedCode(b, lines, i)
+
+		case strings.Contains(line, "|") && i+1 < len(lines) && strings.Contains(lines[i+1], "-") && mdTableRuleRe.MatchString(lines[i+1]):
+			flush()
+			i = r.table(b, lines, i)
+
+		default:
+			para = append(para, line)
+			i++
+		}
+	}
+	flush()
+}
+
+func (r *markdownRenderer) heading(b *strings.Builder, level int, text string) {
+	text = strings.TrimSpace(text)
+	tag := "h" + strconv.Itoa(level)
+	b.WriteString("<" + tag + ` id="` + r.headingID(text) + `">` + r.inline(text) + "</" + tag + ">\n")
+}
+
+// headingID returns the anchor of a heading as GitHub makes it, so links
+// to sections of a README keep working
+func (r *markdownRenderer) headingID(text string) string {
+	var id strings.Builder
+	for _, c := range strings.ToLower(text) {
+		switch {
+		case unicode.IsLetter(c) || unicode.IsDigit(c) || c == '-' || c == '_':
+			id.WriteRune(c)
+		case c == ' ':
+			id.WriteByte('-')
+		}
+	}
+	slug := id.String()
+	if n := r.headingIDs[slug]; n > 0 {
+		r.headingIDs[slug]++
+		return html.EscapeString(slug + "-" + strconv.Itoa(n))
+	}
+	r.headingIDs[slug] = 1
+	return html.EscapeString(slug)
+}
+
+func (r *markdownRenderer) fencedCode(b *strings.Builder, lines []string, i int) int {
+	m := mdFenceRe.FindStringSubmatch(lines[i])
+	fence, indent := m[1], indentOf(lines[i])
+
+	b.WriteString("<pre><code")
+	if language := strings.Map(func(c rune) rune {
+		if unicode.IsLetter(c) || unicode.IsDigit(c) || strings.ContainsRune("+-_#.", c) {
+			return c
+		}
+		return -1
+	}, m[2]); language != "" {
+		b.WriteString(` class="language-` + html.EscapeString(language) + `"`)
+	}
+	b.WriteString(">")
+
+	for i++; i < len(lines); i++ {
+		if m := mdFenceRe.FindStringSubmatch(lines[i]); m != nil && m[2] == "" && m[1][0] == fence[0] && len(m[1]) >= len(fence) {
+			i++
+			break
+		}
+		line := lines[i]
+		line = line[min(indent, indentOf(line)):]
+		b.WriteString(html.EscapeString(line) + "\n")
+	}
+	b.WriteString("</code></pre>\n")
+	return i
+}
+
+func (r *markdownRenderer) indentedCode(b *strings.Builder, lines []string, i int) int {
+	var code []string
+	for ; i < len(lines) && (isBlank(lines[i]) || indentOf(lines[i]) >= 4); i++ {
+		code = append(code, lines[i][min(4, len(lines[i])):])
+	}
+	for len(code) > 0 && isBlank(code[len(code)-1]) {
+		code = code[:len(code)-1]
+	}
+	b.WriteString("<pre><code>" + html.EscapeString(strings.Join(code, "\n")) + "\n</code></pre>\n")
+	return i
+}
+
+func (r *markdownRenderer) blockquote(b *strings.Builder, lines []string, i int) int {
+	var quoted []string
+	for ; i < len(lines); i++ {
+		line := lines[i]
+		if m := mdQuoteRe.FindString(line); m != "" {
+			quoted = append(quoted, line[len(m):])
+			continue
+		}
+		// A paragraph in the quote goes on without the >
+		if isBlank(line) || startsBlock(line) || len(quoted) == 0 || isBlank(quoted[len(quoted)-1]) {
+			break
+		}
+		quoted = append(quoted, line)
+	}
+	b.WriteString("<blockquote>\n")
+	r.blocks(b, quoted, false)
+	b.WriteString("</blockquote>\n")
+	return i
+}
+
+// list renders the list starting at lines[i]. Items continue with the
+// lines indented to their content, and with paragraph lines that are not.
+func (r *markdownRenderer) list(b *strings.Builder, lines []string, i int) int {
+	first := mdListItemRe.FindStringSubmatch(lines[i])
+	marker := first[2]
+	ordered := marker[0] >= '0' && marker[0] <= '9'
+	sameList := func(m []string) bool {
+		if ordered {
+			return m[2][0] >= '0' && m[2][0] <= '9' && m[2][len(m[2])-1] == marker[len(marker)-1]
+		}
+		return m[2] == marker
+	}
+
+	var items [][]string
+	indent, loose := 0, false
+	for i < len(lines) {
+		line := lines[i]
+		if m := mdListItemRe.FindStringSubmatch(line); m != nil && (len(items) == 0 || indentOf(line) < indent) {
+			if !sameList(m) {
+				break
+			}
+			indent = len(m[0])
+			if m[3] == "" {
+				indent++
+			}
+			items = append(items, []string{line[min(indent, len(line)):]})
+			i++
+			continue
+		}
+
+		item := items[len(items)-1]
+		switch {
+		case isBlank(line):
+			next := i + 1
+			for next < len(lines) && isBlank(lines[next]) {
+				next++
+			}
+			if next == len(lines) {
+				return r.renderList(b, items, ordered, first[2], loose, next)
+			}
+			m := mdListItemRe.FindStringSubmatch(lines[next])
+			if indentOf(lines[next]) < indent && (m == nil || !sameList(m)) {
+				return r.renderList(b, items, ordered, first[2], loose, i)
+			}
+			// Blank lines between items, or between blocks of an item,
+			// make the list loose
+			loose = true
+			items[len(items)-1] = append(item, "")
+			i++
+		case indentOf(line) >= indent:
+			items[len(items)-1] = append(item, line[indent:])
+			i++
+		case !startsBlock(line) && len(item) > 0 && !isBlank(item[len(item)-1]):
+			items[len(items)-1] = append(item, strings.TrimLeft(line, " "))
+			i++
+		default:
+			return r.renderList(b, items, ordered, first[2], loose, i)
+		}
+	}
+	return r.renderList(b, items, ordered, first[2], loose, i)
+}
+
+func (r *markdownRenderer) renderList(b *strings.Builder, items [][]string, ordered bool, marker string, loose bool, next int) int {
+	tag := "ul"
+	if ordered {
+		tag = "ol"
+		start, _ := strconv.Atoi(marker[:len(marker)-1])
+		if start != 1 {
+			b.WriteString(`<ol start="` + strconv.Itoa(start) + `">` + "\n")
+		} else {
+			b.WriteString("<ol>\n")
+		}
+	} else {
+		b.WriteString("<ul>\n")
+	}
+
+	for _, item := range items {
+		b.WriteString("<li>")
+		if m := mdTaskRe.FindStringSubmatch(item[0]); m != nil {
+			if m[1] == " " {
+				b.WriteString(`<input type="checkbox" disabled> `)
+			} else {
+				b.WriteString(`<input type="checkbox" checked disabled> `)
+			}
+			item = append([]string{item[0][len(m[0]):]}, item[1:]...)
+		}
+		r.blocks(b, item, !loose)
+		b.WriteString("</li>\n")
+	}
+	b.WriteString("</" + tag + ">\n")
+	return next
+}
+
+func (r *markdownRenderer) table(b *strings.Builder, lines []string, i int) int {
+	header := splitTableRow(lines[i])
+	var aligns []string
+	for _, cell := range splitTableRow(lines[i+1]) {
+		switch {
+		case strings.HasPrefix(cell, ":") && strings.HasSuffix(cell, ":"):
+			aligns = append(aligns, "center")
+		case strings.HasSuffix(cell, ":"):
+			aligns = append(aligns, "right")
+		case strings.HasPrefix(cell, ":"):
+			aligns = append(aligns, "left")
+		default:
+			aligns = append(aligns, "")
+		}
+	}
+
+	row := func(cells []string, tag string) {
+		b.WriteString("<tr>")
+		for n := range header {
+			cell := ""
+			if n < len(cells) {
+				cell = cells[n]
+			}
+			b.WriteString("<" + tag)
+			if n < len(aligns) && aligns[n] != "" {
+				b.WriteString(` style="text-align: ` + aligns[n] + `"`)
+			}
+			b.WriteString(">" + r.inline(cell) + "</" + tag + ">")
+		}
+		b.WriteString("</tr>\n")
+	}
+
+	b.WriteString("<table>\n<thead>\n")
+	row(header, "th")
+	b.WriteString("</thead>\n")
+
+	i += 2
+	if i < len(lines) && !isBlank(lines[i]) && !startsBlock(lines[i]) {
+		b.WriteString("<tbody>\n")
+		for ; i < len(lines) && !isBlank(lines[i]) && !startsBlock(lines[i]); i++ {
+			row(splitTableRow(lines[i]), "td")
+		}
+		b.WriteString("</tbody>\n")
+	}
+	b.WriteString("</table>\n")
+	return i
+}
+
+// splitTableRow splits a table row at the pipes that are not escaped
+func splitTableRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, `\|`) {
+		line = line[:len(line)-1]
+	}
+
+	var cells []string
+	var cell strings.Builder
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && i+1 < len(line) && line[i+1] == '|':
+			cell.WriteByte('|')
+			i++
+		case line[i] == '|':
+			cells = append(cells, strings.TrimSpace(cell.String()))
+			cell.Reset()
+		default:
+			cell.WriteByte(line[i])
+		}
+	}
+	return append(cells, strings.TrimSpace(cell.String()))
+}
+
+// inline renders the inline content of a block
+func (r *markdownRenderer) inline(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && s[i+1] == '\n':
+			b.WriteString("<br>\n")
+			i += 2
+			continue
+		case c == '\\' && i+1 < len(s) && isASCIIPunct(s[i+1]):
+			b.WriteString(html.EscapeString(s[i+1 : i+2]))
+			i += 2
+			continue
+		case c == '\n':
+			if strings.HasSuffix(s[:i], "  ") {
+				b.WriteString("<br>")
+			}
+			b.WriteByte('\n')
+			i++
+			continue
+		case c == '`':
+			if code, end := codeSpan(s, i); end > 0 {
+				b.WriteString("<code>" + html.EscapeString(code) + "</code>")
+				i = end
+				continue
+			}
+			n := runLength(s, i)
+			b.WriteString(s[i : i+n])
+			i += n
+			continue
+		case c == '!' && i+1 < len(s) && s[i+1] == '[':
+			if end := r.linkOrImage(&b, s, i+1, true); end > 0 {
+				i = end
+				continue
+			}
+		case c == '[' && !r.inLink:
+			if end := r.linkOrImage(&b, s, i, false); end > 0 {
+				i = end
+				continue
+			}
+		case c == '<' && !r.inLink:
+			if m := mdAutolinkRe.FindStringSubmatch(s[i:]); m != nil {
+				b.WriteString(r.anchor(m[1], "", html.EscapeString(m[1])))
+				i += len(m[0])
+				continue
+			}
+		case c == 'h' && !r.inLink && (i == 0 || !isWordByte(s[i-1])):
+			if m := mdBareURLRe.FindString(s[i:]); m != "" {
+				b.WriteString(r.anchor(m, "", html.EscapeString(m)))
+				i += len(m)
+				continue
+			}
+		case c == '*' || c == '_' || c == '~':
+			if end := r.emphasis(&b, s, i); end > 0 {
+				i = end
+				continue
+			}
+			n := runLength(s, i)
+			b.WriteString(s[i : i+n])
+			i += n
+			continue
+		}
+		b.WriteString(html.EscapeString(s[i : i+1]))
+		i++
+	}
+	return b.String()
+}
+
+func isASCIIPunct(c byte) bool {
+	return c < 0x80 && unicode.IsPunct(rune(c)) || strings.IndexByte("$+<=>^`|~", c) >= 0
+}
+
+func isWordByte(c byte) bool {
+	return c >= 0x80 || c == '_' || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c))
+}
+
+func runLength(s string, i int) int {
+	n := 1
+	for i+n < len(s) && s[i+n] == s[i] {
+		n++
+	}
+	return n
+}
+
+// codeSpan returns the content of the code span starting at s[i] and where
+// it ends, or an end of 0 when its backticks are not closed
+func codeSpan(s string, i int) (string, int) {
+	n := runLength(s, i)
+	for j := i + n; j < len(s); {
+		if s[j] != '`' {
+			j++
+			continue
+		}
+		m := runLength(s, j)
+		if m == n {
+			code := strings.ReplaceAll(s[i+n:j], "\n", " ")
+			if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.TrimSpace(code) != "" {
+				code = code[1 : len(code)-1]
+			}
+			return code, j + m
+		}
+		j += m
+	}
+	return "", 0
+}
+
+// closingBracket returns the index of the ] closing the [ at s[i], or -1
+func closingBracket(s string, i int) int {
+	depth := 0
+	for j := i; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case '`':
+			if _, end := codeSpan(s, j); end > 0 {
+				j = end - 1
+			}
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				return j
+			}
+		}
+	}
+	return -1
+}
+
+// linkOrImage renders the link, or image, whose text starts with the [ at
+// s[i] and returns where it ends, or 0 when there is none
+func (r *markdownRenderer) linkOrImage(b *strings.Builder, s string, i int, image bool) int {
+	close := closingBracket(s, i)
+	if close < 0 {
+		return 0
+	}
+	text := s[i+1 : close]
+
+	var dest, title string
+	end := close + 1
+	switch {
+	case end < len(s) && s[end] == '(':
+		var ok bool
+		if dest, title, end, ok = linkDestination(s, end+1); !ok {
+			return 0
+		}
+	default:
+		label := text
+		if end+1 < len(s) && s[end] == '[' {
+			if labelEnd := strings.IndexByte(s[end+1:], ']'); labelEnd >= 0 {
+				if l := s[end+1 : end+1+labelEnd]; l != "" {
+					label = l
+				}
+				end += labelEnd + 2
+			}
+		}
+		ref, ok := r.refs[normalizeRefLabel(label)]
+		if !ok {
+			return 0
+		}
+		dest, title = ref.url, ref.title
+	}
+
+	if image {
+		alt := html.EscapeString(plainText(text))
+		src := dest
+		if r.opts.ImageURL != nil {
+			src = r.opts.ImageURL(dest)
+		}
+		if src = safeURL(src); src == "" {
+			b.WriteString(alt)
+			return end
+		}
+		b.WriteString(`<img src="` + html.EscapeString(src) + `" alt="` + alt + `"`)
+		if title != "" {
+			b.WriteString(` title="` + html.EscapeString(title) + `"`)
+		}
+		b.WriteString(` loading="lazy">`)
+		return end
+	}
+
+	r.inLink = true
+	inner := r.inline(text)
+	r.inLink = false
+	b.WriteString(r.anchor(dest, title, inner))
+	return end
+}
+
+// linkDestination parses the destination and title of an inline link,
+// starting after its (
+func linkDestination(s string, i int) (string, string, int, bool) {
+	for i < len(s) && (s[i] == ' ' || s[i] == '\n') {
+		i++
+	}
+
+	var dest string
+	if i < len(s) && s[i] == '<' {
+		end := strings.IndexAny(s[i:], ">\n")
+		if end < 0 || s[i+end] != '>' {
+			return "", "", 0, false
+		}
+		dest, i = s[i+1:i+end], i+end+1
+	} else {
+		start, depth := i, 0
+		for ; i < len(s) && s[i] != ' ' && s[i] != '\n'; i++ {
+			if s[i] == '(' {
+				depth++
+			} else if s[i] == ')' {
+				if depth == 0 {
+					break
+				}
+				depth--
+			}
+		}
+		dest = s[start:i]
+	}
+
+	for i < len(s) && (s[i] == ' ' || s[i] == '\n') {
+		i++
+	}
+	var title string
+	if i < len(s) && (s[i] == '"' || s[i] == '\'' || s[i] == '(') {
+		quote := s[i]
+		if quote == '(' {
+			quote = ')'
+		}
+		end := strings.IndexByte(s[i+1:], quote)
+		if end < 0 {
+			return "", "", 0, false
+		}
+		title, i = s[i+1:i+1+end], i+end+2
+		for i < len(s) && (s[i] == ' ' || s[i] == '\n') {
+			i++
+		}
+	}
+	if i >= len(s) || s[i] != ')' {
+		return "", "", 0, false
+	}
+	return dest, title, i + 1, true
+}
+
+func (r *markdownRenderer) anchor(href, title, inner string) string {
+	href = safeURL(href)
+	if href == "" {
+		return inner
+	}
+	a := `<a href="` + html.EscapeString(href) + `"`
+	if title != "" {
+		a += ` title="` + html.EscapeString(title) + `"`
+	}
+	if !strings.HasPrefix(href, "#") {
+		a += ` rel="nofollow noopener noreferrer" target="_blank"`
+	}
+	return a + ">" + inner + "</a>"
+}
+
+// safeURL returns u when it is an http, https, mailto or relative URL, and
+// "" otherwise, so links cannot run script
+func safeURL(u string) string {
+	u = strings.TrimSpace(u)
+	if u == "" {
+		return ""
+	}
+	end := strings.IndexAny(u, "/?#")
+	if end < 0 {
+		end = len(u)
+	}
+	if colon := strings.IndexByte(u[:end], ':'); colon >= 0 {
+		switch strings.ToLower(u[:colon]) {
+		case "http", "https", "mailto":
+		default:
+			return ""
+		}
+	}
+	return u
+}
+
+// plainText strips the Markdown from the text of an image, for its alt
+func plainText(s string) string {
+	return strings.Map(func(c rune) rune {
+		if strings.ContainsRune("*_~`[]\\", c) {
+			return -1
+		}
+		return c
+	}, s)
+}
+
+// emphasis renders the emphasis, strong emphasis or strikethrough opening
+// with the delimiter run at s[i] and returns where it ends, or 0 when the
+// run opens none
+func (r *markdownRenderer) emphasis(b *strings.Builder, s string, i int) int {
+	n := runLength(s, i)
+	if !leftFlanking(s, i, n) {
+		return 0
+	}
+	if s[i] == '~' {
+		if n != 2 {
+			return 0
+		}
+		close := closingDelimiter(s, i+n, '~', 2)
+		if close < 0 {
+			return 0
+		}
+		b.WriteString("<del>" + r.inline(s[i+2:close]) + "</del>")
+		return close + 2
+	}
+
+	for use := min(n, 3); use > 0; use-- {
+		close := closingDelimiter(s, i+n, s[i], use)
+		if close < 0 {
+			continue
+		}
+		// Delimiters beyond those used are text
+		b.WriteString(s[i : i+n-use])
+		inner := r.inline(s[i+n : close])
+		switch use {
+		case 3:
+			b.WriteString("<em><strong>" + inner + "</strong></em>")
+		case 2:
+			b.WriteString("<strong>" + inner + "</strong>")
+		default:
+			b.WriteString("<em>" + inner + "</em>")
+		}
+		return close + use
+	}
+	return 0
+}
+
+// leftFlanking reports whether the delimiter run of n at s[i] can open
+// emphasis. Underscores inside words cannot, as in snake_case.
+func leftFlanking(s string, i, n int) bool {
+	if i+n >= len(s) || s[i+n] == ' ' || s[i+n] == '\n' {
+		return false
+	}
+	return s[i] != '_' || i == 0 || !isWordByte(s[i-1])
+}
+
+// rightFlanking reports whether the delimiter run of n at s[i] can close
+// emphasis
+func rightFlanking(s string, i, n int) bool {
+	if i == 0 || s[i-1] == ' ' || s[i-1] == '\n' {
+		return false
+	}
+	return s[i] != '_' || i+n >= len(s) || !isWordByte(s[i+n])
+}
+
+// closingDelimiter returns where the run of at least use delim characters
+// that closes emphasis opened before s[from] starts, skipping code spans
+// and the emphasis opened in between, or -1
+func closingDelimiter(s string, from int, delim byte, use int) int {
+	for j := from; j < len(s); {
+		switch {
+		case s[j] == '\\':
+			j += 2
+		case s[j] == '`':
+			if _, end := codeSpan(s, j); end > 0 {
+				j = end
+			} else {
+				j += runLength(s, j)
+			}
+		case s[j] == delim:
+			m := runLength(s, j)
+			if rightFlanking(s, j, m) && m >= use {
+				return j + m - use
+			}
+			// A run that only opens is closed by a later run first
+			if leftFlanking(s, j, m) {
+				if inner := closingDelimiter(s, j+m, delim, min(m, 3)); inner >= 0 {
+					j = inner + min(m, 3)
+					continue
+				}
+			}
+			j += m
+		default:
+			j++
+		}
+	}
+	return -1
+}
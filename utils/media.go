@@ -0,0 +1,709 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
+	"oncloud/models"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// ExtractMediaMetadata reads embedded metadata from file content: EXIF from
+// JPEG and TIFF-based images, ID3 tags from MP3 audio and movie headers from
+// MP4/QuickTime video. The format is detected from the content itself. It
+// returns nil when nothing useful is found.
+func ExtractMediaMetadata(content []byte) (media *models.MediaMetadata) {
+	// The parsers check bounds, but a malformed upload must never take the
+	// upload down with it
+	defer func() {
+		if recover() != nil {
+			media = nil
+		}
+	}()
+
+	media = &models.MediaMetadata{}
+	switch {
+	case bytes.HasPrefix(content, []byte{0xFF, 0xD8}):
+		if tiff := findJPEGExif(content); tiff != nil {
+			parseExif(tiff, media)
+		}
+		setImageDimensions(content, media)
+	case bytes.HasPrefix(content, []byte("II*\x00")), bytes.HasPrefix(content, []byte("MM\x00*")):
+		parseExif(content, media)
+	case bytes.HasPrefix(content, []byte("\x89PNG")), bytes.HasPrefix(content, []byte("GIF8")):
+		setImageDimensions(content, media)
+	case bytes.HasPrefix(content, []byte("ID3")), isMPEGFrame(content, 0):
+		parseMP3(content, media)
+	case len(content) >= 12 && string(content[4:8]) == "ftyp":
+		parseMP4(content, media)
+	default:
+		return nil
+	}
+
+	if *media == (models.MediaMetadata{}) {
+		return nil
+	}
+	return media
+}
+
+func setImageDimensions(content []byte, media *models.MediaMetadata) {
+	if config, _, err := image.DecodeConfig(bytes.NewReader(content)); err == nil {
+		media.Width = config.Width
+		media.Height = config.Height
+	}
+}
+
+// EXIF
+
+// findJPEGExif returns the TIFF structure inside a JPEG's APP1 Exif segment
+func findJPEGExif(content []byte) []byte {
+	for i := 2; i+4 <= len(content); {
+		if content[i] != 0xFF {
+			return nil
+		}
+		marker := content[i+1]
+		if marker == 0xD8 || marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			i += 2
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 {
+			return nil // start of image data, no EXIF before it
+		}
+		length := int(binary.BigEndian.Uint16(content[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(content) {
+			return nil
+		}
+		segment := content[i+4 : end]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:]
+		}
+		i = end
+	}
+	return nil
+}
+
+const (
+	exifTagImageWidth        = 0x0100
+	exifTagImageHeight       = 0x0101
+	exifTagMake              = 0x010F
+	exifTagModel             = 0x0110
+	exifTagOrientation       = 0x0112
+	exifTagDateTime          = 0x0132
+	exifTagExposureTime      = 0x829A
+	exifTagFNumber           = 0x829D
+	exifTagExifIFD           = 0x8769
+	exifTagGPSIFD            = 0x8825
+	exifTagISO               = 0x8827
+	exifTagDateTimeOriginal  = 0x9003
+	exifTagDateTimeDigitized = 0x9004
+	exifTagOffsetOriginal    = 0x9011
+	exifTagFocalLength       = 0x920A
+	exifTagPixelXDimension   = 0xA002
+	exifTagPixelYDimension   = 0xA003
+	exifTagLensModel         = 0xA434
+
+	gpsTagLatitudeRef  = 0x0001
+	gpsTagLatitude     = 0x0002
+	gpsTagLongitudeRef = 0x0003
+	gpsTagLongitude    = 0x0004
+	gpsTagAltitudeRef  = 0x0005
+	gpsTagAltitude     = 0x0006
+)
+
+// exifTypeSizes is the byte size of each TIFF field type
+var exifTypeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+type exifEntry struct {
+	typ   uint16
+	count int
+	value []byte
+}
+
+type tiffReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+func parseExif(data []byte, media *models.MediaMetadata) {
+	if len(data) < 8 {
+		return
+	}
+	t := &tiffReader{data: data, order: binary.LittleEndian}
+	if data[0] == 'M' {
+		t.order = binary.BigEndian
+	}
+
+	ifd0 := t.readIFD(t.order.Uint32(data[4:]))
+	media.CameraMake = t.ascii(ifd0[exifTagMake])
+	media.CameraModel = t.ascii(ifd0[exifTagModel])
+	media.Orientation = t.int(ifd0[exifTagOrientation])
+	media.Width = t.int(ifd0[exifTagImageWidth])
+	media.Height = t.int(ifd0[exifTagImageHeight])
+
+	exif := map[uint16]exifEntry{}
+	if offset := t.int(ifd0[exifTagExifIFD]); offset > 0 {
+		exif = t.readIFD(uint32(offset))
+	}
+	if w, h := t.int(exif[exifTagPixelXDimension]), t.int(exif[exifTagPixelYDimension]); w > 0 && h > 0 {
+		media.Width, media.Height = w, h
+	}
+	media.LensModel = t.ascii(exif[exifTagLensModel])
+	media.ISO = t.int(exif[exifTagISO])
+	if v := t.rationals(exif[exifTagFNumber]); len(v) > 0 {
+		media.FNumber = math.Round(v[0]*10) / 10
+	}
+	if v := t.rationals(exif[exifTagFocalLength]); len(v) > 0 {
+		media.FocalLength = math.Round(v[0]*10) / 10
+	}
+	if v := t.rationals(exif[exifTagExposureTime]); len(v) > 0 && v[0] > 0 {
+		if v[0] < 1 {
+			media.ExposureTime = fmt.Sprintf("1/%d", int(math.Round(1/v[0])))
+		} else {
+			media.ExposureTime = strconv.FormatFloat(v[0], 'f', -1, 64)
+		}
+	}
+
+	offset := t.ascii(exif[exifTagOffsetOriginal])
+	for _, date := range []string{
+		t.ascii(exif[exifTagDateTimeOriginal]),
+		t.ascii(exif[exifTagDateTimeDigitized]),
+		t.ascii(ifd0[exifTagDateTime]),
+	} {
+		if capturedAt := parseExifDate(date, offset); capturedAt != nil {
+			media.CapturedAt = capturedAt
+			break
+		}
+	}
+
+	if offset := t.int(ifd0[exifTagGPSIFD]); offset > 0 {
+		media.Location = t.gpsLocation(t.readIFD(uint32(offset)))
+	}
+}
+
+func (t *tiffReader) readIFD(offset uint32) map[uint16]exifEntry {
+	entries := make(map[uint16]exifEntry)
+	start := int(offset)
+	if start < 8 || start+2 > len(t.data) {
+		return entries
+	}
+
+	count := int(t.order.Uint16(t.data[start:]))
+	for i := 0; i < count; i++ {
+		pos := start + 2 + i*12
+		if pos+12 > len(t.data) {
+			break
+		}
+		tag := t.order.Uint16(t.data[pos:])
+		typ := t.order.Uint16(t.data[pos+2:])
+		n := int(t.order.Uint32(t.data[pos+4:]))
+		size, ok := exifTypeSizes[typ]
+		if !ok || n <= 0 || n > len(t.data) {
+			continue
+		}
+
+		// Values of up to four bytes are stored inline, larger ones at an offset
+		value := t.data[pos+8 : pos+12]
+		if total := size * n; total > 4 {
+			at := int(t.order.Uint32(t.data[pos+8:]))
+			if at < 0 || at+total > len(t.data) {
+				continue
+			}
+			value = t.data[at : at+total]
+		}
+		entries[tag] = exifEntry{typ: typ, count: n, value: value}
+	}
+	return entries
+}
+
+func (t *tiffReader) ascii(e exifEntry) string {
+	if e.typ != 2 {
+		return ""
+	}
+	value := e.value
+	if i := bytes.IndexByte(value, 0); i >= 0 {
+		value = value[:i]
+	}
+	return strings.TrimSpace(string(value))
+}
+
+func (t *tiffReader) int(e exifEntry) int {
+	switch e.typ {
+	case 1, 7:
+		return int(e.value[0])
+	case 3:
+		return int(t.order.Uint16(e.value))
+	case 4, 9:
+		return int(t.order.Uint32(e.value))
+	}
+	return 0
+}
+
+func (t *tiffReader) rationals(e exifEntry) []float64 {
+	if e.typ != 5 && e.typ != 10 {
+		return nil
+	}
+	values := make([]float64, 0, e.count)
+	for i := 0; i+8 <= len(e.value); i += 8 {
+		num, den := float64(t.order.Uint32(e.value[i:])), float64(t.order.Uint32(e.value[i+4:]))
+		if e.typ == 10 {
+			num, den = float64(int32(t.order.Uint32(e.value[i:]))), float64(int32(t.order.Uint32(e.value[i+4:])))
+		}
+		if den == 0 {
+			return nil
+		}
+		values = append(values, num/den)
+	}
+	return values
+}
+
+func (t *tiffReader) gpsLocation(gps map[uint16]exifEntry) *models.MediaLocation {
+	lat, lon := t.rationals(gps[gpsTagLatitude]), t.rationals(gps[gpsTagLongitude])
+	if len(lat) < 3 || len(lon) < 3 {
+		return nil
+	}
+
+	location := &models.MediaLocation{
+		Latitude:  lat[0] + lat[1]/60 + lat[2]/3600,
+		Longitude: lon[0] + lon[1]/60 + lon[2]/3600,
+	}
+	if t.ascii(gps[gpsTagLatitudeRef]) == "S" {
+		location.Latitude = -location.Latitude
+	}
+	if t.ascii(gps[gpsTagLongitudeRef]) == "W" {
+		location.Longitude = -location.Longitude
+	}
+	if alt := t.rationals(gps[gpsTagAltitude]); len(alt) > 0 {
+		altitude := alt[0]
+		if t.int(gps[gpsTagAltitudeRef]) == 1 {
+			altitude = -altitude
+		}
+		location.Altitude = &altitude
+	}
+
+	return validLocation(location)
+}
+
+// parseExifDate parses an EXIF "2006:01:02 15:04:05" date. Without an offset
+// the camera's local time is kept as if it were UTC.
+func parseExifDate(value, offset string) *time.Time {
+	if value == "" || strings.HasPrefix(value, "0000") {
+		return nil
+	}
+	layout := "2006:01:02 15:04:05"
+	if offset != "" {
+		value += offset
+		layout += "-07:00"
+	}
+	t, err := time.Parse(layout, value)
+	if err != nil {
+		return nil
+	}
+	t = t.UTC()
+	return &t
+}
+
+// validLocation drops coordinates that are out of range or the 0,0 some
+// devices write without a GPS fix
+func validLocation(location *models.MediaLocation) *models.MediaLocation {
+	if math.Abs(location.Latitude) > 90 || math.Abs(location.Longitude) > 180 ||
+		(location.Latitude == 0 && location.Longitude == 0) {
+		return nil
+	}
+	return location
+}
+
+// ID3 and MPEG audio
+
+func parseMP3(content []byte, media *models.MediaMetadata) {
+	media.AudioCodec = "mp3"
+
+	audioStart := 0
+	if bytes.HasPrefix(content, []byte("ID3")) && len(content) >= 10 {
+		size := synchsafe(content[6:10])
+		audioStart = 10 + size
+		if content[5]&0x10 != 0 {
+			audioStart += 10 // footer
+		}
+		if audioStart <= len(content) {
+			parseID3v2(content[:audioStart], media)
+		}
+	}
+
+	audioEnd := len(content)
+	if len(content) >= 128 && bytes.HasPrefix(content[len(content)-128:], []byte("TAG")) {
+		audioEnd -= 128
+		parseID3v1(content[audioEnd:], media)
+	}
+
+	if media.Duration == 0 && audioStart < audioEnd {
+		media.Duration = mp3Duration(content[audioStart:audioEnd])
+	}
+}
+
+func parseID3v2(tag []byte, media *models.MediaMetadata) {
+	version := tag[3]
+	pos := 10
+	if tag[5]&0x40 != 0 && len(tag) >= 14 {
+		// Extended header: v2.4 counts the size bytes, v2.3 does not
+		if version == 4 {
+			pos += synchsafe(tag[10:14])
+		} else {
+			pos += 4 + int(binary.BigEndian.Uint32(tag[10:14]))
+		}
+	}
+
+	idLen, headerLen := 4, 10
+	if version == 2 {
+		idLen, headerLen = 3, 6
+	}
+
+	for pos+headerLen <= len(tag) {
+		id := string(tag[pos : pos+idLen])
+		if tag[pos] == 0 {
+			break // padding
+		}
+		var size int
+		switch version {
+		case 2:
+			size = int(tag[pos+3])<<16 | int(tag[pos+4])<<8 | int(tag[pos+5])
+		case 4:
+			size = synchsafe(tag[pos+4 : pos+8])
+		default:
+			size = int(binary.BigEndian.Uint32(tag[pos+4:]))
+		}
+		start := pos + headerLen
+		if size <= 0 || start+size > len(tag) {
+			break
+		}
+		frame := tag[start : start+size]
+		pos = start + size
+
+		switch id {
+		case "TIT2", "TT2":
+			media.Title = id3Text(frame)
+		case "TPE1", "TP1":
+			media.Artist = id3Text(frame)
+		case "TALB", "TAL":
+			media.Album = id3Text(frame)
+		case "TYER", "TYE", "TDRC":
+			if year := leadingYear(id3Text(frame)); year > 0 {
+				media.Year = year
+			}
+		case "TLEN", "TLE":
+			if ms, err := strconv.Atoi(id3Text(frame)); err == nil && ms > 0 {
+				media.Duration = float64(ms) / 1000
+			}
+		}
+	}
+}
+
+// parseID3v1 fills in anything the ID3v2 tag did not provide
+func parseID3v1(tag []byte, media *models.MediaMetadata) {
+	field := func(from, to int) string {
+		return strings.TrimSpace(strings.TrimRight(latin1(tag[from:to]), "\x00"))
+	}
+	if media.Title == "" {
+		media.Title = field(3, 33)
+	}
+	if media.Artist == "" {
+		media.Artist = field(33, 63)
+	}
+	if media.Album == "" {
+		media.Album = field(63, 93)
+	}
+	if media.Year == 0 {
+		media.Year = leadingYear(field(93, 97))
+	}
+}
+
+// id3Text decodes a text frame according to its encoding byte
+func id3Text(frame []byte) string {
+	if len(frame) < 2 {
+		return ""
+	}
+	encoding, data := frame[0], frame[1:]
+
+	var text string
+	switch encoding {
+	case 1, 2:
+		var order binary.ByteOrder = binary.BigEndian
+		if encoding == 1 && len(data) >= 2 {
+			if data[0] == 0xFF && data[1] == 0xFE {
+				order = binary.LittleEndian
+			}
+			if (data[0] == 0xFF && data[1] == 0xFE) || (data[0] == 0xFE && data[1] == 0xFF) {
+				data = data[2:]
+			}
+		}
+		units := make([]uint16, 0, len(data)/2)
+		for i := 0; i+1 < len(data); i += 2 {
+			units = append(units, order.Uint16(data[i:]))
+		}
+		text = string(utf16.Decode(units))
+	case 3:
+		text = string(data)
+	default:
+		text = latin1(data)
+	}
+
+	// Multiple values are separated by NUL; keep the first
+	if i := strings.IndexByte(text, 0); i >= 0 {
+		text = text[:i]
+	}
+	return strings.TrimSpace(text)
+}
+
+func latin1(data []byte) string {
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
+
+func synchsafe(b []byte) int {
+	return int(b[0]&0x7F)<<21 | int(b[1]&0x7F)<<14 | int(b[2]&0x7F)<<7 | int(b[3]&0x7F)
+}
+
+func leadingYear(value string) int {
+	if len(value) < 4 {
+		return 0
+	}
+	year, err := strconv.Atoi(value[:4])
+	if err != nil || year < 1000 {
+		return 0
+	}
+	return year
+}
+
+var (
+	mp3Bitrates       = [2][16]int{{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0}, {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0}}
+	mp3SampleRates    = [3]int{44100, 48000, 32000}
+	mp3FrameSyncRange = 64 * 1024
+)
+
+// isMPEGFrame reports whether an MPEG audio layer III frame header starts at pos
+func isMPEGFrame(content []byte, pos int) bool {
+	if pos+4 > len(content) {
+		return false
+	}
+	h := content[pos : pos+4]
+	return h[0] == 0xFF && h[1]&0xE0 == 0xE0 &&
+		(h[1]>>3)&3 != 1 && (h[1]>>1)&3 == 1 &&
+		h[2]>>4 != 0 && h[2]>>4 != 15 && (h[2]>>2)&3 != 3
+}
+
+// mp3Duration uses the Xing/Info frame count of VBR files, or the bitrate of
+// the first frame for constant bitrate files
+func mp3Duration(audio []byte) float64 {
+	pos := 0
+	for pos < len(audio) && pos < mp3FrameSyncRange && !isMPEGFrame(audio, pos) {
+		pos++
+	}
+	if !isMPEGFrame(audio, pos) {
+		return 0
+	}
+
+	h := audio[pos : pos+4]
+	mpeg1 := (h[1]>>3)&3 == 3
+	sampleRate := mp3SampleRates[(h[2]>>2)&3]
+	samplesPerFrame := 1152
+	bitrates := mp3Bitrates[0]
+	if !mpeg1 {
+		samplesPerFrame = 576
+		bitrates = mp3Bitrates[1]
+		sampleRate /= 2
+		if (h[1]>>3)&3 == 0 {
+			sampleRate /= 2 // MPEG 2.5
+		}
+	}
+	mono := h[3]>>6 == 3
+
+	sideInfo := 32
+	switch {
+	case mpeg1 && mono:
+		sideInfo = 17
+	case !mpeg1 && !mono:
+		sideInfo = 17
+	case !mpeg1 && mono:
+		sideInfo = 9
+	}
+	xing := pos + 4 + sideInfo
+	if xing+12 <= len(audio) {
+		if id := string(audio[xing : xing+4]); id == "Xing" || id == "Info" {
+			if flags := binary.BigEndian.Uint32(audio[xing+4:]); flags&1 != 0 {
+				frames := binary.BigEndian.Uint32(audio[xing+8:])
+				return math.Round(float64(frames)*float64(samplesPerFrame)/float64(sampleRate)*100) / 100
+			}
+		}
+	}
+
+	bitrate := bitrates[h[2]>>4] * 1000
+	if bitrate == 0 {
+		return 0
+	}
+	return math.Round(float64(len(audio)-pos)*8/float64(bitrate)*100) / 100
+}
+
+// MP4 / QuickTime
+
+var (
+	mp4Containers = map[string]bool{"moov": true, "trak": true, "mdia": true, "minf": true, "stbl": true, "udta": true}
+	mp4Epoch      = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+	iso6709       = regexp.MustCompile(`^([+-]\d+(?:\.\d+)?)([+-]\d+(?:\.\d+)?)([+-]\d+(?:\.\d+)?)?`)
+
+	mp4VideoCodecs = map[string]string{"avc1": "h264", "avc3": "h264", "hvc1": "hevc", "hev1": "hevc", "av01": "av1", "vp09": "vp9", "mp4v": "mpeg4", "apcn": "prores", "apch": "prores"}
+	mp4AudioCodecs = map[string]string{"mp4a": "aac", "ac-3": "ac3", "ec-3": "eac3", "Opus": "opus", "alac": "alac", "fLaC": "flac", ".mp3": "mp3"}
+)
+
+// mp4Track collects what is known about the track being walked
+type mp4Track struct {
+	handler       string
+	format        string
+	width, height int
+}
+
+func parseMP4(content []byte, media *models.MediaMetadata) {
+	var track *mp4Track
+	finishTrack := func() {
+		if track == nil {
+			return
+		}
+		switch track.handler {
+		case "vide":
+			if media.VideoCodec == "" {
+				media.VideoCodec = mp4CodecName(mp4VideoCodecs, track.format)
+				media.Width, media.Height = track.width, track.height
+			}
+		case "soun":
+			if media.AudioCodec == "" {
+				media.AudioCodec = mp4CodecName(mp4AudioCodecs, track.format)
+			}
+		}
+		track = nil
+	}
+
+	var walk func(data []byte)
+	walk = func(data []byte) {
+		for pos := 0; pos+8 <= len(data); {
+			size := int(binary.BigEndian.Uint32(data[pos:]))
+			kind := string(data[pos+4 : pos+8])
+			header := 8
+			switch size {
+			case 0:
+				size = len(data) - pos
+			case 1:
+				if pos+16 > len(data) {
+					return
+				}
+				large := binary.BigEndian.Uint64(data[pos+8:])
+				if large > uint64(len(data)-pos) {
+					return
+				}
+				size, header = int(large), 16
+			}
+			if size < header || pos+size > len(data) {
+				return
+			}
+			box := data[pos+header : pos+size]
+			pos += size
+
+			switch {
+			case kind == "trak":
+				finishTrack()
+				track = &mp4Track{}
+				walk(box)
+				finishTrack()
+			case mp4Containers[kind]:
+				walk(box)
+			case kind == "mvhd":
+				parseMvhd(box, media)
+			case kind == "tkhd" && track != nil:
+				track.width, track.height = parseTkhd(box)
+			case kind == "hdlr" && track != nil && len(box) >= 12:
+				track.handler = string(box[8:12])
+			case kind == "stsd" && track != nil && len(box) >= 16:
+				track.format = string(box[12:16])
+			case kind == "\xa9xyz" && len(box) > 4:
+				media.Location = parseISO6709(string(box[4:]))
+			}
+		}
+	}
+	walk(content)
+}
+
+func mp4CodecName(names map[string]string, format string) string {
+	if name, ok := names[format]; ok {
+		return name
+	}
+	return strings.TrimSpace(format)
+}
+
+func parseMvhd(box []byte, media *models.MediaMetadata) {
+	var created uint64
+	var timescale uint32
+	var duration uint64
+	switch {
+	case len(box) >= 32 && box[0] == 1:
+		created = binary.BigEndian.Uint64(box[4:])
+		timescale = binary.BigEndian.Uint32(box[20:])
+		duration = binary.BigEndian.Uint64(box[24:])
+	case len(box) >= 20:
+		created = uint64(binary.BigEndian.Uint32(box[4:]))
+		timescale = binary.BigEndian.Uint32(box[12:])
+		duration = uint64(binary.BigEndian.Uint32(box[16:]))
+	default:
+		return
+	}
+
+	if timescale > 0 {
+		media.Duration = math.Round(float64(duration)/float64(timescale)*100) / 100
+	}
+	if created > 0 {
+		capturedAt := mp4Epoch.Add(time.Duration(created) * time.Second)
+		media.CapturedAt = &capturedAt
+	}
+}
+
+// parseTkhd returns a track's display size, stored as 16.16 fixed point
+func parseTkhd(box []byte) (int, int) {
+	offset := 76
+	if len(box) > 0 && box[0] == 1 {
+		offset = 88
+	}
+	if len(box) < offset+8 {
+		return 0, 0
+	}
+	return int(binary.BigEndian.Uint32(box[offset:]) >> 16), int(binary.BigEndian.Uint32(box[offset+4:]) >> 16)
+}
+
+// parseISO6709 parses a location such as "+37.7749-122.4194+010.000/"
+func parseISO6709(value string) *models.MediaLocation {
+	match := iso6709.FindStringSubmatch(value)
+	if match == nil {
+		return nil
+	}
+	lat, err1 := strconv.ParseFloat(match[1], 64)
+	lon, err2 := strconv.ParseFloat(match[2], 64)
+	if err1 != nil || err2 != nil {
+		return nil
+	}
+
+	location := &models.MediaLocation{Latitude: lat, Longitude: lon}
+	if match[3] != "" {
+		if alt, err := strconv.ParseFloat(match[3], 64); err == nil {
+			location.Altitude = &alt
+		}
+	}
+	return validLocation(location)
+}
@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+)
+
+// ErrPDFUnreadable is returned when the pages of a PDF cannot be found
+var ErrPDFUnreadable = errors.New("unreadable PDF")
+
+// PDFPageCount returns the number of pages of a PDF, as its page tree
+// counts them, or as many page objects as it has when the tree cannot be
+// read
+func PDFPageCount(content []byte) (int, error) {
+	objects := readPDFObjects(content)
+
+	if startxrefs := pdfStartXref.FindAllSubmatch(content, -1); len(startxrefs) > 0 {
+		offset, _ := strconv.Atoi(string(startxrefs[len(startxrefs)-1][1]))
+		if offset > 0 && offset < len(content) {
+			trailer := pdfTrailer(content, offset, objects)
+			if catalog, ok := pdfResolve(objects, pdfDictValue(trailer, "/Root")); ok {
+				if pages, ok := pdfResolve(objects, pdfDictValue(pdfDict(catalog.body), "/Pages")); ok {
+					count, err := strconv.Atoi(string(bytes.TrimSpace(pdfDictValue(pdfDict(pages.body), "/Count"))))
+					if err == nil && count > 0 {
+						return count, nil
+					}
+				}
+			}
+		}
+	}
+
+	count := 0
+	for _, obj := range objects {
+		if pdfPageType.Match(pdfDict(obj.body)) {
+			count++
+		}
+	}
+	if count == 0 {
+		return 0, ErrPDFUnreadable
+	}
+	return count, nil
+}
+
+// pdfResolve returns the object a "N G R" reference points to
+func pdfResolve(objects map[int]pdfObject, value []byte) (pdfObject, bool) {
+	match := pdfRefPattern.FindSubmatch(bytes.TrimSpace(value))
+	if match == nil {
+		return pdfObject{}, false
+	}
+	num, _ := strconv.Atoi(string(match[1]))
+	obj, ok := objects[num]
+	return obj, ok
+}
@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pwnedPasswordsURL is the Have I Been Pwned range API. Only the first five
+// hex characters of the password's SHA-1 hash are sent (k-anonymity).
+const pwnedPasswordsURL = "https://api.pwnedpasswords.com/range/"
+
+var pwnedClient = &http.Client{Timeout: 5 * time.Second}
+
+// PasswordBreachCount returns how many times a password appears in known data
+// breaches according to Have I Been Pwned
+func PasswordBreachCount(password string) (int, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	req, err := http.NewRequest(http.MethodGet, pwnedPasswordsURL+prefix, nil)
+	if err != nil {
+		return 0, err
+	}
+	// Padding hides the real number of matches from observers of the response size
+	req.Header.Set("Add-Padding", "true")
+	req.Header.Set("User-Agent", "oncloud")
+
+	resp, err := pwnedClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("breach check failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("breach check failed: status %d", resp.StatusCode)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		candidate, count, found := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if !found || candidate != suffix {
+			continue
+		}
+		return strconv.Atoi(count)
+	}
+
+	return 0, scanner.Err()
+}
@@ -0,0 +1,165 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrRemoteAddressNotAllowed is returned when a remote URL resolves to an
+// address on the server's own network
+var ErrRemoteAddressNotAllowed = errors.New("remote address is not allowed")
+
+const maxRemoteRedirects = 5
+
+// Ranges that are not covered by the net.IP helpers but must never be
+// fetched: "this network", carrier-grade NAT, IETF protocol assignments,
+// benchmarking, reserved and the IPv6 NAT64/6to4/Teredo mappings of
+// private IPv4 space
+var blockedRemoteNetworks = mustParseCIDRs(
+	"0.0.0.0/8",
+	"100.64.0.0/10",
+	"192.0.0.0/24",
+	"198.18.0.0/15",
+	"240.0.0.0/4",
+	"64:ff9b::/96",
+	"64:ff9b:1::/48",
+	"2001::/32",
+	"2002::/16",
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// IsPublicIP reports whether ip is a globally routable unicast address
+func IsPublicIP(ip net.IP) bool {
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() {
+		return false
+	}
+	for _, network := range blockedRemoteNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidateRemoteURL checks that a user supplied URL is an absolute http or
+// https URL. Where it points is checked when connecting.
+func ValidateRemoteURL(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.New("only http and https URLs are supported")
+	}
+	if u.Hostname() == "" {
+		return nil, errors.New("URL must have a host")
+	}
+	if u.User != nil {
+		return nil, errors.New("URL must not contain credentials")
+	}
+	return u, nil
+}
+
+// NewRemoteFetchClient returns an HTTP client for fetching user supplied
+// URLs. Addresses are checked after DNS resolution, on every connection,
+// so neither a hostname nor a redirect can point it at the server's own
+// network.
+func NewRemoteFetchClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if !IsPublicIP(net.ParseIP(host)) {
+				return ErrRemoteAddressNotAllowed
+			}
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			// Never through a proxy, which would make the connection
+			// checks above meaningless
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: 30 * time.Second,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       90 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRemoteRedirects {
+				return errors.New("too many redirects")
+			}
+			_, err := ValidateRemoteURL(req.URL.String())
+			return err
+		},
+	}
+}
+
+// RemoteFileName picks a name for a fetched file from its
+// Content-Disposition header or else the last segment of its URL, adding
+// an extension for its content type when the name has none
+func RemoteFileName(resp *http.Response, mimeType string) string {
+	name := ""
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		name = path.Base(strings.ReplaceAll(params["filename"], "\\", "/"))
+	}
+	if name == "" || name == "." || name == "/" {
+		name = path.Base(resp.Request.URL.Path)
+	}
+	if name == "" || name == "." || name == "/" {
+		name = "download"
+	}
+
+	if path.Ext(name) == "" && mimeType != "" {
+		name += extensionForMimeType(mimeType)
+	}
+	return strings.TrimSpace(name)
+}
+
+// Usual extensions for types mime.ExtensionsByType lists several for, in
+// alphabetical order
+var preferredExtensions = map[string]string{
+	"audio/mpeg": ".mp3",
+	"image/jpeg": ".jpg",
+	"text/html":  ".html",
+	"text/plain": ".txt",
+	"video/mp4":  ".mp4",
+}
+
+func extensionForMimeType(mimeType string) string {
+	if ext, ok := preferredExtensions[mimeType]; ok {
+		return ext
+	}
+	if extensions, err := mime.ExtensionsByType(mimeType); err == nil && len(extensions) > 0 {
+		return extensions[0]
+	}
+	return ""
+}
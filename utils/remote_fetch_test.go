@@ -0,0 +1,132 @@
+package utils
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestIsPublicIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"8.8.8.8", true},
+		{"151.101.1.69", true},
+		{"2606:4700:4700::1111", true},
+
+		// Loopback and unspecified
+		{"127.0.0.1", false},
+		{"127.255.255.254", false},
+		{"::1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+
+		// RFC 1918
+		{"10.0.0.1", false},
+		{"172.16.5.4", false},
+		{"172.31.255.255", false},
+		{"192.168.1.1", false},
+
+		// Link-local, including the cloud metadata endpoint
+		{"169.254.169.254", false},
+		{"169.254.0.1", false},
+		{"fe80::1", false},
+
+		// IPv4-mapped IPv6 of private addresses
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:10.1.2.3", false},
+		{"::ffff:169.254.169.254", false},
+		{"::ffff:8.8.8.8", true},
+
+		// Unique local IPv6
+		{"fc00::1", false},
+		{"fd12:3456:789a::1", false},
+
+		// Carrier-grade NAT and other reserved ranges
+		{"100.64.0.1", false},
+		{"100.127.255.254", false},
+		{"::ffff:100.64.0.1", false},
+		{"198.18.0.1", false},
+		{"240.0.0.1", false},
+		{"255.255.255.255", false},
+
+		// NAT64 and 6to4 mappings of private IPv4 space
+		{"64:ff9b::a00:1", false},
+		{"2002:a00:1::", false},
+
+		// Multicast
+		{"224.0.0.1", false},
+		{"ff02::1", false},
+	}
+	for _, tt := range tests {
+		if got := IsPublicIP(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("IsPublicIP(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+	if IsPublicIP(nil) {
+		t.Error("IsPublicIP(nil) = true")
+	}
+}
+
+// redirectingTransport answers requests for one public host with a redirect
+// itself and sends every other request to the real transport
+type redirectingTransport struct {
+	host     string
+	location string
+	next     http.RoundTripper
+}
+
+func (rt *redirectingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != rt.host {
+		return rt.next.RoundTrip(req)
+	}
+	return &http.Response{
+		StatusCode: http.StatusFound,
+		Header:     http.Header{"Location": {rt.location}},
+		Body:       http.NoBody,
+		Request:    req,
+	}, nil
+}
+
+func TestRemoteFetchClientRejectsRedirectToPrivateHost(t *testing.T) {
+	reached := false
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+	defer internal.Close()
+
+	for _, location := range []string{
+		internal.URL + "/admin",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://[::ffff:10.0.0.1]/",
+	} {
+		client := NewRemoteFetchClient(5 * time.Second)
+		client.Transport = &redirectingTransport{host: "files.example.com", location: location, next: client.Transport}
+
+		resp, err := client.Get("http://files.example.com/report.pdf")
+		if err == nil {
+			resp.Body.Close()
+			t.Fatalf("redirect to %s was followed", location)
+		}
+		if !errors.Is(err, ErrRemoteAddressNotAllowed) {
+			t.Errorf("redirect to %s: %v, want ErrRemoteAddressNotAllowed", location, err)
+		}
+	}
+	if reached {
+		t.Error("the internal server was reached")
+	}
+}
+
+func TestRemoteFetchClientRejectsPrivateHost(t *testing.T) {
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer internal.Close()
+
+	_, err := NewRemoteFetchClient(5 * time.Second).Get(internal.URL)
+	if !errors.Is(err, ErrRemoteAddressNotAllowed) {
+		t.Fatalf("fetching %s: %v, want ErrRemoteAddressNotAllowed", internal.URL, err)
+	}
+}
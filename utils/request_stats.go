@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+// requestStatsRetention is how much per-minute request history is kept in memory
+const requestStatsRetention = 24 * 60
+
+// RequestStatsBucket holds request counts for one minute
+type RequestStatsBucket struct {
+	Minute       time.Time `json:"minute"`
+	Requests     int64     `json:"requests"`
+	ServerErrors int64     `json:"server_errors"`
+	ClientErrors int64     `json:"client_errors"`
+}
+
+type requestStats struct {
+	buckets [requestStatsRetention]RequestStatsBucket
+	mutex   sync.Mutex
+}
+
+var processRequestStats = &requestStats{}
+
+// RecordRequestStatus counts a completed request in the current minute bucket
+func RecordRequestStatus(statusCode int) {
+	minute := time.Now().Truncate(time.Minute)
+	slot := int(minute.Unix()/60) % requestStatsRetention
+
+	s := processRequestStats
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	bucket := &s.buckets[slot]
+	if !bucket.Minute.Equal(minute) {
+		*bucket = RequestStatsBucket{Minute: minute}
+	}
+
+	bucket.Requests++
+	switch {
+	case statusCode >= 500:
+		bucket.ServerErrors++
+	case statusCode >= 400:
+		bucket.ClientErrors++
+	}
+}
+
+// GetRequestStats returns the per-minute buckets recorded since `since`, oldest first.
+// Counts are for this process only.
+func GetRequestStats(since time.Time) []RequestStatsBucket {
+	s := processRequestStats
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	cutoff := time.Now().Add(-requestStatsRetention * time.Minute)
+	if since.Before(cutoff) {
+		since = cutoff
+	}
+
+	var buckets []RequestStatsBucket
+	for _, bucket := range s.buckets {
+		if bucket.Minute.IsZero() || bucket.Minute.Before(since) {
+			continue
+		}
+		buckets = append(buckets, bucket)
+	}
+
+	for i := 1; i < len(buckets); i++ {
+		for j := i; j > 0 && buckets[j].Minute.Before(buckets[j-1].Minute); j-- {
+			buckets[j], buckets[j-1] = buckets[j-1], buckets[j]
+		}
+	}
+
+	return buckets
+}
@@ -1,15 +1,77 @@
 package utils
 
 import (
+	"errors"
 	"math"
 	"net/http"
 	"oncloud/models"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Error codes sent in APIError.Code. Statuses without an entry here use
+// their status text in snake case, e.g. payment_required.
+const (
+	ErrorCodeBadRequest       = "bad_request"
+	ErrorCodeValidation       = "validation_failed"
+	ErrorCodeUnauthorized     = "unauthorized"
+	ErrorCodeForbidden        = "forbidden"
+	ErrorCodeNotFound         = "not_found"
+	ErrorCodeConflict         = "conflict"
+	ErrorCodePayloadTooLarge  = "payload_too_large"
+	ErrorCodeRateLimited      = "rate_limited"
+	ErrorCodeInternal         = "internal_error"
+	ErrorCodeUnavailable      = "service_unavailable"
+	ErrorCodeMaintenance      = "maintenance"
+	ErrorCodeScanPending      = "scan_pending"
+	ErrorCodeFileInfected     = "file_infected"
+	ErrorCodeFileTypeMismatch = "file_type_mismatch"
+	ErrorCodeFileTypeBlocked  = "file_type_blocked"
+	ErrorCodeFileExpired      = "file_expired"
+	ErrorCodeFileArchived     = "file_archived"
+	ErrorCodeAccountSuspended = "account_suspended"
+	ErrorCodeAccountReadOnly  = "account_read_only"
+	ErrorCodeStreamPreparing  = "stream_preparing"
+	defaultPaginationMaxLimit = 100
+)
+
+// Error codes of the kinds of service errors, answered by the error
+// middleware
+const (
+	ErrorCodeQuotaExceeded       = "quota_exceeded"
+	ErrorCodeProviderUnavailable = "provider_unavailable"
+	ErrorCodeProviderTimeout     = "provider_timeout"
+)
+
+var errorCodes = map[int]string{
+	http.StatusBadRequest:            ErrorCodeBadRequest,
+	http.StatusUnprocessableEntity:   ErrorCodeValidation,
+	http.StatusUnauthorized:          ErrorCodeUnauthorized,
+	http.StatusForbidden:             ErrorCodeForbidden,
+	http.StatusNotFound:              ErrorCodeNotFound,
+	http.StatusConflict:              ErrorCodeConflict,
+	http.StatusRequestEntityTooLarge: ErrorCodePayloadTooLarge,
+	http.StatusTooManyRequests:       ErrorCodeRateLimited,
+	http.StatusInternalServerError:   ErrorCodeInternal,
+	http.StatusServiceUnavailable:    ErrorCodeUnavailable,
+}
+
+// ErrorCodeForStatus returns the error code sent for an HTTP status
+func ErrorCodeForStatus(statusCode int) string {
+	if code, ok := errorCodes[statusCode]; ok {
+		return code
+	}
+	text := http.StatusText(statusCode)
+	if text == "" {
+		return "error"
+	}
+	return strings.ReplaceAll(strings.ToLower(strings.ReplaceAll(text, "-", " ")), " ", "_")
+}
+
 // SuccessResponse sends a successful API response
 func SuccessResponse(c *gin.Context, message string, data interface{}) {
 	response := models.APIResponse{
@@ -32,13 +94,20 @@ func CreatedResponse(c *gin.Context, message string, data interface{}) {
 	c.JSON(http.StatusCreated, response)
 }
 
-// ErrorResponse sends an error API response
+// ErrorResponse sends an error API response with the status's error code
 func ErrorResponse(c *gin.Context, statusCode int, message string, details map[string]interface{}) {
+	ErrorResponseWithCode(c, statusCode, ErrorCodeForStatus(statusCode), message, details)
+}
+
+// ErrorResponseWithCode sends an error API response with a specific error
+// code, for failures clients need to tell apart from others of the same
+// status
+func ErrorResponseWithCode(c *gin.Context, statusCode int, code, message string, details map[string]interface{}) {
 	response := models.APIResponse{
 		Success: false,
 		Message: message,
 		Error: &models.APIError{
-			Code:    http.StatusText(statusCode),
+			Code:    code,
 			Message: message,
 			Details: details,
 		},
@@ -47,11 +116,24 @@ func ErrorResponse(c *gin.Context, statusCode int, message string, details map[s
 	c.JSON(statusCode, response)
 }
 
-// ValidationErrorResponse sends a validation error response
+// ServiceErrorResponse leaves a service error for the error middleware,
+// which answers it with the status and error code of its kind. message is
+// sent instead for errors of no known kind.
+func ServiceErrorResponse(c *gin.Context, err error, message string) {
+	c.Error(err).SetMeta(message)
+}
+
+// ValidationErrorResponse sends a validation error response. Errors from
+// ValidateStruct are also listed per field.
 func ValidationErrorResponse(c *gin.Context, err error) {
-	ErrorResponse(c, http.StatusUnprocessableEntity, "Validation failed", map[string]interface{}{
+	details := map[string]interface{}{
 		"validation_errors": err.Error(),
-	})
+	}
+	var fieldErrors FieldErrors
+	if errors.As(err, &fieldErrors) {
+		details["fields"] = fieldErrors
+	}
+	ErrorResponse(c, http.StatusUnprocessableEntity, "Validation failed", details)
 }
 
 // UnauthorizedResponse sends an unauthorized response
@@ -104,9 +186,28 @@ func TooManyRequestsResponse(c *gin.Context, message string) {
 	ErrorResponse(c, http.StatusTooManyRequests, message, nil)
 }
 
+// PayloadTooLargeResponse sends a request entity too large response
+func PayloadTooLargeResponse(c *gin.Context, message string) {
+	if message == "" {
+		message = "Request entity too large"
+	}
+	ErrorResponse(c, http.StatusRequestEntityTooLarge, message, nil)
+}
+
+// ServiceUnavailableResponse sends a service unavailable response
+func ServiceUnavailableResponse(c *gin.Context, message string) {
+	if message == "" {
+		message = "Service temporarily unavailable"
+	}
+	ErrorResponse(c, http.StatusServiceUnavailable, message, nil)
+}
+
 // PaginatedResponse sends a paginated response
 func PaginatedResponse(c *gin.Context, message string, data interface{}, page, limit, total int) {
-	totalPages := int(math.Ceil(float64(total) / float64(limit)))
+	totalPages := 0
+	if limit > 0 {
+		totalPages = int(math.Ceil(float64(total) / float64(limit)))
+	}
 
 	response := models.APIResponse{
 		Success: true,
@@ -117,12 +218,33 @@ func PaginatedResponse(c *gin.Context, message string, data interface{}, page, l
 			Limit:      limit,
 			Total:      total,
 			TotalPages: totalPages,
+			HasNext:    page < totalPages,
+			HasPrev:    page > 1,
 		},
 		Timestamp: time.Now(),
 	}
 	c.JSON(http.StatusOK, response)
 }
 
+// GetPagination reads the page and limit query parameters. A page below 1
+// is the first page, and a limit outside 1..maxLimit (100 when 0) is
+// defaultLimit.
+func GetPagination(c *gin.Context, defaultLimit, maxLimit int) (int, int) {
+	if maxLimit <= 0 {
+		maxLimit = defaultPaginationMaxLimit
+	}
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultLimit)))
+	if err != nil || limit < 1 || limit > maxLimit {
+		limit = defaultLimit
+	}
+	return page, limit
+}
+
 // FileUploadResponse sends a file upload response
 func FileUploadResponse(c *gin.Context, message string, file *models.File, uploadURL string) {
 	response := models.UploadResponse{
@@ -132,6 +254,22 @@ func FileUploadResponse(c *gin.Context, message string, file *models.File, uploa
 	SuccessResponse(c, message, response)
 }
 
+// ScimResponse sends a SCIM 2.0 resource with the SCIM media type
+func ScimResponse(c *gin.Context, statusCode int, data interface{}) {
+	c.Header("Content-Type", "application/scim+json")
+	c.JSON(statusCode, data)
+}
+
+// ScimErrorResponse sends a SCIM 2.0 error (RFC 7644 section 3.12)
+func ScimErrorResponse(c *gin.Context, statusCode int, scimType, detail string) {
+	ScimResponse(c, statusCode, models.ScimError{
+		Schemas:  []string{models.ScimErrorSchema},
+		Status:   strconv.Itoa(statusCode),
+		ScimType: scimType,
+		Detail:   detail,
+	})
+}
+
 // AbortWithError aborts request with error response
 func AbortWithError(c *gin.Context, statusCode int, message string) {
 	ErrorResponse(c, statusCode, message, nil)
@@ -0,0 +1,146 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultStorageKeyTemplate is the key template used by providers without one
+const DefaultStorageKeyTemplate = "{year}/{month}/{day}/{name}"
+
+// StorageKeyVars are the values placeholders in a storage key template stand
+// for
+type StorageKeyVars struct {
+	UserID string
+	FileID string
+	// Name is the unique stored name of the file, with its extension
+	Name string
+	Time time.Time
+}
+
+var storageKeyPlaceholder = regexp.MustCompile(`\{([a-z_]+)(?::(\d+))?\}`)
+
+// RenderStorageKey builds a storage key from template. Placeholders are
+// {user_id}, {file_id}, {name}, {ext}, {year}, {month}, {day}, {hour},
+// {random} and {hash} or {hash:N}, the first N (default 2) hex characters of
+// the SHA-256 of the file ID, for spreading keys over S3 partitions. Any other
+// text, such as a tenant prefix, is kept as it is.
+func RenderStorageKey(template string, vars StorageKeyVars) (string, error) {
+	if template == "" {
+		template = DefaultStorageKeyTemplate
+	}
+	if err := ValidateStorageKeyTemplate(template); err != nil {
+		return "", err
+	}
+
+	t := vars.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	t = t.UTC()
+
+	key := storageKeyPlaceholder.ReplaceAllStringFunc(template, func(match string) string {
+		parts := storageKeyPlaceholder.FindStringSubmatch(match)
+		switch parts[1] {
+		case "user_id":
+			return vars.UserID
+		case "file_id":
+			return vars.FileID
+		case "name":
+			return vars.Name
+		case "ext":
+			return strings.TrimPrefix(path.Ext(vars.Name), ".")
+		case "year":
+			return fmt.Sprintf("%d", t.Year())
+		case "month":
+			return fmt.Sprintf("%02d", t.Month())
+		case "day":
+			return fmt.Sprintf("%02d", t.Day())
+		case "hour":
+			return fmt.Sprintf("%02d", t.Hour())
+		case "random":
+			return strings.ToLower(generateRandomString(12))
+		case "hash":
+			length := 2
+			if parts[2] != "" {
+				length, _ = strconv.Atoi(parts[2])
+			}
+			seed := vars.FileID
+			if seed == "" {
+				seed = vars.Name
+			}
+			sum := sha256.Sum256([]byte(seed))
+			return hex.EncodeToString(sum[:])[:length]
+		}
+		return match
+	})
+
+	// Empty placeholders must not leave empty path segments behind
+	for strings.Contains(key, "//") {
+		key = strings.ReplaceAll(key, "//", "/")
+	}
+	return strings.Trim(key, "/"), nil
+}
+
+// ValidateStorageKeyTemplate checks that template only uses known
+// placeholders and always yields unique, relative keys
+func ValidateStorageKeyTemplate(template string) error {
+	if strings.HasPrefix(template, "/") {
+		return fmt.Errorf("storage key template must be relative")
+	}
+	for _, segment := range strings.Split(template, "/") {
+		if segment == ".." || segment == "." {
+			return fmt.Errorf("storage key template must not contain %q segments", segment)
+		}
+	}
+	if strings.ContainsAny(storageKeyPlaceholder.ReplaceAllString(template, ""), "{}\\") {
+		return fmt.Errorf("storage key template contains an invalid placeholder")
+	}
+
+	unique := false
+	for _, parts := range storageKeyPlaceholder.FindAllStringSubmatch(template, -1) {
+		switch parts[1] {
+		case "name", "file_id", "random":
+			unique = true
+		case "user_id", "ext", "year", "month", "day", "hour":
+		case "hash":
+			if parts[2] != "" {
+				if n, _ := strconv.Atoi(parts[2]); n < 1 || n > 8 {
+					return fmt.Errorf("{hash:N} length must be between 1 and 8")
+				}
+			}
+		default:
+			return fmt.Errorf("unknown storage key placeholder {%s}", parts[1])
+		}
+		if parts[2] != "" && parts[1] != "hash" {
+			return fmt.Errorf("placeholder {%s} takes no length", parts[1])
+		}
+	}
+	if !unique {
+		return fmt.Errorf("storage key template must contain {name}, {file_id} or {random}")
+	}
+	return nil
+}
+
+// UniqueStorageName returns a unique name to store a file called name under
+func UniqueStorageName(name, ext string) string {
+	return generateUniqueFileName(name, ext)
+}
+
+// StorageKeyPrefix returns the fixed text every key rendered from template
+// starts with
+func StorageKeyPrefix(template string) string {
+	if template == "" {
+		template = DefaultStorageKeyTemplate
+	}
+	if i := strings.Index(template, "{"); i >= 0 {
+		return template[:i]
+	}
+	return template
+}
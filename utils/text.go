@@ -0,0 +1,222 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"path"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Text encodings DecodeText tells apart
+const (
+	EncodingUTF8    = "utf-8"
+	EncodingUTF16LE = "utf-16le"
+	EncodingUTF16BE = "utf-16be"
+	EncodingLatin1  = "iso-8859-1"
+)
+
+// ErrNotText is returned by DecodeText for content that is not text
+var ErrNotText = errors.New("content is not text")
+
+// DecodedText is the start of a text file decoded to UTF-8
+type DecodedText struct {
+	Text      string
+	Encoding  string
+	BOM       bool // whether the content starts with a byte order mark
+	Lines     int  // in the whole content
+	Truncated bool // whether Text is only the start of the content
+}
+
+// textSniffSize is how much of the content is looked at to tell text from
+// binary content, and UTF-16 without a byte order mark
+const textSniffSize = 8 * 1024
+
+// DecodeText decodes content as text, UTF-8 or UTF-16 told by its byte
+// order mark or its NUL bytes, or Latin-1 when it is not valid UTF-8, and
+// returns at most limit bytes of it cut at a character
+func DecodeText(content []byte, limit int) (*DecodedText, error) {
+	decoded := &DecodedText{}
+
+	switch {
+	case bytes.HasPrefix(content, []byte("\xef\xbb\xbf")):
+		decoded.Encoding, decoded.BOM = EncodingUTF8, true
+		content = content[3:]
+	case bytes.HasPrefix(content, []byte("\xff\xfe")):
+		decoded.Encoding, decoded.BOM = EncodingUTF16LE, true
+		content = content[2:]
+	case bytes.HasPrefix(content, []byte("\xfe\xff")):
+		decoded.Encoding, decoded.BOM = EncodingUTF16BE, true
+		content = content[2:]
+	default:
+		decoded.Encoding = sniffTextEncoding(content[:min(len(content), textSniffSize)])
+		if decoded.Encoding == "" {
+			return nil, ErrNotText
+		}
+	}
+
+	var text string
+	switch decoded.Encoding {
+	case EncodingUTF16LE, EncodingUTF16BE:
+		text = decodeUTF16(content, decoded.Encoding == EncodingUTF16BE)
+	case EncodingUTF8:
+		if !decoded.BOM && !utf8.Valid(content) {
+			decoded.Encoding = EncodingLatin1
+			text = decodeLatin1(content)
+		} else {
+			text = strings.ToValidUTF8(string(content), "�")
+		}
+	}
+
+	decoded.Lines = strings.Count(text, "\n")
+	if text != "" && !strings.HasSuffix(text, "\n") {
+		decoded.Lines++
+	}
+
+	if len(text) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		text = text[:cut]
+		decoded.Truncated = true
+	}
+	decoded.Text = text
+	return decoded, nil
+}
+
+// sniffTextEncoding guesses the encoding of text without a byte order mark
+// from its start: UTF-16 when every other byte is mostly NUL, as in text
+// that is mostly ASCII, or UTF-8. It returns "" for binary content, which
+// has NUL or control bytes elsewhere.
+func sniffTextEncoding(sample []byte) string {
+	if len(sample) == 0 {
+		return EncodingUTF8
+	}
+
+	var evenNUL, oddNUL, control int
+	for i, b := range sample {
+		switch {
+		case b == 0 && i%2 == 0:
+			evenNUL++
+		case b == 0:
+			oddNUL++
+		case b < 0x20 && b != '\t' && b != '\n' && b != '\r' && b != '\f' && b != 0x1b:
+			control++
+		}
+	}
+
+	half := len(sample) / 2
+	switch {
+	case half > 0 && oddNUL > half*3/10 && evenNUL <= half/20:
+		return EncodingUTF16LE
+	case half > 0 && evenNUL > half*3/10 && oddNUL <= half/20:
+		return EncodingUTF16BE
+	case evenNUL+oddNUL > 0 || control > len(sample)/10:
+		return ""
+	}
+	return EncodingUTF8
+}
+
+func decodeUTF16(content []byte, bigEndian bool) string {
+	units := make([]uint16, len(content)/2)
+	for i := range units {
+		if bigEndian {
+			units[i] = uint16(content[2*i])<<8 | uint16(content[2*i+1])
+		} else {
+			units[i] = uint16(content[2*i+1])<<8 | uint16(content[2*i])
+		}
+	}
+	return string(utf16.Decode(units))
+}
+
+func decodeLatin1(content []byte) string {
+	var b strings.Builder
+	b.Grow(len(content))
+	for _, c := range content {
+		b.WriteRune(rune(c))
+	}
+	return b.String()
+}
+
+// languagesByExtension are the languages code is highlighted as, named as
+// highlight.js and Prism name them, by file extension
+var languagesByExtension = map[string]string{
+	".go": "go", ".py": "python", ".pyw": "python", ".rb": "ruby", ".php": "php",
+	".js": "javascript", ".mjs": "javascript", ".cjs": "javascript", ".jsx": "javascript",
+	".ts": "typescript", ".tsx": "typescript", ".java": "java", ".kt": "kotlin", ".kts": "kotlin",
+	".scala": "scala", ".swift": "swift", ".m": "objectivec", ".c": "c", ".h": "c",
+	".cc": "cpp", ".cpp": "cpp", ".cxx": "cpp", ".hpp": "cpp", ".hh": "cpp", ".cs": "csharp",
+	".fs": "fsharp", ".rs": "rust", ".dart": "dart", ".lua": "lua", ".pl": "perl", ".pm": "perl",
+	".r": "r", ".jl": "julia", ".hs": "haskell", ".ex": "elixir", ".exs": "elixir", ".erl": "erlang",
+	".clj": "clojure", ".groovy": "groovy", ".vb": "vbnet", ".ps1": "powershell",
+	".sh": "bash", ".bash": "bash", ".zsh": "bash", ".bat": "dos", ".cmd": "dos",
+	".sql": "sql", ".html": "html", ".htm": "html", ".xml": "xml", ".svg": "xml", ".xsl": "xml",
+	".css": "css", ".scss": "scss", ".sass": "scss", ".less": "less", ".vue": "html",
+	".json": "json", ".jsonc": "json", ".yaml": "yaml", ".yml": "yaml", ".toml": "toml",
+	".ini": "ini", ".cfg": "ini", ".conf": "ini", ".properties": "properties", ".env": "bash",
+	".md": "markdown", ".markdown": "markdown", ".rst": "plaintext", ".tex": "latex",
+	".csv": "plaintext", ".tsv": "plaintext", ".log": "plaintext", ".txt": "plaintext",
+	".proto": "protobuf", ".graphql": "graphql", ".gql": "graphql", ".tf": "hcl", ".hcl": "hcl",
+	".diff": "diff", ".patch": "diff", ".dockerfile": "dockerfile", ".mk": "makefile",
+	".gradle": "groovy", ".zig": "zig", ".nim": "nim", ".sol": "solidity",
+}
+
+// languagesByName are the languages of files known by their whole name
+var languagesByName = map[string]string{
+	"dockerfile": "dockerfile", "containerfile": "dockerfile", "makefile": "makefile",
+	"gnumakefile": "makefile", "cmakelists.txt": "cmake", "gemfile": "ruby", "rakefile": "ruby",
+	"vagrantfile": "ruby", "jenkinsfile": "groovy", "go.mod": "go", ".gitignore": "bash",
+	".bashrc": "bash", ".zshrc": "bash", ".profile": "bash", ".editorconfig": "ini",
+}
+
+// languagesByInterpreter are the languages of scripts by the interpreter
+// their #! line names
+var languagesByInterpreter = map[string]string{
+	"sh": "bash", "bash": "bash", "zsh": "bash", "dash": "bash", "ksh": "bash",
+	"python": "python", "python2": "python", "python3": "python", "node": "javascript",
+	"deno": "typescript", "ruby": "ruby", "perl": "perl", "php": "php", "lua": "lua",
+	"Rscript": "r", "pwsh": "powershell",
+}
+
+// languagesByMimeType are the languages of files known by their MIME type
+var languagesByMimeType = map[string]string{
+	"application/json": "json", "application/xml": "xml", "text/xml": "xml",
+	"text/html": "html", "text/css": "css", "text/markdown": "markdown",
+	"application/javascript": "javascript", "text/javascript": "javascript",
+	"application/x-yaml": "yaml", "text/yaml": "yaml", "application/sql": "sql",
+	"text/x-shellscript": "bash", "application/x-sh": "bash", "text/x-python": "python",
+}
+
+// DetectLanguage names the language text is highlighted as, from the name
+// of its file, its #! line or its MIME type, or "plaintext"
+func DetectLanguage(name, mimeType, text string) string {
+	name = strings.ToLower(path.Base(name))
+	if language, ok := languagesByName[name]; ok {
+		return language
+	}
+	if language, ok := languagesByExtension[path.Ext(name)]; ok {
+		return language
+	}
+
+	if strings.HasPrefix(text, "#!") {
+		line, _, _ := strings.Cut(text[2:], "\n")
+		fields := strings.Fields(line)
+		if len(fields) > 0 {
+			interpreter := path.Base(fields[0])
+			if interpreter == "env" && len(fields) > 1 {
+				interpreter = fields[1]
+			}
+			if language, ok := languagesByInterpreter[interpreter]; ok {
+				return language
+			}
+		}
+	}
+
+	mimeType, _, _ = strings.Cut(strings.ToLower(mimeType), ";")
+	if language, ok := languagesByMimeType[strings.TrimSpace(mimeType)]; ok {
+		return language
+	}
+	return "plaintext"
+}
@@ -32,20 +32,55 @@ type UploadConfig struct {
 
 // ProcessFileUpload processes uploaded file and returns file information
 func ProcessFileUpload(file *multipart.FileHeader, config *UploadConfig) (*FileInfo, error) {
+	ext, mimeType, err := validateUpload(file.Filename, file.Size, config)
+	if err != nil {
+		return nil, err
+	}
+
+	// Open file to calculate hash
+	src, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %v", err)
+	}
+	defer src.Close()
+
+	// Calculate file hash
+	hash, err := calculateFileHash(src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to calculate file hash: %v", err)
+	}
+
+	return newFileInfo(file.Filename, file.Size, ext, mimeType, hash), nil
+}
+
+// ProcessFileContent processes file content that did not arrive as a
+// multipart upload, e.g. content fetched from elsewhere
+func ProcessFileContent(fileName string, content []byte, config *UploadConfig) (*FileInfo, error) {
+	ext, mimeType, err := validateUpload(fileName, int64(len(content)), config)
+	if err != nil {
+		return nil, err
+	}
+
+	return newFileInfo(fileName, int64(len(content)), ext, mimeType, fmt.Sprintf("%x", md5.Sum(content))), nil
+}
+
+// validateUpload checks a file's size and type and returns its extension
+// and MIME type
+func validateUpload(fileName string, size int64, config *UploadConfig) (string, string, error) {
 	// Validate file size
-	if file.Size > config.MaxFileSize {
-		return nil, fmt.Errorf("file size %d exceeds maximum allowed size %d", file.Size, config.MaxFileSize)
+	if size > config.MaxFileSize {
+		return "", "", fmt.Errorf("file size %d exceeds maximum allowed size %d", size, config.MaxFileSize)
 	}
 
 	// Get file extension
-	ext := strings.ToLower(filepath.Ext(file.Filename))
+	ext := strings.ToLower(filepath.Ext(fileName))
 	if ext == "" {
-		return nil, fmt.Errorf("file must have an extension")
+		return "", "", fmt.Errorf("file must have an extension")
 	}
 
 	// Validate file type
 	if !isAllowedFileType(ext, config.AllowedTypes) {
-		return nil, fmt.Errorf("file type %s is not allowed", ext)
+		return "", "", fmt.Errorf("file type %s is not allowed", ext)
 	}
 
 	// Get MIME type
@@ -54,38 +89,29 @@ func ProcessFileUpload(file *multipart.FileHeader, config *UploadConfig) (*FileI
 		mimeType = "application/octet-stream"
 	}
 
-	// Open file to calculate hash
-	src, err := file.Open()
-	if err != nil {
-		return nil, fmt.Errorf("failed to open file: %v", err)
-	}
-	defer src.Close()
-
-	// Calculate file hash
-	hash, err := calculateFileHash(src)
-	if err != nil {
-		return nil, fmt.Errorf("failed to calculate file hash: %v", err)
-	}
+	return ext, mimeType, nil
+}
 
+func newFileInfo(fileName string, size int64, ext, mimeType, hash string) *FileInfo {
 	// Generate unique filename
-	uniqueName := generateUniqueFileName(file.Filename, ext)
+	uniqueName := generateUniqueFileName(fileName, ext)
 
 	// Generate storage path
 	storagePath := generateStoragePath(uniqueName)
 
 	// Extract metadata
-	metadata := extractFileMetadata(file, mimeType)
+	metadata := extractFileMetadata(fileName, mimeType)
 
 	return &FileInfo{
 		Name:         uniqueName,
-		OriginalName: file.Filename,
-		Size:         file.Size,
+		OriginalName: fileName,
+		Size:         size,
 		Extension:    ext,
 		MimeType:     mimeType,
 		Hash:         hash,
 		Path:         storagePath,
 		Metadata:     metadata,
-	}, nil
+	}
 }
 
 // isAllowedFileType checks if file extension is allowed
@@ -148,10 +174,10 @@ func cleanFileName(name string) string {
 }
 
 // extractFileMetadata extracts metadata from file
-func extractFileMetadata(file *multipart.FileHeader, mimeType string) map[string]string {
+func extractFileMetadata(fileName, mimeType string) map[string]string {
 	metadata := make(map[string]string)
 
-	metadata["original_name"] = file.Filename
+	metadata["original_name"] = fileName
 	metadata["mime_type"] = mimeType
 	metadata["upload_time"] = time.Now().Format(time.RFC3339)
 
@@ -161,6 +187,12 @@ func extractFileMetadata(file *multipart.FileHeader, mimeType string) map[string
 	return metadata
 }
 
+// FileCategory returns the category of a file of mimeType: image, video,
+// audio, document, text, archive or other
+func FileCategory(mimeType string) string {
+	return getFileCategory(mimeType)
+}
+
 // getFileCategory determines file category based on MIME type
 func getFileCategory(mimeType string) string {
 	if strings.HasPrefix(mimeType, "image/") {
@@ -193,3 +225,9 @@ func GenerateUploadPath(userID, filename string) string {
 	randomStr := generateRandomString(8)
 	return fmt.Sprintf("uploads/%s/%d_%s_%s", userID, timestamp, randomStr, filename)
 }
+
+// ContentDisposition returns a Content-Disposition header value naming the
+// file, quoted or RFC 2231 encoded as the name needs
+func ContentDisposition(disposition, name string) string {
+	return mime.FormatMediaType(disposition, map[string]string{"filename": name})
+}
@@ -0,0 +1,25 @@
+package utils
+
+import (
+	"mime"
+	"testing"
+)
+
+func TestContentDisposition(t *testing.T) {
+	for _, name := range []string{
+		"report.pdf",
+		`quote" ; filename="evil.exe`,
+		"line\r\nSet-Cookie: a=b.txt",
+		"résumé 2026.docx",
+		"back\\slash.txt",
+	} {
+		header := ContentDisposition("attachment", name)
+		disposition, params, err := mime.ParseMediaType(header)
+		if err != nil {
+			t.Fatalf("%q: unparseable header %q: %v", name, header, err)
+		}
+		if disposition != "attachment" || params["filename"] != name || len(params) != 1 {
+			t.Errorf("%q: header %q parsed as %s %v", name, header, disposition, params)
+		}
+	}
+}
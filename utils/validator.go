@@ -23,6 +23,9 @@ func init() {
 	validate.RegisterValidation("strong_password", validateStrongPassword)
 	validate.RegisterValidation("username", validateUsername)
 	validate.RegisterValidation("folder_name", validateFolderName)
+	validate.RegisterValidation("objectid", validateObjectID)
+	validate.RegisterValidation("storage_key_template", validateStorageKeyTemplate)
+	validate.RegisterValidation("folder_path_template", validateFolderPathTemplate)
 
 	// Register custom tag name function
 	validate.RegisterTagNameFunc(func(fld reflect.StructField) string {
@@ -48,16 +51,43 @@ func ValidateVar(field interface{}, tag string) error {
 	return validate.Var(field, tag)
 }
 
+// FieldError is a validation failure of one request field
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// FieldErrors are the validation failures of a request, one per field
+type FieldErrors []FieldError
+
+func (fe FieldErrors) Error() string {
+	messages := make([]string, 0, len(fe))
+	for _, e := range fe {
+		messages = append(messages, e.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
 // formatValidationErrors formats validation errors for better readability
 func formatValidationErrors(err error) error {
 	var validationErrors validator.ValidationErrors
 	if errors.As(err, &validationErrors) {
-		var messages []string
+		fieldErrors := make(FieldErrors, 0, len(validationErrors))
 		for _, e := range validationErrors {
-			message := getValidationMessage(e)
-			messages = append(messages, message)
+			// The namespace starts with the struct's name, e.g.
+			// CloudImportRequest.sources[0].id
+			field := e.Namespace()
+			if _, path, found := strings.Cut(field, "."); found {
+				field = path
+			}
+			fieldErrors = append(fieldErrors, FieldError{
+				Field:   field,
+				Rule:    e.Tag(),
+				Message: getValidationMessage(e),
+			})
 		}
-		return errors.New(strings.Join(messages, "; "))
+		return fieldErrors
 	}
 	return err
 }
@@ -95,6 +125,18 @@ func getValidationMessage(e validator.FieldError) string {
 		return fmt.Sprintf("%s must contain only letters, numbers, and underscores", field)
 	case "folder_name":
 		return fmt.Sprintf("%s contains invalid characters", field)
+	case "storage_key_template":
+		return fmt.Sprintf("%s must be a valid storage key template", field)
+	case "folder_path_template":
+		return fmt.Sprintf("%s must be a folder path such as /Photos/{year}/{month}", field)
+	case "objectid":
+		return fmt.Sprintf("%s must be a valid ID", field)
+	case "hexcolor":
+		return fmt.Sprintf("%s must be a hex color", field)
+	case "url":
+		return fmt.Sprintf("%s must be a valid URL", field)
+	case "gtfield":
+		return fmt.Sprintf("%s must be after %s", field, e.Param())
 	default:
 		return fmt.Sprintf("%s is invalid", field)
 	}
@@ -171,6 +213,18 @@ func validateUsername(fl validator.FieldLevel) bool {
 	return matched
 }
 
+func validateObjectID(fl validator.FieldLevel) bool {
+	return IsValidObjectID(fl.Field().String())
+}
+
+func validateStorageKeyTemplate(fl validator.FieldLevel) bool {
+	return ValidateStorageKeyTemplate(fl.Field().String()) == nil
+}
+
+func validateFolderPathTemplate(fl validator.FieldLevel) bool {
+	return ValidateFolderPathTemplate(fl.Field().String()) == nil
+}
+
 func validateFolderName(fl validator.FieldLevel) bool {
 	name := fl.Field().String()
 	// Disallow special characters that might cause issues
@@ -0,0 +1,687 @@
+package utils
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrWatermarkUnsupported is returned for content that cannot be
+	// watermarked: encrypted or unparseable PDFs and undecodable images
+	ErrWatermarkUnsupported = errors.New("content cannot be watermarked")
+)
+
+// watermarkGlyphs is a 5x7 bitmap font, one row per byte with the leftmost
+// pixel in bit 4. Watermarks are drawn from these pixels, so neither images
+// nor PDFs need a font. Text is upper-cased; runes without a glyph print as ?.
+var watermarkGlyphs = map[rune][7]uint8{
+	'A': {0b01110, 0b10001, 0b10001, 0b11111, 0b10001, 0b10001, 0b10001},
+	'B': {0b11110, 0b10001, 0b10001, 0b11110, 0b10001, 0b10001, 0b11110},
+	'C': {0b01110, 0b10001, 0b10000, 0b10000, 0b10000, 0b10001, 0b01110},
+	'D': {0b11110, 0b10001, 0b10001, 0b10001, 0b10001, 0b10001, 0b11110},
+	'E': {0b11111, 0b10000, 0b10000, 0b11110, 0b10000, 0b10000, 0b11111},
+	'F': {0b11111, 0b10000, 0b10000, 0b11110, 0b10000, 0b10000, 0b10000},
+	'G': {0b01110, 0b10001, 0b10000, 0b10111, 0b10001, 0b10001, 0b01111},
+	'H': {0b10001, 0b10001, 0b10001, 0b11111, 0b10001, 0b10001, 0b10001},
+	'I': {0b01110, 0b00100, 0b00100, 0b00100, 0b00100, 0b00100, 0b01110},
+	'J': {0b00111, 0b00010, 0b00010, 0b00010, 0b00010, 0b10010, 0b01100},
+	'K': {0b10001, 0b10010, 0b10100, 0b11000, 0b10100, 0b10010, 0b10001},
+	'L': {0b10000, 0b10000, 0b10000, 0b10000, 0b10000, 0b10000, 0b11111},
+	'M': {0b10001, 0b11011, 0b10101, 0b10101, 0b10001, 0b10001, 0b10001},
+	'N': {0b10001, 0b10001, 0b11001, 0b10101, 0b10011, 0b10001, 0b10001},
+	'O': {0b01110, 0b10001, 0b10001, 0b10001, 0b10001, 0b10001, 0b01110},
+	'P': {0b11110, 0b10001, 0b10001, 0b11110, 0b10000, 0b10000, 0b10000},
+	'Q': {0b01110, 0b10001, 0b10001, 0b10001, 0b10101, 0b10010, 0b01101},
+	'R': {0b11110, 0b10001, 0b10001, 0b11110, 0b10100, 0b10010, 0b10001},
+	'S': {0b01111, 0b10000, 0b10000, 0b01110, 0b00001, 0b00001, 0b11110},
+	'T': {0b11111, 0b00100, 0b00100, 0b00100, 0b00100, 0b00100, 0b00100},
+	'U': {0b10001, 0b10001, 0b10001, 0b10001, 0b10001, 0b10001, 0b01110},
+	'V': {0b10001, 0b10001, 0b10001, 0b10001, 0b10001, 0b01010, 0b00100},
+	'W': {0b10001, 0b10001, 0b10001, 0b10101, 0b10101, 0b10101, 0b01010},
+	'X': {0b10001, 0b10001, 0b01010, 0b00100, 0b01010, 0b10001, 0b10001},
+	'Y': {0b10001, 0b10001, 0b10001, 0b01010, 0b00100, 0b00100, 0b00100},
+	'Z': {0b11111, 0b00001, 0b00010, 0b00100, 0b01000, 0b10000, 0b11111},
+	'0': {0b01110, 0b10001, 0b10011, 0b10101, 0b11001, 0b10001, 0b01110},
+	'1': {0b00100, 0b01100, 0b00100, 0b00100, 0b00100, 0b00100, 0b01110},
+	'2': {0b01110, 0b10001, 0b00001, 0b00010, 0b00100, 0b01000, 0b11111},
+	'3': {0b11111, 0b00010, 0b00100, 0b00010, 0b00001, 0b10001, 0b01110},
+	'4': {0b00010, 0b00110, 0b01010, 0b10010, 0b11111, 0b00010, 0b00010},
+	'5': {0b11111, 0b10000, 0b11110, 0b00001, 0b00001, 0b10001, 0b01110},
+	'6': {0b00110, 0b01000, 0b10000, 0b11110, 0b10001, 0b10001, 0b01110},
+	'7': {0b11111, 0b00001, 0b00010, 0b00100, 0b01000, 0b01000, 0b01000},
+	'8': {0b01110, 0b10001, 0b10001, 0b01110, 0b10001, 0b10001, 0b01110},
+	'9': {0b01110, 0b10001, 0b10001, 0b01111, 0b00001, 0b00010, 0b01100},
+	' ': {},
+	'.': {0, 0, 0, 0, 0, 0b01100, 0b01100},
+	',': {0, 0, 0, 0, 0b01100, 0b00100, 0b01000},
+	'-': {0, 0, 0, 0b11111, 0, 0, 0},
+	'_': {0, 0, 0, 0, 0, 0, 0b11111},
+	'+': {0, 0b00100, 0b00100, 0b11111, 0b00100, 0b00100, 0},
+	':': {0, 0b01100, 0b01100, 0, 0b01100, 0b01100, 0},
+	'/': {0b00001, 0b00001, 0b00010, 0b00100, 0b01000, 0b10000, 0b10000},
+	'@': {0b01110, 0b10001, 0b10111, 0b10101, 0b10111, 0b10000, 0b01110},
+	'?': {0b01110, 0b10001, 0b00001, 0b00010, 0b00100, 0, 0b00100},
+}
+
+const (
+	glyphAdvance = 6 // columns per character, with one of spacing
+	lineAdvance  = 9 // rows per line, with two of spacing
+)
+
+// watermarkText is the lines of a watermark as glyph rows, with the width
+// and height of the block in glyph pixels
+type watermarkText struct {
+	lines  [][][7]uint8
+	width  int
+	height int
+}
+
+func newWatermarkText(lines []string) *watermarkText {
+	text := &watermarkText{}
+	for _, line := range lines {
+		var glyphs [][7]uint8
+		for _, r := range strings.ToUpper(line) {
+			glyph, ok := watermarkGlyphs[r]
+			if !ok {
+				glyph = watermarkGlyphs['?']
+			}
+			glyphs = append(glyphs, glyph)
+		}
+		text.lines = append(text.lines, glyphs)
+		if width := len(glyphs)*glyphAdvance - 1; width > text.width {
+			text.width = width
+		}
+	}
+	text.height = len(text.lines)*lineAdvance - 2
+	return text
+}
+
+// pixel reports whether the glyph pixel at col, row of the block is set
+func (t *watermarkText) pixel(col, row int) bool {
+	if col < 0 || row < 0 {
+		return false
+	}
+	line, y := row/lineAdvance, row%lineAdvance
+	if line >= len(t.lines) || y >= 7 {
+		return false
+	}
+	char, x := col/glyphAdvance, col%glyphAdvance
+	if char >= len(t.lines[line]) || x >= 5 {
+		return false
+	}
+	return t.lines[line][char][y]&(1<<(4-x)) != 0
+}
+
+// WatermarkImage burns lines of text into a JPEG, PNG or GIF image, tiled
+// diagonally across it in translucent grey. It returns the watermarked
+// image, re-encoded as JPEG for JPEGs and as PNG otherwise, and its MIME
+// type. Only the first frame of an animated GIF is kept.
+func WatermarkImage(content []byte, lines []string) ([]byte, string, error) {
+	src, format, err := image.Decode(bytes.NewReader(content))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrWatermarkUnsupported, err)
+	}
+
+	bounds := src.Bounds()
+	dst := image.NewRGBA(bounds)
+	draw.Draw(dst, bounds, src, bounds.Min, draw.Src)
+
+	text := newWatermarkText(lines)
+	width, height := bounds.Dx(), bounds.Dy()
+	scale := max(2, min(width, height)/160)
+	tileWidth := (text.width + 24) * scale
+	tileHeight := (text.height + 16) * scale
+
+	// Map each image pixel back into the rotated, tiled text
+	const alpha = 0.35
+	ink := color.RGBA{R: 128, G: 128, B: 128, A: 255}
+	sin, cos := math.Sincos(-math.Pi / 6)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			u := float64(x)*cos + float64(y)*sin
+			v := -float64(x)*sin + float64(y)*cos
+			tu := int(math.Floor(u)) % tileWidth
+			tv := int(math.Floor(v)) % tileHeight
+			if tu < 0 {
+				tu += tileWidth
+			}
+			if tv < 0 {
+				tv += tileHeight
+			}
+			if !text.pixel(tu/scale, tv/scale) {
+				continue
+			}
+
+			px := dst.PixOffset(bounds.Min.X+x, bounds.Min.Y+y)
+			dst.Pix[px] = blendChannel(dst.Pix[px], ink.R, alpha)
+			dst.Pix[px+1] = blendChannel(dst.Pix[px+1], ink.G, alpha)
+			dst.Pix[px+2] = blendChannel(dst.Pix[px+2], ink.B, alpha)
+		}
+	}
+
+	var out bytes.Buffer
+	if format == "jpeg" {
+		if err := jpeg.Encode(&out, dst, &jpeg.Options{Quality: 90}); err != nil {
+			return nil, "", err
+		}
+		return out.Bytes(), "image/jpeg", nil
+	}
+	if err := png.Encode(&out, dst); err != nil {
+		return nil, "", err
+	}
+	return out.Bytes(), "image/png", nil
+}
+
+func blendChannel(base, ink uint8, alpha float64) uint8 {
+	return uint8(float64(base)*(1-alpha) + float64(ink)*alpha + 0.5)
+}
+
+var (
+	pdfObjectPattern   = regexp.MustCompile(`(\d+)\s+(\d+)\s+obj\b`)
+	pdfStartXref       = regexp.MustCompile(`startxref\s+(\d+)`)
+	pdfPageType        = regexp.MustCompile(`/Type\s*/Page([^A-Za-z0-9]|$)`)
+	pdfObjStmType      = regexp.MustCompile(`/Type\s*/ObjStm\b`)
+	pdfRefPattern      = regexp.MustCompile(`^(\d+)\s+(\d+)\s+R`)
+	pdfRefsPattern     = regexp.MustCompile(`\d+\s+\d+\s+R`)
+	pdfNumberPattern   = regexp.MustCompile(`-?(\d+\.?\d*|\.\d+)`)
+	pdfDefaultMediaBox = [4]float64{0, 0, 612, 792}
+)
+
+// pdfObject is the latest definition of an object in a PDF
+type pdfObject struct {
+	generation int
+	body       []byte // between "obj" and "endobj"
+	offset     int    // where it is defined, later definitions win
+}
+
+// WatermarkPDF burns lines of text diagonally across every page of a PDF,
+// drawn in grey from the watermark font. The original bytes are kept and
+// the watermark added as an incremental update, so content stays as it was
+// signed or linearised. Encrypted PDFs are refused.
+func WatermarkPDF(content []byte, lines []string) ([]byte, error) {
+	if bytes.Contains(content, []byte("/Encrypt")) {
+		return nil, fmt.Errorf("%w: encrypted PDF", ErrWatermarkUnsupported)
+	}
+
+	startxrefs := pdfStartXref.FindAllSubmatch(content, -1)
+	if len(startxrefs) == 0 {
+		return nil, fmt.Errorf("%w: no cross-reference table", ErrWatermarkUnsupported)
+	}
+	prevXref, _ := strconv.Atoi(string(startxrefs[len(startxrefs)-1][1]))
+	if prevXref <= 0 || prevXref >= len(content) {
+		return nil, fmt.Errorf("%w: bad cross-reference offset", ErrWatermarkUnsupported)
+	}
+
+	objects := readPDFObjects(content)
+	trailer := pdfTrailer(content, prevXref, objects)
+	root := pdfDictValue(trailer, "/Root")
+	size, _ := strconv.Atoi(string(pdfDictValue(trailer, "/Size")))
+	if root == nil || size <= 0 {
+		return nil, fmt.Errorf("%w: no document catalog", ErrWatermarkUnsupported)
+	}
+
+	var pages []int
+	for num, obj := range objects {
+		if pdfPageType.Match(pdfDict(obj.body)) {
+			pages = append(pages, num)
+		}
+	}
+	if len(pages) == 0 {
+		return nil, fmt.Errorf("%w: no pages", ErrWatermarkUnsupported)
+	}
+	sort.Ints(pages)
+
+	text := newWatermarkText(lines)
+	var update bytes.Buffer
+	offsets := make(map[int]int)
+	generations := make(map[int]int)
+	base := len(content) + 1
+	writeObject := func(num, generation int, body string) {
+		offsets[num] = base + update.Len()
+		generations[num] = generation
+		fmt.Fprintf(&update, "%d %d obj\n%s\nendobj\n", num, generation, body)
+	}
+	writeStream := func(num int, data string) {
+		writeObject(num, 0, fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(data), data))
+	}
+
+	// Pages start with a stream saving the graphics state and end with one
+	// restoring it before drawing the watermark, so the watermark is not
+	// skewed by whatever the page's own content leaves behind
+	next := size
+	saveState := next
+	next++
+	writeStream(saveState, "q")
+
+	stamps := make(map[[4]float64]int)
+	for _, num := range pages {
+		page := objects[num]
+		box := pdfMediaBox(objects, page.body)
+		stamp, ok := stamps[box]
+		if !ok {
+			stamp = next
+			next++
+			stamps[box] = stamp
+			writeStream(stamp, pdfWatermarkStream(text, box))
+		}
+
+		dict := pdfDict(page.body)
+		contents := pdfDictValue(dict, "/Contents")
+		refs := []string{fmt.Sprintf("%d 0 R", saveState)}
+		if contents != nil {
+			refs = append(refs, pdfContentRefs(objects, contents)...)
+		}
+		refs = append(refs, fmt.Sprintf("%d 0 R", stamp))
+
+		rewritten := pdfDictWithout(dict, "/Contents")
+		rewritten = append(rewritten[:len(rewritten)-2], []byte(" /Contents ["+strings.Join(refs, " ")+"] >>")...)
+		writeObject(num, page.generation, string(rewritten))
+	}
+
+	// A cross-reference subsection per object, as the numbers are scattered
+	xrefOffset := base + update.Len()
+	nums := make([]int, 0, len(offsets))
+	for num := range offsets {
+		nums = append(nums, num)
+	}
+	sort.Ints(nums)
+	update.WriteString("xref\n")
+	for _, num := range nums {
+		fmt.Fprintf(&update, "%d 1\n%010d %05d n \n", num, offsets[num], generations[num])
+	}
+
+	fmt.Fprintf(&update, "trailer\n<< /Size %d /Root %s /Prev %d", next, pdfRef(root), prevXref)
+	if info := pdfDictValue(trailer, "/Info"); info != nil {
+		fmt.Fprintf(&update, " /Info %s", pdfRef(info))
+	}
+	fmt.Fprintf(&update, " >>\nstartxref\n%d\n%%%%EOF\n", xrefOffset)
+
+	out := make([]byte, 0, len(content)+1+update.Len())
+	out = append(out, content...)
+	out = append(out, '\n')
+	out = append(out, update.Bytes()...)
+	return out, nil
+}
+
+// readPDFObjects returns the latest definition of every object, both those
+// written directly and those packed into object streams
+func readPDFObjects(content []byte) map[int]pdfObject {
+	objects := make(map[int]pdfObject)
+	define := func(num int, obj pdfObject) {
+		if existing, ok := objects[num]; !ok || obj.offset >= existing.offset {
+			objects[num] = obj
+		}
+	}
+
+	for pos := 0; pos < len(content); {
+		match := pdfObjectPattern.FindSubmatchIndex(content[pos:])
+		if match == nil {
+			break
+		}
+		start := pos + match[0]
+		bodyStart := pos + match[1]
+		num, _ := strconv.Atoi(string(content[pos+match[2] : pos+match[3]]))
+		generation, _ := strconv.Atoi(string(content[pos+match[4] : pos+match[5]]))
+
+		// Skip over stream data, which may contain anything
+		searchFrom := bodyStart
+		if streamAt := bytes.Index(content[bodyStart:], []byte("stream")); streamAt >= 0 {
+			endobjAt := bytes.Index(content[bodyStart:], []byte("endobj"))
+			if endobjAt < 0 || streamAt < endobjAt {
+				if end := bytes.Index(content[bodyStart+streamAt:], []byte("endstream")); end >= 0 {
+					searchFrom = bodyStart + streamAt + end
+				}
+			}
+		}
+		end := bytes.Index(content[searchFrom:], []byte("endobj"))
+		if end < 0 {
+			break
+		}
+		body := content[bodyStart : searchFrom+end]
+		define(num, pdfObject{generation: generation, body: body, offset: start})
+
+		if pdfObjStmType.Match(pdfDict(body)) {
+			for packedNum, packed := range readPDFObjectStream(body) {
+				define(packedNum, pdfObject{body: packed, offset: start})
+			}
+		}
+		pos = searchFrom + end + len("endobj")
+	}
+	return objects
+}
+
+// readPDFObjectStream unpacks the objects of an object stream
+func readPDFObjectStream(body []byte) map[int][]byte {
+	dict := pdfDict(body)
+	count, _ := strconv.Atoi(string(pdfDictValue(dict, "/N")))
+	first, _ := strconv.Atoi(string(pdfDictValue(dict, "/First")))
+	data := pdfStreamData(body)
+	if data == nil || count <= 0 {
+		return nil
+	}
+
+	if filter := pdfDictValue(dict, "/Filter"); filter != nil {
+		if !bytes.Contains(filter, []byte("/FlateDecode")) || bytes.Contains(dict, []byte("/DecodeParms")) {
+			return nil
+		}
+		reader, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil
+		}
+		inflated, err := io.ReadAll(reader)
+		if err != nil && len(inflated) == 0 {
+			return nil
+		}
+		data = inflated
+	}
+	if first <= 0 || first > len(data) {
+		return nil
+	}
+
+	header := strings.Fields(string(data[:first]))
+	if len(header) < 2*count {
+		return nil
+	}
+	packed := make(map[int][]byte, count)
+	for i := 0; i < count; i++ {
+		num, err1 := strconv.Atoi(header[2*i])
+		offset, err2 := strconv.Atoi(header[2*i+1])
+		if err1 != nil || err2 != nil || first+offset > len(data) {
+			return nil
+		}
+		end := len(data)
+		if i+1 < count {
+			if nextOffset, err := strconv.Atoi(header[2*i+3]); err == nil && first+nextOffset <= len(data) {
+				end = first + nextOffset
+			}
+		}
+		if end < first+offset {
+			return nil
+		}
+		packed[num] = data[first+offset : end]
+	}
+	return packed
+}
+
+// pdfStreamData returns the data of a stream object's body
+func pdfStreamData(body []byte) []byte {
+	start := bytes.Index(body, []byte("stream"))
+	if start < 0 {
+		return nil
+	}
+	start += len("stream")
+	if start < len(body) && body[start] == '\r' {
+		start++
+	}
+	if start < len(body) && body[start] == '\n' {
+		start++
+	}
+	end := bytes.LastIndex(body, []byte("endstream"))
+	if end < start {
+		return nil
+	}
+	return bytes.TrimRight(body[start:end], "\r\n")
+}
+
+// pdfTrailer returns the trailer dictionary of the cross-reference section
+// at offset, a classic trailer or a cross-reference stream's dictionary
+func pdfTrailer(content []byte, offset int, objects map[int]pdfObject) []byte {
+	section := content[offset:]
+	if bytes.HasPrefix(section, []byte("xref")) {
+		if at := bytes.Index(section, []byte("trailer")); at >= 0 {
+			return pdfDict(section[at+len("trailer"):])
+		}
+		return nil
+	}
+	if match := pdfObjectPattern.FindSubmatchIndex(section); match != nil && match[0] == 0 {
+		num, _ := strconv.Atoi(string(section[match[2]:match[3]]))
+		if obj, ok := objects[num]; ok {
+			return pdfDict(obj.body)
+		}
+	}
+	return nil
+}
+
+// pdfDict returns the dictionary, << through >>, that data starts with
+func pdfDict(data []byte) []byte {
+	start := bytes.Index(data, []byte("<<"))
+	if start < 0 || len(bytes.TrimSpace(data[:start])) > 0 {
+		return nil
+	}
+	if end := pdfValueEnd(data, start); end > start {
+		return data[start:end]
+	}
+	return nil
+}
+
+// pdfValueEnd returns where the PDF value starting at data[start] ends
+func pdfValueEnd(data []byte, start int) int {
+	depth := 0
+	for i := start; i < len(data); i++ {
+		switch data[i] {
+		case '(':
+			// Strings may hold any bracket, and nest balanced parentheses
+			nesting := 0
+			for ; i < len(data); i++ {
+				if data[i] == '\\' {
+					i++
+				} else if data[i] == '(' {
+					nesting++
+				} else if data[i] == ')' {
+					nesting--
+					if nesting == 0 {
+						break
+					}
+				}
+			}
+			if depth == 0 {
+				return i + 1
+			}
+		case '%':
+			for i < len(data) && data[i] != '\n' && data[i] != '\r' {
+				i++
+			}
+		case '<':
+			if i+1 < len(data) && data[i+1] == '<' {
+				depth++
+				i++
+			} else {
+				for i < len(data) && data[i] != '>' {
+					i++
+				}
+				if depth == 0 {
+					return i + 1
+				}
+			}
+		case '>':
+			if i+1 < len(data) && data[i+1] == '>' {
+				depth--
+				i++
+				if depth == 0 {
+					return i + 1
+				}
+			}
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
+
+// pdfDictEntry finds key among the top-level entries of dict, returning
+// where the key starts and where its value starts and ends
+func pdfDictEntry(dict []byte, key string) (int, int, int) {
+	if len(dict) < 4 {
+		return -1, -1, -1
+	}
+	for i := 2; i < len(dict)-2; i++ {
+		switch dict[i] {
+		case '(', '<', '[':
+			if end := pdfValueEnd(dict, i); end > i {
+				i = end - 1
+			}
+		case '/':
+			name := i + 1
+			for name < len(dict) && !bytes.ContainsRune([]byte(" \t\r\n/<>[]()%"), rune(dict[name])) {
+				name++
+			}
+			if string(dict[i:name]) != key {
+				i = name - 1
+				continue
+			}
+
+			valueStart := name
+			for valueStart < len(dict) && bytes.ContainsRune([]byte(" \t\r\n"), rune(dict[valueStart])) {
+				valueStart++
+			}
+			valueEnd := valueStart
+			switch {
+			case valueStart >= len(dict):
+				return -1, -1, -1
+			case dict[valueStart] == '<' || dict[valueStart] == '[' || dict[valueStart] == '(':
+				valueEnd = pdfValueEnd(dict, valueStart)
+			case pdfRefPattern.Match(dict[valueStart:]):
+				valueEnd = valueStart + len(pdfRefPattern.Find(dict[valueStart:]))
+			default:
+				valueEnd = valueStart + 1
+				for valueEnd < len(dict) && !bytes.ContainsRune([]byte(" \t\r\n/<>[]()%"), rune(dict[valueEnd])) {
+					valueEnd++
+				}
+			}
+			if valueEnd < valueStart {
+				return -1, -1, -1
+			}
+			return i, valueStart, valueEnd
+		}
+	}
+	return -1, -1, -1
+}
+
+// pdfDictValue returns the value of a top-level entry of dict, or nil
+func pdfDictValue(dict []byte, key string) []byte {
+	_, start, end := pdfDictEntry(dict, key)
+	if start < 0 {
+		return nil
+	}
+	return dict[start:end]
+}
+
+// pdfDictWithout returns a copy of dict without its key entry
+func pdfDictWithout(dict []byte, key string) []byte {
+	out := append([]byte(nil), dict...)
+	if keyStart, _, end := pdfDictEntry(dict, key); keyStart >= 0 {
+		out = append(out[:keyStart], dict[end:]...)
+	}
+	return out
+}
+
+// pdfRef returns the "N G R" reference a value starts with
+func pdfRef(value []byte) string {
+	return string(pdfRefPattern.Find(bytes.TrimSpace(value)))
+}
+
+// pdfContentRefs returns the content stream references of a page's
+// /Contents, a reference or an array, following a reference to an array
+func pdfContentRefs(objects map[int]pdfObject, contents []byte) []string {
+	contents = bytes.TrimSpace(contents)
+	if match := pdfRefPattern.FindSubmatch(contents); match != nil {
+		num, _ := strconv.Atoi(string(match[1]))
+		if obj, ok := objects[num]; ok {
+			if body := bytes.TrimSpace(obj.body); bytes.HasPrefix(body, []byte("[")) {
+				contents = body
+			}
+		}
+	}
+	if !bytes.HasPrefix(contents, []byte("[")) {
+		return []string{pdfRef(contents)}
+	}
+
+	var refs []string
+	inner := strings.TrimSuffix(strings.TrimPrefix(string(contents), "["), "]")
+	for _, match := range pdfRefsPattern.FindAllString(inner, -1) {
+		refs = append(refs, match)
+	}
+	return refs
+}
+
+// pdfMediaBox returns a page's media box, inherited from its parents when
+// the page has none
+func pdfMediaBox(objects map[int]pdfObject, body []byte) [4]float64 {
+	dict := pdfDict(body)
+	for depth := 0; dict != nil && depth < 32; depth++ {
+		if value := pdfDictValue(dict, "/MediaBox"); value != nil {
+			if ref := pdfRefPattern.FindSubmatch(value); ref != nil {
+				num, _ := strconv.Atoi(string(ref[1]))
+				value = bytes.TrimSpace(objects[num].body)
+			}
+			numbers := pdfNumberPattern.FindAll(value, -1)
+			if len(numbers) == 4 {
+				var box [4]float64
+				for i, number := range numbers {
+					box[i], _ = strconv.ParseFloat(string(number), 64)
+				}
+				if box[2] > box[0] && box[3] > box[1] {
+					return box
+				}
+			}
+		}
+
+		parent := pdfRefPattern.FindSubmatch(pdfDictValue(dict, "/Parent"))
+		if parent == nil {
+			break
+		}
+		num, _ := strconv.Atoi(string(parent[1]))
+		dict = pdfDict(bytes.TrimSpace(objects[num].body))
+	}
+	return pdfDefaultMediaBox
+}
+
+// pdfWatermarkStream draws text diagonally across the middle of a page
+// box, as small squares with gaps so the page stays readable beneath it
+func pdfWatermarkStream(text *watermarkText, box [4]float64) string {
+	width, height := box[2]-box[0], box[3]-box[1]
+	angle := math.Atan2(height, width)
+	diagonal := math.Hypot(width, height)
+	scale := 0.7 * diagonal / float64(text.width)
+	if limit := 0.25 * math.Min(width, height) / float64(text.height); scale > limit {
+		scale = limit
+	}
+	sin, cos := math.Sincos(angle)
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', 3, 64) }
+
+	var out strings.Builder
+	out.WriteString("Q q 0.6 g\n")
+	fmt.Fprintf(&out, "%s %s %s %s %s %s cm\n",
+		f(cos*scale), f(sin*scale), f(-sin*scale), f(cos*scale),
+		f(box[0]+width/2), f(box[1]+height/2))
+
+	left := -float64(text.width) / 2
+	top := float64(text.height) / 2
+	for row := 0; row < text.height; row++ {
+		for col := 0; col < text.width; col++ {
+			if text.pixel(col, row) {
+				fmt.Fprintf(&out, "%s %s 0.7 0.7 re\n", f(left+float64(col)+0.15), f(top-float64(row)-0.85))
+			}
+		}
+	}
+	out.WriteString("f Q")
+	return out.String()
+}
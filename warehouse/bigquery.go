@@ -0,0 +1,217 @@
+package warehouse
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	bigQueryInsertScope = "https://www.googleapis.com/auth/bigquery.insertdata"
+	bigQueryAPIBase     = "https://bigquery.googleapis.com/bigquery/v2"
+	googleTokenURL      = "https://oauth2.googleapis.com/token"
+)
+
+// BigQuerySink streams events with the tabledata.insertAll API, authenticating
+// as a service account. Event IDs are sent as insertId for best-effort dedup.
+type BigQuerySink struct {
+	insertURL   string
+	clientEmail string
+	privateKey  *rsa.PrivateKey
+	tokenURL    string
+	client      *http.Client
+
+	token       string
+	tokenExpiry time.Time
+	mutex       sync.Mutex
+}
+
+type serviceAccountKey struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// NewBigQuerySink creates a new BigQuery sink from a service account key file
+func NewBigQuerySink(cfg *Config) (*BigQuerySink, error) {
+	data, err := os.ReadFile(cfg.BigQueryCredentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read BigQuery credentials: %v", err)
+	}
+
+	var key serviceAccountKey
+	if err := json.Unmarshal(data, &key); err != nil {
+		return nil, fmt.Errorf("invalid BigQuery credentials file: %v", err)
+	}
+	if key.ClientEmail == "" || key.PrivateKey == "" {
+		return nil, fmt.Errorf("BigQuery credentials must contain client_email and private_key")
+	}
+
+	privateKey, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(key.PrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid BigQuery private key: %v", err)
+	}
+
+	tokenURL := key.TokenURI
+	if tokenURL == "" {
+		tokenURL = googleTokenURL
+	}
+
+	return &BigQuerySink{
+		insertURL: fmt.Sprintf("%s/projects/%s/datasets/%s/tables/%s/insertAll",
+			bigQueryAPIBase,
+			url.PathEscape(cfg.BigQueryProjectID),
+			url.PathEscape(cfg.BigQueryDataset),
+			url.PathEscape(cfg.BigQueryTable)),
+		clientEmail: key.ClientEmail,
+		privateKey:  privateKey,
+		tokenURL:    tokenURL,
+		client:      newHTTPClient(cfg.Timeout),
+	}, nil
+}
+
+func (s *BigQuerySink) Name() string {
+	return "bigquery"
+}
+
+type bigQueryInsertRow struct {
+	InsertID string `json:"insertId"`
+	JSON     Event  `json:"json"`
+}
+
+type bigQueryInsertResponse struct {
+	InsertErrors []struct {
+		Index  int `json:"index"`
+		Errors []struct {
+			Reason  string `json:"reason"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	} `json:"insertErrors"`
+}
+
+// WriteEvents streams a batch of events into the configured table
+func (s *BigQuerySink) WriteEvents(ctx context.Context, events []Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	rows := make([]bigQueryInsertRow, len(events))
+	for i, event := range events {
+		rows[i] = bigQueryInsertRow{InsertID: event.Source + ":" + event.ID, JSON: event}
+	}
+
+	body, err := json.Marshal(map[string]interface{}{"rows": rows})
+	if err != nil {
+		return fmt.Errorf("failed to encode events: %v", err)
+	}
+
+	token, err := s.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.insertURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("bigquery request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("bigquery returned %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+
+	var result bigQueryInsertResponse
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return fmt.Errorf("invalid bigquery response: %v", err)
+	}
+	if len(result.InsertErrors) > 0 {
+		first := result.InsertErrors[0]
+		reason := "unknown"
+		if len(first.Errors) > 0 {
+			reason = first.Errors[0].Reason + ": " + first.Errors[0].Message
+		}
+		return fmt.Errorf("bigquery rejected %d rows (row %d: %s)", len(result.InsertErrors), first.Index, reason)
+	}
+
+	return nil
+}
+
+// HealthCheck verifies the service account can obtain an access token
+func (s *BigQuerySink) HealthCheck(ctx context.Context) error {
+	_, err := s.accessToken(ctx)
+	return err
+}
+
+// accessToken returns a cached OAuth access token, exchanging a signed JWT
+// assertion for a new one shortly before the current token expires
+func (s *BigQuerySink) accessToken(ctx context.Context) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.token != "" && time.Now().Before(s.tokenExpiry.Add(-time.Minute)) {
+		return s.token, nil
+	}
+
+	now := time.Now()
+	assertion, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":   s.clientEmail,
+		"scope": bigQueryInsertScope,
+		"aud":   s.tokenURL,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}).SignedString(s.privateKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign BigQuery token assertion: %v", err)
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
+	form.Set("assertion", assertion)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("bigquery token request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+		Error       string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("invalid bigquery token response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || token.AccessToken == "" {
+		return "", fmt.Errorf("bigquery token request returned %d: %s", resp.StatusCode, token.Error)
+	}
+
+	s.token = token.AccessToken
+	s.tokenExpiry = now.Add(time.Duration(token.ExpiresIn) * time.Second)
+	return s.token, nil
+}
@@ -0,0 +1,104 @@
+package warehouse
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ClickHouseSink writes events through the ClickHouse HTTP interface using
+// JSONEachRow. A ReplacingMergeTree table ordered by id deduplicates retries:
+//
+//	CREATE TABLE events (
+//	    id String, source LowCardinality(String), type LowCardinality(String),
+//	    action LowCardinality(String), user_id String, resource_type String,
+//	    resource_id String, timestamp DateTime64(3, 'UTC'), payload String
+//	) ENGINE = ReplacingMergeTree ORDER BY (source, id)
+type ClickHouseSink struct {
+	endpoint string
+	table    string
+	username string
+	password string
+	client   *http.Client
+}
+
+// NewClickHouseSink creates a new ClickHouse sink
+func NewClickHouseSink(cfg *Config) *ClickHouseSink {
+	table := cfg.ClickHouseTable
+	if cfg.ClickHouseDatabase != "" {
+		table = cfg.ClickHouseDatabase + "." + table
+	}
+
+	return &ClickHouseSink{
+		endpoint: strings.TrimRight(cfg.ClickHouseURL, "/"),
+		table:    table,
+		username: cfg.ClickHouseUsername,
+		password: cfg.ClickHousePassword,
+		client:   newHTTPClient(cfg.Timeout),
+	}
+}
+
+func (s *ClickHouseSink) Name() string {
+	return "clickhouse"
+}
+
+// WriteEvents inserts a batch of events in a single request
+func (s *ClickHouseSink) WriteEvents(ctx context.Context, events []Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, event := range events {
+		if err := encoder.Encode(event); err != nil {
+			return fmt.Errorf("failed to encode event %s: %v", event.ID, err)
+		}
+	}
+
+	params := url.Values{}
+	params.Set("query", fmt.Sprintf("INSERT INTO %s FORMAT JSONEachRow", s.table))
+	params.Set("date_time_input_format", "best_effort")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint+"/?"+params.Encode(), &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+
+	return s.do(req)
+}
+
+// HealthCheck pings the ClickHouse server
+func (s *ClickHouseSink) HealthCheck(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.endpoint+"/ping", nil)
+	if err != nil {
+		return err
+	}
+	return s.do(req)
+}
+
+func (s *ClickHouseSink) do(req *http.Request) error {
+	if s.username != "" {
+		req.Header.Set("X-ClickHouse-User", s.username)
+		req.Header.Set("X-ClickHouse-Key", s.password)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("clickhouse request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("clickhouse returned %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	return nil
+}
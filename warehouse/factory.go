@@ -0,0 +1,54 @@
+package warehouse
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// NewSink creates a warehouse sink based on the configured type
+func NewSink(cfg *Config) (Sink, error) {
+	if err := ValidateConfig(cfg); err != nil {
+		return nil, err
+	}
+
+	switch cfg.Type {
+	case "clickhouse":
+		return NewClickHouseSink(cfg), nil
+	case "bigquery":
+		return NewBigQuerySink(cfg)
+	default:
+		return nil, fmt.Errorf("unsupported warehouse sink type: %s", cfg.Type)
+	}
+}
+
+// ValidateConfig validates warehouse sink configuration
+func ValidateConfig(cfg *Config) error {
+	switch cfg.Type {
+	case "clickhouse":
+		if cfg.ClickHouseURL == "" {
+			return fmt.Errorf("ClickHouse URL is required")
+		}
+		if cfg.ClickHouseTable == "" {
+			return fmt.Errorf("ClickHouse table is required")
+		}
+	case "bigquery":
+		if cfg.BigQueryProjectID == "" || cfg.BigQueryDataset == "" || cfg.BigQueryTable == "" {
+			return fmt.Errorf("BigQuery project, dataset and table are required")
+		}
+		if cfg.BigQueryCredentialsFile == "" {
+			return fmt.Errorf("BigQuery credentials file is required")
+		}
+	default:
+		return fmt.Errorf("unsupported warehouse sink type: %s", cfg.Type)
+	}
+
+	return nil
+}
+
+func newHTTPClient(timeout time.Duration) *http.Client {
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	return &http.Client{Timeout: timeout}
+}
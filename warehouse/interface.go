@@ -0,0 +1,50 @@
+package warehouse
+
+import (
+	"context"
+	"time"
+)
+
+// Sink defines the common interface for analytics warehouse destinations
+type Sink interface {
+	// WriteEvents writes a batch of events. Delivery is at-least-once, so
+	// sinks should deduplicate on Event.ID where the warehouse supports it.
+	WriteEvents(ctx context.Context, events []Event) error
+
+	// Provider info
+	Name() string
+	HealthCheck(ctx context.Context) error
+}
+
+// Event is the flattened row shape exported to the warehouse for both
+// analytics events and user activities
+type Event struct {
+	ID           string    `json:"id"`
+	Source       string    `json:"source"` // analytics, activities
+	Type         string    `json:"type"`
+	Action       string    `json:"action"`
+	UserID       string    `json:"user_id"`
+	ResourceType string    `json:"resource_type"`
+	ResourceID   string    `json:"resource_id"`
+	Timestamp    time.Time `json:"timestamp"`
+	Payload      string    `json:"payload"` // original document as relaxed extended JSON
+}
+
+// Config contains connection settings for all supported sinks
+type Config struct {
+	Type    string // clickhouse, bigquery
+	Timeout time.Duration
+
+	// ClickHouse
+	ClickHouseURL      string
+	ClickHouseDatabase string
+	ClickHouseTable    string
+	ClickHouseUsername string
+	ClickHousePassword string
+
+	// BigQuery
+	BigQueryProjectID       string
+	BigQueryDataset         string
+	BigQueryTable           string
+	BigQueryCredentialsFile string
+}